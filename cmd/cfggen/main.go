@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+)
+
+// templates lists the configurations cfggen generates, each filled with its
+// defaults.
+var templates = map[string]func() any{
+	"api":                         func() any { return global.APIConfig{}.Default() },
+	"keyword_extractor":           func() any { return global.KeywordExtractorConfig{}.Default() },
+	"logger":                      func() any { return global.LoggerConfig{}.Default() },
+	"migrate":                     func() any { return global.MigrateConfig{}.Default() },
+	"party_press_release_scraper": func() any { return global.PartyPressReleaseScraperConfig{}.Default() },
+	"scheduler":                   func() any { return global.SchedulerConfig{}.Default() },
+	"scraper":                     func() any { return global.ScraperWorkerConfig{}.Default() },
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	fs := flag.NewFlagSet("cfggen", flag.ContinueOnError)
+	name := fs.String("type", "", "configuration to generate: "+strings.Join(names, ", "))
+	output := fs.String("output", "", "path of the generated file (default: stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	tmpl, ok := templates[*name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown configuration %q, supported: %s\n", *name, strings.Join(names, ", "))
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", *output, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := global.WriteConfig(w, tmpl()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write configuration: %v\n", err)
+		return 1
+	}
+	return 0
+}
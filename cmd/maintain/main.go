@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/viper"
+)
+
+// Exit codes of the maintain command.
+const (
+	ExitOK       = 0 // command succeeded
+	ExitError    = 1 // an error occurred
+	ExitDegraded = 2 // an index has a recall below the minimum
+)
+
+const usage = `Usage: maintain [--env FILE] <command> <subcommand> [flags]
+
+Commands:
+  vector-index inspect [--sample-size N] [--k N] [--tolerance F] [--min-recall F]
+      list the vector indexes with their size, estimated recall and last rebuild
+  vector-index reindex --index NAME [--m N] [--ef-construction N] [--lists N]
+      [--max-replication-lag D] [--max-transaction-age D] [--lock-timeout D]
+      rebuild an index concurrently and report its progress
+  tasks purge [--older-than D] [--batch-size N]
+      delete the finished tasks older than D with their articles, e.g. nightly
+      from cron
+`
+
+type app struct {
+	store storage.Storage
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("maintain", flag.ContinueOnError)
+	envFile := fs.String("env", ".env", "path to the env file")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return ExitError
+	}
+
+	viper.SetConfigFile(*envFile)
+	viper.SetConfigType("env")
+	viper.AutomaticEnv()
+	viper.SetDefault("MODE", "dev")
+
+	global.SetMode(viper.GetString("MODE"))
+	global.Logger = global.InitBaseLogger(global.Mode())
+	if err := viper.ReadInConfig(); err != nil {
+		global.Logger.Warn().
+			Err(err).
+			Str("env_file", *envFile).
+			Msg("Failed to read env file, using environment variables only")
+	}
+
+	config := global.LoadPostgresConfig()
+	if config == nil {
+		global.Logger.Error().Msg("Failed to load Postgres config")
+		return ExitError
+	}
+
+	// a rebuild is interrupted, and its record kept, on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// the progress of a build is polled next to it, a pool is needed
+	pool, err := pgxpool.New(ctx, config.URL())
+	if err != nil {
+		global.Logger.Error().Err(err).Msg("Failed to connect to Postgres")
+		return ExitError
+	}
+	defer pool.Close()
+	a := app{store: storage.New(pool, nil)}
+
+	cmd, sub, cmdArgs := fs.Arg(0), fs.Arg(1), fs.Args()[2:]
+	switch {
+	case cmd == "vector-index" && sub == "inspect":
+		return a.inspect(ctx, cmdArgs)
+	case cmd == "vector-index" && sub == "reindex":
+		return a.reindex(ctx, cmdArgs)
+	case cmd == "tasks" && sub == "purge":
+		return a.purge(ctx, cmdArgs)
+	default:
+		global.Logger.Error().Str("command", cmd).Str("subcommand", sub).Msg("Unknown command")
+		fs.Usage()
+		return ExitError
+	}
+}
+
+// recallFlags registers the flags of the recall sampling on fs, defaulting to
+// cfg.
+func recallFlags(fs *flag.FlagSet, cfg *global.VectorIndexConfig) {
+	fs.IntVar(&cfg.SampleSize, "sample-size", cfg.SampleSize, "number of query vectors sampled to estimate the recall")
+	fs.IntVar(&cfg.K, "k", cfg.K, "number of nearest neighbours searched per query vector")
+	fs.Float64Var(&cfg.Tolerance, "tolerance", cfg.Tolerance, "distance tolerance of a neighbour tied with the k-th exact one")
+	fs.Float64Var(&cfg.MinRecall, "min-recall", cfg.MinRecall, "recall below which an index is degraded")
+}
+
+func (a app) inspect(ctx context.Context, args []string) int {
+	cfg := global.VectorIndexConfig{}.Default()
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	recallFlags(fs, &cfg)
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+
+	if err := cfg.Validate(); err != nil {
+		global.Logger.Error().Err(err).Msg("Invalid flags")
+		return ExitError
+	}
+
+	stats, err := a.store.VectorIndexMaintenance().WithConfig(cfg).InspectIndexes(ctx)
+	if err != nil {
+		global.Logger.Error().Err(err).Msg("Failed to inspect vector indexes")
+		return ExitError
+	}
+
+	code := ExitOK
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tMETHOD\tPARAMS\tROWS\tSIZE\tRECALL\tSTATE\tLAST REINDEX")
+	for _, s := range stats {
+		state := "ok"
+		switch {
+		case !s.Valid:
+			state, code = "invalid", ExitDegraded
+		case s.Degraded:
+			state, code = "degraded", ExitDegraded
+		}
+
+		last := "never"
+		if s.LastReindexAt != nil {
+			last = s.LastReindexAt.Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%.3f±%.3f\t%s\t%s\n", s.Name, s.Method, params(s.Params),
+			s.Rows, s.SizeBytes, s.Recall.Recall, s.Recall.StdErr, state, last)
+	}
+	w.Flush()
+	return code
+}
+
+func (a app) reindex(ctx context.Context, args []string) int {
+	cfg := global.VectorIndexConfig{}.Default()
+	fs := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	index := fs.String("index", "", "name of the index, qualified by its schema")
+	var p storage.ReindexParams
+	fs.IntVar(&p.M, "m", 0, "m of an hnsw index, 0 keeps the one of the index")
+	fs.IntVar(&p.EfConstruction, "ef-construction", 0, "ef_construction of an hnsw index, 0 keeps the one of the index")
+	fs.IntVar(&p.Lists, "lists", 0, "lists of an ivfflat index, 0 keeps the one of the index")
+	fs.DurationVar(&cfg.MaxReplicationLag, "max-replication-lag", cfg.MaxReplicationLag, "refuse to run while a replica lags more, 0 for no limit")
+	fs.DurationVar(&cfg.MaxTransactionAge, "max-transaction-age", cfg.MaxTransactionAge, "refuse to run while a transaction is open for longer, 0 for no limit")
+	fs.DurationVar(&cfg.LockTimeout, "lock-timeout", cfg.LockTimeout, "longest wait for the lock of the swap of the index names")
+	fs.DurationVar(&cfg.ProgressInterval, "progress-interval", cfg.ProgressInterval, "interval of the progress reports")
+	recallFlags(fs, &cfg)
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+
+	if *index == "" {
+		global.Logger.Error().Msg("--index is required")
+		return ExitError
+	}
+
+	if err := cfg.Validate(); err != nil {
+		global.Logger.Error().Err(err).Msg("Invalid flags")
+		return ExitError
+	}
+
+	record, err := a.store.VectorIndexMaintenance().WithConfig(cfg).
+		ReindexConcurrently(ctx, *index, p, func(p storage.ReindexProgress) {
+			global.Logger.Info().
+				Str("step", p.Step).
+				Str("phase", p.Phase).
+				Int64("blocks_done", p.BlocksDone).
+				Int64("blocks_total", p.BlocksTotal).
+				Int64("tuples_done", p.TuplesDone).
+				Int64("tuples_total", p.TuplesTotal).
+				Msg("Rebuilding vector index")
+		})
+	if err != nil {
+		global.Logger.Error().Err(err).Str("index", *index).Msg("Failed to rebuild vector index")
+		return ExitError
+	}
+
+	event := global.Logger.Info().
+		Int32("record_id", record.ID).
+		Str("index", record.Index).
+		Str("params", params(record.Params))
+	if record.RecallBefore != nil {
+		event = event.Float64("recall_before", *record.RecallBefore)
+	}
+	if record.RecallAfter != nil {
+		event = event.Float64("recall_after", *record.RecallAfter)
+	}
+	if record.SizeBefore != nil && record.SizeAfter != nil {
+		event = event.Int64("size_before", *record.SizeBefore).Int64("size_after", *record.SizeAfter)
+	}
+	event.Msg("Vector index rebuilt")
+	return ExitOK
+}
+
+func (a app) purge(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	age := fs.Duration("older-than", storage.DefaultTaskRetention, "age of the finished tasks deleted")
+	batchSize := fs.Int("batch-size", storage.DefaultTaskPurgeBatchSize, "number of tasks deleted per transaction")
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+
+	n, err := a.store.Task().PurgeOlderThan(ctx, *age, *batchSize)
+	if err != nil {
+		global.Logger.Error().Err(err).
+			Int64("tasks", n.Tasks).
+			Int64("articles", n.Articles).
+			Msg("Failed to purge tasks")
+		return ExitError
+	}
+
+	global.Logger.Info().
+		Int64("tasks", n.Tasks).
+		Int64("articles", n.Articles).
+		Msg("Purged tasks")
+	return ExitOK
+}
+
+func params(p storage.ReindexParams) string {
+	switch {
+	case p.Lists > 0:
+		return fmt.Sprintf("lists=%d", p.Lists)
+	case p.M > 0 || p.EfConstruction > 0:
+		return fmt.Sprintf("m=%d,ef_construction=%d", p.M, p.EfConstruction)
+	default:
+		return "-"
+	}
+}
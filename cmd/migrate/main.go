@@ -1,103 +1,371 @@
 package main
 
-// import (
-// 	"errors"
-// 	"os"
-
-// 	"github.com/ChiaYuChang/weathercock/internal/global"
-// 	"github.com/golang-migrate/migrate/v4"
-// 	_ "github.com/golang-migrate/migrate/v4/source/file"
-// 	"github.com/spf13/pflag"
-// 	"github.com/spf13/viper"
-// )
-
-// func main() {
-// 	var step int
-// 	pflag.IntVarP(&step, "step", "s", 0, "steps looks at the currently active migration version. It will migrate up if n > 0, and down if n < 0.")
-// 	pflag.Parse()
-
-// 	global.SetMode("dev")
-// 	global.Logger = global.InitBaseLogger()
-// 	global.Logger.Debug().Msg("Logger initialized")
-
-// 	err := global.ReadDotEnvFile(".env", "env", []string{"."})
-// 	if err != nil {
-// 		global.Logger.
-// 			Err(err).
-// 			Msg("Failed to read .env file")
-// 		os.Exit(1)
-// 	}
-// 	global.Logger.Debug().Msg("Loaded .env file successfully")
-
-// 	config := global.LoadPostgresConfig()
-// 	global.Logger.Debug().
-// 		Str("conn_str", config.URL()).
-// 		Msg("Postgres config loaded")
-// 	err = config.ReadPasswordFile()
-// 	if err != nil {
-// 		global.Logger.
-// 			Err(err).
-// 			Msg("Failed to read password file")
-// 		os.Exit(1)
-// 	}
-
-// 	srcURL := "file://" + viper.GetString("MIGRATIONS_PATH")
-// 	dstURL := config.URL()
-// 	global.Logger.Debug().
-// 		Str("src_url", srcURL).
-// 		Str("dst_url", config.URLString()).
-// 		Msg("Migration paths loaded")
-
-// 	// Initialize migration
-// 	m, err := global.Migrate(srcURL, dstURL)
-// 	if err != nil {
-// 		global.Logger.
-// 			Err(err).
-// 			Msg("Failed to read .env file")
-// 		os.Exit(1)
-// 	}
-
-// 	if step == 0 {
-// 		global.Logger.Info().
-// 			Msg("No step specified, running migrations up to latest version")
-// 		if err := m.Up(); err != nil {
-// 			if !errors.Is(err, migrate.ErrNoChange) {
-// 				global.Logger.
-// 					Err(err).
-// 					Msg("Failed to apply migrations")
-// 				os.Exit(1)
-// 			}
-// 			global.Logger.Debug().Msg("No new migrations to apply")
-// 		} else {
-// 			global.Logger.Debug().Msg("Migrations applied successfully")
-// 		}
-// 	} else {
-// 		global.Logger.Info().
-// 			Int("step", step).
-// 			Msgf("Running migrations up %d steps", step)
-// 		if err := m.Steps(step); err != nil {
-// 			if !errors.Is(err, migrate.ErrNoChange) {
-// 				global.Logger.
-// 					Err(err).
-// 					Msg("Failed to apply migrations")
-// 				os.Exit(1)
-// 			}
-// 			global.Logger.Debug().Msg("No new migrations to apply")
-// 		} else {
-// 			global.Logger.Debug().Msg("Migrations applied successfully")
-// 		}
-// 	}
-
-// 	ver, dirty, err := m.Version()
-// 	if err != nil {
-// 		global.Logger.
-// 			Err(err).
-// 			Msg("Failed to get migration version")
-// 		os.Exit(1)
-// 	}
-// 	global.Logger.Debug().
-// 		Uint("version", ver).
-// 		Bool("is_dirty", dirty).
-// 		Msg("Migration version loaded")
-
-// }
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/viper"
+)
+
+// Exit codes of the migrate command.
+const (
+	ExitApplied  = 0 // migrations applied (or command succeeded)
+	ExitError    = 1 // an error occurred
+	ExitNoChange = 2 // nothing to do
+)
+
+const usage = `Usage: migrate [--env FILE] <command> [flags]
+
+Commands:
+  up      [--step N]           migrate up to the latest version, or N steps (down if N < 0)
+  status                       list applied and pending migrations with checksums
+  goto    --version N [--yes]  migrate up or down to an exact version
+  force   --version N --i-know set the version without running migrations (dirty-state recovery)
+  drift                        compare the live schema against the one recorded at the last migration
+`
+
+type app struct {
+	m       *migrate.Migrate
+	conn    *pgx.Conn
+	srcURL  string
+	timeout time.Duration
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	envFile := fs.String("env", ".env", "path to the env file")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitError
+	}
+
+	viper.SetConfigFile(*envFile)
+	viper.SetConfigType("env")
+	viper.AutomaticEnv()
+	viper.SetDefault("MODE", "dev")
+
+	global.SetMode(viper.GetString("MODE"))
+	global.Logger = global.InitBaseLogger(global.Mode())
+	if err := viper.ReadInConfig(); err != nil {
+		global.Logger.Warn().
+			Err(err).
+			Str("env_file", *envFile).
+			Msg("Failed to read env file, using environment variables only")
+	}
+
+	config := global.LoadPostgresConfig()
+	if config == nil {
+		global.Logger.Error().Msg("Failed to load Postgres config")
+		return ExitError
+	}
+
+	a := app{
+		srcURL:  "file://" + utils.DefaultIfZero(viper.GetString("MIGRATIONS_PATH"), global.MigrateConfig{}.Default().Migrations),
+		timeout: 30 * time.Second,
+	}
+	global.Logger.Debug().
+		Str("src_url", a.srcURL).
+		Str("dst_url", config.URLString()).
+		Msg("Migration paths loaded")
+
+	var err error
+	a.m, err = global.Migrate(a.srcURL, config.URL())
+	if err != nil {
+		global.Logger.Error().Err(err).Msg("Failed to initialize migration")
+		return ExitError
+	}
+	defer a.m.Close()
+
+	ctx, cancel := a.ctx()
+	defer cancel()
+	a.conn, err = pgx.Connect(ctx, config.URL())
+	if err != nil {
+		global.Logger.Error().Err(err).Msg("Failed to connect to Postgres")
+		return ExitError
+	}
+	defer a.conn.Close(context.Background())
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "up":
+		return a.up(cmdArgs)
+	case "status":
+		return a.status()
+	case "goto":
+		return a.gotoVersion(cmdArgs)
+	case "force":
+		return a.force(cmdArgs)
+	case "drift":
+		return a.drift()
+	default:
+		global.Logger.Error().Str("command", cmd).Msg("Unknown command")
+		fs.Usage()
+		return ExitError
+	}
+}
+
+func (a app) up(args []string) int {
+	fs := flag.NewFlagSet("up", flag.ContinueOnError)
+	step := fs.Int("step", 0, "migrate up if N > 0 and down if N < 0, 0 means up to the latest version")
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+
+	if code, ok := a.preflight(); !ok {
+		return code
+	}
+
+	var err error
+	if *step == 0 {
+		global.Logger.Info().Msg("No step specified, running migrations up to latest version")
+		err = a.m.Up()
+	} else {
+		global.Logger.Info().Int("step", *step).Msg("Running migrations")
+		err = a.m.Steps(*step)
+	}
+	return a.result(err)
+}
+
+func (a app) gotoVersion(args []string) int {
+	fs := flag.NewFlagSet("goto", flag.ContinueOnError)
+	version := fs.Int("version", -1, "target migration version")
+	yes := fs.Bool("yes", false, "do not ask for confirmation of down migrations")
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+
+	if *version < 0 {
+		global.Logger.Error().Msg("--version is required")
+		return ExitError
+	}
+
+	if code, ok := a.preflight(); !ok {
+		return code
+	}
+
+	current, _, err := a.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		global.Logger.Error().Err(err).Msg("Failed to get migration version")
+		return ExitError
+	}
+
+	if uint(*version) < current && global.Mode() == "prod" && !*yes {
+		if !confirm(fmt.Sprintf("Migrate DOWN from version %d to %d in prod mode?", current, *version)) {
+			global.Logger.Info().Msg("Aborted by user")
+			return ExitNoChange
+		}
+	}
+
+	global.Logger.Info().
+		Uint("from", current).
+		Int("to", *version).
+		Msg("Migrating to version")
+	return a.result(a.m.Migrate(uint(*version)))
+}
+
+func (a app) force(args []string) int {
+	fs := flag.NewFlagSet("force", flag.ContinueOnError)
+	version := fs.Int("version", -2, "version to set, -1 means no migration has been applied")
+	iKnow := fs.Bool("i-know", false, "confirm that the schema has been fixed manually")
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+
+	if *version < -1 {
+		global.Logger.Error().Msg("--version is required")
+		return ExitError
+	}
+
+	if !*iKnow {
+		global.Logger.Error().
+			Msg("force only sets the version and clears the dirty flag without running any migration, pass --i-know to proceed")
+		return ExitError
+	}
+
+	if err := a.m.Force(*version); err != nil {
+		global.Logger.Error().Err(err).Int("version", *version).Msg("Failed to force version")
+		return ExitError
+	}
+
+	global.Logger.Info().Int("version", *version).Msg("Version forced")
+	if *version >= 0 {
+		a.record(uint(*version))
+	}
+	return ExitApplied
+}
+
+func (a app) status() int {
+	status, err := global.MigrationsStatus(a.m, a.srcURL)
+	if err != nil {
+		global.Logger.Error().Err(err).Msg("Failed to get migration status")
+		return ExitError
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tCHECKSUM")
+	pending := 0
+	for _, s := range status {
+		state := "pending"
+		switch {
+		case s.Dirty:
+			state = "dirty"
+		case s.Applied:
+			state = "applied"
+		default:
+			pending++
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Identifier, state, s.Checksum)
+	}
+	w.Flush()
+
+	if pending == 0 {
+		return ExitNoChange
+	}
+	return ExitApplied
+}
+
+func (a app) drift() int {
+	current, dirty, err := a.m.Version()
+	if err != nil {
+		global.Logger.Error().Err(err).Msg("Failed to get migration version")
+		return ExitError
+	}
+
+	ctx, cancel := a.ctx()
+	defer cancel()
+	recorded, live, err := global.CheckSchemaDrift(ctx, a.conn, global.SchemaFingerprintTable, current)
+	switch {
+	case errors.Is(err, global.ErrSchemaDrift):
+		global.Logger.Warn().
+			Uint("version", current).
+			Bool("dirty", dirty).
+			Str("recorded", recorded).
+			Str("live", live).
+			Msg("Schema drift detected, the schema was changed out-of-band")
+		return ExitError
+	case err != nil:
+		global.Logger.Error().Err(err).Uint("version", current).Msg("Failed to check schema drift")
+		return ExitError
+	}
+
+	global.Logger.Info().
+		Uint("version", current).
+		Str("hash", live).
+		Msg("No schema drift detected")
+	return ExitNoChange
+}
+
+// preflight refuses to run on a dirty database and warns about schema drift
+// before any migration is applied.
+func (a app) preflight() (int, bool) {
+	current, dirty, err := a.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return ExitApplied, true
+	}
+
+	if err != nil {
+		global.Logger.Error().Err(err).Msg("Failed to get migration version")
+		return ExitError, false
+	}
+
+	if dirty {
+		global.Logger.Error().
+			Err(global.ErrMigrationIsDirty).
+			Uint("version", current).
+			Msg("Fix the schema manually and run `force --version N --i-know` to recover")
+		return ExitError, false
+	}
+
+	ctx, cancel := a.ctx()
+	defer cancel()
+	recorded, live, err := global.CheckSchemaDrift(ctx, a.conn, global.SchemaFingerprintTable, current)
+	switch {
+	case errors.Is(err, global.ErrSchemaDrift):
+		global.Logger.Warn().
+			Uint("version", current).
+			Str("recorded", recorded).
+			Str("live", live).
+			Msg("Schema drift detected, the schema was changed out-of-band")
+	case errors.Is(err, global.ErrNoFingerprint):
+		global.Logger.Debug().
+			Uint("version", current).
+			Msg("No schema fingerprint recorded, skip drift check")
+	case err != nil:
+		global.Logger.Warn().Err(err).Msg("Failed to check schema drift")
+	}
+	return ExitApplied, true
+}
+
+// result maps the error of a migration to an exit code and records the
+// schema fingerprint after a successful migration.
+func (a app) result(err error) int {
+	if errors.Is(err, migrate.ErrNoChange) {
+		global.Logger.Info().Msg("No new migrations to apply")
+		return ExitNoChange
+	}
+
+	if err != nil {
+		global.Logger.Error().Err(err).Msg("Failed to apply migrations")
+		return ExitError
+	}
+
+	ver, dirty, err := a.m.Version()
+	if err != nil {
+		global.Logger.Error().Err(err).Msg("Failed to get migration version")
+		return ExitError
+	}
+	global.Logger.Info().
+		Uint("version", ver).
+		Bool("is_dirty", dirty).
+		Msg("Migrations applied successfully")
+	a.record(ver)
+	return ExitApplied
+}
+
+func (a app) record(ver uint) {
+	ctx, cancel := a.ctx()
+	defer cancel()
+	hash, err := global.RecordSchemaFingerprint(ctx, a.conn, global.SchemaFingerprintTable, ver)
+	if err != nil {
+		global.Logger.Warn().Err(err).Uint("version", ver).Msg("Failed to record schema fingerprint")
+		return
+	}
+	global.Logger.Debug().
+		Uint("version", ver).
+		Str("hash", hash).
+		Msg("Schema fingerprint recorded")
+}
+
+func (a app) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), a.timeout)
+}
+
+func confirm(prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", prompt)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
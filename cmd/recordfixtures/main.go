@@ -0,0 +1,93 @@
+// Command recordfixtures re-records the pages listed in the manifest of
+// internal/scrapers/fixtures from the live sites and sanitizes them.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers/fixtures"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("recordfixtures", flag.ContinueOnError)
+	dir := fs.String("dir", "internal/scrapers/fixtures/testdata", "directory of the recordings")
+	delay := fs.Duration("delay", 2*time.Second, "pause between two requests")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	recs, err := fixtures.Manifest()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cli := &http.Client{Timeout: 30 * time.Second}
+	failed := 0
+	for i, rec := range recs {
+		if i > 0 {
+			time.Sleep(*delay)
+		}
+		if err := record(cli, *dir, rec); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to record %s: %v\n", rec.URL, err)
+			failed++
+			continue
+		}
+		fmt.Printf("recorded %s -> %s\n", rec.URL, rec.File)
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d recordings failed\n", failed, len(recs))
+		return 1
+	}
+	return 0
+}
+
+func record(cli *http.Client, dir string, rec fixtures.Recording) error {
+	req, err := http.NewRequest(http.MethodGet, rec.URL, nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range scrapers.DefaultHeaders {
+		// let the client negotiate and decode the compression
+		if key == "Accept-Encoding" {
+			continue
+		}
+		req.Header.Set(key, value)
+	}
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	status := rec.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if resp.StatusCode != status {
+		return fmt.Errorf("status: %s, expected %d", resp.Status, status)
+	}
+
+	body, err := fixtures.Sanitize(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	fn := filepath.Join(dir, filepath.FromSlash(rec.File))
+	if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(fn, body, 0o644)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+)
+
+const tmpl = `// Code generated by schemaversion. DO NOT EDIT.
+
+package %s
+
+// ExpectedSchemaVersion is the highest migration version in the migrations
+// directory at build time. The database has to be at least at this version.
+const ExpectedSchemaVersion uint = %d
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("schemaversion", flag.ContinueOnError)
+	migrations := fs.String("migrations", "./migrations", "directory of the migrations")
+	output := fs.String("output", "schema_version.go", "path of the generated file")
+	pkg := fs.String("package", "global", "package of the generated file")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	dir, err := filepath.Abs(*migrations)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid migrations directory %s: %v\n", *migrations, err)
+		return 1
+	}
+
+	version, err := global.LatestMigrationVersion("file://" + filepath.ToSlash(dir))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read migrations: %v\n", err)
+		return 1
+	}
+
+	src, err := format.Source(fmt.Appendf(nil, tmpl, *pkg, version))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to format generated file: %v\n", err)
+		return 1
+	}
+
+	if err := os.WriteFile(*output, src, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	return 0
+}
@@ -14,103 +14,94 @@ import (
 	"sync/atomic"
 	"time"
 
-	openai "github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
-	"github.com/openai/openai-go/shared"
-
 	"github.com/ChiaYuChang/weathercock/internal/llm"
+	openaiplug "github.com/ChiaYuChang/weathercock/internal/llm/openai"
 	"github.com/ChiaYuChang/weathercock/internal/models"
 	"github.com/ChiaYuChang/weathercock/internal/storage"
 	"github.com/ChiaYuChang/weathercock/internal/testtools"
-	"github.com/ChiaYuChang/weathercock/pkgs/errors"
 	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func Embedding(paragraphs []string, user, model string) ([][]float64, error) {
-	cli := openai.NewClient(
-		option.WithBaseURL("http://localhost:11434/v1"),
+// newClient creates a client of the OpenAI compatible API of the local
+// Ollama server.
+func newClient(ctx context.Context) (*openaiplug.Client, error) {
+	return openaiplug.OpenAI(ctx,
+		openaiplug.WithAPIKey("ollama"),
+		openaiplug.WithBaseURL("http://localhost:11434/v1"),
+		openaiplug.UseChatChatCompletions(),
+		openaiplug.WithEmbedDim(1024),
 	)
+}
 
-	embed, err := cli.Embeddings.New(
-		context.TODO(),
-		openai.EmbeddingNewParams{
-			Model: model,
-			Input: openai.EmbeddingNewParamsInputUnion{
-				OfArrayOfStrings: paragraphs,
-			},
-			Dimensions:     openai.Int(1024),
-			EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
-			User:           openai.String(user),
-		},
-	)
+func Embedding(paragraphs []string, user, model string) ([][]float32, error) {
+	if len(paragraphs) == 0 {
+		return nil, nil
+	}
+
+	ctx := context.TODO()
+	cli, err := newClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	inputs := make([]llm.EmbedInput, len(paragraphs))
+	for i, p := range paragraphs {
+		inputs[i] = llm.NewSimpleTextInput(p)
+	}
+	embed, err := cli.Embed(ctx, &llm.EmbedRequest{
+		Inputs:    inputs,
+		ModelName: model,
+		User:      user,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get embedding from Llama.cpp server: %w", err)
 	}
 
-	if len(embed.Data) == 0 {
+	if len(embed.Embeddings) == 0 {
 		return nil, fmt.Errorf("no embedding data returned")
 	}
 
-	embeddings := make([][]float64, len(embed.Data))
-	for i, data := range embed.Data {
-		if len(data.Embedding) == 0 {
+	embeddings := make([][]float32, len(embed.Embeddings))
+	for i, data := range embed.Embeddings {
+		if len(data.Values) == 0 {
 			return nil, fmt.Errorf("embedding data is empty for index %d", i)
 		}
-		embeddings[data.Index] = data.Embedding // Assuming we want the first embedding only
+		embeddings[i] = data.Values
 	}
 	return embeddings, nil
 }
 
 func Keywords(prompt string, user, model, content string) (map[string][]string, error) {
-	cli := openai.NewClient(
-		option.WithBaseURL("http://localhost:11434/v1"),
-	)
+	ctx := context.Background()
+	cli, err := newClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
 
-	resp, err := cli.Chat.Completions.New(
-		context.Background(),
-		openai.ChatCompletionNewParams{
-			Model: model,
-			Messages: []openai.ChatCompletionMessageParamUnion{
-				{
-					OfSystem: &openai.ChatCompletionSystemMessageParam{
-						Content: openai.ChatCompletionSystemMessageParamContentUnion{
-							OfString: openai.String(string(prompt)),
-						},
-					},
-				},
-				{
-					OfUser: &openai.ChatCompletionUserMessageParam{
-						Content: openai.ChatCompletionUserMessageParamContentUnion{
-							OfString: openai.String(content),
-						},
-					},
-				},
-			},
-			MaxCompletionTokens: openai.Int(128),
-			Store:               openai.Bool(false),
-			User:                openai.String(user),
-			ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
-				OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
-					JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
-						Name:        "keyword_extraction",
-						Strict:      openai.Bool(true),
-						Description: openai.String("Extract keywords from the content and categorize them into themes, entities, concepts three categories."),
-						Schema:      KeywordExtractionJsonSchema,
-					},
-					Type: "json_schema",
-				},
-			},
+	resp, err := cli.Generate(ctx, &llm.GenerateRequest{
+		Messages: []llm.Message{
+			llm.NewTextMessage(llm.RoleSystem, prompt),
+			llm.NewTextMessage(llm.RoleUser, content),
 		},
-	)
-
+		ModelName: model,
+		MaxTokens: utils.Ptr(128),
+		User:      user,
+		Schema: &llm.ResponseSchema{
+			Name:        "keyword_extraction",
+			Strict:      true,
+			Description: "Extract keywords from the content and categorize them into themes, entities, concepts three categories.",
+			S:           KeywordExtractionJsonSchema,
+		},
+	})
 	if err != nil {
 		log.Fatalf("failed to get chat completion: %v", err)
 	}
 
 	re := regexp.MustCompile(`\{(?:[^{}]|{[^{}]*})*\}`)
-	fmt.Printf("Response: %s\n", resp.Choices[0].Message.Content)
-	rawdata := re.FindString(resp.Choices[0].Message.Content)
+	fmt.Printf("Response: %s\n", resp.Outputs[0])
+	rawdata := re.FindString(resp.Outputs[0])
 	fmt.Println("Raw data:", rawdata)
 
 	keywords := map[string][]string{}
@@ -158,6 +149,33 @@ func NewMessage[T any](index int32, start time.Time, data T) Message[T] {
 	return Message[T]{Index: index, Data: data, Cost: time.Since(start)}
 }
 
+// unembedded returns the chunks of chunks not embedded under the model of mID
+// yet, logging how many were skipped, so that seeding an article again does
+// not embed its chunks twice.
+func unembedded(ctx context.Context, s storage.Storage, mID int32,
+	chunks []storage.ChunkWithContent) ([]storage.ChunkWithContent, error) {
+	ids := make([]int32, len(chunks))
+	for i, c := range chunks {
+		ids[i] = c.Chunk.ID
+	}
+
+	embedded, err := s.UserEmbeddings().ExistsForChunks(storage.WithFreshReads(ctx), mID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make([]storage.ChunkWithContent, 0, len(chunks))
+	for _, c := range chunks {
+		if !embedded[c.Chunk.ID] {
+			missing = append(missing, c)
+		}
+	}
+	if skipped := len(chunks) - len(missing); skipped > 0 {
+		log.Printf("Skipped %d chunks embedded already: mID: %d", skipped, mID)
+	}
+	return missing, nil
+}
+
 func main() {
 	raw, err := os.ReadFile("cmd/testdata/news.txt")
 	if err != nil {
@@ -172,7 +190,8 @@ func main() {
 	}
 
 	embedModel := "jeffh/intfloat-multilingual-e5-large-instruct:f32"
-	// embeddings, err := Embedding(paragraphs, "user-123", embedModel)
+	user := uuid.NewString()
+	// embeddings, err := Embedding(paragraphs, user, embedModel)
 	// for i, e := range embeddings {
 	// 	fmt.Printf("text: %s\n", paragraphs[i])
 	// 	fmt.Printf("embedding: %7.4f...\n", e[:10])
@@ -185,7 +204,7 @@ func main() {
 	}
 	// ccModel := "phi4-mini:latest"
 	ccModel := "gemma3n:e4b"
-	keywords, err := Keywords(string(prompt), "user-123", ccModel, content)
+	keywords, err := Keywords(string(prompt), user, ccModel, content)
 	for k, v := range keywords {
 		fmt.Printf("Category: %s, Keywords: %s\n", k, strings.Join(v, ", "))
 	}
@@ -251,28 +270,12 @@ func main() {
 	s := storage.New(pool, nil)
 	log.Println("Storage initialized successfully")
 
-	// check if the embedding model exists
+	// get the embedding model, inserting it if it does not exist
 	modelInsertCtx, modelInsertCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer modelInsertCancel()
-	mID, err := s.Models().Insert(modelInsertCtx, embedModel)
-
+	mID, err := s.Models().GetOrCreate(modelInsertCtx, embedModel, 1024)
 	if err != nil {
-		if e, ok := err.(*errors.Error); ok {
-			if e.Code == errors.ECIntegrityConstrainViolation {
-				log.Printf("Model '%s' already exists in storage, skipping insertion", embedModel)
-				modelGetCtx, modelGetCancel := context.WithTimeout(context.Background(), 5*time.Second)
-				defer modelGetCancel()
-				m, err := s.Models().GetByName(modelGetCtx, embedModel)
-				if err != nil {
-					log.Fatalf("failed to get model by name '%s': %v", embedModel, err)
-				}
-				mID = m.ID
-			} else {
-				log.Fatalf("failed to insert model into storage: %v", e)
-			}
-		} else {
-			log.Fatalf("failed to insert model into storage: %v", err)
-		}
+		log.Fatalf("failed to get or create model '%s': %v", embedModel, err)
 	}
 	log.Printf("Model '%s' inserted/get with ID: %d", embedModel, mID)
 
@@ -352,37 +355,46 @@ func main() {
 			}
 			dbInsertCtx, dbInsertCancel = context.WithTimeout(context.Background(), 5*time.Second)
 			defer dbInsertCancel()
-			offsets, err := s.UserChunks().BatchInsert(dbInsertCtx, article.ID, paragraphs, chunkSize, chunkOverlap)
-			if err != nil {
+			if _, err := s.UserChunks().BatchInsert(dbInsertCtx, article.ID, paragraphs, chunkSize, chunkOverlap); err != nil {
 				log.Fatalf("failed to insert chunks into storage: %v", err)
 			}
 
-			chunks := make([]string, 0, len(offsets))
-			for _, offset := range offsets {
-				chunk, _, _, _ := llm.ExtractChunk(article.Content, offset)
-				chunks = append(chunks, chunk)
+			stored, err := s.UserChunks().GetWithContent(storage.WithFreshReads(dbInsertCtx), article.ID)
+			if err != nil {
+				log.Fatalf("failed to get chunks from storage: %v", err)
+			}
+			stored, err = unembedded(dbInsertCtx, s, mID, stored)
+			if err != nil {
+				log.Fatalf("failed to check the embedded chunks: %v", err)
+			}
+
+			chunks := make([]string, len(stored))
+			for i, c := range stored {
+				chunks[i] = c.Content
 			}
-			embeddings, err := Embedding(chunks, "user-123", embedModel)
+			embeddings, err := Embedding(chunks, task.TaskID.String(), embedModel)
 			if err != nil {
 				log.Fatalf("failed to get embedding for query: %v", err)
 			}
 
+			items := make([]storage.EmbeddingItem, len(embeddings))
 			for i, embedding := range embeddings {
-				dbInsertCtx, dbInsertCancel = context.WithTimeout(context.Background(), 5*time.Second)
-				defer dbInsertCancel()
-				_, err = s.UserEmbeddings().Insert(
-					dbInsertCtx,
-					article.ID,
-					offsets[i].ID,
-					mID,
-					utils.ToFloat32(embedding),
-				)
-
-				if err != nil {
-					log.Fatalf("failed to insert embedding into storage: aID: %d, cID: %d, mID: %d, msg: %v",
-						article.ID, offsets[i].ID, mID, err)
+				items[i] = storage.EmbeddingItem{
+					ArticleID: article.ID,
+					ChunkID:   stored[i].Chunk.ID,
+					ModelID:   mID,
+					Embedding: llm.SplitEmbedding{
+						Embedding: llm.Embedding{Values: embedding},
+						SubCount:  1,
+					},
 				}
 			}
+			dbInsertCtx, dbInsertCancel = context.WithTimeout(context.Background(), 5*time.Second)
+			defer dbInsertCancel()
+			if _, err = s.UserEmbeddings().BatchInsert(dbInsertCtx, items); err != nil {
+				log.Fatalf("failed to insert embeddings into storage: aID: %d, mID: %d, msg: %v",
+					article.ID, mID, err)
+			}
 			sleep := time.Duration(rand.IntN(500)+200) * time.Millisecond
 			time.Sleep(sleep)
 		}
@@ -447,37 +459,46 @@ func main() {
 			}
 			dbInsertCtx, dbInsertCancel = context.WithTimeout(context.Background(), 5*time.Second)
 			defer dbInsertCancel()
-			offsets, err := s.UserChunks().BatchInsert(dbInsertCtx, article.ID, paragraphs, chunkSize, chunkOverlap)
-			if err != nil {
+			if _, err := s.UserChunks().BatchInsert(dbInsertCtx, article.ID, paragraphs, chunkSize, chunkOverlap); err != nil {
 				log.Fatalf("failed to insert chunks into storage: %v", err)
 			}
 
-			chunks := make([]string, 0, len(offsets))
-			for _, offset := range offsets {
-				chunk, _, _, _ := llm.ExtractChunk(article.Content, offset)
-				chunks = append(chunks, chunk)
+			stored, err := s.UserChunks().GetWithContent(storage.WithFreshReads(dbInsertCtx), article.ID)
+			if err != nil {
+				log.Fatalf("failed to get chunks from storage: %v", err)
+			}
+			stored, err = unembedded(dbInsertCtx, s, mID, stored)
+			if err != nil {
+				log.Fatalf("failed to check the embedded chunks: %v", err)
+			}
+
+			chunks := make([]string, len(stored))
+			for i, c := range stored {
+				chunks[i] = c.Content
 			}
-			embeddings, err := Embedding(chunks, "user-123", embedModel)
+			embeddings, err := Embedding(chunks, task.TaskID.String(), embedModel)
 			if err != nil {
 				log.Fatalf("failed to get embedding for query: %v", err)
 			}
 
+			items := make([]storage.EmbeddingItem, len(embeddings))
 			for i, embedding := range embeddings {
-				dbInsertCtx, dbInsertCancel = context.WithTimeout(context.Background(), 5*time.Second)
-				defer dbInsertCancel()
-				_, err = s.UserEmbeddings().Insert(
-					dbInsertCtx,
-					article.ID,
-					offsets[i].ID,
-					mID,
-					utils.ToFloat32(embedding),
-				)
-
-				if err != nil {
-					log.Fatalf("failed to insert embedding into storage: aID: %d, cID: %d, mID: %d, msg: %v",
-						article.ID, offsets[i].ID, mID, err)
+				items[i] = storage.EmbeddingItem{
+					ArticleID: article.ID,
+					ChunkID:   stored[i].Chunk.ID,
+					ModelID:   mID,
+					Embedding: llm.SplitEmbedding{
+						Embedding: llm.Embedding{Values: embedding},
+						SubCount:  1,
+					},
 				}
 			}
+			dbInsertCtx, dbInsertCancel = context.WithTimeout(context.Background(), 5*time.Second)
+			defer dbInsertCancel()
+			if _, err = s.UserEmbeddings().BatchInsert(dbInsertCtx, items); err != nil {
+				log.Fatalf("failed to insert embeddings into storage: aID: %d, mID: %d, msg: %v",
+					article.ID, mID, err)
+			}
 			sleep := time.Duration(rand.IntN(500)) * time.Millisecond
 			time.Sleep(sleep)
 		}
@@ -7,6 +7,9 @@ package main
 
 // 	"github.com/ChiaYuChang/weathercock/internal/global"
 // 	"github.com/ChiaYuChang/weathercock/internal/llm"
+// 	_ "github.com/ChiaYuChang/weathercock/internal/llm/gemini"
+// 	_ "github.com/ChiaYuChang/weathercock/internal/llm/ollama"
+// 	_ "github.com/ChiaYuChang/weathercock/internal/llm/openai"
 // 	"github.com/ChiaYuChang/weathercock/internal/storage"
 // 	"github.com/ChiaYuChang/weathercock/internal/workers"
 // 	"github.com/ChiaYuChang/weathercock/internal/workers/subscribers"
@@ -46,7 +49,7 @@ package main
 // 	store := storage.NewStorage(global.PGXPool)
 
 // 	// Create LLM client
-// 	llmClient, err := llm.NewClient(cfg.LLM, global.Logger, global.Tracer)
+// 	llmClient, err := llm.NewFromConfig(context.Background(), cfg.LLM)
 // 	if err != nil {
 // 		global.Logger.Fatal().Err(err).Msg("Failed to create LLM client")
 // 	}
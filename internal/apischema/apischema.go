@@ -0,0 +1,210 @@
+// Package apischema holds the paths, the parameters and the payloads of the
+// HTTP API shared by the server (internal/router) and the Go client
+// (pkgs/client), so that the two cannot drift apart.
+package apischema
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Paths of the API. The ones with a {name} part are formatted with Path.
+const (
+	PathTaskFromURL      = "/api/v1/task/url"
+	PathTaskFromText     = "/api/v1/task/text"
+	PathTask             = "/api/v1/tasks/{task_id}"
+	PathTaskTimeline     = "/api/v1/tasks/{task_id}/timeline"
+	PathTaskRecover      = "/api/v1/tasks/recover/{intake_id}"
+	PathArticles         = "/api/v1/articles"
+	PathKeywordAnomalies = "/api/v1/keywords/anomalies"
+	PathStatsDashboard   = "/api/v1/stats/dashboard"
+	PathSourceWeights    = "/api/v1/admin/source-weights"
+	PathSourceWeight     = "/api/v1/admin/source-weights/{source}"
+	PathReconciliation   = "/api/v1/admin/reconciliation"
+	PathVectorIndexes    = "/api/v1/admin/vector-indexes"
+	PathVectorReindex    = "/api/v1/admin/vector-indexes/{index}/reindex"
+	PathVectorIndexJob   = "/api/v1/admin/vector-indexes/jobs/{job_id}"
+)
+
+// Path replaces the {name} parts of path with the escaped values, given as
+// name, value pairs.
+func Path(path string, pairs ...string) string {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		path = strings.ReplaceAll(path, "{"+pairs[i]+"}", url.PathEscape(pairs[i+1]))
+	}
+	return path
+}
+
+// Headers of the API.
+const (
+	// EditorTokenHeader is the header carrying the editor token, an
+	// "Authorization: Bearer <token>" header is accepted as well.
+	EditorTokenHeader = "X-Editor-Token"
+	// HTMXRequestHeader is set by htmx on the requests of the web UI, which
+	// expect HTML fragments or no body rather than JSON.
+	HTMXRequestHeader = "HX-Request"
+	// PushURLHeader tells htmx the page of a created task.
+	PushURLHeader = "HX-PUSH-URL"
+	// IntakeIDHeader carries the intake id of a task submission which failed
+	// after it was journaled, the submission is recovered with it, see
+	// PathTaskRecover.
+	IntakeIDHeader = "X-Intake-ID"
+)
+
+// Form fields of the task creation.
+const (
+	FormQueryURL  = "query_url"
+	FormQueryText = "query_text"
+)
+
+// TaskCreated is the response of the task creation, if not requested by the
+// web UI.
+type TaskCreated struct {
+	TaskID uuid.UUID `json:"task_id"`
+}
+
+// Query parameters of the article list and of the keyword anomalies.
+const (
+	ParamDead      = "dead"
+	ParamQuery     = "q"
+	ParamSort      = "sort"
+	ParamTauHours  = "tau_hours"
+	ParamRecency   = "w_recency"
+	ParamSource    = "w_source"
+	ParamRelevance = "w_relevance"
+	ParamLimit     = "limit"
+	ParamOffset    = "offset"
+	ParamSince     = "since"
+)
+
+// ParamLang is the query parameter choosing the locale of the labels of any
+// response, e.g. lang=en. It takes precedence over the Accept-Language header.
+const ParamLang = "lang"
+
+// Page sizes of the article list.
+const (
+	DefaultArticlesPageSize = 20
+	MaxArticlesPageSize     = 100
+)
+
+// Orderings of the article list.
+const (
+	ArticlesSortRecent = "recent"
+	ArticlesSortTop    = "top"
+)
+
+// ArticleListItem is an article of the article list. Score is only set in the
+// top ordering.
+type ArticleListItem struct {
+	models.ListArticlesWithURLStatusRow
+	Score *float64 `json:"score,omitempty"`
+}
+
+// ArticleFilter is the query of the article list, the zero values are left
+// to the server defaults.
+type ArticleFilter struct {
+	Dead      *bool
+	Query     string
+	Sort      string
+	TauHours  *float64
+	Recency   *float64
+	Source    *float64
+	Relevance *float64
+	Limit     int
+	Offset    int
+}
+
+// Values encodes f as query parameters.
+func (f ArticleFilter) Values() url.Values {
+	v := url.Values{}
+	if f.Dead != nil {
+		v.Set(ParamDead, strconv.FormatBool(*f.Dead))
+	}
+
+	if f.Query != "" {
+		v.Set(ParamQuery, f.Query)
+	}
+
+	if f.Sort != "" {
+		v.Set(ParamSort, f.Sort)
+	}
+
+	for name, w := range map[string]*float64{
+		ParamTauHours:  f.TauHours,
+		ParamRecency:   f.Recency,
+		ParamSource:    f.Source,
+		ParamRelevance: f.Relevance,
+	} {
+		if w != nil {
+			v.Set(name, strconv.FormatFloat(*w, 'g', -1, 64))
+		}
+	}
+
+	if f.Limit > 0 {
+		v.Set(ParamLimit, strconv.Itoa(f.Limit))
+	}
+
+	if f.Offset > 0 {
+		v.Set(ParamOffset, strconv.Itoa(f.Offset))
+	}
+	return v
+}
+
+// ArticlesResponse is the response of the article list.
+type ArticlesResponse struct {
+	Articles []ArticleListItem `json:"articles"`
+}
+
+// KeywordAnomaliesResponse is the response of the keyword anomalies.
+type KeywordAnomaliesResponse struct {
+	Anomalies []models.ListKeywordAnomaliesSinceRow `json:"anomalies"`
+}
+
+// SourceWeightsResponse is the response of the source weight list.
+type SourceWeightsResponse struct {
+	SourceWeights []models.SourceWeight `json:"source_weights"`
+}
+
+// ReconciliationResponse is the response of the reconciliation reports.
+type ReconciliationResponse struct {
+	Reports []storage.ReconciliationReport `json:"reports"`
+}
+
+// VectorIndexesResponse is the response of the vector index inspection.
+type VectorIndexesResponse struct {
+	Indexes []storage.VectorIndexStats `json:"indexes"`
+}
+
+// The statuses of a VectorIndexJob.
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// VectorIndexJob is a rebuild of a vector index run in the background, see
+// storage.VectorIndexMaintenance.ReindexConcurrently. Progress is the last
+// progress reported, Record the record of the finished rebuild.
+type VectorIndexJob struct {
+	ID         uuid.UUID                  `json:"id"`
+	Index      string                     `json:"index"`
+	Params     storage.ReindexParams      `json:"params"`
+	Status     string                     `json:"status"`
+	Progress   storage.ReindexProgress    `json:"progress"`
+	Record     *storage.VectorIndexRecord `json:"record,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+	StartedAt  time.Time                  `json:"started_at"`
+	FinishedAt *time.Time                 `json:"finished_at,omitempty"`
+}
+
+// SourceWeightRequest is the request body to set the weight of a source.
+type SourceWeightRequest struct {
+	Weight *float64 `json:"weight" validate:"required,min=0,max=1"`
+	Editor string   `json:"editor" validate:"required,max=64"`
+}
@@ -0,0 +1,80 @@
+package e2etest
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers/fixtures"
+)
+
+// ArticleHost is the host of the articles served by the ArticleServer.
+const ArticleHost = "https://tw.news.yahoo.com"
+
+// Article is an article served by the ArticleServer.
+type Article struct {
+	Title       string
+	Publisher   string
+	Paragraphs  []string
+	PublishedAt time.Time
+}
+
+// yahooArticleTmpl renders an article with the markup scrapers.ParseYahooNewsBody
+// extracts the fields from.
+var yahooArticleTmpl = template.Must(template.New("article").Parse(`<!DOCTYPE html>
+<html>
+<body>
+<div class="caas-container">
+<script type="application/ld+json">{"datePublished":"{{.Published}}","dateModified":"{{.Published}}","keywords":["e2e"]}</script>
+<header class="caas-header"><div class="caas-logo"><span class="caas-attr-provider">{{.Publisher}}</span></div></header>
+<h1 id="caas-lead-header-undefined">{{.Title}}</h1>
+<div class="caas-body">
+{{range .Paragraphs}}<p>{{.}}</p>
+{{end}}</div>
+</div>
+</body>
+</html>`))
+
+// ArticleServer is a fixture server the scraper fetches the articles from,
+// counting the requests of every path. The articles are served at ArticleHost,
+// fetch them with the Client or through the Transport.
+type ArticleServer struct {
+	*fixtures.Server
+}
+
+// NewArticleServer starts an ArticleServer. Close it when done.
+func NewArticleServer() *ArticleServer {
+	srv, err := fixtures.NewServer(global.FixturesConfig{})
+	if err != nil {
+		panic(err)
+	}
+	return &ArticleServer{Server: srv}
+}
+
+// Add serves article at path and returns its URL.
+func (s *ArticleServer) Add(path string, article Article) string {
+	var buf bytes.Buffer
+	err := yahooArticleTmpl.Execute(&buf, map[string]any{
+		"Title":      article.Title,
+		"Publisher":  article.Publisher,
+		"Paragraphs": article.Paragraphs,
+		"Published":  article.PublishedAt.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to render article: %v", err))
+	}
+
+	u := ArticleHost + path
+	if err := s.Server.Add(u, fixtures.Page{Status: http.StatusOK, Body: buf.Bytes()}); err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// Hits returns the number of requests to path.
+func (s *ArticleServer) Hits(path string) int {
+	return s.Server.Hits(ArticleHost + path)
+}
@@ -0,0 +1,15 @@
+// Package e2etest drives tasks through the scrape and keyword extraction
+// pipeline while injecting dependency failures, and checks the invariants the
+// pipeline should keep under them.
+//
+// The package provides the fault injection hooks: Proxy cuts the connections
+// to NATS, Postgres or Valkey, FakeLLM fails or blocks LLM calls, and
+// ArticleServer serves the articles to scrape. The scenarios are integration
+// tests, run them with
+//
+//	TEST_POSTGRES_URL=postgres://... TEST_NATS_URL=nats://... \
+//	TEST_VALKEY_URL=redis://... go test -tags integration ./internal/e2etest/
+//
+// The scenarios exposing a known gap of the pipeline are skipped with a
+// reference to the issue tracking it.
+package e2etest
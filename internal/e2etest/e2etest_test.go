@@ -0,0 +1,155 @@
+package e2etest_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/e2etest"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/stretchr/testify/require"
+)
+
+// echoServer echoes every line back to the client.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if _, err := conn.Write([]byte(line)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func echo(conn net.Conn, msg string) (string, error) {
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte(msg + "\n")); err != nil {
+		return "", err
+	}
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestProxy(t *testing.T) {
+	p, err := e2etest.NewProxy(echoServer(t))
+	require.NoError(t, err)
+	defer p.Close()
+
+	conn, err := net.Dial("tcp", p.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	got, err := echo(conn, "ping")
+	require.NoError(t, err)
+	require.Equal(t, "ping\n", got)
+
+	// the live connection is dropped and the new ones are refused
+	p.Cut()
+	_, err = echo(conn, "ping")
+	require.Error(t, err)
+
+	cut, err := net.Dial("tcp", p.Addr())
+	require.NoError(t, err)
+	defer cut.Close()
+	_, err = echo(cut, "ping")
+	require.Error(t, err)
+
+	p.Restore()
+	restored, err := net.Dial("tcp", p.Addr())
+	require.NoError(t, err)
+	defer restored.Close()
+	got, err = echo(restored, "pong")
+	require.NoError(t, err)
+	require.Equal(t, "pong\n", got)
+	require.Equal(t, 1, p.Cuts())
+
+	<-p.CutFor(10 * time.Millisecond)
+	require.Equal(t, 2, p.Cuts())
+}
+
+func TestFakeLLM(t *testing.T) {
+	ctx := context.Background()
+	cli := e2etest.NewFakeLLM(`{"keywords":{}}`)
+	cli.Inject(e2etest.FailFirst(2, e2etest.ErrRateLimited))
+
+	for range 2 {
+		_, err := cli.Generate(ctx, nil)
+		require.ErrorIs(t, err, e2etest.ErrRateLimited)
+	}
+
+	resp, err := cli.Generate(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"keywords":{}}`}, resp.Outputs)
+	require.Equal(t, 3, cli.Calls())
+
+	// a blocked call returns once its context is done
+	cli = e2etest.NewFakeLLM("{}")
+	entered := make(chan struct{})
+	cli.Inject(e2etest.BlockFirst(entered))
+
+	bCtx, cancel := context.WithCancel(ctx)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := cli.Generate(bCtx, nil)
+		errs <- err
+	}()
+	<-entered
+	cancel()
+	require.ErrorIs(t, <-errs, context.Canceled)
+
+	_, err = cli.Generate(ctx, nil)
+	require.NoError(t, err)
+}
+
+func TestArticleServer(t *testing.T) {
+	srv := e2etest.NewArticleServer()
+	defer srv.Close()
+
+	publishedAt := time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)
+	url := srv.Add("/news/1.html", e2etest.Article{
+		Title:       "能源政策辯論",
+		Publisher:   "e2e news",
+		Paragraphs:  []string{"第一段。", "第二段。"},
+		PublishedAt: publishedAt,
+	})
+
+	require.Equal(t, e2etest.ArticleHost+"/news/1.html", url)
+
+	cli := srv.Client()
+	resp, err := cli.Get(url)
+	require.NoError(t, err)
+	result := scrapers.ParseYahooNewsResp(resp)
+	require.Nil(t, result.Error)
+	require.Equal(t, "能源政策辯論", result.Article.Title)
+	require.Equal(t, "e2e news", result.Article.Publisher)
+	require.Equal(t, []string{"第一段。", "第二段。"}, result.Article.Content)
+	require.True(t, publishedAt.Equal(result.Article.Published))
+	require.Equal(t, 1, srv.Hits("/news/1.html"))
+
+	resp, err = cli.Get(e2etest.ArticleHost + "/missing")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,111 @@
+package e2etest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+)
+
+// ErrRateLimited is the error a rate limited LLM provider responds with.
+var ErrRateLimited = ec.NewWithHTTPStatus(http.StatusTooManyRequests,
+	ec.ECTooManyRequests, "too many requests")
+
+// ErrNotSupported is returned by the FakeLLM methods the pipeline does not use.
+var ErrNotSupported = errors.New("not supported by the fake LLM")
+
+// Fault decides whether the n-th call (starting at 1) to the FakeLLM fails.
+// A nil error lets the call through.
+type Fault func(ctx context.Context, n int) error
+
+// FailFirst fails the first n calls with err.
+func FailFirst(n int, err error) Fault {
+	return func(_ context.Context, call int) error {
+		if call <= n {
+			return err
+		}
+		return nil
+	}
+}
+
+// BlockFirst blocks the first call until its context is done and signals
+// entered once it is blocked, which lets a test kill a worker mid-Handle.
+func BlockFirst(entered chan<- struct{}) Fault {
+	return func(ctx context.Context, call int) error {
+		if call != 1 {
+			return nil
+		}
+		close(entered)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+}
+
+// FakeLLM is an llm.LLM answering every generation with Output, unless a
+// Fault injected into it fails the call.
+type FakeLLM struct {
+	*llm.BaseClient
+	Output string
+
+	mu     sync.Mutex
+	calls  int
+	faults []Fault
+}
+
+var _ llm.LLM = (*FakeLLM)(nil)
+
+// NewFakeLLM creates a FakeLLM answering with output.
+func NewFakeLLM(output string) *FakeLLM {
+	return &FakeLLM{
+		BaseClient: llm.NewClient(),
+		Output:     output,
+	}
+}
+
+// Inject adds a fault to the following calls.
+func (f *FakeLLM) Inject(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, fault)
+}
+
+// Calls returns the number of calls to Generate.
+func (f *FakeLLM) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (f *FakeLLM) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm.GenerateResponse, error) {
+	f.mu.Lock()
+	f.calls++
+	n := f.calls
+	faults := append([]Fault(nil), f.faults...)
+	f.mu.Unlock()
+
+	for _, fault := range faults {
+		if err := fault(ctx, n); err != nil {
+			return nil, err
+		}
+	}
+	return &llm.GenerateResponse{Outputs: []string{f.Output}}, nil
+}
+
+func (f *FakeLLM) BatchCreate(ctx context.Context, reqs *llm.BatchRequest) (*llm.BatchResponse, error) {
+	return nil, ErrNotSupported
+}
+
+func (f *FakeLLM) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequest) (*llm.BatchResponse, error) {
+	return nil, ErrNotSupported
+}
+
+func (f *FakeLLM) BatchCancel(ctx context.Context, req *llm.BatchCancelRequest) error {
+	return ErrNotSupported
+}
+
+func (f *FakeLLM) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.EmbedResponse, error) {
+	return nil, ErrNotSupported
+}
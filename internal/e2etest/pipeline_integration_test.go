@@ -0,0 +1,525 @@
+//go:build integration
+
+package e2etest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/e2etest"
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/ChiaYuChang/weathercock/internal/workers/publishers"
+	"github.com/ChiaYuChang/weathercock/internal/workers/subscribers"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	// eventStream stores the events the pipeline publishes, so that the suite
+	// can count them.
+	eventStream = "E2E_EVENTS"
+	// scenarioTimeout bounds a scenario, which has to outlast a NAK delay and
+	// an ack wait of the consumers.
+	scenarioTimeout = 2 * time.Minute
+)
+
+const keywordsOutput = `{"keywords":{"themes":["能源"],"events":["電價調整"],"entities":["台電"],"actions":["漲價"]},"relations":[]}`
+
+// eventLog counts the events of every task by subject. An event is counted
+// once however many times it is delivered.
+type eventLog struct {
+	mu     sync.Mutex
+	seen   map[uint64]bool
+	counts map[uuid.UUID]map[string]int
+}
+
+func (l *eventLog) record(seq uint64, subject string, taskID uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[seq] {
+		return
+	}
+	l.seen[seq] = true
+	if l.counts[taskID] == nil {
+		l.counts[taskID] = map[string]int{}
+	}
+	l.counts[taskID][subject]++
+}
+
+func (l *eventLog) count(taskID uuid.UUID, subject string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.counts[taskID][subject]
+}
+
+// stageHandler counts the messages handled by the wrapped worker and calls the
+// hooks around Handle to inject the faults. The worker records its stage in
+// the timeline of the task itself.
+type stageHandler struct {
+	workers.Handler
+	before func(n int)
+	after  func(n int, err error)
+
+	mu sync.Mutex
+	n  int
+}
+
+func (h *stageHandler) Handle(ctx context.Context, msg *nats.Msg) error {
+	h.mu.Lock()
+	h.n++
+	n := h.n
+	h.mu.Unlock()
+
+	if h.before != nil {
+		h.before(n)
+	}
+	err := h.Handler.Handle(ctx, msg)
+	if h.after != nil {
+		h.after(n, err)
+	}
+	return err
+}
+
+// pipeline is the scrape and keyword extraction pipeline wired to the
+// dependencies through fault proxies.
+type pipeline struct {
+	pg, nats, valkey *e2etest.Proxy
+
+	pool   *pgxpool.Pool
+	store  storage.Storage
+	nc     *nats.Conn
+	js     nats.JetStreamContext
+	cache  *redis.Client
+	llm    *e2etest.FakeLLM
+	site   *e2etest.ArticleServer
+	events *eventLog
+
+	logger zerolog.Logger
+	tracer trace.Tracer
+}
+
+// proxied puts a proxy in front of the host of rawURL and returns the URL
+// pointing to the proxy.
+func proxied(t *testing.T, rawURL string) (*e2etest.Proxy, string) {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+
+	p, err := e2etest.NewProxy(u.Host)
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+
+	u.Host = p.Addr()
+	return p, u.String()
+}
+
+// newPipeline connects to the dependencies at TEST_POSTGRES_URL (which needs
+// the pgvector extension), TEST_NATS_URL (with JetStream enabled) and
+// TEST_VALKEY_URL, and recreates the streams of the pipeline.
+func newPipeline(t *testing.T) *pipeline {
+	t.Helper()
+	pgURL, natsURL, valkeyURL := os.Getenv("TEST_POSTGRES_URL"),
+		os.Getenv("TEST_NATS_URL"), os.Getenv("TEST_VALKEY_URL")
+	if pgURL == "" || natsURL == "" || valkeyURL == "" {
+		t.Skip("TEST_POSTGRES_URL, TEST_NATS_URL or TEST_VALKEY_URL is not set")
+	}
+
+	m, err := global.Migrate("file://../../migrations", pgURL)
+	require.NoError(t, err)
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		require.NoError(t, err)
+	}
+	m.Close()
+
+	p := &pipeline{
+		llm:    e2etest.NewFakeLLM(keywordsOutput),
+		site:   e2etest.NewArticleServer(),
+		events: &eventLog{seen: map[uint64]bool{}, counts: map[uuid.UUID]map[string]int{}},
+		logger: zerolog.New(zerolog.NewTestWriter(t)).Level(zerolog.WarnLevel),
+		tracer: noop.NewTracerProvider().Tracer("e2e"),
+	}
+	t.Cleanup(p.site.Close)
+
+	var proxiedURL string
+	p.pg, proxiedURL = proxied(t, pgURL)
+	p.pool, err = pgxpool.New(context.Background(), proxiedURL)
+	require.NoError(t, err)
+	t.Cleanup(p.pool.Close)
+
+	p.valkey, proxiedURL = proxied(t, valkeyURL)
+	opts, err := redis.ParseURL(proxiedURL)
+	require.NoError(t, err)
+	p.cache = redis.NewClient(opts)
+	t.Cleanup(func() { p.cache.Close() })
+	p.store = storage.New(p.pool, p.cache)
+
+	p.nats, proxiedURL = proxied(t, natsURL)
+	p.nc, err = nats.Connect(proxiedURL,
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(200*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(p.nc.Close)
+
+	p.js, err = p.nc.JetStream()
+	require.NoError(t, err)
+	for name, subjects := range map[string][]string{
+		subscribers.ScraperWorkerStreamName: {
+			workers.SubjectCmd(workers.StageScrape), workers.SubjectCmd(workers.StageExtractKeywords),
+			workers.TaskScrape, workers.TaskExtractKeywords,
+		},
+		eventStream: {
+			workers.SubjectAllTaskEvents,
+			workers.ArticleScraped, workers.KeywordsExtracted, workers.TaskFailed,
+		},
+	} {
+		if err := p.js.DeleteStream(name); err != nil && !errors.Is(err, nats.ErrStreamNotFound) {
+			require.NoError(t, err)
+		}
+		_, err := p.js.AddStream(&nats.StreamConfig{
+			Name:     name,
+			Subjects: subjects,
+			Storage:  nats.MemoryStorage,
+		})
+		require.NoError(t, err)
+	}
+
+	// conduct the pipeline: a scraped article is handed to the keyword
+	// extractor, deduplicated by the task so a redelivered event does not
+	// extract twice.
+	sub, err := p.js.Subscribe(">", func(msg *nats.Msg) {
+		// the copies dual published on the legacy subjects
+		if msg.Header.Get(workers.AliasOfHeader) != "" {
+			_ = msg.Ack()
+			return
+		}
+
+		var base workers.BaseMessage
+		if err := json.Unmarshal(msg.Data, &base); err != nil {
+			t.Errorf("malformed %s event: %v", msg.Subject, err)
+			_ = msg.Term()
+			return
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			t.Errorf("%s event without metadata: %v", msg.Subject, err)
+			return
+		}
+		p.events.record(meta.Sequence.Stream, msg.Subject, base.TaskID)
+
+		if msg.Subject == workers.SubjectEvt(workers.StageScrape, workers.OutcomeDone) {
+			var scraped workers.MsgArticleScraped
+			_ = json.Unmarshal(msg.Data, &scraped)
+			data, _ := json.Marshal(workers.CmdExtractKeywords{
+				BaseMessage: scraped.BaseMessage,
+				ArticleID:   scraped.ArticleID,
+			})
+			if _, err := p.js.Publish(workers.SubjectCmd(workers.StageExtractKeywords), data,
+				nats.MsgId("extract-"+base.TaskID.String())); err != nil {
+				_ = msg.Nak()
+				return
+			}
+		}
+		_ = msg.Ack()
+	}, nats.BindStream(eventStream), nats.DeliverAll(), nats.AckExplicit(), nats.ManualAck())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sub.Unsubscribe() })
+	return p
+}
+
+// freePort returns a free local port for the health check of a runner.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// run runs the handler until the returned cancel is called, which waits for
+// the runner to exit.
+func (p *pipeline) run(t *testing.T, h workers.Handler) (cancel func()) {
+	t.Helper()
+	r, err := workers.NewRunner(p.nc, p.logger, p.tracer, h,
+		workers.WithHealthCheckPort(freePort(t)),
+		workers.WithTimeout(30*time.Second),
+		workers.WithShutdownWaitTime(time.Second))
+	require.NoError(t, err)
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = r.Run(ctx)
+	}()
+
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			stop()
+			<-done
+		})
+	}
+	t.Cleanup(cancel)
+	return cancel
+}
+
+func (p *pipeline) scraper(t *testing.T) *stageHandler {
+	t.Helper()
+	w, err := subscribers.NewScraperWorker(p.nc, p.logger, p.tracer, &p.store, p.cache)
+	require.NoError(t, err)
+	w.WithTransport(p.site.Transport())
+	return &stageHandler{Handler: w}
+}
+
+func (p *pipeline) keywords(t *testing.T) *stageHandler {
+	t.Helper()
+	w, err := subscribers.NewKeywordExtractorWorker(p.nc, p.logger, p.tracer, &p.store, p.cache,
+		subscribers.NewLLM(p.llm, "fake", "extract the keywords", nil), nil)
+	require.NoError(t, err)
+	return &stageHandler{Handler: w}
+}
+
+// submit serves a new article and submits its URL the way the API does.
+func (p *pipeline) submit(t *testing.T) uuid.UUID {
+	t.Helper()
+	path := fmt.Sprintf("/news/%s.html", uuid.NewString())
+	articleURL := p.site.Add(path, e2etest.Article{
+		Title:       "電價調整 " + path,
+		Publisher:   "e2e news",
+		Paragraphs:  []string{"台電宣布電價調整方案。", "能源政策引發討論。"},
+		PublishedAt: time.Now().Truncate(time.Second),
+	})
+
+	pub := publishers.NewPublisher("e2e-publisher", p.js, p.logger, p.tracer)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	taskID, err := p.store.Task().InsertFromURL(ctx, articleURL, func(ctx context.Context, taskID uuid.UUID) error {
+		return pub.PublishNATSMessage(ctx, workers.SubjectCmd(workers.StageScrape), workers.CmdScrapeArticle{
+			BaseMessage: workers.BaseMessage{
+				TaskID:  taskID,
+				Version: workers.MessageVersion,
+				EventAt: time.Now().Unix(),
+			},
+			URL: articleURL,
+		})
+	})
+	require.NoError(t, err)
+	return taskID
+}
+
+// waitExtracted waits for the keywords of the task to be extracted.
+func (p *pipeline) waitExtracted(t *testing.T, taskID uuid.UUID) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return p.events.count(taskID, workers.SubjectEvt(workers.StageExtractKeywords, workers.OutcomeDone)) > 0
+	}, scenarioTimeout, 100*time.Millisecond, "keywords of task %s are never extracted", taskID)
+}
+
+func (p *pipeline) queryInt(t *testing.T, sql string, args ...any) int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var n int
+	require.NoError(t, p.pool.QueryRow(ctx, sql, args...).Scan(&n))
+	return n
+}
+
+// requireInvariants checks that the task has reached a terminal state, that
+// nothing has been stored twice, and that every event has been published once.
+func (p *pipeline) requireInvariants(t *testing.T, taskID uuid.UUID) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	state, err := p.store.TaskEvents().State(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusDone, state.Status, "task should be done: %+v", state.Stages)
+
+	require.Equal(t, 1, p.queryInt(t,
+		`SELECT count(*) FROM users.articles WHERE task_id = $1`, taskID))
+	require.Zero(t, p.queryInt(t, `
+		SELECT count(*) FROM (
+			SELECT 1 FROM users.chunks AS c JOIN users.articles AS a ON a.id = c.article_id
+			WHERE a.task_id = $1
+			GROUP BY c.article_id, c.start, c."end" HAVING count(*) > 1
+		) AS d`, taskID), "duplicate chunks")
+	require.Zero(t, p.queryInt(t, `
+		SELECT count(*) FROM (
+			SELECT 1 FROM users.embeddings AS e JOIN users.articles AS a ON a.id = e.article_id
+			WHERE a.task_id = $1
+			GROUP BY e.chunk_id, e.model_id HAVING count(*) > 1
+		) AS d`, taskID), "duplicate embeddings")
+	require.Equal(t, 4, p.queryInt(t, `
+		SELECT count(*) FROM users.articles_keywords AS ak JOIN users.articles AS a ON a.id = ak.article_id
+		WHERE a.task_id = $1`, taskID), "keywords should be linked once")
+
+	// give a duplicate event the time to show up before counting
+	time.Sleep(time.Second)
+	require.Equal(t, 1, p.events.count(taskID, workers.SubjectEvt(workers.StageScrape, workers.OutcomeDone)))
+	require.Equal(t, 1, p.events.count(taskID, workers.SubjectEvt(workers.StageExtractKeywords, workers.OutcomeDone)))
+	require.Zero(t, p.events.count(taskID, workers.SubjectEvt(workers.StageScrape, workers.OutcomeFailed)))
+	require.Zero(t, p.events.count(taskID, workers.SubjectEvt(workers.StageExtractKeywords, workers.OutcomeFailed)))
+}
+
+// stageStatuses returns the statuses the timeline of the task records for
+// stage, in order.
+func (p *pipeline) stageStatuses(t *testing.T, taskID uuid.UUID, stage workers.Stage) []models.TaskStatus {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	timeline, err := p.store.TaskEvents().Timeline(ctx, taskID)
+	require.NoError(t, err)
+
+	var statuses []models.TaskStatus
+	for _, e := range timeline.Events {
+		if e.Stage == stage.String() {
+			statuses = append(statuses, e.Status)
+		}
+	}
+	return statuses
+}
+
+// requireRecovered checks that the timeline records a failure of stage and
+// its recovery.
+func (p *pipeline) requireRecovered(t *testing.T, taskID uuid.UUID, stage workers.Stage) {
+	t.Helper()
+	statuses := p.stageStatuses(t, taskID, stage)
+
+	failed := false
+	for _, status := range statuses {
+		if status == models.TaskStatusFailed {
+			failed = true
+		}
+		if status == models.TaskStatusDone {
+			require.True(t, failed, "stage %s is done without a recorded failure", stage)
+			return
+		}
+	}
+	require.Fail(t, "stage has not recovered", "stage %s: %v", stage, statuses)
+}
+
+func TestPipelineNATSDroppedAfterScrapePublish(t *testing.T) {
+	p := newPipeline(t)
+	scraper := p.scraper(t)
+	var restored <-chan struct{}
+	scraper.after = func(n int, err error) {
+		if n == 1 && err == nil {
+			restored = p.nats.CutFor(10 * time.Second)
+		}
+	}
+	p.run(t, scraper)
+	p.run(t, p.keywords(t))
+
+	taskID := p.submit(t)
+	p.waitExtracted(t, taskID)
+	<-restored
+	p.requireInvariants(t, taskID)
+
+	// the ACK is either flushed on the reconnect or lost, the scrape being then
+	// redelivered; a redelivered scrape is acknowledged without scraping again
+	require.Equal(t, []models.TaskStatus{models.TaskStatusProcessing, models.TaskStatusDone},
+		p.stageStatuses(t, taskID, workers.StageScrape))
+}
+
+// No stage of the pipeline inserts chunks yet, Postgres is restarted between
+// the article and its keywords instead.
+func TestPipelinePostgresRestartedBetweenArticleAndKeywords(t *testing.T) {
+	p := newPipeline(t)
+	p.run(t, p.scraper(t))
+
+	keywords := p.keywords(t)
+	keywords.before = func(n int) {
+		if n == 1 {
+			p.pg.Cut()
+		}
+	}
+	keywords.after = func(n int, err error) {
+		if n == 1 {
+			p.pg.Restore()
+		}
+	}
+	p.run(t, keywords)
+
+	taskID := p.submit(t)
+	p.waitExtracted(t, taskID)
+	require.Equal(t, 1, p.pg.Cuts())
+	// the article is read from the cache, the keywords fail to be stored
+	require.Equal(t, 2, p.llm.Calls())
+	p.requireInvariants(t, taskID)
+}
+
+func TestPipelineValkeyUnavailableDuringKeywordRead(t *testing.T) {
+	p := newPipeline(t)
+	p.run(t, p.scraper(t))
+
+	keywords := p.keywords(t)
+	keywords.before = func(n int) {
+		if n == 1 {
+			p.valkey.Cut()
+		}
+	}
+	keywords.after = func(n int, err error) {
+		p.valkey.Restore()
+	}
+	p.run(t, keywords)
+
+	taskID := p.submit(t)
+	p.waitExtracted(t, taskID)
+	require.Equal(t, 1, p.valkey.Cuts())
+	p.requireInvariants(t, taskID)
+	p.requireRecovered(t, taskID, workers.StageExtractKeywords)
+}
+
+func TestPipelineLLMRateLimited(t *testing.T) {
+	p := newPipeline(t)
+	p.llm.Inject(e2etest.FailFirst(2, e2etest.ErrRateLimited))
+	p.run(t, p.scraper(t))
+	p.run(t, p.keywords(t))
+
+	taskID := p.submit(t)
+	p.waitExtracted(t, taskID)
+	require.Equal(t, 3, p.llm.Calls())
+	p.requireInvariants(t, taskID)
+	p.requireRecovered(t, taskID, workers.StageExtractKeywords)
+}
+
+func TestPipelineWorkerKilledMidHandle(t *testing.T) {
+	p := newPipeline(t)
+	p.run(t, p.scraper(t))
+
+	entered := make(chan struct{})
+	p.llm.Inject(e2etest.BlockFirst(entered))
+	kill := p.run(t, p.keywords(t))
+
+	taskID := p.submit(t)
+	select {
+	case <-entered:
+	case <-time.After(scenarioTimeout):
+		t.Fatal("keyword extractor never called the LLM")
+	}
+	kill()
+
+	p.run(t, p.keywords(t))
+	p.waitExtracted(t, taskID)
+	p.requireInvariants(t, taskID)
+	p.requireRecovered(t, taskID, workers.StageExtractKeywords)
+}
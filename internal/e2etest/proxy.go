@@ -0,0 +1,148 @@
+package e2etest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Proxy is a TCP proxy put between a client and a dependency (NATS, Postgres,
+// Valkey) to inject connection failures deterministically. While the proxy is
+// cut, the live connections are closed and the new ones are closed right after
+// they are accepted, so the client sees the dependency go down and come back.
+type Proxy struct {
+	target   string
+	listener net.Listener
+
+	mu    sync.Mutex
+	cut   bool
+	conns map[net.Conn]struct{}
+	cuts  int
+	wg    sync.WaitGroup
+}
+
+// NewProxy listens on a random local port and forwards the connections to
+// target, e.g. "localhost:4222".
+func NewProxy(target string) (*Proxy, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		target:   target,
+		listener: l,
+		conns:    map[net.Conn]struct{}{},
+	}
+
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the host:port the clients should connect to.
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Cut closes the live connections and refuses the new ones until Restore.
+func (p *Proxy) Cut() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.cut {
+		p.cuts++
+	}
+	p.cut = true
+	for c := range p.conns {
+		_ = c.Close()
+	}
+}
+
+// Restore accepts connections again.
+func (p *Proxy) Restore() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cut = false
+}
+
+// CutFor cuts the proxy and restores it after d. It returns a channel closed
+// on the restore.
+func (p *Proxy) CutFor(d time.Duration) <-chan struct{} {
+	p.Cut()
+	done := make(chan struct{})
+	time.AfterFunc(d, func() {
+		p.Restore()
+		close(done)
+	})
+	return done
+}
+
+// Cuts returns the number of times the proxy has been cut.
+func (p *Proxy) Cuts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cuts
+}
+
+// Close stops the proxy and closes every connection.
+func (p *Proxy) Close() error {
+	err := p.listener.Close()
+	p.mu.Lock()
+	for c := range p.conns {
+		_ = c.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) serve() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		p.mu.Lock()
+		if p.cut {
+			p.mu.Unlock()
+			_ = conn.Close()
+			continue
+		}
+		p.mu.Unlock()
+
+		upstream, err := net.DialTimeout("tcp", p.target, 5*time.Second)
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+
+		p.mu.Lock()
+		p.conns[conn] = struct{}{}
+		p.conns[upstream] = struct{}{}
+		p.mu.Unlock()
+
+		p.wg.Add(2)
+		go p.pipe(conn, upstream)
+		go p.pipe(upstream, conn)
+	}
+}
+
+// pipe copies src to dst until either side is closed, then closes both.
+func (p *Proxy) pipe(dst, src net.Conn) {
+	defer p.wg.Done()
+	_, _ = io.Copy(dst, src)
+	_ = dst.Close()
+	_ = src.Close()
+
+	p.mu.Lock()
+	delete(p.conns, dst)
+	delete(p.conns, src)
+	p.mu.Unlock()
+}
@@ -0,0 +1,68 @@
+package global
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ConfigMap converts cfg to the map LoadConfig decodes it from, keyed by the
+// mapstructure tags of the fields. Durations are written as strings, e.g.
+// "30s", and fields without a mapstructure tag are skipped.
+func ConfigMap(cfg any) map[string]any {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	return configStruct(v)
+}
+
+func configStruct(v reflect.Value) map[string]any {
+	m := map[string]any{}
+	for i := range v.NumField() {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("mapstructure"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		m[name] = configValue(v.Field(i))
+	}
+	return m
+}
+
+func configValue(v reflect.Value) any {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Struct:
+		return configStruct(v)
+	case v.Kind() == reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return configValue(v.Elem())
+	case v.Kind() == reflect.Slice:
+		s := make([]any, v.Len())
+		for i := range v.Len() {
+			s[i] = configValue(v.Index(i))
+		}
+		return s
+	default:
+		return v.Interface()
+	}
+}
+
+// WriteConfig writes cfg to w as an indented JSON configuration file.
+func WriteConfig(w io.Writer, cfg any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ConfigMap(cfg))
+}
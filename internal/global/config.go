@@ -1,116 +1,770 @@
 package global
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"time"
+
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/go-playground/validator/v10"
 )
 
+// Config is a configuration of a binary. Default returns the configuration
+// the file is decoded on top of, so that the file only needs to list the
+// fields it overrides.
+type Config[T any] interface {
+	Default() T
+	Validate() error
+}
+
+// LoadAndValidate loads the configuration file at path on top of the defaults
+// of T and validates it. The format of the file is derived from its extension.
+func LoadAndValidate[T Config[T]](path string) (T, error) {
+	var zero T
+	cfg := zero.Default()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return zero, ec.ErrInvalidConfig.Clone().
+			WithMessage("failed to open config file").
+			WithDetails(path).
+			Warp(err)
+	}
+	defer f.Close()
+
+	configType := strings.TrimPrefix(filepath.Ext(path), ".")
+	if err := LoadConfig(f, configType, &cfg); err != nil {
+		return zero, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return zero, err
+	}
+	return cfg, nil
+}
+
+// configValidator validates the configurations. Fields are named after their
+// mapstructure tags, i.e. the keys of the configuration file.
+var configValidator = newConfigValidator()
+
+func newConfigValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("mapstructure"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterStructValidation(validateLLMConfig, LLMConfig{})
+	return v
+}
+
+// validateConfig validates cfg and reports every invalid field at once.
+func validateConfig(cfg any) error {
+	err := configValidator.Struct(cfg)
+	if err == nil {
+		return nil
+	}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return ec.ErrInvalidConfig.Clone().Warp(err)
+	}
+
+	details := make([]string, len(verrs))
+	errs := make([]error, len(verrs))
+	for i, fe := range verrs {
+		details[i] = fieldErrorString(fe)
+		errs[i] = errors.New(details[i])
+	}
+	return ec.ErrInvalidConfig.Clone().
+		WithMessage(fmt.Sprintf("invalid %s", reflect.TypeOf(cfg).Name())).
+		WithDetails(details...).
+		Warp(errors.Join(errs...))
+}
+
+// fieldErrorString describes fe by the key of the field in the configuration
+// file. The value is left out, as it may be a secret.
+func fieldErrorString(fe validator.FieldError) string {
+	_, key, _ := strings.Cut(fe.Namespace(), ".")
+	if fe.Param() == "" {
+		return fmt.Sprintf("%s: failed on %q", key, fe.Tag())
+	}
+	return fmt.Sprintf("%s: failed on %q (%s)", key, fe.Tag(), fe.Param())
+}
+
 type ZeroLogConfig struct {
-	GlobalLevel      int8   `json:"global_level"`
-	Console          bool   `json:"console"`
-	LogFile          string `json:"log_file"`
-	IncludeTimestamp bool   `json:"include_timestamp"`
-	UseUnixTimestamp bool   `json:"use_unix_timestamp"`
+	GlobalLevel      int8   `json:"global_level"       validate:"min=-1,max=7" mapstructure:"global_level"`
+	Console          bool   `json:"console"                                    mapstructure:"console"`
+	LogFile          string `json:"log_file"                                   mapstructure:"log_file"`
+	IncludeTimestamp bool   `json:"include_timestamp"                          mapstructure:"include_timestamp"`
+	UseUnixTimestamp bool   `json:"use_unix_timestamp"                         mapstructure:"use_unix_timestamp"`
+}
+
+func (ZeroLogConfig) Default() ZeroLogConfig {
+	return ZeroLogConfig{
+		GlobalLevel:      1,
+		Console:          true,
+		IncludeTimestamp: true,
+	}
 }
 
 type OtelConfig struct {
-	ServiceName       string `json:"service_name"`
-	CollectorEndpoint string `json:"collector_endpoint"`
-	Insecure          bool   `json:"insecure"`
+	ServiceName       string `json:"service_name"       validate:"required" mapstructure:"service_name"`
+	CollectorEndpoint string `json:"collector_endpoint"                     mapstructure:"collector_endpoint"`
+	Insecure          bool   `json:"insecure"                               mapstructure:"insecure"`
+}
+
+func (OtelConfig) Default() OtelConfig {
+	return OtelConfig{
+		ServiceName:       "weathercock",
+		CollectorEndpoint: "localhost:4317",
+		Insecure:          true,
+	}
 }
 
 type ValkeyConfig struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	Password string `json:"password"`
-	DB       int    `json:"db"`
+	Host     string `json:"host"     validate:"required"                mapstructure:"host"`
+	Port     int    `json:"port"     validate:"required,min=1,max=65535" mapstructure:"port"`
+	Password string `json:"password"                                    mapstructure:"password"`
+	DB       int    `json:"db"       validate:"min=0,max=15"            mapstructure:"db"`
+}
+
+func (ValkeyConfig) Default() ValkeyConfig {
+	return ValkeyConfig{
+		Host: "localhost",
+		Port: 6379,
+	}
 }
 
 type WorkerConfig struct {
-	Timeout          time.Duration `json:"timeout"`
-	HealthCheckPort  int           `json:"health_check_port"`
-	HealthCheckHost  string        `json:"health_check_host"`
-	ShutdownWaitTime time.Duration `json:"shutdown_wait_time"`
+	Timeout          time.Duration `json:"timeout"            validate:"min=0"                    mapstructure:"timeout"`
+	HealthCheckPort  int           `json:"health_check_port"  validate:"required,min=1,max=65535" mapstructure:"health_check_port"`
+	HealthCheckHost  string        `json:"health_check_host"  validate:"required"                 mapstructure:"health_check_host"`
+	ShutdownWaitTime time.Duration `json:"shutdown_wait_time" validate:"min=0"                    mapstructure:"shutdown_wait_time"`
+}
+
+func (WorkerConfig) Default() WorkerConfig {
+	return WorkerConfig{
+		Timeout:          30 * time.Second,
+		HealthCheckPort:  8081,
+		HealthCheckHost:  "0.0.0.0",
+		ShutdownWaitTime: 10 * time.Second,
+	}
 }
 
 type OpenAIConfig struct {
-	APIKey  string        `json:"api_key"`
-	BaseURL string        `json:"base_url"`
-	Model   string        `json:"model"`
-	Timeout time.Duration `json:"timeout"`
+	APIKey     string        `json:"api_key"                                mapstructure:"api_key"`
+	APIKeyFile string        `json:"api_key_file"                           mapstructure:"api_key_file"`
+	BaseURL    string        `json:"base_url"      validate:"omitempty,url" mapstructure:"base_url"`
+	Proxy      string        `json:"proxy"                                  mapstructure:"proxy"`
+	Model      string        `json:"model"                                  mapstructure:"model"`
+	EmbedModel string        `json:"embed_model"                            mapstructure:"embed_model"`
+	EmbedDim   int           `json:"embed_dim"     validate:"min=0"         mapstructure:"embed_dim"`
+	Timeout    time.Duration `json:"timeout"       validate:"min=0"         mapstructure:"timeout"`
+	// Organization and Project are the organization and the project of the
+	// account the usage is billed to.
+	Organization string `json:"organization" mapstructure:"organization"`
+	Project      string `json:"project"      mapstructure:"project"`
 }
 
 type OllamaConfig struct {
-	BaseURL string        `json:"base_url"`
-	Model   string        `json:"model"`
-	Timeout time.Duration `json:"timeout"`
+	BaseURL          string        `json:"base_url"          validate:"omitempty,url" mapstructure:"base_url"`
+	Proxy            string        `json:"proxy"                                      mapstructure:"proxy"`
+	Model            string        `json:"model"                                      mapstructure:"model"`
+	EmbedModel       string        `json:"embed_model"                                mapstructure:"embed_model"`
+	Timeout          time.Duration `json:"timeout"           validate:"min=0"         mapstructure:"timeout"`
+	EmbedParallelism int           `json:"embed_parallelism" validate:"min=0"         mapstructure:"embed_parallelism"`
+	KeepAlive        time.Duration `json:"keep_alive"                                 mapstructure:"keep_alive"`
+	AutoPull         bool          `json:"auto_pull"                                  mapstructure:"auto_pull"`
+	BearerToken      string        `json:"bearer_token"                               mapstructure:"bearer_token"`
+	BearerTokenFile  string        `json:"bearer_token_file"                          mapstructure:"bearer_token_file"`
+}
+
+// ReadBearerToken returns the token authenticating the requests to a server
+// behind a reverse proxy, read from BearerTokenFile if it is set.
+func (c OllamaConfig) ReadBearerToken() (string, error) {
+	return readSecret(c.BearerToken, c.BearerTokenFile)
 }
 
 type GeminiConfig struct {
-	APIKey  string        `json:"api_key"`
-	BaseURL string        `json:"base_url"`
-	Model   string        `json:"model"`
-	Timeout time.Duration `json:"timeout"`
+	APIKey     string        `json:"api_key"                                mapstructure:"api_key"`
+	APIKeyFile string        `json:"api_key_file"                           mapstructure:"api_key_file"`
+	BaseURL    string        `json:"base_url"      validate:"omitempty,url" mapstructure:"base_url"`
+	Proxy      string        `json:"proxy"                                  mapstructure:"proxy"`
+	Model      string        `json:"model"                                  mapstructure:"model"`
+	EmbedModel string        `json:"embed_model"                            mapstructure:"embed_model"`
+	Timeout    time.Duration `json:"timeout"       validate:"min=0"         mapstructure:"timeout"`
+	// VertexProject and VertexLocation select the Vertex AI backend instead of
+	// the API key, authenticated with the service account of CredentialsFile
+	// or the application default credentials.
+	VertexProject   string `json:"vertex_project"   mapstructure:"vertex_project"`
+	VertexLocation  string `json:"vertex_location"  mapstructure:"vertex_location"`
+	CredentialsFile string `json:"credentials_file" mapstructure:"credentials_file"`
 }
 
+type AnthropicConfig struct {
+	APIKey     string        `json:"api_key"                                mapstructure:"api_key"`
+	APIKeyFile string        `json:"api_key_file"                           mapstructure:"api_key_file"`
+	BaseURL    string        `json:"base_url"      validate:"omitempty,url" mapstructure:"base_url"`
+	Proxy      string        `json:"proxy"                                  mapstructure:"proxy"`
+	Model      string        `json:"model"                                  mapstructure:"model"`
+	Timeout    time.Duration `json:"timeout"       validate:"min=0"         mapstructure:"timeout"`
+	// MaxTokens is the max_tokens of the requests which set none, required by
+	// the messages API.
+	MaxTokens int `json:"max_tokens" validate:"min=0" mapstructure:"max_tokens"`
+}
+
+// ReadAPIKey returns the API key, read from APIKeyFile if it is set.
+func (c OpenAIConfig) ReadAPIKey() (string, error) {
+	return readSecret(c.APIKey, c.APIKeyFile)
+}
+
+// ReadAPIKey returns the API key, read from APIKeyFile if it is set.
+func (c GeminiConfig) ReadAPIKey() (string, error) {
+	return readSecret(c.APIKey, c.APIKeyFile)
+}
+
+// ReadAPIKey returns the API key, read from APIKeyFile if it is set.
+func (c AnthropicConfig) ReadAPIKey() (string, error) {
+	return readSecret(c.APIKey, c.APIKeyFile)
+}
+
+// readSecret returns the content of file without the surrounding spaces, or
+// value if file is empty.
+func readSecret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file %s: %w", file, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// LLMConfig selects the LLM provider by Provider. Only the configuration of
+// the selected provider is required.
 type LLMConfig struct {
-	Provider string       `json:"provider"`
-	OpenAI   OpenAIConfig `json:"openai"`
-	Ollama   OllamaConfig `json:"ollama"`
-	Gemini   GeminiConfig `json:"gemini"`
+	Provider  string          `json:"provider"  validate:"required,oneof=openai ollama gemini anthropic" mapstructure:"provider"`
+	OpenAI    OpenAIConfig    `json:"openai"                                                            mapstructure:"openai"`
+	Ollama    OllamaConfig    `json:"ollama"                                                            mapstructure:"ollama"`
+	Gemini    GeminiConfig    `json:"gemini"                                                            mapstructure:"gemini"`
+	Anthropic AnthropicConfig `json:"anthropic"                                                         mapstructure:"anthropic"`
+	Embed     EmbedConfig     `json:"embed"                                                             mapstructure:"embed"`
+}
+
+// EmbedConfig configures how the inputs over the per-input token limit of the
+// embedding model are split. A zero MaxInputTokens uses the limit of the model.
+type EmbedConfig struct {
+	MaxInputTokens int    `json:"max_input_tokens" validate:"min=0"             mapstructure:"max_input_tokens"`
+	Pooling        string `json:"pooling"          validate:"oneof=mean max"    mapstructure:"pooling"`
+	MaxSplits      int    `json:"max_splits"       validate:"min=1"             mapstructure:"max_splits"`
+}
+
+// Default leaves the model empty, a model has to be chosen explicitly.
+func (LLMConfig) Default() LLMConfig {
+	return LLMConfig{
+		Provider: "ollama",
+		OpenAI: OpenAIConfig{
+			BaseURL: "https://api.openai.com/v1",
+			Timeout: 60 * time.Second,
+		},
+		Ollama: OllamaConfig{
+			BaseURL:    "http://localhost:11434",
+			EmbedModel: "bge-m3",
+			Timeout:    60 * time.Second,
+		},
+		Gemini: GeminiConfig{
+			Timeout: 60 * time.Second,
+		},
+		Anthropic: AnthropicConfig{
+			BaseURL: "https://api.anthropic.com",
+			Timeout: 60 * time.Second,
+		},
+		Embed: EmbedConfig{
+			Pooling:   "mean",
+			MaxSplits: 8,
+		},
+	}
+}
+
+// validateLLMConfig requires the model, and the API key or its file if the
+// provider needs one, of the selected provider.
+func validateLLMConfig(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(LLMConfig)
+	switch cfg.Provider {
+	case "openai":
+		if cfg.OpenAI.Model == "" {
+			sl.ReportError(cfg.OpenAI.Model, "openai.model", "Model", "required", "")
+		}
+		if cfg.OpenAI.APIKey == "" && cfg.OpenAI.APIKeyFile == "" {
+			sl.ReportError(cfg.OpenAI.APIKey, "openai.api_key", "APIKey", "required", "")
+		}
+	case "ollama":
+		if cfg.Ollama.Model == "" {
+			sl.ReportError(cfg.Ollama.Model, "ollama.model", "Model", "required", "")
+		}
+	case "gemini":
+		if cfg.Gemini.Model == "" {
+			sl.ReportError(cfg.Gemini.Model, "gemini.model", "Model", "required", "")
+		}
+		if cfg.Gemini.APIKey == "" && cfg.Gemini.APIKeyFile == "" {
+			sl.ReportError(cfg.Gemini.APIKey, "gemini.api_key", "APIKey", "required", "")
+		}
+	case "anthropic":
+		if cfg.Anthropic.Model == "" {
+			sl.ReportError(cfg.Anthropic.Model, "anthropic.model", "Model", "required", "")
+		}
+		if cfg.Anthropic.APIKey == "" && cfg.Anthropic.APIKeyFile == "" {
+			sl.ReportError(cfg.Anthropic.APIKey, "anthropic.api_key", "APIKey", "required", "")
+		}
+	}
 }
 
 type APIConfig struct {
-	Name            string         `json:"name"`
-	Host            string         `json:"host"`
-	Port            int            `json:"port"`
-	ShutdownTimeout time.Duration  `json:"shutdown_timeout"`
-	Logger          ZeroLogConfig  `json:"logger"`
-	Postgres        PostgresConfig `json:"postgres"`
-	NATS            NATSConfig     `json:"nats"`
-	Valkey          ValkeyConfig   `json:"valkey"`
-	Template        TemplateConfig `json:"template"`
-	LLM             LLMConfig      `json:"llm"`
-	Otel            OtelConfig     `json:"otel"`
+	Name            string            `json:"name"             validate:"required"                 mapstructure:"name"`
+	Host            string            `json:"host"             validate:"required"                 mapstructure:"host"`
+	Port            int               `json:"port"             validate:"required,min=1,max=65535" mapstructure:"port"`
+	ShutdownTimeout time.Duration     `json:"shutdown_timeout" validate:"min=0"                    mapstructure:"shutdown_timeout"`
+	Logger          ZeroLogConfig     `json:"logger"                                               mapstructure:"logger"`
+	Postgres        PostgresConfig    `json:"postgres"                                             mapstructure:"postgres"`
+	NATS            NATSConfig        `json:"nats"                                                 mapstructure:"nats"`
+	Valkey          ValkeyConfig      `json:"valkey"                                               mapstructure:"valkey"`
+	Template        TemplateConfig    `json:"template"                                             mapstructure:"template"`
+	LLM             LLMConfig         `json:"llm"                                                  mapstructure:"llm"`
+	Otel            OtelConfig        `json:"otel"                                                 mapstructure:"otel"`
+	EditorToken     string            `json:"editor_token"                                         mapstructure:"editor_token"`
+	Scoring         ScoringConfig     `json:"scoring"                                              mapstructure:"scoring"`
+	Intake          IntakeConfig      `json:"intake"                                               mapstructure:"intake"`
+	VectorIndex     VectorIndexConfig `json:"vector_index"                                         mapstructure:"vector_index"`
+}
+
+func (APIConfig) Default() APIConfig {
+	return APIConfig{
+		Name:            "api",
+		Host:            "0.0.0.0",
+		Port:            8080,
+		ShutdownTimeout: 10 * time.Second,
+		Logger:          ZeroLogConfig{}.Default(),
+		Postgres:        PostgresConfig{}.Default(),
+		NATS:            NATSConfig{}.Default(),
+		Valkey:          ValkeyConfig{}.Default(),
+		Template:        TemplateConfig{}.Default(),
+		LLM:             LLMConfig{}.Default(),
+		Otel:            OtelConfig{}.Default(),
+		Scoring:         ScoringConfig{}.Default(),
+		Intake:          IntakeConfig{}.Default(),
+		VectorIndex:     VectorIndexConfig{}.Default(),
+	}
+}
+
+func (c APIConfig) Validate() error {
+	return validateConfig(c)
+}
+
+// ScoringConfig is the default scoring of the top articles, see
+// storage.ArticleScoring. A request may override TauHours and the weights.
+type ScoringConfig struct {
+	TauHours            float64 `json:"tau_hours"             validate:"gt=0,max=8760" mapstructure:"tau_hours"`
+	Recency             float64 `json:"recency"               validate:"min=0,max=1"   mapstructure:"recency"`
+	Source              float64 `json:"source"                validate:"min=0,max=1"   mapstructure:"source"`
+	Relevance           float64 `json:"relevance"             validate:"min=0,max=1"   mapstructure:"relevance"`
+	DefaultSourceWeight float64 `json:"default_source_weight" validate:"min=0,max=1"   mapstructure:"default_source_weight"`
+}
+
+func (ScoringConfig) Default() ScoringConfig {
+	return ScoringConfig{
+		TauHours:            24,
+		Recency:             0.5,
+		Source:              0.2,
+		Relevance:           0.3,
+		DefaultSourceWeight: 0.5,
+	}
+}
+
+// IntakeConfig configures the journal of the task submissions, see
+// intake.Journal. The bodies of at most MaxBodySize bytes are appended to
+// segment files of about SegmentSize bytes in Dir, which are removed once
+// their last record is older than Retention. Fsync is one of always, interval
+// (every FsyncInterval) or never. The entries not consumed within StaleAfter
+// are reported at startup.
+type IntakeConfig struct {
+	Enabled       bool          `json:"enabled"                                               mapstructure:"enabled"`
+	Dir           string        `json:"dir"            validate:"required"                    mapstructure:"dir"`
+	MaxBodySize   int64         `json:"max_body_size"  validate:"min=1"                       mapstructure:"max_body_size"`
+	SegmentSize   int64         `json:"segment_size"   validate:"min=1024"                    mapstructure:"segment_size"`
+	Retention     time.Duration `json:"retention"      validate:"min=1h"                      mapstructure:"retention"`
+	Fsync         string        `json:"fsync"          validate:"oneof=always interval never" mapstructure:"fsync"`
+	FsyncInterval time.Duration `json:"fsync_interval" validate:"min=1ms"                     mapstructure:"fsync_interval"`
+	StaleAfter    time.Duration `json:"stale_after"    validate:"min=1m"                      mapstructure:"stale_after"`
+}
+
+func (IntakeConfig) Default() IntakeConfig {
+	return IntakeConfig{
+		Enabled:       true,
+		Dir:           "./data/intake",
+		MaxBodySize:   1 << 20,
+		SegmentSize:   64 << 20,
+		Retention:     7 * 24 * time.Hour,
+		Fsync:         "interval",
+		FsyncInterval: time.Second,
+		StaleAfter:    time.Hour,
+	}
+}
+
+// VectorIndexConfig configures the maintenance of the vector indexes, see
+// storage.VectorIndexMaintenance. The recall of an index is estimated on
+// SampleSize vectors drawn from its table, as the share of the exact K nearest
+// neighbours of each the index finds: a neighbour found within Tolerance of the
+// distance of the K-th exact one counts, so that the ties are not missed. An
+// index with a recall below MinRecall is reported degraded. A rebuild is
+// refused while a replica lags by more than MaxReplicationLag or a transaction
+// has been open for more than MaxTransactionAge, 0 for no limit. Its progress
+// is polled every ProgressInterval and the swap of the index names waits at
+// most LockTimeout for its lock.
+type VectorIndexConfig struct {
+	SampleSize        int           `json:"sample_size"         validate:"min=1,max=1000" mapstructure:"sample_size"`
+	K                 int           `json:"k"                   validate:"min=1,max=100"  mapstructure:"k"`
+	Tolerance         float64       `json:"tolerance"           validate:"min=0"          mapstructure:"tolerance"`
+	MinRecall         float64       `json:"min_recall"          validate:"min=0,max=1"    mapstructure:"min_recall"`
+	MaxReplicationLag time.Duration `json:"max_replication_lag" validate:"min=0"          mapstructure:"max_replication_lag"`
+	MaxTransactionAge time.Duration `json:"max_transaction_age" validate:"min=0"          mapstructure:"max_transaction_age"`
+	ProgressInterval  time.Duration `json:"progress_interval"   validate:"min=10ms"       mapstructure:"progress_interval"`
+	LockTimeout       time.Duration `json:"lock_timeout"        validate:"min=1ms"        mapstructure:"lock_timeout"`
+}
+
+func (VectorIndexConfig) Default() VectorIndexConfig {
+	return VectorIndexConfig{
+		SampleSize:        100,
+		K:                 10,
+		Tolerance:         1e-6,
+		MinRecall:         0.9,
+		MaxReplicationLag: 30 * time.Second,
+		MaxTransactionAge: 10 * time.Minute,
+		ProgressInterval:  2 * time.Second,
+		LockTimeout:       5 * time.Second,
+	}
+}
+
+func (c VectorIndexConfig) Validate() error {
+	return validateConfig(c)
 }
 
 type MigrateConfig struct {
-	Name       string         `json:"name"`
-	Postgres   PostgresConfig `json:"postgres"`
-	Migrations string         `json:"migrations"`
+	Name       string         `json:"name"       validate:"required" mapstructure:"name"`
+	Postgres   PostgresConfig `json:"postgres"                       mapstructure:"postgres"`
+	Migrations string         `json:"migrations" validate:"required" mapstructure:"migrations"`
 }
 
+func (MigrateConfig) Default() MigrateConfig {
+	// migrate brings the schema up to date, it cannot require it to be
+	pg := PostgresConfig{}.Default()
+	pg.SchemaCheck = SchemaCheckOff
+
+	return MigrateConfig{
+		Name:       "migrate",
+		Postgres:   pg,
+		Migrations: "./migrations",
+	}
+}
+
+func (c MigrateConfig) Validate() error {
+	return validateConfig(c)
+}
+
+// PartyPressReleaseScraperConfig configures the party press release scraper.
+// Parallelism is the number of press releases fetched at once, one less than
+// the number of CPUs if zero. Sessions are the sessions of the sites putting
+// their press releases behind one, by the party of the site: kmt, dpp or tpp.
 type PartyPressReleaseScraperConfig struct {
-	Name     string         `json:"name"`
-	Logger   ZeroLogConfig  `json:"logger"`
-	Otel     OtelConfig     `json:"otel"`
-	Postgres PostgresConfig `json:"postgres"`
+	Name        string                   `json:"name"        validate:"required" mapstructure:"name"`
+	Logger      ZeroLogConfig            `json:"logger"                          mapstructure:"logger"`
+	Otel        OtelConfig               `json:"otel"                            mapstructure:"otel"`
+	Postgres    PostgresConfig           `json:"postgres"                        mapstructure:"postgres"`
+	Parallelism int                      `json:"parallelism" validate:"min=0"    mapstructure:"parallelism"`
+	Sessions    map[string]SessionConfig `json:"sessions"    validate:"dive"     mapstructure:"sessions"`
+}
+
+// SessionConfig configures the session of a site. A warmup session visits
+// LandingURL and keeps the cookies it sets. A form session posts the username
+// and the password read from CredentialsFile, a JSON file holding them, as the
+// UsernameField and PasswordField of the form at LoginURL, visiting LandingURL
+// first if set. The credentials are never logged.
+//
+// The session is expired when a site answers with a 401, redirects to a page
+// under LoginPath, or serves a page matching LoginSelector or containing
+// LoginMarker.
+type SessionConfig struct {
+	Kind            string            `json:"kind"             validate:"oneof=warmup form"                     mapstructure:"kind"`
+	LandingURL      string            `json:"landing_url"      validate:"required_if=Kind warmup,omitempty,url" mapstructure:"landing_url"`
+	LoginURL        string            `json:"login_url"        validate:"required_if=Kind form,omitempty,url"   mapstructure:"login_url"`
+	UsernameField   string            `json:"username_field"                                                    mapstructure:"username_field"`
+	PasswordField   string            `json:"password_field"                                                    mapstructure:"password_field"`
+	CredentialsFile string            `json:"credentials_file" validate:"required_if=Kind form"                 mapstructure:"credentials_file"`
+	Headers         map[string]string `json:"headers"                                                           mapstructure:"headers"`
+	LoginPath       string            `json:"login_path"                                                        mapstructure:"login_path"`
+	LoginSelector   string            `json:"login_selector"                                                    mapstructure:"login_selector"`
+	LoginMarker     string            `json:"login_marker"                                                      mapstructure:"login_marker"`
 }
 
-type ScraperConfig struct {
-	Name     string         `json:"name"`
-	Logger   ZeroLogConfig  `json:"logger"`
-	Postgres PostgresConfig `json:"postgres"`
-	Otel     OtelConfig     `json:"otel"`
-	NATS     NATSConfig     `json:"nats"`
-	Valkey   ValkeyConfig   `json:"valkey"`
-	Worker   WorkerConfig   `json:"worker"`
+func (PartyPressReleaseScraperConfig) Default() PartyPressReleaseScraperConfig {
+	return PartyPressReleaseScraperConfig{
+		Name:     "party_press_release_scraper",
+		Logger:   ZeroLogConfig{}.Default(),
+		Otel:     OtelConfig{}.Default(),
+		Postgres: PostgresConfig{}.Default(),
+	}
+}
+
+func (c PartyPressReleaseScraperConfig) Validate() error {
+	return validateConfig(c)
+}
+
+// FixturesConfig serves the recorded pages of scrapers/fixtures instead of the
+// live sites, for local development. Addr is where the fixture server listens,
+// a random local port if empty. ErrorRate is the fraction of the requests
+// answered with a 503 and Latency delays every response.
+type FixturesConfig struct {
+	Enabled   bool          `json:"enabled"    mapstructure:"enabled"`
+	Addr      string        `json:"addr"       validate:"omitempty,hostname_port" mapstructure:"addr"`
+	ErrorRate float64       `json:"error_rate" validate:"min=0,max=1"             mapstructure:"error_rate"`
+	Latency   time.Duration `json:"latency"    validate:"min=0"                   mapstructure:"latency"`
+}
+
+type ScraperWorkerConfig struct {
+	Name     string         `json:"name"     validate:"required" mapstructure:"name"`
+	Logger   ZeroLogConfig  `json:"logger"                       mapstructure:"logger"`
+	Postgres PostgresConfig `json:"postgres"                     mapstructure:"postgres"`
+	Otel     OtelConfig     `json:"otel"                         mapstructure:"otel"`
+	NATS     NATSConfig     `json:"nats"                         mapstructure:"nats"`
+	Valkey   ValkeyConfig   `json:"valkey"                       mapstructure:"valkey"`
+	Worker   WorkerConfig   `json:"worker"                       mapstructure:"worker"`
+	Fixtures FixturesConfig `json:"fixtures"                     mapstructure:"fixtures"`
+}
+
+func (ScraperWorkerConfig) Default() ScraperWorkerConfig {
+	return ScraperWorkerConfig{
+		Name:     "scraper",
+		Logger:   ZeroLogConfig{}.Default(),
+		Postgres: PostgresConfig{}.Default(),
+		Otel:     OtelConfig{}.Default(),
+		NATS:     NATSConfig{}.Default(),
+		Valkey:   ValkeyConfig{}.Default(),
+		Worker:   WorkerConfig{}.Default(),
+	}
+}
+
+func (c ScraperWorkerConfig) Validate() error {
+	return validateConfig(c)
 }
 
 type KeywordExtractorConfig struct {
-	Name     string         `json:"name"`
-	Logger   ZeroLogConfig  `json:"logger"`
-	Otel     OtelConfig     `json:"otel"`
-	Nats     NATSConfig     `json:"nats"`
-	Postgres PostgresConfig `json:"postgres"`
-	Valkey   ValkeyConfig   `json:"valkey"`
-	Worker   WorkerConfig   `json:"worker"`
-	LLM      LLMConfig      `json:"llm"`
+	Name     string         `json:"name"     validate:"required" mapstructure:"name"`
+	Logger   ZeroLogConfig  `json:"logger"                       mapstructure:"logger"`
+	Otel     OtelConfig     `json:"otel"                         mapstructure:"otel"`
+	Nats     NATSConfig     `json:"nats"                         mapstructure:"nats"`
+	Postgres PostgresConfig `json:"postgres"                     mapstructure:"postgres"`
+	Valkey   ValkeyConfig   `json:"valkey"                       mapstructure:"valkey"`
+	Worker   WorkerConfig   `json:"worker"                       mapstructure:"worker"`
+	LLM      LLMConfig      `json:"llm"                          mapstructure:"llm"`
+}
+
+func (KeywordExtractorConfig) Default() KeywordExtractorConfig {
+	return KeywordExtractorConfig{
+		Name:     "keyword_extractor",
+		Logger:   ZeroLogConfig{}.Default(),
+		Otel:     OtelConfig{}.Default(),
+		Nats:     NATSConfig{}.Default(),
+		Postgres: PostgresConfig{}.Default(),
+		Valkey:   ValkeyConfig{}.Default(),
+		Worker:   WorkerConfig{}.Default(),
+		LLM:      LLMConfig{}.Default(),
+	}
+}
+
+func (c KeywordExtractorConfig) Validate() error {
+	return validateConfig(c)
+}
+
+// SchedulerConfig configures the scheduler, which publishes the periodic
+// tasks every Interval.
+type SchedulerConfig struct {
+	Name      string          `json:"name"     validate:"required"        mapstructure:"name"`
+	Interval  time.Duration   `json:"interval" validate:"required,min=1m" mapstructure:"interval"`
+	Logger    ZeroLogConfig   `json:"logger"                              mapstructure:"logger"`
+	Otel      OtelConfig      `json:"otel"                                mapstructure:"otel"`
+	NATS      NATSConfig      `json:"nats"                                mapstructure:"nats"`
+	Postgres  PostgresConfig  `json:"postgres"                            mapstructure:"postgres"`
+	Worker    WorkerConfig    `json:"worker"                              mapstructure:"worker"`
+	Anomaly   AnomalyConfig   `json:"anomaly"                             mapstructure:"anomaly"`
+	Discovery DiscoveryConfig `json:"discovery"                           mapstructure:"discovery"`
+	Reconcile ReconcileConfig `json:"reconcile"                           mapstructure:"reconcile"`
+	Freshness FreshnessConfig `json:"freshness"                           mapstructure:"freshness"`
+	Valkey    ValkeyConfig    `json:"valkey"                              mapstructure:"valkey"`
+}
+
+func (SchedulerConfig) Default() SchedulerConfig {
+	return SchedulerConfig{
+		Name:      "scheduler",
+		Interval:  time.Hour,
+		Logger:    ZeroLogConfig{}.Default(),
+		Otel:      OtelConfig{}.Default(),
+		NATS:      NATSConfig{}.Default(),
+		Postgres:  PostgresConfig{}.Default(),
+		Worker:    WorkerConfig{}.Default(),
+		Anomaly:   AnomalyConfig{}.Default(),
+		Discovery: DiscoveryConfig{}.Default(),
+		Reconcile: ReconcileConfig{}.Default(),
+		Freshness: FreshnessConfig{}.Default(),
+		Valkey:    ValkeyConfig{}.Default(),
+	}
+}
+
+func (c SchedulerConfig) Validate() error {
+	return validateConfig(c)
+}
+
+// AnomalyConfig configures the keyword anomaly detection. A keyword is flagged
+// on a day its mentions reach MinCount and exceed the mean of the Window days
+// before by more than Threshold standard deviations. The keywords with fewer
+// than MinBaselineCount mentions over the window are not analysed. Each pass
+// evaluates the last LookbackDays complete days. The anomalies are delivered
+// to WebhookURL, signed with WebhookSecret, if set.
+type AnomalyConfig struct {
+	Window           int     `json:"window"             validate:"min=7,max=365"   mapstructure:"window"`
+	Threshold        float64 `json:"threshold"          validate:"gt=0"            mapstructure:"threshold"`
+	MinCount         int     `json:"min_count"          validate:"min=1"           mapstructure:"min_count"`
+	MinBaselineCount int     `json:"min_baseline_count" validate:"min=1"           mapstructure:"min_baseline_count"`
+	LookbackDays     int     `json:"lookback_days"      validate:"min=1,max=30"    mapstructure:"lookback_days"`
+	WebhookURL       string  `json:"webhook_url"        validate:"omitempty,url"   mapstructure:"webhook_url"`
+	WebhookSecret    string  `json:"webhook_secret"                                mapstructure:"webhook_secret"`
+}
+
+func (AnomalyConfig) Default() AnomalyConfig {
+	return AnomalyConfig{
+		Window:           28,
+		Threshold:        3,
+		MinCount:         5,
+		MinBaselineCount: 5,
+		LookbackDays:     3,
+	}
+}
+
+// DiscoveryConfig configures the discovery of the fresh Yahoo news. Every
+// Interval the Feeds, news sitemaps or RSS feeds, are fetched and a task is
+// created for each new article about one of the Allowlist terms, all of them
+// if it is empty. The articles published more than MaxAge ago are skipped, the
+// ones published within FreshWindow are scraped first. A pass creates at most
+// MaxPerRun tasks and an hour at most MaxPerHour, 0 for no hourly budget. The
+// URLs seen are remembered for SeenTTL.
+type DiscoveryConfig struct {
+	Enabled     bool          `json:"enabled"                                                  mapstructure:"enabled"`
+	Interval    time.Duration `json:"interval"      validate:"min=1m"                          mapstructure:"interval"`
+	Feeds       []string      `json:"feeds"         validate:"dive,url" mapstructure:"feeds"`
+	Allowlist   []string      `json:"allowlist"                                                mapstructure:"allowlist"`
+	MaxPerRun   int           `json:"max_per_run"   validate:"min=1"                           mapstructure:"max_per_run"`
+	MaxPerHour  int           `json:"max_per_hour"  validate:"min=0"                           mapstructure:"max_per_hour"`
+	MaxAge      time.Duration `json:"max_age"       validate:"min=1h"                          mapstructure:"max_age"`
+	FreshWindow time.Duration `json:"fresh_window"  validate:"min=0"                           mapstructure:"fresh_window"`
+	SeenTTL     time.Duration `json:"seen_ttl"      validate:"min=1h"                          mapstructure:"seen_ttl"`
+}
+
+func (DiscoveryConfig) Default() DiscoveryConfig {
+	return DiscoveryConfig{
+		Interval:    5 * time.Minute,
+		Feeds:       []string{"https://tw.news.yahoo.com/rss/politics"},
+		MaxPerRun:   50,
+		MaxPerHour:  300,
+		MaxAge:      48 * time.Hour,
+		FreshWindow: 2 * time.Hour,
+		SeenTTL:     7 * 24 * time.Hour,
+	}
+}
+
+// ReconcileConfig configures the reconciliation of the task artifacts cached
+// in Valkey with Postgres. Every Interval the tasks done within the last
+// Window are paged through BatchSize at a time, and SampleRate of them are
+// checked, at most MaxPerRun a pass: the passes resume where the last one
+// stopped. The job makes at most ValkeyRate and PostgresRate requests a
+// second, 0 for no limit. The missing cache entries are rehydrated for
+// CacheTTL.
+type ReconcileConfig struct {
+	Enabled      bool          `json:"enabled"                                   mapstructure:"enabled"`
+	Interval     time.Duration `json:"interval"      validate:"min=1m"           mapstructure:"interval"`
+	Window       time.Duration `json:"window"        validate:"min=1h"           mapstructure:"window"`
+	SampleRate   float64       `json:"sample_rate"   validate:"gt=0,lte=1"       mapstructure:"sample_rate"`
+	BatchSize    int           `json:"batch_size"    validate:"min=1,max=1000"   mapstructure:"batch_size"`
+	MaxPerRun    int           `json:"max_per_run"   validate:"min=1"            mapstructure:"max_per_run"`
+	ValkeyRate   float64       `json:"valkey_rate"   validate:"min=0"            mapstructure:"valkey_rate"`
+	PostgresRate float64       `json:"postgres_rate" validate:"min=0"            mapstructure:"postgres_rate"`
+	CacheTTL     time.Duration `json:"cache_ttl"     validate:"min=1m"           mapstructure:"cache_ttl"`
+}
+
+func (ReconcileConfig) Default() ReconcileConfig {
+	return ReconcileConfig{
+		Interval:     time.Hour,
+		Window:       3 * 24 * time.Hour,
+		SampleRate:   0.1,
+		BatchSize:    100,
+		MaxPerRun:    1000,
+		ValkeyRate:   200,
+		PostgresRate: 50,
+		CacheTTL:     3 * time.Hour,
+	}
+}
+
+// FreshnessConfig configures the re-check of the scraped articles for the
+// updates of their publisher. Every Interval the articles published within the
+// last Window and not checked for RecheckAfter are fetched again, at most
+// ChecksPerRun a pass and Parallelism at once. An updated article is
+// re-processed at most MaxReprocessPerDay times a day, its later updates wait
+// for the next day.
+type FreshnessConfig struct {
+	Enabled            bool          `json:"enabled"                                          mapstructure:"enabled"`
+	Interval           time.Duration `json:"interval"              validate:"min=1m"          mapstructure:"interval"`
+	Window             time.Duration `json:"window"                validate:"min=1h"          mapstructure:"window"`
+	RecheckAfter       time.Duration `json:"recheck_after"         validate:"min=1m"          mapstructure:"recheck_after"`
+	ChecksPerRun       int           `json:"checks_per_run"        validate:"min=1"           mapstructure:"checks_per_run"`
+	Parallelism        int           `json:"parallelism"           validate:"min=1,max=64"    mapstructure:"parallelism"`
+	MaxReprocessPerDay int           `json:"max_reprocess_per_day" validate:"min=1"           mapstructure:"max_reprocess_per_day"`
+}
+
+func (FreshnessConfig) Default() FreshnessConfig {
+	return FreshnessConfig{
+		Interval:           30 * time.Minute,
+		Window:             48 * time.Hour,
+		RecheckAfter:       2 * time.Hour,
+		ChecksPerRun:       500,
+		Parallelism:        4,
+		MaxReprocessPerDay: 3,
+	}
 }
 
 type LoggerConfig struct {
-	Name   string        `json:"name"`
-	Logger ZeroLogConfig `json:"logger"`
-	Otel   OtelConfig    `json:"otel"`
-	Nats   NATSConfig    `json:"nats"`
-	Worker WorkerConfig  `json:"worker"`
+	Name   string        `json:"name"   validate:"required" mapstructure:"name"`
+	Logger ZeroLogConfig `json:"logger"                     mapstructure:"logger"`
+	Otel   OtelConfig    `json:"otel"                       mapstructure:"otel"`
+	Nats   NATSConfig    `json:"nats"                       mapstructure:"nats"`
+	Worker WorkerConfig  `json:"worker"                     mapstructure:"worker"`
+}
+
+func (LoggerConfig) Default() LoggerConfig {
+	return LoggerConfig{
+		Name:   "logger",
+		Logger: ZeroLogConfig{}.Default(),
+		Otel:   OtelConfig{}.Default(),
+		Nats:   NATSConfig{}.Default(),
+		Worker: WorkerConfig{}.Default(),
+	}
+}
+
+func (c LoggerConfig) Validate() error {
+	return validateConfig(c)
 }
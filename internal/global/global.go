@@ -43,40 +43,35 @@ func Mode() string {
 	return utils.DefaultIfZero(mode, "dev")
 }
 
-// InitPostgres initializes the Postgres connection pool and returns it.
-func InitPostgres(ctx context.Context, cfg PostgresConfig) (*pgxpool.Pool, error) {
+// InitPostgres initializes the Postgres connection pools and returns them. The
+// read pool is nil unless a read replica is configured, in which case the
+// storage falls back to the write pool. The migration version of the database
+// is checked against ExpectedSchemaVersion according to cfg.SchemaCheck.
+func InitPostgres(ctx context.Context, cfg PostgresConfig) (write, read *pgxpool.Pool, err error) {
 	pCtx, pCancel := context.WithTimeout(ctx, 30*time.Second)
 	defer pCancel()
 
 	if err := cfg.Validate(); err != nil {
-		return nil, ec.ErrInvalidConfig.Clone().Warp(err).
+		return nil, nil, ec.ErrInvalidConfig.Clone().Warp(err).
 			WithMessage("invalid postgres config")
 	}
 
-	p, err := cfg.Pool(pCtx)
+	write, err = cfg.Pool(pCtx)
 	if err != nil {
-		return nil, ec.ErrDBError.Clone().
+		return nil, nil, ec.ErrDBError.Clone().
 			WithMessage("failed to create Postgres connection pool").
 			Warp(err)
 	}
 
-	if p == nil {
-		return nil, ec.ErrDBError.Clone().
+	if write == nil {
+		return nil, nil, ec.ErrDBError.Clone().
 			WithMessage("failed to create Postgres connection pool").
 			WithDetails("postgres connection pool is nil")
 	}
 
-	for retry := 0; p.Ping(pCtx) != nil && retry < 5; retry++ {
-		wt := 5 * (1 << retry) * time.Second
-		Logger.Warn().
-			Int("retry", retry).
-			Dur("wait_time", wt).
-			Msg("Waiting for Postgres connection...")
-		time.Sleep(wt)
-	}
-
-	if err := p.Ping(pCtx); err != nil {
-		return nil, fmt.Errorf("failed to ping to Postgres: %w", err)
+	if err := pingPostgres(pCtx, write); err != nil {
+		write.Close()
+		return nil, nil, fmt.Errorf("failed to ping to Postgres: %w", err)
 	}
 
 	Logger.Info().
@@ -86,8 +81,53 @@ func InitPostgres(ctx context.Context, cfg PostgresConfig) (*pgxpool.Pool, error
 		Str("username", cfg.Username).
 		Str("password", utils.Mask(cfg.Password)).
 		Bool("sslmode", cfg.SSLMode).
+		Int32("max_conns", write.Config().MaxConns).
 		Msg("connected to Postgres DB")
-	return p, nil
+
+	if _, err := (SchemaCheck{Mode: cfg.SchemaCheck, Expected: ExpectedSchemaVersion}).Run(pCtx, write); err != nil {
+		write.Close()
+		return nil, nil, ec.ErrDBError.Clone().
+			WithMessage("database schema is not compatible with the binary").
+			Warp(err)
+	}
+
+	read, err = cfg.ReadPool(pCtx)
+	if err != nil {
+		write.Close()
+		return nil, nil, ec.ErrDBError.Clone().
+			WithMessage("failed to create Postgres read replica connection pool").
+			Warp(err)
+	}
+
+	if read == nil {
+		return write, nil, nil
+	}
+
+	if err := pingPostgres(pCtx, read); err != nil {
+		write.Close()
+		read.Close()
+		return nil, nil, fmt.Errorf("failed to ping to Postgres read replica: %w", err)
+	}
+
+	Logger.Info().
+		Str("dsn", redactDSN(cfg.ReadReplicaDSN)).
+		Int32("max_conns", read.Config().MaxConns).
+		Msg("connected to Postgres read replica")
+	return write, read, nil
+}
+
+// pingPostgres pings the pool, retrying with exponential backoff while the
+// database is starting up.
+func pingPostgres(ctx context.Context, p *pgxpool.Pool) error {
+	for retry := 0; p.Ping(ctx) != nil && retry < 5; retry++ {
+		wt := 5 * (1 << retry) * time.Second
+		Logger.Warn().
+			Int("retry", retry).
+			Dur("wait_time", wt).
+			Msg("Waiting for Postgres connection...")
+		time.Sleep(wt)
+	}
+	return p.Ping(ctx)
 }
 
 // InitNATS initializes the NATS connection and returns it.
@@ -1,10 +1,31 @@
 package global
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5"
+)
+
+// SchemaFingerprintTable is the default table recording the hash of the live
+// schema after each successful migration, it is used to detect out-of-band
+// schema changes.
+const SchemaFingerprintTable = "schema_fingerprints"
+
+var (
+	ErrSchemaDrift      = errors.New("live schema differs from the schema recorded at the last migration")
+	ErrNoFingerprint    = errors.New("no schema fingerprint recorded")
+	ErrMigrationIsDirty = errors.New("database is in a dirty migration state")
 )
 
 // Migrate creates a new migration instance using the provided source and database URLs.
@@ -15,3 +36,167 @@ func Migrate(srcURL, dbURL string) (*migrate.Migrate, error) {
 	}
 	return m, nil
 }
+
+// MigrationStatus describes a migration found in the source and whether it
+// has been applied to the database.
+type MigrationStatus struct {
+	Version    uint   `json:"version"`
+	Identifier string `json:"identifier"`
+	Checksum   string `json:"checksum"`
+	Applied    bool   `json:"applied"`
+	Dirty      bool   `json:"dirty"`
+}
+
+// MigrationsStatus lists the migrations in srcURL together with their sha256
+// checksum (of the up migration) and whether they have been applied to the
+// database managed by m.
+func MigrationsStatus(m *migrate.Migrate, srcURL string) ([]MigrationStatus, error) {
+	current, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, fmt.Errorf("failed to get migration version: %w", err)
+	}
+	hasVersion := err == nil
+
+	src, err := source.Open(srcURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migration source: %w", err)
+	}
+	defer src.Close()
+
+	status := []MigrationStatus{}
+	ver, err := src.First()
+	for err == nil {
+		s := MigrationStatus{
+			Version: ver,
+			Applied: hasVersion && ver <= current,
+			Dirty:   hasVersion && dirty && ver == current,
+		}
+
+		r, identifier, rerr := src.ReadUp(ver)
+		if rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read migration %d: %w", ver, rerr)
+		}
+		if rerr == nil {
+			hasher := sha256.New()
+			_, cerr := io.Copy(hasher, r)
+			r.Close()
+			if cerr != nil {
+				return nil, fmt.Errorf("failed to read migration %d: %w", ver, cerr)
+			}
+			s.Identifier = identifier
+			s.Checksum = hex.EncodeToString(hasher.Sum(nil))
+		}
+
+		status = append(status, s)
+		ver, err = src.Next(ver)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+	return status, nil
+}
+
+// SchemaHash computes a sha256 hash of the live schema (tables, columns and
+// indexes of the public and users schemas) from information_schema and
+// pg_indexes. The migration bookkeeping tables, schema_migrations and the
+// fingerprint table, are excluded.
+func SchemaHash(ctx context.Context, conn *pgx.Conn, table string) (string, error) {
+	const query = `
+SELECT 'column' AS kind, table_schema || '.' || table_name || '.' || column_name || ':' ||
+       data_type || ':' || udt_name || ':' || is_nullable || ':' || COALESCE(column_default, '') AS def
+FROM information_schema.columns
+WHERE table_schema IN ('public', 'users')
+  AND table_name NOT IN ('schema_migrations', $1)
+UNION ALL
+SELECT 'index' AS kind, schemaname || '.' || indexname || ':' || indexdef AS def
+FROM pg_indexes
+WHERE schemaname IN ('public', 'users')
+  AND tablename NOT IN ('schema_migrations', $1)
+ORDER BY kind, def`
+
+	rows, err := conn.Query(ctx, query, table)
+	if err != nil {
+		return "", fmt.Errorf("failed to dump schema: %w", err)
+	}
+	defer rows.Close()
+
+	hasher := sha256.New()
+	for rows.Next() {
+		var kind, def string
+		if err := rows.Scan(&kind, &def); err != nil {
+			return "", fmt.Errorf("failed to scan schema: %w", err)
+		}
+		hasher.Write([]byte(kind))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(def))
+		hasher.Write([]byte{'\n'})
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("failed to dump schema: %w", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// RecordSchemaFingerprint stores the hash of the live schema for the given
+// migration version in table, usually SchemaFingerprintTable. It should be
+// called after a successful migration.
+func RecordSchemaFingerprint(ctx context.Context, conn *pgx.Conn, table string, version uint) (string, error) {
+	if _, err := conn.Exec(ctx, fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    version     BIGINT      PRIMARY KEY,
+    hash        TEXT        NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, table)); err != nil {
+		return "", fmt.Errorf("failed to create %s table: %w", table, err)
+	}
+
+	hash, err := SchemaHash(ctx, conn, table)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := conn.Exec(ctx, fmt.Sprintf(`
+INSERT INTO %s (version, hash, recorded_at) VALUES ($1, $2, $3)
+ON CONFLICT (version) DO UPDATE SET hash = EXCLUDED.hash, recorded_at = EXCLUDED.recorded_at`,
+		table), int64(version), hash, time.Now()); err != nil {
+		return "", fmt.Errorf("failed to record schema fingerprint: %w", err)
+	}
+	return hash, nil
+}
+
+// CheckSchemaDrift compares the hash of the live schema against the hash
+// recorded in table at the given migration version. It returns
+// ErrNoFingerprint if no hash was recorded and ErrSchemaDrift if the hashes
+// differ.
+func CheckSchemaDrift(ctx context.Context, conn *pgx.Conn, table string, version uint) (recorded, live string, err error) {
+	var exists bool
+	if err := conn.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = 'public' AND table_name = $1)`,
+		table).Scan(&exists); err != nil {
+		return "", "", fmt.Errorf("failed to check %s table: %w", table, err)
+	}
+	if !exists {
+		return "", "", ErrNoFingerprint
+	}
+
+	err = conn.QueryRow(ctx, fmt.Sprintf(
+		`SELECT hash FROM %s WHERE version = $1`, table),
+		int64(version)).Scan(&recorded)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", ErrNoFingerprint
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get schema fingerprint: %w", err)
+	}
+
+	live, err = SchemaHash(ctx, conn, table)
+	if err != nil {
+		return recorded, "", err
+	}
+
+	if recorded != live {
+		return recorded, live, ErrSchemaDrift
+	}
+	return recorded, live, nil
+}
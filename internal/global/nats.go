@@ -2,6 +2,7 @@ package global
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -17,11 +18,35 @@ const (
 	NATSTaskStream       = "weathercock_tasks"
 )
 
+// NATSTaskStreamSubjects are the subjects of the task stream: the task
+// commands and events and the admin commands of the subject hierarchy, see
+// workers.SubjectCmd, and the legacy flat subjects they are aliased by during
+// the migration to it.
+var NATSTaskStreamSubjects = []string{
+	"task.cmd.>",
+	"task.evt.>",
+	"admin.cmd.>",
+	// legacy commands
+	"task.scrape",
+	"task.scrape.priority",
+	"task.generate_title",
+	"task.extract.keyword",
+	"task.create.embedding",
+	"task.update.status",
+	"task.logs",
+	// legacy events
+	"task.created",
+	"task.failed",
+	"article.scraped",
+	"article.keywords.extracted",
+	"article.embedding.created",
+}
+
 // NATSConfig holds configuration for connecting to a NATS server.
 // Authentication by username and password.
 type NATSConfig struct {
 	Host      string `json:"host"     validate:"required"                  mapstructure:"host"`
-	Port      int    `json:"port"     validate:"required"                  mapstructure:"port"`
+	Port      int    `json:"port"     validate:"required,min=1,max=65535"  mapstructure:"port"`
 	Username  string `json:"username" validate:"required_without=Token"    mapstructure:"username"`
 	Password  string `json:"password" validate:"required_without=Token"    mapstructure:"password"`
 	Token     string `json:"token"    validate:"required_without=Password" mapstructure:"token"`
@@ -39,6 +64,11 @@ func DefaultNATSConfig() *NATSConfig {
 	}
 }
 
+// Default returns the default NATSConfig.
+func (NATSConfig) Default() NATSConfig {
+	return *DefaultNATSConfig()
+}
+
 // LoadNATSConfig returns the NATS configuration from Viper.
 func LoadNATSConfig() *NATSConfig {
 	conf := DefaultNATSConfig()
@@ -133,20 +163,20 @@ func (c NATSConfig) ConnectJetStream() (*nats.Conn, nats.JetStreamContext, error
 		return nil, nil, fmt.Errorf("failed to add weathercock_logs stream: %w", err)
 	}
 
-	// Create weathercock_tasks stream
-	_, err = js.AddStream(&nats.StreamConfig{
-		Name: NATSTaskStream,
-		Subjects: []string{
-			"task.create",
-			"task.scrape",
-			"task.generate_title",
-			"task.extract.keyword",
-		},
+	// Create weathercock_tasks stream, or update the subjects of the one
+	// created before the subject hierarchy
+	taskStream := &nats.StreamConfig{
+		Name:     NATSTaskStream,
+		Subjects: NATSTaskStreamSubjects,
 		MaxMsgs:  -1,
 		MaxBytes: -1,
 		MaxAge:   0,
 		Storage:  nats.FileStorage,
-	})
+	}
+	_, err = js.AddStream(taskStream)
+	if errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+		_, err = js.UpdateStream(taskStream)
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to add weathercock_tasks stream: %w", err)
 	}
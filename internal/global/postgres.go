@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand/v2"
+	"net/url"
 	"os"
 	"strings"
 
@@ -18,41 +19,61 @@ import (
 // PasswordFile is used to specify a file from which the password can be read.
 // The Password field is required unless PasswordFile is provided.
 // SSLMode is a boolean that indicates whether to use SSL for the connection. (default: false)
+// ReadReplicaDSN is the connection string of a read replica, the read-only
+// queries are routed to it when set. MaxConns and ReadMaxConns size the write
+// and the read pool, zero keeps the pgxpool default. SchemaCheck is what the
+// service does at startup if the database is behind the migrations the binary
+// was built against, one of strict, warn (default) and off.
 type PostgresConfig struct {
 	Host         string `json:"host"          validate:"required"                      mapstructure:"host"`
-	Port         int    `json:"port"          validate:"required"                      mapstructure:"port"`
+	Port         int    `json:"port"          validate:"required,min=1,max=65535"      mapstructure:"port"`
 	Username     string `json:"username"      validate:"required"                      mapstructure:"username"`
 	Password     string `json:"password"      validate:"required_without=PasswordFile" mapstructure:"password"`
 	PasswordFile string `json:"password_file" validate:"required_without=Password"     mapstructure:"password_file"`
 	Database     string `json:"database"      validate:"required"                      mapstructure:"database"`
 	SSLMode      bool   `json:"sslmode"                                                mapstructure:"sslmode"`
+
+	ReadReplicaDSN string `json:"read_replica_dsn,omitempty" mapstructure:"read_replica_dsn"`
+	MaxConns       int32  `json:"max_conns,omitempty"        validate:"gte=0" mapstructure:"max_conns"`
+	ReadMaxConns   int32  `json:"read_max_conns,omitempty"   validate:"gte=0" mapstructure:"read_max_conns"`
+
+	SchemaCheck SchemaCheckMode `json:"schema_check,omitempty" validate:"omitempty,oneof=strict warn off" mapstructure:"schema_check"`
 }
 
-func LoadPostgresConfig() *PostgresConfig {
-	viper.SetDefault("POSTGRES_HOST", "localhost")
-	viper.SetDefault("POSTGRES_PORT", 5432)
-	viper.SetDefault("POSTGRES_USER", "postgres")
-	viper.SetDefault("POSTGRES_APP_DB", "db")
-	viper.SetDefault("POSTGRES_SSLMODE", false)
-
-	cfx := &PostgresConfig{
-		Host:         viper.GetString("POSTGRES_HOST"),
-		Port:         viper.GetInt("POSTGRES_PORT"),
-		Username:     viper.GetString("POSTGRES_USER"),
-		Password:     viper.GetString("POSTGRES_PASSWORD"),
-		PasswordFile: viper.GetString("POSTGRES_PASSWORD_FILE"),
-		Database:     viper.GetString("POSTGRES_APP_DB"),
-		SSLMode:      viper.GetBool("POSTGRES_SSLMODE"),
+// Default returns the PostgresConfig of a local database.
+func (PostgresConfig) Default() PostgresConfig {
+	return PostgresConfig{
+		Host:        "localhost",
+		Port:        5432,
+		Username:    "postgres",
+		Database:    "db",
+		SchemaCheck: SchemaCheckWarn,
 	}
+}
+
+func LoadPostgresConfig() *PostgresConfig {
+	cfx := PostgresConfig{}.Default()
+	cfx.Host = utils.DefaultIfZero(viper.GetString("POSTGRES_HOST"), cfx.Host)
+	cfx.Port = utils.DefaultIfZero(viper.GetInt("POSTGRES_PORT"), cfx.Port)
+	cfx.Username = utils.DefaultIfZero(viper.GetString("POSTGRES_USER"), cfx.Username)
+	cfx.Password = viper.GetString("POSTGRES_PASSWORD")
+	cfx.PasswordFile = viper.GetString("POSTGRES_PASSWORD_FILE")
+	cfx.Database = utils.DefaultIfZero(viper.GetString("POSTGRES_APP_DB"), cfx.Database)
+	cfx.SSLMode = viper.GetBool("POSTGRES_SSLMODE")
+	cfx.ReadReplicaDSN = viper.GetString("POSTGRES_READ_REPLICA_DSN")
+	cfx.MaxConns = viper.GetInt32("POSTGRES_MAX_CONNS")
+	cfx.ReadMaxConns = viper.GetInt32("POSTGRES_READ_MAX_CONNS")
+	cfx.SchemaCheck = utils.DefaultIfZero(SchemaCheckMode(viper.GetString("POSTGRES_SCHEMA_CHECK")), cfx.SchemaCheck)
 
 	if err := cfx.ReadPasswordFile(); err != nil {
 		Logger.Warn().Err(err).Msg("failed to read password file")
 		return nil
 	}
-	return cfx
+	return &cfx
 }
 
-// MarshalJSON is a custom JSON marshaller that masks the password field.
+// MarshalJSON is a custom JSON marshaller that masks the password field and
+// the password in the read replica DSN.
 func (c PostgresConfig) MarshalJSON() ([]byte, error) {
 	password := c.Password
 	if c.PasswordFile != "" {
@@ -61,14 +82,30 @@ func (c PostgresConfig) MarshalJSON() ([]byte, error) {
 
 	type Alias PostgresConfig
 	return json.Marshal(&struct {
-		Password string `json:"password,omitempty"`
+		Password       string `json:"password,omitempty"`
+		ReadReplicaDSN string `json:"read_replica_dsn,omitempty"`
 		*Alias
 	}{
-		Password: password,
-		Alias:    (*Alias)(&c),
+		Password:       password,
+		ReadReplicaDSN: redactDSN(c.ReadReplicaDSN),
+		Alias:          (*Alias)(&c),
 	})
 }
 
+// redactDSN masks the password of a URL connection string. A key-value
+// connection string is masked entirely.
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return utils.Mask(dsn)
+	}
+	return u.Redacted()
+}
+
 // MarshalJSONPlain is a custom JSON marshaller that does not mask the password.
 // It is used for internal purposes where the password needs to be visible.
 // Use with caution, as it will expose the password in the JSON output.
@@ -131,13 +168,39 @@ func (c *PostgresConfig) URLString() string {
 
 // Pool returns a connection pool for the PostgreSQL database.
 func (c *PostgresConfig) Pool(ctx context.Context) (*pgxpool.Pool, error) {
-	pool, err := pgxpool.New(ctx, c.URL())
+	pool, err := newPool(ctx, c.URL(), c.MaxConns)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Postgres: %w", err)
 	}
 	return pool, nil
 }
 
+// ReadPool returns a connection pool for the read replica. It returns a nil
+// pool if no read replica is configured.
+func (c *PostgresConfig) ReadPool(ctx context.Context) (*pgxpool.Pool, error) {
+	if c.ReadReplicaDSN == "" {
+		return nil, nil
+	}
+
+	pool, err := newPool(ctx, c.ReadReplicaDSN, c.ReadMaxConns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Postgres read replica: %w", err)
+	}
+	return pool, nil
+}
+
+func newPool(ctx context.Context, dsn string, maxConns int32) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxConns > 0 {
+		cfg.MaxConns = maxConns
+	}
+	return pgxpool.NewWithConfig(ctx, cfg)
+}
+
 // String returns a JSON representation of the PostgresConfig.
 // It masks the password if PasswordFile is set, otherwise it shows the actual password.
 func (c PostgresConfig) String() string {
@@ -0,0 +1,234 @@
+package global
+
+//go:generate go run ../../cmd/schemaversion -migrations ../../migrations -output schema_version.go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultMigrationsTable is the table golang-migrate records the version of
+// the database in.
+const DefaultMigrationsTable = "schema_migrations"
+
+var ErrSchemaBehind = errors.New("database schema is behind the version the binary expects")
+
+// SchemaCheckMode tells what a service does if the database schema is not
+// compatible with the binary at startup.
+type SchemaCheckMode string
+
+const (
+	// SchemaCheckStrict refuses to start.
+	SchemaCheckStrict SchemaCheckMode = "strict"
+	// SchemaCheckWarn logs the mismatch, continues and reports it on /readyz.
+	SchemaCheckWarn SchemaCheckMode = "warn"
+	// SchemaCheckOff skips the check.
+	SchemaCheckOff SchemaCheckMode = "off"
+)
+
+// SchemaQuerier runs the introspection queries, e.g. a *pgx.Conn or a
+// *pgxpool.Pool.
+type SchemaQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// SchemaCompatibility compares the migration version of the database with
+// the version the binary was built against.
+type SchemaCompatibility struct {
+	Current  uint `json:"current_version"`
+	Dirty    bool `json:"dirty"`
+	Expected uint `json:"expected_version"`
+}
+
+// Behind reports whether the database misses migrations the binary expects.
+func (c SchemaCompatibility) Behind() bool {
+	return c.Current < c.Expected
+}
+
+// Ahead reports whether the database has migrations the binary does not know
+// about. The migrations are additive, so an older binary keeps working.
+func (c SchemaCompatibility) Ahead() bool {
+	return c.Current > c.Expected
+}
+
+// Compatible reports whether the binary can run against the database.
+func (c SchemaCompatibility) Compatible() bool {
+	return !c.Dirty && !c.Behind()
+}
+
+// SchemaCheck checks the migration version of the database against Expected.
+// An empty Mode is SchemaCheckWarn and an empty MigrationsTable is the
+// DefaultMigrationsTable.
+type SchemaCheck struct {
+	Mode            SchemaCheckMode
+	Expected        uint
+	MigrationsTable string
+}
+
+// schemaMismatch holds the incompatibility found by the last check in warn
+// mode, it is nil if the schema is compatible.
+var schemaMismatch atomic.Pointer[SchemaCompatibility]
+
+// SchemaMismatch returns the incompatibility found at startup by a check in
+// warn mode, if any.
+func SchemaMismatch() (SchemaCompatibility, bool) {
+	c := schemaMismatch.Load()
+	if c == nil {
+		return SchemaCompatibility{}, false
+	}
+	return *c, true
+}
+
+// Run reads the migration version of db and applies the mode. In strict mode
+// a dirty or behind database is an error, in warn mode it is logged and
+// reported by SchemaMismatch.
+func (sc SchemaCheck) Run(ctx context.Context, db SchemaQuerier) (SchemaCompatibility, error) {
+	if sc.Mode == SchemaCheckOff {
+		schemaMismatch.Store(nil)
+		return SchemaCompatibility{Expected: sc.Expected}, nil
+	}
+
+	c, err := SchemaVersion(ctx, db, sc.MigrationsTable)
+	if err != nil {
+		return c, err
+	}
+	c.Expected = sc.Expected
+
+	if c.Compatible() {
+		schemaMismatch.Store(nil)
+		if c.Ahead() {
+			Logger.Info().
+				Uint("current_version", c.Current).
+				Uint("expected_version", c.Expected).
+				Msg("database schema is ahead of the binary")
+		}
+		return c, nil
+	}
+
+	err = ErrSchemaBehind
+	if c.Dirty {
+		err = ErrMigrationIsDirty
+	}
+
+	if sc.Mode == SchemaCheckStrict {
+		return c, fmt.Errorf("%w: database at version %d (dirty: %t), binary expects %d",
+			err, c.Current, c.Dirty, c.Expected)
+	}
+
+	Logger.Warn().
+		Err(err).
+		Uint("current_version", c.Current).
+		Bool("dirty", c.Dirty).
+		Uint("expected_version", c.Expected).
+		Msg("database schema is not compatible with the binary")
+	schemaMismatch.Store(&c)
+	return c, nil
+}
+
+// SchemaVersion reads the migration version of db from the migrations table.
+// A database without the table or without a version is at version 0.
+func SchemaVersion(ctx context.Context, db SchemaQuerier, table string) (SchemaCompatibility, error) {
+	if table == "" {
+		table = DefaultMigrationsTable
+	}
+
+	var exists bool
+	if err := db.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`,
+		pgx.Identifier{table}.Sanitize()).Scan(&exists); err != nil {
+		return SchemaCompatibility{}, fmt.Errorf("failed to check %s table: %w", table, err)
+	}
+	if !exists {
+		return SchemaCompatibility{}, nil
+	}
+
+	var c SchemaCompatibility
+	var version int64
+	err := db.QueryRow(ctx, fmt.Sprintf(`SELECT version, dirty FROM %s LIMIT 1`,
+		pgx.Identifier{table}.Sanitize())).Scan(&version, &c.Dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return SchemaCompatibility{}, nil
+	}
+	if err != nil {
+		return SchemaCompatibility{}, fmt.Errorf("failed to get migration version: %w", err)
+	}
+	c.Current = uint(version)
+	return c, nil
+}
+
+// ColumnInfo describes a column of a table.
+type ColumnInfo struct {
+	Name     string `json:"name"`
+	DataType string `json:"data_type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// SchemaIntrospection is the migration version of the database and the
+// columns of the tables the binary uses.
+type SchemaIntrospection struct {
+	SchemaCompatibility
+	Tables map[string][]ColumnInfo `json:"tables"`
+}
+
+// TableColumns lists the columns of the tables, given as schema.table, in
+// their ordinal order. A table missing from the database has no columns.
+func TableColumns(ctx context.Context, db SchemaQuerier, tables []string) (map[string][]ColumnInfo, error) {
+	columns := make(map[string][]ColumnInfo, len(tables))
+	for _, t := range tables {
+		columns[t] = []ColumnInfo{}
+	}
+
+	rows, err := db.Query(ctx, `
+SELECT table_schema || '.' || table_name, column_name, udt_name, is_nullable = 'YES'
+FROM information_schema.columns
+WHERE table_schema || '.' || table_name = ANY($1)
+ORDER BY table_schema, table_name, ordinal_position`, tables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list columns: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table string
+		var col ColumnInfo
+		if err := rows.Scan(&table, &col.Name, &col.DataType, &col.Nullable); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		columns[table] = append(columns[table], col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list columns: %w", err)
+	}
+	return columns, nil
+}
+
+// LatestMigrationVersion returns the highest version of the migrations in
+// srcURL, 0 if there are none.
+func LatestMigrationVersion(srcURL string) (uint, error) {
+	src, err := source.Open(srcURL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open migration source: %w", err)
+	}
+	defer src.Close()
+
+	ver, err := src.First()
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+
+	var latest uint
+	for err == nil {
+		latest = ver
+		ver, err = src.Next(ver)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("failed to list migrations: %w", err)
+	}
+	return latest, nil
+}
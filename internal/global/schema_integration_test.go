@@ -0,0 +1,149 @@
+//go:build integration
+
+package global_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// setSchemaVersion records version in the test migrations table the way
+// golang-migrate does.
+func setSchemaVersion(t *testing.T, conn *pgx.Conn, version int64, dirty bool) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := conn.Exec(ctx, fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL);
+TRUNCATE %[1]s;`, testMigrationsTable))
+	require.NoError(t, err)
+	_, err = conn.Exec(ctx, fmt.Sprintf(`INSERT INTO %s (version, dirty) VALUES ($1, $2)`,
+		testMigrationsTable), version, dirty)
+	require.NoError(t, err)
+}
+
+func TestSchemaCheck(t *testing.T) {
+	dbURL := testPostgresURL(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, dbURL)
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+	resetDatabase(t, conn)
+	defer resetDatabase(t, conn)
+
+	const expected = 5
+	tcs := []struct {
+		name     string
+		version  int64
+		dirty    bool
+		mode     global.SchemaCheckMode
+		err      error
+		mismatch bool
+	}{
+		{name: "strict behind", version: 4, mode: global.SchemaCheckStrict, err: global.ErrSchemaBehind},
+		{name: "strict ahead", version: 6, mode: global.SchemaCheckStrict},
+		{name: "strict dirty", version: 5, dirty: true, mode: global.SchemaCheckStrict, err: global.ErrMigrationIsDirty},
+		{name: "strict current", version: 5, mode: global.SchemaCheckStrict},
+		{name: "warn behind", version: 4, mode: global.SchemaCheckWarn, mismatch: true},
+		{name: "warn ahead", version: 6, mode: global.SchemaCheckWarn},
+		{name: "warn dirty", version: 5, dirty: true, mode: global.SchemaCheckWarn, mismatch: true},
+		{name: "empty mode warns", version: 4, mismatch: true},
+		{name: "off behind", version: 4, mode: global.SchemaCheckOff},
+		{name: "off ahead", version: 6, mode: global.SchemaCheckOff},
+		{name: "off dirty", version: 5, dirty: true, mode: global.SchemaCheckOff},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			setSchemaVersion(t, conn, tc.version, tc.dirty)
+
+			c, err := global.SchemaCheck{
+				Mode:            tc.mode,
+				Expected:        expected,
+				MigrationsTable: testMigrationsTable,
+			}.Run(ctx, conn)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			if tc.mode != global.SchemaCheckOff {
+				require.Equal(t, uint(tc.version), c.Current)
+				require.Equal(t, tc.dirty, c.Dirty)
+			}
+			require.Equal(t, uint(expected), c.Expected)
+
+			mismatch, ok := global.SchemaMismatch()
+			require.Equal(t, tc.mismatch, ok)
+			if ok {
+				require.Equal(t, c, mismatch)
+			}
+		})
+	}
+
+	t.Run("no migrations table", func(t *testing.T) {
+		resetDatabase(t, conn)
+		_, err := global.SchemaCheck{
+			Mode:            global.SchemaCheckStrict,
+			Expected:        expected,
+			MigrationsTable: testMigrationsTable,
+		}.Run(ctx, conn)
+		require.ErrorIs(t, err, global.ErrSchemaBehind)
+	})
+}
+
+func TestSchemaIntrospection(t *testing.T) {
+	dbURL := testPostgresURL(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, dbURL)
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+	resetDatabase(t, conn)
+	defer resetDatabase(t, conn)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE migration_test (id SERIAL PRIMARY KEY, name TEXT, created_at TIMESTAMPTZ NOT NULL);`)
+	require.NoError(t, err)
+	setSchemaVersion(t, conn, 3, false)
+
+	c, err := global.SchemaVersion(ctx, conn, testMigrationsTable)
+	require.NoError(t, err)
+	c.Expected = 3
+
+	// a declared table missing from the database is listed without columns
+	tables, err := global.TableColumns(ctx, conn, []string{"public.migration_test", "public.no_such_table"})
+	require.NoError(t, err)
+	require.Equal(t, []global.ColumnInfo{
+		{Name: "id", DataType: "int4", Nullable: false},
+		{Name: "name", DataType: "text", Nullable: true},
+		{Name: "created_at", DataType: "timestamptz", Nullable: false},
+	}, tables["public.migration_test"])
+	require.Empty(t, tables["public.no_such_table"])
+
+	data, err := json.Marshal(global.SchemaIntrospection{SchemaCompatibility: c, Tables: tables})
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"current_version": 3,
+		"dirty": false,
+		"expected_version": 3,
+		"tables": {
+			"public.migration_test": [
+				{"name": "id", "data_type": "int4", "nullable": false},
+				{"name": "name", "data_type": "text", "nullable": true},
+				{"name": "created_at", "data_type": "timestamptz", "nullable": false}
+			],
+			"public.no_such_table": []
+		}
+	}`, string(data))
+}
@@ -0,0 +1,43 @@
+package global_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaCompatibility(t *testing.T) {
+	tcs := []struct {
+		name       string
+		c          global.SchemaCompatibility
+		behind     bool
+		ahead      bool
+		compatible bool
+	}{
+		{name: "current", c: global.SchemaCompatibility{Current: 5, Expected: 5}, compatible: true},
+		{name: "behind", c: global.SchemaCompatibility{Current: 4, Expected: 5}, behind: true},
+		{name: "ahead", c: global.SchemaCompatibility{Current: 6, Expected: 5}, ahead: true, compatible: true},
+		{name: "dirty", c: global.SchemaCompatibility{Current: 5, Dirty: true, Expected: 5}},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.behind, tc.c.Behind())
+			require.Equal(t, tc.ahead, tc.c.Ahead())
+			require.Equal(t, tc.compatible, tc.c.Compatible())
+		})
+	}
+}
+
+// TestExpectedSchemaVersion fails if a migration was added without running
+// go generate ./internal/global.
+func TestExpectedSchemaVersion(t *testing.T) {
+	dir, err := filepath.Abs("../../migrations")
+	require.NoError(t, err)
+
+	latest, err := global.LatestMigrationVersion("file://" + filepath.ToSlash(dir))
+	require.NoError(t, err)
+	require.Equal(t, latest, global.ExpectedSchemaVersion)
+}
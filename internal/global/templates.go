@@ -12,8 +12,16 @@ import (
 
 // TemplateConfig holds directory and file pattern for templates.
 type TemplateConfig struct {
-	Dir  string `json:"dir"  validate:"required"`
-	File string `json:"file" validate:"required"`
+	Dir  string `json:"dir"  validate:"required" mapstructure:"dir"`
+	File string `json:"file" validate:"required" mapstructure:"file"`
+}
+
+// Default returns the TemplateConfig of the templates shipped in the repo.
+func (TemplateConfig) Default() TemplateConfig {
+	return TemplateConfig{
+		Dir:  "./templates",
+		File: "*.gotmpl",
+	}
 }
 
 func (tmpl TemplateConfig) Path() string {
@@ -30,8 +38,8 @@ func (tmpl TemplateConfig) Validate() error {
 
 func TemplatesConfig() *TemplateConfig {
 	return &TemplateConfig{
-		Dir:  utils.DefaultIfZero(viper.GetString("TMPL_DIR"), "./templates"),
-		File: utils.DefaultIfZero(viper.GetString("TMPL_FILE"), "*.gotmpl"),
+		Dir:  utils.DefaultIfZero(viper.GetString("TMPL_DIR"), TemplateConfig{}.Default().Dir),
+		File: utils.DefaultIfZero(viper.GetString("TMPL_FILE"), TemplateConfig{}.Default().File),
 	}
 }
 
@@ -0,0 +1,475 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"golang.org/x/time/rate"
+)
+
+const (
+	DefaultBaseURL    = "https://api.anthropic.com"
+	DefaultAPIVersion = "2023-06-01"
+	DefaultGenModel   = "claude-sonnet-4-0"
+	// DefaultMaxTokens is the max_tokens of the requests setting no
+	// MaxTokens, unless set by WithMaxTokens.
+	DefaultMaxTokens = 4096
+)
+
+var (
+	ErrAPIKeyMissing      = errors.New("missing Anthropic API key")
+	ErrModelNotFound      = errors.New("model not found")
+	ErrIncompleteResponse = errors.New("anthropic request failed: response was incomplete")
+)
+
+type Client struct {
+	*llm.BaseClient
+	HTTPClient *http.Client
+	BaseURL    string
+	APIVersion string
+	// MaxTokens is the max_tokens of the requests setting no MaxTokens.
+	MaxTokens int
+	apiKey    string
+}
+
+var _ llm.Streamer = (*Client)(nil)
+
+type builder struct {
+	APIKey      string
+	APIVer      string
+	BaseURL     string
+	Timeout     *time.Duration
+	Client      *http.Client
+	Transport   http.RoundTripper
+	Models      map[string]llm.Model
+	DefaultGen  string
+	MaxTokens   int
+	RetryPolicy *llm.RetryPolicy
+	Limiter     *rate.Limiter
+}
+
+// Anthropic creates a new Anthropic client with the given context and options.
+// It initializes the client, validates models, and sets up default models. The
+// Anthropic API has no embedding model, the client only generates.
+// Parameters:
+//   - ctx: The context for the client initialization.
+//   - opts: Functional options to configure the Anthropic client.
+//
+// Returns:
+//   - *Client: The initialized Anthropic client.
+//   - error: An error if client creation fails.
+func Anthropic(ctx context.Context, opts ...Option) (*Client, error) {
+	b := &builder{Models: map[string]llm.Model{}}
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.APIKey == "" {
+		return nil, ErrAPIKeyMissing
+	}
+
+	httpClient := utils.IfElse(b.Client == nil, http.DefaultClient, b.Client)
+	if b.Transport != nil {
+		httpClient = llm.HTTPClientWithTransport(httpClient, b.Transport)
+	}
+	if b.Timeout != nil {
+		wrapped := *httpClient
+		wrapped.Timeout = *b.Timeout
+		httpClient = &wrapped
+	}
+
+	cli := &Client{
+		HTTPClient: httpClient,
+		BaseURL:    strings.TrimSuffix(utils.DefaultIfZero(b.BaseURL, DefaultBaseURL), "/"),
+		APIVersion: utils.DefaultIfZero(b.APIVer, DefaultAPIVersion),
+		MaxTokens:  utils.DefaultIfZero(b.MaxTokens, DefaultMaxTokens),
+		apiKey:     b.APIKey,
+	}
+
+	if len(b.Models) == 0 {
+		b.Models[DefaultGenModel] = NewAnthropicModel(llm.ModelGenerate, DefaultGenModel)
+	}
+
+	// validate models
+	for name, model := range b.Models {
+		if model.Type() != llm.ModelGenerate {
+			return nil, fmt.Errorf("%w: model %s, the anthropic client only generates",
+				llm.ErrNotImplemented, name)
+		}
+
+		var m modelInfo
+		if err := cli.call(ctx, http.MethodGet, "/v1/models/"+url.PathEscape(name), nil, &m); err != nil {
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+				return nil, fmt.Errorf("%w: %s, %w", ErrModelNotFound, name, err)
+			}
+			return nil, fmt.Errorf("could not retrieve model %s from Anthropic API: %w", name, err)
+		}
+
+		aModel := model.(AnthropicModel)
+		aModel.DisplayName = m.DisplayName
+		aModel.CreatedAt, _ = time.Parse(time.RFC3339, m.CreatedAt)
+		b.Models[name] = aModel
+	}
+
+	base := llm.NewClient()
+	base.RetryPolicy = llm.DefaultRetryPolicy()
+	if b.RetryPolicy != nil {
+		base.RetryPolicy = *b.RetryPolicy
+	}
+	if base.RetryPolicy.RetryOn == nil {
+		base.RetryPolicy.RetryOn = IsTransient
+	}
+	base.Limiter = b.Limiter
+
+	for _, model := range b.Models {
+		if err := base.WithModel(model); err != nil {
+			return nil, fmt.Errorf("could not register model %s: %w", model.Name(), err)
+		}
+	}
+
+	b.DefaultGen = utils.DefaultIfZero(b.DefaultGen, DefaultGenModel)
+	if err := base.SetDefaultModel(llm.ModelGenerate, b.DefaultGen); err != nil {
+		return nil, fmt.Errorf("could not set default generate model: %w", err)
+	}
+
+	cli.BaseClient = base
+	return cli, nil
+}
+
+// APIError is the error response of the Anthropic API.
+type APIError struct {
+	// StatusCode is the HTTP status of the response, 0 for an error event
+	// of a stream.
+	StatusCode int
+	// Type is the type of the error, e.g. rate_limit_error or
+	// overloaded_error.
+	Type      string
+	Message   string
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	msg := "anthropic api error"
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" %d", e.StatusCode)
+	}
+	msg += fmt.Sprintf(": %s: %s", e.Type, e.Message)
+	if e.RequestID != "" {
+		msg += ", request id: " + e.RequestID
+	}
+	return msg
+}
+
+// apiErrorBody is the body of an error response, and an error event.
+type apiErrorBody struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+	RequestID string `json:"request_id"`
+}
+
+// IsTransient reports whether the error of an Anthropic request is worth
+// retrying: a rate limit, an overloaded or a server error of the API, or a
+// transient failure to reach it.
+func IsTransient(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return llm.IsTransientStatus(apiErr.StatusCode) ||
+			apiErr.Type == "overloaded_error" || apiErr.Type == "api_error"
+	}
+	return llm.IsTransient(err)
+}
+
+// do sends a request with body, encoded as JSON, to the path of the API. The
+// error of a response which is not a success is an *APIError, the caller
+// closes the body of the response otherwise.
+func (cli *Client) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, cli.BaseURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", cli.apiKey)
+	req.Header.Set("anthropic-version", cli.APIVersion)
+
+	resp, err := cli.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	apiErr := &APIError{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("request-id")}
+	var errBody apiErrorBody
+	if err := json.Unmarshal(data, &errBody); err != nil || errBody.Error.Message == "" {
+		apiErr.Message = string(data)
+	} else {
+		apiErr.Type = errBody.Error.Type
+		apiErr.Message = errBody.Error.Message
+		apiErr.RequestID = utils.DefaultIfZero(errBody.RequestID, apiErr.RequestID)
+	}
+	return nil, apiErr
+}
+
+// call sends a request with do and decodes the JSON of the response into out.
+func (cli *Client) call(ctx context.Context, method, path string, body, out any) error {
+	resp, err := cli.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response body: %w", err)
+	}
+	return nil
+}
+
+// messagesRequest converts req to the body of a request to /v1/messages.
+func (cli *Client) messagesRequest(req *llm.GenerateRequest) (*messagesRequest, error) {
+	modelName := req.ModelName
+	if modelName == "" {
+		if m, ok := cli.DefaultModel(llm.ModelGenerate); ok {
+			modelName = m.Name()
+		} else {
+			modelName = DefaultGenModel
+		}
+	}
+	return toMessagesRequest(modelName, req, cli.MaxTokens)
+}
+
+// Generate sends a request to the messages API of Anthropic. The system
+// messages are the system prompt of the request. The output of a request
+// with a Schema is the input of a tool taking the schema, which the model is
+// forced to call, and fails with an error wrapping llm.ErrContentBlocked if
+// the model refuses the request.
+// Parameters:
+//   - ctx: The context for the request.
+//   - req: llm.GenerateRequest containing the messages and model information.
+//     Its Config, if any, is a map[string]any of the fields of the request
+//     body, e.g. {"top_k": 40}.
+//
+// Returns:
+//   - *llm.GenerateResponse with the generated output and raw response.
+//   - error if the request fails or the configuration type is invalid.
+func (cli *Client) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm.GenerateResponse, error) {
+	if req == nil {
+		return nil, llm.ErrRequestShouldNotBeNull
+	}
+
+	if len(req.Messages) == 0 {
+		return nil, llm.ErrNoInput
+	}
+
+	body, err := cli.messagesRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp messagesResponse
+	err = cli.Retry(ctx, func(ctx context.Context) error {
+		return cli.call(ctx, http.MethodPost, "/v1/messages", body, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StopReason == StopReasonRefusal {
+		return nil, fmt.Errorf("%w: refused by %s", llm.ErrContentBlocked, resp.Model)
+	}
+
+	output := outputOf(&resp, body)
+	var schemaErr error
+	if req.Schema != nil {
+		output = llm.RepairOutput(output, req.Schema)
+		schemaErr = llm.ValidateOutput(output, req.Schema)
+	}
+
+	return &llm.GenerateResponse{
+		Outputs:      []string{output},
+		Usage:        resp.Usage.toUsage(),
+		Raw:          resp,
+		SchemaErr:    schemaErr,
+		FinishReason: finishReason(resp.StopReason, req.Schema != nil),
+	}, nil
+}
+
+// streamEvent is an event of a streamed message, the data of a server-sent
+// event.
+type streamEvent struct {
+	Type         string            `json:"type"`
+	Message      *messagesResponse `json:"message,omitempty"`
+	Index        int               `json:"index"`
+	ContentBlock *contentBlock     `json:"content_block,omitempty"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta,omitempty"`
+	Usage *usage `json:"usage,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// GenerateStream streams the message of an Anthropic model, a chunk per text
+// delta, or per delta of the JSON of a structured output. The Done chunk
+// carries the stop reason and the token counts of the message. A stream the
+// API stops before the end of the message ends with ErrIncompleteResponse.
+// Parameters:
+//   - ctx: The context for the request.
+//   - req: llm.GenerateRequest containing the messages and model information.
+//
+// Returns:
+//   - <-chan llm.GenerateChunk receiving the deltas of the output.
+//   - error if the configuration type is invalid.
+func (cli *Client) GenerateStream(ctx context.Context, req *llm.GenerateRequest) (<-chan llm.GenerateChunk, error) {
+	if req == nil {
+		return nil, llm.ErrRequestShouldNotBeNull
+	}
+
+	if len(req.Messages) == 0 {
+		return nil, llm.ErrNoInput
+	}
+
+	body, err := cli.messagesRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	body.Stream = true
+
+	return llm.NewStreamEnd(ctx, func(emit func(delta string) error) (llm.StreamEnd, error) {
+		if err := cli.Wait(ctx); err != nil {
+			return llm.StreamEnd{}, err
+		}
+
+		resp, err := cli.do(ctx, http.MethodPost, "/v1/messages", body)
+		if err != nil {
+			return llm.StreamEnd{}, err
+		}
+		defer resp.Body.Close()
+
+		var end llm.StreamEnd
+		var u usage
+		stopReason, done := "", false
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+
+			var event streamEvent
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+				return end, fmt.Errorf("failed to decode stream event: %w", err)
+			}
+			switch event.Type {
+			case "message_start":
+				if event.Message != nil {
+					u = event.Message.Usage
+				}
+			case "content_block_delta":
+				if event.Delta == nil {
+					continue
+				}
+				delta := event.Delta.Text
+				if event.Delta.Type == "input_json_delta" {
+					delta = event.Delta.PartialJSON
+				}
+				if err := emit(delta); err != nil {
+					return end, err
+				}
+			case "message_delta":
+				if event.Delta != nil && event.Delta.StopReason != "" {
+					stopReason = event.Delta.StopReason
+				}
+				if event.Usage != nil {
+					// the output tokens of a delta are cumulative
+					u.OutputTokens = event.Usage.OutputTokens
+				}
+			case "message_stop":
+				done = true
+			case "error":
+				apiErr := &APIError{}
+				if event.Error != nil {
+					apiErr.Type, apiErr.Message = event.Error.Type, event.Error.Message
+				}
+				return end, apiErr
+			}
+		}
+		end = llm.StreamEnd{
+			FinishReason: finishReason(stopReason, req.Schema != nil),
+			Usage:        u.toUsage(),
+		}
+		if err := scanner.Err(); err != nil {
+			if ctx.Err() != nil {
+				return end, ctx.Err()
+			}
+			return end, fmt.Errorf("failed to read stream: %w", err)
+		}
+
+		if !done {
+			if err := ctx.Err(); err != nil {
+				return end, err
+			}
+			return end, ErrIncompleteResponse
+		}
+		if stopReason == StopReasonRefusal {
+			return end, fmt.Errorf("%w: refused by %s", llm.ErrContentBlocked, body.Model)
+		}
+		return end, nil
+	}), nil
+}
+
+// Embed is not supported by Anthropic, which has no embedding model.
+func (cli *Client) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.EmbedResponse, error) {
+	return nil, llm.ErrNotImplemented
+}
+
+// BatchCreate is not supported by the Anthropic client yet.
+func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm.BatchResponse, error) {
+	return nil, llm.ErrNotImplemented
+}
+
+// BatchRetrieve is not supported by the Anthropic client yet.
+func (cli *Client) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequest) (*llm.BatchResponse, error) {
+	return nil, llm.ErrNotImplemented
+}
+
+// BatchCancel is not supported by the Anthropic client yet.
+func (cli *Client) BatchCancel(ctx context.Context, req *llm.BatchCancelRequest) error {
+	return llm.ErrNotImplemented
+}
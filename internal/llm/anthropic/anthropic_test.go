@@ -0,0 +1,434 @@
+package anthropic_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/llm/anthropic"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/stretchr/testify/require"
+)
+
+const testModel = "claude-3-5-haiku-latest"
+
+func TestAnthropic(t *testing.T) {
+	key := os.Getenv("ANTHROPIC_API_KEY")
+	if key == "" {
+		t.Skip("ANTHROPIC_API_KEY not found, skip test")
+	}
+
+	cli, err := anthropic.Anthropic(context.Background(),
+		anthropic.WithAPIKey(key),
+		anthropic.WithModel(anthropic.NewAnthropicModel(llm.ModelGenerate, testModel)),
+		anthropic.WithDefaultGenerate(testModel),
+		anthropic.WithTimeout(30*time.Second),
+	)
+	if err != nil {
+		t.Skipf("could not connect to anthropic, skip test: %v", err)
+	}
+	require.NotNil(t, cli)
+
+	t.Run("Generate", func(t *testing.T) {
+		resp, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{
+				llm.NewTextMessage(llm.RoleSystem, "You always answer within 50 words."),
+				llm.NewTextMessage(llm.RoleUser, "Please introduce yourself."),
+			},
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, resp.Outputs[0])
+		require.Equal(t, llm.FinishReasonStop, resp.FinishReason)
+		t.Log(resp.Outputs[0])
+	})
+
+	t.Run("Structured", func(t *testing.T) {
+		resp, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{
+				llm.NewTextMessage(llm.RoleUser, "Where is the capital of Taiwan?"),
+			},
+			Schema: &llm.ResponseSchema{Name: "city", S: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"city": map[string]any{"type": "string"}},
+				"required":   []string{"city"},
+			}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.SchemaErr)
+		t.Log(resp.Outputs[0])
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		ch, err := cli.GenerateStream(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{
+				llm.NewTextMessage(llm.RoleUser, "Count from 1 to 20, separated by spaces."),
+			},
+		})
+		require.NoError(t, err)
+
+		sb := strings.Builder{}
+		for chunk := range ch {
+			require.NoError(t, chunk.Err)
+			sb.WriteString(chunk.Delta)
+		}
+		require.Contains(t, sb.String(), "20")
+	})
+}
+
+// newTestServer serves the models of the API and the messages of handle.
+func newTestServer(t *testing.T, handle http.HandlerFunc) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "my-anthropic-key", r.Header.Get("x-api-key"))
+		require.Equal(t, anthropic.DefaultAPIVersion, r.Header.Get("anthropic-version"))
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.PathValue("model") == "claude-unknown" {
+			w.Header().Set("request-id", "req_011CSHoEeqs5C35K2UUqR7Fy")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"type":"error","error":{"type":"not_found_error","message":"model: claude-unknown"}}`))
+			return
+		}
+		fmt.Fprintf(w, `{"type":"model","id":"%s","display_name":"Claude Haiku 3.5","created_at":"2024-10-22T00:00:00Z"}`,
+			r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1/messages", handle)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestClient(t *testing.T, server *httptest.Server, opts ...anthropic.Option) *anthropic.Client {
+	cli, err := anthropic.Anthropic(context.Background(), append([]anthropic.Option{
+		anthropic.WithAPIKey("my-anthropic-key"),
+		anthropic.WithBaseURL(server.URL),
+		anthropic.WithModel(anthropic.NewAnthropicModel(llm.ModelGenerate, testModel)),
+		anthropic.WithDefaultGenerate(testModel),
+	}, opts...)...)
+	require.NoError(t, err)
+	return cli
+}
+
+func TestAnthropicGenerate(t *testing.T) {
+	var body map[string]any
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_01XFDUDYJgAACzvnptvVoYEL","type":"message","role":"assistant",` +
+			`"model":"claude-3-5-haiku-20241022","content":[{"type":"text","text":"三鶯線預計明年底通車測試。"}],` +
+			`"stop_reason":"end_turn","stop_sequence":null,` +
+			`"usage":{"input_tokens":42,"cache_read_input_tokens":8,"output_tokens":17}}`))
+	})
+	cli := newTestClient(t, server)
+
+	m, ok := cli.DefaultModel(llm.ModelGenerate)
+	require.True(t, ok)
+	require.Equal(t, "Claude Haiku 3.5", m.(anthropic.AnthropicModel).DisplayName)
+
+	resp, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages: []llm.Message{
+			llm.NewTextMessage(llm.RoleSystem, "You are a news editor.", "Answer in Traditional Chinese."),
+			llm.NewTextMessage(llm.RoleUser, "三鶯線何時通車？"),
+			llm.NewMessage(llm.RoleUser, llm.ImagePart{MIME: "image/png", Data: []byte("png")}),
+		},
+		Temperature: utils.Ptr[float32](0.2),
+		Stop:        []string{"END"},
+		User:        "task-5f0c",
+		Config:      map[string]any{"top_k": 40},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"三鶯線預計明年底通車測試。"}, resp.Outputs)
+	require.Equal(t, llm.FinishReasonStop, resp.FinishReason)
+	require.Equal(t, llm.Usage{PromptTokens: 50, CompletionTokens: 17, TotalTokens: 67}, resp.Usage)
+
+	require.Equal(t, testModel, body["model"])
+	require.Equal(t, "You are a news editor.\n\nAnswer in Traditional Chinese.", body["system"])
+	require.EqualValues(t, anthropic.DefaultMaxTokens, body["max_tokens"])
+	require.InDelta(t, 0.2, body["temperature"], 1e-6)
+	require.Equal(t, []any{"END"}, body["stop_sequences"])
+	require.EqualValues(t, 40, body["top_k"])
+	require.Equal(t, map[string]any{"user_id": "task-5f0c"}, body["metadata"])
+	require.Equal(t, []any{
+		map[string]any{"role": "user", "content": []any{
+			map[string]any{"type": "text", "text": "三鶯線何時通車？"},
+		}},
+		map[string]any{"role": "user", "content": []any{
+			map[string]any{"type": "image", "source": map[string]any{
+				"type": "base64", "media_type": "image/png", "data": "cG5n",
+			}},
+		}},
+	}, body["messages"])
+	require.NotContains(t, body, "tools")
+
+	t.Run("max tokens", func(t *testing.T) {
+		cli := newTestClient(t, server, anthropic.WithMaxTokens(1024))
+		_, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hi")},
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, 1024, body["max_tokens"])
+
+		_, err = cli.Generate(context.Background(), &llm.GenerateRequest{
+			Messages:  []llm.Message{llm.NewTextMessage(llm.RoleUser, "hi")},
+			MaxTokens: utils.Ptr(64),
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, 64, body["max_tokens"])
+
+		_, err = anthropic.Anthropic(context.Background(), anthropic.WithMaxTokens(0))
+		require.Error(t, err)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		_, err := cli.Generate(context.Background(), nil)
+		require.ErrorIs(t, err, llm.ErrRequestShouldNotBeNull)
+
+		_, err = cli.Generate(context.Background(), &llm.GenerateRequest{})
+		require.ErrorIs(t, err, llm.ErrNoInput)
+
+		_, err = cli.Generate(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{llm.NewTextMessage(llm.RoleSystem, "You are a news editor.")},
+		})
+		require.ErrorIs(t, err, llm.ErrNoInput)
+
+		_, err = cli.Generate(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hi")},
+			Config:   struct{}{},
+		})
+		require.ErrorIs(t, err, anthropic.ErrInvalidConfigType)
+
+		_, err = cli.Generate(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hi")},
+			Tools:    []llm.ToolDefinition{{Name: "lookup_party_positions"}},
+		})
+		require.ErrorIs(t, err, llm.ErrNotImplemented)
+	})
+}
+
+func TestAnthropicStructuredOutput(t *testing.T) {
+	var body map[string]any
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_014p7gG3wDgGV9EUtLvnow3U","type":"message","role":"assistant",` +
+			`"model":"claude-3-5-haiku-20241022","content":[{"type":"tool_use","id":"toolu_01A09q90qw90lq917835lq9",` +
+			`"name":"stance","input":{"stance":"support","confidence":0.8}}],` +
+			`"stop_reason":"tool_use","usage":{"input_tokens":120,"output_tokens":30}}`))
+	})
+	cli := newTestClient(t, server)
+
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"stance":     map[string]any{"type": "string", "enum": []any{"support", "oppose", "neutral"}},
+			"confidence": map[string]any{"type": "number"},
+		},
+		"required": []any{"stance", "confidence"},
+	}
+	resp, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "What is the stance of the article?")},
+		Schema:   &llm.ResponseSchema{Name: "stance", Description: "The stance of the article.", S: schema},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"stance":"support","confidence":0.8}`, resp.Outputs[0])
+	require.NoError(t, resp.SchemaErr)
+	require.Equal(t, llm.FinishReasonStop, resp.FinishReason)
+
+	require.Equal(t, []any{map[string]any{
+		"name":         "stance",
+		"description":  "The stance of the article.",
+		"input_schema": schema,
+	}}, body["tools"])
+	require.Equal(t, map[string]any{"type": "tool", "name": "stance"}, body["tool_choice"])
+
+	t.Run("invalid tool name", func(t *testing.T) {
+		_, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "What is the stance of the article?")},
+			Schema:   &llm.ResponseSchema{Name: "stance of the article", S: schema},
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"type": "tool", "name": "structured_output"}, body["tool_choice"])
+	})
+}
+
+func TestAnthropicGenerateStream(t *testing.T) {
+	events := []string{
+		`event: message_start
+data: {"type":"message_start","message":{"id":"msg_1nZdL29xx5MUA1yADyHTEsnR8uuvGzszyY","type":"message","role":"assistant","content":[],"model":"claude-3-5-haiku-20241022","stop_reason":null,"usage":{"input_tokens":25,"output_tokens":1}}}`,
+		`event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`event: ping
+data: {"type": "ping"}`,
+		`event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"!"}}`,
+		`event: content_block_stop
+data: {"type":"content_block_stop","index":0}`,
+		`event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"max_tokens","stop_sequence":null},"usage":{"output_tokens":15}}`,
+		`event: message_stop
+data: {"type":"message_stop"}`,
+	}
+
+	var body map[string]any
+	var n atomic.Int32
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "text/event-stream")
+		sent := events
+		switch n.Add(1) {
+		case 2:
+			// the connection drops before the end of the message
+			sent = events[:4]
+		case 3:
+			sent = append(events[:2:2], `event: error
+data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+		}
+		for _, event := range sent {
+			io.WriteString(w, event+"\n\n")
+		}
+	})
+	cli := newTestClient(t, server)
+
+	collect := func(t *testing.T) []llm.GenerateChunk {
+		ch, err := cli.GenerateStream(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "Say hello.")},
+		})
+		require.NoError(t, err)
+		var chunks []llm.GenerateChunk
+		for chunk := range ch {
+			chunks = append(chunks, chunk)
+		}
+		return chunks
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		chunks := collect(t)
+		require.Equal(t, true, body["stream"])
+		require.Len(t, chunks, 3)
+		require.Equal(t, "Hello", chunks[0].Delta)
+		require.Equal(t, "!", chunks[1].Delta)
+
+		end := chunks[2]
+		require.True(t, end.Done)
+		require.NoError(t, end.Err)
+		require.Equal(t, llm.FinishReasonLength, end.FinishReason)
+		require.Equal(t, llm.Usage{PromptTokens: 25, CompletionTokens: 15, TotalTokens: 40}, end.Usage)
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		chunks := collect(t)
+		require.Len(t, chunks, 2)
+		require.ErrorIs(t, chunks[1].Err, anthropic.ErrIncompleteResponse)
+	})
+
+	t.Run("error event", func(t *testing.T) {
+		chunks := collect(t)
+		require.Len(t, chunks, 1)
+
+		var apiErr *anthropic.APIError
+		require.ErrorAs(t, chunks[0].Err, &apiErr)
+		require.Equal(t, "overloaded_error", apiErr.Type)
+		require.True(t, anthropic.IsTransient(chunks[0].Err))
+	})
+}
+
+func TestAnthropicErrors(t *testing.T) {
+	var n atomic.Int32
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch n.Add(1) {
+		case 1:
+			w.WriteHeader(529)
+			w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+		case 2:
+			w.Write([]byte(`{"id":"msg_01","type":"message","role":"assistant","model":"claude-3-5-haiku-20241022",` +
+				`"content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`))
+		case 3:
+			w.Write([]byte(`{"id":"msg_02","type":"message","role":"assistant","model":"claude-3-5-haiku-20241022",` +
+				`"content":[],"stop_reason":"refusal","usage":{"input_tokens":3,"output_tokens":0}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error",` +
+				`"message":"max_tokens: 100000 > 8192, which is the maximum allowed number of output tokens"},` +
+				`"request_id":"req_011CSHoEeqs5C35K2UUqR7Fy"}`))
+		}
+	})
+	cli := newTestClient(t, server, anthropic.WithRetryPolicy(llm.RetryPolicy{
+		MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond,
+	}))
+	req := &llm.GenerateRequest{Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hi")}}
+
+	t.Run("overloaded is retried", func(t *testing.T) {
+		resp, err := cli.Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, []string{"ok"}, resp.Outputs)
+		require.EqualValues(t, 2, n.Load())
+	})
+
+	t.Run("refusal", func(t *testing.T) {
+		_, err := cli.Generate(context.Background(), req)
+		require.ErrorIs(t, err, llm.ErrContentBlocked)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		_, err := cli.Generate(context.Background(), req)
+		var apiErr *anthropic.APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+		require.Equal(t, "invalid_request_error", apiErr.Type)
+		require.Equal(t, "req_011CSHoEeqs5C35K2UUqR7Fy", apiErr.RequestID)
+		require.False(t, anthropic.IsTransient(err))
+		require.EqualValues(t, 4, n.Load())
+	})
+
+	t.Run("model not found", func(t *testing.T) {
+		_, err := anthropic.Anthropic(context.Background(),
+			anthropic.WithAPIKey("my-anthropic-key"),
+			anthropic.WithBaseURL(server.URL),
+			anthropic.WithModel(anthropic.NewAnthropicModel(llm.ModelGenerate, "claude-unknown")),
+			anthropic.WithDefaultGenerate("claude-unknown"),
+		)
+		require.ErrorIs(t, err, anthropic.ErrModelNotFound)
+		require.ErrorContains(t, err, "req_011CSHoEeqs5C35K2UUqR7Fy")
+	})
+
+	t.Run("embed model", func(t *testing.T) {
+		_, err := anthropic.Anthropic(context.Background(),
+			anthropic.WithAPIKey("my-anthropic-key"),
+			anthropic.WithBaseURL(server.URL),
+			anthropic.WithModel(anthropic.NewAnthropicModel(llm.ModelEmbed, testModel)),
+		)
+		require.ErrorIs(t, err, llm.ErrNotImplemented)
+	})
+
+	t.Run("missing api key", func(t *testing.T) {
+		_, err := anthropic.Anthropic(context.Background(), anthropic.WithBaseURL(server.URL))
+		require.ErrorIs(t, err, anthropic.ErrAPIKeyMissing)
+	})
+
+	t.Run("not implemented", func(t *testing.T) {
+		_, err := cli.Embed(context.Background(), &llm.EmbedRequest{
+			Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("三鶯線")},
+		})
+		require.ErrorIs(t, err, llm.ErrNotImplemented)
+
+		_, err = cli.BatchCreate(context.Background(), &llm.BatchRequest{})
+		require.ErrorIs(t, err, llm.ErrNotImplemented)
+
+		_, err = cli.BatchRetrieve(context.Background(), &llm.BatchRetrieveRequest{})
+		require.ErrorIs(t, err, llm.ErrNotImplemented)
+
+		require.ErrorIs(t, cli.BatchCancel(context.Background(), &llm.BatchCancelRequest{}), llm.ErrNotImplemented)
+	})
+}
@@ -0,0 +1,41 @@
+package anthropic
+
+import (
+	"context"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+)
+
+func init() {
+	llm.RegisterProvider("anthropic", func(ctx context.Context, cfg global.LLMConfig) (llm.LLM, error) {
+		return NewFromConfig(ctx, cfg.Anthropic)
+	})
+}
+
+// NewFromConfig creates a client from its configuration.
+func NewFromConfig(ctx context.Context, cfg global.AnthropicConfig) (*Client, error) {
+	key, err := cfg.ReadAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []Option{
+		WithAPIKey(key),
+		WithModel(NewAnthropicModel(llm.ModelGenerate, cfg.Model)),
+		WithDefaultGenerate(cfg.Model),
+	}
+	if cfg.BaseURL != "" {
+		opts = append(opts, WithBaseURL(cfg.BaseURL))
+	}
+	if cfg.Proxy != "" {
+		opts = append(opts, WithProxy(cfg.Proxy))
+	}
+	if cfg.Timeout > 0 {
+		opts = append(opts, WithTimeout(cfg.Timeout))
+	}
+	if cfg.MaxTokens > 0 {
+		opts = append(opts, WithMaxTokens(cfg.MaxTokens))
+	}
+	return Anthropic(ctx, opts...)
+}
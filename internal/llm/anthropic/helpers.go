@@ -0,0 +1,326 @@
+package anthropic
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+)
+
+var (
+	ErrMassageConvertFailed = errors.New("could not convert messages to Anthropic messages")
+	ErrInvalidConfigType    = errors.New("invalid config type")
+)
+
+// The stop reasons of a message.
+const (
+	StopReasonEndTurn      = "end_turn"
+	StopReasonMaxTokens    = "max_tokens"
+	StopReasonStopSequence = "stop_sequence"
+	StopReasonToolUse      = "tool_use"
+	StopReasonRefusal      = "refusal"
+)
+
+// structuredTool is the name of the tool a structured output is forced
+// through, for a schema whose name is not a valid tool name.
+const structuredTool = "structured_output"
+
+// toolNameRe matches the names the messages API accepts for a tool.
+var toolNameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// messagesRequest is the body of a request to /v1/messages.
+type messagesRequest struct {
+	Model         string      `json:"model"`
+	Messages      []message   `json:"messages"`
+	System        string      `json:"system,omitempty"`
+	MaxTokens     int         `json:"max_tokens"`
+	Temperature   *float32    `json:"temperature,omitempty"`
+	TopP          *float32    `json:"top_p,omitempty"`
+	StopSequences []string    `json:"stop_sequences,omitempty"`
+	Stream        bool        `json:"stream,omitempty"`
+	Tools         []tool      `json:"tools,omitempty"`
+	ToolChoice    *toolChoice `json:"tool_choice,omitempty"`
+	Metadata      *metadata   `json:"metadata,omitempty"`
+
+	// extra are the fields of the config of the request, overriding the ones
+	// above.
+	extra map[string]any
+}
+
+func (r messagesRequest) MarshalJSON() ([]byte, error) {
+	type Alias messagesRequest
+	data, err := json.Marshal(Alias(r))
+	if err != nil || len(r.extra) == 0 {
+		return data, err
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	maps.Copy(fields, r.extra)
+	return json.Marshal(fields)
+}
+
+type message struct {
+	Role    string         `json:"role"`
+	Content []contentBlock `json:"content"`
+}
+
+// contentBlock is a block of the content of a message, a text, an image or a
+// tool use, depending on its Type.
+type contentBlock struct {
+	Type   string          `json:"type"`
+	Text   string          `json:"text,omitempty"`
+	Source *imageSource    `json:"source,omitempty"`
+	ID     string          `json:"id,omitempty"`
+	Name   string          `json:"name,omitempty"`
+	Input  json.RawMessage `json:"input,omitempty"`
+}
+
+type imageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type tool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type toolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type metadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+// messagesResponse is the response of /v1/messages, the message of the model.
+type messagesResponse struct {
+	ID           string         `json:"id"`
+	Type         string         `json:"type"`
+	Role         string         `json:"role"`
+	Model        string         `json:"model"`
+	Content      []contentBlock `json:"content"`
+	StopReason   string         `json:"stop_reason"`
+	StopSequence string         `json:"stop_sequence,omitempty"`
+	Usage        usage          `json:"usage"`
+}
+
+type usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// toUsage converts u to an llm.Usage, the tokens written to and read from the
+// prompt cache counting as prompt tokens.
+func (u usage) toUsage() llm.Usage {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	return llm.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+	}
+}
+
+// modelInfo is the response of /v1/models/{model_id}.
+type modelInfo struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// toMessages converts msgs to the messages and the system prompt of a request.
+// The system messages are left out of the messages, their texts are the
+// system prompt, concatenated in order.
+func toMessages(msgs []llm.Message) ([]message, string, error) {
+	var system []string
+	messages := make([]message, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Role == llm.RoleSystem {
+			for _, part := range msg.ContentParts() {
+				p, ok := part.(llm.TextPart)
+				if !ok {
+					return nil, "", fmt.Errorf("%w: unsupported part in system message: %T", ErrMassageConvertFailed, part)
+				}
+				system = append(system, p.Text)
+			}
+			continue
+		}
+
+		var role string
+		switch msg.Role {
+		case llm.RoleUser:
+			role = "user"
+		case llm.RoleAssistant:
+			role = "assistant"
+		default:
+			return nil, "", fmt.Errorf("%w: unsupported role: %s", ErrMassageConvertFailed, msg.Role)
+		}
+
+		parts := msg.ContentParts()
+		blocks := make([]contentBlock, len(parts))
+		for i, part := range parts {
+			switch p := part.(type) {
+			case llm.TextPart:
+				blocks[i] = contentBlock{Type: "text", Text: p.Text}
+			case llm.ImagePart:
+				if err := p.Validate(); err != nil {
+					return nil, "", fmt.Errorf("%w: %w", ErrMassageConvertFailed, err)
+				}
+				if p.URL != "" {
+					blocks[i] = contentBlock{Type: "image", Source: &imageSource{Type: "url", URL: p.URL}}
+				} else {
+					blocks[i] = contentBlock{Type: "image", Source: &imageSource{
+						Type:      "base64",
+						MediaType: p.MIME,
+						Data:      base64.StdEncoding.EncodeToString(p.Data),
+					}}
+				}
+			default:
+				return nil, "", fmt.Errorf("%w: unsupported part: %T", ErrMassageConvertFailed, part)
+			}
+		}
+		messages = append(messages, message{Role: role, Content: blocks})
+	}
+	if len(messages) == 0 {
+		return nil, "", fmt.Errorf("%w: no user or assistant message", llm.ErrNoInput)
+	}
+	return messages, strings.Join(system, "\n\n"), nil
+}
+
+// toConfig performs a type assertion of the config of a request, the fields
+// merged into the body of the request, e.g. {"top_k": 40}.
+func toConfig(conf any) (map[string]any, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	config, ok := conf.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T, expected %T", ErrInvalidConfigType, conf, *new(map[string]any))
+	}
+	return config, nil
+}
+
+// structuredOutputTool returns the tool the output of schema is forced
+// through, taking the output as its input.
+func structuredOutputTool(schema *llm.ResponseSchema) tool {
+	t := tool{
+		Name:        structuredTool,
+		Description: schema.Description,
+		InputSchema: schema.S,
+	}
+	if toolNameRe.MatchString(schema.Name) {
+		t.Name = schema.Name
+	}
+	if t.Description == "" {
+		t.Description = "Respond with the structured output, as the input of this tool."
+	}
+	if t.InputSchema == nil {
+		t.InputSchema = map[string]any{"type": "object"}
+	}
+	return t
+}
+
+// toMessagesRequest converts the generation parameters and the messages of
+// req to the body of a request to the model of modelName, with maxTokens
+// unless req sets MaxTokens. A request with a Schema forces the model to
+// call the tool of structuredOutputTool.
+func toMessagesRequest(modelName string, req *llm.GenerateRequest, maxTokens int) (*messagesRequest, error) {
+	if req.UsesTools() {
+		return nil, fmt.Errorf("%w: tool calls are not supported by the anthropic client", llm.ErrNotImplemented)
+	}
+
+	extra, err := toConfig(req.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, system, err := toMessages(req.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	body := &messagesRequest{
+		Model:         modelName,
+		Messages:      messages,
+		System:        system,
+		MaxTokens:     maxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+		extra:         extra,
+	}
+	if req.MaxTokens != nil {
+		body.MaxTokens = *req.MaxTokens
+	}
+	if req.Seed != nil {
+		global.Logger.Warn().
+			Int64("seed", *req.Seed).
+			Msg("the messages api does not support seeds, dropping it")
+	}
+	if req.User != "" {
+		body.Metadata = &metadata{UserID: req.User}
+	}
+	if req.Schema != nil {
+		t := structuredOutputTool(req.Schema)
+		body.Tools = []tool{t}
+		body.ToolChoice = &toolChoice{Type: "tool", Name: t.Name}
+	}
+	return body, nil
+}
+
+// outputOf returns the output of resp: the input of the tool call of a
+// structured output, or the texts of the content, concatenated.
+func outputOf(resp *messagesResponse, body *messagesRequest) string {
+	if body.ToolChoice != nil {
+		for _, block := range resp.Content {
+			if block.Type == "tool_use" && block.Name == body.ToolChoice.Name {
+				return string(block.Input)
+			}
+		}
+	}
+
+	sb := strings.Builder{}
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			sb.WriteString(block.Text)
+		}
+	}
+	return sb.String()
+}
+
+// finishReason converts the stop reason of a message to one of the
+// llm.FinishReason constants where it has an equivalent. The call of the
+// tool of a structured output is its normal end.
+func finishReason(stopReason string, structured bool) string {
+	switch stopReason {
+	case StopReasonEndTurn, StopReasonStopSequence:
+		return llm.FinishReasonStop
+	case StopReasonMaxTokens:
+		return llm.FinishReasonLength
+	case StopReasonToolUse:
+		if structured {
+			return llm.FinishReasonStop
+		}
+		return llm.FinishReasonToolCalls
+	case StopReasonRefusal:
+		return llm.FinishReasonContentFilter
+	default:
+		return stopReason
+	}
+}
@@ -0,0 +1,36 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+)
+
+type AnthropicModel struct {
+	DisplayName string    `json:"display_name"`
+	CreatedAt   time.Time `json:"created_at"`
+	llm.BaseModel
+}
+
+// NewAnthropicModel creates a new AnthropicModel with the specified model
+// type and name, e.g. claude-sonnet-4-0 or its versioned name.
+func NewAnthropicModel(modelType llm.ModelType, name string) AnthropicModel {
+	return AnthropicModel{
+		BaseModel: llm.NewBaseModel(modelType, name),
+	}
+}
+
+// MarshalJSON customizes the JSON marshaling of AnthropicModel.
+func (model AnthropicModel) MarshalJSON() ([]byte, error) {
+	type Alias AnthropicModel
+	return json.Marshal(&struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Alias
+	}{
+		Name:  model.Name(),
+		Type:  string(model.Type()),
+		Alias: Alias(model),
+	})
+}
@@ -0,0 +1,150 @@
+package anthropic
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+)
+
+var (
+	ErrDuplicatedModel = fmt.Errorf("duplicate model")
+)
+
+type Option func(*builder) error
+
+// WithAPIKey sets the API key for Anthropic authentication.
+func WithAPIKey(apikey string) Option {
+	return func(b *builder) error {
+		b.APIKey = apikey
+		return nil
+	}
+}
+
+// WithBaseURL sets the base URL of the Anthropic API, e.g. of a proxy.
+func WithBaseURL(u string) Option {
+	return func(b *builder) error {
+		if _, err := url.Parse(u); err != nil {
+			return fmt.Errorf("invalid base url: %w", err)
+		}
+		b.BaseURL = u
+		return nil
+	}
+}
+
+// WithAPIVersion sets the anthropic-version header of the requests,
+// DefaultAPIVersion by default.
+func WithAPIVersion(ver string) Option {
+	return func(b *builder) error {
+		b.APIVer = ver
+		return nil
+	}
+}
+
+// WithModel registers one or more Anthropic models with the client.
+func WithModel(models ...AnthropicModel) Option {
+	return func(b *builder) error {
+		for _, model := range models {
+			if _, exists := b.Models[model.Name()]; exists {
+				return fmt.Errorf("%w: %s", ErrDuplicatedModel, model.Name())
+			}
+			b.Models[model.Name()] = model
+		}
+		return nil
+	}
+}
+
+// WithDefaultGenerate sets the default model for text generation.
+func WithDefaultGenerate(name string) Option {
+	return func(b *builder) error {
+		b.DefaultGen = name
+		return nil
+	}
+}
+
+// WithMaxTokens sets the max_tokens of the requests which set no MaxTokens,
+// DefaultMaxTokens by default. The messages API requires one.
+func WithMaxTokens(n int) Option {
+	return func(b *builder) error {
+		if n < 1 {
+			return fmt.Errorf("max tokens should be at least 1, got %d", n)
+		}
+		b.MaxTokens = n
+		return nil
+	}
+}
+
+// WithRetryPolicy sets the policy the generate requests are retried with,
+// llm.DefaultRetryPolicy by default. The errors are classified by IsTransient
+// if its RetryOn is nil.
+func WithRetryPolicy(p llm.RetryPolicy) Option {
+	return func(b *builder) error {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("invalid retry policy: %w", err)
+		}
+		b.RetryPolicy = &p
+		return nil
+	}
+}
+
+// WithRateLimit limits the generate requests of the client to rps per
+// second, with bursts of up to burst requests. The limit is shared by the
+// goroutines using the client.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(b *builder) error {
+		l, err := llm.NewRateLimiter(rps, burst)
+		if err != nil {
+			return fmt.Errorf("invalid rate limit: %w", err)
+		}
+		b.Limiter = l
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout for API requests.
+func WithTimeout(timeout time.Duration) Option {
+	return func(b *builder) error {
+		b.Timeout = &timeout
+		return nil
+	}
+}
+
+// WithHTTPClient sets a custom http.Client for the Anthropic client. The
+// timeout of WithTimeout, if set, replaces its own.
+func WithHTTPClient(c *http.Client) Option {
+	return func(b *builder) error {
+		if c == nil {
+			return fmt.Errorf("http client should not be nil")
+		}
+		b.Client = c
+		return nil
+	}
+}
+
+// WithTransport sends the requests of the client, the validation of the
+// models included, with rt. The http.Client of WithHTTPClient is copied, not
+// modified.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(b *builder) error {
+		if rt == nil {
+			return fmt.Errorf("transport should not be nil")
+		}
+		b.Transport = rt
+		return nil
+	}
+}
+
+// WithProxy sends the requests of the client through the proxy of rawURL, see
+// llm.ProxyTransport. It replaces the transport of WithTransport.
+func WithProxy(rawURL string) Option {
+	return func(b *builder) error {
+		t, err := llm.ProxyTransport(rawURL)
+		if err != nil {
+			return err
+		}
+		b.Transport = t
+		return nil
+	}
+}
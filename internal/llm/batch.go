@@ -0,0 +1,427 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/ChiaYuChang/weathercock/pkgs/retry"
+)
+
+const (
+	// DefaultBatchInputMaxBytes is the size limit of a batch input file (OpenAI: 200 MB).
+	DefaultBatchInputMaxBytes int64 = 200 << 20
+	// DefaultBatchInputMaxLines is the request count limit of a batch input file (OpenAI: 50,000).
+	DefaultBatchInputMaxLines int64 = 50_000
+)
+
+var (
+	ErrBatchInputTooLarge = errors.New("batch input too large")
+	ErrBatchInputClosed   = errors.New("batch input has been closed")
+)
+
+// BatchInputTooLargeError reports which limit a batch input would exceed. It
+// wraps ErrBatchInputTooLarge.
+type BatchInputTooLargeError struct {
+	Bytes    int64 // size of the input including the rejected line
+	Lines    int64 // number of lines including the rejected line
+	MaxBytes int64
+	MaxLines int64
+}
+
+func (e *BatchInputTooLargeError) Error() string {
+	if e.MaxLines > 0 && e.Lines > e.MaxLines {
+		return fmt.Sprintf("%s: %d lines exceeds the limit of %d lines",
+			ErrBatchInputTooLarge, e.Lines, e.MaxLines)
+	}
+	return fmt.Sprintf("%s: %d bytes exceeds the limit of %d bytes",
+		ErrBatchInputTooLarge, e.Bytes, e.MaxBytes)
+}
+
+func (e *BatchInputTooLargeError) Unwrap() error {
+	return ErrBatchInputTooLarge
+}
+
+// BatchInputWriter serializes the requests of a batch job into a JSONL file.
+// It tracks the size and line count of the file and rejects lines exceeding
+// the limits before anything is uploaded. The file is a temporary file owned
+// by the writer unless one is provided with WithBatchInputFile.
+type BatchInputWriter struct {
+	mu       sync.Mutex
+	file     *os.File
+	owned    bool
+	keep     bool
+	closed   bool
+	bytes    int64
+	lines    int64
+	maxBytes int64
+	maxLines int64
+	mirror   io.Writer
+}
+
+type BatchInputOption func(*BatchInputWriter) error
+
+// WithBatchInputFile writes the input to the given file instead of a temporary
+// one. The file is truncated, and it is neither closed nor removed by Close.
+func WithBatchInputFile(f *os.File) BatchInputOption {
+	return func(w *BatchInputWriter) error {
+		if f == nil {
+			return errors.New("batch input file should not be nil")
+		}
+
+		if err := f.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate batch input file: %w", err)
+		}
+		w.file = f
+		w.owned = false
+		return nil
+	}
+}
+
+// WithBatchInputLimits sets the size and line count limits, a non-positive
+// value disables the corresponding limit.
+func WithBatchInputLimits(maxBytes, maxLines int64) BatchInputOption {
+	return func(w *BatchInputWriter) error {
+		w.maxBytes = maxBytes
+		w.maxLines = maxLines
+		return nil
+	}
+}
+
+// WithKeepBatchInput keeps the temporary file after Close, for debugging.
+func WithKeepBatchInput(keep bool) BatchInputOption {
+	return func(w *BatchInputWriter) error {
+		w.keep = keep
+		return nil
+	}
+}
+
+// withBatchInputMirror copies everything written to the input into mirror.
+func withBatchInputMirror(mirror io.Writer) BatchInputOption {
+	return func(w *BatchInputWriter) error {
+		w.mirror = mirror
+		return nil
+	}
+}
+
+// NewBatchInputWriter creates a BatchInputWriter with the default OpenAI limits.
+func NewBatchInputWriter(opts ...BatchInputOption) (*BatchInputWriter, error) {
+	w := &BatchInputWriter{
+		maxBytes: DefaultBatchInputMaxBytes,
+		maxLines: DefaultBatchInputMaxLines,
+	}
+
+	for _, opt := range opts {
+		if err := opt(w); err != nil {
+			return nil, err
+		}
+	}
+
+	if w.file == nil {
+		f, err := os.CreateTemp("", "batch-input-*.jsonl")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create batch input file: %w", err)
+		}
+		w.file = f
+		w.owned = true
+	}
+	return w, nil
+}
+
+// WriteLine marshals v to JSON and appends it to the input as a single line.
+func (w *BatchInputWriter) WriteLine(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch input line: %w", err)
+	}
+	return w.writeLine(append(data, '\n'))
+}
+
+func (w *BatchInputWriter) writeLine(line []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrBatchInputClosed
+	}
+
+	bytes, lines := w.bytes+int64(len(line)), w.lines+1
+	if (w.maxBytes > 0 && bytes > w.maxBytes) || (w.maxLines > 0 && lines > w.maxLines) {
+		return &BatchInputTooLargeError{
+			Bytes:    bytes,
+			Lines:    lines,
+			MaxBytes: w.maxBytes,
+			MaxLines: w.maxLines,
+		}
+	}
+
+	if _, err := w.file.WriteAt(line, w.bytes); err != nil {
+		return fmt.Errorf("failed to write batch input: %w", err)
+	}
+
+	if w.mirror != nil {
+		if _, err := w.mirror.Write(line); err != nil {
+			return fmt.Errorf("failed to write batch input mirror: %w", err)
+		}
+	}
+
+	w.bytes, w.lines = bytes, lines
+	return nil
+}
+
+// Size returns the number of bytes written.
+func (w *BatchInputWriter) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.bytes
+}
+
+// Lines returns the number of lines written.
+func (w *BatchInputWriter) Lines() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lines
+}
+
+// Name returns the path of the underlying file.
+func (w *BatchInputWriter) Name() string {
+	return w.file.Name()
+}
+
+// Reader returns a reader over everything written so far. It always starts at
+// offset zero regardless of how the file has been read or written before.
+func (w *BatchInputWriter) Reader() io.Reader {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return io.NewSectionReader(w.file, 0, w.bytes)
+}
+
+// Close closes and removes the temporary file unless it should be kept. A
+// caller-provided file is left untouched. Close is idempotent.
+func (w *BatchInputWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || !w.owned {
+		w.closed = true
+		return nil
+	}
+	w.closed = true
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close batch input file: %w", err)
+	}
+
+	if w.keep {
+		return nil
+	}
+
+	if err := os.Remove(w.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove batch input file: %w", err)
+	}
+	return nil
+}
+
+// BatchInput returns the writer to serialize the requests of the batch into.
+// If req.Input is nil, a temporary writer with the given options is created;
+// a deprecated req.ReadWriter still receives a copy of the input. The writer
+// should be closed once the input has been uploaded or the upload failed.
+func (req *BatchRequest) BatchInput(opts ...BatchInputOption) (*BatchInputWriter, error) {
+	if req.Input != nil {
+		return req.Input, nil
+	}
+
+	if req.ReadWriter != nil {
+		opts = append(opts, withBatchInputMirror(req.ReadWriter))
+	}
+	return NewBatchInputWriter(opts...)
+}
+
+var ErrBatchItemFailed = errors.New("batch request failed")
+
+// BatchItemError is the failure of a single request of a batch job, e.g. a
+// request not executed because the job was cancelled. It wraps
+// ErrBatchItemFailed.
+type BatchItemError struct {
+	CustomID   string
+	StatusCode int // 0 if the request got no response
+	Code       string
+	Message    string
+}
+
+func (e *BatchItemError) Error() string {
+	return fmt.Sprintf("%s: %s, code: %s (%d), msg: %s",
+		ErrBatchItemFailed, e.CustomID, e.Code, e.StatusCode, e.Message)
+}
+
+func (e *BatchItemError) Unwrap() error {
+	return ErrBatchItemFailed
+}
+
+// BatchItemResult is the result of the request of Index in the Requests of a
+// BatchRequest. Exactly one of Embed, Generate and Err is set.
+type BatchItemResult struct {
+	Index    int               `json:"index"`
+	CustomID string            `json:"custom_id"`
+	Embed    *EmbedResponse    `json:"embed,omitempty"`
+	Generate *GenerateResponse `json:"generate,omitempty"`
+	Err      error             `json:"-"`
+}
+
+const (
+	// DefaultBatchPollMinInterval is the delay before the first poll of a batch job.
+	DefaultBatchPollMinInterval = 5 * time.Second
+	// DefaultBatchPollMaxInterval is the longest delay between two polls.
+	DefaultBatchPollMaxInterval = time.Minute
+	// DefaultBatchWaitDeadline is the completion window of a batch job (OpenAI: 24 hours).
+	DefaultBatchWaitDeadline = 24 * time.Hour
+	// DefaultBatchPollMaxErrors is the number of consecutive failed polls tolerated.
+	DefaultBatchPollMaxErrors = 3
+)
+
+var ErrBatchWaitTimeout = errors.New("timed out waiting for the batch job")
+
+// BatchWaitTimeoutError reports a batch job still not done at the deadline of
+// WaitForBatch. It wraps ErrBatchWaitTimeout.
+type BatchWaitTimeoutError struct {
+	ID     string
+	Status string // last status retrieved, empty if no poll succeeded
+	Polls  int
+	Waited time.Duration
+}
+
+func (e *BatchWaitTimeoutError) Error() string {
+	return fmt.Sprintf("%s: %s is %q after %d polls in %s",
+		ErrBatchWaitTimeout, e.ID, e.Status, e.Polls, e.Waited)
+}
+
+func (e *BatchWaitTimeoutError) Unwrap() error {
+	return ErrBatchWaitTimeout
+}
+
+// batchWaiter polls a batch job until it is done.
+type batchWaiter struct {
+	policy    retry.Policy
+	deadline  time.Duration
+	maxErrors int
+	req       BatchRetrieveRequest
+}
+
+type WaitOption func(*batchWaiter) error
+
+// WithPollInterval sets the delay before the first poll, doubled after each
+// poll up to maxInterval.
+func WithPollInterval(minInterval, maxInterval time.Duration) WaitOption {
+	return func(w *batchWaiter) error {
+		if minInterval <= 0 || maxInterval < minInterval {
+			return fmt.Errorf("poll interval should be positive and min should not exceed max, got %s and %s", minInterval, maxInterval)
+		}
+		w.policy.MinDelay, w.policy.MaxDelay = minInterval, maxInterval
+		return nil
+	}
+}
+
+// WithWaitDeadline sets how long the job is waited for, a non-positive value
+// waits until ctx is done.
+func WithWaitDeadline(d time.Duration) WaitOption {
+	return func(w *batchWaiter) error {
+		w.deadline = d
+		return nil
+	}
+}
+
+// WithPollMaxErrors sets the number of consecutive failed polls tolerated
+// before giving up, 0 gives up on the first one.
+func WithPollMaxErrors(n int) WaitOption {
+	return func(w *batchWaiter) error {
+		if n < 0 {
+			return fmt.Errorf("max poll errors should not be negative, got %d", n)
+		}
+		w.maxErrors = n
+		return nil
+	}
+}
+
+// WithRetrieveConfig sets the provider configs of the BatchRetrieveRequest of
+// each poll.
+func WithRetrieveConfig(statusCheck, retrieve any) WaitOption {
+	return func(w *batchWaiter) error {
+		w.req.StatusCheckConfig, w.req.RetrieveConfig = statusCheck, retrieve
+		return nil
+	}
+}
+
+// WithWaitClock sets the clock the polls are scheduled on.
+func WithWaitClock(clock clockid.Clock) WaitOption {
+	return func(w *batchWaiter) error {
+		if clock == nil {
+			return errors.New("clock should not be nil")
+		}
+		w.policy.Clock = clock
+		return nil
+	}
+}
+
+// WaitForBatch polls the batch job id of cli with an exponential backoff until
+// it is done, and returns its final BatchResponse. It returns a
+// *BatchWaitTimeoutError if the job is not done by the deadline, the error of
+// ctx if ctx is done first, and the error of the last poll once more polls in
+// a row failed than tolerated.
+func WaitForBatch(ctx context.Context, cli LLM, id string, opts ...WaitOption) (*BatchResponse, error) {
+	w := &batchWaiter{
+		policy: retry.Policy{
+			MinDelay: DefaultBatchPollMinInterval,
+			MaxDelay: DefaultBatchPollMaxInterval,
+			Clock:    clockid.Real,
+		},
+		deadline:  DefaultBatchWaitDeadline,
+		maxErrors: DefaultBatchPollMaxErrors,
+	}
+	for _, opt := range opts {
+		if err := opt(w); err != nil {
+			return nil, err
+		}
+	}
+	w.req.ID = id
+
+	clock := w.policy.Clock
+	start := clock.Now()
+	status, errs := "", 0
+	for poll := 1; ; poll++ {
+		resp, err := cli.BatchRetrieve(ctx, &w.req)
+		switch {
+		case err == nil && resp != nil && resp.IsDone:
+			return resp, nil
+		case err != nil:
+			if ctx.Err() != nil {
+				return resp, ctx.Err()
+			}
+			// a done job may fail to be fetched, it is polled again
+			if errs++; errs > w.maxErrors {
+				return resp, fmt.Errorf("failed to retrieve batch %s (%d polls in a row): %w", id, errs, err)
+			}
+		case resp != nil:
+			status, errs = resp.Status, 0
+		}
+
+		delay := w.policy.Delay(poll)
+		if w.deadline > 0 {
+			left := w.deadline - clock.Since(start)
+			if left <= 0 {
+				return resp, &BatchWaitTimeoutError{ID: id, Status: status, Polls: poll, Waited: clock.Since(start)}
+			}
+			// the last poll is made at the deadline
+			delay = min(delay, left)
+		}
+
+		if err := clockid.Sleep(ctx, clock, delay); err != nil {
+			return resp, err
+		}
+	}
+}
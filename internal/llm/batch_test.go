@@ -0,0 +1,236 @@
+package llm_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/e2etest"
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchInputWriter(t *testing.T) {
+	type line struct {
+		CustomID string `json:"custom_id"`
+	}
+
+	t.Run("Reader_Starts_At_Zero", func(t *testing.T) {
+		w, err := llm.NewBatchInputWriter()
+		require.NoError(t, err)
+		defer w.Close()
+
+		require.NoError(t, w.WriteLine(line{CustomID: "a"}))
+		require.NoError(t, w.WriteLine(line{CustomID: "b"}))
+		require.Equal(t, int64(2), w.Lines())
+
+		for range 2 {
+			data, err := io.ReadAll(w.Reader())
+			require.NoError(t, err)
+			require.Equal(t, "{\"custom_id\":\"a\"}\n{\"custom_id\":\"b\"}\n", string(data))
+			require.Equal(t, int64(len(data)), w.Size())
+		}
+	})
+
+	t.Run("Size_Limit", func(t *testing.T) {
+		w, err := llm.NewBatchInputWriter(llm.WithBatchInputLimits(20, 0))
+		require.NoError(t, err)
+		defer w.Close()
+
+		require.NoError(t, w.WriteLine(line{CustomID: "a"}))
+		err = w.WriteLine(line{CustomID: "b"})
+		require.ErrorIs(t, err, llm.ErrBatchInputTooLarge)
+
+		var tooLarge *llm.BatchInputTooLargeError
+		require.ErrorAs(t, err, &tooLarge)
+		require.Equal(t, int64(20), tooLarge.MaxBytes)
+
+		// the rejected line is not written
+		require.Equal(t, int64(1), w.Lines())
+		data, err := io.ReadAll(w.Reader())
+		require.NoError(t, err)
+		require.Equal(t, "{\"custom_id\":\"a\"}\n", string(data))
+	})
+
+	t.Run("Line_Limit", func(t *testing.T) {
+		w, err := llm.NewBatchInputWriter(llm.WithBatchInputLimits(0, 1))
+		require.NoError(t, err)
+		defer w.Close()
+
+		require.NoError(t, w.WriteLine(line{CustomID: "a"}))
+		require.ErrorIs(t, w.WriteLine(line{CustomID: "b"}), llm.ErrBatchInputTooLarge)
+	})
+
+	t.Run("Close_Removes_Temp_File", func(t *testing.T) {
+		w, err := llm.NewBatchInputWriter()
+		require.NoError(t, err)
+		require.NoError(t, w.WriteLine(line{CustomID: "a"}))
+		require.FileExists(t, w.Name())
+
+		require.NoError(t, w.Close())
+		require.NoFileExists(t, w.Name())
+		require.NoError(t, w.Close())
+		require.ErrorIs(t, w.WriteLine(line{CustomID: "b"}), llm.ErrBatchInputClosed)
+	})
+
+	t.Run("Keep_Temp_File", func(t *testing.T) {
+		w, err := llm.NewBatchInputWriter(llm.WithKeepBatchInput(true))
+		require.NoError(t, err)
+		defer os.Remove(w.Name())
+
+		require.NoError(t, w.WriteLine(line{CustomID: "a"}))
+		require.NoError(t, w.Close())
+		require.FileExists(t, w.Name())
+	})
+
+	t.Run("Caller_Provided_File", func(t *testing.T) {
+		f, err := os.Create(filepath.Join(t.TempDir(), "input.jsonl"))
+		require.NoError(t, err)
+		defer f.Close()
+		_, err = f.WriteString("stale content\n")
+		require.NoError(t, err)
+
+		w, err := llm.NewBatchInputWriter(llm.WithBatchInputFile(f))
+		require.NoError(t, err)
+		require.NoError(t, w.WriteLine(line{CustomID: "a"}))
+		require.NoError(t, w.Close())
+
+		data, err := os.ReadFile(f.Name())
+		require.NoError(t, err)
+		require.Equal(t, "{\"custom_id\":\"a\"}\n", string(data))
+	})
+
+	t.Run("Deprecated_ReadWriter", func(t *testing.T) {
+		// the buffer has been drained by the caller before, the input should
+		// still be read from the start of the writer.
+		buf := bytes.NewBufferString("previous batch\n")
+		_, _ = io.ReadAll(buf)
+
+		req := &llm.BatchRequest{ReadWriter: buf}
+		w, err := req.BatchInput()
+		require.NoError(t, err)
+		defer w.Close()
+
+		require.NoError(t, w.WriteLine(line{CustomID: "a"}))
+		data, err := io.ReadAll(w.Reader())
+		require.NoError(t, err)
+		require.Equal(t, "{\"custom_id\":\"a\"}\n", string(data))
+		require.Equal(t, string(data), buf.String())
+	})
+}
+
+// batchLLM answers the polls of a batch job with its script, repeating the
+// last answer once the script is over.
+type batchLLM struct {
+	*e2etest.FakeLLM
+	mu     sync.Mutex
+	script []batchPoll
+	polls  int
+}
+
+type batchPoll struct {
+	status string
+	done   bool
+	err    error
+}
+
+func (b *batchLLM) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequest) (*llm.BatchResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := b.script[min(b.polls, len(b.script)-1)]
+	b.polls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &llm.BatchResponse{ID: req.ID, Status: p.status, IsDone: p.done}, nil
+}
+
+func TestWaitForBatch(t *testing.T) {
+	fast := []llm.WaitOption{llm.WithPollInterval(time.Millisecond, 4*time.Millisecond)}
+	errFlaky := errors.New("flaky network")
+
+	t.Run("Done", func(t *testing.T) {
+		cli := &batchLLM{FakeLLM: e2etest.NewFakeLLM(""), script: []batchPoll{
+			{status: "validating"}, {status: "in_progress"}, {status: "completed", done: true},
+		}}
+		resp, err := llm.WaitForBatch(context.Background(), cli, "batch_1", fast...)
+		require.NoError(t, err)
+		require.Equal(t, "batch_1", resp.ID)
+		require.Equal(t, "completed", resp.Status)
+		require.Equal(t, 3, cli.polls)
+	})
+
+	t.Run("Tolerated_Errors", func(t *testing.T) {
+		cli := &batchLLM{FakeLLM: e2etest.NewFakeLLM(""), script: []batchPoll{
+			{status: "in_progress"}, {err: errFlaky}, {err: errFlaky}, {status: "completed", done: true},
+		}}
+		resp, err := llm.WaitForBatch(context.Background(), cli, "batch_1",
+			append(fast, llm.WithPollMaxErrors(2))...)
+		require.NoError(t, err)
+		require.Equal(t, "completed", resp.Status)
+	})
+
+	t.Run("Too_Many_Errors", func(t *testing.T) {
+		cli := &batchLLM{FakeLLM: e2etest.NewFakeLLM(""), script: []batchPoll{
+			{status: "in_progress"}, {err: errFlaky},
+		}}
+		_, err := llm.WaitForBatch(context.Background(), cli, "batch_1",
+			append(fast, llm.WithPollMaxErrors(2))...)
+		require.ErrorIs(t, err, errFlaky)
+		require.Equal(t, 4, cli.polls)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		cli := &batchLLM{FakeLLM: e2etest.NewFakeLLM(""), script: []batchPoll{{status: "in_progress"}}}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := llm.WaitForBatch(ctx, cli, "batch_1", append(fast, llm.WithWaitDeadline(0))...)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+		clock := clockid.NewFake(start)
+		go func() {
+			// the backoff is cut short by the deadline before the last poll
+			for _, d := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 3 * time.Second} {
+				clock.BlockUntil(1)
+				clock.Advance(d)
+			}
+		}()
+
+		cli := &batchLLM{FakeLLM: e2etest.NewFakeLLM(""), script: []batchPoll{{status: "in_progress"}}}
+		// a delay longer than expected is never advanced past, ctx fails it
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := llm.WaitForBatch(ctx, cli, "batch_1",
+			llm.WithPollInterval(time.Second, 4*time.Second),
+			llm.WithWaitDeadline(10*time.Second),
+			llm.WithWaitClock(clock))
+		require.ErrorIs(t, err, llm.ErrBatchWaitTimeout)
+
+		var timeout *llm.BatchWaitTimeoutError
+		require.ErrorAs(t, err, &timeout)
+		require.Equal(t, "batch_1", timeout.ID)
+		require.Equal(t, "in_progress", timeout.Status)
+		require.Equal(t, 5, timeout.Polls)
+		require.Equal(t, 10*time.Second, timeout.Waited)
+	})
+
+	t.Run("Invalid_Options", func(t *testing.T) {
+		cli := &batchLLM{FakeLLM: e2etest.NewFakeLLM("")}
+		_, err := llm.WaitForBatch(context.Background(), cli, "batch_1",
+			llm.WithPollInterval(time.Minute, time.Second))
+		require.Error(t, err)
+		_, err = llm.WaitForBatch(context.Background(), cli, "batch_1", llm.WithPollMaxErrors(-1))
+		require.Error(t, err)
+		require.Zero(t, cli.polls)
+	})
+}
@@ -0,0 +1,278 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultEmbedCacheTTL is how long a cached embedding is kept.
+const DefaultEmbedCacheTTL = 7 * 24 * time.Hour
+
+// Cache stores values by key for a while. It is implemented by ValkeyCache and
+// MemoryCache.
+type Cache interface {
+	// Get returns the value of key, ok is false if there is none.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set stores value under key for ttl, forever if ttl is zero.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// ValkeyCache is a Cache in Valkey.
+type ValkeyCache struct {
+	client *redis.Client
+}
+
+func NewValkeyCache(client *redis.Client) *ValkeyCache {
+	return &ValkeyCache{client: client}
+}
+
+func (c *ValkeyCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	val, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cached value: %w", err)
+	}
+	return val, true, nil
+}
+
+func (c *ValkeyCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := c.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to cache value: %w", err)
+	}
+	return nil
+}
+
+// MemoryCache is a Cache in memory, for tests and single process tools.
+// Expired values are dropped when they are read.
+type MemoryCache struct {
+	mu     sync.Mutex
+	clock  clockid.Clock
+	values map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache creates a MemoryCache expiring the values on clock, the real
+// one if nil.
+func NewMemoryCache(clock clockid.Clock) *MemoryCache {
+	if clock == nil {
+		clock = clockid.Real
+	}
+	return &MemoryCache{clock: clock, values: map[string]memoryCacheEntry{}}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.values[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !e.expires.IsZero() && !c.clock.Now().Before(e.expires) {
+		delete(c.values, key)
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := memoryCacheEntry{value: value}
+	if ttl > 0 {
+		e.expires = c.clock.Now().Add(ttl)
+	}
+	c.values[key] = e
+	return nil
+}
+
+// Len returns the number of values in the cache, the expired ones included.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.values)
+}
+
+// EmbedCacheKey is the cache key of the embedding of input by model, the
+// SHA-256 of both.
+func EmbedCacheKey(model, input string) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(input))
+	return "embed." + hex.EncodeToString(h.Sum(nil))
+}
+
+// CachedEmbedder is an LLM answering Embed from a cache for the inputs it has
+// embedded before, and from the wrapped LLM for the others. The inputs are
+// told apart by their model, Dimensions, TaskType and text only, so a client whose
+// request Config changes the vectors should not share a cache with one whose
+// does not.
+type CachedEmbedder struct {
+	LLM
+	cache Cache
+	ttl   time.Duration
+}
+
+// NewCachedEmbedder wraps client with cache, keeping the embeddings for ttl,
+// DefaultEmbedCacheTTL if zero.
+func NewCachedEmbedder(client LLM, cache Cache, ttl time.Duration) *CachedEmbedder {
+	if ttl == 0 {
+		ttl = DefaultEmbedCacheTTL
+	}
+	return &CachedEmbedder{LLM: client, cache: cache, ttl: ttl}
+}
+
+// Embed returns an embedding per input of req in the same order. Only the
+// inputs missing from the cache are sent to the wrapped LLM, each once, and
+// the Usage of the response is theirs. A failing cache is logged and treated
+// as empty.
+func (e *CachedEmbedder) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	if req == nil {
+		return nil, ErrRequestShouldNotBeNull
+	}
+	if len(req.Inputs) == 0 {
+		return nil, ErrNoInput
+	}
+
+	model := req.ModelName
+	if model == "" {
+		if m, ok := e.DefaultModel(ModelEmbed); ok {
+			model = m.Name()
+		}
+	}
+	// the embeddings truncated to other dimensions, or for other tasks, are
+	// kept apart
+	keyModel := model
+	if req.Dimensions != nil {
+		keyModel = fmt.Sprintf("%s@%d", model, *req.Dimensions)
+	}
+	if req.TaskType != "" {
+		keyModel = fmt.Sprintf("%s#%s", keyModel, req.TaskType)
+	}
+
+	embeddings := make([]Embedding, len(req.Inputs))
+	keys := make([]string, len(req.Inputs))
+	// missing maps the key of an input to embed to the indices it fills
+	missing := map[string][]int{}
+	var inputs []EmbedInput
+	for i, in := range req.Inputs {
+		keys[i] = EmbedCacheKey(keyModel, in.String())
+		if idx, ok := missing[keys[i]]; ok {
+			missing[keys[i]] = append(idx, i)
+			continue
+		}
+
+		if values, ok := e.get(ctx, keys[i]); ok {
+			embeddings[i] = Embedding{State: EmbedStateOk, Values: values}
+			continue
+		}
+		missing[keys[i]] = []int{i}
+		inputs = append(inputs, in)
+	}
+
+	if len(inputs) == 0 {
+		return &EmbedResponse{Model: model, Embeddings: embeddings}, nil
+	}
+
+	resp, err := e.LLM.Embed(ctx, &EmbedRequest{
+		Inputs:     inputs,
+		ModelName:  req.ModelName,
+		Dimensions: req.Dimensions,
+		TaskType:   req.TaskType,
+		User:       req.User,
+		Config:     req.Config,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("%w: %d embeddings for %d inputs",
+			ErrEmbedFailed, len(resp.Embeddings), len(inputs))
+	}
+
+	for j, emb := range resp.Embeddings {
+		key := EmbedCacheKey(keyModel, inputs[j].String())
+		for _, i := range missing[key] {
+			embeddings[i] = emb
+		}
+		if emb.State == EmbedStateOk {
+			e.set(ctx, key, emb.Values)
+		}
+	}
+
+	resp.Embeddings = embeddings
+	if resp.Model == "" {
+		resp.Model = model
+	}
+	return resp, nil
+}
+
+func (e *CachedEmbedder) get(ctx context.Context, key string) ([]float32, bool) {
+	b, ok, err := e.cache.Get(ctx, key)
+	if err != nil {
+		global.Logger.Warn().
+			Err(err).
+			Str("key", key).
+			Msg("failed to read cached embedding")
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	values, err := decodeEmbedding(b)
+	if err != nil {
+		global.Logger.Warn().
+			Err(err).
+			Str("key", key).
+			Msg("malformed cached embedding")
+		return nil, false
+	}
+	return values, true
+}
+
+func (e *CachedEmbedder) set(ctx context.Context, key string, values []float32) {
+	if err := e.cache.Set(ctx, key, encodeEmbedding(values), e.ttl); err != nil {
+		global.Logger.Warn().
+			Err(err).
+			Str("key", key).
+			Msg("failed to cache embedding")
+	}
+}
+
+// encodeEmbedding encodes values as little-endian float32s.
+func encodeEmbedding(values []float32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(v))
+	}
+	return b
+}
+
+func decodeEmbedding(b []byte) ([]float32, error) {
+	if len(b) == 0 || len(b)%4 != 0 {
+		return nil, fmt.Errorf("embedding of %d bytes", len(b))
+	}
+	values := make([]float32, len(b)/4)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return values, nil
+}
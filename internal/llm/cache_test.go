@@ -0,0 +1,212 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/llm/llmtest"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/stretchr/testify/require"
+)
+
+// failingCache is a Cache which is down.
+type failingCache struct{}
+
+func (failingCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func (failingCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func embedRequest(texts ...string) *llm.EmbedRequest {
+	inputs := make([]llm.EmbedInput, len(texts))
+	for i, text := range texts {
+		inputs[i] = llm.NewSimpleTextInput(text)
+	}
+	return &llm.EmbedRequest{Inputs: inputs, ModelName: "nomic-embed-text"}
+}
+
+func embedResponse(vectors ...[]float32) *llm.EmbedResponse {
+	embeddings := make([]llm.Embedding, len(vectors))
+	for i, v := range vectors {
+		embeddings[i] = llm.Embedding{Values: v}
+	}
+	return &llm.EmbedResponse{Model: "nomic-embed-text", Embeddings: embeddings}
+}
+
+func vectors(resp *llm.EmbedResponse) [][]float32 {
+	vs := make([][]float32, len(resp.Embeddings))
+	for i, emb := range resp.Embeddings {
+		vs[i] = emb.Values
+	}
+	return vs
+}
+
+// inputTexts returns the texts of the inputs of the embed requests of cli.
+func inputTexts(cli *llmtest.MockLLM) [][]string {
+	var texts [][]string
+	for _, req := range cli.EmbedRequests() {
+		ts := make([]string, len(req.Inputs))
+		for i, in := range req.Inputs {
+			ts[i] = in.String()
+		}
+		texts = append(texts, ts)
+	}
+	return texts
+}
+
+func TestCachedEmbedder(t *testing.T) {
+	ctx := context.Background()
+	a, b, c := []float32{0.1, 0.2}, []float32{0.3, 0.4}, []float32{-0.5, 0.6}
+
+	t.Run("fills the missing inputs", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().
+			QueueEmbed(embedResponse(a, b), nil).
+			QueueEmbed(embedResponse(c), nil)
+		cache := llm.NewMemoryCache(nil)
+		e := llm.NewCachedEmbedder(cli, cache, 0)
+
+		resp, err := e.Embed(ctx, embedRequest("a", "b"))
+		require.NoError(t, err)
+		require.Len(t, resp.Embeddings, 2)
+		require.Equal(t, 2, cache.Len())
+
+		resp, err = e.Embed(ctx, embedRequest("b", "c", "a", "c"))
+		require.NoError(t, err)
+		require.Equal(t, [][]float32{b, c, a, c}, vectors(resp))
+		require.Equal(t, [][]string{{"a", "b"}, {"c"}}, inputTexts(cli))
+
+		resp, err = e.Embed(ctx, embedRequest("c"))
+		require.NoError(t, err)
+		require.Equal(t, c, resp.Embeddings[0].Values)
+		require.Equal(t, "nomic-embed-text", resp.Model)
+		require.Len(t, cli.EmbedRequests(), 2)
+	})
+
+	t.Run("keyed by model", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().
+			QueueEmbed(embedResponse(a), nil).
+			QueueEmbed(embedResponse(b), nil)
+		e := llm.NewCachedEmbedder(cli, llm.NewMemoryCache(nil), 0)
+
+		_, err := e.Embed(ctx, embedRequest("a"))
+		require.NoError(t, err)
+
+		req := embedRequest("a")
+		req.ModelName = "text-embedding-3-small"
+		resp, err := e.Embed(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, b, resp.Embeddings[0].Values)
+		require.Len(t, cli.EmbedRequests(), 2)
+		require.NotEqual(t, llm.EmbedCacheKey("nomic-embed-text", "a"),
+			llm.EmbedCacheKey("text-embedding-3-small", "a"))
+	})
+
+	t.Run("keyed by dimensions", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().
+			QueueEmbed(embedResponse(a), nil).
+			QueueEmbed(embedResponse(b), nil)
+		e := llm.NewCachedEmbedder(cli, llm.NewMemoryCache(nil), 0)
+
+		_, err := e.Embed(ctx, embedRequest("a"))
+		require.NoError(t, err)
+
+		req := embedRequest("a")
+		dim := 2
+		req.Dimensions = &dim
+		resp, err := e.Embed(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, b, resp.Embeddings[0].Values)
+		require.Equal(t, &dim, cli.EmbedRequests()[1].Dimensions)
+
+		_, err = e.Embed(ctx, req)
+		require.NoError(t, err)
+		require.Len(t, cli.EmbedRequests(), 2)
+	})
+
+	t.Run("keyed by task type", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().
+			QueueEmbed(embedResponse(a), nil).
+			QueueEmbed(embedResponse(b), nil)
+		e := llm.NewCachedEmbedder(cli, llm.NewMemoryCache(nil), 0)
+
+		req := embedRequest("a")
+		req.TaskType = "RETRIEVAL_DOCUMENT"
+		_, err := e.Embed(ctx, req)
+		require.NoError(t, err)
+
+		req.TaskType = "RETRIEVAL_QUERY"
+		resp, err := e.Embed(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, b, resp.Embeddings[0].Values)
+		require.Equal(t, "RETRIEVAL_QUERY", cli.EmbedRequests()[1].TaskType)
+	})
+
+	t.Run("only ok embeddings are cached", func(t *testing.T) {
+		truncated := embedResponse(a, b)
+		truncated.Embeddings[1].State = llm.EmbedStateTruncated
+		cli := llmtest.NewMockLLM().QueueEmbed(truncated, nil)
+		cache := llm.NewMemoryCache(nil)
+		e := llm.NewCachedEmbedder(cli, cache, 0)
+
+		resp, err := e.Embed(ctx, embedRequest("a", "b"))
+		require.NoError(t, err)
+		require.Equal(t, llm.EmbedStateTruncated, resp.Embeddings[1].State)
+		require.Equal(t, 1, cache.Len())
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		clock := clockid.NewFake(time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC))
+		cli := llmtest.NewMockLLM().
+			QueueEmbed(embedResponse(a), nil).
+			QueueEmbed(embedResponse(a), nil)
+		e := llm.NewCachedEmbedder(cli, llm.NewMemoryCache(clock), time.Hour)
+
+		for range 2 {
+			_, err := e.Embed(ctx, embedRequest("a"))
+			require.NoError(t, err)
+		}
+		require.Len(t, cli.EmbedRequests(), 1)
+
+		clock.Advance(time.Hour)
+		_, err := e.Embed(ctx, embedRequest("a"))
+		require.NoError(t, err)
+		require.Len(t, cli.EmbedRequests(), 2)
+	})
+
+	t.Run("cache down", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().
+			QueueEmbed(embedResponse(a), nil).
+			QueueEmbed(embedResponse(a), nil)
+		e := llm.NewCachedEmbedder(cli, failingCache{}, 0)
+
+		for range 2 {
+			resp, err := e.Embed(ctx, embedRequest("a"))
+			require.NoError(t, err)
+			require.Equal(t, a, resp.Embeddings[0].Values)
+		}
+		require.Len(t, cli.EmbedRequests(), 2)
+	})
+
+	t.Run("provider errors", func(t *testing.T) {
+		errDown := errors.New("provider down")
+		cli := llmtest.NewMockLLM().
+			QueueEmbed(nil, errDown).
+			QueueEmbed(embedResponse(a), nil)
+		e := llm.NewCachedEmbedder(cli, llm.NewMemoryCache(nil), 0)
+
+		_, err := e.Embed(ctx, embedRequest("a"))
+		require.ErrorIs(t, err, errDown)
+
+		_, err = e.Embed(ctx, embedRequest("a", "b"))
+		require.ErrorIs(t, err, llm.ErrEmbedFailed)
+
+		_, err = e.Embed(ctx, &llm.EmbedRequest{})
+		require.ErrorIs(t, err, llm.ErrNoInput)
+	})
+}
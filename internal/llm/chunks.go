@@ -2,6 +2,7 @@ package llm
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -51,6 +52,133 @@ func ChunckOffsets(text string, size, overlap int) ([]ChunkOffsets, error) {
 	return offsets, nil
 }
 
+// ChunkSentenceOffsets splits a single text into chunks of at most maxSize
+// runes and returns offsets for each chunk in the text, like ChunckOffsets.
+// The unique content of a chunk is made of whole sentences, see SentenceEnds,
+// as long as they fit in maxSize-overlap runes; a longer sentence is split
+// hard at that length. The overlaps are the overlap/2 runes around the unique
+// content, whatever the sentences.
+func ChunkSentenceOffsets(text string, maxSize, overlap int) ([]ChunkOffsets, error) {
+	if maxSize <= 0 {
+		return nil, ErrChunkSizeTooSmall
+	}
+	if overlap <= 1 || overlap >= maxSize || overlap%2 != 0 {
+		return nil, ErrInvalidChunkOverlap
+	}
+
+	textLen := len([]rune(text))
+	ends := append(SentenceEnds(text), textLen)
+	budget := maxSize - overlap
+
+	var offsets []ChunkOffsets
+	appendChunk := func(uniqueStart, uniqueEnd int) {
+		start := max(0, uniqueStart-overlap/2)
+		end := min(textLen, uniqueEnd+overlap/2)
+		offsets = append(offsets, ChunkOffsets{
+			Start:       int32(start),
+			OffsetLeft:  int32(uniqueStart - start),
+			OffsetRight: int32(uniqueEnd - start),
+			End:         int32(end),
+		})
+	}
+
+	uniqueStart, uniqueEnd := 0, 0
+	for _, end := range ends {
+		if end-uniqueStart <= budget {
+			uniqueEnd = end
+			continue
+		}
+
+		if uniqueEnd > uniqueStart {
+			appendChunk(uniqueStart, uniqueEnd)
+			uniqueStart = uniqueEnd
+		}
+		// the sentence alone exceeds the budget
+		for end-uniqueStart > budget {
+			appendChunk(uniqueStart, uniqueStart+budget)
+			uniqueStart += budget
+		}
+		uniqueEnd = end
+	}
+
+	if uniqueEnd > uniqueStart {
+		appendChunk(uniqueStart, uniqueEnd)
+	}
+	return offsets, nil
+}
+
+// ChunkByTokens splits a single text into chunks of at most maxTokens tokens,
+// as counted by tok, and returns offsets for each chunk in the text, like
+// ChunckOffsets. The offsets are still rune offsets, so ExtractChunk works on
+// them. The unique content of a chunk is the longest run of runes within
+// maxTokens-overlapTokens tokens, and each overlap the longest one within
+// overlapTokens/2. As tokens are counted per part, the chunk may count a
+// token more or less at the joins than the sum of its parts.
+func ChunkByTokens(text string, tok Tokenizer, maxTokens, overlapTokens int) ([]ChunkOffsets, error) {
+	if maxTokens <= 0 {
+		return nil, ErrChunkSizeTooSmall
+	}
+	if overlapTokens <= 1 || overlapTokens >= maxTokens || overlapTokens%2 != 0 {
+		return nil, ErrInvalidChunkOverlap
+	}
+
+	runes := []rune(text)
+	fits := func(start, end, limit int) bool {
+		return tok.CountTokens(string(runes[start:end])) <= limit
+	}
+
+	var offsets []ChunkOffsets
+	budget, half := maxTokens-overlapTokens, overlapTokens/2
+	for uniqueStart := 0; uniqueStart < len(runes); {
+		// a rune is taken even if it alone exceeds the budget
+		uniqueEnd := max(uniqueStart+1, lastFit(uniqueStart, len(runes), func(end int) bool {
+			return fits(uniqueStart, end, budget)
+		}))
+		start := uniqueStart - lastFit(0, uniqueStart, func(n int) bool {
+			return fits(uniqueStart-n, uniqueStart, half)
+		})
+		end := lastFit(uniqueEnd, len(runes), func(end int) bool {
+			return fits(uniqueEnd, end, half)
+		})
+
+		offsets = append(offsets, ChunkOffsets{
+			Start:       int32(start),
+			OffsetLeft:  int32(uniqueStart - start),
+			OffsetRight: int32(uniqueEnd - start),
+			End:         int32(end),
+		})
+		uniqueStart = uniqueEnd
+	}
+	return offsets, nil
+}
+
+// lastFit returns the largest n in [lo, hi] for which fit holds, assuming fit
+// holds for lo and does not hold past the first n it fails for. The bound is
+// first found by doubling the step from lo, so that the cost depends on the
+// result rather than on hi.
+func lastFit(lo, hi int, fit func(n int) bool) int {
+	ok, step := lo, 1
+	for ok < hi {
+		next := min(hi, ok+step)
+		if !fit(next) {
+			hi = next - 1
+			break
+		}
+		ok, step = next, step*2
+	}
+
+	// fit holds for ok and fails past hi
+	for ok < hi {
+		mid := ok + (hi-ok+1)/2
+		if fit(mid) {
+			ok = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return ok
+}
+
 // ChunckParagraphsOffsets splits paragraphs into chunks and returns offsets for each chunk in the full article.
 func ChunckParagraphsOffsets(paragraphs []string, size, overlap int) ([]ChunkOffsets, error) {
 	if size <= 0 {
@@ -98,8 +226,22 @@ func ChunckParagraphsOffsets(paragraphs []string, size, overlap int) ([]ChunkOff
 }
 
 // ExtractChunk extracts the chunk, unique content, and overlaps from the article using offsets.
-func ExtractChunk(article string, offsets ChunkOffsets) (chunk, leftOverlap, unique, rightOverlap string) {
-	runes := []rune(article)
+// It returns ErrChunkOutOfRange if the offsets do not fit in the article, e.g.
+// if they are stale offsets of an article since ingested again with a shorter
+// content.
+func ExtractChunk(article string, offsets ChunkOffsets) (chunk, leftOverlap, unique, rightOverlap string, err error) {
+	return ExtractChunkRunes([]rune(article), offsets)
+}
+
+// ExtractChunkRunes is ExtractChunk on the runes of the article, to extract
+// many chunks of an article without converting it for each.
+func ExtractChunkRunes(runes []rune, offsets ChunkOffsets) (chunk, leftOverlap, unique, rightOverlap string, err error) {
+	if offsets.Start < 0 || offsets.Start > offsets.End || int(offsets.End) > len(runes) ||
+		offsets.OffsetLeft < 0 || offsets.OffsetLeft > offsets.OffsetRight ||
+		offsets.OffsetRight > offsets.End-offsets.Start {
+		return "", "", "", "", fmt.Errorf("%w: %+v in a text of %d runes", ErrChunkOutOfRange, offsets, len(runes))
+	}
+
 	chunk = string(runes[offsets.Start:offsets.End])
 	if offsets.OffsetLeft > 0 {
 		leftOverlap = string(runes[offsets.Start : offsets.Start+offsets.OffsetLeft])
@@ -111,6 +253,76 @@ func ExtractChunk(article string, offsets ChunkOffsets) (chunk, leftOverlap, uni
 	return
 }
 
+// sentenceTerminators are the runes ending a sentence in Chinese and English text.
+var sentenceTerminators = map[rune]bool{
+	'。': true, '！': true, '？': true, '；': true,
+	'!': true, '?': true, ';': true, '\n': true,
+}
+
+// sentenceClosers are the closing quotes and brackets that belong to the
+// sentence they follow, e.g. 「我是受害者。」
+var sentenceClosers = map[rune]bool{
+	'」': true, '』': true, '”': true, '’': true, '）': true, '"': true, '\'': true, ')': true,
+}
+
+// SentenceEnds returns the rune offsets right after each sentence terminator
+// (and its closing quotes) in the text. A '.' only ends a sentence if it is
+// followed by a space or the end of the text, so decimals are not split.
+func SentenceEnds(text string) []int {
+	runes := []rune(text)
+	var ends []int
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		isEnd := sentenceTerminators[r] ||
+			(r == '.' && (i+1 == len(runes) || runes[i+1] == ' '))
+		if !isEnd {
+			continue
+		}
+		for i+1 < len(runes) && sentenceClosers[runes[i+1]] {
+			i++
+		}
+		ends = append(ends, i+1)
+	}
+	return ends
+}
+
+// clauseSeparators are the runes separating clauses within a sentence.
+var clauseSeparators = map[rune]bool{
+	'，': true, '、': true, '：': true, ',': true, ':': true,
+}
+
+// TrimToSentence trims the text to at most budget runes. A longer text is cut
+// after the last sentence end in the second half of the budget, then after the
+// last clause separator, and exactly at the budget if there is neither. It
+// returns the trimmed text and its length in runes.
+func TrimToSentence(text string, budget int) (string, int) {
+	runes := []rune(text)
+	if budget <= 0 {
+		return "", 0
+	}
+	if len(runes) <= budget {
+		return text, len(runes)
+	}
+
+	cut := 0
+	for _, end := range SentenceEnds(string(runes[:budget])) {
+		if end >= budget/2 {
+			cut = end
+		}
+	}
+
+	for i := budget - 1; cut == 0 && i >= budget/2; i-- {
+		if clauseSeparators[runes[i]] {
+			cut = i + 1
+		}
+	}
+
+	if cut == 0 {
+		cut = budget
+	}
+	return string(runes[:cut]), cut
+}
+
 // LlmInjectionPatterns contains regex patterns to detect potential LLM injection attacks.
 // These patterns are used to identify malicious content that could manipulate the behavior of LLMs.
 // The patterns include SQL injection, XSS, llm-specific injections, and other common attack vectors.
@@ -148,14 +360,64 @@ var LlmInjectionPatterns = []string{
 	"(?i)```[\\s\\S]*?```",
 }
 
+// llmInjectionRegexps are LlmInjectionPatterns compiled once, in the same
+// order.
+var llmInjectionRegexps = compileLLMInjectionPatterns(LlmInjectionPatterns)
+
+func compileLLMInjectionPatterns(patterns []string) []*regexp.Regexp {
+	regexps := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		regexps[i] = regexp.MustCompile(pattern)
+	}
+	return regexps
+}
+
+// LLMInjection is a match of one of LlmInjectionPatterns in an input.
+type LLMInjection struct {
+	// Pattern is the pattern matched, as listed in LlmInjectionPatterns.
+	Pattern string
+	// Match is the first substring of the input matching the pattern.
+	Match string
+	// Start and End are the byte offsets of Match in the input.
+	Start, End int
+}
+
+// FindLLMInjections returns a match per pattern of LlmInjectionPatterns found
+// in the input, in the order of the patterns. It returns nil if the input
+// looks safe.
+func FindLLMInjections(input string) []LLMInjection {
+	return findLLMInjections(input, len(llmInjectionRegexps))
+}
+
+// findLLMInjections returns up to n matches of the patterns in the input.
+func findLLMInjections(input string, n int) []LLMInjection {
+	var found []LLMInjection
+	for _, re := range llmInjectionRegexps {
+		if len(found) == n {
+			break
+		}
+		// matching without tracking the offsets is cheaper, the offsets are
+		// only searched for in the rare inputs matched
+		if !re.MatchString(input) {
+			continue
+		}
+		loc := re.FindStringIndex(input)
+		found = append(found, LLMInjection{
+			Pattern: re.String(),
+			Match:   input[loc[0]:loc[1]],
+			Start:   loc[0],
+			End:     loc[1],
+		})
+	}
+	return found
+}
+
 // DetectLLMInjection checks if the input string contains patterns that indicate potential
-// LLM injection attacks. It returns true if any of the patterns match, indicating a
-// potential injection attempt.
+// LLM injection attacks. It returns true and the first pattern matched, if any.
+// Use FindLLMInjections to get all the matches.
 func DetectLLMInjection(input string) (bool, string) {
-	for _, pattern := range LlmInjectionPatterns {
-		if matched, _ := regexp.MatchString(pattern, input); matched {
-			return true, pattern
-		}
+	if found := findLLMInjections(input, 1); len(found) > 0 {
+		return true, found[0].Pattern
 	}
 	return false, ""
 }
@@ -169,6 +431,7 @@ func (c chunk) String() string {
 }
 
 var ErrChunkSizeTooSmall = errors.New("chunk size must be greater than 0")
+var ErrChunkOutOfRange = errors.New("chunk offsets out of range")
 var ErrInvalidChunkOverlap = errors.New("chunk overlap must be an even number greater than 1 and less than chunk size")
 
 // Chunck splits the input text into chunks of a specified size with a defined
@@ -1,11 +1,13 @@
 package llm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"slices"
 
 	"github.com/ChiaYuChang/weathercock/internal/global"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -17,12 +19,19 @@ type BaseClient struct {
 	Models            map[string]Model
 	DefaultModels     map[ModelType]string
 	SystemInstruction map[ModelType]string
+	// RetryPolicy is how the generate and embed calls of the provider
+	// clients are retried, see Retry.
+	RetryPolicy RetryPolicy
+	// Limiter limits the rate of the calls to the provider, shared by the
+	// goroutines using the client. The calls are not limited if nil.
+	Limiter *rate.Limiter
 }
 
 func NewClient() *BaseClient {
 	return &BaseClient{
 		Models:        make(map[string]Model),
 		DefaultModels: make(map[ModelType]string),
+		RetryPolicy:   DefaultRetryPolicy(),
 	}
 }
 
@@ -90,3 +99,71 @@ func (cli *BaseClient) WithSystemInstruction(t ModelType, instruction string) er
 	cli.SystemInstruction[t] = instruction
 	return nil
 }
+
+// WithRetryPolicy sets the policy the calls are retried with.
+func (cli *BaseClient) WithRetryPolicy(p RetryPolicy) error {
+	if err := p.Validate(); err != nil {
+		return fmt.Errorf("invalid retry policy: %w", err)
+	}
+	cli.RetryPolicy = p
+	return nil
+}
+
+// Retry calls fn, a call to the provider, with the retry policy of the client.
+// Every attempt waits for the rate limit first.
+func (cli *BaseClient) Retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	return cli.RetryPolicy.Do(ctx, func(ctx context.Context) error {
+		if err := cli.Wait(ctx); err != nil {
+			return err
+		}
+		return fn(ctx)
+	})
+}
+
+// NewRateLimiter creates a limiter of rps calls per second, with bursts of up
+// to burst calls.
+func NewRateLimiter(rps float64, burst int) (*rate.Limiter, error) {
+	if rps <= 0 {
+		return nil, fmt.Errorf("rate limit should be positive, got %g", rps)
+	}
+	if burst < 1 {
+		return nil, fmt.Errorf("rate limit burst should be at least 1, got %d", burst)
+	}
+	return rate.NewLimiter(rate.Limit(rps), burst), nil
+}
+
+// WithRateLimit limits the calls to rps per second, with bursts of up to burst
+// calls.
+func (cli *BaseClient) WithRateLimit(rps float64, burst int) error {
+	l, err := NewRateLimiter(rps, burst)
+	if err != nil {
+		return err
+	}
+	cli.Limiter = l
+	return nil
+}
+
+// Wait blocks until the rate limit allows a call, or ctx is done. The error
+// is ctx.Err() if ctx was done, or would be before the call is allowed.
+func (cli *BaseClient) Wait(ctx context.Context) error {
+	if cli.Limiter == nil {
+		return nil
+	}
+	if err := cli.Limiter.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+	}
+	return nil
+}
+
+// RateLimitTokens returns the calls the rate limit allows right now, which
+// are negative while calls are waiting, e.g. for a gauge. ok is false if the
+// calls are not limited.
+func (cli *BaseClient) RateLimitTokens() (tokens float64, ok bool) {
+	if cli.Limiter == nil {
+		return 0, false
+	}
+	return cli.Limiter.Tokens(), true
+}
@@ -0,0 +1,418 @@
+package gemini
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"google.golang.org/genai"
+)
+
+var (
+	ErrMixedBatch    = errors.New("batch mixes generate and embed requests")
+	ErrInvalidKey    = errors.New("invalid batch request key")
+	errNotEmbedBatch = errors.New("not an embed batch")
+)
+
+// embedBatchType is the metadata type of the operation of an embed batch job.
+const embedBatchType = "EmbedContentBatch"
+
+// embedContentRequest is an EmbedContentRequest of the REST API.
+type embedContentRequest struct {
+	Model                string         `json:"model"`
+	Content              *genai.Content `json:"content"`
+	TaskType             string         `json:"taskType,omitempty"`
+	Title                string         `json:"title,omitempty"`
+	OutputDimensionality *int32         `json:"outputDimensionality,omitempty"`
+}
+
+type embedBatchMetadata struct {
+	Key string `json:"key"`
+}
+
+// embedBatchRequest is a request of an embed batch job, a line of its JSONL
+// input file keyed by Key, or an inlined request keyed by its Metadata.
+type embedBatchRequest struct {
+	Key      string              `json:"key,omitempty"`
+	Request  embedContentRequest `json:"request"`
+	Metadata *embedBatchMetadata `json:"metadata,omitempty"`
+}
+
+type embedBatchInputConfig struct {
+	FileName string `json:"fileName,omitempty"`
+	Requests *struct {
+		Requests []embedBatchRequest `json:"requests"`
+	} `json:"requests,omitempty"`
+}
+
+type embedBatchBody struct {
+	Batch struct {
+		Model       string                `json:"model"`
+		DisplayName string                `json:"displayName,omitempty"`
+		InputConfig embedBatchInputConfig `json:"inputConfig"`
+	} `json:"batch"`
+}
+
+// embedBatchOutputLine is a line of the output file of an embed batch job, or
+// an inlined response keyed by its Metadata.
+type embedBatchOutputLine struct {
+	Key      string              `json:"key,omitempty"`
+	Metadata *embedBatchMetadata `json:"metadata,omitempty"`
+	Response *struct {
+		Embedding struct {
+			Values []float32 `json:"values"`
+		} `json:"embedding"`
+	} `json:"response,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// batchOperation is the operation of a batch job, as returned by the REST API.
+type batchOperation struct {
+	Name     string `json:"name"`
+	Metadata struct {
+		Type        string    `json:"@type"`
+		Model       string    `json:"model"`
+		DisplayName string    `json:"displayName"`
+		State       string    `json:"state"`
+		CreateTime  time.Time `json:"createTime"`
+		EndTime     time.Time `json:"endTime"`
+		UpdateTime  time.Time `json:"updateTime"`
+		Output      struct {
+			ResponsesFile    string `json:"responsesFile"`
+			InlinedResponses struct {
+				InlinedResponses []embedBatchOutputLine `json:"inlinedResponses"`
+			} `json:"inlinedResponses"`
+		} `json:"output"`
+	} `json:"metadata"`
+}
+
+// isEmbed reports whether op is the operation of an embed batch job.
+func (op *batchOperation) isEmbed() bool {
+	return strings.HasSuffix(op.Metadata.Type, "."+embedBatchType)
+}
+
+// jobState is the state of op, e.g. BATCH_STATE_RUNNING, as a genai.JobState.
+func (op *batchOperation) jobState() genai.JobState {
+	return genai.JobState(strings.Replace(op.Metadata.State, "BATCH_STATE_", "JOB_STATE_", 1))
+}
+
+func (op *batchOperation) batchResponse() *llm.BatchResponse {
+	state := op.jobState()
+	return &llm.BatchResponse{
+		ID:        op.Name,
+		Status:    string(state),
+		IsDone:    IsTerminalJobState(state),
+		CreatedAt: op.Metadata.CreateTime,
+		EndAt:     op.Metadata.EndTime,
+		UpdateAt:  op.Metadata.UpdateTime,
+		Raw:       op,
+	}
+}
+
+// embedBatchKey is the key of the input of index pos of the request of index
+// i in the batch job name: my-job-3-0.
+func embedBatchKey(name string, i, pos int) string {
+	return fmt.Sprintf("%s-%d-%d", name, i, pos)
+}
+
+// parseEmbedBatchKey returns the custom ID of the request of key, e.g.
+// my-job-3, the index of the request and the index of its input. The name of
+// the job may contain dashes, the indices are the last two parts of the key.
+func parseEmbedBatchKey(key string) (string, int, int, error) {
+	sep := strings.LastIndex(key, "-")
+	if sep < 0 {
+		return "", 0, 0, fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	pos, err := strconv.Atoi(key[sep+1:])
+	if err != nil || pos < 0 {
+		return "", 0, 0, fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	customID := key[:sep]
+	sep = strings.LastIndex(customID, "-")
+	if sep < 0 {
+		return "", 0, 0, fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	index, err := strconv.Atoi(customID[sep+1:])
+	if err != nil || index < 0 {
+		return "", 0, 0, fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return customID, index, pos, nil
+}
+
+// ParseEmbedBatchOutput decodes the lines of the output of an embed batch job
+// created by BatchCreate, and gathers the embeddings of each request, in the
+// order of its inputs. The results are ordered by the index of their request,
+// a request one input of which failed has the *llm.BatchItemError of the
+// failure. The inputs missing from the output are in the EmbedStateError
+// state. Empty lines are skipped.
+func ParseEmbedBatchOutput(model string, lines [][]byte) ([]llm.BatchItemResult, error) {
+	results := map[int]*llm.BatchItemResult{}
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var out embedBatchOutputLine
+		if err := json.Unmarshal(line, &out); err != nil {
+			return nil, fmt.Errorf("failed to decode line %d of the batch output: %w", i, err)
+		}
+
+		key := out.Key
+		if key == "" && out.Metadata != nil {
+			key = out.Metadata.Key
+		}
+		customID, index, pos, err := parseEmbedBatchKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i, err)
+		}
+
+		result, ok := results[index]
+		if !ok {
+			result = &llm.BatchItemResult{
+				Index:    index,
+				CustomID: customID,
+				Embed:    &llm.EmbedResponse{Model: model},
+			}
+			results[index] = result
+		}
+		if result.Err != nil {
+			continue
+		}
+
+		switch {
+		case out.Error != nil:
+			result.Embed, result.Err = nil, &llm.BatchItemError{
+				CustomID: key,
+				Code:     strconv.Itoa(out.Error.Code),
+				Message:  out.Error.Message,
+			}
+			continue
+		case out.Response == nil:
+			result.Embed, result.Err = nil, &llm.BatchItemError{CustomID: key, Message: "no response"}
+			continue
+		}
+
+		embeds := result.Embed.Embeddings
+		if pos >= len(embeds) {
+			embeds = append(embeds, make([]llm.Embedding, pos+1-len(embeds))...)
+		}
+		embeds[pos] = llm.Embedding{State: llm.EmbedStateOk, Values: out.Response.Embedding.Values}
+		result.Embed.Embeddings = embeds
+	}
+
+	parsed := make([]llm.BatchItemResult, 0, len(results))
+	for _, result := range results {
+		if result.Embed != nil {
+			for i, embed := range result.Embed.Embeddings {
+				if embed.Values == nil {
+					result.Embed.Embeddings[i] = llm.Embedding{
+						State: llm.EmbedStateError,
+						Error: "missing from the batch output",
+					}
+				}
+			}
+		}
+		parsed = append(parsed, *result)
+	}
+	slices.SortFunc(parsed, func(a, b llm.BatchItemResult) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+	return parsed, nil
+}
+
+// batchCreateEmbed creates an embed batch job of the requests of req, each
+// input of which is a request of the job. The genai SDK does not support embed
+// batch jobs, they are created with the REST API of the Gemini API. The
+// BatchCreateConfig of req is not used.
+func (cli *Client) batchCreateEmbed(ctx context.Context, req *llm.BatchRequest) (*llm.BatchResponse, error) {
+	if cli.vertex() {
+		return nil, fmt.Errorf("%w: embed batch jobs on Vertex AI", llm.ErrNotImplemented)
+	}
+
+	modelName := req.ModelName
+	if modelName == "" {
+		if m, ok := cli.DefaultModel(llm.ModelEmbed); ok {
+			modelName = m.Name()
+		} else {
+			modelName = DefaultEmbedModel
+		}
+	}
+	model := "models/" + strings.TrimPrefix(modelName, "models/")
+
+	input, err := req.BatchInput(llm.WithBatchInputLimits(BatchInputMaxBytes, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch input: %w", err)
+	}
+	defer input.Close()
+
+	var inlined []embedBatchRequest
+	for i, r := range req.Requests {
+		subreq, ok := r.(*llm.EmbedRequest)
+		if !ok {
+			return nil, fmt.Errorf("%w: request %d is a %T", ErrMixedBatch, i, r)
+		}
+		if len(subreq.Inputs) == 0 {
+			return nil, fmt.Errorf("request %d: %w", i, llm.ErrNoInput)
+		}
+		if subreq.ModelName != "" && "models/"+strings.TrimPrefix(subreq.ModelName, "models/") != model {
+			return nil, fmt.Errorf("request %d: model %s is not the model %s of the batch",
+				i, subreq.ModelName, modelName)
+		}
+
+		config, err := toEmbedContentConfig(modelName, subreq)
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %w", i, err)
+		}
+
+		for j, in := range subreq.Inputs {
+			key := embedBatchKey(req.BatchJobName, i, j)
+			request := embedContentRequest{
+				Model:   model,
+				Content: genai.NewContentFromText(in.String(), genai.RoleUser),
+			}
+			if config != nil {
+				request.TaskType = config.TaskType
+				request.Title = config.Title
+				request.OutputDimensionality = config.OutputDimensionality
+			}
+
+			if err := input.WriteLine(embedBatchRequest{Key: key, Request: request}); err != nil {
+				return nil, fmt.Errorf("failed to write input %d of the %d-th request to jsonl: %w", j, i, err)
+			}
+			inlined = append(inlined, embedBatchRequest{
+				Request:  request,
+				Metadata: &embedBatchMetadata{Key: key},
+			})
+		}
+	}
+
+	var body embedBatchBody
+	body.Batch.Model = model
+	body.Batch.DisplayName = req.BatchJobName
+	if input.Size() > BatchInlineMaxBytes {
+		file, err := cli.GenAI.Files.Upload(ctx, input.Reader(), &genai.UploadFileConfig{
+			MIMEType:    "jsonl",
+			DisplayName: req.BatchJobName,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload batch input: %w", err)
+		}
+		body.Batch.InputConfig.FileName = file.Name
+	} else {
+		body.Batch.InputConfig.Requests = &struct {
+			Requests []embedBatchRequest `json:"requests"`
+		}{Requests: inlined}
+	}
+
+	var op batchOperation
+	if err := cli.restCall(ctx, http.MethodPost, model+":asyncBatchEmbedContent", body, &op); err != nil {
+		return nil, err
+	}
+	return op.batchResponse(), nil
+}
+
+// embedBatchOutput returns the output lines of the embed batch job name, and
+// errNotEmbedBatch if it is a generate batch job.
+func (cli *Client) embedBatchOutput(ctx context.Context, name string) ([][]byte, error) {
+	var op batchOperation
+	if err := cli.restCall(ctx, http.MethodGet, name, nil, &op); err != nil {
+		return nil, err
+	}
+	if !op.isEmbed() {
+		return nil, errNotEmbedBatch
+	}
+
+	output := op.Metadata.Output
+	if output.ResponsesFile != "" {
+		data, err := cli.GenAI.Files.Download(ctx,
+			genai.NewDownloadURIFromFile(&genai.File{DownloadURI: output.ResponsesFile}), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download batch output %s: %w", output.ResponsesFile, err)
+		}
+		return bytes.Split(data, []byte("\n")), nil
+	}
+
+	lines := make([][]byte, len(output.InlinedResponses.InlinedResponses))
+	for i, resp := range output.InlinedResponses.InlinedResponses {
+		line, err := json.Marshal(resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode response %d of the batch output: %w", i, err)
+		}
+		lines[i] = line
+	}
+	return lines, nil
+}
+
+// vertex reports whether the client uses the Vertex AI backend.
+func (cli *Client) vertex() bool {
+	return cli.GenAI.ClientConfig().Backend == genai.BackendVertexAI
+}
+
+// restCall sends a request to the REST API, for the endpoints the genai SDK
+// does not support, and decodes its JSON response into out. The errors of
+// the API are returned as genai.APIError, as by the SDK.
+func (cli *Client) restCall(ctx context.Context, method, path string, body, out any) error {
+	conf := cli.GenAI.ClientConfig()
+	u := strings.TrimSuffix(conf.HTTPOptions.BaseURL, "/") + "/" + conf.HTTPOptions.APIVersion + "/" + path
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request body: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", conf.APIKey)
+
+	httpClient := conf.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Error genai.APIError `json:"error"`
+		}
+		if err := json.Unmarshal(data, &apiErr); err != nil || apiErr.Error.Message == "" {
+			apiErr.Error.Message = string(data)
+		}
+		apiErr.Error.Code = resp.StatusCode
+		return apiErr.Error
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response body: %w", err)
+	}
+	return nil
+}
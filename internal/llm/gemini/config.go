@@ -0,0 +1,54 @@
+package gemini
+
+import (
+	"context"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+)
+
+func init() {
+	llm.RegisterProvider("gemini", func(ctx context.Context, cfg global.LLMConfig) (llm.LLM, error) {
+		return NewFromConfig(ctx, cfg.Gemini)
+	})
+}
+
+// NewFromConfig creates a client from its configuration, of Vertex AI if it
+// has a Vertex project. The embedding model is DefaultEmbedModel if the
+// configuration has none.
+func NewFromConfig(ctx context.Context, cfg global.GeminiConfig) (*Client, error) {
+	key, err := cfg.ReadAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	embedModel := utils.DefaultIfZero(cfg.EmbedModel, DefaultEmbedModel)
+	opts := []Option{
+		WithModel(
+			NewGeminiModel(llm.ModelGenerate, cfg.Model),
+			NewGeminiModel(llm.ModelEmbed, embedModel),
+		),
+		WithDefaultGenerate(cfg.Model),
+		WithDefaultEmbed(embedModel),
+	}
+	if cfg.VertexProject != "" || cfg.VertexLocation != "" {
+		opts = append(opts, WithVertex(cfg.VertexProject, cfg.VertexLocation))
+	}
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, WithCredentialsFile(cfg.CredentialsFile))
+	}
+	if key != "" {
+		opts = append(opts, WithAPIKey(key))
+	}
+	if cfg.BaseURL != "" {
+		opts = append(opts, WithBaseURL(cfg.BaseURL))
+	}
+	if cfg.Proxy != "" {
+		opts = append(opts, WithProxy(cfg.Proxy))
+	}
+	if cfg.Timeout > 0 {
+		opts = append(opts, WithTimeout(cfg.Timeout))
+	}
+	return Gemini(ctx, opts...)
+}
@@ -2,14 +2,19 @@ package gemini
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"slices"
+	"strings"
 	"time"
 
+	"cloud.google.com/go/auth"
+	"cloud.google.com/go/auth/credentials"
+	"cloud.google.com/go/auth/httptransport"
 	"github.com/ChiaYuChang/weathercock/internal/llm"
 	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"golang.org/x/time/rate"
 	"google.golang.org/genai"
 )
 
@@ -21,29 +26,123 @@ const (
 	EmbedTaskRetrivalDocument = "RETRIEVAL_DOCUMENT"
 	EmbedTaskClassification   = "CLASSIFICATION"
 	EmbedTaskClustering       = "CLUSTERING"
+	EmbedTaskSimilarity       = "SEMANTIC_SIMILARITY"
+	EmbedTaskQuestionAnswer   = "QUESTION_ANSWERING"
+	EmbedTaskFactVerification = "FACT_VERIFICATION"
+	EmbedTaskCodeQuery        = "CODE_RETRIEVAL_QUERY"
 )
 
+// embedTasks are the task types of the EmbedRequest the Gemini API accepts.
+var embedTasks = []string{
+	EmbedTaskRetrivalQuery, EmbedTaskRetrivalDocument, EmbedTaskClassification,
+	EmbedTaskClustering, EmbedTaskSimilarity, EmbedTaskQuestionAnswer,
+	EmbedTaskFactVerification, EmbedTaskCodeQuery,
+}
+
 const (
 	GeminiAPIVersion = "v1beta"
+	VertexAPIVersion = "v1beta1"
 )
 
+// vertexScope is the OAuth scope of the requests to Vertex AI.
+const vertexScope = "https://www.googleapis.com/auth/cloud-platform"
+
 var (
 	ErrAPIKeyMissing = errors.New("missing Gemini API key")
+	ErrAuthConflict  = errors.New("API key and Vertex AI settings are mutually exclusive")
 	ErrModelNotFound = errors.New("model not found")
+	ErrInvalidTask   = errors.New("invalid embedding task type")
 )
 
 type Client struct {
 	*llm.BaseClient
 	GenAI *genai.Client
+	// SafetySettings are the safety settings of the generation requests whose
+	// config sets none.
+	SafetySettings []*genai.SafetySetting
 }
 
 type builder struct {
 	APIKey       string
 	APIVer       string
+	BaseURL      string
 	Timeout      *time.Duration
 	Models       map[string]llm.Model
 	DefaultGen   string
 	DefaultEmbed string
+	RetryPolicy  *llm.RetryPolicy
+	RetryAfter   time.Duration
+	Limiter      *rate.Limiter
+	Transport    http.RoundTripper
+	Safety       []*genai.SafetySetting
+	Vertex       bool
+	Project      string
+	Location     string
+	Credentials  *auth.Credentials
+}
+
+// clientConfig returns the config of the genai client of b, for the Gemini
+// API with an API key, or for Vertex AI with credentials.
+func (b *builder) clientConfig(ctx context.Context) (*genai.ClientConfig, error) {
+	config := &genai.ClientConfig{
+		HTTPOptions: genai.HTTPOptions{
+			BaseURL: b.BaseURL,
+			Timeout: b.Timeout,
+		},
+	}
+
+	if !b.Vertex {
+		if b.Credentials != nil {
+			return nil, fmt.Errorf("credentials are only used by Vertex AI, see WithVertex")
+		}
+		if b.APIKey == "" {
+			return nil, ErrAPIKeyMissing
+		}
+		config.Backend = genai.BackendGeminiAPI
+		config.APIKey = b.APIKey
+		config.HTTPOptions.APIVersion = utils.DefaultIfZero(b.APIVer, GeminiAPIVersion)
+		if b.Transport != nil {
+			config.HTTPClient = &http.Client{Transport: b.Transport}
+		}
+		return config, nil
+	}
+
+	if b.APIKey != "" {
+		return nil, ErrAuthConflict
+	}
+	config.Backend = genai.BackendVertexAI
+	config.Project = b.Project
+	config.Location = b.Location
+	config.Credentials = b.Credentials
+	config.HTTPOptions.APIVersion = utils.DefaultIfZero(b.APIVer, VertexAPIVersion)
+	if b.Transport == nil {
+		// the SDK authenticates its own HTTP client
+		return config, nil
+	}
+
+	// the HTTP client of a transport is authenticated here, as by the SDK
+	creds := b.Credentials
+	if creds == nil {
+		var err error
+		creds, err = credentials.DetectDefault(&credentials.DetectOptions{Scopes: []string{vertexScope}})
+		if err != nil {
+			return nil, fmt.Errorf("could not find default credentials: %w", err)
+		}
+	}
+	quotaProject, err := creds.QuotaProjectID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get quota project: %w", err)
+	}
+	config.Credentials = creds
+	config.HTTPClient, err = httptransport.NewClient(&httptransport.Options{
+		Credentials:      creds,
+		BaseRoundTripper: b.Transport,
+		Headers:          http.Header{"X-Goog-User-Project": []string{quotaProject}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create HTTP client: %w", err)
+	}
+	return config, nil
 }
 
 // NewGeminiModel creates a new GeminiModel with the specified model type and name.
@@ -63,29 +162,18 @@ func NewGeminiModel(modelType llm.ModelType, name string) GeminiModel {
 //   - *Client: The initialized Gemini client.
 //   - error: An error if client creation fails.
 func Gemini(ctx context.Context, opts ...Option) (*Client, error) {
-	b := &builder{}
+	b := &builder{Models: map[string]llm.Model{}}
 	for _, opt := range opts {
 		if err := opt(b); err != nil {
 			return nil, err
 		}
 	}
 
-	if b.APIKey == "" {
-		return nil, ErrAPIKeyMissing
+	config, err := b.clientConfig(ctx)
+	if err != nil {
+		return nil, err
 	}
-
-	ver := utils.DefaultIfZero(b.APIVer, GeminiAPIVersion)
-	cli, err := genai.NewClient(
-		ctx,
-		&genai.ClientConfig{
-			APIKey:  b.APIKey,
-			Backend: genai.BackendGeminiAPI,
-			HTTPOptions: genai.HTTPOptions{
-				APIVersion: ver,
-				Timeout:    b.Timeout,
-			},
-		},
-	)
+	cli, err := genai.NewClient(ctx, config)
 
 	if err != nil {
 		return nil, fmt.Errorf("could not create Gemini API client: %w", err)
@@ -97,21 +185,27 @@ func Gemini(ctx context.Context, opts ...Option) (*Client, error) {
 		b.Models[DefaultEmbedModel] = NewGeminiModel(llm.ModelEmbed, DefaultEmbedModel)
 	}
 
-	// validate models
+	// validate models, Vertex AI names the publisher models without the
+	// models/ prefix and does not report their supported actions
 	for name, model := range b.Models {
-		m, err := cli.Models.Get(ctx, name, nil)
+		getName := name
+		if b.Vertex {
+			getName = strings.TrimPrefix(name, "models/")
+		}
+		m, err := cli.Models.Get(ctx, getName, nil)
 		if err != nil {
 			return nil, fmt.Errorf("could not retrieve model %s from Gemini API: %w", name, err)
 		}
 
-		switch model.Type() {
-		case llm.ModelEmbed:
+		switch {
+		case b.Vertex:
+		case model.Type() == llm.ModelEmbed:
 			if !slices.Contains(m.SupportedActions, "embedContent") {
 				return nil, fmt.Errorf(
 					"model %s (%s) does not support embedding content",
 					name, m.DisplayName)
 			}
-		case llm.ModelGenerate:
+		case model.Type() == llm.ModelGenerate:
 			if !slices.Contains(m.SupportedActions, "generateContent") {
 				return nil, fmt.Errorf(
 					"model %s (%s) does not support generating content",
@@ -130,6 +224,18 @@ func Gemini(ctx context.Context, opts ...Option) (*Client, error) {
 	}
 
 	base := llm.NewClient()
+	base.RetryPolicy = llm.DefaultRetryPolicy()
+	if b.RetryPolicy != nil {
+		base.RetryPolicy = *b.RetryPolicy
+	}
+	if base.RetryPolicy.RetryOn == nil {
+		base.RetryPolicy.RetryOn = IsTransient
+	}
+	if b.RetryAfter > 0 && base.RetryPolicy.RetryAfter == nil {
+		base.RetryPolicy.RetryAfter = retryAfter(b.RetryAfter)
+	}
+	base.Limiter = b.Limiter
+
 	for _, model := range b.Models {
 		if err := base.WithModel(model); err != nil {
 			return nil, fmt.Errorf("could not register model %s: %w", model.Name(), err)
@@ -146,7 +252,92 @@ func Gemini(ctx context.Context, opts ...Option) (*Client, error) {
 		return nil, fmt.Errorf("could not set default embed model: %w", err)
 	}
 
-	return &Client{base, cli}, nil
+	return &Client{BaseClient: base, GenAI: cli, SafetySettings: b.Safety}, nil
+}
+
+// IsTransient reports whether the error of a Gemini request is worth
+// retrying: a rate limit or a server error of the API, or a transient failure
+// to reach it.
+func IsTransient(err error) bool {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return llm.IsTransientStatus(apiErr.Code)
+	}
+	return llm.IsTransient(err)
+}
+
+// retryInfoType is the type of the RetryInfo in the details of an API error.
+const retryInfoType = "type.googleapis.com/google.rpc.RetryInfo"
+
+// retryAfter returns the RetryAfter of a retry policy, the retry delay of the
+// RetryInfo in the details of an API error, up to maxWait.
+func retryAfter(maxWait time.Duration) func(err error) time.Duration {
+	return func(err error) time.Duration {
+		var apiErr genai.APIError
+		if !errors.As(err, &apiErr) {
+			return 0
+		}
+		for _, detail := range apiErr.Details {
+			if detail["@type"] != retryInfoType {
+				continue
+			}
+			// the delay is a protobuf Duration, e.g. "37s" or "1.5s"
+			v, _ := detail["retryDelay"].(string)
+			if d, err := time.ParseDuration(v); err == nil && d > 0 {
+				return min(d, maxWait)
+			}
+		}
+		return 0
+	}
+}
+
+// BlockedError is a generation blocked for the safety policy of the Gemini
+// API, either its prompt or its response. It wraps llm.ErrContentBlocked.
+type BlockedError struct {
+	// Reason is the block reason of the prompt, e.g. SAFETY, or the finish
+	// reason of the response, e.g. PROHIBITED_CONTENT.
+	Reason  string
+	Message string
+	// SafetyRatings are the ratings of the blocked prompt or response.
+	SafetyRatings []*genai.SafetyRating
+}
+
+func (e *BlockedError) Error() string {
+	msg := fmt.Sprintf("%s: %s", llm.ErrContentBlocked, e.Reason)
+	for _, r := range e.SafetyRatings {
+		if r.Blocked {
+			msg += fmt.Sprintf(", %s (%s)", r.Category, r.Probability)
+		}
+	}
+	if e.Message != "" {
+		msg += ", msg: " + e.Message
+	}
+	return msg
+}
+
+func (e *BlockedError) Unwrap() error {
+	return llm.ErrContentBlocked
+}
+
+// blockedError returns the *BlockedError of resp if its prompt or its first
+// candidate was blocked, nil otherwise.
+func blockedError(resp *genai.GenerateContentResponse) error {
+	if fb := resp.PromptFeedback; fb != nil && fb.BlockReason != "" {
+		return &BlockedError{
+			Reason:        string(fb.BlockReason),
+			Message:       fb.BlockReasonMessage,
+			SafetyRatings: fb.SafetyRatings,
+		}
+	}
+	if finishReason(resp) == llm.FinishReasonContentFilter {
+		c := resp.Candidates[0]
+		return &BlockedError{
+			Reason:        string(c.FinishReason),
+			Message:       c.FinishMessage,
+			SafetyRatings: c.SafetyRatings,
+		}
+	}
+	return nil
 }
 
 // Generate sends a content generation request to the Gemini API using the specified model and configuration.
@@ -166,6 +357,139 @@ func (cli *Client) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm
 		return nil, llm.ErrNoInput
 	}
 
+	modelName, contents, config, err := cli.generateContentArgs(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *genai.GenerateContentResponse
+	err = cli.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = cli.GenAI.Models.GenerateContent(ctx, modelName, contents, config)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := blockedError(resp); err != nil {
+		return nil, err
+	}
+
+	output := resp.Text()
+	var schemaErr error
+	if req.Schema != nil {
+		output = llm.RepairOutput(output, req.Schema)
+		schemaErr = llm.ValidateOutput(output, req.Schema)
+	}
+
+	var usage llm.Usage
+	if u := resp.UsageMetadata; u != nil {
+		usage = llm.Usage{
+			PromptTokens:     int(u.PromptTokenCount),
+			CompletionTokens: int(u.CandidatesTokenCount),
+			TotalTokens:      int(u.TotalTokenCount),
+		}
+	}
+
+	return &llm.GenerateResponse{
+		Outputs:      []string{output},
+		Usage:        usage,
+		Raw:          resp,
+		SchemaErr:    schemaErr,
+		FinishReason: finishReason(resp),
+	}, nil
+}
+
+// GenerateStream streams the content generated by a Gemini model, a chunk per
+// response received from the API.
+// Parameters:
+//   - ctx: The context for the request.
+//   - req: llm.GenerateRequest containing the messages and model information.
+//
+// Returns:
+//   - <-chan llm.GenerateChunk receiving the deltas of the output.
+//   - error if the configuration type is invalid.
+func (cli *Client) GenerateStream(ctx context.Context, req *llm.GenerateRequest) (<-chan llm.GenerateChunk, error) {
+	if req == nil {
+		return nil, llm.ErrRequestShouldNotBeNull
+	}
+
+	if len(req.Messages) == 0 {
+		return nil, llm.ErrNoInput
+	}
+
+	modelName, contents, config, err := cli.generateContentArgs(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return llm.NewStream(ctx, func(emit func(delta string) error) error {
+		if err := cli.Wait(ctx); err != nil {
+			return err
+		}
+
+		for resp, err := range cli.GenAI.Models.GenerateContentStream(ctx, modelName, contents, config) {
+			if err != nil {
+				return err
+			}
+			if err := blockedError(resp); err != nil {
+				return err
+			}
+			if err := emit(resp.Text()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}), nil
+}
+
+// TokenCount counts the tokens of msgs for the named model with the Gemini API,
+// the default generate model if modelName is empty. The Gemini API does not
+// count a system instruction, the system messages are counted as the first
+// user content instead.
+func (cli *Client) TokenCount(ctx context.Context, modelName string, msgs []llm.Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, llm.ErrNoInput
+	}
+
+	if modelName == "" {
+		if m, ok := cli.DefaultModel(llm.ModelGenerate); ok {
+			modelName = m.Name()
+		} else {
+			modelName = DefaultGenModel
+		}
+	}
+
+	var contents []*genai.Content
+	system, err := systemInstruction(msgs)
+	if err != nil {
+		return 0, err
+	}
+	if system != nil {
+		contents = append(contents, system)
+	}
+	if slices.ContainsFunc(msgs, func(msg llm.Message) bool { return msg.Role != llm.RoleSystem }) {
+		turns, err := toGenAIContents(msgs)
+		if err != nil {
+			return 0, err
+		}
+		contents = append(contents, turns...)
+	}
+
+	var resp *genai.CountTokensResponse
+	err = cli.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = cli.GenAI.Models.CountTokens(ctx, modelName, contents, nil)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count tokens: %w", err)
+	}
+	return int(resp.TotalTokens), nil
+}
+
+// generateContentArgs converts req to the arguments of a content generation.
+func (cli *Client) generateContentArgs(req *llm.GenerateRequest) (string, []*genai.Content, *genai.GenerateContentConfig, error) {
 	modelName := req.ModelName
 	if modelName == "" {
 		if m, ok := cli.DefaultModel(llm.ModelGenerate); ok {
@@ -177,12 +501,12 @@ func (cli *Client) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm
 
 	contents, err := toGenAIContents(req.Messages)
 	if err != nil {
-		return nil, err
+		return "", nil, nil, err
 	}
 
-	config, err := assertAs[*genai.GenerateContentConfig](req.Config)
+	config, err := toGenerateContentConfig(req)
 	if err != nil {
-		return nil, err
+		return "", nil, nil, err
 	}
 
 	if req.Schema != nil {
@@ -191,26 +515,22 @@ func (cli *Client) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm
 		}
 		config.ResponseJsonSchema = req.Schema.S
 	}
+	return modelName, contents, cli.withSafetySettings(config), nil
+}
 
-	resp, err := cli.GenAI.Models.GenerateContent(ctx, modelName, contents, config)
-	if err != nil {
-		return nil, err
+// withSafetySettings returns config with the SafetySettings of the client if
+// it sets none. config is a copy of the config of the request, or nil.
+func (cli *Client) withSafetySettings(config *genai.GenerateContentConfig) *genai.GenerateContentConfig {
+	if len(cli.SafetySettings) == 0 {
+		return config
 	}
-
-	if req.Schema != nil {
-		output, err := extractJSONObject(resp.Text())
-		if err == nil {
-			return &llm.GenerateResponse{
-				Outputs: []string{output},
-				Raw:     resp,
-			}, nil
-		}
+	if config == nil {
+		config = &genai.GenerateContentConfig{}
 	}
-
-	return &llm.GenerateResponse{
-		Outputs: []string{resp.Text()},
-		Raw:     resp,
-	}, nil
+	if len(config.SafetySettings) == 0 {
+		config.SafetySettings = cli.SafetySettings
+	}
+	return config
 }
 
 // Embed generates embeddings for the given request using the Gemini API.
@@ -244,16 +564,23 @@ func (cli *Client) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.Embed
 		contents[i] = genai.NewContentFromText(input.String(), genai.RoleUser)
 	}
 
-	config, err := assertAs[*genai.EmbedContentConfig](req.Config)
+	config, err := toEmbedContentConfig(modelName, req)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := cli.GenAI.Models.EmbedContent(ctx, modelName, contents, config)
+	var resp *genai.EmbedContentResponse
+	err = cli.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = cli.GenAI.Models.EmbedContent(ctx, modelName, contents, config)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate embedding: %w", err)
 	}
 
+	// the token counts are only reported by the Vertex API
+	var usage llm.Usage
 	embeds := make([]llm.Embedding, len(resp.Embeddings))
 	for i, embed := range resp.Embeddings {
 		embeds[i] = llm.Embedding{
@@ -261,26 +588,87 @@ func (cli *Client) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.Embed
 			Values: embed.Values,
 		}
 
-		if embed.Statistics != nil && embed.Statistics.Truncated {
-			embeds[i].State = llm.EmbedStateTruncated
+		if embed.Statistics != nil {
+			usage.PromptTokens += int(embed.Statistics.TokenCount)
+			if embed.Statistics.Truncated {
+				embeds[i].State = llm.EmbedStateTruncated
+			}
 		}
 	}
+	usage.TotalTokens = usage.PromptTokens
+
 	return &llm.EmbedResponse{
 		Embeddings: embeds,
 		Model:      modelName,
+		Usage:      usage,
 		Raw:        resp,
 	}, nil
 }
 
+const (
+	// BatchInlineMaxBytes is the size limit of the inlined requests of a batch job.
+	BatchInlineMaxBytes int64 = 20 << 20
+	// BatchInputMaxBytes is the size limit of a batch input file.
+	BatchInputMaxBytes int64 = 2 << 30
+)
+
+// batchInputLine is a line of the JSONL input file of a batch job.
+type batchInputLine struct {
+	Key     string            `json:"key"`
+	Request batchInputRequest `json:"request"`
+}
+
+type batchInputRequest struct {
+	Contents          []*genai.Content        `json:"contents"`
+	SystemInstruction *genai.Content          `json:"systemInstruction,omitempty"`
+	SafetySettings    []*genai.SafetySetting  `json:"safetySettings,omitempty"`
+	GenerationConfig  *genai.GenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// toGenerationConfig extracts the generation parameters of a
+// GenerateContentConfig in the shape of the REST request.
+func toGenerationConfig(c *genai.GenerateContentConfig) *genai.GenerationConfig {
+	return &genai.GenerationConfig{
+		Temperature:        c.Temperature,
+		TopP:               c.TopP,
+		TopK:               c.TopK,
+		CandidateCount:     c.CandidateCount,
+		MaxOutputTokens:    c.MaxOutputTokens,
+		StopSequences:      c.StopSequences,
+		ResponseLogprobs:   c.ResponseLogprobs,
+		Logprobs:           c.Logprobs,
+		PresencePenalty:    c.PresencePenalty,
+		FrequencyPenalty:   c.FrequencyPenalty,
+		Seed:               c.Seed,
+		ResponseMIMEType:   c.ResponseMIMEType,
+		ResponseSchema:     c.ResponseSchema,
+		ResponseJsonSchema: c.ResponseJsonSchema,
+	}
+}
+
 // BatchGenerate processes multiple generation requests in a single batch job using the Gemini API.
+// A batch of embed requests is an embed batch job, a batch job mixing both
+// kinds of requests is rejected with ErrMixedBatch.
 // Parameters:
 //   - ctx: The context for the request.
-//   - req: llm.BatchRequest containing multiple generation requests.
+//   - req: llm.BatchRequest containing multiple generation or embed requests.
 //
 // Returns:
 //   - *llm.BatchResponse with the batch job details.
 //   - error if the request fails.
 func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm.BatchResponse, error) {
+	if req == nil {
+		return nil, llm.ErrRequestShouldNotBeNull
+	}
+
+	if len(req.Requests) == 0 {
+		return nil, llm.ErrNoInput
+	}
+
+	if _, ok := req.Requests[0].(*llm.EmbedRequest); ok {
+		return cli.batchCreateEmbed(ctx, req)
+	}
+
 	inlineReqs := make([]*genai.InlinedRequest, len(req.Requests))
 	for i, r := range req.Requests {
 		switch subreq := r.(type) {
@@ -290,10 +678,11 @@ func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm
 				return nil, err
 			}
 
-			gConf, err := assertAs[*genai.GenerateContentConfig](subreq.Config)
+			gConf, err := toGenerateContentConfig(subreq)
 			if err != nil {
 				return nil, err
 			}
+			gConf = cli.withSafetySettings(gConf)
 
 			modelName := subreq.ModelName
 			if modelName == "" {
@@ -310,7 +699,7 @@ func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm
 				Config:   gConf,
 			}
 		case *llm.EmbedRequest:
-			return nil, llm.ErrNotImplemented
+			return nil, fmt.Errorf("%w: request %d is a %T", ErrMixedBatch, i, r)
 		default:
 			return nil, llm.ErrNotImplemented
 		}
@@ -339,19 +728,46 @@ func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm
 		}
 	}
 
-	src := &genai.BatchJobSource{
-		// Format:          "jsonl",
-		InlinedRequests: inlineReqs,
+	input, err := req.BatchInput(llm.WithBatchInputLimits(BatchInputMaxBytes, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch input: %w", err)
 	}
+	defer input.Close()
 
-	data, err := json.Marshal(src)
-	if err != nil {
-		return nil, err
+	for i, r := range inlineReqs {
+		line := batchInputLine{
+			Key: fmt.Sprintf("%s-%d", req.BatchJobName, i),
+			Request: batchInputRequest{
+				Contents: r.Contents,
+			},
+		}
+		if r.Config != nil {
+			line.Request.SystemInstruction = r.Config.SystemInstruction
+			line.Request.SafetySettings = r.Config.SafetySettings
+			line.Request.GenerationConfig = toGenerationConfig(r.Config)
+		}
+
+		if err := input.WriteLine(line); err != nil {
+			return nil, fmt.Errorf("failed to write %d-th request to jsonl: %w", i, err)
+		}
 	}
 
-	_, err = req.ReadWriter.Write(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to write to writer: %w", err)
+	// Inlined requests are limited in size, larger inputs are uploaded as a
+	// JSONL file.
+	src := &genai.BatchJobSource{
+		InlinedRequests: inlineReqs,
+	}
+	if input.Size() > BatchInlineMaxBytes {
+		file, err := cli.GenAI.Files.Upload(ctx, input.Reader(), &genai.UploadFileConfig{
+			MIMEType:    "jsonl",
+			DisplayName: req.BatchJobName,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload batch input: %w", err)
+		}
+		src = &genai.BatchJobSource{
+			FileName: file.Name,
+		}
 	}
 
 	job, err := cli.GenAI.Batches.Create(ctx, modelName, src, config)
@@ -378,7 +794,8 @@ func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm
 //   - req: llm.BatchRetrieveRequest containing the ID of the batch job to retrieve.
 //
 // Returns:
-//   - *llm.BatchResponse with the batch job details and results if completed.
+//   - *llm.BatchResponse with the batch job details and results if completed,
+//     the embeddings of an embed batch job gathered by request in Parsed.
 //   - error if the request fails.
 func (cli *Client) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequest) (*llm.BatchResponse, error) {
 	conf, err := assertAs[*genai.GetBatchJobConfig](req.RetrieveConfig)
@@ -392,9 +809,26 @@ func (cli *Client) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequ
 	}
 
 	var responses [][]byte
+	var parsed []llm.BatchItemResult
 	if job.State == genai.JobStateSucceeded {
-		for _, resp := range job.Dest.InlinedResponses {
-			responses = append(responses, []byte(resp.Response.Text()))
+		// the SDK does not decode the output of embed batch jobs, which are
+		// not supported on Vertex AI
+		err = errNotEmbedBatch
+		if !cli.vertex() {
+			responses, err = cli.embedBatchOutput(ctx, job.Name)
+		}
+		switch {
+		case err == nil:
+			if parsed, err = ParseEmbedBatchOutput(strings.TrimPrefix(job.Model, "models/"), responses); err != nil {
+				return nil, err
+			}
+		case errors.Is(err, errNotEmbedBatch):
+			responses = nil
+			for _, resp := range job.Dest.InlinedResponses {
+				responses = append(responses, []byte(resp.Response.Text()))
+			}
+		default:
+			return nil, fmt.Errorf("failed to retrieve the batch output: %w", err)
 		}
 	}
 
@@ -407,8 +841,9 @@ func (cli *Client) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequ
 		EndAt:     job.EndTime,
 		UpdateAt:  job.UpdateTime,
 		Responses: responses,
+		Parsed:    parsed,
 		Raw:       job,
-	}, err
+	}, nil
 }
 
 // BatchCancel cancels a running batch job on the Gemini API.
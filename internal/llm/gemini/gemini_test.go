@@ -5,16 +5,23 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"cloud.google.com/go/auth"
 	"github.com/ChiaYuChang/weathercock/internal/llm"
 	"github.com/ChiaYuChang/weathercock/internal/llm/gemini"
+	"github.com/ChiaYuChang/weathercock/internal/llm/llmtest"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
 	"github.com/invopop/jsonschema"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/genai"
@@ -159,6 +166,143 @@ func TestGeminiBatchCreate(t *testing.T) {
 	t.Log(string(data))
 }
 
+func TestGeminiBatchEmbed(t *testing.T) {
+	var body map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1beta/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"models/%s","displayName":"%[1]s","supportedGenerationMethods":["generateContent","embedContent"]}`,
+			r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "gemini-embedding-001:asyncBatchEmbedContent", r.PathValue("action"))
+		require.Equal(t, "my-gemini-key", r.Header.Get("x-goog-api-key"))
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"batches/embd2k7xq0hv3m1g","metadata":{` +
+			`"@type":"type.googleapis.com/google.ai.generativelanguage.v1main.EmbedContentBatch",` +
+			`"model":"models/gemini-embedding-001","displayName":"nightly-reembed",` +
+			`"state":"BATCH_STATE_PENDING","createTime":"2025-08-21T01:02:03.456789Z"}}`))
+	})
+	mux.HandleFunc("GET /v1beta/batches/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, "./"+r.PathValue("id")[:8]+"_batch_completed_status.json")
+	})
+	mux.HandleFunc("GET /v1beta/files/{file}", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "embd9q4m-output:download", r.PathValue("file"))
+		http.ServeFile(w, r, "./embd9q4m_batch_completed_results.jsonl")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithBaseURL(server.URL),
+	)
+	require.NoError(t, err)
+
+	resp, err := cli.BatchCreate(context.Background(), &llm.BatchRequest{
+		BatchJobName: "nightly-reembed",
+		Requests: []llm.Request{
+			&llm.EmbedRequest{
+				Inputs: []llm.EmbedInput{
+					llm.NewSimpleTextInput("三鶯線"),
+					llm.NewSimpleTextInput("Triton-1"),
+				},
+				TaskType: gemini.EmbedTaskRetrivalDocument,
+			},
+			&llm.EmbedRequest{
+				Inputs:     []llm.EmbedInput{llm.NewSimpleTextInput("CPI")},
+				Dimensions: utils.Ptr(768),
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "batches/embd2k7xq0hv3m1g", resp.ID)
+	require.Equal(t, string(genai.JobStatePending), resp.Status)
+	require.False(t, resp.IsDone)
+
+	batch := body["batch"].(map[string]any)
+	require.Equal(t, "nightly-reembed", batch["displayName"])
+	requests := batch["inputConfig"].(map[string]any)["requests"].(map[string]any)["requests"].([]any)
+	require.Len(t, requests, 3)
+	keys := make([]string, len(requests))
+	for i, r := range requests {
+		keys[i] = r.(map[string]any)["metadata"].(map[string]any)["key"].(string)
+	}
+	require.Equal(t, []string{"nightly-reembed-0-0", "nightly-reembed-0-1", "nightly-reembed-1-0"}, keys)
+	first := requests[0].(map[string]any)["request"].(map[string]any)
+	require.Equal(t, "models/gemini-embedding-001", first["model"])
+	require.Equal(t, "RETRIEVAL_DOCUMENT", first["taskType"])
+	last := requests[2].(map[string]any)["request"].(map[string]any)
+	require.Equal(t, float64(768), last["outputDimensionality"])
+
+	t.Run("inlined responses", func(t *testing.T) {
+		resp, err := cli.BatchRetrieve(context.Background(), &llm.BatchRetrieveRequest{
+			ID: "batches/embd2k7xq0hv3m1g",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsDone)
+		require.Len(t, resp.Responses, 4)
+		require.Len(t, resp.Parsed, 3)
+
+		require.Equal(t, "nightly-reembed-0", resp.Parsed[0].CustomID)
+		require.Equal(t, "gemini-embedding-001", resp.Parsed[0].Embed.Model)
+		require.Equal(t, [][]float32{
+			{0.0102, 0.0345, -0.0221, 0.0067},
+			{-0.0314, 0.0021, 0.0178, -0.0093},
+		}, [][]float32{resp.Parsed[0].Embed.Embeddings[0].Values, resp.Parsed[0].Embed.Embeddings[1].Values})
+
+		require.Nil(t, resp.Parsed[1].Embed)
+		require.ErrorIs(t, resp.Parsed[1].Err, llm.ErrBatchItemFailed)
+		var itemErr *llm.BatchItemError
+		require.ErrorAs(t, resp.Parsed[1].Err, &itemErr)
+		require.Equal(t, "nightly-reembed-1-0", itemErr.CustomID)
+		require.Equal(t, "3", itemErr.Code)
+
+		require.Equal(t, 2, resp.Parsed[2].Index)
+		require.Len(t, resp.Parsed[2].Embed.Embeddings, 1)
+	})
+
+	t.Run("responses file", func(t *testing.T) {
+		resp, err := cli.BatchRetrieve(context.Background(), &llm.BatchRetrieveRequest{
+			ID: "batches/embd9q4mz7c2w8ka",
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.Parsed, 2)
+		require.Len(t, resp.Parsed[0].Embed.Embeddings, 2)
+		require.Equal(t, []float32{-0.0058, 0.0319, -0.0147, 0.0201}, resp.Parsed[0].Embed.Embeddings[0].Values)
+		require.Equal(t, []float32{0.0441, -0.0172, 0.0093, 0.0265}, resp.Parsed[1].Embed.Embeddings[0].Values)
+	})
+
+	t.Run("missing inputs", func(t *testing.T) {
+		parsed, err := gemini.ParseEmbedBatchOutput("gemini-embedding-001", [][]byte{
+			[]byte(`{"key":"job-0-1","response":{"embedding":{"values":[0.1]}}}`),
+			nil,
+		})
+		require.NoError(t, err)
+		require.Len(t, parsed, 1)
+		require.Equal(t, llm.EmbedStateError, parsed[0].Embed.Embeddings[0].State)
+		require.Equal(t, llm.EmbedStateOk, parsed[0].Embed.Embeddings[1].State)
+
+		_, err = gemini.ParseEmbedBatchOutput("gemini-embedding-001", [][]byte{
+			[]byte(`{"key":"job","response":{"embedding":{"values":[0.1]}}}`),
+		})
+		require.ErrorIs(t, err, gemini.ErrInvalidKey)
+	})
+
+	t.Run("mixed requests", func(t *testing.T) {
+		_, err := cli.BatchCreate(context.Background(), &llm.BatchRequest{
+			Requests: []llm.Request{
+				&llm.EmbedRequest{Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("三鶯線")}},
+				&llm.GenerateRequest{Messages: []llm.Message{{Role: llm.RoleUser, Content: []string{"hi"}}}},
+			},
+		})
+		require.ErrorIs(t, err, gemini.ErrMixedBatch)
+	})
+}
+
 func TestGeminiForamatOutput(t *testing.T) {
 	key := os.Getenv("GEMINI_API_KEY")
 	if key == "" {
@@ -294,3 +438,584 @@ func TestGeminiForamatOutput(t *testing.T) {
 		require.Equal(t, data[i], r)
 	}
 }
+
+func TestGeminiGenerationParams(t *testing.T) {
+	var body map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1beta/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"models/%s","displayName":"%[1]s","supportedGenerationMethods":["generateContent","embedContent"]}`,
+			r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithBaseURL(server.URL),
+	)
+	require.NoError(t, err)
+
+	req := &llm.GenerateRequest{
+		Messages:    []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+		Temperature: utils.Ptr[float32](0.5),
+		TopP:        utils.Ptr[float32](0.25),
+		MaxTokens:   utils.Ptr(64),
+		Stop:        []string{"\n\n"},
+		Seed:        utils.Ptr[int64](42),
+	}
+	resp, err := cli.Generate(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ok"}, resp.Outputs)
+	require.Equal(t, llm.FinishReasonStop, resp.FinishReason)
+	require.Equal(t, map[string]any{
+		"temperature":     0.5,
+		"topP":            0.25,
+		"maxOutputTokens": float64(64),
+		"stopSequences":   []any{"\n\n"},
+		"seed":            float64(42),
+	}, body["generationConfig"])
+
+	// the config overrides the parameters of the request, and is not modified
+	config := &genai.GenerateContentConfig{Temperature: utils.Ptr[float32](1)}
+	req.Config = config
+	_, err = cli.Generate(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, body["generationConfig"].(map[string]any)["temperature"])
+	require.Equal(t, 0.25, body["generationConfig"].(map[string]any)["topP"])
+	require.Nil(t, config.TopP)
+
+	req.Config = nil
+	req.Seed = utils.Ptr[int64](1 << 40)
+	_, err = cli.Generate(context.Background(), req)
+	require.ErrorContains(t, err, "seed should be a 32-bit integer")
+
+	req.Seed = nil
+	req.Tools = []llm.ToolDefinition{{Name: "lookup_party_positions"}}
+	_, err = cli.Generate(context.Background(), req)
+	require.ErrorIs(t, err, llm.ErrNotImplemented)
+}
+
+func TestGeminiSystemInstruction(t *testing.T) {
+	var body map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1beta/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"models/%s","displayName":"%[1]s","supportedGenerationMethods":["generateContent","embedContent"]}`,
+			r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithBaseURL(server.URL),
+	)
+	require.NoError(t, err)
+
+	req := &llm.GenerateRequest{
+		Messages: []llm.Message{
+			llm.NewTextMessage(llm.RoleSystem, "You extract the keywords of news articles."),
+			llm.NewTextMessage(llm.RoleUser, "新北市政府今日宣布，捷運三鶯線的整體工程進度已超過85%。"),
+			llm.NewTextMessage(llm.RoleSystem, "Answer in Traditional Chinese."),
+		},
+	}
+	_, err = cli.Generate(context.Background(), req)
+	require.NoError(t, err)
+
+	instruction := body["systemInstruction"].(map[string]any)["parts"].([]any)
+	require.Equal(t, []any{map[string]any{
+		"text": "You extract the keywords of news articles.\n\nAnswer in Traditional Chinese.",
+	}}, instruction)
+	contents := body["contents"].([]any)
+	require.Len(t, contents, 1)
+	require.Equal(t, "user", contents[0].(map[string]any)["role"])
+
+	// the system instruction of the config overrides the system messages
+	config := &genai.GenerateContentConfig{
+		SystemInstruction: genai.NewContentFromText("You summarize news articles.", genai.RoleUser),
+	}
+	req.Config = config
+	_, err = cli.Generate(context.Background(), req)
+	require.NoError(t, err)
+	instruction = body["systemInstruction"].(map[string]any)["parts"].([]any)
+	require.Equal(t, "You summarize news articles.", instruction[0].(map[string]any)["text"])
+
+	req.Config = nil
+	req.Messages = req.Messages[:1]
+	_, err = cli.Generate(context.Background(), req)
+	require.ErrorIs(t, err, llm.ErrNoInput)
+}
+
+func TestGeminiContentBlocked(t *testing.T) {
+	var body map[string]any
+	fixture := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1beta/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"models/%s","displayName":"%[1]s","supportedGenerationMethods":["generateContent","embedContent"]}`,
+			r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, fixture)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithBaseURL(server.URL),
+		gemini.WithSafetySettings(&genai.SafetySetting{
+			Category:  genai.HarmCategoryCivicIntegrity,
+			Threshold: genai.HarmBlockThresholdBlockOnlyHigh,
+		}),
+	)
+	require.NoError(t, err)
+
+	req := &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "Extract the keywords of the article.")},
+	}
+
+	t.Run("prompt", func(t *testing.T) {
+		fixture = "./generate_blocked_prompt.json"
+		_, err := cli.Generate(context.Background(), req)
+		require.ErrorIs(t, err, llm.ErrContentBlocked)
+		var blocked *gemini.BlockedError
+		require.ErrorAs(t, err, &blocked)
+		require.Equal(t, "SAFETY", blocked.Reason)
+		require.Len(t, blocked.SafetyRatings, 4)
+		require.ErrorContains(t, err, "HARM_CATEGORY_HATE_SPEECH (MEDIUM)")
+
+		require.Equal(t, []any{map[string]any{
+			"category":  "HARM_CATEGORY_CIVIC_INTEGRITY",
+			"threshold": "BLOCK_ONLY_HIGH",
+		}}, body["safetySettings"])
+	})
+
+	t.Run("response", func(t *testing.T) {
+		fixture = "./generate_blocked_response.json"
+		_, err := cli.Generate(context.Background(), req)
+		require.ErrorIs(t, err, llm.ErrContentBlocked)
+		var blocked *gemini.BlockedError
+		require.ErrorAs(t, err, &blocked)
+		require.Equal(t, "SAFETY", blocked.Reason)
+		require.ErrorContains(t, err, "HARM_CATEGORY_HARASSMENT (HIGH)")
+		require.NotContains(t, err.Error(), "HATE_SPEECH")
+	})
+
+	t.Run("config safety settings", func(t *testing.T) {
+		fixture = "./generate_blocked_response.json"
+		req := *req
+		req.Config = &genai.GenerateContentConfig{
+			SafetySettings: []*genai.SafetySetting{{
+				Category:  genai.HarmCategoryHarassment,
+				Threshold: genai.HarmBlockThresholdBlockNone,
+			}},
+		}
+		_, err := cli.Generate(context.Background(), &req)
+		require.ErrorIs(t, err, llm.ErrContentBlocked)
+		require.Len(t, body["safetySettings"], 1)
+		require.Equal(t, "HARM_CATEGORY_HARASSMENT", body["safetySettings"].([]any)[0].(map[string]any)["category"])
+	})
+
+	_, err = gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithBaseURL(server.URL),
+		gemini.WithSafetySettings(&genai.SafetySetting{Category: genai.HarmCategoryHarassment}),
+	)
+	require.ErrorContains(t, err, "threshold")
+}
+
+func TestGeminiTokenCount(t *testing.T) {
+	var body map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1beta/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"models/%s","displayName":"%[1]s","inputTokenLimit":1048576,"outputTokenLimit":65536,`+
+			`"supportedGenerationMethods":["generateContent","countTokens","embedContent"]}`, r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "gemini-2.5-flash:countTokens", r.PathValue("action"))
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"totalTokens":57}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithBaseURL(server.URL),
+	)
+	require.NoError(t, err)
+
+	m, ok := cli.DefaultModel(llm.ModelGenerate)
+	require.True(t, ok)
+	require.Equal(t, 1048576, m.(llm.InputLimiter).MaxInputTokens())
+	require.Equal(t, 65536, m.(llm.OutputLimiter).MaxOutputTokens())
+
+	msgs := []llm.Message{
+		llm.NewTextMessage(llm.RoleSystem, "You extract the keywords of news articles."),
+		llm.NewTextMessage(llm.RoleUser, "新北市政府今日宣布，捷運三鶯線的整體工程進度已超過85%。"),
+	}
+	n, err := llm.TokenCount(context.Background(), cli, "", msgs)
+	require.NoError(t, err)
+	require.Equal(t, 57, n)
+	contents := body["contents"].([]any)
+	require.Len(t, contents, 2)
+	require.Equal(t, "You extract the keywords of news articles.",
+		contents[0].(map[string]any)["parts"].([]any)[0].(map[string]any)["text"])
+
+	// a prompt of system messages only
+	_, err = cli.TokenCount(context.Background(), "", msgs[:1])
+	require.NoError(t, err)
+	require.Len(t, body["contents"], 1)
+
+	_, err = cli.TokenCount(context.Background(), "", nil)
+	require.ErrorIs(t, err, llm.ErrNoInput)
+
+	_, err = llm.TokenCount(context.Background(), llmtest.NewMockLLM(), "", msgs)
+	require.ErrorIs(t, err, llm.ErrNotImplemented)
+}
+
+func TestGeminiRetry(t *testing.T) {
+	var mu sync.Mutex
+	var at []time.Time
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1beta/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"models/%s","displayName":"%[1]s","supportedGenerationMethods":["generateContent","embedContent"]}`,
+			r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		at = append(at, time.Now())
+		n := len(at)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if n%2 == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"code":429,"status":"RESOURCE_EXHAUSTED",` +
+				`"message":"You exceeded your current quota, please check your plan and billing details.",` +
+				`"details":[{"@type":"type.googleapis.com/google.rpc.QuotaFailure","violations":[{"quotaId":"GenerateRequestsPerMinutePerProjectPerModel-FreeTier"}]},` +
+				`{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"0.3s"}]}}`))
+			return
+		}
+		if strings.HasSuffix(r.PathValue("action"), ":batchEmbedContents") {
+			w.Write([]byte(`{"embeddings":[{"values":[0.1,0.2]}]}`))
+			return
+		}
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	policy := llm.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	newClient := func(t *testing.T, opts ...gemini.Option) *gemini.Client {
+		cli, err := gemini.Gemini(context.Background(), append([]gemini.Option{
+			gemini.WithAPIKey("my-gemini-key"),
+			gemini.WithBaseURL(server.URL),
+			gemini.WithRetryPolicy(policy),
+		}, opts...)...)
+		require.NoError(t, err)
+		return cli
+	}
+	req := &llm.GenerateRequest{Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")}}
+
+	t.Run("honored", func(t *testing.T) {
+		at = nil
+		resp, err := newClient(t, gemini.WithRetryAfter(time.Second)).Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, []string{"ok"}, resp.Outputs)
+		require.Len(t, at, 2)
+		require.GreaterOrEqual(t, at[1].Sub(at[0]), 300*time.Millisecond)
+	})
+
+	t.Run("capped", func(t *testing.T) {
+		at = nil
+		_, err := newClient(t, gemini.WithRetryAfter(10*time.Millisecond)).Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.Less(t, at[1].Sub(at[0]), 300*time.Millisecond)
+	})
+
+	t.Run("ignored by default", func(t *testing.T) {
+		at = nil
+		_, err := newClient(t).Embed(context.Background(), &llm.EmbedRequest{
+			Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("三鶯線")},
+		})
+		require.NoError(t, err)
+		require.Len(t, at, 2)
+		require.Less(t, at[1].Sub(at[0]), 300*time.Millisecond)
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		at = nil
+		cli := newClient(t, gemini.WithRetryPolicy(llm.RetryPolicy{MaxAttempts: 1}))
+		_, err := cli.Generate(context.Background(), req)
+		var apiErr genai.APIError
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusTooManyRequests, apiErr.Code)
+		require.Len(t, at, 1)
+	})
+
+	_, err := gemini.Gemini(context.Background(), gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithRetryAfter(0))
+	require.Error(t, err)
+}
+
+func TestGeminiEmbedDimensions(t *testing.T) {
+	var body map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1beta/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"models/%s","displayName":"%[1]s","supportedGenerationMethods":["generateContent","embedContent"]}`,
+			r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"embeddings":[{"values":[0.1,0.2]}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithBaseURL(server.URL),
+	)
+	require.NoError(t, err)
+
+	dimensionality := func() any {
+		return body["requests"].([]any)[0].(map[string]any)["outputDimensionality"]
+	}
+	req := &llm.EmbedRequest{Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("三鶯線")}}
+	resp, err := cli.Embed(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, resp.Embeddings, 1)
+	require.Nil(t, dimensionality())
+
+	req.Dimensions = utils.Ptr(768)
+	_, err = cli.Embed(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, float64(768), dimensionality())
+
+	// the config overrides the dimensions of the request, and is not modified
+	config := &genai.EmbedContentConfig{TaskType: "RETRIEVAL_DOCUMENT"}
+	req.Config = config
+	_, err = cli.Embed(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, float64(768), dimensionality())
+	require.Nil(t, config.OutputDimensionality)
+
+	config.OutputDimensionality = utils.Ptr[int32](256)
+	_, err = cli.Embed(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, float64(256), dimensionality())
+
+	req.Config = nil
+	req.Dimensions = utils.Ptr(-1)
+	_, err = cli.Embed(context.Background(), req)
+	require.ErrorIs(t, err, llm.ErrInvalidDimensions)
+
+	req.Dimensions = utils.Ptr(256)
+	req.ModelName = "models/embedding-001"
+	_, err = cli.Embed(context.Background(), req)
+	require.ErrorIs(t, err, llm.ErrInvalidDimensions)
+	require.ErrorContains(t, err, "embedding-001")
+}
+
+func TestGeminiEmbedTaskType(t *testing.T) {
+	var body map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1beta/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"models/%s","displayName":"%[1]s","supportedGenerationMethods":["generateContent","embedContent"]}`,
+			r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"embeddings":[{"values":[0.1,0.2]}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithBaseURL(server.URL),
+	)
+	require.NoError(t, err)
+
+	taskType := func() any {
+		return body["requests"].([]any)[0].(map[string]any)["taskType"]
+	}
+	req := &llm.EmbedRequest{
+		Inputs:   []llm.EmbedInput{llm.NewSimpleTextInput("三鶯線")},
+		TaskType: gemini.EmbedTaskRetrivalQuery,
+	}
+	_, err = cli.Embed(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, "RETRIEVAL_QUERY", taskType())
+
+	// the config overrides the task type of the request
+	req.Config = &genai.EmbedContentConfig{TaskType: gemini.EmbedTaskClustering}
+	_, err = cli.Embed(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, "CLUSTERING", taskType())
+
+	req.Config = nil
+	req.TaskType = "retrieval_query"
+	_, err = cli.Embed(context.Background(), req)
+	require.ErrorIs(t, err, gemini.ErrInvalidTask)
+	require.ErrorContains(t, err, "SEMANTIC_SIMILARITY")
+}
+
+func TestGeminiProxy(t *testing.T) {
+	proxy := llmtest.NewProxy()
+	defer proxy.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1beta/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"models/%s","displayName":"%[1]s","supportedGenerationMethods":["generateContent","embedContent"]}`,
+			r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithBaseURL(server.URL),
+		gemini.WithProxy(proxy.ProxyURL("weathercock", "s3cret")),
+	)
+	require.NoError(t, err)
+	_, err = cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+	})
+	require.NoError(t, err)
+
+	var targets []string
+	for _, r := range proxy.Requests() {
+		require.Equal(t, "Basic d2VhdGhlcmNvY2s6czNjcmV0", r.Auth)
+		targets = append(targets, r.Method+" "+strings.TrimPrefix(r.Target, server.URL))
+	}
+	// the models are validated through the proxy too
+	require.ElementsMatch(t, []string{
+		"GET /v1beta/models/" + gemini.DefaultGenModel,
+		"GET /v1beta/models/" + gemini.DefaultEmbedModel,
+		"POST /v1beta/models/" + gemini.DefaultGenModel + ":generateContent",
+	}, targets)
+
+	_, err = gemini.Gemini(context.Background(), gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithProxy("ftp://proxy.internal"))
+	require.ErrorIs(t, err, llm.ErrInvalidProxy)
+}
+
+// staticToken is a TokenProvider of a fixed access token.
+type staticToken string
+
+func (tok staticToken) Token(ctx context.Context) (*auth.Token, error) {
+	return &auth.Token{Value: string(tok), Type: "Bearer", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestGeminiVertex(t *testing.T) {
+	var paths []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1beta1/publishers/google/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer vertex-token", r.Header.Get("Authorization"))
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		// Vertex AI does not report the supported actions of its models
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"publishers/google/models/%s","versionId":"default"}`, r.PathValue("model"))
+	})
+	mux.HandleFunc("POST /v1beta1/projects/weathercock/locations/us-central1/publishers/google/models/{action}",
+		func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "Bearer vertex-token", r.Header.Get("Authorization"))
+			require.Empty(t, r.Header.Get("x-goog-api-key"))
+			paths = append(paths, r.Method+" "+r.URL.Path)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`))
+		})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	creds := auth.NewCredentials(&auth.CredentialsOptions{TokenProvider: staticToken("vertex-token")})
+	for _, tc := range []struct {
+		name string
+		opts []gemini.Option
+	}{
+		{name: "sdk client"},
+		{name: "transport", opts: []gemini.Option{gemini.WithTransport(http.DefaultTransport)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			paths = nil
+			cli, err := gemini.Gemini(context.Background(), append([]gemini.Option{
+				gemini.WithVertex("weathercock", "us-central1"),
+				gemini.WithCredentials(creds),
+				gemini.WithBaseURL(server.URL),
+				gemini.WithModel(
+					gemini.NewGeminiModel(llm.ModelGenerate, "models/"+gemini.DefaultGenModel),
+					gemini.NewGeminiModel(llm.ModelEmbed, gemini.DefaultEmbedModel),
+				),
+				gemini.WithDefaultGenerate("models/" + gemini.DefaultGenModel),
+			}, tc.opts...)...)
+			require.NoError(t, err)
+
+			resp, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+				Messages:  []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+				ModelName: gemini.DefaultGenModel,
+			})
+			require.NoError(t, err)
+			require.Equal(t, []string{"ok"}, resp.Outputs)
+			require.ElementsMatch(t, []string{
+				"GET /v1beta1/publishers/google/models/" + gemini.DefaultGenModel,
+				"GET /v1beta1/publishers/google/models/" + gemini.DefaultEmbedModel,
+				"POST /v1beta1/projects/weathercock/locations/us-central1/publishers/google/models/" +
+					gemini.DefaultGenModel + ":generateContent",
+			}, paths)
+
+			_, err = cli.BatchCreate(context.Background(), &llm.BatchRequest{
+				Requests: []llm.Request{&llm.EmbedRequest{Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("三鶯線")}}},
+			})
+			require.ErrorIs(t, err, llm.ErrNotImplemented)
+		})
+	}
+
+	_, err := gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithVertex("weathercock", "us-central1"),
+	)
+	require.ErrorIs(t, err, gemini.ErrAuthConflict)
+
+	_, err = gemini.Gemini(context.Background(),
+		gemini.WithAPIKey("my-gemini-key"),
+		gemini.WithCredentials(creds),
+	)
+	require.ErrorContains(t, err, "WithVertex")
+
+	_, err = gemini.Gemini(context.Background(), gemini.WithVertex("weathercock", ""))
+	require.ErrorContains(t, err, "location")
+}
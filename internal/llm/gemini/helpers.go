@@ -3,9 +3,13 @@ package gemini
 import (
 	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"slices"
 	"strings"
 
 	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
 	"google.golang.org/genai"
 )
 
@@ -28,28 +32,75 @@ func IsTerminalJobState(status genai.JobState) bool {
 }
 
 // toGenAIContents converts a slice of llm.Message to a slice of *genai.Content.
+// The system messages are left out, they are the system instruction of the
+// config of the request, see systemInstruction.
 func toGenAIContents(messages []llm.Message) ([]*genai.Content, error) {
-	contents := make([]*genai.Content, len(messages))
-	for i, msg := range messages {
-		parts := make([]*genai.Part, len(msg.Content))
-		for j, text := range msg.Content {
-			parts[j] = genai.NewPartFromText(text)
+	contents := make([]*genai.Content, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == llm.RoleSystem {
+			continue
+		}
+
+		mParts := msg.ContentParts()
+		parts := make([]*genai.Part, len(mParts))
+		for j, part := range mParts {
+			switch p := part.(type) {
+			case llm.TextPart:
+				parts[j] = genai.NewPartFromText(p.Text)
+			case llm.ImagePart:
+				if err := p.Validate(); err != nil {
+					return nil, fmt.Errorf("%w: %w", ErrMassageConvertFailed, err)
+				}
+				if p.URL != "" {
+					parts[j] = genai.NewPartFromURI(p.URL, p.MIME)
+				} else {
+					parts[j] = genai.NewPartFromBytes(p.Data, p.MIME)
+				}
+			default:
+				return nil, fmt.Errorf("%w: unsupported part: %T", ErrMassageConvertFailed, part)
+			}
 		}
 
 		var role genai.Role
 		switch msg.Role {
 		case llm.RoleUser:
 			role = genai.RoleUser
-		case llm.RoleSystem, llm.RoleAssistant:
+		case llm.RoleAssistant:
 			role = genai.RoleModel
 		default:
 			return nil, fmt.Errorf("%w: unsupported role: %s", ErrMassageConvertFailed, msg.Role)
 		}
-		contents[i] = genai.NewContentFromParts(parts, role)
+		contents = append(contents, genai.NewContentFromParts(parts, role))
+	}
+	if len(contents) == 0 {
+		return nil, fmt.Errorf("%w: no user or assistant message", llm.ErrNoInput)
 	}
 	return contents, nil
 }
 
+// systemInstruction returns the texts of the system messages, concatenated in
+// order, as a system instruction, nil if there is none. The Gemini API takes
+// the text in the contents as the user's.
+func systemInstruction(messages []llm.Message) (*genai.Content, error) {
+	var texts []string
+	for _, msg := range messages {
+		if msg.Role != llm.RoleSystem {
+			continue
+		}
+		for _, part := range msg.ContentParts() {
+			p, ok := part.(llm.TextPart)
+			if !ok {
+				return nil, fmt.Errorf("%w: unsupported part in system message: %T", ErrMassageConvertFailed, part)
+			}
+			texts = append(texts, p.Text)
+		}
+	}
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	return genai.NewContentFromText(strings.Join(texts, "\n\n"), genai.RoleUser), nil
+}
+
 // assertAs performs a type assertion, returning the result or an error if the assertion fails.
 func assertAs[T any](conf any) (T, error) {
 	if conf == nil {
@@ -64,21 +115,119 @@ func assertAs[T any](conf any) (T, error) {
 	return gConf, nil
 }
 
-func extractJSONObject(s string) (string, error) {
-	start := strings.Index(s, "{")
-	if start == -1 {
-		return "", fmt.Errorf("could not find opening brace '{' in the string")
+// toGenerateContentConfig converts the generation parameters and the system
+// messages of req to a GenerateContentConfig, the fields set in req.Config
+// overriding them. The config of req is copied, not modified. The tools are
+// not supported yet.
+func toGenerateContentConfig(req *llm.GenerateRequest) (*genai.GenerateContentConfig, error) {
+	if req.UsesTools() {
+		return nil, fmt.Errorf("%w: tool calls are not supported by the gemini client", llm.ErrNotImplemented)
+	}
+
+	conf, err := assertAs[*genai.GenerateContentConfig](req.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &genai.GenerateContentConfig{}
+	if conf != nil {
+		*config = *conf
+	}
+	if config.Temperature == nil {
+		config.Temperature = req.Temperature
+	}
+	if config.TopP == nil {
+		config.TopP = req.TopP
+	}
+	if config.MaxOutputTokens == 0 && req.MaxTokens != nil {
+		if *req.MaxTokens > math.MaxInt32 {
+			return nil, fmt.Errorf("max tokens should not exceed %d, got %d", math.MaxInt32, *req.MaxTokens)
+		}
+		config.MaxOutputTokens = int32(*req.MaxTokens)
+	}
+	if len(config.StopSequences) == 0 {
+		config.StopSequences = req.Stop
+	}
+	if config.SystemInstruction == nil {
+		if config.SystemInstruction, err = systemInstruction(req.Messages); err != nil {
+			return nil, err
+		}
+	}
+	if config.Seed == nil && req.Seed != nil {
+		if *req.Seed < math.MinInt32 || *req.Seed > math.MaxInt32 {
+			return nil, fmt.Errorf("seed should be a 32-bit integer, got %d", *req.Seed)
+		}
+		config.Seed = utils.Ptr(int32(*req.Seed))
+	}
+
+	if conf == nil && reflect.ValueOf(*config).IsZero() {
+		return nil, nil
+	}
+	return config, nil
+}
+
+// fixedDimensionModels are the embedding models which do not support
+// truncating their embeddings with OutputDimensionality.
+var fixedDimensionModels = []string{"embedding-001"}
+
+// toEmbedContentConfig returns the EmbedContentConfig of req, with the
+// Dimensions of req as OutputDimensionality and its TaskType as TaskType,
+// unless the config sets them. The config of req is copied, not modified.
+func toEmbedContentConfig(modelName string, req *llm.EmbedRequest) (*genai.EmbedContentConfig, error) {
+	conf, err := assertAs[*genai.EmbedContentConfig](req.Config)
+	if err != nil {
+		return nil, err
+	}
+	if req.Dimensions == nil && req.TaskType == "" {
+		return conf, nil
+	}
+
+	if err := req.ValidateDimensions(); err != nil {
+		return nil, err
+	}
+	if req.Dimensions != nil {
+		if *req.Dimensions > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: should not exceed %d, got %d",
+				llm.ErrInvalidDimensions, math.MaxInt32, *req.Dimensions)
+		}
+		if slices.Contains(fixedDimensionModels, strings.TrimPrefix(modelName, "models/")) {
+			return nil, fmt.Errorf("%w: model %s does not support dimensions", llm.ErrInvalidDimensions, modelName)
+		}
+	}
+	if req.TaskType != "" && !slices.Contains(embedTasks, req.TaskType) {
+		return nil, fmt.Errorf("%w: %q, should be one of %s",
+			ErrInvalidTask, req.TaskType, strings.Join(embedTasks, ", "))
 	}
 
-	end := strings.LastIndex(s, "}")
-	if end == -1 {
-		return "", fmt.Errorf("could not find closing brace '}' in the string")
+	config := &genai.EmbedContentConfig{}
+	if conf != nil {
+		*config = *conf
 	}
+	if config.OutputDimensionality == nil && req.Dimensions != nil {
+		config.OutputDimensionality = utils.Ptr(int32(*req.Dimensions))
+	}
+	if config.TaskType == "" {
+		config.TaskType = req.TaskType
+	}
+	return config, nil
+}
 
-	if end < start {
-		return "", fmt.Errorf("found closing brace '}' before opening brace '{'")
+// finishReason returns the finish reason of the first candidate of resp, as
+// one of the llm.FinishReason constants where it has an equivalent.
+func finishReason(resp *genai.GenerateContentResponse) string {
+	if len(resp.Candidates) == 0 {
+		return ""
 	}
 
-	// Slice the string from the first '{' to the last '}'
-	return s[start : end+1], nil
+	switch reason := resp.Candidates[0].FinishReason; reason {
+	case genai.FinishReasonStop:
+		return llm.FinishReasonStop
+	case genai.FinishReasonMaxTokens:
+		return llm.FinishReasonLength
+	case genai.FinishReasonSafety, genai.FinishReasonBlocklist, genai.FinishReasonProhibitedContent,
+		genai.FinishReasonSPII, genai.FinishReasonImageSafety:
+		return llm.FinishReasonContentFilter
+	default:
+		return strings.ToLower(string(reason))
+	}
 }
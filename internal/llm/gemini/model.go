@@ -16,6 +16,19 @@ type GeminiModel struct {
 	llm.BaseModel
 }
 
+var (
+	_ llm.InputLimiter  = GeminiModel{}
+	_ llm.OutputLimiter = GeminiModel{}
+)
+
+// MaxInputTokens returns the InputTokenLimit of the model, fetched from the
+// API by Gemini, or 0 if it is unknown.
+func (model GeminiModel) MaxInputTokens() int { return int(model.InputTokenLimit) }
+
+// MaxOutputTokens returns the OutputTokenLimit of the model, fetched from the
+// API by Gemini, or 0 if it is unknown.
+func (model GeminiModel) MaxOutputTokens() int { return int(model.OutputTokenLimit) }
+
 // MarshalJSON customizes the JSON marshaling of GeminiModel.
 func (model GeminiModel) MarshalJSON() ([]byte, error) {
 	type Alias GeminiModel
@@ -2,7 +2,14 @@ package gemini
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"time"
+
+	"cloud.google.com/go/auth"
+	"cloud.google.com/go/auth/credentials"
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"google.golang.org/genai"
 )
 
 var (
@@ -19,6 +26,50 @@ func WithAPIKey(apikey string) Option {
 	}
 }
 
+// WithVertex selects the Vertex AI backend of the project in location, e.g.
+// us-central1 or global, instead of the Gemini API. The requests are
+// authenticated with the credentials of WithCredentials or
+// WithCredentialsFile, the application default credentials otherwise, and
+// the client should not have an API key.
+func WithVertex(project, location string) Option {
+	return func(b *builder) error {
+		if project == "" || location == "" {
+			return fmt.Errorf("vertex project and location should not be empty")
+		}
+		b.Vertex = true
+		b.Project = project
+		b.Location = location
+		return nil
+	}
+}
+
+// WithCredentials authenticates the requests of a Vertex AI client with creds.
+func WithCredentials(creds *auth.Credentials) Option {
+	return func(b *builder) error {
+		if creds == nil {
+			return fmt.Errorf("credentials should not be nil")
+		}
+		b.Credentials = creds
+		return nil
+	}
+}
+
+// WithCredentialsFile authenticates the requests of a Vertex AI client with
+// the credentials of a JSON file, e.g. the key of a service account.
+func WithCredentialsFile(path string) Option {
+	return func(b *builder) error {
+		creds, err := credentials.DetectDefault(&credentials.DetectOptions{
+			Scopes:          []string{vertexScope},
+			CredentialsFile: path,
+		})
+		if err != nil {
+			return fmt.Errorf("could not read credentials file %s: %w", path, err)
+		}
+		b.Credentials = creds
+		return nil
+	}
+}
+
 // WithModel registers one or more Gemini models with the client.
 func WithModel(models ...GeminiModel) Option {
 	return func(b *builder) error {
@@ -56,6 +107,77 @@ func WithAPIVersion(ver string) Option {
 	}
 }
 
+// WithBaseURL sets the base URL of the Gemini API, e.g. of a proxy.
+func WithBaseURL(u string) Option {
+	return func(b *builder) error {
+		if _, err := url.Parse(u); err != nil {
+			return fmt.Errorf("invalid base url: %w", err)
+		}
+		b.BaseURL = u
+		return nil
+	}
+}
+
+// WithRetryPolicy sets the policy the generate and embed requests are retried
+// with, llm.DefaultRetryPolicy by default. The errors are classified by
+// IsTransient if its RetryOn is nil.
+func WithRetryPolicy(p llm.RetryPolicy) Option {
+	return func(b *builder) error {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("invalid retry policy: %w", err)
+		}
+		b.RetryPolicy = &p
+		return nil
+	}
+}
+
+// WithRetryAfter makes the retries of the requests wait the retry delay the
+// Gemini API asks for in the RetryInfo of a rate limited response, up to
+// maxWait, when it is longer than the delay of the retry policy.
+func WithRetryAfter(maxWait time.Duration) Option {
+	return func(b *builder) error {
+		if maxWait <= 0 {
+			return fmt.Errorf("max retry after should be positive, got %s", maxWait)
+		}
+		b.RetryAfter = maxWait
+		return nil
+	}
+}
+
+// WithRateLimit limits the generate and embed requests of the client to rps
+// per second, with bursts of up to burst requests. The limit is shared by the
+// goroutines using the client.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(b *builder) error {
+		l, err := llm.NewRateLimiter(rps, burst)
+		if err != nil {
+			return fmt.Errorf("invalid rate limit: %w", err)
+		}
+		b.Limiter = l
+		return nil
+	}
+}
+
+// WithSafetySettings sets the safety settings of the generation requests
+// whose config sets none, e.g. to relax the thresholds of the categories the
+// political news trip:
+//
+//	WithSafetySettings(&genai.SafetySetting{
+//		Category:  genai.HarmCategoryCivicIntegrity,
+//		Threshold: genai.HarmBlockThresholdBlockOnlyHigh,
+//	})
+func WithSafetySettings(settings ...*genai.SafetySetting) Option {
+	return func(b *builder) error {
+		for i, s := range settings {
+			if s == nil || s.Category == "" || s.Threshold == "" {
+				return fmt.Errorf("safety setting %d should have a category and a threshold", i)
+			}
+		}
+		b.Safety = settings
+		return nil
+	}
+}
+
 // WithTimeout sets the timeout for API requests.
 func WithTimeout(timeout time.Duration) Option {
 	return func(b *builder) error {
@@ -63,3 +185,28 @@ func WithTimeout(timeout time.Duration) Option {
 		return nil
 	}
 }
+
+// WithTransport sends the requests of the client, the validation of the
+// models included, with rt.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(b *builder) error {
+		if rt == nil {
+			return fmt.Errorf("transport should not be nil")
+		}
+		b.Transport = rt
+		return nil
+	}
+}
+
+// WithProxy sends the requests of the client through the proxy of rawURL, see
+// llm.ProxyTransport. It replaces the transport of WithTransport.
+func WithProxy(rawURL string) Option {
+	return func(b *builder) error {
+		t, err := llm.ProxyTransport(rawURL)
+		if err != nil {
+			return err
+		}
+		b.Transport = t
+		return nil
+	}
+}
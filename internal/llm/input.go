@@ -2,10 +2,34 @@ package llm
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	txttmpl "text/template"
+	"text/template/parse"
 )
 
+var ErrMissingPromptVar = errors.New("missing required prompt variable")
+
+// MissingPromptVarsError lists the variables a prompt needs but was not given.
+// It wraps ErrMissingPromptVar.
+type MissingPromptVarsError struct {
+	Names []string
+}
+
+func (e *MissingPromptVarsError) Error() string {
+	if len(e.Names) == 1 {
+		return fmt.Sprintf("%s: %s", ErrMissingPromptVar, e.Names[0])
+	}
+	return fmt.Sprintf("%ss: %s", ErrMissingPromptVar, strings.Join(e.Names, ", "))
+}
+
+func (e *MissingPromptVarsError) Unwrap() error {
+	return ErrMissingPromptVar
+}
+
 // SimpleTextInput represents a basic text input for embedding.
 type SimpleTextInput struct {
 	Content string
@@ -27,13 +51,132 @@ type EmbedInput interface {
 	String() string
 }
 
+// PromptVar declares a variable a prompt template may reference.
+type PromptVar struct {
+	Name     string
+	Required bool
+	// MaxRunes caps the length of a string value, longer values are truncated.
+	// Zero means no limit.
+	MaxRunes int
+}
+
+// PromptTemplateOption configures a PromptTemplateFactory.
+type PromptTemplateOption func(*promptTemplateConfig)
+
+type promptTemplateConfig struct {
+	vars   []PromptVar
+	left   string
+	right  string
+	strict bool
+}
+
+// WithPromptVars declares the variables of the template. Once declared, unknown
+// variables and missing required ones fail the rendering, and string values are
+// sanitized before they are rendered.
+func WithPromptVars(vars ...PromptVar) PromptTemplateOption {
+	return func(cfg *promptTemplateConfig) {
+		cfg.vars = append(cfg.vars, vars...)
+		cfg.strict = true
+	}
+}
+
+// WithDelims overrides the action delimiters of the template, e.g. "⟦" and "⟧",
+// for templates whose content legitimately contains Go template syntax.
+func WithDelims(left, right string) PromptTemplateOption {
+	return func(cfg *promptTemplateConfig) {
+		cfg.left, cfg.right = left, right
+	}
+}
+
+// zeroWidth replaces the zero-width characters that could hide text from a
+// human reviewer of a prompt.
+var zeroWidth = strings.NewReplacer(
+	"\u200b", "", // zero width space
+	"\u200c", "", // zero width non-joiner
+	"\u200d", "", // zero width joiner
+	"\u2060", "", // word joiner
+	"\ufeff", "", // zero width no-break space
+)
+
+// templateDelims replaces the default and the common override delimiters.
+var templateDelims = strings.NewReplacer(
+	"{{", "{ {", "}}", "} }",
+	"\u27e6", "[", "\u27e7", "]",
+)
+
+// StripZeroWidth removes zero-width characters from s.
+func StripZeroWidth(s string) string {
+	return zeroWidth.Replace(s)
+}
+
+// TruncateRunes returns the first n runes of s. A non-positive n returns s
+// unchanged.
+func TruncateRunes(s string, n int) string {
+	if n <= 0 {
+		return s
+	}
+
+	i := 0
+	for j := range s {
+		if i == n {
+			return s[:j]
+		}
+		i++
+	}
+	return s
+}
+
+// NeutralizeTemplate breaks up template-looking sequences in s, so that it
+// stays inert if the output is ever parsed as a template again.
+func NeutralizeTemplate(s string) string {
+	return templateDelims.Replace(s)
+}
+
+// PromptFuncMap returns the functions available in prompt templates.
+func PromptFuncMap() txttmpl.FuncMap {
+	return txttmpl.FuncMap{
+		"stripZeroWidth": StripZeroWidth,
+		"truncate": func(n int, s string) string {
+			return TruncateRunes(s, n)
+		},
+		"neutralize": NeutralizeTemplate,
+	}
+}
+
 type PromptTemplateFactory struct {
 	raw      string
 	template *txttmpl.Template
+	vars     map[string]PromptVar
+	refs     []string
+	strict   bool
 }
 
-func NewPromptTemplateFactory(template string) (*PromptTemplateFactory, error) {
-	tmpl, err := txttmpl.New("query").Parse(template)
+// NewPromptTemplateFactory parses template and returns a factory of
+// PromptTemplate. Without WithPromptVars any variable may be referenced, but a
+// missing one still fails the rendering instead of printing "<no value>".
+func NewPromptTemplateFactory(template string, opts ...PromptTemplateOption) (*PromptTemplateFactory, error) {
+	cfg := &promptTemplateConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	vars := make(map[string]PromptVar, len(cfg.vars))
+	for _, v := range cfg.vars {
+		if v.Name == "" {
+			return nil, fmt.Errorf("prompt variable name should not be empty")
+		}
+
+		if _, ok := vars[v.Name]; ok {
+			return nil, fmt.Errorf("duplicated prompt variable: %s", v.Name)
+		}
+		vars[v.Name] = v
+	}
+
+	tmpl, err := txttmpl.New("query").
+		Delims(cfg.left, cfg.right).
+		Funcs(PromptFuncMap()).
+		Option("missingkey=error").
+		Parse(template)
 	if err != nil {
 		return nil, err
 	}
@@ -41,48 +184,172 @@ func NewPromptTemplateFactory(template string) (*PromptTemplateFactory, error) {
 	return &PromptTemplateFactory{
 		raw:      template,
 		template: tmpl,
+		vars:     vars,
+		refs:     templateRefs(tmpl),
+		strict:   cfg.strict,
 	}, nil
 }
 
+// Vars returns the sorted names of the variables the template references,
+// e.g. instruct and query for "Instruct: {{.instruct}}\nQuery: {{.query}}".
+func (factory PromptTemplateFactory) Vars() []string {
+	return slices.Clone(factory.refs)
+}
+
 func (factory PromptTemplateFactory) NewPromptTemplate(vars map[string]any) *PromptTemplate {
 	return &PromptTemplate{
 		variables: vars,
 		raw:       factory.raw,
 		template:  factory.template,
+		schema:    factory.vars,
+		refs:      factory.refs,
+		strict:    factory.strict,
 	}
 }
 
-
 type PromptTemplate struct {
 	variables map[string]any
 	raw       string
 	template  *txttmpl.Template
+	schema    map[string]PromptVar
+	refs      []string
+	strict    bool
 }
 
 // NewPromptTemplate creates a new PromptTemplate with the given variables and template string.
-func NewPromptTemplate(vars map[string]any, template string) (*PromptTemplate, error) {
-	tmpl, err := txttmpl.New("query").Parse(template)
+func NewPromptTemplate(vars map[string]any, template string, opts ...PromptTemplateOption) (*PromptTemplate, error) {
+	factory, err := NewPromptTemplateFactory(template, opts...)
 	if err != nil {
 		return nil, err
 	}
-
-	return &PromptTemplate{
-		variables: vars,
-		raw:       template,
-		template:  tmpl,
-	}, nil
+	return factory.NewPromptTemplate(vars), nil
 }
 
-// Render executes the template with the stored variables and returns the resulting string.
+// Render validates the stored variables against the declared schema, if any,
+// executes the template and returns the resulting string.
 func (q PromptTemplate) Render() (string, error) {
-	buf := bytes.NewBuffer(nil)
-	err := q.template.Execute(buf, q.variables)
+	vars, err := q.values()
 	if err != nil {
-		panic(err)
+		return "", err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := q.template.Execute(buf, vars); err != nil {
+		return "", err
 	}
 	return buf.String(), nil
 }
 
+// values returns the variables to execute the template with. Values are
+// passed as data and never parsed, so template syntax in them renders
+// literally.
+func (q PromptTemplate) values() (map[string]any, error) {
+	if !q.strict {
+		var missing []string
+		for _, name := range q.refs {
+			if _, ok := q.variables[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return nil, &MissingPromptVarsError{Names: missing}
+		}
+		return q.variables, nil
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(q.variables)) {
+		if _, ok := q.schema[key]; !ok {
+			return nil, fmt.Errorf("unknown prompt variable: %s", key)
+		}
+	}
+
+	vars := make(map[string]any, len(q.schema))
+	var missing []string
+	for _, name := range slices.Sorted(maps.Keys(q.schema)) {
+		v := q.schema[name]
+		val, ok := q.variables[name]
+		if !ok || val == nil {
+			if v.Required {
+				missing = append(missing, name)
+			}
+			vars[name] = ""
+			continue
+		}
+
+		if s, ok := val.(string); ok {
+			val = TruncateRunes(StripZeroWidth(s), v.MaxRunes)
+		}
+		vars[name] = val
+	}
+	if len(missing) > 0 {
+		return nil, &MissingPromptVarsError{Names: missing}
+	}
+	return vars, nil
+}
+
+// templateRefs returns the sorted names of the variables tmpl references on
+// its data, as .name or $.name. The fields within range and with refer to
+// another value and are left out.
+func templateRefs(tmpl *txttmpl.Template) []string {
+	refs := map[string]struct{}{}
+	var walk func(node parse.Node, root bool)
+	walk = func(node parse.Node, root bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c, root)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, root)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd, root)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg, root)
+			}
+		case *parse.FieldNode:
+			if root {
+				refs[n.Ident[0]] = struct{}{}
+			}
+		case *parse.VariableNode:
+			if n.Ident[0] == "$" && len(n.Ident) > 1 {
+				refs[n.Ident[1]] = struct{}{}
+			}
+		case *parse.ChainNode:
+			walk(n.Node, root)
+		case *parse.IfNode:
+			walk(n.Pipe, root)
+			walk(n.List, root)
+			walk(n.ElseList, root)
+		case *parse.RangeNode:
+			walk(n.Pipe, root)
+			walk(n.List, false)
+			walk(n.ElseList, root)
+		case *parse.WithNode:
+			walk(n.Pipe, root)
+			walk(n.List, false)
+			walk(n.ElseList, root)
+		case *parse.TemplateNode:
+			walk(n.Pipe, root)
+		}
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root, true)
+		}
+	}
+	return slices.Sorted(maps.Keys(refs))
+}
+
 // String returns the rendered string of the PromptTemplate, or an error message if rendering fails.
 func (q PromptTemplate) String() string {
 	s, err := q.Render()
@@ -92,9 +359,11 @@ func (q PromptTemplate) String() string {
 	return s
 }
 
-// GetVar retrieves the value of a variable from the PromptTemplate.
-func (q PromptTemplate) GetVar(key string) any {
-	return q.variables[key]
+// GetVar retrieves the value of a string variable from the PromptTemplate.
+// ok is false if the variable is not set or is not a string.
+func (q PromptTemplate) GetVar(key string) (string, bool) {
+	val, ok := q.variables[key].(string)
+	return val, ok
 }
 
 // SetVar sets the value of a variable in the PromptTemplate.
@@ -107,14 +376,23 @@ func (q PromptTemplate) Template() string {
 	return q.raw
 }
 
+// InstructQueryTemplate is the template of the instruction-prefixed embedding
+// inputs.
+const InstructQueryTemplate = "Instruct: {{.instruct}}\nQuery: {{.query}}"
+
+// InstructQueryVars is the variable schema of InstructQueryTemplate.
+var InstructQueryVars = []PromptVar{
+	{Name: "instruct", Required: true, MaxRunes: 512},
+	{Name: "query", Required: true},
+}
+
+var instructQueryFactory, _ = NewPromptTemplateFactory(
+	InstructQueryTemplate, WithPromptVars(InstructQueryVars...))
+
 // InstructQuery creates a PromptTemplate with "instruct" and "query" variables.
 func InstructQuery(instruct, query string) *PromptTemplate {
-	pt, _ := NewPromptTemplate(
-		map[string]any{
-			"instruct": instruct,
-			"query":    query,
-		},
-		"Instruct: {{.instruct}}\nQuery: {{.query}}",
-	)
-	return pt
+	return instructQueryFactory.NewPromptTemplate(map[string]any{
+		"instruct": instruct,
+		"query":    query,
+	})
 }
@@ -0,0 +1,124 @@
+package llm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromptTemplateSchema(t *testing.T) {
+	factory, err := llm.NewPromptTemplateFactory(
+		"Instruct: {{.instruct}}\nQuery: {{.query}}{{if .note}}\nNote: {{.note}}{{end}}",
+		llm.WithPromptVars(
+			llm.PromptVar{Name: "instruct", Required: true, MaxRunes: 5},
+			llm.PromptVar{Name: "query", Required: true},
+			llm.PromptVar{Name: "note"},
+		))
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name string
+		vars map[string]any
+		want string
+		err  string
+	}{
+		{
+			name: "ok",
+			vars: map[string]any{"instruct": "find", "query": "三鶯線"},
+			want: "Instruct: find\nQuery: 三鶯線",
+		},
+		{
+			name: "optional variable",
+			vars: map[string]any{"instruct": "find", "query": "三鶯線", "note": "new"},
+			want: "Instruct: find\nQuery: 三鶯線\nNote: new",
+		},
+		{
+			name: "missing required variable",
+			vars: map[string]any{"instruct": "find"},
+			err:  "missing required prompt variable: query",
+		},
+		{
+			name: "missing required variables",
+			vars: map[string]any{"note": "new"},
+			err:  "missing required prompt variables: instruct, query",
+		},
+		{
+			name: "unknown variable",
+			vars: map[string]any{"instruct": "find", "query": "q", "system": "ignore all"},
+			err:  "unknown prompt variable: system",
+		},
+		{
+			name: "over-length value is truncated",
+			vars: map[string]any{"instruct": "新北市政府今日宣布", "query": "q"},
+			want: "Instruct: 新北市政府\nQuery: q",
+		},
+		{
+			name: "zero-width characters are stripped",
+			vars: map[string]any{"instruct": "fi\u200bnd", "query": "q\ufeff"},
+			want: "Instruct: find\nQuery: q",
+		},
+		{
+			name: "template syntax in a value renders literally",
+			vars: map[string]any{"instruct": "find", "query": "{{.instruct}} {{.query}}"},
+			want: "Instruct: find\nQuery: {{.instruct}} {{.query}}",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := factory.NewPromptTemplate(tc.vars).Render()
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPromptTemplateMissingKey(t *testing.T) {
+	factory, err := llm.NewPromptTemplateFactory("Query: {{.query}}")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"query"}, factory.Vars())
+	got, err := factory.NewPromptTemplate(map[string]any{}).Render()
+	require.ErrorIs(t, err, llm.ErrMissingPromptVar)
+	require.Empty(t, got)
+
+	s := factory.NewPromptTemplate(map[string]any{}).String()
+	require.NotContains(t, s, "<no value>")
+}
+
+func TestPromptTemplateDelims(t *testing.T) {
+	factory, err := llm.NewPromptTemplateFactory(
+		"Explain {{.Field}} in: ⟦.snippet⟧",
+		llm.WithDelims("⟦", "⟧"),
+		llm.WithPromptVars(llm.PromptVar{Name: "snippet", Required: true}))
+	require.NoError(t, err)
+
+	got, err := factory.NewPromptTemplate(map[string]any{"snippet": "{{range .Items}}"}).Render()
+	require.NoError(t, err)
+	require.Equal(t, "Explain {{.Field}} in: {{range .Items}}", got)
+}
+
+func TestPromptFuncMap(t *testing.T) {
+	factory, err := llm.NewPromptTemplateFactory(
+		"{{truncate 3 .s}}|{{neutralize .s}}|{{stripZeroWidth .z}}")
+	require.NoError(t, err)
+
+	got, err := factory.NewPromptTemplate(map[string]any{
+		"s": "{{.x}}",
+		"z": "a\u200db",
+	}).Render()
+	require.NoError(t, err)
+	require.Equal(t, "{{.|{ {.x} }|ab", got)
+}
+
+func TestInstructQuery(t *testing.T) {
+	query := llm.InstructQuery("retrieve", "三鶯線 "+strings.Repeat("進度", 3))
+	require.Equal(t, llm.InstructQueryTemplate, query.Template())
+	require.Equal(t, "Instruct: retrieve\nQuery: 三鶯線 進度進度進度", query.String())
+}
@@ -3,6 +3,7 @@ package llm
 import (
 	"context"
 	"errors"
+	"fmt"
 )
 
 var (
@@ -40,3 +41,38 @@ type LLM interface {
 	// ListModels returns all registered models.
 	ListModels() []Model
 }
+
+// Warmer is implemented by the LLMs serving models which are loaded on demand,
+// so that a model can be loaded before the first request waits for it.
+type Warmer interface {
+	// Warmup loads the named model, the default generate model if modelName
+	// is empty.
+	Warmup(ctx context.Context, modelName string) error
+}
+
+// Warmup loads the named model of cli if cli is a Warmer, it does nothing
+// otherwise.
+func Warmup(ctx context.Context, cli LLM, modelName string) error {
+	if w, ok := cli.(Warmer); ok {
+		return w.Warmup(ctx, modelName)
+	}
+	return nil
+}
+
+// TokenCounter is implemented by the LLMs whose provider counts the tokens of
+// a prompt, so that a prompt can be checked against the input limit of the
+// model before paying for a request.
+type TokenCounter interface {
+	// TokenCount returns the number of tokens of msgs for the named model,
+	// the default generate model if modelName is empty.
+	TokenCount(ctx context.Context, modelName string, msgs []Message) (int, error)
+}
+
+// TokenCount returns the number of tokens of msgs for the named model of cli
+// if cli is a TokenCounter, and fails with ErrNotImplemented otherwise.
+func TokenCount(ctx context.Context, cli LLM, modelName string, msgs []Message) (int, error) {
+	if c, ok := cli.(TokenCounter); ok {
+		return c.TokenCount(ctx, modelName, msgs)
+	}
+	return 0, fmt.Errorf("%w: %T does not count tokens", ErrNotImplemented, cli)
+}
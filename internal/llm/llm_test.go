@@ -4,13 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/ChiaYuChang/weathercock/internal/global"
 	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/llm/anthropic"
 	"github.com/ChiaYuChang/weathercock/internal/llm/gemini"
 	"github.com/ChiaYuChang/weathercock/internal/llm/ollama"
 	"github.com/ChiaYuChang/weathercock/internal/llm/openai"
@@ -33,8 +38,196 @@ func TestNewPromptTemplate(t *testing.T) {
 	qText := query.String()
 	require.NotEmpty(t, qText)
 	require.Equal(t, template, query.Template())
-	require.Contains(t, qText, query.GetVar("instruct"))
-	require.Contains(t, qText, query.GetVar("query"))
+	for _, key := range []string{"instruct", "query"} {
+		val, ok := query.GetVar(key)
+		require.True(t, ok)
+		require.Contains(t, qText, val)
+	}
+
+	_, ok := query.GetVar("unknown")
+	require.False(t, ok)
+}
+
+func TestUsageJSON(t *testing.T) {
+	usage := llm.Usage{PromptTokens: 12, CompletionTokens: 34, TotalTokens: 46}
+	bs, err := json.Marshal(llm.GenerateResponse{Outputs: []string{"ok"}, Usage: usage})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"outputs":["ok"],"usage":{"prompt_tokens":12,"completion_tokens":34,"total_tokens":46}}`,
+		string(bs))
+
+	bs, err = json.Marshal(llm.EmbedResponse{Model: "embed", Usage: llm.Usage{PromptTokens: 8, TotalTokens: 8}})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"model":"embed","usage":{"prompt_tokens":8,"completion_tokens":0,"total_tokens":8}}`,
+		string(bs))
+}
+
+func TestMessageParts(t *testing.T) {
+	img := llm.ImagePart{MIME: "image/jpeg", Data: []byte("jpeg")}
+	msg := llm.Message{Role: llm.RoleUser, Content: []string{"hello"}, Parts: []llm.Part{img}}
+	require.Equal(t, []llm.Part{llm.TextPart{Text: "hello"}, img}, msg.ContentParts())
+	require.True(t, msg.HasImage())
+	require.False(t, llm.NewTextMessage(llm.RoleUser, "a", "b").HasImage())
+	require.Equal(t, []llm.Part{llm.TextPart{Text: "a"}, llm.TextPart{Text: "b"}},
+		llm.NewTextMessage(llm.RoleUser, "a", "b").ContentParts())
+
+	require.NoError(t, img.Validate())
+	require.Equal(t, "data:image/jpeg;base64,anBlZw==", img.DataURL())
+
+	url := llm.ImagePart{URL: "https://example.com/cat.png"}
+	require.NoError(t, url.Validate())
+	require.Equal(t, url.URL, url.DataURL())
+
+	for _, p := range []llm.ImagePart{
+		{},
+		{Data: []byte("jpeg")},
+		{MIME: "image/jpeg", Data: []byte("jpeg"), URL: url.URL},
+	} {
+		require.ErrorIs(t, p.Validate(), llm.ErrInvalidPart)
+	}
+}
+
+func TestToolDefinition(t *testing.T) {
+	type args struct {
+		Party string `json:"party"`
+	}
+
+	tool := llm.ToolDefinition{Name: "lookup_party_positions", Parameters: map[string]any{"type": "object"}}
+	require.NoError(t, tool.Validate())
+	params, err := tool.ParametersMap()
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"type": "object"}, params)
+
+	tool.Parameters = args{Party: "DPP"}
+	params, err = tool.ParametersMap()
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"party": "DPP"}, params)
+
+	tool.Parameters = []string{"party"}
+	_, err = tool.ParametersMap()
+	require.ErrorIs(t, err, llm.ErrInvalidTool)
+
+	tool.Parameters = nil
+	params, err = tool.ParametersMap()
+	require.NoError(t, err)
+	require.Nil(t, params)
+
+	require.ErrorIs(t, llm.ToolDefinition{Name: " "}.Validate(), llm.ErrInvalidTool)
+
+	req := llm.GenerateRequest{Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")}}
+	require.False(t, req.UsesTools())
+	req.Messages = append(req.Messages,
+		llm.NewToolCallMessage(llm.ToolCall{ID: "call_1", Name: tool.Name, Arguments: `{"party":"DPP"}`}))
+	require.True(t, req.UsesTools())
+	req.Messages = req.Messages[:1]
+	req.Tools = []llm.ToolDefinition{tool}
+	require.True(t, req.UsesTools())
+
+	msg := llm.NewToolMessage("call_1", "result")
+	require.Equal(t, llm.RoleTool, msg.Role)
+	require.Equal(t, "call_1", msg.ToolCallID)
+	require.Equal(t, []string{"result"}, msg.Content)
+}
+
+func TestNewFromConfig(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer my-openai-key", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"gpt-5-nano","object":"model","created":1754426384,"owned_by":"system"}]}`))
+	})
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":[]}`))
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"capabilities":["completion","embedding"]}`))
+	})
+	mux.HandleFunc("GET /v1/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "my-anthropic-key", r.Header.Get("x-api-key"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"type":"model","id":"%s","display_name":"Claude Haiku 3.5"}`, r.PathValue("model"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	require.Equal(t, []string{"anthropic", "gemini", "ollama", "openai"}, llm.Providers())
+
+	t.Run("openai", func(t *testing.T) {
+		keyFile := filepath.Join(t.TempDir(), "openai_api_key")
+		require.NoError(t, os.WriteFile(keyFile, []byte("my-openai-key\n"), 0o600))
+
+		cfg := global.LLMConfig{}.Default()
+		cfg.Provider = "openai"
+		cfg.OpenAI.APIKeyFile = keyFile
+		cfg.OpenAI.BaseURL = server.URL
+		cfg.OpenAI.Model = "gpt-5-nano"
+		cfg.OpenAI.EmbedDim = 256
+
+		cli, err := llm.NewFromConfig(context.Background(), cfg)
+		require.NoError(t, err)
+		require.IsType(t, &openai.Client{}, cli)
+		require.EqualValues(t, 256, cli.(*openai.Client).EmbedDim)
+
+		m, ok := cli.DefaultModel(llm.ModelEmbed)
+		require.True(t, ok)
+		require.Equal(t, string(openai.DefaultEmbedModel), m.Name())
+	})
+
+	t.Run("ollama", func(t *testing.T) {
+		cfg := global.LLMConfig{}.Default()
+		cfg.Ollama.BaseURL = server.URL
+		cfg.Ollama.Model = "gemma3:270m"
+
+		cli, err := llm.NewFromConfig(context.Background(), cfg)
+		require.NoError(t, err)
+		require.IsType(t, &ollama.Client{}, cli)
+
+		m, ok := cli.DefaultModel(llm.ModelGenerate)
+		require.True(t, ok)
+		require.Equal(t, "gemma3:270m", m.Name())
+	})
+
+	t.Run("anthropic", func(t *testing.T) {
+		cfg := global.LLMConfig{}.Default()
+		cfg.Provider = "anthropic"
+		cfg.Anthropic.APIKey = "my-anthropic-key"
+		cfg.Anthropic.BaseURL = server.URL
+		cfg.Anthropic.Model = "claude-3-5-haiku-latest"
+		cfg.Anthropic.MaxTokens = 1024
+
+		cli, err := llm.NewFromConfig(context.Background(), cfg)
+		require.NoError(t, err)
+		require.IsType(t, &anthropic.Client{}, cli)
+		require.Equal(t, 1024, cli.(*anthropic.Client).MaxTokens)
+
+		m, ok := cli.DefaultModel(llm.ModelGenerate)
+		require.True(t, ok)
+		require.Equal(t, "claude-3-5-haiku-latest", m.Name())
+	})
+
+	t.Run("missing key file", func(t *testing.T) {
+		cfg := global.LLMConfig{}.Default()
+		cfg.Provider = "gemini"
+		cfg.Gemini.APIKeyFile = filepath.Join(t.TempDir(), "missing")
+		cfg.Gemini.Model = "gemini-2.5-flash"
+
+		_, err := llm.NewFromConfig(context.Background(), cfg)
+		require.ErrorContains(t, err, "failed to create gemini client: failed to read secret file")
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		cfg := global.LLMConfig{}.Default()
+		cfg.Provider = "mistral"
+
+		_, err := llm.NewFromConfig(context.Background(), cfg)
+		require.ErrorIs(t, err, llm.ErrUnknownProvider)
+		require.EqualError(t, err, `unknown llm provider: "mistral", supported: anthropic, gemini, ollama, openai`)
+
+		var e *llm.UnknownProviderError
+		require.ErrorAs(t, err, &e)
+		require.Equal(t, "mistral", e.Provider)
+	})
 }
 
 func textGenerateTests(t *testing.T, cli llm.LLM, verbose bool) {
@@ -242,6 +435,29 @@ func TestGeminiGenerate(t *testing.T) {
 	}
 	require.NotNil(t, cli)
 	textGenerateTests(t, cli, true)
+	textStreamTests(t, cli)
+}
+
+func TestAnthropicGenerate(t *testing.T) {
+	key := os.Getenv("ANTHROPIC_API_KEY")
+	if key == "" {
+		t.Skip("ANTHROPIC_API_KEY not found, skip test")
+	}
+
+	var cli llm.LLM
+	var err error
+	cli, err = anthropic.Anthropic(
+		context.Background(),
+		anthropic.WithAPIKey(key),
+		anthropic.WithTimeout(30*time.Second),
+	)
+
+	if err != nil {
+		t.Skipf("Skipping Anthropic tests: could not connect to anthropic API or models not found. Error: %v", err)
+	}
+	require.NotNil(t, cli)
+	textGenerateTests(t, cli, true)
+	textStreamTests(t, cli)
 }
 
 func TestGeminiEmbed(t *testing.T) {
@@ -312,6 +528,7 @@ func TestOllamaGenerate(t *testing.T) {
 	}
 	require.NotNil(t, cli)
 	textGenerateTests(t, cli, false)
+	textStreamTests(t, cli)
 }
 
 func TestOllamaEmbed(t *testing.T) {
@@ -394,6 +611,7 @@ func TestOpenAIGenerate(t *testing.T) {
 	}
 	require.NotNil(t, cli)
 	textGenerateTests(t, cli, false)
+	textStreamTests(t, cli)
 }
 
 func TestOpenAIEmbed(t *testing.T) {
@@ -0,0 +1,98 @@
+package llmtest
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+)
+
+// Model expects the request to be made to the model name. It fails the
+// requests without a model.
+func Model(name string) Expectation {
+	return func(req any) error {
+		var got string
+		switch r := req.(type) {
+		case *llm.GenerateRequest:
+			got = r.ModelName
+		case *llm.EmbedRequest:
+			got = r.ModelName
+		case *llm.BatchRequest:
+			got = r.ModelName
+		default:
+			return fmt.Errorf("%T has no model", req)
+		}
+
+		if got != name {
+			return fmt.Errorf("model should be %q, got %q", name, got)
+		}
+		return nil
+	}
+}
+
+// Roles expects the messages of a generate request to have the roles, in
+// order.
+func Roles(roles ...llm.Role) Expectation {
+	return func(req any) error {
+		r, ok := req.(*llm.GenerateRequest)
+		if !ok {
+			return fmt.Errorf("%T has no messages", req)
+		}
+
+		got := make([]llm.Role, len(r.Messages))
+		for i, msg := range r.Messages {
+			got[i] = msg.Role
+		}
+		if !slices.Equal(got, roles) {
+			return fmt.Errorf("message roles should be %v, got %v", roles, got)
+		}
+		return nil
+	}
+}
+
+// HasSchema expects a generate request with a response schema named name, or
+// with any name if name is empty.
+func HasSchema(name string) Expectation {
+	return func(req any) error {
+		r, ok := req.(*llm.GenerateRequest)
+		if !ok {
+			return fmt.Errorf("%T has no schema", req)
+		}
+
+		switch {
+		case r.Schema == nil:
+			return fmt.Errorf("schema should be set")
+		case name != "" && r.Schema.Name != name:
+			return fmt.Errorf("schema should be %q, got %q", name, r.Schema.Name)
+		}
+		return nil
+	}
+}
+
+// NoSchema expects a generate request without a response schema.
+func NoSchema() Expectation {
+	return func(req any) error {
+		r, ok := req.(*llm.GenerateRequest)
+		if !ok {
+			return fmt.Errorf("%T is not a generate request", req)
+		}
+
+		if r.Schema != nil {
+			return fmt.Errorf("schema should not be set, got %q", r.Schema.Name)
+		}
+		return nil
+	}
+}
+
+// Func expects fn to return nil for the request, it is a shorthand for the
+// checks of a typed request on its other fields.
+func Func[T any](fn func(req T) error) Expectation {
+	return func(req any) error {
+		r, ok := req.(T)
+		if !ok {
+			var want T
+			return fmt.Errorf("request should be a %T, got %T", want, req)
+		}
+		return fn(r)
+	}
+}
@@ -0,0 +1,251 @@
+// Package llmtest provides a scriptable llm.LLM for the tests of the packages
+// calling an LLM, so that they run without a live provider.
+//
+// A MockLLM answers each call with the next response queued for its method,
+// records the calls, and checks the requests against the expectations of the
+// responses:
+//
+//	cli := llmtest.NewMockLLM()
+//	cli.QueueOutput(`{"keywords":[]}`,
+//		llmtest.Model("gpt-5-nano"),
+//		llmtest.Roles(llm.RoleSystem, llm.RoleUser),
+//		llmtest.HasSchema("keywords"))
+package llmtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+)
+
+var (
+	// ErrNoResponse is returned by a call to a MockLLM with no response
+	// queued for its method.
+	ErrNoResponse = errors.New("no response queued")
+	// ErrUnexpectedRequest is wrapped by the error of a call whose request
+	// does not meet an expectation.
+	ErrUnexpectedRequest = errors.New("unexpected request")
+)
+
+// The methods of llm.LLM a MockLLM queues responses for.
+const (
+	MethodGenerate      = "Generate"
+	MethodEmbed         = "Embed"
+	MethodBatchCreate   = "BatchCreate"
+	MethodBatchRetrieve = "BatchRetrieve"
+	MethodBatchCancel   = "BatchCancel"
+)
+
+// Call is a call received by a MockLLM.
+type Call struct {
+	Method  string
+	Request any
+	Err     error
+}
+
+// Expectation checks a request received by a MockLLM, it returns why the
+// request does not meet it.
+type Expectation func(req any) error
+
+// reply is a queued response.
+type reply struct {
+	resp    any
+	err     error
+	expects []Expectation
+}
+
+// MockLLM is an llm.LLM answering the calls with the queued responses. The
+// models are managed by its BaseClient. It is safe for concurrent use.
+type MockLLM struct {
+	*llm.BaseClient
+
+	mu      sync.Mutex
+	replies map[string][]reply
+	expects []Expectation
+	calls   []Call
+}
+
+var _ llm.LLM = (*MockLLM)(nil)
+
+// NewMockLLM creates a MockLLM with no response queued.
+func NewMockLLM() *MockLLM {
+	return &MockLLM{
+		BaseClient: llm.NewClient(),
+		replies:    map[string][]reply{},
+	}
+}
+
+// Expect adds expectations checked on every following call.
+func (m *MockLLM) Expect(expects ...Expectation) *MockLLM {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expects = append(m.expects, expects...)
+	return m
+}
+
+func (m *MockLLM) queue(method string, resp any, err error, expects []Expectation) *MockLLM {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replies[method] = append(m.replies[method], reply{resp: resp, err: err, expects: expects})
+	return m
+}
+
+// QueueGenerate queues the response and error of a call to Generate, the
+// request of the call should meet expects.
+func (m *MockLLM) QueueGenerate(resp *llm.GenerateResponse, err error, expects ...Expectation) *MockLLM {
+	return m.queue(MethodGenerate, resp, err, expects)
+}
+
+// QueueOutput queues a call to Generate answered with output.
+func (m *MockLLM) QueueOutput(output string, expects ...Expectation) *MockLLM {
+	return m.QueueGenerate(&llm.GenerateResponse{Outputs: []string{output}}, nil, expects...)
+}
+
+// QueueEmbed queues the response and error of a call to Embed.
+func (m *MockLLM) QueueEmbed(resp *llm.EmbedResponse, err error, expects ...Expectation) *MockLLM {
+	return m.queue(MethodEmbed, resp, err, expects)
+}
+
+// QueueBatchCreate queues the response and error of a call to BatchCreate.
+func (m *MockLLM) QueueBatchCreate(resp *llm.BatchResponse, err error, expects ...Expectation) *MockLLM {
+	return m.queue(MethodBatchCreate, resp, err, expects)
+}
+
+// QueueBatchRetrieve queues the response and error of a call to BatchRetrieve.
+func (m *MockLLM) QueueBatchRetrieve(resp *llm.BatchResponse, err error, expects ...Expectation) *MockLLM {
+	return m.queue(MethodBatchRetrieve, resp, err, expects)
+}
+
+// QueueBatchCancel queues the error of a call to BatchCancel.
+func (m *MockLLM) QueueBatchCancel(err error, expects ...Expectation) *MockLLM {
+	return m.queue(MethodBatchCancel, nil, err, expects)
+}
+
+// Calls returns the calls received, in order.
+func (m *MockLLM) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.calls)
+}
+
+// GenerateRequests returns the requests of the calls to Generate, in order.
+func (m *MockLLM) GenerateRequests() []*llm.GenerateRequest {
+	return requests[*llm.GenerateRequest](m, MethodGenerate)
+}
+
+// EmbedRequests returns the requests of the calls to Embed, in order.
+func (m *MockLLM) EmbedRequests() []*llm.EmbedRequest {
+	return requests[*llm.EmbedRequest](m, MethodEmbed)
+}
+
+func requests[T any](m *MockLLM, method string) []T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var reqs []T
+	for _, call := range m.calls {
+		if call.Method == method {
+			reqs = append(reqs, call.Request.(T))
+		}
+	}
+	return reqs
+}
+
+// Pending returns the number of queued responses not consumed yet.
+func (m *MockLLM) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, replies := range m.replies {
+		n += len(replies)
+	}
+	return n
+}
+
+// call records the call and pops its response. The error of a call whose
+// context is done, which has no response queued, or whose request does not
+// meet the expectations is returned without consuming a response.
+func (m *MockLLM) call(ctx context.Context, method string, req any) (any, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, err := m.next(ctx, method, req)
+	if err == nil {
+		err = r.err
+	}
+	m.calls = append(m.calls, Call{Method: method, Request: req, Err: err})
+	return r.resp, err
+}
+
+func (m *MockLLM) next(ctx context.Context, method string, req any) (reply, error) {
+	if err := ctx.Err(); err != nil {
+		return reply{}, err
+	}
+
+	replies := m.replies[method]
+	if len(replies) == 0 {
+		return reply{}, fmt.Errorf("%w: %s call %d", ErrNoResponse, method, m.count(method)+1)
+	}
+
+	for _, expect := range slices.Concat(m.expects, replies[0].expects) {
+		if err := expect(req); err != nil {
+			return reply{}, fmt.Errorf("%w: %s call %d: %w",
+				ErrUnexpectedRequest, method, m.count(method)+1, err)
+		}
+	}
+	m.replies[method] = replies[1:]
+	return replies[0], nil
+}
+
+// count returns the number of calls to method received.
+func (m *MockLLM) count(method string) int {
+	n := 0
+	for _, call := range m.calls {
+		if call.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// Generate answers with the next queued response. The output of a request
+// with a schema is validated against it, as the providers do, unless the
+// queued response has a SchemaErr already.
+func (m *MockLLM) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm.GenerateResponse, error) {
+	resp, err := m.call(ctx, MethodGenerate, req)
+	r, _ := resp.(*llm.GenerateResponse)
+	if r != nil && req != nil && req.Schema != nil && r.SchemaErr == nil && len(r.Outputs) > 0 {
+		validated := *r
+		validated.SchemaErr = llm.ValidateOutput(r.Outputs[0], req.Schema)
+		r = &validated
+	}
+	return r, err
+}
+
+func (m *MockLLM) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.EmbedResponse, error) {
+	resp, err := m.call(ctx, MethodEmbed, req)
+	r, _ := resp.(*llm.EmbedResponse)
+	return r, err
+}
+
+func (m *MockLLM) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm.BatchResponse, error) {
+	resp, err := m.call(ctx, MethodBatchCreate, req)
+	r, _ := resp.(*llm.BatchResponse)
+	return r, err
+}
+
+func (m *MockLLM) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequest) (*llm.BatchResponse, error) {
+	resp, err := m.call(ctx, MethodBatchRetrieve, req)
+	r, _ := resp.(*llm.BatchResponse)
+	return r, err
+}
+
+func (m *MockLLM) BatchCancel(ctx context.Context, req *llm.BatchCancelRequest) error {
+	_, err := m.call(ctx, MethodBatchCancel, req)
+	return err
+}
@@ -0,0 +1,170 @@
+package llmtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/llm/llmtest"
+	"github.com/stretchr/testify/require"
+)
+
+func generateRequest(model string, schema *llm.ResponseSchema) *llm.GenerateRequest {
+	return &llm.GenerateRequest{
+		Messages: []llm.Message{
+			{Role: llm.RoleSystem, Content: []string{"Extract the keywords"}},
+			{Role: llm.RoleUser, Content: []string{"some article"}},
+		},
+		ModelName: model,
+		Schema:    schema,
+	}
+}
+
+func TestMockLLMQueue(t *testing.T) {
+	errDown := errors.New("provider down")
+	cli := llmtest.NewMockLLM().
+		QueueOutput("first").
+		QueueGenerate(nil, errDown).
+		QueueEmbed(&llm.EmbedResponse{Embeddings: []llm.Embedding{{Values: []float32{0.1, 0.2}}}}, nil)
+	require.Equal(t, 3, cli.Pending())
+
+	ctx := context.Background()
+	resp, err := cli.Generate(ctx, generateRequest("m", nil))
+	require.NoError(t, err)
+	require.Equal(t, []string{"first"}, resp.Outputs)
+
+	_, err = cli.Generate(ctx, generateRequest("m", nil))
+	require.ErrorIs(t, err, errDown)
+
+	_, err = cli.Generate(ctx, generateRequest("m", nil))
+	require.ErrorIs(t, err, llmtest.ErrNoResponse)
+
+	embed, err := cli.Embed(ctx, &llm.EmbedRequest{Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("a")}})
+	require.NoError(t, err)
+	require.Len(t, embed.Embeddings, 1)
+
+	_, err = cli.BatchRetrieve(ctx, &llm.BatchRetrieveRequest{ID: "batch_1"})
+	require.ErrorIs(t, err, llmtest.ErrNoResponse)
+	require.Zero(t, cli.Pending())
+
+	calls := cli.Calls()
+	require.Len(t, calls, 5)
+	require.Equal(t, llmtest.MethodGenerate, calls[0].Method)
+	require.NoError(t, calls[0].Err)
+	require.ErrorIs(t, calls[1].Err, errDown)
+	require.Equal(t, llmtest.MethodBatchRetrieve, calls[4].Method)
+	require.Len(t, cli.GenerateRequests(), 3)
+	require.Len(t, cli.EmbedRequests(), 1)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	cli.QueueBatchCancel(nil)
+	require.ErrorIs(t, cli.BatchCancel(cancelled, &llm.BatchCancelRequest{}), context.Canceled)
+	require.Equal(t, 1, cli.Pending())
+}
+
+func TestMockLLMExpectations(t *testing.T) {
+	schema := &llm.ResponseSchema{Name: "keywords"}
+	tcs := []struct {
+		name    string
+		expects []llmtest.Expectation
+		req     *llm.GenerateRequest
+		ok      bool
+	}{
+		{
+			name: "met",
+			expects: []llmtest.Expectation{
+				llmtest.Model("gpt-5-nano"),
+				llmtest.Roles(llm.RoleSystem, llm.RoleUser),
+				llmtest.HasSchema("keywords"),
+			},
+			req: generateRequest("gpt-5-nano", schema),
+			ok:  true,
+		},
+		{
+			name:    "wrong model",
+			expects: []llmtest.Expectation{llmtest.Model("gpt-5-nano")},
+			req:     generateRequest("gemma3", schema),
+		},
+		{
+			name:    "wrong roles",
+			expects: []llmtest.Expectation{llmtest.Roles(llm.RoleUser)},
+			req:     generateRequest("gpt-5-nano", schema),
+		},
+		{
+			name:    "no schema",
+			expects: []llmtest.Expectation{llmtest.HasSchema("")},
+			req:     generateRequest("gpt-5-nano", nil),
+		},
+		{
+			name:    "unwanted schema",
+			expects: []llmtest.Expectation{llmtest.NoSchema()},
+			req:     generateRequest("gpt-5-nano", schema),
+		},
+		{
+			name: "func",
+			expects: []llmtest.Expectation{llmtest.Func(func(req *llm.GenerateRequest) error {
+				if req.Messages[1].Content[0] != "some article" {
+					return errors.New("wrong content")
+				}
+				return nil
+			})},
+			req: generateRequest("gpt-5-nano", nil),
+			ok:  true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			cli := llmtest.NewMockLLM().QueueOutput("{}", tc.expects...)
+			_, err := cli.Generate(context.Background(), tc.req)
+			if tc.ok {
+				require.NoError(t, err)
+				require.Zero(t, cli.Pending())
+				return
+			}
+			require.ErrorIs(t, err, llmtest.ErrUnexpectedRequest)
+			require.Equal(t, 1, cli.Pending())
+		})
+	}
+
+	t.Run("every call", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().Expect(llmtest.Model("text-embedding-3-small")).
+			QueueEmbed(&llm.EmbedResponse{}, nil).
+			QueueEmbed(&llm.EmbedResponse{}, nil)
+		_, err := cli.Embed(context.Background(), &llm.EmbedRequest{ModelName: "text-embedding-3-small"})
+		require.NoError(t, err)
+		_, err = cli.Embed(context.Background(), &llm.EmbedRequest{ModelName: "nomic-embed-text"})
+		require.ErrorIs(t, err, llmtest.ErrUnexpectedRequest)
+	})
+}
+
+func TestMockLLMValidatesOutput(t *testing.T) {
+	schema := &llm.ResponseSchema{Name: "keywords", S: map[string]any{
+		"type":     "object",
+		"required": []string{"keywords"},
+		"properties": map[string]any{
+			"keywords": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+	}}
+	cli := llmtest.NewMockLLM().
+		QueueOutput(`{"keywords":["三鶯線"]}`).
+		QueueOutput(`{"keywords":[1]}`).
+		QueueOutput(`{"keywords":[1]}`)
+
+	ctx := context.Background()
+	resp, err := cli.Generate(ctx, generateRequest("m", schema))
+	require.NoError(t, err)
+	require.NoError(t, resp.SchemaErr)
+
+	resp, err = cli.Generate(ctx, generateRequest("m", schema))
+	require.NoError(t, err)
+	var violation *llm.SchemaViolationError
+	require.ErrorAs(t, resp.SchemaErr, &violation)
+	require.Equal(t, "$.keywords[0]", violation.Path)
+
+	resp, err = cli.Generate(ctx, generateRequest("m", nil))
+	require.NoError(t, err)
+	require.NoError(t, resp.SchemaErr)
+}
@@ -0,0 +1,109 @@
+package llmtest
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+)
+
+// ProxyRequest is a request relayed by a Proxy.
+type ProxyRequest struct {
+	Method string
+	// Target is the absolute URL of the request, or the host of a CONNECT.
+	Target string
+	// Auth is the Proxy-Authorization header of the request.
+	Auth string
+}
+
+// Proxy is a forward HTTP proxy recording the requests it relays, for the
+// tests of the clients sent through a proxy. The https requests are tunneled
+// with CONNECT.
+type Proxy struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []ProxyRequest
+}
+
+// NewProxy starts a Proxy, to be closed by the caller.
+func NewProxy() *Proxy {
+	p := &Proxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	return p
+}
+
+// ProxyURL is the URL of the proxy, with the credentials of user if it is not
+// empty.
+func (p *Proxy) ProxyURL(user, password string) string {
+	u, _ := url.Parse(p.URL)
+	if user != "" {
+		u.User = url.UserPassword(user, password)
+	}
+	return u.String()
+}
+
+// Requests returns the requests relayed so far, in order.
+func (p *Proxy) Requests() []ProxyRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.requests)
+}
+
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) {
+	req := ProxyRequest{Method: r.Method, Target: r.URL.String(), Auth: r.Header.Get("Proxy-Authorization")}
+	if r.Method == http.MethodConnect {
+		req.Target = r.Host
+	}
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	p.mu.Unlock()
+
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Authorization")
+	resp, err := http.DefaultTransport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// tunnel relays the bytes between the client of a CONNECT and its host.
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+	// the tunnel is closed once either side is
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
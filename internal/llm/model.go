@@ -14,8 +14,27 @@ const (
 
 var (
 	ErrInvalidModelType = fmt.Errorf("invalid model type")
+	ErrContextTooLong   = fmt.Errorf("input exceeds the context window of the model")
 )
 
+// ContextTooLongError is returned for a request whose input is estimated to
+// take more tokens than the context window of the model.
+type ContextTooLongError struct {
+	Model string
+	// Tokens is the estimated number of tokens of the input.
+	Tokens int
+	// ContextLength is the number of tokens of the context window.
+	ContextLength int
+}
+
+func (e *ContextTooLongError) Error() string {
+	return fmt.Sprintf("%s: %s, %d tokens, %d allowed", ErrContextTooLong, e.Model, e.Tokens, e.ContextLength)
+}
+
+func (e *ContextTooLongError) Unwrap() error {
+	return ErrContextTooLong
+}
+
 var ModelTypeList = []ModelType{ModelGenerate, ModelEmbed}
 
 func (m ModelType) String() string {
@@ -94,3 +113,39 @@ func (m *BaseModel) UnmarshalJSON(data []byte) error {
 	m.name = ali.Name
 	return nil
 }
+
+// InputLimiter is implemented by the models with a limit on the number of
+// tokens of an input. The longer inputs of an embedding model are truncated by
+// the provider, those of a generate model are rejected.
+type InputLimiter interface {
+	MaxInputTokens() int
+}
+
+// OutputLimiter is implemented by the generate models with a limit on the
+// number of tokens they generate. A zero limit means unknown.
+type OutputLimiter interface {
+	MaxOutputTokens() int
+}
+
+// ContextLimiter is implemented by the generate models with a known context
+// window, the number of tokens of the input and the output together. A zero
+// length means unknown.
+type ContextLimiter interface {
+	ContextLength() int
+}
+
+// EmbedModel is an embedding model with a per-input token limit, e.g. 512 for
+// multilingual-e5 or 8191 for text-embedding-3. A zero limit means unknown.
+type EmbedModel struct {
+	BaseModel
+	maxInputTokens int
+}
+
+func NewEmbedModel(name string, maxInputTokens int) EmbedModel {
+	return EmbedModel{
+		BaseModel:      NewBaseModel(ModelEmbed, name),
+		maxInputTokens: maxInputTokens,
+	}
+}
+
+func (m EmbedModel) MaxInputTokens() int { return m.maxInputTokens }
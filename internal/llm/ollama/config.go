@@ -0,0 +1,52 @@
+package ollama
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+)
+
+func init() {
+	llm.RegisterProvider("ollama", func(ctx context.Context, cfg global.LLMConfig) (llm.LLM, error) {
+		return NewFromConfig(ctx, cfg.Ollama)
+	})
+}
+
+// NewFromConfig creates a client from its configuration.
+func NewFromConfig(ctx context.Context, cfg global.OllamaConfig) (*Client, error) {
+	opts := []Option{
+		WithHost(cfg.BaseURL),
+		WithModel(
+			NewOllamaModel(llm.ModelGenerate, cfg.Model),
+			NewOllamaModel(llm.ModelEmbed, cfg.EmbedModel),
+		),
+		WithDefaultGenerate(cfg.Model),
+		WithDefaultEmbed(cfg.EmbedModel),
+	}
+	if cfg.Timeout > 0 {
+		opts = append(opts, WithHTTPClient(&http.Client{Timeout: cfg.Timeout}))
+	}
+	if cfg.Proxy != "" {
+		opts = append(opts, WithProxy(cfg.Proxy))
+	}
+	if cfg.EmbedParallelism > 0 {
+		opts = append(opts, WithEmbedParallelism(cfg.EmbedParallelism))
+	}
+	if cfg.KeepAlive != 0 {
+		opts = append(opts, WithKeepAlive(cfg.KeepAlive))
+	}
+	if cfg.AutoPull {
+		opts = append(opts, WithAutoPull(true))
+	}
+
+	token, err := cfg.ReadBearerToken()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		opts = append(opts, WithBearerToken(token))
+	}
+	return Ollama(ctx, opts...)
+}
@@ -2,44 +2,95 @@ package ollama
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"net/http"
 	"strings"
 	"time"
 
+	"github.com/ChiaYuChang/weathercock/internal/global"
 	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
 	"github.com/ollama/ollama/api"
 )
 
 // healthCheck checks the connection to the Ollama server.
-// It retries the connection up to MaxRetries times with exponential backoff.
+// It retries the connection on any error with the delays of policy.
 // Parameters:
 //   - ctx: The context for the health check.
 //   - cli: The Ollama API client.
+//   - policy: The retry policy of the client.
 //
 // Returns:
 //   - error: An error if the connection cannot be established after retries.
-func healthCheck(ctx context.Context, cli *api.Client) error {
+func healthCheck(ctx context.Context, cli *api.Client, policy llm.RetryPolicy) error {
 	if cli == nil {
 		return ErrOptNilClient
 	}
 
-	var err error
-	for i := 0; i < MaxRetries; i++ {
-		if _, err = cli.List(ctx); err == nil {
-			return nil
-		}
-		time.Sleep(min(1<<i*time.Second, MaxRetryWaitingTime))
+	policy.RetryOn = func(error) bool { return true }
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		_, err := cli.List(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCanNotConnectToServer, err)
+	}
+	return nil
+}
+
+// headerTransport sets header on the requests before they are sent by base.
+type headerTransport struct {
+	header http.Header
+	base   http.RoundTripper
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	for key, values := range t.header {
+		r.Header[key] = values
+	}
+	return t.base.RoundTrip(r)
+}
+
+// withHeader returns a copy of c setting header on its requests.
+func withHeader(c *http.Client, header http.Header) *http.Client {
+	wrapped := *c
+	wrapped.Transport = headerTransport{
+		header: header.Clone(),
+		base:   utils.IfElse(c.Transport == nil, http.DefaultTransport, c.Transport),
+	}
+	return &wrapped
+}
+
+// IsTransient reports whether the error of an Ollama request is worth
+// retrying: a server error, e.g. while the model is loaded, or a transient
+// failure to reach the server.
+// Parameters:
+//   - err: The error of the request.
+//
+// Returns:
+//   - bool: Whether the request should be retried.
+func IsTransient(err error) bool {
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) {
+		return llm.IsTransientStatus(statusErr.StatusCode)
 	}
-	return ErrCanNotConnectToServer
+	return llm.IsTransient(err)
 }
 
 // toOllamaMessages converts a slice of llm.Message to a slice of api.Message for Ollama.
 // Parameters:
 //   - msgs: The slice of llm.Message to convert.
+//   - vision: Whether the model takes images.
 //
 // Returns:
 //   - []api.Message: The converted slice of Ollama API messages.
-func toOllamaMessages(msgs []llm.Message) []api.Message {
+//   - error: An error wrapping llm.ErrNotImplemented if a message has an image
+//     and the model does not take images, or llm.ErrInvalidPart if an image
+//     is given by URL, Ollama only takes the image data.
+func toOllamaMessages(msgs []llm.Message, vision bool) ([]api.Message, error) {
 	count := 0
 	for _, msg := range msgs {
 		count += len(msg.Content)
@@ -48,14 +99,118 @@ func toOllamaMessages(msgs []llm.Message) []api.Message {
 	oMsgs := make([]api.Message, 0, count)
 	for _, msg := range msgs {
 		role := string(msg.Role)
-		for _, content := range msg.Content {
-			oMsgs = append(oMsgs, api.Message{
-				Role:    role,
-				Content: content,
-			})
+		if !msg.HasImage() {
+			for _, part := range msg.ContentParts() {
+				oMsgs = append(oMsgs, api.Message{
+					Role:    role,
+					Content: part.(llm.TextPart).Text,
+				})
+			}
+			continue
+		}
+
+		if !vision {
+			return nil, fmt.Errorf("%w: the model does not take images", llm.ErrNotImplemented)
 		}
+
+		// the images go with the texts of the message in a single message
+		oMsg := api.Message{Role: role}
+		var texts []string
+		for _, part := range msg.ContentParts() {
+			switch p := part.(type) {
+			case llm.TextPart:
+				texts = append(texts, p.Text)
+			case llm.ImagePart:
+				if err := p.Validate(); err != nil {
+					return nil, err
+				}
+				if p.URL != "" {
+					return nil, fmt.Errorf("%w: ollama takes the image data, not its URL", llm.ErrInvalidPart)
+				}
+				oMsg.Images = append(oMsg.Images, api.ImageData(p.Data))
+			default:
+				return nil, fmt.Errorf("%w: unsupported part %T", llm.ErrInvalidPart, part)
+			}
+		}
+		oMsg.Content = strings.Join(texts, "\n\n")
+		oMsgs = append(oMsgs, oMsg)
 	}
-	return oMsgs
+	return oMsgs, nil
+}
+
+// isEndpointNotFound reports whether err is the 404 of a server without the
+// endpoint, rather than of a model which is not found.
+func isEndpointNotFound(err error) bool {
+	var statusErr api.StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound &&
+		strings.Contains(statusErr.ErrorMessage, "page not found")
+}
+
+// isFormatUnsupported reports whether err is the rejection of a schema as the
+// format of a chat, by a server taking a string only.
+func isFormatUnsupported(err error) bool {
+	var statusErr api.StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusBadRequest &&
+		strings.Contains(statusErr.ErrorMessage, "format")
+}
+
+// isModelNotFound reports whether err is the 404 of a model which is not on
+// the server.
+func isModelNotFound(err error) bool {
+	var statusErr api.StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound &&
+		!isEndpointNotFound(err)
+}
+
+// pullModel pulls the named model from the registry, logging the progress of
+// each layer by steps of PullProgressStep percent. It returns once the server
+// reports the pull has succeeded, the error wraps ErrModelPullFailed
+// otherwise, e.g. if the registry does not have the model or ctx is done.
+func pullModel(ctx context.Context, cli *api.Client, name string) error {
+	logger := global.Logger.With().Str("model", name).Logger()
+	logger.Info().Msg("model not found on the server, pulling it")
+
+	start := time.Now()
+	status := ""
+	logged := map[string]int64{}
+	err := cli.Pull(ctx, &api.PullRequest{Model: name}, func(p api.ProgressResponse) error {
+		if p.Digest == "" || p.Total <= 0 {
+			if p.Status != status {
+				status = p.Status
+				logger.Info().Str("status", status).Msg("pulling model")
+			}
+			return nil
+		}
+
+		percent := p.Completed * 100 / p.Total
+		prev, ok := logged[p.Digest]
+		if ok && (percent == prev || percent < 100 && percent-prev < PullProgressStep) {
+			return nil
+		}
+		logged[p.Digest] = percent
+		logger.Info().
+			Str("layer", p.Digest).
+			Int64("percent", percent).
+			Int64("completed", p.Completed).
+			Int64("total", p.Total).
+			Msg("pulling model")
+		return nil
+	})
+	if err == nil {
+		// the stream ends without an error once ctx is done
+		err = ctx.Err()
+	}
+	if err == nil && status != "success" {
+		err = ErrIncompleteResponse
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s, %w", ErrModelPullFailed, name, err)
+	}
+
+	logger.Info().
+		Dur("elapsed", time.Since(start)).
+		Msg("model pulled")
+	return nil
 }
 
 // toOptions performs a type assertion, returning the result or an error.
@@ -78,20 +233,44 @@ func toOptions(conf any) (map[string]any, error) {
 	return options, nil
 }
 
-func extractJSONObject(s string) (string, error) {
-	start := strings.Index(s, "{")
-	if start == -1 {
-		return "", fmt.Errorf("could not find opening brace '{' in the string")
+// toGenerateOptions converts the generation parameters of req to the options
+// of an Ollama request, the options of req.Config overriding them.
+// Parameters:
+//   - req: The request to convert.
+//
+// Returns:
+//   - map[string]any: The options, nil if there is none.
+//   - error: An error if req.Config is not a map[string]any.
+func toGenerateOptions(req *llm.GenerateRequest) (map[string]any, error) {
+	if req.UsesTools() {
+		return nil, fmt.Errorf("%w: tool calls are not supported by the ollama client", llm.ErrNotImplemented)
 	}
 
-	end := strings.LastIndex(s, "}")
-	if end == -1 {
-		return "", fmt.Errorf("could not find closing brace '}' in the string")
+	conf, err := toOptions(req.Config)
+	if err != nil {
+		return nil, err
 	}
 
-	if end < start {
-		return "", fmt.Errorf("found closing brace '}' before opening brace '{'")
+	opts := map[string]any{}
+	if req.Temperature != nil {
+		opts["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		opts["top_p"] = *req.TopP
 	}
+	if req.MaxTokens != nil {
+		opts["num_predict"] = *req.MaxTokens
+	}
+	if len(req.Stop) > 0 {
+		opts["stop"] = req.Stop
+	}
+	if req.Seed != nil {
+		opts["seed"] = *req.Seed
+	}
+	maps.Copy(opts, conf)
 
-	return s[start : end+1], nil
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	return opts, nil
 }
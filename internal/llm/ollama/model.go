@@ -1,9 +1,19 @@
 package ollama
 
 import (
+	"encoding/json"
+	"strings"
+
 	"github.com/ChiaYuChang/weathercock/internal/llm"
 )
 
+// The capabilities of a model reported by the Ollama server.
+const (
+	CapabilityCompletion = "completion"
+	CapabilityEmbedding  = "embedding"
+	CapabilityVision     = "vision"
+)
+
 type OllamaModel struct {
 	llm.BaseModel
 	License      string         `json:"license"`
@@ -11,9 +21,43 @@ type OllamaModel struct {
 	Capabilities []string       `json:"capabilities"`
 }
 
+var _ llm.ContextLimiter = OllamaModel{}
+
 // NewOllamaModel creates a new OllamaModel with the specified model type and name.
 func NewOllamaModel(modelType llm.ModelType, name string) OllamaModel {
 	return OllamaModel{
 		BaseModel: llm.NewBaseModel(modelType, name),
 	}
 }
+
+// ContextLength returns the context window the model was trained with, the
+// <architecture>.context_length of its ModelInfo, or 0 if it is unknown.
+func (m OllamaModel) ContextLength() int {
+	if arch, ok := m.ModelInfo["general.architecture"].(string); ok {
+		if n := toInt(m.ModelInfo[arch+".context_length"]); n > 0 {
+			return n
+		}
+	}
+	for key, v := range m.ModelInfo {
+		if strings.HasSuffix(key, ".context_length") {
+			return toInt(v)
+		}
+	}
+	return 0
+}
+
+// toInt converts a number decoded from JSON to an int, 0 if v is not one.
+func toInt(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	}
+	return 0
+}
@@ -1,7 +1,9 @@
 package ollama
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,11 +11,13 @@ import (
 	"runtime"
 	"slices"
 	"sync"
-	"time"
+	"sync/atomic"
 
+	"github.com/ChiaYuChang/weathercock/internal/global"
 	"github.com/ChiaYuChang/weathercock/internal/llm"
 	"github.com/ChiaYuChang/weathercock/pkgs/utils"
 	"github.com/ollama/ollama/api"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -24,18 +28,41 @@ var (
 	ErrModelNotFount         = errors.New("could not retrieve model from ollama API")
 	ErrModelNotSupport       = errors.New("model not support")
 	ErrInvalidOptionsType    = errors.New("invalid options type")
-)
+	ErrModelPullFailed       = errors.New("failed to pull model")
 
-var (
-	Parallel            = min(runtime.NumCPU(), 3)
-	MaxRetries          = 4
-	MaxRetryWaitingTime = 10 * time.Second
+	errNoEmbedEndpoint = errors.New("server has no /api/embed endpoint")
 )
 
+// formatJSON is the format of a chat constrained to any JSON, the only one
+// supported by the servers before the structured outputs.
+var formatJSON = json.RawMessage(`"json"`)
+
+// DefaultEmbedParallelism is the number of embed requests a client makes at
+// a time, unless set by WithEmbedParallelism.
+var DefaultEmbedParallelism = min(runtime.NumCPU(), 3)
+
+// EmbedBatchSize is the maximum number of inputs of an embed request.
+const EmbedBatchSize = 32
+
+// PullProgressStep is the step, in percent, the progress of the layers of a
+// pulled model is logged by.
+const PullProgressStep = 10
+
 // Client implements the llm.LLM interface for interacting with the Ollama service.
 type Client struct {
 	*llm.BaseClient
 	OllamaAPI *api.Client
+	// EmbedParallelism is the number of embed requests made at a time.
+	EmbedParallelism int
+	// KeepAlive is the keep_alive of the requests, the server default if nil.
+	KeepAlive *api.Duration
+	// Tokenizer counts the tokens of the input of a generate request.
+	Tokenizer llm.Tokenizer
+	// legacyEmbed is set once the server is found without /api/embed.
+	legacyEmbed atomic.Bool
+	// legacyFormat is set once the server is found to take no schema as the
+	// format of a chat.
+	legacyFormat atomic.Bool
 }
 
 // builder is used to construct an Ollama Client using the functional options pattern.
@@ -46,18 +73,29 @@ type builder struct {
 	Models       map[string]llm.Model
 	DefaultGen   string
 	DefaultEmbed string
+	RetryPolicy  *llm.RetryPolicy
+	Limiter      *rate.Limiter
+	Parallelism  int
+	KeepAlive    *api.Duration
+	AutoPull     bool
+	Tokenizer    llm.Tokenizer
+	Header       http.Header
+	Transport    http.RoundTripper
 }
 
+// OllamaEmbedReq is a batch of the inputs of an embed request, starting at
+// the Index-th input.
 type OllamaEmbedReq struct {
-	Index int
-	Req   *api.EmbeddingRequest
+	Index  int
+	Inputs []string
 }
 
+// OllamaEmbedRawResp is the response to an OllamaEmbedReq.
 type OllamaEmbedRawResp struct {
 	index int
-	Text  string                 `json:"text"`
-	Error error                  `json:"error,omitempty"`
-	Raw   *api.EmbeddingResponse `json:"raw,omitempty"`
+	Texts []string           `json:"texts"`
+	Error error              `json:"error,omitempty"`
+	Raw   *api.EmbedResponse `json:"raw,omitempty"`
 }
 
 // Ollama creates a new Ollama client with the given context and options.
@@ -70,7 +108,7 @@ type OllamaEmbedRawResp struct {
 //   - *Client: The initialized Ollama client.
 //   - error: An error if client creation fails.
 func Ollama(ctx context.Context, opts ...Option) (*Client, error) {
-	b := &builder{Models: make(map[string]llm.Model)}
+	b := &builder{Models: make(map[string]llm.Model), Header: http.Header{}}
 	for _, opt := range opts {
 		if err := opt(b); err != nil {
 			return nil, err
@@ -85,10 +123,24 @@ func Ollama(ctx context.Context, opts ...Option) (*Client, error) {
 		return nil, ErrNoBaseURL
 	}
 
-	cli := api.NewClient(b.URL, utils.IfElse(
-		b.Client == nil, http.DefaultClient, b.Client))
+	httpClient := utils.IfElse(b.Client == nil, http.DefaultClient, b.Client)
+	if b.Transport != nil {
+		httpClient = llm.HTTPClientWithTransport(httpClient, b.Transport)
+	}
+	if len(b.Header) > 0 {
+		httpClient = withHeader(httpClient, b.Header)
+	}
+	cli := api.NewClient(b.URL, httpClient)
 
-	if err := healthCheck(ctx, cli); err != nil {
+	policy := llm.DefaultRetryPolicy()
+	if b.RetryPolicy != nil {
+		policy = *b.RetryPolicy
+	}
+	if policy.RetryOn == nil {
+		policy.RetryOn = IsTransient
+	}
+
+	if err := healthCheck(ctx, cli, policy); err != nil {
 		return nil, err
 	}
 
@@ -104,6 +156,12 @@ func Ollama(ctx context.Context, opts ...Option) (*Client, error) {
 	// and also retrieve model capabilities for request validation
 	for name, model := range b.Models {
 		m, err := cli.Show(ctx, &api.ShowRequest{Model: name})
+		if err != nil && b.AutoPull && isModelNotFound(err) {
+			if err := pullModel(ctx, cli, name); err != nil {
+				return nil, err
+			}
+			m, err = cli.Show(ctx, &api.ShowRequest{Model: name})
+		}
 		if err != nil {
 			return nil, fmt.Errorf("%w: %s, %s", ErrModelNotFount, name, err)
 		}
@@ -115,12 +173,12 @@ func Ollama(ctx context.Context, opts ...Option) (*Client, error) {
 
 		switch model.Type() {
 		case llm.ModelEmbed:
-			if !slices.Contains(capabilities, "embedding") {
+			if !slices.Contains(capabilities, CapabilityEmbedding) {
 				return nil, fmt.Errorf(
 					"%w does not support embedding content: %s", ErrModelNotSupport, name)
 			}
 		case llm.ModelGenerate:
-			if !slices.Contains(capabilities, "completion") {
+			if !slices.Contains(capabilities, CapabilityCompletion) {
 				return nil, fmt.Errorf(
 					"%w generating content: %s", ErrModelNotSupport, name)
 			}
@@ -134,6 +192,8 @@ func Ollama(ctx context.Context, opts ...Option) (*Client, error) {
 	}
 
 	base := llm.NewClient()
+	base.RetryPolicy = policy
+	base.Limiter = b.Limiter
 	for _, model := range b.Models {
 		if err := base.WithModel(model); err != nil {
 			return nil, err
@@ -147,10 +207,18 @@ func Ollama(ctx context.Context, opts ...Option) (*Client, error) {
 	if err := base.SetDefaultModel(llm.ModelGenerate, b.DefaultGen); err != nil {
 		return nil, err
 	}
-	return &Client{BaseClient: base, OllamaAPI: cli}, nil
+	return &Client{
+		BaseClient:       base,
+		OllamaAPI:        cli,
+		EmbedParallelism: utils.DefaultIfZero(b.Parallelism, DefaultEmbedParallelism),
+		KeepAlive:        b.KeepAlive,
+		Tokenizer:        utils.IfElse[llm.Tokenizer](b.Tokenizer == nil, llm.ApproxTokenizer{}, b.Tokenizer),
+	}, nil
 }
 
-// Generate produces a response from the Ollama model.
+// Generate produces a response from the Ollama model. The output of a request
+// with a Schema is constrained to it by the format of the chat, or to JSON and
+// then repaired, by a server predating the structured outputs.
 // Parameters:
 //   - ctx: The context for the request.
 //   - req: llm.GenerateRequest containing the messages and model information.
@@ -167,6 +235,127 @@ func (c *Client) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm.G
 		return nil, llm.ErrNoInput
 	}
 
+	chatReq, err := c.chatRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	isStreaming := false
+	chatReq.Stream = &isStreaming
+	var apiResp api.ChatResponse
+	chat := func() error {
+		return c.Retry(ctx, func(ctx context.Context) error {
+			return c.OllamaAPI.Chat(ctx, chatReq, func(resp api.ChatResponse) error {
+				apiResp = resp
+				return nil
+			})
+		})
+	}
+	err = chat()
+	if err != nil && c.fallBackToJSONFormat(chatReq, err) {
+		err = chat()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ollama chat failed: %w", err)
+	}
+
+	if !apiResp.Done {
+		return nil, ErrIncompleteResponse
+	}
+
+	output := apiResp.Message.Content
+	var schemaErr error
+	if req.Schema != nil {
+		if bytes.Equal(chatReq.Format, formatJSON) {
+			// the output is only constrained to be JSON, not to match the schema
+			output = llm.RepairOutput(output, req.Schema)
+		}
+		schemaErr = llm.ValidateOutput(output, req.Schema)
+	}
+
+	return &llm.GenerateResponse{
+		Outputs: []string{output},
+		Usage: llm.Usage{
+			PromptTokens:     apiResp.PromptEvalCount,
+			CompletionTokens: apiResp.EvalCount,
+			TotalTokens:      apiResp.PromptEvalCount + apiResp.EvalCount,
+		},
+		Raw:          apiResp,
+		SchemaErr:    schemaErr,
+		FinishReason: apiResp.DoneReason,
+	}, nil
+}
+
+// GenerateStream streams the chat of the Ollama model, a chunk per message
+// received from the server. The Done chunk carries the done_reason and the
+// token counts of the last message. A stream the server stops before its last
+// message, e.g. when the connection drops, ends with ErrIncompleteResponse.
+// Parameters:
+//   - ctx: The context for the request.
+//   - req: llm.GenerateRequest containing the messages and model information.
+//
+// Returns:
+//   - <-chan llm.GenerateChunk receiving the deltas of the output.
+//   - error if the configuration type is invalid.
+func (c *Client) GenerateStream(ctx context.Context, req *llm.GenerateRequest) (<-chan llm.GenerateChunk, error) {
+	if req == nil {
+		return nil, llm.ErrRequestShouldNotBeNull
+	}
+
+	if len(req.Messages) == 0 {
+		return nil, llm.ErrNoInput
+	}
+
+	chatReq, err := c.chatRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	isStreaming := true
+	chatReq.Stream = &isStreaming
+	return llm.NewStreamEnd(ctx, func(emit func(delta string) error) (llm.StreamEnd, error) {
+		if err := c.Wait(ctx); err != nil {
+			return llm.StreamEnd{}, err
+		}
+
+		var end llm.StreamEnd
+		done := false
+		handle := func(resp api.ChatResponse) error {
+			if resp.Done {
+				done = true
+				end = llm.StreamEnd{
+					FinishReason: resp.DoneReason,
+					Usage: llm.Usage{
+						PromptTokens:     resp.PromptEvalCount,
+						CompletionTokens: resp.EvalCount,
+						TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+					},
+				}
+			}
+			return emit(resp.Message.Content)
+		}
+		err := c.OllamaAPI.Chat(ctx, chatReq, handle)
+		if err != nil && c.fallBackToJSONFormat(chatReq, err) {
+			// the format is rejected before anything is streamed
+			err = c.OllamaAPI.Chat(ctx, chatReq, handle)
+		}
+		if err != nil {
+			return end, fmt.Errorf("ollama chat failed: %w", err)
+		}
+
+		if !done {
+			// the stream ends without an error once ctx is done
+			if err := ctx.Err(); err != nil {
+				return end, err
+			}
+			return end, ErrIncompleteResponse
+		}
+		return end, nil
+	}), nil
+}
+
+// chatRequest converts req to an Ollama chat request.
+func (c *Client) chatRequest(req *llm.GenerateRequest) (*api.ChatRequest, error) {
 	modelName := req.ModelName
 	if modelName == "" {
 		if m, ok := c.DefaultModel(llm.ModelGenerate); ok {
@@ -176,62 +365,148 @@ func (c *Client) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm.G
 		}
 	}
 
-	messages := toOllamaMessages(req.Messages)
-
-	opts, err := toOptions(req.Config)
+	opts, err := toGenerateOptions(req)
 	if err != nil {
 		return nil, err
 	}
 
+	var format json.RawMessage
 	if req.Schema != nil {
-		if len(opts) == 0 {
-			opts = map[string]any{}
+		if format, err = c.chatFormat(req.Schema); err != nil {
+			return nil, err
 		}
-		opts["schema"] = req.Schema.S
 	}
 
-	isStreaming := false
-	var apiResp api.ChatResponse
-	if err := c.OllamaAPI.Chat(ctx, &api.ChatRequest{
-		Model:    modelName,
-		Messages: messages,
-		Options:  opts,
-		Stream:   &isStreaming,
-	}, func(resp api.ChatResponse) error {
-		apiResp = resp
+	messages, err := toOllamaMessages(req.Messages, c.HasCapability(modelName, CapabilityVision))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", modelName, err)
+	}
+
+	if err := c.checkContextLength(modelName, messages, opts); err != nil {
+		return nil, err
+	}
+
+	return &api.ChatRequest{
+		Model:     modelName,
+		Messages:  messages,
+		Format:    format,
+		Options:   opts,
+		KeepAlive: c.KeepAlive,
+	}, nil
+}
+
+// checkContextLength estimates the number of tokens of messages and fails with
+// an *llm.ContextTooLongError if it exceeds the context window of the model,
+// the num_ctx of opts if it is set. Nothing is checked for a model of unknown
+// context length.
+func (c *Client) checkContextLength(modelName string, messages []api.Message, opts map[string]any) error {
+	limit := toInt(opts["num_ctx"])
+	if m, ok := c.Models[modelName].(OllamaModel); ok && limit <= 0 {
+		limit = m.ContextLength()
+	}
+	if limit <= 0 {
 		return nil
-	}); err != nil {
-		return nil, fmt.Errorf("ollama chat failed: %w", err)
 	}
 
-	if !apiResp.Done {
-		return nil, ErrIncompleteResponse
+	tokens := 0
+	for _, msg := range messages {
+		tokens += c.Tokenizer.CountTokens(msg.Content)
 	}
+	if tokens > limit {
+		return &llm.ContextTooLongError{Model: modelName, Tokens: tokens, ContextLength: limit}
+	}
+	return nil
+}
 
-	if req.Schema != nil {
-		output, err := extractJSONObject(apiResp.Message.Content)
-		if err == nil {
-			return &llm.GenerateResponse{
-				Outputs: []string{output},
-				Raw:     apiResp,
-			}, nil
+// chatFormat returns the format of a chat constrained to schema, the schema
+// itself unless the server is found to take the "json" format only.
+func (c *Client) chatFormat(schema *llm.ResponseSchema) (json.RawMessage, error) {
+	if c.legacyFormat.Load() {
+		return formatJSON, nil
+	}
+	format, err := json.Marshal(schema.S)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the schema %s: %w", schema.Name, err)
+	}
+	return format, nil
+}
+
+// fallBackToJSONFormat sets the format of req to "json" if err is the
+// rejection of its schema by a server predating the structured outputs, and
+// reports whether it did. The schema is not sent to the server from then on.
+func (c *Client) fallBackToJSONFormat(req *api.ChatRequest, err error) bool {
+	if len(req.Format) == 0 || bytes.Equal(req.Format, formatJSON) || !isFormatUnsupported(err) {
+		return false
+	}
+	if c.legacyFormat.CompareAndSwap(false, true) {
+		global.Logger.Warn().
+			Err(err).
+			Str("model", req.Model).
+			Msg("server takes no schema as the format, falling back to the json format")
+	}
+	req.Format = formatJSON
+	return true
+}
+
+// Warmup loads the named model on the server, the default generate model if
+// modelName is empty, so that the first request after the server unloaded it
+// does not wait for its weights. An embedding model embeds a short input, any
+// other model generates a single token. It stays loaded as long as KeepAlive.
+func (c *Client) Warmup(ctx context.Context, modelName string) error {
+	if modelName == "" {
+		m, ok := c.DefaultModel(llm.ModelGenerate)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNoDefaultModel, "generate")
 		}
+		modelName = m.Name()
 	}
 
-	return &llm.GenerateResponse{
-		Outputs: []string{apiResp.Message.Content},
-		Raw:     apiResp,
-	}, nil
+	var err error
+	if m, ok := c.Models[modelName]; ok && m.Type() == llm.ModelEmbed {
+		_, err = c.embedBatch(ctx, modelName, []string{"warmup"}, nil)
+		if errors.Is(err, errNoEmbedEndpoint) {
+			_, err = c.embedOne(ctx, modelName, "warmup", nil)
+		}
+	} else {
+		isStreaming := false
+		err = c.Retry(ctx, func(ctx context.Context) error {
+			return c.OllamaAPI.Chat(ctx, &api.ChatRequest{
+				Model:     modelName,
+				Messages:  []api.Message{{Role: "user", Content: "hi"}},
+				Stream:    &isStreaming,
+				Options:   map[string]any{"num_predict": 1},
+				KeepAlive: c.KeepAlive,
+			}, func(api.ChatResponse) error { return nil })
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to warm up %s: %w", modelName, err)
+	}
+	return nil
+}
+
+// HasCapability reports whether the named model has the capability, as shown
+// by the server when the client was created.
+func (c *Client) HasCapability(modelName, capability string) bool {
+	m, ok := c.Models[modelName].(OllamaModel)
+	return ok && slices.Contains(m.Capabilities, capability)
 }
 
 // Embed generates embeddings for the given request using the Ollama model.
+// The inputs are sent to the /api/embed endpoint in batches of up to
+// EmbedBatchSize, EmbedParallelism batches at a time. For a server without
+// it, the inputs are sent one by one to the deprecated /api/embeddings
+// endpoint instead, from then on.
 // Parameters:
 //   - ctx: The context for the request.
 //   - req: llm.EmbedRequest containing the inputs and model information.
 //
 // Returns:
-//   - *llm.EmbedResponse with the generated embeddings and raw response.
-//   - error if the request fails or the configuration type is invalid.
+//   - *llm.EmbedResponse with the generated embeddings and raw response. An
+//     input which failed is in the EmbedStateError state, with the error.
+//   - error if the request fails or the configuration type is invalid. If ctx
+//     is done before every input is embedded, its error is returned with the
+//     response, the inputs left in the EmbedStateCancelled state.
 func (c *Client) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.EmbedResponse, error) {
 	if req == nil {
 		return nil, llm.ErrRequestShouldNotBeNull
@@ -240,6 +515,9 @@ func (c *Client) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.EmbedRe
 	if len(req.Inputs) == 0 {
 		return nil, llm.ErrNoInput
 	}
+	if req.Dimensions != nil {
+		return nil, fmt.Errorf("%w: not supported by the ollama client", llm.ErrInvalidDimensions)
+	}
 
 	modelName := req.ModelName
 	if modelName == "" {
@@ -260,52 +538,94 @@ func (c *Client) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.EmbedRe
 		Model:      modelName,
 	}
 
-	raws := make([]OllamaEmbedRawResp, len(req.Inputs))
+	batchSize := EmbedBatchSize
+	if c.legacyEmbed.Load() {
+		batchSize = 1
+	}
+	var raws []OllamaEmbedRawResp
 	reqCh, respCh := make(chan *OllamaEmbedReq), make(chan *OllamaEmbedRawResp)
 
 	workersWg := sync.WaitGroup{}
-	for i := 0; i < Parallel; i++ {
+	for range max(c.EmbedParallelism, 1) {
 		workersWg.Add(1)
-		go func(i int, reqCh <-chan *OllamaEmbedReq, respCh chan<- *OllamaEmbedRawResp) {
+		go func(reqCh <-chan *OllamaEmbedReq, respCh chan<- *OllamaEmbedRawResp) {
 			// decrement counter when the goroutine exits.
 			defer workersWg.Done()
 			for input := range reqCh {
-				apiResp, err := c.OllamaAPI.Embeddings(ctx, input.Req)
-				respCh <- &OllamaEmbedRawResp{
-					index: input.Index,
-					Text:  input.Req.Prompt,
-					Error: err,
-					Raw:   apiResp,
+				if !c.legacyEmbed.Load() {
+					raw, err := c.embedBatch(ctx, modelName, input.Inputs, opts)
+					if !errors.Is(err, errNoEmbedEndpoint) {
+						respCh <- &OllamaEmbedRawResp{
+							index: input.Index,
+							Texts: input.Inputs,
+							Error: err,
+							Raw:   raw,
+						}
+						continue
+					}
+				}
+
+				for j, text := range input.Inputs {
+					raw, err := c.embedOne(ctx, modelName, text, opts)
+					respCh <- &OllamaEmbedRawResp{
+						index: input.Index + j,
+						Texts: []string{text},
+						Error: err,
+						Raw:   raw,
+					}
 				}
 			}
-		}(i, reqCh, respCh)
+		}(reqCh, respCh)
 	}
 
+	done := make([]bool, len(req.Inputs))
 	collectorWg := sync.WaitGroup{}
 	collectorWg.Add(1)
 	go func(respCh <-chan *OllamaEmbedRawResp) {
 		defer collectorWg.Done()
 		for rawResp := range respCh {
-			if rawResp.Error != nil {
-				resp.Embeddings[rawResp.index].State = llm.EmbedStateError
-			} else {
-				resp.Embeddings[rawResp.index] = llm.Embedding{
-					State:  llm.EmbedStateOk,
-					Values: utils.ToFloat32(rawResp.Raw.Embedding),
+			for j := range rawResp.Texts {
+				i := rawResp.index + j
+				switch {
+				case rawResp.Error != nil && ctx.Err() != nil:
+					resp.Embeddings[i] = llm.Embedding{
+						State: llm.EmbedStateCancelled,
+						Error: rawResp.Error.Error(),
+					}
+				case rawResp.Error != nil:
+					resp.Embeddings[i] = llm.Embedding{
+						State: llm.EmbedStateError,
+						Error: rawResp.Error.Error(),
+					}
+				default:
+					resp.Embeddings[i] = llm.Embedding{
+						State:  llm.EmbedStateOk,
+						Values: rawResp.Raw.Embeddings[j],
+					}
 				}
+				done[i] = true
+			}
+			if rawResp.Raw != nil {
+				resp.Usage.PromptTokens += rawResp.Raw.PromptEvalCount
+				resp.Usage.TotalTokens += rawResp.Raw.PromptEvalCount
 			}
-			raws[rawResp.index] = *rawResp
+			raws = append(raws, *rawResp)
 		}
 	}(respCh)
 
-	for i, input := range req.Inputs {
-		reqCh <- &OllamaEmbedReq{
-			Index: i,
-			Req: &api.EmbeddingRequest{
-				Model:   modelName,
-				Prompt:  input.String(),
-				Options: opts,
-			},
+	// stop feeding the workers once ctx is done, they finish the inputs
+	// they have, which fail early as the requests share ctx
+produce:
+	for start := 0; start < len(req.Inputs); start += batchSize {
+		texts := make([]string, 0, batchSize)
+		for _, input := range req.Inputs[start:min(start+batchSize, len(req.Inputs))] {
+			texts = append(texts, input.String())
+		}
+
+		select {
+		case reqCh <- &OllamaEmbedReq{Index: start, Inputs: texts}:
+		case <-ctx.Done():
+			break produce
 		}
 	}
 	close(reqCh)
@@ -314,10 +634,77 @@ func (c *Client) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.EmbedRe
 	close(respCh)
 	collectorWg.Wait()
 
+	slices.SortFunc(raws, func(a, b OllamaEmbedRawResp) int { return a.index - b.index })
 	resp.Raw = raws
+	if err := ctx.Err(); err != nil {
+		for i := range done {
+			if !done[i] {
+				resp.Embeddings[i] = llm.Embedding{State: llm.EmbedStateCancelled, Error: err.Error()}
+			}
+		}
+		return resp, err
+	}
 	return resp, nil
 }
 
+// embedBatch embeds texts with a request to /api/embed. The error is
+// errNoEmbedEndpoint if the server does not have it, it is not tried again.
+func (c *Client) embedBatch(ctx context.Context, modelName string, texts []string,
+	opts map[string]any) (*api.EmbedResponse, error) {
+	var apiResp *api.EmbedResponse
+	err := c.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		apiResp, err = c.OllamaAPI.Embed(ctx, &api.EmbedRequest{
+			Model:     modelName,
+			Input:     texts,
+			Options:   opts,
+			KeepAlive: c.KeepAlive,
+		})
+		return err
+	})
+	if isEndpointNotFound(err) {
+		if c.legacyEmbed.CompareAndSwap(false, true) {
+			global.Logger.Warn().
+				Str("model", modelName).
+				Msg("server has no /api/embed endpoint, falling back to /api/embeddings")
+		}
+		return nil, errNoEmbedEndpoint
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(apiResp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("%w: %d embeddings for %d inputs",
+			llm.ErrEmbedFailed, len(apiResp.Embeddings), len(texts))
+	}
+	return apiResp, nil
+}
+
+// embedOne embeds text with a request to the deprecated /api/embeddings, in
+// the shape of a response of /api/embed.
+func (c *Client) embedOne(ctx context.Context, modelName, text string,
+	opts map[string]any) (*api.EmbedResponse, error) {
+	var apiResp *api.EmbeddingResponse
+	err := c.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		apiResp, err = c.OllamaAPI.Embeddings(ctx, &api.EmbeddingRequest{
+			Model:     modelName,
+			Prompt:    text,
+			Options:   opts,
+			KeepAlive: c.KeepAlive,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the deprecated endpoint reports no token counts
+	return &api.EmbedResponse{
+		Model:      modelName,
+		Embeddings: [][]float32{utils.ToFloat32(apiResp.Embedding)},
+	}, nil
+}
+
 // BatchGenerate is not supported by Ollama.
 func (c *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm.BatchResponse, error) {
 	return nil, llm.ErrNotImplemented
@@ -7,15 +7,26 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"github.com/ChiaYuChang/weathercock/internal/global"
 	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/llm/llmtest"
 	"github.com/ChiaYuChang/weathercock/internal/llm/ollama"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
 	"github.com/invopop/jsonschema"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
 )
 
@@ -568,3 +579,971 @@ func TestOllamaForamatOutput(t *testing.T) {
 		require.Equal(t, data[i], r)
 	}
 }
+
+// newFakeOllama creates a client of a fake Ollama server, serving the models
+// and mux for the other endpoints.
+func newFakeOllama(t testing.TB, mux *http.ServeMux, opts ...ollama.Option) *ollama.Client {
+	t.Helper()
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":[]}`))
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"capabilities":["completion","embedding"]}`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	cli, err := ollama.Ollama(context.Background(), append([]ollama.Option{
+		ollama.WithHost(server.URL),
+		ollama.WithModel(
+			ollama.NewOllamaModel(llm.ModelGenerate, GenModel),
+			ollama.NewOllamaModel(llm.ModelEmbed, EmbedModel),
+		),
+		ollama.WithDefaultGenerate(GenModel),
+		ollama.WithDefaultEmbed(EmbedModel),
+	}, opts...)...)
+	require.NoError(t, err)
+	return cli
+}
+
+func TestOllamaGenerationParams(t *testing.T) {
+	var options map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Options map[string]any `json:"options"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		options = req.Options
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"gemma3:270m","message":{"role":"assistant","content":"ok"},"done":true,"done_reason":"length"}`))
+	})
+	cli := newFakeOllama(t, mux)
+
+	req := &llm.GenerateRequest{
+		Messages:    []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+		Temperature: utils.Ptr[float32](0.5),
+		TopP:        utils.Ptr[float32](0.25),
+		MaxTokens:   utils.Ptr(64),
+		Stop:        []string{"END"},
+		Seed:        utils.Ptr[int64](42),
+	}
+	resp, err := cli.Generate(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ok"}, resp.Outputs)
+	require.True(t, resp.Truncated())
+	require.Equal(t, map[string]any{
+		"temperature": 0.5,
+		"top_p":       0.25,
+		"num_predict": float64(64),
+		"stop":        []any{"END"},
+		"seed":        float64(42),
+	}, options)
+
+	// the config overrides the parameters of the request, and is not modified
+	config := map[string]any{"temperature": 1, "num_ctx": 2048}
+	req.Config = config
+	_, err = cli.Generate(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, float64(1), options["temperature"])
+	require.Equal(t, float64(2048), options["num_ctx"])
+	require.Equal(t, 0.25, options["top_p"])
+	require.Len(t, config, 2)
+
+	_, err = cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+	})
+	require.NoError(t, err)
+	require.Empty(t, options)
+
+	_, err = cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages: []llm.Message{
+			llm.NewTextMessage(llm.RoleUser, "hello"),
+			llm.NewToolMessage("call_1", `{"positions":[]}`),
+		},
+	})
+	require.ErrorIs(t, err, llm.ErrNotImplemented)
+}
+
+// keepAliveServer is a fake Ollama server recording the path, model and
+// keep_alive of the chat and embed requests.
+type keepAliveServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *keepAliveServer) mux(t *testing.T) *http.ServeMux {
+	mux := http.NewServeMux()
+	record := func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model     string          `json:"model"`
+			KeepAlive json.RawMessage `json:"keep_alive"`
+			Options   map[string]any  `json:"options"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		s.mu.Lock()
+		s.requests = append(s.requests, fmt.Sprintf("%s %s %s %v", r.URL.Path, req.Model, string(req.KeepAlive), req.Options))
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/chat":
+			w.Write([]byte(`{"model":"gemma3:270m","message":{"role":"assistant","content":"ok"},"done":true}`))
+		case "/api/embed":
+			w.Write([]byte(`{"model":"bge-large:latest","embeddings":[[1]]}`))
+		case "/api/embeddings":
+			w.Write([]byte(`{"embedding":[1]}`))
+		}
+	}
+	mux.HandleFunc("POST /api/chat", record)
+	mux.HandleFunc("POST /api/embed", record)
+	mux.HandleFunc("POST /api/embeddings", record)
+	return mux
+}
+
+func (s *keepAliveServer) reset() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func TestOllamaKeepAlive(t *testing.T) {
+	ctx := context.Background()
+	server := &keepAliveServer{}
+	cli := newFakeOllama(t, server.mux(t), ollama.WithKeepAlive(30*time.Minute))
+	require.Equal(t, 30*time.Minute, cli.KeepAlive.Duration)
+
+	_, err := cli.Generate(ctx, &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+	})
+	require.NoError(t, err)
+	_, err = cli.Embed(ctx, &llm.EmbedRequest{Inputs: embedInputs(1)})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`/api/chat gemma3:270m "30m0s" map[]`,
+		`/api/embed bge-large:latest "30m0s" map[]`,
+	}, server.reset())
+
+	t.Run("forever", func(t *testing.T) {
+		server := &keepAliveServer{}
+		cli := newFakeOllama(t, server.mux(t), ollama.WithKeepAlive(-1))
+		require.NoError(t, cli.Warmup(ctx, ""))
+		require.Equal(t, []string{`/api/chat gemma3:270m -1 map[num_predict:1]`}, server.reset())
+	})
+
+	t.Run("server default", func(t *testing.T) {
+		server := &keepAliveServer{}
+		cli := newFakeOllama(t, server.mux(t))
+		require.Nil(t, cli.KeepAlive)
+		_, err := cli.Embed(ctx, &llm.EmbedRequest{Inputs: embedInputs(1)})
+		require.NoError(t, err)
+		require.Equal(t, []string{`/api/embed bge-large:latest  map[]`}, server.reset())
+	})
+}
+
+func TestOllamaWarmup(t *testing.T) {
+	ctx := context.Background()
+	server := &keepAliveServer{}
+	cli := newFakeOllama(t, server.mux(t), ollama.WithKeepAlive(time.Hour))
+
+	require.NoError(t, cli.Warmup(ctx, ""))
+	require.NoError(t, cli.Warmup(ctx, GenModel))
+	require.NoError(t, cli.Warmup(ctx, EmbedModel))
+	require.NoError(t, llm.Warmup(ctx, cli, EmbedModel))
+	require.Equal(t, []string{
+		`/api/chat gemma3:270m "1h0m0s" map[num_predict:1]`,
+		`/api/chat gemma3:270m "1h0m0s" map[num_predict:1]`,
+		`/api/embed bge-large:latest "1h0m0s" map[]`,
+		`/api/embed bge-large:latest "1h0m0s" map[]`,
+	}, server.reset())
+
+	t.Run("legacy server", func(t *testing.T) {
+		mux := server.mux(t)
+		legacy := http.NewServeMux()
+		legacy.Handle("POST /api/embeddings", mux)
+		legacy.Handle("POST /api/chat", mux)
+		cli := newFakeOllama(t, legacy)
+		require.NoError(t, cli.Warmup(ctx, EmbedModel))
+		require.Equal(t, []string{`/api/embeddings bge-large:latest  map[]`}, server.reset())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"model \"llama3\" not found, try pulling it first"}`))
+		})
+		cli := newFakeOllama(t, mux)
+		err := cli.Warmup(ctx, "llama3")
+		require.ErrorContains(t, err, "failed to warm up llama3")
+		require.ErrorContains(t, err, "not found")
+	})
+
+	// the clients loading no models on demand have nothing to warm up
+	require.NoError(t, llm.Warmup(ctx, llmtest.NewMockLLM(), ""))
+}
+
+// pullServer is a fake Ollama server on which the models are missing until
+// they are pulled, the pull streams progress lines.
+type pullServer struct {
+	mu     sync.Mutex
+	pulled map[string]bool
+	// progress are the lines streamed by a pull if the model is known.
+	progress []string
+	pulls    []string
+	// block makes the pulls wait for their request to be cancelled.
+	block bool
+}
+
+func (s *pullServer) mux(t *testing.T) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":[]}`))
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		s.mu.Lock()
+		pulled := s.pulled[req.Model]
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !pulled {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error":"model '%s' not found"}`, req.Model)
+			return
+		}
+		w.Write([]byte(`{"capabilities":["completion","embedding"]}`))
+	})
+	mux.HandleFunc("POST /api/pull", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		s.mu.Lock()
+		s.pulls = append(s.pulls, req.Model)
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if req.Model == "unknown:latest" {
+			w.Write([]byte(`{"status":"pulling manifest"}` + "\n"))
+			w.Write([]byte(`{"error":"pull model manifest: file does not exist"}` + "\n"))
+			return
+		}
+		for _, line := range s.progress {
+			w.Write([]byte(line + "\n"))
+		}
+		if s.block {
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		s.mu.Lock()
+		s.pulled[req.Model] = true
+		s.mu.Unlock()
+		w.Write([]byte(`{"status":"success"}` + "\n"))
+	})
+	return mux
+}
+
+func TestOllamaAutoPull(t *testing.T) {
+	logs := &strings.Builder{}
+	prev := global.Logger
+	global.Logger = zerolog.New(logs)
+	t.Cleanup(func() { global.Logger = prev })
+
+	newServer := func(t *testing.T, s *pullServer) string {
+		s.pulled = map[string]bool{}
+		server := httptest.NewServer(s.mux(t))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	opts := func(host string, models ...string) []ollama.Option {
+		return []ollama.Option{
+			ollama.WithHost(host),
+			ollama.WithModel(
+				ollama.NewOllamaModel(llm.ModelGenerate, models[0]),
+				ollama.NewOllamaModel(llm.ModelEmbed, models[1]),
+			),
+			ollama.WithDefaultGenerate(models[0]),
+			ollama.WithDefaultEmbed(models[1]),
+			ollama.WithRetryPolicy(llm.RetryPolicy{MaxAttempts: 1}),
+		}
+	}
+
+	server := &pullServer{progress: []string{
+		`{"status":"pulling manifest"}`,
+		`{"status":"pulling aeda25e63ebd","digest":"sha256:aeda25e63ebd","total":1000,"completed":0}`,
+		`{"status":"pulling aeda25e63ebd","digest":"sha256:aeda25e63ebd","total":1000,"completed":50}`,
+		`{"status":"pulling aeda25e63ebd","digest":"sha256:aeda25e63ebd","total":1000,"completed":120}`,
+		`{"status":"pulling aeda25e63ebd","digest":"sha256:aeda25e63ebd","total":1000,"completed":1000}`,
+		`{"status":"pulling aeda25e63ebd","digest":"sha256:aeda25e63ebd","total":1000,"completed":1000}`,
+		`{"status":"verifying sha256 digest"}`,
+	}}
+	host := newServer(t, server)
+
+	// the models are not pulled unless asked to
+	_, err := ollama.Ollama(context.Background(), opts(host, GenModel, EmbedModel)...)
+	require.ErrorIs(t, err, ollama.ErrModelNotFount)
+	require.Empty(t, server.pulls)
+
+	cli, err := ollama.Ollama(context.Background(),
+		append(opts(host, GenModel, EmbedModel), ollama.WithAutoPull(true))...)
+	require.NoError(t, err)
+	require.True(t, cli.HasModel(GenModel))
+	require.ElementsMatch(t, []string{GenModel, EmbedModel}, server.pulls)
+
+	var percents []int
+	for line := range strings.Lines(logs.String()) {
+		var entry struct {
+			Model   string `json:"model"`
+			Layer   string `json:"layer"`
+			Percent *int   `json:"percent"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		if entry.Model == GenModel && entry.Percent != nil {
+			require.Equal(t, "sha256:aeda25e63ebd", entry.Layer)
+			percents = append(percents, *entry.Percent)
+		}
+	}
+	require.Equal(t, []int{0, 12, 100}, percents)
+	require.Contains(t, logs.String(), `"status":"verifying sha256 digest"`)
+	require.Contains(t, logs.String(), `"message":"model pulled"`)
+
+	t.Run("rejected by the registry", func(t *testing.T) {
+		server := &pullServer{}
+		_, err := ollama.Ollama(context.Background(),
+			append(opts(newServer(t, server), "unknown:latest", EmbedModel), ollama.WithAutoPull(true))...)
+		require.ErrorIs(t, err, ollama.ErrModelPullFailed)
+		require.ErrorContains(t, err, "unknown:latest")
+		require.ErrorContains(t, err, "file does not exist")
+		require.Contains(t, server.pulls, "unknown:latest")
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		server := &pullServer{block: true, progress: []string{`{"status":"pulling manifest"}`}}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := ollama.Ollama(ctx,
+			append(opts(newServer(t, server), GenModel, EmbedModel), ollama.WithAutoPull(true))...)
+		require.ErrorIs(t, err, ollama.ErrModelPullFailed)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestOllamaStructuredFormat(t *testing.T) {
+	recorded, err := os.ReadFile(filepath.Join("testdata", "chat_format.json"))
+	require.NoError(t, err)
+
+	schema := &llm.ResponseSchema{Name: "city_weather", S: map[string]any{
+		"type":     "object",
+		"required": []string{"n", "records"},
+		"properties": map[string]any{
+			"n": map[string]any{"type": "integer"},
+			"records": map[string]any{"type": "array", "items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"city":    map[string]any{"type": "string"},
+					"weather": map[string]any{"type": "string"},
+				},
+			}},
+		},
+	}}
+	want := `{"n": 2, "records": [{"city": "Taipei", "weather": "Sunny"}, {"city": "London", "weather": "Cloudy"}]}`
+	req := &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "Taipei: sunny, London: cloudy")},
+		Schema:   schema,
+	}
+
+	type chatRequest struct {
+		Format  json.RawMessage `json:"format"`
+		Options map[string]any  `json:"options"`
+	}
+	var formats []string
+	chatHandler := func(t *testing.T, handle func(w http.ResponseWriter, req chatRequest)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var req chatRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			require.NotContains(t, req.Options, "schema")
+			formats = append(formats, string(req.Format))
+			w.Header().Set("Content-Type", "application/json")
+			handle(w, req)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", chatHandler(t, func(w http.ResponseWriter, req chatRequest) {
+		w.Write(recorded)
+	}))
+	cli := newFakeOllama(t, mux)
+
+	resp, err := cli.Generate(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, []string{want}, resp.Outputs)
+	require.NoError(t, resp.SchemaErr)
+	require.Equal(t, llm.Usage{PromptTokens: 74, CompletionTokens: 39, TotalTokens: 113}, resp.Usage)
+
+	wantFormat, err := json.Marshal(schema.S)
+	require.NoError(t, err)
+	require.Len(t, formats, 1)
+	require.JSONEq(t, string(wantFormat), formats[0])
+
+	// no format without a schema
+	_, err = cli.Generate(context.Background(), &llm.GenerateRequest{Messages: req.Messages})
+	require.NoError(t, err)
+	require.Empty(t, formats[1])
+
+	t.Run("server without structured outputs", func(t *testing.T) {
+		formats = nil
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /api/chat", chatHandler(t, func(w http.ResponseWriter, req chatRequest) {
+			if string(req.Format) != `"json"` {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"json: cannot unmarshal object into Go struct field ChatRequest.format of type string"}`))
+				return
+			}
+			w.Write([]byte(`{"model":"gemma3:270m","message":{"role":"assistant","content":` +
+				strconv.Quote("Here you go:\n```json\n"+want+"\n```") + `},"done":true}`))
+		}))
+		cli := newFakeOllama(t, mux)
+
+		for range 2 {
+			resp, err := cli.Generate(context.Background(), req)
+			require.NoError(t, err)
+			require.JSONEq(t, want, resp.Outputs[0])
+			require.NoError(t, resp.SchemaErr)
+		}
+		// the schema is only sent until the server rejects it
+		require.Equal(t, string(wantFormat), formats[0])
+		require.Equal(t, []string{`"json"`, `"json"`}, formats[1:])
+	})
+}
+
+func TestOllamaModelContextLength(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		info map[string]any
+		want int
+	}{
+		{"architecture", map[string]any{
+			"general.architecture":      "gemma3",
+			"gemma3.context_length":     float64(131072),
+			"gemma3.vision.image_size":  float64(896),
+			"gemma3.embedding_length":   float64(3840),
+			"general.parameter_count":   float64(12187325040),
+			"tokenizer.ggml.model":      "llama",
+			"tokenizer.ggml.token_type": nil,
+		}, 131072},
+		{"other key", map[string]any{"bert.context_length": json.Number("8192")}, 8192},
+		{"unknown", map[string]any{"general.architecture": "gemma3"}, 0},
+		{"no info", nil, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := ollama.NewOllamaModel(llm.ModelGenerate, GenModel)
+			m.ModelInfo = tc.info
+			require.Equal(t, tc.want, m.ContextLength())
+		})
+	}
+}
+
+// runeTokenizer counts every rune as a token.
+type runeTokenizer struct{}
+
+func (runeTokenizer) CountTokens(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+func TestOllamaContextTooLong(t *testing.T) {
+	var chats atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":[]}`))
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"capabilities":["completion","embedding"],` +
+			`"model_info":{"general.architecture":"gemma3","gemma3.context_length":48}}`))
+	})
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+		chats.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"gemma3:270m","message":{"role":"assistant","content":"ok"},` +
+			`"done":true,"prompt_eval_count":51,"eval_count":1}`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	newClient := func(t *testing.T, opts ...ollama.Option) *ollama.Client {
+		cli, err := ollama.Ollama(context.Background(), append([]ollama.Option{
+			ollama.WithHost(server.URL),
+			ollama.WithModel(
+				ollama.NewOllamaModel(llm.ModelGenerate, GenModel),
+				ollama.NewOllamaModel(llm.ModelEmbed, EmbedModel),
+			),
+			ollama.WithDefaultGenerate(GenModel),
+			ollama.WithDefaultEmbed(EmbedModel),
+		}, opts...)...)
+		require.NoError(t, err)
+		return cli
+	}
+	request := func(text string) *llm.GenerateRequest {
+		return &llm.GenerateRequest{Messages: []llm.Message{
+			llm.NewTextMessage(llm.RoleSystem, "Summarize the article."),
+			llm.NewTextMessage(llm.RoleUser, text),
+		}}
+	}
+
+	cli := newClient(t)
+	// 200 bytes and the 6 tokens of the system message
+	resp, err := cli.Generate(context.Background(), request(strings.Repeat("abcd", 50)))
+	var tooLong *llm.ContextTooLongError
+	require.ErrorAs(t, err, &tooLong)
+	require.ErrorIs(t, err, llm.ErrContextTooLong)
+	require.Equal(t, llm.ContextTooLongError{Model: GenModel, Tokens: 56, ContextLength: 48}, *tooLong)
+	require.ErrorContains(t, err, "gemma3:270m, 56 tokens, 48 allowed")
+	require.Nil(t, resp)
+
+	_, err = cli.GenerateStream(context.Background(), request(strings.Repeat("abcd", 100)))
+	require.ErrorIs(t, err, llm.ErrContextTooLong)
+	require.Zero(t, chats.Load())
+
+	resp, err = cli.Generate(context.Background(), request(strings.Repeat("abcd", 10)))
+	require.NoError(t, err)
+	require.Equal(t, llm.Usage{PromptTokens: 51, CompletionTokens: 1, TotalTokens: 52}, resp.Usage)
+
+	t.Run("num_ctx", func(t *testing.T) {
+		req := request(strings.Repeat("abcd", 10))
+		req.Config = map[string]any{"num_ctx": 8}
+		_, err := cli.Generate(context.Background(), req)
+		require.ErrorAs(t, err, &tooLong)
+		require.Equal(t, 8, tooLong.ContextLength)
+	})
+
+	t.Run("tokenizer", func(t *testing.T) {
+		req := request(strings.Repeat("abcd", 11))
+		_, err := cli.Generate(context.Background(), req)
+		require.NoError(t, err)
+
+		cli := newClient(t, ollama.WithTokenizer(runeTokenizer{}))
+		_, err = cli.Generate(context.Background(), req)
+		require.ErrorAs(t, err, &tooLong)
+		require.Equal(t, 66, tooLong.Tokens)
+
+		_, err = ollama.Ollama(context.Background(), ollama.WithTokenizer(nil))
+		require.ErrorContains(t, err, "tokenizer should not be nil")
+	})
+}
+
+func TestOllamaHeaderAuth(t *testing.T) {
+	var mu sync.Mutex
+	paths := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"gemma3:270m","message":{"role":"assistant","content":"ok"},"done":true}`))
+	})
+	mux.HandleFunc("POST /api/embed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"bge-large:latest","embeddings":[[1]]}`))
+	})
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":[]}`))
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"capabilities":["completion","embedding"]}`))
+	})
+	// the proxy rejects the requests without the token
+	proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" || r.Header.Get("X-Tenant") != "weathercock" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized"}`))
+			return
+		}
+		mu.Lock()
+		paths[r.URL.Path]++
+		mu.Unlock()
+		mux.ServeHTTP(w, r)
+	})
+	server := httptest.NewServer(proxy)
+	t.Cleanup(server.Close)
+
+	opts := func(opts ...ollama.Option) []ollama.Option {
+		return append([]ollama.Option{
+			ollama.WithHost(server.URL),
+			ollama.WithModel(
+				ollama.NewOllamaModel(llm.ModelGenerate, GenModel),
+				ollama.NewOllamaModel(llm.ModelEmbed, EmbedModel),
+			),
+			ollama.WithDefaultGenerate(GenModel),
+			ollama.WithDefaultEmbed(EmbedModel),
+			ollama.WithRetryPolicy(llm.RetryPolicy{MaxAttempts: 1}),
+		}, opts...)
+	}
+
+	_, err := ollama.Ollama(context.Background(), opts(ollama.WithBearerToken("s3cr3t"))...)
+	require.ErrorIs(t, err, ollama.ErrCanNotConnectToServer)
+	require.ErrorContains(t, err, "unauthorized")
+
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	cli, err := ollama.Ollama(context.Background(), opts(
+		ollama.WithHTTPClient(httpClient),
+		ollama.WithBearerToken("s3cr3t"),
+		ollama.WithHeader("X-Tenant", "weathercock"),
+	)...)
+	require.NoError(t, err)
+	require.Nil(t, httpClient.Transport)
+
+	_, err = cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+	})
+	require.NoError(t, err)
+	resp, err := cli.Embed(context.Background(), &llm.EmbedRequest{Inputs: embedInputs(1)})
+	require.NoError(t, err)
+	require.Equal(t, llm.EmbedStateOk, resp.Embeddings[0].State)
+	require.Equal(t, map[string]int{"/api/tags": 1, "/api/show": 2, "/api/chat": 1, "/api/embed": 1}, paths)
+
+	_, err = ollama.Ollama(context.Background(), ollama.WithBearerToken(""))
+	require.ErrorContains(t, err, "bearer token should not be empty")
+	_, err = ollama.Ollama(context.Background(), ollama.WithHeader("", "x"))
+	require.ErrorContains(t, err, "header key should not be empty")
+}
+
+// collectChunks reads ch until it is closed.
+func collectChunks(t *testing.T, ch <-chan llm.GenerateChunk) []llm.GenerateChunk {
+	t.Helper()
+	var chunks []llm.GenerateChunk
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case chunk, ok := <-ch:
+			if !ok {
+				return chunks
+			}
+			chunks = append(chunks, chunk)
+		case <-timeout:
+			t.Fatal("stream not closed")
+		}
+	}
+}
+
+func TestOllamaGenerateStream(t *testing.T) {
+	lines := []string{
+		`{"model":"gemma3:270m","message":{"role":"assistant","content":"三鶯線"},"done":false}`,
+		`{"model":"gemma3:270m","message":{"role":"assistant","content":" opens"},"done":false}`,
+		`{"model":"gemma3:270m","message":{"role":"assistant","content":" today"},"done":false}`,
+		`{"model":"gemma3:270m","message":{"role":"assistant","content":""},"done":true,` +
+			`"done_reason":"length","prompt_eval_count":12,"eval_count":3}`,
+	}
+	var drop atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream *bool `json:"stream"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.True(t, *req.Stream)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i, line := range lines {
+			if drop.Load() && i == 2 {
+				// the connection drops in the middle of the stream
+				conn, _, err := w.(http.Hijacker).Hijack()
+				require.NoError(t, err)
+				conn.Close()
+				return
+			}
+			w.Write([]byte(line + "\n"))
+			w.(http.Flusher).Flush()
+		}
+	})
+	cli := newFakeOllama(t, mux)
+	req := &llm.GenerateRequest{Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "news")}}
+
+	ch, err := cli.GenerateStream(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, []llm.GenerateChunk{
+		{Index: 0, Delta: "三鶯線"},
+		{Index: 1, Delta: " opens"},
+		{Index: 2, Delta: " today"},
+		{Index: 3, Done: true, StreamEnd: llm.StreamEnd{
+			FinishReason: "length",
+			Usage:        llm.Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+		}},
+	}, collectChunks(t, ch))
+
+	t.Run("connection dropped", func(t *testing.T) {
+		drop.Store(true)
+		defer drop.Store(false)
+
+		ch, err := cli.GenerateStream(context.Background(), req)
+		require.NoError(t, err)
+		chunks := collectChunks(t, ch)
+		require.Len(t, chunks, 3)
+		require.Equal(t, "三鶯線 opens", chunks[0].Delta+chunks[1].Delta)
+		require.True(t, chunks[2].Done)
+		require.ErrorIs(t, chunks[2].Err, ollama.ErrIncompleteResponse)
+		require.Zero(t, chunks[2].StreamEnd)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		ch, err := cli.GenerateStream(ctx, req)
+		require.NoError(t, err)
+		for chunk := range ch {
+			require.Empty(t, chunk.StreamEnd.FinishReason)
+		}
+	})
+}
+
+// embedInputs returns n inputs, the i-th of which is "input i".
+func embedInputs(n int) []llm.EmbedInput {
+	inputs := make([]llm.EmbedInput, n)
+	for i := range inputs {
+		inputs[i] = llm.NewSimpleTextInput(fmt.Sprintf("input %d", i))
+	}
+	return inputs
+}
+
+// inputVector is the embedding the fake server returns for "input i", [i].
+func inputVector(text string) []float32 {
+	var i float32
+	fmt.Sscanf(text, "input %g", &i)
+	return []float32{i}
+}
+
+// embedServer is a fake Ollama server embedding "input i" as [i], on /api/embed
+// unless legacy, after the delay of a request.
+type embedServer struct {
+	legacy     bool
+	delay      time.Duration
+	batchCalls atomic.Int32
+	calls      atomic.Int32
+	// onCall is called with the number of the call before it is answered.
+	onCall func(n int32)
+}
+
+func (s *embedServer) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/embed", func(w http.ResponseWriter, r *http.Request) {
+		s.batchCalls.Add(1)
+		if s.legacy {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.call()
+		w.Header().Set("Content-Type", "application/json")
+		if req.Model != EmbedModel {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error":"model %q not found, try pulling it first"}`, req.Model)
+			return
+		}
+
+		embeddings := make([][]float32, len(req.Input))
+		for i, text := range req.Input {
+			embeddings[i] = inputVector(text)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"model": req.Model, "embeddings": embeddings, "prompt_eval_count": 2 * len(req.Input)})
+	})
+	mux.HandleFunc("POST /api/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Prompt string `json:"prompt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.call()
+		w.Header().Set("Content-Type", "application/json")
+		if req.Prompt == "input 3" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"input too long"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"embedding": inputVector(req.Prompt)})
+	})
+	return mux
+}
+
+func (s *embedServer) call() {
+	n := s.calls.Add(1)
+	if s.onCall != nil {
+		s.onCall(n)
+	}
+	time.Sleep(s.delay)
+}
+
+func TestOllamaEmbedBatch(t *testing.T) {
+	_, err := ollama.Ollama(context.Background(), ollama.WithEmbedParallelism(0))
+	require.ErrorContains(t, err, "embed parallelism should be at least 1")
+
+	server := &embedServer{}
+	cli := newFakeOllama(t, server.mux(), ollama.WithEmbedParallelism(2))
+	require.Equal(t, 2, cli.EmbedParallelism)
+
+	resp, err := cli.Embed(context.Background(), &llm.EmbedRequest{Inputs: embedInputs(100)})
+	require.NoError(t, err)
+	require.EqualValues(t, 4, server.calls.Load())
+	require.Equal(t, llm.Usage{PromptTokens: 200, TotalTokens: 200}, resp.Usage)
+	for i, emb := range resp.Embeddings {
+		require.Equal(t, llm.EmbedStateOk, emb.State)
+		require.Equal(t, []float32{float32(i)}, emb.Values)
+	}
+
+	// a model not found is an error, not a server without the endpoint
+	resp, err = cli.Embed(context.Background(), &llm.EmbedRequest{Inputs: embedInputs(2), ModelName: "bge-m3"})
+	require.NoError(t, err)
+	require.Equal(t, llm.EmbedStateError, resp.Embeddings[1].State)
+	require.Contains(t, resp.Embeddings[1].Error, "not found")
+
+	t.Run("legacy server", func(t *testing.T) {
+		server := &embedServer{legacy: true}
+		cli := newFakeOllama(t, server.mux())
+		require.Equal(t, ollama.DefaultEmbedParallelism, cli.EmbedParallelism)
+
+		for range 2 {
+			resp, err := cli.Embed(context.Background(), &llm.EmbedRequest{Inputs: embedInputs(40)})
+			require.NoError(t, err)
+			require.Equal(t, []float32{39}, resp.Embeddings[39].Values)
+			require.Equal(t, llm.EmbedStateError, resp.Embeddings[3].State)
+			require.Contains(t, resp.Embeddings[3].Error, "input too long")
+		}
+		require.EqualValues(t, 80, server.calls.Load())
+		// the endpoint is only tried until it is found missing
+		require.LessOrEqual(t, server.batchCalls.Load(), int32(ollama.DefaultEmbedParallelism))
+	})
+}
+
+func TestOllamaEmbedCancelled(t *testing.T) {
+	t.Run("batch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server := &embedServer{onCall: func(n int32) { cancel() }}
+		cli := newFakeOllama(t, server.mux(), ollama.WithEmbedParallelism(1))
+
+		resp, err := cli.Embed(ctx, &llm.EmbedRequest{Inputs: embedInputs(50)})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Len(t, resp.Embeddings, 50)
+		require.EqualValues(t, 1, server.calls.Load())
+		for _, emb := range resp.Embeddings {
+			require.Equal(t, llm.EmbedStateCancelled, emb.State)
+			require.NotEmpty(t, emb.Error)
+		}
+	})
+
+	t.Run("legacy server", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server := &embedServer{legacy: true, onCall: func(n int32) {
+			if n == 10 {
+				cancel()
+			}
+		}}
+		cli := newFakeOllama(t, server.mux(), ollama.WithEmbedParallelism(3),
+			ollama.WithRetryPolicy(llm.RetryPolicy{MaxAttempts: 1}))
+
+		resp, err := cli.Embed(ctx, &llm.EmbedRequest{Inputs: embedInputs(50)})
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotNil(t, resp)
+		require.Len(t, resp.Embeddings, 50)
+		require.Less(t, int(server.calls.Load()), 10+cli.EmbedParallelism)
+
+		states := map[string]int{}
+		for _, emb := range resp.Embeddings {
+			states[emb.State]++
+			if emb.State == llm.EmbedStateCancelled {
+				require.NotEmpty(t, emb.Error)
+			}
+		}
+		require.Equal(t, 50, states[llm.EmbedStateOk]+states[llm.EmbedStateError]+states[llm.EmbedStateCancelled])
+		// the calls before the 10th were answered, but for input 3 and the
+		// ones still in flight
+		require.GreaterOrEqual(t, states[llm.EmbedStateOk], 10-1-cli.EmbedParallelism)
+		require.GreaterOrEqual(t, states[llm.EmbedStateCancelled], 50-10-cli.EmbedParallelism)
+
+		require.Equal(t, llm.EmbedStateError, resp.Embeddings[3].State)
+		require.Contains(t, resp.Embeddings[3].Error, "input too long")
+	})
+}
+
+// BenchmarkOllamaEmbed embeds 100 inputs with a server taking 2ms a request.
+func BenchmarkOllamaEmbed(b *testing.B) {
+	for _, bc := range []struct {
+		name        string
+		legacy      bool
+		parallelism int
+	}{
+		{name: "embeddings/parallel-1", legacy: true, parallelism: 1},
+		{name: "embeddings/parallel-8", legacy: true, parallelism: 8},
+		{name: "embed/parallel-1", parallelism: 1},
+		{name: "embed/parallel-8", parallelism: 8},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			server := &embedServer{legacy: bc.legacy, delay: 2 * time.Millisecond}
+			cli := newFakeOllama(b, server.mux(), ollama.WithEmbedParallelism(bc.parallelism))
+			req := &llm.EmbedRequest{Inputs: embedInputs(100)}
+
+			b.ResetTimer()
+			for range b.N {
+				if _, err := cli.Embed(context.Background(), req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestOllamaProxy(t *testing.T) {
+	proxy := llmtest.NewProxy()
+	defer proxy.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"gemma3:270m","message":{"role":"assistant","content":"ok"},"done":true}`))
+	})
+	cli := newFakeOllama(t, mux, ollama.WithProxy(proxy.ProxyURL("weathercock", "s3cret")))
+
+	_, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+	})
+	require.NoError(t, err)
+
+	var paths []string
+	for _, req := range proxy.Requests() {
+		require.Equal(t, "Basic d2VhdGhlcmNvY2s6czNjcmV0", req.Auth)
+		u, err := url.Parse(req.Target)
+		require.NoError(t, err)
+		paths = append(paths, u.Path)
+	}
+	// the health check goes through the proxy too
+	require.Contains(t, paths, "/api/tags")
+	require.Contains(t, paths, "/api/chat")
+
+	_, err = ollama.Ollama(context.Background(), ollama.WithProxy("ftp://proxy.internal"))
+	require.ErrorIs(t, err, llm.ErrInvalidProxy)
+}
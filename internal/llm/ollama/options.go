@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ollama/ollama/api"
 )
 
 var (
@@ -41,6 +45,128 @@ func WithHTTPClient(c *http.Client) Option {
 	}
 }
 
+// WithTransport sends the requests of the client, the health check
+// included, with rt. The http.Client of WithHTTPClient is copied, not
+// modified.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(b *builder) error {
+		if rt == nil {
+			return fmt.Errorf("transport should not be nil")
+		}
+		b.Transport = rt
+		return nil
+	}
+}
+
+// WithProxy sends the requests of the client through the proxy of rawURL, see
+// llm.ProxyTransport. It replaces the transport of WithTransport.
+func WithProxy(rawURL string) Option {
+	return func(b *builder) error {
+		t, err := llm.ProxyTransport(rawURL)
+		if err != nil {
+			return err
+		}
+		b.Transport = t
+		return nil
+	}
+}
+
+// WithHeader sets a header on every request of the client, e.g. for a server
+// behind an authenticating reverse proxy. The http.Client given by
+// WithHTTPClient, or http.DefaultClient, is wrapped and not modified.
+func WithHeader(key, value string) Option {
+	return func(b *builder) error {
+		if key == "" {
+			return fmt.Errorf("header key should not be empty")
+		}
+		b.Header.Set(key, value)
+		return nil
+	}
+}
+
+// WithBearerToken authenticates every request of the client with the token,
+// in the Authorization header.
+func WithBearerToken(token string) Option {
+	return func(b *builder) error {
+		if token == "" {
+			return fmt.Errorf("bearer token should not be empty")
+		}
+		return WithHeader("Authorization", "Bearer "+token)(b)
+	}
+}
+
+// WithRetryPolicy sets the policy the chat and embedding requests are retried
+// with, llm.DefaultRetryPolicy by default. The errors are classified by
+// IsTransient if its RetryOn is nil.
+func WithRetryPolicy(p llm.RetryPolicy) Option {
+	return func(b *builder) error {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("invalid retry policy: %w", err)
+		}
+		b.RetryPolicy = &p
+		return nil
+	}
+}
+
+// WithRateLimit limits the generate and embed requests of the client to rps
+// per second, with bursts of up to burst requests. The limit is shared by the
+// goroutines using the client.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(b *builder) error {
+		l, err := llm.NewRateLimiter(rps, burst)
+		if err != nil {
+			return fmt.Errorf("invalid rate limit: %w", err)
+		}
+		b.Limiter = l
+		return nil
+	}
+}
+
+// WithEmbedParallelism sets the number of embed requests the client makes at
+// a time, DefaultEmbedParallelism by default.
+func WithEmbedParallelism(n int) Option {
+	return func(b *builder) error {
+		if n < 1 {
+			return fmt.Errorf("embed parallelism should be at least 1, got %d", n)
+		}
+		b.Parallelism = n
+		return nil
+	}
+}
+
+// WithKeepAlive sets how long the server keeps the models of the client loaded
+// after a request, as the keep_alive of every chat and embed request. A
+// negative d keeps them loaded until the server stops, 0 unloads them right
+// after the request. The server default, 5 minutes, is used if it is not set.
+func WithKeepAlive(d time.Duration) Option {
+	return func(b *builder) error {
+		b.KeepAlive = &api.Duration{Duration: d}
+		return nil
+	}
+}
+
+// WithAutoPull sets whether the models missing on the server are pulled
+// from the registry when the client is created, instead of failing with
+// ErrModelNotFount. The progress of the pull is logged.
+func WithAutoPull(pull bool) Option {
+	return func(b *builder) error {
+		b.AutoPull = pull
+		return nil
+	}
+}
+
+// WithTokenizer sets the tokenizer the input of a generate request is counted
+// with before it is sent, llm.ApproxTokenizer by default.
+func WithTokenizer(tok llm.Tokenizer) Option {
+	return func(b *builder) error {
+		if tok == nil {
+			return fmt.Errorf("tokenizer should not be nil")
+		}
+		b.Tokenizer = tok
+		return nil
+	}
+}
+
 // WithModel registers one or more Ollama models with the client.
 func WithModel(models ...OllamaModel) Option {
 	return func(b *builder) error {
@@ -0,0 +1,126 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+)
+
+// azureDeploymentRoutes are the routes Azure serves under the path of a
+// deployment, /openai/deployments/{deployment}/chat/completions. The other
+// routes, e.g. files and batches, are served under /openai.
+var azureDeploymentRoutes = map[string]bool{
+	"chat/completions": true,
+	"completions":      true,
+	"embeddings":       true,
+}
+
+// azureConfig is the Azure OpenAI resource a client connects to.
+type azureConfig struct {
+	// BaseURL is the endpoint of the resource with the /openai/ path.
+	BaseURL    *url.URL
+	APIVersion string
+	Deployment string
+}
+
+// WithAzure connects the client to the Azure OpenAI resource of endpoint, e.g.
+// https://my-resource.openai.azure.com, with the API version apiVersion, e.g.
+// 2024-10-21. The API key is sent in the api-key header instead of a bearer
+// token.
+//
+// Azure addresses a model by the name of its deployment: the chat completions
+// and embeddings are sent to /openai/deployments/{model}/..., the model being
+// the one of the request. deployment is the default generation model, so a
+// client without WithModel generates with it. The health check generates a
+// single token with it, since /models lists the base models of Azure rather
+// than its deployments. WithBaseURL is ignored.
+func WithAzure(endpoint, apiVersion, deployment string) Option {
+	return func(b *builder) error {
+		if apiVersion == "" {
+			return errors.New("azure api version should not be empty")
+		}
+		if deployment == "" {
+			return errors.New("azure deployment should not be empty")
+		}
+
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid azure endpoint: %w", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid azure endpoint: %q, expected an absolute url", endpoint)
+		}
+
+		b.Azure = &azureConfig{
+			BaseURL:    u.JoinPath("openai", "/"),
+			APIVersion: apiVersion,
+			Deployment: deployment,
+		}
+		return nil
+	}
+}
+
+// options returns the request options of the resource, authenticated by key.
+// The bearer token the SDK reads from OPENAI_API_KEY is removed.
+func (az *azureConfig) options(key string) []option.RequestOption {
+	return []option.RequestOption{
+		option.WithBaseURL(az.BaseURL.String()),
+		option.WithQueryAdd("api-version", az.APIVersion),
+		option.WithHeaderDel("authorization"),
+		option.WithHeader("api-key", key),
+		option.WithMiddleware(az.deploymentMiddleware),
+	}
+}
+
+// deploymentMiddleware moves the requests of the deployment routes to the
+// path of the deployment named by the model of their body, the deployment of
+// the client if the body has none.
+func (az *azureConfig) deploymentMiddleware(r *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	route, ok := strings.CutPrefix(r.URL.Path, az.BaseURL.Path)
+	if !ok || !azureDeploymentRoutes[route] {
+		return next(r)
+	}
+
+	deployment := az.Deployment
+	if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the body of %s: %w", route, err)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var v struct {
+			Model string `json:"model"`
+		}
+		if err := json.Unmarshal(body, &v); err == nil && v.Model != "" {
+			deployment = v.Model
+		}
+	}
+
+	r.URL.Path = az.BaseURL.JoinPath("deployments", deployment, route).Path
+	r.URL.RawPath = ""
+	return next(r)
+}
+
+// ping generates a single token with the deployment, the cheapest request
+// proving that the resource is reachable and the key and the deployment are
+// valid.
+func (az *azureConfig) ping(cli openai.Client) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := cli.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+			Model:               az.Deployment,
+			Messages:            []openai.ChatCompletionMessageParamUnion{openai.UserMessage("hi")},
+			MaxCompletionTokens: openai.Int(1),
+		})
+		return err
+	}
+}
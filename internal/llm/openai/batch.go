@@ -0,0 +1,239 @@
+package openai
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/responses"
+)
+
+// The prefixes of the custom IDs of the requests of BatchCreate, followed by
+// the creation time, the name of the job and the index of the request:
+// gen-1755706275-my-job-07.
+const (
+	batchGeneratePrefix = "gen-"
+	batchEmbedPrefix    = "embed-"
+)
+
+var ErrInvalidCustomID = errors.New("invalid custom id")
+
+// batchOutputLine is a line of the output or the error file of a batch job.
+type batchOutputLine struct {
+	ID       string `json:"id"`
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		RequestID  string          `json:"request_id"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *batchOutputError `json:"error"`
+}
+
+type batchOutputError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ParseBatchOutput decodes the lines of the output or the error file of a
+// batch job created by BatchCreate, and matches them to the requests of the
+// job by their custom IDs. The results are ordered by the index of their
+// request, a request which failed has the *llm.BatchItemError of its failure.
+// Empty lines are skipped.
+func ParseBatchOutput(lines [][]byte) ([]llm.BatchItemResult, error) {
+	results := make([]llm.BatchItemResult, 0, len(lines))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var out batchOutputLine
+		if err := json.Unmarshal(line, &out); err != nil {
+			return nil, fmt.Errorf("failed to decode line %d of the batch output: %w", i, err)
+		}
+
+		result, err := parseBatchOutputLine(out)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i, err)
+		}
+		results = append(results, result)
+	}
+
+	slices.SortFunc(results, func(a, b llm.BatchItemResult) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+	return results, nil
+}
+
+func parseBatchOutputLine(out batchOutputLine) (llm.BatchItemResult, error) {
+	prefix, index, err := parseCustomID(out.CustomID)
+	if err != nil {
+		return llm.BatchItemResult{}, err
+	}
+
+	result := llm.BatchItemResult{Index: index, CustomID: out.CustomID}
+	itemErr := &llm.BatchItemError{CustomID: out.CustomID}
+	switch {
+	case out.Error != nil:
+		itemErr.Code, itemErr.Message = out.Error.Code, out.Error.Message
+		if out.Response != nil {
+			itemErr.StatusCode = out.Response.StatusCode
+		}
+		result.Err = itemErr
+		return result, nil
+	case out.Response == nil:
+		itemErr.Message = "no response"
+		result.Err = itemErr
+		return result, nil
+	case out.Response.StatusCode != http.StatusOK:
+		var body struct {
+			Error batchOutputError `json:"error"`
+		}
+		// the body of an error is kept as the message if it is not an API error
+		if err := json.Unmarshal(out.Response.Body, &body); err != nil || body.Error.Message == "" {
+			body.Error.Message = string(out.Response.Body)
+		}
+		itemErr.StatusCode = out.Response.StatusCode
+		itemErr.Code, itemErr.Message = body.Error.Code, body.Error.Message
+		result.Err = itemErr
+		return result, nil
+	}
+
+	switch prefix {
+	case batchEmbedPrefix:
+		var resp openai.CreateEmbeddingResponse
+		if err := json.Unmarshal(out.Response.Body, &resp); err != nil {
+			return result, fmt.Errorf("failed to decode the embeddings of %s: %w", out.CustomID, err)
+		}
+		result.Embed = toEmbedResponse(resp.Model, &resp)
+	case batchGeneratePrefix:
+		var resp responses.Response
+		if err := json.Unmarshal(out.Response.Body, &resp); err != nil {
+			return result, fmt.Errorf("failed to decode the response of %s: %w", out.CustomID, err)
+		}
+		result.Generate = &llm.GenerateResponse{
+			Outputs:   []string{resp.OutputText()},
+			ToolCalls: responseToolCalls(&resp),
+			Usage: llm.Usage{
+				PromptTokens:     int(resp.Usage.InputTokens),
+				CompletionTokens: int(resp.Usage.OutputTokens),
+				TotalTokens:      int(resp.Usage.TotalTokens),
+			},
+			Raw: &resp,
+		}
+	}
+	return result, nil
+}
+
+// parseCustomID returns the prefix and the index of the request of a custom ID
+// of BatchCreate. The name of the job may contain dashes, the index is the
+// last field.
+func parseCustomID(id string) (string, int, error) {
+	var prefix string
+	switch {
+	case strings.HasPrefix(id, batchGeneratePrefix):
+		prefix = batchGeneratePrefix
+	case strings.HasPrefix(id, batchEmbedPrefix):
+		prefix = batchEmbedPrefix
+	default:
+		return "", 0, fmt.Errorf("%w: %q, expected a %q or %q prefix",
+			ErrInvalidCustomID, id, batchGeneratePrefix, batchEmbedPrefix)
+	}
+
+	i := strings.LastIndexByte(id, '-')
+	index, err := strconv.Atoi(id[i+1:])
+	if i < len(prefix) || err != nil || index < 0 {
+		return "", 0, fmt.Errorf("%w: %q, expected an index after the last dash", ErrInvalidCustomID, id)
+	}
+	return prefix, index, nil
+}
+
+// BatchFileCleanup selects the files of a batch job BatchRetrieve deletes once
+// the job is done and its output has been read.
+type BatchFileCleanup int
+
+const (
+	// CleanupNone keeps the files of the batch jobs.
+	CleanupNone BatchFileCleanup = iota
+	// CleanupInput deletes the input file.
+	CleanupInput
+	// CleanupAll deletes the input, output and error files.
+	CleanupAll
+)
+
+// cleanupBatchFiles deletes the files of batch selected by BatchCleanup. The
+// failures are logged, the output has been read already.
+func (cli *Client) cleanupBatchFiles(ctx context.Context, batch *openai.Batch) {
+	if cli.BatchCleanup == CleanupNone {
+		return
+	}
+
+	ids := []string{batch.InputFileID}
+	if cli.BatchCleanup == CleanupAll {
+		ids = append(ids, batch.OutputFileID, batch.ErrorFileID)
+	}
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if _, err := cli.OpenAI.Files.Delete(ctx, id); err != nil {
+			global.Logger.Warn().
+				Err(err).
+				Str("batch_id", batch.ID).
+				Str("file_id", id).
+				Msg("failed to delete batch file")
+		}
+	}
+}
+
+// PurgeBatchFiles deletes the batch input and output files created more than
+// olderThan ago, except the inputs of the jobs still running, and returns the
+// number of files deleted. The failures to delete a file are logged, those to
+// list the files or the jobs are returned.
+func (cli *Client) PurgeBatchFiles(ctx context.Context, olderThan time.Duration) (int, error) {
+	running := map[string]bool{}
+	batches := cli.OpenAI.Batches.ListAutoPaging(ctx, openai.BatchListParams{})
+	for batches.Next() {
+		if batch := batches.Current(); !IsTerminalJobState(batch.Status) {
+			running[batch.InputFileID] = true
+		}
+	}
+	if err := batches.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list batches: %w", err)
+	}
+
+	cutoff := time.Now().Add(-olderThan).Unix()
+	deleted := 0
+	for _, purpose := range []openai.FileObjectPurpose{openai.FileObjectPurposeBatch, openai.FileObjectPurposeBatchOutput} {
+		files := cli.OpenAI.Files.ListAutoPaging(ctx, openai.FileListParams{Purpose: openai.String(string(purpose))})
+		for files.Next() {
+			file := files.Current()
+			if file.CreatedAt >= cutoff || running[file.ID] {
+				continue
+			}
+			if _, err := cli.OpenAI.Files.Delete(ctx, file.ID); err != nil {
+				global.Logger.Warn().
+					Err(err).
+					Str("file_id", file.ID).
+					Msg("failed to delete batch file")
+				continue
+			}
+			deleted++
+		}
+		if err := files.Err(); err != nil {
+			return deleted, fmt.Errorf("failed to list %s files: %w", purpose, err)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,190 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/openai/openai-go/v2"
+)
+
+// ServerFlavor identifies the implementation behind an OpenAI-compatible endpoint.
+type ServerFlavor string
+
+const (
+	FlavorOpenAI   ServerFlavor = "openai"
+	FlavorAzure    ServerFlavor = "azure"
+	FlavorOllama   ServerFlavor = "ollama"
+	FlavorLlamaCpp ServerFlavor = "llama.cpp"
+	FlavorVLLM     ServerFlavor = "vllm"
+	FlavorUnknown  ServerFlavor = "unknown"
+)
+
+// ServerCaps describes which optional parts of the OpenAI API a server supports.
+// Generate, Embed and BatchCreate consult it to fall back gracefully instead of
+// failing with opaque 404/400 responses.
+type ServerCaps struct {
+	Flavor          ServerFlavor `json:"flavor"`
+	ResponsesAPI    bool         `json:"responses_api"`
+	JSONSchema      bool         `json:"json_schema"`
+	Batches         bool         `json:"batches"`
+	EmbedDimensions bool         `json:"embed_dimensions"`
+	// MaxEmbedInputs is the maximum number of inputs per embedding request, 0 means unknown.
+	MaxEmbedInputs int `json:"max_embed_inputs"`
+}
+
+// DefaultServerCaps returns the capabilities assumed for a given server flavor
+// before any endpoint probing takes place.
+func DefaultServerCaps(flavor ServerFlavor) ServerCaps {
+	switch flavor {
+	case FlavorOpenAI:
+		return ServerCaps{
+			Flavor:          flavor,
+			ResponsesAPI:    true,
+			JSONSchema:      true,
+			Batches:         true,
+			EmbedDimensions: true,
+			MaxEmbedInputs:  2048,
+		}
+	case FlavorAzure:
+		// the responses API of Azure takes a preview API version, the chat
+		// completions are used instead
+		return ServerCaps{
+			Flavor:          flavor,
+			JSONSchema:      true,
+			Batches:         true,
+			EmbedDimensions: true,
+			MaxEmbedInputs:  2048,
+		}
+	case FlavorOllama, FlavorLlamaCpp, FlavorVLLM:
+		return ServerCaps{
+			Flavor:     flavor,
+			JSONSchema: true,
+		}
+	default:
+		return ServerCaps{Flavor: FlavorUnknown}
+	}
+}
+
+// modelList is the raw payload of the /models endpoint. Only the fields used to
+// tell server implementations apart are decoded.
+type modelList struct {
+	Object string `json:"object"`
+	Data   []struct {
+		ID         string          `json:"id"`
+		OwnedBy    string          `json:"owned_by"`
+		Meta       json.RawMessage `json:"meta,omitempty"`
+		MaxLen     *int64          `json:"max_model_len,omitempty"`
+		Root       string          `json:"root,omitempty"`
+		Permission json.RawMessage `json:"permission,omitempty"`
+	} `json:"data"`
+	// llama.cpp also returns a top level "models" array in the Ollama format.
+	Models json.RawMessage `json:"models,omitempty"`
+}
+
+// detectFlavor guesses the server implementation from the shape of the /models payload.
+func detectFlavor(data []byte) ServerFlavor {
+	var list modelList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return FlavorUnknown
+	}
+
+	if len(list.Models) > 0 {
+		return FlavorLlamaCpp
+	}
+
+	for _, m := range list.Data {
+		switch {
+		case m.OwnedBy == "llamacpp" || len(m.Meta) > 0:
+			return FlavorLlamaCpp
+		case m.OwnedBy == "vllm" || m.MaxLen != nil:
+			return FlavorVLLM
+		case m.OwnedBy == "library" || m.OwnedBy == "ollama":
+			return FlavorOllama
+		case m.OwnedBy == "openai" || m.OwnedBy == "system" ||
+			m.OwnedBy == "openai-internal" || m.OwnedBy == "openai-dev":
+			return FlavorOpenAI
+		}
+	}
+	return FlavorUnknown
+}
+
+// flavorOfBaseURL guesses the server implementation from the base URL the
+// client sends its requests to, nil meaning the default of the SDK:
+// OPENAI_BASE_URL if set, the OpenAI API otherwise.
+func flavorOfBaseURL(u *url.URL) ServerFlavor {
+	if u == nil {
+		raw, ok := os.LookupEnv("OPENAI_BASE_URL")
+		if !ok {
+			return FlavorOpenAI
+		}
+
+		var err error
+		if u, err = url.Parse(raw); err != nil {
+			return FlavorUnknown
+		}
+	}
+
+	if strings.EqualFold(u.Hostname(), "api.openai.com") {
+		return FlavorOpenAI
+	}
+	return FlavorUnknown
+}
+
+// probeCaps detects the server flavor and probes the optional endpoints with
+// lightweight requests that never trigger a completion. The /models request is
+// retried with policy, and the flavor falls back to the one of baseURL when the
+// server cannot be reached or its /models payload tells nothing.
+func probeCaps(ctx context.Context, cli openai.Client, policy llm.RetryPolicy, baseURL *url.URL) ServerCaps {
+	var raw []byte
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		raw = nil
+		return cli.Get(ctx, "models", nil, &raw, retryOpts(nil)...)
+	})
+
+	flavor := FlavorUnknown
+	if err == nil {
+		flavor = detectFlavor(raw)
+	}
+	if flavor == FlavorUnknown {
+		flavor = flavorOfBaseURL(baseURL)
+	}
+
+	caps := DefaultServerCaps(flavor)
+	if err != nil {
+		return caps
+	}
+
+	// an empty body is rejected with 400 by servers that implement the
+	// endpoint, while servers that do not return 404 (or 405).
+	caps.ResponsesAPI = endpointExists(cli.Post(ctx, "responses", map[string]any{}, nil))
+	caps.Batches = endpointExists(cli.Get(ctx, "batches", openai.BatchListParams{Limit: openai.Int(1)}, nil))
+	return caps
+}
+
+// endpointExists reports whether the error returned by a probe request
+// indicates the endpoint is implemented by the server.
+func endpointExists(err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var e *openai.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	switch e.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return false
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,54 @@
+package openai
+
+import (
+	"context"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+)
+
+func init() {
+	llm.RegisterProvider("openai", func(ctx context.Context, cfg global.LLMConfig) (llm.LLM, error) {
+		return NewFromConfig(ctx, cfg.OpenAI)
+	})
+}
+
+// NewFromConfig creates a client from its configuration. The embedding model
+// is DefaultEmbedModel if the configuration has none.
+func NewFromConfig(ctx context.Context, cfg global.OpenAIConfig) (*Client, error) {
+	key, err := cfg.ReadAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	embedModel := utils.DefaultIfZero(cfg.EmbedModel, DefaultEmbedModel)
+	opts := []Option{
+		WithAPIKey(key),
+		WithModel(
+			NewOpenAIModel(llm.ModelGenerate, cfg.Model),
+			NewOpenAIModel(llm.ModelEmbed, embedModel),
+		),
+		WithDefaultGenerate(cfg.Model),
+		WithDefaultEmbed(embedModel),
+	}
+	if cfg.BaseURL != "" {
+		opts = append(opts, WithBaseURL(cfg.BaseURL))
+	}
+	if cfg.Proxy != "" {
+		opts = append(opts, WithProxy(cfg.Proxy))
+	}
+	if cfg.Timeout > 0 {
+		opts = append(opts, WithTimeout(cfg.Timeout))
+	}
+	if cfg.EmbedDim > 0 {
+		opts = append(opts, WithEmbedDim(cfg.EmbedDim))
+	}
+	if cfg.Organization != "" {
+		opts = append(opts, WithOrganization(cfg.Organization))
+	}
+	if cfg.Project != "" {
+		opts = append(opts, WithProject(cfg.Project))
+	}
+	return OpenAI(ctx, opts...)
+}
@@ -9,15 +9,21 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ChiaYuChang/weathercock/internal/global"
 	"github.com/ChiaYuChang/weathercock/internal/llm"
 	"github.com/ChiaYuChang/weathercock/pkgs/utils"
 	"github.com/openai/openai-go/v2"
 	"github.com/openai/openai-go/v2/option"
 	"github.com/openai/openai-go/v2/responses"
 	"github.com/openai/openai-go/v2/shared"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -25,9 +31,16 @@ const (
 	DefaultEmbedModel = openai.EmbeddingModelTextEmbedding3Small
 )
 
-var (
-	MaxRetries          = 4
-	MaxRetryWaitingTime = 10 * time.Second
+const (
+	// DefaultEmbedBatchInputs is the maximum number of inputs of an embedding
+	// request, for a server whose limit is unknown.
+	DefaultEmbedBatchInputs = 2048
+	// DefaultEmbedBatchTokens is the maximum number of tokens of the inputs of
+	// an embedding request (OpenAI: 300,000).
+	DefaultEmbedBatchTokens = 300_000
+	// DefaultEmbedParallelism is the number of embedding requests a client
+	// makes at a time, unless set by WithEmbedBatch.
+	DefaultEmbedParallelism = 1
 )
 
 var (
@@ -42,6 +55,27 @@ type Client struct {
 	OpenAI          openai.Client
 	EmbedDim        int64
 	UseChatComplete bool
+	Caps            ServerCaps
+	// EmbedBatchInputs and EmbedBatchTokens are the maximum number of inputs
+	// and of tokens of an embedding request, the inputs of Embed are split
+	// into batches within both.
+	EmbedBatchInputs int
+	EmbedBatchTokens int
+	// EmbedParallelism is the number of embedding requests made at a time.
+	EmbedParallelism int
+	// Tokenizer counts the tokens of the inputs of Embed.
+	Tokenizer llm.Tokenizer
+	// BatchCleanup selects the files deleted by BatchRetrieve once a batch job
+	// is done.
+	BatchCleanup BatchFileCleanup
+
+	// Logprobs requests the log probabilities of the output tokens of the chat
+	// completions, with TopLogprobs alternatives each.
+	Logprobs    bool
+	TopLogprobs int
+	// jsonSchemaRejected is set once the server rejected a json_schema
+	// response format, the chat completions fall back to json_object.
+	jsonSchemaRejected atomic.Bool
 }
 
 // builder is used to construct an OpenAI Client using the functional options pattern.
@@ -58,6 +92,22 @@ type builder struct {
 	EmbedDim        int64
 	DefaultGen      string
 	DefaultEmbed    string
+	Caps            *ServerCaps
+	Azure           *azureConfig
+	RetryPolicy     *llm.RetryPolicy
+	Limiter         *rate.Limiter
+	EmbedInputs     int
+	EmbedTokens     int
+	Parallelism     int
+	Tokenizer       llm.Tokenizer
+	RetryAfter      time.Duration
+	NoHealthCheck   bool
+	BatchCleanup    BatchFileCleanup
+	Organization    string
+	Project         string
+	Logprobs        bool
+	TopLogprobs     int
+	Transport       http.RoundTripper
 }
 
 type OpenAIModel struct {
@@ -80,6 +130,30 @@ func WithAPIKey(key string) Option {
 	}
 }
 
+// WithOrganization sets the OpenAI-Organization header of the requests, the
+// organization the usage is billed to.
+func WithOrganization(org string) Option {
+	return func(b *builder) error {
+		if org == "" {
+			return errors.New("organization should not be empty")
+		}
+		b.Organization = org
+		return nil
+	}
+}
+
+// WithProject sets the OpenAI-Project header of the requests, the project
+// of the organization the usage is allocated to.
+func WithProject(project string) Option {
+	return func(b *builder) error {
+		if project == "" {
+			return errors.New("project should not be empty")
+		}
+		b.Project = project
+		return nil
+	}
+}
+
 func WithTimeout(timeout time.Duration) Option {
 	return func(b *builder) error {
 		b.Timeout = timeout
@@ -87,6 +161,8 @@ func WithTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithMaxRetries sets the retries of the requests made by the OpenAI SDK, the
+// generate and embed requests are retried by the retry policy instead.
 func WithMaxRetries(retries int) Option {
 	return func(b *builder) error {
 		if retries <= 0 {
@@ -97,6 +173,83 @@ func WithMaxRetries(retries int) Option {
 	}
 }
 
+// WithRetryPolicy sets the policy the generate and embed requests are retried
+// with, llm.DefaultRetryPolicy by default. The errors are classified by
+// IsTransient if its RetryOn is nil.
+func WithRetryPolicy(p llm.RetryPolicy) Option {
+	return func(b *builder) error {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("invalid retry policy: %w", err)
+		}
+		b.RetryPolicy = &p
+		return nil
+	}
+}
+
+// WithRetryAfter makes the retries of the generate and embed requests wait
+// the delay asked for by the Retry-After-Ms or Retry-After header of a rate
+// limited or failing response, up to maxWait, when it is longer than the delay
+// of the retry policy.
+func WithRetryAfter(maxWait time.Duration) Option {
+	return func(b *builder) error {
+		if maxWait <= 0 {
+			return fmt.Errorf("max retry after should be positive, got %s", maxWait)
+		}
+		b.RetryAfter = maxWait
+		return nil
+	}
+}
+
+// WithoutHealthCheck skips the health check of OpenAI, for the proxies which
+// do not permit listing the models. The capabilities of the server are still
+// probed unless set by WithServerCaps.
+func WithoutHealthCheck() Option {
+	return func(b *builder) error {
+		b.NoHealthCheck = true
+		return nil
+	}
+}
+
+// WithBatchFileCleanup makes BatchRetrieve delete the files of a batch job
+// selected by cleanup, after reading the output of the job.
+func WithBatchFileCleanup(cleanup BatchFileCleanup) Option {
+	return func(b *builder) error {
+		if cleanup < CleanupNone || cleanup > CleanupAll {
+			return fmt.Errorf("invalid batch file cleanup: %d", cleanup)
+		}
+		b.BatchCleanup = cleanup
+		return nil
+	}
+}
+
+// WithLogprobs requests the log probabilities of the output tokens of the chat
+// completions, along with the topK most likely tokens at each position, up to
+// 20. They are not requested from the responses API.
+func WithLogprobs(topK int) Option {
+	return func(b *builder) error {
+		if topK < 0 || topK > 20 {
+			return fmt.Errorf("top logprobs should be between 0 and 20, got %d", topK)
+		}
+		b.Logprobs = true
+		b.TopLogprobs = topK
+		return nil
+	}
+}
+
+// WithRateLimit limits the generate and embed requests of the client to rps
+// per second, with bursts of up to burst requests. The limit is shared by the
+// goroutines using the client.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(b *builder) error {
+		l, err := llm.NewRateLimiter(rps, burst)
+		if err != nil {
+			return fmt.Errorf("invalid rate limit: %w", err)
+		}
+		b.Limiter = l
+		return nil
+	}
+}
+
 // WithHTTPClient sets a custom http.Client.
 func WithHTTPClient(c *http.Client) Option {
 	return func(b *builder) error {
@@ -105,6 +258,32 @@ func WithHTTPClient(c *http.Client) Option {
 	}
 }
 
+// WithTransport sends the requests of the client, the health check
+// included, with rt. The http.Client of WithHTTPClient is copied, not
+// modified.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(b *builder) error {
+		if rt == nil {
+			return errors.New("transport should not be nil")
+		}
+		b.Transport = rt
+		return nil
+	}
+}
+
+// WithProxy sends the requests of the client through the proxy of rawURL, see
+// llm.ProxyTransport. It replaces the transport of WithTransport.
+func WithProxy(rawURL string) Option {
+	return func(b *builder) error {
+		t, err := llm.ProxyTransport(rawURL)
+		if err != nil {
+			return err
+		}
+		b.Transport = t
+		return nil
+	}
+}
+
 // WithModel registers one or more models with the client.
 func WithModel(models ...OpenAIModel) Option {
 	return func(b *builder) error {
@@ -151,6 +330,37 @@ func WithEmbedDim(dim int) Option {
 	}
 }
 
+// WithEmbedBatch splits the inputs of Embed into requests of up to maxInputs
+// inputs and maxTokens tokens, parallelism requests at a time. A zero
+// maxInputs is the MaxEmbedInputs of the server, DefaultEmbedBatchInputs if
+// unknown, a zero maxTokens is DefaultEmbedBatchTokens. An input over
+// maxTokens is sent alone.
+func WithEmbedBatch(maxInputs, maxTokens, parallelism int) Option {
+	return func(b *builder) error {
+		if maxInputs < 0 || maxTokens < 0 {
+			return fmt.Errorf("embed batch limits should not be negative, got %d inputs and %d tokens",
+				maxInputs, maxTokens)
+		}
+		if parallelism < 1 {
+			return fmt.Errorf("embed parallelism should be at least 1, got %d", parallelism)
+		}
+		b.EmbedInputs, b.EmbedTokens, b.Parallelism = maxInputs, maxTokens, parallelism
+		return nil
+	}
+}
+
+// WithTokenizer sets the tokenizer the inputs of Embed are counted with, to
+// split them into batches, llm.ApproxTokenizer by default.
+func WithTokenizer(tok llm.Tokenizer) Option {
+	return func(b *builder) error {
+		if tok == nil {
+			return fmt.Errorf("tokenizer should not be nil")
+		}
+		b.Tokenizer = tok
+		return nil
+	}
+}
+
 func WithBaseURL(u string) Option {
 	return func(b *builder) error {
 		u, err := url.Parse(u)
@@ -162,6 +372,18 @@ func WithBaseURL(u string) Option {
 	}
 }
 
+// WithServerCaps overrides the capabilities detected by probing the server,
+// for servers that misreport what they support. Probing is skipped when set.
+func WithServerCaps(caps ServerCaps) Option {
+	return func(b *builder) error {
+		if caps.MaxEmbedInputs < 0 {
+			return fmt.Errorf("max embed inputs should not be negative: %d", caps.MaxEmbedInputs)
+		}
+		b.Caps = &caps
+		return nil
+	}
+}
+
 // OpenAI creates a new OpenAI client.
 func OpenAI(ctx context.Context, opts ...Option) (*Client, error) {
 	b := &builder{Models: make(map[string]llm.Model)}
@@ -179,15 +401,28 @@ func OpenAI(ctx context.Context, opts ...Option) (*Client, error) {
 	if b.APIKey == "" {
 		return nil, ErrAPIKeyMissing
 	}
-	openAICliOptions = append(openAICliOptions, option.WithAPIKey(b.APIKey))
+	if b.Azure != nil {
+		openAICliOptions = append(openAICliOptions, b.Azure.options(b.APIKey)...)
+	} else {
+		openAICliOptions = append(openAICliOptions, option.WithAPIKey(b.APIKey))
+	}
+	if b.Organization != "" {
+		openAICliOptions = append(openAICliOptions, option.WithOrganization(b.Organization))
+	}
+	if b.Project != "" {
+		openAICliOptions = append(openAICliOptions, option.WithProject(b.Project))
+	}
 	if b.Timeout > 0 {
 		openAICliOptions = append(openAICliOptions, option.WithRequestTimeout(b.Timeout))
 	}
 
-	if b.BaseURL != nil {
+	if b.BaseURL != nil && b.Azure == nil {
 		openAICliOptions = append(openAICliOptions, option.WithBaseURL(b.BaseURL.String()))
 	}
 
+	if b.Transport != nil {
+		b.HTTPClient = llm.HTTPClientWithTransport(b.HTTPClient, b.Transport)
+	}
 	if b.HTTPClient != nil {
 		openAICliOptions = append(openAICliOptions, option.WithHTTPClient(b.HTTPClient))
 	}
@@ -207,24 +442,62 @@ func OpenAI(ctx context.Context, opts ...Option) (*Client, error) {
 	}
 	cli := openai.NewClient(openAICliOptions...)
 
-	if err := healthCheck(ctx, cli); err != nil {
-		return nil, err
+	policy := llm.DefaultRetryPolicy()
+	if b.RetryPolicy != nil {
+		policy = *b.RetryPolicy
+	}
+	if policy.RetryOn == nil {
+		policy.RetryOn = IsTransient
+	}
+	if b.RetryAfter > 0 && policy.RetryAfter == nil {
+		policy.RetryAfter = retryAfter(b.RetryAfter)
+	}
+
+	ping := func(ctx context.Context) error {
+		_, err := cli.Models.List(ctx)
+		return err
+	}
+	defaultGen := DefaultGenModel
+	if b.Azure != nil {
+		ping = b.Azure.ping(cli)
+		defaultGen = b.Azure.Deployment
+	}
+
+	if !b.NoHealthCheck {
+		if err := healthCheck(ctx, policy, ping); err != nil {
+			return nil, err
+		}
+	}
+
+	var caps ServerCaps
+	switch {
+	case b.Caps != nil:
+		caps = *b.Caps
+	case b.Azure != nil:
+		caps = DefaultServerCaps(FlavorAzure)
+	default:
+		caps = probeCaps(ctx, cli, policy, b.BaseURL)
 	}
 
 	// Add default models if none were provided by the user.
 	if len(b.Models) == 0 {
-		b.Models[DefaultGenModel] = NewOpenAIModel(llm.ModelGenerate, DefaultGenModel)
+		b.Models[defaultGen] = NewOpenAIModel(llm.ModelGenerate, defaultGen)
 		b.Models[DefaultEmbedModel] = NewOpenAIModel(llm.ModelEmbed, DefaultEmbedModel)
 	}
+	if _, ok := b.Models[defaultGen]; !ok && b.Azure != nil {
+		b.Models[defaultGen] = NewOpenAIModel(llm.ModelGenerate, defaultGen)
+	}
 
 	base := llm.NewClient()
+	base.RetryPolicy = policy
+	base.Limiter = b.Limiter
 	for _, model := range b.Models {
 		if err := base.WithModel(model); err != nil {
 			return nil, err
 		}
 	}
 
-	b.DefaultGen = utils.DefaultIfZero(b.DefaultGen, DefaultGenModel)
+	b.DefaultGen = utils.DefaultIfZero(b.DefaultGen, defaultGen)
 	if err := base.SetDefaultModel(llm.ModelGenerate, b.DefaultGen); err != nil {
 		return nil, err
 	}
@@ -234,11 +507,24 @@ func OpenAI(ctx context.Context, opts ...Option) (*Client, error) {
 		return nil, err
 	}
 
+	embedInputs := utils.DefaultIfZero(b.EmbedInputs, caps.MaxEmbedInputs)
+	var tokenizer llm.Tokenizer = llm.ApproxTokenizer{}
+	if b.Tokenizer != nil {
+		tokenizer = b.Tokenizer
+	}
 	return &Client{
-		BaseClient:      base,
-		OpenAI:          cli,
-		EmbedDim:        b.EmbedDim,
-		UseChatComplete: b.UseChatComplete,
+		BaseClient:       base,
+		OpenAI:           cli,
+		EmbedDim:         b.EmbedDim,
+		UseChatComplete:  b.UseChatComplete,
+		Caps:             caps,
+		EmbedBatchInputs: utils.DefaultIfZero(embedInputs, DefaultEmbedBatchInputs),
+		EmbedBatchTokens: utils.DefaultIfZero(b.EmbedTokens, DefaultEmbedBatchTokens),
+		EmbedParallelism: utils.DefaultIfZero(b.Parallelism, DefaultEmbedParallelism),
+		Tokenizer:        tokenizer,
+		BatchCleanup:     b.BatchCleanup,
+		Logprobs:         b.Logprobs,
+		TopLogprobs:      b.TopLogprobs,
 	}, nil
 }
 
@@ -251,7 +537,7 @@ func (cli *Client) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm
 		return nil, llm.ErrNoInput
 	}
 
-	if cli.UseChatComplete {
+	if cli.UseChatComplete || !cli.Caps.ResponsesAPI {
 		return cli.generateChatCompletions(ctx, req)
 	}
 	return cli.generateRequest(ctx, req)
@@ -273,12 +559,24 @@ func (cli *Client) generateRequest(ctx context.Context, req *llm.GenerateRequest
 		opts = v
 	}
 
+	input, err := toResponseInputParam(req.Messages)
+	if err != nil {
+		return nil, err
+	}
+
 	params := responses.ResponseNewParams{
 		Model: modelName,
 		Input: responses.ResponseNewParamsInputUnion{
-			OfInputItemList: toResponseInputParam(req.Messages),
+			OfInputItemList: input,
 		},
 	}
+	setResponseGenerationParams(&params, req)
+	if params.Tools, err = toResponseTools(req.Tools); err != nil {
+		return nil, err
+	}
+	if cli.Logprobs {
+		global.Logger.Warn().Msg("logprobs are only requested from the chat completions, dropping them")
+	}
 	if req.Schema != nil {
 		bs, err := json.Marshal(req.Schema.S)
 		if err != nil {
@@ -300,7 +598,12 @@ func (cli *Client) generateRequest(ctx context.Context, req *llm.GenerateRequest
 		}
 	}
 
-	resp, err := cli.OpenAI.Responses.New(ctx, params, opts...)
+	var resp *responses.Response
+	err = cli.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = cli.OpenAI.Responses.New(ctx, params, retryOpts(opts)...)
+		return err
+	})
 	if err != nil {
 		if e, ok := err.(*openai.Error); ok {
 			return nil, fmt.Errorf("code: %s (%d), type: %s, msg: %s",
@@ -309,13 +612,148 @@ func (cli *Client) generateRequest(ctx context.Context, req *llm.GenerateRequest
 		return nil, err
 	}
 
+	output := resp.OutputText()
+	toolCalls := responseToolCalls(resp)
+	var schemaErr error
+	if req.Schema != nil && (output != "" || len(toolCalls) == 0) {
+		output = llm.RepairOutput(output, req.Schema)
+		schemaErr = llm.ValidateOutput(output, req.Schema)
+	}
+
 	return &llm.GenerateResponse{
-		Outputs: []string{resp.OutputText()},
-		Raw:     resp,
+		Outputs:   []string{output},
+		ToolCalls: toolCalls,
+		Usage: llm.Usage{
+			PromptTokens:     int(resp.Usage.InputTokens),
+			CompletionTokens: int(resp.Usage.OutputTokens),
+			TotalTokens:      int(resp.Usage.TotalTokens),
+		},
+		Raw:          resp,
+		SchemaErr:    schemaErr,
+		FinishReason: responseFinishReason(resp, len(toolCalls) > 0),
 	}, nil
 }
 
+// responseFinishReason returns the finish reason of a response, from the
+// reason it is incomplete if it is.
+func responseFinishReason(resp *responses.Response, toolCalls bool) string {
+	switch {
+	case resp.Status == responses.ResponseStatusIncomplete:
+		if resp.IncompleteDetails.Reason == "max_output_tokens" {
+			return llm.FinishReasonLength
+		}
+		return resp.IncompleteDetails.Reason
+	case toolCalls:
+		return llm.FinishReasonToolCalls
+	case resp.Status == responses.ResponseStatusCompleted:
+		return llm.FinishReasonStop
+	}
+	return string(resp.Status)
+}
+
 func (cli *Client) generateChatCompletions(ctx context.Context, req *llm.GenerateRequest) (*llm.GenerateResponse, error) {
+	params, opts, err := cli.chatCompletionParams(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *openai.ChatCompletion
+	err = cli.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = cli.OpenAI.Chat.Completions.New(ctx, params, retryOpts(opts)...)
+		return err
+	})
+	if params.ResponseFormat.OfJSONSchema != nil && isSchemaRejected(err) &&
+		cli.jsonSchemaRejected.CompareAndSwap(false, true) {
+		global.Logger.Warn().
+			Err(err).
+			Str("flavor", string(cli.Caps.Flavor)).
+			Msg("server rejected json_schema, retrying with json_object")
+		return cli.generateChatCompletions(ctx, req)
+	}
+	if err != nil {
+		if e, ok := err.(*openai.Error); ok {
+			return nil, fmt.Errorf("code: %s (%d), type: %s, msg: %s",
+				e.Code, e.StatusCode, e.Type, e.Message)
+		}
+		return nil, err
+	}
+
+	output := resp.Choices[0].Message.Content
+	toolCalls := chatToolCalls(resp.Choices[0].Message.ToolCalls)
+	var schemaErr error
+	if req.Schema != nil && (output != "" || len(toolCalls) == 0) {
+		output = llm.RepairOutput(output, req.Schema)
+		schemaErr = llm.ValidateOutput(output, req.Schema)
+	}
+
+	return &llm.GenerateResponse{
+		Outputs:   []string{output},
+		ToolCalls: toolCalls,
+		Usage: llm.Usage{
+			PromptTokens:     int(resp.Usage.PromptTokens),
+			CompletionTokens: int(resp.Usage.CompletionTokens),
+			TotalTokens:      int(resp.Usage.TotalTokens),
+		},
+		Raw:          resp,
+		SchemaErr:    schemaErr,
+		FinishReason: resp.Choices[0].FinishReason,
+		Logprobs:     chatLogprobs(resp.Choices[0].Logprobs.Content),
+	}, nil
+}
+
+// chatLogprobs converts the log probabilities of the tokens of a chat
+// completion, nil if there are none.
+func chatLogprobs(tokens []openai.ChatCompletionTokenLogprob) []llm.TokenLogprob {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	logprobs := make([]llm.TokenLogprob, len(tokens))
+	for i, token := range tokens {
+		logprobs[i] = llm.TokenLogprob{Token: token.Token, Logprob: token.Logprob}
+		for _, top := range token.TopLogprobs {
+			logprobs[i].Alternatives = append(logprobs[i].Alternatives,
+				llm.TokenLogprob{Token: top.Token, Logprob: top.Logprob})
+		}
+	}
+	return logprobs
+}
+
+// schemaSystemPrompt is the system message telling the model the schema of
+// its output, for the servers which do not take a json_schema response format.
+const schemaSystemPrompt = "Reply with a JSON object only, without any explanation, " +
+	"matching the following JSON schema:\n%s"
+
+// schemaPrompt returns the system message telling the model schema.
+func schemaPrompt(schema *llm.ResponseSchema) (string, error) {
+	bs, err := json.Marshal(schema.S)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal schema %s: %w", schema.Name, err)
+	}
+	return fmt.Sprintf(schemaSystemPrompt, bs), nil
+}
+
+// isSchemaRejected reports whether err is a server rejecting the json_schema
+// response format of a chat completion.
+func isSchemaRejected(err error) bool {
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode != http.StatusBadRequest && apiErr.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+	if apiErr.Param == "response_format" {
+		return true
+	}
+	msg := strings.ToLower(apiErr.Message)
+	return strings.Contains(msg, "json_schema") || strings.Contains(msg, "response_format")
+}
+
+// chatCompletionParams converts req to the params of a chat completion and
+// the options of its request.
+func (cli *Client) chatCompletionParams(req *llm.GenerateRequest) (openai.ChatCompletionNewParams, []option.RequestOption, error) {
 	modelName := req.ModelName
 	if modelName == "" {
 		if m, ok := cli.DefaultModel(llm.ModelGenerate); ok {
@@ -327,7 +765,38 @@ func (cli *Client) generateChatCompletions(ctx context.Context, req *llm.Generat
 
 	messages := []openai.ChatCompletionMessageParamUnion{}
 	for _, msg := range req.Messages {
-		for _, content := range msg.Content {
+		if msg.HasImage() {
+			// only the user messages take images, in a single message
+			if msg.Role != llm.RoleUser {
+				return openai.ChatCompletionNewParams{}, nil, fmt.Errorf(
+					"%w: images are only supported in user messages, got a %s message",
+					llm.ErrInvalidPart, msg.Role)
+			}
+
+			parts, err := toChatContentParts(msg.ContentParts())
+			if err != nil {
+				return openai.ChatCompletionNewParams{}, nil, err
+			}
+			messages = append(messages, openai.UserMessage(parts))
+			continue
+		}
+
+		if msg.Role == llm.RoleTool {
+			if msg.ToolCallID == "" {
+				return openai.ChatCompletionNewParams{}, nil, fmt.Errorf(
+					"%w: a tool message should have the id of its call", llm.ErrInvalidTool)
+			}
+			messages = append(messages, openai.ToolMessage(toolResult(msg), msg.ToolCallID))
+			continue
+		}
+
+		if msg.Role == llm.RoleAssistant && len(msg.ToolCalls) > 0 {
+			messages = append(messages, toChatToolCallMessage(msg))
+			continue
+		}
+
+		for _, part := range msg.ContentParts() {
+			content := part.(llm.TextPart).Text
 			switch msg.Role {
 			case llm.RoleSystem:
 				messages = append(messages, openai.SystemMessage(content))
@@ -348,7 +817,28 @@ func (cli *Client) generateChatCompletions(ctx context.Context, req *llm.Generat
 		Messages: messages,
 		Model:    modelName,
 	}
-	if req.Schema != nil {
+	cli.setChatGenerationParams(&params, req)
+	tools, err := toChatTools(req.Tools)
+	if err != nil {
+		return openai.ChatCompletionNewParams{}, nil, err
+	}
+	params.Tools = tools
+	if req.Schema != nil && (!cli.Caps.JSONSchema || cli.jsonSchemaRejected.Load()) {
+		global.Logger.Warn().
+			Str("flavor", string(cli.Caps.Flavor)).
+			Str("schema", req.Schema.Name).
+			Msg("server does not support json_schema, falling back to json_object")
+		// the model is told the schema instead
+		prompt, err := schemaPrompt(req.Schema)
+		if err != nil {
+			return openai.ChatCompletionNewParams{}, nil, err
+		}
+		params.Messages = append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(prompt)},
+			params.Messages...)
+		params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONObject: &shared.ResponseFormatJSONObjectParam{},
+		}
+	} else if req.Schema != nil {
 		params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
 			OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
 				JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
@@ -360,23 +850,158 @@ func (cli *Client) generateChatCompletions(ctx context.Context, req *llm.Generat
 			},
 		}
 	}
-	resp, err := cli.OpenAI.Chat.Completions.New(ctx, params, opts...)
+	return params, opts, nil
+}
 
-	if err != nil {
-		if e, ok := err.(*openai.Error); ok {
-			return nil, fmt.Errorf("code: %s (%d), type: %s, msg: %s",
-				e.Code, e.StatusCode, e.Type, e.Message)
+// setResponseGenerationParams sets the generation parameters of req on the
+// params of a response. The responses API takes neither stop sequences nor a
+// seed, they are dropped. The options of req.Config are applied to the body
+// after them, so they override the parameters.
+func setResponseGenerationParams(params *responses.ResponseNewParams, req *llm.GenerateRequest) {
+	if req.User != "" {
+		params.User = openai.String(req.User)
+	}
+	if req.Temperature != nil {
+		params.Temperature = openai.Float(toFloat64(*req.Temperature))
+	}
+	if req.TopP != nil {
+		params.TopP = openai.Float(toFloat64(*req.TopP))
+	}
+	if req.MaxTokens != nil {
+		params.MaxOutputTokens = openai.Int(int64(*req.MaxTokens))
+	}
+	if len(req.Stop) > 0 || req.Seed != nil {
+		global.Logger.Warn().
+			Strs("stop", req.Stop).
+			Bool("seed", req.Seed != nil).
+			Msg("the responses api does not support stop sequences and seeds, dropping them")
+	}
+}
+
+// setChatGenerationParams sets the generation parameters of req on the params
+// of a chat completion. OpenAI takes the maximum number of tokens as
+// max_completion_tokens, the compatible servers as max_tokens. The options of
+// req.Config are applied to the body after them, so they override the
+// parameters.
+func (cli *Client) setChatGenerationParams(params *openai.ChatCompletionNewParams, req *llm.GenerateRequest) {
+	if cli.Logprobs {
+		params.Logprobs = openai.Bool(true)
+		if cli.TopLogprobs > 0 {
+			params.TopLogprobs = openai.Int(int64(cli.TopLogprobs))
+		}
+	}
+	if req.User != "" {
+		params.User = openai.String(req.User)
+	}
+	if req.Temperature != nil {
+		params.Temperature = openai.Float(toFloat64(*req.Temperature))
+	}
+	if req.TopP != nil {
+		params.TopP = openai.Float(toFloat64(*req.TopP))
+	}
+	if req.MaxTokens != nil {
+		if cli.Caps.Flavor == FlavorOpenAI {
+			params.MaxCompletionTokens = openai.Int(int64(*req.MaxTokens))
+		} else {
+			params.MaxTokens = openai.Int(int64(*req.MaxTokens))
+		}
+	}
+	if len(req.Stop) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: req.Stop}
+	}
+	if req.Seed != nil {
+		params.Seed = openai.Int(*req.Seed)
+	}
+}
+
+// toFloat64 converts f to the float64 of the same decimal representation, so
+// that 0.7 is sent as 0.7 rather than 0.699999988079071.
+func toFloat64(f float32) float64 {
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+	return v
+}
+
+// toChatContentParts converts the parts of a user message to the content
+// parts of a chat completion message.
+func toChatContentParts(parts []llm.Part) ([]openai.ChatCompletionContentPartUnionParam, error) {
+	cParts := make([]openai.ChatCompletionContentPartUnionParam, len(parts))
+	for i, part := range parts {
+		switch p := part.(type) {
+		case llm.TextPart:
+			cParts[i] = openai.TextContentPart(p.Text)
+		case llm.ImagePart:
+			if err := p.Validate(); err != nil {
+				return nil, err
+			}
+			cParts[i] = openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+				URL: p.DataURL(),
+			})
+		default:
+			return nil, fmt.Errorf("%w: unsupported part %T", llm.ErrInvalidPart, part)
 		}
+	}
+	return cParts, nil
+}
+
+// GenerateStream streams the generation through the Chat Completions API,
+// which every OpenAI-compatible server serves, whichever API Generate uses.
+// The tool calls are not streamed, a request with tools is generated with
+// Generate instead.
+func (cli *Client) GenerateStream(ctx context.Context, req *llm.GenerateRequest) (<-chan llm.GenerateChunk, error) {
+	if req == nil {
+		return nil, llm.ErrRequestShouldNotBeNull
+	}
+
+	if len(req.Messages) == 0 {
+		return nil, llm.ErrNoInput
+	}
+
+	if req.UsesTools() {
+		return nil, fmt.Errorf("%w: tool calls are not streamed", llm.ErrNotImplemented)
+	}
+
+	params, opts, err := cli.chatCompletionParams(req)
+	if err != nil {
 		return nil, err
 	}
+	return llm.NewStream(ctx, func(emit func(delta string) error) error {
+		if err := cli.Wait(ctx); err != nil {
+			return err
+		}
 
-	return &llm.GenerateResponse{
-		Outputs: []string{resp.Choices[0].Message.Content},
-		Raw:     resp,
-	}, nil
+		stream := cli.OpenAI.Chat.Completions.NewStreaming(ctx, params, opts...)
+		defer stream.Close()
+
+		for stream.Next() {
+			for _, choice := range stream.Current().Choices {
+				if choice.Index != 0 {
+					continue
+				}
+				if err := emit(choice.Delta.Content); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := stream.Err(); err != nil {
+			if e, ok := err.(*openai.Error); ok {
+				return fmt.Errorf("code: %s (%d), type: %s, msg: %s",
+					e.Code, e.StatusCode, e.Type, e.Message)
+			}
+			return err
+		}
+		return nil
+	}), nil
 }
 
 // Embed generates embeddings for the given request using an OpenAI model.
+// Embed generates the embeddings of the inputs of req. The inputs are split
+// into requests within EmbedBatchInputs and EmbedBatchTokens, made
+// EmbedParallelism at a time, and their embeddings put back in the order of
+// the inputs. The inputs of a failed request are in the EmbedStateError
+// state, with the error, and the error is only returned if every request
+// failed. If ctx is done before every input is embedded, its error is returned
+// with the response, the inputs left in the EmbedStateCancelled state.
 func (cli *Client) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.EmbedResponse, error) {
 	if req == nil {
 		return nil, llm.ErrRequestShouldNotBeNull
@@ -405,39 +1030,172 @@ func (cli *Client) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.Embed
 		opts = v
 	}
 
-	var resp *openai.CreateEmbeddingResponse
-	var err error
-	if cli.EmbedDim > 0 {
-		resp, err = cli.OpenAI.Embeddings.New(
-			ctx,
-			openai.EmbeddingNewParams{
-				Input: openai.EmbeddingNewParamsInputUnion{
-					OfArrayOfStrings: input,
-				},
-				Model:          modelName,
-				Dimensions:     openai.Int(int64(cli.EmbedDim)),
-				EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
-			},
-			opts...,
-		)
+	embedDim, err := cli.embedDimensions(modelName, req)
+	if err != nil {
+		return nil, err
+	}
+
+	params := openai.EmbeddingNewParams{
+		Model:          modelName,
+		EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
+	}
+	if embedDim > 0 {
+		params.Dimensions = openai.Int(embedDim)
+	}
+	if req.User != "" {
+		params.User = openai.String(req.User)
+	}
+
+	resp := &llm.EmbedResponse{
+		Model:      modelName,
+		Embeddings: make([]llm.Embedding, len(input)),
+	}
+	batches := cli.embedBatches(input)
+	raws := make([]*openai.CreateEmbeddingResponse, len(batches))
+	errs := make([]error, len(batches))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(cli.EmbedParallelism, 1))
+	started := 0
+produce:
+	for ; started < len(batches); started++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break produce
+		}
+
+		wg.Add(1)
+		go func(i int, batch embedBatch) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			params := params
+			params.Input = openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: batch.Texts}
+			raw, err := cli.embedBatch(ctx, params, opts)
+			raws[i], errs[i] = raw, err
+			if err != nil {
+				state := llm.EmbedStateError
+				if ctx.Err() != nil {
+					state = llm.EmbedStateCancelled
+				}
+				for j := range batch.Texts {
+					resp.Embeddings[batch.Start+j] = llm.Embedding{State: state, Error: err.Error()}
+				}
+				return
+			}
+
+			embedded := toEmbedResponse(modelName, raw)
+			copy(resp.Embeddings[batch.Start:], embedded.Embeddings)
+			mu.Lock()
+			resp.Usage = resp.Usage.Add(embedded.Usage)
+			mu.Unlock()
+		}(started, batches[started])
+	}
+	wg.Wait()
+
+	if len(raws) == 1 {
+		resp.Raw = raws[0]
 	} else {
-		resp, err = cli.OpenAI.Embeddings.New(
-			ctx,
-			openai.EmbeddingNewParams{
-				Input: openai.EmbeddingNewParamsInputUnion{
-					OfArrayOfStrings: input,
-				},
-				Model:          modelName,
-				EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
-			},
-			opts...,
-		)
+		resp.Raw = raws
+	}
+
+	if err := ctx.Err(); err != nil {
+		for _, batch := range batches[started:] {
+			for j := range batch.Texts {
+				resp.Embeddings[batch.Start+j] = llm.Embedding{State: llm.EmbedStateCancelled, Error: err.Error()}
+			}
+		}
+		return resp, err
+	}
+
+	if !slices.ContainsFunc(errs, func(err error) bool { return err == nil }) {
+		return nil, fmt.Errorf("failed to generate embeddings: %w", errors.Join(errs...))
 	}
+	return resp, nil
+}
 
+// fixedDimensionModels are the embedding models which do not support
+// truncating their embeddings with the dimensions parameter.
+var fixedDimensionModels = []string{openai.EmbeddingModelTextEmbeddingAda002}
+
+// embedDimensions returns the dimensions of the embeddings of req by
+// modelName, 0 for those of the model. Those of req are rejected if the server
+// or the model does not support them, while those of the client are dropped
+// with a warning.
+func (cli *Client) embedDimensions(modelName string, req *llm.EmbedRequest) (int64, error) {
+	if req.Dimensions != nil {
+		if err := req.ValidateDimensions(); err != nil {
+			return 0, err
+		}
+		if !cli.Caps.EmbedDimensions {
+			return 0, fmt.Errorf("%w: %s server does not support the dimensions parameter",
+				llm.ErrInvalidDimensions, cli.Caps.Flavor)
+		}
+		if slices.Contains(fixedDimensionModels, modelName) {
+			return 0, fmt.Errorf("%w: model %s does not support dimensions",
+				llm.ErrInvalidDimensions, modelName)
+		}
+		return int64(*req.Dimensions), nil
+	}
+
+	if cli.EmbedDim > 0 && !cli.Caps.EmbedDimensions {
+		global.Logger.Warn().
+			Str("flavor", string(cli.Caps.Flavor)).
+			Int64("dimensions", cli.EmbedDim).
+			Msg("server does not support the dimensions parameter, dropping it")
+		return 0, nil
+	}
+	return cli.EmbedDim, nil
+}
+
+// embedBatch is the inputs of an embedding request, from the input of Start.
+type embedBatch struct {
+	Start int
+	Texts []string
+}
+
+// embedBatches splits texts into batches within EmbedBatchInputs and
+// EmbedBatchTokens.
+func (cli *Client) embedBatches(texts []string) []embedBatch {
+	var batches []embedBatch
+	batch, tokens := embedBatch{}, 0
+	for i, text := range texts {
+		n := cli.Tokenizer.CountTokens(text)
+		full := len(batch.Texts) >= cli.EmbedBatchInputs ||
+			(cli.EmbedBatchTokens > 0 && tokens+n > cli.EmbedBatchTokens)
+		if len(batch.Texts) > 0 && full {
+			batches = append(batches, batch)
+			batch, tokens = embedBatch{Start: i}, 0
+		}
+		batch.Texts = append(batch.Texts, text)
+		tokens += n
+	}
+	return append(batches, batch)
+}
+
+// embedBatch embeds the inputs of params with a single request.
+func (cli *Client) embedBatch(ctx context.Context, params openai.EmbeddingNewParams,
+	opts []option.RequestOption) (*openai.CreateEmbeddingResponse, error) {
+	var resp *openai.CreateEmbeddingResponse
+	err := cli.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = cli.OpenAI.Embeddings.New(ctx, params, retryOpts(opts)...)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate embeddings: %w", err)
+		return nil, err
+	}
+	if len(resp.Data) != len(params.Input.OfArrayOfStrings) {
+		return nil, fmt.Errorf("%w: %d embeddings for %d inputs",
+			llm.ErrEmbedFailed, len(resp.Data), len(params.Input.OfArrayOfStrings))
 	}
+	return resp, nil
+}
 
+// toEmbedResponse converts the embeddings of resp, ordered by their index.
+func toEmbedResponse(modelName string, resp *openai.CreateEmbeddingResponse) *llm.EmbedResponse {
 	embedding := make([]llm.Embedding, len(resp.Data))
 	for _, d := range resp.Data {
 		embedding[d.Index] = llm.Embedding{
@@ -449,8 +1207,12 @@ func (cli *Client) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.Embed
 	return &llm.EmbedResponse{
 		Model:      modelName,
 		Embeddings: embedding,
-		Raw:        resp,
-	}, nil
+		Usage: llm.Usage{
+			PromptTokens: int(resp.Usage.PromptTokens),
+			TotalTokens:  int(resp.Usage.TotalTokens),
+		},
+		Raw: resp,
+	}
 }
 
 type BatchRequestJSONL struct {
@@ -489,10 +1251,17 @@ func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm
 		return nil, llm.ErrNoInput
 	}
 
-	if req.ReadWriter == nil {
-		return nil, fmt.Errorf("read writer should not be nil")
+	if !cli.Caps.Batches {
+		return nil, fmt.Errorf("%w: %s server does not support the Batches API",
+			llm.ErrNotImplemented, cli.Caps.Flavor)
 	}
 
+	input, err := req.BatchInput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch input: %w", err)
+	}
+	defer input.Close()
+
 	modelName := req.ModelName
 	if modelName == "" {
 		if m, ok := cli.DefaultModel(llm.ModelEmbed); ok {
@@ -510,15 +1279,25 @@ func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm
 
 		switch subr := r.(type) {
 		case *llm.GenerateRequest:
-			body = responses.ResponseNewParams{
+			input, err := toResponseInputParam(subr.Messages)
+			if err != nil {
+				return nil, fmt.Errorf("request %d: %w", i, err)
+			}
+
+			params := responses.ResponseNewParams{
 				Model: modelName,
 				Input: responses.ResponseNewParamsInputUnion{
-					OfInputItemList: toResponseInputParam(subr.Messages),
+					OfInputItemList: input,
 				},
 			}
+			setResponseGenerationParams(&params, subr)
+			if params.Tools, err = toResponseTools(subr.Tools); err != nil {
+				return nil, fmt.Errorf("request %d: %w", i, err)
+			}
+			body = params
 
 			jsonl = BatchRequestJSONL{
-				CustomID: fmt.Sprintf("gen-"+formatter, now, req.BatchJobName, i),
+				CustomID: fmt.Sprintf(batchGeneratePrefix+formatter, now, req.BatchJobName, i),
 				Endpoint: openai.BatchNewParamsEndpointV1Responses,
 			}
 		case *llm.EmbedRequest:
@@ -534,12 +1313,19 @@ func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm
 				Model:          modelName,
 				EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
 			}
-			if cli.EmbedDim > 0 {
-				tmp.Dimensions = openai.Int(cli.EmbedDim)
+			embedDim, err := cli.embedDimensions(modelName, subr)
+			if err != nil {
+				return nil, err
+			}
+			if embedDim > 0 {
+				tmp.Dimensions = openai.Int(embedDim)
+			}
+			if subr.User != "" {
+				tmp.User = openai.String(subr.User)
 			}
 			body = tmp
 			jsonl = BatchRequestJSONL{
-				CustomID: fmt.Sprintf("embed-"+formatter, now, req.BatchJobName, i),
+				CustomID: fmt.Sprintf(batchEmbedPrefix+formatter, now, req.BatchJobName, i),
 				Endpoint: openai.BatchNewParamsEndpointV1Embeddings,
 			}
 		default:
@@ -549,13 +1335,9 @@ func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm
 		jsonl.Method = http.MethodPost
 		jsonl.Body = body
 
-		data, err := json.Marshal(jsonl)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal %d-th request to jsonl: %w", i, err)
+		if err := input.WriteLine(jsonl); err != nil {
+			return nil, fmt.Errorf("failed to write %d-th request to jsonl: %w", i, err)
 		}
-
-		req.ReadWriter.Write(data)
-		req.ReadWriter.Write([]byte{'\n'})
 	}
 
 	var opts []option.RequestOption
@@ -563,11 +1345,12 @@ func (cli *Client) BatchCreate(ctx context.Context, req *llm.BatchRequest) (*llm
 		opts = v
 	}
 
-	if f, ok := req.ReadWriter.(*os.File); ok {
-		f.Seek(0, io.SeekStart)
-	}
-
-	file, err := cli.File(ctx, req.ReadWriter, fmt.Sprintf("%d-%s.jsonl", now, req.BatchJobName), "application/jsonl", opts...)
+	global.Logger.Debug().
+		Str("batch_job_name", req.BatchJobName).
+		Int64("bytes", input.Size()).
+		Int64("lines", input.Lines()).
+		Msg("Uploading batch input")
+	file, err := cli.File(ctx, input.Reader(), fmt.Sprintf("%d-%s.jsonl", now, req.BatchJobName), "application/jsonl", opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create jsonl file for batch: %w", err)
 	}
@@ -649,11 +1432,12 @@ func (cli *Client) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequ
 		return resp, nil
 	}
 
+	outputFileID := batch.OutputFileID
 	if resp.Status != string(openai.BatchStatusCompleted) {
-		batch.OutputFileID = batch.ErrorFileID
+		outputFileID = batch.ErrorFileID
 	}
 
-	if batch.OutputFileID == "" {
+	if outputFileID == "" {
 		return resp, fmt.Errorf("%w: empty file_id field", ErrFailedToGetOutputFile)
 	}
 
@@ -662,7 +1446,7 @@ func (cli *Client) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequ
 		opts = v
 	}
 
-	file, err := cli.OpenAI.Files.Content(ctx, batch.OutputFileID, opts...)
+	file, err := cli.OpenAI.Files.Content(ctx, outputFileID, opts...)
 	resp.Raw = file
 	if err != nil {
 		return resp, fmt.Errorf("%s: %w", ErrFailedToGetOutputFile.Error(), err)
@@ -683,6 +1467,10 @@ func (cli *Client) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequ
 		return resp, fmt.Errorf("failed to get output file: %w", err)
 	}
 	resp.Responses = bytes.Split(body, []byte("\n"))
+	cli.cleanupBatchFiles(ctx, batch)
+	if resp.Parsed, err = ParseBatchOutput(resp.Responses); err != nil {
+		return resp, err
+	}
 	return resp, nil
 }
 
@@ -702,42 +1490,257 @@ func formatter(n int) string {
 	return fmt.Sprintf("%%0%dd", digit)
 }
 
-func healthCheck(ctx context.Context, cli openai.Client) error {
-	var err error
-	for i := 0; i < MaxRetries; i++ {
-		if _, err = cli.Models.List(ctx); err == nil {
-			return nil
+// healthCheck pings the server, by listing its models or with the ping of
+// Azure, retrying on any error with the delays of policy.
+func healthCheck(ctx context.Context, policy llm.RetryPolicy, ping func(ctx context.Context) error) error {
+	policy.RetryOn = func(error) bool { return true }
+	err := policy.Do(ctx, ping)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCanNotConnectToServer, err)
+	}
+	return nil
+}
+
+// retryAfter returns the RetryAfter of a retry policy, the delay asked for by
+// the response of an API error, up to maxWait.
+func retryAfter(maxWait time.Duration) func(err error) time.Duration {
+	return func(err error) time.Duration {
+		var apiErr *openai.Error
+		if !errors.As(err, &apiErr) || apiErr.Response == nil {
+			return 0
 		}
-		time.Sleep(min(1<<i*time.Second, MaxRetryWaitingTime))
+		d, _ := llm.ParseRetryAfter(apiErr.Response.Header, time.Now())
+		return min(d, maxWait)
 	}
-	return ErrCanNotConnectToServer
 }
 
-func toResponseInputParam(msgs []llm.Message) responses.ResponseInputParam {
-	param := make(responses.ResponseInputParam, len(msgs))
-	for i, msg := range msgs {
-		content := make(responses.ResponseInputMessageContentListParam, len(msg.Content))
+// IsTransient reports whether the error of an OpenAI request is worth
+// retrying: a rate limit or a server error of the API, or a transient
+// failure to reach it.
+func IsTransient(err error) bool {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return llm.IsTransientStatus(apiErr.StatusCode)
+	}
+	return llm.IsTransient(err)
+}
+
+// retryOpts returns opts disabling the retries of the SDK, for the requests
+// retried by the retry policy of the client.
+func retryOpts(opts []option.RequestOption) []option.RequestOption {
+	return append(slices.Clip(opts), option.WithMaxRetries(0))
+}
+
+func toResponseInputParam(msgs []llm.Message) (responses.ResponseInputParam, error) {
+	param := make(responses.ResponseInputParam, 0, len(msgs))
+	for _, msg := range msgs {
+		switch {
+		case msg.Role == llm.RoleTool:
+			if msg.ToolCallID == "" {
+				return nil, fmt.Errorf("%w: a tool message should have the id of its call", llm.ErrInvalidTool)
+			}
+			param = append(param, responses.ResponseInputItemUnionParam{
+				OfFunctionCallOutput: &responses.ResponseInputItemFunctionCallOutputParam{
+					CallID: msg.ToolCallID,
+					Output: toolResult(msg),
+				},
+			})
+			continue
+		case msg.Role == llm.RoleAssistant && len(msg.ToolCalls) > 0:
+			if len(msg.Content) > 0 {
+				text := msg
+				text.ToolCalls = nil
+				items, err := toResponseInputParam([]llm.Message{text})
+				if err != nil {
+					return nil, err
+				}
+				param = append(param, items...)
+			}
+			for _, call := range msg.ToolCalls {
+				param = append(param, responses.ResponseInputItemUnionParam{
+					OfFunctionCall: &responses.ResponseFunctionToolCallParam{
+						CallID:    call.ID,
+						Name:      call.Name,
+						Arguments: call.Arguments,
+					},
+				})
+			}
+			continue
+		}
+
+		parts := msg.ContentParts()
+		content := make(responses.ResponseInputMessageContentListParam, len(parts))
 		role := "user"
 		if msg.Role == llm.RoleAssistant || msg.Role == llm.RoleSystem {
 			role = "system"
 		}
+		if role != "user" && msg.HasImage() {
+			return nil, fmt.Errorf(
+				"%w: images are only supported in user messages, got a %s message",
+				llm.ErrInvalidPart, msg.Role)
+		}
 
-		for j, c := range msg.Content {
-			content[j] = responses.ResponseInputContentUnionParam{
-				OfInputText: &responses.ResponseInputTextParam{
-					Text: c,
-				},
+		for j, part := range parts {
+			switch p := part.(type) {
+			case llm.TextPart:
+				content[j] = responses.ResponseInputContentUnionParam{
+					OfInputText: &responses.ResponseInputTextParam{
+						Text: p.Text,
+					},
+				}
+			case llm.ImagePart:
+				if err := p.Validate(); err != nil {
+					return nil, err
+				}
+				content[j] = responses.ResponseInputContentUnionParam{
+					OfInputImage: &responses.ResponseInputImageParam{
+						ImageURL: openai.String(p.DataURL()),
+						Detail:   responses.ResponseInputImageDetailAuto,
+					},
+				}
+			default:
+				return nil, fmt.Errorf("%w: unsupported part %T", llm.ErrInvalidPart, part)
 			}
 		}
 
-		param[i] = responses.ResponseInputItemUnionParam{
+		param = append(param, responses.ResponseInputItemUnionParam{
 			OfInputMessage: &responses.ResponseInputItemMessageParam{
 				Role:    role,
 				Content: content,
 			},
+		})
+	}
+	return param, nil
+}
+
+// toolResult returns the texts of a tool message, the result of its call.
+func toolResult(msg llm.Message) string {
+	return strings.Join(msg.Content, "\n")
+}
+
+// toolParameters returns the parameters of tool, an object without properties
+// if it has none, as the responses API requires them.
+func toolParameters(tool llm.ToolDefinition) (map[string]any, error) {
+	if err := tool.Validate(); err != nil {
+		return nil, err
+	}
+	params, err := tool.ParametersMap()
+	if err != nil {
+		return nil, err
+	}
+	if params == nil {
+		params = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	return params, nil
+}
+
+// toChatTools converts the tools of a request to the function tools of a chat
+// completion.
+func toChatTools(tools []llm.ToolDefinition) ([]openai.ChatCompletionToolUnionParam, error) {
+	if len(tools) == 0 {
+		return nil, nil
+	}
+
+	cTools := make([]openai.ChatCompletionToolUnionParam, len(tools))
+	for i, tool := range tools {
+		params, err := toolParameters(tool)
+		if err != nil {
+			return nil, err
+		}
+		fn := shared.FunctionDefinitionParam{
+			Name:       tool.Name,
+			Parameters: shared.FunctionParameters(params),
+			Strict:     openai.Bool(tool.Strict),
+		}
+		if tool.Description != "" {
+			fn.Description = openai.String(tool.Description)
+		}
+		cTools[i] = openai.ChatCompletionFunctionTool(fn)
+	}
+	return cTools, nil
+}
+
+// toResponseTools converts the tools of a request to the function tools of a
+// response.
+func toResponseTools(tools []llm.ToolDefinition) ([]responses.ToolUnionParam, error) {
+	if len(tools) == 0 {
+		return nil, nil
+	}
+
+	rTools := make([]responses.ToolUnionParam, len(tools))
+	for i, tool := range tools {
+		params, err := toolParameters(tool)
+		if err != nil {
+			return nil, err
+		}
+		fn := &responses.FunctionToolParam{
+			Name:       tool.Name,
+			Parameters: params,
+			Strict:     openai.Bool(tool.Strict),
+		}
+		if tool.Description != "" {
+			fn.Description = openai.String(tool.Description)
+		}
+		rTools[i] = responses.ToolUnionParam{OfFunction: fn}
+	}
+	return rTools, nil
+}
+
+// toChatToolCallMessage converts an assistant message with tool calls to the
+// message of a chat completion, its texts as the content.
+func toChatToolCallMessage(msg llm.Message) openai.ChatCompletionMessageParamUnion {
+	assistant := openai.ChatCompletionAssistantMessageParam{
+		ToolCalls: make([]openai.ChatCompletionMessageToolCallUnionParam, len(msg.ToolCalls)),
+	}
+	if len(msg.Content) > 0 {
+		assistant.Content.OfString = openai.String(strings.Join(msg.Content, "\n"))
+	}
+	for i, call := range msg.ToolCalls {
+		assistant.ToolCalls[i] = openai.ChatCompletionMessageToolCallUnionParam{
+			OfFunction: &openai.ChatCompletionMessageFunctionToolCallParam{
+				ID: call.ID,
+				Function: openai.ChatCompletionMessageFunctionToolCallFunctionParam{
+					Name:      call.Name,
+					Arguments: call.Arguments,
+				},
+			},
 		}
 	}
-	return param
+	return openai.ChatCompletionMessageParamUnion{OfAssistant: &assistant}
+}
+
+// chatToolCalls returns the function calls of a chat completion message, the
+// custom tool calls are dropped.
+func chatToolCalls(calls []openai.ChatCompletionMessageToolCallUnion) []llm.ToolCall {
+	var toolCalls []llm.ToolCall
+	for _, call := range calls {
+		if call.Type != "function" {
+			continue
+		}
+		toolCalls = append(toolCalls, llm.ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	return toolCalls
+}
+
+// responseToolCalls returns the function calls of the output of resp.
+func responseToolCalls(resp *responses.Response) []llm.ToolCall {
+	var toolCalls []llm.ToolCall
+	for _, item := range resp.Output {
+		if item.Type != "function_call" {
+			continue
+		}
+		call := item.AsFunctionCall()
+		toolCalls = append(toolCalls, llm.ToolCall{
+			ID:        call.CallID,
+			Name:      call.Name,
+			Arguments: call.Arguments,
+		})
+	}
+	return toolCalls
 }
 
 // IsTerminalJobState checks if a given job status indicates a terminal state (succeeded, failed, cancelled, or expired).
@@ -9,19 +9,27 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/llm/llmtest"
 	openaiplug "github.com/ChiaYuChang/weathercock/internal/llm/openai"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
 	"github.com/invopop/jsonschema"
 	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
 	"github.com/stretchr/testify/require"
 )
 
@@ -263,50 +271,59 @@ func TestOpenAIBatchCreate(t *testing.T) {
 	require.Equal(t, file.Purpose, openai.FileObjectPurposeBatch)
 }
 
-func TestOpenAIBatchRetrive(t *testing.T) {
-	tcs := []struct {
-		Name            string
-		HTTPStatus      int
-		RetrieveErrMsg  string
-		BatchID         string
-		BatchStatusFile [][2]string
-		BatchResultFile string
-	}{
-		{
-			Name:           "Batch_Not_Found",
-			HTTPStatus:     http.StatusNotFound,
-			RetrieveErrMsg: "missing required batch_id parameter",
-		},
-		{
-			Name:       "Batch_Cacelled",
-			HTTPStatus: http.StatusOK,
-			BatchID:    "batch_68a49aeda4f881908e42162e5d37453e",
-			BatchStatusFile: [][2]string{
-				{
-					string(openai.BatchStatusCancelled),
-					"./68a49_batch_cancelled_status.json",
-				},
+// batchCase is a batch served by newBatchServer, it goes through the
+// statuses of BatchStatusFile, one per retrieval, and stays at the last one.
+type batchCase struct {
+	Name            string
+	HTTPStatus      int
+	RetrieveErrMsg  string
+	BatchID         string
+	BatchStatusFile [][2]string
+	BatchResultFile string
+}
+
+// batchCases are the batches of the batch fixtures.
+var batchCases = []batchCase{
+	{
+		Name:           "Batch_Not_Found",
+		HTTPStatus:     http.StatusNotFound,
+		RetrieveErrMsg: "missing required batch_id parameter",
+	},
+	{
+		Name:       "Batch_Cacelled",
+		HTTPStatus: http.StatusOK,
+		BatchID:    "batch_68a49aeda4f881908e42162e5d37453e",
+		BatchStatusFile: [][2]string{
+			{
+				string(openai.BatchStatusCancelled),
+				"./68a49_batch_cancelled_status.json",
 			},
-			BatchResultFile: "./68a49_batch_cancelled_results.jsonl",
 		},
-		{
-			Name:       "Batch_Completed",
-			HTTPStatus: http.StatusOK,
-			BatchID:    "batch_68a5f3a332788190a219f8b62fe1ed33",
-			BatchStatusFile: [][2]string{
-				{
-					string(openai.BatchStatusInProgress),
-					"./68a5f_batch_inprogress_status.json",
-				},
-				{
-					string(openai.BatchStatusCompleted),
-					"./68a5f_batch_completed_status.json",
-				},
+		BatchResultFile: "./68a49_batch_cancelled_results.jsonl",
+	},
+	{
+		Name:       "Batch_Completed",
+		HTTPStatus: http.StatusOK,
+		BatchID:    "batch_68a5f3a332788190a219f8b62fe1ed33",
+		BatchStatusFile: [][2]string{
+			{
+				string(openai.BatchStatusInProgress),
+				"./68a5f_batch_inprogress_status.json",
+			},
+			{
+				string(openai.BatchStatusCompleted),
+				"./68a5f_batch_completed_status.json",
 			},
-			BatchResultFile: "./68a5f_batch_completed_results.jsonl",
 		},
-	}
+		BatchResultFile: "./68a5f_batch_completed_results.jsonl",
+	},
+}
 
+// newBatchServer serves the batches of tcs, their result files and the model
+// list of the OpenAI API, for the requests authorized by key. It returns the
+// server and the number of retrievals of a batch.
+func newBatchServer(t *testing.T, key string, tcs []batchCase) (*httptest.Server, func(id string) int) {
+	t.Helper()
 	sCode := map[string]int{}
 	for _, tc := range tcs {
 		sCode[tc.BatchID] = tc.HTTPStatus
@@ -324,10 +341,8 @@ func TestOpenAIBatchRetrive(t *testing.T) {
 			continue
 		}
 
-		wd, err := os.Getwd()
-		require.NoError(t, err)
 		for _, bsf := range tc.BatchStatusFile {
-			filePath := filepath.Join(wd, "internal", "llm", "openai", bsf[1])
+			filePath := bsf[1]
 			s, err := os.ReadFile(filePath)
 			require.NoError(t, err)
 			require.NotNil(t, s)
@@ -338,7 +353,7 @@ func TestOpenAIBatchRetrive(t *testing.T) {
 				require.Len(t, subm, 2)
 
 				fID := string(subm[1])
-				resultFilePath := filepath.Join(wd, "internal", "llm", "openai", tc.BatchResultFile)
+				resultFilePath := tc.BatchResultFile
 				r, err := os.ReadFile(resultFilePath)
 				require.NoError(t, err)
 				result[fID] = r
@@ -346,7 +361,7 @@ func TestOpenAIBatchRetrive(t *testing.T) {
 		}
 	}
 
-	key := "my-openai-key"
+	var mu sync.Mutex
 	ithQuery := map[string]int{}
 
 	mux := http.NewServeMux()
@@ -358,8 +373,10 @@ func TestOpenAIBatchRetrive(t *testing.T) {
 		}
 
 		bID := r.PathValue("batch_id")
+		mu.Lock()
 		i := ithQuery[bID]
 		ithQuery[bID] = min(i+1, len(status[bID]))
+		mu.Unlock()
 		data, ok := status[bID]
 		if !ok {
 			w.WriteHeader(http.StatusNotFound)
@@ -379,7 +396,7 @@ func TestOpenAIBatchRetrive(t *testing.T) {
 		require.True(t, ok)
 
 		w.WriteHeader(c)
-		w.Write(data[i])
+		w.Write(data[min(i, len(data)-1)])
 	})
 
 	mux.HandleFunc("/files/{file_id}/content", func(w http.ResponseWriter, r *http.Request) {
@@ -446,7 +463,18 @@ func TestOpenAIBatchRetrive(t *testing.T) {
 		w.Write(data)
 	})
 	server := httptest.NewServer(mux)
-	defer server.Close()
+	t.Cleanup(server.Close)
+	return server, func(id string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return ithQuery[id]
+	}
+}
+
+func TestOpenAIBatchRetrive(t *testing.T) {
+	tcs := batchCases
+	key := "my-openai-key"
+	server, queries := newBatchServer(t, key, tcs)
 
 	dim := 1024
 	cli, err := openaiplug.OpenAI(context.Background(),
@@ -482,9 +510,10 @@ func TestOpenAIBatchRetrive(t *testing.T) {
 				require.NoError(t, err)
 				require.NotNil(t, resp)
 
-				i := max(0, ithQuery[tc.BatchID]-1)
+				i := max(0, queries(tc.BatchID)-1)
 				require.Equal(t, string(tc.BatchStatusFile[i][0]), resp.Status)
 				if resp.IsDone {
+					require.Len(t, resp.Parsed, 10)
 					break
 				}
 				time.Sleep(time.Duration(min(1<<retry, 10)) * time.Second)
@@ -495,6 +524,148 @@ func TestOpenAIBatchRetrive(t *testing.T) {
 	}
 }
 
+func TestParseBatchOutput(t *testing.T) {
+	readLines := func(t *testing.T, path string) [][]byte {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return bytes.Split(data, []byte("\n"))
+	}
+
+	t.Run("completed", func(t *testing.T) {
+		lines := readLines(t, "./68a5f_batch_completed_results.jsonl")
+		slices.Reverse(lines)
+		results, err := openaiplug.ParseBatchOutput(lines)
+		require.NoError(t, err)
+		require.Len(t, results, 10)
+		for i, r := range results {
+			require.Equal(t, i, r.Index)
+			require.Equal(t, fmt.Sprintf("embed-1755706275-openai-batch-test-file-%02d", i), r.CustomID)
+			require.NoError(t, r.Err)
+			require.Nil(t, r.Generate)
+			require.NotNil(t, r.Embed)
+			require.Equal(t, "text-embedding-3-small", r.Embed.Model)
+			require.NotEmpty(t, r.Embed.Embeddings)
+			for _, e := range r.Embed.Embeddings {
+				require.Equal(t, llm.EmbedStateOk, e.State)
+				require.Equal(t, 16, e.Dim())
+			}
+		}
+		require.Equal(t, 1633, results[0].Embed.Usage.PromptTokens)
+		require.Equal(t, float32(0.3522074), results[0].Embed.Embeddings[0].Values[0])
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		results, err := openaiplug.ParseBatchOutput(readLines(t, "./68a49_batch_cancelled_results.jsonl"))
+		require.NoError(t, err)
+		require.Len(t, results, 10)
+		for _, r := range results {
+			require.Nil(t, r.Embed)
+			require.ErrorIs(t, r.Err, llm.ErrBatchItemFailed)
+
+			var itemErr *llm.BatchItemError
+			require.ErrorAs(t, r.Err, &itemErr)
+			require.Equal(t, "batch_cancelled", itemErr.Code)
+			require.Equal(t, r.CustomID, itemErr.CustomID)
+		}
+	})
+
+	t.Run("generate", func(t *testing.T) {
+		results, err := openaiplug.ParseBatchOutput([][]byte{
+			[]byte(`{"id":"batch_req_2","custom_id":"gen-1755706275-my-job-1","response":{"status_code":429,"request_id":"r2","body":{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}},"error":null}`),
+			[]byte(`{"id":"batch_req_1","custom_id":"gen-1755706275-my-job-0","response":{"status_code":200,"request_id":"r1","body":{"id":"resp_1","object":"response","status":"completed","output":[{"id":"msg_1","type":"message","role":"assistant","status":"completed","content":[{"type":"output_text","text":"三鶯線","annotations":[]}]}],"usage":{"input_tokens":12,"output_tokens":3,"total_tokens":15}}},"error":null}`),
+			{},
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		require.NoError(t, results[0].Err)
+		require.Equal(t, []string{"三鶯線"}, results[0].Generate.Outputs)
+		require.Equal(t, llm.Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}, results[0].Generate.Usage)
+
+		var itemErr *llm.BatchItemError
+		require.ErrorAs(t, results[1].Err, &itemErr)
+		require.Equal(t, http.StatusTooManyRequests, itemErr.StatusCode)
+		require.Equal(t, "rate_limit_exceeded", itemErr.Code)
+		require.Equal(t, "Rate limit reached", itemErr.Message)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := openaiplug.ParseBatchOutput([][]byte{[]byte(`{"custom_id":"request-1"}`)})
+		require.ErrorIs(t, err, openaiplug.ErrInvalidCustomID)
+		_, err = openaiplug.ParseBatchOutput([][]byte{[]byte(`{"custom_id":"gen-1755706275-my-job"}`)})
+		require.ErrorIs(t, err, openaiplug.ErrInvalidCustomID)
+		_, err = openaiplug.ParseBatchOutput([][]byte{[]byte(`{"custom_id":`)})
+		require.ErrorContains(t, err, "failed to decode line 0")
+	})
+}
+
+// newMockClient returns a client of the OpenAI API mocked at baseURL.
+func newMockClient(t *testing.T, key, baseURL string) *openaiplug.Client {
+	t.Helper()
+	cli, err := openaiplug.OpenAI(context.Background(),
+		openaiplug.WithAPIKey(key),
+		openaiplug.WithTimeout(30*time.Second),
+		openaiplug.WithBaseURL(baseURL),
+		openaiplug.WithModel(
+			openaiplug.NewOpenAIModel(llm.ModelGenerate, openaiplug.DefaultGenModel),
+			openaiplug.NewOpenAIModel(llm.ModelEmbed, openaiplug.DefaultEmbedModel),
+		),
+		openaiplug.WithDefaultGenerate(openaiplug.DefaultGenModel),
+		openaiplug.WithDefaultEmbed(openaiplug.DefaultEmbedModel),
+	)
+	require.NoError(t, err)
+	require.NotNil(t, cli)
+	return cli
+}
+
+func TestOpenAIWaitForBatch(t *testing.T) {
+	key := "my-openai-key"
+	server, queries := newBatchServer(t, key, batchCases)
+
+	cli := newMockClient(t, key, server.URL)
+
+	for _, tc := range batchCases[1:] {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := llm.WaitForBatch(context.Background(), cli, tc.BatchID,
+				llm.WithPollInterval(time.Millisecond, 4*time.Millisecond))
+			require.NoError(t, err)
+			require.NotNil(t, resp)
+			require.True(t, resp.IsDone)
+			require.Equal(t, tc.BatchStatusFile[len(tc.BatchStatusFile)-1][0], resp.Status)
+			require.Equal(t, len(tc.BatchStatusFile), queries(tc.BatchID))
+		})
+	}
+
+	t.Run("Batch_Not_Found", func(t *testing.T) {
+		_, err := llm.WaitForBatch(context.Background(), cli, "batch_unknown",
+			llm.WithPollInterval(time.Millisecond, 4*time.Millisecond),
+			llm.WithPollMaxErrors(1))
+		require.ErrorContains(t, err, "404 Not Found")
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		id := "batch_68a5f3a332788190a219f8b62fe1ed33"
+		server, _ := newBatchServer(t, key, []batchCase{{
+			Name:       "Batch_In_Progress",
+			HTTPStatus: http.StatusOK,
+			BatchID:    id,
+			BatchStatusFile: [][2]string{{
+				string(openai.BatchStatusInProgress),
+				"./68a5f_batch_inprogress_status.json",
+			}},
+		}})
+		cli := newMockClient(t, key, server.URL)
+
+		_, err := llm.WaitForBatch(context.Background(), cli, id,
+			llm.WithPollInterval(time.Millisecond, 4*time.Millisecond),
+			llm.WithWaitDeadline(20*time.Millisecond))
+		require.ErrorIs(t, err, llm.ErrBatchWaitTimeout)
+
+		var timeout *llm.BatchWaitTimeoutError
+		require.ErrorAs(t, err, &timeout)
+		require.Equal(t, string(openai.BatchStatusInProgress), timeout.Status)
+	})
+}
+
 func TestOpenAIForamatOutput(t *testing.T) {
 	key := os.Getenv("OPENAI_API_KEY")
 	if key == "" {
@@ -644,3 +815,1652 @@ func LogJson(t *testing.T, v any) {
 	require.NotNil(t, data)
 	t.Log(string(data))
 }
+
+func TestOpenAIServerCaps(t *testing.T) {
+	type served struct {
+		Path string
+		Body map[string]any
+	}
+
+	tcs := []struct {
+		Name      string
+		Models    string
+		Responses bool
+		Batches   bool
+		Caps      openaiplug.ServerCaps
+	}{
+		{
+			Name:   "llama.cpp",
+			Models: `{"object":"list","models":[{"name":"qwen3","model":"qwen3"}],"data":[{"id":"qwen3","object":"model","created":1754426384,"owned_by":"llamacpp","meta":{"n_ctx_train":40960}}]}`,
+			Caps: openaiplug.ServerCaps{
+				Flavor:     openaiplug.FlavorLlamaCpp,
+				JSONSchema: true,
+			},
+		},
+		{
+			Name:   "Ollama-shim",
+			Models: `{"object":"list","data":[{"id":"qwen3","object":"model","created":1754426384,"owned_by":"library"}]}`,
+			Caps: openaiplug.ServerCaps{
+				Flavor:     openaiplug.FlavorOllama,
+				JSONSchema: true,
+			},
+		},
+		{
+			Name:      "OpenAI",
+			Models:    `{"object":"list","data":[{"id":"gpt-5-nano","object":"model","created":1754426384,"owned_by":"system"}]}`,
+			Responses: true,
+			Batches:   true,
+			Caps: openaiplug.ServerCaps{
+				Flavor:          openaiplug.FlavorOpenAI,
+				ResponsesAPI:    true,
+				JSONSchema:      true,
+				Batches:         true,
+				EmbedDimensions: true,
+				MaxEmbedInputs:  2048,
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			calls := []served{}
+			record := func(r *http.Request) map[string]any {
+				body := map[string]any{}
+				data, _ := io.ReadAll(r.Body)
+				_ = json.Unmarshal(data, &body)
+				calls = append(calls, served{Path: r.URL.Path, Body: body})
+				return body
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tc.Models))
+			})
+			mux.HandleFunc("POST /responses", func(w http.ResponseWriter, r *http.Request) {
+				body := record(r)
+				if !tc.Responses {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				if _, ok := body["model"]; !ok {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(`{"error":{"message":"Missing required parameter: 'model'.","type":"invalid_request_error"}}`))
+					return
+				}
+				w.Write([]byte(`{"id":"resp_1","object":"response","status":"completed","output":[{"id":"msg_1","type":"message","role":"assistant","status":"completed","content":[{"type":"output_text","text":"responses","annotations":[]}]}]}`))
+			})
+			mux.HandleFunc("GET /batches", func(w http.ResponseWriter, r *http.Request) {
+				if !tc.Batches {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"object":"list","data":[],"has_more":false}`))
+			})
+			mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+				record(r)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"qwen3","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"chat"}}]}`))
+			})
+			mux.HandleFunc("POST /embeddings", func(w http.ResponseWriter, r *http.Request) {
+				record(r)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"object":"list","model":"bge-m3","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2,0.3]}]}`))
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			cli, err := openaiplug.OpenAI(context.Background(),
+				openaiplug.WithAPIKey("my-openai-key"),
+				openaiplug.WithBaseURL(server.URL),
+				openaiplug.WithMaxRetries(1),
+				openaiplug.WithEmbedDim(1024),
+			)
+			require.NoError(t, err)
+			require.NotNil(t, cli)
+			require.Equal(t, tc.Caps, cli.Caps)
+
+			calls = calls[:0]
+			resp, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+				Messages: []llm.Message{
+					{Role: llm.RoleUser, Content: []string{"hello"}},
+				},
+			})
+			require.NoError(t, err)
+			require.Len(t, calls, 1)
+			if tc.Caps.ResponsesAPI {
+				require.Equal(t, "/responses", calls[0].Path)
+				require.Equal(t, []string{"responses"}, resp.Outputs)
+			} else {
+				require.Equal(t, "/chat/completions", calls[0].Path)
+				require.Equal(t, []string{"chat"}, resp.Outputs)
+			}
+
+			calls = calls[:0]
+			_, err = cli.Embed(context.Background(), &llm.EmbedRequest{
+				Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("hello")},
+			})
+			require.NoError(t, err)
+			require.Len(t, calls, 1)
+			_, ok := calls[0].Body["dimensions"]
+			require.Equal(t, tc.Caps.EmbedDimensions, ok)
+
+			if !tc.Caps.Batches {
+				_, err = cli.BatchCreate(context.Background(), &llm.BatchRequest{
+					Requests:   []llm.Request{&llm.EmbedRequest{Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("hello")}}},
+					ReadWriter: &bytes.Buffer{},
+				})
+				require.ErrorIs(t, err, llm.ErrNotImplemented)
+			}
+		})
+	}
+
+	t.Run("Override", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(tcs[1].Models))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		caps := openaiplug.ServerCaps{
+			Flavor:          openaiplug.FlavorOllama,
+			EmbedDimensions: true,
+		}
+		cli, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithServerCaps(caps),
+		)
+		require.NoError(t, err)
+		require.Equal(t, caps, cli.Caps)
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		// the health check lists the models first, then the probe fails more
+		// times than the SDK retries
+		var n atomic.Int32
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+			if i := n.Add(1); i > 1 && i <= 4 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(tcs[1].Models))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		cli, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithRetryPolicy(llm.RetryPolicy{
+				MaxAttempts: 4,
+				BaseDelay:   time.Millisecond,
+				MaxDelay:    4 * time.Millisecond,
+			}),
+		)
+		require.NoError(t, err)
+		require.Equal(t, tcs[1].Caps, cli.Caps)
+		require.Equal(t, int32(5), n.Load())
+	})
+
+	t.Run("BaseURL", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /v1/models", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		target, err := url.Parse(server.URL)
+		require.NoError(t, err)
+
+		cli, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL("https://api.openai.com/v1"),
+			openaiplug.WithHTTPClient(&http.Client{Transport: hostTransport{target: target}}),
+			openaiplug.WithoutHealthCheck(),
+		)
+		require.NoError(t, err)
+		require.Equal(t, openaiplug.DefaultServerCaps(openaiplug.FlavorOpenAI), cli.Caps)
+	})
+}
+
+// hostTransport sends the requests to target whatever their host, with
+// http.DefaultTransport.
+type hostTransport struct {
+	target *url.URL
+}
+
+func (t hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOpenAIBatchInput(t *testing.T) {
+	tcs := []struct {
+		Name       string
+		UploadFail bool
+	}{
+		{Name: "Upload_Success"},
+		{Name: "Upload_Failure", UploadFail: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			var uploaded []byte
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"object":"list","data":[]}`))
+			})
+			mux.HandleFunc("POST /files", func(w http.ResponseWriter, r *http.Request) {
+				if tc.UploadFail {
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write([]byte(`{"error":{"message":"upload failed"}}`))
+					return
+				}
+
+				file, _, err := r.FormFile("file")
+				require.NoError(t, err)
+				uploaded, err = io.ReadAll(file)
+				require.NoError(t, err)
+
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"id":"file-1","object":"file","bytes":1,"created_at":1754426384,"filename":"input.jsonl","purpose":"batch"}`))
+			})
+			mux.HandleFunc("POST /batches", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"id":"batch-1","object":"batch","endpoint":"/v1/embeddings","input_file_id":"file-1","completion_window":"24h","status":"validating","created_at":1754426384}`))
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			cli, err := openaiplug.OpenAI(context.Background(),
+				openaiplug.WithAPIKey("my-openai-key"),
+				openaiplug.WithBaseURL(server.URL),
+				openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(openaiplug.FlavorOpenAI)),
+			)
+			require.NoError(t, err)
+
+			input, err := llm.NewBatchInputWriter()
+			require.NoError(t, err)
+
+			reqs := []llm.Request{
+				&llm.EmbedRequest{Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("hello")}},
+				&llm.EmbedRequest{Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("world")}},
+			}
+			_, err = cli.BatchCreate(context.Background(), &llm.BatchRequest{
+				Endpoint:     string(openai.BatchNewParamsEndpointV1Embeddings),
+				BatchJobName: "openai-batch-input",
+				Requests:     reqs,
+				Input:        input,
+			})
+			if tc.UploadFail {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				lines := bytes.Split(bytes.TrimSpace(uploaded), []byte("\n"))
+				require.Len(t, lines, len(reqs))
+			}
+			require.NoFileExists(t, input.Name())
+		})
+	}
+
+	t.Run("Too_Large", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && r.URL.Path == "/models" {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"object":"list","data":[]}`))
+				return
+			}
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}))
+		defer server.Close()
+
+		cli, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(openaiplug.FlavorOpenAI)),
+		)
+		require.NoError(t, err)
+
+		input, err := llm.NewBatchInputWriter(llm.WithBatchInputLimits(0, 1))
+		require.NoError(t, err)
+
+		_, err = cli.BatchCreate(context.Background(), &llm.BatchRequest{
+			Endpoint: string(openai.BatchNewParamsEndpointV1Embeddings),
+			Requests: []llm.Request{
+				&llm.EmbedRequest{Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("hello")}},
+				&llm.EmbedRequest{Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("world")}},
+			},
+			Input: input,
+		})
+		require.ErrorIs(t, err, llm.ErrBatchInputTooLarge)
+		require.NoFileExists(t, input.Name())
+	})
+}
+
+func TestTokenizer(t *testing.T) {
+	tok, err := openaiplug.NewTokenizer(openai.EmbeddingModelTextEmbedding3Small)
+	require.NoError(t, err)
+
+	// cl100k_base
+	require.Equal(t, []int{15339, 1917}, tok.Encode("hello world"))
+	require.Equal(t, 2, tok.CountTokens("hello world"))
+	require.Zero(t, tok.CountTokens(""))
+	require.Equal(t, tok.CountTokens("<|endoftext|> hi"), len(tok.Encode("<|endoftext|> hi")))
+
+	_, err = openaiplug.NewTokenizer("unknown-model")
+	require.Error(t, err)
+
+	text := strings.Repeat("新北市政府今日宣布，捷運三鶯線的整體工程進度已超過85%。The MRT line opens next year. ", 20)
+	offsets, err := llm.ChunkByTokens(text, tok, 64, 8)
+	require.NoError(t, err)
+	require.Greater(t, len(offsets), 1)
+	for _, o := range offsets {
+		_, left, unique, right, err := llm.ExtractChunk(text, o)
+		require.NoError(t, err)
+		require.LessOrEqual(t, tok.CountTokens(unique), 56)
+		require.LessOrEqual(t, tok.CountTokens(left), 4)
+		require.LessOrEqual(t, tok.CountTokens(right), 4)
+	}
+}
+
+func TestOpenAIRetry(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"gpt-5-nano","object":"model","created":1754426384,"owned_by":"system"}]}`))
+	})
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		n := calls[r.URL.Path]
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		// rate limited, then overloaded, then served
+		switch n {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"message":"Rate limit reached","type":"requests"}}`))
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"message":"The server is overloaded","type":"server_error"}}`))
+		default:
+			w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gpt-5-nano","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"chat"}}]}`))
+		}
+	})
+	mux.HandleFunc("POST /embeddings", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"Invalid input","type":"invalid_request_error"}}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cli, err := openaiplug.OpenAI(context.Background(),
+		openaiplug.WithAPIKey("my-openai-key"),
+		openaiplug.WithBaseURL(server.URL),
+		openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(openaiplug.FlavorUnknown)),
+		openaiplug.WithRetryPolicy(llm.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    4 * time.Millisecond,
+			Jitter:      0.5,
+		}),
+	)
+	require.NoError(t, err)
+
+	resp, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: []string{"hello"}}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"chat"}, resp.Outputs)
+	require.Equal(t, 3, calls["/chat/completions"])
+
+	// a client error is not retried, neither by the policy nor by the SDK
+	_, err = cli.Embed(context.Background(), &llm.EmbedRequest{
+		Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("hello")},
+	})
+	require.ErrorContains(t, err, "400 Bad Request")
+	require.Equal(t, 1, calls["/embeddings"])
+
+	require.True(t, openaiplug.IsTransient(fmt.Errorf("failed: %w", &openai.Error{StatusCode: http.StatusBadGateway})))
+	require.False(t, openaiplug.IsTransient(&openai.Error{StatusCode: http.StatusUnauthorized}))
+}
+
+func TestOpenAIImageMessage(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G'}
+	wantURL := "data:image/png;base64,iVBORw=="
+
+	var mu sync.Mutex
+	bodies := map[string]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"gpt-5-nano","object":"model","created":1754426384,"owned_by":"system"}]}`))
+	})
+	record := func(r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies[r.URL.Path] = string(body)
+		mu.Unlock()
+	}
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gpt-5-nano","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"a cat"}}]}`))
+	})
+	mux.HandleFunc("POST /responses", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"resp_1","object":"response","status":"completed","output":[{"id":"msg_1","type":"message","role":"assistant","status":"completed","content":[{"type":"output_text","text":"a cat","annotations":[]}]}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	req := &llm.GenerateRequest{
+		Messages: []llm.Message{
+			llm.NewTextMessage(llm.RoleSystem, "Describe the image."),
+			llm.NewMessage(llm.RoleUser,
+				llm.TextPart{Text: "What is in it?"},
+				llm.ImagePart{MIME: "image/png", Data: png},
+			),
+		},
+	}
+
+	for _, flavor := range []openaiplug.ServerFlavor{openaiplug.FlavorUnknown, openaiplug.FlavorOpenAI} {
+		t.Run(string(flavor), func(t *testing.T) {
+			cli, err := openaiplug.OpenAI(context.Background(),
+				openaiplug.WithAPIKey("my-openai-key"),
+				openaiplug.WithBaseURL(server.URL),
+				openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(flavor)),
+			)
+			require.NoError(t, err)
+
+			resp, err := cli.Generate(context.Background(), req)
+			require.NoError(t, err)
+			require.Equal(t, []string{"a cat"}, resp.Outputs)
+
+			_, err = cli.Generate(context.Background(), &llm.GenerateRequest{
+				Messages: []llm.Message{llm.NewMessage(llm.RoleUser, llm.ImagePart{Data: png})},
+			})
+			require.ErrorIs(t, err, llm.ErrInvalidPart)
+		})
+	}
+
+	require.Contains(t, bodies["/chat/completions"], `"image_url":{"url":"`+wantURL+`"`)
+	require.Contains(t, bodies["/chat/completions"], "What is in it?")
+	require.Contains(t, bodies["/responses"], `"image_url":"`+wantURL+`"`)
+	require.Contains(t, bodies["/responses"], `"type":"input_image"`)
+
+	cli := newMockClient(t, "my-openai-key", server.URL)
+	_, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewMessage(llm.RoleSystem, llm.ImagePart{URL: "https://example.com/cat.png"})},
+	})
+	require.ErrorIs(t, err, llm.ErrInvalidPart)
+}
+
+func TestOpenAIGenerationParams(t *testing.T) {
+	var mu sync.Mutex
+	var body map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"gpt-5-nano","object":"model","created":1754426384,"owned_by":"system"}]}`))
+	})
+	record := func(r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	}
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gpt-5-nano","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"ok"}}]}`))
+	})
+	mux.HandleFunc("POST /responses", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"resp_1","object":"response","status":"completed","output":[{"id":"msg_1","type":"message","role":"assistant","status":"completed","content":[{"type":"output_text","text":"ok","annotations":[]}]}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	newClient := func(t *testing.T, flavor openaiplug.ServerFlavor, opts ...openaiplug.Option) *openaiplug.Client {
+		cli, err := openaiplug.OpenAI(context.Background(), append([]openaiplug.Option{
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(flavor)),
+		}, opts...)...)
+		require.NoError(t, err)
+		return cli
+	}
+	newRequest := func() *llm.GenerateRequest {
+		return &llm.GenerateRequest{
+			Messages:    []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+			Temperature: utils.Ptr[float32](0.7),
+			TopP:        utils.Ptr[float32](0.9),
+			MaxTokens:   utils.Ptr(64),
+			Stop:        []string{"END"},
+			Seed:        utils.Ptr[int64](42),
+		}
+	}
+
+	t.Run("chat completions", func(t *testing.T) {
+		_, err := newClient(t, openaiplug.FlavorVLLM).Generate(context.Background(), newRequest())
+		require.NoError(t, err)
+		require.Equal(t, 0.7, body["temperature"])
+		require.Equal(t, 0.9, body["top_p"])
+		require.Equal(t, float64(64), body["max_tokens"])
+		require.Equal(t, []any{"END"}, body["stop"])
+		require.Equal(t, float64(42), body["seed"])
+		require.NotContains(t, body, "max_completion_tokens")
+	})
+
+	t.Run("chat completions of openai", func(t *testing.T) {
+		cli := newClient(t, openaiplug.FlavorOpenAI, openaiplug.UseChatChatCompletions())
+		_, err := cli.Generate(context.Background(), newRequest())
+		require.NoError(t, err)
+		require.Equal(t, float64(64), body["max_completion_tokens"])
+		require.NotContains(t, body, "max_tokens")
+	})
+
+	t.Run("responses", func(t *testing.T) {
+		_, err := newClient(t, openaiplug.FlavorOpenAI).Generate(context.Background(), newRequest())
+		require.NoError(t, err)
+		require.Equal(t, 0.7, body["temperature"])
+		require.Equal(t, 0.9, body["top_p"])
+		require.Equal(t, float64(64), body["max_output_tokens"])
+		require.NotContains(t, body, "stop")
+		require.NotContains(t, body, "seed")
+	})
+
+	t.Run("config overrides", func(t *testing.T) {
+		req := newRequest()
+		req.Config = []option.RequestOption{option.WithJSONSet("temperature", 0.1)}
+		_, err := newClient(t, openaiplug.FlavorVLLM).Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, 0.1, body["temperature"])
+		require.Equal(t, 0.9, body["top_p"])
+	})
+
+	t.Run("unset", func(t *testing.T) {
+		_, err := newClient(t, openaiplug.FlavorVLLM).Generate(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+		})
+		require.NoError(t, err)
+		for _, key := range []string{"temperature", "top_p", "max_tokens", "stop", "seed"} {
+			require.NotContains(t, body, key)
+		}
+	})
+}
+
+func TestOpenAIToolCalls(t *testing.T) {
+	var mu sync.Mutex
+	var body map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"gpt-5-nano","object":"model","created":1754426384,"owned_by":"system"}]}`))
+	})
+	record := func(r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	}
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gpt-5-nano","choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup_party_positions","arguments":"{\"party\":\"DPP\"}"}}]}}]}`))
+	})
+	mux.HandleFunc("POST /responses", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"resp_1","object":"response","status":"completed","output":[{"id":"fc_1","type":"function_call","status":"completed","call_id":"call_1","name":"lookup_party_positions","arguments":"{\"party\":\"DPP\"}"}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tool := llm.ToolDefinition{
+		Name:        "lookup_party_positions",
+		Description: "Look up the positions of a party on the issues.",
+		Parameters: map[string]any{
+			"type":       "object",
+			"required":   []string{"party"},
+			"properties": map[string]any{"party": map[string]any{"type": "string"}},
+		},
+	}
+	call := llm.ToolCall{ID: "call_1", Name: "lookup_party_positions", Arguments: `{"party":"DPP"}`}
+	newRequest := func() *llm.GenerateRequest {
+		return &llm.GenerateRequest{
+			Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "What does the DPP think of 三鶯線?")},
+			Tools:    []llm.ToolDefinition{tool},
+			Schema:   &llm.ResponseSchema{Name: "answer", S: map[string]any{"type": "object"}},
+		}
+	}
+	// the second turn of the conversation, with the result of the call
+	followUp := func() *llm.GenerateRequest {
+		req := newRequest()
+		req.Messages = append(req.Messages,
+			llm.NewToolCallMessage(call),
+			llm.NewToolMessage(call.ID, `{"positions":["support"]}`),
+		)
+		return req
+	}
+
+	newClient := func(t *testing.T, flavor openaiplug.ServerFlavor) *openaiplug.Client {
+		cli, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(flavor)),
+		)
+		require.NoError(t, err)
+		return cli
+	}
+
+	t.Run("chat completions", func(t *testing.T) {
+		cli := newClient(t, openaiplug.FlavorVLLM)
+		resp, err := cli.Generate(context.Background(), newRequest())
+		require.NoError(t, err)
+		require.Equal(t, []llm.ToolCall{call}, resp.ToolCalls)
+		require.NoError(t, resp.SchemaErr)
+
+		tools := body["tools"].([]any)
+		require.Len(t, tools, 1)
+		fn := tools[0].(map[string]any)
+		require.Equal(t, "function", fn["type"])
+		require.Equal(t, "lookup_party_positions", fn["function"].(map[string]any)["name"])
+		require.Equal(t, tool.Description, fn["function"].(map[string]any)["description"])
+		require.Contains(t, fn["function"].(map[string]any)["parameters"], "properties")
+
+		_, err = cli.Generate(context.Background(), followUp())
+		require.NoError(t, err)
+		messages := body["messages"].([]any)
+		require.Len(t, messages, 3)
+		assistant := messages[1].(map[string]any)
+		require.Equal(t, "assistant", assistant["role"])
+		toolCall := assistant["tool_calls"].([]any)[0].(map[string]any)
+		require.Equal(t, "call_1", toolCall["id"])
+		require.Equal(t, `{"party":"DPP"}`, toolCall["function"].(map[string]any)["arguments"])
+		require.Equal(t, map[string]any{
+			"role":         "tool",
+			"tool_call_id": "call_1",
+			"content":      `{"positions":["support"]}`,
+		}, messages[2])
+	})
+
+	t.Run("responses", func(t *testing.T) {
+		cli := newClient(t, openaiplug.FlavorOpenAI)
+		resp, err := cli.Generate(context.Background(), newRequest())
+		require.NoError(t, err)
+		require.Equal(t, []llm.ToolCall{call}, resp.ToolCalls)
+		require.NoError(t, resp.SchemaErr)
+
+		fn := body["tools"].([]any)[0].(map[string]any)
+		require.Equal(t, "function", fn["type"])
+		require.Equal(t, "lookup_party_positions", fn["name"])
+		require.Equal(t, false, fn["strict"])
+
+		_, err = cli.Generate(context.Background(), followUp())
+		require.NoError(t, err)
+		input := body["input"].([]any)
+		require.Len(t, input, 3)
+		require.Equal(t, map[string]any{
+			"type":      "function_call",
+			"call_id":   "call_1",
+			"name":      "lookup_party_positions",
+			"arguments": `{"party":"DPP"}`,
+		}, input[1])
+		require.Equal(t, map[string]any{
+			"type":    "function_call_output",
+			"call_id": "call_1",
+			"output":  `{"positions":["support"]}`,
+		}, input[2])
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, flavor := range []openaiplug.ServerFlavor{openaiplug.FlavorVLLM, openaiplug.FlavorOpenAI} {
+			cli := newClient(t, flavor)
+			req := followUp()
+			req.Messages[2].ToolCallID = ""
+			_, err := cli.Generate(context.Background(), req)
+			require.ErrorIs(t, err, llm.ErrInvalidTool)
+
+			req = newRequest()
+			req.Tools = []llm.ToolDefinition{{Description: "no name"}}
+			_, err = cli.Generate(context.Background(), req)
+			require.ErrorIs(t, err, llm.ErrInvalidTool)
+		}
+
+		_, err := newClient(t, openaiplug.FlavorVLLM).GenerateStream(context.Background(), newRequest())
+		require.ErrorIs(t, err, llm.ErrNotImplemented)
+	})
+}
+
+func TestOpenAIAzure(t *testing.T) {
+	type request struct {
+		Path      string
+		Query     url.Values
+		APIKey    string
+		Bearer    string
+		Model     string
+		MaxTokens any
+	}
+
+	var mu sync.Mutex
+	var reqs []request
+	mux := http.NewServeMux()
+	record := func(r *http.Request) {
+		body := map[string]any{}
+		json.NewDecoder(r.Body).Decode(&body)
+		model, _ := body["model"].(string)
+		mu.Lock()
+		defer mu.Unlock()
+		reqs = append(reqs, request{
+			Path:      r.URL.Path,
+			Query:     r.URL.Query(),
+			APIKey:    r.Header.Get("api-key"),
+			Bearer:    r.Header.Get("Authorization"),
+			Model:     model,
+			MaxTokens: body["max_completion_tokens"],
+		})
+	}
+	mux.HandleFunc("POST /azure/openai/deployments/{deployment}/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.PathValue("deployment") == "missing-deployment" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"DeploymentNotFound","message":"The API deployment for this resource does not exist."}}`))
+			return
+		}
+		record(r)
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gpt-4o-mini","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"ok"}}]}`))
+	})
+	mux.HandleFunc("POST /azure/openai/deployments/{deployment}/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","model":"text-embedding-3-small","data":[{"object":"embedding","index":0,"embedding":[0.5,0.25]}],"usage":{"prompt_tokens":2,"total_tokens":2}}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("OPENAI_API_KEY", "not-for-azure")
+	cli, err := openaiplug.OpenAI(context.Background(),
+		openaiplug.WithAPIKey("my-azure-key"),
+		openaiplug.WithAzure(server.URL+"/azure", "2024-10-21", "chat-deployment"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, openaiplug.FlavorAzure, cli.Caps.Flavor)
+	require.False(t, cli.Caps.ResponsesAPI)
+
+	// the health check generates a single token with the deployment
+	require.Len(t, reqs, 1)
+	require.Equal(t, request{
+		Path:      "/azure/openai/deployments/chat-deployment/chat/completions",
+		Query:     url.Values{"api-version": {"2024-10-21"}},
+		APIKey:    "my-azure-key",
+		Model:     "chat-deployment",
+		MaxTokens: float64(1),
+	}, reqs[0])
+
+	resp, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ok"}, resp.Outputs)
+	require.Equal(t, "/azure/openai/deployments/chat-deployment/chat/completions", reqs[1].Path)
+
+	_, err = cli.Generate(context.Background(), &llm.GenerateRequest{
+		Messages:  []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+		ModelName: "gpt-4o-deployment",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "/azure/openai/deployments/gpt-4o-deployment/chat/completions", reqs[2].Path)
+
+	embed, err := cli.Embed(context.Background(), &llm.EmbedRequest{Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("三鶯線")}})
+	require.NoError(t, err)
+	require.Equal(t, []float32{0.5, 0.25}, embed.Embeddings[0].Values)
+	require.Equal(t, "/azure/openai/deployments/text-embedding-3-small/embeddings", reqs[3].Path)
+	require.Equal(t, "2024-10-21", reqs[3].Query.Get("api-version"))
+	require.Empty(t, reqs[3].Bearer)
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, opt := range []openaiplug.Option{
+			openaiplug.WithAzure(server.URL, "", "chat-deployment"),
+			openaiplug.WithAzure(server.URL, "2024-10-21", ""),
+			openaiplug.WithAzure("my-resource.openai.azure.com", "2024-10-21", "chat-deployment"),
+		} {
+			_, err := openaiplug.OpenAI(context.Background(), openaiplug.WithAPIKey("my-azure-key"), opt)
+			require.Error(t, err)
+		}
+
+		_, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-azure-key"),
+			openaiplug.WithAzure(server.URL+"/azure", "2024-10-21", "missing-deployment"),
+			openaiplug.WithRetryPolicy(llm.RetryPolicy{MaxAttempts: 1}),
+		)
+		require.ErrorIs(t, err, openaiplug.ErrCanNotConnectToServer)
+	})
+}
+
+func TestOpenAIEmbedBatches(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"text-embedding-3-small","object":"model","created":1705948997,"owned_by":"system"}]}`))
+	})
+	// the embedding of an input is its number, an input starting with "fail"
+	// fails its request
+	mux.HandleFunc("POST /embeddings", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input []string `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		batches = append(batches, req.Input)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		data := make([]map[string]any, len(req.Input))
+		for i, input := range req.Input {
+			if strings.HasPrefix(input, "fail") {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":{"message":"invalid input","type":"invalid_request_error","code":null}}`))
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimPrefix(input, "input-"))
+			data[i] = map[string]any{"object": "embedding", "index": i, "embedding": []float64{float64(n)}}
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"object": "list",
+			"model":  "text-embedding-3-small",
+			"data":   data,
+			"usage":  map[string]any{"prompt_tokens": len(req.Input), "total_tokens": len(req.Input)},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	newClient := func(t *testing.T, opts ...openaiplug.Option) *openaiplug.Client {
+		cli, err := openaiplug.OpenAI(context.Background(), append([]openaiplug.Option{
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(openaiplug.FlavorOpenAI)),
+			openaiplug.WithRetryPolicy(llm.RetryPolicy{MaxAttempts: 1}),
+		}, opts...)...)
+		require.NoError(t, err)
+		return cli
+	}
+	inputs := func(texts ...string) []llm.EmbedInput {
+		inputs := make([]llm.EmbedInput, len(texts))
+		for i, text := range texts {
+			inputs[i] = llm.NewSimpleTextInput(text)
+		}
+		return inputs
+	}
+	numbered := func(n int) []string {
+		texts := make([]string, n)
+		for i := range texts {
+			texts[i] = fmt.Sprintf("input-%d", i)
+		}
+		return texts
+	}
+
+	t.Run("split by inputs", func(t *testing.T) {
+		batches = nil
+		cli := newClient(t, openaiplug.WithEmbedBatch(4, 0, 3))
+		require.Equal(t, openaiplug.DefaultEmbedBatchTokens, cli.EmbedBatchTokens)
+
+		resp, err := cli.Embed(context.Background(), &llm.EmbedRequest{Inputs: inputs(numbered(10)...)})
+		require.NoError(t, err)
+		require.Len(t, resp.Embeddings, 10)
+		for i, e := range resp.Embeddings {
+			require.Equal(t, llm.EmbedStateOk, e.State)
+			require.Equal(t, []float32{float32(i)}, e.Values)
+		}
+		require.Equal(t, llm.Usage{PromptTokens: 10, TotalTokens: 10}, resp.Usage)
+		require.Len(t, resp.Raw, 3)
+
+		sizes := []int{}
+		for _, b := range batches {
+			sizes = append(sizes, len(b))
+		}
+		slices.Sort(sizes)
+		require.Equal(t, []int{2, 4, 4}, sizes)
+	})
+
+	t.Run("split by tokens", func(t *testing.T) {
+		batches = nil
+		// an input of 7 runes fills a batch, a longer one is sent alone
+		cli := newClient(t, openaiplug.WithEmbedBatch(0, 7, 1), openaiplug.WithTokenizer(runeTokenizer{}))
+		require.Equal(t, 2048, cli.EmbedBatchInputs)
+
+		texts := append(numbered(3), strings.Repeat("9", 20))
+		_, err := cli.Embed(context.Background(), &llm.EmbedRequest{Inputs: inputs(texts...)})
+		require.NoError(t, err)
+		require.Equal(t, [][]string{{"input-0"}, {"input-1"}, {"input-2"}, {texts[3]}}, batches)
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		texts := numbered(6)
+		texts[3] = "fail-3"
+		resp, err := newClient(t, openaiplug.WithEmbedBatch(2, 0, 2)).Embed(context.Background(),
+			&llm.EmbedRequest{Inputs: inputs(texts...)})
+		require.NoError(t, err)
+		for i, e := range resp.Embeddings {
+			if i == 2 || i == 3 {
+				require.Equal(t, llm.EmbedStateError, e.State)
+				require.Contains(t, e.Error, "invalid input")
+				continue
+			}
+			require.Equal(t, llm.EmbedStateOk, e.State)
+			require.Equal(t, []float32{float32(i)}, e.Values)
+		}
+		require.Equal(t, llm.Usage{PromptTokens: 4, TotalTokens: 4}, resp.Usage)
+	})
+
+	t.Run("every batch failed", func(t *testing.T) {
+		_, err := newClient(t, openaiplug.WithEmbedBatch(1, 0, 1)).Embed(context.Background(),
+			&llm.EmbedRequest{Inputs: inputs("fail-0", "fail-1")})
+		require.ErrorContains(t, err, "failed to generate embeddings")
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		resp, err := newClient(t, openaiplug.WithEmbedBatch(2, 0, 1)).Embed(ctx,
+			&llm.EmbedRequest{Inputs: inputs(numbered(4)...)})
+		require.ErrorIs(t, err, context.Canceled)
+		for _, e := range resp.Embeddings {
+			require.Equal(t, llm.EmbedStateCancelled, e.State)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, opt := range []openaiplug.Option{
+			openaiplug.WithEmbedBatch(-1, 0, 1),
+			openaiplug.WithEmbedBatch(0, -1, 1),
+			openaiplug.WithEmbedBatch(0, 0, 0),
+			openaiplug.WithTokenizer(nil),
+		} {
+			_, err := openaiplug.OpenAI(context.Background(), openaiplug.WithAPIKey("my-openai-key"), opt)
+			require.Error(t, err)
+		}
+	})
+}
+
+// runeTokenizer counts a token per rune.
+type runeTokenizer struct{}
+
+func (runeTokenizer) CountTokens(text string) int { return utf8.RuneCountInString(text) }
+
+func TestOpenAIRetryAfter(t *testing.T) {
+	var mu sync.Mutex
+	var at []time.Time
+	mux := http.NewServeMux()
+	// the models are not permitted, as by some proxies
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":{"message":"Forbidden","type":"forbidden"}}`))
+	})
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		at = append(at, time.Now())
+		n := len(at)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if n%2 == 1 {
+			w.Header().Set("Retry-After-Ms", "300")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"message":"Rate limit reached","type":"requests"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gpt-5-nano","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"chat"}}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	policy := llm.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	newClient := func(t *testing.T, opts ...openaiplug.Option) *openaiplug.Client {
+		cli, err := openaiplug.OpenAI(context.Background(), append([]openaiplug.Option{
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(openaiplug.FlavorVLLM)),
+			openaiplug.WithRetryPolicy(policy),
+			openaiplug.WithoutHealthCheck(),
+		}, opts...)...)
+		require.NoError(t, err)
+		return cli
+	}
+	req := &llm.GenerateRequest{Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")}}
+
+	t.Run("honored", func(t *testing.T) {
+		at = nil
+		resp, err := newClient(t, openaiplug.WithRetryAfter(time.Second)).Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, []string{"chat"}, resp.Outputs)
+		require.Len(t, at, 2)
+		require.GreaterOrEqual(t, at[1].Sub(at[0]), 300*time.Millisecond)
+	})
+
+	t.Run("capped", func(t *testing.T) {
+		at = nil
+		_, err := newClient(t, openaiplug.WithRetryAfter(10*time.Millisecond)).Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.Less(t, at[1].Sub(at[0]), 300*time.Millisecond)
+	})
+
+	t.Run("ignored by default", func(t *testing.T) {
+		at = nil
+		_, err := newClient(t).Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.Less(t, at[1].Sub(at[0]), 300*time.Millisecond)
+	})
+
+	t.Run("health check", func(t *testing.T) {
+		_, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithRetryPolicy(llm.RetryPolicy{MaxAttempts: 1}),
+		)
+		require.ErrorIs(t, err, openaiplug.ErrCanNotConnectToServer)
+
+		// a dead server is not waited for once ctx is done
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err = openaiplug.OpenAI(ctx,
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL("http://127.0.0.1:1"),
+		)
+		require.ErrorIs(t, err, openaiplug.ErrCanNotConnectToServer)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 2*time.Second)
+	})
+
+	_, err := openaiplug.OpenAI(context.Background(), openaiplug.WithAPIKey("my-openai-key"),
+		openaiplug.WithRetryAfter(0))
+	require.Error(t, err)
+}
+
+func TestOpenAIRateLimit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"gpt-5-nano","object":"model","created":1754426384,"owned_by":"system"}]}`))
+	})
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gpt-5-nano","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"ok"}}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	_, err := openaiplug.OpenAI(context.Background(),
+		openaiplug.WithAPIKey("my-openai-key"),
+		openaiplug.WithRateLimit(-1, 1),
+	)
+	require.ErrorContains(t, err, "invalid rate limit")
+
+	cli, err := openaiplug.OpenAI(context.Background(),
+		openaiplug.WithAPIKey("my-openai-key"),
+		openaiplug.WithBaseURL(server.URL),
+		openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(openaiplug.FlavorUnknown)),
+		openaiplug.WithRateLimit(20, 1),
+	)
+	require.NoError(t, err)
+
+	// the goroutines share the limit, the five requests take four intervals
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+				Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+			})
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+
+	_, ok := cli.RateLimitTokens()
+	require.True(t, ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cli.Generate(ctx, &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestOpenAIEmbedDimensions(t *testing.T) {
+	var dims []*int
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /embeddings", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input      []string `json:"input"`
+			Dimensions *int     `json:"dimensions"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		dims = append(dims, req.Dimensions)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"object": "list",
+			"model":  "text-embedding-3-small",
+			"data":   []map[string]any{{"object": "embedding", "index": 0, "embedding": []float64{0.1, 0.2}}},
+			"usage":  map[string]any{"prompt_tokens": 1, "total_tokens": 1},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	newClient := func(t *testing.T, flavor openaiplug.ServerFlavor) *openaiplug.Client {
+		cli, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithoutHealthCheck(),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(flavor)),
+			openaiplug.WithRetryPolicy(llm.RetryPolicy{MaxAttempts: 1}),
+			openaiplug.WithEmbedDim(512),
+		)
+		require.NoError(t, err)
+		return cli
+	}
+	embed := func(cli *openaiplug.Client, model string, dim *int) error {
+		_, err := cli.Embed(context.Background(), &llm.EmbedRequest{
+			Inputs:     []llm.EmbedInput{llm.NewSimpleTextInput("三鶯線")},
+			ModelName:  model,
+			Dimensions: dim,
+		})
+		return err
+	}
+
+	cli := newClient(t, openaiplug.FlavorOpenAI)
+	require.NoError(t, embed(cli, "text-embedding-3-small", nil))
+	require.NoError(t, embed(cli, "text-embedding-3-small", openai.Ptr(256)))
+	require.Len(t, dims, 2)
+	require.Equal(t, 512, *dims[0])
+	require.Equal(t, 256, *dims[1])
+
+	err := embed(cli, "text-embedding-3-small", openai.Ptr(0))
+	require.ErrorIs(t, err, llm.ErrInvalidDimensions)
+	require.ErrorContains(t, err, "should be positive")
+
+	err = embed(cli, openai.EmbeddingModelTextEmbeddingAda002, openai.Ptr(256))
+	require.ErrorIs(t, err, llm.ErrInvalidDimensions)
+	require.ErrorContains(t, err, "text-embedding-ada-002")
+
+	cli = newClient(t, openaiplug.FlavorOllama)
+	require.NoError(t, embed(cli, "nomic-embed-text", nil))
+	require.Len(t, dims, 3)
+	require.Nil(t, dims[2])
+
+	err = embed(cli, "nomic-embed-text", openai.Ptr(256))
+	require.ErrorIs(t, err, llm.ErrInvalidDimensions)
+	require.ErrorContains(t, err, "ollama server")
+	require.Len(t, dims, 3)
+}
+
+func TestOpenAIBatchFileCleanup(t *testing.T) {
+	now := time.Now().Unix()
+	old := now - int64((48 * time.Hour).Seconds())
+
+	var mu sync.Mutex
+	var deleted []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /batches/{batch_id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":%q,"object":"batch","endpoint":"/v1/embeddings","status":"completed",
+			"input_file_id":"file-input","output_file_id":"file-output","error_file_id":"file-error",
+			"created_at":%d,"completed_at":%d}`, r.PathValue("batch_id"), old, now)
+	})
+	mux.HandleFunc("GET /files/{file_id}/content", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"batch_req_1","custom_id":"embed-1755706275-job-0","response":{"status_code":200,` +
+			`"request_id":"req_1","body":{"object":"list","model":"text-embedding-3-small",` +
+			`"data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]}],` +
+			`"usage":{"prompt_tokens":1,"total_tokens":1}}},"error":null}`))
+	})
+	mux.HandleFunc("GET /batches", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"object":"list","has_more":false,"data":[
+			{"id":"batch_running","object":"batch","status":"in_progress","input_file_id":"file-running","created_at":%d},
+			{"id":"batch_done","object":"batch","status":"completed","input_file_id":"file-old-input","created_at":%[1]d}]}`, old)
+	})
+	mux.HandleFunc("GET /files", func(w http.ResponseWriter, r *http.Request) {
+		files := map[string]string{
+			"batch": fmt.Sprintf(`{"id":"file-running","object":"file","purpose":"batch","created_at":%d},
+				{"id":"file-old-input","object":"file","purpose":"batch","created_at":%[1]d},
+				{"id":"file-new-input","object":"file","purpose":"batch","created_at":%d}`, old, now),
+			"batch_output": fmt.Sprintf(`{"id":"file-old-output","object":"file","purpose":"batch_output","created_at":%d},
+				{"id":"file-error","object":"file","purpose":"batch_output","created_at":%[1]d}`, old),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"object":"list","has_more":false,"data":[%s]}`, files[r.URL.Query().Get("purpose")])
+	})
+	mux.HandleFunc("DELETE /files/{file_id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("file_id")
+		w.Header().Set("Content-Type", "application/json")
+		if id == "file-error" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"message":"No such File object: file-error","type":"invalid_request_error"}}`))
+			return
+		}
+		mu.Lock()
+		deleted = append(deleted, id)
+		mu.Unlock()
+		fmt.Fprintf(w, `{"id":%q,"object":"file","deleted":true}`, id)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	newClient := func(t *testing.T, opts ...openaiplug.Option) *openaiplug.Client {
+		cli, err := openaiplug.OpenAI(context.Background(), append([]openaiplug.Option{
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithoutHealthCheck(),
+			openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(openaiplug.FlavorOpenAI)),
+		}, opts...)...)
+		require.NoError(t, err)
+		return cli
+	}
+	retrieve := func(t *testing.T, cli *openaiplug.Client) {
+		resp, err := cli.BatchRetrieve(context.Background(), &llm.BatchRetrieveRequest{ID: "batch_1"})
+		require.NoError(t, err)
+		require.True(t, resp.IsDone)
+		require.Len(t, resp.Parsed, 1)
+	}
+
+	t.Run("none", func(t *testing.T) {
+		deleted = nil
+		retrieve(t, newClient(t))
+		require.Empty(t, deleted)
+	})
+
+	t.Run("input", func(t *testing.T) {
+		deleted = nil
+		retrieve(t, newClient(t, openaiplug.WithBatchFileCleanup(openaiplug.CleanupInput)))
+		require.Equal(t, []string{"file-input"}, deleted)
+	})
+
+	t.Run("all", func(t *testing.T) {
+		deleted = nil
+		// the error file is gone already, which is only logged
+		retrieve(t, newClient(t, openaiplug.WithBatchFileCleanup(openaiplug.CleanupAll)))
+		require.Equal(t, []string{"file-input", "file-output"}, deleted)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBatchFileCleanup(openaiplug.BatchFileCleanup(3)))
+		require.ErrorContains(t, err, "invalid batch file cleanup")
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		deleted = nil
+		n, err := newClient(t).PurgeBatchFiles(context.Background(), 24*time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		require.Equal(t, []string{"file-old-input", "file-old-output"}, deleted)
+	})
+}
+
+func TestOpenAIChatStructuredOutput(t *testing.T) {
+	var bodies []map[string]any
+	reject := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies = append(bodies, body)
+
+		w.Header().Set("Content-Type", "application/json")
+		format := body["response_format"].(map[string]any)
+		if reject && format["type"] == "json_schema" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"'response_format.type' must be 'json_object' or 'text'","type":"invalid_request_error","param":null,"code":null}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gemma3","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"{\"title\":\"三鶯線\"}"}}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	schema := &llm.ResponseSchema{Name: "article", S: map[string]any{
+		"type":       "object",
+		"required":   []string{"title"},
+		"properties": map[string]any{"title": map[string]any{"type": "string"}},
+	}}
+	newClient := func(t *testing.T, caps openaiplug.ServerCaps) *openaiplug.Client {
+		cli, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithoutHealthCheck(),
+			openaiplug.WithServerCaps(caps),
+			openaiplug.WithRetryPolicy(llm.RetryPolicy{MaxAttempts: 1}),
+			openaiplug.UseChatChatCompletions(),
+		)
+		require.NoError(t, err)
+		return cli
+	}
+	generate := func(t *testing.T, cli *openaiplug.Client) {
+		resp, err := cli.Generate(context.Background(), &llm.GenerateRequest{
+			Messages: []llm.Message{
+				llm.NewTextMessage(llm.RoleSystem, "Extract the title"),
+				llm.NewTextMessage(llm.RoleUser, "三鶯線 opens"),
+			},
+			ModelName: "gemma3",
+			Schema:    schema,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{`{"title":"三鶯線"}`}, resp.Outputs)
+		require.NoError(t, resp.SchemaErr)
+	}
+	requireJSONObject := func(t *testing.T, body map[string]any) {
+		require.Equal(t, map[string]any{"type": "json_object"}, body["response_format"])
+		messages := body["messages"].([]any)
+		require.Len(t, messages, 3)
+		first := messages[0].(map[string]any)
+		require.Equal(t, "system", first["role"])
+		require.Contains(t, first["content"], "matching the following JSON schema")
+		require.Contains(t, first["content"], `"required":["title"]`)
+	}
+
+	t.Run("json schema", func(t *testing.T) {
+		bodies = nil
+		generate(t, newClient(t, openaiplug.DefaultServerCaps(openaiplug.FlavorLlamaCpp)))
+		require.Len(t, bodies, 1)
+		format := bodies[0]["response_format"].(map[string]any)
+		require.Equal(t, "json_schema", format["type"])
+		require.Equal(t, "article", format["json_schema"].(map[string]any)["name"])
+		require.Len(t, bodies[0]["messages"], 2)
+	})
+
+	t.Run("json object", func(t *testing.T) {
+		bodies = nil
+		generate(t, newClient(t, openaiplug.ServerCaps{Flavor: openaiplug.FlavorUnknown}))
+		require.Len(t, bodies, 1)
+		requireJSONObject(t, bodies[0])
+	})
+
+	t.Run("json schema rejected", func(t *testing.T) {
+		bodies, reject = nil, true
+		defer func() { reject = false }()
+
+		cli := newClient(t, openaiplug.DefaultServerCaps(openaiplug.FlavorLlamaCpp))
+		generate(t, cli)
+		require.Len(t, bodies, 2)
+		require.Equal(t, "json_schema", bodies[0]["response_format"].(map[string]any)["type"])
+		requireJSONObject(t, bodies[1])
+
+		// the client remembers the server rejects json_schema
+		generate(t, cli)
+		require.Len(t, bodies, 3)
+		requireJSONObject(t, bodies[2])
+	})
+}
+
+func TestOpenAIOrganizationAndUser(t *testing.T) {
+	var header http.Header
+	var body map[string]any
+	record := func(r *http.Request) {
+		header = r.Header.Clone()
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gpt-5-nano","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"ok"}}]}`))
+	})
+	mux.HandleFunc("POST /responses", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"resp_1","object":"response","status":"completed","output":[{"id":"msg_1","type":"message","role":"assistant","status":"completed","content":[{"type":"output_text","text":"ok","annotations":[]}]}]}`))
+	})
+	mux.HandleFunc("POST /embeddings", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","model":"text-embedding-3-small","data":[{"object":"embedding","index":0,"embedding":[0.1]}],"usage":{"prompt_tokens":1,"total_tokens":1}}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	newClient := func(t *testing.T, opts ...openaiplug.Option) *openaiplug.Client {
+		cli, err := openaiplug.OpenAI(context.Background(), append([]openaiplug.Option{
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithoutHealthCheck(),
+			openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(openaiplug.FlavorOpenAI)),
+			openaiplug.WithOrganization("org-weathercock"),
+			openaiplug.WithProject("proj_keywords"),
+		}, opts...)...)
+		require.NoError(t, err)
+		return cli
+	}
+	user := "0198c6b2-3f5e-7c1a-9d2e-5b8f4a6c1e20"
+	generate := &llm.GenerateRequest{
+		Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")},
+		User:     user,
+	}
+	requireAttributed := func(t *testing.T) {
+		require.Equal(t, "org-weathercock", header.Get("OpenAI-Organization"))
+		require.Equal(t, "proj_keywords", header.Get("OpenAI-Project"))
+		require.Equal(t, user, body["user"])
+	}
+
+	t.Run("responses", func(t *testing.T) {
+		_, err := newClient(t).Generate(context.Background(), generate)
+		require.NoError(t, err)
+		requireAttributed(t)
+	})
+
+	t.Run("chat completions", func(t *testing.T) {
+		_, err := newClient(t, openaiplug.UseChatChatCompletions()).Generate(context.Background(), generate)
+		require.NoError(t, err)
+		requireAttributed(t)
+	})
+
+	t.Run("embeddings", func(t *testing.T) {
+		_, err := newClient(t).Embed(context.Background(), &llm.EmbedRequest{
+			Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("三鶯線")},
+			User:   user,
+		})
+		require.NoError(t, err)
+		requireAttributed(t)
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		_, err := newClient(t).Generate(context.Background(), &llm.GenerateRequest{
+			Messages: generate.Messages,
+		})
+		require.NoError(t, err)
+		require.NotContains(t, body, "user")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := openaiplug.OpenAI(context.Background(), openaiplug.WithOrganization(""))
+		require.ErrorContains(t, err, "organization should not be empty")
+		_, err = openaiplug.OpenAI(context.Background(), openaiplug.WithProject(""))
+		require.ErrorContains(t, err, "project should not be empty")
+	})
+}
+
+func TestOpenAILogprobs(t *testing.T) {
+	var body map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gpt-5-nano",
+			"choices":[{"index":0,"finish_reason":"length","message":{"role":"assistant","content":"support"},
+			"logprobs":{"content":[{"token":"support","logprob":-0.1,"bytes":null,"top_logprobs":[
+				{"token":"support","logprob":-0.1,"bytes":null},{"token":"oppose","logprob":-2.4,"bytes":null}]}],"refusal":null}}]}`))
+	})
+	mux.HandleFunc("POST /responses", func(w http.ResponseWriter, r *http.Request) {
+		body = map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"resp_1","object":"response","status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},
+			"output":[{"id":"msg_1","type":"message","role":"assistant","status":"incomplete","content":[{"type":"output_text","text":"supp","annotations":[]}]}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	newClient := func(t *testing.T, opts ...openaiplug.Option) *openaiplug.Client {
+		cli, err := openaiplug.OpenAI(context.Background(), append([]openaiplug.Option{
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithoutHealthCheck(),
+			openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(openaiplug.FlavorOpenAI)),
+		}, opts...)...)
+		require.NoError(t, err)
+		return cli
+	}
+	req := &llm.GenerateRequest{
+		Messages:  []llm.Message{llm.NewTextMessage(llm.RoleUser, "Does the DPP support 三鶯線?")},
+		MaxTokens: utils.Ptr(1),
+	}
+
+	t.Run("chat completions", func(t *testing.T) {
+		cli := newClient(t, openaiplug.UseChatChatCompletions(), openaiplug.WithLogprobs(2))
+		resp, err := cli.Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, true, body["logprobs"])
+		require.Equal(t, float64(2), body["top_logprobs"])
+
+		require.Equal(t, llm.FinishReasonLength, resp.FinishReason)
+		require.True(t, resp.Truncated())
+		require.Equal(t, []llm.TokenLogprob{{
+			Token:   "support",
+			Logprob: -0.1,
+			Alternatives: []llm.TokenLogprob{
+				{Token: "support", Logprob: -0.1},
+				{Token: "oppose", Logprob: -2.4},
+			},
+		}}, resp.Logprobs)
+	})
+
+	t.Run("without logprobs", func(t *testing.T) {
+		_, err := newClient(t, openaiplug.UseChatChatCompletions()).Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.NotContains(t, body, "logprobs")
+		require.NotContains(t, body, "top_logprobs")
+	})
+
+	t.Run("responses", func(t *testing.T) {
+		resp, err := newClient(t, openaiplug.WithLogprobs(2)).Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.NotContains(t, body, "top_logprobs")
+		require.Equal(t, llm.FinishReasonLength, resp.FinishReason)
+		require.Nil(t, resp.Logprobs)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := openaiplug.OpenAI(context.Background(), openaiplug.WithLogprobs(21))
+		require.ErrorContains(t, err, "between 0 and 20")
+	})
+}
+
+// countingTransport counts the requests it sends with http.DefaultTransport.
+type countingTransport struct {
+	n atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOpenAIProxy(t *testing.T) {
+	proxy := llmtest.NewProxy()
+	defer proxy.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"gpt-5-nano","object":"model","created":1754426384,"owned_by":"system"}]}`))
+	})
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1754426384,"model":"gpt-5-nano","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"ok"}}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	req := &llm.GenerateRequest{Messages: []llm.Message{llm.NewTextMessage(llm.RoleUser, "hello")}}
+
+	t.Run("proxy", func(t *testing.T) {
+		cli, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.UseChatChatCompletions(),
+			openaiplug.WithHTTPClient(&http.Client{}),
+			openaiplug.WithProxy(proxy.ProxyURL("weathercock", "s3cret")),
+		)
+		require.NoError(t, err)
+		_, err = cli.Generate(context.Background(), req)
+		require.NoError(t, err)
+
+		var targets []string
+		for _, r := range proxy.Requests() {
+			require.Equal(t, "Basic d2VhdGhlcmNvY2s6czNjcmV0", r.Auth)
+			targets = append(targets, r.Method+" "+r.Target)
+		}
+		// the health check and the probe of the capabilities go through the
+		// proxy too
+		require.Contains(t, targets, "GET "+server.URL+"/models")
+		require.Contains(t, targets, "POST "+server.URL+"/chat/completions")
+	})
+
+	t.Run("transport", func(t *testing.T) {
+		transport := &countingTransport{}
+		cli, err := openaiplug.OpenAI(context.Background(),
+			openaiplug.WithAPIKey("my-openai-key"),
+			openaiplug.WithBaseURL(server.URL),
+			openaiplug.WithServerCaps(openaiplug.DefaultServerCaps(openaiplug.FlavorOpenAI)),
+			openaiplug.UseChatChatCompletions(),
+			openaiplug.WithTransport(transport),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, transport.n.Load())
+
+		_, err = cli.Generate(context.Background(), req)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, transport.n.Load())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := openaiplug.OpenAI(context.Background(), openaiplug.WithProxy("ftp://proxy.internal"))
+		require.ErrorIs(t, err, llm.ErrInvalidProxy)
+		_, err = openaiplug.OpenAI(context.Background(), openaiplug.WithTransport(nil))
+		require.ErrorContains(t, err, "transport should not be nil")
+	})
+}
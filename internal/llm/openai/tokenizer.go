@@ -0,0 +1,48 @@
+package openai
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// bpeLoaderOnce installs the loader of the encodings embedded in the binary,
+// so that no encoding is downloaded at run time.
+var bpeLoaderOnce sync.Once
+
+// Tokenizer counts and encodes the tokens of a text with the tiktoken encoding
+// of an OpenAI model. It is safe for concurrent use.
+type Tokenizer struct {
+	enc *tiktoken.Tiktoken
+}
+
+var _ llm.TokenEncoder = (*Tokenizer)(nil)
+
+// NewTokenizer returns the tokenizer of the OpenAI model. It fails for a model
+// without a known tiktoken encoding, e.g. one served by another provider, for
+// which llm.ApproxTokenizer can be used instead.
+func NewTokenizer(model string) (*Tokenizer, error) {
+	bpeLoaderOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	})
+
+	enc, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the tokenizer of %s: %w", model, err)
+	}
+	return &Tokenizer{enc: enc}, nil
+}
+
+// Encode returns the IDs of the tokens of the text. The special tokens, e.g.
+// <|endoftext|>, are encoded as plain text.
+func (t *Tokenizer) Encode(text string) []int {
+	return t.enc.EncodeOrdinary(text)
+}
+
+// CountTokens returns the number of tokens of the text.
+func (t *Tokenizer) CountTokens(text string) int {
+	return len(t.Encode(text))
+}
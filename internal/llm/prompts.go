@@ -0,0 +1,192 @@
+package llm
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// PromptStore holds system prompts by name. The variant of a prompt for a
+// language is named after the prompt and the language tag, e.g. keyword.en,
+// while the prompt without a tag is the fallback of every language.
+type PromptStore struct {
+	prompts map[string]string
+}
+
+// NewPromptStore creates a PromptStore from prompts keyed by name.
+func NewPromptStore(prompts map[string]string) *PromptStore {
+	store := &PromptStore{prompts: make(map[string]string, len(prompts))}
+	for name, prompt := range prompts {
+		store.prompts[name] = prompt
+	}
+	return store
+}
+
+// LoadPromptStore loads the *.txt files of dir, each prompt is named after its
+// file name without the extension.
+func LoadPromptStore(dir string) (*PromptStore, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt dir: %w", err)
+	}
+
+	prompts := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".txt" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt %s: %w", entry.Name(), err)
+		}
+		prompts[strings.TrimSuffix(entry.Name(), ".txt")] = string(data)
+	}
+	return &PromptStore{prompts: prompts}, nil
+}
+
+// LanguageFallbacks returns lang followed by its prefixes, the most specific
+// first, e.g. zh-Hant-TW, zh-Hant, zh.
+func LanguageFallbacks(lang string) []string {
+	if lang == "" {
+		return nil
+	}
+
+	subtags := strings.Split(lang, "-")
+	tags := make([]string, 0, len(subtags))
+	for i := len(subtags); i > 0; i-- {
+		tags = append(tags, strings.Join(subtags[:i], "-"))
+	}
+	return tags
+}
+
+// Lookup returns the variant of the named prompt for lang, following the
+// fallback chain of lang and then the prompt without a language tag. key is
+// the name of the prompt found.
+func (s *PromptStore) Lookup(name, lang string) (prompt, key string, ok bool) {
+	for _, tag := range LanguageFallbacks(lang) {
+		key = name + "." + tag
+		if prompt, ok = s.prompts[key]; ok {
+			return prompt, key, true
+		}
+	}
+
+	prompt, ok = s.prompts[name]
+	if !ok {
+		return "", "", false
+	}
+	return prompt, name, true
+}
+
+// PromptLibrary holds parsed prompt templates by name, named like the prompts
+// of a PromptStore.
+type PromptLibrary struct {
+	factories map[string]*PromptTemplateFactory
+}
+
+// NewPromptLibrary parses templates keyed by name into a PromptLibrary, with
+// opts applied to every template.
+func NewPromptLibrary(templates map[string]string, opts ...PromptTemplateOption) (*PromptLibrary, error) {
+	lib := &PromptLibrary{factories: make(map[string]*PromptTemplateFactory, len(templates))}
+	for _, name := range slices.Sorted(maps.Keys(templates)) {
+		factory, err := NewPromptTemplateFactory(templates[name], opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse prompt %s: %w", name, err)
+		}
+		lib.factories[name] = factory
+	}
+	return lib, nil
+}
+
+// LoadPromptLibrary walks dir for *.tmpl and *.txt files and parses each into
+// a template named after its path relative to dir without the extension, e.g.
+// stance/summary for stance/summary.tmpl.
+func LoadPromptLibrary(dir string, opts ...PromptTemplateOption) (*PromptLibrary, error) {
+	templates := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if d.IsDir() || (ext != ".tmpl" && ext != ".txt") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimSuffix(rel, ext))
+		if _, ok := templates[name]; ok {
+			return fmt.Errorf("duplicated prompt %s: %s", name, rel)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read prompt %s: %w", rel, err)
+		}
+		templates[name] = string(data)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load prompt dir: %w", err)
+	}
+	return NewPromptLibrary(templates, opts...)
+}
+
+// Names returns the sorted names of the templates.
+func (l *PromptLibrary) Names() []string {
+	return slices.Sorted(maps.Keys(l.factories))
+}
+
+// Get returns the named template.
+func (l *PromptLibrary) Get(name string) (*PromptTemplateFactory, bool) {
+	factory, ok := l.factories[name]
+	return factory, ok
+}
+
+// Lookup returns the variant of the named template for lang, falling back the
+// way PromptStore.Lookup does. key is the name of the template found.
+func (l *PromptLibrary) Lookup(name, lang string) (factory *PromptTemplateFactory, key string, ok bool) {
+	for _, tag := range LanguageFallbacks(lang) {
+		key = name + "." + tag
+		if factory, ok = l.factories[key]; ok {
+			return factory, key, true
+		}
+	}
+
+	factory, ok = l.factories[name]
+	if !ok {
+		return nil, "", false
+	}
+	return factory, name, true
+}
+
+// Render renders the named template with vars. The variables the template
+// needs but vars lacks are all listed in a MissingPromptVarsError.
+func (l *PromptLibrary) Render(name string, vars map[string]any) (string, error) {
+	factory, ok := l.factories[name]
+	if !ok {
+		return "", fmt.Errorf("prompt %s not found", name)
+	}
+
+	s, err := factory.NewPromptTemplate(vars).Render()
+	if err != nil {
+		return "", fmt.Errorf("failed to render prompt %s: %w", name, err)
+	}
+	return s, nil
+}
+
+// MustRender is like Render but panics if the template fails to render, for
+// the templates and variables known to be valid.
+func (l *PromptLibrary) MustRender(name string, vars map[string]any) string {
+	s, err := l.Render(name, vars)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
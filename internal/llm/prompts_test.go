@@ -0,0 +1,99 @@
+package llm_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPromptLibrary(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"keyword.txt":          "Extract the keywords.",
+		"keyword.en.txt":       "Extract the English keywords.",
+		"stance/summary.tmpl":  "Summarize the stance of {{.party}} on {{.topic}}.",
+		"title.tmpl":           "Title: {{.title}}{{range .tags}} #{{.Name}}{{end}}",
+		"notes.md":             "not a prompt",
+		"stance/README":        "not a prompt either",
+		"stance/nested/x.tmpl": "{{$.depth}}",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	lib, err := llm.LoadPromptLibrary(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"keyword", "keyword.en", "stance/nested/x", "stance/summary", "title"}, lib.Names())
+
+	factory, ok := lib.Get("stance/summary")
+	require.True(t, ok)
+	require.Equal(t, []string{"party", "topic"}, factory.Vars())
+	_, ok = lib.Get("notes")
+	require.False(t, ok)
+
+	factory, ok = lib.Get("title")
+	require.True(t, ok)
+	require.Equal(t, []string{"tags", "title"}, factory.Vars())
+
+	_, key, ok := lib.Lookup("keyword", "en-US")
+	require.True(t, ok)
+	require.Equal(t, "keyword.en", key)
+	_, key, _ = lib.Lookup("keyword", "zh-Hant")
+	require.Equal(t, "keyword", key)
+
+	t.Run("render", func(t *testing.T) {
+		got, err := lib.Render("stance/summary", map[string]any{"party": "DPP", "topic": "三鶯線"})
+		require.NoError(t, err)
+		require.Equal(t, "Summarize the stance of DPP on 三鶯線.", got)
+		require.Equal(t, "Extract the keywords.", lib.MustRender("keyword", nil))
+	})
+
+	t.Run("missing variables", func(t *testing.T) {
+		_, err := lib.Render("stance/summary", map[string]any{})
+		require.ErrorIs(t, err, llm.ErrMissingPromptVar)
+		require.ErrorContains(t, err, "stance/summary")
+		require.ErrorContains(t, err, "missing required prompt variables: party, topic")
+
+		var missing *llm.MissingPromptVarsError
+		require.ErrorAs(t, err, &missing)
+		require.Equal(t, []string{"party", "topic"}, missing.Names)
+
+		_, err = lib.Render("stance/nested/x", nil)
+		require.ErrorContains(t, err, "missing required prompt variable: depth")
+
+		require.Panics(t, func() { lib.MustRender("stance/summary", map[string]any{"party": "KMT"}) })
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := lib.Render("stance", nil)
+		require.ErrorContains(t, err, "prompt stance not found")
+	})
+
+	t.Run("malformed template", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.tmpl"), []byte("{{.x"), 0o644))
+		_, err := llm.LoadPromptLibrary(dir)
+		require.ErrorContains(t, err, "failed to parse prompt broken")
+	})
+
+	t.Run("duplicated name", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{"title.txt", "title.tmpl"} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("title"), 0o644))
+		}
+		_, err := llm.LoadPromptLibrary(dir)
+		require.ErrorContains(t, err, "duplicated prompt title")
+	})
+}
+
+func TestLoadPromptLibraryRepoPrompts(t *testing.T) {
+	lib, err := llm.LoadPromptLibrary(filepath.Join("..", "..", "prompt"))
+	require.NoError(t, err)
+
+	_, ok := lib.Get("keyword")
+	require.True(t, ok)
+}
@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+)
+
+var ErrUnknownProvider = errors.New("unknown llm provider")
+
+// UnknownProviderError is returned by NewFromConfig for a provider which is
+// not registered.
+type UnknownProviderError struct {
+	Provider  string
+	Supported []string
+}
+
+func (e *UnknownProviderError) Error() string {
+	return fmt.Sprintf("%s: %q, supported: %s", ErrUnknownProvider, e.Provider, strings.Join(e.Supported, ", "))
+}
+
+func (e *UnknownProviderError) Unwrap() error {
+	return ErrUnknownProvider
+}
+
+// ProviderFactory creates the client of a provider from the LLM configuration.
+type ProviderFactory func(ctx context.Context, cfg global.LLMConfig) (LLM, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ProviderFactory{}
+)
+
+// RegisterProvider makes the provider of name available to NewFromConfig. It
+// is called by the init function of the provider packages, so a binary
+// imports the packages of the providers it supports, e.g.
+//
+//	import _ "github.com/ChiaYuChang/weathercock/internal/llm/ollama"
+//
+// It panics if factory is nil or name is registered twice.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if factory == nil {
+		panic("llm: nil factory of provider " + name)
+	}
+	if _, ok := providers[name]; ok {
+		panic("llm: provider " + name + " registered twice")
+	}
+	providers[name] = factory
+}
+
+// Providers returns the names of the registered providers, sorted.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	return slices.Sorted(maps.Keys(providers))
+}
+
+// NewFromConfig creates the client of the provider selected by cfg.Provider.
+// The error is an *UnknownProviderError if the provider is not registered.
+func NewFromConfig(ctx context.Context, cfg global.LLMConfig) (LLM, error) {
+	providersMu.RLock()
+	factory, ok := providers[cfg.Provider]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, &UnknownProviderError{Provider: cfg.Provider, Supported: Providers()}
+	}
+
+	cli, err := factory(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s client: %w", cfg.Provider, err)
+	}
+	return cli, nil
+}
@@ -0,0 +1,585 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/invopop/jsonschema"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// RepairAction is a fix RepairJSON applied to a structured output.
+type RepairAction string
+
+const (
+	// RepairStripFences removes the markdown code fences around the JSON.
+	RepairStripFences RepairAction = "strip_fences"
+	// RepairStripProse removes the text before and after the JSON.
+	RepairStripProse RepairAction = "strip_prose"
+	// RepairSmartQuotes replaces the typographic quotes delimiting the
+	// strings with double quotes.
+	RepairSmartQuotes RepairAction = "smart_quotes"
+	// RepairSingleQuotes replaces the single quotes delimiting the strings
+	// with double quotes.
+	RepairSingleQuotes RepairAction = "single_quotes"
+	// RepairTrailingCommas removes the commas before a closing bracket.
+	RepairTrailingCommas RepairAction = "trailing_commas"
+	// RepairControlChars escapes the control characters inside the strings.
+	RepairControlChars RepairAction = "control_chars"
+	// RepairCloseBrackets closes the string and the brackets left open by a
+	// truncated output.
+	RepairCloseBrackets RepairAction = "close_brackets"
+	// RepairCoerceTypes converts the strings holding a number or a boolean
+	// where the schema asks for one.
+	RepairCoerceTypes RepairAction = "coerce_types"
+)
+
+var (
+	ErrUnrepairableJSON = errors.New("unrepairable json")
+	ErrSchemaViolation  = errors.New("json does not match the schema")
+)
+
+// SchemaViolationError reports the first value of a structured output which
+// does not match the schema. It wraps ErrSchemaViolation.
+type SchemaViolationError struct {
+	// Path is the path of the value from the root $, e.g. $.relations[2].source.
+	Path   string
+	Reason string
+}
+
+func (e *SchemaViolationError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrSchemaViolation, e.Path, e.Reason)
+}
+
+func (e *SchemaViolationError) Unwrap() error {
+	return ErrSchemaViolation
+}
+
+var (
+	jsonRepairsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llm_json_repairs_total",
+		Help: "Number of structured outputs repaired, by repair action.",
+	}, []string{"action"})
+	jsonRepairFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llm_json_repair_failures_total",
+		Help: "Number of structured outputs which could not be repaired, by reason.",
+	}, []string{"reason"})
+)
+
+// RepairJSON repairs the common defects of a structured output and checks it
+// against schema, if not nil. The repairs are conservative: they only remove
+// what is around the JSON, normalize the quotes and the commas, escape the
+// control characters, and append the closers of a truncated output. A key or
+// a value is never made up, so that an output missing one stays broken.
+//
+// It returns the repaired JSON and the actions applied, in the order they were
+// first applied. If the output cannot be repaired the error wraps
+// ErrUnrepairableJSON and the JSON is empty. If it is valid JSON but does not
+// match the schema the error wraps ErrSchemaViolation and the JSON is the
+// repaired one, without the type coercions. A coerced output is encoded again,
+// with the keys of its objects sorted.
+func RepairJSON(raw string, schema *jsonschema.Schema) (string, []RepairAction, error) {
+	r := &repairer{}
+	s := r.stripFences(raw)
+
+	opener := "{["
+	if schema != nil {
+		switch schema.Type {
+		case "object":
+			opener = "{"
+		case "array":
+			opener = "["
+		}
+	}
+
+	s, err := r.scan(s, opener)
+	if err != nil {
+		jsonRepairFailuresTotal.WithLabelValues("syntax").Inc()
+		return "", r.actions, err
+	}
+
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		jsonRepairFailuresTotal.WithLabelValues("syntax").Inc()
+		return "", r.actions, fmt.Errorf("%w: %w", ErrUnrepairableJSON, err)
+	}
+
+	if schema != nil {
+		var coerced bool
+		if v, coerced, err = conform(v, schema, "$", true); err != nil {
+			jsonRepairFailuresTotal.WithLabelValues("schema").Inc()
+			return s, r.actions, err
+		}
+
+		if coerced {
+			buf := &bytes.Buffer{}
+			enc := json.NewEncoder(buf)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(v); err != nil {
+				return s, r.actions, fmt.Errorf("failed to encode the coerced json: %w", err)
+			}
+			s = strings.TrimSuffix(buf.String(), "\n")
+			r.add(RepairCoerceTypes)
+		}
+	}
+
+	for _, a := range r.actions {
+		jsonRepairsTotal.WithLabelValues(string(a)).Inc()
+	}
+	return s, r.actions, nil
+}
+
+// RepairOutput repairs the structured output of a request with schema. It
+// returns output itself if it cannot be repaired, so that the caller fails on
+// it as it would have without the repair, and the syntactically repaired JSON
+// if only the schema check fails.
+func RepairOutput(output string, schema *ResponseSchema) string {
+	repaired, actions, err := RepairJSON(output, schema.JSONSchema())
+	switch {
+	case errors.Is(err, ErrSchemaViolation):
+		global.Logger.Warn().
+			Err(err).
+			Str("schema", schema.Name).
+			Strs("actions", repairActionStrings(actions)).
+			Msg("structured output does not match the schema")
+		return repaired
+	case err != nil:
+		global.Logger.Warn().
+			Err(err).
+			Str("schema", schema.Name).
+			Msg("failed to repair structured output")
+		return output
+	case len(actions) > 0:
+		global.Logger.Debug().
+			Str("schema", schema.Name).
+			Strs("actions", repairActionStrings(actions)).
+			Msg("structured output repaired")
+	}
+	return repaired
+}
+
+// ValidateOutput checks the structured output of a request against schema,
+// the required properties, the types, the enums and the additional
+// properties, without repairing or coercing anything. It returns nil if the
+// output matches or there is no schema to check, and a *SchemaViolationError
+// with the path of the first mismatch otherwise.
+func ValidateOutput(output string, schema *ResponseSchema) error {
+	js := schema.JSONSchema()
+	if js == nil {
+		return nil
+	}
+
+	dec := json.NewDecoder(strings.NewReader(output))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return &SchemaViolationError{Path: "$", Reason: fmt.Sprintf("invalid json: %s", err)}
+	}
+	if dec.More() {
+		return &SchemaViolationError{Path: "$", Reason: "trailing data after the json"}
+	}
+
+	_, _, err := conform(v, js, "$", false)
+	return err
+}
+
+// JSONSchema returns S as a *jsonschema.Schema, converting it through its JSON
+// encoding if it is of another type, e.g. a map. It returns nil if there is no
+// schema or S does not convert to one.
+func (s *ResponseSchema) JSONSchema() *jsonschema.Schema {
+	if s == nil || s.S == nil {
+		return nil
+	}
+
+	switch v := s.S.(type) {
+	case *jsonschema.Schema:
+		return v
+	case jsonschema.Schema:
+		return &v
+	}
+
+	bs, err := json.Marshal(s.S)
+	if err != nil {
+		return nil
+	}
+
+	schema := &jsonschema.Schema{}
+	if err := json.Unmarshal(bs, schema); err != nil {
+		return nil
+	}
+	return schema
+}
+
+func repairActionStrings(actions []RepairAction) []string {
+	ss := make([]string, len(actions))
+	for i, a := range actions {
+		ss[i] = string(a)
+	}
+	return ss
+}
+
+// repairer keeps the actions applied while repairing an output.
+type repairer struct {
+	actions []RepairAction
+}
+
+func (r *repairer) add(a RepairAction) {
+	if !slices.Contains(r.actions, a) {
+		r.actions = append(r.actions, a)
+	}
+}
+
+// stripFences returns the content of the first code fence of s, if the fence
+// comes before the JSON. The closing fence may be missing if the output was
+// truncated.
+func (r *repairer) stripFences(s string) string {
+	start := strings.Index(s, "```")
+	if start == -1 {
+		return s
+	}
+
+	if i := strings.IndexAny(s, "{["); i != -1 && i < start {
+		return s
+	}
+
+	body := s[start+3:]
+	// the info string, e.g. json
+	if nl := strings.IndexByte(body, '\n'); nl != -1 {
+		if info := strings.TrimSpace(body[:nl]); !strings.ContainsAny(info, "{[") {
+			body = body[nl+1:]
+		}
+	}
+
+	if end := strings.Index(body, "```"); end != -1 {
+		body = body[:end]
+	}
+	r.add(RepairStripFences)
+	return body
+}
+
+// scan rewrites the first JSON object or array of s, starting at one of the
+// opener characters, and drops what is around it.
+func (r *repairer) scan(s, opener string) (string, error) {
+	start := strings.IndexAny(s, opener)
+	if start == -1 {
+		return "", fmt.Errorf("%w: no json found", ErrUnrepairableJSON)
+	}
+
+	if strings.TrimSpace(s[:start]) != "" {
+		r.add(RepairStripProse)
+	}
+
+	var (
+		out     = make([]byte, 0, len(s))
+		stack   []byte
+		quote   rune // the closing quote of the current string, 0 outside of strings
+		escaped bool
+		end     = -1
+	)
+
+	for i := start; i < len(s) && end == -1; {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		next := i + size
+
+		if quote == 0 {
+			switch c {
+			case '"':
+				quote = '"'
+				out = append(out, '"')
+			case '\'':
+				quote = '\''
+				out = append(out, '"')
+				r.add(RepairSingleQuotes)
+			case '“', '”':
+				quote = '”'
+				out = append(out, '"')
+				r.add(RepairSmartQuotes)
+			case '‘', '’':
+				quote = '’'
+				out = append(out, '"')
+				r.add(RepairSmartQuotes)
+			case '{':
+				stack = append(stack, '}')
+				out = append(out, '{')
+			case '[':
+				stack = append(stack, ']')
+				out = append(out, '[')
+			case '}', ']':
+				if len(stack) == 0 || stack[len(stack)-1] != byte(c) {
+					return "", fmt.Errorf("%w: unexpected %q at offset %d", ErrUnrepairableJSON, c, i)
+				}
+				out = r.trimTrailingComma(out)
+				out = append(out, byte(c))
+				stack = stack[:len(stack)-1]
+				if len(stack) == 0 {
+					end = next
+				}
+			default:
+				out = append(out, s[i:next]...)
+			}
+			i = next
+			continue
+		}
+
+		switch {
+		case escaped:
+			escaped = false
+			if quote == '\'' && c == '\'' {
+				// \' is not a valid escape in JSON
+				out = append(out[:len(out)-1], '\'')
+			} else {
+				out = append(out, s[i:next]...)
+			}
+		case c == '\\':
+			escaped = true
+			out = append(out, '\\')
+		case c == quote && (quote == '"' || closesString(s[next:])):
+			quote = 0
+			out = append(out, '"')
+		case c == '"':
+			// a double quote inside a string delimited by other quotes
+			out = append(out, '\\', '"')
+		case c < 0x20:
+			out = appendEscapedControl(out, c)
+			r.add(RepairControlChars)
+		default:
+			out = append(out, s[i:next]...)
+		}
+		i = next
+	}
+
+	if end == -1 {
+		// truncated output
+		if quote != 0 {
+			if escaped {
+				out = out[:len(out)-1]
+			}
+			out = append(out, '"')
+		}
+
+		for j := len(stack) - 1; j >= 0; j-- {
+			out = r.trimTrailingComma(out)
+			out = append(out, stack[j])
+		}
+		r.add(RepairCloseBrackets)
+	} else if strings.TrimSpace(s[end:]) != "" {
+		r.add(RepairStripProse)
+	}
+	return string(out), nil
+}
+
+// trimTrailingComma removes the comma, if any, ending out but for whitespace.
+func (r *repairer) trimTrailingComma(out []byte) []byte {
+	i := len(out) - 1
+	for i >= 0 && strings.IndexByte(" \t\r\n", out[i]) != -1 {
+		i--
+	}
+
+	if i >= 0 && out[i] == ',' {
+		r.add(RepairTrailingCommas)
+		return append(out[:i], out[i+1:]...)
+	}
+	return out
+}
+
+// closesString reports whether a quote followed by rest closes a string,
+// rather than being part of it as an apostrophe is.
+func closesString(rest string) bool {
+	rest = strings.TrimLeft(rest, " \t\r\n")
+	return rest == "" || strings.IndexByte(":,}]", rest[0]) != -1
+}
+
+func appendEscapedControl(out []byte, c rune) []byte {
+	switch c {
+	case '\n':
+		return append(out, `\n`...)
+	case '\r':
+		return append(out, `\r`...)
+	case '\t':
+		return append(out, `\t`...)
+	case '\b':
+		return append(out, `\b`...)
+	case '\f':
+		return append(out, `\f`...)
+	}
+	return fmt.Appendf(out, `\u%04x`, c)
+}
+
+// conform checks v, decoded with json.Number numbers, against schema. If
+// coerce is true, the strings holding a number or a boolean where the schema
+// asks for one are converted, in which case coerced is true, otherwise they
+// are a mismatch. Only the type, the enum, the required, the properties, the
+// additional properties and the items keywords are checked.
+func conform(v any, schema *jsonschema.Schema, path string, coerce bool) (_ any, coerced bool, err error) {
+	if schema == nil {
+		return v, false, nil
+	}
+
+	mismatch := func(format string, args ...any) (any, bool, error) {
+		return v, false, &SchemaViolationError{Path: path, Reason: fmt.Sprintf(format, args...)}
+	}
+	if str, ok := v.(string); ok && !coerce {
+		switch schema.Type {
+		case "integer":
+			return mismatch("expected an integer, got %q", str)
+		case "number":
+			return mismatch("expected a number, got %q", str)
+		case "boolean":
+			return mismatch("expected a boolean, got %q", str)
+		}
+	}
+
+	switch schema.Type {
+	case "":
+	case "object":
+		if _, ok := v.(map[string]any); !ok {
+			return mismatch("expected an object, got %s", jsonKind(v))
+		}
+	case "array":
+		if _, ok := v.([]any); !ok {
+			return mismatch("expected an array, got %s", jsonKind(v))
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return mismatch("expected a string, got %s", jsonKind(v))
+		}
+	case "integer":
+		switch n := v.(type) {
+		case json.Number:
+			if _, err := n.Int64(); err != nil {
+				return mismatch("expected an integer, got %s", n)
+			}
+		case string:
+			i, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+			if err != nil {
+				return mismatch("expected an integer, got %q", n)
+			}
+			v, coerced = json.Number(strconv.FormatInt(i, 10)), true
+		default:
+			return mismatch("expected an integer, got %s", jsonKind(v))
+		}
+	case "number":
+		switch n := v.(type) {
+		case json.Number:
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+				return mismatch("expected a number, got %q", n)
+			}
+			v, coerced = json.Number(strconv.FormatFloat(f, 'f', -1, 64)), true
+		default:
+			return mismatch("expected a number, got %s", jsonKind(v))
+		}
+	case "boolean":
+		switch b := v.(type) {
+		case bool:
+		case string:
+			switch b {
+			case "true":
+				v, coerced = true, true
+			case "false":
+				v, coerced = false, true
+			default:
+				return mismatch("expected a boolean, got %q", b)
+			}
+		default:
+			return mismatch("expected a boolean, got %s", jsonKind(v))
+		}
+	case "null":
+		if v != nil {
+			return mismatch("expected null, got %s", jsonKind(v))
+		}
+	default:
+		// a type this check does not know of
+		return v, false, nil
+	}
+
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(e any) bool { return jsonEqual(e, v) }) {
+		return mismatch("%v is not one of the enum values", v)
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		for _, key := range schema.Required {
+			if _, ok := val[key]; !ok {
+				return mismatch("missing required property %q", key)
+			}
+		}
+
+		for key, elem := range val {
+			var prop *jsonschema.Schema
+			if schema.Properties != nil {
+				prop, _ = schema.Properties.Get(key)
+			}
+
+			if prop == nil {
+				if isFalseSchema(schema.AdditionalProperties) {
+					return mismatch("unexpected property %q", key)
+				}
+				prop = schema.AdditionalProperties
+			}
+
+			elem, c, err := conform(elem, prop, path+"."+key, coerce)
+			if err != nil {
+				return v, false, err
+			}
+			val[key], coerced = elem, coerced || c
+		}
+	case []any:
+		for i, elem := range val {
+			elem, c, err := conform(elem, schema.Items, fmt.Sprintf("%s[%d]", path, i), coerce)
+			if err != nil {
+				return v, false, err
+			}
+			val[i], coerced = elem, coerced || c
+		}
+	}
+	return v, coerced, nil
+}
+
+func isFalseSchema(s *jsonschema.Schema) bool {
+	if s == nil {
+		return false
+	}
+	bs, err := json.Marshal(s)
+	return err == nil && string(bs) == "false"
+}
+
+func jsonEqual(a, b any) bool {
+	ba, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+
+	bb, err := json.Marshal(b)
+	return err == nil && bytes.Equal(ba, bb)
+}
+
+func jsonKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
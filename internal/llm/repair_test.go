@@ -0,0 +1,541 @@
+package llm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/invopop/jsonschema"
+	"github.com/stretchr/testify/require"
+)
+
+type repairFixtureOutput struct {
+	Title    string   `json:"title"`
+	Score    int      `json:"score"`
+	Weight   float64  `json:"weight"`
+	Done     bool     `json:"done"`
+	Tags     []string `json:"tags"`
+	Polarity string   `json:"polarity" jsonschema:"enum=positive,enum=neutral,enum=negative"`
+}
+
+var repairFixtureSchema = (&jsonschema.Reflector{ExpandedStruct: true, DoNotReference: true}).
+	Reflect(repairFixtureOutput{})
+
+const repairFixtureWant = `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":["a","b"],"polarity":"neutral"}`
+
+// repairFixtureCoerced is repairFixtureWant encoded again after a coercion.
+const repairFixtureCoerced = `{"done":true,"polarity":"neutral","score":3,"tags":["a","b"],"title":"AI","weight":0.5}`
+
+// repairFixtures are the shapes of the broken structured outputs seen from the
+// models, whatever the provider.
+var repairFixtures = []struct {
+	name    string
+	raw     string
+	schema  *jsonschema.Schema
+	want    string
+	actions []llm.RepairAction
+	// wantErr is the error of an unrepairable output, want is then the JSON
+	// returned along with it, if any, and actions are not checked if the
+	// syntax cannot be repaired.
+	wantErr error
+}{
+	{
+		name: "valid",
+		raw:  repairFixtureWant,
+		want: repairFixtureWant,
+	},
+	{
+		name: "surrounding whitespace",
+		raw:  "\n  " + repairFixtureWant + "\n",
+		want: repairFixtureWant,
+	},
+	{
+		name:    "markdown fence",
+		raw:     "```json\n" + repairFixtureWant + "\n```",
+		want:    repairFixtureWant,
+		actions: []llm.RepairAction{llm.RepairStripFences},
+	},
+	{
+		name:    "fence without an info string",
+		raw:     "```\n" + repairFixtureWant + "\n```\n",
+		want:    repairFixtureWant,
+		actions: []llm.RepairAction{llm.RepairStripFences},
+	},
+	{
+		name:    "fence on the same line",
+		raw:     "```" + repairFixtureWant + "```",
+		want:    repairFixtureWant,
+		actions: []llm.RepairAction{llm.RepairStripFences},
+	},
+	{
+		name:    "unterminated fence",
+		raw:     "```json\n" + repairFixtureWant,
+		want:    repairFixtureWant,
+		actions: []llm.RepairAction{llm.RepairStripFences},
+	},
+	{
+		name:    "fence inside a string is kept",
+		raw:     "{\"title\":\"```json\"}",
+		schema:  &jsonschema.Schema{Type: "object"},
+		want:    "{\"title\":\"```json\"}",
+		actions: nil,
+	},
+	{
+		name:    "prose around the json",
+		raw:     "Here are the results:\n" + repairFixtureWant + "\nLet me know if you need more.",
+		want:    repairFixtureWant,
+		actions: []llm.RepairAction{llm.RepairStripProse},
+	},
+	{
+		name:    "prose and fence",
+		raw:     "Sure!\n```json\n" + repairFixtureWant + "\n```\nDone.",
+		want:    repairFixtureWant,
+		actions: []llm.RepairAction{llm.RepairStripFences},
+	},
+	{
+		name:    "brackets in the prose before an object",
+		raw:     "[note] the output: " + repairFixtureWant,
+		schema:  repairFixtureSchema,
+		want:    repairFixtureWant,
+		actions: []llm.RepairAction{llm.RepairStripProse},
+	},
+	{
+		name:    "second value after the json",
+		raw:     `{"a":1} {"a":2}`,
+		want:    `{"a":1}`,
+		actions: []llm.RepairAction{llm.RepairStripProse},
+	},
+	{
+		name:    "top-level array",
+		raw:     `Result: ["a", "b",]`,
+		want:    `["a", "b"]`,
+		actions: []llm.RepairAction{llm.RepairStripProse, llm.RepairTrailingCommas},
+	},
+	{
+		name:    "trailing comma in an object",
+		raw:     `{"a": 1, "b": 2,}`,
+		want:    `{"a": 1, "b": 2}`,
+		actions: []llm.RepairAction{llm.RepairTrailingCommas},
+	},
+	{
+		name:    "trailing comma in an array",
+		raw:     `{"tags": ["a", "b", ]}`,
+		want:    `{"tags": ["a", "b" ]}`,
+		actions: []llm.RepairAction{llm.RepairTrailingCommas},
+	},
+	{
+		name:    "trailing commas on several lines",
+		raw:     "{\n  \"tags\": [\n    \"a\",\n  ],\n}",
+		want:    "{\n  \"tags\": [\n    \"a\"\n  ]\n}",
+		actions: []llm.RepairAction{llm.RepairTrailingCommas},
+	},
+	{
+		name: "comma inside a string is kept",
+		raw:  `{"a": "x,}"}`,
+		want: `{"a": "x,}"}`,
+	},
+	{
+		name:    "single quotes",
+		raw:     `{'title': 'AI', 'tags': ['a', 'b']}`,
+		want:    `{"title": "AI", "tags": ["a", "b"]}`,
+		actions: []llm.RepairAction{llm.RepairSingleQuotes},
+	},
+	{
+		name:    "apostrophe in a single-quoted string",
+		raw:     `{'title': 'Taiwan's economy'}`,
+		want:    `{"title": "Taiwan's economy"}`,
+		actions: []llm.RepairAction{llm.RepairSingleQuotes},
+	},
+	{
+		name:    "escaped single quote",
+		raw:     `{'title': 'it\'s'}`,
+		want:    `{"title": "it's"}`,
+		actions: []llm.RepairAction{llm.RepairSingleQuotes},
+	},
+	{
+		name:    "double quote in a single-quoted string",
+		raw:     `{'title': 'the "AI" plan'}`,
+		want:    `{"title": "the \"AI\" plan"}`,
+		actions: []llm.RepairAction{llm.RepairSingleQuotes},
+	},
+	{
+		name: "apostrophe in a double-quoted string is kept",
+		raw:  `{"title": "Taiwan's economy"}`,
+		want: `{"title": "Taiwan's economy"}`,
+	},
+	{
+		name:    "smart quotes",
+		raw:     `{“title”: “AI”, “tags”: [“a”]}`,
+		want:    `{"title": "AI", "tags": ["a"]}`,
+		actions: []llm.RepairAction{llm.RepairSmartQuotes},
+	},
+	{
+		name:    "smart single quotes",
+		raw:     `{‘title’: ‘Taiwan’s economy’}`,
+		want:    `{"title": "Taiwan’s economy"}`,
+		actions: []llm.RepairAction{llm.RepairSmartQuotes},
+	},
+	{
+		name: "smart quotes inside a string are kept",
+		raw:  `{"title": "the “AI” plan"}`,
+		want: `{"title": "the “AI” plan"}`,
+	},
+	{
+		name: "full-width text is kept",
+		raw:  `{"title": "行政院：啟動ＡＩ計畫，「十大建設」"}`,
+		want: `{"title": "行政院：啟動ＡＩ計畫，「十大建設」"}`,
+	},
+	{
+		name:    "newline in a string",
+		raw:     "{\"title\": \"line one\nline two\"}",
+		want:    `{"title": "line one\nline two"}`,
+		actions: []llm.RepairAction{llm.RepairControlChars},
+	},
+	{
+		name:    "tab and other control characters in a string",
+		raw:     "{\"title\": \"a\tb\x01c\r\"}",
+		want:    `{"title": "a\tb\u0001c\r"}`,
+		actions: []llm.RepairAction{llm.RepairControlChars},
+	},
+	{
+		name: "escaped newline is kept",
+		raw:  `{"title": "a\nb"}`,
+		want: `{"title": "a\nb"}`,
+	},
+	{
+		name:    "truncated after a value",
+		raw:     `{"tags": ["a", "b"`,
+		want:    `{"tags": ["a", "b"]}`,
+		actions: []llm.RepairAction{llm.RepairCloseBrackets},
+	},
+	{
+		name:    "truncated after a comma",
+		raw:     `{"tags": ["a", "b",`,
+		want:    `{"tags": ["a", "b"]}`,
+		actions: []llm.RepairAction{llm.RepairTrailingCommas, llm.RepairCloseBrackets},
+	},
+	{
+		name:    "truncated in a string value",
+		raw:     `{"title": "AI plan`,
+		want:    `{"title": "AI plan"}`,
+		actions: []llm.RepairAction{llm.RepairCloseBrackets},
+	},
+	{
+		name:    "truncated after a backslash",
+		raw:     `{"title": "AI\`,
+		want:    `{"title": "AI"}`,
+		actions: []llm.RepairAction{llm.RepairCloseBrackets},
+	},
+	{
+		name:    "truncated nested objects",
+		raw:     `{"a": {"b": [{"c": 1}, {"c": 2`,
+		want:    `{"a": {"b": [{"c": 1}, {"c": 2}]}}`,
+		actions: []llm.RepairAction{llm.RepairCloseBrackets},
+	},
+	{
+		name:    "truncated fenced output",
+		raw:     "```json\n{\"tags\": [\"a\",\n",
+		want:    "{\"tags\": [\"a\"\n]}",
+		actions: []llm.RepairAction{llm.RepairStripFences, llm.RepairTrailingCommas, llm.RepairCloseBrackets},
+	},
+	{
+		name:    "truncated in a key",
+		raw:     `{"title": "AI", "sco`,
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "truncated after a key",
+		raw:     `{"title": "AI", "score":`,
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "truncated in a literal",
+		raw:     `{"done": tr`,
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "truncated in a number",
+		raw:     `{"weight": 0.`,
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "no json",
+		raw:     "I cannot help with that.",
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "empty",
+		raw:     "",
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "mismatched brackets",
+		raw:     `{"tags": ["a"}`,
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "unescaped double quotes in a string",
+		raw:     `{"title": "the "AI" plan"}`,
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "unquoted keys",
+		raw:     `{title: "AI"}`,
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "missing comma",
+		raw:     `{"a": 1 "b": 2}`,
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "comments",
+		raw:     "{\"a\": 1 // the count\n}",
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "object expected, array found",
+		raw:     `["a"]`,
+		schema:  repairFixtureSchema,
+		wantErr: llm.ErrUnrepairableJSON,
+	},
+	{
+		name:    "numeric strings coerced",
+		raw:     `{"title":"AI","score":"3","weight":" 0.5","done":true,"tags":["a","b"],"polarity":"neutral"}`,
+		schema:  repairFixtureSchema,
+		want:    repairFixtureCoerced,
+		actions: []llm.RepairAction{llm.RepairCoerceTypes},
+	},
+	{
+		name:    "boolean string coerced",
+		raw:     `{"title":"AI","score":3,"weight":0.5,"done":"true","tags":["a","b"],"polarity":"neutral"}`,
+		schema:  repairFixtureSchema,
+		want:    repairFixtureCoerced,
+		actions: []llm.RepairAction{llm.RepairCoerceTypes},
+	},
+	{
+		name: "coercion after the syntax repairs",
+		raw: "```json\n{'title':'AI','score':'3','weight':0.5,'done':true," +
+			"'tags':['a','b',],'polarity':'neutral'\n",
+		schema: repairFixtureSchema,
+		want:   repairFixtureCoerced,
+		actions: []llm.RepairAction{llm.RepairStripFences, llm.RepairSingleQuotes,
+			llm.RepairTrailingCommas, llm.RepairCloseBrackets, llm.RepairCoerceTypes},
+	},
+	{
+		name:    "coerced output keeps the html characters",
+		raw:     `{"title":"<b>&</b>","score":"1"}`,
+		schema:  &jsonschema.Schema{Type: "object", Properties: repairFixtureSchema.Properties},
+		want:    `{"score":1,"title":"<b>&</b>"}`,
+		actions: []llm.RepairAction{llm.RepairCoerceTypes},
+	},
+	{
+		name:    "valid output matching the schema",
+		raw:     repairFixtureWant,
+		schema:  repairFixtureSchema,
+		want:    repairFixtureWant,
+		actions: nil,
+	},
+	{
+		name:    "non-integer string where an integer is expected",
+		raw:     `{"title":"AI","score":"three","weight":0.5,"done":true,"tags":[],"polarity":"neutral"}`,
+		schema:  repairFixtureSchema,
+		want:    `{"title":"AI","score":"three","weight":0.5,"done":true,"tags":[],"polarity":"neutral"}`,
+		wantErr: llm.ErrSchemaViolation,
+	},
+	{
+		name:    "float where an integer is expected",
+		raw:     `{"title":"AI","score":3.5,"weight":0.5,"done":true,"tags":[],"polarity":"neutral"}`,
+		schema:  repairFixtureSchema,
+		want:    `{"title":"AI","score":3.5,"weight":0.5,"done":true,"tags":[],"polarity":"neutral"}`,
+		wantErr: llm.ErrSchemaViolation,
+	},
+	{
+		name:    "missing required property",
+		raw:     `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":[]}`,
+		schema:  repairFixtureSchema,
+		want:    `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":[]}`,
+		wantErr: llm.ErrSchemaViolation,
+	},
+	{
+		name: "missing property of a truncated output",
+		raw:  `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":["a"`,
+		// the brackets are closed, the missing properties are not made up
+		schema:  repairFixtureSchema,
+		want:    `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":["a"]}`,
+		actions: []llm.RepairAction{llm.RepairCloseBrackets},
+		wantErr: llm.ErrSchemaViolation,
+	},
+	{
+		name:    "unexpected property",
+		raw:     `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":[],"polarity":"neutral","extra":1}`,
+		schema:  repairFixtureSchema,
+		want:    `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":[],"polarity":"neutral","extra":1}`,
+		wantErr: llm.ErrSchemaViolation,
+	},
+	{
+		name:    "value outside of the enum",
+		raw:     `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":[],"polarity":"angry"}`,
+		schema:  repairFixtureSchema,
+		want:    `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":[],"polarity":"angry"}`,
+		wantErr: llm.ErrSchemaViolation,
+	},
+	{
+		name:    "wrong item type",
+		raw:     `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":["a",1],"polarity":"neutral"}`,
+		schema:  repairFixtureSchema,
+		want:    `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":["a",1],"polarity":"neutral"}`,
+		wantErr: llm.ErrSchemaViolation,
+	},
+	{
+		name:    "numeric string where a string is expected is kept",
+		raw:     `{"title":"2025","score":3,"weight":0.5,"done":true,"tags":["1"],"polarity":"neutral"}`,
+		schema:  repairFixtureSchema,
+		want:    `{"title":"2025","score":3,"weight":0.5,"done":true,"tags":["1"],"polarity":"neutral"}`,
+		actions: nil,
+	},
+}
+
+func TestRepairJSON(t *testing.T) {
+	for _, tc := range repairFixtures {
+		t.Run(tc.name, func(t *testing.T) {
+			got, actions, err := llm.RepairJSON(tc.raw, tc.schema)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.True(t, json.Valid([]byte(got)), got)
+			}
+			require.Equal(t, tc.want, got)
+			if !errors.Is(err, llm.ErrUnrepairableJSON) {
+				require.Equal(t, tc.actions, actions)
+			}
+
+			if err == nil {
+				// a repaired output needs no more repair
+				again, actions, err := llm.RepairJSON(got, tc.schema)
+				require.NoError(t, err)
+				require.Equal(t, got, again)
+				require.Empty(t, actions)
+			}
+		})
+	}
+}
+
+func TestResponseSchemaJSONSchema(t *testing.T) {
+	var s *llm.ResponseSchema
+	require.Nil(t, s.JSONSchema())
+	require.Nil(t, (&llm.ResponseSchema{}).JSONSchema())
+
+	s = &llm.ResponseSchema{S: repairFixtureSchema}
+	require.Same(t, repairFixtureSchema, s.JSONSchema())
+
+	s = &llm.ResponseSchema{S: map[string]any{
+		"type":                 "object",
+		"required":             []string{"score"},
+		"additionalProperties": false,
+		"properties": map[string]any{
+			"score": map[string]any{"type": "integer"},
+		},
+	}}
+	schema := s.JSONSchema()
+	require.NotNil(t, schema)
+	require.Equal(t, "object", schema.Type)
+
+	got, actions, err := llm.RepairJSON(`{"score": "3"}`, schema)
+	require.NoError(t, err)
+	require.Equal(t, `{"score":3}`, got)
+	require.Equal(t, []llm.RepairAction{llm.RepairCoerceTypes}, actions)
+
+	_, _, err = llm.RepairJSON(`{"score": 3, "extra": 1}`, schema)
+	require.ErrorIs(t, err, llm.ErrSchemaViolation)
+}
+
+func TestRepairOutput(t *testing.T) {
+	schema := &llm.ResponseSchema{Name: "fixture", S: repairFixtureSchema}
+	require.Equal(t, repairFixtureWant, llm.RepairOutput("```json\n"+repairFixtureWant+"```", schema))
+
+	// unrepairable outputs are returned as they are
+	require.Equal(t, "I cannot help with that.", llm.RepairOutput("I cannot help with that.", schema))
+
+	// outputs not matching the schema are returned repaired
+	require.Equal(t, `{"title":"AI"}`, llm.RepairOutput(`Here: {"title":"AI",}`, schema))
+}
+
+func TestValidateOutput(t *testing.T) {
+	schema := &llm.ResponseSchema{Name: "fixture", S: repairFixtureSchema}
+
+	tcs := []struct {
+		name   string
+		output string
+		path   string
+		reason string
+	}{
+		{
+			name:   "valid",
+			output: repairFixtureWant,
+		},
+		{
+			name:   "missing required property",
+			output: `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":[]}`,
+			path:   "$",
+			reason: `missing required property "polarity"`,
+		},
+		{
+			name:   "wrong type",
+			output: `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":["a",1],"polarity":"neutral"}`,
+			path:   "$.tags[1]",
+			reason: "expected a string, got a number",
+		},
+		{
+			name:   "numeric string is not coerced",
+			output: `{"title":"AI","score":"3","weight":0.5,"done":true,"tags":[],"polarity":"neutral"}`,
+			path:   "$.score",
+			reason: `expected an integer, got "3"`,
+		},
+		{
+			name:   "boolean string is not coerced",
+			output: `{"title":"AI","score":3,"weight":0.5,"done":"true","tags":[],"polarity":"neutral"}`,
+			path:   "$.done",
+			reason: `expected a boolean, got "true"`,
+		},
+		{
+			name:   "unexpected property",
+			output: `{"title":"AI","score":3,"weight":0.5,"done":true,"tags":[],"polarity":"neutral","extra":1}`,
+			path:   "$",
+			reason: `unexpected property "extra"`,
+		},
+		{
+			name:   "not json",
+			output: "```json\n" + repairFixtureWant + "\n```",
+			path:   "$",
+			reason: "invalid json",
+		},
+		{
+			name:   "trailing data",
+			output: repairFixtureWant + ` {}`,
+			path:   "$",
+			reason: "trailing data after the json",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := llm.ValidateOutput(tc.output, schema)
+			if tc.path == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, llm.ErrSchemaViolation)
+			var violation *llm.SchemaViolationError
+			require.ErrorAs(t, err, &violation)
+			require.Equal(t, tc.path, violation.Path)
+			require.Contains(t, violation.Reason, tc.reason)
+		})
+	}
+
+	require.NoError(t, llm.ValidateOutput("not json", nil))
+	require.NoError(t, llm.ValidateOutput("not json", &llm.ResponseSchema{Name: "free"}))
+}
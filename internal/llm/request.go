@@ -1,9 +1,11 @@
 package llm
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 	"time"
 
@@ -14,11 +16,19 @@ const (
 	EmbedStateOk        = ""
 	EmbedStateTruncated = "truncated"
 	EmbedStateError     = "error"
+	// EmbedStateCancelled is the state of an input left unembedded because
+	// the context of the request was done.
+	EmbedStateCancelled = "cancelled"
 )
 
 var (
 	ErrRequestShouldNotBeNull = errors.New("request should not be null")
 	ErrNoInput                = errors.New("no input provided in request")
+	ErrInvalidPart            = errors.New("invalid message part")
+	ErrInvalidDimensions      = errors.New("invalid embedding dimensions")
+	// ErrContentBlocked is the error of a generation the provider refused, or
+	// stopped, for its safety policy.
+	ErrContentBlocked = errors.New("content blocked by the provider")
 )
 
 type Role string
@@ -27,6 +37,8 @@ const (
 	RoleSystem    Role = "system"
 	RoleUser      Role = "user"
 	RoleAssistant Role = "assistant"
+	// RoleTool is the role of the result of a tool call, see NewToolMessage.
+	RoleTool Role = "tool"
 )
 
 type Request interface {
@@ -36,13 +48,121 @@ type Request interface {
 type Message struct {
 	Role    Role
 	Content []string
+	// Parts is the content of the message following the texts of Content,
+	// e.g. the images of a multimodal request.
+	Parts []Part
+	// ToolCalls are the calls of an assistant message, sent back to the model
+	// with their results.
+	ToolCalls []ToolCall
+	// ToolCallID is the ID of the call a RoleTool message is the result of.
+	ToolCallID string
 }
 
+// NewTextMessage creates a message of texts.
+func NewTextMessage(role Role, texts ...string) Message {
+	return Message{Role: role, Content: texts}
+}
+
+// NewMessage creates a message of parts, e.g. a text and the image it asks
+// about.
+func NewMessage(role Role, parts ...Part) Message {
+	return Message{Role: role, Parts: parts}
+}
+
+// ContentParts returns the content of the message in order, a TextPart per
+// text of Content followed by Parts.
+func (msg Message) ContentParts() []Part {
+	parts := make([]Part, 0, len(msg.Content)+len(msg.Parts))
+	for _, text := range msg.Content {
+		parts = append(parts, TextPart{Text: text})
+	}
+	return append(parts, msg.Parts...)
+}
+
+// HasImage reports whether the message has an ImagePart.
+func (msg Message) HasImage() bool {
+	return slices.ContainsFunc(msg.Parts, func(p Part) bool {
+		_, ok := p.(ImagePart)
+		return ok
+	})
+}
+
+// Part is a piece of the content of a message, a TextPart or an ImagePart.
+type Part interface {
+	isPart()
+}
+
+// TextPart is a text in a message.
+type TextPart struct {
+	Text string
+}
+
+// ImagePart is an image in a message, either its Data of the MIME type, e.g.
+// image/png, or its URL.
+type ImagePart struct {
+	MIME string
+	Data []byte
+	URL  string
+}
+
+func (TextPart) isPart()  {}
+func (ImagePart) isPart() {}
+
+// Validate checks that the image has either data of a MIME type or a URL.
+func (p ImagePart) Validate() error {
+	switch {
+	case len(p.Data) > 0 && p.URL != "":
+		return fmt.Errorf("%w: image should have either data or a URL, not both", ErrInvalidPart)
+	case len(p.Data) > 0 && p.MIME == "":
+		return fmt.Errorf("%w: image data should have a MIME type", ErrInvalidPart)
+	case len(p.Data) == 0 && p.URL == "":
+		return fmt.Errorf("%w: image should have data or a URL", ErrInvalidPart)
+	}
+	return nil
+}
+
+// DataURL returns the URL of the image, a base64 data URL if it is given by
+// its data.
+func (p ImagePart) DataURL() string {
+	if p.URL != "" {
+		return p.URL
+	}
+	return "data:" + p.MIME + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+}
+
+// GenerateRequest is a request to generate a response to Messages.
+//
+// The generation parameters are left to the defaults of the model when nil
+// or empty. They are translated by each provider, and dropped with a warning
+// where the provider has no equivalent. Config is the provider specific
+// configuration, e.g. a *genai.GenerateContentConfig for Gemini or the
+// options map for Ollama; a parameter set in Config overrides the one of the
+// request.
 type GenerateRequest struct {
 	Messages  []Message
 	ModelName string
 	Schema    *ResponseSchema
-	Config    any
+
+	// Temperature controls the randomness of the output.
+	Temperature *float32
+	// TopP is the cumulative probability of the tokens sampled from.
+	TopP *float32
+	// MaxTokens is the maximum number of tokens of the output.
+	MaxTokens *int
+	// Stop are the sequences the generation stops at.
+	Stop []string
+	// Seed makes the sampling reproducible, where the provider supports it.
+	Seed *int64
+
+	// Tools are the functions the model may call, see ToolDefinition.
+	Tools []ToolDefinition
+
+	// User identifies the end user of the request to the provider, e.g. the
+	// task UUID for abuse tracing. It is ignored by the providers which have
+	// no such field.
+	User string
+
+	Config any
 }
 
 type ResponseSchema struct {
@@ -57,29 +177,102 @@ func (req GenerateRequest) Endpoint() string {
 }
 
 type GenerateResponse struct {
-	Outputs []string
-	Raw     any
+	Outputs []string `json:"outputs,omitempty"`
+	// ToolCalls are the calls of the tools of the request by the model.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Usage     Usage      `json:"usage"`
+	Raw       any        `json:"raw,omitempty"`
+	// SchemaErr is the result of ValidateOutput on the output of a request
+	// with a schema, after the repairs: nil if it matches, a
+	// *SchemaViolationError if not.
+	SchemaErr error `json:"-"`
+	// FinishReason is why the model stopped, one of the FinishReason
+	// constants where the provider reason has an equivalent.
+	FinishReason string `json:"finish_reason,omitempty"`
+	// Logprobs are the log probabilities of the tokens of the first output,
+	// where requested and reported by the provider.
+	Logprobs []TokenLogprob `json:"logprobs,omitempty"`
+}
+
+// The finish reasons of a GenerateResponse shared by the providers.
+const (
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonToolCalls     = "tool_calls"
+	FinishReasonContentFilter = "content_filter"
+)
+
+// Truncated reports whether the output was cut by the maximum number of
+// tokens.
+func (resp GenerateResponse) Truncated() bool {
+	return resp.FinishReason == FinishReasonLength
+}
+
+// TokenLogprob is the log probability of a token of an output, along with the
+// most likely tokens at its position.
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	// Alternatives are the top tokens at the position, the token included.
+	Alternatives []TokenLogprob `json:"alternatives,omitempty"`
+}
+
+// Usage is the number of tokens a request used, as reported by the provider.
+// The counts a provider does not report are zero.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Add returns the sum of the token counts of u and v.
+func (u Usage) Add(v Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + v.PromptTokens,
+		CompletionTokens: u.CompletionTokens + v.CompletionTokens,
+		TotalTokens:      u.TotalTokens + v.TotalTokens,
+	}
 }
 
 type EmbedRequest struct {
 	Inputs    []EmbedInput
 	ModelName string
-	Config    any
+	// Dimensions, if set, overrides the embedding dimensions of the client for
+	// this request. The model should support truncating its embeddings.
+	Dimensions *int
+	// TaskType is the intended use of the embeddings, e.g. RETRIEVAL_QUERY,
+	// for the providers optimizing them for it. The others ignore it.
+	TaskType string
+	// User identifies the end user of the request, as for GenerateRequest.
+	User   string
+	Config any
 }
 
 func (req EmbedRequest) Endpoint() string {
 	return "embedding"
 }
 
+// ValidateDimensions checks that the Dimensions of req, if set, are positive.
+func (req EmbedRequest) ValidateDimensions() error {
+	if req.Dimensions != nil && *req.Dimensions <= 0 {
+		return fmt.Errorf("%w: should be positive, got %d", ErrInvalidDimensions, *req.Dimensions)
+	}
+	return nil
+}
+
 type EmbedResponse struct {
 	Model      string      `json:"model,omitempty"`
 	Embeddings []Embedding `json:"embeddings,omitempty"`
+	Usage      Usage       `json:"usage"`
 	Raw        any         `json:"raw,omitempty"`
 }
 
 type Embedding struct {
 	State  string    `json:"state,omitempty"`
 	Values []float32 `json:"values,omitempty"`
+	// Error is why the input was not embedded, for the EmbedStateError and
+	// EmbedStateCancelled states.
+	Error string `json:"error,omitempty"`
 }
 
 func (embed Embedding) Dim() int {
@@ -98,13 +291,17 @@ func (embed Embedding) String() string {
 		state, len(embed.Values), strings.Join(strs, ", "))
 }
 
+// BatchRequest is a batch job. The requests are serialized into Input, or into
+// a temporary BatchInputWriter if Input is nil. ReadWriter only receives a copy
+// of the serialized input and will be removed in the next release.
 type BatchRequest struct {
 	ModelName         string            `json:"model_name"`
 	BatchJobName      string            `json:"batch_job_name"`
 	Endpoint          string            `json:"endpoint"`
 	Requests          []Request         `json:"requests"`
 	Metadata          map[string]string `json:"meta_data"`
-	ReadWriter        io.ReadWriter     `json:"read_writer"`
+	Input             *BatchInputWriter `json:"-"`
+	ReadWriter        io.ReadWriter     `json:"read_writer"` // Deprecated: use Input instead.
 	FileUploadConfig  any               `json:"file_upload_config"`
 	BatchCreateConfig any               `json:"batch_create_config"`
 }
@@ -122,7 +319,10 @@ type BatchResponse struct {
 	EndAt          time.Time `json:"end_at"`
 	UpdateAt       time.Time `json:"update_at"`
 	Responses      [][]byte  `json:"responses"`
-	Raw            any       `json:"raw"`
+	// Parsed are the results of Responses matched to the requests of the
+	// batch, ordered by their index.
+	Parsed []BatchItemResult `json:"parsed,omitempty"`
+	Raw    any               `json:"raw"`
 }
 
 type BatchRetrieveRequest struct {
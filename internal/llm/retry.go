@@ -0,0 +1,158 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/ChiaYuChang/weathercock/pkgs/retry"
+)
+
+const (
+	DefaultRetryMaxAttempts = 4
+	DefaultRetryBaseDelay   = time.Second
+	DefaultRetryMaxDelay    = 10 * time.Second
+	DefaultRetryJitter      = 0.5
+)
+
+// RetryPolicy is how the calls of a client to its provider are retried. The
+// delay before the n-th retry is BaseDelay doubled n-1 times, capped at
+// MaxDelay, with up to Jitter of it taken off at random.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, 1 never
+	// retries.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Jitter is the fraction of the delay taken off at random, in [0, 1].
+	Jitter float64
+	// RetryOn reports whether a failed call is retried, IsTransient if nil.
+	RetryOn func(err error) bool
+	// RetryAfter returns the delay the provider asked for before err is
+	// retried, 0 if none. The retry waits the longer of it and the delay of
+	// the policy.
+	RetryAfter func(err error) time.Duration
+	// Clock is the clock the delays are waited on, the real one if nil.
+	Clock clockid.Clock
+}
+
+// DefaultRetryPolicy returns the policy of four attempts 1s, 2s and 4s apart,
+// with a jitter of a half, retrying the transient errors.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: DefaultRetryMaxAttempts,
+		BaseDelay:   DefaultRetryBaseDelay,
+		MaxDelay:    DefaultRetryMaxDelay,
+		Jitter:      DefaultRetryJitter,
+	}
+}
+
+// Validate checks that the policy makes at least one attempt with sensible
+// delays.
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return fmt.Errorf("max attempts should be at least 1, got %d", p.MaxAttempts)
+	case p.BaseDelay < 0 || p.MaxDelay < 0:
+		return fmt.Errorf("retry delays should not be negative, got %s and %s", p.BaseDelay, p.MaxDelay)
+	case p.MaxDelay > 0 && p.MaxDelay < p.BaseDelay:
+		return fmt.Errorf("max delay %s should not be less than the base delay %s", p.MaxDelay, p.BaseDelay)
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("jitter should be in [0, 1], got %g", p.Jitter)
+	}
+	return nil
+}
+
+func (p RetryPolicy) policy() retry.Policy {
+	return retry.Policy{
+		MaxAttempts: p.MaxAttempts,
+		MinDelay:    p.BaseDelay,
+		MaxDelay:    p.MaxDelay,
+		Jitter:      p.Jitter,
+		Clock:       p.Clock,
+	}
+}
+
+// Delay returns the delay before the n-th retry, n starting at 1.
+func (p RetryPolicy) Delay(n int) time.Duration {
+	return p.policy().Delay(n)
+}
+
+// Do calls fn until it succeeds, it fails with an error RetryOn does not
+// retry, or MaxAttempts attempts have been made. It returns the last error of
+// fn. If ctx is done while waiting, the error of ctx is returned wrapping the
+// last error.
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	retryOn := p.RetryOn
+	if retryOn == nil {
+		retryOn = IsTransient
+	}
+
+	err := p.policy().Do(ctx, func(ctx context.Context) error {
+		err := fn(ctx)
+		if err != nil && !retryOn(err) {
+			return retry.Permanent(err)
+		}
+		if err != nil && p.RetryAfter != nil {
+			return retry.After(err, p.RetryAfter(err))
+		}
+		return err
+	})
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("%w, last attempt: %w", ctx.Err(), err)
+	}
+	return err
+}
+
+// IsTransient reports whether err is a failure to reach the provider which
+// may not happen again: a refused or reset connection, a connection closed
+// mid-response, or a network timeout. The errors of the provider APIs are
+// classified by their clients, with IsTransientStatus.
+func IsTransient(err error) bool {
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsTransientStatus reports whether a response of the HTTP status code is
+// worth retrying: the provider is rate limiting, or failing on its side.
+func IsTransientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// ParseRetryAfter returns the delay asked for by the headers of a response:
+// the milliseconds of Retry-After-Ms, or the seconds or the date of
+// Retry-After, relative to now.
+func ParseRetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	if v := h.Get("Retry-After-Ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond)), true
+		}
+	}
+
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.ParseFloat(v, 64); err == nil && s >= 0 {
+		return time.Duration(s * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
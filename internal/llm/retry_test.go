@@ -0,0 +1,256 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/llm/gemini"
+	"github.com/ChiaYuChang/weathercock/internal/llm/ollama"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genai"
+)
+
+// errRefused is the error of a request to a server which is down.
+var errRefused = &net.OpError{Op: "dial", Net: "tcp",
+	Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := llm.RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: 0.5}
+	for n, full := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second,
+		8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		for range 100 {
+			d := p.Delay(n + 1)
+			require.GreaterOrEqual(t, d, full/2, "retry %d", n+1)
+			require.LessOrEqual(t, d, full, "retry %d", n+1)
+		}
+	}
+
+	p.Jitter = 0
+	require.Equal(t, 4*time.Second, p.Delay(3))
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	require.NoError(t, llm.DefaultRetryPolicy().Validate())
+	require.NoError(t, llm.RetryPolicy{MaxAttempts: 1}.Validate())
+	require.Error(t, llm.RetryPolicy{}.Validate())
+	require.Error(t, llm.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Second, MaxDelay: time.Millisecond}.Validate())
+	require.Error(t, llm.RetryPolicy{MaxAttempts: 2, Jitter: 1.5}.Validate())
+
+	cli := llm.NewClient()
+	require.Error(t, cli.WithRetryPolicy(llm.RetryPolicy{}))
+	require.Equal(t, llm.DefaultRetryPolicy().MaxAttempts, cli.RetryPolicy.MaxAttempts)
+	require.NoError(t, cli.WithRetryPolicy(llm.RetryPolicy{MaxAttempts: 2}))
+	require.Equal(t, 2, cli.RetryPolicy.MaxAttempts)
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	newPolicy := func(clock clockid.Clock) llm.RetryPolicy {
+		return llm.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute,
+			Jitter: 0.5, Clock: clock}
+	}
+
+	t.Run("transient", func(t *testing.T) {
+		clock := clockid.NewFake(time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC))
+		go func() {
+			for range 2 {
+				clock.BlockUntil(1)
+				clock.Advance(time.Minute)
+			}
+		}()
+
+		calls := 0
+		err := newPolicy(clock).Do(context.Background(), func(ctx context.Context) error {
+			if calls++; calls < 3 {
+				return fmt.Errorf("failed to chat: %w", errRefused)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		calls := 0
+		p := newPolicy(nil)
+		p.BaseDelay, p.MaxDelay = time.Millisecond, time.Millisecond
+		err := p.Do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return io.ErrUnexpectedEOF
+		})
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("permanent", func(t *testing.T) {
+		calls := 0
+		err := newPolicy(nil).Do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return llm.ErrSchemaViolation
+		})
+		require.ErrorIs(t, err, llm.ErrSchemaViolation)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("retry on", func(t *testing.T) {
+		calls := 0
+		p := newPolicy(nil)
+		p.BaseDelay, p.MaxDelay = time.Millisecond, time.Millisecond
+		p.RetryOn = func(err error) bool { return errors.Is(err, llm.ErrSchemaViolation) }
+		err := p.Do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return llm.ErrSchemaViolation
+		})
+		require.ErrorIs(t, err, llm.ErrSchemaViolation)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+		clock := clockid.NewFake(start)
+		go func() {
+			clock.BlockUntil(1)
+			clock.Advance(30 * time.Second)
+		}()
+
+		var at []time.Duration
+		p := newPolicy(clock)
+		p.MaxAttempts = 2
+		p.RetryAfter = func(err error) time.Duration { return 30 * time.Second }
+		err := p.Do(context.Background(), func(ctx context.Context) error {
+			at = append(at, clock.Since(start))
+			return errRefused
+		})
+		require.ErrorIs(t, err, syscall.ECONNREFUSED)
+		require.Equal(t, []time.Duration{0, 30 * time.Second}, at)
+	})
+
+	t.Run("cancelled mid-backoff", func(t *testing.T) {
+		// the clock is never advanced, the backoff only ends with ctx
+		clock := clockid.NewFake(time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC))
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			clock.BlockUntil(1)
+			cancel()
+		}()
+
+		calls := 0
+		err := newPolicy(clock).Do(ctx, func(ctx context.Context) error {
+			calls++
+			return errRefused
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.ErrorIs(t, err, syscall.ECONNREFUSED)
+		require.Equal(t, 1, calls)
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	tcs := []struct {
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		{http.Header{"Retry-After": {"20"}}, 20 * time.Second, true},
+		{http.Header{"Retry-After": {"1.5"}}, 1500 * time.Millisecond, true},
+		{http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}}, time.Minute, true},
+		{http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, 0, true},
+		{http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"1"}}, 250 * time.Millisecond, true},
+		{http.Header{"Retry-After-Ms": {"soon"}, "Retry-After": {"1"}}, time.Second, true},
+		{http.Header{"Retry-After": {"soon"}}, 0, false},
+		{http.Header{"Retry-After": {"-1"}}, 0, false},
+		{http.Header{}, 0, false},
+	}
+	for _, tc := range tcs {
+		d, ok := llm.ParseRetryAfter(tc.header, now)
+		require.Equal(t, tc.ok, ok, tc.header)
+		require.Equal(t, tc.want, d, tc.header)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tcs := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"nil", nil, false},
+		{"connection refused", errRefused, true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"network timeout", &net.DNSError{IsTimeout: true}, true},
+		{"cancelled", context.Canceled, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"schema mismatch", llm.ErrSchemaViolation, false},
+		{"no input", llm.ErrNoInput, false},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.transient, llm.IsTransient(tc.err))
+		})
+	}
+
+	for code, transient := range map[int]bool{400: false, 401: false, 404: false, 422: false,
+		429: true, 500: true, 502: true, 503: true} {
+		require.Equal(t, transient, llm.IsTransientStatus(code), "status %d", code)
+		require.Equal(t, transient, ollama.IsTransient(api.StatusError{StatusCode: code}), "ollama status %d", code)
+		require.Equal(t, transient, gemini.IsTransient(fmt.Errorf("failed to generate embedding: %w",
+			genai.APIError{Code: code})), "gemini status %d", code)
+	}
+	require.True(t, ollama.IsTransient(errRefused))
+	require.True(t, gemini.IsTransient(errRefused))
+}
+
+func TestBaseClientRateLimit(t *testing.T) {
+	cli := llm.NewClient()
+	_, ok := cli.RateLimitTokens()
+	require.False(t, ok)
+	require.NoError(t, cli.Wait(context.Background()))
+
+	require.ErrorContains(t, cli.WithRateLimit(0, 1), "rate limit should be positive")
+	require.ErrorContains(t, cli.WithRateLimit(1, 0), "burst should be at least 1")
+
+	// two calls a second, the third one waits for the half a second
+	require.NoError(t, cli.WithRateLimit(2, 2))
+	tokens, ok := cli.RateLimitTokens()
+	require.True(t, ok)
+	require.InDelta(t, 2, tokens, 0.01)
+
+	start := time.Now()
+	for range 3 {
+		require.NoError(t, cli.Retry(context.Background(), func(ctx context.Context) error { return nil }))
+	}
+	require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	tokens, _ = cli.RateLimitTokens()
+	require.Less(t, tokens, 1.0)
+
+	t.Run("context done", func(t *testing.T) {
+		require.NoError(t, cli.WithRateLimit(0.001, 1))
+		require.NoError(t, cli.Wait(context.Background()))
+
+		calls := 0
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := cli.Retry(ctx, func(ctx context.Context) error {
+			calls++
+			return nil
+		})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Zero(t, calls)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		require.ErrorIs(t, cli.Wait(ctx), context.Canceled)
+	})
+}
@@ -0,0 +1,363 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"unicode"
+)
+
+var (
+	ErrTooManySplits     = errors.New("input needs more splits than allowed")
+	ErrDimensionMismatch = errors.New("embeddings have different dimensions")
+	ErrEmbedFailed       = errors.New("provider failed to embed the input")
+)
+
+// Tokenizer counts the tokens of a text the way an embedding model does, or
+// at least never fewer.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// TokenEncoder is a Tokenizer knowing the vocabulary of the model, e.g. the
+// tiktoken encoding of an OpenAI model.
+type TokenEncoder interface {
+	Tokenizer
+	// Encode returns the IDs of the tokens of the text.
+	Encode(text string) []int
+}
+
+// ApproxTokenizer estimates the token count without the model vocabulary. A
+// CJK rune is counted as a token and every other run of runes as one token
+// per four bytes, which overestimates the multilingual tokenizers slightly.
+type ApproxTokenizer struct{}
+
+func (ApproxTokenizer) CountTokens(text string) int {
+	n, run := 0, 0
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			n += 1 + (run+3)/4
+			run = 0
+			continue
+		}
+		run += len(string(r))
+	}
+	return n + (run+3)/4
+}
+
+// Pooling is the way the sub-vectors of a split input are combined.
+type Pooling string
+
+const (
+	PoolingMean Pooling = "mean"
+	PoolingMax  Pooling = "max"
+)
+
+// Pool normalizes the vectors, combines them element-wise and normalizes the
+// result again, so the pooled vector is comparable to an unsplit one.
+func Pool(vectors [][]float32, pooling Pooling) ([]float32, error) {
+	if len(vectors) == 0 {
+		return nil, ErrNoInput
+	}
+
+	dim := len(vectors[0])
+	pooled := make([]float32, dim)
+	for i, vec := range vectors {
+		if len(vec) != dim {
+			return nil, fmt.Errorf("%w: %d and %d", ErrDimensionMismatch, dim, len(vec))
+		}
+
+		vec = normalize(vec)
+		for j, v := range vec {
+			switch {
+			case pooling == PoolingMax && (i == 0 || v > pooled[j]):
+				pooled[j] = v
+			case pooling != PoolingMax:
+				pooled[j] += v / float32(len(vectors))
+			}
+		}
+	}
+	return normalize(pooled), nil
+}
+
+// normalize returns a copy of vec with unit L2 norm. A zero vector is returned
+// as is.
+func normalize(vec []float32) []float32 {
+	var sum float64
+	for _, v := range vec {
+		sum += float64(v) * float64(v)
+	}
+
+	out := make([]float32, len(vec))
+	copy(out, vec)
+	if sum == 0 {
+		return out
+	}
+
+	norm := float32(math.Sqrt(sum))
+	for i := range out {
+		out[i] /= norm
+	}
+	return out
+}
+
+// SplitByTokens splits text into pieces of at most limit tokens. The pieces
+// are cut after a sentence end where possible, then after a clause separator,
+// and between runes otherwise. Concatenating the pieces gives back the text.
+func SplitByTokens(text string, limit int, tok Tokenizer) []string {
+	if limit <= 0 || tok.CountTokens(text) <= limit {
+		return []string{text}
+	}
+
+	var segs []string
+	for _, sent := range cutAt(text, SentenceEnds(text)) {
+		if tok.CountTokens(sent) <= limit {
+			segs = append(segs, sent)
+			continue
+		}
+		for _, clause := range cutAt(sent, clauseEnds(sent)) {
+			if tok.CountTokens(clause) <= limit {
+				segs = append(segs, clause)
+				continue
+			}
+			segs = append(segs, cutRunes(clause, limit, tok)...)
+		}
+	}
+
+	var pieces []string
+	cur := ""
+	for _, seg := range segs {
+		if cur != "" && tok.CountTokens(cur+seg) > limit {
+			pieces = append(pieces, cur)
+			cur = ""
+		}
+		cur += seg
+	}
+	return append(pieces, cur)
+}
+
+// cutAt cuts text at the rune offsets in ends. The text after the last end is
+// kept as the last segment.
+func cutAt(text string, ends []int) []string {
+	runes := []rune(text)
+	var segs []string
+	start := 0
+	for _, end := range ends {
+		if end > start {
+			segs = append(segs, string(runes[start:end]))
+			start = end
+		}
+	}
+	if start < len(runes) {
+		segs = append(segs, string(runes[start:]))
+	}
+	return segs
+}
+
+// clauseEnds returns the rune offsets right after each clause separator.
+func clauseEnds(text string) []int {
+	var ends []int
+	for i, r := range []rune(text) {
+		if clauseSeparators[r] {
+			ends = append(ends, i+1)
+		}
+	}
+	return ends
+}
+
+// cutRunes cuts text into the longest runs of runes within limit tokens.
+func cutRunes(text string, limit int, tok Tokenizer) []string {
+	runes := []rune(text)
+	var segs []string
+	for start := 0; start < len(runes); {
+		end := start + 1
+		for end < len(runes) && tok.CountTokens(string(runes[start:end+1])) <= limit {
+			end++
+		}
+		segs = append(segs, string(runes[start:end]))
+		start = end
+	}
+	return segs
+}
+
+// SplitEmbedConfig configures the SplitEmbedder. A zero MaxInputTokens takes
+// the limit of the model if it implements InputLimiter.
+type SplitEmbedConfig struct {
+	MaxInputTokens int
+	Pooling        Pooling
+	MaxSplits      int
+}
+
+func DefaultSplitEmbedConfig() SplitEmbedConfig {
+	return SplitEmbedConfig{
+		MaxInputTokens: 0,
+		Pooling:        PoolingMean,
+		MaxSplits:      8,
+	}
+}
+
+// SplitEmbedding is the embedding of an input, pooled from SubCount pieces if
+// the input was split.
+type SplitEmbedding struct {
+	Embedding
+	WasSplit bool
+	SubCount int
+}
+
+// SplitEmbedder embeds the inputs longer than the model limit piece by piece
+// instead of letting the provider truncate them silently. An input the
+// provider reports as truncated anyway is split further and embedded again.
+type SplitEmbedder struct {
+	client    LLM
+	tokenizer Tokenizer
+	cfg       SplitEmbedConfig
+}
+
+func NewSplitEmbedder(client LLM, tokenizer Tokenizer, cfg SplitEmbedConfig) *SplitEmbedder {
+	return &SplitEmbedder{client: client, tokenizer: tokenizer, cfg: cfg}
+}
+
+// limit returns the token limit of an input to the model, 0 if unknown.
+func (e *SplitEmbedder) limit(modelName string) int {
+	if e.cfg.MaxInputTokens > 0 {
+		return e.cfg.MaxInputTokens
+	}
+	for _, m := range e.client.ListModels() {
+		if l, ok := m.(InputLimiter); ok && m.Name() == modelName {
+			return l.MaxInputTokens()
+		}
+	}
+	return 0
+}
+
+// piece is a part of the input at index input waiting to be embedded.
+type piece struct {
+	input  int
+	prefix string
+	text   string
+}
+
+func (p piece) embedInput() EmbedInput {
+	return SimpleTextInput{Content: p.text, Prefix: p.prefix}
+}
+
+// Embed embeds the inputs of req and returns an embedding per input in the
+// same order.
+func (e *SplitEmbedder) Embed(ctx context.Context, req *EmbedRequest) ([]SplitEmbedding, error) {
+	if req == nil {
+		return nil, ErrRequestShouldNotBeNull
+	}
+	if len(req.Inputs) == 0 {
+		return nil, ErrNoInput
+	}
+
+	limit := e.limit(req.ModelName)
+	counts := make([]int, len(req.Inputs))
+	vectors := make([][][]float32, len(req.Inputs))
+
+	var pending []piece
+	for i, in := range req.Inputs {
+		p := piece{input: i, text: in.String()}
+		if s, ok := in.(SimpleTextInput); ok {
+			p.prefix, p.text = s.Prefix, s.Content
+		}
+
+		pieces, err := e.split(p, limit, 0)
+		if err != nil {
+			return nil, err
+		}
+		counts[i] = len(pieces)
+		pending = append(pending, pieces...)
+	}
+
+	for len(pending) > 0 {
+		inputs := make([]EmbedInput, len(pending))
+		for i, p := range pending {
+			inputs[i] = p.embedInput()
+		}
+
+		resp, err := e.client.Embed(ctx, &EmbedRequest{
+			Inputs:     inputs,
+			ModelName:  req.ModelName,
+			Dimensions: req.Dimensions,
+			TaskType:   req.TaskType,
+			User:       req.User,
+			Config:     req.Config,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Embeddings) != len(pending) {
+			return nil, fmt.Errorf("%w: %d embeddings for %d inputs",
+				ErrEmbedFailed, len(resp.Embeddings), len(pending))
+		}
+
+		var retry []piece
+		for i, emb := range resp.Embeddings {
+			p := pending[i]
+			switch emb.State {
+			case EmbedStateOk:
+				vectors[p.input] = append(vectors[p.input], emb.Values)
+			case EmbedStateTruncated:
+				// the tokenizer undercounted, so halve what it counted
+				pieces, err := e.split(p, e.tokenizer.CountTokens(p.prefix+p.text)/2, counts[p.input]-1)
+				if err != nil {
+					return nil, err
+				}
+				if len(pieces) == 1 {
+					return nil, fmt.Errorf("%w: input %d is truncated and cannot be split further",
+						ErrEmbedFailed, p.input)
+				}
+				counts[p.input] += len(pieces) - 1
+				retry = append(retry, pieces...)
+			default:
+				if emb.Error != "" {
+					return nil, fmt.Errorf("%w: input %d: %s", ErrEmbedFailed, p.input, emb.Error)
+				}
+				return nil, fmt.Errorf("%w: input %d", ErrEmbedFailed, p.input)
+			}
+		}
+		pending = retry
+	}
+
+	embeddings := make([]SplitEmbedding, len(req.Inputs))
+	for i, vecs := range vectors {
+		if len(vecs) == 1 {
+			embeddings[i] = SplitEmbedding{Embedding: Embedding{Values: vecs[0]}, SubCount: 1}
+			continue
+		}
+
+		pooled, err := Pool(vecs, e.cfg.Pooling)
+		if err != nil {
+			return nil, err
+		}
+		embeddings[i] = SplitEmbedding{
+			Embedding: Embedding{Values: pooled},
+			WasSplit:  true,
+			SubCount:  len(vecs),
+		}
+	}
+	return embeddings, nil
+}
+
+// split splits p into pieces within limit tokens, prefix included. others is
+// the number of the other pieces of the same input, counted against MaxSplits.
+func (e *SplitEmbedder) split(p piece, limit, others int) ([]piece, error) {
+	if limit <= 0 {
+		return []piece{p}, nil
+	}
+
+	budget := max(1, limit-e.tokenizer.CountTokens(p.prefix))
+	texts := SplitByTokens(p.text, budget, e.tokenizer)
+	if others+len(texts) > e.cfg.MaxSplits {
+		return nil, fmt.Errorf("%w: input %d needs %d pieces, at most %d",
+			ErrTooManySplits, p.input, others+len(texts), e.cfg.MaxSplits)
+	}
+
+	pieces := make([]piece, len(texts))
+	for i, text := range texts {
+		pieces[i] = piece{input: p.input, prefix: p.prefix, text: text}
+	}
+	return pieces, nil
+}
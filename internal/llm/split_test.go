@@ -0,0 +1,234 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/stretchr/testify/require"
+)
+
+// runeTokenizer counts every rune as a token.
+type runeTokenizer struct{}
+
+func (runeTokenizer) CountTokens(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// fakeEmbedder embeds a text as [runes, 1] and reports the texts longer than
+// truncateAt runes as truncated, if truncateAt is positive.
+type fakeEmbedder struct {
+	*llm.BaseClient
+	truncateAt int
+	inputs     []string
+}
+
+func newFakeEmbedder(truncateAt int, models ...llm.Model) *fakeEmbedder {
+	cli := llm.NewClient()
+	_ = cli.WithModel(models...)
+	return &fakeEmbedder{BaseClient: cli, truncateAt: truncateAt}
+}
+
+var errUnused = errors.New("unused by the split embedder")
+
+func (f *fakeEmbedder) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm.GenerateResponse, error) {
+	return nil, errUnused
+}
+
+func (f *fakeEmbedder) BatchCreate(ctx context.Context, reqs *llm.BatchRequest) (*llm.BatchResponse, error) {
+	return nil, errUnused
+}
+
+func (f *fakeEmbedder) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequest) (*llm.BatchResponse, error) {
+	return nil, errUnused
+}
+
+func (f *fakeEmbedder) BatchCancel(ctx context.Context, req *llm.BatchCancelRequest) error {
+	return errUnused
+}
+
+func (f *fakeEmbedder) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.EmbedResponse, error) {
+	resp := &llm.EmbedResponse{Model: req.ModelName}
+	for _, in := range req.Inputs {
+		text := in.String()
+		f.inputs = append(f.inputs, text)
+
+		n := utf8.RuneCountInString(text)
+		emb := llm.Embedding{Values: []float32{float32(n), 1}}
+		if f.truncateAt > 0 && n > f.truncateAt {
+			emb = llm.Embedding{
+				State:  llm.EmbedStateTruncated,
+				Values: []float32{float32(f.truncateAt), 1},
+			}
+		}
+		resp.Embeddings = append(resp.Embeddings, emb)
+	}
+	return resp, nil
+}
+
+func TestSplitByTokens(t *testing.T) {
+	tcs := []struct {
+		Name     string
+		Text     string
+		Limit    int
+		Expected []string
+	}{
+		{
+			Name:     "Within limit",
+			Text:     "交通部宣布下修年齡。立委反彈！",
+			Limit:    15,
+			Expected: []string{"交通部宣布下修年齡。立委反彈！"},
+		},
+		{
+			Name:     "Chinese sentences",
+			Text:     "交通部宣布下修年齡。立委反彈！民眾表示支持。",
+			Limit:    12,
+			Expected: []string{"交通部宣布下修年齡。", "立委反彈！民眾表示支持。"},
+		},
+		{
+			Name:     "Closing quote stays with its sentence",
+			Text:     "他說：「我們會再研議。」記者追問細節。",
+			Limit:    12,
+			Expected: []string{"他說：「我們會再研議。」", "記者追問細節。"},
+		},
+		{
+			Name:     "Clauses of a long sentence",
+			Text:     "交通部宣布，下修年齡，立委反彈。",
+			Limit:    6,
+			Expected: []string{"交通部宣布，", "下修年齡，", "立委反彈。"},
+		},
+		{
+			Name:     "No boundary",
+			Text:     "abcdefgh",
+			Limit:    3,
+			Expected: []string{"abc", "def", "gh"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			pieces := llm.SplitByTokens(tc.Text, tc.Limit, runeTokenizer{})
+			require.Equal(t, tc.Expected, pieces)
+			require.Equal(t, tc.Text, strings.Join(pieces, ""))
+			for _, p := range pieces {
+				require.LessOrEqual(t, utf8.RuneCountInString(p), tc.Limit)
+			}
+		})
+	}
+}
+
+func TestPool(t *testing.T) {
+	vectors := [][]float32{{3, 4}, {0, 5}}
+
+	// (0.6, 0.8) and (0, 1) averaged to (0.3, 0.9), then normalized
+	mean, err := llm.Pool(vectors, llm.PoolingMean)
+	require.NoError(t, err)
+	require.InDeltaSlice(t, []float32{0.31623, 0.94868}, mean, 1e-4)
+
+	// (0.6, 1) normalized
+	maxed, err := llm.Pool(vectors, llm.PoolingMax)
+	require.NoError(t, err)
+	require.InDeltaSlice(t, []float32{0.51450, 0.85749}, maxed, 1e-4)
+
+	_, err = llm.Pool([][]float32{{1, 0}, {1, 0, 0}}, llm.PoolingMean)
+	require.ErrorIs(t, err, llm.ErrDimensionMismatch)
+
+	_, err = llm.Pool(nil, llm.PoolingMean)
+	require.ErrorIs(t, err, llm.ErrNoInput)
+}
+
+func TestApproxTokenizer(t *testing.T) {
+	tok := llm.ApproxTokenizer{}
+	require.Equal(t, 0, tok.CountTokens(""))
+	require.Equal(t, 4, tok.CountTokens("交通部。"))
+	require.Equal(t, 4, tok.CountTokens("energy policy"))
+	require.Equal(t, 4, tok.CountTokens("能源 policy"))
+}
+
+func TestSplitEmbedder(t *testing.T) {
+	ctx := context.Background()
+	short := "立委反彈！"
+	long := "交通部宣布下修年齡。立委反彈！民眾表示支持。"
+
+	t.Run("Model limit", func(t *testing.T) {
+		cli := newFakeEmbedder(0, llm.NewEmbedModel("e5", 12))
+		e := llm.NewSplitEmbedder(cli, runeTokenizer{}, llm.DefaultSplitEmbedConfig())
+
+		embs, err := e.Embed(ctx, &llm.EmbedRequest{
+			Inputs:    []llm.EmbedInput{llm.NewSimpleTextInput(short), llm.NewSimpleTextInput(long)},
+			ModelName: "e5",
+		})
+		require.NoError(t, err)
+		require.Len(t, embs, 2)
+
+		// the chunk within the limit is embedded as is
+		require.Equal(t, llm.SplitEmbedding{
+			Embedding: llm.Embedding{Values: []float32{5, 1}},
+			SubCount:  1,
+		}, embs[0])
+
+		require.True(t, embs[1].WasSplit)
+		require.Equal(t, 2, embs[1].SubCount)
+		expected, err := llm.Pool([][]float32{{10, 1}, {12, 1}}, llm.PoolingMean)
+		require.NoError(t, err)
+		require.InDeltaSlice(t, expected, embs[1].Values, 1e-6)
+		require.Equal(t, []string{short, "交通部宣布下修年齡。", "立委反彈！民眾表示支持。"}, cli.inputs)
+	})
+
+	t.Run("Prefix is kept on every piece", func(t *testing.T) {
+		cli := newFakeEmbedder(0)
+		cfg := llm.DefaultSplitEmbedConfig()
+		cfg.MaxInputTokens = 20
+		e := llm.NewSplitEmbedder(cli, runeTokenizer{}, cfg)
+
+		_, err := e.Embed(ctx, &llm.EmbedRequest{
+			Inputs: []llm.EmbedInput{llm.SimpleTextInput{Prefix: "passage: ", Content: long}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"passage: 交通部宣布下修年齡。", "passage: 立委反彈！", "passage: 民眾表示支持。"}, cli.inputs)
+	})
+
+	t.Run("Truncated input is split", func(t *testing.T) {
+		// the model limit is unknown, the provider truncates at 12 runes
+		cli := newFakeEmbedder(12)
+		e := llm.NewSplitEmbedder(cli, runeTokenizer{}, llm.DefaultSplitEmbedConfig())
+
+		embs, err := e.Embed(ctx, &llm.EmbedRequest{
+			Inputs: []llm.EmbedInput{llm.NewSimpleTextInput(short), llm.NewSimpleTextInput(long)},
+		})
+		require.NoError(t, err)
+		require.False(t, embs[0].WasSplit)
+		require.True(t, embs[1].WasSplit)
+		require.Equal(t, 3, embs[1].SubCount)
+		require.Equal(t, []string{short, long, "交通部宣布下修年齡。", "立委反彈！", "民眾表示支持。"}, cli.inputs)
+	})
+
+	t.Run("Too many splits", func(t *testing.T) {
+		cli := newFakeEmbedder(0)
+		cfg := llm.DefaultSplitEmbedConfig()
+		cfg.MaxInputTokens = 5
+		cfg.MaxSplits = 3
+		e := llm.NewSplitEmbedder(cli, runeTokenizer{}, cfg)
+
+		_, err := e.Embed(ctx, &llm.EmbedRequest{
+			Inputs: []llm.EmbedInput{llm.NewSimpleTextInput(long)},
+		})
+		require.ErrorIs(t, err, llm.ErrTooManySplits)
+		require.Empty(t, cli.inputs)
+	})
+
+	t.Run("Truncated input over the split limit", func(t *testing.T) {
+		cli := newFakeEmbedder(1)
+		cfg := llm.DefaultSplitEmbedConfig()
+		cfg.MaxSplits = 1
+		e := llm.NewSplitEmbedder(cli, runeTokenizer{}, cfg)
+
+		_, err := e.Embed(ctx, &llm.EmbedRequest{
+			Inputs: []llm.EmbedInput{llm.NewSimpleTextInput("交通")},
+		})
+		require.ErrorIs(t, err, llm.ErrTooManySplits)
+	})
+}
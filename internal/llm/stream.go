@@ -0,0 +1,96 @@
+package llm
+
+import (
+	"context"
+)
+
+// GenerateChunk is a piece of a streamed generation. Index counts the chunks
+// of a stream from zero. The last chunk of a stream has Done set, it carries
+// no delta but the error of the generation, if any, and how it ended.
+type GenerateChunk struct {
+	Index int
+	Delta string
+	Done  bool
+	Err   error
+	StreamEnd
+}
+
+// StreamEnd is how a streamed generation ended, as far as the provider
+// reports it.
+type StreamEnd struct {
+	// FinishReason is why the model stopped, e.g. "stop" or "length".
+	FinishReason string
+	Usage        Usage
+}
+
+// Streamer is implemented by the LLMs able to stream a generation while it is
+// produced. The deltas are the raw output of the model, a structured output
+// is not repaired, use Generate for it.
+type Streamer interface {
+	// GenerateStream starts a generation and returns the channel of its
+	// chunks. The channel is closed after the Done chunk, or once ctx is
+	// cancelled. The request is validated before the generation starts.
+	GenerateStream(ctx context.Context, req *GenerateRequest) (<-chan GenerateChunk, error)
+}
+
+// GenerateStream streams a generation from cli. An LLM that is not a Streamer
+// answers with a single chunk holding the output of Generate.
+func GenerateStream(ctx context.Context, cli LLM, req *GenerateRequest) (<-chan GenerateChunk, error) {
+	if s, ok := cli.(Streamer); ok {
+		return s.GenerateStream(ctx, req)
+	}
+
+	resp, err := cli.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamEnd(ctx, func(emit func(delta string) error) (StreamEnd, error) {
+		for _, output := range resp.Outputs {
+			if err := emit(output); err != nil {
+				return StreamEnd{}, err
+			}
+		}
+		return StreamEnd{FinishReason: resp.FinishReason, Usage: resp.Usage}, nil
+	}), nil
+}
+
+// NewStream runs produce in a goroutine and returns the channel of the deltas
+// it emits. Empty deltas are dropped. emit returns the error of ctx once it is
+// cancelled, produce should then return. The Done chunk carries the error
+// returned by produce.
+func NewStream(ctx context.Context, produce func(emit func(delta string) error) error) <-chan GenerateChunk {
+	return NewStreamEnd(ctx, func(emit func(delta string) error) (StreamEnd, error) {
+		return StreamEnd{}, produce(emit)
+	})
+}
+
+// NewStreamEnd is NewStream for a produce reporting how the generation ended,
+// which the Done chunk carries along with the error.
+func NewStreamEnd(ctx context.Context,
+	produce func(emit func(delta string) error) (StreamEnd, error)) <-chan GenerateChunk {
+	ch := make(chan GenerateChunk)
+	go func() {
+		defer close(ch)
+
+		i := 0
+		send := func(chunk GenerateChunk) error {
+			chunk.Index = i
+			select {
+			case ch <- chunk:
+				i++
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		end, err := produce(func(delta string) error {
+			if delta == "" {
+				return ctx.Err()
+			}
+			return send(GenerateChunk{Delta: delta})
+		})
+		_ = send(GenerateChunk{Done: true, Err: err, StreamEnd: end})
+	}()
+	return ch
+}
@@ -0,0 +1,138 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/e2etest"
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/stretchr/testify/require"
+)
+
+// collectStream reads ch until it is closed.
+func collectStream(t *testing.T, ch <-chan llm.GenerateChunk) []llm.GenerateChunk {
+	t.Helper()
+	var chunks []llm.GenerateChunk
+	timeout := time.After(2 * time.Minute)
+	for {
+		select {
+		case chunk, ok := <-ch:
+			if !ok {
+				return chunks
+			}
+			chunks = append(chunks, chunk)
+		case <-timeout:
+			t.Fatal("stream not closed")
+		}
+	}
+}
+
+func TestNewStream(t *testing.T) {
+	t.Run("deltas", func(t *testing.T) {
+		ch := llm.NewStream(context.Background(), func(emit func(delta string) error) error {
+			for _, delta := range []string{"Hello", "", ", ", "world"} {
+				if err := emit(delta); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		chunks := collectStream(t, ch)
+		require.Equal(t, []llm.GenerateChunk{
+			{Index: 0, Delta: "Hello"},
+			{Index: 1, Delta: ", "},
+			{Index: 2, Delta: "world"},
+			{Index: 3, Done: true},
+		}, chunks)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		errBroken := errors.New("broken stream")
+		ch := llm.NewStream(context.Background(), func(emit func(delta string) error) error {
+			if err := emit("partial"); err != nil {
+				return err
+			}
+			return errBroken
+		})
+
+		chunks := collectStream(t, ch)
+		require.Len(t, chunks, 2)
+		require.Equal(t, "partial", chunks[0].Delta)
+		require.True(t, chunks[1].Done)
+		require.ErrorIs(t, chunks[1].Err, errBroken)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan error, 1)
+		ch := llm.NewStream(ctx, func(emit func(delta string) error) error {
+			for {
+				if err := emit("token"); err != nil {
+					stopped <- err
+					return err
+				}
+			}
+		})
+
+		chunk := <-ch
+		require.Equal(t, "token", chunk.Delta)
+		cancel()
+		collectStream(t, ch)
+		require.ErrorIs(t, <-stopped, context.Canceled)
+	})
+}
+
+func TestGenerateStreamFallback(t *testing.T) {
+	cli := e2etest.NewFakeLLM(`{"keywords":["a","b"]}`)
+	ch, err := llm.GenerateStream(context.Background(), cli, &llm.GenerateRequest{
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: []string{"hi"}}},
+	})
+	require.NoError(t, err)
+
+	chunks := collectStream(t, ch)
+	require.Equal(t, []llm.GenerateChunk{
+		{Index: 0, Delta: `{"keywords":["a","b"]}`},
+		{Index: 1, Done: true},
+	}, chunks)
+	require.Equal(t, 1, cli.Calls())
+
+	errDown := errors.New("provider down")
+	cli.Inject(func(ctx context.Context, n int) error { return errDown })
+	_, err = llm.GenerateStream(context.Background(), cli, &llm.GenerateRequest{})
+	require.ErrorIs(t, err, errDown)
+}
+
+// textStreamTests checks that the stream of cli delivers the output in order
+// and ends with a single Done chunk.
+func textStreamTests(t *testing.T, cli llm.LLM) {
+	_, err := llm.GenerateStream(context.Background(), cli, nil)
+	require.ErrorIs(t, err, llm.ErrRequestShouldNotBeNull)
+
+	_, err = llm.GenerateStream(context.Background(), cli, &llm.GenerateRequest{})
+	require.ErrorIs(t, err, llm.ErrNoInput)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ch, err := llm.GenerateStream(ctx, cli, &llm.GenerateRequest{
+		Messages: []llm.Message{{
+			Role:    llm.RoleUser,
+			Content: []string{"Count from 1 to 20, separated by spaces."},
+		}},
+	})
+	require.NoError(t, err)
+
+	chunks := collectStream(t, ch)
+	require.NotEmpty(t, chunks)
+	sb := strings.Builder{}
+	for i, chunk := range chunks {
+		require.Equal(t, i, chunk.Index)
+		require.Equal(t, i == len(chunks)-1, chunk.Done)
+		sb.WriteString(chunk.Delta)
+	}
+	require.NoError(t, chunks[len(chunks)-1].Err)
+	require.Contains(t, sb.String(), "20")
+}
@@ -0,0 +1,152 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ErrNoOutput         = errors.New("no output from the model")
+	ErrStructuredOutput = errors.New("model failed to produce a valid structured output")
+)
+
+// StructuredRepairPrompt is the message asking the model to correct its
+// previous output, with the error found in it.
+const StructuredRepairPrompt = "Your previous response could not be used: %s. " +
+	"Reply with the corrected JSON only, matching the schema, without any explanation."
+
+var structuredRepromptsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "llm_structured_reprompts_total",
+	Help: "Number of times a model was asked to correct its structured output, by schema.",
+}, []string{"schema"})
+
+// StructuredResult is the outcome of GenerateStructured.
+type StructuredResult struct {
+	// Response is the last response of the model.
+	Response *GenerateResponse
+	// Attempts is the number of requests made, 1 if the first output was valid.
+	Attempts int
+	// Repairs are the fixes RepairJSON applied to the last output.
+	Repairs []RepairAction
+	// Usage is the number of tokens used by all the requests.
+	Usage Usage
+}
+
+// GenerateStructured generates with req and unmarshals the output into out, a
+// non-nil pointer. An output which does not unmarshal, or does not match the
+// schema of req, is repaired with RepairJSON first. If it still fails, the
+// model is shown its output and the error, and asked to correct it, up to
+// maxRepairs times. This costs the previous output and a short message instead
+// of the whole prompt again.
+//
+// The errors of the client are returned as they are, they are retried by its
+// retry policy. Once the repairs are exhausted, the error wraps
+// ErrStructuredOutput and the last failure. The result is returned along with
+// the error if the model answered at least once.
+func GenerateStructured(ctx context.Context, cli LLM, req *GenerateRequest, out any,
+	maxRepairs int) (*StructuredResult, error) {
+	if req == nil {
+		return nil, ErrRequestShouldNotBeNull
+	}
+	if rv := reflect.ValueOf(out); rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return nil, fmt.Errorf("out should be a non-nil pointer, got %T", out)
+	}
+	if maxRepairs < 0 {
+		return nil, fmt.Errorf("max repairs should not be negative, got %d", maxRepairs)
+	}
+
+	schemaName := ""
+	if req.Schema != nil {
+		schemaName = req.Schema.Name
+	}
+
+	result := &StructuredResult{}
+	messages := slices.Clip(req.Messages)
+	for {
+		attempt := *req
+		attempt.Messages = messages
+		resp, err := cli.Generate(ctx, &attempt)
+		if err != nil {
+			if result.Attempts == 0 {
+				return nil, err
+			}
+			return result, err
+		}
+		result.Response = resp
+		result.Attempts++
+		result.Usage = result.Usage.Add(resp.Usage)
+
+		if len(resp.Outputs) == 0 {
+			return result, ErrNoOutput
+		}
+
+		output := resp.Outputs[0]
+		repairs, err := decodeStructured(output, resp.SchemaErr, req.Schema, out)
+		if err == nil {
+			result.Repairs = repairs
+			return result, nil
+		}
+		if result.Attempts > maxRepairs {
+			return result, fmt.Errorf("%w after %d attempts: %w", ErrStructuredOutput, result.Attempts, err)
+		}
+
+		global.Logger.Debug().
+			Err(err).
+			Str("schema", schemaName).
+			Int("attempt", result.Attempts).
+			Msg("asking the model to correct its structured output")
+		structuredRepromptsTotal.WithLabelValues(schemaName).Inc()
+		messages = append(messages,
+			Message{Role: RoleAssistant, Content: []string{output}},
+			Message{Role: RoleUser, Content: []string{fmt.Sprintf(StructuredRepairPrompt, err)}},
+		)
+	}
+}
+
+// decodeStructured unmarshals output into out, after checking it against
+// schema unless schemaErr already holds the result of the check. A failing
+// output is repaired once before giving up.
+func decodeStructured(output string, schemaErr error, schema *ResponseSchema, out any) ([]RepairAction, error) {
+	if schema != nil && schemaErr == nil {
+		schemaErr = ValidateOutput(output, schema)
+	}
+
+	err := schemaErr
+	if err == nil {
+		if err = unmarshalInto(output, out); err == nil {
+			return nil, nil
+		}
+	}
+
+	repaired, actions, rErr := RepairJSON(output, schema.JSONSchema())
+	if rErr != nil || len(actions) == 0 {
+		return nil, err
+	}
+	if schema != nil {
+		if err := ValidateOutput(repaired, schema); err != nil {
+			return nil, err
+		}
+	}
+	if err := unmarshalInto(repaired, out); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// unmarshalInto unmarshals data into out, reset first so that nothing is left
+// of a previous output.
+func unmarshalInto(data string, out any) error {
+	reflect.ValueOf(out).Elem().SetZero()
+	if err := json.Unmarshal([]byte(data), out); err != nil {
+		return fmt.Errorf("invalid json: %w", err)
+	}
+	return nil
+}
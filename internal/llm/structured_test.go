@@ -0,0 +1,179 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/llm/llmtest"
+	"github.com/stretchr/testify/require"
+)
+
+type structuredOutput struct {
+	Title string   `json:"title"`
+	Score int      `json:"score"`
+	Tags  []string `json:"tags"`
+}
+
+var structuredSchema = &llm.ResponseSchema{Name: "article", S: map[string]any{
+	"type":                 "object",
+	"required":             []string{"title", "score"},
+	"additionalProperties": false,
+	"properties": map[string]any{
+		"title": map[string]any{"type": "string"},
+		"score": map[string]any{"type": "integer"},
+		"tags":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+	},
+}}
+
+func structuredRequest(schema *llm.ResponseSchema) *llm.GenerateRequest {
+	return &llm.GenerateRequest{
+		Messages: []llm.Message{
+			{Role: llm.RoleSystem, Content: []string{"Rate the article"}},
+			{Role: llm.RoleUser, Content: []string{"三鶯線 opens"}},
+		},
+		ModelName: "gemma3",
+		Schema:    schema,
+	}
+}
+
+func output(s string, promptTokens int) *llm.GenerateResponse {
+	return &llm.GenerateResponse{
+		Outputs: []string{s},
+		Usage:   llm.Usage{PromptTokens: promptTokens, CompletionTokens: 10, TotalTokens: promptTokens + 10},
+	}
+}
+
+func TestGenerateStructured(t *testing.T) {
+	ctx := context.Background()
+	valid := `{"title":"三鶯線","score":4,"tags":["transit"]}`
+	want := structuredOutput{Title: "三鶯線", Score: 4, Tags: []string{"transit"}}
+
+	t.Run("malformed then valid", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().
+			QueueGenerate(output(`The rating is {"title": "三鶯線", "score": 4`+"\n"+`"tags"`, 100), nil).
+			QueueGenerate(output(valid, 130), nil)
+
+		var out structuredOutput
+		result, err := llm.GenerateStructured(ctx, cli, structuredRequest(structuredSchema), &out, 2)
+		require.NoError(t, err)
+		require.Equal(t, want, out)
+		require.Equal(t, 2, result.Attempts)
+		require.Equal(t, llm.Usage{PromptTokens: 230, CompletionTokens: 20, TotalTokens: 250}, result.Usage)
+
+		reqs := cli.GenerateRequests()
+		require.Len(t, reqs, 2)
+		require.Len(t, reqs[0].Messages, 2)
+		require.Len(t, reqs[1].Messages, 4)
+		require.Equal(t, llm.RoleAssistant, reqs[1].Messages[2].Role)
+		require.Equal(t, cli.Calls()[0].Request.(*llm.GenerateRequest).Messages, reqs[1].Messages[:2])
+		require.Equal(t, llm.RoleUser, reqs[1].Messages[3].Role)
+		require.Contains(t, reqs[1].Messages[3].Content[0], "invalid json")
+		require.Equal(t, structuredSchema, reqs[1].Schema)
+	})
+
+	t.Run("schema violation", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().
+			QueueOutput(`{"title":"三鶯線","score":"four"}`).
+			QueueOutput(valid)
+
+		var out structuredOutput
+		result, err := llm.GenerateStructured(ctx, cli, structuredRequest(structuredSchema), &out, 1)
+		require.NoError(t, err)
+		require.Equal(t, want, out)
+		require.Equal(t, 2, result.Attempts)
+
+		reprompt := cli.GenerateRequests()[1].Messages[3].Content[0]
+		require.Contains(t, reprompt, "$.score")
+		require.Contains(t, reprompt, `expected an integer, got "four"`)
+	})
+
+	t.Run("repaired without asking again", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().QueueOutput("```json\n{'title':'三鶯線','score':4,'tags':['transit',]}\n```")
+
+		var out structuredOutput
+		result, err := llm.GenerateStructured(ctx, cli, structuredRequest(structuredSchema), &out, 2)
+		require.NoError(t, err)
+		require.Equal(t, want, out)
+		require.Equal(t, 1, result.Attempts)
+		require.Equal(t, []llm.RepairAction{llm.RepairStripFences, llm.RepairSingleQuotes,
+			llm.RepairTrailingCommas}, result.Repairs)
+	})
+
+	t.Run("without schema", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().
+			QueueOutput("I cannot rate it.").
+			QueueOutput(`{"title":"三鶯線","score":4,"tags":["transit"],"extra":true}`)
+
+		var out structuredOutput
+		result, err := llm.GenerateStructured(ctx, cli, structuredRequest(nil), &out, 1)
+		require.NoError(t, err)
+		require.Equal(t, want, out)
+		require.Equal(t, 2, result.Attempts)
+	})
+
+	t.Run("repairs exhausted", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().
+			QueueOutput(`{"title":"三鶯線"}`).
+			QueueOutput(`{"title":"三鶯線"}`).
+			QueueOutput(`{"title":"三鶯線"}`)
+
+		var out structuredOutput
+		result, err := llm.GenerateStructured(ctx, cli, structuredRequest(structuredSchema), &out, 2)
+		require.ErrorIs(t, err, llm.ErrStructuredOutput)
+		require.ErrorIs(t, err, llm.ErrSchemaViolation)
+		require.ErrorContains(t, err, "after 3 attempts")
+		require.Equal(t, 3, result.Attempts)
+		require.Zero(t, cli.Pending())
+		require.Len(t, cli.GenerateRequests()[2].Messages, 6)
+	})
+
+	t.Run("no repair", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().QueueOutput("not json").QueueOutput(valid)
+
+		var out structuredOutput
+		_, err := llm.GenerateStructured(ctx, cli, structuredRequest(structuredSchema), &out, 0)
+		require.ErrorIs(t, err, llm.ErrStructuredOutput)
+		require.Equal(t, 1, cli.Pending())
+	})
+
+	t.Run("client error", func(t *testing.T) {
+		errDown := errors.New("provider down")
+		cli := llmtest.NewMockLLM().QueueOutput("not json").QueueGenerate(nil, errDown)
+
+		var out structuredOutput
+		result, err := llm.GenerateStructured(ctx, cli, structuredRequest(structuredSchema), &out, 2)
+		require.ErrorIs(t, err, errDown)
+		require.Equal(t, 1, result.Attempts)
+
+		result, err = llm.GenerateStructured(ctx, llmtest.NewMockLLM().QueueGenerate(nil, errDown),
+			structuredRequest(structuredSchema), &out, 2)
+		require.ErrorIs(t, err, errDown)
+		require.Nil(t, result)
+	})
+
+	t.Run("no output", func(t *testing.T) {
+		cli := llmtest.NewMockLLM().QueueGenerate(&llm.GenerateResponse{}, nil)
+
+		var out structuredOutput
+		_, err := llm.GenerateStructured(ctx, cli, structuredRequest(structuredSchema), &out, 2)
+		require.ErrorIs(t, err, llm.ErrNoOutput)
+		require.Len(t, cli.Calls(), 1)
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		cli := llmtest.NewMockLLM()
+		var out structuredOutput
+		_, err := llm.GenerateStructured(ctx, cli, nil, &out, 1)
+		require.ErrorIs(t, err, llm.ErrRequestShouldNotBeNull)
+
+		_, err = llm.GenerateStructured(ctx, cli, structuredRequest(nil), out, 1)
+		require.ErrorContains(t, err, "non-nil pointer")
+
+		_, err = llm.GenerateStructured(ctx, cli, structuredRequest(nil), &out, -1)
+		require.True(t, strings.Contains(err.Error(), "negative"))
+		require.Empty(t, cli.Calls())
+	})
+}
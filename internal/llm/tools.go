@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidTool = errors.New("invalid tool")
+
+// ToolDefinition is a function the model may call instead of answering, e.g.
+// lookup_party_positions. The model answers with the ToolCalls of a
+// GenerateResponse, the results are sent back in RoleTool messages.
+type ToolDefinition struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the arguments, e.g. a map or a
+	// *jsonschema.Schema. A function without parameters leaves it nil.
+	Parameters any
+	// Strict makes the arguments follow Parameters exactly, where the
+	// provider supports it.
+	Strict bool
+}
+
+// Validate checks that the tool has a name.
+func (t ToolDefinition) Validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("%w: tool should have a name", ErrInvalidTool)
+	}
+	return nil
+}
+
+// ParametersMap returns Parameters as a map, converting it through its JSON
+// encoding if it is of another type. It returns nil if there are no
+// parameters.
+func (t ToolDefinition) ParametersMap() (map[string]any, error) {
+	switch p := t.Parameters.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return p, nil
+	}
+
+	data, err := json.Marshal(t.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to encode the parameters of %s: %w", ErrInvalidTool, t.Name, err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w: the parameters of %s should be a JSON object: %w", ErrInvalidTool, t.Name, err)
+	}
+	return m, nil
+}
+
+// ToolCall is a call of a tool by the model.
+type ToolCall struct {
+	// ID identifies the call, the result of the call is sent back with it.
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Arguments is the JSON object of the arguments.
+	Arguments string `json:"arguments"`
+}
+
+// NewToolMessage creates the message of the result of the call of callID.
+func NewToolMessage(callID, result string) Message {
+	return Message{Role: RoleTool, Content: []string{result}, ToolCallID: callID}
+}
+
+// NewToolCallMessage creates the assistant message of calls, to send them back
+// along with their results in the next request of a conversation.
+func NewToolCallMessage(calls ...ToolCall) Message {
+	return Message{Role: RoleAssistant, ToolCalls: calls}
+}
+
+// UsesTools reports whether the request has tools, or a message of a tool
+// conversation: a tool call or the result of one.
+func (req GenerateRequest) UsesTools() bool {
+	return len(req.Tools) > 0 || slices.ContainsFunc(req.Messages, func(msg Message) bool {
+		return msg.Role == RoleTool || len(msg.ToolCalls) > 0
+	})
+}
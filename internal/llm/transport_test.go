@@ -0,0 +1,81 @@
+package llm_test
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/llm/llmtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyTransport(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	proxy := llmtest.NewProxy()
+	defer proxy.Close()
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("weathercock:s3cret"))
+
+	get := func(t *testing.T, client *http.Client, url string) {
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "ok", string(body))
+	}
+
+	t.Run("http", func(t *testing.T) {
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		transport, err := llm.ProxyTransport(proxy.ProxyURL("weathercock", "s3cret"))
+		require.NoError(t, err)
+		get(t, llm.HTTPClientWithTransport(nil, transport), server.URL+"/models")
+
+		reqs := proxy.Requests()
+		require.Equal(t, llmtest.ProxyRequest{Method: http.MethodGet, Target: server.URL + "/models", Auth: auth},
+			reqs[len(reqs)-1])
+	})
+
+	t.Run("https", func(t *testing.T) {
+		server := httptest.NewTLSServer(handler)
+		defer server.Close()
+
+		transport, err := llm.ProxyTransport(proxy.ProxyURL("weathercock", "s3cret"))
+		require.NoError(t, err)
+		transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+		get(t, &http.Client{Transport: transport}, server.URL)
+
+		reqs := proxy.Requests()
+		require.Equal(t, llmtest.ProxyRequest{Method: http.MethodConnect, Target: server.Listener.Addr().String(), Auth: auth},
+			reqs[len(reqs)-1])
+	})
+
+	t.Run("without scheme", func(t *testing.T) {
+		transport, err := llm.ProxyTransport("proxy.internal:3128")
+		require.NoError(t, err)
+		u, err := transport.Proxy(httptest.NewRequest(http.MethodGet, "https://api.openai.com/v1/models", nil))
+		require.NoError(t, err)
+		require.Equal(t, "http://proxy.internal:3128", u.String())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, rawURL := range []string{"ftp://proxy.internal", "http://", "http://proxy.internal:port"} {
+			_, err := llm.ProxyTransport(rawURL)
+			require.ErrorIs(t, err, llm.ErrInvalidProxy, rawURL)
+		}
+	})
+
+	t.Run("client copied", func(t *testing.T) {
+		transport, err := llm.ProxyTransport(proxy.URL)
+		require.NoError(t, err)
+		c := &http.Client{}
+		require.Same(t, transport, llm.HTTPClientWithTransport(c, transport).Transport)
+		require.Nil(t, c.Transport)
+	})
+}
@@ -12,6 +12,114 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const countListedArticles = `-- name: CountListedArticles :one
+SELECT COUNT(*)::bigint AS total
+FROM articles AS a
+WHERE (
+        $1::text = ''
+        OR a.party::text = $1::text
+    )
+    AND (
+        $2::text = ''
+        OR a.source = $2::text
+    )
+    AND (
+        $3::timestamptz IS NULL
+        OR a.published_at >= $3::timestamptz
+    )
+    AND (
+        $4::timestamptz IS NULL
+        OR a.published_at < $4::timestamptz
+    )
+    AND (
+        $5::text = ''
+        OR a.title ILIKE '%' || $5::text || '%'
+    )
+`
+
+type CountListedArticlesParams struct {
+	Party  string             `db:"party" json:"party"`
+	Source string             `db:"source" json:"source"`
+	Since  pgtype.Timestamptz `db:"since" json:"since"`
+	Until  pgtype.Timestamptz `db:"until" json:"until"`
+	Query  string             `db:"query" json:"query"`
+}
+
+// Counts the articles ListArticles lists under the same filter, regardless of
+// the cursor.
+func (q *Queries) CountListedArticles(ctx context.Context, arg CountListedArticlesParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countListedArticles,
+		arg.Party,
+		arg.Source,
+		arg.Since,
+		arg.Until,
+		arg.Query,
+	)
+	var total int64
+	err := row.Scan(&total)
+	return total, err
+}
+
+const countUsersArticlesByTaskStatus = `-- name: CountUsersArticlesByTaskStatus :one
+SELECT COUNT(*)::bigint AS total
+FROM users.articles AS a
+    JOIN users.tasks AS t ON t.task_id = a.task_id
+WHERE t.status = $1::task_status
+`
+
+func (q *Queries) CountUsersArticlesByTaskStatus(ctx context.Context, taskStatus TaskStatus) (int64, error) {
+	row := q.db.QueryRow(ctx, countUsersArticlesByTaskStatus, taskStatus)
+	var total int64
+	err := row.Scan(&total)
+	return total, err
+}
+
+const deleteUsersArticleKeywords = `-- name: DeleteUsersArticleKeywords :exec
+DELETE FROM users.articles_keywords
+WHERE article_id = $1
+`
+
+func (q *Queries) DeleteUsersArticleKeywords(ctx context.Context, articleID int32) error {
+	_, err := q.db.Exec(ctx, deleteUsersArticleKeywords, articleID)
+	return err
+}
+
+const existsArticleByMD5 = `-- name: ExistsArticleByMD5 :one
+SELECT EXISTS (
+        SELECT 1
+        FROM articles
+        WHERE md5 = $1
+    ) AS found
+`
+
+func (q *Queries) ExistsArticleByMD5(ctx context.Context, md5 string) (bool, error) {
+	row := q.db.QueryRow(ctx, existsArticleByMD5, md5)
+	var found bool
+	err := row.Scan(&found)
+	return found, err
+}
+
+const existsUsersArticleByMD5 = `-- name: ExistsUsersArticleByMD5 :one
+SELECT EXISTS (
+        SELECT 1
+        FROM users.articles
+        WHERE task_id = $1
+            AND md5 = $2
+    ) AS found
+`
+
+type ExistsUsersArticleByMD5Params struct {
+	TaskID uuid.UUID `db:"task_id" json:"task_id"`
+	Md5    string    `db:"md5" json:"md5"`
+}
+
+func (q *Queries) ExistsUsersArticleByMD5(ctx context.Context, arg ExistsUsersArticleByMD5Params) (bool, error) {
+	row := q.db.QueryRow(ctx, existsUsersArticleByMD5, arg.TaskID, arg.Md5)
+	var found bool
+	err := row.Scan(&found)
+	return found, err
+}
+
 const extractChunks = `-- name: ExtractChunks :many
 SELECT c.article_id AS article_id,
     c.id AS chunk_id,
@@ -91,7 +199,7 @@ func (q *Queries) ExtractUsersChunks(ctx context.Context, id int32) ([]ExtractUs
 }
 
 const getArticleByID = `-- name: GetArticleByID :one
-SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at
+SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at, modified_at, canonical_id
 FROM articles
 WHERE id = $1
 `
@@ -110,12 +218,14 @@ func (q *Queries) GetArticleByID(ctx context.Context, id int32) (Article, error)
 		&i.Cuts,
 		&i.PublishedAt,
 		&i.CreatedAt,
+		&i.ModifiedAt,
+		&i.CanonicalID,
 	)
 	return i, err
 }
 
 const getArticleByIDs = `-- name: GetArticleByIDs :many
-SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at
+SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at, modified_at, canonical_id
 FROM articles
 WHERE id = ANY($1::integer[])
 `
@@ -140,6 +250,8 @@ func (q *Queries) GetArticleByIDs(ctx context.Context, ids []int32) ([]Article,
 			&i.Cuts,
 			&i.PublishedAt,
 			&i.CreatedAt,
+			&i.ModifiedAt,
+			&i.CanonicalID,
 		); err != nil {
 			return nil, err
 		}
@@ -152,7 +264,7 @@ func (q *Queries) GetArticleByIDs(ctx context.Context, ids []int32) ([]Article,
 }
 
 const getArticleByMD5 = `-- name: GetArticleByMD5 :one
-SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at
+SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at, modified_at, canonical_id
 FROM articles
 WHERE md5 = $1
 `
@@ -171,12 +283,14 @@ func (q *Queries) GetArticleByMD5(ctx context.Context, md5 string) (Article, err
 		&i.Cuts,
 		&i.PublishedAt,
 		&i.CreatedAt,
+		&i.ModifiedAt,
+		&i.CanonicalID,
 	)
 	return i, err
 }
 
 const getArticleByURL = `-- name: GetArticleByURL :one
-SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at
+SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at, modified_at, canonical_id
 FROM articles
 WHERE "url" = $1
 ORDER BY published_at DESC
@@ -197,12 +311,14 @@ func (q *Queries) GetArticleByURL(ctx context.Context, url string) (Article, err
 		&i.Cuts,
 		&i.PublishedAt,
 		&i.CreatedAt,
+		&i.ModifiedAt,
+		&i.CanonicalID,
 	)
 	return i, err
 }
 
 const getArticleWithinTimeInterval = `-- name: GetArticleWithinTimeInterval :many
-SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at
+SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at, modified_at, canonical_id
 FROM articles
 WHERE published_at BETWEEN $1 AND $2
 ORDER BY published_at DESC
@@ -235,6 +351,8 @@ func (q *Queries) GetArticleWithinTimeInterval(ctx context.Context, arg GetArtic
 			&i.Cuts,
 			&i.PublishedAt,
 			&i.CreatedAt,
+			&i.ModifiedAt,
+			&i.CanonicalID,
 		); err != nil {
 			return nil, err
 		}
@@ -247,7 +365,7 @@ func (q *Queries) GetArticleWithinTimeInterval(ctx context.Context, arg GetArtic
 }
 
 const getArticlesInPastKDays = `-- name: GetArticlesInPastKDays :many
-SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at
+SELECT id, title, url, source, md5, party, content, cuts, published_at, created_at, modified_at, canonical_id
 FROM articles
 WHERE published_at >= NOW() - INTERVAL '1 day' * $1::integer
 ORDER BY published_at DESC
@@ -279,6 +397,8 @@ func (q *Queries) GetArticlesInPastKDays(ctx context.Context, arg GetArticlesInP
 			&i.Cuts,
 			&i.PublishedAt,
 			&i.CreatedAt,
+			&i.ModifiedAt,
+			&i.CanonicalID,
 		); err != nil {
 			return nil, err
 		}
@@ -291,7 +411,7 @@ func (q *Queries) GetArticlesInPastKDays(ctx context.Context, arg GetArticlesInP
 }
 
 const getUsersArticleByID = `-- name: GetUsersArticleByID :one
-SELECT id, task_id, title, url, source, md5, content, cuts, published_at, created_at
+SELECT id, task_id, title, url, source, md5, content, cuts, published_at, created_at, needs_review, modified_at, content_hash, last_checked_at
 FROM users.articles
 WHERE id = $1
 `
@@ -310,18 +430,28 @@ func (q *Queries) GetUsersArticleByID(ctx context.Context, id int32) (UsersArtic
 		&i.Cuts,
 		&i.PublishedAt,
 		&i.CreatedAt,
+		&i.NeedsReview,
+		&i.ModifiedAt,
+		&i.ContentHash,
+		&i.LastCheckedAt,
 	)
 	return i, err
 }
 
 const getUsersArticleByMD5 = `-- name: GetUsersArticleByMD5 :one
-SELECT id, task_id, title, url, source, md5, content, cuts, published_at, created_at
+SELECT id, task_id, title, url, source, md5, content, cuts, published_at, created_at, needs_review, modified_at, content_hash, last_checked_at
 FROM users.articles
-WHERE md5 = $1
+WHERE task_id = $1
+    AND md5 = $2
 `
 
-func (q *Queries) GetUsersArticleByMD5(ctx context.Context, md5 string) (UsersArticle, error) {
-	row := q.db.QueryRow(ctx, getUsersArticleByMD5, md5)
+type GetUsersArticleByMD5Params struct {
+	TaskID uuid.UUID `db:"task_id" json:"task_id"`
+	Md5    string    `db:"md5" json:"md5"`
+}
+
+func (q *Queries) GetUsersArticleByMD5(ctx context.Context, arg GetUsersArticleByMD5Params) (UsersArticle, error) {
+	row := q.db.QueryRow(ctx, getUsersArticleByMD5, arg.TaskID, arg.Md5)
 	var i UsersArticle
 	err := row.Scan(
 		&i.ID,
@@ -334,12 +464,16 @@ func (q *Queries) GetUsersArticleByMD5(ctx context.Context, md5 string) (UsersAr
 		&i.Cuts,
 		&i.PublishedAt,
 		&i.CreatedAt,
+		&i.NeedsReview,
+		&i.ModifiedAt,
+		&i.ContentHash,
+		&i.LastCheckedAt,
 	)
 	return i, err
 }
 
 const getUsersArticleByTaskID = `-- name: GetUsersArticleByTaskID :one
-SELECT id, task_id, title, url, source, md5, content, cuts, published_at, created_at
+SELECT id, task_id, title, url, source, md5, content, cuts, published_at, created_at, needs_review, modified_at, content_hash, last_checked_at
 FROM users.articles
 WHERE task_id = $1
 `
@@ -358,10 +492,117 @@ func (q *Queries) GetUsersArticleByTaskID(ctx context.Context, taskID uuid.UUID)
 		&i.Cuts,
 		&i.PublishedAt,
 		&i.CreatedAt,
+		&i.NeedsReview,
+		&i.ModifiedAt,
+		&i.ContentHash,
+		&i.LastCheckedAt,
 	)
 	return i, err
 }
 
+const getUsersChunksByArticleID = `-- name: GetUsersChunksByArticleID :many
+SELECT id,
+    article_id,
+    "start",
+    offset_left,
+    offset_right,
+    "end",
+    created_at
+FROM users.chunks
+WHERE article_id = $1
+ORDER BY "start",
+    id
+`
+
+func (q *Queries) GetUsersChunksByArticleID(ctx context.Context, articleID int32) ([]UsersChunk, error) {
+	rows, err := q.db.Query(ctx, getUsersChunksByArticleID, articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UsersChunk
+	for rows.Next() {
+		var i UsersChunk
+		if err := rows.Scan(
+			&i.ID,
+			&i.ArticleID,
+			&i.Start,
+			&i.OffsetLeft,
+			&i.OffsetRight,
+			&i.End,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUsersChunksWithContent = `-- name: GetUsersChunksWithContent :many
+SELECT c.id,
+    c.article_id,
+    c."start",
+    c.offset_left,
+    c.offset_right,
+    c."end",
+    c.created_at,
+    substring(
+        a.content
+        FROM c."start" + 1 FOR (c."end" - c."start")
+    )::text AS content
+FROM users.chunks AS c
+    JOIN users.articles AS a ON a.id = c.article_id
+WHERE c.article_id = $1
+ORDER BY c."start",
+    c.id
+`
+
+type GetUsersChunksWithContentRow struct {
+	ID          int32              `db:"id" json:"id"`
+	ArticleID   int32              `db:"article_id" json:"article_id"`
+	Start       int32              `db:"start" json:"start"`
+	OffsetLeft  int32              `db:"offset_left" json:"offset_left"`
+	OffsetRight int32              `db:"offset_right" json:"offset_right"`
+	End         int32              `db:"end" json:"end"`
+	CreatedAt   pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	Content     string             `db:"content" json:"content"`
+}
+
+// The chunks of the article with their text, the substring of the content
+// from start to end.
+func (q *Queries) GetUsersChunksWithContent(ctx context.Context, articleID int32) ([]GetUsersChunksWithContentRow, error) {
+	rows, err := q.db.Query(ctx, getUsersChunksWithContent, articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUsersChunksWithContentRow
+	for rows.Next() {
+		var i GetUsersChunksWithContentRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ArticleID,
+			&i.Start,
+			&i.OffsetLeft,
+			&i.OffsetRight,
+			&i.End,
+			&i.CreatedAt,
+			&i.Content,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const insertArticle = `-- name: InsertArticle :one
 INSERT INTO articles (
         title,
@@ -371,7 +612,8 @@ INSERT INTO articles (
         party,
         content,
         cuts,
-        published_at
+        published_at,
+        modified_at
     )
 VALUES (
         $1,
@@ -381,7 +623,8 @@ VALUES (
         $5,
         $6,
         $7,
-        $8
+        $8,
+        $9
     )
 RETURNING id
 `
@@ -395,6 +638,7 @@ type InsertArticleParams struct {
 	Content     string             `db:"content" json:"content"`
 	Cuts        []int32            `db:"cuts" json:"cuts"`
 	PublishedAt pgtype.Timestamptz `db:"published_at" json:"published_at"`
+	ModifiedAt  pgtype.Timestamptz `db:"modified_at" json:"modified_at"`
 }
 
 func (q *Queries) InsertArticle(ctx context.Context, arg InsertArticleParams) (int32, error) {
@@ -407,6 +651,7 @@ func (q *Queries) InsertArticle(ctx context.Context, arg InsertArticleParams) (i
 		arg.Content,
 		arg.Cuts,
 		arg.PublishedAt,
+		arg.ModifiedAt,
 	)
 	var id int32
 	err := row.Scan(&id)
@@ -510,7 +755,9 @@ INSERT INTO users.articles (
         md5,
         content,
         cuts,
-        published_at
+        published_at,
+        modified_at,
+        content_hash
     )
 VALUES (
         $1,
@@ -520,7 +767,9 @@ VALUES (
         $5,
         $6,
         $7,
-        $8
+        $8,
+        $9,
+        $10
     )
 RETURNING id
 `
@@ -534,6 +783,8 @@ type InsertUsersArticleParams struct {
 	Content     string             `db:"content" json:"content"`
 	Cuts        []int32            `db:"cuts" json:"cuts"`
 	PublishedAt pgtype.Timestamptz `db:"published_at" json:"published_at"`
+	ModifiedAt  pgtype.Timestamptz `db:"modified_at" json:"modified_at"`
+	ContentHash string             `db:"content_hash" json:"content_hash"`
 }
 
 func (q *Queries) InsertUsersArticle(ctx context.Context, arg InsertUsersArticleParams) (int32, error) {
@@ -546,6 +797,8 @@ func (q *Queries) InsertUsersArticle(ctx context.Context, arg InsertUsersArticle
 		arg.Content,
 		arg.Cuts,
 		arg.PublishedAt,
+		arg.ModifiedAt,
+		arg.ContentHash,
 	)
 	var id int32
 	err := row.Scan(&id)
@@ -584,3 +837,498 @@ func (q *Queries) InsertUsersChunk(ctx context.Context, arg InsertUsersChunkPara
 	err := row.Scan(&id)
 	return id, err
 }
+
+const listArticles = `-- name: ListArticles :many
+SELECT a.id,
+    a.title,
+    a."url",
+    a.source,
+    a.party,
+    a.published_at,
+    a.modified_at
+FROM articles AS a
+WHERE (
+        $1::text = ''
+        OR a.party::text = $1::text
+    )
+    AND (
+        $2::text = ''
+        OR a.source = $2::text
+    )
+    AND (
+        $3::timestamptz IS NULL
+        OR a.published_at >= $3::timestamptz
+    )
+    AND (
+        $4::timestamptz IS NULL
+        OR a.published_at < $4::timestamptz
+    )
+    AND (
+        $5::text = ''
+        OR a.title ILIKE '%' || $5::text || '%'
+    )
+    AND (
+        $6::integer IS NULL
+        OR (
+            $7::boolean
+            AND (a.published_at, a.id) > (
+                $8::timestamptz,
+                $6::integer
+            )
+        )
+        OR (
+            NOT $7::boolean
+            AND (a.published_at, a.id) < (
+                $8::timestamptz,
+                $6::integer
+            )
+        )
+    )
+ORDER BY CASE
+        WHEN $7::boolean THEN a.published_at
+    END,
+    CASE
+        WHEN $7::boolean THEN a.id
+    END,
+    a.published_at DESC,
+    a.id DESC
+LIMIT $9::integer OFFSET $10::integer
+`
+
+type ListArticlesParams struct {
+	Party            string             `db:"party" json:"party"`
+	Source           string             `db:"source" json:"source"`
+	Since            pgtype.Timestamptz `db:"since" json:"since"`
+	Until            pgtype.Timestamptz `db:"until" json:"until"`
+	Query            string             `db:"query" json:"query"`
+	AfterID          pgtype.Int4        `db:"after_id" json:"after_id"`
+	Ascending        bool               `db:"ascending" json:"ascending"`
+	AfterPublishedAt pgtype.Timestamptz `db:"after_published_at" json:"after_published_at"`
+	Limit            int32              `db:"limit" json:"limit"`
+	Offset           int32              `db:"offset" json:"offset"`
+}
+
+type ListArticlesRow struct {
+	ID          int32              `db:"id" json:"id"`
+	Title       string             `db:"title" json:"title"`
+	Url         string             `db:"url" json:"url"`
+	Source      string             `db:"source" json:"source"`
+	Party       Party              `db:"party" json:"party"`
+	PublishedAt pgtype.Timestamptz `db:"published_at" json:"published_at"`
+	ModifiedAt  pgtype.Timestamptz `db:"modified_at" json:"modified_at"`
+}
+
+// Lists the articles published in [since, until) which match the filter, the
+// newest first or, if ascending, the oldest first, after the cursor
+// (after_published_at, after_id) if one is given. An empty party, source or
+// query and a NULL bound match every article, the query matches the titles
+// case-insensitively.
+func (q *Queries) ListArticles(ctx context.Context, arg ListArticlesParams) ([]ListArticlesRow, error) {
+	rows, err := q.db.Query(ctx, listArticles,
+		arg.Party,
+		arg.Source,
+		arg.Since,
+		arg.Until,
+		arg.Query,
+		arg.AfterID,
+		arg.Ascending,
+		arg.AfterPublishedAt,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListArticlesRow
+	for rows.Next() {
+		var i ListArticlesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Url,
+			&i.Source,
+			&i.Party,
+			&i.PublishedAt,
+			&i.ModifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersArticlesByTaskStatus = `-- name: ListUsersArticlesByTaskStatus :many
+SELECT a.id,
+    a.task_id,
+    a.title,
+    a."url",
+    a.source,
+    a.published_at,
+    a.modified_at,
+    a.needs_review
+FROM users.articles AS a
+    JOIN users.tasks AS t ON t.task_id = a.task_id
+WHERE t.status = $1::task_status
+ORDER BY a.published_at DESC,
+    a.id DESC
+LIMIT $2::integer OFFSET $3::integer
+`
+
+type ListUsersArticlesByTaskStatusParams struct {
+	TaskStatus TaskStatus `db:"task_status" json:"task_status"`
+	Limit      int32      `db:"limit" json:"limit"`
+	Offset     int32      `db:"offset" json:"offset"`
+}
+
+type ListUsersArticlesByTaskStatusRow struct {
+	ID          int32              `db:"id" json:"id"`
+	TaskID      uuid.UUID          `db:"task_id" json:"task_id"`
+	Title       string             `db:"title" json:"title"`
+	Url         string             `db:"url" json:"url"`
+	Source      string             `db:"source" json:"source"`
+	PublishedAt pgtype.Timestamptz `db:"published_at" json:"published_at"`
+	ModifiedAt  pgtype.Timestamptz `db:"modified_at" json:"modified_at"`
+	NeedsReview bool               `db:"needs_review" json:"needs_review"`
+}
+
+// Lists the user articles of the tasks of task_status, the newest first.
+func (q *Queries) ListUsersArticlesByTaskStatus(ctx context.Context, arg ListUsersArticlesByTaskStatusParams) ([]ListUsersArticlesByTaskStatusRow, error) {
+	rows, err := q.db.Query(ctx, listUsersArticlesByTaskStatus, arg.TaskStatus, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsersArticlesByTaskStatusRow
+	for rows.Next() {
+		var i ListUsersArticlesByTaskStatusRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.Title,
+			&i.Url,
+			&i.Source,
+			&i.PublishedAt,
+			&i.ModifiedAt,
+			&i.NeedsReview,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const replaceUsersArticle = `-- name: ReplaceUsersArticle :execrows
+UPDATE users.articles
+SET title = $1::text,
+    source = $2::text,
+    md5 = $3::text,
+    content = $4::text,
+    cuts = $5::integer [],
+    published_at = $6::timestamptz,
+    modified_at = $7::timestamptz,
+    content_hash = $8::text,
+    needs_review = FALSE
+WHERE id = $9::integer
+    AND task_id = $10::uuid
+`
+
+type ReplaceUsersArticleParams struct {
+	Title       string             `db:"title" json:"title"`
+	Source      string             `db:"source" json:"source"`
+	Md5         string             `db:"md5" json:"md5"`
+	Content     string             `db:"content" json:"content"`
+	Cuts        []int32            `db:"cuts" json:"cuts"`
+	PublishedAt pgtype.Timestamptz `db:"published_at" json:"published_at"`
+	ModifiedAt  pgtype.Timestamptz `db:"modified_at" json:"modified_at"`
+	ContentHash string             `db:"content_hash" json:"content_hash"`
+	ID          int32              `db:"id" json:"id"`
+	TaskID      uuid.UUID          `db:"task_id" json:"task_id"`
+}
+
+// Replaces the scraped fields of the article of the task, and clears its
+// review flag.
+func (q *Queries) ReplaceUsersArticle(ctx context.Context, arg ReplaceUsersArticleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, replaceUsersArticle,
+		arg.Title,
+		arg.Source,
+		arg.Md5,
+		arg.Content,
+		arg.Cuts,
+		arg.PublishedAt,
+		arg.ModifiedAt,
+		arg.ContentHash,
+		arg.ID,
+		arg.TaskID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const searchArticlesByText = `-- name: SearchArticlesByText :many
+WITH q AS (
+    SELECT websearch_to_tsquery('articles_fts', $1::text) AS tsq
+),
+matched AS (
+    SELECT a.id,
+        a.title,
+        a."url",
+        a.source,
+        a.party,
+        a.published_at,
+        a.content,
+        articles_search_vector(a.title, a.content) @@ q.tsq AS fts,
+        (
+            ts_rank_cd(articles_search_vector(a.title, a.content), q.tsq)
+            + word_similarity($1::text, a.title)
+        )::real AS rank
+    FROM articles AS a,
+        q
+    WHERE articles_search_vector(a.title, a.content) @@ q.tsq
+        OR a.title ILIKE '%' || $2::text || '%'
+        OR a.content ILIKE '%' || $2::text || '%'
+    ORDER BY CASE
+            WHEN $3::boolean THEN a.published_at
+        END DESC,
+        rank DESC,
+        a.published_at DESC,
+        a.id DESC
+    LIMIT $4::integer OFFSET $5::integer
+)
+SELECT m.id,
+    m.title,
+    m."url",
+    m.source,
+    m.party,
+    m.published_at,
+    m.rank,
+    (
+        CASE
+            WHEN m.fts THEN ts_headline(
+                'articles_fts',
+                m.content,
+                q.tsq,
+                'MaxFragments=2, MinWords=5, MaxWords=20'
+            )
+            ELSE replace(
+                substr(
+                    m.content,
+                    greatest(strpos(lower(m.content), lower($1::text)) - 20, 1),
+                    80
+                ),
+                $1::text,
+                '<b>' || $1::text || '</b>'
+            )
+        END
+    )::text AS headline
+FROM matched AS m,
+    q
+ORDER BY CASE
+        WHEN $3::boolean THEN m.published_at
+    END DESC,
+    m.rank DESC,
+    m.published_at DESC,
+    m.id DESC
+`
+
+type SearchArticlesByTextParams struct {
+	Query     string `db:"query" json:"query"`
+	Pattern   string `db:"pattern" json:"pattern"`
+	ByRecency bool   `db:"by_recency" json:"by_recency"`
+	Limit     int32  `db:"limit" json:"limit"`
+	Offset    int32  `db:"offset" json:"offset"`
+}
+
+type SearchArticlesByTextRow struct {
+	ID          int32              `db:"id" json:"id"`
+	Title       string             `db:"title" json:"title"`
+	Url         string             `db:"url" json:"url"`
+	Source      string             `db:"source" json:"source"`
+	Party       Party              `db:"party" json:"party"`
+	PublishedAt pgtype.Timestamptz `db:"published_at" json:"published_at"`
+	Rank        float32            `db:"rank" json:"rank"`
+	Headline    string             `db:"headline" json:"headline"`
+}
+
+// Ranks the articles matching query, by the full-text search or, for the words
+// the text search configuration cannot segment, as a substring of the title or
+// the content, the best match first or, if by_recency, the newest first. The
+// headline is the fragments of the content around the matches.
+func (q *Queries) SearchArticlesByText(ctx context.Context, arg SearchArticlesByTextParams) ([]SearchArticlesByTextRow, error) {
+	rows, err := q.db.Query(ctx, searchArticlesByText,
+		arg.Query,
+		arg.Pattern,
+		arg.ByRecency,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchArticlesByTextRow
+	for rows.Next() {
+		var i SearchArticlesByTextRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Url,
+			&i.Source,
+			&i.Party,
+			&i.PublishedAt,
+			&i.Rank,
+			&i.Headline,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const upsertArticleByMD5 = `-- name: UpsertArticleByMD5 :one
+INSERT INTO articles (
+        title,
+        "url",
+        source,
+        md5,
+        party,
+        content,
+        cuts,
+        published_at,
+        modified_at
+    )
+VALUES (
+        $1,
+        $2,
+        $3,
+        $4,
+        $5,
+        $6,
+        $7,
+        $8,
+        $9
+    ) ON CONFLICT (md5) DO
+UPDATE
+SET md5 = EXCLUDED.md5
+RETURNING id,
+    (xmax = 0)::boolean AS inserted
+`
+
+type UpsertArticleByMD5Params struct {
+	Title       string             `db:"title" json:"title"`
+	Url         string             `db:"url" json:"url"`
+	Source      string             `db:"source" json:"source"`
+	Md5         string             `db:"md5" json:"md5"`
+	Party       Party              `db:"party" json:"party"`
+	Content     string             `db:"content" json:"content"`
+	Cuts        []int32            `db:"cuts" json:"cuts"`
+	PublishedAt pgtype.Timestamptz `db:"published_at" json:"published_at"`
+	ModifiedAt  pgtype.Timestamptz `db:"modified_at" json:"modified_at"`
+}
+
+type UpsertArticleByMD5Row struct {
+	ID       int32 `db:"id" json:"id"`
+	Inserted bool  `db:"inserted" json:"inserted"`
+}
+
+// Inserts the article, or leaves the one of the same md5 as it is, and returns
+// its id either way. inserted is false for an existing article.
+func (q *Queries) UpsertArticleByMD5(ctx context.Context, arg UpsertArticleByMD5Params) (UpsertArticleByMD5Row, error) {
+	row := q.db.QueryRow(ctx, upsertArticleByMD5,
+		arg.Title,
+		arg.Url,
+		arg.Source,
+		arg.Md5,
+		arg.Party,
+		arg.Content,
+		arg.Cuts,
+		arg.PublishedAt,
+		arg.ModifiedAt,
+	)
+	var i UpsertArticleByMD5Row
+	err := row.Scan(&i.ID, &i.Inserted)
+	return i, err
+}
+
+const upsertUsersArticleByMD5 = `-- name: UpsertUsersArticleByMD5 :one
+INSERT INTO users.articles (
+        task_id,
+        title,
+        "url",
+        source,
+        md5,
+        content,
+        cuts,
+        published_at,
+        modified_at,
+        content_hash
+    )
+VALUES (
+        $1,
+        $2,
+        $3,
+        $4,
+        $5,
+        $6,
+        $7,
+        $8,
+        $9,
+        $10
+    ) ON CONFLICT (task_id, md5) DO
+UPDATE
+SET md5 = EXCLUDED.md5
+RETURNING id,
+    (xmax = 0)::boolean AS inserted
+`
+
+type UpsertUsersArticleByMD5Params struct {
+	TaskID      uuid.UUID          `db:"task_id" json:"task_id"`
+	Title       string             `db:"title" json:"title"`
+	Url         string             `db:"url" json:"url"`
+	Source      string             `db:"source" json:"source"`
+	Md5         string             `db:"md5" json:"md5"`
+	Content     string             `db:"content" json:"content"`
+	Cuts        []int32            `db:"cuts" json:"cuts"`
+	PublishedAt pgtype.Timestamptz `db:"published_at" json:"published_at"`
+	ModifiedAt  pgtype.Timestamptz `db:"modified_at" json:"modified_at"`
+	ContentHash string             `db:"content_hash" json:"content_hash"`
+}
+
+type UpsertUsersArticleByMD5Row struct {
+	ID       int32 `db:"id" json:"id"`
+	Inserted bool  `db:"inserted" json:"inserted"`
+}
+
+// Inserts the article, or leaves the one of the same md5 in the task as it is,
+// and returns its id either way. inserted is false for an existing article.
+func (q *Queries) UpsertUsersArticleByMD5(ctx context.Context, arg UpsertUsersArticleByMD5Params) (UpsertUsersArticleByMD5Row, error) {
+	row := q.db.QueryRow(ctx, upsertUsersArticleByMD5,
+		arg.TaskID,
+		arg.Title,
+		arg.Url,
+		arg.Source,
+		arg.Md5,
+		arg.Content,
+		arg.Cuts,
+		arg.PublishedAt,
+		arg.ModifiedAt,
+		arg.ContentHash,
+	)
+	var i UpsertUsersArticleByMD5Row
+	err := row.Scan(&i.ID, &i.Inserted)
+	return i, err
+}
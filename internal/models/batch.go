@@ -214,9 +214,11 @@ INSERT INTO users.embeddings (
         article_id,
         chunk_id,
         model_id,
-        vector
+        vector,
+        was_split,
+        sub_count
     )
-VALUES ($1, $2, $3, $4::vector) ON CONFLICT DO NOTHING
+VALUES ($1, $2, $3, $4::vector, $5, $6) ON CONFLICT DO NOTHING
 RETURNING id
 `
 
@@ -231,6 +233,8 @@ type InsertUsersEmbeddingBatchParams struct {
 	ChunkID   int32           `db:"chunk_id" json:"chunk_id"`
 	ModelID   int32           `db:"model_id" json:"model_id"`
 	Vector    pgvector.Vector `db:"vector" json:"vector"`
+	WasSplit  bool            `db:"was_split" json:"was_split"`
+	SubCount  int32           `db:"sub_count" json:"sub_count"`
 }
 
 func (q *Queries) InsertUsersEmbeddingBatch(ctx context.Context, arg []InsertUsersEmbeddingBatchParams) *InsertUsersEmbeddingBatchBatchResults {
@@ -241,6 +245,8 @@ func (q *Queries) InsertUsersEmbeddingBatch(ctx context.Context, arg []InsertUse
 			a.ChunkID,
 			a.ModelID,
 			a.Vector,
+			a.WasSplit,
+			a.SubCount,
 		}
 		batch.Queue(insertUsersEmbeddingBatch, vals...)
 	}
@@ -8,6 +8,8 @@ package models
 import (
 	"context"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/pgvector/pgvector-go"
 )
 
@@ -73,12 +75,12 @@ func (q *Queries) GetAverageUsersEmbeddingByArticleIDs(ctx context.Context, arg
 
 const getKNNEmbeddingsByCosineSimilarity = `-- name: GetKNNEmbeddingsByCosineSimilarity :many
 SELECT article_id,
-    (vector <=> $1::vector)::float8 AS similarity -- <=> is the cosine distance operator in pgvector
+    (vector::vector/*dim*/ <=> $1::vector/*dim*/)::float8 AS similarity -- <=> is the cosine distance operator in pgvector
 FROM embeddings
 WHERE model_id = $2::integer
     AND vector IS NOT NULL
     AND vector <> '[]'::vector
-ORDER BY vector <=> $1
+ORDER BY vector::vector/*dim*/ <=> $1::vector/*dim*/
 LIMIT $3::integer
 `
 
@@ -319,6 +321,282 @@ func (q *Queries) GetKNNUsersEmbeddingsByL2Distance(ctx context.Context, arg Get
 	return items, nil
 }
 
+const getSimilarArticlesByTaskID = `-- name: GetSimilarArticlesByTaskID :many
+WITH query AS (
+    SELECT AVG(e.vector)::vector AS vector
+    FROM users.embeddings AS e
+        JOIN users.articles AS ua ON ua.id = e.article_id
+    WHERE ua.task_id = $1::uuid
+        AND e.model_id = $2::integer
+),
+matched AS (
+    SELECT e.article_id,
+        MIN(e.vector::vector/*dim*/ <=> q.vector::vector/*dim*/)::float8 AS distance
+    FROM embeddings AS e
+        CROSS JOIN query AS q
+    WHERE e.model_id = $2::integer
+        AND q.vector IS NOT NULL
+        AND e.article_id NOT IN (
+            SELECT a.id
+            FROM articles AS a
+                JOIN users.articles AS ua ON ua.md5 = a.md5
+            WHERE ua.task_id = $1::uuid
+        )
+    GROUP BY e.article_id
+    ORDER BY distance ASC
+    LIMIT $3::integer
+)
+SELECT a.id AS article_id,
+    a.title,
+    a."url",
+    a.source,
+    a.published_at,
+    (1 - m.distance)::float8 AS similarity,
+    c.chunk_id,
+    c.chunk_similarity,
+    c.unique_start,
+    c.unique_end,
+    c.snippet
+FROM matched AS m
+    JOIN articles AS a ON a.id = m.article_id
+    CROSS JOIN query AS q
+    JOIN LATERAL (
+        SELECT ch.id AS chunk_id,
+            (1 - (e.vector::vector/*dim*/ <=> q.vector::vector/*dim*/))::float8 AS chunk_similarity,
+            (ch."start" + ch.offset_left)::integer AS unique_start,
+            (ch."start" + ch.offset_right)::integer AS unique_end,
+            substring(
+                a.content
+                FROM ch."start" + ch.offset_left + 1 FOR (ch.offset_right - ch.offset_left)
+            )::text AS snippet
+        FROM embeddings AS e
+            JOIN chunks AS ch ON ch.id = e.chunk_id
+        WHERE e.article_id = a.id
+            AND e.model_id = $2::integer
+        ORDER BY e.vector::vector/*dim*/ <=> q.vector::vector/*dim*/ ASC,
+            ch.id ASC
+        LIMIT $4::integer
+    ) AS c ON TRUE
+ORDER BY similarity DESC,
+    a.id ASC,
+    c.chunk_similarity DESC,
+    c.chunk_id ASC
+`
+
+type GetSimilarArticlesByTaskIDParams struct {
+	TaskID  uuid.UUID `db:"task_id" json:"task_id"`
+	ModelID int32     `db:"model_id" json:"model_id"`
+	K       int32     `db:"k" json:"k"`
+	TopM    int32     `db:"top_m" json:"top_m"`
+}
+
+type GetSimilarArticlesByTaskIDRow struct {
+	ArticleID       int32              `db:"article_id" json:"article_id"`
+	Title           string             `db:"title" json:"title"`
+	Url             string             `db:"url" json:"url"`
+	Source          string             `db:"source" json:"source"`
+	PublishedAt     pgtype.Timestamptz `db:"published_at" json:"published_at"`
+	Similarity      float64            `db:"similarity" json:"similarity"`
+	ChunkID         int32              `db:"chunk_id" json:"chunk_id"`
+	ChunkSimilarity float64            `db:"chunk_similarity" json:"chunk_similarity"`
+	UniqueStart     int32              `db:"unique_start" json:"unique_start"`
+	UniqueEnd       int32              `db:"unique_end" json:"unique_end"`
+	Snippet         string             `db:"snippet" json:"snippet"`
+}
+
+// The articles nearest to the average embedding of the articles of a task,
+// each joined with its top_m closest chunks. A match yields one row per chunk.
+func (q *Queries) GetSimilarArticlesByTaskID(ctx context.Context, arg GetSimilarArticlesByTaskIDParams) ([]GetSimilarArticlesByTaskIDRow, error) {
+	rows, err := q.db.Query(ctx, getSimilarArticlesByTaskID,
+		arg.TaskID,
+		arg.ModelID,
+		arg.K,
+		arg.TopM,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSimilarArticlesByTaskIDRow
+	for rows.Next() {
+		var i GetSimilarArticlesByTaskIDRow
+		if err := rows.Scan(
+			&i.ArticleID,
+			&i.Title,
+			&i.Url,
+			&i.Source,
+			&i.PublishedAt,
+			&i.Similarity,
+			&i.ChunkID,
+			&i.ChunkSimilarity,
+			&i.UniqueStart,
+			&i.UniqueEnd,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUsersEmbeddingModelIDByTaskID = `-- name: GetUsersEmbeddingModelIDByTaskID :one
+SELECT e.model_id
+FROM users.embeddings AS e
+    JOIN users.articles AS ua ON ua.id = e.article_id
+WHERE ua.task_id = $1::uuid
+ORDER BY e.id DESC
+LIMIT 1
+`
+
+// The model of the latest embedding of the articles of a task.
+func (q *Queries) GetUsersEmbeddingModelIDByTaskID(ctx context.Context, taskID uuid.UUID) (int32, error) {
+	row := q.db.QueryRow(ctx, getUsersEmbeddingModelIDByTaskID, taskID)
+	var model_id int32
+	err := row.Scan(&model_id)
+	return model_id, err
+}
+
+const hybridSearchArticles = `-- name: HybridSearchArticles :many
+WITH nearest AS (
+    SELECT e.chunk_id,
+        e.article_id,
+        (e.vector::vector/*dim*/ <=> $1::vector/*dim*/)::float8 AS distance
+    FROM embeddings AS e
+    WHERE e.model_id = $2::integer
+    ORDER BY e.vector::vector/*dim*/ <=> $1::vector/*dim*/
+    LIMIT $3::integer
+),
+vector_hits AS (
+    SELECT DISTINCT ON (article_id) article_id,
+        chunk_id,
+        distance
+    FROM nearest
+    ORDER BY article_id,
+        distance,
+        chunk_id
+),
+vector_ranked AS (
+    SELECT article_id,
+        chunk_id,
+        distance,
+        row_number() OVER (
+            ORDER BY distance,
+                chunk_id
+        ) AS "rank"
+    FROM vector_hits
+),
+keyword_hits AS (
+    SELECT a.id AS article_id,
+        (
+            ts_rank_cd(articles_search_vector(a.title, a.content), q.tsq)
+            + word_similarity($4::text, a.title)
+        )::float8 AS score
+    FROM articles AS a,
+        websearch_to_tsquery('articles_fts', $4::text) AS q(tsq)
+    WHERE articles_search_vector(a.title, a.content) @@ q.tsq
+        OR a.title ILIKE '%' || $5::text || '%'
+        OR a.content ILIKE '%' || $5::text || '%'
+    ORDER BY score DESC,
+        a.id
+    LIMIT $3::integer
+),
+keyword_ranked AS (
+    SELECT article_id,
+        score,
+        row_number() OVER (
+            ORDER BY score DESC,
+                article_id
+        ) AS "rank"
+    FROM keyword_hits
+)
+SELECT COALESCE(v.article_id, k.article_id)::integer AS article_id,
+    v.chunk_id,
+    v.distance AS vector_distance,
+    v."rank" AS vector_rank,
+    k.score AS keyword_score,
+    k."rank" AS keyword_rank,
+    (
+        COALESCE($6::float8 / ($7::float8 + v."rank"), 0)
+        + COALESCE($8::float8 / ($7::float8 + k."rank"), 0)
+    )::float8 AS score
+FROM vector_ranked AS v
+    FULL JOIN keyword_ranked AS k ON k.article_id = v.article_id
+ORDER BY score DESC,
+    article_id
+LIMIT $9::integer
+`
+
+type HybridSearchArticlesParams struct {
+	Embedding     pgvector.Vector `db:"embedding" json:"embedding"`
+	ModelID       int32           `db:"model_id" json:"model_id"`
+	Candidates    int32           `db:"candidates" json:"candidates"`
+	Query         string          `db:"query" json:"query"`
+	Pattern       string          `db:"pattern" json:"pattern"`
+	VectorWeight  float64         `db:"vector_weight" json:"vector_weight"`
+	RrfK          float64         `db:"rrf_k" json:"rrf_k"`
+	KeywordWeight float64         `db:"keyword_weight" json:"keyword_weight"`
+	Limit         int32           `db:"limit" json:"limit"`
+}
+
+type HybridSearchArticlesRow struct {
+	ArticleID      int32         `db:"article_id" json:"article_id"`
+	ChunkID        pgtype.Int4   `db:"chunk_id" json:"chunk_id"`
+	VectorDistance pgtype.Float8 `db:"vector_distance" json:"vector_distance"`
+	VectorRank     pgtype.Int8   `db:"vector_rank" json:"vector_rank"`
+	KeywordScore   pgtype.Float8 `db:"keyword_score" json:"keyword_score"`
+	KeywordRank    pgtype.Int8   `db:"keyword_rank" json:"keyword_rank"`
+	Score          float64       `db:"score" json:"score"`
+}
+
+// Fuses the articles of the chunks nearest to embedding under the cosine
+// distance with the articles matching query, as Article.Search matches them,
+// by their weighted reciprocal ranks: an article scores
+// vector_weight / (rrf_k + its rank by distance) plus
+// keyword_weight / (rrf_k + its rank by keyword score), a side it is not
+// among the candidates of adding nothing. An article is ranked by distance
+// by its nearest chunk.
+func (q *Queries) HybridSearchArticles(ctx context.Context, arg HybridSearchArticlesParams) ([]HybridSearchArticlesRow, error) {
+	rows, err := q.db.Query(ctx, hybridSearchArticles,
+		arg.Embedding,
+		arg.ModelID,
+		arg.Candidates,
+		arg.Query,
+		arg.Pattern,
+		arg.VectorWeight,
+		arg.RrfK,
+		arg.KeywordWeight,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []HybridSearchArticlesRow
+	for rows.Next() {
+		var i HybridSearchArticlesRow
+		if err := rows.Scan(
+			&i.ArticleID,
+			&i.ChunkID,
+			&i.VectorDistance,
+			&i.VectorRank,
+			&i.KeywordScore,
+			&i.KeywordRank,
+			&i.Score,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const insertEmbedding = `-- name: InsertEmbedding :one
 INSERT INTO embeddings (
         article_id,
@@ -354,9 +632,11 @@ INSERT INTO users.embeddings (
         article_id,
         chunk_id,
         model_id,
-        vector
+        vector,
+        was_split,
+        sub_count
     )
-VALUES ($1, $2, $3, $4::vector)
+VALUES ($1, $2, $3, $4::vector, $5, $6)
 RETURNING id
 `
 
@@ -365,6 +645,8 @@ type InsertUserEmbeddingParams struct {
 	ChunkID   int32           `db:"chunk_id" json:"chunk_id"`
 	ModelID   int32           `db:"model_id" json:"model_id"`
 	Vector    pgvector.Vector `db:"vector" json:"vector"`
+	WasSplit  bool            `db:"was_split" json:"was_split"`
+	SubCount  int32           `db:"sub_count" json:"sub_count"`
 }
 
 func (q *Queries) InsertUserEmbedding(ctx context.Context, arg InsertUserEmbeddingParams) (int32, error) {
@@ -373,6 +655,8 @@ func (q *Queries) InsertUserEmbedding(ctx context.Context, arg InsertUserEmbeddi
 		arg.ChunkID,
 		arg.ModelID,
 		arg.Vector,
+		arg.WasSplit,
+		arg.SubCount,
 	)
 	var id int32
 	err := row.Scan(&id)
@@ -408,3 +692,211 @@ func (q *Queries) InsertUsersEmbedding(ctx context.Context, arg InsertUsersEmbed
 	err := row.Scan(&id)
 	return id, err
 }
+
+const insertUsersEmbeddingIfAbsent = `-- name: InsertUsersEmbeddingIfAbsent :one
+INSERT INTO users.embeddings (
+        article_id,
+        chunk_id,
+        model_id,
+        vector,
+        was_split,
+        sub_count
+    )
+VALUES ($1, $2, $3, $4::vector, $5, $6) ON CONFLICT DO NOTHING
+RETURNING id
+`
+
+type InsertUsersEmbeddingIfAbsentParams struct {
+	ArticleID int32           `db:"article_id" json:"article_id"`
+	ChunkID   int32           `db:"chunk_id" json:"chunk_id"`
+	ModelID   int32           `db:"model_id" json:"model_id"`
+	Vector    pgvector.Vector `db:"vector" json:"vector"`
+	WasSplit  bool            `db:"was_split" json:"was_split"`
+	SubCount  int32           `db:"sub_count" json:"sub_count"`
+}
+
+// Inserts the embedding unless the chunk is embedded under the model already,
+// no row is returned then.
+func (q *Queries) InsertUsersEmbeddingIfAbsent(ctx context.Context, arg InsertUsersEmbeddingIfAbsentParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertUsersEmbeddingIfAbsent,
+		arg.ArticleID,
+		arg.ChunkID,
+		arg.ModelID,
+		arg.Vector,
+		arg.WasSplit,
+		arg.SubCount,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const listUsersEmbeddedChunkIDs = `-- name: ListUsersEmbeddedChunkIDs :many
+SELECT DISTINCT chunk_id
+FROM users.embeddings
+WHERE model_id = $1::integer
+    AND chunk_id = ANY($2::integer [])
+ORDER BY chunk_id
+`
+
+type ListUsersEmbeddedChunkIDsParams struct {
+	ModelID  int32   `db:"model_id" json:"model_id"`
+	ChunkIds []int32 `db:"chunk_ids" json:"chunk_ids"`
+}
+
+// The chunks among chunk_ids embedded under the model.
+func (q *Queries) ListUsersEmbeddedChunkIDs(ctx context.Context, arg ListUsersEmbeddedChunkIDsParams) ([]int32, error) {
+	rows, err := q.db.Query(ctx, listUsersEmbeddedChunkIDs, arg.ModelID, arg.ChunkIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var chunk_id int32
+		if err := rows.Scan(&chunk_id); err != nil {
+			return nil, err
+		}
+		items = append(items, chunk_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchSimilarEmbeddings = `-- name: SearchSimilarEmbeddings :many
+SELECT e.chunk_id,
+    e.article_id,
+    c."start",
+    c.offset_left,
+    c.offset_right,
+    c."end",
+    (e.vector::vector/*dim*/ <=> $1::vector/*dim*/)::float8 AS distance
+FROM embeddings AS e
+    JOIN chunks AS c ON c.id = e.chunk_id
+WHERE e.model_id = $2::integer
+    AND (e.vector::vector/*dim*/ <=> $1::vector/*dim*/) <= $3::float8
+ORDER BY e.vector::vector/*dim*/ <=> $1::vector/*dim*/
+LIMIT $4::integer
+`
+
+type SearchSimilarEmbeddingsParams struct {
+	Query       pgvector.Vector `db:"query" json:"query"`
+	ModelID     int32           `db:"model_id" json:"model_id"`
+	MaxDistance float64         `db:"max_distance" json:"max_distance"`
+	Limit       int32           `db:"limit" json:"limit"`
+}
+
+type SearchSimilarEmbeddingsRow struct {
+	ChunkID     int32   `db:"chunk_id" json:"chunk_id"`
+	ArticleID   int32   `db:"article_id" json:"article_id"`
+	Start       int32   `db:"start" json:"start"`
+	OffsetLeft  int32   `db:"offset_left" json:"offset_left"`
+	OffsetRight int32   `db:"offset_right" json:"offset_right"`
+	End         int32   `db:"end" json:"end"`
+	Distance    float64 `db:"distance" json:"distance"`
+}
+
+// The chunks of the articles nearest to query under the cosine distance, no
+// farther than max_distance, with their offsets in the article content.
+func (q *Queries) SearchSimilarEmbeddings(ctx context.Context, arg SearchSimilarEmbeddingsParams) ([]SearchSimilarEmbeddingsRow, error) {
+	rows, err := q.db.Query(ctx, searchSimilarEmbeddings,
+		arg.Query,
+		arg.ModelID,
+		arg.MaxDistance,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchSimilarEmbeddingsRow
+	for rows.Next() {
+		var i SearchSimilarEmbeddingsRow
+		if err := rows.Scan(
+			&i.ChunkID,
+			&i.ArticleID,
+			&i.Start,
+			&i.OffsetLeft,
+			&i.OffsetRight,
+			&i.End,
+			&i.Distance,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchSimilarUsersEmbeddings = `-- name: SearchSimilarUsersEmbeddings :many
+SELECT e.chunk_id,
+    e.article_id,
+    c."start",
+    c.offset_left,
+    c.offset_right,
+    c."end",
+    (e.vector::vector/*dim*/ <=> $1::vector/*dim*/)::float8 AS distance
+FROM users.embeddings AS e
+    JOIN users.chunks AS c ON c.id = e.chunk_id
+WHERE e.model_id = $2::integer
+    AND (e.vector::vector/*dim*/ <=> $1::vector/*dim*/) <= $3::float8
+ORDER BY e.vector::vector/*dim*/ <=> $1::vector/*dim*/
+LIMIT $4::integer
+`
+
+type SearchSimilarUsersEmbeddingsParams struct {
+	Query       pgvector.Vector `db:"query" json:"query"`
+	ModelID     int32           `db:"model_id" json:"model_id"`
+	MaxDistance float64         `db:"max_distance" json:"max_distance"`
+	Limit       int32           `db:"limit" json:"limit"`
+}
+
+type SearchSimilarUsersEmbeddingsRow struct {
+	ChunkID     int32   `db:"chunk_id" json:"chunk_id"`
+	ArticleID   int32   `db:"article_id" json:"article_id"`
+	Start       int32   `db:"start" json:"start"`
+	OffsetLeft  int32   `db:"offset_left" json:"offset_left"`
+	OffsetRight int32   `db:"offset_right" json:"offset_right"`
+	End         int32   `db:"end" json:"end"`
+	Distance    float64 `db:"distance" json:"distance"`
+}
+
+// The chunks of the user articles nearest to query under the cosine distance,
+// no farther than max_distance, with their offsets in the article content.
+func (q *Queries) SearchSimilarUsersEmbeddings(ctx context.Context, arg SearchSimilarUsersEmbeddingsParams) ([]SearchSimilarUsersEmbeddingsRow, error) {
+	rows, err := q.db.Query(ctx, searchSimilarUsersEmbeddings,
+		arg.Query,
+		arg.ModelID,
+		arg.MaxDistance,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchSimilarUsersEmbeddingsRow
+	for rows.Next() {
+		var i SearchSimilarUsersEmbeddingsRow
+		if err := rows.Scan(
+			&i.ChunkID,
+			&i.ArticleID,
+			&i.Start,
+			&i.OffsetLeft,
+			&i.OffsetRight,
+			&i.End,
+			&i.Distance,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
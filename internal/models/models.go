@@ -6,12 +6,199 @@ package models
 
 import (
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type AnnotationAction string
+
+const (
+	AnnotationActionConfirm AnnotationAction = "confirm"
+	AnnotationActionReject  AnnotationAction = "reject"
+	AnnotationActionReplace AnnotationAction = "replace"
+)
+
+func (e *AnnotationAction) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = AnnotationAction(s)
+	case string:
+		*e = AnnotationAction(s)
+	default:
+		return fmt.Errorf("unsupported scan type for AnnotationAction: %T", src)
+	}
+	return nil
+}
+
+type NullAnnotationAction struct {
+	AnnotationAction AnnotationAction `json:"annotation_action"`
+	Valid            bool             `json:"valid"` // Valid is true if AnnotationAction is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullAnnotationAction) Scan(value interface{}) error {
+	if value == nil {
+		ns.AnnotationAction, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.AnnotationAction.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullAnnotationAction) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.AnnotationAction), nil
+}
+
+func (e AnnotationAction) Valid() bool {
+	switch e {
+	case AnnotationActionConfirm,
+		AnnotationActionReject,
+		AnnotationActionReplace:
+		return true
+	}
+	return false
+}
+
+func AllAnnotationActionValues() []AnnotationAction {
+	return []AnnotationAction{
+		AnnotationActionConfirm,
+		AnnotationActionReject,
+		AnnotationActionReplace,
+	}
+}
+
+type AnnotationTarget string
+
+const (
+	AnnotationTargetKeyword AnnotationTarget = "keyword"
+	AnnotationTargetStance  AnnotationTarget = "stance"
+	AnnotationTargetSummary AnnotationTarget = "summary"
+)
+
+func (e *AnnotationTarget) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = AnnotationTarget(s)
+	case string:
+		*e = AnnotationTarget(s)
+	default:
+		return fmt.Errorf("unsupported scan type for AnnotationTarget: %T", src)
+	}
+	return nil
+}
+
+type NullAnnotationTarget struct {
+	AnnotationTarget AnnotationTarget `json:"annotation_target"`
+	Valid            bool             `json:"valid"` // Valid is true if AnnotationTarget is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullAnnotationTarget) Scan(value interface{}) error {
+	if value == nil {
+		ns.AnnotationTarget, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.AnnotationTarget.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullAnnotationTarget) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.AnnotationTarget), nil
+}
+
+func (e AnnotationTarget) Valid() bool {
+	switch e {
+	case AnnotationTargetKeyword,
+		AnnotationTargetStance,
+		AnnotationTargetSummary:
+		return true
+	}
+	return false
+}
+
+func AllAnnotationTargetValues() []AnnotationTarget {
+	return []AnnotationTarget{
+		AnnotationTargetKeyword,
+		AnnotationTargetStance,
+		AnnotationTargetSummary,
+	}
+}
+
+type KeywordCategory string
+
+const (
+	KeywordCategoryTheme  KeywordCategory = "theme"
+	KeywordCategoryEvent  KeywordCategory = "event"
+	KeywordCategoryEntity KeywordCategory = "entity"
+	KeywordCategoryAction KeywordCategory = "action"
+)
+
+func (e *KeywordCategory) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = KeywordCategory(s)
+	case string:
+		*e = KeywordCategory(s)
+	default:
+		return fmt.Errorf("unsupported scan type for KeywordCategory: %T", src)
+	}
+	return nil
+}
+
+type NullKeywordCategory struct {
+	KeywordCategory KeywordCategory `json:"keyword_category"`
+	Valid           bool            `json:"valid"` // Valid is true if KeywordCategory is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullKeywordCategory) Scan(value interface{}) error {
+	if value == nil {
+		ns.KeywordCategory, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.KeywordCategory.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullKeywordCategory) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.KeywordCategory), nil
+}
+
+func (e KeywordCategory) Valid() bool {
+	switch e {
+	case KeywordCategoryTheme,
+		KeywordCategoryEvent,
+		KeywordCategoryEntity,
+		KeywordCategoryAction:
+		return true
+	}
+	return false
+}
+
+func AllKeywordCategoryValues() []KeywordCategory {
+	return []KeywordCategory{
+		KeywordCategoryTheme,
+		KeywordCategoryEvent,
+		KeywordCategoryEntity,
+		KeywordCategoryAction,
+	}
+}
+
 type Party string
 
 const (
@@ -76,6 +263,250 @@ func AllPartyValues() []Party {
 	}
 }
 
+type ReviewItemType string
+
+const (
+	ReviewItemTypeKeywordOutput      ReviewItemType = "keyword_output"
+	ReviewItemTypeExtraction         ReviewItemType = "extraction"
+	ReviewItemTypeKeywordAttribution ReviewItemType = "keyword_attribution"
+	ReviewItemTypeStance             ReviewItemType = "stance"
+	ReviewItemTypeReconciliation     ReviewItemType = "reconciliation"
+)
+
+func (e *ReviewItemType) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = ReviewItemType(s)
+	case string:
+		*e = ReviewItemType(s)
+	default:
+		return fmt.Errorf("unsupported scan type for ReviewItemType: %T", src)
+	}
+	return nil
+}
+
+type NullReviewItemType struct {
+	ReviewItemType ReviewItemType `json:"review_item_type"`
+	Valid          bool           `json:"valid"` // Valid is true if ReviewItemType is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullReviewItemType) Scan(value interface{}) error {
+	if value == nil {
+		ns.ReviewItemType, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.ReviewItemType.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullReviewItemType) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.ReviewItemType), nil
+}
+
+func (e ReviewItemType) Valid() bool {
+	switch e {
+	case ReviewItemTypeKeywordOutput,
+		ReviewItemTypeExtraction,
+		ReviewItemTypeKeywordAttribution,
+		ReviewItemTypeStance,
+		ReviewItemTypeReconciliation:
+		return true
+	}
+	return false
+}
+
+func AllReviewItemTypeValues() []ReviewItemType {
+	return []ReviewItemType{
+		ReviewItemTypeKeywordOutput,
+		ReviewItemTypeExtraction,
+		ReviewItemTypeKeywordAttribution,
+		ReviewItemTypeStance,
+		ReviewItemTypeReconciliation,
+	}
+}
+
+type ReviewStatus string
+
+const (
+	ReviewStatusOpen      ReviewStatus = "open"
+	ReviewStatusResolved  ReviewStatus = "resolved"
+	ReviewStatusDismissed ReviewStatus = "dismissed"
+)
+
+func (e *ReviewStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = ReviewStatus(s)
+	case string:
+		*e = ReviewStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for ReviewStatus: %T", src)
+	}
+	return nil
+}
+
+type NullReviewStatus struct {
+	ReviewStatus ReviewStatus `json:"review_status"`
+	Valid        bool         `json:"valid"` // Valid is true if ReviewStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullReviewStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.ReviewStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.ReviewStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullReviewStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.ReviewStatus), nil
+}
+
+func (e ReviewStatus) Valid() bool {
+	switch e {
+	case ReviewStatusOpen,
+		ReviewStatusResolved,
+		ReviewStatusDismissed:
+		return true
+	}
+	return false
+}
+
+func AllReviewStatusValues() []ReviewStatus {
+	return []ReviewStatus{
+		ReviewStatusOpen,
+		ReviewStatusResolved,
+		ReviewStatusDismissed,
+	}
+}
+
+type SavedSearchDelivery string
+
+const (
+	SavedSearchDeliveryNone    SavedSearchDelivery = "none"
+	SavedSearchDeliveryWebhook SavedSearchDelivery = "webhook"
+)
+
+func (e *SavedSearchDelivery) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = SavedSearchDelivery(s)
+	case string:
+		*e = SavedSearchDelivery(s)
+	default:
+		return fmt.Errorf("unsupported scan type for SavedSearchDelivery: %T", src)
+	}
+	return nil
+}
+
+type NullSavedSearchDelivery struct {
+	SavedSearchDelivery SavedSearchDelivery `json:"saved_search_delivery"`
+	Valid               bool                `json:"valid"` // Valid is true if SavedSearchDelivery is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullSavedSearchDelivery) Scan(value interface{}) error {
+	if value == nil {
+		ns.SavedSearchDelivery, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.SavedSearchDelivery.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullSavedSearchDelivery) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.SavedSearchDelivery), nil
+}
+
+func (e SavedSearchDelivery) Valid() bool {
+	switch e {
+	case SavedSearchDeliveryNone,
+		SavedSearchDeliveryWebhook:
+		return true
+	}
+	return false
+}
+
+func AllSavedSearchDeliveryValues() []SavedSearchDelivery {
+	return []SavedSearchDelivery{
+		SavedSearchDeliveryNone,
+		SavedSearchDeliveryWebhook,
+	}
+}
+
+type SavedSearchSchedule string
+
+const (
+	SavedSearchScheduleDaily  SavedSearchSchedule = "daily"
+	SavedSearchScheduleWeekly SavedSearchSchedule = "weekly"
+)
+
+func (e *SavedSearchSchedule) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = SavedSearchSchedule(s)
+	case string:
+		*e = SavedSearchSchedule(s)
+	default:
+		return fmt.Errorf("unsupported scan type for SavedSearchSchedule: %T", src)
+	}
+	return nil
+}
+
+type NullSavedSearchSchedule struct {
+	SavedSearchSchedule SavedSearchSchedule `json:"saved_search_schedule"`
+	Valid               bool                `json:"valid"` // Valid is true if SavedSearchSchedule is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullSavedSearchSchedule) Scan(value interface{}) error {
+	if value == nil {
+		ns.SavedSearchSchedule, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.SavedSearchSchedule.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullSavedSearchSchedule) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.SavedSearchSchedule), nil
+}
+
+func (e SavedSearchSchedule) Valid() bool {
+	switch e {
+	case SavedSearchScheduleDaily,
+		SavedSearchScheduleWeekly:
+		return true
+	}
+	return false
+}
+
+func AllSavedSearchScheduleValues() []SavedSearchSchedule {
+	return []SavedSearchSchedule{
+		SavedSearchScheduleDaily,
+		SavedSearchScheduleWeekly,
+	}
+}
+
 type SourceType string
 
 const (
@@ -198,6 +629,41 @@ func AllTaskStatusValues() []TaskStatus {
 	}
 }
 
+type Annotation struct {
+	ID          int32              `db:"id" json:"id"`
+	TargetType  AnnotationTarget   `db:"target_type" json:"target_type"`
+	TargetID    string             `db:"target_id" json:"target_id"`
+	ArticleID   int32              `db:"article_id" json:"article_id"`
+	Action      AnnotationAction   `db:"action" json:"action"`
+	Replacement json.RawMessage    `db:"replacement" json:"replacement"`
+	Author      string             `db:"author" json:"author"`
+	CreatedAt   pgtype.Timestamptz `db:"created_at" json:"created_at"`
+}
+
+type ArticleRevision struct {
+	ID        int32              `db:"id" json:"id"`
+	ArticleID int32              `db:"article_id" json:"article_id"`
+	Field     string             `db:"field" json:"field"`
+	OldValue  string             `db:"old_value" json:"old_value"`
+	NewValue  string             `db:"new_value" json:"new_value"`
+	Note      string             `db:"note" json:"note"`
+	CreatedAt pgtype.Timestamptz `db:"created_at" json:"created_at"`
+}
+
+type ArticleStance struct {
+	ArticleID  int32              `db:"article_id" json:"article_id"`
+	Party      Party              `db:"party" json:"party"`
+	Stance     string             `db:"stance" json:"stance"`
+	Confidence float32            `db:"confidence" json:"confidence"`
+	CreatedAt  pgtype.Timestamptz `db:"created_at" json:"created_at"`
+}
+
+type ArticleSummary struct {
+	ArticleID int32              `db:"article_id" json:"article_id"`
+	Summary   string             `db:"summary" json:"summary"`
+	CreatedAt pgtype.Timestamptz `db:"created_at" json:"created_at"`
+}
+
 type Article struct {
 	ID          int32              `db:"id" json:"id"`
 	Title       string             `db:"title" json:"title"`
@@ -209,6 +675,8 @@ type Article struct {
 	Cuts        []int32            `db:"cuts" json:"cuts"`
 	PublishedAt pgtype.Timestamptz `db:"published_at" json:"published_at"`
 	CreatedAt   pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	ModifiedAt  pgtype.Timestamptz `db:"modified_at" json:"modified_at"`
+	CanonicalID pgtype.Int4        `db:"canonical_id" json:"canonical_id"`
 }
 
 type ArticlesKeyword struct {
@@ -226,6 +694,29 @@ type Chunk struct {
 	CreatedAt   pgtype.Timestamptz `db:"created_at" json:"created_at"`
 }
 
+type Counter struct {
+	Name      string             `db:"name" json:"name"`
+	Value     int64              `db:"value" json:"value"`
+	UpdatedAt pgtype.Timestamptz `db:"updated_at" json:"updated_at"`
+}
+
+type EffectiveArticleStance struct {
+	ArticleID  int32   `db:"article_id" json:"article_id"`
+	Party      Party   `db:"party" json:"party"`
+	Stance     string  `db:"stance" json:"stance"`
+	Confidence float32 `db:"confidence" json:"confidence"`
+}
+
+type EffectiveArticleSummary struct {
+	ArticleID int32  `db:"article_id" json:"article_id"`
+	Summary   string `db:"summary" json:"summary"`
+}
+
+type EffectiveArticlesKeyword struct {
+	ArticleID int32  `db:"article_id" json:"article_id"`
+	Term      string `db:"term" json:"term"`
+}
+
 type Embedding struct {
 	ID        int32              `db:"id" json:"id"`
 	ArticleID int32              `db:"article_id" json:"article_id"`
@@ -233,17 +724,78 @@ type Embedding struct {
 	ModelID   int32              `db:"model_id" json:"model_id"`
 	Vector    interface{}        `db:"vector" json:"vector"`
 	CreatedAt pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	WasSplit  bool               `db:"was_split" json:"was_split"`
+	SubCount  int32              `db:"sub_count" json:"sub_count"`
+}
+
+type EmbeddingsArchive struct {
+	ID         int32              `db:"id" json:"id"`
+	ArticleID  int32              `db:"article_id" json:"article_id"`
+	ChunkID    int32              `db:"chunk_id" json:"chunk_id"`
+	ModelID    int32              `db:"model_id" json:"model_id"`
+	Dims       int32              `db:"dims" json:"dims"`
+	Vector     []byte             `db:"vector" json:"vector"`
+	CreatedAt  pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	ArchivedAt pgtype.Timestamptz `db:"archived_at" json:"archived_at"`
+	WasSplit   bool               `db:"was_split" json:"was_split"`
+	SubCount   int32              `db:"sub_count" json:"sub_count"`
 }
 
 type Keyword struct {
-	ID   int32  `db:"id" json:"id"`
-	Term string `db:"term" json:"term"`
+	ID             int32  `db:"id" json:"id"`
+	Term           string `db:"term" json:"term"`
+	Lang           string `db:"lang" json:"lang"`
+	LowInformation bool   `db:"low_information" json:"low_information"`
+}
+
+type KeywordAnomaly struct {
+	KeywordID      int32              `db:"keyword_id" json:"keyword_id"`
+	Date           pgtype.Date        `db:"date" json:"date"`
+	Count          int32              `db:"count" json:"count"`
+	BaselineMean   float64            `db:"baseline_mean" json:"baseline_mean"`
+	BaselineStddev float64            `db:"baseline_stddev" json:"baseline_stddev"`
+	Zscore         float64            `db:"zscore" json:"zscore"`
+	DetectedAt     pgtype.Timestamptz `db:"detected_at" json:"detected_at"`
+}
+
+type KeywordLink struct {
+	KeywordID       int32              `db:"keyword_id" json:"keyword_id"`
+	LinkedKeywordID int32              `db:"linked_keyword_id" json:"linked_keyword_id"`
+	Similarity      float32            `db:"similarity" json:"similarity"`
+	CreatedAt       pgtype.Timestamptz `db:"created_at" json:"created_at"`
+}
+
+type LatestAnnotation struct {
+	ID          int32              `db:"id" json:"id"`
+	TargetType  AnnotationTarget   `db:"target_type" json:"target_type"`
+	TargetID    string             `db:"target_id" json:"target_id"`
+	ArticleID   int32              `db:"article_id" json:"article_id"`
+	Action      AnnotationAction   `db:"action" json:"action"`
+	Replacement json.RawMessage    `db:"replacement" json:"replacement"`
+	Author      string             `db:"author" json:"author"`
+	CreatedAt   pgtype.Timestamptz `db:"created_at" json:"created_at"`
 }
 
 type Model struct {
 	ID        int32              `db:"id" json:"id"`
 	Name      string             `db:"name" json:"name"`
 	CreatedAt pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	Dim       int32              `db:"dim" json:"dim"`
+	Active    bool               `db:"active" json:"active"`
+}
+
+type ReconciliationReport struct {
+	ID         int32              `db:"id" json:"id"`
+	Since      pgtype.Timestamptz `db:"since" json:"since"`
+	LastTaskID int32              `db:"last_task_id" json:"last_task_id"`
+	Checked    int32              `db:"checked" json:"checked"`
+	Sampled    int32              `db:"sampled" json:"sampled"`
+	Counts     []byte             `db:"counts" json:"counts"`
+	Repaired   int32              `db:"repaired" json:"repaired"`
+	Flagged    int32              `db:"flagged" json:"flagged"`
+	StartedAt  pgtype.Timestamptz `db:"started_at" json:"started_at"`
+	UpdatedAt  pgtype.Timestamptz `db:"updated_at" json:"updated_at"`
+	FinishedAt pgtype.Timestamptz `db:"finished_at" json:"finished_at"`
 }
 
 type SchemaMigration struct {
@@ -251,22 +803,52 @@ type SchemaMigration struct {
 	Dirty   bool  `db:"dirty" json:"dirty"`
 }
 
+type SourceWeight struct {
+	Source    string             `db:"source" json:"source"`
+	Weight    float64            `db:"weight" json:"weight"`
+	UpdatedBy string             `db:"updated_by" json:"updated_by"`
+	UpdatedAt pgtype.Timestamptz `db:"updated_at" json:"updated_at"`
+}
+
+type UrlStatus struct {
+	ArticleID           int32              `db:"article_id" json:"article_id"`
+	LastCheckedAt       pgtype.Timestamptz `db:"last_checked_at" json:"last_checked_at"`
+	LastStatusCode      pgtype.Int4        `db:"last_status_code" json:"last_status_code"`
+	ConsecutiveFailures int32              `db:"consecutive_failures" json:"consecutive_failures"`
+	Dead                bool               `db:"dead" json:"dead"`
+}
+
 type UsersArticle struct {
-	ID          int32              `db:"id" json:"id"`
-	TaskID      uuid.UUID          `db:"task_id" json:"task_id"`
-	Title       string             `db:"title" json:"title"`
-	Url         string             `db:"url" json:"url"`
-	Source      string             `db:"source" json:"source"`
-	Md5         string             `db:"md5" json:"md5"`
-	Content     string             `db:"content" json:"content"`
-	Cuts        []int32            `db:"cuts" json:"cuts"`
-	PublishedAt pgtype.Timestamptz `db:"published_at" json:"published_at"`
-	CreatedAt   pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	ID            int32              `db:"id" json:"id"`
+	TaskID        uuid.UUID          `db:"task_id" json:"task_id"`
+	Title         string             `db:"title" json:"title"`
+	Url           string             `db:"url" json:"url"`
+	Source        string             `db:"source" json:"source"`
+	Md5           string             `db:"md5" json:"md5"`
+	Content       string             `db:"content" json:"content"`
+	Cuts          []int32            `db:"cuts" json:"cuts"`
+	PublishedAt   pgtype.Timestamptz `db:"published_at" json:"published_at"`
+	CreatedAt     pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	NeedsReview   bool               `db:"needs_review" json:"needs_review"`
+	ModifiedAt    pgtype.Timestamptz `db:"modified_at" json:"modified_at"`
+	ContentHash   string             `db:"content_hash" json:"content_hash"`
+	LastCheckedAt pgtype.Timestamptz `db:"last_checked_at" json:"last_checked_at"`
+}
+
+type UsersArticleRevision struct {
+	ID        int32              `db:"id" json:"id"`
+	ArticleID int32              `db:"article_id" json:"article_id"`
+	Field     string             `db:"field" json:"field"`
+	OldValue  string             `db:"old_value" json:"old_value"`
+	NewValue  string             `db:"new_value" json:"new_value"`
+	Note      string             `db:"note" json:"note"`
+	CreatedAt pgtype.Timestamptz `db:"created_at" json:"created_at"`
 }
 
 type UsersArticlesKeyword struct {
-	ArticleID int32 `db:"article_id" json:"article_id"`
-	KeywordID int32 `db:"keyword_id" json:"keyword_id"`
+	ArticleID int32               `db:"article_id" json:"article_id"`
+	KeywordID int32               `db:"keyword_id" json:"keyword_id"`
+	Category  NullKeywordCategory `db:"category" json:"category"`
 }
 
 type UsersChunk struct {
@@ -286,6 +868,59 @@ type UsersEmbedding struct {
 	ModelID   int32              `db:"model_id" json:"model_id"`
 	Vector    interface{}        `db:"vector" json:"vector"`
 	CreatedAt pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	WasSplit  bool               `db:"was_split" json:"was_split"`
+	SubCount  int32              `db:"sub_count" json:"sub_count"`
+}
+
+type UsersReviewQueue struct {
+	ID             int32              `db:"id" json:"id"`
+	ItemType       ReviewItemType     `db:"item_type" json:"item_type"`
+	ItemRef        []byte             `db:"item_ref" json:"item_ref"`
+	ArticleID      int32              `db:"article_id" json:"article_id"`
+	Reason         string             `db:"reason" json:"reason"`
+	Priority       int32              `db:"priority" json:"priority"`
+	Status         ReviewStatus       `db:"status" json:"status"`
+	AssignedTo     pgtype.Text        `db:"assigned_to" json:"assigned_to"`
+	LeaseExpiresAt pgtype.Timestamptz `db:"lease_expires_at" json:"lease_expires_at"`
+	CreatedAt      pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	ResolvedAt     pgtype.Timestamptz `db:"resolved_at" json:"resolved_at"`
+}
+
+type UsersSavedSearchHit struct {
+	SavedSearchID int32              `db:"saved_search_id" json:"saved_search_id"`
+	ArticleID     int32              `db:"article_id" json:"article_id"`
+	RunAt         pgtype.Timestamptz `db:"run_at" json:"run_at"`
+}
+
+type UsersSavedSearch struct {
+	ID         int32               `db:"id" json:"id"`
+	OwnerID    string              `db:"owner_id" json:"owner_id"`
+	Name       string              `db:"name" json:"name"`
+	Filter     []byte              `db:"filter" json:"filter"`
+	Schedule   SavedSearchSchedule `db:"schedule" json:"schedule"`
+	Delivery   SavedSearchDelivery `db:"delivery" json:"delivery"`
+	WebhookUrl string              `db:"webhook_url" json:"webhook_url"`
+	LastRunAt  pgtype.Timestamptz  `db:"last_run_at" json:"last_run_at"`
+	CreatedAt  pgtype.Timestamptz  `db:"created_at" json:"created_at"`
+	UpdatedAt  pgtype.Timestamptz  `db:"updated_at" json:"updated_at"`
+}
+
+type UsersTaskEvent struct {
+	ID        int64              `db:"id" json:"id"`
+	TaskID    uuid.UUID          `db:"task_id" json:"task_id"`
+	Stage     string             `db:"stage" json:"stage"`
+	Status    TaskStatus         `db:"status" json:"status"`
+	Message   string             `db:"message" json:"message"`
+	CreatedAt pgtype.Timestamptz `db:"created_at" json:"created_at"`
+}
+
+type UsersTaskState struct {
+	TaskID        uuid.UUID          `db:"task_id" json:"task_id"`
+	CurrentStatus TaskStatus         `db:"current_status" json:"current_status"`
+	StageStatuses []byte             `db:"stage_statuses" json:"stage_statuses"`
+	LastEventID   int64              `db:"last_event_id" json:"last_event_id"`
+	Compacted     bool               `db:"compacted" json:"compacted"`
+	UpdatedAt     pgtype.Timestamptz `db:"updated_at" json:"updated_at"`
 }
 
 type UsersTask struct {
@@ -297,4 +932,20 @@ type UsersTask struct {
 	ErrorMessage  pgtype.Text        `db:"error_message" json:"error_message"`
 	CreatedAt     pgtype.Timestamptz `db:"created_at" json:"created_at"`
 	UpdatedAt     pgtype.Timestamptz `db:"updated_at" json:"updated_at"`
+	OwnerID       pgtype.Text        `db:"owner_id" json:"owner_id"`
+}
+
+type VectorIndexMaintenance struct {
+	ID           int32              `db:"id" json:"id"`
+	IndexName    string             `db:"index_name" json:"index_name"`
+	TableName    string             `db:"table_name" json:"table_name"`
+	Method       string             `db:"method" json:"method"`
+	Params       []byte             `db:"params" json:"params"`
+	RecallBefore pgtype.Float8      `db:"recall_before" json:"recall_before"`
+	RecallAfter  pgtype.Float8      `db:"recall_after" json:"recall_after"`
+	SizeBefore   pgtype.Int8        `db:"size_before" json:"size_before"`
+	SizeAfter    pgtype.Int8        `db:"size_after" json:"size_after"`
+	Error        pgtype.Text        `db:"error" json:"error"`
+	StartedAt    pgtype.Timestamptz `db:"started_at" json:"started_at"`
+	FinishedAt   pgtype.Timestamptz `db:"finished_at" json:"finished_at"`
 }
@@ -9,6 +9,20 @@ import (
 	"context"
 )
 
+const deactivateModel = `-- name: DeactivateModel :execrows
+UPDATE models
+SET active = FALSE
+WHERE id = $1::integer
+`
+
+func (q *Queries) DeactivateModel(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deactivateModel, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteModelByID = `-- name: DeleteModelByID :exec
 DELETE FROM models
 WHERE id = $1::integer
@@ -21,83 +35,131 @@ func (q *Queries) DeleteModelByID(ctx context.Context, id int32) error {
 }
 
 const getModelByID = `-- name: GetModelByID :one
-SELECT id, name
+SELECT id, name, dim, active
 FROM models
 WHERE id = $1::integer
 LIMIT 1
 `
 
 type GetModelByIDRow struct {
-	ID   int32  `db:"id" json:"id"`
-	Name string `db:"name" json:"name"`
+	ID     int32  `db:"id" json:"id"`
+	Name   string `db:"name" json:"name"`
+	Dim    int32  `db:"dim" json:"dim"`
+	Active bool   `db:"active" json:"active"`
 }
 
 func (q *Queries) GetModelByID(ctx context.Context, id int32) (GetModelByIDRow, error) {
 	row := q.db.QueryRow(ctx, getModelByID, id)
 	var i GetModelByIDRow
-	err := row.Scan(&i.ID, &i.Name)
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Dim,
+		&i.Active,
+	)
 	return i, err
 }
 
 const getModelByName = `-- name: GetModelByName :one
-SELECT id, name
+SELECT id, name, dim, active
 FROM models
 WHERE name = $1::text
 LIMIT 1
 `
 
 type GetModelByNameRow struct {
-	ID   int32  `db:"id" json:"id"`
-	Name string `db:"name" json:"name"`
+	ID     int32  `db:"id" json:"id"`
+	Name   string `db:"name" json:"name"`
+	Dim    int32  `db:"dim" json:"dim"`
+	Active bool   `db:"active" json:"active"`
 }
 
 func (q *Queries) GetModelByName(ctx context.Context, name string) (GetModelByNameRow, error) {
 	row := q.db.QueryRow(ctx, getModelByName, name)
 	var i GetModelByNameRow
-	err := row.Scan(&i.ID, &i.Name)
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Dim,
+		&i.Active,
+	)
+	return i, err
+}
+
+const getOrCreateModel = `-- name: GetOrCreateModel :one
+INSERT INTO models (name, dim)
+VALUES ($1::text, $2::integer)
+ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+RETURNING id, dim, active, (xmax = 0)::boolean AS inserted
+`
+
+type GetOrCreateModelParams struct {
+	Name string `db:"name" json:"name"`
+	Dim  int32  `db:"dim" json:"dim"`
+}
+
+type GetOrCreateModelRow struct {
+	ID       int32 `db:"id" json:"id"`
+	Dim      int32 `db:"dim" json:"dim"`
+	Active   bool  `db:"active" json:"active"`
+	Inserted bool  `db:"inserted" json:"inserted"`
+}
+
+// GetOrCreateModel inserts the model unless one of the name exists, and returns
+// the model of the name either way. The no-op update makes RETURNING return
+// the existing model too. inserted is false for an existing model.
+func (q *Queries) GetOrCreateModel(ctx context.Context, arg GetOrCreateModelParams) (GetOrCreateModelRow, error) {
+	row := q.db.QueryRow(ctx, getOrCreateModel, arg.Name, arg.Dim)
+	var i GetOrCreateModelRow
+	err := row.Scan(
+		&i.ID,
+		&i.Dim,
+		&i.Active,
+		&i.Inserted,
+	)
 	return i, err
 }
 
 const insertModel = `-- name: InsertModel :one
-INSERT INTO models (name)
-VALUES ($1::text)
+INSERT INTO models (name, dim)
+VALUES ($1::text, $2::integer)
 RETURNING id
 `
 
-func (q *Queries) InsertModel(ctx context.Context, name string) (int32, error) {
-	row := q.db.QueryRow(ctx, insertModel, name)
+type InsertModelParams struct {
+	Name string `db:"name" json:"name"`
+	Dim  int32  `db:"dim" json:"dim"`
+}
+
+func (q *Queries) InsertModel(ctx context.Context, arg InsertModelParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertModel, arg.Name, arg.Dim)
 	var id int32
 	err := row.Scan(&id)
 	return id, err
 }
 
 const listModels = `-- name: ListModels :many
-SELECT id, name
+SELECT id, name, created_at, dim, active
 FROM models
-ORDER BY created_at DESC
-LIMIT $1 OFFSET $2
+ORDER BY created_at DESC, id DESC
 `
 
-type ListModelsParams struct {
-	Limit  int32 `db:"limit" json:"limit"`
-	Offset int32 `db:"offset" json:"offset"`
-}
-
-type ListModelsRow struct {
-	ID   int32  `db:"id" json:"id"`
-	Name string `db:"name" json:"name"`
-}
-
-func (q *Queries) ListModels(ctx context.Context, arg ListModelsParams) ([]ListModelsRow, error) {
-	rows, err := q.db.Query(ctx, listModels, arg.Limit, arg.Offset)
+func (q *Queries) ListModels(ctx context.Context) ([]Model, error) {
+	rows, err := q.db.Query(ctx, listModels)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ListModelsRow
+	var items []Model
 	for rows.Next() {
-		var i ListModelsRow
-		if err := rows.Scan(&i.ID, &i.Name); err != nil {
+		var i Model
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.Dim,
+			&i.Active,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -8,12 +8,72 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Querier interface {
+	// An open item is claimed if it is unassigned, already assigned to the
+	// editor, or the lease of its assignee has expired.
+	ClaimReviewItem(ctx context.Context, arg ClaimReviewItemParams) (UsersReviewQueue, error)
+	// Only the editor holding an unexpired lease on the item closes it.
+	CloseReviewItem(ctx context.Context, arg CloseReviewItemParams) (UsersReviewQueue, error)
+	// Deletes up to batch_size events older than cutoff of the tasks in a terminal
+	// state, keeping the first and the last event of every stage, and flags the
+	// snapshots of these tasks as compacted.
+	CompactTaskEvents(ctx context.Context, arg CompactTaskEventsParams) (int64, error)
+	CountArticles(ctx context.Context) (int64, error)
+	CountArticlesByParty(ctx context.Context) ([]CountArticlesByPartyRow, error)
+	CountArticlesKeywords(ctx context.Context) (int64, error)
+	// The articles published per bucket, a field of date_trunc, and party within
+	// [since, until).
+	CountArticlesPerBucketByParty(ctx context.Context, arg CountArticlesPerBucketByPartyParams) ([]CountArticlesPerBucketByPartyRow, error)
+	// The articles published per bucket, a field of date_trunc, and source within
+	// [since, until).
+	CountArticlesPerBucketBySource(ctx context.Context, arg CountArticlesPerBucketBySourceParams) ([]CountArticlesPerBucketBySourceRow, error)
+	// The articles published per day and party since since.
+	CountArticlesPerDay(ctx context.Context, since pgtype.Timestamptz) ([]CountArticlesPerDayRow, error)
+	CountArticlesPublishedSince(ctx context.Context, since pgtype.Timestamptz) (int64, error)
+	CountDeadArticlesBySource(ctx context.Context) ([]CountDeadArticlesBySourceRow, error)
+	// Counts the articles ListArticles lists under the same filter, regardless of
+	// the cursor.
+	CountListedArticles(ctx context.Context, arg CountListedArticlesParams) (int64, error)
+	CountSavedSearchesByOwner(ctx context.Context, ownerID string) (int64, error)
+	CountUsersArticleRevisionsSince(ctx context.Context, arg CountUsersArticleRevisionsSinceParams) (int64, error)
+	CountUsersArticlesByTaskStatus(ctx context.Context, taskStatus TaskStatus) (int64, error)
+	CountUsersTasks(ctx context.Context) (int64, error)
+	CountUsersTasksDoneSince(ctx context.Context, since pgtype.Timestamptz) (int64, error)
+	// CreateReconciliationReport starts the report of a pass over the tasks done
+	// since since.
+	CreateReconciliationReport(ctx context.Context, since pgtype.Timestamptz) (ReconciliationReport, error)
+	// CreateVectorIndexMaintenance starts the record of a rebuild of an index.
+	CreateVectorIndexMaintenance(ctx context.Context, arg CreateVectorIndexMaintenanceParams) (VectorIndexMaintenance, error)
+	DeactivateModel(ctx context.Context, id int32) (int64, error)
+	DeleteAnnotation(ctx context.Context, arg DeleteAnnotationParams) (int64, error)
+	DeleteArchivedEmbeddingsByIDs(ctx context.Context, ids []int32) (int64, error)
+	DeleteEmbeddingsByIDs(ctx context.Context, ids []int32) (int64, error)
 	DeleteModelByID(ctx context.Context, id int32) error
+	DeleteSavedSearch(ctx context.Context, arg DeleteSavedSearchParams) (int64, error)
+	// DeleteUserTask deletes the task, its articles, their chunks, embeddings,
+	// keywords and reviews, and its events go with it through the cascades.
+	DeleteUserTask(ctx context.Context, taskID uuid.UUID) (int64, error)
+	DeleteUsersArticleKeywords(ctx context.Context, articleID int32) error
+	DeleteUsersChunksByArticleID(ctx context.Context, articleID int32) error
+	// An item flagged again while it is open is not queued twice, its priority is
+	// raised to the highest of the flags instead.
+	EnqueueReviewItem(ctx context.Context, arg EnqueueReviewItemParams) (UsersReviewQueue, error)
+	ExistsArticleByMD5(ctx context.Context, md5 string) (bool, error)
+	ExistsUsersArticleByMD5(ctx context.Context, arg ExistsUsersArticleByMD5Params) (bool, error)
 	ExtractChunks(ctx context.Context, id int32) ([]ExtractChunksRow, error)
 	ExtractUsersChunks(ctx context.Context, id int32) ([]ExtractUsersChunksRow, error)
+	// FindNearDuplicateArticles returns the articles published within window_days
+	// of the article of id whose content is at least pg_trgm.similarity_threshold
+	// similar to its own by trigrams, the most similar first. The content is
+	// matched by the % operator for the trigram index to serve the search, the
+	// threshold has to be set on the transaction.
+	FindNearDuplicateArticles(ctx context.Context, arg FindNearDuplicateArticlesParams) ([]FindNearDuplicateArticlesRow, error)
+	// FinishVectorIndexMaintenance finishes the record of a rebuild, with the
+	// error of a failed one.
+	FinishVectorIndexMaintenance(ctx context.Context, arg FinishVectorIndexMaintenanceParams) (VectorIndexMaintenance, error)
 	GetArticleByID(ctx context.Context, id int32) (Article, error)
 	GetArticleByIDs(ctx context.Context, ids []int32) ([]Article, error)
 	GetArticleByMD5(ctx context.Context, md5 string) (Article, error)
@@ -22,36 +82,247 @@ type Querier interface {
 	GetArticlesInPastKDays(ctx context.Context, arg GetArticlesInPastKDaysParams) ([]Article, error)
 	GetAverageEmbeddingByArticleIDs(ctx context.Context, arg GetAverageEmbeddingByArticleIDsParams) (GetAverageEmbeddingByArticleIDsRow, error)
 	GetAverageUsersEmbeddingByArticleIDs(ctx context.Context, arg GetAverageUsersEmbeddingByArticleIDsParams) (GetAverageUsersEmbeddingByArticleIDsRow, error)
+	// GetCreateIndexProgress returns the progress of the index build running on
+	// the table, by qualified name.
+	GetCreateIndexProgress(ctx context.Context, tableName string) (GetCreateIndexProgressRow, error)
+	GetEffectiveKeywordsByArticleID(ctx context.Context, articleID int32) ([]string, error)
 	GetKNNEmbeddingsByCosineSimilarity(ctx context.Context, arg GetKNNEmbeddingsByCosineSimilarityParams) ([]GetKNNEmbeddingsByCosineSimilarityRow, error)
 	GetKNNEmbeddingsByInnerProduct(ctx context.Context, arg GetKNNEmbeddingsByInnerProductParams) ([]GetKNNEmbeddingsByInnerProductRow, error)
 	GetKNNEmbeddingsByL2Distance(ctx context.Context, arg GetKNNEmbeddingsByL2DistanceParams) ([]GetKNNEmbeddingsByL2DistanceRow, error)
 	GetKNNUsersEmbeddingsByCosineSimilarity(ctx context.Context, arg GetKNNUsersEmbeddingsByCosineSimilarityParams) ([]GetKNNUsersEmbeddingsByCosineSimilarityRow, error)
 	GetKNNUsersEmbeddingsByInnerProduct(ctx context.Context, arg GetKNNUsersEmbeddingsByInnerProductParams) ([]GetKNNUsersEmbeddingsByInnerProductRow, error)
 	GetKNNUsersEmbeddingsByL2Distance(ctx context.Context, arg GetKNNUsersEmbeddingsByL2DistanceParams) ([]GetKNNUsersEmbeddingsByL2DistanceRow, error)
+	// GetLongestTransactionAge returns the age of the oldest transaction open by
+	// another client, in seconds.
+	GetLongestTransactionAge(ctx context.Context) (float64, error)
+	// GetMaxReplicationLag returns the largest lag of the replicas streaming from
+	// the server, in seconds.
+	GetMaxReplicationLag(ctx context.Context) (float64, error)
 	GetModelByID(ctx context.Context, id int32) (GetModelByIDRow, error)
 	GetModelByName(ctx context.Context, name string) (GetModelByNameRow, error)
+	// GetOrCreateModel inserts the model unless one of the name exists, and returns
+	// the model of the name either way. The no-op update makes RETURNING return
+	// the existing model too. inserted is false for an existing model.
+	GetOrCreateModel(ctx context.Context, arg GetOrCreateModelParams) (GetOrCreateModelRow, error)
+	GetReviewItem(ctx context.Context, id int32) (UsersReviewQueue, error)
+	// GetRunningReconciliationReport returns the report of the pass not finished
+	// yet, if any.
+	GetRunningReconciliationReport(ctx context.Context) (ReconciliationReport, error)
+	GetSavedSearch(ctx context.Context, arg GetSavedSearchParams) (UsersSavedSearch, error)
+	// The articles nearest to the average embedding of the articles of a task,
+	// each joined with its top_m closest chunks. A match yields one row per chunk.
+	GetSimilarArticlesByTaskID(ctx context.Context, arg GetSimilarArticlesByTaskIDParams) ([]GetSimilarArticlesByTaskIDRow, error)
+	// Counts the articles published within [start, end] by party and stance,
+	// with the stance annotations of the editors applied.
+	GetStanceCounts(ctx context.Context, arg GetStanceCountsParams) ([]GetStanceCountsRow, error)
+	GetTaskState(ctx context.Context, taskID uuid.UUID) (UsersTaskState, error)
+	GetTopKeywords(ctx context.Context, arg GetTopKeywordsParams) ([]GetTopKeywordsRow, error)
 	GetUserTask(ctx context.Context, taskID uuid.UUID) (UsersTask, error)
+	GetUserTaskStatus(ctx context.Context, taskID uuid.UUID) (GetUserTaskStatusRow, error)
 	GetUsersArticleByID(ctx context.Context, id int32) (UsersArticle, error)
-	GetUsersArticleByMD5(ctx context.Context, md5 string) (UsersArticle, error)
+	GetUsersArticleByMD5(ctx context.Context, arg GetUsersArticleByMD5Params) (UsersArticle, error)
 	GetUsersArticleByTaskID(ctx context.Context, taskID uuid.UUID) (UsersArticle, error)
+	GetUsersChunksByArticleID(ctx context.Context, articleID int32) ([]UsersChunk, error)
+	// The chunks of the article with their text, the substring of the content
+	// from start to end.
+	GetUsersChunksWithContent(ctx context.Context, articleID int32) ([]GetUsersChunksWithContentRow, error)
+	// The model of the latest embedding of the articles of a task.
+	GetUsersEmbeddingModelIDByTaskID(ctx context.Context, taskID uuid.UUID) (int32, error)
+	// GetVectorIndexState returns whether the index, by qualified name, is valid
+	// and its size.
+	GetVectorIndexState(ctx context.Context, indexName string) (GetVectorIndexStateRow, error)
+	// Fuses the articles of the chunks nearest to embedding under the cosine
+	// distance with the articles matching query, as Article.Search matches them,
+	// by their weighted reciprocal ranks: an article scores
+	// vector_weight / (rrf_k + its rank by distance) plus
+	// keyword_weight / (rrf_k + its rank by keyword score), a side it is not
+	// among the candidates of adding nothing. An article is ranked by distance
+	// by its nearest chunk.
+	HybridSearchArticles(ctx context.Context, arg HybridSearchArticlesParams) ([]HybridSearchArticlesRow, error)
+	// Atomically add delta to a counter, creating it if it does not exist.
+	IncrementCounter(ctx context.Context, arg IncrementCounterParams) error
+	InsertAnnotation(ctx context.Context, arg InsertAnnotationParams) (Annotation, error)
 	InsertArticle(ctx context.Context, arg InsertArticleParams) (int32, error)
+	InsertArticleRevision(ctx context.Context, arg InsertArticleRevisionParams) error
 	InsertChunk(ctx context.Context, arg InsertChunkParams) (int32, error)
 	InsertChunksBatch(ctx context.Context, arg []InsertChunksBatchParams) *InsertChunksBatchBatchResults
 	InsertEmbedding(ctx context.Context, arg InsertEmbeddingParams) (int32, error)
 	InsertEmbeddingBatch(ctx context.Context, arg []InsertEmbeddingBatchParams) *InsertEmbeddingBatchBatchResults
-	InsertModel(ctx context.Context, name string) (int32, error)
+	InsertEmbeddingsArchive(ctx context.Context, arg InsertEmbeddingsArchiveParams) (int64, error)
+	// A keyword already flagged on the day is not flagged again, no row is
+	// returned then.
+	InsertKeywordAnomaly(ctx context.Context, arg InsertKeywordAnomalyParams) (KeywordAnomaly, error)
+	InsertKeywordLink(ctx context.Context, arg InsertKeywordLinkParams) error
+	InsertModel(ctx context.Context, arg InsertModelParams) (int32, error)
+	InsertSavedSearch(ctx context.Context, arg InsertSavedSearchParams) (UsersSavedSearch, error)
+	InsertSavedSearchHits(ctx context.Context, arg InsertSavedSearchHitsParams) (int64, error)
+	InsertTaskEvent(ctx context.Context, arg InsertTaskEventParams) (UsersTaskEvent, error)
 	InsertTestUserArticle(ctx context.Context, arg InsertTestUserArticleParams) (int32, error)
 	InsertUserEmbedding(ctx context.Context, arg InsertUserEmbeddingParams) (int32, error)
 	InsertUserTask(ctx context.Context, arg InsertUserTaskParams) (uuid.UUID, error)
 	InsertUsersArticle(ctx context.Context, arg InsertUsersArticleParams) (int32, error)
+	InsertUsersArticleKeywords(ctx context.Context, arg InsertUsersArticleKeywordsParams) error
+	InsertUsersArticleRevision(ctx context.Context, arg InsertUsersArticleRevisionParams) error
 	InsertUsersChunk(ctx context.Context, arg InsertUsersChunkParams) (int32, error)
 	InsertUsersChunksBatch(ctx context.Context, arg []InsertUsersChunksBatchParams) *InsertUsersChunksBatchBatchResults
 	InsertUsersEmbedding(ctx context.Context, arg InsertUsersEmbeddingParams) (int32, error)
 	InsertUsersEmbeddingBatch(ctx context.Context, arg []InsertUsersEmbeddingBatchParams) *InsertUsersEmbeddingBatchBatchResults
-	ListModels(ctx context.Context, arg ListModelsParams) ([]ListModelsRow, error)
+	// Inserts the embedding unless the chunk is embedded under the model already,
+	// no row is returned then.
+	InsertUsersEmbeddingIfAbsent(ctx context.Context, arg InsertUsersEmbeddingIfAbsentParams) (int32, error)
+	// Counts the public articles mentioning each keyword per day, in the time
+	// zone tz, over the articles published in [published_from, published_to). The
+	// days without articles are not returned and the low information keywords are
+	// left out.
+	KeywordTrend(ctx context.Context, arg KeywordTrendParams) ([]KeywordTrendRow, error)
+	// LinkArticleDuplicates links the article of id, and the duplicates linked to
+	// it, to the article of canonical_id.
+	LinkArticleDuplicates(ctx context.Context, arg LinkArticleDuplicatesParams) (int64, error)
+	ListAnnotationsByArticleID(ctx context.Context, articleID int32) ([]Annotation, error)
+	// The candidate set is bounded by the model, the publishing date, and
+	// optionally the party, the vectors are re-scored by the caller.
+	ListArchivedEmbeddingCandidates(ctx context.Context, arg ListArchivedEmbeddingCandidatesParams) ([]ListArchivedEmbeddingCandidatesRow, error)
+	ListArchivedEmbeddingsByArticleIDs(ctx context.Context, articleIds []int32) ([]EmbeddingsArchive, error)
+	// Lists the articles published in [since, until) which match the filter, the
+	// newest first or, if ascending, the oldest first, after the cursor
+	// (after_published_at, after_id) if one is given. An empty party, source or
+	// query and a NULL bound match every article, the query matches the titles
+	// case-insensitively.
+	ListArticles(ctx context.Context, arg ListArticlesParams) ([]ListArticlesRow, error)
+	// Articles which have never been checked come first, then the ones checked
+	// least recently.
+	ListArticlesDueForCheck(ctx context.Context, limit int32) ([]ListArticlesDueForCheckRow, error)
+	// If dead is NULL, articles are returned regardless of their URL status. If
+	// terms is not empty, only the articles with one of the terms in the title or
+	// the content are returned.
+	ListArticlesWithURLStatus(ctx context.Context, arg ListArticlesWithURLStatusParams) ([]ListArticlesWithURLStatusRow, error)
+	ListCounters(ctx context.Context) ([]Counter, error)
+	// ListDoneTasksSince pages through the tasks done since since, by row id.
+	ListDoneTasksSince(ctx context.Context, arg ListDoneTasksSinceParams) ([]ListDoneTasksSinceRow, error)
+	// Saved searches which have never run come first, then the ones whose last
+	// run is the oldest.
+	ListDueSavedSearches(ctx context.Context, arg ListDueSavedSearchesParams) ([]UsersSavedSearch, error)
+	// The rows are locked until the end of the transaction, so concurrent
+	// archivers skip them instead of moving them twice.
+	ListEmbeddingsToArchive(ctx context.Context, arg ListEmbeddingsToArchiveParams) ([]ListEmbeddingsToArchiveRow, error)
+	ListKeywordAnomaliesSince(ctx context.Context, arg ListKeywordAnomaliesSinceParams) ([]ListKeywordAnomaliesSinceRow, error)
+	ListKeywordsByLang(ctx context.Context, arg ListKeywordsByLangParams) ([]Keyword, error)
+	// Lists the keywords of a user article with their category, the keywords
+	// attached without a category last.
+	ListKeywordsByUsersArticleID(ctx context.Context, articleID int32) ([]ListKeywordsByUsersArticleIDRow, error)
+	// ListKnownURLs returns the URLs among urls already scraped into an article or
+	// submitted as a URL task.
+	ListKnownURLs(ctx context.Context, urls []string) ([]string, error)
+	// ListLastVectorIndexReindexes returns the time of the last successful
+	// rebuild of each index.
+	ListLastVectorIndexReindexes(ctx context.Context) ([]ListLastVectorIndexReindexesRow, error)
+	ListLatestAnnotationsByArticleID(ctx context.Context, arg ListLatestAnnotationsByArticleIDParams) ([]LatestAnnotation, error)
+	ListModels(ctx context.Context) ([]Model, error)
+	// ListReconciliationReports returns the latest reports, newest first.
+	ListReconciliationReports(ctx context.Context, limit int32) ([]ReconciliationReport, error)
+	// Oldest first, an empty item type lists the items of every type.
+	ListReviewItems(ctx context.Context, arg ListReviewItemsParams) ([]UsersReviewQueue, error)
+	ListSavedSearchHits(ctx context.Context, arg ListSavedSearchHitsParams) ([]ListSavedSearchHitsRow, error)
+	ListSavedSearchesByOwner(ctx context.Context, ownerID string) ([]UsersSavedSearch, error)
+	ListSourceWeights(ctx context.Context) ([]SourceWeight, error)
+	// ListTaskArtifacts returns the pipeline artifacts of the user articles of a
+	// task: the title, the content, the keyword terms, sorted, and the number of
+	// chunks and of chunks with an embedding.
+	ListTaskArtifacts(ctx context.Context, taskID uuid.UUID) ([]ListTaskArtifactsRow, error)
+	ListTaskEvents(ctx context.Context, taskID uuid.UUID) ([]UsersTaskEvent, error)
+	// Ranks the articles by a score blending the recency decay
+	// exp(-age_hours / tau_hours), the weight of the source, default_source_weight
+	// if it has none, and the relevance, the share of the terms found in the
+	// title (half) and the content (half). The articles are filtered as by
+	// ListArticlesWithURLStatus. The score is computed in a subquery so the pages
+	// are cut on it, by offset.
+	ListTopArticles(ctx context.Context, arg ListTopArticlesParams) ([]ListTopArticlesRow, error)
+	// Counts the user articles published since since by keyword, leaving out the
+	// low information keywords.
+	ListTopUsersKeywords(ctx context.Context, arg ListTopUsersKeywordsParams) ([]ListTopUsersKeywordsRow, error)
 	ListUserTasks(ctx context.Context, arg ListUserTasksParams) ([]UsersTask, error)
+	ListUserTasksByStatus(ctx context.Context, arg ListUserTasksByStatusParams) ([]UsersTask, error)
+	// Lists the user articles of the tasks of task_status, the newest first.
+	ListUsersArticlesByTaskStatus(ctx context.Context, arg ListUsersArticlesByTaskStatusParams) ([]ListUsersArticlesByTaskStatusRow, error)
+	// The user articles scraped from a URL and published since since which have
+	// never been checked, or not since checked_before, least recently checked
+	// first.
+	ListUsersArticlesDueForRecheck(ctx context.Context, arg ListUsersArticlesDueForRecheckParams) ([]ListUsersArticlesDueForRecheckRow, error)
+	// The chunks among chunk_ids embedded under the model.
+	ListUsersEmbeddedChunkIDs(ctx context.Context, arg ListUsersEmbeddedChunkIDsParams) ([]int32, error)
+	// ListVectorIndexes returns the pgvector indexes of the database with the
+	// column or the expression they cover and its type, the predicate of a partial
+	// index, empty for the others, the operator class, their build options, their
+	// size and the estimated number of rows they cover.
+	ListVectorIndexes(ctx context.Context) ([]ListVectorIndexesRow, error)
+	// Serializes the inserts of the saved searches of an owner until the end of
+	// the transaction, so that the per-owner cap holds under concurrent inserts.
+	LockSavedSearchOwner(ctx context.Context, ownerID string) error
+	// Serializes the appends to the event stream of a task.
+	LockUserTask(ctx context.Context, taskID uuid.UUID) (int32, error)
+	// PurgeUserTasks deletes up to batch_size tasks in a terminal state last
+	// updated before cutoff, with everything cascading from them, and returns the
+	// number of tasks and of their articles deleted.
+	PurgeUserTasks(ctx context.Context, arg PurgeUserTasksParams) (PurgeUserTasksRow, error)
+	// Replaces the scraped fields of the article of the task, and clears its
+	// review flag.
+	ReplaceUsersArticle(ctx context.Context, arg ReplaceUsersArticleParams) (int64, error)
+	RestoreEmbeddings(ctx context.Context, arg RestoreEmbeddingsParams) (int64, error)
+	ReviewQueueDepth(ctx context.Context) ([]ReviewQueueDepthRow, error)
+	ReviewQueueMedianResolution(ctx context.Context, since pgtype.Timestamptz) ([]ReviewQueueMedianResolutionRow, error)
+	// Lists the articles published in (published_after, published_before] which
+	// match the filter, newest first. An empty party or source and an empty list
+	// of keywords match every article. The keywords are matched against the
+	// effective keywords, with the keyword annotations of the editors applied,
+	// and each article carries its effective summary, empty if it has none or the
+	// editors rejected it.
+	SearchArticles(ctx context.Context, arg SearchArticlesParams) ([]SearchArticlesRow, error)
+	// Ranks the articles matching query, by the full-text search or, for the words
+	// the text search configuration cannot segment, as a substring of the title or
+	// the content, the best match first or, if by_recency, the newest first. The
+	// headline is the fragments of the content around the matches.
+	SearchArticlesByText(ctx context.Context, arg SearchArticlesByTextParams) ([]SearchArticlesByTextRow, error)
+	// The chunks of the articles nearest to query under the cosine distance, no
+	// farther than max_distance, with their offsets in the article content.
+	SearchSimilarEmbeddings(ctx context.Context, arg SearchSimilarEmbeddingsParams) ([]SearchSimilarEmbeddingsRow, error)
+	// The chunks of the user articles nearest to query under the cosine distance,
+	// no farther than max_distance, with their offsets in the article content.
+	SearchSimilarUsersEmbeddings(ctx context.Context, arg SearchSimilarUsersEmbeddingsParams) ([]SearchSimilarUsersEmbeddingsRow, error)
+	SetCounter(ctx context.Context, arg SetCounterParams) error
+	SetKeywordLowInformation(ctx context.Context, arg SetKeywordLowInformationParams) error
+	SetUsersArticleNeedsReview(ctx context.Context, arg SetUsersArticleNeedsReviewParams) (int64, error)
+	// A NULL modified_at keeps the stored modification time.
+	TouchUsersArticleChecked(ctx context.Context, arg TouchUsersArticleCheckedParams) (int64, error)
+	// TransitionUserTaskStatus moves the task to task_status only if its status is
+	// one of from_statuses, so that a concurrent update is never overwritten by an
+	// illegal transition.
+	TransitionUserTaskStatus(ctx context.Context, arg TransitionUserTaskStatusParams) (int64, error)
+	UpdateArticleURL(ctx context.Context, arg UpdateArticleURLParams) error
+	// UpdateReconciliationReport records the progress of a pass, and finishes it
+	// if finished is set.
+	UpdateReconciliationReport(ctx context.Context, arg UpdateReconciliationReportParams) (ReconciliationReport, error)
+	UpdateSavedSearch(ctx context.Context, arg UpdateSavedSearchParams) (UsersSavedSearch, error)
+	// Moves last_run_at of a saved search forward, unless another run has moved it
+	// since prev_run_at was read.
+	UpdateSavedSearchLastRun(ctx context.Context, arg UpdateSavedSearchLastRunParams) (int64, error)
 	UpdateUserTaskErrMsg(ctx context.Context, arg UpdateUserTaskErrMsgParams) error
-	UpdateUserTaskStatus(ctx context.Context, arg UpdateUserTaskStatusParams) error
+	// The title and the content before the update are returned, to be kept as
+	// revisions.
+	UpdateUsersArticleContent(ctx context.Context, arg UpdateUsersArticleContentParams) (UpdateUsersArticleContentRow, error)
+	// Inserts the article, or leaves the one of the same md5 as it is, and returns
+	// its id either way. inserted is false for an existing article.
+	UpsertArticleByMD5(ctx context.Context, arg UpsertArticleByMD5Params) (UpsertArticleByMD5Row, error)
+	// Inserts the terms which do not exist yet in lang, and returns all of them.
+	UpsertKeywords(ctx context.Context, arg UpsertKeywordsParams) ([]Keyword, error)
+	UpsertSourceWeight(ctx context.Context, arg UpsertSourceWeightParams) (SourceWeight, error)
+	UpsertTaskState(ctx context.Context, arg UpsertTaskStateParams) error
+	UpsertURLStatus(ctx context.Context, arg UpsertURLStatusParams) error
+	// Inserts the article, or leaves the one of the same md5 as it is, and returns
+	// its id either way. inserted is false for an existing article.
+	UpsertUsersArticleByMD5(ctx context.Context, arg UpsertUsersArticleByMD5Params) (UpsertUsersArticleByMD5Row, error)
+	// Attaches the keywords to a user article with their category, the category of
+	// a keyword attached already is updated.
+	UpsertUsersArticleKeywords(ctx context.Context, arg UpsertUsersArticleKeywordsParams) error
 }
 
 var _ Querier = (*Queries)(nil)
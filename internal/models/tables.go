@@ -0,0 +1,36 @@
+package models
+
+// Tables lists the tables, as schema.table, the queries of this package run
+// against. It is kept alongside the models, a table added by a migration and
+// used by a query belongs here too.
+var Tables = []string{
+	"public.annotations",
+	"public.article_revisions",
+	"public.article_stances",
+	"public.article_summaries",
+	"public.articles",
+	"public.articles_keywords",
+	"public.chunks",
+	"public.counters",
+	"public.embeddings",
+	"public.embeddings_archive",
+	"public.keyword_anomalies",
+	"public.keyword_links",
+	"public.keywords",
+	"public.models",
+	"public.reconciliation_reports",
+	"public.source_weights",
+	"public.url_status",
+	"public.vector_index_maintenance",
+	"users.article_revisions",
+	"users.articles",
+	"users.articles_keywords",
+	"users.chunks",
+	"users.embeddings",
+	"users.review_queue",
+	"users.saved_search_hits",
+	"users.saved_searches",
+	"users.task_events",
+	"users.task_state",
+	"users.tasks",
+}
@@ -12,8 +12,23 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const deleteUserTask = `-- name: DeleteUserTask :execrows
+DELETE FROM users.tasks
+WHERE task_id = $1
+`
+
+// DeleteUserTask deletes the task, its articles, their chunks, embeddings,
+// keywords and reviews, and its events go with it through the cascades.
+func (q *Queries) DeleteUserTask(ctx context.Context, taskID uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserTask, taskID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const getUserTask = `-- name: GetUserTask :one
-SELECT id, task_id, source, original_input, status, error_message, created_at, updated_at FROM users.tasks
+SELECT id, task_id, source, original_input, status, error_message, created_at, updated_at, owner_id FROM users.tasks
 WHERE task_id = $1
 `
 
@@ -29,35 +44,87 @@ func (q *Queries) GetUserTask(ctx context.Context, taskID uuid.UUID) (UsersTask,
 		&i.ErrorMessage,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.OwnerID,
 	)
 	return i, err
 }
 
+const getUserTaskStatus = `-- name: GetUserTaskStatus :one
+SELECT status, error_message, updated_at FROM users.tasks
+WHERE task_id = $1
+`
+
+type GetUserTaskStatusRow struct {
+	Status       TaskStatus         `db:"status" json:"status"`
+	ErrorMessage pgtype.Text        `db:"error_message" json:"error_message"`
+	UpdatedAt    pgtype.Timestamptz `db:"updated_at" json:"updated_at"`
+}
+
+func (q *Queries) GetUserTaskStatus(ctx context.Context, taskID uuid.UUID) (GetUserTaskStatusRow, error) {
+	row := q.db.QueryRow(ctx, getUserTaskStatus, taskID)
+	var i GetUserTaskStatusRow
+	err := row.Scan(&i.Status, &i.ErrorMessage, &i.UpdatedAt)
+	return i, err
+}
+
 const insertUserTask = `-- name: InsertUserTask :one
 INSERT INTO users.tasks (
     source,
-    original_input
+    original_input,
+    owner_id
 ) VALUES (
     $1,
-    $2
+    $2,
+    $3
 )
 RETURNING task_id
 `
 
 type InsertUserTaskParams struct {
-	Source        SourceType `db:"source" json:"source"`
-	OriginalInput string     `db:"original_input" json:"original_input"`
+	Source        SourceType  `db:"source" json:"source"`
+	OriginalInput string      `db:"original_input" json:"original_input"`
+	OwnerID       pgtype.Text `db:"owner_id" json:"owner_id"`
 }
 
 func (q *Queries) InsertUserTask(ctx context.Context, arg InsertUserTaskParams) (uuid.UUID, error) {
-	row := q.db.QueryRow(ctx, insertUserTask, arg.Source, arg.OriginalInput)
+	row := q.db.QueryRow(ctx, insertUserTask, arg.Source, arg.OriginalInput, arg.OwnerID)
 	var task_id uuid.UUID
 	err := row.Scan(&task_id)
 	return task_id, err
 }
 
+const listKnownURLs = `-- name: ListKnownURLs :many
+SELECT "url" FROM articles
+WHERE "url" = ANY($1::text[])
+UNION
+SELECT original_input AS "url" FROM users.tasks
+WHERE source = 'url' AND original_input = ANY($1::text[])
+`
+
+// ListKnownURLs returns the URLs among urls already scraped into an article or
+// submitted as a URL task.
+func (q *Queries) ListKnownURLs(ctx context.Context, urls []string) ([]string, error) {
+	rows, err := q.db.Query(ctx, listKnownURLs, urls)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		items = append(items, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listUserTasks = `-- name: ListUserTasks :many
-SELECT id, task_id, source, original_input, status, error_message, created_at, updated_at FROM users.tasks
+SELECT id, task_id, source, original_input, status, error_message, created_at, updated_at, owner_id FROM users.tasks
 WHERE id > $1
 ORDER BY id DESC
 LIMIT $2::integer
@@ -86,6 +153,7 @@ func (q *Queries) ListUserTasks(ctx context.Context, arg ListUserTasksParams) ([
 			&i.ErrorMessage,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.OwnerID,
 		); err != nil {
 			return nil, err
 		}
@@ -97,34 +165,144 @@ func (q *Queries) ListUserTasks(ctx context.Context, arg ListUserTasksParams) ([
 	return items, nil
 }
 
-const updateUserTaskErrMsg = `-- name: UpdateUserTaskErrMsg :exec
+const listUserTasksByStatus = `-- name: ListUserTasksByStatus :many
+SELECT id, task_id, source, original_input, status, error_message, created_at, updated_at, owner_id FROM users.tasks
+WHERE status = $1::task_status
+ORDER BY updated_at DESC, id DESC
+LIMIT $2::integer
+OFFSET $3::integer
+`
+
+type ListUserTasksByStatusParams struct {
+	TaskStatus TaskStatus `db:"task_status" json:"task_status"`
+	Limit      int32      `db:"limit" json:"limit"`
+	Offset     int32      `db:"offset" json:"offset"`
+}
+
+func (q *Queries) ListUserTasksByStatus(ctx context.Context, arg ListUserTasksByStatusParams) ([]UsersTask, error) {
+	rows, err := q.db.Query(ctx, listUserTasksByStatus, arg.TaskStatus, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UsersTask
+	for rows.Next() {
+		var i UsersTask
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.Source,
+			&i.OriginalInput,
+			&i.Status,
+			&i.ErrorMessage,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.OwnerID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const purgeUserTasks = `-- name: PurgeUserTasks :one
+WITH doomed AS (
+    SELECT task_id
+    FROM users.tasks
+    WHERE status IN ('done', 'failed')
+        AND updated_at < $1::timestamptz
+    ORDER BY updated_at, id
+    LIMIT $2::integer
+), deleted AS (
+    DELETE FROM users.tasks
+    WHERE task_id IN (
+            SELECT task_id
+            FROM doomed
+        )
+    RETURNING task_id
+)
+SELECT (
+        SELECT COUNT(*)
+        FROM deleted
+    )::bigint AS tasks,
+    (
+        SELECT COUNT(*)
+        FROM users.articles
+        WHERE task_id IN (
+                SELECT task_id
+                FROM doomed
+            )
+    )::bigint AS articles
+`
+
+type PurgeUserTasksParams struct {
+	Cutoff    pgtype.Timestamptz `db:"cutoff" json:"cutoff"`
+	BatchSize int32              `db:"batch_size" json:"batch_size"`
+}
+
+type PurgeUserTasksRow struct {
+	Tasks    int64 `db:"tasks" json:"tasks"`
+	Articles int64 `db:"articles" json:"articles"`
+}
+
+// PurgeUserTasks deletes up to batch_size tasks in a terminal state last
+// updated before cutoff, with everything cascading from them, and returns the
+// number of tasks and of their articles deleted.
+func (q *Queries) PurgeUserTasks(ctx context.Context, arg PurgeUserTasksParams) (PurgeUserTasksRow, error) {
+	row := q.db.QueryRow(ctx, purgeUserTasks, arg.Cutoff, arg.BatchSize)
+	var i PurgeUserTasksRow
+	err := row.Scan(&i.Tasks, &i.Articles)
+	return i, err
+}
+
+const transitionUserTaskStatus = `-- name: TransitionUserTaskStatus :execrows
 UPDATE users.tasks
-SET error_message = $1, status = 'failed', updated_at = NOW()
-WHERE task_id = $2
+SET status = $1::task_status,
+    error_message = $2,
+    updated_at = NOW()
+WHERE task_id = $3
+    AND status::text = ANY($4::text[])
 `
 
-type UpdateUserTaskErrMsgParams struct {
+type TransitionUserTaskStatusParams struct {
+	TaskStatus   TaskStatus  `db:"task_status" json:"task_status"`
 	ErrorMessage pgtype.Text `db:"error_message" json:"error_message"`
 	TaskID       uuid.UUID   `db:"task_id" json:"task_id"`
+	FromStatuses []string    `db:"from_statuses" json:"from_statuses"`
 }
 
-func (q *Queries) UpdateUserTaskErrMsg(ctx context.Context, arg UpdateUserTaskErrMsgParams) error {
-	_, err := q.db.Exec(ctx, updateUserTaskErrMsg, arg.ErrorMessage, arg.TaskID)
-	return err
+// TransitionUserTaskStatus moves the task to task_status only if its status is
+// one of from_statuses, so that a concurrent update is never overwritten by an
+// illegal transition.
+func (q *Queries) TransitionUserTaskStatus(ctx context.Context, arg TransitionUserTaskStatusParams) (int64, error) {
+	result, err := q.db.Exec(ctx, transitionUserTaskStatus,
+		arg.TaskStatus,
+		arg.ErrorMessage,
+		arg.TaskID,
+		arg.FromStatuses,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-const updateUserTaskStatus = `-- name: UpdateUserTaskStatus :exec
+const updateUserTaskErrMsg = `-- name: UpdateUserTaskErrMsg :exec
 UPDATE users.tasks
-SET status = $2::task_status, updated_at = NOW()
-WHERE task_id = $1
+SET error_message = $1, status = 'failed', updated_at = NOW()
+WHERE task_id = $2
 `
 
-type UpdateUserTaskStatusParams struct {
-	TaskID     uuid.UUID  `db:"task_id" json:"task_id"`
-	TaskStatus TaskStatus `db:"task_status" json:"task_status"`
+type UpdateUserTaskErrMsgParams struct {
+	ErrorMessage pgtype.Text `db:"error_message" json:"error_message"`
+	TaskID       uuid.UUID   `db:"task_id" json:"task_id"`
 }
 
-func (q *Queries) UpdateUserTaskStatus(ctx context.Context, arg UpdateUserTaskStatusParams) error {
-	_, err := q.db.Exec(ctx, updateUserTaskStatus, arg.TaskID, arg.TaskStatus)
+func (q *Queries) UpdateUserTaskErrMsg(ctx context.Context, arg UpdateUserTaskErrMsgParams) error {
+	_, err := q.db.Exec(ctx, updateUserTaskErrMsg, arg.ErrorMessage, arg.TaskID)
 	return err
 }
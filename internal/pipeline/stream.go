@@ -0,0 +1,231 @@
+// Package pipeline processes the texts of the tasks as streams, so that a very
+// large submitted text is never held in memory as a whole, let alone several
+// times over.
+package pipeline
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+)
+
+// DefaultStreamThreshold is the size in bytes of a text above which it is
+// chunked as a stream rather than in memory.
+const DefaultStreamThreshold = 256 << 10
+
+var ErrCutsNotSorted = errors.New("paragraph cuts should be sorted")
+
+// TextStream reads a text paragraph by paragraph and rune by rune, keeping
+// track of the rune offset of the paragraphs in the whole text.
+type TextStream struct {
+	r *bufio.Reader
+	// cuts are the byte offsets ending the paragraphs, see utils.Join.
+	cuts []int32
+	// whole is whether the text is one paragraph, lines whether its lines are.
+	whole, lines bool
+	// pos is the number of bytes read.
+	pos int64
+	// end is the byte offset ending the current paragraph, -1 if it ends at
+	// the end of the text or of its line.
+	end    int64
+	inPara bool
+	eof    bool
+	err    error
+}
+
+// NewTextStream reads the text of r split into paragraphs at cuts, the byte
+// offsets ending each paragraph, as utils.Split does: the text after the last
+// cut is dropped and, without cuts, the whole text is one paragraph.
+func NewTextStream(r io.Reader, cuts []int32) *TextStream {
+	return &TextStream{r: bufio.NewReader(r), cuts: cuts, whole: len(cuts) == 0, end: -1}
+}
+
+// NewLineStream reads the text of r split into lines, the paragraphs of a
+// submitted text, as strings.SplitAfter(text, "\n") does: a line keeps its
+// newline so that the offsets index the text as it is.
+func NewLineStream(r io.Reader) *TextStream {
+	return &TextStream{r: bufio.NewReader(r), lines: true, end: -1}
+}
+
+// Err returns the first error reading the text other than io.EOF.
+func (s *TextStream) Err() error {
+	return s.err
+}
+
+// nextParagraph moves to the next paragraph, skipping what is left of the
+// current one. It returns false at the end of the text.
+func (s *TextStream) nextParagraph() bool {
+	for s.inPara {
+		if _, ok := s.readRune(); !ok {
+			break
+		}
+	}
+	if s.eof || s.err != nil {
+		return false
+	}
+
+	switch {
+	case s.lines:
+		if _, err := s.r.Peek(1); err != nil {
+			s.fail(err)
+			return false
+		}
+	case s.whole:
+		// the paragraph is read once
+		if s.pos > 0 {
+			return false
+		}
+	default:
+		if len(s.cuts) == 0 {
+			return false
+		}
+		if int64(s.cuts[0]) < s.pos {
+			s.err = fmt.Errorf("%w: %d after %d", ErrCutsNotSorted, s.cuts[0], s.pos)
+			return false
+		}
+		s.end, s.cuts = int64(s.cuts[0]), s.cuts[1:]
+	}
+	s.inPara = true
+	return true
+}
+
+// readRune returns the next rune of the current paragraph, and false at its
+// end. An invalid byte is read as utf8.RuneError, as a conversion to []rune
+// does.
+func (s *TextStream) readRune() (rune, bool) {
+	if !s.inPara {
+		return 0, false
+	}
+	if s.end >= 0 && s.pos >= s.end {
+		s.inPara = false
+		return 0, false
+	}
+
+	r, size, err := s.r.ReadRune()
+	if err != nil {
+		s.inPara = false
+		s.fail(err)
+		return 0, false
+	}
+	s.pos += int64(size)
+
+	if s.lines && r == '\n' {
+		s.inPara = false
+	}
+	return r, true
+}
+
+func (s *TextStream) fail(err error) {
+	s.eof = true
+	if !errors.Is(err, io.EOF) {
+		s.err = err
+	}
+}
+
+// Chunk is a chunk of a text stream.
+type Chunk struct {
+	llm.ChunkOffsets
+	// Text is the text of the chunk, overlaps included.
+	Text string
+}
+
+// Chunker chunks a TextStream exactly as llm.ChunckParagraphsOffsets chunks the
+// paragraphs of the text, holding no more than a chunk of runes at once
+// whatever the size of the text.
+type Chunker struct {
+	stream        *TextStream
+	size, overlap int
+
+	inPara bool
+	// paraStart is the rune offset of the current paragraph in the text.
+	paraStart int
+	// buf holds the runes of the current paragraph from bufStart on.
+	buf      []rune
+	bufStart int
+	// read is whether the current paragraph has been read up to its end.
+	read bool
+	// i is the start of the unique content of the next chunk in the paragraph.
+	i int
+}
+
+// NewChunker chunks stream into chunks of size runes overlapping by overlap
+// runes, see llm.ChunckParagraphsOffsets.
+func NewChunker(stream *TextStream, size, overlap int) (*Chunker, error) {
+	if size <= 0 {
+		return nil, llm.ErrChunkSizeTooSmall
+	}
+	if overlap <= 1 || overlap >= size || overlap%2 != 0 {
+		return nil, llm.ErrInvalidChunkOverlap
+	}
+	return &Chunker{
+		stream:  stream,
+		size:    size,
+		overlap: overlap,
+		buf:     make([]rune, 0, size),
+	}, nil
+}
+
+// Next returns the next chunk, and io.EOF once the text is chunked.
+func (c *Chunker) Next() (Chunk, error) {
+	for {
+		if !c.inPara {
+			if !c.stream.nextParagraph() {
+				if err := c.stream.Err(); err != nil {
+					return Chunk{}, err
+				}
+				return Chunk{}, io.EOF
+			}
+			c.inPara, c.read, c.i = true, false, 0
+			c.buf, c.bufStart = c.buf[:0], 0
+		}
+
+		// read the paragraph up to the end of the chunk, or to its end
+		need := c.i + c.size - c.overlap/2
+		for !c.read && c.bufStart+len(c.buf) < need {
+			r, ok := c.stream.readRune()
+			if !ok {
+				c.read = true
+				break
+			}
+			c.buf = append(c.buf, r)
+		}
+
+		length := need
+		if c.read {
+			length = c.bufStart + len(c.buf)
+		}
+		if length == 0 {
+			c.inPara = false
+			continue
+		}
+
+		start := max(0, c.i-c.overlap/2)
+		end := min(length, need)
+		uniqueEnd := min(length, c.i+c.size-c.overlap)
+		chunk := Chunk{
+			ChunkOffsets: llm.ChunkOffsets{
+				Start:       int32(c.paraStart + start),
+				OffsetLeft:  int32(c.i - start),
+				OffsetRight: int32(uniqueEnd - start),
+				End:         int32(c.paraStart + end),
+			},
+			Text: string(c.buf[start-c.bufStart : end-c.bufStart]),
+		}
+
+		if c.read && uniqueEnd >= length {
+			c.inPara = false
+			c.paraStart += length
+			return chunk, nil
+		}
+
+		// drop the runes before the next chunk
+		c.i += c.size - c.overlap
+		next := max(0, c.i-c.overlap/2)
+		c.buf = c.buf[:copy(c.buf, c.buf[next-c.bufStart:])]
+		c.bufStart = next
+		return chunk, nil
+	}
+}
@@ -0,0 +1,223 @@
+package pipeline_test
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/pipeline"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/stretchr/testify/require"
+)
+
+var alphabet = []rune("交通部宣布下修高齡換照年齡立委反彈受害者。，「」abc XYZ 123.!?\n\xff")
+
+// randomParagraphs returns up to n paragraphs of up to maxLen runes, some of
+// them empty.
+func randomParagraphs(r *rand.Rand, n, maxLen int) []string {
+	paragraphs := make([]string, r.IntN(n+1))
+	for i := range paragraphs {
+		if r.IntN(8) == 0 {
+			continue
+		}
+
+		var b strings.Builder
+		for range r.IntN(maxLen) + 1 {
+			b.WriteRune(alphabet[r.IntN(len(alphabet))])
+		}
+		paragraphs[i] = b.String()
+	}
+	return paragraphs
+}
+
+// chunkAll returns all the chunks of stream.
+func chunkAll(t *testing.T, stream *pipeline.TextStream, size, overlap int) []pipeline.Chunk {
+	t.Helper()
+	chunker, err := pipeline.NewChunker(stream, size, overlap)
+	require.NoError(t, err)
+
+	var chunks []pipeline.Chunk
+	for {
+		c, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			return chunks
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, c)
+	}
+}
+
+func requireEquivalent(t *testing.T, text string, paragraphs []string, chunks []pipeline.Chunk, size, overlap int) {
+	t.Helper()
+	want, err := llm.ChunckParagraphsOffsets(paragraphs, size, overlap)
+	require.NoError(t, err)
+	require.Len(t, chunks, len(want))
+
+	runes := []rune(text)
+	for i, c := range chunks {
+		require.Equal(t, want[i], c.ChunkOffsets, "chunk %d", i)
+		require.Equal(t, string(runes[c.Start:c.End]), c.Text, "chunk %d", i)
+	}
+}
+
+func TestChunkerEquivalence(t *testing.T) {
+	r := rand.New(rand.NewPCG(2185, 1))
+	for i := range 500 {
+		size := r.IntN(64) + 3
+		overlap := 2 * (r.IntN((size-1)/2) + 1)
+		if overlap >= size {
+			overlap -= 2
+		}
+		if overlap < 2 {
+			size, overlap = 4, 2
+		}
+
+		paragraphs := randomParagraphs(r, 12, 4*size)
+		text, cuts := utils.Join(paragraphs)
+		cuts32 := make([]int32, len(cuts))
+		for j, cut := range cuts {
+			cuts32[j] = int32(cut)
+		}
+		chunks := chunkAll(t, pipeline.NewTextStream(strings.NewReader(text), cuts32), size, overlap)
+		requireEquivalent(t, text, utils.Split(text, cuts), chunks, size, overlap)
+
+		// the lines of a submitted text
+		chunks = chunkAll(t, pipeline.NewLineStream(strings.NewReader(text)), size, overlap)
+		requireEquivalent(t, text, strings.SplitAfter(text, "\n"), chunks, size, overlap)
+
+		// a text without cuts is one paragraph
+		if i%10 == 0 {
+			chunks = chunkAll(t, pipeline.NewTextStream(strings.NewReader(text), nil), size, overlap)
+			requireEquivalent(t, text, []string{text}, chunks, size, overlap)
+		}
+	}
+}
+
+func TestChunkerEquivalenceLargeText(t *testing.T) {
+	r := rand.New(rand.NewPCG(2185, 2))
+	for range 3 {
+		paragraphs := randomParagraphs(r, 200, 20_000)
+		text, cuts := utils.Join(paragraphs)
+		cuts32 := make([]int32, len(cuts))
+		for j, cut := range cuts {
+			cuts32[j] = int32(cut)
+		}
+
+		// a stream read in small pieces, decoding runes across the reads
+		stream := pipeline.NewTextStream(iotest.HalfReader(strings.NewReader(text)), cuts32)
+		chunks := chunkAll(t, stream, 512, 64)
+		requireEquivalent(t, text, paragraphs, chunks, 512, 64)
+	}
+}
+
+func TestChunkerErrors(t *testing.T) {
+	stream := pipeline.NewTextStream(strings.NewReader("text"), nil)
+	_, err := pipeline.NewChunker(stream, 0, 2)
+	require.ErrorIs(t, err, llm.ErrChunkSizeTooSmall)
+	_, err = pipeline.NewChunker(stream, 10, 3)
+	require.ErrorIs(t, err, llm.ErrInvalidChunkOverlap)
+
+	errRead := errors.New("read")
+	stream = pipeline.NewTextStream(io.MultiReader(strings.NewReader("交通部宣布"), iotest.ErrReader(errRead)), nil)
+	chunker, err := pipeline.NewChunker(stream, 4, 2)
+	require.NoError(t, err)
+	for err == nil {
+		_, err = chunker.Next()
+	}
+	require.ErrorIs(t, err, errRead)
+
+	stream = pipeline.NewTextStream(strings.NewReader("交通部宣布"), []int32{6, 3})
+	chunker, err = pipeline.NewChunker(stream, 4, 2)
+	require.NoError(t, err)
+	for err == nil {
+		_, err = chunker.Next()
+	}
+	require.ErrorIs(t, err, pipeline.ErrCutsNotSorted)
+}
+
+// syntheticText reads n bytes of Chinese text without holding it, repeating a
+// paragraph.
+type syntheticText struct {
+	n, off int
+}
+
+const syntheticParagraph = "新北三峽發生重大車禍，交通部也宣布，將下修高齡換照年齡，從75歲降至70歲，卻引發部分「資深」立委反彈。"
+
+func (s *syntheticText) Read(p []byte) (int, error) {
+	if s.off >= s.n {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && s.off < s.n {
+		i := s.off % len(syntheticParagraph)
+		c := copy(p[n:min(len(p), n+s.n-s.off)], syntheticParagraph[i:])
+		n += c
+		s.off += c
+	}
+	return n, nil
+}
+
+// chunkPeakHeap chunks a synthetic text of n bytes and returns the peak of the
+// live heap while doing so.
+func chunkPeakHeap(tb testing.TB, n int) (chunks int, peak uint64) {
+	tb.Helper()
+	chunker, err := pipeline.NewChunker(pipeline.NewTextStream(&syntheticText{n: n}, nil), 512, 64)
+	require.NoError(tb, err)
+
+	var stats runtime.MemStats
+	for {
+		_, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			return chunks, peak
+		}
+		require.NoError(tb, err)
+
+		if chunks++; chunks%100 == 0 {
+			runtime.GC()
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapAlloc)
+		}
+	}
+}
+
+func TestChunkerBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("chunks megabytes of text")
+	}
+
+	runtime.GC()
+	var base runtime.MemStats
+	runtime.ReadMemStats(&base)
+
+	_, small := chunkPeakHeap(t, 1<<20)
+	_, large := chunkPeakHeap(t, 5<<20)
+	// the live heap does not grow with the text, a copy of the 5 MB text
+	// alone would exceed the slack
+	require.Less(t, large, max(small, base.HeapAlloc)+1<<20)
+}
+
+func BenchmarkChunker(b *testing.B) {
+	for _, bc := range []struct {
+		Name string
+		Size int
+	}{
+		{"1MB", 1 << 20},
+		{"5MB", 5 << 20},
+	} {
+		b.Run(bc.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			var peak uint64
+			for b.Loop() {
+				_, p := chunkPeakHeap(b, bc.Size)
+				peak = max(peak, p)
+			}
+			b.ReportMetric(float64(peak), "peak-heap-B")
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/go-playground/validator/v10"
+)
+
+// EditorTokenHeader is the header carrying the editor token, an
+// "Authorization: Bearer <token>" header is accepted as well.
+const EditorTokenHeader = apischema.EditorTokenHeader
+
+// Annotations provides methods to manage the annotations editors attach to
+// article outputs. All methods require a valid editor token.
+type Annotations struct {
+	*Repo
+	*validator.Validate
+	editorToken string
+}
+
+// Annotations converts Repo to an AnnotationsEndpoint guarded by the given
+// editor token. An empty token disables the endpoint.
+func (r *Repo) Annotations(validator *validator.Validate, editorToken string) AnnotationsEndpoint {
+	return Annotations{
+		Repo:        r,
+		Validate:    validator,
+		editorToken: editorToken,
+	}
+}
+
+// AnnotationRequest is the request body to create an annotation.
+type AnnotationRequest struct {
+	TargetType  models.AnnotationTarget `json:"target_type" validate:"required,oneof=keyword stance summary"`
+	TargetID    string                  `json:"target_id"   validate:"required,max=256"`
+	Action      models.AnnotationAction `json:"action"      validate:"required,oneof=confirm reject replace"`
+	Replacement json.RawMessage         `json:"replacement,omitempty" validate:"required_if=Action replace"`
+	Author      string                  `json:"author"      validate:"required,max=64"`
+}
+
+func (a Annotations) Create(r *http.Request) (*models.Annotation, error) {
+	if err := a.authorize(r); err != nil {
+		return nil, err
+	}
+
+	aID, err := articleID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var req AnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errors.ErrBadRequest.Clone().
+			WithDetails("failed to decode annotation request body").
+			Warp(err)
+	}
+
+	vCtx, vCancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer vCancel()
+	if err := a.Validate.StructCtx(vCtx, req); err != nil {
+		return nil, errors.ErrValidationFailed.Clone().
+			WithDetails(err.Error()).
+			Warp(err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	annotation, err := a.Storage.Annotations().Create(ctx, aID, req.TargetType,
+		req.TargetID, req.Action, req.Replacement, req.Author)
+	if err != nil {
+		return nil, err
+	}
+	return &annotation, nil
+}
+
+func (a Annotations) List(r *http.Request) ([]models.Annotation, error) {
+	if err := a.authorize(r); err != nil {
+		return nil, err
+	}
+
+	aID, err := articleID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	return a.Storage.Annotations().ListByArticle(ctx, aID)
+}
+
+func (a Annotations) Delete(r *http.Request) error {
+	if err := a.authorize(r); err != nil {
+		return err
+	}
+
+	aID, err := articleID(r)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("annotation_id"), 10, 32)
+	if err != nil || id <= 0 {
+		return errors.ErrBadRequest.Clone().
+			WithDetails("invalid annotation_id format").
+			Warp(err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	return a.Storage.Annotations().Delete(ctx, aID, int32(id))
+}
+
+// authorize checks the editor token of the request in constant time.
+func (a Annotations) authorize(r *http.Request) error {
+	return authorizeEditor(r, a.editorToken, "annotation")
+}
+
+// authorizeEditor checks the editor token of the request against editorToken
+// in constant time. An empty editorToken disables the endpoints of feature.
+func authorizeEditor(r *http.Request, editorToken, feature string) error {
+	if editorToken == "" {
+		return errors.ErrForbidden.Clone().
+			WithDetails(fmt.Sprintf("%s endpoints are disabled, no editor token configured", feature))
+	}
+
+	token := r.Header.Get(EditorTokenHeader)
+	if token == "" {
+		token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+
+	if token == "" {
+		return errors.ErrUnauthorized.Clone().
+			WithDetails("missing editor token")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(editorToken)) != 1 {
+		return errors.ErrForbidden.Clone().
+			WithDetails("invalid editor token")
+	}
+	return nil
+}
+
+func articleID(r *http.Request) (int32, error) {
+	id, err := strconv.ParseInt(r.PathValue("article_id"), 10, 32)
+	if err != nil || id <= 0 {
+		return 0, errors.ErrBadRequest.Clone().
+			WithDetails(fmt.Sprintf("invalid article_id format: %q", r.PathValue("article_id"))).
+			Warp(err)
+	}
+	return int32(id), nil
+}
@@ -3,7 +3,10 @@ package api
 import (
 	"net/http"
 
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/global"
 	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
 	"github.com/google/uuid"
 )
 
@@ -11,8 +14,64 @@ type TaskEndpoint interface {
 	InsertFromText(r *http.Request) (uuid.UUID, error)
 	InsertFromURL(r *http.Request) (uuid.UUID, error)
 	Get(r *http.Request) (*models.UsersTask, error)
+	Similar(r *http.Request) ([]storage.SimilarArticle, error)
+	Timeline(r *http.Request) (*storage.TaskTimeline, error)
+}
+
+type PublicArticlesEndpoint interface {
+	List(r *http.Request) ([]ArticleListItem, error)
 }
 
 type UserArticlesEndpoint interface {
 	GetByTaskID(r *http.Request) (*models.UsersArticle, error)
 }
+
+type AnnotationsEndpoint interface {
+	Create(r *http.Request) (*models.Annotation, error)
+	List(r *http.Request) ([]models.Annotation, error)
+	Delete(r *http.Request) error
+}
+
+type ReviewEndpoint interface {
+	List(r *http.Request) ([]models.UsersReviewQueue, error)
+	Claim(r *http.Request) (*models.UsersReviewQueue, error)
+	Resolve(r *http.Request) (*models.UsersReviewQueue, error)
+	Stats(r *http.Request) (*storage.ReviewQueueStats, error)
+}
+
+type SchemaEndpoint interface {
+	Get(r *http.Request) (*global.SchemaIntrospection, error)
+}
+
+type SavedSearchesEndpoint interface {
+	Create(r *http.Request) (*models.UsersSavedSearch, error)
+	List(r *http.Request) ([]models.UsersSavedSearch, error)
+	Get(r *http.Request) (*models.UsersSavedSearch, error)
+	Update(r *http.Request) (*models.UsersSavedSearch, error)
+	Delete(r *http.Request) error
+	Hits(r *http.Request) ([]models.ListSavedSearchHitsRow, error)
+}
+
+type StatsEndpoint interface {
+	Summary(r *http.Request) (*StatsSummary, error)
+	Dashboard(r *http.Request) (*StatsDashboard, error)
+}
+
+type KeywordsEndpoint interface {
+	Anomalies(r *http.Request) ([]models.ListKeywordAnomaliesSinceRow, error)
+}
+
+type SourceWeightsEndpoint interface {
+	List(r *http.Request) ([]models.SourceWeight, error)
+	Set(r *http.Request) (*models.SourceWeight, error)
+}
+
+type ReconciliationEndpoint interface {
+	Reports(r *http.Request) ([]storage.ReconciliationReport, error)
+}
+
+type VectorIndexesEndpoint interface {
+	Inspect(r *http.Request) ([]storage.VectorIndexStats, error)
+	Reindex(r *http.Request) (*apischema.VectorIndexJob, error)
+	Job(r *http.Request) (*apischema.VectorIndexJob, error)
+}
@@ -1,10 +1,15 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
 	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
 	"github.com/ChiaYuChang/weathercock/pkgs/errors"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
@@ -13,15 +18,154 @@ import (
 type PublicArticles struct {
 	*Repo
 	*validator.Validate
+	scoring storage.ArticleScoring
 }
 
-func (r *Repo) PublicArticles(validator *validator.Validate) *PublicArticles {
+// PublicArticles converts Repo to a PublicArticlesEndpoint, the top ordering
+// defaults to scoring.
+func (r *Repo) PublicArticles(validator *validator.Validate, scoring storage.ArticleScoring) *PublicArticles {
 	return &PublicArticles{
 		Repo:     r,
 		Validate: validator,
+		scoring:  scoring,
 	}
 }
 
+const (
+	DefaultArticlesPageSize = apischema.DefaultArticlesPageSize
+	MaxArticlesPageSize     = apischema.MaxArticlesPageSize
+	// MaxScoringTauHours is the largest recency decay of the top ordering, a
+	// year.
+	MaxScoringTauHours = 365 * 24
+)
+
+// Orderings of the article list.
+const (
+	ArticlesSortRecent = apischema.ArticlesSortRecent
+	ArticlesSortTop    = apischema.ArticlesSortTop
+)
+
+// ArticleListItem is an article of the article list. Score is only set in the
+// top ordering.
+type ArticleListItem = apischema.ArticleListItem
+
+// List returns the public articles along with the liveness of their URLs. The
+// query parameters are:
+//   - dead: if set, only the articles whose URL is (or is not) dead
+//   - q: if set, only the articles with one of its terms in the title or the
+//     content, see storage.SearchTerms
+//   - sort: recent (default), newest first, or top, by descending score, see
+//     storage.ArticleScoring
+//   - tau_hours, w_recency, w_source, w_relevance: the recency decay and the
+//     weights of the top ordering, the configured ones by default
+//   - limit: the number of articles, at most MaxArticlesPageSize
+//   - offset: the number of articles to skip. In the top ordering, offset plus
+//     limit is at most storage.MaxTopArticlesDepth
+func (a *PublicArticles) List(r *http.Request) ([]ArticleListItem, error) {
+	var dead *bool
+	if v := r.URL.Query().Get("dead"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.ErrBadRequest.Clone().
+				WithDetails(fmt.Sprintf("invalid dead: %q", v)).
+				Warp(err)
+		}
+		dead = &b
+	}
+	terms := storage.SearchTerms(r.URL.Query().Get("q"))
+
+	limit, err := queryInt(r, "limit", DefaultArticlesPageSize, 1, MaxArticlesPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	sort := r.URL.Query().Get("sort")
+	switch sort {
+	case "", ArticlesSortRecent:
+		offset, err := queryInt(r, "offset", 0, 0, 1<<31-1)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		rows, err := a.Storage.URLStatus().List(ctx, dead, terms, int32(limit), int32(offset))
+		if err != nil {
+			return nil, err
+		}
+
+		items := make([]ArticleListItem, len(rows))
+		for i, row := range rows {
+			items[i] = ArticleListItem{ListArticlesWithURLStatusRow: row}
+		}
+		return items, nil
+	case ArticlesSortTop:
+		offset, err := queryInt(r, "offset", 0, 0, storage.MaxTopArticlesDepth-limit)
+		if err != nil {
+			return nil, err
+		}
+
+		scoring, err := a.scoringOf(r)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		rows, err := a.Storage.URLStatus().Top(ctx, dead, terms, scoring, time.Now(),
+			int32(limit), int32(offset))
+		if err != nil {
+			return nil, err
+		}
+
+		items := make([]ArticleListItem, len(rows))
+		for i, row := range rows {
+			items[i] = ArticleListItem{
+				ListArticlesWithURLStatusRow: models.ListArticlesWithURLStatusRow{
+					ID:             row.ID,
+					Title:          row.Title,
+					Url:            row.Url,
+					Source:         row.Source,
+					Party:          row.Party,
+					PublishedAt:    row.PublishedAt,
+					LastCheckedAt:  row.LastCheckedAt,
+					LastStatusCode: row.LastStatusCode,
+					Dead:           row.Dead,
+				},
+				Score: &row.Score,
+			}
+		}
+		return items, nil
+	default:
+		return nil, errors.ErrBadRequest.Clone().
+			WithDetails(fmt.Sprintf("invalid sort, expected %s or %s: %q",
+				ArticlesSortRecent, ArticlesSortTop, sort))
+	}
+}
+
+// scoringOf returns the configured scoring overridden by the query parameters
+// of r.
+func (a *PublicArticles) scoringOf(r *http.Request) (storage.ArticleScoring, error) {
+	scoring := a.scoring
+	var err error
+	if scoring.TauHours, err = queryFloat(r, "tau_hours", scoring.TauHours, 1, MaxScoringTauHours); err != nil {
+		return scoring, err
+	}
+
+	if scoring.Recency, err = queryFloat(r, "w_recency", scoring.Recency, 0, 1); err != nil {
+		return scoring, err
+	}
+
+	if scoring.Source, err = queryFloat(r, "w_source", scoring.Source, 0, 1); err != nil {
+		return scoring, err
+	}
+
+	if scoring.Relevance, err = queryFloat(r, "w_relevance", scoring.Relevance, 0, 1); err != nil {
+		return scoring, err
+	}
+	return scoring, nil
+}
+
 type UserArticles struct {
 	*Repo
 	*validator.Validate
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+)
+
+// Keywords provides the keyword trends.
+type Keywords struct {
+	*Repo
+}
+
+// Keywords converts Repo to a KeywordsEndpoint.
+func (r *Repo) Keywords() KeywordsEndpoint {
+	return Keywords{Repo: r}
+}
+
+const (
+	DefaultAnomaliesSinceDays = 7
+	DefaultAnomaliesPageSize  = 50
+	MaxAnomaliesPageSize      = 500
+)
+
+// Anomalies returns the keywords flagged as spiking, the latest day and the
+// highest z-score first. The query parameters are:
+//   - since: the first day, as YYYY-MM-DD, DefaultAnomaliesSinceDays days ago
+//     by default
+//   - limit: the number of anomalies, at most MaxAnomaliesPageSize
+func (k Keywords) Anomalies(r *http.Request) ([]models.ListKeywordAnomaliesSinceRow, error) {
+	since := time.Now().In(scrapers.DefaultTimeZone).AddDate(0, 0, -DefaultAnomaliesSinceDays)
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.ParseInLocation(time.DateOnly, v, scrapers.DefaultTimeZone)
+		if err != nil {
+			return nil, errors.ErrBadRequest.Clone().
+				WithDetails(fmt.Sprintf("invalid since, expected YYYY-MM-DD: %q", v)).
+				Warp(err)
+		}
+		since = t
+	}
+
+	limit, err := queryInt(r, "limit", DefaultAnomaliesPageSize, 1, MaxAnomaliesPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	return k.Storage.KeywordAnomalies().Since(ctx, since, int32(limit))
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+)
+
+// Reconciliation provides the reports of the reconciliation of the cache with
+// the database. It requires a valid editor token.
+type Reconciliation struct {
+	*Repo
+	editorToken string
+}
+
+// Reconciliation converts Repo to a ReconciliationEndpoint guarded by the
+// given editor token.
+func (r *Repo) Reconciliation(editorToken string) ReconciliationEndpoint {
+	return Reconciliation{Repo: r, editorToken: editorToken}
+}
+
+const MaxReconciliationReports = 100
+
+// Reports returns the latest reconciliation passes, newest first, with the
+// number of divergences by class. The query parameters are:
+//   - limit: the number of reports, storage.DefaultReconciliationReports by
+//     default and at most MaxReconciliationReports
+func (v Reconciliation) Reports(r *http.Request) ([]storage.ReconciliationReport, error) {
+	if err := authorizeEditor(r, v.editorToken, "reconciliation"); err != nil {
+		return nil, err
+	}
+
+	limit, err := queryInt(r, "limit", storage.DefaultReconciliationReports, 1, MaxReconciliationReports)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	return v.Storage.Reconciliation().Reports(ctx, int32(limit))
+}
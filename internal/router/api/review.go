@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	DefaultReviewPageSize = 20
+	MaxReviewPageSize     = 100
+)
+
+// Review provides methods for the editors to work through the review queue.
+// All methods require a valid editor token.
+type Review struct {
+	*Repo
+	*validator.Validate
+	editorToken string
+}
+
+// Review converts Repo to a ReviewEndpoint guarded by the given editor token.
+// An empty token disables the endpoint.
+func (r *Repo) Review(validator *validator.Validate, editorToken string) ReviewEndpoint {
+	return Review{
+		Repo:        r,
+		Validate:    validator,
+		editorToken: editorToken,
+	}
+}
+
+// ReviewClaimRequest is the request body to claim a review item. A zero lease
+// is the storage.DefaultReviewLease.
+type ReviewClaimRequest struct {
+	Editor       string `json:"editor"        validate:"required,max=64"`
+	LeaseSeconds int    `json:"lease_seconds" validate:"min=0,max=7200"`
+}
+
+// ReviewResolveRequest is the request body to resolve a claimed review item.
+type ReviewResolveRequest struct {
+	Editor string               `json:"editor" validate:"required,max=64"`
+	Action storage.ReviewAction `json:"action" validate:"required,oneof=approve dismiss"`
+}
+
+// List lists the review items, oldest first. The items can be filtered by
+// the type and status query parameters, the status defaults to open.
+func (v Review) List(r *http.Request) ([]models.UsersReviewQueue, error) {
+	if err := authorizeEditor(r, v.editorToken, "review"); err != nil {
+		return nil, err
+	}
+
+	status := models.ReviewStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = models.ReviewStatusOpen
+	}
+	itemType := models.ReviewItemType(r.URL.Query().Get("type"))
+
+	limit, err := queryInt(r, "limit", DefaultReviewPageSize, 1, MaxReviewPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := queryInt(r, "offset", 0, 0, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	return v.Storage.ReviewQueue().List(ctx, status, itemType, int32(limit), int32(offset))
+}
+
+func (v Review) Claim(r *http.Request) (*models.UsersReviewQueue, error) {
+	if err := authorizeEditor(r, v.editorToken, "review"); err != nil {
+		return nil, err
+	}
+
+	id, err := reviewID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var req ReviewClaimRequest
+	if err := v.decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	lease := storage.DefaultReviewLease
+	if req.LeaseSeconds > 0 {
+		lease = time.Duration(req.LeaseSeconds) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	item, err := v.Storage.ReviewQueue().Claim(ctx, id, req.Editor, time.Now(), lease)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (v Review) Resolve(r *http.Request) (*models.UsersReviewQueue, error) {
+	if err := authorizeEditor(r, v.editorToken, "review"); err != nil {
+		return nil, err
+	}
+
+	id, err := reviewID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var req ReviewResolveRequest
+	if err := v.decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	item, err := v.Storage.ReviewQueue().Resolve(ctx, id, req.Editor, req.Action,
+		time.Now(), reviewDomain{v.Repo})
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// Stats returns the depth of the review queue and the median time to
+// resolution over the storage.DefaultReviewMetricsWindow, by item type.
+func (v Review) Stats(r *http.Request) (*storage.ReviewQueueStats, error) {
+	if err := authorizeEditor(r, v.editorToken, "review"); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	stats, err := v.Storage.ReviewQueue().Stats(ctx, time.Now(), storage.DefaultReviewMetricsWindow)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (v Review) decode(r *http.Request, req any) error {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return errors.ErrBadRequest.Clone().
+			WithDetails("failed to decode review request body").
+			Warp(err)
+	}
+
+	vCtx, vCancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer vCancel()
+	if err := v.Validate.StructCtx(vCtx, req); err != nil {
+		return errors.ErrValidationFailed.Clone().
+			WithDetails(err.Error()).
+			Warp(err)
+	}
+	return nil
+}
+
+func reviewID(r *http.Request) (int32, error) {
+	id, err := strconv.ParseInt(r.PathValue("review_id"), 10, 32)
+	if err != nil || id <= 0 {
+		return 0, errors.ErrBadRequest.Clone().
+			WithDetails(fmt.Sprintf("invalid review_id format: %q", r.PathValue("review_id"))).
+			Warp(err)
+	}
+	return int32(id), nil
+}
+
+// reviewDomain carries out the domain operations of the review resolutions
+// on the user articles.
+type reviewDomain struct {
+	*Repo
+}
+
+func (d reviewDomain) ApproveKeywordOutput(ctx context.Context, articleID int32) error {
+	return d.Storage.UserArticles().SetNeedsReview(ctx, articleID, false)
+}
+
+// Rescrape publishes a scrape command for the URL of the task the article was
+// scraped for, which replaces the article with the one scraped again.
+func (d reviewDomain) Rescrape(ctx context.Context, articleID int32) error {
+	article, err := d.Storage.UserArticles().GetByID(ctx, articleID)
+	if err != nil {
+		return err
+	}
+
+	task, err := d.Storage.Task().Get(ctx, article.TaskID)
+	if err != nil {
+		return err
+	}
+
+	if task.Source != models.SourceTypeUrl {
+		return errors.ErrValidationFailed.Clone().
+			WithMessage("article cannot be rescraped").
+			WithDetails(fmt.Sprintf("article %d was not scraped from a URL", articleID))
+	}
+
+	err = d.Publisher.PublishNATSMessage(ctx, workers.SubjectCmd(workers.StageScrape), workers.CmdScrapeArticle{
+		BaseMessage: workers.BaseMessage{TaskID: task.TaskID},
+		URL:         task.OriginalInput,
+		Replace:     articleID,
+	})
+	if err != nil {
+		return errors.ErrNATSMsgPublishFailed.Clone().
+			WithDetails("failed to publish rescrape task").
+			Warp(err)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/go-playground/validator/v10"
+)
+
+// OwnerHeader is the header identifying the owner of the saved searches.
+const OwnerHeader = "X-Owner-ID"
+
+const (
+	DefaultSavedSearchHitsPageSize = 20
+	MaxSavedSearchHitsPageSize     = 100
+)
+
+// SavedSearches provides methods to manage the saved searches of an owner.
+// All methods require a valid editor token.
+type SavedSearches struct {
+	*Repo
+	*validator.Validate
+	editorToken string
+}
+
+// SavedSearches converts Repo to a SavedSearchesEndpoint guarded by the given
+// editor token. An empty token disables the endpoint.
+func (r *Repo) SavedSearches(validator *validator.Validate, editorToken string) SavedSearchesEndpoint {
+	return SavedSearches{
+		Repo:        r,
+		Validate:    validator,
+		editorToken: editorToken,
+	}
+}
+
+// SavedSearchRequest is the request body to create or update a saved search.
+// The filter is an ArticleFilter of any version.
+type SavedSearchRequest struct {
+	Name       string                     `json:"name"        validate:"required,max=64"`
+	Filter     json.RawMessage            `json:"filter"      validate:"required"`
+	Schedule   models.SavedSearchSchedule `json:"schedule"    validate:"required,oneof=daily weekly"`
+	Delivery   models.SavedSearchDelivery `json:"delivery"    validate:"omitempty,oneof=none webhook"`
+	WebhookURL string                     `json:"webhook_url" validate:"required_if=Delivery webhook,omitempty,url,max=2048"`
+}
+
+func (a SavedSearches) Create(r *http.Request) (*models.UsersSavedSearch, error) {
+	ownerID, err := a.owner(r)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := a.decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	search, err := a.Storage.SavedSearches().Create(ctx, ownerID, in)
+	if err != nil {
+		return nil, err
+	}
+	return &search, nil
+}
+
+func (a SavedSearches) List(r *http.Request) ([]models.UsersSavedSearch, error) {
+	ownerID, err := a.owner(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	return a.Storage.SavedSearches().List(ctx, ownerID)
+}
+
+func (a SavedSearches) Get(r *http.Request) (*models.UsersSavedSearch, error) {
+	ownerID, err := a.owner(r)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := savedSearchID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	search, err := a.Storage.SavedSearches().Get(ctx, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	return &search, nil
+}
+
+func (a SavedSearches) Update(r *http.Request) (*models.UsersSavedSearch, error) {
+	ownerID, err := a.owner(r)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := savedSearchID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := a.decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	search, err := a.Storage.SavedSearches().Update(ctx, ownerID, id, in)
+	if err != nil {
+		return nil, err
+	}
+	return &search, nil
+}
+
+func (a SavedSearches) Delete(r *http.Request) error {
+	ownerID, err := a.owner(r)
+	if err != nil {
+		return err
+	}
+
+	id, err := savedSearchID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	return a.Storage.SavedSearches().Delete(ctx, ownerID, id)
+}
+
+// Hits returns the articles matched by a saved search, the latest run first.
+// The query parameters are:
+//   - limit: the number of articles, at most MaxSavedSearchHitsPageSize
+//   - offset: the number of articles to skip
+func (a SavedSearches) Hits(r *http.Request) ([]models.ListSavedSearchHitsRow, error) {
+	ownerID, err := a.owner(r)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := savedSearchID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := queryInt(r, "limit", DefaultSavedSearchHitsPageSize, 1, MaxSavedSearchHitsPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := queryInt(r, "offset", 0, 0, 1<<31-1)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	return a.Storage.SavedSearches().Hits(ctx, ownerID, id, int32(limit), int32(offset))
+}
+
+// decode decodes and validates the saved search in the request body, upgrading
+// its filter to the current version.
+func (a SavedSearches) decode(r *http.Request) (storage.SavedSearchInput, error) {
+	var req SavedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return storage.SavedSearchInput{}, errors.ErrBadRequest.Clone().
+			WithDetails("failed to decode saved search request body").
+			Warp(err)
+	}
+
+	vCtx, vCancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer vCancel()
+	if err := a.Validate.StructCtx(vCtx, req); err != nil {
+		return storage.SavedSearchInput{}, errors.ErrValidationFailed.Clone().
+			WithDetails(err.Error()).
+			Warp(err)
+	}
+
+	filter, err := storage.ParseArticleFilter(req.Filter)
+	if err != nil {
+		return storage.SavedSearchInput{}, err
+	}
+
+	if err := a.Validate.StructCtx(vCtx, filter); err != nil {
+		return storage.SavedSearchInput{}, errors.ErrValidationFailed.Clone().
+			WithDetails(err.Error()).
+			Warp(err)
+	}
+
+	if req.Delivery == "" {
+		req.Delivery = models.SavedSearchDeliveryNone
+	}
+
+	return storage.SavedSearchInput{
+		Name:       req.Name,
+		Filter:     filter,
+		Schedule:   req.Schedule,
+		Delivery:   req.Delivery,
+		WebhookURL: req.WebhookURL,
+	}, nil
+}
+
+// owner checks the editor token of the request and returns its owner. There
+// are no user accounts yet, the owner is whatever the editor puts in
+// OwnerHeader.
+func (a SavedSearches) owner(r *http.Request) (string, error) {
+	if err := authorizeEditor(r, a.editorToken, "saved search"); err != nil {
+		return "", err
+	}
+
+	ownerID := r.Header.Get(OwnerHeader)
+	if ownerID == "" {
+		return "", errors.ErrUnauthorized.Clone().
+			WithDetails(fmt.Sprintf("missing %s header", OwnerHeader))
+	}
+
+	if len(ownerID) > 128 {
+		return "", errors.ErrBadRequest.Clone().
+			WithDetails(fmt.Sprintf("%s header should be at most 128 bytes", OwnerHeader))
+	}
+	return ownerID, nil
+}
+
+func savedSearchID(r *http.Request) (int32, error) {
+	id, err := strconv.ParseInt(r.PathValue("saved_search_id"), 10, 32)
+	if err != nil || id <= 0 {
+		return 0, errors.ErrBadRequest.Clone().
+			WithDetails(fmt.Sprintf("invalid saved_search_id format: %q", r.PathValue("saved_search_id"))).
+			Warp(err)
+	}
+	return int32(id), nil
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+)
+
+// Schema provides the introspection of the database schema to check that the
+// database and the binary are compatible. It requires a valid editor token.
+type Schema struct {
+	*Repo
+	editorToken string
+}
+
+// Schema converts Repo to a SchemaEndpoint guarded by the given editor token.
+func (r *Repo) Schema(editorToken string) SchemaEndpoint {
+	return Schema{Repo: r, editorToken: editorToken}
+}
+
+func (v Schema) Get(r *http.Request) (*global.SchemaIntrospection, error) {
+	if err := authorizeEditor(r, v.editorToken, "schema"); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	schema, err := v.Storage.Schema().Introspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
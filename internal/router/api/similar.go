@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/google/uuid"
+)
+
+// Similar returns the articles similar to the articles of a task. The query
+// parameters are:
+//   - model_id (required): the embedding model to search with
+//   - k: the number of articles, at most storage.MaxSimilarK
+//   - explain: if true, each article carries its top-m contributing chunks
+//   - m: the number of chunks per article, at most storage.MaxExplainTopM
+//   - snippet_runes: the rune budget of each snippet, at most storage.MaxSnippetRunes
+func (t UserTasks) Similar(r *http.Request) ([]storage.SimilarArticle, error) {
+	taskID, err := uuid.Parse(r.PathValue("task_id"))
+	if err != nil {
+		return nil, errors.ErrBadRequest.Clone().
+			WithDetails("invalid task_id format").
+			Warp(err)
+	}
+
+	modelID, err := queryInt(r, "model_id", 0, 1, 1<<31-1)
+	if err != nil {
+		return nil, err
+	}
+	if modelID == 0 {
+		return nil, errors.ErrBadRequest.Clone().
+			WithDetails("missing query parameter: model_id")
+	}
+
+	k, err := queryInt(r, "k", storage.DefaultSimilarK, 1, storage.MaxSimilarK)
+	if err != nil {
+		return nil, err
+	}
+
+	explain := storage.ExplainOptions{}
+	if v := r.URL.Query().Get("explain"); v != "" {
+		explain.Enabled, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.ErrBadRequest.Clone().
+				WithDetails(fmt.Sprintf("invalid explain: %q", v)).
+				Warp(err)
+		}
+	}
+
+	if explain.TopM, err = queryInt(r, "m", storage.DefaultExplainTopM,
+		1, storage.MaxExplainTopM); err != nil {
+		return nil, err
+	}
+
+	if explain.SnippetRunes, err = queryInt(r, "snippet_runes", storage.DefaultSnippetRunes,
+		1, storage.MaxSnippetRunes); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	return t.Storage.Similarity().SimilarArticles(ctx, taskID, int32(modelID), k, explain)
+}
+
+// queryInt parses an integer query parameter within [lo, hi], it returns def
+// if the parameter is absent.
+func queryInt(r *http.Request, name string, def, lo, hi int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.ErrBadRequest.Clone().
+			WithDetails(fmt.Sprintf("invalid %s: %q", name, v)).
+			Warp(err)
+	}
+
+	if n < lo || n > hi {
+		return 0, errors.ErrBadRequest.Clone().
+			WithDetails(fmt.Sprintf("%s should be in [%d, %d], got: %d", name, lo, hi, n))
+	}
+	return n, nil
+}
+
+// queryFloat parses a float query parameter within [lo, hi], it returns def if
+// the parameter is absent.
+func queryFloat(r *http.Request, name string, def, lo, hi float64) (float64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, errors.ErrBadRequest.Clone().
+			WithDetails(fmt.Sprintf("invalid %s: %q", name, v)).
+			Warp(err)
+	}
+
+	if math.IsNaN(f) || f < lo || f > hi {
+		return 0, errors.ErrBadRequest.Clone().
+			WithDetails(fmt.Sprintf("%s should be in [%v, %v], got: %v", name, lo, hi, f))
+	}
+	return f, nil
+}
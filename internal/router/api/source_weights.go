@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/go-playground/validator/v10"
+)
+
+// SourceWeights provides methods for the editors to manage the authority
+// weights of the sources. All methods require a valid editor token.
+type SourceWeights struct {
+	*Repo
+	*validator.Validate
+	editorToken string
+}
+
+// SourceWeights converts Repo to a SourceWeightsEndpoint guarded by the given
+// editor token. An empty token disables the endpoint.
+func (r *Repo) SourceWeights(validator *validator.Validate, editorToken string) SourceWeightsEndpoint {
+	return SourceWeights{
+		Repo:        r,
+		Validate:    validator,
+		editorToken: editorToken,
+	}
+}
+
+// SourceWeightRequest is the request body to set the weight of a source.
+type SourceWeightRequest = apischema.SourceWeightRequest
+
+// List returns the weights of the sources, by source.
+func (s SourceWeights) List(r *http.Request) ([]models.SourceWeight, error) {
+	if err := authorizeEditor(r, s.editorToken, "source weight"); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	return s.Storage.SourceWeights().List(ctx)
+}
+
+// Set sets the weight of the source of the path. The top articles use it from
+// the next request on.
+func (s SourceWeights) Set(r *http.Request) (*models.SourceWeight, error) {
+	if err := authorizeEditor(r, s.editorToken, "source weight"); err != nil {
+		return nil, err
+	}
+
+	var req SourceWeightRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errors.ErrBadRequest.Clone().
+			WithDetails("failed to decode source weight request body").
+			Warp(err)
+	}
+
+	vCtx, vCancel := context.WithTimeout(r.Context(), 1*time.Second)
+	defer vCancel()
+	if err := s.Validate.StructCtx(vCtx, req); err != nil {
+		return nil, errors.ErrValidationFailed.Clone().
+			WithDetails(err.Error()).
+			Warp(err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	sw, err := s.Storage.SourceWeights().Set(ctx, r.PathValue("source"), *req.Weight, req.Editor)
+	if err != nil {
+		return nil, err
+	}
+	return &sw, nil
+}
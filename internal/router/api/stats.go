@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+)
+
+const (
+	StatsSummaryCacheKey = "stats.summary"
+	StatsSummaryCacheTTL = 30 * time.Second
+)
+
+// Stats provides the headline numbers of the dashboard.
+type Stats struct {
+	*Repo
+}
+
+// Stats converts Repo to a StatsEndpoint.
+func (r *Repo) Stats() StatsEndpoint {
+	return Stats{Repo: r}
+}
+
+// CounterValue is a counter value and the time it was last updated.
+type CounterValue struct {
+	Value     int64     `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StatsSummary is the response of the stats summary endpoint.
+type StatsSummary struct {
+	Counters    map[string]CounterValue `json:"counters"`
+	GeneratedAt time.Time               `json:"generated_at"`
+}
+
+func newStatsSummary(counters []models.Counter, generatedAt time.Time) *StatsSummary {
+	summary := &StatsSummary{
+		Counters:    make(map[string]CounterValue, len(counters)),
+		GeneratedAt: generatedAt,
+	}
+	for _, c := range counters {
+		summary.Counters[c.Name] = CounterValue{
+			Value:     c.Value,
+			UpdatedAt: c.UpdatedAt.Time,
+		}
+	}
+	return summary
+}
+
+// Summary returns the pre-aggregated counters. The result is cached for
+// StatsSummaryCacheTTL if a cache is configured.
+func (s Stats) Summary(r *http.Request) (*StatsSummary, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if s.Storage.Cache != nil {
+		if data, err := s.Storage.Cache.Get(ctx, StatsSummaryCacheKey).Bytes(); err == nil {
+			var summary StatsSummary
+			if err := json.Unmarshal(data, &summary); err == nil {
+				return &summary, nil
+			}
+		}
+	}
+
+	counters, err := s.Storage.Counters().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := newStatsSummary(counters, time.Now())
+	if s.Storage.Cache != nil {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			return nil, errors.ErrInternalServerError.Clone().
+				WithDetails("failed to marshal stats summary").
+				Warp(err)
+		}
+
+		if err := s.Storage.Cache.Set(ctx, StatsSummaryCacheKey, data, StatsSummaryCacheTTL).Err(); err != nil {
+			s.Logger.Warn().
+				Err(err).
+				Str("key", StatsSummaryCacheKey).
+				Msg("Failed to cache stats summary")
+		}
+	}
+	return summary, nil
+}
+
+// StatsDashboard is the response of the stats dashboard endpoint, all its
+// numbers are read from one snapshot of the database.
+type StatsDashboard struct {
+	Summary *StatsSummary
+	Parties []models.CountArticlesByPartyRow
+	Trend   []models.CountArticlesPerDayRow
+	// TrendSince is the start of the first day of the trend.
+	TrendSince time.Time
+}
+
+// Dashboard returns the counters, the articles of each party and their trend
+// over the last storage.DashboardTrendDays days. Unlike Summary it is never
+// cached, the widgets are to add up with each other.
+func (s Stats) Dashboard(r *http.Request) (*StatsDashboard, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	d, err := s.Storage.Stats().Dashboard(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsDashboard{
+		Summary:    newStatsSummary(d.Counters, now),
+		Parties:    d.Parties,
+		Trend:      d.Trend,
+		TrendSince: d.TrendSince,
+	}, nil
+}
@@ -2,15 +2,16 @@ package api
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strings"
 	"time"
 
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
 	"github.com/ChiaYuChang/weathercock/internal/llm"
 	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
 	"github.com/ChiaYuChang/weathercock/internal/workers"
 	"github.com/ChiaYuChang/weathercock/pkgs/errors"
 	"github.com/go-playground/validator/v10"
@@ -33,7 +34,9 @@ func (r *Repo) UserTask(validator *validator.Validate) TaskEndpoint {
 	}
 }
 
-func (t UserTasks) InsertFromText(r *http.Request) (taskID uuid.UUID, err error) {
+// InsertFromURL creates a task from the news URL of the query_url form field,
+// rejecting the URL of a site scrapers.DefaultRegistry has no parser for.
+func (t UserTasks) InsertFromURL(r *http.Request) (taskID uuid.UUID, err error) {
 	if err = r.ParseForm(); err != nil {
 		e := errors.ErrBadRequest.Clone()
 		e.Details = append(e.Details, "failed to parse form data")
@@ -41,7 +44,7 @@ func (t UserTasks) InsertFromText(r *http.Request) (taskID uuid.UUID, err error)
 		return uuid.Nil, e
 	}
 
-	qURL := r.Form["query_url"][0]
+	qURL := r.Form.Get(apischema.FormQueryURL)
 	vCtx, vCancel := context.WithTimeout(r.Context(), 1*time.Second)
 	defer vCancel()
 	err = t.Validate.VarCtx(vCtx, qURL, "url,required")
@@ -52,24 +55,20 @@ func (t UserTasks) InsertFromText(r *http.Request) (taskID uuid.UUID, err error)
 		return uuid.Nil, e
 	}
 
-	if u, err := url.Parse(qURL); err != nil || u.Hostname() != "tw.news.yahoo.com" {
+	if _, err := scrapers.DefaultRegistry.Lookup(qURL); err != nil {
 		e := errors.ErrBadRequest.Clone()
 		e.Details = append(e.Details, "only support Yahoo news URL (tw.news.yahoo.com)")
+		e.Warp(err)
 		return uuid.Nil, e
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 	taskID, err = t.Storage.Task().InsertFromURL(ctx, qURL, func(ctx context.Context, taskID uuid.UUID) error {
-		payload, err := json.Marshal(workers.CmdScrapeArticle{
+		err := t.Publisher.PublishNATSMessage(ctx, workers.SubjectCmd(workers.StageScrape), workers.CmdScrapeArticle{
 			BaseMessage: workers.BaseMessage{TaskID: taskID},
 			URL:         qURL,
 		})
-		if err != nil {
-			return fmt.Errorf("failed to marshal scrape task payload: %w", err)
-		}
-
-		err = t.Publisher.PublishNATSMessage(ctx, workers.TaskScrape, payload)
 		if err != nil {
 			return fmt.Errorf("failed to publish scrape task: %w", err)
 		}
@@ -86,7 +85,9 @@ func (t UserTasks) InsertFromText(r *http.Request) (taskID uuid.UUID, err error)
 	return taskID, nil
 }
 
-func (t UserTasks) InsertFromURL(r *http.Request) (taskID uuid.UUID, err error) {
+// InsertFromText creates a task from the query_text form field. A first line
+// starting with # is taken as the title, otherwise the title is generated.
+func (t UserTasks) InsertFromText(r *http.Request) (taskID uuid.UUID, err error) {
 	if err = r.ParseForm(); err != nil {
 		e := errors.ErrBadRequest.Clone()
 		e.Details = append(e.Details, "failed to parse form data")
@@ -94,7 +95,7 @@ func (t UserTasks) InsertFromURL(r *http.Request) (taskID uuid.UUID, err error)
 		return uuid.Nil, e
 	}
 
-	rawText := r.Form["query_text"][0]
+	rawText := r.Form.Get(apischema.FormQueryText)
 	text := strings.TrimSpace(string(rawText))
 	if len(text) == 0 {
 		e := errors.ErrNoContent.Clone().
@@ -103,9 +104,11 @@ func (t UserTasks) InsertFromURL(r *http.Request) (taskID uuid.UUID, err error)
 		return uuid.Nil, e
 	}
 
-	if found, p := llm.DetectLLMInjection(text); found {
-		e := errors.ErrContentContainsMaliciousPrompt.Clone().
-			WithDetails(fmt.Sprintf("potential malicious prompt: %s", p))
+	if found := llm.FindLLMInjections(text); len(found) > 0 {
+		e := errors.ErrContentContainsMaliciousPrompt.Clone()
+		for _, f := range found {
+			e.WithDetails(fmt.Sprintf("potential malicious prompt: %q (pattern: %s)", f.Match, f.Pattern))
+		}
 		return uuid.Nil, e
 	}
 
@@ -137,7 +140,7 @@ func (t UserTasks) InsertFromURL(r *http.Request) (taskID uuid.UUID, err error)
 	taskID, err = t.Storage.Task().InsertFromText(ctx, text, func(ctx context.Context, taskID uuid.UUID) error {
 		if len(title) == 0 {
 			err = t.Publisher.PublishNATSMessage(ctx,
-				workers.TaskGenerateTitle,
+				workers.SubjectCmd(workers.StageGenerateTitle),
 				workers.CmdGenerateTitle{
 					BaseMessage: workers.BaseMessage{
 						TaskID: taskID,
@@ -151,7 +154,7 @@ func (t UserTasks) InsertFromURL(r *http.Request) (taskID uuid.UUID, err error)
 
 		pipe := t.Storage.Cache.Pipeline()
 		const ttl = 60 * time.Minute
-		pipe.Set(ctx, fmt.Sprintf("task.%s.title", taskID.String()), title, ttl)
+		pipe.Set(ctx, workers.TitleCacheKey(taskID), title, ttl)
 		pipe.Set(ctx, fmt.Sprintf("task.%s.contents", taskID.String()), contents, ttl)
 		if _, err := pipe.Exec(ctx); err != nil {
 			return fmt.Errorf("failed to execute cache pipeline: %w", err)
@@ -179,59 +182,29 @@ func (t UserTasks) Get(r *http.Request) (*models.UsersTask, error) {
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	task, err := t.Storage.Queries.GetUserTask(ctx, taskID)
+	// The task is polled right after it is created, read it from the write
+	// pool so that a lagging replica never reports it missing.
+	task, err := t.Storage.Task().Get(storage.WithFreshReads(ctx), taskID)
 	if err != nil {
-		pge, ok := errors.NewPGErr(err)
-		var e *errors.Error
-		if ok {
-			e = errors.FromPgError(pge)
-		} else {
-			e = errors.ErrDBError.Clone().
-				Warp(err).
-				WithDetails("failed to get task")
-		}
-		return nil, e
+		return nil, err
 	}
 	return &task, nil
 }
 
-func (t UserTasks) UpdateStatus(r *http.Request) error {
-	taskID, err := uuid.Parse(r.PathValue("task_id"))
+// Timeline returns the timeline of a task. Once the events of the task have
+// been compacted, the timeline is reconstructed from the snapshot of its state
+// and flagged as summarized.
+func (t UserTasks) Timeline(r *http.Request) (*storage.TaskTimeline, error) {
+	task, err := t.Get(r)
 	if err != nil {
-		e := errors.ErrBadRequest.Clone().
-			WithDetails("invalid task_id format").
-			Warp(err)
-		return e
-	}
-
-	status := models.TaskStatus(r.URL.Query().Get("status"))
-	if !status.Valid() {
-		e := errors.ErrBadRequest.Clone()
-		if status == "" {
-			e.WithDetails("missing status query parameter: status")
-			return e
-		}
-		e.WithDetails(fmt.Sprintf("invalid status: %s", status))
-		return e
+		return nil, err
 	}
 
-	err = t.Repo.Storage.Queries.UpdateUserTaskStatus(
-		context.Background(), models.UpdateUserTaskStatusParams{
-			TaskStatus: status,
-			TaskID:     taskID,
-		})
-
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	timeline, err := t.Storage.TaskEvents().Timeline(ctx, task.TaskID)
 	if err != nil {
-		pge, ok := errors.NewPGErr(err)
-		var e *errors.Error
-		if ok {
-			e = errors.FromPgError(pge)
-		} else {
-			e = errors.ErrDBError.Clone().
-				WithDetails("failed to get task").
-				Warp(err)
-		}
-		return e
+		return nil, err
 	}
-	return nil
+	return &timeline, nil
 }
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/google/uuid"
+)
+
+// MaxVectorIndexJobs is the number of finished rebuilds whose status is kept
+// to be polled.
+const MaxVectorIndexJobs = 20
+
+// VectorIndexes provides the inspection and the rebuild of the vector indexes.
+// A rebuild runs in the background, its status is polled by its job ID. It
+// requires a valid editor token.
+type VectorIndexes struct {
+	*Repo
+	editorToken string
+	cfg         global.VectorIndexConfig
+	jobs        *vectorIndexJobs
+}
+
+// VectorIndexes converts Repo to a VectorIndexesEndpoint guarded by the given
+// editor token, sampling the recall and guarding the rebuilds as cfg says.
+func (r *Repo) VectorIndexes(editorToken string, cfg global.VectorIndexConfig) VectorIndexesEndpoint {
+	return VectorIndexes{
+		Repo:        r,
+		editorToken: editorToken,
+		cfg:         cfg,
+		jobs:        &vectorIndexJobs{jobs: map[uuid.UUID]*apischema.VectorIndexJob{}},
+	}
+}
+
+// Inspect returns the vector indexes with their size, their estimated recall
+// and the time of their last rebuild.
+func (v VectorIndexes) Inspect(r *http.Request) ([]storage.VectorIndexStats, error) {
+	if err := authorizeEditor(r, v.editorToken, "vector index"); err != nil {
+		return nil, err
+	}
+
+	// the recall is estimated by exact searches over the tables
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	return v.maintenance().InspectIndexes(ctx)
+}
+
+// Reindex starts the rebuild of the index of the path in the background. The
+// optional request body holds the build options, the zero ones keep the
+// options of the index. It fails at once if the rebuild is refused.
+func (v VectorIndexes) Reindex(r *http.Request) (*apischema.VectorIndexJob, error) {
+	if err := authorizeEditor(r, v.editorToken, "vector index"); err != nil {
+		return nil, err
+	}
+
+	var params storage.ReindexParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && err != io.EOF {
+		return nil, errors.ErrBadRequest.Clone().
+			WithDetails("failed to decode reindex request body").
+			Warp(err)
+	}
+
+	index := r.PathValue("index")
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	params, err := v.maintenance().PlanReindex(ctx, index, params)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := v.jobs.start(index, params, v.Storage.Clock().Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// the rebuild outlives the request
+	go func(ctx context.Context) {
+		record, err := v.maintenance().ReindexConcurrently(ctx, index, params, func(p storage.ReindexProgress) {
+			v.jobs.update(job.ID, func(j *apischema.VectorIndexJob) { j.Progress = p })
+		})
+		v.jobs.finish(job.ID, record, err, v.Storage.Clock().Now())
+		if err != nil {
+			v.Logger.Error().Err(err).Str("index", index).Str("job_id", job.ID.String()).
+				Msg("Failed to rebuild vector index")
+		}
+	}(context.WithoutCancel(r.Context()))
+	return &job, nil
+}
+
+// Job returns the status of the rebuild of the job ID of the path.
+func (v VectorIndexes) Job(r *http.Request) (*apischema.VectorIndexJob, error) {
+	if err := authorizeEditor(r, v.editorToken, "vector index"); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(r.PathValue("job_id"))
+	if err != nil {
+		return nil, errors.ErrBadRequest.Clone().
+			WithDetails("invalid job id").
+			Warp(err)
+	}
+
+	job, ok := v.jobs.get(id)
+	if !ok {
+		return nil, errors.ErrNotFound.Clone().
+			WithDetails(fmt.Sprintf("vector index job %s not found", id))
+	}
+	return &job, nil
+}
+
+func (v VectorIndexes) maintenance() storage.VectorIndexMaintenance {
+	return v.Storage.VectorIndexMaintenance().WithConfig(v.cfg)
+}
+
+// vectorIndexJobs tracks the rebuilds run in the background, keeping the last
+// MaxVectorIndexJobs finished ones.
+type vectorIndexJobs struct {
+	mu       sync.Mutex
+	jobs     map[uuid.UUID]*apischema.VectorIndexJob
+	finished []uuid.UUID
+}
+
+// start tracks a new rebuild of the index, it fails with a conflict if one is
+// running.
+func (t *vectorIndexJobs) start(index string, params storage.ReindexParams, now time.Time) (apischema.VectorIndexJob, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, job := range t.jobs {
+		if job.Index == index && job.Status == apischema.JobRunning {
+			return apischema.VectorIndexJob{}, errors.ErrConflict.Clone().
+				WithDetails(fmt.Sprintf("vector index %s is being rebuilt by job %s", index, job.ID))
+		}
+	}
+
+	job := &apischema.VectorIndexJob{
+		ID:        uuid.New(),
+		Index:     index,
+		Params:    params,
+		Status:    apischema.JobRunning,
+		StartedAt: now,
+	}
+	t.jobs[job.ID] = job
+	return *job, nil
+}
+
+func (t *vectorIndexJobs) update(id uuid.UUID, fn func(job *apischema.VectorIndexJob)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if job, ok := t.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// finish records the result of the rebuild and forgets the oldest finished
+// jobs over MaxVectorIndexJobs.
+func (t *vectorIndexJobs) finish(id uuid.UUID, record storage.VectorIndexRecord, err error, now time.Time) {
+	t.update(id, func(job *apischema.VectorIndexJob) {
+		job.Status, job.FinishedAt = apischema.JobDone, &now
+		if record.ID != 0 {
+			job.Record = &record
+		}
+		if err != nil {
+			job.Status, job.Error = apischema.JobFailed, err.Error()
+		}
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.finished = append(t.finished, id)
+	for len(t.finished) > MaxVectorIndexJobs {
+		delete(t.jobs, t.finished[0])
+		t.finished = t.finished[1:]
+	}
+}
+
+func (t *vectorIndexJobs) get(id uuid.UUID) (apischema.VectorIndexJob, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[id]
+	if !ok {
+		return apischema.VectorIndexJob{}, false
+	}
+	return *job, true
+}
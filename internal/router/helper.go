@@ -2,10 +2,14 @@ package router
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/global"
 	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
 	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 )
 
@@ -52,3 +56,22 @@ func fireOkResp(w http.ResponseWriter, r *http.Request, logger zerolog.Logger,
 	w.WriteHeader(http.StatusOK)
 	w.Write(data)
 }
+
+// fireTaskCreatedResp tells htmx the page of the created task, the other
+// clients get its id in an apischema.TaskCreated.
+func fireTaskCreatedResp(w http.ResponseWriter, r *http.Request,
+	header map[string]string, taskID uuid.UUID) {
+
+	header[apischema.PushURLHeader] = fmt.Sprintf("/task/%s", taskID.String())
+	if r.Header.Get(apischema.HTMXRequestHeader) != "" {
+		fireOkResp(w, r, global.Logger, header, nil)
+		return
+	}
+
+	data, err := json.Marshal(apischema.TaskCreated{TaskID: taskID})
+	if err != nil {
+		fireErrResp(w, r, global.Logger, header, "failed to marshal created task", err)
+		return
+	}
+	fireOkResp(w, r, global.Logger, header, data)
+}
@@ -0,0 +1,159 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/router/intake"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/google/uuid"
+)
+
+// insertFunc creates a task from a submission, see api.TaskEndpoint.
+type insertFunc func(r *http.Request) (uuid.UUID, error)
+
+// journaledInsert journals the raw body of a task submission to endpoint
+// before insert validates it, so that a failed insert does not lose it. A nil
+// journal disables the journaling.
+func journaledInsert(journal *intake.Journal, endpoint string, insert insertFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		if journal == nil {
+			taskID, err := insert(r)
+			if err != nil {
+				fireErrResp(w, r, global.Logger, header, "failed to create task", err)
+				return
+			}
+			fireTaskCreatedResp(w, r, header, taskID)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, journal.MaxBodySize()))
+		if err != nil {
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				e := ec.ErrPayloadTooLarge.Clone().
+					WithDetails(fmt.Sprintf("the body exceeds %d bytes", mbe.Limit))
+				fireErrResp(w, r, global.Logger, header, "failed to create task", e)
+				return
+			}
+			e := ec.ErrBadRequest.Clone().WithDetails("failed to read the body").Warp(err)
+			fireErrResp(w, r, global.Logger, header, "failed to create task", e)
+			return
+		}
+
+		// the submission is still handled if it cannot be journaled
+		intakeID, err := journal.Append(endpoint, r.Header.Get("Content-Type"), body)
+		if err != nil {
+			global.Logger.Error().
+				Err(err).
+				Str("path", r.URL.Path).
+				Msg("Failed to journal task submission")
+		}
+
+		setBody(r, body)
+		submit(w, r, header, journal, intakeID, insert)
+	}
+}
+
+// recoverTask re-submits the journaled body of the intake_id path value to
+// the endpoint it was received on.
+func recoverTask(journal *intake.Journal, inserts map[string]insertFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		intakeID, err := uuid.Parse(r.PathValue("intake_id"))
+		if err != nil {
+			e := ec.ErrBadRequest.Clone().WithDetails("invalid intake_id format").Warp(err)
+			fireErrResp(w, r, global.Logger, header, "failed to recover task", e)
+			return
+		}
+
+		if journal == nil {
+			e := ec.ErrNotFound.Clone().WithDetails("the intake journal is disabled")
+			fireErrResp(w, r, global.Logger, header, "failed to recover task", e)
+			return
+		}
+
+		entry, err := journal.Get(intakeID)
+		if err != nil {
+			e := ec.ErrNotFound.Clone().
+				WithDetails(fmt.Sprintf("no pending submission of intake_id %s", intakeID)).
+				Warp(err)
+			fireErrResp(w, r, global.Logger, header, "failed to recover task", e)
+			return
+		}
+
+		insert, ok := inserts[entry.Endpoint]
+		if !ok {
+			e := ec.ErrInternalServerError.Clone().
+				WithDetails(fmt.Sprintf("unknown endpoint %s of the submission", entry.Endpoint))
+			fireErrResp(w, r, global.Logger, header, "failed to recover task", e)
+			return
+		}
+
+		r.Header.Set("Content-Type", entry.ContentType)
+		setBody(r, entry.Body)
+		submit(w, r, header, journal, intakeID, insert)
+	}
+}
+
+// submit creates the task of the journaled submission r and consumes its
+// entry. A submission which failed on the server side is left in the journal
+// and its intake id is returned with the error, the rejected ones are consumed
+// as a retry would fail the same.
+func submit(w http.ResponseWriter, r *http.Request, header map[string]string,
+	journal *intake.Journal, intakeID uuid.UUID, insert insertFunc) {
+	taskID, err := insert(r)
+	if err != nil {
+		e, ok := err.(*ec.Error)
+		if !ok {
+			e = ec.ErrInternalServerError.Clone().Warp(err)
+		}
+
+		if e.HttpStatusCode >= http.StatusInternalServerError && intakeID != uuid.Nil {
+			header[apischema.IntakeIDHeader] = intakeID.String()
+			e = e.WithDetails(fmt.Sprintf("intake_id: %s", intakeID))
+		} else {
+			consume(r, journal, intakeID)
+		}
+		fireErrResp(w, r, global.Logger, header, "failed to create task", e)
+		return
+	}
+
+	consume(r, journal, intakeID)
+	fireTaskCreatedResp(w, r, header, taskID)
+}
+
+func consume(r *http.Request, journal *intake.Journal, intakeID uuid.UUID) {
+	if intakeID == uuid.Nil {
+		return
+	}
+	if err := journal.Consume(intakeID); err != nil {
+		global.Logger.Error().
+			Err(err).
+			Str("path", r.URL.Path).
+			Str("intake_id", intakeID.String()).
+			Msg("Failed to consume intake entry")
+	}
+}
+
+// setBody replaces the body of r, which has been read, with body.
+func setBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	// the form is parsed from the new body
+	r.Form, r.PostForm = nil, nil
+}
@@ -0,0 +1,22 @@
+package intake
+
+import "os"
+
+// SetSync makes j sync its segments with fn.
+func (j *Journal) SetSync(fn func(*os.File) error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.sync = fn
+}
+
+// Segments returns the sequence numbers of the segments of j.
+func (j *Journal) Segments() []int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	seqs := make([]int, len(j.segments))
+	for i, seg := range j.segments {
+		seqs[i] = seg.seq
+	}
+	return seqs
+}
@@ -0,0 +1,549 @@
+// Package intake journals the raw task submissions before they are inserted,
+// so that a submission accepted by the API is never lost to a failed insert.
+// The body of each submission is appended to a local append-only Journal
+// first, the entry is consumed, i.e. a tombstone is appended, once its task is
+// created, and an entry left behind can be re-submitted by its id.
+package intake
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// FsyncPolicy tells when the journal flushes the appended records to the disk.
+// The records are written to the file as they are appended, so they survive a
+// crash of the process whatever the policy, the policy only matters to a
+// crash of the machine.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways syncs after every record, the slowest and the safest.
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncInterval syncs every FsyncInterval if anything was appended.
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever leaves the flushing to the operating system.
+	FsyncNever FsyncPolicy = "never"
+)
+
+var (
+	ErrNotFound = errors.New("intake entry not found")
+	ErrClosed   = errors.New("intake journal closed")
+)
+
+var staleEntries = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "intake_stale_entries",
+	Help: "The number of journaled task submissions not consumed within the stale window, as of the last sweep.",
+})
+
+const segmentPattern = "intake-%06d.jsonl"
+
+var segmentName = regexp.MustCompile(`^intake-(\d{6,})\.jsonl$`)
+
+// Options configures a Journal. The Clock and the IDs default to the real
+// ones.
+type Options struct {
+	Dir           string
+	MaxBodySize   int64
+	SegmentSize   int64
+	Retention     time.Duration
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+	StaleAfter    time.Duration
+	Clock         clockid.Clock
+	IDs           clockid.IDGen
+}
+
+func DefaultOptions(dir string) Options {
+	opts := NewOptions(global.IntakeConfig{}.Default())
+	opts.Dir = dir
+	return opts
+}
+
+func NewOptions(cfg global.IntakeConfig) Options {
+	return Options{
+		Dir:           cfg.Dir,
+		MaxBodySize:   cfg.MaxBodySize,
+		SegmentSize:   cfg.SegmentSize,
+		Retention:     cfg.Retention,
+		Fsync:         FsyncPolicy(cfg.Fsync),
+		FsyncInterval: cfg.FsyncInterval,
+		StaleAfter:    cfg.StaleAfter,
+	}
+}
+
+// Entry is a journaled submission: the raw body received on Endpoint.
+type Entry struct {
+	ID          uuid.UUID `json:"id"`
+	Endpoint    string    `json:"endpoint"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+const (
+	recordEntry    = "entry"
+	recordConsumed = "consumed"
+)
+
+// record is a line of a segment, an entry or the tombstone of one.
+type record struct {
+	Type string    `json:"type"`
+	At   time.Time `json:"at"`
+	*Entry
+	Consumed uuid.UUID `json:"consumed,omitempty"`
+}
+
+// pending is an unconsumed entry and the segment it is in.
+type pending struct {
+	Entry
+	seq int
+}
+
+type segment struct {
+	seq    int
+	size   int64
+	newest time.Time
+	// torn is set if the segment ends in the middle of a record
+	torn bool
+}
+
+// Journal is an append-only journal of the task submissions kept in segment
+// files of a directory. The segments are rotated once they reach SegmentSize
+// and removed once their newest record is older than Retention, together with
+// the entries not consumed in them. The unconsumed entries are kept in memory,
+// they are rebuilt from the segments on Open.
+type Journal struct {
+	mu       sync.Mutex
+	opts     Options
+	clock    clockid.Clock
+	ids      clockid.IDGen
+	file     *os.File
+	segments []segment
+	lastSeq  int
+	pending  map[uuid.UUID]pending
+	dirty    bool
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+	sync     func(*os.File) error
+}
+
+// Open opens the journal of opts.Dir, creating the directory if needed, and
+// replays its segments. A torn last record, i.e. the process crashed in the
+// middle of writing it, is skipped. The entries left unconsumed by the last
+// run are reported, see Sweep.
+func Open(opts Options) (*Journal, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("intake journal directory is required")
+	}
+	if opts.MaxBodySize <= 0 {
+		return nil, fmt.Errorf("max body size should be positive, got %d", opts.MaxBodySize)
+	}
+	if opts.SegmentSize <= 0 {
+		return nil, fmt.Errorf("segment size should be positive, got %d", opts.SegmentSize)
+	}
+	switch opts.Fsync {
+	case FsyncAlways, FsyncNever:
+	case FsyncInterval:
+		if opts.FsyncInterval <= 0 {
+			return nil, fmt.Errorf("fsync interval should be positive, got %s", opts.FsyncInterval)
+		}
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q", opts.Fsync)
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create intake journal directory: %w", err)
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clockid.Real
+	}
+	if opts.IDs == nil {
+		opts.IDs = clockid.Random
+	}
+
+	j := &Journal{
+		opts:    opts,
+		clock:   opts.Clock,
+		ids:     opts.IDs,
+		pending: map[uuid.UUID]pending{},
+		done:    make(chan struct{}),
+		sync:    (*os.File).Sync,
+	}
+	if err := j.replay(); err != nil {
+		return nil, err
+	}
+	j.expire()
+	if err := j.openSegment(false); err != nil {
+		return nil, err
+	}
+
+	if opts.Fsync == FsyncInterval {
+		j.wg.Add(1)
+		go j.syncLoop()
+	}
+	j.Sweep()
+	return j, nil
+}
+
+// replay rebuilds the segments and the pending entries from the directory.
+func (j *Journal) replay() error {
+	des, err := os.ReadDir(j.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read intake journal directory: %w", err)
+	}
+
+	var seqs []int
+	for _, de := range des {
+		m := segmentName.FindStringSubmatch(de.Name())
+		if de.IsDir() || m == nil {
+			continue
+		}
+		seq, _ := strconv.Atoi(m[1])
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+
+	for _, seq := range seqs {
+		seg, err := j.replaySegment(seq)
+		if err != nil {
+			return err
+		}
+		j.segments = append(j.segments, seg)
+		j.lastSeq = seq
+	}
+	return nil
+}
+
+func (j *Journal) replaySegment(seq int) (segment, error) {
+	seg := segment{seq: seq}
+	f, err := os.Open(j.path(seq))
+	if err != nil {
+		return seg, fmt.Errorf("failed to open intake segment %d: %w", seq, err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		seg.size += int64(len(line))
+		if len(bytes.TrimSpace(line)) > 0 {
+			var rec record
+			if err := json.Unmarshal(line, &rec); err != nil {
+				global.Logger.Warn().
+					Err(err).
+					Int("segment", seq).
+					Int("line", n).
+					Msg("Skipping a broken intake record")
+			} else {
+				j.apply(&seg, rec)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			seg.torn = len(line) > 0
+			return seg, nil
+		}
+		if err != nil {
+			return seg, fmt.Errorf("failed to read intake segment %d: %w", seq, err)
+		}
+	}
+}
+
+func (j *Journal) apply(seg *segment, rec record) {
+	if rec.At.After(seg.newest) {
+		seg.newest = rec.At
+	}
+
+	switch rec.Type {
+	case recordEntry:
+		if rec.Entry != nil {
+			j.pending[rec.ID] = pending{Entry: *rec.Entry, seq: seg.seq}
+		}
+	case recordConsumed:
+		delete(j.pending, rec.Consumed)
+	}
+}
+
+func (j *Journal) path(seq int) string {
+	return filepath.Join(j.opts.Dir, fmt.Sprintf(segmentPattern, seq))
+}
+
+// openSegment opens the last segment for appending, or the next one if next
+// is set, the last one is full or torn, or there is none.
+func (j *Journal) openSegment(next bool) error {
+	if n := len(j.segments); next || n == 0 || j.segments[n-1].torn ||
+		j.segments[n-1].size >= j.opts.SegmentSize {
+		j.lastSeq++
+		j.segments = append(j.segments, segment{seq: j.lastSeq})
+	}
+
+	seq := j.segments[len(j.segments)-1].seq
+	f, err := os.OpenFile(j.path(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open intake segment %d: %w", seq, err)
+	}
+	j.file = f
+	return nil
+}
+
+// rotate closes the current segment and opens the next one.
+func (j *Journal) rotate() error {
+	if err := j.closeFile(); err != nil {
+		return err
+	}
+	j.expire()
+	return j.openSegment(true)
+}
+
+// expire removes the segments, the current one excepted, whose newest record
+// is older than the retention window, and the entries left in them.
+func (j *Journal) expire() {
+	if j.opts.Retention <= 0 {
+		return
+	}
+
+	cutoff := j.clock.Now().Add(-j.opts.Retention)
+	kept := j.segments[:0]
+	for i, seg := range j.segments {
+		current := j.file != nil && i == len(j.segments)-1
+		if current || seg.newest.IsZero() || seg.newest.After(cutoff) {
+			kept = append(kept, seg)
+			continue
+		}
+
+		if err := os.Remove(j.path(seg.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			global.Logger.Error().
+				Err(err).
+				Int("segment", seg.seq).
+				Msg("Failed to remove expired intake segment")
+			kept = append(kept, seg)
+			continue
+		}
+
+		for id, e := range j.pending {
+			if e.seq == seg.seq {
+				global.Logger.Warn().
+					Str("intake_id", id.String()).
+					Str("endpoint", e.Endpoint).
+					Time("received_at", e.ReceivedAt).
+					Msg("Dropping unconsumed intake entry past the retention window")
+				delete(j.pending, id)
+			}
+		}
+	}
+	j.segments = kept
+}
+
+// write appends rec to the current segment, rotating it if it is full. The
+// record is written at once so that a crash leaves at most the last record
+// torn.
+func (j *Journal) write(rec record) error {
+	if j.closed {
+		return ErrClosed
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal intake record: %w", err)
+	}
+	data = append(data, '\n')
+
+	seg := &j.segments[len(j.segments)-1]
+	if seg.size > 0 && seg.size+int64(len(data)) > j.opts.SegmentSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+		seg = &j.segments[len(j.segments)-1]
+	}
+
+	n, err := j.file.Write(data)
+	seg.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write intake record: %w", err)
+	}
+	if rec.At.After(seg.newest) {
+		seg.newest = rec.At
+	}
+
+	if j.opts.Fsync == FsyncAlways {
+		if err := j.sync(j.file); err != nil {
+			return fmt.Errorf("failed to sync intake segment: %w", err)
+		}
+		return nil
+	}
+	j.dirty = true
+	return nil
+}
+
+// MaxBodySize is the size limit of the bodies of the submissions, the larger
+// ones are rejected rather than journaled.
+func (j *Journal) MaxBodySize() int64 {
+	return j.opts.MaxBodySize
+}
+
+// Append journals body, received on endpoint, and returns the id of its entry.
+func (j *Journal) Append(endpoint, contentType string, body []byte) (uuid.UUID, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.clock.Now()
+	e := Entry{
+		ID:          j.ids.NewUUID(),
+		Endpoint:    endpoint,
+		ContentType: contentType,
+		Body:        body,
+		ReceivedAt:  now,
+	}
+	if err := j.write(record{Type: recordEntry, At: now, Entry: &e}); err != nil {
+		return uuid.Nil, err
+	}
+	j.pending[e.ID] = pending{Entry: e, seq: j.segments[len(j.segments)-1].seq}
+	return e.ID, nil
+}
+
+// Consume marks the entry of id as consumed, its task is created. It returns
+// ErrNotFound if there is no such pending entry.
+func (j *Journal) Consume(id uuid.UUID) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.pending[id]; !ok {
+		return ErrNotFound
+	}
+	if err := j.write(record{Type: recordConsumed, At: j.clock.Now(), Consumed: id}); err != nil {
+		return err
+	}
+	delete(j.pending, id)
+	return nil
+}
+
+// Get returns the pending entry of id, or ErrNotFound.
+func (j *Journal) Get(id uuid.UUID) (Entry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e, ok := j.pending[id]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return e.Entry, nil
+}
+
+// Pending returns the pending entries received more than olderThan ago, oldest
+// first.
+func (j *Journal) Pending(olderThan time.Duration) []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	cutoff := j.clock.Now().Add(-olderThan)
+	var entries []Entry
+	for _, e := range j.pending {
+		if !e.ReceivedAt.After(cutoff) {
+			entries = append(entries, e.Entry)
+		}
+	}
+	sort.Slice(entries, func(i, k int) bool {
+		return entries[i].ReceivedAt.Before(entries[k].ReceivedAt)
+	})
+	return entries
+}
+
+// Sweep reports the entries not consumed within StaleAfter, left behind by a
+// failed insert or a crash, for the operators to follow up: their number is
+// set to the intake_stale_entries gauge and each of them is logged.
+func (j *Journal) Sweep() []Entry {
+	stale := j.Pending(j.opts.StaleAfter)
+	staleEntries.Set(float64(len(stale)))
+	for _, e := range stale {
+		global.Logger.Warn().
+			Str("intake_id", e.ID.String()).
+			Str("endpoint", e.Endpoint).
+			Time("received_at", e.ReceivedAt).
+			Int("body_size", len(e.Body)).
+			Msg("Unconsumed intake entry, recover it or discard it")
+	}
+	return stale
+}
+
+func (j *Journal) syncLoop() {
+	defer j.wg.Done()
+
+	ticker := j.clock.NewTicker(j.opts.FsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C():
+			j.mu.Lock()
+			if err := j.flush(); err != nil {
+				global.Logger.Error().Err(err).Msg("Failed to sync intake segment")
+			}
+			j.mu.Unlock()
+		}
+	}
+}
+
+// flush syncs the current segment if anything was appended since the last
+// sync. The journal of a FsyncNever policy is never synced.
+func (j *Journal) flush() error {
+	if !j.dirty || j.file == nil || j.opts.Fsync == FsyncNever {
+		return nil
+	}
+	if err := j.sync(j.file); err != nil {
+		return err
+	}
+	j.dirty = false
+	return nil
+}
+
+func (j *Journal) closeFile() error {
+	if j.file == nil {
+		return nil
+	}
+	if err := j.flush(); err != nil {
+		return fmt.Errorf("failed to sync intake segment: %w", err)
+	}
+	if err := j.file.Close(); err != nil {
+		return fmt.Errorf("failed to close intake segment: %w", err)
+	}
+	j.file = nil
+	return nil
+}
+
+// Close syncs and closes the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.closed = true
+	close(j.done)
+	j.mu.Unlock()
+
+	j.wg.Wait()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.closeFile()
+}
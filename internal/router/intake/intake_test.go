@@ -0,0 +1,233 @@
+package intake_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/router/intake"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+func testOptions(t *testing.T, clock clockid.Clock, fsync intake.FsyncPolicy) intake.Options {
+	t.Helper()
+
+	opts := intake.DefaultOptions(t.TempDir())
+	opts.Fsync = fsync
+	opts.Clock = clock
+	opts.IDs = &clockid.Sequence{}
+	return opts
+}
+
+func openJournal(t *testing.T, opts intake.Options) *intake.Journal {
+	t.Helper()
+
+	j, err := intake.Open(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = j.Close() })
+	return j
+}
+
+func TestOpen(t *testing.T) {
+	opts := intake.DefaultOptions("")
+	_, err := intake.Open(opts)
+	require.Error(t, err)
+
+	opts = intake.DefaultOptions(t.TempDir())
+	opts.Fsync = "sometimes"
+	_, err = intake.Open(opts)
+	require.ErrorContains(t, err, `unknown fsync policy "sometimes"`)
+
+	opts.Fsync = intake.FsyncInterval
+	opts.FsyncInterval = 0
+	_, err = intake.Open(opts)
+	require.Error(t, err)
+}
+
+func TestAppendAndConsume(t *testing.T) {
+	clock := clockid.NewFake(start)
+	j := openJournal(t, testOptions(t, clock, intake.FsyncNever))
+
+	body := []byte("query_text=%23+title%0Abody")
+	id, err := j.Append(apischema.PathTaskFromText, "application/x-www-form-urlencoded", body)
+	require.NoError(t, err)
+
+	e, err := j.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, intake.Entry{
+		ID:          id,
+		Endpoint:    apischema.PathTaskFromText,
+		ContentType: "application/x-www-form-urlencoded",
+		Body:        body,
+		ReceivedAt:  start,
+	}, e)
+
+	require.NoError(t, j.Consume(id))
+	_, err = j.Get(id)
+	require.ErrorIs(t, err, intake.ErrNotFound)
+	require.ErrorIs(t, j.Consume(id), intake.ErrNotFound)
+}
+
+func TestCrashRecovery(t *testing.T) {
+	clock := clockid.NewFake(start)
+	opts := testOptions(t, clock, intake.FsyncInterval)
+	opts.StaleAfter = time.Hour
+
+	// the process crashes between the append and the consume of lost, it is
+	// never closed
+	crashed, err := intake.Open(opts)
+	require.NoError(t, err)
+	lost, err := crashed.Append(apischema.PathTaskFromText, "", []byte("query_text=lost"))
+	require.NoError(t, err)
+	done, err := crashed.Append(apischema.PathTaskFromURL, "", []byte("query_url=done"))
+	require.NoError(t, err)
+	require.NoError(t, crashed.Consume(done))
+
+	clock.Advance(30 * time.Minute)
+	restarted := openJournal(t, opts)
+	require.Empty(t, restarted.Sweep(), "the entry is not stale yet")
+
+	clock.Advance(31 * time.Minute)
+	restarted = openJournal(t, opts)
+	stale := restarted.Sweep()
+	require.Len(t, stale, 1)
+	require.Equal(t, lost, stale[0].ID)
+	require.Equal(t, []byte("query_text=lost"), stale[0].Body)
+
+	_, err = restarted.Get(done)
+	require.ErrorIs(t, err, intake.ErrNotFound)
+}
+
+func TestTornRecord(t *testing.T) {
+	clock := clockid.NewFake(start)
+	opts := testOptions(t, clock, intake.FsyncAlways)
+
+	j, err := intake.Open(opts)
+	require.NoError(t, err)
+	id, err := j.Append(apischema.PathTaskFromText, "", []byte("query_text=kept"))
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+
+	// the crash cut the second record short
+	f, err := os.OpenFile(filepath.Join(opts.Dir, segmentPath(1)), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"type":"entry","at":"2025-06-01T09:00:00Z","id":"00000000-`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	j = openJournal(t, opts)
+	_, err = j.Get(id)
+	require.NoError(t, err)
+	require.Len(t, j.Pending(0), 1)
+
+	// the records appended after the torn one are not mixed up with it
+	next, err := j.Append(apischema.PathTaskFromText, "", []byte("query_text=next"))
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, j.Segments())
+	require.NoError(t, j.Close())
+
+	j = openJournal(t, opts)
+	require.Len(t, j.Pending(0), 2)
+	_, err = j.Get(next)
+	require.NoError(t, err)
+}
+
+func TestRotation(t *testing.T) {
+	clock := clockid.NewFake(start)
+	opts := testOptions(t, clock, intake.FsyncNever)
+	opts.SegmentSize = 512
+	opts.Retention = 24 * time.Hour
+	j := openJournal(t, opts)
+
+	body := make([]byte, 200)
+	var ids []string
+	for range 6 {
+		id, err := j.Append(apischema.PathTaskFromText, "", body)
+		require.NoError(t, err)
+		ids = append(ids, id.String())
+	}
+	require.Greater(t, len(j.Segments()), 1)
+	for _, seq := range j.Segments() {
+		fi, err := os.Stat(filepath.Join(opts.Dir, segmentPath(seq)))
+		require.NoError(t, err)
+		require.LessOrEqual(t, fi.Size(), opts.SegmentSize)
+	}
+	first := j.Segments()[0]
+
+	// the segments past the retention window are removed on the next rotation,
+	// with the entries left in them
+	clock.Advance(25 * time.Hour)
+	for range 3 {
+		_, err := j.Append(apischema.PathTaskFromText, "", body)
+		require.NoError(t, err)
+	}
+	require.NotContains(t, j.Segments(), first)
+	_, err := os.Stat(filepath.Join(opts.Dir, segmentPath(first)))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	for _, e := range j.Pending(0) {
+		require.NotContains(t, ids, e.ID.String())
+	}
+	require.Len(t, j.Pending(0), 3)
+
+	// and on the next start
+	require.NoError(t, j.Close())
+	clock.Advance(25 * time.Hour)
+	j = openJournal(t, opts)
+	require.Empty(t, j.Pending(0))
+	require.Len(t, j.Segments(), 1)
+}
+
+func segmentPath(seq int) string {
+	return fmt.Sprintf("intake-%06d.jsonl", seq)
+}
+
+func TestFsyncPolicy(t *testing.T) {
+	tcs := []struct {
+		policy intake.FsyncPolicy
+		// syncs after the appends, a tick and the close
+		afterAppends, afterTick, afterClose int64
+	}{
+		{policy: intake.FsyncAlways, afterAppends: 3, afterTick: 3, afterClose: 3},
+		{policy: intake.FsyncInterval, afterAppends: 0, afterTick: 1, afterClose: 1},
+		{policy: intake.FsyncNever, afterAppends: 0, afterTick: 0, afterClose: 0},
+	}
+
+	for _, tc := range tcs {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			clock := clockid.NewFake(start)
+			opts := testOptions(t, clock, tc.policy)
+			j, err := intake.Open(opts)
+			require.NoError(t, err)
+
+			var syncs atomic.Int64
+			j.SetSync(func(f *os.File) error {
+				syncs.Add(1)
+				return f.Sync()
+			})
+
+			for range 3 {
+				_, err := j.Append(apischema.PathTaskFromText, "", []byte("query_text=x"))
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.afterAppends, syncs.Load())
+
+			if tc.policy == intake.FsyncInterval {
+				clock.BlockUntil(1)
+				clock.Advance(opts.FsyncInterval)
+				require.Eventually(t, func() bool { return syncs.Load() == tc.afterTick },
+					time.Second, time.Millisecond)
+			}
+			require.Equal(t, tc.afterTick, syncs.Load())
+
+			require.NoError(t, j.Close())
+			require.Equal(t, tc.afterClose, syncs.Load())
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package locale
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+)
+
+// Kind is the enum type a label is of, the top level keys of the catalog.
+type Kind string
+
+const (
+	KindTaskStatus       Kind = "task_status"
+	KindSourceType       Kind = "source_type"
+	KindParty            Kind = "party"
+	KindAnnotationTarget Kind = "annotation_target"
+	KindAnnotationAction Kind = "annotation_action"
+	KindReviewItemType   Kind = "review_item_type"
+	KindReviewStatus     Kind = "review_status"
+)
+
+// Enums are the values of the enums the catalog localizes, by kind.
+var Enums = map[Kind][]string{
+	KindTaskStatus:       values(models.AllTaskStatusValues()),
+	KindSourceType:       values(models.AllSourceTypeValues()),
+	KindParty:            values(models.AllPartyValues()),
+	KindAnnotationTarget: values(models.AllAnnotationTargetValues()),
+	KindAnnotationAction: values(models.AllAnnotationActionValues()),
+	KindReviewItemType:   values(models.AllReviewItemTypeValues()),
+	KindReviewStatus:     values(models.AllReviewStatusValues()),
+}
+
+func values[E ~string](enums []E) []string {
+	vs := make([]string, len(enums))
+	for i, e := range enums {
+		vs[i] = string(e)
+	}
+	return vs
+}
+
+//go:embed catalog/*.json
+var catalogFS embed.FS
+
+// Catalog holds the labels of every locale, by kind and value.
+type Catalog struct {
+	labels map[Locale]map[Kind]map[string]string
+}
+
+// NewCatalog creates a Catalog of labels, by locale, kind and value.
+func NewCatalog(labels map[Locale]map[Kind]map[string]string) *Catalog {
+	return &Catalog{labels: labels}
+}
+
+// Load loads the embedded catalog, a catalog/<locale>.json file per locale,
+// and validates it, see Catalog.Validate.
+func Load() (*Catalog, error) {
+	c := NewCatalog(map[Locale]map[Kind]map[string]string{})
+	for _, l := range Locales {
+		data, err := catalogFS.ReadFile(path.Join("catalog", string(l)+".json"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read catalog of %s: %w", l, err)
+		}
+
+		var labels map[Kind]map[string]string
+		if err := json.Unmarshal(data, &labels); err != nil {
+			return nil, fmt.Errorf("failed to parse catalog of %s: %w", l, err)
+		}
+		c.labels[l] = labels
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks the catalog against Enums: every locale should have a
+// label for every value, and no label for a value which is not one.
+func (c *Catalog) Validate() error {
+	var errs []error
+	for _, l := range Locales {
+		labels := c.labels[l]
+		for kind, vs := range Enums {
+			for _, v := range vs {
+				if labels[kind][v] == "" {
+					errs = append(errs, fmt.Errorf("catalog %s: missing label of %s %q", l, kind, v))
+				}
+			}
+		}
+
+		for kind, entries := range labels {
+			known, ok := Enums[kind]
+			if !ok {
+				errs = append(errs, fmt.Errorf("catalog %s: unknown kind %q", l, kind))
+				continue
+			}
+
+			for v := range entries {
+				if !slices.Contains(known, v) {
+					errs = append(errs, fmt.Errorf("catalog %s: %q is not a %s", l, v, kind))
+				}
+			}
+		}
+	}
+
+	// report in a stable order, the maps are iterated at random
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+// Label returns the label of value in l. A value without a label, i.e. an
+// enum value added without updating the catalog, is returned as is and
+// logged, so that it never fails a response.
+func (c *Catalog) Label(l Locale, kind Kind, value string) string {
+	if label, ok := c.labels[l][kind][value]; ok {
+		return label
+	}
+
+	if value != "" {
+		global.Logger.Warn().
+			Str("locale", string(l)).
+			Str("kind", string(kind)).
+			Str("value", value).
+			Msg("Missing label, falling back to the raw value")
+	}
+	return value
+}
+
+// catalog is the embedded catalog. A broken catalog fails the start of the
+// process, the catalog test catches it before.
+var catalog = func() *Catalog {
+	c, err := Load()
+	if err != nil {
+		panic(fmt.Sprintf("invalid locale catalog: %v", err))
+	}
+	return c
+}()
+
+// Label returns the label of value in the locale of ctx, see Catalog.Label.
+func Label(ctx context.Context, kind Kind, value string) string {
+	return catalog.Label(FromContext(ctx), kind, value)
+}
@@ -0,0 +1,145 @@
+package locale
+
+import (
+	"context"
+
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+)
+
+// Task is a task with the labels of its status and source.
+type Task struct {
+	models.UsersTask
+	StatusLabel string `json:"status_label"`
+	SourceLabel string `json:"source_label"`
+}
+
+// LabelTask labels t in the locale of ctx.
+func LabelTask(ctx context.Context, t *models.UsersTask) Task {
+	return Task{
+		UsersTask:   *t,
+		StatusLabel: Label(ctx, KindTaskStatus, string(t.Status)),
+		SourceLabel: Label(ctx, KindSourceType, string(t.Source)),
+	}
+}
+
+// TaskTimeline is a task timeline with the label of the task status.
+type TaskTimeline struct {
+	storage.TaskTimeline
+	StatusLabel string `json:"status_label"`
+}
+
+// LabelTaskTimeline labels t in the locale of ctx.
+func LabelTaskTimeline(ctx context.Context, t *storage.TaskTimeline) TaskTimeline {
+	return TaskTimeline{
+		TaskTimeline: *t,
+		StatusLabel:  Label(ctx, KindTaskStatus, string(t.Status)),
+	}
+}
+
+// Article is an article of the list with the label of its party.
+type Article struct {
+	apischema.ArticleListItem
+	PartyLabel string `json:"party_label"`
+}
+
+// LabelArticles labels articles in the locale of ctx.
+func LabelArticles(ctx context.Context, articles []apischema.ArticleListItem) []Article {
+	labeled := make([]Article, len(articles))
+	for i, a := range articles {
+		labeled[i] = Article{
+			ArticleListItem: a,
+			PartyLabel:      Label(ctx, KindParty, string(a.Party)),
+		}
+	}
+	return labeled
+}
+
+// Annotation is an annotation with the labels of its target and action.
+type Annotation struct {
+	models.Annotation
+	TargetTypeLabel string `json:"target_type_label"`
+	ActionLabel     string `json:"action_label"`
+}
+
+// LabelAnnotation labels a in the locale of ctx.
+func LabelAnnotation(ctx context.Context, a *models.Annotation) Annotation {
+	return Annotation{
+		Annotation:      *a,
+		TargetTypeLabel: Label(ctx, KindAnnotationTarget, string(a.TargetType)),
+		ActionLabel:     Label(ctx, KindAnnotationAction, string(a.Action)),
+	}
+}
+
+// LabelAnnotations labels annotations in the locale of ctx.
+func LabelAnnotations(ctx context.Context, annotations []models.Annotation) []Annotation {
+	labeled := make([]Annotation, len(annotations))
+	for i := range annotations {
+		labeled[i] = LabelAnnotation(ctx, &annotations[i])
+	}
+	return labeled
+}
+
+// ReviewItem is a review item with the labels of its type, i.e. the reason it
+// was queued for, and of its status.
+type ReviewItem struct {
+	models.UsersReviewQueue
+	ItemTypeLabel string `json:"item_type_label"`
+	StatusLabel   string `json:"status_label"`
+}
+
+// LabelReviewItem labels item in the locale of ctx.
+func LabelReviewItem(ctx context.Context, item *models.UsersReviewQueue) ReviewItem {
+	return ReviewItem{
+		UsersReviewQueue: *item,
+		ItemTypeLabel:    Label(ctx, KindReviewItemType, string(item.ItemType)),
+		StatusLabel:      Label(ctx, KindReviewStatus, string(item.Status)),
+	}
+}
+
+// LabelReviewItems labels items in the locale of ctx.
+func LabelReviewItems(ctx context.Context, items []models.UsersReviewQueue) []ReviewItem {
+	labeled := make([]ReviewItem, len(items))
+	for i := range items {
+		labeled[i] = LabelReviewItem(ctx, &items[i])
+	}
+	return labeled
+}
+
+// PartyCount is the number of articles of a party with the label of the party.
+type PartyCount struct {
+	models.CountArticlesByPartyRow
+	PartyLabel string `json:"party_label"`
+}
+
+// LabelPartyCounts labels counts in the locale of ctx.
+func LabelPartyCounts(ctx context.Context, counts []models.CountArticlesByPartyRow) []PartyCount {
+	labeled := make([]PartyCount, len(counts))
+	for i, c := range counts {
+		labeled[i] = PartyCount{
+			CountArticlesByPartyRow: c,
+			PartyLabel:              Label(ctx, KindParty, string(c.Party)),
+		}
+	}
+	return labeled
+}
+
+// DailyCount is the number of articles of a party published on a day with the
+// label of the party.
+type DailyCount struct {
+	models.CountArticlesPerDayRow
+	PartyLabel string `json:"party_label"`
+}
+
+// LabelDailyCounts labels counts in the locale of ctx.
+func LabelDailyCounts(ctx context.Context, counts []models.CountArticlesPerDayRow) []DailyCount {
+	labeled := make([]DailyCount, len(counts))
+	for i, c := range counts {
+		labeled[i] = DailyCount{
+			CountArticlesPerDayRow: c,
+			PartyLabel:             Label(ctx, KindParty, string(c.Party)),
+		}
+	}
+	return labeled
+}
@@ -0,0 +1,94 @@
+// Package locale localizes the enum values of the API responses. The labels
+// of every locale are kept in an embedded message catalog, see Catalog, and
+// attached alongside the raw values, e.g. "status": "processing" and
+// "status_label": "處理中", so that the clients do not keep a mapping of their
+// own.
+package locale
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"golang.org/x/text/language"
+)
+
+// Locale is a BCP 47 language tag the catalog has labels for.
+type Locale string
+
+const (
+	ZhHant Locale = "zh-Hant"
+	En     Locale = "en"
+	// Default is the locale of the requests which do not match any other.
+	Default = ZhHant
+)
+
+// Locales are the supported locales, Default first.
+var Locales = []Locale{ZhHant, En}
+
+var matcher = language.NewMatcher([]language.Tag{
+	language.TraditionalChinese,
+	language.English,
+})
+
+// Negotiate returns the supported locale closest to an Accept-Language
+// header, e.g. zh-TW or zh-HK picks ZhHant and en-US picks En. Default is
+// returned if no language of the header matches.
+func Negotiate(acceptLanguage string) Locale {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return Default
+	}
+
+	_, i, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return Default
+	}
+	return Locales[i]
+}
+
+// Parse returns the supported locale of a language tag, e.g. en or zh-TW, and
+// whether there is one.
+func Parse(tag string) (Locale, bool) {
+	t, err := language.Parse(tag)
+	if err != nil {
+		return "", false
+	}
+
+	_, i, confidence := matcher.Match(t)
+	if confidence < language.High {
+		return "", false
+	}
+	return Locales[i], true
+}
+
+type ctxKey struct{}
+
+// WithLocale returns a copy of ctx carrying l.
+func WithLocale(ctx context.Context, l Locale) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the locale of ctx, Default if it carries none.
+func FromContext(ctx context.Context) Locale {
+	if l, ok := ctx.Value(ctxKey{}).(Locale); ok {
+		return l
+	}
+	return Default
+}
+
+// Middleware negotiates the locale of each request and puts it in the request
+// context. The lang query parameter, see apischema.ParamLang, takes precedence
+// over the Accept-Language header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l, ok := Parse(r.URL.Query().Get(apischema.ParamLang))
+		if !ok {
+			l = Negotiate(r.Header.Get("Accept-Language"))
+		}
+
+		w.Header().Set("Content-Language", string(l))
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(WithLocale(r.Context(), l)))
+	})
+}
@@ -0,0 +1,195 @@
+package locale_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/router/locale"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	// fails as soon as an enum value is added without its labels
+	_, err := locale.Load()
+	require.NoError(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	labels := map[locale.Locale]map[locale.Kind]map[string]string{}
+	for _, l := range locale.Locales {
+		labels[l] = map[locale.Kind]map[string]string{}
+		for kind, vs := range locale.Enums {
+			labels[l][kind] = map[string]string{}
+			for _, v := range vs {
+				labels[l][kind][v] = string(l) + ":" + v
+			}
+		}
+	}
+	require.NoError(t, locale.NewCatalog(labels).Validate())
+
+	delete(labels[locale.ZhHant][locale.KindTaskStatus], string(models.TaskStatusProcessing))
+	labels[locale.En][locale.KindParty]["NPP"] = "New Power Party"
+	err := locale.NewCatalog(labels).Validate()
+	require.ErrorContains(t, err, `catalog zh-Hant: missing label of task_status "processing"`)
+	require.ErrorContains(t, err, `catalog en: "NPP" is not a party`)
+}
+
+func TestNegotiate(t *testing.T) {
+	tcs := []struct {
+		header string
+		want   locale.Locale
+	}{
+		{header: "", want: locale.ZhHant},
+		{header: "zh-TW,zh;q=0.9,en-US;q=0.8,en;q=0.7", want: locale.ZhHant},
+		{header: "zh-HK", want: locale.ZhHant},
+		{header: "en-US,en;q=0.9", want: locale.En},
+		{header: "fr-FR,en;q=0.5", want: locale.En},
+		{header: "en;q=0.3,zh-Hant;q=0.8", want: locale.ZhHant},
+		{header: "fr-FR", want: locale.ZhHant},
+		{header: ";;;", want: locale.ZhHant},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.header, func(t *testing.T) {
+			require.Equal(t, tc.want, locale.Negotiate(tc.header))
+		})
+	}
+}
+
+func labeledTaskServer(t *testing.T, task models.UsersTask) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(locale.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(locale.LabelTask(r.Context(), &task))
+	})))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func getLabeled(t *testing.T, url, acceptLanguage string) (map[string]any, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if acceptLanguage != "" {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return body, resp.Header.Get("Content-Language")
+}
+
+func TestMiddleware(t *testing.T) {
+	srv := labeledTaskServer(t, models.UsersTask{
+		Source: models.SourceTypeUrl,
+		Status: models.TaskStatusProcessing,
+	})
+
+	tcs := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		locale         string
+		status         string
+		source         string
+	}{
+		{
+			name:           "traditional chinese",
+			acceptLanguage: "zh-TW,zh;q=0.9",
+			locale:         "zh-Hant",
+			status:         "處理中",
+			source:         "網址",
+		},
+		{
+			name:           "english",
+			acceptLanguage: "en-US,en;q=0.9",
+			locale:         "en",
+			status:         "Processing",
+			source:         "URL",
+		},
+		{
+			name:   "default locale",
+			locale: "zh-Hant",
+			status: "處理中",
+			source: "網址",
+		},
+		{
+			name:           "query parameter overrides the header",
+			query:          "?" + apischema.ParamLang + "=en",
+			acceptLanguage: "zh-TW",
+			locale:         "en",
+			status:         "Processing",
+			source:         "URL",
+		},
+		{
+			name:           "unsupported query parameter is ignored",
+			query:          "?" + apischema.ParamLang + "=ja",
+			acceptLanguage: "en",
+			locale:         "en",
+			status:         "Processing",
+			source:         "URL",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			body, lang := getLabeled(t, srv.URL+tc.query, tc.acceptLanguage)
+			require.Equal(t, tc.locale, lang)
+			require.Equal(t, "processing", body["status"])
+			require.Equal(t, tc.status, body["status_label"])
+			require.Equal(t, tc.source, body["source_label"])
+		})
+	}
+}
+
+func TestMiddlewareFallback(t *testing.T) {
+	// a status the catalog does not know yet
+	srv := labeledTaskServer(t, models.UsersTask{
+		Source: models.SourceTypeText,
+		Status: models.TaskStatus("archived"),
+	})
+
+	logs := &syncBuffer{}
+	prev := global.Logger
+	global.Logger = zerolog.New(logs)
+	t.Cleanup(func() { global.Logger = prev })
+
+	for acceptLanguage, source := range map[string]string{"zh-TW": "文字", "en": "Text"} {
+		body, _ := getLabeled(t, srv.URL, acceptLanguage)
+		require.Equal(t, "archived", body["status"])
+		require.Equal(t, "archived", body["status_label"])
+		require.Equal(t, source, body["source_label"])
+	}
+	require.Contains(t, logs.String(), `"value":"archived"`)
+}
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of the server.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
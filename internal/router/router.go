@@ -4,13 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"html/template"
 	"net/http"
 	"time"
 
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
 	"github.com/ChiaYuChang/weathercock/internal/global"
 	"github.com/ChiaYuChang/weathercock/internal/router/api"
+	"github.com/ChiaYuChang/weathercock/internal/router/intake"
+	"github.com/ChiaYuChang/weathercock/internal/router/locale"
 	"github.com/ChiaYuChang/weathercock/internal/storage"
 	"github.com/ChiaYuChang/weathercock/internal/workers/publishers"
 )
@@ -38,45 +40,39 @@ var TestArticle = Article{
 	Keywords:    []string{"高齡換照", "交通部", "重大車禍", "陳雪生", "陳超明"},
 }
 
-func NewRouter(store storage.Storage, pub *publishers.Publisher, tmpl *template.Template) *http.ServeMux {
+// NewRouter serves the API and the web UI. The task submissions are journaled
+// to journal before they are inserted, see intake.Journal, nil to disable it.
+// The vector index endpoints are configured by vectorIndex.
+func NewRouter(store storage.Storage, pub *publishers.Publisher, tmpl *template.Template,
+	editorToken string, scoring global.ScoringConfig, vectorIndex global.VectorIndexConfig,
+	journal *intake.Journal) http.Handler {
 	mux := http.NewServeMux()
 
 	repo := api.NewRepo(store, pub, global.Logger, nil)
 	taskEp := repo.UserTask(global.Validator)
+	annotationEp := repo.Annotations(global.Validator, editorToken)
+	statsEp := repo.Stats()
+	keywordsEp := repo.Keywords()
+	articlesEp := repo.PublicArticles(global.Validator, storage.ArticleScoring(scoring))
+	savedSearchEp := repo.SavedSearches(global.Validator, editorToken)
+	reviewEp := repo.Review(global.Validator, editorToken)
+	schemaEp := repo.Schema(editorToken)
+	sourceWeightsEp := repo.SourceWeights(global.Validator, editorToken)
+	reconciliationEp := repo.Reconciliation(editorToken)
+	vectorIndexesEp := repo.VectorIndexes(editorToken, vectorIndex)
 
 	// file server
 	mux.Handle("/", http.FileServer(http.Dir("./static")))
 
 	// API endpoints
-	mux.HandleFunc("POST /api/v1/task/url", func(w http.ResponseWriter, r *http.Request) {
-		header := map[string]string{
-			"Content-Type": "application/json; charset=utf-8",
-		}
-
-		taskID, err := taskEp.InsertFromURL(r)
-		if err != nil {
-			fireErrResp(w, r, global.Logger, header, "failed to create task", err)
-			return
-		}
-
-		header["HX-PUSH-URL"] = fmt.Sprintf("/task/%s", taskID.String())
-		fireOkResp(w, r, global.Logger, header, nil)
-	})
-
-	mux.HandleFunc("POST /api/v1/task/text", func(w http.ResponseWriter, r *http.Request) {
-		header := map[string]string{
-			"Content-Type": "application/json; charset=utf-8",
-		}
-
-		taskID, err := taskEp.InsertFromText(r)
-		if err != nil {
-			fireErrResp(w, r, global.Logger, header, "failed to create task", err)
-			return
-		}
-
-		header["HX-PUSH-URL"] = fmt.Sprintf("/task/%s", taskID.String())
-		fireOkResp(w, r, global.Logger, header, nil)
-	})
+	inserts := map[string]insertFunc{
+		apischema.PathTaskFromURL:  taskEp.InsertFromURL,
+		apischema.PathTaskFromText: taskEp.InsertFromText,
+	}
+	for endpoint, insert := range inserts {
+		mux.HandleFunc("POST "+endpoint, journaledInsert(journal, endpoint, insert))
+	}
+	mux.HandleFunc("POST "+apischema.PathTaskRecover, recoverTask(journal, inserts))
 
 	mux.HandleFunc("GET /api/v1/articles/{task_id}", func(w http.ResponseWriter, r *http.Request) {
 		global.Logger.Info().
@@ -172,5 +168,529 @@ func NewRouter(store storage.Storage, pub *publishers.Publisher, tmpl *template.
 			Str("host", r.Host).
 			Msg("Counter reset after serving keywords request")
 	})
-	return mux
+
+	mux.HandleFunc("GET "+apischema.PathKeywordAnomalies, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		anomalies, err := keywordsEp.Anomalies(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to list keyword anomalies", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"anomalies": anomalies,
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal keyword anomalies", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET /api/v1/tasks/{task_id}/similar", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		articles, err := taskEp.Similar(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to search similar articles", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"articles": articles,
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal similar articles", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET "+apischema.PathTask, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		task, err := taskEp.Get(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to get task", err)
+			return
+		}
+
+		data, err := json.Marshal(locale.LabelTask(r.Context(), task))
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal task", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET "+apischema.PathTaskTimeline, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		timeline, err := taskEp.Timeline(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to get task timeline", err)
+			return
+		}
+
+		data, err := json.Marshal(locale.LabelTaskTimeline(r.Context(), timeline))
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal task timeline", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET "+apischema.PathArticles, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		articles, err := articlesEp.List(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to list articles", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"articles": locale.LabelArticles(r.Context(), articles),
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal articles", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET /api/v1/stats/summary", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		summary, err := statsEp.Summary(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to get stats summary", err)
+			return
+		}
+
+		data, err := json.Marshal(summary)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal stats summary", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET "+apischema.PathStatsDashboard, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		dashboard, err := statsEp.Dashboard(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to get stats dashboard", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"summary":     dashboard.Summary,
+			"parties":     locale.LabelPartyCounts(r.Context(), dashboard.Parties),
+			"trend":       locale.LabelDailyCounts(r.Context(), dashboard.Trend),
+			"trend_since": dashboard.TrendSince,
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal stats dashboard", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("POST /api/v1/articles/{article_id}/annotations", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		annotation, err := annotationEp.Create(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to create annotation", err)
+			return
+		}
+
+		data, err := json.Marshal(locale.LabelAnnotation(r.Context(), annotation))
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal annotation", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET /api/v1/articles/{article_id}/annotations", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		annotations, err := annotationEp.List(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to list annotations", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"annotations": locale.LabelAnnotations(r.Context(), annotations),
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal annotations", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("DELETE /api/v1/articles/{article_id}/annotations/{annotation_id}", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		if err := annotationEp.Delete(r); err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to delete annotation", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, nil)
+	})
+
+	mux.HandleFunc("POST /api/v1/saved-searches", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		search, err := savedSearchEp.Create(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to create saved search", err)
+			return
+		}
+
+		data, err := json.Marshal(search)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal saved search", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET /api/v1/saved-searches", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		searches, err := savedSearchEp.List(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to list saved searches", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"saved_searches": searches,
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal saved searches", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET /api/v1/saved-searches/{saved_search_id}", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		search, err := savedSearchEp.Get(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to get saved search", err)
+			return
+		}
+
+		data, err := json.Marshal(search)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal saved search", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("PUT /api/v1/saved-searches/{saved_search_id}", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		search, err := savedSearchEp.Update(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to update saved search", err)
+			return
+		}
+
+		data, err := json.Marshal(search)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal saved search", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("DELETE /api/v1/saved-searches/{saved_search_id}", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		if err := savedSearchEp.Delete(r); err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to delete saved search", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, nil)
+	})
+
+	mux.HandleFunc("GET /api/v1/saved-searches/{saved_search_id}/hits", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		hits, err := savedSearchEp.Hits(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to list saved search hits", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"hits": hits,
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal saved search hits", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET /api/v1/admin/review", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		items, err := reviewEp.List(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to list review items", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"items": locale.LabelReviewItems(r.Context(), items),
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal review items", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET /api/v1/admin/review/stats", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		stats, err := reviewEp.Stats(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to get review queue stats", err)
+			return
+		}
+
+		data, err := json.Marshal(stats)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal review queue stats", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("POST /api/v1/admin/review/{review_id}/claim", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		item, err := reviewEp.Claim(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to claim review item", err)
+			return
+		}
+
+		data, err := json.Marshal(locale.LabelReviewItem(r.Context(), item))
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal review item", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("POST /api/v1/admin/review/{review_id}/resolve", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		item, err := reviewEp.Resolve(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to resolve review item", err)
+			return
+		}
+
+		data, err := json.Marshal(locale.LabelReviewItem(r.Context(), item))
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal review item", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET "+apischema.PathSourceWeights, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		weights, err := sourceWeightsEp.List(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to list source weights", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"source_weights": weights,
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal source weights", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("PUT "+apischema.PathSourceWeight, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		weight, err := sourceWeightsEp.Set(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to set source weight", err)
+			return
+		}
+
+		data, err := json.Marshal(weight)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal source weight", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET "+apischema.PathReconciliation, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		reports, err := reconciliationEp.Reports(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to list reconciliation reports", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"reports": reports,
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal reconciliation reports", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET "+apischema.PathVectorIndexes, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		indexes, err := vectorIndexesEp.Inspect(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to inspect vector indexes", err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"indexes": indexes,
+		})
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal vector indexes", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("POST "+apischema.PathVectorReindex, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		job, err := vectorIndexesEp.Reindex(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to start vector index rebuild", err)
+			return
+		}
+
+		data, err := json.Marshal(job)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal vector index job", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET "+apischema.PathVectorIndexJob, func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		job, err := vectorIndexesEp.Job(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to get vector index job", err)
+			return
+		}
+
+		data, err := json.Marshal(job)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal vector index job", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+
+	mux.HandleFunc("GET /api/v1/admin/schema", func(w http.ResponseWriter, r *http.Request) {
+		header := map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+		}
+
+		schema, err := schemaEp.Get(r)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to introspect schema", err)
+			return
+		}
+
+		data, err := json.Marshal(schema)
+		if err != nil {
+			fireErrResp(w, r, global.Logger, header, "failed to marshal schema", err)
+			return
+		}
+		fireOkResp(w, r, global.Logger, header, data)
+	})
+	return locale.Middleware(mux)
 }
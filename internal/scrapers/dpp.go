@@ -61,7 +61,7 @@ func ParseDppOfficialSite(urls []string, breaks Delay, selectors SiteSelectors,
 
 	re := regexp.MustCompile(`www\.dpp\.org\.tw/(?:anti_rumor|media)/contents/(\d+)`)
 	for i, subject := range subjects {
-		resp, err := http.Get(fmt.Sprintf(DppURLTmpl, subject.subject))
+		resp, err := httpClient().Get(fmt.Sprintf(DppURLTmpl, subject.subject))
 		if err != nil {
 			return fmt.Errorf("faile to fetch the latest %s", subject.name)
 		}
@@ -111,7 +111,7 @@ func ParseDppOfficialSite(urls []string, breaks Delay, selectors SiteSelectors,
 		"www.dpp.org.tw", 2, true,
 		[]*regexp.Regexp{
 			regexp.MustCompile(`^https://www\.dpp\.org\.tw/(?:media|anti_rumor)`),
-		}, breaks, headers, output, files)
+		}, breaks, headers, output, files, Sessions["dpp"])
 
 	collector.OnHTML(
 		selectors.ContentContainerSelector,
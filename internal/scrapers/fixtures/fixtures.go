@@ -0,0 +1,302 @@
+// Package fixtures serves recorded pages of the party sites and Yahoo News on
+// a local port, so the scrapers can run without hitting the live sites.
+//
+// The recordings are listed in testdata/manifest.json and re-recorded from the
+// live sites with
+//
+//	go run ./cmd/recordfixtures
+//
+// The Server answers for the recorded hosts: its Transport sends every request
+// to the server, keeping the original host, so the seed URLs, the allowed
+// domains and the URL filters of the scrapers stay unchanged.
+package fixtures
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+)
+
+//go:embed testdata
+var recordings embed.FS
+
+// ManifestFile is the path of the manifest in the recordings.
+const ManifestFile = "testdata/manifest.json"
+
+// Recording is an entry of the manifest, the page recorded from URL and stored
+// in File, relative to testdata. A URL with a query only matches a request
+// with the same query, one without a query matches any query.
+type Recording struct {
+	URL    string `json:"url"`
+	File   string `json:"file"`
+	Status int    `json:"status,omitempty"`
+}
+
+// Manifest reads the recordings listed in the embedded manifest.
+func Manifest() ([]Recording, error) {
+	data, err := recordings.ReadFile(ManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var recs []Recording
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return recs, nil
+}
+
+// Page is a response of the Server. A zero Status is 200 and an empty
+// ContentType is HTML.
+type Page struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// page is a Page served for requests with query, any query if it is nil.
+type page struct {
+	Page
+	query url.Values
+}
+
+// Server serves the recorded pages, and the pages added to it, by the host and
+// the path of the request.
+type Server struct {
+	srv *httptest.Server
+	cfg global.FixturesConfig
+
+	mu    sync.Mutex
+	pages map[string][]page
+	hits  map[string]int
+}
+
+// NewServer starts a Server with the embedded recordings on cfg.Addr. Close it
+// when done.
+func NewServer(cfg global.FixturesConfig) (*Server, error) {
+	s := &Server{
+		cfg:   cfg,
+		pages: map[string][]page{},
+		hits:  map[string]int{},
+	}
+
+	recs, err := Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rec := range recs {
+		body, err := recordings.ReadFile(path.Join("testdata", rec.File))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recording of %s: %w", rec.URL, err)
+		}
+		if err := s.add(rec.URL, Page{Status: rec.Status, Body: body}); err != nil {
+			return nil, err
+		}
+	}
+
+	addr := cfg.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	s.srv = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	s.srv.Listener.Close()
+	s.srv.Listener = l
+	s.srv.Start()
+
+	global.Logger.Info().
+		Str("addr", s.Addr()).
+		Int("recordings", len(recs)).
+		Float64("error_rate", cfg.ErrorRate).
+		Dur("latency", cfg.Latency).
+		Msg("fixture server started")
+	return s, nil
+}
+
+// StartFixtureServer starts a Server for the test and closes it on cleanup.
+func StartFixtureServer(tb testing.TB, cfg global.FixturesConfig) *Server {
+	tb.Helper()
+	s, err := NewServer(cfg)
+	if err != nil {
+		tb.Fatalf("failed to start fixture server: %v", err)
+	}
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Addr returns the address the Server listens on.
+func (s *Server) Addr() string {
+	return s.srv.Listener.Addr().String()
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Transport returns a round tripper sending every request to the Server.
+func (s *Server) Transport() http.RoundTripper {
+	return NewTransport(s.Addr())
+}
+
+// Client returns an HTTP client sending every request to the Server.
+func (s *Server) Client() *http.Client {
+	return &http.Client{Transport: s.Transport(), Timeout: 30 * time.Second}
+}
+
+// Add serves p at rawURL, in place of any recording of the same URL.
+func (s *Server) Add(rawURL string, p Page) error {
+	return s.add(rawURL, p)
+}
+
+func (s *Server) add(rawURL string, p Page) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid fixture URL %q: %w", rawURL, err)
+	}
+
+	var query url.Values
+	if u.RawQuery != "" {
+		query = u.Query()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := pageKey(u.Hostname(), u.Path)
+	pages := s.pages[key]
+	for i, old := range pages {
+		if old.query.Encode() == query.Encode() {
+			pages = append(pages[:i], pages[i+1:]...)
+			break
+		}
+	}
+	s.pages[key] = append(pages, page{Page: p, query: query})
+	return nil
+}
+
+// Hits returns the number of requests to the host and path of rawURL.
+func (s *Server) Hits(rawURL string) int {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[pageKey(u.Hostname(), u.Path)]
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	key := pageKey(host, r.URL.Path)
+
+	s.mu.Lock()
+	s.hits[key]++
+	p, ok := match(s.pages[key], r.URL.Query())
+	s.mu.Unlock()
+
+	if s.cfg.Latency > 0 {
+		select {
+		case <-time.After(s.cfg.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if s.cfg.ErrorRate > 0 && rand.Float64() < s.cfg.ErrorRate {
+		http.Error(w, "injected failure", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", p.ContentType)
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(p.Body)
+}
+
+// match returns the page served for query, preferring the one recorded with
+// the same query.
+func match(pages []page, query url.Values) (Page, bool) {
+	var fallback *page
+	for i, p := range pages {
+		if p.query == nil {
+			fallback = &pages[i]
+			continue
+		}
+		if p.query.Encode() == query.Encode() {
+			return p.withDefaults(), true
+		}
+	}
+	if fallback == nil {
+		return Page{}, false
+	}
+	return fallback.withDefaults(), true
+}
+
+func (p page) withDefaults() Page {
+	if p.Status == 0 {
+		p.Status = http.StatusOK
+	}
+	if p.ContentType == "" {
+		p.ContentType = "text/html; charset=utf-8"
+	}
+	return p.Page
+}
+
+func pageKey(host, p string) string {
+	if p == "" {
+		p = "/"
+	}
+	return strings.ToLower(host) + p
+}
+
+// transport sends every request to addr over plain HTTP, keeping the original
+// host in the Host header.
+type transport struct {
+	addr string
+	base http.RoundTripper
+}
+
+// NewTransport returns a round tripper sending every request to the fixture
+// server at addr, e.g. one started by another process.
+func NewTransport(addr string) http.RoundTripper {
+	return transport{addr: addr, base: http.DefaultTransport}
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme = "http"
+	r.URL.Host = t.addr
+	r.Host = req.URL.Host
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	// the scrapers read the URL of the page from the response
+	resp.Request = req
+	return resp, nil
+}
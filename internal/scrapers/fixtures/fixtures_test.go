@@ -0,0 +1,142 @@
+package fixtures_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers/fixtures"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	kmtList    = "https://www.kmt.org.tw/search/label/%E6%96%B0%E8%81%9E%E7%A8%BF"
+	kmtListEnd = kmtList + "?updated-max=2025-05-30T16%3A20%3A00%2B08%3A00&max-results=10"
+)
+
+func get(t *testing.T, cli *http.Client, rawURL string) (int, string) {
+	t.Helper()
+	resp, err := cli.Get(rawURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestManifest(t *testing.T) {
+	recs, err := fixtures.Manifest()
+	require.NoError(t, err)
+	require.NotEmpty(t, recs)
+
+	for _, rec := range recs {
+		require.NotEmpty(t, rec.URL)
+		require.NotEmpty(t, rec.File)
+	}
+}
+
+func TestServerServesByHost(t *testing.T) {
+	srv := fixtures.StartFixtureServer(t, global.FixturesConfig{})
+	cli := srv.Client()
+
+	resp, err := cli.Get("https://www.tpp.org.tw/news?page=1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "www.tpp.org.tw", resp.Request.URL.Host, "the response keeps the original URL")
+
+	status, body := get(t, cli, "https://www.dpp.org.tw/media")
+	require.Equal(t, http.StatusOK, status)
+	require.Contains(t, body, "news_abtn")
+
+	status, _ = get(t, cli, "https://www.kmt.org.tw/media")
+	require.Equal(t, http.StatusNotFound, status, "the path is recorded for another host")
+
+	require.Equal(t, 1, srv.Hits("https://www.tpp.org.tw/news"))
+	require.Equal(t, 1, srv.Hits("https://www.dpp.org.tw/media"))
+}
+
+func TestServerMatchesQuery(t *testing.T) {
+	srv := fixtures.StartFixtureServer(t, global.FixturesConfig{})
+	cli := srv.Client()
+
+	_, body := get(t, cli, kmtList+"?updated-max=2025-06-03T10%3A00%3A00%2B08%3A00&max-results=10")
+	require.Contains(t, body, "date-posts", "an unrecorded query falls back to the page without a query")
+
+	_, body = get(t, cli, kmtListEnd)
+	require.NotContains(t, body, "date-posts", "a recorded query is served its own page")
+
+	require.Equal(t, 2, srv.Hits(kmtList))
+}
+
+func TestServerAdd(t *testing.T) {
+	srv := fixtures.StartFixtureServer(t, global.FixturesConfig{})
+	cli := srv.Client()
+
+	require.NoError(t, srv.Add("https://www.dpp.org.tw/media", fixtures.Page{
+		Status: http.StatusGone,
+		Body:   []byte("gone"),
+	}))
+	status, body := get(t, cli, "https://www.dpp.org.tw/media")
+	require.Equal(t, http.StatusGone, status)
+	require.Equal(t, "gone", body)
+
+	require.NoError(t, srv.Add("https://example.com/feed.xml", fixtures.Page{
+		ContentType: "application/xml",
+		Body:        []byte("<rss/>"),
+	}))
+	resp, err := cli.Get("https://example.com/feed.xml")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/xml", resp.Header.Get("Content-Type"))
+}
+
+func TestServerFaults(t *testing.T) {
+	t.Run("ErrorRate", func(t *testing.T) {
+		srv := fixtures.StartFixtureServer(t, global.FixturesConfig{ErrorRate: 1})
+		status, _ := get(t, srv.Client(), "https://www.tpp.org.tw/news")
+		require.Equal(t, http.StatusServiceUnavailable, status)
+		require.Equal(t, 1, srv.Hits("https://www.tpp.org.tw/news"))
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		srv := fixtures.StartFixtureServer(t, global.FixturesConfig{Latency: 50 * time.Millisecond})
+		start := time.Now()
+		status, _ := get(t, srv.Client(), "https://www.tpp.org.tw/news")
+		require.Equal(t, http.StatusOK, status)
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+}
+
+func TestSanitize(t *testing.T) {
+	page := `<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width">
+<link rel="stylesheet" href="/main.css"><style>body{}</style>
+<script src="/tracker.js"></script>
+<script type="application/ld+json">{"datePublished":"2025-06-01T09:30:15.000Z"}</script>
+</head><body>
+<!-- ad slot -->
+<div class="caas-body" id="body" data-reactid="42" style="color:red" onclick="track()">
+<p>內文</p>
+<a href="https://tw.news.yahoo.com/a.html?id=1&utm_source=fb&fbclid=abc#top" rel="nofollow">link</a>
+<iframe src="https://ads.example.com"></iframe>
+</div></body></html>`
+
+	out, err := fixtures.Sanitize(strings.NewReader(page))
+	require.NoError(t, err)
+	got := string(out)
+
+	for _, removed := range []string{"tracker.js", "body{}", "main.css", "viewport",
+		"ad slot", "data-reactid", "style=", "onclick", "rel=", "iframe", "utm_source", "fbclid"} {
+		require.NotContains(t, got, removed)
+	}
+	for _, kept := range []string{`charset="utf-8"`, "application/ld+json", "datePublished",
+		`class="caas-body"`, `id="body"`, "<p>內文</p>",
+		`href="https://tw.news.yahoo.com/a.html?id=1#top"`} {
+		require.Contains(t, got, kept)
+	}
+}
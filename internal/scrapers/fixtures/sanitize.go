@@ -0,0 +1,109 @@
+package fixtures
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// strippedElements are removed from a recorded page, the scrapers read none of
+// them. The JSON-LD scripts are kept, the Yahoo News scraper reads them.
+const strippedElements = "script:not([type='application/ld+json']), style, noscript, iframe, " +
+	"link, svg, form, object, embed, video, audio, meta:not([charset])"
+
+// keptAttributes are the attributes kept on the elements of a recorded page.
+var keptAttributes = map[string]bool{
+	"id":       true,
+	"class":    true,
+	"href":     true,
+	"title":    true,
+	"datetime": true,
+	"itemprop": true,
+	"type":     true,
+	"charset":  true,
+	"lang":     true,
+}
+
+// Sanitize strips a page recorded from a live site down to the markup the
+// scrapers read. Scripts, styles, embedded media, comments and the attributes
+// other than the keptAttributes are removed, and the query of the links loses
+// its tracking parameters.
+func Sanitize(r io.Reader) ([]byte, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+
+	doc.Find(strippedElements).Remove()
+	for _, n := range doc.Nodes {
+		sanitizeNode(n)
+	}
+
+	var buf bytes.Buffer
+	for _, n := range doc.Nodes {
+		if err := html.Render(&buf, n); err != nil {
+			return nil, fmt.Errorf("failed to render page: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func sanitizeNode(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.CommentNode {
+			n.RemoveChild(c)
+		} else {
+			sanitizeNode(c)
+		}
+		c = next
+	}
+
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if !keptAttributes[a.Key] {
+			continue
+		}
+		if a.Key == "href" {
+			a.Val = stripTracking(a.Val)
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+}
+
+// stripTracking removes the utm_* and click id parameters from the query of
+// href.
+func stripTracking(href string) string {
+	base, query, ok := strings.Cut(href, "?")
+	if !ok {
+		return href
+	}
+
+	query, fragment, hasFragment := strings.Cut(query, "#")
+	var kept []string
+	for _, param := range strings.Split(query, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if strings.HasPrefix(name, "utm_") || name == "fbclid" || name == "gclid" {
+			continue
+		}
+		kept = append(kept, param)
+	}
+
+	href = base
+	if len(kept) > 0 {
+		href += "?" + strings.Join(kept, "&")
+	}
+	if hasFragment {
+		href += "#" + fragment
+	}
+	return href
+}
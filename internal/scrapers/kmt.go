@@ -5,7 +5,6 @@ import (
 	"encoding/hex"
 	stde "errors"
 	"fmt"
-	"math/rand/v2"
 	"net/url"
 	"regexp"
 	"strconv"
@@ -45,6 +44,9 @@ var KmtSeedUrls = []string{
 }
 
 // ParseKmtOfficialSite scrapes the KMT official site for press releases.
+// The list pages are visited one after the other, following the next page
+// token, and the press releases they link to are queued on an async collector
+// fetching breaks.Parallelism of them at once, with the delays of breaks.
 // Parameters:
 // - urls: List of seed URLs to start scraping from. (use KmtSeedUrls for default)
 // - breaks: Configuration for scraping breaks.
@@ -53,84 +55,33 @@ var KmtSeedUrls = []string{
 // Returns an error if the scraping process fails.
 func ParseKmtOfficialSite(urls []string, breaks Delay, selectors SiteSelectors,
 	headers map[string]string, output chan<- ScrapingResult, files map[string]struct{}) error {
+	// Ensure the output channel is closed when done
+	defer close(output)
 	filters := []*regexp.Regexp{
 		regexp.MustCompile(`^https://www\.kmt\.org\.tw/search/label/%E6%96%B0%E8%81%9E%E7%A8%BF`),
 		regexp.MustCompile(`^https://www\.kmt\.org\.tw/\d{4}/\d{2}/.*\.html`),
 	}
-	hasher := md5.New()
-
-	collector := NewCollector("www.kmt.org.tw", 2, true, filters,
-		breaks, headers, output, files)
+	lists := NewCollector("www.kmt.org.tw", 1, false, filters,
+		breaks, headers, output, files, Sessions["kmt"])
+	details := NewCollector("www.kmt.org.tw", 1, true, filters,
+		breaks, headers, output, files, Sessions["kmt"])
 
-	collector.OnHTML(
+	// the list page being visited, the list collector is synchronous
+	var page struct {
+		links []string
+		next  string
+		err   error
+	}
+	lists.OnHTML(
 		selectors.ContentContainerSelector,
 		func(e *colly.HTMLElement) {
-			urlStr := e.Request.URL.String()
-
-			if strings.Contains(urlStr, url.PathEscape("新聞稿")) {
-				links, next, err := parseKMTPressReleaseList(e, selectors)
-				if err != nil {
-					global.Logger.Error().
-						Err(err).
-						Str("link", urlStr).
-						Msg("Failed to parse KMT press release list")
-					output <- ScrapingResult{
-						Content: Content{Link: urlStr},
-						Error:   err,
-					}
-					return
-				}
-				for _, link := range links {
-					if strings.Contains(link, "www.facebook.com") ||
-						strings.Contains(link, "www.youtube.com") ||
-						strings.Contains(link, "www.instagram.com") ||
-						strings.Contains(link, "x.com") {
-						global.Logger.Debug().
-							Str("src_link", e.Request.URL.String()).
-							Str("dst_link", link).
-							Msg("Skipping social media link")
-						return
-					}
-
-					hasher.Reset()
-					hasher.Write([]byte(link))
-					if _, ok := files[hex.EncodeToString(hasher.Sum(nil))]; ok {
-						global.Logger.Debug().
-							Str("src_link", e.Request.URL.String()).
-							Str("dst_link", link).
-							Msg("Skipping already visited link")
-						return
-					}
-
-					err := e.Request.Visit(link)
-					if err != nil {
-						// omit errors that are expected
-						if stde.Is(err, colly.ErrNoURLFiltersMatch) ||
-							stde.Is(err, colly.ErrMaxDepth) ||
-							strings.Contains(err.Error(), "already visited") {
-							return
-						}
-
-						global.Logger.Error().
-							Err(err).
-							Str("src_link", e.Request.URL.String()).
-							Str("dst_link", link).
-							Msg("Failed to visit link")
-						output <- ScrapingResult{Error: fmt.Errorf(
-							"[OnHTML] failed to visit link %s: %w", link, err,
-						)}
-					}
+			page.links, page.next, page.err = parseKMTPressReleaseList(e, selectors)
+		},
+	)
 
-					sleep := time.Duration(rand.Int64N(int64(breaks.DelayTimeRng))) + breaks.MinDelayTime
-					global.Logger.Debug().
-						Int64("duration", int64(sleep/time.Second)).
-						Str("link", link).
-						Msg("[VisitLoop] Taking a break before visiting next link")
-					time.Sleep(sleep)
-				}
-				// new seed
-				collector.Visit(next)
-			}
+	details.OnHTML(
+		selectors.ContentContainerSelector,
+		func(e *colly.HTMLElement) {
 			content, err := parseKMTPressReleaseContent(e, selectors)
 			if err != nil {
 				global.Logger.Error().
@@ -149,19 +100,92 @@ func ParseKmtOfficialSite(urls []string, breaks Delay, selectors SiteSelectors,
 	)
 
 	var err error
+seeds:
 	for _, seed := range urls {
-		err = collector.Visit(seed)
-		if err != nil {
-			break
+		for link := seed; link != ""; link = page.next {
+			page.links, page.next, page.err = nil, "", nil
+			if vErr := lists.Visit(link); vErr != nil {
+				if link == seed {
+					err = vErr
+					break seeds
+				}
+
+				// the failed request has been reported by the collector
+				global.Logger.Error().
+					Err(vErr).
+					Str("link", link).
+					Msg("Failed to visit next page, stop paging")
+				break
+			}
+
+			if page.err != nil {
+				global.Logger.Error().
+					Err(page.err).
+					Str("link", link).
+					Msg("Failed to parse KMT press release list")
+				output <- ScrapingResult{
+					Content: Content{Link: link},
+					Error:   page.err,
+				}
+				break
+			}
+
+			for _, dst := range page.links {
+				visitKMTPressRelease(details, link, dst, output, files)
+			}
 		}
 	}
-	collector.Wait()
+	details.Wait()
 	if err != nil {
 		return fmt.Errorf("[Seed] failed to visit seed URLs: %w", err)
 	}
 	return nil
 }
 
+// visitKMTPressRelease queues the press release at link, found on the list
+// page src, unless it is a social media link or it has been scraped already.
+func visitKMTPressRelease(c *colly.Collector, src, link string,
+	output chan<- ScrapingResult, files map[string]struct{}) {
+	if strings.Contains(link, "www.facebook.com") ||
+		strings.Contains(link, "www.youtube.com") ||
+		strings.Contains(link, "www.instagram.com") ||
+		strings.Contains(link, "x.com") {
+		global.Logger.Debug().
+			Str("src_link", src).
+			Str("dst_link", link).
+			Msg("Skipping social media link")
+		return
+	}
+
+	hasher := md5.New()
+	hasher.Write([]byte(link))
+	if _, ok := files[hex.EncodeToString(hasher.Sum(nil))]; ok {
+		global.Logger.Debug().
+			Str("src_link", src).
+			Str("dst_link", link).
+			Msg("Skipping already visited link")
+		return
+	}
+
+	if err := c.Visit(link); err != nil {
+		// omit errors that are expected
+		if stde.Is(err, colly.ErrNoURLFiltersMatch) ||
+			stde.Is(err, colly.ErrMaxDepth) ||
+			strings.Contains(err.Error(), "already visited") {
+			return
+		}
+
+		global.Logger.Error().
+			Err(err).
+			Str("src_link", src).
+			Str("dst_link", link).
+			Msg("Failed to visit link")
+		output <- ScrapingResult{Error: fmt.Errorf(
+			"[VisitLoop] failed to visit link %s: %w", link, err,
+		)}
+	}
+}
+
 // parseKMTPressReleaseList extracts links and the next page URL from the KMT press release list page.
 func parseKMTPressReleaseList(e *colly.HTMLElement, selector SiteSelectors) (links []string, next string, err error) {
 	matches := regexp.MustCompile(`PageNo=(\d+)`).FindAllStringSubmatch(e.Request.URL.String(), -1)
@@ -170,14 +194,6 @@ func parseKMTPressReleaseList(e *colly.HTMLElement, selector SiteSelectors) (lin
 		pageNo, _ = strconv.Atoi(matches[0][1])
 	}
 
-	timestamp, ok := e.DOM.Find(selector.NextPageTokenSelector).Last().Attr("title")
-	if !ok {
-		global.Logger.Error().
-			Str("link", e.Request.URL.String()).
-			Msg("Failed to find timestamp in document")
-		return nil, "", fmt.Errorf("failed to find timestamp in document for link: %s", e.Request.URL.String())
-	}
-
 	links = []string{}
 	e.DOM.Find(selector.HrefSelector).Each(func(i int, s *goquery.Selection) {
 		link, ok := s.Attr("href")
@@ -186,6 +202,22 @@ func parseKMTPressReleaseList(e *colly.HTMLElement, selector SiteSelectors) (lin
 		}
 	})
 
+	if len(links) == 0 {
+		// the page past the oldest press release lists nothing
+		global.Logger.Info().
+			Int("page_no", pageNo).
+			Msg("Reached the last page")
+		return links, "", nil
+	}
+
+	timestamp, ok := e.DOM.Find(selector.NextPageTokenSelector).Last().Attr("title")
+	if !ok {
+		global.Logger.Error().
+			Str("link", e.Request.URL.String()).
+			Msg("Failed to find timestamp in document")
+		return nil, "", fmt.Errorf("failed to find timestamp in document for link: %s", e.Request.URL.String())
+	}
+
 	global.Logger.Info().
 		Int("page_no", pageNo).
 		Int("n_links", len(links)).
@@ -0,0 +1,300 @@
+package scrapers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxConsecutiveFailures is the number of consecutive hard failures
+// after which a URL is flagged as dead.
+const DefaultMaxConsecutiveFailures = 3
+
+// LinkHealth classifies the outcome of a liveness check.
+type LinkHealth int
+
+const (
+	// LinkAlive means the URL resolved to a successful response.
+	LinkAlive LinkHealth = iota
+	// LinkSoftFailure means the check is inconclusive, e.g. a timeout, a
+	// server error or the request being throttled. It does not count towards
+	// the URL being dead.
+	LinkSoftFailure
+	// LinkHardFailure means the page is gone, e.g. 404, 410 or the host
+	// does not exist anymore.
+	LinkHardFailure
+)
+
+func (h LinkHealth) String() string {
+	switch h {
+	case LinkAlive:
+		return "alive"
+	case LinkSoftFailure:
+		return "soft_failure"
+	case LinkHardFailure:
+		return "hard_failure"
+	}
+	return fmt.Sprintf("LinkHealth(%d)", int(h))
+}
+
+// LinkCheck is the outcome of checking a single URL.
+type LinkCheck struct {
+	URL        string // URL being checked
+	FinalURL   string // URL after following redirects
+	StatusCode int    // status code of the final response, 0 if there is none
+	Err        error  // error if no response has been received
+}
+
+// Health classifies the check result.
+func (c LinkCheck) Health() LinkHealth {
+	if c.Err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(c.Err, &dnsErr) && dnsErr.IsNotFound {
+			return LinkHardFailure
+		}
+		return LinkSoftFailure
+	}
+
+	switch {
+	case c.StatusCode >= 200 && c.StatusCode < 400:
+		return LinkAlive
+	case c.StatusCode == http.StatusNotFound,
+		c.StatusCode == http.StatusGone,
+		c.StatusCode == http.StatusUnavailableForLegalReasons:
+		return LinkHardFailure
+	}
+	return LinkSoftFailure
+}
+
+// Moved reports whether the URL has been redirected to a different
+// canonical URL, and returns the canonical form of the new URL.
+func (c LinkCheck) Moved() (string, bool) {
+	if c.FinalURL == "" || c.Health() != LinkAlive {
+		return "", false
+	}
+
+	final, err := CanonicalURL(c.FinalURL)
+	if err != nil {
+		return "", false
+	}
+
+	orig, err := CanonicalURL(c.URL)
+	if err != nil || orig == final {
+		return "", false
+	}
+	return final, true
+}
+
+// URLState is the liveness state of a stored URL.
+type URLState struct {
+	StatusCode          int
+	ConsecutiveFailures int32
+	Dead                bool
+}
+
+// Next returns the state after check. A successful check resets the state, a
+// hard failure increases the failure count and flags the URL as dead once it
+// reaches maxFailures, a soft failure only updates the status code. A dead
+// URL stays dead until a check succeeds.
+func (s URLState) Next(check LinkCheck, maxFailures int32) URLState {
+	next := URLState{
+		StatusCode:          check.StatusCode,
+		ConsecutiveFailures: s.ConsecutiveFailures,
+		Dead:                s.Dead,
+	}
+
+	switch check.Health() {
+	case LinkAlive:
+		next.ConsecutiveFailures = 0
+		next.Dead = false
+	case LinkHardFailure:
+		next.ConsecutiveFailures++
+		if maxFailures > 0 && next.ConsecutiveFailures >= maxFailures {
+			next.Dead = true
+		}
+	}
+	return next
+}
+
+// trackingParams are the query parameters dropped by CanonicalURL.
+var trackingParams = []string{"utm_", "fbclid", "gclid"}
+
+// CanonicalURL normalizes a URL so that different spellings of the same page
+// compare equal: the scheme and host are lowercased, default ports, the
+// fragment and tracking parameters are removed, and the query is sorted.
+func CanonicalURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse url %q: %w", raw, err)
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("url should be absolute: %q", raw)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" &&
+		!(u.Scheme == "http" && port == "80") &&
+		!(u.Scheme == "https" && port == "443") {
+		host = net.JoinHostPort(host, port)
+	}
+	u.Host = host
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	q := u.Query()
+	for key := range q {
+		for _, p := range trackingParams {
+			if strings.HasPrefix(strings.ToLower(key), p) {
+				q.Del(key)
+				break
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
+
+// LinkChecker checks whether URLs still resolve. It issues HEAD requests and
+// falls back to a ranged GET for servers which reject HEAD. At most perHost
+// requests are in flight for the same host, and each of them is followed by
+// a break before the slot is released.
+type LinkChecker struct {
+	client  *http.Client
+	headers map[string]string
+	breaks  Delay
+	perHost int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// NewLinkChecker creates a LinkChecker. If client is nil, a client with a 10
+// second timeout is used.
+func NewLinkChecker(client *http.Client, perHost int, breaks Delay, headers map[string]string) *LinkChecker {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if perHost < 1 {
+		perHost = 1
+	}
+
+	if headers == nil {
+		headers = DefaultHeaders
+	}
+
+	return &LinkChecker{
+		client:  client,
+		headers: headers,
+		breaks:  breaks,
+		perHost: perHost,
+		slots:   map[string]chan struct{}{},
+	}
+}
+
+func (c *LinkChecker) slot(host string) chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.slots[host]
+	if !ok {
+		s = make(chan struct{}, c.perHost)
+		c.slots[host] = s
+	}
+	return s
+}
+
+// Check checks a single URL.
+func (c *LinkChecker) Check(ctx context.Context, rawURL string) LinkCheck {
+	check := LinkCheck{URL: rawURL}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		check.Err = fmt.Errorf("failed to parse url: %w", err)
+		return check
+	}
+
+	slot := c.slot(strings.ToLower(u.Hostname()))
+	select {
+	case slot <- struct{}{}:
+	case <-ctx.Done():
+		check.Err = ctx.Err()
+		return check
+	}
+	defer func() {
+		c.pause(ctx)
+		<-slot
+	}()
+
+	resp, err := c.do(ctx, http.MethodHead, rawURL)
+	if err == nil && headRejected(resp.StatusCode) {
+		resp.Body.Close()
+		resp, err = c.do(ctx, http.MethodGet, rawURL)
+	}
+
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<10))
+
+	check.StatusCode = resp.StatusCode
+	check.FinalURL = resp.Request.URL.String()
+	return check
+}
+
+func (c *LinkChecker) do(ctx context.Context, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	return c.client.Do(req)
+}
+
+func (c *LinkChecker) pause(ctx context.Context) {
+	d := c.breaks.MinDelayTime
+	if c.breaks.DelayTimeRng > 0 {
+		d += rand.N(c.breaks.DelayTimeRng)
+	}
+
+	if d <= 0 {
+		return
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// headRejected reports whether the server does not support HEAD requests.
+func headRejected(code int) bool {
+	return code == http.StatusMethodNotAllowed ||
+		code == http.StatusNotImplemented ||
+		code == http.StatusForbidden
+}
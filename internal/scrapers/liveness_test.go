@@ -0,0 +1,87 @@
+package scrapers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tcs := []struct {
+		name string
+		raw  string
+		want string
+		err  bool
+	}{
+		{
+			name: "lowercase scheme and host",
+			raw:  "HTTPS://TW.News.Yahoo.com/a.html",
+			want: "https://tw.news.yahoo.com/a.html",
+		},
+		{
+			name: "drop default port and fragment",
+			raw:  "https://www.kmt.org.tw:443/p/1#top",
+			want: "https://www.kmt.org.tw/p/1",
+		},
+		{
+			name: "keep non-default port",
+			raw:  "http://localhost:8080/p",
+			want: "http://localhost:8080/p",
+		},
+		{
+			name: "drop tracking parameters and sort query",
+			raw:  "https://www.dpp.org.tw/media/contents/1?utm_source=fb&b=2&a=1&fbclid=x",
+			want: "https://www.dpp.org.tw/media/contents/1?a=1&b=2",
+		},
+		{
+			name: "empty path",
+			raw:  "https://www.tpp.org.tw",
+			want: "https://www.tpp.org.tw/",
+		},
+		{
+			name: "relative url",
+			raw:  "/p/1",
+			err:  true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := scrapers.CanonicalURL(tc.raw)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLinkCheckerHeadFallback(t *testing.T) {
+	methods := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		require.Equal(t, "bytes=0-0", r.Header.Get("Range"))
+		w.WriteHeader(http.StatusPartialContent)
+	}))
+	defer srv.Close()
+
+	checker := scrapers.NewLinkChecker(srv.Client(), 1, scrapers.Delay{}, map[string]string{})
+	check := checker.Check(context.Background(), srv.URL+"/p")
+	require.NoError(t, check.Err)
+	require.Equal(t, http.StatusPartialContent, check.StatusCode)
+	require.Equal(t, scrapers.LinkAlive, check.Health())
+	require.Equal(t, []string{http.MethodHead, http.MethodGet}, methods)
+
+	_, moved := check.Moved()
+	require.False(t, moved)
+}
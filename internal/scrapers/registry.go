@@ -0,0 +1,126 @@
+package scrapers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedSite is returned by Registry.Lookup for a URL no parser is
+// registered for.
+var ErrUnsupportedSite = errors.New("unsupported site")
+
+// ParsedArticle is an article parsed by an ArticleParser, normalized across
+// the sites.
+type ParsedArticle struct {
+	Title      string
+	Publisher  string
+	Published  time.Time
+	Modified   time.Time // zero if the site declares no modification time
+	Paragraphs []string
+	Keywords   []string
+}
+
+// DeclaredModified returns the modification time declared by the publisher,
+// and false if it declares none.
+func (a ParsedArticle) DeclaredModified() (time.Time, bool) {
+	if a.Modified.IsZero() || a.Modified.Equal(a.Published) {
+		return time.Time{}, false
+	}
+	return a.Modified, true
+}
+
+// ArticleParser parses the article page of a site.
+type ArticleParser interface {
+	Parse(resp *http.Response) (*ParsedArticle, error)
+}
+
+// Registry maps the hostnames of the sites to the parsers of their article
+// pages. A pattern is either a hostname, e.g. "tw.news.yahoo.com", or a
+// wildcard matching its subdomains, e.g. "*.udn.com". A hostname matches
+// itself before any wildcard, and a longer wildcard before a shorter one.
+type Registry struct {
+	hosts     map[string]ArticleParser
+	wildcards map[string]ArticleParser // keyed by the suffix, e.g. ".udn.com"
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		hosts:     map[string]ArticleParser{},
+		wildcards: map[string]ArticleParser{},
+	}
+}
+
+// Register makes the registry parse the articles of the hosts matching
+// pattern with p, replacing the parser registered for pattern before.
+func (r *Registry) Register(pattern string, p ArticleParser) *Registry {
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		r.wildcards[suffix] = p
+		return r
+	}
+	r.hosts[pattern] = p
+	return r
+}
+
+// Lookup returns the parser of the host of rawURL, ErrUnsupportedSite if none
+// matches it.
+func (r *Registry) Lookup(rawURL string) (ArticleParser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSite, err)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if p, ok := r.hosts[host]; ok {
+		return p, nil
+	}
+
+	var parser ArticleParser
+	var matched string
+	for suffix, p := range r.wildcards {
+		if strings.HasSuffix(host, suffix) && len(suffix) > len(matched) {
+			parser, matched = p, suffix
+		}
+	}
+	if parser == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedSite, host)
+	}
+	return parser, nil
+}
+
+// Supports reports whether a parser is registered for the host of rawURL.
+func (r *Registry) Supports(rawURL string) bool {
+	_, err := r.Lookup(rawURL)
+	return err == nil
+}
+
+// DefaultRegistry holds the parsers of the supported news sites.
+var DefaultRegistry = NewRegistry().
+	Register("tw.news.yahoo.com", YahooNewsParser{})
+
+// YahooNewsParser is the ArticleParser of Yahoo News.
+type YahooNewsParser struct{}
+
+// Parse parses the article of resp with ParseYahooNewsResp.
+func (YahooNewsParser) Parse(resp *http.Response) (*ParsedArticle, error) {
+	result := ParseYahooNewsResp(resp)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	article := result.Article
+	parsed := &ParsedArticle{
+		Title:      article.Title,
+		Publisher:  article.Publisher,
+		Published:  article.Published,
+		Paragraphs: article.Content,
+		Keywords:   article.Keywords,
+	}
+	parsed.Modified, _ = article.DeclaredModified()
+	return parsed, nil
+}
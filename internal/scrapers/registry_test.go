@@ -0,0 +1,103 @@
+package scrapers_test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers/fixtures"
+	"github.com/stretchr/testify/require"
+)
+
+// namedParser is an ArticleParser telling the registered parsers apart.
+type namedParser string
+
+func (p namedParser) Parse(resp *http.Response) (*scrapers.ParsedArticle, error) {
+	return &scrapers.ParsedArticle{Title: string(p)}, nil
+}
+
+func TestRegistryLookup(t *testing.T) {
+	r := scrapers.NewRegistry().
+		Register("tw.news.yahoo.com", namedParser("yahoo")).
+		Register("*.udn.com", namedParser("udn")).
+		Register("*.money.udn.com", namedParser("money")).
+		Register("*.ltn.com.tw", namedParser("ltn")).
+		Register("news.ltn.com.tw", namedParser("ltn-news"))
+
+	for _, tc := range []struct {
+		Name string
+		URL  string
+		Want string
+	}{
+		{Name: "host", URL: "https://tw.news.yahoo.com/a-071647696.html", Want: "yahoo"},
+		{Name: "case and port", URL: "https://TW.News.Yahoo.com:443/a.html", Want: "yahoo"},
+		{Name: "subdomain", URL: "https://news.udn.com/news/story/1", Want: "udn"},
+		{Name: "longest wildcard", URL: "https://www.money.udn.com/money/story/1", Want: "money"},
+		{Name: "host before wildcard", URL: "https://news.ltn.com.tw/news/1", Want: "ltn-news"},
+		{Name: "wildcard is not the domain", URL: "https://udn.com/news/story/1"},
+		{Name: "other host", URL: "https://www.cna.com.tw/news/1"},
+		{Name: "suffix of another host", URL: "https://evilnews.yahoo.com/a.html"},
+		{Name: "invalid", URL: "://tw.news.yahoo.com"},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			p, err := r.Lookup(tc.URL)
+			if tc.Want == "" {
+				require.ErrorIs(t, err, scrapers.ErrUnsupportedSite)
+				require.False(t, r.Supports(tc.URL))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, namedParser(tc.Want), p)
+			require.True(t, r.Supports(tc.URL))
+		})
+	}
+}
+
+func TestRegistryRegisterReplaces(t *testing.T) {
+	r := scrapers.NewRegistry().
+		Register("tw.news.yahoo.com", namedParser("old")).
+		Register("TW.NEWS.YAHOO.COM", namedParser("new"))
+
+	p, err := r.Lookup("https://tw.news.yahoo.com/a.html")
+	require.NoError(t, err)
+	require.Equal(t, namedParser("new"), p)
+}
+
+func TestYahooNewsParser(t *testing.T) {
+	srv := fixtures.StartFixtureServer(t, global.FixturesConfig{})
+
+	link := "https://tw.news.yahoo.com/" + url.PathEscape("高齡換照年齡擬下修-73歲藍委-我是受害者-071647696.html")
+	p, err := scrapers.DefaultRegistry.Lookup(link)
+	require.NoError(t, err)
+	require.IsType(t, scrapers.YahooNewsParser{}, p)
+
+	t.Run("article", func(t *testing.T) {
+		resp, err := srv.Client().Get(link)
+		require.NoError(t, err)
+
+		article, err := p.Parse(resp)
+		require.NoError(t, err)
+		require.Equal(t, "高齡換照年齡擬下修73歲藍委：我是受害者", article.Title)
+		require.Equal(t, "中央社", article.Publisher)
+		require.Equal(t, []string{"交通部", "國民黨", "換照", "高齡駕駛"}, article.Keywords)
+		require.Len(t, article.Paragraphs, 3)
+
+		modified, ok := article.DeclaredModified()
+		require.True(t, ok)
+		require.True(t, modified.After(article.Published))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		resp, err := srv.Client().Get("https://tw.news.yahoo.com/no-such-article-000000000.html")
+		require.NoError(t, err)
+		require.NotEqual(t, http.StatusOK, resp.StatusCode)
+
+		article, err := p.Parse(resp)
+		require.Error(t, err)
+		require.Nil(t, article)
+		require.False(t, errors.Is(err, scrapers.ErrUnsupportedSite))
+	})
+}
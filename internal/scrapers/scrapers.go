@@ -55,6 +55,15 @@ var DefaultTimeZone, _ = time.LoadLocation("Asia/Taipei")
 type Delay struct {
 	MinDelayTime time.Duration // Minimum time for a short break
 	DelayTimeRng time.Duration // Random range added to short break
+	Parallelism  int           // Number of pages fetched at once, DefaultParallelism if not positive
+}
+
+// parallelism returns the number of pages fetched at once.
+func (d Delay) parallelism() int {
+	if d.Parallelism > 0 {
+		return d.Parallelism
+	}
+	return max(DefaultParallelism, 1)
 }
 
 var DefaultBreaks = Delay{
@@ -65,6 +74,16 @@ var DefaultBreaks = Delay{
 // DefaultParallelism is the number of concurrent requests to make.
 var DefaultParallelism = runtime.NumCPU() - 1
 
+// Transport is the round tripper of the collectors and the HTTP clients of the
+// scrapers, nil for http.DefaultTransport. The fixture server replaces it to
+// serve recorded pages instead of the live sites.
+var Transport http.RoundTripper
+
+// httpClient returns the HTTP client of the scrapers.
+func httpClient() *http.Client {
+	return &http.Client{Transport: Transport}
+}
+
 type SiteSelectors struct {
 	TitleSelector            string            `json:"title_selector"`
 	ContentContainerSelector string            `json:"content_container_selector"`
@@ -127,10 +146,15 @@ func (s ScrapingResult) ToRecord() Record {
 	return r
 }
 
+// NewCollector creates a collector of the pages of domain. The requests carry
+// headers and, if session is not nil, the cookies and the headers of the
+// session, established by the first request. A login page served in place of a
+// page is never parsed: the session is refreshed, the queue waiting for it, and
+// the request retried once. If the session cannot be refreshed, or expires
+// again right away, the requests fail with ErrSessionExpired.
 func NewCollector(domain string, maxDepth int, async bool, filter []*regexp.Regexp, breaks Delay,
-	headers map[string]string, output chan<- ScrapingResult, files map[string]struct{}) *colly.Collector {
-	hasher := md5.New()
-
+	headers map[string]string, output chan<- ScrapingResult, files map[string]struct{},
+	session SessionProvider) *colly.Collector {
 	c := colly.NewCollector(
 		colly.AllowedDomains(domain),
 		colly.URLFilters(filter...),
@@ -138,15 +162,25 @@ func NewCollector(domain string, maxDepth int, async bool, filter []*regexp.Rege
 		colly.MaxDepth(maxDepth),
 	)
 
+	if Transport != nil {
+		c.WithTransport(Transport)
+	}
+
 	c.Limit(&colly.LimitRule{
 		DomainGlob:  fmt.Sprintf("*%s", domain),
-		Parallelism: DefaultParallelism,
+		Parallelism: breaks.parallelism(),
 		Delay:       breaks.MinDelayTime,
 		RandomDelay: breaks.DelayTimeRng,
 	})
 
+	var cs *collectorSession
+	if session != nil {
+		cs = newCollectorSession(session)
+	}
+
 	c.OnRequest(func(r *colly.Request) {
-		hasher.Reset()
+		// the requests of an async collector run concurrently, every one hashes on its own
+		hasher := md5.New()
 		hasher.Write([]byte(strings.TrimLeft(r.URL.String(), "https://")))
 		hashsum := hex.EncodeToString(hasher.Sum(nil))
 		msg := global.Logger.Debug().
@@ -167,10 +201,33 @@ func NewCollector(domain string, maxDepth int, async bool, filter []*regexp.Rege
 		for key, value := range headers {
 			r.Headers.Set(key, value)
 		}
+
+		if cs != nil {
+			if err := cs.attach(r); err != nil {
+				msg.Err(err).Msg("Skipping page without session")
+				output <- ScrapingResult{
+					Content: Content{Link: r.URL.String()},
+					Error:   err,
+				}
+				r.Abort()
+				return
+			}
+		}
 		msg.Msg("Visiting new page")
 	})
 
 	c.OnError(func(r *colly.Response, err error) {
+		if cs != nil {
+			u := r.Request.URL
+			if target := redirectTarget(err); target != nil {
+				u = target
+			}
+			if cs.provider.IsLoginPage(u, r.StatusCode, r.Body) {
+				cs.expired(r.Request, output)
+				return
+			}
+		}
+
 		global.Logger.Error().
 			Err(err).
 			Str("state", "OnError").
@@ -196,6 +253,12 @@ func NewCollector(domain string, maxDepth int, async bool, filter []*regexp.Rege
 				Msg("Request failed with non-200 status code")
 			return
 		}
+
+		if cs != nil && cs.provider.IsLoginPage(r.Request.URL, r.StatusCode, r.Body) {
+			// the body is dropped before the HTML callbacks run
+			r.Body = nil
+			cs.expired(r.Request, output)
+		}
 	})
 
 	return c
@@ -1,51 +1,212 @@
 package scrapers_test
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ChiaYuChang/weathercock/internal/global"
 	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers/fixtures"
 	"github.com/stretchr/testify/require"
 )
 
-func TestParseKMTPressRelease(t *testing.T) {
-	global.InitBaseLogger("dev")
+// testBreaks keeps the scrapers from pausing between the recorded pages.
+var testBreaks = scrapers.Delay{DelayTimeRng: time.Millisecond}
+
+// useFixtures serves the recorded pages to the scrapers for the test.
+func useFixtures(t *testing.T) *fixtures.Server {
+	t.Helper()
+	srv := fixtures.StartFixtureServer(t, global.FixturesConfig{})
+	transport := scrapers.Transport
+	scrapers.Transport = srv.Transport()
+	t.Cleanup(func() { scrapers.Transport = transport })
+	return srv
+}
+
+type parseFunc func(urls []string, breaks scrapers.Delay, selectors scrapers.SiteSelectors,
+	headers map[string]string, output chan<- scrapers.ScrapingResult, files map[string]struct{}) error
+
+// scrape runs parse and collects its results.
+func scrape(t *testing.T, parse parseFunc, urls []string, selectors scrapers.SiteSelectors) []scrapers.ScrapingResult {
+	t.Helper()
 
 	c := make(chan scrapers.ScrapingResult)
-	exfns := make(map[string]struct{})
-	err := scrapers.ParseKmtOfficialSite(
-		scrapers.KmtSeedUrls,
-		scrapers.DefaultBreaks,
-		scrapers.KmtSelectors,
-		scrapers.DefaultHeaders,
-		c,
-		exfns,
-	)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- parse(urls, testBreaks, selectors, scrapers.DefaultHeaders, c, map[string]struct{}{})
+	}()
 
+	var results []scrapers.ScrapingResult
 	for result := range c {
+		results = append(results, result)
+	}
+	require.NoError(t, <-errc)
+	return results
+}
+
+func TestParseKMTPressRelease(t *testing.T) {
+	global.InitBaseLogger("dev")
+	useFixtures(t)
+
+	results := scrape(t, scrapers.ParseKmtOfficialSite, scrapers.KmtSeedUrls, scrapers.KmtSelectors)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		require.NoError(t, result.Error, "Error in scraping result")
+		require.NotEmpty(t, result.Content.Title)
+		require.NotEmpty(t, result.Content.Contents, "Content should not be empty")
+		require.False(t, result.Content.Date.IsZero())
+	}
+}
+
+// archivePages and archiveArticles are the size of the archives served to the
+// scrapers in place of the recorded pages.
+const (
+	archivePages    = 3
+	archiveArticles = 10
+)
+
+// requireArchive checks that the results of an archive scrape are the
+// archivePages * archiveArticles press releases, each once, and that it took
+// no politeness sleeps.
+func requireArchive(t *testing.T, srv *fixtures.Server, results []scrapers.ScrapingResult, links []string, elapsed time.Duration) {
+	t.Helper()
+	require.Less(t, elapsed, 10*time.Second)
+	require.Len(t, results, len(links))
+
+	seen := map[string]bool{}
+	for _, result := range results {
+		require.NoError(t, result.Error, "Error in scraping result")
+		require.NotEmpty(t, result.Content.Contents, "Content should not be empty")
+		seen[result.Content.Link] = true
+	}
+	require.Len(t, seen, len(links))
+	for _, link := range links {
+		require.True(t, seen[link], link)
+		require.Equal(t, 1, srv.Hits(link))
+	}
+}
+
+func TestParseKMTArchive(t *testing.T) {
+	srv := useFixtures(t)
+
+	const list = "https://www.kmt.org.tw/search/label/%E6%96%B0%E8%81%9E%E7%A8%BF"
+	last := time.Date(2025, 6, 30, 12, 0, 0, 0, scrapers.DefaultTimeZone)
+	var links []string
+	for p := 1; p <= archivePages+1; p++ {
+		// the first page answers the seed, the others the next page token of
+		// the previous one
+		pageURL := list
+		if p > 1 {
+			pageURL = fmt.Sprintf(scrapers.KmtURLTmpl, url.QueryEscape(last.Format(time.RFC3339)), p)
+		}
+
+		var body strings.Builder
+		body.WriteString(`<html><body><div id="recentwork"><div id="Blog1">`)
+		for n := 1; p <= archivePages && n <= archiveArticles; n++ {
+			last = last.Add(-time.Hour)
+			link := fmt.Sprintf("https://www.kmt.org.tw/2025/06/blog-post_%d%02d.html", p, n)
+			links = append(links, link)
+			fmt.Fprintf(&body, `<div class="date-posts"><h3><a href="%s">第 %d 頁第 %d 則</a></h3>`+
+				`<i class="pdt"><abbr class="published" itemprop="datePublished" title="%s"></abbr></i></div>`,
+				link, p, n, last.Format(time.RFC3339))
+
+			require.NoError(t, srv.Add(link, fixtures.Page{Body: []byte(fmt.Sprintf(
+				`<html><body><div id="recentwork"><div id="Blog1"><div id="div1">`+
+					`<h3>第 %d 頁第 %d 則</h3><div class="post-body"><p>文傳會</p><p>內容 %d-%d</p></div>`+
+					`<div class="post-footer-line"><i class="pdt"><abbr class="published" title="%s"></abbr></i></div>`+
+					`</div></div></div></body></html>`, p, n, p, n, last.Format(time.RFC3339)))}))
+		}
+		body.WriteString(`</div></div></body></html>`)
+		require.NoError(t, srv.Add(pageURL, fixtures.Page{Body: []byte(body.String())}))
+	}
+
+	start := time.Now()
+	results := scrape(t, scrapers.ParseKmtOfficialSite,
+		[]string{fmt.Sprintf(scrapers.KmtURLTmpl, url.QueryEscape(time.Now().Format(time.RFC3339)), 1)},
+		scrapers.KmtSelectors)
+	requireArchive(t, srv, results, links, time.Since(start))
+	require.Equal(t, archivePages+1, srv.Hits(list))
+}
+
+func TestParseTPPArchive(t *testing.T) {
+	srv := useFixtures(t)
+
+	var links []string
+	for p := 1; p <= archivePages; p++ {
+		var body strings.Builder
+		body.WriteString(`<html><body><div class="news_list">`)
+		for n := 1; n <= archiveArticles; n++ {
+			link := fmt.Sprintf("https://www.tpp.org.tw/newsdetail/6%d%02d", p, n)
+			links = append(links, link)
+			fmt.Fprintf(&body, `<div class="list_frame"><a href="%s">第 %d 頁第 %d 則</a></div>`,
+				strings.TrimPrefix(link, "https://www.tpp.org.tw"), p, n)
+
+			require.NoError(t, srv.Add(link, fixtures.Page{Body: []byte(fmt.Sprintf(
+				`<html><body><div class="news_container"><p class="content_topic">第 %d 頁第 %d 則</p>`+
+					`<p class="content_date">2025/06/%02d</p><div class="content_description">內容 %d-%d</div>`+
+					`</div></body></html>`, p, n, n, p, n))}))
+		}
+		fmt.Fprintf(&body, `</div><div class="pages_container"><a href="/news?page=1">1</a>`+
+			`<a href="/news?page=%d">%d</a></div></body></html>`, archivePages, archivePages)
+
+		page := fixtures.Page{Body: []byte(body.String())}
+		require.NoError(t, srv.Add(fmt.Sprintf(scrapers.TppSeedUrls[0], p), page))
+		if p == 1 {
+			// the last page is read from the news page
+			require.NoError(t, srv.Add("https://www.tpp.org.tw/news", page))
+		}
+	}
+
+	start := time.Now()
+	results := scrape(t, scrapers.ParseTppOfficialSite, scrapers.TppSeedUrls, scrapers.TppSelectors)
+	requireArchive(t, srv, results, links, time.Since(start))
+}
+
+func TestParseDPPPressRelease(t *testing.T) {
+	srv := useFixtures(t)
+
+	results := scrape(t, scrapers.ParseDppOfficialSite, scrapers.DppSeedUrls, scrapers.DppSelectors)
+	require.Len(t, results, 3)
+	for _, result := range results {
+		require.NoError(t, result.Error, "Error in scraping result")
+		require.NotEmpty(t, result.Content.Title)
+		require.NotEmpty(t, result.Content.Contents, "Content should not be empty")
+	}
+	require.Equal(t, 1, srv.Hits("https://www.dpp.org.tw/media/contents/8"))
+	require.Zero(t, srv.Hits("https://www.dpp.org.tw/media/contents/7"), "the oldest press release is 8")
+}
+
+func TestParseTPPPressRelease(t *testing.T) {
+	useFixtures(t)
+
+	results := scrape(t, scrapers.ParseTppOfficialSite, scrapers.TppSeedUrls, scrapers.TppSelectors)
+	require.Len(t, results, 2)
+	for _, result := range results {
 		require.NoError(t, result.Error, "Error in scraping result")
-		require.NotEmpty(t, result.Content, "Content should not be empty")
-	}
-	require.NoError(t, err, "Failed to parse KMT press releases")
-}
-
-// func TestParseTPPPressRelease(t *testing.T) {
-// 	global.LoadConfigs(".env", "env", []string{"../../"})
-
-// 	c := make(chan scrapers.ScrapingResult)
-// 	exfns := make(map[string]struct{})
-// 	err := scrapers.ParseTppOfficialSite(
-// 		scrapers.TppSeedUrls,
-// 		scrapers.DefaultBreaks,
-// 		scrapers.TppSelectors,
-// 		scrapers.DefaultHeaders,
-// 		c,
-// 		exfns,
-// 	)
-
-// 	for result := range c {
-// 		require.NoError(t, result.Error, "Error in scraping result")
-// 		require.NotEmpty(t, result.Content, "Content should not be empty")
-// 	}
-// 	require.NoError(t, err, "Failed to parse TPP press releases")
-// }
+		require.NotEmpty(t, result.Content.Title)
+		require.NotEmpty(t, result.Content.Contents, "Content should not be empty")
+		require.Equal(t, scrapers.DefaultTimeZone, result.Content.Date.Location())
+	}
+}
+
+func TestParseYahooNews(t *testing.T) {
+	srv := fixtures.StartFixtureServer(t, global.FixturesConfig{})
+
+	link := "https://tw.news.yahoo.com/" + url.PathEscape("高齡換照年齡擬下修-73歲藍委-我是受害者-071647696.html")
+	resp, err := srv.Client().Get(link)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	result := scrapers.ParseYahooNewsResp(resp)
+	require.Nil(t, result.Error)
+	require.Equal(t, "高齡換照年齡擬下修73歲藍委：我是受害者", result.Article.Title)
+	require.Equal(t, "中央社", result.Article.Publisher)
+	require.Equal(t, []string{"交通部", "國民黨", "換照", "高齡駕駛"}, result.Article.Keywords)
+	require.Len(t, result.Article.Content, 3, "the related news paragraph is skipped")
+	require.True(t, result.Article.Modified.After(result.Article.Published))
+	require.NotEmpty(t, result.Article.ID)
+}
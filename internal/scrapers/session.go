@@ -0,0 +1,524 @@
+package scrapers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly/v2"
+)
+
+// DefaultSessionTimeout is the time given to establish or refresh a session.
+const DefaultSessionTimeout = 30 * time.Second
+
+var (
+	// ErrSessionExpired is the error of the requests of a collector whose
+	// session expired and could not be refreshed, or expired again right after
+	// being refreshed.
+	ErrSessionExpired = errors.New("session expired")
+	ErrLoginFailed    = errors.New("login failed")
+)
+
+// Sessions are the sessions of the sites putting their press releases behind
+// one, by the party of the site: kmt, dpp or tpp. They are set once at
+// startup, the collectors of a site without a session make plain requests.
+var Sessions = map[string]SessionProvider{}
+
+// SessionProvider establishes the session of a site, the cookies and the
+// headers every request to the site carries.
+type SessionProvider interface {
+	// Establish establishes a new session with client.
+	Establish(ctx context.Context, client *http.Client) (cookies []*http.Cookie, headers map[string]string, err error)
+	// Refresh establishes the session again once it expired.
+	Refresh(ctx context.Context, client *http.Client) (cookies []*http.Cookie, headers map[string]string, err error)
+	// IsLoginPage reports whether the site answered a request for u with its
+	// login page rather than the requested one, as it does once the session
+	// expired. body is nil for a redirect.
+	IsLoginPage(u *url.URL, statusCode int, body []byte) bool
+}
+
+// LoginMarker tells the login page of a site apart. A page is the login page
+// if it is answered with a 401, is under Path, matches Selector or contains
+// Text. Empty fields are not checked.
+type LoginMarker struct {
+	Path     string
+	Selector string
+	Text     string
+}
+
+// IsLoginPage reports whether the page of u answered with statusCode and body
+// is the login page.
+func (m LoginMarker) IsLoginPage(u *url.URL, statusCode int, body []byte) bool {
+	if statusCode == http.StatusUnauthorized {
+		return true
+	}
+
+	if m.Path != "" && u != nil && strings.HasPrefix(u.Path, m.Path) {
+		return true
+	}
+
+	if len(body) == 0 {
+		return false
+	}
+
+	if m.Text != "" && bytes.Contains(body, []byte(m.Text)) {
+		return true
+	}
+
+	if m.Selector != "" {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+		if err == nil && doc.Find(m.Selector).Length() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WarmupSession is the session set by the cookies of a landing page.
+type WarmupSession struct {
+	LoginMarker
+	landing *url.URL
+	headers map[string]string
+}
+
+// NewWarmupSession creates a WarmupSession visiting landingURL, the requests
+// to the site carry headers on top of the cookies.
+func NewWarmupSession(landingURL string, marker LoginMarker, headers map[string]string) (*WarmupSession, error) {
+	u, err := url.Parse(landingURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse landing url: %w", err)
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("landing url should be absolute: %s", landingURL)
+	}
+
+	return &WarmupSession{LoginMarker: marker, landing: u, headers: headers}, nil
+}
+
+// Establish visits the landing page and returns the cookies it set.
+func (w *WarmupSession) Establish(ctx context.Context, client *http.Client) ([]*http.Cookie, map[string]string, error) {
+	c, jar := withJar(client)
+	if err := visit(ctx, c, w.landing); err != nil {
+		return nil, nil, err
+	}
+
+	cookies := jar.Cookies(siteURL(w.landing))
+	if len(cookies) == 0 {
+		return nil, nil, fmt.Errorf("landing page %s set no cookies", w.landing)
+	}
+	return cookies, w.headers, nil
+}
+
+// Refresh visits the landing page again.
+func (w *WarmupSession) Refresh(ctx context.Context, client *http.Client) ([]*http.Cookie, map[string]string, error) {
+	return w.Establish(ctx, client)
+}
+
+// FormLoginOptions configures a FormLoginSession.
+type FormLoginOptions struct {
+	Marker LoginMarker
+	// LandingURL, if set, is visited before the login for the cookies the form
+	// expects.
+	LandingURL    string
+	LoginURL      string
+	UsernameField string
+	PasswordField string
+	// CredentialsFile is the JSON file of the username and the password, it is
+	// read on every login so that the credentials can be rotated.
+	CredentialsFile string
+	Headers         map[string]string
+}
+
+// credentials are the credentials of a FormLoginSession, they are never
+// logged nor put in an error.
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// FormLoginSession is the session set by posting the credentials to a login
+// form.
+type FormLoginSession struct {
+	LoginMarker
+	landing *url.URL
+	login   *url.URL
+	opts    FormLoginOptions
+}
+
+// NewFormLoginSession creates a FormLoginSession.
+func NewFormLoginSession(opts FormLoginOptions) (*FormLoginSession, error) {
+	login, err := url.Parse(opts.LoginURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse login url: %w", err)
+	}
+
+	if login.Scheme == "" || login.Host == "" {
+		return nil, fmt.Errorf("login url should be absolute: %s", opts.LoginURL)
+	}
+
+	var landing *url.URL
+	if opts.LandingURL != "" {
+		if landing, err = url.Parse(opts.LandingURL); err != nil {
+			return nil, fmt.Errorf("failed to parse landing url: %w", err)
+		}
+	}
+
+	if opts.CredentialsFile == "" {
+		return nil, fmt.Errorf("credentials file should not be empty")
+	}
+
+	if opts.UsernameField == "" {
+		opts.UsernameField = "username"
+	}
+
+	if opts.PasswordField == "" {
+		opts.PasswordField = "password"
+	}
+
+	return &FormLoginSession{
+		LoginMarker: opts.Marker,
+		landing:     landing,
+		login:       login,
+		opts:        opts,
+	}, nil
+}
+
+// Establish posts the credentials to the login form and returns the cookies
+// of the session. It fails with ErrLoginFailed if the site answers with the
+// login page again.
+func (f *FormLoginSession) Establish(ctx context.Context, client *http.Client) ([]*http.Cookie, map[string]string, error) {
+	cred, err := f.credentials()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, jar := withJar(client)
+	if f.landing != nil {
+		if err := visit(ctx, c, f.landing); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	form := url.Values{}
+	form.Set(f.opts.UsernameField, cred.Username)
+	form.Set(f.opts.PasswordField, cred.Password)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.login.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create login request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for key, value := range f.opts.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to post login form: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(resp.Body); err != nil {
+		return nil, nil, fmt.Errorf("failed to read login response: %w", err)
+	}
+
+	// a failed login serves the form again
+	if resp.StatusCode >= http.StatusBadRequest || f.IsLoginPage(resp.Request.URL, resp.StatusCode, body.Bytes()) {
+		return nil, nil, fmt.Errorf("%w: status code %d", ErrLoginFailed, resp.StatusCode)
+	}
+
+	cookies := jar.Cookies(siteURL(f.login))
+	if len(cookies) == 0 {
+		return nil, nil, fmt.Errorf("%w: no session cookies set", ErrLoginFailed)
+	}
+	return cookies, f.opts.Headers, nil
+}
+
+// Refresh logs in again.
+func (f *FormLoginSession) Refresh(ctx context.Context, client *http.Client) ([]*http.Cookie, map[string]string, error) {
+	return f.Establish(ctx, client)
+}
+
+func (f *FormLoginSession) credentials() (credentials, error) {
+	var cred credentials
+	b, err := os.ReadFile(f.opts.CredentialsFile)
+	if err != nil {
+		return cred, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &cred); err != nil {
+		// the error of the decoder may quote the file
+		return cred, fmt.Errorf("failed to parse credentials file %s", f.opts.CredentialsFile)
+	}
+
+	if cred.Username == "" || cred.Password == "" {
+		return cred, fmt.Errorf("credentials file %s should hold a username and a password", f.opts.CredentialsFile)
+	}
+	return cred, nil
+}
+
+// NewSessionProvider creates the SessionProvider of cfg.
+func NewSessionProvider(cfg global.SessionConfig) (SessionProvider, error) {
+	marker := LoginMarker{
+		Path:     cfg.LoginPath,
+		Selector: cfg.LoginSelector,
+		Text:     cfg.LoginMarker,
+	}
+
+	switch cfg.Kind {
+	case "warmup":
+		return NewWarmupSession(cfg.LandingURL, marker, cfg.Headers)
+	case "form":
+		return NewFormLoginSession(FormLoginOptions{
+			Marker:          marker,
+			LandingURL:      cfg.LandingURL,
+			LoginURL:        cfg.LoginURL,
+			UsernameField:   cfg.UsernameField,
+			PasswordField:   cfg.PasswordField,
+			CredentialsFile: cfg.CredentialsFile,
+			Headers:         cfg.Headers,
+		})
+	default:
+		return nil, fmt.Errorf("unknown session kind: %q", cfg.Kind)
+	}
+}
+
+// NewSessions creates the sessions of cfgs by party, to be set as Sessions.
+func NewSessions(cfgs map[string]global.SessionConfig) (map[string]SessionProvider, error) {
+	sessions := make(map[string]SessionProvider, len(cfgs))
+	for party, cfg := range cfgs {
+		session, err := NewSessionProvider(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create session of %s: %w", party, err)
+		}
+		sessions[party] = session
+	}
+	return sessions, nil
+}
+
+// withJar returns a copy of client keeping the cookies in a new jar.
+func withJar(client *http.Client) (*http.Client, *cookiejar.Jar) {
+	jar, _ := cookiejar.New(nil)
+	c := *client
+	c.Jar = jar
+	return &c, jar
+}
+
+// visit gets the page of u, following its redirects.
+func visit(ctx context.Context, client *http.Client, u *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to visit %s: %w", u, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to visit %s: status code %d", u, resp.StatusCode)
+	}
+	return nil
+}
+
+// siteURL returns the root of the site of u, the cookies of the site are
+// those of its root.
+func siteURL(u *url.URL) *url.URL {
+	return &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}
+}
+
+// sessionRequest is the state of a request of a collector with a session, in
+// the context of the request under sessionKey(ID).
+type sessionRequest struct {
+	// link is the requested URL, the URL of the request is that of the login
+	// page after a redirect to it.
+	link string
+	// gen is the generation of the session the request carries.
+	gen int
+}
+
+func sessionKey(id uint32) string {
+	return fmt.Sprintf("session:%d", id)
+}
+
+// collectorSession is the session of a collector. The requests wait while it
+// is established or refreshed.
+type collectorSession struct {
+	provider SessionProvider
+	client   *http.Client
+
+	mu      sync.RWMutex
+	cookie  string
+	headers map[string]string
+	// gen is incremented on every refresh, 0 until the session is
+	// established.
+	gen int
+	// retried are the links retried after a refresh.
+	retried map[string]bool
+	// err is the error of the session once given up on, every request fails
+	// with it.
+	err error
+}
+
+func newCollectorSession(provider SessionProvider) *collectorSession {
+	return &collectorSession{
+		provider: provider,
+		client:   httpClient(),
+		retried:  map[string]bool{},
+	}
+}
+
+// set sets the cookies and the headers of the session, s.mu is held.
+func (s *collectorSession) set(cookies []*http.Cookie, headers map[string]string) {
+	pairs := make([]string, len(cookies))
+	for i, c := range cookies {
+		pairs[i] = (&http.Cookie{Name: c.Name, Value: c.Value}).String()
+	}
+	s.cookie = strings.Join(pairs, "; ")
+	s.headers = headers
+	s.gen++
+}
+
+// attach attaches the session to r, establishing it first.
+func (s *collectorSession) attach(r *colly.Request) error {
+	s.mu.RLock()
+	gen, err := s.gen, s.err
+	s.mu.RUnlock()
+
+	if gen == 0 && err == nil {
+		s.mu.Lock()
+		if s.gen == 0 && s.err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultSessionTimeout)
+			cookies, headers, err := s.provider.Establish(ctx, s.client)
+			cancel()
+			if err != nil {
+				s.err = fmt.Errorf("failed to establish session: %w", err)
+			} else {
+				s.set(cookies, headers)
+				global.Logger.Info().
+					Str("domain", r.URL.Hostname()).
+					Int("cookies", len(cookies)).
+					Msg("Session established")
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return s.err
+	}
+
+	r.Ctx.Put(sessionKey(r.ID), sessionRequest{link: r.URL.String(), gen: s.gen})
+	for key, value := range s.headers {
+		r.Headers.Set(key, value)
+	}
+	if s.cookie != "" {
+		r.Headers.Set("Cookie", s.cookie)
+	}
+	return nil
+}
+
+// refresh refreshes the session expired for req, unless a request of the same
+// session already did. It gives up on the session if it expired again for a
+// link retried after a refresh.
+func (s *collectorSession) refresh(req sessionRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+
+	if s.retried[req.link] {
+		s.err = fmt.Errorf("%w: login page served again after refreshing the session", ErrSessionExpired)
+		return s.err
+	}
+
+	if s.gen == req.gen {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultSessionTimeout)
+		defer cancel()
+		cookies, headers, err := s.provider.Refresh(ctx, s.client)
+		if err != nil {
+			s.err = fmt.Errorf("%w: failed to refresh session: %w", ErrSessionExpired, err)
+			return s.err
+		}
+		s.set(cookies, headers)
+		global.Logger.Info().
+			Str("link", req.link).
+			Int("cookies", len(cookies)).
+			Msg("Session refreshed")
+	}
+	s.retried[req.link] = true
+	return nil
+}
+
+// expired handles the login page served for r: the session is refreshed and
+// r retried, otherwise a ScrapingResult warning of the login page and failing
+// with the error of the session is sent to output.
+func (s *collectorSession) expired(r *colly.Request, output chan<- ScrapingResult) {
+	req, ok := r.Ctx.GetAny(sessionKey(r.ID)).(sessionRequest)
+	if !ok {
+		req = sessionRequest{link: r.URL.String()}
+	}
+
+	global.Logger.Warn().
+		Str("link", req.link).
+		Str("login", r.URL.String()).
+		Msg("Login page served, session expired")
+
+	err := s.refresh(req)
+	if err == nil {
+		// a redirect moved the request to the login page
+		if r.URL, err = url.Parse(req.link); err == nil {
+			// the retried request passed the checks of the collector once,
+			// its failure has been reported by the callbacks as any other
+			if err := r.Retry(); err != nil {
+				global.Logger.Debug().
+					Err(err).
+					Str("link", req.link).
+					Msg("Retried request failed")
+			}
+			return
+		}
+	}
+
+	global.Logger.Error().
+		Err(err).
+		Str("link", req.link).
+		Msg("Giving up on the session")
+	output <- ScrapingResult{
+		Content:  Content{Link: req.link},
+		Error:    err,
+		Warnings: []string{fmt.Sprintf("login page served in place of %s", req.link)},
+	}
+}
+
+// redirectTarget returns the URL of the redirect err failed to follow, nil if
+// err is not such an error.
+func redirectTarget(err error) *url.URL {
+	var uErr *url.Error
+	if !errors.As(err, &uErr) {
+		return nil
+	}
+
+	u, pErr := url.Parse(uErr.URL)
+	if pErr != nil {
+		return nil
+	}
+	return u
+}
@@ -0,0 +1,316 @@
+package scrapers_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/gocolly/colly/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// loginPage is the login page of the gated site, its markup matches the
+// content selector as a press release does.
+const loginPage = `<html><body><article><h1>會員登入</h1><p>請先登入會員</p>` +
+	`<form id="login" method="post" action="/login"><input name="account"><input name="secret"></form>` +
+	`</article></body></html>`
+
+var gatedMarker = scrapers.LoginMarker{Path: "/login", Selector: "form#login"}
+
+// gatedSite serves its press releases to the requests carrying a valid session
+// cookie, and its login page to the other ones as the login mode says.
+type gatedSite struct {
+	mu sync.Mutex
+	// mode is how the login page is served: "page" in place of the press
+	// release, "redirect" by a redirect to /login or "unauthorized" with a
+	// 401.
+	mode string
+	// expireAfter is the number of press releases served before the sessions
+	// expire, never if zero.
+	expireAfter int
+	// broken makes the sessions established after the first one invalid.
+	broken bool
+
+	tokens   map[string]bool
+	issued   int
+	served   int
+	landings int
+	logins   int
+}
+
+func newGatedSite(mode string) *gatedSite {
+	return &gatedSite{mode: mode, tokens: map[string]bool{}}
+}
+
+// issue sets a new session cookie, s.mu is held.
+func (s *gatedSite) issue(w http.ResponseWriter) {
+	s.issued++
+	token := fmt.Sprintf("token-%d", s.issued)
+	if !s.broken || s.issued == 1 {
+		s.tokens[token] = true
+	}
+	http.SetCookie(w, &http.Cookie{Name: "sid", Value: token, Path: "/"})
+}
+
+func (s *gatedSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/":
+		s.landings++
+		s.issue(w)
+		fmt.Fprint(w, `<html><body><p>歡迎</p></body></html>`)
+	case r.URL.Path == "/login" && r.Method == http.MethodPost:
+		s.logins++
+		if r.FormValue("account") != "editor" || r.FormValue("secret") != "hunter2" {
+			fmt.Fprint(w, loginPage)
+			return
+		}
+		s.issue(w)
+		http.Redirect(w, r, "/", http.StatusFound)
+	case r.URL.Path == "/login":
+		fmt.Fprint(w, loginPage)
+	case strings.HasPrefix(r.URL.Path, "/news/"):
+		if c, err := r.Cookie("sid"); err != nil || !s.tokens[c.Value] {
+			switch s.mode {
+			case "redirect":
+				http.Redirect(w, r, "/login", http.StatusFound)
+			case "unauthorized":
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, loginPage)
+			default:
+				fmt.Fprint(w, loginPage)
+			}
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/news/")
+		fmt.Fprintf(w, `<html><body><article><h1>新聞稿 %s</h1><p>內容 %s</p></article></body></html>`, id, id)
+		if s.served++; s.served == s.expireAfter {
+			clear(s.tokens)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// crawl visits n press releases of srv with a collector of session and
+// returns the results.
+func crawl(t *testing.T, srv *httptest.Server, session scrapers.SessionProvider, async bool, n int) []scrapers.ScrapingResult {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	output := make(chan scrapers.ScrapingResult, 2*n)
+	c := scrapers.NewCollector(u.Hostname(), 1, async, nil, scrapers.Delay{Parallelism: 2},
+		map[string]string{}, output, map[string]struct{}{}, session)
+	c.OnHTML("article", func(e *colly.HTMLElement) {
+		output <- scrapers.ScrapingResult{Content: scrapers.Content{
+			Title:    e.ChildText("h1"),
+			Link:     e.Request.URL.String(),
+			Contents: e.ChildTexts("p"),
+		}}
+	})
+
+	for i := 1; i <= n; i++ {
+		// the failed requests have been reported by the collector
+		_ = c.Visit(fmt.Sprintf("%s/news/%d", srv.URL, i))
+	}
+	c.Wait()
+	close(output)
+
+	var results []scrapers.ScrapingResult
+	for result := range output {
+		require.NotContains(t, strings.Join(result.Content.Contents, ""), "請先登入",
+			"login page parsed as %s", result.Content.Link)
+		require.NotEqual(t, "會員登入", result.Content.Title)
+		results = append(results, result)
+	}
+	return results
+}
+
+// requireContents checks that results are the n press releases of srv, each
+// once.
+func requireContents(t *testing.T, srv *httptest.Server, results []scrapers.ScrapingResult, n int) {
+	t.Helper()
+	require.Len(t, results, n)
+	seen := map[string]bool{}
+	for _, result := range results {
+		require.NoError(t, result.Error)
+		require.Empty(t, result.Warnings)
+		seen[result.Content.Link] = true
+	}
+	for i := 1; i <= n; i++ {
+		require.True(t, seen[fmt.Sprintf("%s/news/%d", srv.URL, i)], i)
+	}
+}
+
+func TestCollectorWithoutSession(t *testing.T) {
+	srv := httptest.NewServer(newGatedSite("page"))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	// nothing tells the login page apart, it is parsed as a press release
+	output := make(chan scrapers.ScrapingResult, 1)
+	c := scrapers.NewCollector(u.Hostname(), 1, false, nil, scrapers.Delay{},
+		map[string]string{}, output, map[string]struct{}{}, nil)
+	c.OnHTML("article", func(e *colly.HTMLElement) {
+		output <- scrapers.ScrapingResult{Content: scrapers.Content{Title: e.ChildText("h1")}}
+	})
+	require.NoError(t, c.Visit(srv.URL+"/news/1"))
+	require.Equal(t, "會員登入", (<-output).Content.Title)
+}
+
+func TestCollectorSession(t *testing.T) {
+	global.InitBaseLogger("dev")
+
+	for _, mode := range []string{"page", "redirect", "unauthorized"} {
+		t.Run(mode, func(t *testing.T) {
+			t.Run("warmup", func(t *testing.T) {
+				site := newGatedSite(mode)
+				srv := httptest.NewServer(site)
+				defer srv.Close()
+
+				session, err := scrapers.NewWarmupSession(srv.URL+"/", gatedMarker, nil)
+				require.NoError(t, err)
+				requireContents(t, srv, crawl(t, srv, session, false, 5), 5)
+				require.Equal(t, 1, site.landings)
+			})
+
+			for _, async := range []bool{false, true} {
+				t.Run(fmt.Sprintf("refresh async=%t", async), func(t *testing.T) {
+					site := newGatedSite(mode)
+					site.expireAfter = 2
+					srv := httptest.NewServer(site)
+					defer srv.Close()
+
+					session, err := scrapers.NewWarmupSession(srv.URL+"/", gatedMarker, nil)
+					require.NoError(t, err)
+					requireContents(t, srv, crawl(t, srv, session, async, 6), 6)
+					require.Equal(t, 2, site.landings, "the session is refreshed once")
+				})
+			}
+
+			t.Run("abort", func(t *testing.T) {
+				site := newGatedSite(mode)
+				site.expireAfter, site.broken = 2, true
+				srv := httptest.NewServer(site)
+				defer srv.Close()
+
+				session, err := scrapers.NewWarmupSession(srv.URL+"/", gatedMarker, nil)
+				require.NoError(t, err)
+				results := crawl(t, srv, session, false, 5)
+				require.Len(t, results, 5)
+				require.Equal(t, 2, site.landings)
+
+				var contents, warnings int
+				for _, result := range results {
+					if result.Error == nil {
+						contents++
+						continue
+					}
+					require.ErrorIs(t, result.Error, scrapers.ErrSessionExpired)
+					if len(result.Warnings) > 0 {
+						warnings++
+						require.Equal(t, srv.URL+"/news/3", result.Content.Link)
+					}
+				}
+				require.Equal(t, 2, contents)
+				require.Equal(t, 1, warnings, "the login page of the press release 3 is reported")
+			})
+		})
+	}
+}
+
+// writeCredentials writes the credentials file of a form login.
+func writeCredentials(t *testing.T, username, password string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, os.WriteFile(path,
+		[]byte(fmt.Sprintf(`{"username": %q, "password": %q}`, username, password)), 0o600))
+	return path
+}
+
+func TestFormLoginSession(t *testing.T) {
+	global.InitBaseLogger("dev")
+	site := newGatedSite("redirect")
+	site.expireAfter = 3
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	opts := scrapers.FormLoginOptions{
+		Marker:          gatedMarker,
+		LoginURL:        srv.URL + "/login",
+		UsernameField:   "account",
+		PasswordField:   "secret",
+		CredentialsFile: writeCredentials(t, "editor", "hunter2"),
+	}
+	session, err := scrapers.NewFormLoginSession(opts)
+	require.NoError(t, err)
+	requireContents(t, srv, crawl(t, srv, session, false, 5), 5)
+	require.Equal(t, 2, site.logins)
+
+	// the password of a failed login is not in the error
+	opts.CredentialsFile = writeCredentials(t, "editor", "wrong-password")
+	session, err = scrapers.NewFormLoginSession(opts)
+	require.NoError(t, err)
+	_, _, err = session.Establish(t.Context(), &http.Client{Timeout: time.Second})
+	require.ErrorIs(t, err, scrapers.ErrLoginFailed)
+	require.NotContains(t, err.Error(), "wrong-password")
+	require.Contains(t, err.Error(), "status code 200", "the form is served again")
+
+	results := crawl(t, srv, session, false, 2)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		require.ErrorIs(t, result.Error, scrapers.ErrLoginFailed)
+		require.NotContains(t, result.Error.Error(), "wrong-password")
+	}
+
+	opts.CredentialsFile = filepath.Join(t.TempDir(), "missing.json")
+	session, err = scrapers.NewFormLoginSession(opts)
+	require.NoError(t, err)
+	_, _, err = session.Establish(t.Context(), &http.Client{Timeout: time.Second})
+	require.Error(t, err)
+}
+
+func TestNewSessionProvider(t *testing.T) {
+	session, err := scrapers.NewSessionProvider(global.SessionConfig{
+		Kind:        "warmup",
+		LandingURL:  "https://www.kmt.org.tw/",
+		LoginMarker: "請先登入",
+	})
+	require.NoError(t, err)
+	require.IsType(t, &scrapers.WarmupSession{}, session)
+	require.True(t, session.IsLoginPage(nil, http.StatusOK, []byte(loginPage)))
+	require.False(t, session.IsLoginPage(nil, http.StatusOK, []byte("<p>內容</p>")))
+
+	session, err = scrapers.NewSessionProvider(global.SessionConfig{
+		Kind:            "form",
+		LoginURL:        "https://www.kmt.org.tw/login",
+		CredentialsFile: "credentials.json",
+		LoginPath:       "/login",
+	})
+	require.NoError(t, err)
+	require.IsType(t, &scrapers.FormLoginSession{}, session)
+	require.True(t, session.IsLoginPage(&url.URL{Path: "/login"}, http.StatusOK, nil))
+	require.True(t, session.IsLoginPage(&url.URL{Path: "/news"}, http.StatusUnauthorized, nil))
+
+	_, err = scrapers.NewSessionProvider(global.SessionConfig{Kind: "oauth"})
+	require.Error(t, err)
+
+	_, err = scrapers.NewSessionProvider(global.SessionConfig{Kind: "warmup", LandingURL: "/"})
+	require.Error(t, err)
+	require.False(t, errors.Is(err, scrapers.ErrSessionExpired))
+}
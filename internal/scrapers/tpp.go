@@ -6,7 +6,6 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
-	"math/rand/v2"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -72,10 +71,12 @@ func ParseTppOfficialSite(urls []string, breaks Delay, selectors SiteSelectors,
 		regexp.MustCompile(`^https:\/\/www\.tpp\.org\.tw\/newsdetail\/\d{4}$`),
 		regexp.MustCompile(`^https:\/\/www\.tpp\.org\.tw\/news.*`),
 	}
-	collector := NewCollector("www.tpp.org.tw", 2, true, filters,
-		breaks, headers, output, files)
+	lists := NewCollector("www.tpp.org.tw", 1, false, filters,
+		breaks, headers, output, files, Sessions["tpp"])
+	details := NewCollector("www.tpp.org.tw", 1, true, filters,
+		breaks, headers, output, files, Sessions["tpp"])
 
-	collector.OnHTML(
+	details.OnHTML(
 		selectors.ContentContainerSelector,
 		func(e *colly.HTMLElement) {
 			content := Content{}
@@ -163,7 +164,10 @@ func ParseTppOfficialSite(urls []string, breaks Delay, selectors SiteSelectors,
 		},
 	)
 
-	collector.OnHTML(
+	// the links of the list page being visited, the list collector is
+	// synchronous
+	var links []string
+	lists.OnHTML(
 		selectors.HrefSelector,
 		func(e *colly.HTMLElement) {
 			var link string
@@ -190,36 +194,32 @@ func ParseTppOfficialSite(urls []string, breaks Delay, selectors SiteSelectors,
 				}
 				return
 			}
-
-			e.Request.Visit(e.Request.AbsoluteURL(link))
-			sleep := time.Duration(rand.Int64N(int64(breaks.DelayTimeRng))) + breaks.MinDelayTime
-			global.Logger.Debug().
-				Int64("duration", int64(sleep/time.Second)).
-				Str("link", link).
-				Msg("[VisitLoop] Taking a break before visiting next link")
-			time.Sleep(sleep)
+			links = append(links, e.Request.AbsoluteURL(link))
 		},
 	)
 
 	for i := 1; i <= total; i++ {
-		// if i%10 == 0 {
-		// 	delay := breaks.LongBreakMinTime + time.Duration(rand.IntN(int(breaks.LongBreakRandomRange.Seconds())))*time.Second
-		// 	global.Logger.Info().
-		// 		Int("page", i).
-		// 		Dur("delay", delay).
-		// 		Msg("Taking a long break before visiting next page")
-		// 	time.Sleep(delay)
-		// }
-		err := collector.Visit(fmt.Sprintf(TppSeedUrls[0], i))
+		links = nil
+		err := lists.Visit(fmt.Sprintf(TppSeedUrls[0], i))
 		if err != nil {
 			global.Logger.Error().
 				Err(err).
 				Str("seed_url", fmt.Sprintf(TppSeedUrls[0], i)).
 				Msg("Failed to visit Seed URL")
+			details.Wait()
 			return err
 		}
+
+		for _, link := range links {
+			if err := details.Visit(link); err != nil && !strings.Contains(err.Error(), "already visited") {
+				global.Logger.Error().
+					Err(err).
+					Str("link", link).
+					Msg("Failed to visit link")
+			}
+		}
 	}
-	collector.Wait()
+	details.Wait()
 	return nil
 }
 
@@ -238,7 +238,7 @@ func retrieveTppLastPage(u string, headers map[string]string) (int, error) {
 		req.Header.Set(key, value)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient().Do(req)
 	if err != nil {
 		return 0, errors.New(
 			http.StatusInternalServerError,
@@ -62,6 +62,15 @@ const (
 	DateModifiedTag  = "dateModified"
 )
 
+// DeclaredModified returns the modification time declared by the publisher,
+// and false if it declares none, Modified falling back to Published then.
+func (a YahooNewsArticle) DeclaredModified() (time.Time, bool) {
+	if a.Modified.IsZero() || a.Modified.Equal(a.Published) {
+		return time.Time{}, false
+	}
+	return a.Modified, true
+}
+
 func Hashing(url string, result *YahooNewsParseResult) string {
 	hasher := md5.New()
 	hasher.Write([]byte(url))
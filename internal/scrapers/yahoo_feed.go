@@ -0,0 +1,332 @@
+package scrapers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxFeedSize is the largest feed read, the rest is dropped.
+const MaxFeedSize = 10 << 20
+
+// ErrUnknownFeedFormat is returned for a feed which is neither a news sitemap
+// nor an RSS feed.
+var ErrUnknownFeedFormat = errors.New("unknown feed format")
+
+// FeedEntry is an article listed by a news feed.
+type FeedEntry struct {
+	URL       string
+	Title     string
+	Published time.Time // zero if the feed does not tell
+	// Keywords are the news keywords of a sitemap entry, or the categories of
+	// an RSS item.
+	Keywords []string
+}
+
+// feedTimeFormats are the time formats found in the sitemaps and the RSS
+// feeds, tried in order.
+var feedTimeFormats = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02T15:04Z07:00",
+	time.DateOnly,
+}
+
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedTimeFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// the tags are matched on their local name, whatever their namespace.
+type sitemapURLSet struct {
+	URLs []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+		News    struct {
+			PublicationDate string `xml:"publication_date"`
+			Title           string `xml:"title"`
+			Keywords        string `xml:"keywords"`
+		} `xml:"news"`
+	} `xml:"url"`
+}
+
+type rssFeed struct {
+	Items []struct {
+		Title      string   `xml:"title"`
+		Link       string   `xml:"link"`
+		PubDate    string   `xml:"pubDate"`
+		Categories []string `xml:"category"`
+	} `xml:"channel>item"`
+}
+
+// ParseYahooFeed parses the entries of a Yahoo news sitemap, i.e. a sitemap
+// with the news extension, or of a Yahoo RSS feed. The entries without a URL
+// are dropped.
+func ParseYahooFeed(data []byte) ([]FeedEntry, error) {
+	root, err := feedRoot(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []FeedEntry
+	switch root {
+	case "urlset":
+		var set sitemapURLSet
+		if err := xml.Unmarshal(data, &set); err != nil {
+			return nil, fmt.Errorf("failed to parse sitemap: %w", err)
+		}
+
+		for _, u := range set.URLs {
+			if strings.TrimSpace(u.Loc) == "" {
+				continue
+			}
+
+			published := parseFeedTime(u.News.PublicationDate)
+			if published.IsZero() {
+				published = parseFeedTime(u.LastMod)
+			}
+
+			entries = append(entries, FeedEntry{
+				URL:       strings.TrimSpace(u.Loc),
+				Title:     strings.TrimSpace(u.News.Title),
+				Published: published,
+				Keywords:  splitKeywords(u.News.Keywords),
+			})
+		}
+	case "rss":
+		var feed rssFeed
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return nil, fmt.Errorf("failed to parse rss: %w", err)
+		}
+
+		for _, item := range feed.Items {
+			if strings.TrimSpace(item.Link) == "" {
+				continue
+			}
+
+			var keywords []string
+			for _, c := range item.Categories {
+				keywords = append(keywords, splitKeywords(c)...)
+			}
+
+			entries = append(entries, FeedEntry{
+				URL:       strings.TrimSpace(item.Link),
+				Title:     strings.TrimSpace(item.Title),
+				Published: parseFeedTime(item.PubDate),
+				Keywords:  keywords,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("%w: root element <%s>", ErrUnknownFeedFormat, root)
+	}
+	return entries, nil
+}
+
+// feedRoot returns the local name of the root element of data.
+func feedRoot(data []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrUnknownFeedFormat, err)
+		}
+
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// splitKeywords splits the comma separated keywords of s, either half or full
+// width commas.
+func splitKeywords(s string) []string {
+	var keywords []string
+	for _, k := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '，' || r == '、'
+	}) {
+		if k = strings.TrimSpace(k); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	return keywords
+}
+
+// feedValidators are the validators of the last response of a feed.
+type feedValidators struct {
+	etag         string
+	lastModified string
+}
+
+// FeedFetcher fetches the news feeds with conditional requests: the ETag and
+// the Last-Modified of the last response of a feed are sent back, so an
+// unchanged feed costs the host a 304. The requests take the per-host slots
+// and breaks of a LinkChecker, so that the feeds and the liveness checks of a
+// process are polite to a host together.
+type FeedFetcher struct {
+	checker *LinkChecker
+
+	mu         sync.Mutex
+	validators map[string]feedValidators
+}
+
+// NewFeedFetcher creates a FeedFetcher sending its requests through checker.
+func NewFeedFetcher(checker *LinkChecker) *FeedFetcher {
+	return &FeedFetcher{
+		checker:    checker,
+		validators: map[string]feedValidators{},
+	}
+}
+
+// Fetch fetches and parses the feed at rawURL. It returns modified false and
+// no entries if the feed has not changed since the last fetch.
+func (f *FeedFetcher) Fetch(ctx context.Context, rawURL string) (entries []FeedEntry, modified bool, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	slot := f.checker.slot(strings.ToLower(u.Hostname()))
+	select {
+	case slot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	defer func() {
+		f.checker.pause(ctx)
+		<-slot
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, value := range f.checker.headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Accept", "application/rss+xml,application/xml;q=0.9,text/xml;q=0.8,*/*;q=0.5")
+	req.Header.Del("Cache-Control")
+
+	f.mu.Lock()
+	v := f.validators[rawURL]
+	f.mu.Unlock()
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+
+	resp, err := f.checker.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, false, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("failed to fetch feed: unexpected status code %d", resp.StatusCode)
+	}
+
+	reader := io.Reader(resp.Body)
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, MaxFeedSize))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read feed: %w", err)
+	}
+
+	if entries, err = ParseYahooFeed(data); err != nil {
+		return nil, false, err
+	}
+
+	// the validators are only kept once the feed has been parsed, a broken
+	// feed is fetched in full again next time
+	f.mu.Lock()
+	f.validators[rawURL] = feedValidators{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+	f.mu.Unlock()
+	return entries, true, nil
+}
+
+// RefetchYahooNews fetches the Yahoo news article at rawURL again, to check it
+// for the updates of its publisher. The request takes the per-host slots and
+// breaks of checker. A non-zero modifiedSince is sent as If-Modified-Since,
+// and modified is false if the host answers that the article has not changed
+// since.
+func RefetchYahooNews(ctx context.Context, checker *LinkChecker, rawURL string,
+	modifiedSince time.Time) (article YahooNewsArticle, modified bool, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return article, false, fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	slot := checker.slot(strings.ToLower(u.Hostname()))
+	select {
+	case slot <- struct{}{}:
+	case <-ctx.Done():
+		return article, false, ctx.Err()
+	}
+	defer func() {
+		checker.pause(ctx)
+		<-slot
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return article, false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, value := range checker.headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Del("Cache-Control")
+
+	if !modifiedSince.IsZero() {
+		req.Header.Set("If-Modified-Since", modifiedSince.UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := checker.client.Do(req)
+	if err != nil {
+		return article, false, fmt.Errorf("failed to fetch article: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return article, false, nil
+	}
+
+	result := ParseYahooNewsResp(resp)
+	if result.Error != nil {
+		return article, false, result.Error
+	}
+	return result.Article, true, nil
+}
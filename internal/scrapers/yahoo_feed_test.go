@@ -0,0 +1,124 @@
+package scrapers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/stretchr/testify/require"
+)
+
+const newsSitemap = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"
+        xmlns:news="http://www.google.com/schemas/sitemap-news/0.9">
+  <url>
+    <loc>https://tw.news.yahoo.com/a-093000123.html</loc>
+    <news:news>
+      <news:publication>
+        <news:name>Yahoo奇摩新聞</news:name>
+        <news:language>zh-tw</news:language>
+      </news:publication>
+      <news:publication_date>2025-06-01T09:30:00+08:00</news:publication_date>
+      <news:title>立法院三讀通過預算案</news:title>
+      <news:keywords>立法院, 預算，國會</news:keywords>
+    </news:news>
+  </url>
+  <url>
+    <loc>https://tw.news.yahoo.com/b-080000456.html</loc>
+    <lastmod>2025-06-01</lastmod>
+  </url>
+  <url>
+    <loc> </loc>
+  </url>
+</urlset>`
+
+const politicsRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Yahoo奇摩新聞 - 政治</title>
+    <item>
+      <title>總統出席活動</title>
+      <link>https://tw.news.yahoo.com/c-101500789.html</link>
+      <pubDate>Sun, 01 Jun 2025 02:15:00 GMT</pubDate>
+      <category>政治</category>
+      <category>總統府</category>
+    </item>
+  </channel>
+</rss>`
+
+func TestParseYahooFeedSitemap(t *testing.T) {
+	entries, err := scrapers.ParseYahooFeed([]byte(newsSitemap))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	require.Equal(t, "https://tw.news.yahoo.com/a-093000123.html", entries[0].URL)
+	require.Equal(t, "立法院三讀通過預算案", entries[0].Title)
+	require.True(t, entries[0].Published.Equal(time.Date(2025, 6, 1, 1, 30, 0, 0, time.UTC)))
+	require.Equal(t, []string{"立法院", "預算", "國會"}, entries[0].Keywords)
+
+	// the last modification stands in for the missing publication date
+	require.True(t, entries[1].Published.Equal(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)))
+	require.Empty(t, entries[1].Keywords)
+}
+
+func TestParseYahooFeedRSS(t *testing.T) {
+	entries, err := scrapers.ParseYahooFeed([]byte(politicsRSS))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	require.Equal(t, "https://tw.news.yahoo.com/c-101500789.html", entries[0].URL)
+	require.Equal(t, "總統出席活動", entries[0].Title)
+	require.True(t, entries[0].Published.Equal(time.Date(2025, 6, 1, 2, 15, 0, 0, time.UTC)))
+	require.Equal(t, []string{"政治", "總統府"}, entries[0].Keywords)
+}
+
+func TestParseYahooFeedUnknown(t *testing.T) {
+	_, err := scrapers.ParseYahooFeed([]byte(`<html><body></body></html>`))
+	require.ErrorIs(t, err, scrapers.ErrUnknownFeedFormat)
+
+	_, err = scrapers.ParseYahooFeed([]byte(`not xml`))
+	require.ErrorIs(t, err, scrapers.ErrUnknownFeedFormat)
+}
+
+func TestFeedFetcherConditional(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		_, _ = w.Write([]byte(politicsRSS))
+	}))
+	defer srv.Close()
+
+	f := scrapers.NewFeedFetcher(scrapers.NewLinkChecker(srv.Client(), 1, scrapers.Delay{}, nil))
+
+	entries, modified, err := f.Fetch(context.Background(), srv.URL+"/rss/politics")
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Len(t, entries, 1)
+
+	entries, modified, err = f.Fetch(context.Background(), srv.URL+"/rss/politics")
+	require.NoError(t, err)
+	require.False(t, modified)
+	require.Empty(t, entries)
+	require.EqualValues(t, 2, calls.Load())
+}
+
+func TestFeedFetcherStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	f := scrapers.NewFeedFetcher(scrapers.NewLinkChecker(srv.Client(), 1, scrapers.Delay{}, nil))
+	_, _, err := f.Fetch(context.Background(), srv.URL)
+	require.ErrorContains(t, err, "503")
+}
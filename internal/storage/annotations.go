@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+)
+
+func (s Storage) Annotations() Annotations {
+	return Annotations{s}
+}
+
+// Annotations contains methods to manage the manual labels and corrections
+// editors attach to LLM outputs.
+type Annotations struct {
+	Storage
+}
+
+// KeywordReplacement is the replacement payload of a keyword annotation.
+type KeywordReplacement struct {
+	Term string `json:"term"`
+}
+
+// StanceReplacement is the replacement payload of a stance annotation.
+type StanceReplacement struct {
+	Stance string `json:"stance"`
+}
+
+// SummaryReplacement is the replacement payload of a summary annotation.
+type SummaryReplacement struct {
+	Summary string `json:"summary"`
+}
+
+// Stances are the stances an article may take toward a party.
+var Stances = []string{"support", "oppose", "neutral"}
+
+// Create validates and inserts a new annotation for the given article.
+// A replace action requires a replacement: the replacement of a keyword must
+// be a KeywordReplacement with a non-empty term, that of a stance a
+// StanceReplacement with one of Stances, and that of a summary a
+// SummaryReplacement with a non-empty summary.
+func (a Annotations) Create(ctx context.Context, aID int32, targetType models.AnnotationTarget,
+	targetID string, action models.AnnotationAction, replacement json.RawMessage, author string) (models.Annotation, error) {
+	if !targetType.Valid() {
+		return models.Annotation{}, errors.ErrValidationFailed.Clone().
+			WithMessage("invalid annotation target type").
+			WithDetails(fmt.Sprintf("target_type: %s", targetType))
+	}
+
+	if !action.Valid() {
+		return models.Annotation{}, errors.ErrValidationFailed.Clone().
+			WithMessage("invalid annotation action").
+			WithDetails(fmt.Sprintf("action: %s", action))
+	}
+
+	targetID = strings.TrimSpace(targetID)
+	if targetID == "" {
+		return models.Annotation{}, errors.ErrValidationFailed.Clone().
+			WithMessage("annotation target id should not be empty")
+	}
+
+	author = strings.TrimSpace(author)
+	if author == "" {
+		return models.Annotation{}, errors.ErrValidationFailed.Clone().
+			WithMessage("annotation author should not be empty")
+	}
+
+	if action != models.AnnotationActionReplace {
+		replacement = nil
+	} else if err := validateReplacement(targetType, replacement); err != nil {
+		return models.Annotation{}, err
+	}
+
+	annotation, err := a.Queries.InsertAnnotation(ctx, models.InsertAnnotationParams{
+		TargetType:  targetType,
+		TargetID:    targetID,
+		ArticleID:   aID,
+		Action:      action,
+		Replacement: replacement,
+		Author:      author,
+	})
+	if err != nil {
+		return models.Annotation{}, handlePgxErr(err)
+	}
+	return annotation, nil
+}
+
+// ListByArticle retrieves all annotations of an article in the order they were created.
+func (a Annotations) ListByArticle(ctx context.Context, aID int32) ([]models.Annotation, error) {
+	annotations, err := a.querier(ctx, "Annotations", "ListByArticle").ListAnnotationsByArticleID(ctx, aID)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return annotations, nil
+}
+
+// LatestByArticle retrieves the annotations that take effect on the outputs
+// of the given target type of an article, keyed by target id.
+func (a Annotations) LatestByArticle(ctx context.Context, aID int32,
+	targetType models.AnnotationTarget) (map[string]models.LatestAnnotation, error) {
+	rows, err := a.querier(ctx, "Annotations", "LatestByArticle").ListLatestAnnotationsByArticleID(ctx, models.ListLatestAnnotationsByArticleIDParams{
+		ArticleID:  aID,
+		TargetType: targetType,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	latest := make(map[string]models.LatestAnnotation, len(rows))
+	for _, row := range rows {
+		latest[row.TargetID] = row
+	}
+	return latest, nil
+}
+
+// Delete removes an annotation of an article. It returns ErrNotFound if no
+// annotation with the given ID belongs to the article.
+func (a Annotations) Delete(ctx context.Context, aID, id int32) error {
+	n, err := a.Queries.DeleteAnnotation(ctx, models.DeleteAnnotationParams{
+		ID:        id,
+		ArticleID: aID,
+	})
+	if err != nil {
+		return handlePgxErr(err)
+	}
+
+	if n == 0 {
+		return errors.ErrNotFound.Clone().
+			WithMessage("annotation not found").
+			WithDetails(fmt.Sprintf("article ID: %d, annotation ID: %d", aID, id))
+	}
+	return nil
+}
+
+// EffectiveKeywords retrieves the keywords of an article with the editor
+// annotations applied.
+func (a Annotations) EffectiveKeywords(ctx context.Context, aID int32) ([]string, error) {
+	terms, err := a.querier(ctx, "Annotations", "EffectiveKeywords").GetEffectiveKeywordsByArticleID(ctx, aID)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return terms, nil
+}
+
+// TopKeywords retrieves the most frequent keywords of articles published
+// within the [start, end] time interval with the editor annotations applied.
+func (a Annotations) TopKeywords(ctx context.Context, start, end time.Time, limit int32) ([]models.GetTopKeywordsRow, error) {
+	aTsz, err := utils.TimeTo.PGTimestamptz(start)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert start time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("start time: %v", start.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	bTsz, err := utils.TimeTo.PGTimestamptz(end)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert end time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("end time: %v", end.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	rows, err := a.querier(ctx, "Annotations", "TopKeywords").GetTopKeywords(ctx, models.GetTopKeywordsParams{
+		Start: aTsz,
+		End:   bTsz,
+		Limit: limit,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
+
+// StanceCounts counts the articles published within the [start, end] time
+// interval by party and stance with the editor annotations applied.
+func (a Annotations) StanceCounts(ctx context.Context, start, end time.Time) ([]models.GetStanceCountsRow, error) {
+	aTsz, err := utils.TimeTo.PGTimestamptz(start)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert start time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("start time: %v", start.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	bTsz, err := utils.TimeTo.PGTimestamptz(end)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert end time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("end time: %v", end.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	rows, err := a.querier(ctx, "Annotations", "StanceCounts").GetStanceCounts(ctx, models.GetStanceCountsParams{
+		Start: aTsz,
+		End:   bTsz,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
+
+func validateReplacement(targetType models.AnnotationTarget, replacement json.RawMessage) error {
+	if len(replacement) == 0 || !json.Valid(replacement) {
+		return errors.ErrValidationFailed.Clone().
+			WithMessage("replace action requires a valid JSON replacement")
+	}
+
+	switch targetType {
+	case models.AnnotationTargetKeyword:
+		var kw KeywordReplacement
+		if err := json.Unmarshal(replacement, &kw); err != nil || strings.TrimSpace(kw.Term) == "" {
+			return errors.ErrValidationFailed.Clone().
+				WithMessage("keyword replacement should be an object with a non-empty term").
+				WithDetails(fmt.Sprintf("replacement: %s", string(replacement))).
+				Warp(err)
+		}
+	case models.AnnotationTargetStance:
+		var st StanceReplacement
+		if err := json.Unmarshal(replacement, &st); err != nil || !slices.Contains(Stances, st.Stance) {
+			return errors.ErrValidationFailed.Clone().
+				WithMessage("stance replacement should be an object with a stance of " + strings.Join(Stances, ", ")).
+				WithDetails(fmt.Sprintf("replacement: %s", string(replacement))).
+				Warp(err)
+		}
+	case models.AnnotationTargetSummary:
+		var sm SummaryReplacement
+		if err := json.Unmarshal(replacement, &sm); err != nil || strings.TrimSpace(sm.Summary) == "" {
+			return errors.ErrValidationFailed.Clone().
+				WithMessage("summary replacement should be an object with a non-empty summary").
+				WithDetails(fmt.Sprintf("replacement: %s", string(replacement))).
+				Warp(err)
+		}
+	}
+	return nil
+}
+
+// LatestAnnotations keeps the most recent annotation of each (target type,
+// target id) pair, mirroring the latest_annotations view. The annotations are
+// expected to be sorted by creation time, as returned by ListByArticle.
+func LatestAnnotations(annotations []models.Annotation) map[models.AnnotationTarget]map[string]models.Annotation {
+	latest := map[models.AnnotationTarget]map[string]models.Annotation{}
+	for _, a := range annotations {
+		if _, ok := latest[a.TargetType]; !ok {
+			latest[a.TargetType] = map[string]models.Annotation{}
+		}
+		latest[a.TargetType][a.TargetID] = a
+	}
+	return latest
+}
+
+// ResolveAnnotation returns the effective value of a model output given the
+// annotation that takes effect on it. The output is kept as-is when it has no
+// annotation or is confirmed, dropped (ok is false) when rejected, and
+// substituted by the replacement when replaced. It follows the rules of the
+// effective_article_stances and effective_article_summaries views, which the
+// aggregates read.
+func ResolveAnnotation(original json.RawMessage, annotation *models.Annotation) (value json.RawMessage, ok bool) {
+	if annotation == nil {
+		return original, true
+	}
+
+	switch annotation.Action {
+	case models.AnnotationActionReject:
+		return nil, false
+	case models.AnnotationActionReplace:
+		return annotation.Replacement, true
+	default:
+		return original, true
+	}
+}
+
+// ApplyKeywordAnnotations applies the keyword annotations to the keywords
+// produced by the model, following the same rules as the
+// effective_articles_keywords view: rejected keywords are excluded, replaced
+// keywords are substituted and keywords confirmed by editors are included even
+// if the model did not produce them. The result is sorted and deduplicated.
+func ApplyKeywordAnnotations(terms []string, annotations []models.Annotation) []string {
+	latest := LatestAnnotations(annotations)[models.AnnotationTargetKeyword]
+
+	effective := make([]string, 0, len(terms)+len(latest))
+	for _, term := range terms {
+		ann, ok := latest[term]
+		if !ok {
+			effective = append(effective, term)
+			continue
+		}
+
+		if term, ok := resolveKeyword(term, ann); ok {
+			effective = append(effective, term)
+		}
+	}
+
+	for target, ann := range latest {
+		if ann.Action == models.AnnotationActionReject {
+			continue
+		}
+		if term, ok := resolveKeyword(target, ann); ok {
+			effective = append(effective, term)
+		}
+	}
+	effective = utils.RemoveDuplicates(effective)
+	slices.Sort(effective)
+	return effective
+}
+
+func resolveKeyword(term string, ann models.Annotation) (string, bool) {
+	original, _ := json.Marshal(KeywordReplacement{Term: term})
+	value, ok := ResolveAnnotation(original, &ann)
+	if !ok {
+		return "", false
+	}
+
+	var kw KeywordReplacement
+	if err := json.Unmarshal(value, &kw); err != nil || kw.Term == "" {
+		return term, true
+	}
+	return kw.Term, true
+}
@@ -0,0 +1,68 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotationsStanceCounts(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	published := time.Date(1998, 3, 1, 12, 0, 0, 0, time.UTC)
+	insert := func(stance string) int32 {
+		title := fmt.Sprintf("stance %s", uuid.NewString())
+		aID, err := storage.Article{Storage: s}.Insert(ctx, "https://example.com/"+title,
+			title, "stance", uuid.NewString(), "content", nil, published, time.Time{})
+		require.NoError(t, err)
+		_, err = pool.Exec(ctx, `
+INSERT INTO article_stances (article_id, party, stance, confidence) VALUES ($1, 'KMT', $2, 0.9)`, aID, stance)
+		require.NoError(t, err)
+		return aID
+	}
+
+	insert("support")
+	rejected := insert("support")
+	replaced := insert("support")
+	confirmed := insert("oppose")
+
+	_, err := s.Annotations().Create(ctx, rejected, models.AnnotationTargetStance, "KMT",
+		models.AnnotationActionReject, nil, "editor")
+	require.NoError(t, err)
+	_, err = s.Annotations().Create(ctx, replaced, models.AnnotationTargetStance, "KMT",
+		models.AnnotationActionReplace, json.RawMessage(`{"stance":"neutral"}`), "editor")
+	require.NoError(t, err)
+	_, err = s.Annotations().Create(ctx, confirmed, models.AnnotationTargetStance, "KMT",
+		models.AnnotationActionConfirm, nil, "editor")
+	require.NoError(t, err)
+
+	// a stance replacement must be one of the stances
+	_, err = s.Annotations().Create(ctx, replaced, models.AnnotationTargetStance, "KMT",
+		models.AnnotationActionReplace, json.RawMessage(`{"stance":"unknown"}`), "editor")
+	require.Error(t, err)
+
+	rows, err := s.Annotations().StanceCounts(ctx, published.Add(-time.Hour), published.Add(time.Hour))
+	require.NoError(t, err)
+
+	counts := map[string]int32{}
+	for _, row := range rows {
+		counts[string(row.Party)+" "+row.Stance] = row.ArticleCount
+	}
+	require.Equal(t, map[string]int32{
+		"KMT support": 1,
+		"KMT neutral": 1,
+		"KMT oppose":  1,
+	}, counts)
+}
@@ -0,0 +1,173 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func keywordAnnotation(id int32, target string, action models.AnnotationAction, replacement string) models.Annotation {
+	ann := models.Annotation{
+		ID:         id,
+		TargetType: models.AnnotationTargetKeyword,
+		TargetID:   target,
+		ArticleID:  1,
+		Action:     action,
+		Author:     "editor",
+	}
+	if replacement != "" {
+		ann.Replacement, _ = json.Marshal(storage.KeywordReplacement{Term: replacement})
+	}
+	return ann
+}
+
+func TestResolveAnnotation(t *testing.T) {
+	original := json.RawMessage(`{"stance":"support"}`)
+	tcs := []struct {
+		Name       string
+		Annotation *models.Annotation
+		Value      json.RawMessage
+		Ok         bool
+	}{
+		{
+			Name:  "No_Annotation",
+			Value: original,
+			Ok:    true,
+		},
+		{
+			Name: "Confirm",
+			Annotation: &models.Annotation{
+				TargetType: models.AnnotationTargetStance,
+				Action:     models.AnnotationActionConfirm,
+			},
+			Value: original,
+			Ok:    true,
+		},
+		{
+			Name: "Reject",
+			Annotation: &models.Annotation{
+				TargetType: models.AnnotationTargetStance,
+				Action:     models.AnnotationActionReject,
+			},
+			Ok: false,
+		},
+		{
+			Name: "Replace",
+			Annotation: &models.Annotation{
+				TargetType:  models.AnnotationTargetStance,
+				Action:      models.AnnotationActionReplace,
+				Replacement: json.RawMessage(`{"stance":"oppose"}`),
+			},
+			Value: json.RawMessage(`{"stance":"oppose"}`),
+			Ok:    true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			value, ok := storage.ResolveAnnotation(original, tc.Annotation)
+			require.Equal(t, tc.Ok, ok)
+			require.Equal(t, tc.Value, value)
+		})
+	}
+}
+
+func TestApplyKeywordAnnotations(t *testing.T) {
+	tcs := []struct {
+		Name        string
+		Terms       []string
+		Annotations []models.Annotation
+		Expected    []string
+	}{
+		{
+			Name:     "No_Annotation",
+			Terms:    []string{"交通部", "高齡換照"},
+			Expected: []string{"交通部", "高齡換照"},
+		},
+		{
+			Name:  "Confirm",
+			Terms: []string{"交通部", "高齡換照"},
+			Annotations: []models.Annotation{
+				keywordAnnotation(1, "交通部", models.AnnotationActionConfirm, ""),
+			},
+			Expected: []string{"交通部", "高齡換照"},
+		},
+		{
+			Name:  "Confirm_Missing_Keyword",
+			Terms: []string{"交通部"},
+			Annotations: []models.Annotation{
+				keywordAnnotation(1, "重大車禍", models.AnnotationActionConfirm, ""),
+			},
+			Expected: []string{"交通部", "重大車禍"},
+		},
+		{
+			Name:  "Reject",
+			Terms: []string{"交通部", "高齡換照"},
+			Annotations: []models.Annotation{
+				keywordAnnotation(1, "交通部", models.AnnotationActionReject, ""),
+			},
+			Expected: []string{"高齡換照"},
+		},
+		{
+			Name:  "Replace",
+			Terms: []string{"交通部", "高齡換照"},
+			Annotations: []models.Annotation{
+				keywordAnnotation(1, "高齡換照", models.AnnotationActionReplace, "高齡駕駛"),
+			},
+			Expected: []string{"交通部", "高齡駕駛"},
+		},
+		{
+			Name:  "Replace_Into_Existing_Keyword",
+			Terms: []string{"交通部", "交通部長"},
+			Annotations: []models.Annotation{
+				keywordAnnotation(1, "交通部長", models.AnnotationActionReplace, "交通部"),
+			},
+			Expected: []string{"交通部"},
+		},
+		{
+			Name:  "Latest_Annotation_Wins",
+			Terms: []string{"交通部", "高齡換照"},
+			Annotations: []models.Annotation{
+				keywordAnnotation(1, "交通部", models.AnnotationActionReject, ""),
+				keywordAnnotation(2, "交通部", models.AnnotationActionConfirm, ""),
+			},
+			Expected: []string{"交通部", "高齡換照"},
+		},
+		{
+			Name:  "Other_Target_Type",
+			Terms: []string{"交通部"},
+			Annotations: []models.Annotation{
+				{
+					TargetType: models.AnnotationTargetSummary,
+					TargetID:   "交通部",
+					Action:     models.AnnotationActionReject,
+				},
+			},
+			Expected: []string{"交通部"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Expected, storage.ApplyKeywordAnnotations(tc.Terms, tc.Annotations))
+		})
+	}
+}
+
+func TestKeywordAnnotationsSurviveReprocess(t *testing.T) {
+	annotations := []models.Annotation{
+		keywordAnnotation(1, "交通部", models.AnnotationActionReject, ""),
+		keywordAnnotation(2, "高齡換照", models.AnnotationActionReplace, "高齡駕駛"),
+	}
+
+	// the keyword worker re-runs on the article and produces the keywords in a
+	// different order with an additional one; annotations are keyed by the
+	// keyword text, so they still apply.
+	first := storage.ApplyKeywordAnnotations([]string{"交通部", "高齡換照", "陳雪生"}, annotations)
+	second := storage.ApplyKeywordAnnotations([]string{"陳超明", "陳雪生", "高齡換照", "交通部"}, annotations)
+	require.Equal(t, []string{"陳雪生", "高齡駕駛"}, first)
+	require.Equal(t, []string{"陳超明", "陳雪生", "高齡駕駛"}, second)
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The limits of the number of articles a page lists.
+const (
+	DefaultArticleListLimit = 20
+	MaxArticleListLimit     = 100
+)
+
+// ArticleOrder is the order the articles are listed in.
+type ArticleOrder string
+
+const (
+	// ArticleOrderNewest lists the articles the most recently published first,
+	// the default.
+	ArticleOrderNewest ArticleOrder = "newest"
+	// ArticleOrderOldest lists the articles the least recently published first.
+	ArticleOrderOldest ArticleOrder = "oldest"
+)
+
+// Valid reports whether o is a known order, the empty one included.
+func (o ArticleOrder) Valid() bool {
+	switch o {
+	case "", ArticleOrderNewest, ArticleOrderOldest:
+		return true
+	}
+	return false
+}
+
+// ArticleCursor is the position of an article in a listing. The ID breaks the
+// ties of the articles published at the same time, so that the pages neither
+// skip nor repeat an article.
+type ArticleCursor struct {
+	PublishedAt time.Time `json:"published_at"`
+	ID          int32     `json:"id"`
+}
+
+// ArticleListParams selects the articles Article.List lists. A zero Party,
+// Source, Query, Since or Until matches every article. The articles are those
+// published in [Since, Until), and Query matches the titles case-insensitively.
+//
+// A page starts either After the cursor of the last article of the previous
+// page or at Offset, not both. The cursor pages stay stable while articles are
+// inserted, the offset ones shift.
+type ArticleListParams struct {
+	Party   models.Party
+	Source  string
+	Since   time.Time
+	Until   time.Time
+	Query   string
+	Limit   int32
+	Offset  int32
+	OrderBy ArticleOrder
+	After   *ArticleCursor
+}
+
+// ArticlePage is a page of a listing. Total is the number of articles
+// matching the filter across all pages, Next the cursor of the next page, nil
+// if HasMore is false.
+type ArticlePage struct {
+	Articles []models.ListArticlesRow `json:"articles"`
+	Total    int64                    `json:"total"`
+	HasMore  bool                     `json:"has_more"`
+	Next     *ArticleCursor           `json:"next,omitempty"`
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// limitPage validates the offset and clamps the limit of a page.
+func limitPage(limit, offset int32) (int32, error) {
+	if offset < 0 {
+		return 0, ec.ErrValidationFailed.Clone().
+			WithMessage("offset should not be negative").
+			WithDetails(fmt.Sprintf("got: %d", offset))
+	}
+
+	if limit <= 0 {
+		limit = DefaultArticleListLimit
+	}
+	return min(limit, MaxArticleListLimit), nil
+}
+
+// optionalTsz converts t to a timestamptz, NULL if t is zero.
+func optionalTsz(t time.Time, name string) (pgtype.Timestamptz, error) {
+	if t.IsZero() {
+		return pgtype.Timestamptz{}, nil
+	}
+
+	tsz, err := utils.TimeTo.PGTimestamptz(t)
+	if err != nil {
+		return pgtype.Timestamptz{}, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("%s: %v", name, t.Format(time.DateTime))).
+			Warp(err)
+	}
+	return tsz, nil
+}
+
+// List returns a page of the articles selected by p, see ArticleListParams.
+// A limit of zero or less falls back to DefaultArticleListLimit, one above
+// MaxArticleListLimit is clamped.
+func (a Article) List(ctx context.Context, p ArticleListParams) (ArticlePage, error) {
+	if p.Party != "" && !p.Party.Valid() {
+		return ArticlePage{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid party").
+			WithDetails(fmt.Sprintf("party: %q", p.Party))
+	}
+
+	if !p.OrderBy.Valid() {
+		return ArticlePage{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid article order").
+			WithDetails(fmt.Sprintf("order by: %q", p.OrderBy))
+	}
+
+	if p.After != nil && p.Offset != 0 {
+		return ArticlePage{}, ec.ErrValidationFailed.Clone().
+			WithMessage("a page starts after a cursor or at an offset, not both").
+			WithDetails(fmt.Sprintf("offset: %d", p.Offset))
+	}
+
+	if !p.Since.IsZero() && !p.Until.IsZero() && !p.Since.Before(p.Until) {
+		return ArticlePage{}, ec.ErrValidationFailed.Clone().
+			WithMessage("since should be before until").
+			WithDetails(fmt.Sprintf("since: %v, until: %v",
+				p.Since.Format(time.DateTime), p.Until.Format(time.DateTime)))
+	}
+
+	limit, err := limitPage(p.Limit, p.Offset)
+	if err != nil {
+		return ArticlePage{}, err
+	}
+
+	since, err := optionalTsz(p.Since, "since")
+	if err != nil {
+		return ArticlePage{}, err
+	}
+
+	until, err := optionalTsz(p.Until, "until")
+	if err != nil {
+		return ArticlePage{}, err
+	}
+
+	params := models.ListArticlesParams{
+		Party:     string(p.Party),
+		Source:    p.Source,
+		Since:     since,
+		Until:     until,
+		Query:     likeEscaper.Replace(p.Query),
+		Ascending: p.OrderBy == ArticleOrderOldest,
+		// one more article than the page tells whether there is a next one
+		Limit:  limit + 1,
+		Offset: p.Offset,
+	}
+	if p.After != nil {
+		if params.AfterPublishedAt, err = optionalTsz(p.After.PublishedAt, "after"); err != nil {
+			return ArticlePage{}, err
+		}
+		params.AfterID = pgtype.Int4{Int32: p.After.ID, Valid: true}
+	}
+
+	q := a.querier(ctx, "Article", "List")
+	rows, err := q.ListArticles(ctx, params)
+	if err != nil {
+		return ArticlePage{}, handlePgxErr(err)
+	}
+
+	total, err := q.CountListedArticles(ctx, models.CountListedArticlesParams{
+		Party:  params.Party,
+		Source: params.Source,
+		Since:  params.Since,
+		Until:  params.Until,
+		Query:  params.Query,
+	})
+	if err != nil {
+		return ArticlePage{}, handlePgxErr(err)
+	}
+
+	page := ArticlePage{Articles: rows, Total: total}
+	if len(rows) > int(limit) {
+		page.Articles = rows[:limit]
+		page.HasMore = true
+		last := page.Articles[limit-1]
+		page.Next = &ArticleCursor{PublishedAt: last.PublishedAt.Time, ID: last.ID}
+	}
+	return page, nil
+}
+
+// UserArticlePage is a page of the user articles, Total is the number of
+// articles across all pages.
+type UserArticlePage struct {
+	Articles []models.ListUsersArticlesByTaskStatusRow `json:"articles"`
+	Total    int64                                     `json:"total"`
+	HasMore  bool                                      `json:"has_more"`
+}
+
+// ListByTaskStatus pages through the user articles of the tasks of status,
+// the most recently published first. The limit is clamped as the one of
+// Article.List.
+func (s UserArticles) ListByTaskStatus(ctx context.Context, status models.TaskStatus,
+	limit, offset int32) (UserArticlePage, error) {
+	if !status.Valid() {
+		return UserArticlePage{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid task status").
+			WithDetails(fmt.Sprintf("status: %s", status))
+	}
+
+	limit, err := limitPage(limit, offset)
+	if err != nil {
+		return UserArticlePage{}, err
+	}
+
+	q := s.querier(ctx, "UserArticles", "ListByTaskStatus")
+	rows, err := q.ListUsersArticlesByTaskStatus(ctx, models.ListUsersArticlesByTaskStatusParams{
+		TaskStatus: status,
+		Limit:      limit + 1,
+		Offset:     offset,
+	})
+	if err != nil {
+		return UserArticlePage{}, handlePgxErr(err)
+	}
+
+	total, err := q.CountUsersArticlesByTaskStatus(ctx, status)
+	if err != nil {
+		return UserArticlePage{}, handlePgxErr(err)
+	}
+
+	page := UserArticlePage{Articles: rows, Total: total}
+	if len(rows) > int(limit) {
+		page.Articles = rows[:limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+// ArticleSearchOrder is the order the matches of a search are listed in.
+type ArticleSearchOrder string
+
+const (
+	// ArticleSearchByRank lists the best matches first, the default.
+	ArticleSearchByRank ArticleSearchOrder = "rank"
+	// ArticleSearchByRecency lists the most recently published matches first.
+	ArticleSearchByRecency ArticleSearchOrder = "recency"
+)
+
+// Valid reports whether o is a known order, the empty one included.
+func (o ArticleSearchOrder) Valid() bool {
+	switch o {
+	case "", ArticleSearchByRank, ArticleSearchByRecency:
+		return true
+	}
+	return false
+}
+
+// Search returns the articles matching query, with the fragments of their
+// content around the matches highlighted by <b></b>. query is a web search
+// query, e.g. `"立法院" -預算`, matched against the words of the title and the
+// content segmented by the articles_fts configuration. A word the
+// configuration cannot segment, e.g. a Chinese one without zhparser, matches
+// as a substring instead. The limit is clamped as the one of List.
+func (a Article) Search(ctx context.Context, query string, orderBy ArticleSearchOrder,
+	limit, offset int32) ([]models.SearchArticlesByTextRow, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("query should not be empty")
+	}
+
+	if !orderBy.Valid() {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid article search order").
+			WithDetails(fmt.Sprintf("order by: %q", orderBy))
+	}
+
+	limit, err := limitPage(limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := a.querier(ctx, "Article", "Search").SearchArticlesByText(ctx, models.SearchArticlesByTextParams{
+		Query:     query,
+		Pattern:   likeEscaper.Replace(query),
+		ByRecency: orderBy == ArticleSearchByRecency,
+		Limit:     limit,
+		Offset:    offset,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
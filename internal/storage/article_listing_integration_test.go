@@ -0,0 +1,180 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArticleList(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// the articles of a source of their own, two of them published at the
+	// same time
+	source := "list-" + uuid.NewString()
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	a := storage.Article{Storage: s}
+	var ids []int32
+	for i, offset := range []time.Duration{0, time.Minute, time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		title := "list 100% " + uuid.NewString()
+		if i%2 == 1 {
+			title = "list " + uuid.NewString()
+		}
+		id, err := a.Insert(ctx, "https://example.com/"+title, title, source,
+			uuid.NewString(), "content", nil, base.Add(offset), time.Time{})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	// the newest first, paged by the cursor
+	var listed []int32
+	p := storage.ArticleListParams{Source: source, Limit: 2}
+	for {
+		page, err := a.List(ctx, p)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), page.Total)
+		for _, row := range page.Articles {
+			listed = append(listed, row.ID)
+		}
+		if !page.HasMore {
+			require.Nil(t, page.Next)
+			break
+		}
+		require.Len(t, page.Articles, 2)
+		p.After = page.Next
+	}
+	require.Equal(t, []int32{ids[4], ids[3], ids[2], ids[1], ids[0]}, listed)
+
+	// the oldest first
+	page, err := a.List(ctx, storage.ArticleListParams{Source: source, Limit: 3, OrderBy: storage.ArticleOrderOldest})
+	require.NoError(t, err)
+	require.True(t, page.HasMore)
+	require.Equal(t, []int32{ids[0], ids[1], ids[2]}, listIDs(page.Articles))
+	page, err = a.List(ctx, storage.ArticleListParams{Source: source, Limit: 3,
+		OrderBy: storage.ArticleOrderOldest, After: page.Next})
+	require.NoError(t, err)
+	require.False(t, page.HasMore)
+	require.Equal(t, []int32{ids[3], ids[4]}, listIDs(page.Articles))
+
+	// the offset pages
+	page, err = a.List(ctx, storage.ArticleListParams{Source: source, Limit: 2, Offset: 4})
+	require.NoError(t, err)
+	require.False(t, page.HasMore)
+	require.Equal(t, []int32{ids[0]}, listIDs(page.Articles))
+
+	// the filters, the wildcards of the query are matched literally
+	page, err = a.List(ctx, storage.ArticleListParams{Source: source, Query: "LIST 100%"})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), page.Total)
+	require.Equal(t, []int32{ids[4], ids[2], ids[0]}, listIDs(page.Articles))
+
+	page, err = a.List(ctx, storage.ArticleListParams{Source: source,
+		Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute)})
+	require.NoError(t, err)
+	require.Equal(t, []int32{ids[3], ids[2], ids[1]}, listIDs(page.Articles))
+
+	page, err = a.List(ctx, storage.ArticleListParams{Source: source, Party: models.PartyDPP})
+	require.NoError(t, err)
+	require.Zero(t, page.Total)
+	require.Empty(t, page.Articles)
+}
+
+func TestUserArticlesListByTaskStatus(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	taskID, err := s.Task().InsertFromText(ctx, "list "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	require.NoError(t, appendStage(ctx, s, taskID, "scrape", models.TaskStatusFailed, "list"))
+	aID, err := s.UserArticles().Insert(ctx, taskID, "list "+uuid.NewString(), "test",
+		"content of the article", nil, time.Now().Add(24*time.Hour), time.Time{}, nil)
+	require.NoError(t, err)
+
+	// the article is the most recently published one
+	page, err := s.UserArticles().ListByTaskStatus(ctx, models.TaskStatusFailed, 1, 0)
+	require.NoError(t, err)
+	require.Len(t, page.Articles, 1)
+	require.Equal(t, aID, page.Articles[0].ID)
+	require.Equal(t, taskID, page.Articles[0].TaskID)
+	require.Equal(t, page.Total > 1, page.HasMore)
+
+	page, err = s.UserArticles().ListByTaskStatus(ctx, models.TaskStatusDone, storage.MaxArticleListLimit, 0)
+	require.NoError(t, err)
+	for _, row := range page.Articles {
+		require.NotEqual(t, aID, row.ID)
+	}
+}
+
+func TestArticleSearch(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// a word of its own, so that only the articles of this test match
+	word := "w" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	a := storage.Article{Storage: s}
+	insert := func(title, content string, publishedAt time.Time) int32 {
+		id, err := a.Insert(ctx, "https://example.com/"+uuid.NewString(), title, "test",
+			uuid.NewString(), content, nil, publishedAt, time.Time{})
+		require.NoError(t, err)
+		return id
+	}
+
+	now := time.Now()
+	inTitle := insert("about "+word, "the content of the article", now.Add(-2*time.Hour))
+	inContent := insert("another article", "the content mentions "+word+" once", now.Add(-time.Hour))
+
+	// the title weighs above the content
+	matches, err := a.Search(ctx, word, storage.ArticleSearchByRank, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	require.Equal(t, inTitle, matches[0].ID)
+	require.Equal(t, inContent, matches[1].ID)
+	require.Greater(t, matches[0].Rank, matches[1].Rank)
+	require.Contains(t, matches[1].Headline, "<b>"+word+"</b>")
+
+	matches, err = a.Search(ctx, word, storage.ArticleSearchByRecency, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []int32{inContent, inTitle}, []int32{matches[0].ID, matches[1].ID})
+
+	matches, err = a.Search(ctx, word, storage.ArticleSearchByRank, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, inContent, matches[0].ID)
+
+	matches, err = a.Search(ctx, word+" -mentions", storage.ArticleSearchByRank, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, inTitle, matches[0].ID)
+
+	// a Chinese word inside a sentence matches whether it is segmented or not
+	cjk := "氣象" + word
+	inSentence := insert("立法院今日三讀", "立法院今日三讀通過"+cjk+"預算案", now)
+	matches, err = a.Search(ctx, cjk, storage.ArticleSearchByRank, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, inSentence, matches[0].ID)
+	require.Contains(t, matches[0].Headline, cjk)
+}
+
+func listIDs(rows []models.ListArticlesRow) []int32 {
+	ids := make([]int32, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	return ids
+}
@@ -0,0 +1,44 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArticleListValidation(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{}
+	s := storage.New(db, nil)
+	a := storage.Article{Storage: s}
+	now := time.Now()
+
+	for _, p := range []storage.ArticleListParams{
+		{Party: "unknown"},
+		{OrderBy: "popular"},
+		{Offset: -1},
+		{Offset: 20, After: &storage.ArticleCursor{PublishedAt: now, ID: 1}},
+		{Since: now, Until: now},
+		{Since: now, Until: now.Add(-time.Hour)},
+	} {
+		_, err := a.List(ctx, p)
+		require.Error(t, err, "%+v", p)
+	}
+
+	_, err := a.Search(ctx, "  ", "", 10, 0)
+	require.Error(t, err)
+	_, err = a.Search(ctx, "立法院", "popular", 10, 0)
+	require.Error(t, err)
+	_, err = a.Search(ctx, "立法院", storage.ArticleSearchByRank, 10, -1)
+	require.Error(t, err)
+
+	_, err = s.UserArticles().ListByTaskStatus(ctx, "unknown", 10, 0)
+	require.Error(t, err)
+	_, err = s.UserArticles().ListByTaskStatus(ctx, models.TaskStatusDone, 10, -1)
+	require.Error(t, err)
+	require.Zero(t, db.calls)
+}
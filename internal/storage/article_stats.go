@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The buckets of Article.StatsByParty and Article.StatsBySource, the fields of
+// date_trunc they truncate the publication time to.
+const (
+	StatsBucketDay   = "day"
+	StatsBucketWeek  = "week"
+	StatsBucketMonth = "month"
+)
+
+// MaxStatsBuckets is the number of buckets a statistics query spans at most,
+// e.g. a year of days.
+const MaxStatsBuckets = 366
+
+// statsRange validates the bucket and the range [since, until) of a
+// statistics query and converts the bounds of the range.
+func statsRange(since, until time.Time, bucket string) (pgtype.Timestamptz, pgtype.Timestamptz, error) {
+	var limit time.Time
+	switch bucket {
+	case StatsBucketDay:
+		limit = since.AddDate(0, 0, MaxStatsBuckets)
+	case StatsBucketWeek:
+		limit = since.AddDate(0, 0, 7*MaxStatsBuckets)
+	case StatsBucketMonth:
+		limit = since.AddDate(0, MaxStatsBuckets, 0)
+	default:
+		return pgtype.Timestamptz{}, pgtype.Timestamptz{}, ec.ErrValidationFailed.Clone().
+			WithMessage("bucket should be one of day, week or month").
+			WithDetails(fmt.Sprintf("got: %q", bucket))
+	}
+
+	if !since.Before(until) {
+		return pgtype.Timestamptz{}, pgtype.Timestamptz{}, ec.ErrValidationFailed.Clone().
+			WithMessage("since should be before until").
+			WithDetails(fmt.Sprintf("since: %v, until: %v", since.Format(time.DateTime), until.Format(time.DateTime)))
+	}
+	if until.After(limit) {
+		return pgtype.Timestamptz{}, pgtype.Timestamptz{}, ec.ErrValidationFailed.Clone().
+			WithMessage(fmt.Sprintf("the range should span at most %d buckets", MaxStatsBuckets)).
+			WithDetails(fmt.Sprintf("bucket: %s, since: %v, until: %v", bucket,
+				since.Format(time.DateTime), until.Format(time.DateTime)))
+	}
+
+	sinceTsz, err := utils.TimeTo.PGTimestamptz(since)
+	if err != nil {
+		return pgtype.Timestamptz{}, pgtype.Timestamptz{}, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert since to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("since: %v", since.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	untilTsz, err := utils.TimeTo.PGTimestamptz(until)
+	if err != nil {
+		return pgtype.Timestamptz{}, pgtype.Timestamptz{}, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert until to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("until: %v", until.Format(time.DateTime))).
+			Warp(err)
+	}
+	return sinceTsz, untilTsz, nil
+}
+
+// StatsByParty returns the number of articles of each party published in each
+// bucket, one of StatsBucketDay, StatsBucketWeek and StatsBucketMonth, within
+// [since, until), the earliest bucket first. The buckets are truncated in the
+// time zone of the database session, a bucket without any article is left out,
+// and the range spans at most MaxStatsBuckets buckets.
+func (a Article) StatsByParty(ctx context.Context, since, until time.Time, bucket string) ([]models.CountArticlesPerBucketByPartyRow, error) {
+	sinceTsz, untilTsz, err := statsRange(since, until, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	counts, err := a.querier(ctx, "Article", "StatsByParty").CountArticlesPerBucketByParty(ctx, models.CountArticlesPerBucketByPartyParams{
+		Bucket: bucket,
+		Since:  sinceTsz,
+		Until:  untilTsz,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return counts, nil
+}
+
+// StatsBySource returns the number of articles of each source published in
+// each bucket within [since, until), as StatsByParty does.
+func (a Article) StatsBySource(ctx context.Context, since, until time.Time, bucket string) ([]models.CountArticlesPerBucketBySourceRow, error) {
+	sinceTsz, untilTsz, err := statsRange(since, until, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	counts, err := a.querier(ctx, "Article", "StatsBySource").CountArticlesPerBucketBySource(ctx, models.CountArticlesPerBucketBySourceParams{
+		Bucket: bucket,
+		Since:  sinceTsz,
+		Until:  untilTsz,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,112 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArticleStats(t *testing.T) {
+	pool := newTestPool(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// the buckets are truncated in the time zone of the session
+	conn, err := pool.Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+	_, err = conn.Exec(ctx, "SET TIME ZONE 'UTC'")
+	require.NoError(t, err)
+	a := storage.Article{Storage: storage.New(conn, nil)}
+
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2001, month, d, 12, 0, 0, 0, time.UTC)
+	}
+	since, until := day(time.January, 1), day(time.March, 1)
+
+	byParty := func() map[string]int64 {
+		counts, err := a.StatsByParty(ctx, since, until, storage.StatsBucketDay)
+		require.NoError(t, err)
+		m := map[string]int64{}
+		for _, c := range counts {
+			m[c.BucketStart.UTC().Format(time.DateOnly)+" "+string(c.Party)] = c.Count
+		}
+		return m
+	}
+	before := byParty()
+
+	// 2001-01-01 is a Monday
+	srcA, srcB := "stats-"+uuid.NewString(), "stats-"+uuid.NewString()
+	seeds := []struct {
+		Source      string
+		Party       models.Party
+		PublishedAt time.Time
+	}{
+		{srcA, models.PartyKMT, day(time.January, 1)},
+		{srcA, models.PartyDPP, day(time.January, 3)},
+		{srcB, models.PartyDPP, day(time.January, 3)},
+		{srcA, models.PartyKMT, day(time.January, 10)},
+		{srcB, models.PartyTPP, day(time.February, 7)},
+		// until is left out
+		{srcA, models.PartyKMT, until},
+	}
+	rows := make([]*storage.ImportRow, len(seeds))
+	for i, seed := range seeds {
+		rows[i] = &storage.ImportRow{
+			URL:         fmt.Sprintf("https://example.com/%s/%d", srcA, i),
+			Title:       fmt.Sprintf("%s %d", srcA, i),
+			Source:      seed.Source,
+			Party:       seed.Party,
+			Content:     "content",
+			PublishedAt: seed.PublishedAt,
+		}
+	}
+	stats, err := a.BulkImport(ctx, importRows(rows...))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(seeds)), stats.Inserted)
+
+	after := byParty()
+	for key, n := range map[string]int64{
+		"2001-01-01 KMT": 1,
+		"2001-01-03 DPP": 2,
+		"2001-01-10 KMT": 1,
+		"2001-02-07 TPP": 1,
+	} {
+		require.Equal(t, n, after[key]-before[key], key)
+	}
+	require.Equal(t, before["2001-03-01 KMT"], after["2001-03-01 KMT"])
+
+	bySource := func(bucket string) map[string]int64 {
+		counts, err := a.StatsBySource(ctx, since, until, bucket)
+		require.NoError(t, err)
+		m := map[string]int64{}
+		for _, c := range counts {
+			switch c.Source {
+			case srcA:
+				m[c.BucketStart.UTC().Format(time.DateOnly)+" A"] = c.Count
+			case srcB:
+				m[c.BucketStart.UTC().Format(time.DateOnly)+" B"] = c.Count
+			}
+		}
+		return m
+	}
+	require.Equal(t, map[string]int64{
+		"2001-01-01 A": 2,
+		"2001-01-01 B": 1,
+		"2001-01-08 A": 1,
+		"2001-02-05 B": 1,
+	}, bySource(storage.StatsBucketWeek))
+	require.Equal(t, map[string]int64{
+		"2001-01-01 A": 3,
+		"2001-01-01 B": 1,
+		"2001-02-01 B": 1,
+	}, bySource(storage.StatsBucketMonth))
+}
@@ -0,0 +1,47 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArticleStatsValidation(t *testing.T) {
+	ctx := context.Background()
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		Name   string
+		Until  time.Time
+		Bucket string
+		OK     bool
+	}{
+		{Name: "day", Until: since.AddDate(0, 0, storage.MaxStatsBuckets), Bucket: storage.StatsBucketDay, OK: true},
+		{Name: "week", Until: since.AddDate(0, 0, 7*storage.MaxStatsBuckets), Bucket: storage.StatsBucketWeek, OK: true},
+		{Name: "month", Until: since.AddDate(0, storage.MaxStatsBuckets, 0), Bucket: storage.StatsBucketMonth, OK: true},
+		{Name: "unknown bucket", Until: since.AddDate(0, 0, 1), Bucket: "year"},
+		{Name: "empty bucket", Until: since.AddDate(0, 0, 1), Bucket: ""},
+		{Name: "empty range", Until: since, Bucket: storage.StatsBucketDay},
+		{Name: "reversed range", Until: since.Add(-time.Hour), Bucket: storage.StatsBucketDay},
+		{Name: "too many buckets", Until: since.AddDate(0, 0, storage.MaxStatsBuckets+1), Bucket: storage.StatsBucketDay},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			db := &fakeDB{}
+			a := storage.Article{Storage: storage.New(db, nil)}
+
+			_, err := a.StatsByParty(ctx, since, tc.Until, tc.Bucket)
+			require.Error(t, err)
+			_, err = a.StatsBySource(ctx, since, tc.Until, tc.Bucket)
+			require.Error(t, err)
+			// a valid range reaches the database, which fails every query
+			if tc.OK {
+				require.Equal(t, 2, db.calls)
+			} else {
+				require.Zero(t, db.calls)
+			}
+		})
+	}
+}
@@ -4,18 +4,28 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/ChiaYuChang/weathercock/internal/llm"
 	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/pipeline"
 	"github.com/ChiaYuChang/weathercock/pkgs/errors"
 	"github.com/ChiaYuChang/weathercock/pkgs/utils"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 var MD5PublishedAtFormat = time.DateOnly
 
+// DefaultChunkBatchSize is the number of chunks UserChunks.InsertStream inserts
+// at once.
+const DefaultChunkBatchSize = 64
+
 func (s Storage) UserArticles() UserArticles {
 	return UserArticles{s}
 }
@@ -33,9 +43,34 @@ func MD5(title, url string, publishAt time.Time) string {
 	return base64.StdEncoding.EncodeToString(md5)
 }
 
+// ContentHash returns the MD5 of content in hex, as the md5 function of
+// Postgres does.
+func ContentHash(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// modifiedTsz returns modifiedAt as a pgtype.Timestamptz, NULL if it is zero.
+func modifiedTsz(modifiedAt time.Time) (pgtype.Timestamptz, error) {
+	if modifiedAt.IsZero() {
+		return pgtype.Timestamptz{}, nil
+	}
+
+	tsz, err := utils.TimeTo.PGTimestamptz(modifiedAt)
+	if err != nil {
+		return tsz, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", modifiedAt.Format(time.DateTime))).
+			Warp(err)
+	}
+	return tsz, nil
+}
+
 // Insert adds a new user article to the database and returns its ID.
+// modifiedAt is the modification time declared by the publisher, zero if it
+// declares none.
 func (s UserArticles) Insert(ctx context.Context, taskID uuid.UUID, title,
-	source, content string, cuts []int32, publishedAt time.Time,
+	source, content string, cuts []int32, publishedAt, modifiedAt time.Time,
 	fn func(ctx context.Context, tID uuid.UUID, aID int32) error) (int32, error) {
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
@@ -52,6 +87,11 @@ func (s UserArticles) Insert(ctx context.Context, taskID uuid.UUID, title,
 			Warp(err)
 	}
 
+	mTsz, err := modifiedTsz(modifiedAt)
+	if err != nil {
+		return 0, err
+	}
+
 	articleID, err := s.Queries.WithTx(tx).
 		InsertUsersArticle(ctx, models.InsertUsersArticleParams{
 			TaskID:      taskID,
@@ -61,6 +101,8 @@ func (s UserArticles) Insert(ctx context.Context, taskID uuid.UUID, title,
 			Content:     content,
 			Cuts:        cuts,
 			PublishedAt: tsz,
+			ModifiedAt:  mTsz,
+			ContentHash: ContentHash(content),
 		})
 	if err != nil {
 		return 0, handlePgxErr(err)
@@ -77,9 +119,121 @@ func (s UserArticles) Insert(ctx context.Context, taskID uuid.UUID, title,
 	return articleID, nil
 }
 
+// UpsertByMD5 inserts a user article the way Insert does, unless the task
+// has one of the same MD5 stored already, and returns the ID of the article
+// either way. inserted is false for an existing article, which is left as it
+// is, so that scraping an article again is not an error. The article of
+// another task is never returned: each task gets its own copy.
+func (s UserArticles) UpsertByMD5(ctx context.Context, taskID uuid.UUID, title,
+	source, content string, cuts []int32, publishedAt, modifiedAt time.Time) (aID int32, inserted bool, err error) {
+	tsz, err := utils.TimeTo.PGTimestamptz(publishedAt)
+	if err != nil {
+		return 0, false, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", publishedAt.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	mTsz, err := modifiedTsz(modifiedAt)
+	if err != nil {
+		return 0, false, err
+	}
+
+	row, err := s.Queries.UpsertUsersArticleByMD5(ctx, models.UpsertUsersArticleByMD5Params{
+		TaskID:      taskID,
+		Title:       title,
+		Source:      source,
+		Md5:         MD5(title, source, publishedAt),
+		Content:     content,
+		Cuts:        cuts,
+		PublishedAt: tsz,
+		ModifiedAt:  mTsz,
+		ContentHash: ContentHash(content),
+	})
+	if err != nil {
+		return 0, false, handlePgxErr(err)
+	}
+	return row.ID, row.Inserted, nil
+}
+
+// Replace replaces the article aID of the task with the one scraped again,
+// e.g. after an editor dismissed its extraction, and clears its review flag.
+// Its chunks, their embeddings and its keywords are deleted, to be made again
+// from the new content. ErrNotFound is returned if the task has no article
+// aID.
+func (s UserArticles) Replace(ctx context.Context, taskID uuid.UUID, aID int32, title,
+	source, content string, cuts []int32, publishedAt, modifiedAt time.Time) error {
+	tsz, err := utils.TimeTo.PGTimestamptz(publishedAt)
+	if err != nil {
+		return errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", publishedAt.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	mTsz, err := modifiedTsz(modifiedAt)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := s.Queries.WithTx(tx)
+	n, err := q.ReplaceUsersArticle(ctx, models.ReplaceUsersArticleParams{
+		Title:       title,
+		Source:      source,
+		Md5:         MD5(title, source, publishedAt),
+		Content:     content,
+		Cuts:        cuts,
+		PublishedAt: tsz,
+		ModifiedAt:  mTsz,
+		ContentHash: ContentHash(content),
+		ID:          aID,
+		TaskID:      taskID,
+	})
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	if n == 0 {
+		return errors.ErrNotFound.Clone().
+			WithDetails(fmt.Sprintf("user article %d of task %s not found", aID, taskID))
+	}
+
+	if err := q.DeleteUsersChunksByArticleID(ctx, aID); err != nil {
+		return handlePgxErr(err)
+	}
+	if err := q.DeleteUsersArticleKeywords(ctx, aID); err != nil {
+		return handlePgxErr(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
+
+// ExistsByMD5 reports whether the task has a user article of the MD5 stored, a
+// cheap check before scraping it again. The article of another task does not
+// count, each task gets its own copy, see UpsertByMD5. The article may be
+// inserted between the check and the insert, which UpsertByMD5 tolerates.
+func (s UserArticles) ExistsByMD5(ctx context.Context, taskID uuid.UUID, md5 string) (bool, error) {
+	found, err := s.querier(ctx, "UserArticles", "ExistsByMD5").ExistsUsersArticleByMD5(ctx, models.ExistsUsersArticleByMD5Params{
+		TaskID: taskID,
+		Md5:    md5,
+	})
+	if err != nil {
+		return false, handlePgxErr(err)
+	}
+	return found, nil
+}
+
 // GetByID retrieves a user article by its ID.
 func (s UserArticles) GetByID(ctx context.Context, aID int32) (*models.UsersArticle, error) {
-	article, err := s.Queries.GetUsersArticleByID(ctx, aID)
+	article, err := s.querier(ctx, "UserArticles", "GetByID").GetUsersArticleByID(ctx, aID)
 	if err != nil {
 		return nil, handlePgxErr(err)
 	}
@@ -88,22 +242,43 @@ func (s UserArticles) GetByID(ctx context.Context, aID int32) (*models.UsersArti
 
 // GetByTaskID retrieves a user article by its associated task ID.
 func (s UserArticles) GetByTaskID(ctx context.Context, taskID uuid.UUID) (*models.UsersArticle, error) {
-	article, err := s.Queries.GetUsersArticleByTaskID(ctx, taskID)
+	article, err := s.querier(ctx, "UserArticles", "GetByTaskID").GetUsersArticleByTaskID(ctx, taskID)
 	if err != nil {
 		return nil, handlePgxErr(err)
 	}
 	return &article, nil
 }
 
-// GetByMD5 retrieves a user article by its MD5 hash.
-func (s UserArticles) GetByMD5(ctx context.Context, md5 string) (*models.UsersArticle, error) {
-	article, err := s.Queries.GetUsersArticleByMD5(ctx, md5)
+// GetByMD5 retrieves the user article of the task by its MD5 hash.
+func (s UserArticles) GetByMD5(ctx context.Context, taskID uuid.UUID, md5 string) (*models.UsersArticle, error) {
+	article, err := s.querier(ctx, "UserArticles", "GetByMD5").GetUsersArticleByMD5(ctx, models.GetUsersArticleByMD5Params{
+		TaskID: taskID,
+		Md5:    md5,
+	})
 	if err != nil {
 		return nil, handlePgxErr(err)
 	}
 	return &article, nil
 }
 
+// SetNeedsReview flags the keyword output of the user article as held back
+// for an editor, or clears the flag.
+func (s UserArticles) SetNeedsReview(ctx context.Context, aID int32, needsReview bool) error {
+	n, err := s.Queries.SetUsersArticleNeedsReview(ctx, models.SetUsersArticleNeedsReviewParams{
+		NeedsReview: needsReview,
+		ID:          aID,
+	})
+	if err != nil {
+		return handlePgxErr(err)
+	}
+
+	if n == 0 {
+		return errors.ErrNotFound.Clone().
+			WithDetails(fmt.Sprintf("user article %d not found", aID))
+	}
+	return nil
+}
+
 func (s Storage) UserChunks() UserChunks {
 	return UserChunks{s}
 }
@@ -137,6 +312,15 @@ func (s UserChunks) BatchInsert(ctx context.Context, aID int32, paragraphs []str
 			Warp(err)
 	}
 
+	if err := s.insertBatch(ctx, aID, offsets); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// insertBatch inserts the chunks of offsets in a single batch and sets their
+// IDs.
+func (s UserChunks) insertBatch(ctx context.Context, aID int32, offsets []llm.ChunkOffsets) error {
 	params := make([]models.InsertUsersChunksBatchParams, 0, len(offsets))
 	for _, offset := range offsets {
 		params = append(params, models.InsertUsersChunksBatchParams{
@@ -161,14 +345,117 @@ func (s UserChunks) BatchInsert(ctx context.Context, aID int32, paragraphs []str
 	})
 
 	if !bErr.IsEmpty() {
-		return nil, bErr.ToError()
+		return bErr.ToError()
 	}
-	return offsets, nil
+	return nil
+}
+
+// InsertStream chunks the text of stream the way BatchInsert chunks its
+// paragraphs, and inserts the chunks batchSize at a time as they are read, so
+// that no more than a batch of chunks is held in memory whatever the size of
+// the text. fn, if not nil, is called with each batch once inserted, e.g. to
+// embed it. It returns the number of chunks inserted.
+func (s UserChunks) InsertStream(ctx context.Context, aID int32, stream *pipeline.TextStream,
+	size, overlap, batchSize int, fn func(ctx context.Context, chunks []pipeline.Chunk) error) (int, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultChunkBatchSize
+	}
+
+	chunker, err := pipeline.NewChunker(stream, size, overlap)
+	if err != nil {
+		return 0, errors.ErrValidationFailed.Clone().
+			WithMessage("failed to chunk paragraphs").
+			WithDetails(fmt.Sprintf("size: %d, overlap: %d", size, overlap)).
+			Warp(err)
+	}
+
+	n := 0
+	chunks := make([]pipeline.Chunk, 0, batchSize)
+	offsets := make([]llm.ChunkOffsets, 0, batchSize)
+	flush := func() error {
+		if len(chunks) == 0 {
+			return nil
+		}
+
+		offsets = offsets[:0]
+		for _, c := range chunks {
+			offsets = append(offsets, c.ChunkOffsets)
+		}
+		if err := s.insertBatch(ctx, aID, offsets); err != nil {
+			return err
+		}
+		for i := range chunks {
+			chunks[i].ID = offsets[i].ID
+		}
+
+		if fn != nil {
+			if err := fn(ctx, chunks); err != nil {
+				return err
+			}
+		}
+		n += len(chunks)
+		chunks = chunks[:0]
+		return nil
+	}
+
+	for {
+		c, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, errors.ErrBadRequest.Clone().
+				WithMessage("failed to read text").
+				Warp(err)
+		}
+
+		if chunks = append(chunks, c); len(chunks) == batchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, flush()
+}
+
+// InsertContent inserts the chunks of content, split into paragraphs at cuts as
+// utils.Split does. A content larger than pipeline.DefaultStreamThreshold is
+// chunked as a stream, see InsertStream, a smaller one in memory at once. fn is
+// called as by InsertStream.
+func (s UserChunks) InsertContent(ctx context.Context, aID int32, content string, cuts []int32,
+	size, overlap int, fn func(ctx context.Context, chunks []pipeline.Chunk) error) (int, error) {
+	if len(content) > pipeline.DefaultStreamThreshold {
+		return s.InsertStream(ctx, aID, pipeline.NewTextStream(strings.NewReader(content), cuts),
+			size, overlap, DefaultChunkBatchSize, fn)
+	}
+
+	ints := make([]int, len(cuts))
+	for i, cut := range cuts {
+		ints[i] = int(cut)
+	}
+	offsets, err := s.BatchInsert(ctx, aID, utils.Split(content, ints), size, overlap)
+	if err != nil || fn == nil {
+		return len(offsets), err
+	}
+
+	runes := []rune(content)
+	chunks := make([]pipeline.Chunk, len(offsets))
+	for i, o := range offsets {
+		text, _, _, _, err := llm.ExtractChunkRunes(runes, o)
+		if err != nil {
+			return len(offsets), errors.ErrValidationFailed.Clone().
+				WithMessage("failed to extract chunk").
+				WithDetails(fmt.Sprintf("article ID: %d, chunk ID: %d", aID, o.ID)).
+				Warp(err)
+		}
+		chunks[i] = pipeline.Chunk{ChunkOffsets: o, Text: text}
+	}
+	return len(chunks), fn(ctx, chunks)
 }
 
 // ExtractByArticleID retrieves all chunks associated with a specific article ID.
 func (s UserChunks) ExtractByArticleID(ctx context.Context, aID int32) ([]string, error) {
-	rows, err := s.Queries.ExtractUsersChunks(ctx, aID)
+	rows, err := s.querier(ctx, "UserChunks", "ExtractByArticleID").ExtractUsersChunks(ctx, aID)
 	if err != nil {
 		return nil, handlePgxErr(err)
 	}
@@ -188,28 +475,80 @@ func (s UserChunks) ExtractByArticleID(ctx context.Context, aID int32) ([]string
 	return chunks, nil
 }
 
-func (s Storage) UserEmbeddings() UserEmbeddings {
-	return UserEmbeddings{
-		db: s.Queries,
+// GetByArticleID returns the chunks of the article in the order of their
+// offsets, with their IDs to line the search hits and the embeddings up with.
+func (s UserChunks) GetByArticleID(ctx context.Context, aID int32) ([]models.UsersChunk, error) {
+	chunks, err := s.querier(ctx, "UserChunks", "GetByArticleID").GetUsersChunksByArticleID(ctx, aID)
+	if err != nil {
+		return nil, handlePgxErr(err)
 	}
+	return chunks, nil
+}
+
+// ChunkWithContent is a chunk with its text, the content of its article from
+// its start to its end.
+type ChunkWithContent struct {
+	Chunk   models.UsersChunk `json:"chunk"`
+	Content string            `json:"content"`
+}
+
+// GetWithContent is GetByArticleID with the text of every chunk, so that a
+// chunk is embedded by its ID rather than by its position in the slice.
+func (s UserChunks) GetWithContent(ctx context.Context, aID int32) ([]ChunkWithContent, error) {
+	rows, err := s.querier(ctx, "UserChunks", "GetWithContent").GetUsersChunksWithContent(ctx, aID)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	chunks := make([]ChunkWithContent, len(rows))
+	for i, row := range rows {
+		chunks[i] = ChunkWithContent{
+			Chunk: models.UsersChunk{
+				ID:          row.ID,
+				ArticleID:   row.ArticleID,
+				Start:       row.Start,
+				OffsetLeft:  row.OffsetLeft,
+				OffsetRight: row.OffsetRight,
+				End:         row.End,
+				CreatedAt:   row.CreatedAt,
+			},
+			Content: row.Content,
+		}
+	}
+	return chunks, nil
+}
+
+func (s Storage) UserEmbeddings() UserEmbeddings {
+	return UserEmbeddings{s}
 }
 
 type UserEmbeddings struct {
-	db models.Querier
+	Storage
 }
 
 func (s UserEmbeddings) Insert(ctx context.Context, aID, cID, mID int32, embedding []float32) (int32, error) {
-	if len(embedding) != 1024 {
-		return 0, errors.ErrValidationFailed.Clone().
-			WithMessage("embedding length must be 1024").
-			WithDetails(fmt.Sprintf("got: %d", len(embedding)))
+	return s.InsertPooled(ctx, aID, cID, mID, llm.SplitEmbedding{
+		Embedding: llm.Embedding{Values: embedding},
+		SubCount:  1,
+	})
+}
+
+// InsertPooled inserts the embedding of a chunk, recording whether it was
+// pooled from the embeddings of the pieces of an over-limit chunk. The length
+// of the embedding must be the dimension of the model of mID, and the model
+// must be active.
+func (s UserEmbeddings) InsertPooled(ctx context.Context, aID, cID, mID int32, embedding llm.SplitEmbedding) (int32, error) {
+	if err := s.check(ctx, mID, embedding); err != nil {
+		return 0, err
 	}
 
-	eID, err := s.db.InsertUserEmbedding(ctx, models.InsertUserEmbeddingParams{
+	eID, err := s.Queries.InsertUserEmbedding(ctx, models.InsertUserEmbeddingParams{
 		ArticleID: aID,
 		ChunkID:   cID,
 		ModelID:   mID,
-		Vector:    utils.ToPgVector(embedding),
+		Vector:    utils.ToPgVector(embedding.Values),
+		WasSplit:  embedding.WasSplit,
+		SubCount:  int32(embedding.SubCount),
 	})
 
 	if err != nil {
@@ -218,14 +557,296 @@ func (s UserEmbeddings) Insert(ctx context.Context, aID, cID, mID int32, embeddi
 	return eID, nil
 }
 
+// InsertIfAbsent inserts the embedding of a chunk the way InsertPooled does,
+// unless the chunk is embedded under the model of mID already. inserted is
+// false then, and eID zero, so that running the embedding stage again does not
+// store a second vector of the chunk.
+func (s UserEmbeddings) InsertIfAbsent(ctx context.Context, aID, cID, mID int32,
+	embedding llm.SplitEmbedding) (eID int32, inserted bool, err error) {
+	if err := s.check(ctx, mID, embedding); err != nil {
+		return 0, false, err
+	}
+
+	eID, err = s.Queries.InsertUsersEmbeddingIfAbsent(ctx, models.InsertUsersEmbeddingIfAbsentParams{
+		ArticleID: aID,
+		ChunkID:   cID,
+		ModelID:   mID,
+		Vector:    utils.ToPgVector(embedding.Values),
+		WasSplit:  embedding.WasSplit,
+		SubCount:  int32(embedding.SubCount),
+	})
+	if err == pgx.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, handlePgxErr(err)
+	}
+	return eID, true, nil
+}
+
+// check checks the embedding to be stored under the model of mID: its sub
+// count, its length against the dimension of the model, and that the model is
+// active. The model is read from the write pool, it may have just been
+// created.
+func (s UserEmbeddings) check(ctx context.Context, mID int32, embedding llm.SplitEmbedding) error {
+	if embedding.SubCount < 1 {
+		return errors.ErrValidationFailed.Clone().
+			WithMessage("embedding sub count must be at least 1").
+			WithDetails(fmt.Sprintf("got: %d", embedding.SubCount))
+	}
+
+	model, err := s.Queries.GetModelByID(ctx, mID)
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	if err := checkModelActive(model); err != nil {
+		return err
+	}
+	return checkModelDim(model, embedding.Values)
+}
+
+// ExistsForChunks reports which of the chunks of chunkIDs are embedded under
+// the model of modelID, every chunk of chunkIDs being a key of the map, so
+// that the embedding stage run again only embeds the others. A chunk embedded
+// the replica has not caught up with yet is reported missing, InsertIfAbsent
+// skips it then.
+func (s UserEmbeddings) ExistsForChunks(ctx context.Context, modelID int32, chunkIDs []int32) (map[int32]bool, error) {
+	exists := make(map[int32]bool, len(chunkIDs))
+	if len(chunkIDs) == 0 {
+		return exists, nil
+	}
+
+	embedded, err := s.querier(ctx, "UserEmbeddings", "ExistsForChunks").ListUsersEmbeddedChunkIDs(ctx,
+		models.ListUsersEmbeddedChunkIDsParams{
+			ModelID:  modelID,
+			ChunkIds: chunkIDs,
+		})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	for _, cID := range chunkIDs {
+		exists[cID] = false
+	}
+	for _, cID := range embedded {
+		exists[cID] = true
+	}
+	return exists, nil
+}
+
+// EmbeddingItem is the embedding of a chunk to be inserted by
+// UserEmbeddings.BatchInsert.
+type EmbeddingItem struct {
+	ArticleID int32
+	ChunkID   int32
+	ModelID   int32
+	Embedding llm.SplitEmbedding
+}
+
+// BatchInsert inserts the embeddings of items in a single batch and returns
+// their IDs, in the order of items. An embedding already stored is skipped,
+// its ID is zero.
+//
+// The items are checked the way InsertPooled checks them before any is sent,
+// and the batch runs in a single implicit transaction: either all the items
+// are inserted or none is. The details of the error name the indexes of the
+// failing items, as those of UserChunks.BatchInsert do.
+func (s UserEmbeddings) BatchInsert(ctx context.Context, items []EmbeddingItem) ([]int32, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	bErr := errors.NewBatchErr()
+	cached := map[int32]models.GetModelByIDRow{}
+	params := make([]models.InsertUsersEmbeddingBatchParams, len(items))
+	for i, item := range items {
+		if item.Embedding.SubCount < 1 {
+			bErr.Add(i, errors.ErrValidationFailed.Clone().
+				WithMessage("embedding sub count must be at least 1").
+				WithDetails(fmt.Sprintf("got: %d", item.Embedding.SubCount)))
+			continue
+		}
+
+		model, ok := cached[item.ModelID]
+		if !ok {
+			var err error
+			if model, err = s.Queries.GetModelByID(ctx, item.ModelID); err != nil {
+				bErr.Add(i, handlePgxErr(err))
+				continue
+			}
+			cached[item.ModelID] = model
+		}
+		if err := checkModelActive(model); err != nil {
+			bErr.Add(i, err)
+			continue
+		}
+		if err := checkModelDim(model, item.Embedding.Values); err != nil {
+			bErr.Add(i, err)
+			continue
+		}
+
+		params[i] = models.InsertUsersEmbeddingBatchParams{
+			ArticleID: item.ArticleID,
+			ChunkID:   item.ChunkID,
+			ModelID:   item.ModelID,
+			Vector:    utils.ToPgVector(item.Embedding.Values),
+			WasSplit:  item.Embedding.WasSplit,
+			SubCount:  int32(item.Embedding.SubCount),
+		}
+	}
+	if !bErr.IsEmpty() {
+		return nil, bErr.ToError()
+	}
+
+	ids := make([]int32, len(items))
+	s.Queries.InsertUsersEmbeddingBatch(ctx, params).QueryRow(func(i int, eID int32, err error) {
+		if err != nil && err != pgx.ErrNoRows {
+			bErr.Add(i, handlePgxErr(err))
+		}
+		ids[i] = eID
+	})
+
+	if !bErr.IsEmpty() {
+		return nil, bErr.ToError()
+	}
+	return ids, nil
+}
+
+// The limits of the number of chunks a similarity search of the embeddings
+// returns.
+const (
+	DefaultSearchLimit = 10
+	// MaxSearchLimit is the default hnsw.ef_search of pgvector, the most rows
+	// a scan of the HNSW index of a model returns.
+	MaxSearchLimit = 40
+)
+
+// SimilarChunk is a chunk matched by a similarity search of the embeddings,
+// with its offsets in the content of its article and its cosine distance to
+// the query.
+type SimilarChunk struct {
+	ArticleID int32 `json:"article_id"`
+	llm.ChunkOffsets
+	Distance float64 `json:"distance"`
+}
+
+// SearchSimilar returns up to limit chunks of the user articles nearest to
+// query under the model of mID, the nearest first. The length of query must
+// be the dimension of the model. Only the chunks with a cosine similarity, 1
+// minus the distance, of at least minScore are returned.
+// A limit of zero or less falls back to DefaultSearchLimit, one above
+// MaxSearchLimit is clamped.
+func (s UserEmbeddings) SearchSimilar(ctx context.Context, mID int32, query []float32,
+	limit int, minScore float32) ([]SimilarChunk, error) {
+	q, arg, err := s.searchSimilarParams(ctx, "SearchSimilar", mID, query, limit, minScore)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.SearchSimilarUsersEmbeddings(ctx, models.SearchSimilarUsersEmbeddingsParams(arg))
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	chunks := make([]SimilarChunk, len(rows))
+	for i, row := range rows {
+		chunks[i] = toSimilarChunk(models.SearchSimilarEmbeddingsRow(row))
+	}
+	return chunks, nil
+}
+
+// SearchSimilarShared is SearchSimilar over the chunks of the shared articles
+// instead of the user articles, to compare a user article against the press
+// releases of the parties.
+func (s UserEmbeddings) SearchSimilarShared(ctx context.Context, mID int32, query []float32,
+	limit int, minScore float32) ([]SimilarChunk, error) {
+	q, arg, err := s.searchSimilarParams(ctx, "SearchSimilarShared", mID, query, limit, minScore)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.SearchSimilarEmbeddings(ctx, arg)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	chunks := make([]SimilarChunk, len(rows))
+	for i, row := range rows {
+		chunks[i] = toSimilarChunk(row)
+	}
+	return chunks, nil
+}
+
+// searchSimilarParams returns the params of the search by method and its
+// queries typed with the dimension of the model of mID by withModelDim.
+func (s UserEmbeddings) searchSimilarParams(ctx context.Context, method string, mID int32, query []float32,
+	limit int, minScore float32) (*models.Queries, models.SearchSimilarEmbeddingsParams, error) {
+	if minScore < -1 || minScore > 1 {
+		return nil, models.SearchSimilarEmbeddingsParams{}, errors.ErrValidationFailed.Clone().
+			WithMessage("min score must be between -1 and 1").
+			WithDetails(fmt.Sprintf("got: %v", minScore))
+	}
+	q, err := s.withModelDim(ctx, "UserEmbeddings", method, mID, query)
+	if err != nil {
+		return nil, models.SearchSimilarEmbeddingsParams{}, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	return q, models.SearchSimilarEmbeddingsParams{
+		Query:       utils.ToPgVector(query),
+		ModelID:     mID,
+		MaxDistance: 1 - float64(minScore),
+		Limit:       int32(min(limit, MaxSearchLimit)),
+	}, nil
+}
+
+// checkModelDim checks the length of embedding against the dimension of model.
+func checkModelDim(model models.GetModelByIDRow, embedding []float32) error {
+	if len(embedding) != int(model.Dim) {
+		return errors.ErrValidationFailed.Clone().
+			WithMessage("embedding length must match the dimension of the model").
+			WithDetails(fmt.Sprintf("model ID: %d, dimension: %d, got: %d", model.ID, model.Dim, len(embedding)))
+	}
+	return nil
+}
+
+// checkModelActive checks that model is active. The embeddings of a
+// deactivated model are still searched, no new one is inserted under it.
+func checkModelActive(model models.GetModelByIDRow) error {
+	if !model.Active {
+		return errors.ErrConflict.Clone().
+			WithMessage("model is deactivated").
+			WithDetails(fmt.Sprintf("model ID: %d, name: %s", model.ID, model.Name))
+	}
+	return nil
+}
+
+func toSimilarChunk(row models.SearchSimilarEmbeddingsRow) SimilarChunk {
+	return SimilarChunk{
+		ArticleID: row.ArticleID,
+		ChunkOffsets: llm.ChunkOffsets{
+			ID:          row.ChunkID,
+			Start:       row.Start,
+			OffsetLeft:  row.OffsetLeft,
+			OffsetRight: row.OffsetRight,
+			End:         row.End,
+		},
+		Distance: row.Distance,
+	}
+}
+
 // Article provides methods to manage articles in the database.
 type Article struct {
 	Storage
 }
 
 // Insert inserts a new article into the database and returns the article ID.
+// modifiedAt is the modification time declared by the publisher, zero if it
+// declares none. The articles counter is bumped in the same transaction.
 func (a Article) Insert(ctx context.Context, url, title, source, md5, content string,
-	cuts []int32, publishedAt time.Time) (int32, error) {
+	cuts []int32, publishedAt, modifiedAt time.Time) (int32, error) {
 	tsz, err := utils.TimeTo.PGTimestamptz(publishedAt)
 	if err != nil {
 		return 0, errors.ErrDBTypeConversionError.Clone().
@@ -233,7 +854,20 @@ func (a Article) Insert(ctx context.Context, url, title, source, md5, content st
 			WithDetails(fmt.Sprintf("time: %v", publishedAt.Format(time.DateTime))).
 			Warp(err)
 	}
-	aid, err := a.Queries.InsertArticle(ctx, models.InsertArticleParams{
+
+	mTsz, err := modifiedTsz(modifiedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := a.Queries.WithTx(tx)
+	aid, err := q.InsertArticle(ctx, models.InsertArticleParams{
 		Title:       title,
 		Url:         url,
 		Source:      source,
@@ -241,25 +875,98 @@ func (a Article) Insert(ctx context.Context, url, title, source, md5, content st
 		Content:     content,
 		Cuts:        cuts,
 		PublishedAt: tsz,
+		ModifiedAt:  mTsz,
 	})
-	return aid, handlePgxErr(err)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	if err = IncrementCounter(ctx, q, CounterArticles, 1); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, handlePgxErr(err)
+	}
+	return aid, nil
+}
+
+// UpsertByMD5 inserts an article the way Insert does, unless one of md5 is
+// stored already, and returns the ID of the article either way. inserted is
+// false for an existing article, which is left as it is and not counted
+// again.
+func (a Article) UpsertByMD5(ctx context.Context, url, title, source, md5, content string,
+	cuts []int32, publishedAt, modifiedAt time.Time) (aID int32, inserted bool, err error) {
+	tsz, err := utils.TimeTo.PGTimestamptz(publishedAt)
+	if err != nil {
+		return 0, false, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", publishedAt.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	mTsz, err := modifiedTsz(modifiedAt)
+	if err != nil {
+		return 0, false, err
+	}
+
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return 0, false, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := a.Queries.WithTx(tx)
+	row, err := q.UpsertArticleByMD5(ctx, models.UpsertArticleByMD5Params{
+		Title:       title,
+		Url:         url,
+		Source:      source,
+		Md5:         md5,
+		Content:     content,
+		Cuts:        cuts,
+		PublishedAt: tsz,
+		ModifiedAt:  mTsz,
+	})
+	if err != nil {
+		return 0, false, handlePgxErr(err)
+	}
+
+	if row.Inserted {
+		if err = IncrementCounter(ctx, q, CounterArticles, 1); err != nil {
+			return 0, false, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, false, handlePgxErr(err)
+	}
+	return row.ID, row.Inserted, nil
+}
+
+// ExistsByMD5 reports whether an article of md5 is stored.
+func (a Article) ExistsByMD5(ctx context.Context, md5 string) (bool, error) {
+	found, err := a.querier(ctx, "Article", "ExistsByMD5").ExistsArticleByMD5(ctx, md5)
+	if err != nil {
+		return false, handlePgxErr(err)
+	}
+	return found, nil
 }
 
 // GetByArticleID retrieves an article by its ID.
 func (a Article) GetByArticleID(ctx context.Context, aID int32) (models.Article, error) {
-	article, err := a.Queries.GetArticleByID(ctx, aID)
+	article, err := a.querier(ctx, "Article", "GetByArticleID").GetArticleByID(ctx, aID)
 	return article, handlePgxErr(err)
 }
 
 // GetByTaskID retrieves an article by its associated task ID.
 func (a Article) GetByMD5(ctx context.Context, md5 string) (models.Article, error) {
-	article, err := a.Queries.GetArticleByMD5(ctx, md5)
+	article, err := a.querier(ctx, "Article", "GetByMD5").GetArticleByMD5(ctx, md5)
 	return article, handlePgxErr(err)
 }
 
 // GetByUrl retrieves an article by its URL.
 func (a Article) GetByUrl(ctx context.Context, url string) (models.Article, error) {
-	article, err := a.Queries.GetArticleByURL(ctx, url)
+	article, err := a.querier(ctx, "Article", "GetByUrl").GetArticleByURL(ctx, url)
 	return article, handlePgxErr(err)
 }
 
@@ -281,7 +988,7 @@ func (a Article) GetArticleWithinTimeInterval(ctx context.Context, start, end ti
 			Warp(err)
 	}
 
-	articles, err := a.Queries.GetArticleWithinTimeInterval(ctx,
+	articles, err := a.querier(ctx, "Article", "GetArticleWithinTimeInterval").GetArticleWithinTimeInterval(ctx,
 		models.GetArticleWithinTimeIntervalParams{
 			Start: aTsz,
 			End:   bTsz,
@@ -292,7 +999,7 @@ func (a Article) GetArticleWithinTimeInterval(ctx context.Context, start, end ti
 
 // GetByPublishedInPastKDays retrieves articles published in the past K days, limited to a specified number.
 func (a Article) GetByPublishedInPastKDays(ctx context.Context, k, limit int32) ([]models.Article, error) {
-	articles, err := a.Queries.GetArticlesInPastKDays(ctx,
+	articles, err := a.querier(ctx, "Article", "GetByPublishedInPastKDays").GetArticlesInPastKDays(ctx,
 		models.GetArticlesInPastKDaysParams{
 			K:     k,
 			Limit: limit,
@@ -357,7 +1064,7 @@ func (c Chunck) BatchInsert(ctx context.Context, aID int32, paragraphs []string,
 
 // ExtractByArticleID retrieves all chunks associated with a specific article ID.
 func (c Chunck) ExtractByArticleID(ctx context.Context, aID int32) ([]string, error) {
-	rows, err := c.Queries.ExtractChunks(ctx, aID)
+	rows, err := c.querier(ctx, "Chunck", "ExtractByArticleID").ExtractChunks(ctx, aID)
 	if err != nil {
 		return nil, handlePgxErr(err)
 	}
@@ -0,0 +1,185 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserArticlesUpsertByMD5(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	taskID, err := s.Task().InsertFromText(ctx, "upsert "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	title := "upsert " + uuid.NewString()
+	published := time.Now()
+	md5 := storage.MD5(title, "test", published)
+
+	found, err := s.UserArticles().ExistsByMD5(ctx, taskID, md5)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	aID, inserted, err := s.UserArticles().UpsertByMD5(ctx, taskID, title, "test",
+		"content of the article", nil, published, time.Time{})
+	require.NoError(t, err)
+	require.True(t, inserted)
+
+	found, err = s.UserArticles().ExistsByMD5(ctx, taskID, md5)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	// scraping the article again returns the stored one, left as it is
+	again, inserted, err := s.UserArticles().UpsertByMD5(ctx, taskID, title, "test",
+		"content of the article, scraped again", nil, published, time.Time{})
+	require.NoError(t, err)
+	require.False(t, inserted)
+	require.Equal(t, aID, again)
+
+	article, err := s.UserArticles().GetByMD5(ctx, taskID, md5)
+	require.NoError(t, err)
+	require.Equal(t, "content of the article", article.Content)
+
+	// the plain insert still fails on the MD5
+	_, err = s.UserArticles().Insert(ctx, taskID, title, "test",
+		"content of the article", nil, published, time.Time{}, nil)
+	require.Error(t, err)
+
+	// the same article submitted by another task gets its own copy, which the
+	// article of the first task does not count for
+	otherID, err := s.Task().InsertFromText(ctx, "upsert "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	found, err = s.UserArticles().ExistsByMD5(ctx, otherID, md5)
+	require.NoError(t, err)
+	require.False(t, found)
+	_, err = s.UserArticles().GetByMD5(ctx, otherID, md5)
+	require.Error(t, err)
+
+	other, inserted, err := s.UserArticles().UpsertByMD5(ctx, otherID, title, "test",
+		"content of the article", nil, published, time.Time{})
+	require.NoError(t, err)
+	require.True(t, inserted)
+	require.NotEqual(t, aID, other)
+
+	article, err = s.UserArticles().GetByTaskID(ctx, otherID)
+	require.NoError(t, err)
+	require.Equal(t, other, article.ID)
+	require.Equal(t, otherID, article.TaskID)
+
+	article, err = s.UserArticles().GetByMD5(ctx, otherID, md5)
+	require.NoError(t, err)
+	require.Equal(t, other, article.ID)
+}
+
+func TestUserArticlesReplace(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// a done task whose doubtful extraction has been dismissed
+	taskID, err := s.Task().InsertFromText(ctx, "replace "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	published := time.Now()
+	aID, err := s.UserArticles().Insert(ctx, taskID, "", "test", "short", nil,
+		published, time.Time{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.UserArticles().SetNeedsReview(ctx, aID, true))
+	_, err = s.UserChunks().Insert(ctx, aID, 0, 0, 5, 5)
+	require.NoError(t, err)
+	_, err = s.Keywords().InsertForUserArticle(ctx, aID, "zh-Hant", []string{"replace " + uuid.NewString()})
+	require.NoError(t, err)
+	item, err := s.ReviewQueue().Enqueue(ctx, storage.ReviewItem{
+		Type:      models.ReviewItemTypeExtraction,
+		ArticleID: aID,
+		Reason:    "missing title",
+	})
+	require.NoError(t, err)
+	for _, stage := range []string{"scrape", "extract_keywords"} {
+		require.NoError(t, appendStage(ctx, s, taskID, stage, models.TaskStatusDone, ""))
+	}
+
+	// the done task cannot be appended to, only reopened
+	err = appendStage(ctx, s, taskID, "scrape", models.TaskStatusProcessing, "")
+	requireConflict(t, err)
+	state, err := s.TaskEvents().Reopen(ctx, taskID, storage.TaskEvent{
+		Stage:  "scrape",
+		Status: models.TaskStatusProcessing,
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusProcessing, state.Status)
+
+	title := "replace " + uuid.NewString()
+	require.NoError(t, s.UserArticles().Replace(ctx, taskID, aID, title, "test",
+		"the content of the article scraped again", nil, published, time.Time{}))
+
+	article, err := s.UserArticles().GetByTaskID(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, aID, article.ID)
+	require.Equal(t, title, article.Title)
+	require.Equal(t, "the content of the article scraped again", article.Content)
+	require.Equal(t, storage.MD5(title, "test", published), article.Md5)
+	require.False(t, article.NeedsReview)
+
+	chunks, err := s.UserChunks().GetByArticleID(ctx, aID)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+	keywords, err := s.Keywords().GetByArticleID(ctx, aID)
+	require.NoError(t, err)
+	require.Empty(t, keywords)
+
+	// the resolved review item is kept
+	_, err = s.ReviewQueue().Get(ctx, item.ID)
+	require.NoError(t, err)
+
+	// the article of another task is not replaced
+	otherID, err := s.Task().InsertFromText(ctx, "replace "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	err = s.UserArticles().Replace(ctx, otherID, aID, title, "test", "content", nil,
+		published, time.Time{})
+	var e *ec.Error
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, ec.ErrNotFound.HttpStatusCode, e.HttpStatusCode)
+}
+
+func TestArticleUpsertByMD5(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.Counters().Reconcile(ctx, storage.DefaultCounterDriftThreshold)
+	require.NoError(t, err)
+	before := counterValue(t, s, storage.CounterArticles)
+
+	title := "upsert " + uuid.NewString()
+	md5 := uuid.NewString()
+	a := storage.Article{Storage: s}
+	aID, inserted, err := a.UpsertByMD5(ctx, "https://example.com/"+title, title,
+		"test", md5, "content", nil, time.Now(), time.Time{})
+	require.NoError(t, err)
+	require.True(t, inserted)
+
+	again, inserted, err := a.UpsertByMD5(ctx, "https://example.com/"+title, title,
+		"test", md5, "content", nil, time.Now(), time.Time{})
+	require.NoError(t, err)
+	require.False(t, inserted)
+	require.Equal(t, aID, again)
+
+	// an existing article is not counted again
+	require.Equal(t, before+1, counterValue(t, s, storage.CounterArticles))
+
+	found, err := a.ExistsByMD5(ctx, md5)
+	require.NoError(t, err)
+	require.True(t, found)
+}
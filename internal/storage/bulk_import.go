@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultImportBatchSize is the number of articles Article.BulkImport copies
+// in a transaction.
+const DefaultImportBatchSize = 1000
+
+// ImportRow is an article of an archive imported by Article.BulkImport. A zero
+// Party is PartyNone, a zero ModifiedAt declares no modification time.
+type ImportRow struct {
+	URL         string
+	Title       string
+	Source      string
+	Party       models.Party
+	Content     string
+	Cuts        []int32
+	PublishedAt time.Time
+	ModifiedAt  time.Time
+}
+
+// ImportStats counts the articles of a bulk import. Skipped are the articles
+// stored already, Failed those the iterator failed to read or which are
+// invalid.
+type ImportStats struct {
+	Inserted int64 `json:"inserted"`
+	Skipped  int64 `json:"skipped"`
+	Failed   int64 `json:"failed"`
+}
+
+// importColumns are the columns of the staging table, in the order of the
+// values of importValues.
+var importColumns = []string{
+	"title", "url", "source", "party", "content", "cuts", "published_at", "modified_at",
+}
+
+const (
+	createImportTable = `CREATE TEMP TABLE articles_import (
+    title text NOT NULL,
+    url text NOT NULL,
+    source text NOT NULL,
+    party text NOT NULL,
+    content text NOT NULL,
+    cuts integer[] NOT NULL,
+    published_at timestamp with time zone NOT NULL,
+    modified_at timestamp with time zone
+)`
+
+	// the md5 is the one of MD5, the publication date taken in UTC
+	insertImported = `INSERT INTO articles (
+        title,
+        "url",
+        source,
+        md5,
+        party,
+        content,
+        cuts,
+        published_at,
+        modified_at
+    )
+SELECT title,
+    "url",
+    source,
+    encode(decode(md5(title || "url" || to_char(published_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')), 'hex'), 'base64'),
+    party::party,
+    content,
+    cuts,
+    published_at,
+    modified_at
+FROM articles_import
+ON CONFLICT (md5) DO NOTHING`
+
+	dropImportTable = `DROP TABLE articles_import`
+)
+
+// importValues validates row and returns its values in the order of
+// importColumns.
+func importValues(row *ImportRow) ([]any, error) {
+	switch {
+	case strings.TrimSpace(row.URL) == "":
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("url should not be empty")
+	case strings.TrimSpace(row.Title) == "":
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("title should not be empty").
+			WithDetails(fmt.Sprintf("url: %s", row.URL))
+	case row.PublishedAt.IsZero():
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("published at should not be zero").
+			WithDetails(fmt.Sprintf("url: %s", row.URL))
+	}
+
+	party := row.Party
+	if party == "" {
+		party = models.PartyNone
+	}
+	if !party.Valid() {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid party").
+			WithDetails(fmt.Sprintf("url: %s, party: %q", row.URL, row.Party))
+	}
+
+	cuts := row.Cuts
+	if cuts == nil {
+		cuts = []int32{}
+	}
+
+	var modifiedAt any
+	if !row.ModifiedAt.IsZero() {
+		modifiedAt = row.ModifiedAt
+	}
+	return []any{
+		row.Title, row.URL, row.Source, string(party), row.Content, cuts, row.PublishedAt, modifiedAt,
+	}, nil
+}
+
+// BulkImport inserts the articles iter yields until it returns a nil row and
+// a nil error, e.g. the press releases of a scraped archive, skipping those
+// stored already. It is the bulk counterpart of UpsertByMD5: the md5 of an
+// article is computed as MD5 does.
+//
+// The articles are copied into a staging table and inserted from it by
+// DefaultImportBatchSize, a transaction each, so that the batches imported
+// stay imported should a later one fail. An error of iter or an invalid row
+// fails that article only, it is logged and the import goes on, while a
+// failing batch stops the import, its articles counted as failed.
+func (a Article) BulkImport(ctx context.Context, iter func() (*ImportRow, error)) (ImportStats, error) {
+	var stats ImportStats
+	batch := make([][]any, 0, DefaultImportBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		n, err := a.importBatch(ctx, batch)
+		if err != nil {
+			stats.Failed += int64(len(batch))
+			return err
+		}
+		stats.Inserted += n
+		stats.Skipped += int64(len(batch)) - n
+		batch = batch[:0]
+		return nil
+	}
+
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		row, err := iter()
+		if err == nil && row == nil {
+			break
+		}
+
+		var values []any
+		if err == nil {
+			values, err = importValues(row)
+		}
+		if err != nil {
+			global.Logger.Warn().
+				Err(err).
+				Int("row", i).
+				Msg("Failed to import article")
+			stats.Failed++
+			continue
+		}
+
+		if batch = append(batch, values); len(batch) == DefaultImportBatchSize {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// importBatch inserts the articles of batch and returns the number of those
+// inserted. The articles counter is bumped in the same transaction.
+func (a Article) importBatch(ctx context.Context, batch [][]any) (int64, error) {
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, createImportTable); err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"articles_import"}, importColumns,
+		pgx.CopyFromRows(batch)); err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	tag, err := tx.Exec(ctx, insertImported)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	// the staging table would outlive a savepoint of WithTx
+	if _, err := tx.Exec(ctx, dropImportTable); err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	if err := IncrementCounter(ctx, a.Queries.WithTx(tx), CounterArticles, tag.RowsAffected()); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, handlePgxErr(err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,86 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArticleBulkImport(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	_, err := s.Counters().Reconcile(ctx, storage.DefaultCounterDriftThreshold)
+	require.NoError(t, err)
+	before := counterValue(t, s, storage.CounterArticles)
+
+	// more articles than a batch, so that they are imported in two
+	prefix := "import " + uuid.NewString()
+	published := time.Date(2024, 5, 20, 23, 30, 0, 0, time.FixedZone("CST", 8*3600))
+	rows := make([]*storage.ImportRow, storage.DefaultImportBatchSize+2)
+	for i := range rows {
+		rows[i] = &storage.ImportRow{
+			URL:         fmt.Sprintf("https://example.com/%s/%d", prefix, i),
+			Title:       fmt.Sprintf("%s %d", prefix, i),
+			Source:      "test",
+			Party:       models.PartyKMT,
+			Content:     "content of the press release",
+			PublishedAt: published,
+		}
+	}
+	rows[1].Party = ""
+	rows[1].ModifiedAt = published.Add(time.Hour)
+
+	// one article is stored already, another is in the archive twice
+	a := storage.Article{Storage: s}
+	stored := rows[0]
+	md5 := storage.MD5(stored.Title, stored.URL, stored.PublishedAt)
+	storedID, inserted, err := a.UpsertByMD5(ctx, stored.URL, stored.Title, stored.Source,
+		md5, stored.Content, nil, stored.PublishedAt, time.Time{})
+	require.NoError(t, err)
+	require.True(t, inserted)
+	archive := append(rows, rows[2], nil, &storage.ImportRow{Title: "no url", PublishedAt: published})
+
+	stats, err := a.BulkImport(ctx, importRows(archive...))
+	require.NoError(t, err)
+	require.Equal(t, storage.ImportStats{
+		Inserted: int64(len(rows) - 1),
+		Skipped:  2,
+		Failed:   2,
+	}, stats)
+	require.Equal(t, before+int64(len(rows)), counterValue(t, s, storage.CounterArticles))
+
+	// the md5 is the one of MD5, the stored article left as it is
+	article, err := a.GetByMD5(ctx, md5)
+	require.NoError(t, err)
+	require.Equal(t, storedID, article.ID)
+
+	article, err = a.GetByMD5(ctx, storage.MD5(rows[1].Title, rows[1].URL, rows[1].PublishedAt))
+	require.NoError(t, err)
+	require.Equal(t, rows[1].URL, article.Url)
+	require.Equal(t, models.PartyNone, article.Party)
+	require.Equal(t, []int32{}, article.Cuts)
+	require.True(t, article.ModifiedAt.Valid)
+	require.WithinDuration(t, rows[1].ModifiedAt, article.ModifiedAt.Time, time.Second)
+
+	last := rows[len(rows)-1]
+	article, err = a.GetByMD5(ctx, storage.MD5(last.Title, last.URL, last.PublishedAt))
+	require.NoError(t, err)
+	require.Equal(t, models.PartyKMT, article.Party)
+	require.False(t, article.ModifiedAt.Valid)
+
+	// importing the archive again inserts nothing
+	stats, err = a.BulkImport(ctx, importRows(rows...))
+	require.NoError(t, err)
+	require.Equal(t, storage.ImportStats{Skipped: int64(len(rows))}, stats)
+}
@@ -0,0 +1,62 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// importRows returns an iterator over rows, failing on the nil ones.
+func importRows(rows ...*storage.ImportRow) func() (*storage.ImportRow, error) {
+	return func() (*storage.ImportRow, error) {
+		if len(rows) == 0 {
+			return nil, nil
+		}
+
+		row := rows[0]
+		rows = rows[1:]
+		if row == nil {
+			return nil, errors.New("malformed archive")
+		}
+		return row, nil
+	}
+}
+
+func TestArticleBulkImportValidation(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	t.Run("invalid rows", func(t *testing.T) {
+		db := &fakeDB{}
+		a := storage.Article{Storage: storage.New(db, nil)}
+
+		stats, err := a.BulkImport(ctx, importRows(
+			nil,
+			&storage.ImportRow{Title: "title", PublishedAt: now},
+			&storage.ImportRow{URL: "https://example.com/1", PublishedAt: now},
+			&storage.ImportRow{URL: "https://example.com/2", Title: "title"},
+			&storage.ImportRow{URL: "https://example.com/3", Title: "title", PublishedAt: now, Party: "unknown"},
+		))
+		require.NoError(t, err)
+		require.Equal(t, storage.ImportStats{Failed: 5}, stats)
+		require.Zero(t, db.calls)
+	})
+
+	t.Run("failing batch", func(t *testing.T) {
+		db := &fakeDB{}
+		a := storage.Article{Storage: storage.New(db, nil)}
+
+		stats, err := a.BulkImport(ctx, importRows(
+			nil,
+			&storage.ImportRow{URL: "https://example.com/1", Title: "title", PublishedAt: now},
+			&storage.ImportRow{URL: "https://example.com/2", Title: "title", PublishedAt: now},
+		))
+		require.Error(t, err)
+		require.Equal(t, storage.ImportStats{Failed: 3}, stats)
+		require.Equal(t, 1, db.calls)
+	})
+}
@@ -0,0 +1,131 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/pipeline"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserChunksInsertContent(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	paragraph := strings.Repeat("新北三峽發生重大車禍，交通部宣布下修高齡換照年齡。", 200)
+	tcs := []struct {
+		Name       string
+		Paragraphs int
+	}{
+		{"in memory", 2},
+		{"streamed", pipeline.DefaultStreamThreshold/len(paragraph) + 1},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			paragraphs := make([]string, tc.Paragraphs)
+			for i := range paragraphs {
+				paragraphs[i] = paragraph
+			}
+			content, cuts := utils.Join(paragraphs)
+			cuts32 := make([]int32, len(cuts))
+			for i, cut := range cuts {
+				cuts32[i] = int32(cut)
+			}
+
+			taskID, err := s.Task().InsertFromText(ctx, "chunks "+uuid.NewString(), nil)
+			require.NoError(t, err)
+			aID, err := s.UserArticles().Insert(ctx, taskID, "chunks "+uuid.NewString(), "test",
+				content, cuts32, time.Now(), time.Time{}, nil)
+			require.NoError(t, err)
+
+			var got []pipeline.Chunk
+			n, err := s.UserChunks().InsertContent(ctx, aID, content, cuts32, 512, 64,
+				func(_ context.Context, chunks []pipeline.Chunk) error {
+					require.LessOrEqual(t, len(chunks), storage.DefaultChunkBatchSize)
+					got = append(got, chunks...)
+					return nil
+				})
+			require.NoError(t, err)
+
+			want, err := llm.ChunckParagraphsOffsets(paragraphs, 512, 64)
+			require.NoError(t, err)
+			require.Equal(t, len(want), n)
+			require.Len(t, got, len(want))
+			for i, c := range got {
+				require.NotZero(t, c.ID)
+				want[i].ID = c.ID
+				require.Equal(t, want[i], c.ChunkOffsets)
+			}
+
+			stored, err := s.UserChunks().ExtractByArticleID(ctx, aID)
+			require.NoError(t, err)
+			require.Len(t, stored, n)
+		})
+	}
+}
+
+func TestUserChunksGetWithContent(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	paragraphs := []string{
+		strings.Repeat("新北三峽發生重大車禍。", 40),
+		strings.Repeat("交通部宣布下修高齡換照年齡。", 40),
+	}
+	content, cuts := utils.Join(paragraphs)
+	cuts32 := make([]int32, len(cuts))
+	for i, cut := range cuts {
+		cuts32[i] = int32(cut)
+	}
+
+	taskID, err := s.Task().InsertFromText(ctx, "chunks "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	aID, err := s.UserArticles().Insert(ctx, taskID, "chunks "+uuid.NewString(), "test",
+		content, cuts32, time.Now(), time.Time{}, nil)
+	require.NoError(t, err)
+
+	offsets, err := s.UserChunks().BatchInsert(ctx, aID, paragraphs, 128, 16)
+	require.NoError(t, err)
+	require.Greater(t, len(offsets), 1)
+
+	chunks, err := s.UserChunks().GetByArticleID(ctx, aID)
+	require.NoError(t, err)
+	require.Len(t, chunks, len(offsets))
+	for i, c := range chunks {
+		require.Equal(t, aID, c.ArticleID)
+		require.Equal(t, offsets[i], llm.ChunkOffsets{
+			ID:          c.ID,
+			Start:       c.Start,
+			OffsetLeft:  c.OffsetLeft,
+			OffsetRight: c.OffsetRight,
+			End:         c.End,
+		})
+	}
+
+	withContent, err := s.UserChunks().GetWithContent(ctx, aID)
+	require.NoError(t, err)
+	require.Len(t, withContent, len(offsets))
+	for i, c := range withContent {
+		require.Equal(t, chunks[i], c.Chunk)
+		text, _, _, _, err := llm.ExtractChunk(content, offsets[i])
+		require.NoError(t, err)
+		require.Equal(t, text, c.Content)
+	}
+
+	chunks, err = s.UserChunks().GetByArticleID(ctx, -1)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/jackc/pgx/v5"
+)
+
+// Running totals, bumped in the same transaction as the insert they count.
+const (
+	CounterArticles = "articles"
+	CounterTasks    = "tasks"
+)
+
+// Rollups, recomputed by Counters.Rollup.
+const (
+	CounterKeywords            = "keywords"
+	CounterArticlesThisWeek    = "articles_this_week"
+	CounterTasksProcessedToday = "tasks_processed_today"
+)
+
+// DefaultCounterDriftThreshold is the drift of a running total above which
+// Counters.Reconcile logs a warning.
+const DefaultCounterDriftThreshold = 10
+
+// IncrementCounter atomically adds delta to the named counter. q should be
+// bound to the transaction of the insert being counted, so the counter is
+// only bumped if the transaction commits.
+func IncrementCounter(ctx context.Context, q models.Querier, name string, delta int64) error {
+	if err := q.IncrementCounter(ctx, models.IncrementCounterParams{
+		Name:  name,
+		Delta: delta,
+	}); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
+
+func (s Storage) Counters() Counters {
+	return Counters{s}
+}
+
+// Counters provides methods to read and maintain the pre-aggregated counters.
+type Counters struct {
+	Storage
+}
+
+// List returns all counters.
+func (c Counters) List(ctx context.Context) ([]models.Counter, error) {
+	counters, err := c.querier(ctx, "Counters", "List").ListCounters(ctx)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return counters, nil
+}
+
+// Rollup recomputes the rollup counters: the articles published since the
+// start of the week (Monday), the tasks done since the start of the day, and
+// the keywords extracted.
+func (c Counters) Rollup(ctx context.Context, now time.Time) error {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	week := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+
+	weekTsz, err := utils.TimeTo.PGTimestamptz(week)
+	if err != nil {
+		return errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", week.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	dayTsz, err := utils.TimeTo.PGTimestamptz(day)
+	if err != nil {
+		return errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", day.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	rollups := map[string]func() (int64, error){
+		CounterArticlesThisWeek: func() (int64, error) {
+			return c.Queries.CountArticlesPublishedSince(ctx, weekTsz)
+		},
+		CounterTasksProcessedToday: func() (int64, error) {
+			return c.Queries.CountUsersTasksDoneSince(ctx, dayTsz)
+		},
+		CounterKeywords: func() (int64, error) {
+			return c.Queries.CountArticlesKeywords(ctx)
+		},
+	}
+
+	for name, count := range rollups {
+		value, err := count()
+		if err != nil {
+			return handlePgxErr(err)
+		}
+
+		if err := c.Queries.SetCounter(ctx, models.SetCounterParams{
+			Name:  name,
+			Value: value,
+		}); err != nil {
+			return handlePgxErr(err)
+		}
+	}
+	return nil
+}
+
+// CounterDrift is the difference between a running total and the real count.
+type CounterDrift struct {
+	Name    string `json:"name"`
+	Counter int64  `json:"counter"`
+	Actual  int64  `json:"actual"`
+}
+
+// Diff returns how much the counter has to be adjusted to match the real count.
+func (d CounterDrift) Diff() int64 {
+	return d.Actual - d.Counter
+}
+
+// DetectDrift compares the counters against the real counts and returns the
+// counters that drifted. A counter missing from counters is treated as zero.
+func DetectDrift(counters []models.Counter, actual map[string]int64) []CounterDrift {
+	values := make(map[string]int64, len(counters))
+	for _, c := range counters {
+		values[c.Name] = c.Value
+	}
+
+	drifts := []CounterDrift{}
+	for _, name := range []string{CounterArticles, CounterTasks} {
+		real, ok := actual[name]
+		if !ok {
+			continue
+		}
+
+		if d := (CounterDrift{Name: name, Counter: values[name], Actual: real}); d.Diff() != 0 {
+			drifts = append(drifts, d)
+		}
+	}
+	return drifts
+}
+
+// Reconcile compares the running totals against the real counts, logs the
+// drifts above threshold, and corrects all of them. The counters and the real
+// counts are read from the same snapshot, and the correction is applied as a
+// relative increment so that inserts committed meanwhile are not lost.
+func (c Counters) Reconcile(ctx context.Context, threshold int64) ([]CounterDrift, error) {
+	tx, err := c.db.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := c.Queries.WithTx(tx)
+	counters, err := q.ListCounters(ctx)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	actual := map[string]int64{}
+	if actual[CounterArticles], err = q.CountArticles(ctx); err != nil {
+		return nil, handlePgxErr(err)
+	}
+	if actual[CounterTasks], err = q.CountUsersTasks(ctx); err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	drifts := DetectDrift(counters, actual)
+	for _, d := range drifts {
+		if abs(d.Diff()) > threshold {
+			global.Logger.Warn().
+				Str("counter", d.Name).
+				Int64("counter_value", d.Counter).
+				Int64("actual_value", d.Actual).
+				Int64("threshold", threshold).
+				Msg("Counter drifted from the real count")
+		}
+
+		if err := IncrementCounter(ctx, c.Queries, d.Name, d.Diff()); err != nil {
+			return drifts, err
+		}
+	}
+	return drifts, nil
+}
+
+// Run recomputes the rollups every interval and reconciles the running totals
+// once a day, until ctx is cancelled.
+func (c Counters) Run(ctx context.Context, interval time.Duration, threshold int64) {
+	ticker := c.Clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	var reconciledOn string
+	for {
+		now := c.Clock().Now()
+		if err := c.Rollup(ctx, now); err != nil {
+			global.Logger.Error().Err(err).Msg("Failed to roll up counters")
+		}
+
+		if today := now.Format(time.DateOnly); today != reconciledOn {
+			if _, err := c.Reconcile(ctx, threshold); err != nil {
+				global.Logger.Error().Err(err).Msg("Failed to reconcile counters")
+			} else {
+				reconciledOn = today
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,138 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPool migrates the database at TEST_POSTGRES_URL (which needs the
+// pgvector extension) to the latest version and returns a pool to it.
+func newTestPool(t testing.TB) *pgxpool.Pool {
+	t.Helper()
+	dbURL := os.Getenv("TEST_POSTGRES_URL")
+	if dbURL == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	m, err := global.Migrate("file://../../migrations", dbURL)
+	require.NoError(t, err)
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		require.NoError(t, err)
+	}
+	m.Close()
+
+	pool, err := pgxpool.New(context.Background(), dbURL)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func newTestStorage(t testing.TB, pool *pgxpool.Pool) storage.Storage {
+	t.Helper()
+	conn, err := pool.Acquire(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(conn.Release)
+	return storage.New(conn, nil)
+}
+
+func counterValue(t *testing.T, s storage.Storage, name string) int64 {
+	t.Helper()
+	counters, err := s.Counters().List(context.Background())
+	require.NoError(t, err)
+	for _, c := range counters {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return 0
+}
+
+func TestCountersConcurrentInserts(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.Counters().Reconcile(ctx, storage.DefaultCounterDriftThreshold)
+	require.NoError(t, err)
+	before := counterValue(t, s, storage.CounterTasks)
+
+	const workers, inserts = 8, 25
+	var wg sync.WaitGroup
+	for range workers {
+		ws := newTestStorage(t, pool)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range inserts {
+				_, err := ws.Task().InsertFromText(ctx, "concurrent insert", nil)
+				require.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, before+workers*inserts, counterValue(t, s, storage.CounterTasks))
+
+	// a rolled back insert does not bump the counter
+	_, err = s.Task().InsertFromText(ctx, "rolled back", func(ctx context.Context, taskID uuid.UUID) error {
+		return errors.New("abort")
+	})
+	require.Error(t, err)
+	require.Equal(t, before+workers*inserts, counterValue(t, s, storage.CounterTasks))
+
+	drifts, err := s.Counters().Reconcile(ctx, storage.DefaultCounterDriftThreshold)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+}
+
+func TestCountersReconcileDrift(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.Counters().Reconcile(ctx, storage.DefaultCounterDriftThreshold)
+	require.NoError(t, err)
+	actual := counterValue(t, s, storage.CounterTasks)
+
+	require.NoError(t, s.Queries.SetCounter(ctx, models.SetCounterParams{
+		Name:  storage.CounterTasks,
+		Value: actual + 42,
+	}))
+
+	drifts, err := s.Counters().Reconcile(ctx, storage.DefaultCounterDriftThreshold)
+	require.NoError(t, err)
+	require.Equal(t, []storage.CounterDrift{
+		{Name: storage.CounterTasks, Counter: actual + 42, Actual: actual},
+	}, drifts)
+	require.Equal(t, actual, counterValue(t, s, storage.CounterTasks))
+
+	require.NoError(t, s.Counters().Rollup(ctx, time.Now()))
+	counters, err := s.Counters().List(ctx)
+	require.NoError(t, err)
+	names := []string{}
+	for _, c := range counters {
+		names = append(names, c.Name)
+	}
+	require.Subset(t, names, []string{
+		storage.CounterArticles,
+		storage.CounterTasks,
+		storage.CounterKeywords,
+		storage.CounterArticlesThisWeek,
+		storage.CounterTasksProcessedToday,
+	})
+}
@@ -0,0 +1,75 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectDrift(t *testing.T) {
+	tcs := []struct {
+		Name     string
+		Counters []models.Counter
+		Actual   map[string]int64
+		Expected []storage.CounterDrift
+	}{
+		{
+			Name: "No_Drift",
+			Counters: []models.Counter{
+				{Name: storage.CounterArticles, Value: 10},
+				{Name: storage.CounterTasks, Value: 3},
+			},
+			Actual: map[string]int64{
+				storage.CounterArticles: 10,
+				storage.CounterTasks:    3,
+			},
+			Expected: []storage.CounterDrift{},
+		},
+		{
+			Name: "Drift",
+			Counters: []models.Counter{
+				{Name: storage.CounterArticles, Value: 7},
+				{Name: storage.CounterTasks, Value: 5},
+			},
+			Actual: map[string]int64{
+				storage.CounterArticles: 10,
+				storage.CounterTasks:    3,
+			},
+			Expected: []storage.CounterDrift{
+				{Name: storage.CounterArticles, Counter: 7, Actual: 10},
+				{Name: storage.CounterTasks, Counter: 5, Actual: 3},
+			},
+		},
+		{
+			Name: "Missing_Counter",
+			Actual: map[string]int64{
+				storage.CounterArticles: 2,
+			},
+			Expected: []storage.CounterDrift{
+				{Name: storage.CounterArticles, Counter: 0, Actual: 2},
+			},
+		},
+		{
+			Name: "Rollups_Are_Ignored",
+			Counters: []models.Counter{
+				{Name: storage.CounterArticlesThisWeek, Value: 100},
+			},
+			Actual: map[string]int64{
+				storage.CounterArticlesThisWeek: 1,
+			},
+			Expected: []storage.CounterDrift{},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			drifts := storage.DetectDrift(tc.Counters, tc.Actual)
+			require.Equal(t, tc.Expected, drifts)
+		})
+	}
+
+	d := storage.CounterDrift{Counter: 7, Actual: 10}
+	require.Equal(t, int64(3), d.Diff())
+}
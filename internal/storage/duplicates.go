@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+)
+
+// The bounds of the search of the near duplicates of an article.
+const (
+	// DuplicateWindowDays is the number of days before and after the
+	// publication of an article its duplicates are published within.
+	DuplicateWindowDays = 7
+	MaxNearDuplicates   = 20
+)
+
+// FindNearDuplicates returns the articles whose content is at least threshold
+// similar to the one of the article of aID, by the similarity of pg_trgm, the
+// most similar first. They are the republications MD5 tells apart, e.g. the
+// press release of a party site republished by a news portal under another
+// URL. Only the articles published within DuplicateWindowDays of the article
+// are compared, and at most MaxNearDuplicates are returned. threshold is in
+// (0, 1], 1 matching the same trigrams only.
+func (a Article) FindNearDuplicates(ctx context.Context, aID int32, threshold float32) ([]models.FindNearDuplicateArticlesRow, error) {
+	if threshold <= 0 || threshold > 1 {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("threshold should be in (0, 1]").
+			WithDetails(fmt.Sprintf("got: %v", threshold))
+	}
+
+	tx, err := a.dbFor(ctx, "Article", "FindNearDuplicates").Begin(ctx)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// the % operator of pg_trgm, which the trigram index of the content
+	// serves, matches at the similarity threshold of the transaction
+	if _, err := tx.Exec(ctx, "SELECT set_config('pg_trgm.similarity_threshold', $1, true)",
+		strconv.FormatFloat(float64(threshold), 'f', -1, 32)); err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	rows, err := a.Queries.WithTx(tx).FindNearDuplicateArticles(ctx, models.FindNearDuplicateArticlesParams{
+		WindowDays: DuplicateWindowDays,
+		ID:         aID,
+		MaxResults: MaxNearDuplicates,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
+
+// MarkDuplicate links the article of aID, and the duplicates linked to it, to
+// the article of canonicalID, so that a duplicate is kept but not embedded
+// again. If the article of canonicalID is a duplicate itself they are linked
+// to its canonical article instead: a canonical article is never a duplicate.
+func (a Article) MarkDuplicate(ctx context.Context, aID, canonicalID int32) error {
+	if aID == canonicalID {
+		return ec.ErrValidationFailed.Clone().
+			WithMessage("an article cannot be a duplicate of itself").
+			WithDetails(fmt.Sprintf("article ID: %d", aID))
+	}
+
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := a.Queries.WithTx(tx)
+	canonical, err := q.GetArticleByID(ctx, canonicalID)
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	if canonical.CanonicalID.Valid {
+		canonicalID = canonical.CanonicalID.Int32
+	}
+	if canonicalID == aID {
+		return ec.ErrConflict.Clone().
+			WithMessage("the canonical article is a duplicate of the article").
+			WithDetails(fmt.Sprintf("article ID: %d, canonical ID: %d", aID, canonical.ID))
+	}
+
+	n, err := q.LinkArticleDuplicates(ctx, models.LinkArticleDuplicatesParams{
+		CanonicalID: canonicalID,
+		ID:          aID,
+	})
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	if n == 0 {
+		return ec.ErrNotFound.Clone().
+			WithMessage("article not found").
+			WithDetails(fmt.Sprintf("article ID: %d", aID))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArticleNearDuplicates(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	a := storage.Article{Storage: s}
+	marker := uuid.NewString()
+	release := marker + " the legislative yuan passed the budget of the next year after a long debate " +
+		strings.Repeat("on the spending of the ministries ", 5)
+	published := time.Now().Add(-24 * time.Hour)
+	rows := []*storage.ImportRow{
+		// the press release of the party site
+		{URL: "https://party.example.com/" + marker, Title: "budget passed", Source: "party",
+			Party: models.PartyKMT, Content: release, PublishedAt: published},
+		// republished by a portal the next day, with a byline
+		{URL: "https://portal.example.com/" + marker, Title: "the budget passed", Source: "portal",
+			Content: "(portal) " + release, PublishedAt: published.Add(20 * time.Hour)},
+		// republished again, out of the window
+		{URL: "https://late.example.com/" + marker, Title: "budget passed", Source: "late",
+			Content: release, PublishedAt: published.Add(-(storage.DuplicateWindowDays + 1) * 24 * time.Hour)},
+		// another article of the same day
+		{URL: "https://other.example.com/" + marker, Title: "typhoon", Source: "portal",
+			Content: marker + " a typhoon is approaching the east coast", PublishedAt: published},
+	}
+	stats, err := a.BulkImport(ctx, importRows(rows...))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(rows)), stats.Inserted)
+
+	ids := make([]int32, len(rows))
+	for i, row := range rows {
+		article, err := a.GetByMD5(ctx, storage.MD5(row.Title, row.URL, row.PublishedAt))
+		require.NoError(t, err)
+		ids[i] = article.ID
+	}
+	party, portal, late, other := ids[0], ids[1], ids[2], ids[3]
+
+	dups, err := a.FindNearDuplicates(ctx, portal, 0.8)
+	require.NoError(t, err)
+	require.Len(t, dups, 1)
+	require.Equal(t, party, dups[0].ID)
+	require.GreaterOrEqual(t, dups[0].Similarity, float32(0.8))
+	require.False(t, dups[0].CanonicalID.Valid)
+
+	dups, err = a.FindNearDuplicates(ctx, other, 0.8)
+	require.NoError(t, err)
+	require.Empty(t, dups)
+
+	require.NoError(t, a.MarkDuplicate(ctx, portal, party))
+	article, err := a.GetByArticleID(ctx, portal)
+	require.NoError(t, err)
+	require.Equal(t, party, article.CanonicalID.Int32)
+
+	dups, err = a.FindNearDuplicates(ctx, party, 0.8)
+	require.NoError(t, err)
+	require.Len(t, dups, 1)
+	require.Equal(t, portal, dups[0].ID)
+	require.Equal(t, party, dups[0].CanonicalID.Int32)
+
+	// a duplicate of a duplicate is linked to the canonical article
+	require.NoError(t, a.MarkDuplicate(ctx, late, portal))
+	article, err = a.GetByArticleID(ctx, late)
+	require.NoError(t, err)
+	require.Equal(t, party, article.CanonicalID.Int32)
+
+	// the canonical article cannot become a duplicate of its duplicates
+	var e *ec.Error
+	err = a.MarkDuplicate(ctx, party, portal)
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, ec.ErrConflict.HttpStatusCode, e.HttpStatusCode)
+
+	// linking the canonical article relinks its duplicates
+	require.NoError(t, a.MarkDuplicate(ctx, party, other))
+	for _, id := range []int32{party, portal, late} {
+		article, err = a.GetByArticleID(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, other, article.CanonicalID.Int32)
+	}
+}
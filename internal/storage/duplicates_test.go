@@ -0,0 +1,23 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArticleDuplicatesValidation(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{}
+	a := storage.Article{Storage: storage.New(db, nil)}
+
+	for _, threshold := range []float32{-0.5, 0, 1.01} {
+		_, err := a.FindNearDuplicates(ctx, 1, threshold)
+		require.Error(t, err, "threshold: %v", threshold)
+	}
+
+	require.Error(t, a.MarkDuplicate(ctx, 1, 1))
+	require.Zero(t, db.calls)
+}
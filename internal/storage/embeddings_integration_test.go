@@ -0,0 +1,416 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// axisVector returns a unit vector of 1024 dimensions leaning towards the
+// axis i, its cosine distance to the other axes grows with tilt.
+func axisVector(i int, tilt float32) []float32 {
+	v := make([]float32, 1024)
+	v[i] = 1
+	v[(i+1)%len(v)] = tilt
+	return v
+}
+
+func TestUserEmbeddingsSearchSimilar(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	modelID, err := s.Models().Insert(ctx, "search-"+uuid.NewString(), 1024)
+	require.NoError(t, err)
+
+	taskID, err := s.Task().InsertFromText(ctx, "search "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	aID, err := s.UserArticles().Insert(ctx, taskID, "search "+uuid.NewString(), "test",
+		"content of the article", nil, time.Now(), time.Time{}, nil)
+	require.NoError(t, err)
+
+	near, err := s.UserChunks().Insert(ctx, aID, 0, 0, 7, 10)
+	require.NoError(t, err)
+	_, err = s.UserEmbeddings().Insert(ctx, aID, near, modelID, axisVector(0, 0.1))
+	require.NoError(t, err)
+
+	far, err := s.UserChunks().Insert(ctx, aID, 7, 3, 15, 22)
+	require.NoError(t, err)
+	_, err = s.UserEmbeddings().Insert(ctx, aID, far, modelID, axisVector(1, 0))
+	require.NoError(t, err)
+
+	chunks, err := s.UserEmbeddings().SearchSimilar(ctx, modelID, axisVector(0, 0), 10, -1)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	require.Equal(t, aID, chunks[0].ArticleID)
+	require.Equal(t, near, chunks[0].ID)
+	require.Equal(t, int32(7), chunks[0].OffsetRight)
+	require.Equal(t, int32(10), chunks[0].End)
+	require.InDelta(t, 1-1/1.00499, chunks[0].Distance, 1e-4)
+	require.Equal(t, far, chunks[1].ID)
+	require.InDelta(t, 1, chunks[1].Distance, 1e-4)
+
+	// the chunks below the min score are left out
+	chunks, err = s.UserEmbeddings().SearchSimilar(ctx, modelID, axisVector(0, 0), 10, 0.5)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	require.Equal(t, near, chunks[0].ID)
+
+	chunks, err = s.UserEmbeddings().SearchSimilar(ctx, modelID, axisVector(0, 0), 1, -1)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+
+	_, err = s.UserEmbeddings().SearchSimilar(ctx, modelID, []float32{1, 0}, 10, 0)
+	require.Error(t, err)
+	_, err = s.UserEmbeddings().SearchSimilar(ctx, modelID, axisVector(0, 0), 10, 1.5)
+	require.Error(t, err)
+
+	// the user articles are not searched by the shared variant
+	chunks, err = s.UserEmbeddings().SearchSimilarShared(ctx, modelID, axisVector(0, 0), 10, -1)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+
+	title := "search " + uuid.NewString()
+	sharedID, err := storage.Article{Storage: s}.Insert(ctx, "https://example.com/"+title,
+		title, "test", uuid.NewString(), "content", nil, time.Now(), time.Time{})
+	require.NoError(t, err)
+	cID, err := s.Queries.InsertChunk(ctx, models.InsertChunkParams{
+		ArticleID:   sharedID,
+		Start:       0,
+		OffsetLeft:  0,
+		OffsetRight: 7,
+		End:         7,
+	})
+	require.NoError(t, err)
+	_, err = s.Queries.InsertEmbedding(ctx, models.InsertEmbeddingParams{
+		ArticleID: sharedID,
+		ChunkID:   cID,
+		ModelID:   modelID,
+		Vector:    utils.ToPgVector(axisVector(0, 0)),
+	})
+	require.NoError(t, err)
+
+	chunks, err = s.UserEmbeddings().SearchSimilarShared(ctx, modelID, axisVector(0, 0.1), 10, 0.9)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	require.Equal(t, sharedID, chunks[0].ArticleID)
+	require.Equal(t, cID, chunks[0].ID)
+}
+
+func TestUserEmbeddingsModelDims(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.Models().Insert(ctx, "dims-"+uuid.NewString(), 0)
+	require.Error(t, err)
+
+	small, err := s.Models().Insert(ctx, "dims-"+uuid.NewString(), 3)
+	require.NoError(t, err)
+	large, err := s.Models().Insert(ctx, "dims-"+uuid.NewString(), 1536)
+	require.NoError(t, err)
+
+	model, err := s.Models().GetByID(ctx, large)
+	require.NoError(t, err)
+	require.Equal(t, int32(1536), model.Dim)
+
+	taskID, err := s.Task().InsertFromText(ctx, "dims "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	aID, err := s.UserArticles().Insert(ctx, taskID, "dims "+uuid.NewString(), "test",
+		"content of the article", nil, time.Now(), time.Time{}, nil)
+	require.NoError(t, err)
+	cID, err := s.UserChunks().Insert(ctx, aID, 0, 0, 7, 10)
+	require.NoError(t, err)
+
+	// the embeddings of both models share the table
+	_, err = s.UserEmbeddings().Insert(ctx, aID, cID, small, []float32{1, 0, 0})
+	require.NoError(t, err)
+	large1536 := make([]float32, 1536)
+	large1536[0] = 1
+	_, err = s.UserEmbeddings().Insert(ctx, aID, cID, large, large1536)
+	require.NoError(t, err)
+
+	// the length is checked against the dimension of the model
+	_, err = s.UserEmbeddings().Insert(ctx, aID, cID, small, large1536)
+	require.Error(t, err)
+	_, err = s.UserEmbeddings().SearchSimilar(ctx, large, []float32{1, 0, 0}, 10, -1)
+	require.Error(t, err)
+
+	for _, tc := range []struct {
+		modelID int32
+		query   []float32
+	}{
+		{small, []float32{1, 0, 0}},
+		{large, large1536},
+	} {
+		chunks, err := s.UserEmbeddings().SearchSimilar(ctx, tc.modelID, tc.query, 10, 0.9)
+		require.NoError(t, err)
+		require.Len(t, chunks, 1)
+		require.Equal(t, cID, chunks[0].ID)
+		require.InDelta(t, 0, chunks[0].Distance, 1e-6)
+	}
+}
+
+func TestModelsGetOrCreate(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	name := "get-or-create-" + uuid.NewString()
+	mID, err := s.Models().GetOrCreate(ctx, name, 1024)
+	require.NoError(t, err)
+
+	again, err := s.Models().GetOrCreate(ctx, name, 1024)
+	require.NoError(t, err)
+	require.Equal(t, mID, again)
+
+	// the model created gets its partial HNSW index on both tables
+	for _, schema := range []string{"public", "users"} {
+		var def string
+		var valid bool
+		err := pool.QueryRow(ctx, `
+			SELECT pg_get_indexdef(i.indexrelid), i.indisvalid
+			FROM pg_index i
+			JOIN pg_class c ON c.oid = i.indexrelid
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE n.nspname = $1 AND c.relname = $2`,
+			schema, fmt.Sprintf("embeddings_vector_hnsw_model_%d_idx", mID)).Scan(&def, &valid)
+		require.NoError(t, err, schema)
+		require.True(t, valid, schema)
+		require.Contains(t, def, "hnsw")
+		require.Contains(t, def, "vector(1024)")
+		require.Contains(t, def, fmt.Sprintf("model_id = %d", mID))
+	}
+
+	// the existing model is never redefined
+	_, err = s.Models().GetOrCreate(ctx, name, 1536)
+	requireConflict(t, err)
+	model, err := s.Models().GetByID(ctx, mID)
+	require.NoError(t, err)
+	require.Equal(t, int32(1024), model.Dim)
+	require.True(t, model.Active)
+
+	_, err = s.Models().GetOrCreate(ctx, name, 0)
+	require.Error(t, err)
+}
+
+func TestModelsDeactivate(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	items := newEmbeddingItems(t, s, 2)
+	mID := items[0].ModelID
+	_, err := s.UserEmbeddings().InsertPooled(ctx, items[0].ArticleID, items[0].ChunkID,
+		mID, items[0].Embedding)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Models().Deactivate(ctx, mID))
+	require.NoError(t, s.Models().Deactivate(ctx, mID))
+
+	list, err := s.Models().List(ctx)
+	require.NoError(t, err)
+	idx := slices.IndexFunc(list, func(m models.Model) bool { return m.ID == mID })
+	require.NotEqual(t, -1, idx)
+	require.False(t, list[idx].Active)
+
+	// no new embedding is inserted under the model
+	_, err = s.UserEmbeddings().InsertPooled(ctx, items[1].ArticleID, items[1].ChunkID,
+		mID, items[1].Embedding)
+	requireConflict(t, err)
+	_, err = s.UserEmbeddings().BatchInsert(ctx, items[1:])
+	require.Error(t, err)
+	model, err := s.Models().GetByID(ctx, mID)
+	require.NoError(t, err)
+	_, err = s.Models().GetOrCreate(ctx, model.Name, model.Dim)
+	requireConflict(t, err)
+
+	// while its embeddings stay searchable
+	chunks, err := s.UserEmbeddings().SearchSimilar(ctx, mID, axisVector(0, 0.1), 10, 0.9)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	require.Equal(t, items[0].ChunkID, chunks[0].ID)
+
+	var e *ec.Error
+	err = s.Models().Deactivate(ctx, -1)
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, ec.ErrNotFound.HttpStatusCode, e.HttpStatusCode)
+}
+
+// newEmbeddingItems inserts an article of n chunks and returns the embeddings
+// of its chunks under a new model, the chunk i leaning towards the axis i.
+func newEmbeddingItems(t testing.TB, s storage.Storage, n int) []storage.EmbeddingItem {
+	t.Helper()
+	ctx := context.Background()
+
+	modelID, err := s.Models().Insert(ctx, "batch-"+uuid.NewString(), 1024)
+	require.NoError(t, err)
+	taskID, err := s.Task().InsertFromText(ctx, "batch "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	aID, err := s.UserArticles().Insert(ctx, taskID, "batch "+uuid.NewString(), "test",
+		"content of the article", nil, time.Now(), time.Time{}, nil)
+	require.NoError(t, err)
+
+	items := make([]storage.EmbeddingItem, n)
+	for i := range items {
+		cID, err := s.UserChunks().Insert(ctx, aID, 0, 0, 7, 10)
+		require.NoError(t, err)
+		items[i] = storage.EmbeddingItem{
+			ArticleID: aID,
+			ChunkID:   cID,
+			ModelID:   modelID,
+			Embedding: llm.SplitEmbedding{
+				Embedding: llm.Embedding{Values: axisVector(i%1024, 0.1)},
+				SubCount:  1,
+			},
+		}
+	}
+	return items
+}
+
+func TestUserEmbeddingsBatchInsert(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	items := newEmbeddingItems(t, s, 3)
+	items[1].Embedding.WasSplit = true
+	items[1].Embedding.SubCount = 2
+
+	ids, err := s.UserEmbeddings().BatchInsert(ctx, items)
+	require.NoError(t, err)
+	require.Len(t, ids, 3)
+	for _, id := range ids {
+		require.NotZero(t, id)
+	}
+
+	var wasSplit bool
+	var subCount int32
+	require.NoError(t, pool.QueryRow(ctx,
+		"SELECT was_split, sub_count FROM users.embeddings WHERE id = $1", ids[1]).
+		Scan(&wasSplit, &subCount))
+	require.True(t, wasSplit)
+	require.Equal(t, int32(2), subCount)
+
+	// the embeddings already stored are skipped
+	ids, err = s.UserEmbeddings().BatchInsert(ctx, items[:1])
+	require.NoError(t, err)
+	require.Equal(t, []int32{0}, ids)
+
+	// the failing items are reported by index and none is inserted
+	bad := newEmbeddingItems(t, s, 3)
+	bad[0].Embedding.Values = []float32{1, 0}
+	bad[2].Embedding.SubCount = 0
+	_, err = s.UserEmbeddings().BatchInsert(ctx, bad)
+	require.Error(t, err)
+	var e *ec.Error
+	require.ErrorAs(t, err, &e)
+	require.Len(t, e.Details, 2)
+	details := strings.Join(e.Details, "\n")
+	require.Contains(t, details, "Index 0: ")
+	require.Contains(t, details, "Index 2: ")
+	require.Zero(t, countRows(t, pool, "users.embeddings", "article_id", bad[1].ArticleID))
+}
+
+func TestUserEmbeddingsInsertIfAbsent(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	items := newEmbeddingItems(t, s, 3)
+	mID := items[0].ModelID
+	chunkIDs := []int32{items[0].ChunkID, items[1].ChunkID, items[2].ChunkID}
+
+	exists, err := s.UserEmbeddings().ExistsForChunks(ctx, mID, chunkIDs)
+	require.NoError(t, err)
+	require.Equal(t, map[int32]bool{chunkIDs[0]: false, chunkIDs[1]: false, chunkIDs[2]: false}, exists)
+
+	eID, inserted, err := s.UserEmbeddings().InsertIfAbsent(ctx, items[0].ArticleID, items[0].ChunkID,
+		mID, items[0].Embedding)
+	require.NoError(t, err)
+	require.True(t, inserted)
+	require.NotZero(t, eID)
+
+	// another vector of the chunk is skipped, not stored next to the first
+	again := items[0].Embedding
+	again.Values = axisVector(5, 0.2)
+	eID, inserted, err = s.UserEmbeddings().InsertIfAbsent(ctx, items[0].ArticleID, items[0].ChunkID,
+		mID, again)
+	require.NoError(t, err)
+	require.False(t, inserted)
+	require.Zero(t, eID)
+	require.Equal(t, 1, countRows(t, pool, "users.embeddings", "chunk_id", items[0].ChunkID))
+
+	_, err = s.UserEmbeddings().BatchInsert(ctx, items[2:])
+	require.NoError(t, err)
+	exists, err = s.UserEmbeddings().ExistsForChunks(ctx, mID, chunkIDs)
+	require.NoError(t, err)
+	require.Equal(t, map[int32]bool{chunkIDs[0]: true, chunkIDs[1]: false, chunkIDs[2]: true}, exists)
+
+	// the chunks are embedded under their model only
+	other, err := s.Models().Insert(ctx, "batch-"+uuid.NewString(), 1024)
+	require.NoError(t, err)
+	exists, err = s.UserEmbeddings().ExistsForChunks(ctx, other, chunkIDs[:1])
+	require.NoError(t, err)
+	require.Equal(t, map[int32]bool{chunkIDs[0]: false}, exists)
+	_, inserted, err = s.UserEmbeddings().InsertIfAbsent(ctx, items[0].ArticleID, items[0].ChunkID,
+		other, items[0].Embedding)
+	require.NoError(t, err)
+	require.True(t, inserted)
+
+	// the embedding is checked as InsertPooled checks it
+	bad := items[1].Embedding
+	bad.Values = []float32{1, 0}
+	_, _, err = s.UserEmbeddings().InsertIfAbsent(ctx, items[1].ArticleID, items[1].ChunkID, mID, bad)
+	require.Error(t, err)
+}
+
+// BenchmarkUserEmbeddingsInsert compares inserting the embeddings of 500
+// chunks one row at a time with inserting them in a batch.
+func BenchmarkUserEmbeddingsInsert(b *testing.B) {
+	pool := newTestPool(b)
+	s := newTestStorage(b, pool)
+	ctx := context.Background()
+
+	b.Run("row", func(b *testing.B) {
+		for b.Loop() {
+			b.StopTimer()
+			items := newEmbeddingItems(b, s, 500)
+			b.StartTimer()
+			for _, item := range items {
+				_, err := s.UserEmbeddings().InsertPooled(ctx, item.ArticleID, item.ChunkID,
+					item.ModelID, item.Embedding)
+				require.NoError(b, err)
+			}
+		}
+	})
+
+	b.Run("batch", func(b *testing.B) {
+		for b.Loop() {
+			b.StopTimer()
+			items := newEmbeddingItems(b, s, 500)
+			b.StartTimer()
+			_, err := s.UserEmbeddings().BatchInsert(ctx, items)
+			require.NoError(b, err)
+		}
+	})
+}
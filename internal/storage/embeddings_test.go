@@ -0,0 +1,35 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserEmbeddingsInsertIfAbsentValidation(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{}
+	e := storage.New(db, nil).UserEmbeddings()
+
+	exists, err := e.ExistsForChunks(ctx, 1, nil)
+	require.NoError(t, err)
+	require.Empty(t, exists)
+
+	_, _, err = e.InsertIfAbsent(ctx, 1, 1, 1, llm.SplitEmbedding{
+		Embedding: llm.Embedding{Values: []float32{1}},
+	})
+	require.Error(t, err)
+	require.Zero(t, db.calls)
+
+	// the lookup of the model fails the insert
+	_, inserted, err := e.InsertIfAbsent(ctx, 1, 1, 1, llm.SplitEmbedding{
+		Embedding: llm.Embedding{Values: []float32{1}},
+		SubCount:  1,
+	})
+	require.Error(t, err)
+	require.False(t, inserted)
+	require.Equal(t, 1, db.calls)
+}
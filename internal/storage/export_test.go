@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+)
+
+// WithAfterCopy returns a copy of t which calls fn between copying a batch to
+// the archive and deleting it from the hot tier.
+func (t Tiering) WithAfterCopy(fn func(batch int) error) Tiering {
+	t.afterCopy = fn
+	return t
+}
+
+// WithReadDB routes the read-only accessor methods to db.
+func WithReadDB(db DB) Option {
+	return func(s *Storage) {
+		s.readDB = db
+	}
+}
+
+// ParseReindexParams and MergeReindexParams expose the handling of the build
+// options of the vector indexes.
+var (
+	ParseReindexParams = parseReindexParams
+	MergeReindexParams = mergeReindexParams
+)
+
+// HandlePgxErr exposes the mapping of the database errors.
+var HandlePgxErr = handlePgxErr
+
+// NewVectorDimDB returns db typing the casts marked by /*dim*/ with dim
+// dimensions.
+func NewVectorDimDB(db models.DBTX, dim int32) models.DBTX {
+	return vectorDimDB{DBTX: db, cast: fmt.Sprintf("::vector(%d)", dim)}
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+)
+
+// The field names of the revisions made to the user articles updated by their
+// publisher.
+const (
+	RevisionFieldTitle   = "title"
+	RevisionFieldContent = "content"
+)
+
+func (s Storage) Freshness() Freshness {
+	return Freshness{s}
+}
+
+// Freshness provides methods to re-check the scraped user articles for the
+// updates of their publisher.
+type Freshness struct {
+	Storage
+}
+
+// DueForRecheck returns up to limit user articles scraped from a URL and
+// published since since which have never been checked, or not since
+// checkedBefore, least recently checked first.
+func (f Freshness) DueForRecheck(ctx context.Context, since, checkedBefore time.Time,
+	limit int32) ([]models.ListUsersArticlesDueForRecheckRow, error) {
+	sTsz, err := utils.TimeTo.PGTimestamptz(since)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert since to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("since: %v", since.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	cTsz, err := utils.TimeTo.PGTimestamptz(checkedBefore)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert checked before to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("checked before: %v", checkedBefore.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	rows, err := f.querier(ctx, "Freshness", "DueForRecheck").
+		ListUsersArticlesDueForRecheck(ctx, models.ListUsersArticlesDueForRecheckParams{
+			Since:         sTsz,
+			CheckedBefore: cTsz,
+			Limit:         limit,
+		})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
+
+// RecordCheck records a check at checkedAt which found the article unchanged.
+// A non-zero modifiedAt replaces the stored modification time.
+func (f Freshness) RecordCheck(ctx context.Context, articleID int32, checkedAt, modifiedAt time.Time) error {
+	cTsz, err := utils.TimeTo.PGTimestamptz(checkedAt)
+	if err != nil {
+		return errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", checkedAt.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	mTsz, err := modifiedTsz(modifiedAt)
+	if err != nil {
+		return err
+	}
+
+	n, err := f.Queries.TouchUsersArticleChecked(ctx, models.TouchUsersArticleCheckedParams{
+		CheckedAt:  cTsz,
+		ModifiedAt: mTsz,
+		ID:         articleID,
+	})
+	if err != nil {
+		return handlePgxErr(err)
+	}
+
+	if n == 0 {
+		return errors.ErrNotFound.Clone().
+			WithDetails(fmt.Sprintf("user article %d not found", articleID))
+	}
+	return nil
+}
+
+// Reprocesses returns the number of updates of the content of the article
+// since since. It reads the write pool, so that a lagging replica does not let
+// an update through the thrash guard of the freshness check.
+func (f Freshness) Reprocesses(ctx context.Context, articleID int32, since time.Time) (int64, error) {
+	tsz, err := utils.TimeTo.PGTimestamptz(since)
+	if err != nil {
+		return 0, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert since to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("since: %v", since.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	n, err := f.querier(ctx, "Freshness", "Reprocesses").
+		CountUsersArticleRevisionsSince(ctx, models.CountUsersArticleRevisionsSinceParams{
+			ArticleID: articleID,
+			Field:     RevisionFieldContent,
+			Since:     tsz,
+		})
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+	return n, nil
+}
+
+// ArticleUpdate is an update of a user article by its publisher.
+type ArticleUpdate struct {
+	ArticleID int32
+	Title     string
+	Content   string
+	Cuts      []int32
+	// ModifiedAt is the modification time declared by the publisher, zero if
+	// it declares none.
+	ModifiedAt time.Time
+	CheckedAt  time.Time
+	// Note is the note of the revisions.
+	Note string
+}
+
+// Update replaces the title and the content of the article, keeping the old
+// ones as revisions, and re-chunks it into chunks of size runes overlapping by
+// overlap: the old chunks are deleted along with their embeddings. fn, if not
+// nil, is called with the ID of the article and the number of chunks before the
+// transaction commits, as by UserArticles.Insert. It returns the number of
+// chunks inserted.
+func (f Freshness) Update(ctx context.Context, u ArticleUpdate, size, overlap int,
+	fn func(ctx context.Context, aID int32, chunks int) error) (int, error) {
+	cTsz, err := utils.TimeTo.PGTimestamptz(u.CheckedAt)
+	if err != nil {
+		return 0, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", u.CheckedAt.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	mTsz, err := modifiedTsz(u.ModifiedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := f.db.Begin(ctx)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := f.Queries.WithTx(tx)
+	old, err := q.UpdateUsersArticleContent(ctx, models.UpdateUsersArticleContentParams{
+		ID:          u.ArticleID,
+		Title:       u.Title,
+		Content:     u.Content,
+		Cuts:        u.Cuts,
+		ContentHash: ContentHash(u.Content),
+		ModifiedAt:  mTsz,
+		CheckedAt:   cTsz,
+	})
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	revisions := []models.InsertUsersArticleRevisionParams{{
+		ArticleID: u.ArticleID,
+		Field:     RevisionFieldContent,
+		OldValue:  old.OldContent,
+		NewValue:  u.Content,
+		Note:      u.Note,
+	}}
+	if old.OldTitle != u.Title {
+		revisions = append(revisions, models.InsertUsersArticleRevisionParams{
+			ArticleID: u.ArticleID,
+			Field:     RevisionFieldTitle,
+			OldValue:  old.OldTitle,
+			NewValue:  u.Title,
+			Note:      u.Note,
+		})
+	}
+	for _, r := range revisions {
+		if err := q.InsertUsersArticleRevision(ctx, r); err != nil {
+			return 0, handlePgxErr(err)
+		}
+	}
+
+	if err := q.DeleteUsersChunksByArticleID(ctx, u.ArticleID); err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	// the chunks are inserted in the transaction too
+	txs := f.Storage
+	txs.Queries, txs.reader = q, nil
+	n, err := txs.UserChunks().InsertContent(ctx, u.ArticleID, u.Content, u.Cuts, size, overlap, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if fn != nil {
+		if err := fn(ctx, u.ArticleID, n); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, handlePgxErr(err)
+	}
+	return n, nil
+}
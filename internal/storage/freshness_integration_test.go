@@ -0,0 +1,67 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreshnessUpdate(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	taskID, err := s.Task().InsertFromText(ctx, "freshness "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	published := time.Now().Add(-time.Hour).Truncate(time.Second)
+	aID, err := s.UserArticles().Insert(ctx, taskID, "舊標題", "test", "第一段。第二段。",
+		[]int32{12, 24}, published, published, nil)
+	require.NoError(t, err)
+
+	n, err := s.UserChunks().InsertContent(ctx, aID, "第一段。第二段。", []int32{12, 24}, 512, 64, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	checkedAt := time.Now().Truncate(time.Second)
+	require.NoError(t, s.Freshness().RecordCheck(ctx, aID, checkedAt, time.Time{}))
+	require.Error(t, s.Freshness().RecordCheck(ctx, -1, checkedAt, time.Time{}))
+
+	called := 0
+	n, err = s.Freshness().Update(ctx, storage.ArticleUpdate{
+		ArticleID:  aID,
+		Title:      "新標題",
+		Content:    "第一段。第二段更新。",
+		Cuts:       []int32{12, 30},
+		ModifiedAt: published.Add(30 * time.Minute),
+		CheckedAt:  checkedAt,
+		Note:       "test",
+	}, 512, 64, func(_ context.Context, id int32, chunks int) error {
+		require.Equal(t, aID, id)
+		require.Equal(t, 2, chunks)
+		called++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, 1, called)
+
+	// the old chunks are replaced
+	chunks, err := s.UserChunks().ExtractByArticleID(ctx, aID)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+
+	count, err := s.Freshness().Reprocesses(ctx, aID, checkedAt.Add(-time.Minute))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+
+	count, err = s.Freshness().Reprocesses(ctx, aID, checkedAt.Add(time.Minute))
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+)
+
+// DefaultRRFK is the constant of the reciprocal rank fusion of HybridSearch,
+// the larger it is the less the top ranks of a side dominate the fused one.
+const DefaultRRFK = 60
+
+// HybridWeights weigh the vector and the keyword side of a hybrid search. Zero
+// weights on both sides fall back to equal ones.
+type HybridWeights struct {
+	Vector  float64 `json:"vector"`
+	Keyword float64 `json:"keyword"`
+}
+
+// HybridParams is a hybrid search of the shared articles by Embedding, under
+// the model of ModelID, and by Query, a web search query as the one of
+// Article.Search. A Limit of zero or less falls back to DefaultSearchLimit,
+// one above MaxSearchLimit is clamped.
+type HybridParams struct {
+	Embedding []float32
+	ModelID   int32
+	Query     string
+	Weights   HybridWeights
+	Limit     int
+}
+
+// HybridMatch is an article matched by a hybrid search, with the scores of
+// both sides for debugging. ChunkID is its chunk nearest to the embedding, and
+// a rank is zero if the article is not among the candidates of its side, its
+// distance and keyword score zero with it.
+type HybridMatch struct {
+	ArticleID      int32   `json:"article_id"`
+	ChunkID        int32   `json:"chunk_id,omitempty"`
+	Score          float64 `json:"score"`
+	VectorDistance float64 `json:"vector_distance"`
+	VectorRank     int64   `json:"vector_rank"`
+	KeywordScore   float64 `json:"keyword_score"`
+	KeywordRank    int64   `json:"keyword_rank"`
+}
+
+// HybridSearch returns the shared articles best matching both the embedding
+// and the query of p, the best first. It fuses the MaxSearchLimit articles
+// nearest to the embedding with the MaxSearchLimit ones best matching the
+// query by reciprocal rank fusion, see the HybridSearchArticles query, so that
+// an article close in the embedding space but not mentioning the query, e.g.
+// a press release of another year on the same topic, falls behind the ones
+// matching both.
+func (s Storage) HybridSearch(ctx context.Context, p HybridParams) ([]HybridMatch, error) {
+	query := strings.TrimSpace(p.Query)
+	if query == "" {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("query should not be empty")
+	}
+
+	w := p.Weights
+	if w.Vector < 0 || w.Keyword < 0 {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("weights should not be negative").
+			WithDetails(fmt.Sprintf("vector: %v, keyword: %v", w.Vector, w.Keyword))
+	}
+
+	if w.Vector == 0 && w.Keyword == 0 {
+		w = HybridWeights{Vector: 1, Keyword: 1}
+	}
+
+	q, err := s.withModelDim(ctx, "Storage", "HybridSearch", p.ModelID, p.Embedding)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := p.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	rows, err := q.HybridSearchArticles(ctx, models.HybridSearchArticlesParams{
+		Embedding:     utils.ToPgVector(p.Embedding),
+		ModelID:       p.ModelID,
+		Candidates:    MaxSearchLimit,
+		Query:         query,
+		Pattern:       likeEscaper.Replace(query),
+		VectorWeight:  w.Vector,
+		RrfK:          DefaultRRFK,
+		KeywordWeight: w.Keyword,
+		Limit:         int32(min(limit, MaxSearchLimit)),
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	matches := make([]HybridMatch, len(rows))
+	for i, row := range rows {
+		matches[i] = HybridMatch{
+			ArticleID:      row.ArticleID,
+			ChunkID:        row.ChunkID.Int32,
+			Score:          row.Score,
+			VectorDistance: row.VectorDistance.Float64,
+			VectorRank:     row.VectorRank.Int64,
+			KeywordScore:   row.KeywordScore.Float64,
+			KeywordRank:    row.KeywordRank.Int64,
+		}
+	}
+	return matches, nil
+}
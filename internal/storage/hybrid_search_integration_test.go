@@ -0,0 +1,106 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHybridSearch(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	modelID, err := s.Models().Insert(ctx, "hybrid-"+uuid.NewString(), 3)
+	require.NoError(t, err)
+
+	// a word of its own, so that only the articles of this test match it
+	word := "w" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	insert := func(title, content string, vector []float32) (int32, int32) {
+		aID, err := storage.Article{Storage: s}.Insert(ctx, "https://example.com/"+uuid.NewString(),
+			title, "test", uuid.NewString(), content, nil, time.Now(), time.Time{})
+		require.NoError(t, err)
+		if vector == nil {
+			return aID, 0
+		}
+
+		cID, err := s.Queries.InsertChunk(ctx, models.InsertChunkParams{
+			ArticleID:   aID,
+			OffsetRight: int32(len(content)),
+			End:         int32(len(content)),
+		})
+		require.NoError(t, err)
+		_, err = s.Queries.InsertEmbedding(ctx, models.InsertEmbeddingParams{
+			ArticleID: aID,
+			ChunkID:   cID,
+			ModelID:   modelID,
+			Vector:    utils.ToPgVector(vector),
+		})
+		require.NoError(t, err)
+		return aID, cID
+	}
+
+	// the nearest article does not mention the word, the farthest does twice
+	nearest, nearestChunk := insert("on topic", "an older press release", []float32{1, 0, 0})
+	both, _ := insert("about "+word, "a press release", []float32{1, 1, 0})
+	far, _ := insert("off topic", word+" and "+word+" again", []float32{-1, 0, 0})
+	keywordOnly, _ := insert("no embedding", "mentions "+word+" once", nil)
+
+	p := storage.HybridParams{
+		Embedding: []float32{1, 0, 0},
+		ModelID:   modelID,
+		Query:     word,
+	}
+	matches, err := s.HybridSearch(ctx, p)
+	require.NoError(t, err)
+	require.Equal(t, []int32{both, far, nearest, keywordOnly}, hybridIDs(matches))
+
+	require.Equal(t, int64(2), matches[0].VectorRank)
+	require.Equal(t, int64(1), matches[0].KeywordRank)
+	require.InDelta(t, 1.0/(storage.DefaultRRFK+2)+1.0/(storage.DefaultRRFK+1), matches[0].Score, 1e-9)
+	require.InDelta(t, 1-1/1.41421, matches[0].VectorDistance, 1e-4)
+	require.Greater(t, matches[0].KeywordScore, matches[1].KeywordScore)
+
+	require.Equal(t, nearestChunk, matches[2].ChunkID)
+	require.Equal(t, int64(1), matches[2].VectorRank)
+	require.Zero(t, matches[2].KeywordRank)
+	require.Zero(t, matches[2].KeywordScore)
+	require.Zero(t, matches[3].ChunkID)
+	require.Zero(t, matches[3].VectorRank)
+	require.Equal(t, int64(3), matches[3].KeywordRank)
+
+	// the weights tip the fusion to a side
+	p.Weights = storage.HybridWeights{Vector: 1}
+	matches, err = s.HybridSearch(ctx, p)
+	require.NoError(t, err)
+	require.Equal(t, []int32{nearest, both, far, keywordOnly}, hybridIDs(matches))
+	require.Zero(t, matches[3].Score)
+
+	p.Weights = storage.HybridWeights{Keyword: 1}
+	p.Limit = 2
+	matches, err = s.HybridSearch(ctx, p)
+	require.NoError(t, err)
+	require.Equal(t, []int32{both, far}, hybridIDs(matches))
+
+	p.Embedding = []float32{1, 0}
+	_, err = s.HybridSearch(ctx, p)
+	require.Error(t, err)
+}
+
+func hybridIDs(matches []storage.HybridMatch) []int32 {
+	ids := make([]int32, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ArticleID
+	}
+	return ids
+}
@@ -0,0 +1,26 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHybridSearchValidation(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{}
+	s := storage.New(db, nil)
+
+	for _, p := range []storage.HybridParams{
+		{Embedding: []float32{1, 0, 0}, ModelID: 1},
+		{Embedding: []float32{1, 0, 0}, ModelID: 1, Query: " "},
+		{Embedding: []float32{1, 0, 0}, ModelID: 1, Query: "立法院",
+			Weights: storage.HybridWeights{Vector: -1, Keyword: 1}},
+	} {
+		_, err := s.HybridSearch(ctx, p)
+		require.Error(t, err, "%+v", p)
+	}
+	require.Zero(t, db.calls)
+}
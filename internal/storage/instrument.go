@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pgvector/pgvector-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+// DefaultSlowQueryThreshold is the duration above which NewInstrumented logs
+// a query.
+const DefaultSlowQueryThreshold = 500 * time.Millisecond
+
+// QueryMetrics records the duration of the queries run on the connections it
+// decorates, by the name sqlc gives them, and logs the slow ones. A statement
+// not generated by sqlc is recorded under "raw".
+type QueryMetrics struct {
+	duration      *prometheus.HistogramVec
+	logger        zerolog.Logger
+	slowThreshold time.Duration
+}
+
+// NewQueryMetrics registers the query duration histogram to reg, or to
+// prometheus.DefaultRegisterer if reg is nil, and logs the queries slower than
+// slowThreshold to logger, zero disabling the log. The histogram already
+// registered by another QueryMetrics is shared, e.g. by the storages of the
+// write and the read database of a service.
+func NewQueryMetrics(reg prometheus.Registerer, logger zerolog.Logger, slowThreshold time.Duration) (*QueryMetrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_query_duration_seconds",
+		Help:    "Duration of the storage queries, by query.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	if err := reg.Register(duration); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			return nil, fmt.Errorf("failed to register the query duration histogram: %w", err)
+		}
+		existing, ok := are.ExistingCollector.(*prometheus.HistogramVec)
+		if !ok {
+			return nil, fmt.Errorf("failed to register the query duration histogram: %w", err)
+		}
+		duration = existing
+	}
+
+	return &QueryMetrics{
+		duration:      duration,
+		logger:        logger,
+		slowThreshold: slowThreshold,
+	}, nil
+}
+
+// NewInstrumented creates a Storage writing to db whose queries are recorded
+// by a QueryMetrics registered to reg, those slower than
+// DefaultSlowQueryThreshold logged to logger. The options are those of New,
+// the decorators of WithDecorators wrapping the instrumented connection. A
+// storage with another threshold is created by New with the Decorate of a
+// QueryMetrics of its own.
+func NewInstrumented(db DB, reg prometheus.Registerer, logger zerolog.Logger, opts ...Option) (Storage, error) {
+	m, err := NewQueryMetrics(reg, logger, DefaultSlowQueryThreshold)
+	if err != nil {
+		return Storage{}, err
+	}
+	return New(db, nil, append([]Option{WithDecorators(m.Decorate)}, opts...)...), nil
+}
+
+// Decorate is the Decorator recording the queries run on db and on the
+// transactions begun on it.
+func (m *QueryMetrics) Decorate(db DB) DB {
+	return instrumentedDB{DB: db, m: m}
+}
+
+// observe records the query of sql which started at start, logging it if it
+// is slow. Only a summary of args is logged, never their content.
+func (m *QueryMetrics) observe(sql string, args []any, start time.Time, err error) {
+	elapsed := time.Since(start)
+	method := queryName(sql)
+	m.duration.WithLabelValues(method).Observe(elapsed.Seconds())
+
+	if m.slowThreshold <= 0 || elapsed < m.slowThreshold {
+		return
+	}
+	m.logger.Warn().
+		Err(err).
+		Str("method", method).
+		Dur("duration", elapsed).
+		Strs("args", summarizeArgs(args)).
+		Msg("Slow query")
+}
+
+func (m *QueryMetrics) exec(ctx context.Context, db models.DBTX, sql string, args []any) (pgconn.CommandTag, error) {
+	start := time.Now()
+	tag, err := db.Exec(ctx, sql, args...)
+	m.observe(sql, args, start, err)
+	return tag, err
+}
+
+// query records the query once its rows are closed, so that the duration
+// covers reading them.
+func (m *QueryMetrics) query(ctx context.Context, db models.DBTX, sql string, args []any) (pgx.Rows, error) {
+	start := time.Now()
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		m.observe(sql, args, start, err)
+		return nil, err
+	}
+	return &instrumentedRows{Rows: rows, done: func(err error) {
+		m.observe(sql, args, start, err)
+	}}, nil
+}
+
+func (m *QueryMetrics) queryRow(ctx context.Context, db models.DBTX, sql string, args []any) pgx.Row {
+	start := time.Now()
+	row := db.QueryRow(ctx, sql, args...)
+	return instrumentedRow{Row: row, done: func(err error) {
+		// no row is an answer, not a failure
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = nil
+		}
+		m.observe(sql, args, start, err)
+	}}
+}
+
+// sendBatch records the batch under the name of its first query once its
+// results are closed.
+func (m *QueryMetrics) sendBatch(ctx context.Context, db models.DBTX, b *pgx.Batch) pgx.BatchResults {
+	start := time.Now()
+	br := db.SendBatch(ctx, b)
+	if br == nil {
+		return nil
+	}
+
+	var sql string
+	if len(b.QueuedQueries) > 0 {
+		sql = b.QueuedQueries[0].SQL
+	}
+	return &instrumentedBatch{BatchResults: br, done: func(err error) {
+		m.observe(sql, nil, start, err)
+	}}
+}
+
+func (m *QueryMetrics) begin(tx pgx.Tx, err error) (pgx.Tx, error) {
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedTx{Tx: tx, m: m}, nil
+}
+
+// queryName returns the name sqlc starts the query sql with, "raw" if there
+// is none.
+func queryName(sql string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(sql), "\n")
+	rest, ok := strings.CutPrefix(line, "-- name: ")
+	if !ok {
+		return "raw"
+	}
+
+	name, _, _ := strings.Cut(rest, " ")
+	if name == "" {
+		return "raw"
+	}
+	return name
+}
+
+// summarizeArgs describes the arguments of a query for the log: the scalars
+// as they are, the strings, the byte slices, the vectors and the other slices
+// by their length only, so that no article content nor embedding is logged.
+func summarizeArgs(args []any) []string {
+	summary := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+			summary[i] = "null"
+		case string:
+			summary[i] = fmt.Sprintf("string(len=%d)", len(v))
+		case []byte:
+			summary[i] = fmt.Sprintf("bytes(len=%d)", len(v))
+		case pgvector.Vector:
+			summary[i] = fmt.Sprintf("vector(dim=%d)", len(v.Slice()))
+		case bool, int, int16, int32, int64, float32, float64:
+			summary[i] = fmt.Sprint(v)
+		case time.Time:
+			summary[i] = v.Format(time.RFC3339)
+		default:
+			rv := reflect.ValueOf(arg)
+			if k := rv.Kind(); k == reflect.Slice || k == reflect.Array || k == reflect.Map {
+				summary[i] = fmt.Sprintf("%T(len=%d)", arg, rv.Len())
+			} else {
+				summary[i] = fmt.Sprintf("%T", arg)
+			}
+		}
+	}
+	return summary
+}
+
+// instrumentedDB records the queries run on DB.
+type instrumentedDB struct {
+	DB
+	m *QueryMetrics
+}
+
+func (db instrumentedDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return db.m.exec(ctx, db.DB, sql, args)
+}
+
+func (db instrumentedDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return db.m.query(ctx, db.DB, sql, args)
+}
+
+func (db instrumentedDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return db.m.queryRow(ctx, db.DB, sql, args)
+}
+
+func (db instrumentedDB) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return db.m.sendBatch(ctx, db.DB, b)
+}
+
+func (db instrumentedDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return db.m.begin(db.DB.Begin(ctx))
+}
+
+func (db instrumentedDB) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	return db.m.begin(db.DB.BeginTx(ctx, txOptions))
+}
+
+// instrumentedTx records the queries run on Tx and on its savepoints.
+type instrumentedTx struct {
+	pgx.Tx
+	m *QueryMetrics
+}
+
+func (tx instrumentedTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return tx.m.exec(ctx, tx.Tx, sql, args)
+}
+
+func (tx instrumentedTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return tx.m.query(ctx, tx.Tx, sql, args)
+}
+
+func (tx instrumentedTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return tx.m.queryRow(ctx, tx.Tx, sql, args)
+}
+
+func (tx instrumentedTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return tx.m.sendBatch(ctx, tx.Tx, b)
+}
+
+func (tx instrumentedTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	return tx.m.begin(tx.Tx.Begin(ctx))
+}
+
+// instrumentedRows calls done once, when the rows are closed.
+type instrumentedRows struct {
+	pgx.Rows
+	done   func(err error)
+	closed bool
+}
+
+func (r *instrumentedRows) Close() {
+	r.Rows.Close()
+	if !r.closed {
+		r.closed = true
+		r.done(r.Rows.Err())
+	}
+}
+
+// Next closes the rows past the last one, as pgx does, so that the query is
+// recorded even if the caller never closes them.
+func (r *instrumentedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.Close()
+	return false
+}
+
+// instrumentedRow calls done when the row is scanned.
+type instrumentedRow struct {
+	pgx.Row
+	done func(err error)
+}
+
+func (r instrumentedRow) Scan(dest ...any) error {
+	err := r.Row.Scan(dest...)
+	r.done(err)
+	return err
+}
+
+// instrumentedBatch calls done once, when the results are closed.
+type instrumentedBatch struct {
+	pgx.BatchResults
+	done   func(err error)
+	closed bool
+}
+
+func (b *instrumentedBatch) Close() error {
+	err := b.BatchResults.Close()
+	if !b.closed {
+		b.closed = true
+		b.done(err)
+	}
+	return err
+}
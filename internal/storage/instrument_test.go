@@ -0,0 +1,131 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+// slowDB is a fakeDB taking delay to answer a row.
+type slowDB struct {
+	fakeDB
+	delay time.Duration
+}
+
+func (db *slowDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	time.Sleep(db.delay)
+	return db.fakeDB.QueryRow(ctx, sql, args...)
+}
+
+// observations returns the number of queries of method recorded in reg.
+func observations(t *testing.T, reg *prometheus.Registry, method string) uint64 {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != "storage_query_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "method" && label.GetValue() == method {
+					return metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestNewInstrumented(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("queries", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		db := &fakeDB{}
+		s, err := storage.NewInstrumented(db, reg, zerolog.Nop())
+		require.NoError(t, err)
+
+		_, _ = s.Models().GetByID(ctx, 1)
+		_, _ = s.Models().GetByID(ctx, 2)
+		_ = s.Models().Deactivate(ctx, 1)
+		_, _ = s.Models().List(ctx)
+		require.Equal(t, 4, db.calls)
+
+		// the failing queries are recorded too
+		require.Equal(t, uint64(2), observations(t, reg, "GetModelByID"))
+		require.Equal(t, uint64(1), observations(t, reg, "DeactivateModel"))
+		require.Equal(t, uint64(1), observations(t, reg, "ListModels"))
+		require.Zero(t, observations(t, reg, "InsertModel"))
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		db := &txFakeDB{}
+		s, err := storage.NewInstrumented(db, reg, zerolog.Nop())
+		require.NoError(t, err)
+
+		err = s.WithTx(ctx, func(tx storage.Storage) error {
+			_, _ = tx.Models().GetByID(ctx, 1)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Len(t, db.tx.stmts, 1)
+		require.Equal(t, uint64(1), observations(t, reg, "GetModelByID"))
+	})
+
+	t.Run("shared registry", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		s, err := storage.NewInstrumented(&fakeDB{}, reg, zerolog.Nop())
+		require.NoError(t, err)
+		// another storage on the registry shares the histogram
+		r, err := storage.NewInstrumented(&fakeDB{}, reg, zerolog.Nop())
+		require.NoError(t, err)
+
+		_, _ = s.Models().GetByID(ctx, 1)
+		_, _ = r.Models().GetByID(ctx, 1)
+		require.Equal(t, uint64(2), observations(t, reg, "GetModelByID"))
+	})
+}
+
+func TestQueryMetricsSlowQuery(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		Name      string
+		Threshold time.Duration
+		WantLog   bool
+	}{
+		{Name: "slow", Threshold: time.Millisecond, WantLog: true},
+		{Name: "fast", Threshold: time.Hour},
+		{Name: "disabled", Threshold: 0},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			reg := prometheus.NewRegistry()
+			m, err := storage.NewQueryMetrics(reg, zerolog.New(&buf), tc.Threshold)
+			require.NoError(t, err)
+
+			db := &slowDB{delay: 5 * time.Millisecond}
+			s := storage.New(db, nil, storage.WithDecorators(m.Decorate))
+			_, _ = s.Models().Insert(ctx, "secret-model", 1024)
+			require.Equal(t, uint64(1), observations(t, reg, "InsertModel"))
+
+			if !tc.WantLog {
+				require.Empty(t, buf.String())
+				return
+			}
+			require.Contains(t, buf.String(), `"method":"InsertModel"`)
+			require.Contains(t, buf.String(), `"args":["string(len=12)","1024"]`)
+			require.NotContains(t, buf.String(), "secret-model")
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s Storage) KeywordAnomalies() KeywordAnomalies {
+	return KeywordAnomalies{s}
+}
+
+// KeywordAnomalies provides methods to read the daily mention counts of the
+// keywords and to record the days they spiked.
+type KeywordAnomalies struct {
+	Storage
+}
+
+// Trend returns the number of public articles mentioning each keyword per
+// calendar day in loc, over the articles published in [from, to). The days
+// without mentions are left out, the low information keywords as well.
+func (k KeywordAnomalies) Trend(ctx context.Context, loc *time.Location, from, to time.Time) ([]models.KeywordTrendRow, error) {
+	fromTsz, err := utils.TimeTo.PGTimestamptz(from)
+	if err != nil {
+		return nil, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", from.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	toTsz, err := utils.TimeTo.PGTimestamptz(to)
+	if err != nil {
+		return nil, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", to.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	rows, err := k.querier(ctx, "KeywordAnomalies", "Trend").KeywordTrend(ctx, models.KeywordTrendParams{
+		Tz:            loc.String(),
+		PublishedFrom: fromTsz,
+		PublishedTo:   toTsz,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
+
+// Record flags a keyword on a day. ok is false if the keyword has already
+// been flagged on that day, the earlier flag is kept then.
+func (k KeywordAnomalies) Record(ctx context.Context, arg models.InsertKeywordAnomalyParams) (anomaly models.KeywordAnomaly, ok bool, err error) {
+	anomaly, err = k.Queries.InsertKeywordAnomaly(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.KeywordAnomaly{}, false, nil
+	}
+
+	if err != nil {
+		return models.KeywordAnomaly{}, false, handlePgxErr(err)
+	}
+	return anomaly, true, nil
+}
+
+// Since returns up to limit anomalies flagged on the day of since or later,
+// the latest day and the highest z-score first.
+func (k KeywordAnomalies) Since(ctx context.Context, since time.Time, limit int32) ([]models.ListKeywordAnomaliesSinceRow, error) {
+	date, err := utils.TimeTo.PGDate(since)
+	if err != nil {
+		return nil, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Date").
+			WithDetails(fmt.Sprintf("time: %v", since.Format(time.DateOnly))).
+			Warp(err)
+	}
+
+	rows, err := k.querier(ctx, "KeywordAnomalies", "Since").ListKeywordAnomaliesSince(ctx, models.ListKeywordAnomaliesSinceParams{
+		Since: date,
+		Limit: limit,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
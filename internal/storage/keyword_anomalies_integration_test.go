@@ -0,0 +1,91 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeywordAnomalies(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx := context.Background()
+	loc := scrapers.DefaultTimeZone
+
+	keyword := func(term string) int32 {
+		var id int32
+		require.NoError(t, pool.QueryRow(ctx,
+			`INSERT INTO keywords (term, lang) VALUES ($1, 'zh-Hant') RETURNING id`,
+			term).Scan(&id))
+		return id
+	}
+	spiking := keyword("kw-" + uuid.NewString()[:8])
+	stopword := keyword("kw-" + uuid.NewString()[:8])
+	require.NoError(t, s.Keywords().SetLowInformation(ctx, stopword, true))
+
+	// 23:30 in Taipei is the day before in UTC
+	day := time.Date(1999, 1, 2, 0, 0, 0, 0, loc)
+	for _, at := range []time.Time{day.Add(time.Hour), day.Add(23*time.Hour + 30*time.Minute), day.AddDate(0, 0, 1)} {
+		var aID int32
+		require.NoError(t, pool.QueryRow(ctx, `
+INSERT INTO articles (title, url, source, md5, content, published_at)
+VALUES ($1, $2, 'test', $3, 'content', $4) RETURNING id`,
+			"title", "https://example.com/"+uuid.NewString(), uuid.NewString(), at).Scan(&aID))
+		_, err := pool.Exec(ctx, `
+INSERT INTO articles_keywords (article_id, keyword_id) VALUES ($1, $2), ($1, $3)`,
+			aID, spiking, stopword)
+		require.NoError(t, err)
+	}
+
+	rows, err := s.KeywordAnomalies().Trend(ctx, loc, day, day.AddDate(0, 0, 2))
+	require.NoError(t, err)
+	counts := map[string]int32{}
+	for _, row := range rows {
+		require.NotEqual(t, stopword, row.KeywordID)
+		if row.KeywordID == spiking {
+			counts[row.Day.Time.Format(time.DateOnly)] = row.Count
+		}
+	}
+	require.Equal(t, map[string]int32{"1999-01-02": 2, "1999-01-03": 1}, counts)
+
+	date, err := utils.TimeTo.PGDate(day)
+	require.NoError(t, err)
+	arg := models.InsertKeywordAnomalyParams{
+		KeywordID:      spiking,
+		Date:           date,
+		Count:          2,
+		BaselineMean:   0.1,
+		BaselineStddev: 0.3,
+		Zscore:         6.3,
+	}
+	anomaly, ok, err := s.KeywordAnomalies().Record(ctx, arg)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, spiking, anomaly.KeywordID)
+
+	// a keyword is flagged once per day
+	arg.Count = 5
+	_, ok, err = s.KeywordAnomalies().Record(ctx, arg)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	since, err := s.KeywordAnomalies().Since(ctx, day, 1000)
+	require.NoError(t, err)
+	var found bool
+	for _, row := range since {
+		if row.KeywordID == spiking {
+			found = true
+			require.Equal(t, int32(2), row.Count)
+			require.Equal(t, "1999-01-02", row.Date.Time.Format(time.DateOnly))
+		}
+	}
+	require.True(t, found)
+}
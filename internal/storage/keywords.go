@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+)
+
+func (s Storage) Keywords() Keywords {
+	return Keywords{s}
+}
+
+// Keywords provides methods to store the keywords extracted from the articles
+// with their language tag, and the links between keywords of different
+// languages.
+type Keywords struct {
+	Storage
+}
+
+// ArticleKeyword is a keyword term extracted from an article with its
+// category.
+type ArticleKeyword struct {
+	Category models.KeywordCategory `json:"category"`
+	Term     string                 `json:"term"`
+}
+
+// InsertForUserArticle inserts the terms of lang that do not exist yet, and
+// attaches all of them to a user article in the same transaction.
+func (k Keywords) InsertForUserArticle(ctx context.Context, articleID int32, lang string,
+	terms []string) ([]models.Keyword, error) {
+	if lang == "" {
+		return nil, errors.ErrValidationFailed.Clone().
+			WithMessage("keyword language should not be empty")
+	}
+
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	tx, err := k.db.Begin(ctx)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := k.Queries.WithTx(tx)
+	keywords, err := q.UpsertKeywords(ctx, models.UpsertKeywordsParams{
+		Terms: terms,
+		Lang:  lang,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	ids := make([]int32, len(keywords))
+	for i, kw := range keywords {
+		ids[i] = kw.ID
+	}
+
+	if err := q.InsertUsersArticleKeywords(ctx, models.InsertUsersArticleKeywordsParams{
+		ArticleID:  articleID,
+		KeywordIds: ids,
+	}); err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return keywords, nil
+}
+
+// Insert attaches a keyword of lang to a user article with its category, see
+// BatchInsert.
+func (k Keywords) Insert(ctx context.Context, articleID int32, lang string,
+	category models.KeywordCategory, term string) ([]models.Keyword, error) {
+	return k.BatchInsert(ctx, articleID, lang, []ArticleKeyword{{Category: category, Term: term}})
+}
+
+// BatchInsert inserts the terms of lang that do not exist yet, and attaches
+// all of them to a user article with their category in the same transaction.
+// A term is attached once, with the category it first appears with. The
+// category of a term attached already, e.g. by an earlier extraction, is
+// updated instead of attaching it again.
+func (k Keywords) BatchInsert(ctx context.Context, articleID int32, lang string,
+	keywords []ArticleKeyword) ([]models.Keyword, error) {
+	if lang == "" {
+		return nil, errors.ErrValidationFailed.Clone().
+			WithMessage("keyword language should not be empty")
+	}
+
+	terms := make([]string, 0, len(keywords))
+	categories := make(map[string]models.KeywordCategory, len(keywords))
+	for _, kw := range keywords {
+		if !kw.Category.Valid() {
+			return nil, errors.ErrValidationFailed.Clone().
+				WithMessage("invalid keyword category").
+				WithDetails(fmt.Sprintf("term: %s, category: %s", kw.Term, kw.Category))
+		}
+
+		if _, ok := categories[kw.Term]; ok {
+			continue
+		}
+		categories[kw.Term] = kw.Category
+		terms = append(terms, kw.Term)
+	}
+
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	tx, err := k.db.Begin(ctx)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := k.Queries.WithTx(tx)
+	inserted, err := q.UpsertKeywords(ctx, models.UpsertKeywordsParams{
+		Terms: terms,
+		Lang:  lang,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	ids := make([]int32, len(inserted))
+	cats := make([]string, len(inserted))
+	for i, kw := range inserted {
+		ids[i] = kw.ID
+		cats[i] = string(categories[kw.Term])
+	}
+
+	if err := q.UpsertUsersArticleKeywords(ctx, models.UpsertUsersArticleKeywordsParams{
+		ArticleID:  articleID,
+		KeywordIds: ids,
+		Categories: cats,
+	}); err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return inserted, nil
+}
+
+// GetByArticleID returns the keywords of a user article with their category.
+func (k Keywords) GetByArticleID(ctx context.Context, articleID int32) ([]models.ListKeywordsByUsersArticleIDRow, error) {
+	keywords, err := k.querier(ctx, "Keywords", "GetByArticleID").ListKeywordsByUsersArticleID(ctx, articleID)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return keywords, nil
+}
+
+// TopKeywords returns up to limit keywords attached to the most user articles
+// published since since, the low information keywords left out.
+func (k Keywords) TopKeywords(ctx context.Context, since time.Time, limit int32) ([]models.ListTopUsersKeywordsRow, error) {
+	sinceTsz, err := utils.TimeTo.PGTimestamptz(since)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", since.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	rows, err := k.querier(ctx, "Keywords", "TopKeywords").ListTopUsersKeywords(ctx, models.ListTopUsersKeywordsParams{
+		Since: sinceTsz,
+		Limit: limit,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
+
+// ListByLang returns up to limit keywords of lang, the most recent first.
+func (k Keywords) ListByLang(ctx context.Context, lang string, limit int32) ([]models.Keyword, error) {
+	keywords, err := k.querier(ctx, "Keywords", "ListByLang").ListKeywordsByLang(ctx, models.ListKeywordsByLangParams{
+		Lang:  lang,
+		Limit: limit,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return keywords, nil
+}
+
+// Link links two keywords of different languages, the pair is stored once
+// regardless of the order of the ids.
+func (k Keywords) Link(ctx context.Context, keywordID, linkedKeywordID int32, similarity float32) error {
+	if keywordID == linkedKeywordID {
+		return errors.ErrValidationFailed.Clone().
+			WithMessage("a keyword should not be linked to itself")
+	}
+
+	if err := k.Queries.InsertKeywordLink(ctx, models.InsertKeywordLinkParams{
+		KeywordID:       keywordID,
+		LinkedKeywordID: linkedKeywordID,
+		Similarity:      similarity,
+	}); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
+
+// SetLowInformation flags or unflags a keyword as too generic to tell the
+// articles apart. The flagged keywords are left out of the trend analysis.
+func (k Keywords) SetLowInformation(ctx context.Context, keywordID int32, lowInformation bool) error {
+	if err := k.Queries.SetKeywordLowInformation(ctx, models.SetKeywordLowInformationParams{
+		LowInformation: lowInformation,
+		ID:             keywordID,
+	}); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeywordsLanguageTag(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx := context.Background()
+
+	taskID, err := s.Task().InsertFromText(ctx, "keywords "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	aID, err := s.UserArticles().Insert(ctx, taskID, "title "+uuid.NewString(), "test",
+		"content", nil, time.Now(), time.Time{}, nil)
+	require.NoError(t, err)
+
+	term := "kw-" + uuid.NewString()[:8]
+	zh, err := s.Keywords().InsertForUserArticle(ctx, aID, "zh-Hant", []string{term, "能源政策"})
+	require.NoError(t, err)
+	require.Len(t, zh, 2)
+
+	// the same term in another language is another keyword
+	en, err := s.Keywords().InsertForUserArticle(ctx, aID, "en", []string{term, "energy policy"})
+	require.NoError(t, err)
+	require.Len(t, en, 2)
+	require.NotEqual(t, zh[0].ID, en[0].ID)
+	for _, k := range en {
+		require.Equal(t, "en", k.Lang)
+	}
+
+	// inserting again returns the existing keywords
+	again, err := s.Keywords().InsertForUserArticle(ctx, aID, "en", []string{term})
+	require.NoError(t, err)
+	require.Equal(t, en[0].ID, again[0].ID)
+
+	var langs []string
+	rows, err := pool.Query(ctx, `
+SELECT k.lang FROM users.articles_keywords AS ak
+JOIN keywords AS k ON k.id = ak.keyword_id
+WHERE ak.article_id = $1 AND k.term = $2
+ORDER BY k.lang`, aID, term)
+	require.NoError(t, err)
+	for rows.Next() {
+		var lang string
+		require.NoError(t, rows.Scan(&lang))
+		langs = append(langs, lang)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{"en", "zh-Hant"}, langs)
+
+	// links are stored once per pair
+	require.NoError(t, s.Keywords().Link(ctx, en[1].ID, zh[1].ID, 0.9))
+	require.NoError(t, s.Keywords().Link(ctx, zh[1].ID, en[1].ID, 0.95))
+	var n int
+	var sim float32
+	require.NoError(t, pool.QueryRow(ctx, `
+SELECT COUNT(*), MAX(similarity) FROM keyword_links
+WHERE keyword_id = LEAST($1::integer, $2::integer)
+    AND linked_keyword_id = GREATEST($1::integer, $2::integer)`,
+		zh[1].ID, en[1].ID).Scan(&n, &sim))
+	require.Equal(t, 1, n)
+	require.InDelta(t, 0.95, sim, 1e-6)
+}
+
+func TestKeywordsCategory(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx := context.Background()
+
+	taskID, err := s.Task().InsertFromText(ctx, "keywords "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	aID, err := s.UserArticles().Insert(ctx, taskID, "title "+uuid.NewString(), "test",
+		"content", nil, time.Now(), time.Time{}, nil)
+	require.NoError(t, err)
+
+	entity := "entity-" + uuid.NewString()[:8]
+	theme := "theme-" + uuid.NewString()[:8]
+	inserted, err := s.Keywords().BatchInsert(ctx, aID, "en", []storage.ArticleKeyword{
+		{Category: models.KeywordCategoryEntity, Term: entity},
+		{Category: models.KeywordCategoryTheme, Term: theme},
+		{Category: models.KeywordCategoryAction, Term: entity},
+	})
+	require.NoError(t, err)
+	require.Len(t, inserted, 2)
+
+	_, err = s.Keywords().BatchInsert(ctx, aID, "en", []storage.ArticleKeyword{
+		{Category: "opinion", Term: theme},
+	})
+	require.Error(t, err)
+
+	// re-extracting updates the category instead of attaching the term again
+	_, err = s.Keywords().Insert(ctx, aID, "en", models.KeywordCategoryEvent, theme)
+	require.NoError(t, err)
+
+	keywords, err := s.Keywords().GetByArticleID(ctx, aID)
+	require.NoError(t, err)
+	require.Len(t, keywords, 2)
+	require.Equal(t, models.KeywordCategoryEvent, keywords[0].Category.KeywordCategory)
+	require.Equal(t, theme, keywords[0].Term)
+	require.Equal(t, models.KeywordCategoryEntity, keywords[1].Category.KeywordCategory)
+	require.Equal(t, entity, keywords[1].Term)
+
+	top, err := s.Keywords().TopKeywords(ctx, time.Now().Add(-time.Hour), 1000)
+	require.NoError(t, err)
+	counts := map[string]int32{}
+	for _, row := range top {
+		counts[row.Term] = row.ArticleCount
+	}
+	require.Equal(t, int32(1), counts[entity])
+	require.Equal(t, int32(1), counts[theme])
+
+	// the articles published before since are not counted
+	top, err = s.Keywords().TopKeywords(ctx, time.Now().Add(time.Hour), 1000)
+	require.NoError(t, err)
+	for _, row := range top {
+		require.NotEqual(t, entity, row.Term)
+	}
+}
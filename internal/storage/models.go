@@ -2,81 +2,160 @@ package storage
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
 )
 
+// MaxModelDim is the most dimensions of a vector pgvector stores.
+const MaxModelDim = 16000
+
 func (s Storage) Models() Models {
-	return Models{
-		db: s.Queries,
-	}
+	return Models{s}
 }
 
 type Models struct {
-	db models.Querier
+	Storage
 }
 
-// Insert adds a new LLM model to the database and returns its ID.
-func (m Models) Insert(ctx context.Context, name string) (int32, error) {
-	mID, err := m.db.InsertModel(ctx, name)
+// Insert adds a new LLM model to the database and returns its ID. dim is the
+// number of dimensions of the embeddings of the model, the embeddings inserted
+// under the model are checked against it. The HNSW indexes the searches under
+// the model go through are built once it is inserted, see GetOrCreate.
+func (m Models) Insert(ctx context.Context, name string, dim int32) (int32, error) {
+	if err := checkDim(dim); err != nil {
+		return 0, err
+	}
+
+	mID, err := m.Queries.InsertModel(ctx, models.InsertModelParams{
+		Name: name,
+		Dim:  dim,
+	})
 	if err != nil {
 		return 0, handlePgxErr(err)
 	}
+
+	if err := m.VectorIndexMaintenance().createModelIndexes(ctx, mID, dim); err != nil {
+		return 0, err
+	}
 	return mID, nil
 }
 
+// GetOrCreate returns the ID of the model of name, inserting it with dim if
+// there is none, in a single statement so that concurrent callers never race
+// on the unique name. It fails if the model exists with another dimension, or
+// is deactivated: its ID would only be used to insert new embeddings under it.
+//
+// The model inserted gets its HNSW index on embeddings and on users.embeddings,
+// a partial index over the vectors of the model typed with its dimension,
+// without which its embeddings are scanned exactly by the searches. The
+// indexes are built concurrently once the model is committed; the ID is not
+// returned before they are, and an error leaves the model without them.
+func (m Models) GetOrCreate(ctx context.Context, name string, dim int32) (int32, error) {
+	if err := checkDim(dim); err != nil {
+		return 0, err
+	}
+
+	model, err := m.Queries.GetOrCreateModel(ctx, models.GetOrCreateModelParams{
+		Name: name,
+		Dim:  dim,
+	})
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	if model.Dim != dim {
+		return 0, errors.ErrConflict.Clone().
+			WithMessage("model exists with another dimension").
+			WithDetails(fmt.Sprintf("model: %s, dimension: %d, got: %d", name, model.Dim, dim))
+	}
+
+	if !model.Active {
+		return 0, errors.ErrConflict.Clone().
+			WithMessage("model is deactivated").
+			WithDetails(fmt.Sprintf("model ID: %d, name: %s", model.ID, name))
+	}
+
+	if model.Inserted {
+		if err := m.VectorIndexMaintenance().createModelIndexes(ctx, model.ID, dim); err != nil {
+			return 0, err
+		}
+	}
+	return model.ID, nil
+}
+
+// checkDim checks dim is a number of dimensions pgvector stores.
+func checkDim(dim int32) error {
+	if dim <= 0 || dim > MaxModelDim {
+		return errors.ErrValidationFailed.Clone().
+			WithMessage(fmt.Sprintf("model dimension must be between 1 and %d", MaxModelDim)).
+			WithDetails(fmt.Sprintf("got: %d", dim))
+	}
+	return nil
+}
+
 // GetByID retrieves the LLM model by its ID.
 func (m Models) GetByID(ctx context.Context, id int32) (models.Model, error) {
-	model, err := m.db.GetModelByID(ctx, id)
+	model, err := m.querier(ctx, "Models", "GetByID").GetModelByID(ctx, id)
 	if err != nil {
 		return models.Model{}, handlePgxErr(err)
 	}
 
 	return models.Model{
-		ID:   model.ID,
-		Name: model.Name,
+		ID:     model.ID,
+		Name:   model.Name,
+		Dim:    model.Dim,
+		Active: model.Active,
 	}, nil
 
 }
 
 // GetByName retrieves a model by its name.
 func (m Models) GetByName(ctx context.Context, name string) (models.Model, error) {
-	model, err := m.db.GetModelByName(ctx, name)
+	model, err := m.querier(ctx, "Models", "GetByName").GetModelByName(ctx, name)
 	if err != nil {
 		return models.Model{}, handlePgxErr(err)
 	}
 
 	return models.Model{
-		ID:   model.ID,
-		Name: model.Name,
+		ID:     model.ID,
+		Name:   model.Name,
+		Dim:    model.Dim,
+		Active: model.Active,
 	}, nil
 }
 
-// List retrieves a list of models with pagination support.
-func (m Models) List(ctx context.Context, limit, offset int32) ([]models.Model, error) {
-	rows, err := m.db.ListModels(ctx, models.ListModelsParams{
-		Limit:  limit,
-		Offset: offset,
-	})
-
+// List retrieves all the models, the most recently created first, the
+// deactivated ones included.
+func (m Models) List(ctx context.Context) ([]models.Model, error) {
+	rows, err := m.querier(ctx, "Models", "List").ListModels(ctx)
 	if err != nil {
 		return nil, handlePgxErr(err)
 	}
+	return rows, nil
+}
 
-	result := make([]models.Model, len(rows))
-	for i, model := range rows {
-		result[i] = models.Model{
-			ID:   model.ID,
-			Name: model.Name,
-		}
+// Deactivate retires the model of id: no new embedding is inserted under it,
+// while its embeddings stay searchable. Deactivating a deactivated model is a
+// no-op.
+func (m Models) Deactivate(ctx context.Context, id int32) error {
+	n, err := m.Queries.DeactivateModel(ctx, id)
+	if err != nil {
+		return handlePgxErr(err)
 	}
 
-	return result, nil
+	if n == 0 {
+		return errors.ErrNotFound.Clone().
+			WithMessage("model not found").
+			WithDetails(fmt.Sprintf("model ID: %d", id))
+	}
+	return nil
 }
 
 // DeleteByID removes a model by its ID.
 func (m Models) DeleteByID(ctx context.Context, id int32) error {
-	err := m.db.DeleteModelByID(ctx, id)
+	err := m.Queries.DeleteModelByID(ctx, id)
 	if err != nil {
 		return handlePgxErr(err)
 	}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// DefaultReconciliationReports is the number of reports Reports returns if no
+// limit is given.
+const DefaultReconciliationReports = 20
+
+func (s Storage) Reconciliation() Reconciliation {
+	return Reconciliation{s}
+}
+
+// Reconciliation provides the methods the reconciliation of the cache with the
+// database runs on: paging through the done tasks, loading their artifacts and
+// keeping the report of each pass.
+type Reconciliation struct {
+	Storage
+}
+
+// ReconciliationReport is the report of a reconciliation pass. Counts holds
+// the number of divergences by class, FinishedAt is nil while the pass runs.
+type ReconciliationReport struct {
+	ID         int32            `json:"id"`
+	Since      time.Time        `json:"since"`
+	LastTaskID int32            `json:"last_task_id"`
+	Checked    int32            `json:"checked"`
+	Sampled    int32            `json:"sampled"`
+	Counts     map[string]int32 `json:"counts"`
+	Repaired   int32            `json:"repaired"`
+	Flagged    int32            `json:"flagged"`
+	StartedAt  time.Time        `json:"started_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+}
+
+func newReconciliationReport(r models.ReconciliationReport) (ReconciliationReport, error) {
+	report := ReconciliationReport{
+		ID:         r.ID,
+		Since:      r.Since.Time,
+		LastTaskID: r.LastTaskID,
+		Checked:    r.Checked,
+		Sampled:    r.Sampled,
+		Counts:     map[string]int32{},
+		Repaired:   r.Repaired,
+		Flagged:    r.Flagged,
+		StartedAt:  r.StartedAt.Time,
+		UpdatedAt:  r.UpdatedAt.Time,
+	}
+	if r.FinishedAt.Valid {
+		report.FinishedAt = &r.FinishedAt.Time
+	}
+
+	if len(r.Counts) > 0 {
+		if err := json.Unmarshal(r.Counts, &report.Counts); err != nil {
+			return ReconciliationReport{}, ec.ErrDBTypeConversionError.Clone().
+				WithMessage("failed to unmarshal reconciliation counts").
+				Warp(err)
+		}
+	}
+	return report, nil
+}
+
+// DoneTasksSince returns up to limit tasks done since since with a row id
+// above afterID, by row id.
+func (r Reconciliation) DoneTasksSince(ctx context.Context, since time.Time,
+	afterID, limit int32) ([]models.ListDoneTasksSinceRow, error) {
+	tasks, err := r.querier(ctx, "Reconciliation", "DoneTasksSince").ListDoneTasksSince(ctx,
+		models.ListDoneTasksSinceParams{
+			Since:   pgtype.Timestamptz{Time: since, Valid: true},
+			AfterID: afterID,
+			Limit:   limit,
+		})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return tasks, nil
+}
+
+// Artifacts returns the pipeline artifacts of the user articles of the task.
+func (r Reconciliation) Artifacts(ctx context.Context, taskID uuid.UUID) ([]models.ListTaskArtifactsRow, error) {
+	artifacts, err := r.querier(ctx, "Reconciliation", "Artifacts").ListTaskArtifacts(ctx, taskID)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return artifacts, nil
+}
+
+// Running returns the report of the pass not finished yet, and whether there
+// is one.
+func (r Reconciliation) Running(ctx context.Context) (ReconciliationReport, bool, error) {
+	row, err := r.querier(ctx, "Reconciliation", "Running").GetRunningReconciliationReport(ctx)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ReconciliationReport{}, false, nil
+	}
+	if err != nil {
+		return ReconciliationReport{}, false, handlePgxErr(err)
+	}
+
+	report, err := newReconciliationReport(row)
+	if err != nil {
+		return ReconciliationReport{}, false, err
+	}
+	return report, true, nil
+}
+
+// Start starts the report of a pass over the tasks done since since. It fails
+// with a conflict if another pass is running.
+func (r Reconciliation) Start(ctx context.Context, since time.Time) (ReconciliationReport, error) {
+	row, err := r.Queries.CreateReconciliationReport(ctx, pgtype.Timestamptz{Time: since, Valid: true})
+	if err != nil {
+		return ReconciliationReport{}, handlePgxErr(err)
+	}
+	return newReconciliationReport(row)
+}
+
+// Save records the progress of the pass of report, and finishes it if finished
+// is set.
+func (r Reconciliation) Save(ctx context.Context, report ReconciliationReport, finished bool) (ReconciliationReport, error) {
+	counts, err := json.Marshal(report.Counts)
+	if err != nil {
+		return ReconciliationReport{}, ec.ErrInternalServerError.Clone().
+			WithMessage("failed to marshal reconciliation counts").
+			Warp(err)
+	}
+
+	row, err := r.Queries.UpdateReconciliationReport(ctx, models.UpdateReconciliationReportParams{
+		LastTaskID: report.LastTaskID,
+		Checked:    report.Checked,
+		Sampled:    report.Sampled,
+		Counts:     counts,
+		Repaired:   report.Repaired,
+		Flagged:    report.Flagged,
+		Finished:   finished,
+		ID:         report.ID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ReconciliationReport{}, ec.ErrNotFound.Clone().
+			WithDetails("reconciliation report not found")
+	}
+	if err != nil {
+		return ReconciliationReport{}, handlePgxErr(err)
+	}
+	return newReconciliationReport(row)
+}
+
+// Reports returns the latest limit reports, newest first,
+// DefaultReconciliationReports if limit is not positive.
+func (r Reconciliation) Reports(ctx context.Context, limit int32) ([]ReconciliationReport, error) {
+	if limit <= 0 {
+		limit = DefaultReconciliationReports
+	}
+
+	rows, err := r.querier(ctx, "Reconciliation", "Reports").ListReconciliationReports(ctx, limit)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	reports := make([]ReconciliationReport, 0, len(rows))
+	for _, row := range rows {
+		report, err := newReconciliationReport(row)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
@@ -0,0 +1,392 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// DefaultReviewLease is how long a claim on a review item lasts.
+	DefaultReviewLease = 15 * time.Minute
+	// MaxReviewLease caps the lease an editor may ask for.
+	MaxReviewLease = 2 * time.Hour
+	// DefaultReviewMetricsWindow is the window of the resolved items the
+	// median time to resolution is computed over.
+	DefaultReviewMetricsWindow = 7 * 24 * time.Hour
+)
+
+var (
+	reviewQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "review_queue_depth",
+		Help: "Number of open review items, by item type.",
+	}, []string{"item_type"})
+	reviewResolutionSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "review_queue_resolution_median_seconds",
+		Help: "Median time from queueing to closing of the review items closed in the metrics window, by item type.",
+	}, []string{"item_type"})
+)
+
+// ReviewAction is the decision of an editor on a review item.
+type ReviewAction string
+
+const (
+	// ReviewActionApprove accepts the flagged output as it is.
+	ReviewActionApprove ReviewAction = "approve"
+	// ReviewActionDismiss rejects the flagged output.
+	ReviewActionDismiss ReviewAction = "dismiss"
+)
+
+// ReviewItem is a pipeline output flagged for an editor. Ref holds the ids
+// needed to load the item, and identifies it along with Type: flagging the
+// same item again while it is open does not queue it twice. A nil Ref refers
+// to the article.
+type ReviewItem struct {
+	Type      models.ReviewItemType
+	Ref       map[string]any
+	ArticleID int32
+	Reason    string
+	Priority  int32
+}
+
+// ReviewDomain carries out the domain operations the resolutions of the
+// review items delegate to.
+type ReviewDomain interface {
+	// ApproveKeywordOutput releases the keyword output of the user article.
+	ApproveKeywordOutput(ctx context.Context, articleID int32) error
+	// Rescrape schedules the user article to be scraped again.
+	Rescrape(ctx context.Context, articleID int32) error
+}
+
+// ApplyResolution runs the domain operation of resolving item with action and
+// returns the status the item is closed with. Approving a keyword output
+// clears its review flag, and dismissing an extraction rescrapes the article.
+// The other resolutions only close the item.
+func ApplyResolution(ctx context.Context, domain ReviewDomain,
+	item models.UsersReviewQueue, action ReviewAction) (models.ReviewStatus, error) {
+	switch action {
+	case ReviewActionApprove:
+		if item.ItemType == models.ReviewItemTypeKeywordOutput {
+			if err := domain.ApproveKeywordOutput(ctx, item.ArticleID); err != nil {
+				return "", err
+			}
+		}
+		return models.ReviewStatusResolved, nil
+	case ReviewActionDismiss:
+		if item.ItemType == models.ReviewItemTypeExtraction {
+			if err := domain.Rescrape(ctx, item.ArticleID); err != nil {
+				return "", err
+			}
+		}
+		return models.ReviewStatusDismissed, nil
+	}
+	return "", ec.ErrValidationFailed.Clone().
+		WithMessage("invalid review action").
+		WithDetails(fmt.Sprintf("action: %q", action))
+}
+
+// LeaseHeld reports whether editor holds an unexpired lease on item at now.
+func LeaseHeld(item models.UsersReviewQueue, editor string, now time.Time) bool {
+	return item.AssignedTo.Valid && item.AssignedTo.String == editor &&
+		item.LeaseExpiresAt.Valid && item.LeaseExpiresAt.Time.After(now)
+}
+
+// ReviewQueueStats are the depth of the open review items and the median time
+// to resolution of the closed ones, by item type.
+type ReviewQueueStats struct {
+	Depth            map[models.ReviewItemType]int64   `json:"depth"`
+	MedianResolution map[models.ReviewItemType]float64 `json:"median_resolution_seconds"`
+}
+
+func (s Storage) ReviewQueue() ReviewQueue {
+	return ReviewQueue{s}
+}
+
+// ReviewQueue provides methods to queue the pipeline outputs flagged for an
+// editor and to work through them.
+type ReviewQueue struct {
+	Storage
+}
+
+// Enqueue queues item, or returns the open item it has already been queued
+// as, with the priority raised to the higher of the two.
+func (r ReviewQueue) Enqueue(ctx context.Context, item ReviewItem) (models.UsersReviewQueue, error) {
+	if !item.Type.Valid() {
+		return models.UsersReviewQueue{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid review item").
+			WithDetails(fmt.Sprintf("invalid item type: %q", item.Type))
+	}
+
+	if item.Reason == "" {
+		return models.UsersReviewQueue{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid review item").
+			WithDetails("a review item requires a reason")
+	}
+
+	ref := item.Ref
+	if ref == nil {
+		ref = map[string]any{"article_id": item.ArticleID}
+	}
+
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return models.UsersReviewQueue{}, ec.ErrInternalServerError.Clone().
+			WithMessage("failed to marshal review item ref").
+			Warp(err)
+	}
+
+	queued, err := r.Queries.EnqueueReviewItem(ctx, models.EnqueueReviewItemParams{
+		ItemType:  item.Type,
+		ItemRef:   data,
+		ArticleID: item.ArticleID,
+		Reason:    item.Reason,
+		Priority:  item.Priority,
+	})
+	if err != nil {
+		return models.UsersReviewQueue{}, handlePgxErr(err)
+	}
+	return queued, nil
+}
+
+// Get returns the review item.
+func (r ReviewQueue) Get(ctx context.Context, id int32) (models.UsersReviewQueue, error) {
+	return r.get(ctx, r.querier(ctx, "ReviewQueue", "Get"), id)
+}
+
+func (r ReviewQueue) get(ctx context.Context, q models.Querier, id int32) (models.UsersReviewQueue, error) {
+	item, err := q.GetReviewItem(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.UsersReviewQueue{}, ec.ErrNotFound.Clone().
+			WithDetails(fmt.Sprintf("review item %d not found", id))
+	}
+
+	if err != nil {
+		return models.UsersReviewQueue{}, handlePgxErr(err)
+	}
+	return item, nil
+}
+
+// List pages through the review items of status, oldest first. An empty
+// itemType lists the items of every type.
+func (r ReviewQueue) List(ctx context.Context, status models.ReviewStatus,
+	itemType models.ReviewItemType, limit, offset int32) ([]models.UsersReviewQueue, error) {
+	if !status.Valid() {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid review status").
+			WithDetails(fmt.Sprintf("status: %q", status))
+	}
+
+	if itemType != "" && !itemType.Valid() {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid review item type").
+			WithDetails(fmt.Sprintf("item type: %q", itemType))
+	}
+
+	items, err := r.querier(ctx, "ReviewQueue", "List").ListReviewItems(ctx, models.ListReviewItemsParams{
+		Status:   status,
+		ItemType: string(itemType),
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return items, nil
+}
+
+// Claim assigns the open review item to editor for lease from now. An item
+// assigned to another editor is claimed only once their lease has expired,
+// and claiming an item again renews the lease.
+func (r ReviewQueue) Claim(ctx context.Context, id int32, editor string,
+	now time.Time, lease time.Duration) (models.UsersReviewQueue, error) {
+	if editor == "" {
+		return models.UsersReviewQueue{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid review claim").
+			WithDetails("a claim requires an editor")
+	}
+
+	if lease <= 0 || lease > MaxReviewLease {
+		return models.UsersReviewQueue{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid review claim").
+			WithDetails(fmt.Sprintf("lease must be in (0, %v]", MaxReviewLease))
+	}
+
+	nowTsz, err := utils.TimeTo.PGTimestamptz(now)
+	if err != nil {
+		return models.UsersReviewQueue{}, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			Warp(err)
+	}
+
+	expiresTsz, err := utils.TimeTo.PGTimestamptz(now.Add(lease))
+	if err != nil {
+		return models.UsersReviewQueue{}, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			Warp(err)
+	}
+
+	item, err := r.Queries.ClaimReviewItem(ctx, models.ClaimReviewItemParams{
+		AssignedTo:     editor,
+		LeaseExpiresAt: expiresTsz,
+		ID:             id,
+		Now:            nowTsz,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.UsersReviewQueue{}, r.unclaimable(ctx, id)
+	}
+
+	if err != nil {
+		return models.UsersReviewQueue{}, handlePgxErr(err)
+	}
+	return item, nil
+}
+
+// unclaimable explains why the review item could not be claimed.
+func (r ReviewQueue) unclaimable(ctx context.Context, id int32) error {
+	item, err := r.get(ctx, r.Queries, id)
+	if err != nil {
+		return err
+	}
+
+	if item.Status != models.ReviewStatusOpen {
+		return ec.ErrConflict.Clone().
+			WithMessage("review item is closed").
+			WithDetails(fmt.Sprintf("review item %d is %s", id, item.Status))
+	}
+	return ec.ErrConflict.Clone().
+		WithMessage("review item is claimed").
+		WithDetails(fmt.Sprintf("review item %d is claimed by %s until %s", id,
+			item.AssignedTo.String, item.LeaseExpiresAt.Time.Format(time.RFC3339)))
+}
+
+// Resolve closes the review item claimed by editor with action, after the
+// domain operation of the resolution has run. The editor must hold an
+// unexpired lease on the item.
+func (r ReviewQueue) Resolve(ctx context.Context, id int32, editor string,
+	action ReviewAction, now time.Time, domain ReviewDomain) (models.UsersReviewQueue, error) {
+	item, err := r.get(ctx, r.Queries, id)
+	if err != nil {
+		return models.UsersReviewQueue{}, err
+	}
+
+	if item.Status != models.ReviewStatusOpen {
+		return models.UsersReviewQueue{}, ec.ErrConflict.Clone().
+			WithMessage("review item is closed").
+			WithDetails(fmt.Sprintf("review item %d is %s", id, item.Status))
+	}
+
+	if !LeaseHeld(item, editor, now) {
+		return models.UsersReviewQueue{}, ec.ErrConflict.Clone().
+			WithMessage("review item is not claimed").
+			WithDetails(fmt.Sprintf("claim review item %d before resolving it", id))
+	}
+
+	status, err := ApplyResolution(ctx, domain, item, action)
+	if err != nil {
+		return models.UsersReviewQueue{}, err
+	}
+
+	nowTsz, err := utils.TimeTo.PGTimestamptz(now)
+	if err != nil {
+		return models.UsersReviewQueue{}, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			Warp(err)
+	}
+
+	closed, err := r.Queries.CloseReviewItem(ctx, models.CloseReviewItemParams{
+		Status:     status,
+		Now:        nowTsz,
+		ID:         id,
+		AssignedTo: editor,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// the lease expired or the item was closed in the meantime, the
+		// domain operations are idempotent so resolving again is safe
+		return models.UsersReviewQueue{}, ec.ErrConflict.Clone().
+			WithMessage("review item is not claimed").
+			WithDetails(fmt.Sprintf("the lease on review item %d has expired", id))
+	}
+
+	if err != nil {
+		return models.UsersReviewQueue{}, handlePgxErr(err)
+	}
+	return closed, nil
+}
+
+// Stats returns the depth of the open review items and the median time to
+// resolution of the items closed in the window before now, by item type.
+func (r ReviewQueue) Stats(ctx context.Context, now time.Time, window time.Duration) (ReviewQueueStats, error) {
+	sinceTsz, err := utils.TimeTo.PGTimestamptz(now.Add(-window))
+	if err != nil {
+		return ReviewQueueStats{}, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			Warp(err)
+	}
+
+	q := r.querier(ctx, "ReviewQueue", "Stats")
+	depth, err := q.ReviewQueueDepth(ctx)
+	if err != nil {
+		return ReviewQueueStats{}, handlePgxErr(err)
+	}
+
+	medians, err := q.ReviewQueueMedianResolution(ctx, sinceTsz)
+	if err != nil {
+		return ReviewQueueStats{}, handlePgxErr(err)
+	}
+
+	stats := ReviewQueueStats{
+		Depth:            make(map[models.ReviewItemType]int64, len(depth)),
+		MedianResolution: make(map[models.ReviewItemType]float64, len(medians)),
+	}
+	for _, row := range depth {
+		stats.Depth[row.ItemType] = row.Depth
+	}
+	for _, row := range medians {
+		stats.MedianResolution[row.ItemType] = row.MedianSeconds
+	}
+	return stats, nil
+}
+
+// RefreshMetrics sets the review queue gauges from the Stats at now.
+func (r ReviewQueue) RefreshMetrics(ctx context.Context, now time.Time, window time.Duration) error {
+	stats, err := r.Stats(ctx, now, window)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range models.AllReviewItemTypeValues() {
+		reviewQueueDepth.WithLabelValues(string(t)).Set(float64(stats.Depth[t]))
+		reviewResolutionSeconds.WithLabelValues(string(t)).Set(stats.MedianResolution[t])
+	}
+	return nil
+}
+
+// RunMetrics refreshes the review queue gauges every interval until ctx is
+// done.
+func (r ReviewQueue) RunMetrics(ctx context.Context, interval, window time.Duration) {
+	ticker := r.Clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			if err := r.RefreshMetrics(ctx, now, window); err != nil {
+				global.Logger.Error().
+					Err(err).
+					Msg("failed to refresh review queue metrics")
+			}
+		}
+	}
+}
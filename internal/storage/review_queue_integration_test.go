@@ -0,0 +1,177 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// articleReviewDomain clears the review flag of the articles for real and
+// records the rescrapes.
+type articleReviewDomain struct {
+	s         storage.Storage
+	rescraped []int32
+}
+
+func (d *articleReviewDomain) ApproveKeywordOutput(ctx context.Context, articleID int32) error {
+	return d.s.UserArticles().SetNeedsReview(ctx, articleID, false)
+}
+
+func (d *articleReviewDomain) Rescrape(_ context.Context, articleID int32) error {
+	d.rescraped = append(d.rescraped, articleID)
+	return nil
+}
+
+func reviewFixture(t *testing.T, s storage.Storage) int32 {
+	ctx := context.Background()
+	taskID, err := s.Task().InsertFromText(ctx, "review "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	aID, err := s.UserArticles().Insert(ctx, taskID, "title "+uuid.NewString(), "test",
+		"content", nil, time.Now(), time.Time{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.UserArticles().SetNeedsReview(ctx, aID, true))
+	return aID
+}
+
+func requireConflict(t *testing.T, err error) {
+	t.Helper()
+	require.Error(t, err)
+	var e *ec.Error
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, ec.ErrConflict.HttpStatusCode, e.HttpStatusCode)
+}
+
+func TestReviewQueueEnqueueIdempotent(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	aID := reviewFixture(t, s)
+	item := storage.ReviewItem{
+		Type:      models.ReviewItemTypeKeywordOutput,
+		ArticleID: aID,
+		Reason:    "no keywords extracted",
+		Priority:  1,
+	}
+
+	first, err := s.ReviewQueue().Enqueue(ctx, item)
+	require.NoError(t, err)
+
+	item.Priority = 5
+	again, err := s.ReviewQueue().Enqueue(ctx, item)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, again.ID)
+	require.EqualValues(t, 5, again.Priority)
+
+	// a lower priority does not demote the open item
+	item.Priority = 0
+	again, err = s.ReviewQueue().Enqueue(ctx, item)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, again.ID)
+	require.EqualValues(t, 5, again.Priority)
+}
+
+func TestReviewQueueClaimLease(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	aID := reviewFixture(t, s)
+	item, err := s.ReviewQueue().Enqueue(ctx, storage.ReviewItem{
+		Type:      models.ReviewItemTypeKeywordOutput,
+		ArticleID: aID,
+		Reason:    "no keywords extracted",
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	claimed, err := s.ReviewQueue().Claim(ctx, item.ID, "alice", now, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "alice", claimed.AssignedTo.String)
+
+	// the lease of alice keeps bob out until it expires
+	_, err = s.ReviewQueue().Claim(ctx, item.ID, "bob", now.Add(30*time.Second), time.Minute)
+	requireConflict(t, err)
+
+	later := now.Add(2 * time.Minute)
+	claimed, err = s.ReviewQueue().Claim(ctx, item.ID, "bob", later, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "bob", claimed.AssignedTo.String)
+
+	// alice lost the lease and cannot resolve the item anymore
+	domain := &articleReviewDomain{s: s}
+	_, err = s.ReviewQueue().Resolve(ctx, item.ID, "alice", storage.ReviewActionApprove, later, domain)
+	requireConflict(t, err)
+
+	resolved, err := s.ReviewQueue().Resolve(ctx, item.ID, "bob", storage.ReviewActionApprove,
+		later.Add(time.Second), domain)
+	require.NoError(t, err)
+	require.Equal(t, models.ReviewStatusResolved, resolved.Status)
+	require.True(t, resolved.ResolvedAt.Valid)
+
+	// a closed item can be neither claimed nor resolved again
+	_, err = s.ReviewQueue().Claim(ctx, item.ID, "alice", later.Add(time.Hour), time.Minute)
+	requireConflict(t, err)
+}
+
+func TestReviewQueueResolveDomain(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	aID := reviewFixture(t, s)
+	kw, err := s.ReviewQueue().Enqueue(ctx, storage.ReviewItem{
+		Type:      models.ReviewItemTypeKeywordOutput,
+		ArticleID: aID,
+		Reason:    "no keywords extracted",
+	})
+	require.NoError(t, err)
+	ex, err := s.ReviewQueue().Enqueue(ctx, storage.ReviewItem{
+		Type:      models.ReviewItemTypeExtraction,
+		ArticleID: aID,
+		Reason:    "content is shorter than 200 runes",
+	})
+	require.NoError(t, err)
+
+	domain := &articleReviewDomain{s: s}
+	now := time.Now()
+	for _, id := range []int32{kw.ID, ex.ID} {
+		_, err := s.ReviewQueue().Claim(ctx, id, "alice", now, time.Minute)
+		require.NoError(t, err)
+	}
+
+	// dismissing the extraction rescrapes the article but keeps the flag
+	dismissed, err := s.ReviewQueue().Resolve(ctx, ex.ID, "alice", storage.ReviewActionDismiss, now, domain)
+	require.NoError(t, err)
+	require.Equal(t, models.ReviewStatusDismissed, dismissed.Status)
+	require.Equal(t, []int32{aID}, domain.rescraped)
+
+	article, err := s.UserArticles().GetByID(ctx, aID)
+	require.NoError(t, err)
+	require.True(t, article.NeedsReview)
+
+	// approving the keyword output clears the flag
+	_, err = s.ReviewQueue().Resolve(ctx, kw.ID, "alice", storage.ReviewActionApprove, now, domain)
+	require.NoError(t, err)
+
+	article, err = s.UserArticles().GetByID(ctx, aID)
+	require.NoError(t, err)
+	require.False(t, article.NeedsReview)
+
+	items, err := s.ReviewQueue().List(ctx, models.ReviewStatusOpen, models.ReviewItemTypeExtraction, 100, 0)
+	require.NoError(t, err)
+	for _, item := range items {
+		require.NotEqual(t, ex.ID, item.ID)
+	}
+}
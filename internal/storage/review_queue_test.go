@@ -0,0 +1,108 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReviewDomain records the domain operations of the resolutions.
+type fakeReviewDomain struct {
+	needsReview map[int32]bool
+	rescraped   []int32
+}
+
+func (d *fakeReviewDomain) ApproveKeywordOutput(_ context.Context, articleID int32) error {
+	d.needsReview[articleID] = false
+	return nil
+}
+
+func (d *fakeReviewDomain) Rescrape(_ context.Context, articleID int32) error {
+	d.rescraped = append(d.rescraped, articleID)
+	return nil
+}
+
+func TestApplyResolution(t *testing.T) {
+	ctx := context.Background()
+	tcs := []struct {
+		name        string
+		itemType    models.ReviewItemType
+		action      storage.ReviewAction
+		status      models.ReviewStatus
+		needsReview bool
+		rescraped   []int32
+	}{
+		{
+			name:     "approve keyword output",
+			itemType: models.ReviewItemTypeKeywordOutput,
+			action:   storage.ReviewActionApprove,
+			status:   models.ReviewStatusResolved,
+		},
+		{
+			name:        "dismiss keyword output",
+			itemType:    models.ReviewItemTypeKeywordOutput,
+			action:      storage.ReviewActionDismiss,
+			status:      models.ReviewStatusDismissed,
+			needsReview: true,
+		},
+		{
+			name:        "approve extraction",
+			itemType:    models.ReviewItemTypeExtraction,
+			action:      storage.ReviewActionApprove,
+			status:      models.ReviewStatusResolved,
+			needsReview: true,
+		},
+		{
+			name:        "dismiss extraction",
+			itemType:    models.ReviewItemTypeExtraction,
+			action:      storage.ReviewActionDismiss,
+			status:      models.ReviewStatusDismissed,
+			needsReview: true,
+			rescraped:   []int32{7},
+		},
+		{
+			name:        "approve stance",
+			itemType:    models.ReviewItemTypeStance,
+			action:      storage.ReviewActionApprove,
+			status:      models.ReviewStatusResolved,
+			needsReview: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			domain := &fakeReviewDomain{needsReview: map[int32]bool{7: true}}
+			item := models.UsersReviewQueue{ItemType: tc.itemType, ArticleID: 7}
+
+			status, err := storage.ApplyResolution(ctx, domain, item, tc.action)
+			require.NoError(t, err)
+			require.Equal(t, tc.status, status)
+			require.Equal(t, tc.needsReview, domain.needsReview[7])
+			require.Equal(t, tc.rescraped, domain.rescraped)
+		})
+	}
+
+	domain := &fakeReviewDomain{needsReview: map[int32]bool{7: true}}
+	_, err := storage.ApplyResolution(ctx, domain,
+		models.UsersReviewQueue{ItemType: models.ReviewItemTypeKeywordOutput, ArticleID: 7}, "escalate")
+	require.Error(t, err)
+	require.True(t, domain.needsReview[7])
+}
+
+func TestLeaseHeld(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	item := models.UsersReviewQueue{
+		AssignedTo:     pgtype.Text{String: "alice", Valid: true},
+		LeaseExpiresAt: pgtype.Timestamptz{Time: now.Add(time.Minute), Valid: true},
+	}
+
+	require.True(t, storage.LeaseHeld(item, "alice", now))
+	require.False(t, storage.LeaseHeld(item, "bob", now))
+	require.False(t, storage.LeaseHeld(item, "alice", now.Add(time.Minute)))
+	require.False(t, storage.LeaseHeld(models.UsersReviewQueue{}, "alice", now))
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+)
+
+// Route tells which pool an accessor method runs its queries on.
+type Route int
+
+const (
+	// RouteWrite runs the queries on the write pool. It is the route of any
+	// method not declared otherwise.
+	RouteWrite Route = iota
+	// RouteRead runs the queries on the read pool, if one is configured.
+	RouteRead
+)
+
+func (r Route) String() string {
+	if r == RouteRead {
+		return "read"
+	}
+	return "write"
+}
+
+// Routes is the routing table of an accessor, keyed by method name.
+type Routes map[string]Route
+
+// routes holds the routing table of every accessor, keyed by accessor type
+// name. Only the methods that run without a transaction and never write may
+// be routed to the read pool.
+var routes = map[string]Routes{
+	"Annotations": {
+		"Create":            RouteWrite,
+		"ListByArticle":     RouteRead,
+		"LatestByArticle":   RouteRead,
+		"Delete":            RouteWrite,
+		"EffectiveKeywords": RouteRead,
+		"TopKeywords":       RouteRead,
+		"StanceCounts":      RouteRead,
+	},
+	"Article": {
+		"Insert":                       RouteWrite,
+		"UpsertByMD5":                  RouteWrite,
+		"BulkImport":                   RouteWrite,
+		"ExistsByMD5":                  RouteRead,
+		"GetByArticleID":               RouteRead,
+		"GetByMD5":                     RouteRead,
+		"GetByUrl":                     RouteRead,
+		"GetArticleWithinTimeInterval": RouteRead,
+		"GetByPublishedInPastKDays":    RouteRead,
+		"List":                         RouteRead,
+		"Search":                       RouteRead,
+		"FindNearDuplicates":           RouteRead,
+		"MarkDuplicate":                RouteWrite,
+		"StatsByParty":                 RouteRead,
+		"StatsBySource":                RouteRead,
+	},
+	"Chunck": {
+		"Insert":             RouteWrite,
+		"BatchInsert":        RouteWrite,
+		"ExtractByArticleID": RouteRead,
+	},
+	"Counters": {
+		"List":      RouteRead,
+		"Rollup":    RouteWrite,
+		"Reconcile": RouteWrite,
+		"Run":       RouteWrite,
+	},
+	"Freshness": {
+		"DueForRecheck": RouteRead,
+		"RecordCheck":   RouteWrite,
+		"Reprocesses":   RouteWrite,
+		"Update":        RouteWrite,
+	},
+	"KeywordAnomalies": {
+		"Trend":  RouteRead,
+		"Record": RouteWrite,
+		"Since":  RouteRead,
+	},
+	"Keywords": {
+		"Insert":               RouteWrite,
+		"BatchInsert":          RouteWrite,
+		"InsertForUserArticle": RouteWrite,
+		"GetByArticleID":       RouteRead,
+		"ListByLang":           RouteRead,
+		"TopKeywords":          RouteRead,
+		"Link":                 RouteWrite,
+		"SetLowInformation":    RouteWrite,
+	},
+	"Models": {
+		"Insert":      RouteWrite,
+		"GetOrCreate": RouteWrite,
+		"GetByID":     RouteRead,
+		"GetByName":   RouteRead,
+		"List":        RouteRead,
+		"Deactivate":  RouteWrite,
+		"DeleteByID":  RouteWrite,
+	},
+	"Reconciliation": {
+		"DoneTasksSince": RouteRead,
+		"Artifacts":      RouteRead,
+		"Running":        RouteWrite,
+		"Start":          RouteWrite,
+		"Save":           RouteWrite,
+		"Reports":        RouteRead,
+	},
+	"ReviewQueue": {
+		"Enqueue":        RouteWrite,
+		"Get":            RouteRead,
+		"List":           RouteRead,
+		"Claim":          RouteWrite,
+		"Resolve":        RouteWrite,
+		"Stats":          RouteRead,
+		"RefreshMetrics": RouteRead,
+		"RunMetrics":     RouteRead,
+	},
+	"SavedSearches": {
+		"Create":       RouteWrite,
+		"Get":          RouteRead,
+		"List":         RouteRead,
+		"Update":       RouteWrite,
+		"Delete":       RouteWrite,
+		"Hits":         RouteRead,
+		"Evaluate":     RouteWrite,
+		"RunEvaluator": RouteWrite,
+	},
+	"Schema": {
+		"Introspect": RouteWrite,
+	},
+	"Similarity": {
+		"SimilarArticles": RouteRead,
+	},
+	"SourceWeights": {
+		"List": RouteRead,
+		"Set":  RouteWrite,
+	},
+	"Stats": {
+		"PartyCounts":   RouteRead,
+		"DailyArticles": RouteRead,
+		"Dashboard":     RouteWrite,
+	},
+	// Storage holds the methods of Storage itself running a query.
+	"Storage": {
+		"HybridSearch": RouteRead,
+		"TaskResult":   RouteWrite,
+	},
+	"TaskEvents": {
+		"Append":       RouteWrite,
+		"Reopen":       RouteWrite,
+		"State":        RouteRead,
+		"Timeline":     RouteRead,
+		"Compact":      RouteWrite,
+		"RunCompactor": RouteWrite,
+	},
+	"Tasks": {
+		"InsertFromURL":   RouteWrite,
+		"InsertFromURLAs": RouteWrite,
+		"InsertFromText":  RouteWrite,
+		"Get":             RouteRead,
+		"KnownURLs":       RouteWrite,
+		"UpdateStatus":    RouteWrite,
+		"GetStatus":       RouteRead,
+		"ListByStatus":    RouteRead,
+		"Delete":          RouteWrite,
+		"PurgeOlderThan":  RouteWrite,
+		"RunPurger":       RouteWrite,
+	},
+	"Tiering": {
+		"ArchiveOlderThan": RouteWrite,
+		"RestoreToHot":     RouteWrite,
+		"Search":           RouteRead,
+		"RunArchiver":      RouteWrite,
+	},
+	"URLStatus": {
+		"DueForCheck":  RouteRead,
+		"RecordCheck":  RouteWrite,
+		"MoveURL":      RouteWrite,
+		"DeadBySource": RouteRead,
+		"List":         RouteRead,
+		"Top":          RouteRead,
+	},
+	"UserArticles": {
+		"Insert":           RouteWrite,
+		"UpsertByMD5":      RouteWrite,
+		"Replace":          RouteWrite,
+		"ExistsByMD5":      RouteRead,
+		"GetByID":          RouteRead,
+		"GetByTaskID":      RouteRead,
+		"GetByMD5":         RouteRead,
+		"SetNeedsReview":   RouteWrite,
+		"ListByTaskStatus": RouteRead,
+	},
+	"UserChunks": {
+		"Insert":             RouteWrite,
+		"BatchInsert":        RouteWrite,
+		"InsertStream":       RouteWrite,
+		"InsertContent":      RouteWrite,
+		"ExtractByArticleID": RouteRead,
+		"GetByArticleID":     RouteRead,
+		"GetWithContent":     RouteRead,
+	},
+	"UserEmbeddings": {
+		"BatchInsert":         RouteWrite,
+		"Insert":              RouteWrite,
+		"InsertPooled":        RouteWrite,
+		"InsertIfAbsent":      RouteWrite,
+		"ExistsForChunks":     RouteRead,
+		"SearchSimilar":       RouteRead,
+		"SearchSimilarShared": RouteRead,
+	},
+	"VectorIndexMaintenance": {
+		"InspectIndexes":      RouteWrite,
+		"PlanReindex":         RouteWrite,
+		"ReindexConcurrently": RouteWrite,
+	},
+}
+
+// RouteOf returns the route declared for the method of the accessor. ok is
+// false if the method is not classified.
+func RouteOf(accessor, method string) (route Route, ok bool) {
+	route, ok = routes[accessor][method]
+	return route, ok
+}
+
+type freshReadsKey struct{}
+
+// WithFreshReads returns a copy of ctx that forces the read-only methods to
+// the write pool, for the read-your-writes paths that cannot tolerate replica
+// lag, e.g. reading a task right after creating it.
+func WithFreshReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshReadsKey{}, true)
+}
+
+// FreshReads reports whether ctx is marked by WithFreshReads.
+func FreshReads(ctx context.Context) bool {
+	fresh, _ := ctx.Value(freshReadsKey{}).(bool)
+	return fresh
+}
+
+// querier returns the queries the method of the accessor should run. It falls
+// back to the write pool if no read pool is configured, the method is not
+// routed to the read pool, or ctx asks for fresh reads.
+func (s Storage) querier(ctx context.Context, accessor, method string) models.Querier {
+	if s.reader == nil || FreshReads(ctx) {
+		return s.Queries
+	}
+
+	if route, _ := RouteOf(accessor, method); route != RouteRead {
+		return s.Queries
+	}
+	return s.reader
+}
+
+// dbFor returns the connection the method of the accessor should begin its
+// transactions on, the one of the queries returned by querier.
+func (s Storage) dbFor(ctx context.Context, accessor, method string) DB {
+	if s.reader == nil || FreshReads(ctx) {
+		return s.db
+	}
+
+	if route, _ := RouteOf(accessor, method); route != RouteRead {
+		return s.db
+	}
+	return s.readDB
+}
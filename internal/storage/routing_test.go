@@ -0,0 +1,178 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+var errFakeDB = errors.New("fake db")
+
+// fakeDB records the number of queries and transactions it receives, every
+// one of them fails.
+type fakeDB struct {
+	calls int
+}
+
+func (db *fakeDB) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	db.calls++
+	return pgconn.CommandTag{}, errFakeDB
+}
+
+func (db *fakeDB) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	db.calls++
+	return nil, errFakeDB
+}
+
+func (db *fakeDB) QueryRow(context.Context, string, ...any) pgx.Row {
+	db.calls++
+	return fakeRow{}
+}
+
+func (db *fakeDB) SendBatch(context.Context, *pgx.Batch) pgx.BatchResults {
+	db.calls++
+	return nil
+}
+
+func (db *fakeDB) Begin(context.Context) (pgx.Tx, error) {
+	db.calls++
+	return nil, errFakeDB
+}
+
+func (db *fakeDB) BeginTx(context.Context, pgx.TxOptions) (pgx.Tx, error) {
+	db.calls++
+	return nil, errFakeDB
+}
+
+type fakeRow struct{}
+
+func (fakeRow) Scan(...any) error { return errFakeDB }
+
+func TestRoutingTableCoverage(t *testing.T) {
+	s := storage.New(&fakeDB{}, nil)
+
+	// Article and Chunck have no accessor on Storage.
+	accessors := []any{storage.Article{Storage: s}, storage.Chunck{Storage: s}}
+	st := reflect.ValueOf(s)
+	for i := range st.NumMethod() {
+		m := st.Type().Method(i)
+		if m.Type.NumIn() != 1 || m.Type.NumOut() != 1 || m.Type.Out(0).Kind() != reflect.Struct {
+			continue
+		}
+		accessors = append(accessors, st.Method(i).Call(nil)[0].Interface())
+	}
+	require.NotEmpty(t, accessors)
+
+	sType := reflect.TypeOf(s)
+	for _, accessor := range accessors {
+		typ := reflect.TypeOf(accessor)
+		for i := range typ.NumMethod() {
+			m := typ.Method(i)
+			if _, promoted := sType.MethodByName(m.Name); promoted {
+				continue
+			}
+
+			// Options returning a modified copy of the accessor run no query.
+			if m.Type.NumOut() == 1 && m.Type.Out(0) == typ {
+				continue
+			}
+
+			_, ok := storage.RouteOf(typ.Name(), m.Name)
+			require.Truef(t, ok, "%s.%s is not classified in the routing table", typ.Name(), m.Name)
+		}
+	}
+}
+
+func TestRouting(t *testing.T) {
+	ctx := context.Background()
+	tcs := []struct {
+		Name       string
+		ReadPool   bool
+		Ctx        context.Context
+		Call       func(ctx context.Context, s storage.Storage)
+		WantWriter int
+		WantReader int
+	}{
+		{
+			Name:     "read to read pool",
+			ReadPool: true,
+			Ctx:      ctx,
+			Call: func(ctx context.Context, s storage.Storage) {
+				_, _ = s.Models().GetByID(ctx, 1)
+			},
+			WantReader: 1,
+		},
+		{
+			Name:     "write to write pool",
+			ReadPool: true,
+			Ctx:      ctx,
+			Call: func(ctx context.Context, s storage.Storage) {
+				_, _ = s.Models().Insert(ctx, "model", 1024)
+			},
+			WantWriter: 1,
+		},
+		{
+			Name:     "transaction to write pool",
+			ReadPool: true,
+			Ctx:      ctx,
+			Call: func(ctx context.Context, s storage.Storage) {
+				_, _ = s.Task().InsertFromURL(ctx, "https://example.com", nil)
+			},
+			WantWriter: 1,
+		},
+		{
+			Name:     "read transaction to read pool",
+			ReadPool: true,
+			Ctx:      ctx,
+			Call: func(ctx context.Context, s storage.Storage) {
+				_, _ = storage.Article{Storage: s}.FindNearDuplicates(ctx, 1, 0.8)
+			},
+			WantReader: 1,
+		},
+		{
+			Name:     "fresh reads to write pool",
+			ReadPool: true,
+			Ctx:      storage.WithFreshReads(ctx),
+			Call: func(ctx context.Context, s storage.Storage) {
+				_, _ = s.Task().Get(ctx, uuid.New())
+			},
+			WantWriter: 1,
+		},
+		{
+			Name:     "read without read pool",
+			ReadPool: false,
+			Ctx:      ctx,
+			Call: func(ctx context.Context, s storage.Storage) {
+				_, _ = s.Task().Get(ctx, uuid.New())
+			},
+			WantWriter: 1,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			writer, reader := &fakeDB{}, &fakeDB{}
+			opts := []storage.Option{}
+			if tc.ReadPool {
+				opts = append(opts, storage.WithReadDB(reader))
+			}
+
+			tc.Call(tc.Ctx, storage.New(writer, nil, opts...))
+			require.Equal(t, tc.WantWriter, writer.calls)
+			require.Equal(t, tc.WantReader, reader.calls)
+		})
+	}
+}
+
+func TestFreshReads(t *testing.T) {
+	ctx := context.Background()
+	require.False(t, storage.FreshReads(ctx))
+	require.True(t, storage.FreshReads(storage.WithFreshReads(ctx)))
+}
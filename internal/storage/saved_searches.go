@@ -0,0 +1,515 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// MaxSavedSearchesPerOwner caps the number of saved searches of an owner.
+	MaxSavedSearchesPerOwner = 20
+	// MaxSavedSearchHitsPerRun caps the number of articles a run records.
+	MaxSavedSearchHitsPerRun = 1000
+	// SavedSearchDigestSize is the number of articles listed in a digest.
+	SavedSearchDigestSize = 10
+	// DefaultSavedSearchBatchSize is the number of due saved searches an
+	// evaluation runs.
+	DefaultSavedSearchBatchSize = 100
+	// SavedSearchDigestEvent is the webhook event of a digest.
+	SavedSearchDigestEvent = "saved_search.digest"
+)
+
+// ArticleFilterVersion is the current version of the ArticleFilter schema.
+const ArticleFilterVersion = 1
+
+// ArticleFilter selects the articles by party, source and keywords. A zero
+// field matches every article, and WithinDays, if set, restricts the articles
+// to the ones published in the last days.
+type ArticleFilter struct {
+	Version    int          `json:"version"`
+	Party      models.Party `json:"party,omitempty"       validate:"omitempty,oneof=none KMT DPP TPP"`
+	Source     string       `json:"source,omitempty"      validate:"max=64"`
+	Keywords   []string     `json:"keywords,omitempty"    validate:"max=10,dive,required,max=32"`
+	WithinDays int          `json:"within_days,omitempty" validate:"min=0,max=365"`
+}
+
+// articleFilterMigrations upgrades a filter of version i to version i+1.
+var articleFilterMigrations = []func(map[string]any) map[string]any{
+	migrateArticleFilterV0,
+}
+
+// migrateArticleFilterV0 upgrades the filters saved before the version field,
+// which had a single keyword, the window in days and a case-insensitive party.
+func migrateArticleFilterV0(m map[string]any) map[string]any {
+	if kw, ok := m["keyword"].(string); ok {
+		if kw != "" {
+			m["keywords"] = []any{kw}
+		}
+		delete(m, "keyword")
+	}
+
+	if days, ok := m["days"]; ok {
+		m["within_days"] = days
+		delete(m, "days")
+	}
+
+	if party, ok := m["party"].(string); ok && !strings.EqualFold(party, string(models.PartyNone)) {
+		m["party"] = strings.ToUpper(party)
+	}
+	return m
+}
+
+// ParseArticleFilter decodes a filter of any version and upgrades it to the
+// current version. A filter without a version is of version 0.
+func ParseArticleFilter(raw []byte) (ArticleFilter, error) {
+	m := map[string]any{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return ArticleFilter{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid article filter").
+			Warp(err)
+	}
+
+	version := 0
+	if v, ok := m["version"].(float64); ok {
+		version = int(v)
+	}
+
+	if version < 0 || version > ArticleFilterVersion {
+		return ArticleFilter{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid article filter").
+			WithDetails(fmt.Sprintf("unsupported filter version: %d", version))
+	}
+
+	for ; version < ArticleFilterVersion; version++ {
+		m = articleFilterMigrations[version](m)
+	}
+	m["version"] = ArticleFilterVersion
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ArticleFilter{}, ec.ErrInternalServerError.Clone().
+			WithMessage("failed to marshal article filter").
+			Warp(err)
+	}
+
+	var filter ArticleFilter
+	if err := json.Unmarshal(data, &filter); err != nil {
+		return ArticleFilter{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid article filter").
+			Warp(err)
+	}
+	return filter, nil
+}
+
+// SchedulePeriod returns the time between two runs of a saved search.
+func SchedulePeriod(schedule models.SavedSearchSchedule) time.Duration {
+	if schedule == models.SavedSearchScheduleWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// SearchWindow returns the window (after, before] of the publication times the
+// run of a saved search at now evaluates. A run picks up where the last one
+// stopped, and the first run looks one schedule period back. The window never
+// extends beyond the WithinDays of the filter.
+func SearchWindow(lastRunAt pgtype.Timestamptz, schedule models.SavedSearchSchedule,
+	filter ArticleFilter, now time.Time) (after, before time.Time) {
+	before = now
+	after = now.Add(-SchedulePeriod(schedule))
+	if lastRunAt.Valid {
+		after = lastRunAt.Time
+	}
+
+	if filter.WithinDays > 0 {
+		if limit := now.AddDate(0, 0, -filter.WithinDays); after.Before(limit) {
+			after = limit
+		}
+	}
+	return after, before
+}
+
+// SavedSearchInput is the user-editable part of a saved search.
+type SavedSearchInput struct {
+	Name       string
+	Filter     ArticleFilter
+	Schedule   models.SavedSearchSchedule
+	Delivery   models.SavedSearchDelivery
+	WebhookURL string
+}
+
+func (in SavedSearchInput) params() (filter []byte, err error) {
+	if !in.Schedule.Valid() {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid saved search").
+			WithDetails(fmt.Sprintf("invalid schedule: %q", in.Schedule))
+	}
+
+	if !in.Delivery.Valid() {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid saved search").
+			WithDetails(fmt.Sprintf("invalid delivery: %q", in.Delivery))
+	}
+
+	if in.Delivery == models.SavedSearchDeliveryWebhook && in.WebhookURL == "" {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid saved search").
+			WithDetails("webhook delivery requires a webhook url")
+	}
+
+	in.Filter.Version = ArticleFilterVersion
+	filter, err = json.Marshal(in.Filter)
+	if err != nil {
+		return nil, ec.ErrInternalServerError.Clone().
+			WithMessage("failed to marshal article filter").
+			Warp(err)
+	}
+	return filter, nil
+}
+
+// SavedSearchDigest is the payload delivered after a run of a saved search
+// with matches.
+type SavedSearchDigest struct {
+	SavedSearchID int32                      `json:"saved_search_id"`
+	Name          string                     `json:"name"`
+	MatchCount    int                        `json:"match_count"`
+	From          time.Time                  `json:"from"`
+	To            time.Time                  `json:"to"`
+	Articles      []models.SearchArticlesRow `json:"articles"`
+}
+
+// WebhookDispatcher delivers a signed payload to a webhook.
+type WebhookDispatcher interface {
+	Dispatch(ctx context.Context, url, event string, payload any) error
+}
+
+func (s Storage) SavedSearches() SavedSearches {
+	return SavedSearches{s}
+}
+
+// SavedSearches provides methods to manage the saved searches of the users and
+// to evaluate them on schedule. Every method but the evaluation is scoped to
+// an owner.
+type SavedSearches struct {
+	Storage
+}
+
+// Create inserts a saved search for the owner, unless the owner already has
+// MaxSavedSearchesPerOwner of them.
+func (s SavedSearches) Create(ctx context.Context, ownerID string, in SavedSearchInput) (models.UsersSavedSearch, error) {
+	filter, err := in.params()
+	if err != nil {
+		return models.UsersSavedSearch{}, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return models.UsersSavedSearch{}, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := s.Queries.WithTx(tx)
+	if err := q.LockSavedSearchOwner(ctx, ownerID); err != nil {
+		return models.UsersSavedSearch{}, handlePgxErr(err)
+	}
+
+	n, err := q.CountSavedSearchesByOwner(ctx, ownerID)
+	if err != nil {
+		return models.UsersSavedSearch{}, handlePgxErr(err)
+	}
+
+	if n >= MaxSavedSearchesPerOwner {
+		return models.UsersSavedSearch{}, ec.ErrForbidden.Clone().
+			WithMessage("too many saved searches").
+			WithDetails(fmt.Sprintf("an owner has at most %d saved searches", MaxSavedSearchesPerOwner))
+	}
+
+	search, err := q.InsertSavedSearch(ctx, models.InsertSavedSearchParams{
+		OwnerID:    ownerID,
+		Name:       in.Name,
+		Filter:     filter,
+		Schedule:   in.Schedule,
+		Delivery:   in.Delivery,
+		WebhookUrl: in.WebhookURL,
+	})
+	if err != nil {
+		return models.UsersSavedSearch{}, handlePgxErr(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return models.UsersSavedSearch{}, handlePgxErr(err)
+	}
+	return search, nil
+}
+
+// Get returns the saved search of the owner.
+func (s SavedSearches) Get(ctx context.Context, ownerID string, id int32) (models.UsersSavedSearch, error) {
+	search, err := s.querier(ctx, "SavedSearches", "Get").GetSavedSearch(ctx, models.GetSavedSearchParams{
+		ID:      id,
+		OwnerID: ownerID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.UsersSavedSearch{}, ec.ErrNotFound.Clone().
+			WithDetails(fmt.Sprintf("saved search %d not found", id))
+	}
+
+	if err != nil {
+		return models.UsersSavedSearch{}, handlePgxErr(err)
+	}
+	return search, nil
+}
+
+// List returns the saved searches of the owner.
+func (s SavedSearches) List(ctx context.Context, ownerID string) ([]models.UsersSavedSearch, error) {
+	searches, err := s.querier(ctx, "SavedSearches", "List").ListSavedSearchesByOwner(ctx, ownerID)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return searches, nil
+}
+
+// Update replaces the user-editable part of the saved search of the owner.
+func (s SavedSearches) Update(ctx context.Context, ownerID string, id int32, in SavedSearchInput) (models.UsersSavedSearch, error) {
+	filter, err := in.params()
+	if err != nil {
+		return models.UsersSavedSearch{}, err
+	}
+
+	search, err := s.Queries.UpdateSavedSearch(ctx, models.UpdateSavedSearchParams{
+		Name:       in.Name,
+		Filter:     filter,
+		Schedule:   in.Schedule,
+		Delivery:   in.Delivery,
+		WebhookUrl: in.WebhookURL,
+		ID:         id,
+		OwnerID:    ownerID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.UsersSavedSearch{}, ec.ErrNotFound.Clone().
+			WithDetails(fmt.Sprintf("saved search %d not found", id))
+	}
+
+	if err != nil {
+		return models.UsersSavedSearch{}, handlePgxErr(err)
+	}
+	return search, nil
+}
+
+// Delete removes the saved search of the owner along with its hits.
+func (s SavedSearches) Delete(ctx context.Context, ownerID string, id int32) error {
+	n, err := s.Queries.DeleteSavedSearch(ctx, models.DeleteSavedSearchParams{
+		ID:      id,
+		OwnerID: ownerID,
+	})
+	if err != nil {
+		return handlePgxErr(err)
+	}
+
+	if n == 0 {
+		return ec.ErrNotFound.Clone().
+			WithDetails(fmt.Sprintf("saved search %d not found", id))
+	}
+	return nil
+}
+
+// Hits pages through the articles matched by the saved search of the owner,
+// the latest run first.
+func (s SavedSearches) Hits(ctx context.Context, ownerID string, id, limit, offset int32) ([]models.ListSavedSearchHitsRow, error) {
+	if _, err := s.Get(ctx, ownerID, id); err != nil {
+		return nil, err
+	}
+
+	hits, err := s.querier(ctx, "SavedSearches", "Hits").ListSavedSearchHits(ctx, models.ListSavedSearchHitsParams{
+		SavedSearchID: id,
+		Limit:         limit,
+		Offset:        offset,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return hits, nil
+}
+
+// Evaluate runs up to batchSize saved searches due at now, and delivers the
+// digests of the runs with matches to the webhooks of the searches asking for
+// it. A nil webhook skips the deliveries. It returns the number of runs.
+func (s SavedSearches) Evaluate(ctx context.Context, now time.Time, batchSize int, webhook WebhookDispatcher) (int, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultSavedSearchBatchSize
+	}
+
+	tsz, err := utils.TimeTo.PGTimestamptz(now)
+	if err != nil {
+		return 0, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", now.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	due, err := s.Queries.ListDueSavedSearches(ctx, models.ListDueSavedSearchesParams{
+		Now:   tsz,
+		Limit: int32(batchSize),
+	})
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	runs := 0
+	for _, search := range due {
+		digest, ok, err := s.run(ctx, search, now)
+		if err != nil {
+			global.Logger.Warn().
+				Err(err).
+				Int32("saved_search_id", search.ID).
+				Msg("failed to run saved search")
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+		runs++
+
+		if webhook == nil || search.Delivery != models.SavedSearchDeliveryWebhook || digest.MatchCount == 0 {
+			continue
+		}
+
+		if err := webhook.Dispatch(ctx, search.WebhookUrl, SavedSearchDigestEvent, digest); err != nil {
+			global.Logger.Warn().
+				Err(err).
+				Int32("saved_search_id", search.ID).
+				Msg("failed to deliver saved search digest")
+		}
+	}
+	return runs, nil
+}
+
+// run evaluates the saved search in its window, records the matches and moves
+// its last run forward in a transaction. ok is false if another run of the
+// search has committed first.
+func (s SavedSearches) run(ctx context.Context, search models.UsersSavedSearch,
+	now time.Time) (digest SavedSearchDigest, ok bool, err error) {
+	filter, err := ParseArticleFilter(search.Filter)
+	if err != nil {
+		return SavedSearchDigest{}, false, err
+	}
+
+	after, before := SearchWindow(search.LastRunAt, search.Schedule, filter, now)
+	afterTsz, err := utils.TimeTo.PGTimestamptz(after)
+	if err != nil {
+		return SavedSearchDigest{}, false, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			Warp(err)
+	}
+
+	beforeTsz, err := utils.TimeTo.PGTimestamptz(before)
+	if err != nil {
+		return SavedSearchDigest{}, false, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			Warp(err)
+	}
+
+	keywords := filter.Keywords
+	if keywords == nil {
+		keywords = []string{}
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return SavedSearchDigest{}, false, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := s.Queries.WithTx(tx)
+	rows, err := q.SearchArticles(ctx, models.SearchArticlesParams{
+		PublishedAfter:  afterTsz,
+		PublishedBefore: beforeTsz,
+		Party:           string(filter.Party),
+		Source:          filter.Source,
+		Keywords:        keywords,
+		Limit:           MaxSavedSearchHitsPerRun,
+	})
+	if err != nil {
+		return SavedSearchDigest{}, false, handlePgxErr(err)
+	}
+
+	if len(rows) > 0 {
+		ids := make([]int32, len(rows))
+		for i, row := range rows {
+			ids[i] = row.ID
+		}
+
+		if _, err := q.InsertSavedSearchHits(ctx, models.InsertSavedSearchHitsParams{
+			SavedSearchID: search.ID,
+			ArticleIds:    ids,
+			RunAt:         beforeTsz,
+		}); err != nil {
+			return SavedSearchDigest{}, false, handlePgxErr(err)
+		}
+	}
+
+	n, err := q.UpdateSavedSearchLastRun(ctx, models.UpdateSavedSearchLastRunParams{
+		RunAt:     beforeTsz,
+		ID:        search.ID,
+		PrevRunAt: search.LastRunAt,
+	})
+	if err != nil {
+		return SavedSearchDigest{}, false, handlePgxErr(err)
+	}
+
+	if n == 0 {
+		return SavedSearchDigest{}, false, nil
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return SavedSearchDigest{}, false, handlePgxErr(err)
+	}
+
+	return SavedSearchDigest{
+		SavedSearchID: search.ID,
+		Name:          search.Name,
+		MatchCount:    len(rows),
+		From:          after,
+		To:            before,
+		Articles:      rows[:min(len(rows), SavedSearchDigestSize)],
+	}, true, nil
+}
+
+// RunEvaluator evaluates the due saved searches every interval until ctx is
+// done.
+func (s SavedSearches) RunEvaluator(ctx context.Context, interval time.Duration, batchSize int, webhook WebhookDispatcher) {
+	ticker := s.Clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			runs, err := s.Evaluate(ctx, now, batchSize, webhook)
+			if err != nil {
+				global.Logger.Error().
+					Err(err).
+					Msg("failed to evaluate saved searches")
+				continue
+			}
+
+			if runs > 0 {
+				global.Logger.Info().
+					Int("runs", runs).
+					Msg("saved searches evaluated")
+			}
+		}
+	}
+}
@@ -0,0 +1,266 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeWebhook struct {
+	mu      sync.Mutex
+	digests map[string][]storage.SavedSearchDigest
+}
+
+func (w *fakeWebhook) Dispatch(_ context.Context, url, event string, payload any) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.digests == nil {
+		w.digests = map[string][]storage.SavedSearchDigest{}
+	}
+	w.digests[url] = append(w.digests[url], payload.(storage.SavedSearchDigest))
+	return nil
+}
+
+func TestSavedSearchesOwnerCap(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	owner := uuid.NewString()
+	in := storage.SavedSearchInput{
+		Schedule: models.SavedSearchScheduleDaily,
+		Delivery: models.SavedSearchDeliveryNone,
+	}
+
+	for i := range storage.MaxSavedSearchesPerOwner {
+		in.Name = fmt.Sprintf("search %d", i)
+		_, err := s.SavedSearches().Create(ctx, owner, in)
+		require.NoError(t, err)
+	}
+
+	in.Name = "one too many"
+	_, err := s.SavedSearches().Create(ctx, owner, in)
+	require.Error(t, err)
+	var e *ec.Error
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, ec.ErrForbidden.HttpStatusCode, e.HttpStatusCode)
+
+	// the cap is per owner
+	_, err = s.SavedSearches().Create(ctx, uuid.NewString(), in)
+	require.NoError(t, err)
+
+	// other owners can neither see nor delete the searches
+	searches, err := s.SavedSearches().List(ctx, owner)
+	require.NoError(t, err)
+	require.Len(t, searches, storage.MaxSavedSearchesPerOwner)
+
+	_, err = s.SavedSearches().Get(ctx, uuid.NewString(), searches[0].ID)
+	require.Error(t, err)
+	require.Error(t, s.SavedSearches().Delete(ctx, uuid.NewString(), searches[0].ID))
+
+	require.NoError(t, s.SavedSearches().Delete(ctx, owner, searches[0].ID))
+	_, err = s.SavedSearches().Create(ctx, owner, in)
+	require.NoError(t, err)
+}
+
+func TestSavedSearchesEvaluate(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	source := "saved-search-" + uuid.NewString()[:8]
+	insert := func(publishedAt time.Time) int32 {
+		title := fmt.Sprintf("saved search %s", uuid.NewString())
+		id, err := storage.Article{Storage: s}.Insert(ctx, "https://example.com/"+title,
+			title, source, uuid.NewString(), "content", nil, publishedAt, time.Time{})
+		require.NoError(t, err)
+		return id
+	}
+
+	owner := uuid.NewString()
+	url := "https://example.com/hooks/" + owner
+	search, err := s.SavedSearches().Create(ctx, owner, storage.SavedSearchInput{
+		Name:       "source",
+		Filter:     storage.ArticleFilter{Source: source},
+		Schedule:   models.SavedSearchScheduleDaily,
+		Delivery:   models.SavedSearchDeliveryWebhook,
+		WebhookURL: url,
+	})
+	require.NoError(t, err)
+
+	first := time.Now().Truncate(time.Second)
+	insert(first.Add(-48 * time.Hour)) // before the first window
+	old := insert(first.Add(-time.Hour))
+
+	webhook := &fakeWebhook{}
+	_, err = s.SavedSearches().Evaluate(ctx, first, 1000, webhook)
+	require.NoError(t, err)
+
+	hits, err := s.SavedSearches().Hits(ctx, owner, search.ID, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	require.Equal(t, old, hits[0].ID)
+	require.Len(t, webhook.digests[url], 1)
+	require.Equal(t, 1, webhook.digests[url][0].MatchCount)
+
+	// not due again before a day has passed
+	_, err = s.SavedSearches().Evaluate(ctx, first.Add(time.Hour), 1000, webhook)
+	require.NoError(t, err)
+	require.Len(t, webhook.digests[url], 1)
+
+	// the second run only picks up the articles published after the first
+	second := first.Add(25 * time.Hour)
+	fresh := insert(first.Add(time.Hour))
+	_, err = s.SavedSearches().Evaluate(ctx, second, 1000, webhook)
+	require.NoError(t, err)
+
+	hits, err = s.SavedSearches().Hits(ctx, owner, search.ID, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, hits, 2)
+	require.Equal(t, fresh, hits[0].ID)
+	require.Equal(t, old, hits[1].ID)
+	require.Len(t, webhook.digests[url], 2)
+	require.Equal(t, 1, webhook.digests[url][1].MatchCount)
+	require.True(t, first.Equal(webhook.digests[url][1].From))
+
+	got, err := s.SavedSearches().Get(ctx, owner, search.ID)
+	require.NoError(t, err)
+	require.True(t, got.LastRunAt.Valid)
+	require.True(t, second.Equal(got.LastRunAt.Time))
+}
+
+func TestSavedSearchesEvaluateEffectiveKeywords(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	first := time.Now().Truncate(time.Second)
+	source := "saved-search-" + uuid.NewString()[:8]
+	title := fmt.Sprintf("saved search %s", uuid.NewString())
+	aID, err := storage.Article{Storage: s}.Insert(ctx, "https://example.com/"+title,
+		title, source, uuid.NewString(), "content", nil, first.Add(-time.Hour), time.Time{})
+	require.NoError(t, err)
+
+	// the model extracted the rejected and the replaced keywords, an editor
+	// rejected the first and replaced the second
+	rejected := "kw-" + uuid.NewString()[:8]
+	replaced := "kw-" + uuid.NewString()[:8]
+	replacement := "kw-" + uuid.NewString()[:8]
+	for _, term := range []string{rejected, replaced} {
+		_, err := pool.Exec(ctx, `
+WITH k AS (INSERT INTO keywords (term, lang) VALUES ($2, 'zh-Hant') RETURNING id)
+INSERT INTO articles_keywords (article_id, keyword_id) SELECT $1, id FROM k`, aID, term)
+		require.NoError(t, err)
+	}
+	_, err = s.Annotations().Create(ctx, aID, models.AnnotationTargetKeyword, rejected,
+		models.AnnotationActionReject, nil, "editor")
+	require.NoError(t, err)
+	_, err = s.Annotations().Create(ctx, aID, models.AnnotationTargetKeyword, replaced,
+		models.AnnotationActionReplace, json.RawMessage(fmt.Sprintf(`{"term":%q}`, replacement)), "editor")
+	require.NoError(t, err)
+
+	owner := uuid.NewString()
+	searches := map[string]int32{}
+	for _, term := range []string{rejected, replaced, replacement} {
+		search, err := s.SavedSearches().Create(ctx, owner, storage.SavedSearchInput{
+			Name:     term,
+			Filter:   storage.ArticleFilter{Source: source, Keywords: []string{term}},
+			Schedule: models.SavedSearchScheduleDaily,
+			Delivery: models.SavedSearchDeliveryNone,
+		})
+		require.NoError(t, err)
+		searches[term] = search.ID
+	}
+
+	_, err = s.SavedSearches().Evaluate(ctx, first, 1000, &fakeWebhook{})
+	require.NoError(t, err)
+
+	// the digest matches the keywords with the annotations applied
+	for term, want := range map[string]int{rejected: 0, replaced: 0, replacement: 1} {
+		hits, err := s.SavedSearches().Hits(ctx, owner, searches[term], 100, 0)
+		require.NoError(t, err)
+		require.Len(t, hits, want, term)
+	}
+}
+
+func TestSavedSearchesDigestEffectiveSummaries(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	first := time.Now().Truncate(time.Second)
+	source := "saved-search-" + uuid.NewString()[:8]
+	insert := func(summary string, publishedAt time.Time) int32 {
+		title := fmt.Sprintf("saved search %s", uuid.NewString())
+		aID, err := storage.Article{Storage: s}.Insert(ctx, "https://example.com/"+title,
+			title, source, uuid.NewString(), "content", nil, publishedAt, time.Time{})
+		require.NoError(t, err)
+		if summary != "" {
+			_, err = pool.Exec(ctx, `INSERT INTO article_summaries (article_id, summary) VALUES ($1, $2)`, aID, summary)
+			require.NoError(t, err)
+		}
+		return aID
+	}
+
+	// the model summarized all but the last article, an editor rejected the
+	// second summary and replaced the third
+	kept := insert("kept", first.Add(-4*time.Hour))
+	rejected := insert("rejected", first.Add(-3*time.Hour))
+	replaced := insert("replaced", first.Add(-2*time.Hour))
+	missing := insert("", first.Add(-time.Hour))
+
+	_, err := s.Annotations().Create(ctx, rejected, models.AnnotationTargetSummary, "summary",
+		models.AnnotationActionReject, nil, "editor")
+	require.NoError(t, err)
+	_, err = s.Annotations().Create(ctx, replaced, models.AnnotationTargetSummary, "summary",
+		models.AnnotationActionReplace, json.RawMessage(`{"summary":"corrected"}`), "editor")
+	require.NoError(t, err)
+
+	// a summary replacement must not be empty
+	_, err = s.Annotations().Create(ctx, replaced, models.AnnotationTargetSummary, "summary",
+		models.AnnotationActionReplace, json.RawMessage(`{"summary":" "}`), "editor")
+	require.Error(t, err)
+
+	owner := uuid.NewString()
+	url := "https://example.com/hooks/" + owner
+	_, err = s.SavedSearches().Create(ctx, owner, storage.SavedSearchInput{
+		Name:       "summaries",
+		Filter:     storage.ArticleFilter{Source: source},
+		Schedule:   models.SavedSearchScheduleDaily,
+		Delivery:   models.SavedSearchDeliveryWebhook,
+		WebhookURL: url,
+	})
+	require.NoError(t, err)
+
+	webhook := &fakeWebhook{}
+	_, err = s.SavedSearches().Evaluate(ctx, first, 1000, webhook)
+	require.NoError(t, err)
+	require.Len(t, webhook.digests[url], 1)
+
+	// the digest carries the summaries with the annotations applied
+	summaries := map[int32]string{}
+	for _, article := range webhook.digests[url][0].Articles {
+		summaries[article.ID] = article.Summary
+	}
+	require.Equal(t, map[int32]string{
+		kept:     "kept",
+		rejected: "",
+		replaced: "corrected",
+		missing:  "",
+	}, summaries)
+}
@@ -0,0 +1,168 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArticleFilter(t *testing.T) {
+	tcs := []struct {
+		name    string
+		raw     string
+		want    storage.ArticleFilter
+		wantErr bool
+	}{
+		{
+			name: "current version",
+			raw:  `{"version":1,"party":"TPP","keywords":["能源"],"within_days":7}`,
+			want: storage.ArticleFilter{
+				Version:    storage.ArticleFilterVersion,
+				Party:      models.PartyTPP,
+				Keywords:   []string{"能源"},
+				WithinDays: 7,
+			},
+		},
+		{
+			name: "version 0",
+			raw:  `{"party":"tpp","keyword":"能源","days":7,"source":"yahoo"}`,
+			want: storage.ArticleFilter{
+				Version:    storage.ArticleFilterVersion,
+				Party:      models.PartyTPP,
+				Source:     "yahoo",
+				Keywords:   []string{"能源"},
+				WithinDays: 7,
+			},
+		},
+		{
+			name: "version 0 without party",
+			raw:  `{"party":"none","keyword":""}`,
+			want: storage.ArticleFilter{
+				Version: storage.ArticleFilterVersion,
+				Party:   models.PartyNone,
+			},
+		},
+		{
+			name:    "future version",
+			raw:     `{"version":2}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			raw:     `{"version":`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong type",
+			raw:     `{"version":1,"keywords":"能源"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := storage.ParseArticleFilter([]byte(tc.raw))
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, filter)
+		})
+	}
+}
+
+func TestArticleFilterValidation(t *testing.T) {
+	v := validator.New()
+	tcs := []struct {
+		name    string
+		filter  storage.ArticleFilter
+		wantErr bool
+	}{
+		{
+			name:   "empty",
+			filter: storage.ArticleFilter{},
+		},
+		{
+			name: "full",
+			filter: storage.ArticleFilter{
+				Party:      models.PartyKMT,
+				Source:     "yahoo",
+				Keywords:   []string{"能源", "核電"},
+				WithinDays: 365,
+			},
+		},
+		{
+			name:    "unknown party",
+			filter:  storage.ArticleFilter{Party: "tpp"},
+			wantErr: true,
+		},
+		{
+			name:    "empty keyword",
+			filter:  storage.ArticleFilter{Keywords: []string{""}},
+			wantErr: true,
+		},
+		{
+			name: "too many keywords",
+			filter: storage.ArticleFilter{
+				Keywords: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "window too long",
+			filter:  storage.ArticleFilter{WithinDays: 366},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := v.Struct(tc.filter)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSearchWindow(t *testing.T) {
+	first := time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+	filter := storage.ArticleFilter{}
+
+	// the first run looks one period back
+	after, before := storage.SearchWindow(pgtype.Timestamptz{},
+		models.SavedSearchScheduleDaily, filter, first)
+	require.Equal(t, first.Add(-24*time.Hour), after)
+	require.Equal(t, first, before)
+
+	// the second run picks up where the first one stopped
+	lastRun := pgtype.Timestamptz{Time: before, Valid: true}
+	after, before = storage.SearchWindow(lastRun,
+		models.SavedSearchScheduleDaily, filter, second)
+	require.Equal(t, first, after)
+	require.Equal(t, second, before)
+
+	after, _ = storage.SearchWindow(pgtype.Timestamptz{},
+		models.SavedSearchScheduleWeekly, filter, first)
+	require.Equal(t, first.AddDate(0, 0, -7), after)
+
+	// the window never extends beyond WithinDays, even after a long pause
+	filter.WithinDays = 3
+	stale := pgtype.Timestamptz{Time: first.AddDate(0, 0, -30), Valid: true}
+	after, _ = storage.SearchWindow(stale,
+		models.SavedSearchScheduleWeekly, filter, first)
+	require.Equal(t, first.AddDate(0, 0, -3), after)
+
+	after, _ = storage.SearchWindow(lastRun,
+		models.SavedSearchScheduleDaily, filter, second)
+	require.Equal(t, first, after)
+}
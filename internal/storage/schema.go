@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+)
+
+func (s Storage) Schema() Schema {
+	return Schema{s}
+}
+
+// Schema provides methods to introspect the schema of the database. It reads
+// from the write pool, a replica may lag behind a migration.
+type Schema struct {
+	Storage
+}
+
+// Introspect returns the migration version of the database, compared with the
+// version the binary expects, and the columns of the tables in models.Tables.
+func (s Schema) Introspect(ctx context.Context) (global.SchemaIntrospection, error) {
+	c, err := global.SchemaVersion(ctx, s.db, global.DefaultMigrationsTable)
+	if err != nil {
+		return global.SchemaIntrospection{}, ec.ErrDBError.Clone().
+			WithMessage("failed to get migration version").
+			Warp(err)
+	}
+	c.Expected = global.ExpectedSchemaVersion
+
+	tables, err := global.TableColumns(ctx, s.db, models.Tables)
+	if err != nil {
+		return global.SchemaIntrospection{}, ec.ErrDBError.Clone().
+			WithMessage("failed to list table columns").
+			Warp(err)
+	}
+	return global.SchemaIntrospection{SchemaCompatibility: c, Tables: tables}, nil
+}
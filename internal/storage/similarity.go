@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultSimilarK       = 10
+	MaxSimilarK           = 50
+	DefaultExplainTopM    = 3
+	MaxExplainTopM        = 10
+	DefaultSnippetRunes   = 120
+	MaxSnippetRunes       = 500
+	minSnippetRunes       = 10
+	similarWithoutExplain = 1 // the best chunk is always fetched to rank the articles
+)
+
+func (s Storage) Similarity() Similarity {
+	return Similarity{s}
+}
+
+// Similarity contains methods to search the articles similar to the articles
+// of a task in the embedding space.
+type Similarity struct {
+	Storage
+}
+
+// ExplainOptions controls the explanation payload of a similarity search.
+// Zero values of TopM and SnippetRunes fall back to the defaults, and values
+// above the caps are clamped.
+type ExplainOptions struct {
+	Enabled      bool
+	TopM         int
+	SnippetRunes int
+}
+
+// Normalize applies the defaults and caps to the options.
+func (o ExplainOptions) Normalize() ExplainOptions {
+	if o.TopM <= 0 {
+		o.TopM = DefaultExplainTopM
+	}
+	o.TopM = min(o.TopM, MaxExplainTopM)
+
+	if o.SnippetRunes <= 0 {
+		o.SnippetRunes = DefaultSnippetRunes
+	}
+	o.SnippetRunes = min(max(o.SnippetRunes, minSnippetRunes), MaxSnippetRunes)
+	return o
+}
+
+// ChunkContribution is a matched chunk explaining why an article is similar.
+// Start and End are the rune range of the snippet in the article content.
+type ChunkContribution struct {
+	ChunkID    int32   `json:"chunk_id"`
+	Similarity float64 `json:"similarity"`
+	Snippet    string  `json:"snippet"`
+	Start      int32   `json:"start"`
+	End        int32   `json:"end"`
+}
+
+// SimilarArticle is an article similar to the articles of a task. Explanation
+// is only populated if explanations are enabled.
+type SimilarArticle struct {
+	ArticleID   int32               `json:"article_id"`
+	Title       string              `json:"title"`
+	URL         string              `json:"url"`
+	Source      string              `json:"source"`
+	PublishedAt time.Time           `json:"published_at"`
+	Similarity  float64             `json:"similarity"`
+	Explanation []ChunkContribution `json:"explanation,omitempty"`
+}
+
+// SimilarArticles returns the k articles nearest to the average embedding of
+// the articles of the task under the given model. The contributing chunks are
+// fetched in the same query, so enabling explanations does not issue any
+// additional query.
+func (s Similarity) SimilarArticles(ctx context.Context, taskID uuid.UUID, modelID int32,
+	k int, explain ExplainOptions) ([]SimilarArticle, error) {
+	if k <= 0 {
+		k = DefaultSimilarK
+	}
+	k = min(k, MaxSimilarK)
+
+	explain = explain.Normalize()
+	topM := similarWithoutExplain
+	if explain.Enabled {
+		topM = explain.TopM
+	}
+
+	q, err := s.withModelDim(ctx, "Similarity", "SimilarArticles", modelID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.GetSimilarArticlesByTaskID(ctx, models.GetSimilarArticlesByTaskIDParams{
+		TaskID:  taskID,
+		ModelID: modelID,
+		K:       int32(k),
+		TopM:    int32(topM),
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return GroupSimilarArticles(rows, explain), nil
+}
+
+// GroupSimilarArticles groups the rows of a similarity search, one row per
+// matched chunk, into articles while keeping the order of the rows. Snippets
+// are trimmed to the rune budget on sentence boundaries.
+func GroupSimilarArticles(rows []models.GetSimilarArticlesByTaskIDRow, explain ExplainOptions) []SimilarArticle {
+	explain = explain.Normalize()
+
+	articles := []SimilarArticle{}
+	index := map[int32]int{}
+	for _, row := range rows {
+		i, ok := index[row.ArticleID]
+		if !ok {
+			i = len(articles)
+			index[row.ArticleID] = i
+			articles = append(articles, SimilarArticle{
+				ArticleID:   row.ArticleID,
+				Title:       row.Title,
+				URL:         row.Url,
+				Source:      row.Source,
+				PublishedAt: row.PublishedAt.Time,
+				Similarity:  row.Similarity,
+			})
+		}
+
+		if !explain.Enabled || len(articles[i].Explanation) >= explain.TopM {
+			continue
+		}
+
+		snippet, n := llm.TrimToSentence(row.Snippet, explain.SnippetRunes)
+		articles[i].Explanation = append(articles[i].Explanation, ChunkContribution{
+			ChunkID:    row.ChunkID,
+			Similarity: row.ChunkSimilarity,
+			Snippet:    snippet,
+			Start:      row.UniqueStart,
+			End:        row.UniqueStart + int32(n),
+		})
+	}
+	return articles
+}
@@ -0,0 +1,141 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// similarArticleFixture inserts a shared article of md5, a new one if empty,
+// with a chunk embedded under modelID for each tilt, the chunk i along
+// axisVector(0, tilts[i]), and returns the article ID and the chunk IDs in
+// the order of tilts.
+func similarArticleFixture(t *testing.T, s storage.Storage, modelID int32, md5 string,
+	tilts ...float32) (int32, []int32) {
+	t.Helper()
+	ctx := context.Background()
+
+	if md5 == "" {
+		md5 = uuid.NewString()
+	}
+	title := "similar " + uuid.NewString()
+	aID, err := storage.Article{Storage: s}.Insert(ctx, "https://example.com/"+title,
+		title, "test", md5, "content of the article", nil, time.Now(), time.Time{})
+	require.NoError(t, err)
+
+	cIDs := make([]int32, len(tilts))
+	for i, tilt := range tilts {
+		cIDs[i], err = s.Queries.InsertChunk(ctx, models.InsertChunkParams{
+			ArticleID:   aID,
+			OffsetRight: 7,
+			End:         7,
+		})
+		require.NoError(t, err)
+		_, err = s.Queries.InsertEmbedding(ctx, models.InsertEmbeddingParams{
+			ArticleID: aID,
+			ChunkID:   cIDs[i],
+			ModelID:   modelID,
+			Vector:    utils.ToPgVector(axisVector(0, tilt)),
+		})
+		require.NoError(t, err)
+	}
+	return aID, cIDs
+}
+
+// tiltSimilarity is the cosine similarity of axisVector(0, tilt) to the axis.
+func tiltSimilarity(tilt float64) float64 {
+	return 1 / math.Sqrt(1+tilt*tilt)
+}
+
+func TestSimilarArticlesTopM(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	modelID, err := s.Models().Insert(ctx, "similar-"+uuid.NewString(), 1024)
+	require.NoError(t, err)
+	taskID, err := s.Task().InsertFromText(ctx, "similar "+uuid.NewString(), nil)
+	require.NoError(t, err)
+	uaID, err := s.UserArticles().Insert(ctx, taskID, "similar "+uuid.NewString(), "test",
+		"content of the user article "+uuid.NewString(), nil, time.Now(), time.Time{}, nil)
+	require.NoError(t, err)
+	ucID, err := s.UserChunks().Insert(ctx, uaID, 0, 0, 7, 10)
+	require.NoError(t, err)
+	_, err = s.UserEmbeddings().Insert(ctx, uaID, ucID, modelID, axisVector(0, 0))
+	require.NoError(t, err)
+
+	// the chunks are inserted out of the order of their similarity, the last
+	// two of the second article are tied
+	first, firstChunks := similarArticleFixture(t, s, modelID, "", 1.0, 0.1, 0.5)
+	second, secondChunks := similarArticleFixture(t, s, modelID, "", 0.8, 0.3, 0.3)
+	_, _ = similarArticleFixture(t, s, modelID, "", 2.0)
+
+	// the shared copy of the article of the task is never similar to it
+	var md5 string
+	require.NoError(t, pool.QueryRow(ctx,
+		"SELECT md5 FROM users.articles WHERE id = $1", uaID).Scan(&md5))
+	_, _ = similarArticleFixture(t, s, modelID, md5, 0)
+
+	type chunk struct {
+		article, chunk int32
+		similarity     float64
+	}
+	want := []chunk{
+		{first, firstChunks[1], tiltSimilarity(0.1)},
+		{first, firstChunks[2], tiltSimilarity(0.5)},
+		{second, secondChunks[1], tiltSimilarity(0.3)},
+		{second, secondChunks[2], tiltSimilarity(0.3)},
+	}
+
+	// the top 2 chunks of the 2 nearest articles, nearest first, the ties in
+	// the order of their IDs
+	rows, err := s.Queries.GetSimilarArticlesByTaskID(ctx, models.GetSimilarArticlesByTaskIDParams{
+		TaskID:  taskID,
+		ModelID: modelID,
+		K:       2,
+		TopM:    2,
+	})
+	require.NoError(t, err)
+	require.Len(t, rows, len(want))
+	for i, row := range rows {
+		require.Equal(t, want[i].article, row.ArticleID, "row %d", i)
+		require.Equal(t, want[i].chunk, row.ChunkID, "row %d", i)
+		require.InDelta(t, want[i].similarity, row.ChunkSimilarity, 1e-4, "row %d", i)
+	}
+	require.InDelta(t, tiltSimilarity(0.1), rows[0].Similarity, 1e-4)
+	require.InDelta(t, tiltSimilarity(0.3), rows[2].Similarity, 1e-4)
+
+	// the search typed with the dimension of the model returns the same
+	similar, err := s.Similarity().SimilarArticles(ctx, taskID, modelID, 2,
+		storage.ExplainOptions{Enabled: true, TopM: 2})
+	require.NoError(t, err)
+	require.Len(t, similar, 2)
+	for i, article := range similar {
+		require.Equal(t, want[2*i].article, article.ArticleID)
+		require.Len(t, article.Explanation, 2)
+		for j, c := range article.Explanation {
+			require.Equal(t, want[2*i+j].chunk, c.ChunkID)
+			require.InDelta(t, want[2*i+j].similarity, c.Similarity, 1e-4)
+		}
+	}
+
+	// without explanations only the best chunk of each article is fetched
+	similar, err = s.Similarity().SimilarArticles(ctx, taskID, modelID, 3, storage.ExplainOptions{})
+	require.NoError(t, err)
+	require.Len(t, similar, 3)
+	require.Equal(t, []int32{first, second}, []int32{similar[0].ArticleID, similar[1].ArticleID})
+	require.InDelta(t, tiltSimilarity(2.0), similar[2].Similarity, 1e-4)
+	for _, article := range similar {
+		require.Empty(t, article.Explanation)
+	}
+}
@@ -0,0 +1,140 @@
+package storage_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// seededRows returns the rows the similarity query yields for chunks lying on
+// the unit circle at the given angles (in degrees) from the query vector (1, 0),
+// ordered the same way as the query: by article similarity, then by chunk
+// similarity.
+func seededRows(t *testing.T, chunks map[int32][]float64, content string) []models.GetSimilarArticlesByTaskIDRow {
+	t.Helper()
+	rows := []models.GetSimilarArticlesByTaskIDRow{}
+	cID := int32(0)
+	for aID, angles := range chunks {
+		best := -1.0
+		for _, deg := range angles {
+			best = max(best, math.Cos(deg*math.Pi/180))
+		}
+		for _, deg := range angles {
+			cID++
+			rows = append(rows, models.GetSimilarArticlesByTaskIDRow{
+				ArticleID:       aID,
+				Title:           "article",
+				Similarity:      best,
+				ChunkID:         cID,
+				ChunkSimilarity: math.Cos(deg * math.Pi / 180),
+				UniqueStart:     cID * 100,
+				UniqueEnd:       cID*100 + int32(utf8.RuneCountInString(content)),
+				Snippet:         content,
+			})
+		}
+	}
+
+	slices.SortFunc(rows, func(a, b models.GetSimilarArticlesByTaskIDRow) int {
+		switch {
+		case a.Similarity != b.Similarity:
+			return -cmpFloat(a.Similarity, b.Similarity)
+		case a.ArticleID != b.ArticleID:
+			return int(a.ArticleID - b.ArticleID)
+		default:
+			return -cmpFloat(a.ChunkSimilarity, b.ChunkSimilarity)
+		}
+	})
+	return rows
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func TestGroupSimilarArticles(t *testing.T) {
+	content := "新北三峽發生重大車禍，交通部也宣布，將下修高齡換照年齡。"
+	rows := seededRows(t, map[int32][]float64{
+		1: {60, 10, 45},
+		2: {5, 80},
+		3: {90},
+	}, content)
+
+	t.Run("Explain", func(t *testing.T) {
+		articles := storage.GroupSimilarArticles(rows, storage.ExplainOptions{
+			Enabled: true,
+			TopM:    2,
+		})
+		require.Len(t, articles, 3)
+		require.Equal(t, []int32{2, 1, 3}, []int32{
+			articles[0].ArticleID, articles[1].ArticleID, articles[2].ArticleID,
+		})
+
+		for _, a := range articles {
+			require.NotEmpty(t, a.Explanation)
+			require.LessOrEqual(t, len(a.Explanation), 2)
+			require.InDelta(t, a.Similarity, a.Explanation[0].Similarity, 1e-9)
+			require.True(t, slices.IsSortedFunc(a.Explanation, func(x, y storage.ChunkContribution) int {
+				return -cmpFloat(x.Similarity, y.Similarity)
+			}))
+		}
+		require.InDelta(t, math.Cos(10*math.Pi/180), articles[1].Explanation[0].Similarity, 1e-9)
+		require.InDelta(t, math.Cos(45*math.Pi/180), articles[1].Explanation[1].Similarity, 1e-9)
+	})
+
+	t.Run("No_Explain", func(t *testing.T) {
+		articles := storage.GroupSimilarArticles(rows, storage.ExplainOptions{})
+		require.Len(t, articles, 3)
+		for _, a := range articles {
+			require.Nil(t, a.Explanation)
+		}
+	})
+
+	t.Run("Snippet_Budget", func(t *testing.T) {
+		articles := storage.GroupSimilarArticles(rows, storage.ExplainOptions{
+			Enabled:      true,
+			TopM:         1,
+			SnippetRunes: 20,
+		})
+		c := articles[0].Explanation[0]
+		// no sentence end within the budget, cut after the last clause separator
+		require.Equal(t, "新北三峽發生重大車禍，交通部也宣布，", c.Snippet)
+		require.True(t, utf8.ValidString(c.Snippet))
+		require.Equal(t, int32(utf8.RuneCountInString(c.Snippet)), c.End-c.Start)
+	})
+}
+
+func TestExplainOptionsNormalize(t *testing.T) {
+	tcs := []struct {
+		Name     string
+		Options  storage.ExplainOptions
+		Expected storage.ExplainOptions
+	}{
+		{
+			Name:     "Defaults",
+			Options:  storage.ExplainOptions{Enabled: true},
+			Expected: storage.ExplainOptions{Enabled: true, TopM: storage.DefaultExplainTopM, SnippetRunes: storage.DefaultSnippetRunes},
+		},
+		{
+			Name:     "Caps",
+			Options:  storage.ExplainOptions{Enabled: true, TopM: 1000, SnippetRunes: 100000},
+			Expected: storage.ExplainOptions{Enabled: true, TopM: storage.MaxExplainTopM, SnippetRunes: storage.MaxSnippetRunes},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Expected, tc.Options.Normalize())
+		})
+	}
+}
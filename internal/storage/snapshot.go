@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DefaultSnapshotTimeout bounds a snapshot, see Storage.WithSnapshot.
+const DefaultSnapshotTimeout = 10 * time.Second
+
+// WithSnapshot calls fn with a copy of the storage bound to a read-only
+// REPEATABLE READ transaction, so that all the queries fn runs see the same
+// snapshot of the database, e.g. the widgets of a dashboard whose numbers have
+// to add up. The snapshot is bounded by DefaultSnapshotTimeout, also on the
+// server side should the caller hang, and takes no lock blocking the writers:
+// a statement writing or locking rows is refused by Postgres, and panics under
+// test so that the accessor method running it is caught early.
+func (s Storage) WithSnapshot(ctx context.Context, fn func(s Storage) error) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultSnapshotTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf("SET LOCAL idle_in_transaction_session_timeout = %d",
+		DefaultSnapshotTimeout.Milliseconds())); err != nil {
+		return handlePgxErr(err)
+	}
+
+	db := snapshotDB{tx: tx}
+	snap := s
+	snap.Queries = models.New(db)
+	snap.db = db
+	// the reads run on the snapshot too, not on the read pool
+	snap.reader = nil
+	if err := fn(snap); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
+
+var (
+	writeStmtRegexp = regexp.MustCompile(`(?i)^(INSERT|UPDATE|DELETE|MERGE|TRUNCATE|COPY|CALL)\b`)
+	writeCTERegexp  = regexp.MustCompile(`(?is)^WITH\b.*\b(INSERT|UPDATE|DELETE|MERGE)\b`)
+	lockRowsRegexp  = regexp.MustCompile(`(?i)\bFOR\s+(UPDATE|NO\s+KEY\s+UPDATE|SHARE|KEY\s+SHARE)\b`)
+)
+
+// writes reports whether the statement sql writes or locks rows. The comment
+// lines sqlc starts its queries with are skipped.
+func writes(sql string) bool {
+	lines := strings.Split(strings.TrimSpace(sql), "\n")
+	for len(lines) > 0 && strings.HasPrefix(strings.TrimSpace(lines[0]), "--") {
+		lines = lines[1:]
+	}
+
+	stmt := strings.TrimSpace(strings.Join(lines, "\n"))
+	return writeStmtRegexp.MatchString(stmt) ||
+		writeCTERegexp.MatchString(stmt) ||
+		lockRowsRegexp.MatchString(stmt)
+}
+
+// snapshotDB runs the queries of a snapshot on its transaction, guarding
+// against the ones writing or locking rows.
+type snapshotDB struct {
+	tx pgx.Tx
+}
+
+func (db snapshotDB) guard(sql string) {
+	if !writes(sql) {
+		return
+	}
+
+	// Postgres refuses the statement in a read-only transaction anyway, the
+	// tests are made to fail loudly rather than on an error which may be
+	// swallowed.
+	if testing.Testing() {
+		panic(fmt.Sprintf("storage: write on a snapshot: %s", sql))
+	}
+	global.Logger.Error().
+		Str("sql", sql).
+		Msg("Write on a snapshot")
+}
+
+func (db snapshotDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	db.guard(sql)
+	return db.tx.Exec(ctx, sql, args...)
+}
+
+func (db snapshotDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	db.guard(sql)
+	return db.tx.Query(ctx, sql, args...)
+}
+
+func (db snapshotDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	db.guard(sql)
+	return db.tx.QueryRow(ctx, sql, args...)
+}
+
+func (db snapshotDB) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	for _, q := range b.QueuedQueries {
+		db.guard(q.SQL)
+	}
+	return db.tx.SendBatch(ctx, b)
+}
+
+// Begin refuses to open a transaction within the snapshot, only the methods
+// writing do so.
+func (db snapshotDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return db.BeginTx(ctx, pgx.TxOptions{})
+}
+
+func (db snapshotDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	if testing.Testing() {
+		panic("storage: transaction on a snapshot")
+	}
+	return nil, ec.ErrDBError.Clone().
+		WithMessage("no transaction can be opened on a snapshot")
+}
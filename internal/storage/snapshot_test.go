@@ -0,0 +1,115 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// snapshotFakeDB begins fakeTx transactions, the other queries go to fakeDB.
+type snapshotFakeDB struct {
+	fakeDB
+	opts pgx.TxOptions
+	tx   *fakeTx
+}
+
+func (db *snapshotFakeDB) BeginTx(_ context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	db.opts = opts
+	db.tx = &fakeTx{}
+	return db.tx, nil
+}
+
+// fakeTx records the statements it runs, all the queries fail.
+type fakeTx struct {
+	pgx.Tx
+	stmts     []string
+	committed bool
+}
+
+func (tx *fakeTx) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	tx.stmts = append(tx.stmts, sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func (tx *fakeTx) Query(_ context.Context, sql string, _ ...any) (pgx.Rows, error) {
+	tx.stmts = append(tx.stmts, sql)
+	return nil, errFakeDB
+}
+
+func (tx *fakeTx) QueryRow(_ context.Context, sql string, _ ...any) pgx.Row {
+	tx.stmts = append(tx.stmts, sql)
+	return fakeRow{}
+}
+
+func (tx *fakeTx) Commit(context.Context) error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Rollback(context.Context) error { return nil }
+
+func TestWithSnapshot(t *testing.T) {
+	ctx := context.Background()
+	reader := &fakeDB{}
+	db := &snapshotFakeDB{}
+	s := storage.New(db, nil, storage.WithReadDB(reader))
+
+	err := s.WithSnapshot(ctx, func(snap storage.Storage) error {
+		_, _ = snap.Models().GetByID(ctx, 1)
+		_, _ = snap.Counters().List(ctx)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}, db.opts)
+	require.True(t, db.tx.committed)
+	// the deadline is set on the server too, then the reads run on the snapshot
+	require.Len(t, db.tx.stmts, 3)
+	require.Contains(t, db.tx.stmts[0], "idle_in_transaction_session_timeout")
+	require.Zero(t, reader.calls)
+	require.Zero(t, db.calls)
+}
+
+func TestWithSnapshotGuard(t *testing.T) {
+	ctx := context.Background()
+	tcs := []struct {
+		Name string
+		Call func(s storage.Storage)
+	}{
+		{
+			Name: "insert",
+			Call: func(s storage.Storage) { _, _ = s.Models().Insert(ctx, "model", 1024) },
+		},
+		{
+			Name: "transaction",
+			Call: func(s storage.Storage) { _, _ = s.Task().InsertFromURL(ctx, "https://example.com", nil) },
+		},
+		{
+			Name: "update",
+			Call: func(s storage.Storage) {
+				_, _ = s.ReviewQueue().Claim(ctx, 1, "editor", s.Clock().Now(), time.Minute)
+			},
+		},
+		{
+			Name: "lock",
+			Call: func(s storage.Storage) { _, _ = s.Queries.LockUserTask(ctx, uuid.New()) },
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := storage.New(&snapshotFakeDB{}, nil)
+			require.Panics(t, func() {
+				_ = s.WithSnapshot(ctx, func(snap storage.Storage) error {
+					tc.Call(snap)
+					return nil
+				})
+			})
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+)
+
+func (s Storage) SourceWeights() SourceWeights {
+	return SourceWeights{s}
+}
+
+// SourceWeights provides methods to manage the authority weights of the
+// sources, blended into the score of the top articles. The weights are read
+// by the top articles query itself, so a new weight applies to the next
+// request.
+type SourceWeights struct {
+	Storage
+}
+
+// List returns the weights of the sources, by source.
+func (w SourceWeights) List(ctx context.Context) ([]models.SourceWeight, error) {
+	weights, err := w.querier(ctx, "SourceWeights", "List").ListSourceWeights(ctx)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return weights, nil
+}
+
+// Set sets the weight of source, in [0, 1], on behalf of updatedBy.
+func (w SourceWeights) Set(ctx context.Context, source string, weight float64, updatedBy string) (models.SourceWeight, error) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return models.SourceWeight{}, errors.ErrValidationFailed.Clone().
+			WithMessage("source should not be empty")
+	}
+
+	if weight < 0 || weight > 1 {
+		return models.SourceWeight{}, errors.ErrValidationFailed.Clone().
+			WithMessage("source weight should be in [0, 1]").
+			WithDetails(fmt.Sprintf("weight: %v", weight))
+	}
+
+	updatedBy = strings.TrimSpace(updatedBy)
+	if updatedBy == "" {
+		return models.SourceWeight{}, errors.ErrValidationFailed.Clone().
+			WithMessage("source weight editor should not be empty")
+	}
+
+	sw, err := w.Queries.UpsertSourceWeight(ctx, models.UpsertSourceWeightParams{
+		Source:    source,
+		Weight:    weight,
+		UpdatedBy: updatedBy,
+	})
+	if err != nil {
+		return models.SourceWeight{}, handlePgxErr(err)
+	}
+	return sw, nil
+}
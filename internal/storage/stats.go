@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+)
+
+// DashboardTrendDays is the number of days covered by the trend of the
+// dashboard.
+const DashboardTrendDays = 30
+
+func (s Storage) Stats() Stats {
+	return Stats{s}
+}
+
+// Stats provides the aggregates of the dashboard.
+type Stats struct {
+	Storage
+}
+
+// PartyCounts returns the number of articles of each party.
+func (s Stats) PartyCounts(ctx context.Context) ([]models.CountArticlesByPartyRow, error) {
+	counts, err := s.querier(ctx, "Stats", "PartyCounts").CountArticlesByParty(ctx)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return counts, nil
+}
+
+// DailyArticles returns the number of articles published each day and party
+// since since.
+func (s Stats) DailyArticles(ctx context.Context, since time.Time) ([]models.CountArticlesPerDayRow, error) {
+	tsz, err := utils.TimeTo.PGTimestamptz(since)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", since.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	counts, err := s.querier(ctx, "Stats", "DailyArticles").CountArticlesPerDay(ctx, tsz)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return counts, nil
+}
+
+// Dashboard holds the numbers of the dashboard, all read from one snapshot.
+type Dashboard struct {
+	Counters []models.Counter
+	Parties  []models.CountArticlesByPartyRow
+	Trend    []models.CountArticlesPerDayRow
+	// TrendSince is the start of the first day of the trend.
+	TrendSince time.Time
+}
+
+// Dashboard reads the counters, the articles of each party and their trend
+// over the last DashboardTrendDays days up to now from one snapshot, see
+// Storage.WithSnapshot, so that the numbers add up across the widgets.
+func (s Stats) Dashboard(ctx context.Context, now time.Time) (Dashboard, error) {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	d := Dashboard{TrendSince: day.AddDate(0, 0, -(DashboardTrendDays - 1))}
+
+	err := s.WithSnapshot(ctx, func(snap Storage) error {
+		var err error
+		if d.Counters, err = snap.Counters().List(ctx); err != nil {
+			return err
+		}
+		if d.Parties, err = snap.Stats().PartyCounts(ctx); err != nil {
+			return err
+		}
+		d.Trend, err = snap.Stats().DailyArticles(ctx, d.TrendSince)
+		return err
+	})
+	if err != nil {
+		return Dashboard{}, err
+	}
+	return d, nil
+}
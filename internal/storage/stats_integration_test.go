@@ -0,0 +1,151 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+// insertPartyArticle inserts an article of party published now, bumping the
+// articles counter in the same transaction as Article.Insert does.
+func insertPartyArticle(ctx context.Context, pool *pgxpool.Pool, party models.Party) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tsz, err := utils.TimeTo.PGTimestamptz(time.Now())
+	if err != nil {
+		return err
+	}
+
+	q := models.New(tx)
+	if _, err := q.InsertArticle(ctx, models.InsertArticleParams{
+		Title:       "stats " + uuid.NewString(),
+		Url:         "https://example.com/" + uuid.NewString(),
+		Source:      "test",
+		Md5:         uuid.NewString(),
+		Party:       party,
+		Content:     "content",
+		Cuts:        []int32{},
+		PublishedAt: tsz,
+	}); err != nil {
+		return err
+	}
+
+	if err := storage.IncrementCounter(ctx, q, storage.CounterArticles, 1); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// dashboardTotals returns the articles counter, the articles of all the
+// parties and the articles of the trend of d.
+func dashboardTotals(d storage.Dashboard) (counter, parties, trend int64) {
+	for _, c := range d.Counters {
+		if c.Name == storage.CounterArticles {
+			counter = c.Value
+		}
+	}
+	for _, p := range d.Parties {
+		parties += p.Count
+	}
+	for _, p := range d.Trend {
+		trend += p.Count
+	}
+	return counter, parties, trend
+}
+
+func TestStatsDashboardConcurrentInserts(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.Counters().Reconcile(ctx, storage.DefaultCounterDriftThreshold)
+	require.NoError(t, err)
+	base, err := s.Stats().Dashboard(ctx, time.Now())
+	require.NoError(t, err)
+	baseCounter, baseParties, baseTrend := dashboardTotals(base)
+	require.Equal(t, baseCounter, baseParties)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, party := range models.AllPartyValues() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := insertPartyArticle(ctx, pool, party); err != nil {
+					t.Errorf("inserter %d: %v", i, err)
+					return
+				}
+			}
+		}()
+	}
+
+	// the articles are all published now, they are counted by the counter,
+	// the parties and the trend alike
+	for range 20 {
+		d, err := s.Stats().Dashboard(ctx, time.Now())
+		require.NoError(t, err)
+		counter, parties, trend := dashboardTotals(d)
+		require.Equal(t, counter, parties)
+		require.Equal(t, parties-baseParties, trend-baseTrend)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestWithSnapshotIsolation(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	total := func(counts []models.CountArticlesByPartyRow) (n int64) {
+		for _, c := range counts {
+			n += c.Count
+		}
+		return n
+	}
+
+	var before, during int64
+	err := s.WithSnapshot(ctx, func(snap storage.Storage) error {
+		counts, err := snap.Stats().PartyCounts(ctx)
+		require.NoError(t, err)
+		before = total(counts)
+
+		// the writers are not blocked by the snapshot, nor seen by it
+		for range 5 {
+			require.NoError(t, insertPartyArticle(ctx, pool, models.PartyKMT))
+		}
+
+		counts, err = snap.Stats().PartyCounts(ctx)
+		require.NoError(t, err)
+		during = total(counts)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, before, during)
+
+	counts, err := s.Stats().PartyCounts(ctx)
+	require.NoError(t, err)
+	require.Equal(t, before+5, total(counts))
+}
@@ -1,31 +1,121 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 
 	"github.com/ChiaYuChang/weathercock/internal/global"
 	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
 	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
 
+// DB is the connection the storage writes to and begins its transactions on,
+// e.g. a *pgxpool.Pool or a *pgxpool.Conn.
+type DB interface {
+	models.DBTX
+	Begin(ctx context.Context) (pgx.Tx, error)
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
 type Storage struct {
+	// Queries runs on the write pool.
 	Queries *models.Queries
 	Cache   *redis.Client
-	db      *pgxpool.Conn
+	db      DB
+	// reader runs on the read pool, it is nil if no read pool is configured.
+	reader *models.Queries
+	clock  clockid.Clock
+
+	// readDB and decorators are set by the options and applied by New.
+	readDB     DB
+	decorators []Decorator
+}
+
+// Option configures a Storage.
+type Option func(*Storage)
+
+// WithReadPool routes the read-only accessor methods to pool. A nil pool keeps
+// every query on the write pool.
+func WithReadPool(pool *pgxpool.Pool) Option {
+	return func(s *Storage) {
+		if pool != nil {
+			s.readDB = pool
+		}
+	}
 }
 
-func New(conn *pgxpool.Conn, cache *redis.Client) Storage {
-	return Storage{
-		Queries: models.New(conn),
-		Cache:   cache,
-		db:      conn,
+// Decorator wraps the connection the queries of a storage run on, e.g. to
+// instrument them. The transactions begun on the connection it returns should
+// be wrapped the same way, so that the queries of the accessor methods opening
+// one are decorated too.
+type Decorator func(DB) DB
+
+// WithDecorators wraps the write pool and the read pool, if any, with ds, the
+// first decorator the innermost.
+func WithDecorators(ds ...Decorator) Option {
+	return func(s *Storage) {
+		s.decorators = append(s.decorators, ds...)
 	}
 }
 
+// WithClock makes the storage take the time and tick on c.
+func WithClock(c clockid.Clock) Option {
+	return func(s *Storage) {
+		s.clock = c
+	}
+}
+
+// Clock returns the clock of the storage, the real one unless set by
+// WithClock.
+func (s Storage) Clock() clockid.Clock {
+	if s.clock == nil {
+		return clockid.Real
+	}
+	return s.clock
+}
+
+// New creates a Storage writing to db. Without WithReadPool every query runs
+// on db.
+func New(db DB, cache *redis.Client, opts ...Option) Storage {
+	s := Storage{
+		Cache: cache,
+		db:    db,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	for _, decorate := range s.decorators {
+		s.db = decorate(s.db)
+		if s.readDB != nil {
+			s.readDB = decorate(s.readDB)
+		}
+	}
+	s.Queries = models.New(s.db)
+	if s.readDB != nil {
+		s.reader = models.New(s.readDB)
+	}
+	return s
+}
+
+// pgErrs are the errors of the SQLSTATEs a caller branches on, to tell e.g. a
+// duplicate md5 from a reference to a missing article, by their code. The
+// name of the constraint violated is in the details of the error.
+var pgErrs = map[string]*ec.Error{
+	pgerrcode.UniqueViolation:                        ec.ErrDBUniqueViolation,
+	pgerrcode.ForeignKeyViolation:                    ec.ErrDBForeignKeyViolation,
+	pgerrcode.NotNullViolation:                       ec.ErrDBNotNullViolation,
+	pgerrcode.StringDataRightTruncationDataException: ec.ErrDBValueTooLong,
+	pgerrcode.SerializationFailure:                   ec.ErrDBSerializationFailure,
+}
+
 func handlePgxErr(err error) *ec.Error {
 	if err == nil {
 		return nil
@@ -33,7 +123,9 @@ func handlePgxErr(err error) *ec.Error {
 
 	if pgerr, ok := ec.NewPGErr(err); ok {
 		var e *ec.Error
-		if pgerrcode.IsIntegrityConstraintViolation(pgerr.Code) {
+		if known, ok := pgErrs[pgerr.Code]; ok {
+			e = known.Clone()
+		} else if pgerrcode.IsIntegrityConstraintViolation(pgerr.Code) {
 			e = ec.ErrDBIntegrityConstrainViolation.Clone()
 		} else {
 			e = ec.ErrDBTypeConversionError.Clone()
@@ -41,6 +133,12 @@ func handlePgxErr(err error) *ec.Error {
 		e.WithMessage(pgerr.Message).
 			WithDetails(pgerr.Details).
 			Warp(err)
+		if pgerr.Constraint != "" {
+			e.WithDetails(fmt.Sprintf("constraint: %s", pgerr.Constraint))
+		}
+		if pgerr.Column != "" {
+			e.WithDetails(fmt.Sprintf("column: %s", pgerr.Column))
+		}
 		return e
 	}
 
@@ -0,0 +1,110 @@
+package storage_test
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlePgxErr(t *testing.T) {
+	for _, tc := range []struct {
+		Name    string
+		Err     error
+		Want    *ec.Error
+		Details []string
+	}{
+		{
+			Name: "unique violation",
+			Err: &pgconn.PgError{
+				Code:           pgerrcode.UniqueViolation,
+				Message:        `duplicate key value violates unique constraint "articles_md5_key"`,
+				Detail:         "Key (md5)=(abc) already exists.",
+				ConstraintName: "articles_md5_key",
+			},
+			Want:    ec.ErrDBUniqueViolation,
+			Details: []string{"Key (md5)=(abc) already exists.", "constraint: articles_md5_key"},
+		},
+		{
+			Name: "foreign key violation",
+			Err: &pgconn.PgError{
+				Code:           pgerrcode.ForeignKeyViolation,
+				Message:        `insert or update on table "chunks" violates foreign key constraint "chunks_article_id_fkey"`,
+				Detail:         `Key (article_id)=(1) is not present in table "articles".`,
+				ConstraintName: "chunks_article_id_fkey",
+			},
+			Want:    ec.ErrDBForeignKeyViolation,
+			Details: []string{`Key (article_id)=(1) is not present in table "articles".`, "constraint: chunks_article_id_fkey"},
+		},
+		{
+			Name: "not null violation",
+			Err: &pgconn.PgError{
+				Code:       pgerrcode.NotNullViolation,
+				Message:    `null value in column "title" of relation "articles" violates not-null constraint`,
+				ColumnName: "title",
+			},
+			Want:    ec.ErrDBNotNullViolation,
+			Details: []string{"", "column: title"},
+		},
+		{
+			Name: "value too long",
+			Err: &pgconn.PgError{
+				Code:    pgerrcode.StringDataRightTruncationDataException,
+				Message: "value too long for type character varying(8)",
+			},
+			Want:    ec.ErrDBValueTooLong,
+			Details: []string{""},
+		},
+		{
+			Name: "serialization failure",
+			Err: &pgconn.PgError{
+				Code:    pgerrcode.SerializationFailure,
+				Message: "could not serialize access due to concurrent update",
+			},
+			Want:    ec.ErrDBSerializationFailure,
+			Details: []string{""},
+		},
+		{
+			Name: "other integrity constraint violation",
+			Err: &pgconn.PgError{
+				Code:           pgerrcode.CheckViolation,
+				Message:        `new row for relation "articles" violates check constraint "articles_canonical_id_check"`,
+				ConstraintName: "articles_canonical_id_check",
+			},
+			Want:    ec.ErrDBIntegrityConstrainViolation,
+			Details: []string{"", "constraint: articles_canonical_id_check"},
+		},
+		{
+			Name:    "other database error",
+			Err:     fmt.Errorf("failed to insert: %w", &pgconn.PgError{Code: pgerrcode.InvalidTextRepresentation}),
+			Want:    ec.ErrDBTypeConversionError,
+			Details: []string{""},
+		},
+		{
+			Name:    "no rows",
+			Err:     sql.ErrNoRows,
+			Want:    ec.ErrNotFound,
+			Details: []string{},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			e := storage.HandlePgxErr(tc.Err)
+			require.ErrorIs(t, e, tc.Want)
+			require.ErrorIs(t, e, tc.Err)
+			require.Equal(t, tc.Want.HttpStatusCode, e.HttpStatusCode)
+			require.Equal(t, tc.Details, e.Details)
+		})
+	}
+
+	require.Nil(t, storage.HandlePgxErr(nil))
+
+	// the codes tell the errors apart
+	e := storage.HandlePgxErr(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	require.NotErrorIs(t, e, ec.ErrDBForeignKeyViolation)
+	require.NotErrorIs(t, e, ec.ErrDBIntegrityConstrainViolation)
+}
@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// DefaultTaskEventRetention is the age of the events of the finished tasks
+	// above which they are compacted.
+	DefaultTaskEventRetention = 30 * 24 * time.Hour
+	// DefaultTaskEventCompactionBatchSize is the number of events deleted per
+	// statement.
+	DefaultTaskEventCompactionBatchSize = 1000
+)
+
+// TaskEvent is a status change of a stage of a task.
+type TaskEvent struct {
+	ID        int64             `json:"id"`
+	Stage     string            `json:"stage"`
+	Status    models.TaskStatus `json:"status"`
+	Message   string            `json:"message,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// StageState is the state of a stage derived from its events. The first and
+// the last event of a stage survive the compaction, so they can always be
+// reconstructed from it.
+type StageState struct {
+	Status       models.TaskStatus `json:"status"`
+	Events       int               `json:"events"`
+	FirstEventID int64             `json:"first_event_id"`
+	FirstStatus  models.TaskStatus `json:"first_status"`
+	FirstAt      time.Time         `json:"first_at"`
+	LastEventID  int64             `json:"last_event_id"`
+	LastAt       time.Time         `json:"last_at"`
+	Message      string            `json:"message,omitempty"`
+}
+
+// TaskState is the snapshot of the state derived from the events of a task.
+type TaskState struct {
+	Status      models.TaskStatus     `json:"status"`
+	Stages      map[string]StageState `json:"stages"`
+	LastEventID int64                 `json:"last_event_id"`
+	Compacted   bool                  `json:"compacted"`
+	UpdatedAt   time.Time             `json:"updated_at"`
+}
+
+// Apply returns the state after event e, which should be newer than the last
+// event applied to s.
+func (s TaskState) Apply(e TaskEvent) TaskState {
+	stages := make(map[string]StageState, len(s.Stages)+1)
+	maps.Copy(stages, s.Stages)
+
+	stage, ok := stages[e.Stage]
+	if !ok {
+		stage.FirstEventID = e.ID
+		stage.FirstStatus = e.Status
+		stage.FirstAt = e.CreatedAt
+	}
+	stage.Status = e.Status
+	stage.Events++
+	stage.LastEventID = e.ID
+	stage.LastAt = e.CreatedAt
+	stage.Message = e.Message
+	stages[e.Stage] = stage
+
+	s.Stages = stages
+	s.LastEventID = e.ID
+	s.UpdatedAt = e.CreatedAt
+	statuses := make([]models.TaskStatus, 0, len(stages))
+	for _, stage := range stages {
+		statuses = append(statuses, stage.Status)
+	}
+	s.Status = DeriveTaskStatus(statuses)
+	return s
+}
+
+// DeriveTaskStatus derives the status of a task from the latest status of
+// each of its stages: failed if any stage failed, done if all of them are
+// done, pending if none has started, and processing otherwise.
+func DeriveTaskStatus(stages []models.TaskStatus) models.TaskStatus {
+	n, done, pending := len(stages), 0, 0
+	for _, status := range stages {
+		switch status {
+		case models.TaskStatusFailed:
+			return models.TaskStatusFailed
+		case models.TaskStatusDone:
+			done++
+		case models.TaskStatusPending:
+			pending++
+		}
+	}
+
+	switch {
+	case n == pending:
+		return models.TaskStatusPending
+	case n == done:
+		return models.TaskStatusDone
+	default:
+		return models.TaskStatusProcessing
+	}
+}
+
+// TaskStatusFromEvents derives the status of a task from its events, in any
+// order. It agrees with the status of the snapshot the events are applied to.
+func TaskStatusFromEvents(events []TaskEvent) models.TaskStatus {
+	latest := map[string]TaskEvent{}
+	for _, e := range events {
+		if cur, ok := latest[e.Stage]; !ok || e.ID > cur.ID {
+			latest[e.Stage] = e
+		}
+	}
+
+	statuses := make([]models.TaskStatus, 0, len(latest))
+	for _, e := range latest {
+		statuses = append(statuses, e.Status)
+	}
+	return DeriveTaskStatus(statuses)
+}
+
+// TaskTimeline is the timeline of a task. Once the events of the task have
+// been compacted, Events only holds the first and the last event of every
+// stage, and Summarized is set.
+type TaskTimeline struct {
+	TaskID     uuid.UUID             `json:"task_id"`
+	Status     models.TaskStatus     `json:"status"`
+	Summarized bool                  `json:"summarized"`
+	Events     []TaskEvent           `json:"events"`
+	Stages     map[string]StageState `json:"stages,omitempty"`
+}
+
+// Events returns the summarized timeline reconstructed from the snapshot: the
+// first and the last event of every stage, ordered by id.
+func (s TaskState) Events() []TaskEvent {
+	events := make([]TaskEvent, 0, 2*len(s.Stages))
+	for name, stage := range s.Stages {
+		events = append(events, TaskEvent{
+			ID:        stage.FirstEventID,
+			Stage:     name,
+			Status:    stage.FirstStatus,
+			CreatedAt: stage.FirstAt,
+		})
+
+		if stage.LastEventID != stage.FirstEventID {
+			events = append(events, TaskEvent{
+				ID:        stage.LastEventID,
+				Stage:     name,
+				Status:    stage.Status,
+				Message:   stage.Message,
+				CreatedAt: stage.LastAt,
+			})
+		}
+	}
+
+	slices.SortFunc(events, func(a, b TaskEvent) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return events
+}
+
+func (s Storage) TaskEvents() TaskEvents {
+	return TaskEvents{s}
+}
+
+// TaskEvents provides methods to append to and read the event streams of the
+// tasks, and to keep the snapshots of their state.
+type TaskEvents struct {
+	Storage
+}
+
+// Append appends e to the event stream of a task and upserts the snapshot of
+// its state in the same transaction, the status of the task is moved to the
+// derived one, with the message of a failed event as its error. It is the only
+// writer of the status of the tasks the workers run, so that the status, the
+// snapshot and the timeline always agree. A move the status of the task does
+// not allow, see CanTransitionTask, e.g. of a done task back to processing by
+// a redelivered command, fails with ErrConflict and appends nothing. A zero
+// CreatedAt is set to the current time.
+func (t TaskEvents) Append(ctx context.Context, taskID uuid.UUID, e TaskEvent) (TaskState, error) {
+	return t.append(ctx, taskID, e, false)
+}
+
+// Reopen appends e the way Append does, but moves a done task back to
+// processing, e.g. when its article is scraped again to replace the one an
+// editor dismissed.
+func (t TaskEvents) Reopen(ctx context.Context, taskID uuid.UUID, e TaskEvent) (TaskState, error) {
+	return t.append(ctx, taskID, e, true)
+}
+
+func (t TaskEvents) append(ctx context.Context, taskID uuid.UUID, e TaskEvent, reopen bool) (TaskState, error) {
+	if e.Stage == "" {
+		return TaskState{}, ec.ErrValidationFailed.Clone().
+			WithMessage("task event stage should not be empty")
+	}
+
+	if !e.Status.Valid() {
+		return TaskState{}, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid task event status").
+			WithDetails(fmt.Sprintf("status: %s", e.Status))
+	}
+
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = t.Clock().Now()
+	}
+
+	createdAt, err := utils.TimeTo.PGTimestamptz(e.CreatedAt)
+	if err != nil {
+		return TaskState{}, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", e.CreatedAt.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	tx, err := t.db.Begin(ctx)
+	if err != nil {
+		return TaskState{}, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := t.Queries.WithTx(tx)
+	if _, err := q.LockUserTask(ctx, taskID); err != nil {
+		return TaskState{}, handlePgxErr(err)
+	}
+
+	state, err := getTaskState(ctx, q, taskID)
+	if err != nil {
+		return TaskState{}, err
+	}
+
+	row, err := q.InsertTaskEvent(ctx, models.InsertTaskEventParams{
+		TaskID:    taskID,
+		Stage:     e.Stage,
+		Status:    e.Status,
+		Message:   e.Message,
+		CreatedAt: createdAt,
+	})
+	if err != nil {
+		return TaskState{}, handlePgxErr(err)
+	}
+
+	prev := state.Status
+	state = state.Apply(taskEventFromRow(row))
+	stages, err := json.Marshal(state.Stages)
+	if err != nil {
+		return TaskState{}, ec.ErrInternalServerError.Clone().
+			WithMessage("failed to marshal stage statuses").
+			Warp(err)
+	}
+
+	if err := q.UpsertTaskState(ctx, models.UpsertTaskStateParams{
+		TaskID:        taskID,
+		CurrentStatus: state.Status,
+		StageStatuses: stages,
+		LastEventID:   state.LastEventID,
+		UpdatedAt:     row.CreatedAt,
+	}); err != nil {
+		return TaskState{}, handlePgxErr(err)
+	}
+
+	// a failure is recorded again for its message
+	if state.Status != prev || e.Status == models.TaskStatusFailed {
+		errMsg := ""
+		if state.Status == models.TaskStatusFailed {
+			errMsg = e.Message
+		}
+		if err := transitionTask(ctx, q, taskID, state.Status, errMsg, reopen); err != nil {
+			return TaskState{}, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return TaskState{}, handlePgxErr(err)
+	}
+	return state, nil
+}
+
+// State returns the snapshot of the state of a task. A task without any event
+// is pending.
+func (t TaskEvents) State(ctx context.Context, taskID uuid.UUID) (TaskState, error) {
+	return getTaskState(ctx, t.querier(ctx, "TaskEvents", "State"), taskID)
+}
+
+// Timeline returns the events of a task, or the summarized timeline
+// reconstructed from its snapshot if the events have been compacted.
+func (t TaskEvents) Timeline(ctx context.Context, taskID uuid.UUID) (TaskTimeline, error) {
+	state, err := t.State(ctx, taskID)
+	if err != nil {
+		return TaskTimeline{}, err
+	}
+
+	timeline := TaskTimeline{
+		TaskID: taskID,
+		Status: state.Status,
+		Stages: state.Stages,
+	}
+
+	if state.Compacted {
+		timeline.Summarized = true
+		timeline.Events = state.Events()
+		return timeline, nil
+	}
+
+	rows, err := t.querier(ctx, "TaskEvents", "Timeline").ListTaskEvents(ctx, taskID)
+	if err != nil {
+		return TaskTimeline{}, handlePgxErr(err)
+	}
+
+	timeline.Events = make([]TaskEvent, len(rows))
+	for i, row := range rows {
+		timeline.Events[i] = taskEventFromRow(row)
+	}
+	return timeline, nil
+}
+
+// Compact deletes the events older than cutoff of the tasks in a terminal
+// state, keeping the first and the last event of every stage. It deletes
+// batchSize events per statement until none is left, and returns the number
+// of events deleted.
+func (t TaskEvents) Compact(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultTaskEventCompactionBatchSize
+	}
+
+	cutoffTsz, err := utils.TimeTo.PGTimestamptz(cutoff)
+	if err != nil {
+		return 0, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", cutoff.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	var total int64
+	for {
+		n, err := t.Queries.CompactTaskEvents(ctx, models.CompactTaskEventsParams{
+			Cutoff:    cutoffTsz,
+			BatchSize: int32(batchSize),
+		})
+		if err != nil {
+			return total, handlePgxErr(err)
+		}
+
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// RunCompactor compacts the events older than retention of the finished
+// tasks every interval, until ctx is cancelled.
+func (t TaskEvents) RunCompactor(ctx context.Context, interval, retention time.Duration, batchSize int) {
+	ticker := t.Clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := t.Compact(ctx, t.Clock().Now().Add(-retention), batchSize)
+		if err != nil {
+			global.Logger.Error().Err(err).Int64("deleted", n).Msg("Failed to compact task events")
+		} else if n > 0 {
+			global.Logger.Info().Int64("deleted", n).Msg("Compacted task events")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func getTaskState(ctx context.Context, q models.Querier, taskID uuid.UUID) (TaskState, error) {
+	row, err := q.GetTaskState(ctx, taskID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return TaskState{Status: models.TaskStatusPending}, nil
+	}
+
+	if err != nil {
+		return TaskState{}, handlePgxErr(err)
+	}
+
+	state := TaskState{
+		Status:      row.CurrentStatus,
+		LastEventID: row.LastEventID,
+		Compacted:   row.Compacted,
+		UpdatedAt:   row.UpdatedAt.Time,
+	}
+
+	if err := json.Unmarshal(row.StageStatuses, &state.Stages); err != nil {
+		return TaskState{}, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to unmarshal stage statuses").
+			WithDetails(fmt.Sprintf("task_id: %s", taskID)).
+			Warp(err)
+	}
+	return state, nil
+}
+
+func taskEventFromRow(row models.UsersTaskEvent) TaskEvent {
+	return TaskEvent{
+		ID:        row.ID,
+		Stage:     row.Stage,
+		Status:    row.Status,
+		Message:   row.Message,
+		CreatedAt: row.CreatedAt.Time,
+	}
+}
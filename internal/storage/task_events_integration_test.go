@@ -0,0 +1,126 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// appendRetries appends a scrape stage failing twice before succeeding and
+// handing the task over to an embed stage ending with last, one minute apart
+// starting at at.
+func appendRetries(t *testing.T, events storage.TaskEvents, taskID uuid.UUID,
+	at time.Time, last models.TaskStatus) {
+	t.Helper()
+	seq := []storage.TaskEvent{
+		{Stage: "scrape", Status: models.TaskStatusProcessing},
+		{Stage: "scrape", Status: models.TaskStatusFailed, Message: "timeout"},
+		{Stage: "scrape", Status: models.TaskStatusProcessing},
+		{Stage: "scrape", Status: models.TaskStatusFailed, Message: "timeout"},
+		{Stage: "scrape", Status: models.TaskStatusProcessing},
+		{Stage: "scrape", Status: models.TaskStatusDone},
+		{Stage: "embed", Status: models.TaskStatusPending},
+		{Stage: "embed", Status: models.TaskStatusProcessing},
+		{Stage: "embed", Status: last},
+	}
+
+	for i, e := range seq {
+		e.CreatedAt = at.Add(time.Duration(i) * time.Minute)
+		_, err := events.Append(context.Background(), taskID, e)
+		require.NoError(t, err)
+	}
+}
+
+func TestTaskEventsCompaction(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx := context.Background()
+	events := s.TaskEvents()
+
+	newTask := func() uuid.UUID {
+		id, err := s.Task().InsertFromText(ctx, "compaction "+uuid.NewString(), nil)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_, _ = pool.Exec(context.Background(), `DELETE FROM users.tasks WHERE task_id = $1`, id)
+		})
+		return id
+	}
+
+	now := time.Now().Truncate(time.Microsecond)
+	old := now.Add(-60 * 24 * time.Hour)
+
+	finished := newTask()
+	appendRetries(t, events, finished, old, models.TaskStatusDone)
+	running := newTask()
+	appendRetries(t, events, running, old, models.TaskStatusProcessing)
+	recent := newTask()
+	appendRetries(t, events, recent, now.Add(-time.Hour), models.TaskStatusDone)
+
+	before, err := events.Timeline(ctx, finished)
+	require.NoError(t, err)
+	require.False(t, before.Summarized)
+	require.Len(t, before.Events, 9)
+	require.Equal(t, models.TaskStatusDone, before.Status)
+
+	task, err := s.Queries.GetUserTask(ctx, finished)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusDone, task.Status)
+
+	// a small batch size makes the compaction run several statements
+	n, err := events.Compact(ctx, now.Add(-storage.DefaultTaskEventRetention), 2)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, n, int64(4))
+
+	after, err := events.Timeline(ctx, finished)
+	require.NoError(t, err)
+	require.True(t, after.Summarized)
+	require.Equal(t, before.Status, after.Status)
+
+	rows, err := s.Queries.ListTaskEvents(ctx, finished)
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	require.Len(t, after.Events, len(rows))
+	for i, row := range rows {
+		require.Equal(t, row.ID, after.Events[i].ID)
+		require.Equal(t, row.Stage, after.Events[i].Stage)
+		require.Equal(t, row.Status, after.Events[i].Status)
+	}
+
+	state, err := events.State(ctx, finished)
+	require.NoError(t, err)
+	require.True(t, state.Compacted)
+	require.Equal(t, 6, state.Stages["scrape"].Events)
+	require.Equal(t, 3, state.Stages["embed"].Events)
+
+	for _, id := range []uuid.UUID{running, recent} {
+		timeline, err := events.Timeline(ctx, id)
+		require.NoError(t, err)
+		require.False(t, timeline.Summarized)
+		require.Len(t, timeline.Events, 9)
+	}
+
+	// compacting again has nothing left to delete for the task
+	_, err = events.Compact(ctx, now.Add(-storage.DefaultTaskEventRetention), 2)
+	require.NoError(t, err)
+	rows, err = s.Queries.ListTaskEvents(ctx, finished)
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+}
+
+func TestTaskEventsAppendUnknownTask(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+
+	_, err := s.TaskEvents().Append(context.Background(), uuid.New(), storage.TaskEvent{
+		Stage:  "scrape",
+		Status: models.TaskStatusProcessing,
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,168 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+var taskStatuses = []models.TaskStatus{
+	models.TaskStatusPending,
+	models.TaskStatusProcessing,
+	models.TaskStatusDone,
+	models.TaskStatusFailed,
+}
+
+// randomTaskEvents generates up to n events over up to 4 stages, with
+// increasing ids and timestamps.
+func randomTaskEvents(r *rand.Rand, n int) []storage.TaskEvent {
+	stages := []string{"scrape", "chunk", "embed", "keywords"}[:1+r.IntN(4)]
+	events := make([]storage.TaskEvent, r.IntN(n+1))
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range events {
+		at = at.Add(time.Duration(1+r.IntN(3600)) * time.Second)
+		events[i] = storage.TaskEvent{
+			ID:        int64(i + 1),
+			Stage:     stages[r.IntN(len(stages))],
+			Status:    taskStatuses[r.IntN(len(taskStatuses))],
+			Message:   fmt.Sprintf("event %d", i+1),
+			CreatedAt: at,
+		}
+	}
+	return events
+}
+
+// compactEvents keeps the first and the last event of every stage, as the
+// CompactTaskEvents query does.
+func compactEvents(events []storage.TaskEvent) []storage.TaskEvent {
+	first, last := map[string]int64{}, map[string]int64{}
+	for _, e := range events {
+		if _, ok := first[e.Stage]; !ok {
+			first[e.Stage] = e.ID
+		}
+		last[e.Stage] = e.ID
+	}
+
+	kept := []storage.TaskEvent{}
+	for _, e := range events {
+		if first[e.Stage] == e.ID || last[e.Stage] == e.ID {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func TestTaskStateEquivalence(t *testing.T) {
+	r := rand.New(rand.NewPCG(2163, 1))
+	for i := range 1000 {
+		events := randomTaskEvents(r, 40)
+
+		// the snapshot goes through its JSON encoding between two events, as
+		// it does in the database
+		state := storage.TaskState{Status: models.TaskStatusPending}
+		for _, e := range events {
+			state = state.Apply(e)
+			data, err := json.Marshal(state)
+			require.NoError(t, err)
+			state = storage.TaskState{}
+			require.NoError(t, json.Unmarshal(data, &state))
+		}
+
+		want := storage.TaskStatusFromEvents(events)
+		require.Equal(t, want, state.Status, "sequence %d: %v", i, events)
+
+		compacted := compactEvents(events)
+		require.Equal(t, want, storage.TaskStatusFromEvents(compacted), "sequence %d: %v", i, events)
+
+		summarized := state.Events()
+		require.Equal(t, want, storage.TaskStatusFromEvents(summarized), "sequence %d: %v", i, events)
+		require.Len(t, summarized, len(compacted))
+		for j := range compacted {
+			require.Equal(t, compacted[j].ID, summarized[j].ID)
+			require.Equal(t, compacted[j].Stage, summarized[j].Stage)
+			require.Equal(t, compacted[j].Status, summarized[j].Status)
+			require.True(t, compacted[j].CreatedAt.Equal(summarized[j].CreatedAt))
+		}
+
+		// events may be read back in any order
+		r.Shuffle(len(events), func(i, j int) {
+			events[i], events[j] = events[j], events[i]
+		})
+		require.Equal(t, want, storage.TaskStatusFromEvents(events), "sequence %d: %v", i, events)
+	}
+}
+
+func TestDeriveTaskStatus(t *testing.T) {
+	tcs := []struct {
+		name   string
+		stages []models.TaskStatus
+		want   models.TaskStatus
+	}{
+		{
+			name: "no stage",
+			want: models.TaskStatusPending,
+		},
+		{
+			name:   "all pending",
+			stages: []models.TaskStatus{models.TaskStatusPending, models.TaskStatusPending},
+			want:   models.TaskStatusPending,
+		},
+		{
+			name:   "some done",
+			stages: []models.TaskStatus{models.TaskStatusDone, models.TaskStatusPending},
+			want:   models.TaskStatusProcessing,
+		},
+		{
+			name:   "all done",
+			stages: []models.TaskStatus{models.TaskStatusDone, models.TaskStatusDone},
+			want:   models.TaskStatusDone,
+		},
+		{
+			name:   "any failed",
+			stages: []models.TaskStatus{models.TaskStatusDone, models.TaskStatusFailed, models.TaskStatusProcessing},
+			want:   models.TaskStatusFailed,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, storage.DeriveTaskStatus(tc.stages))
+		})
+	}
+}
+
+func TestTaskStateRetry(t *testing.T) {
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := []storage.TaskEvent{
+		{ID: 1, Stage: "scrape", Status: models.TaskStatusProcessing, CreatedAt: at},
+		{ID: 2, Stage: "scrape", Status: models.TaskStatusFailed, Message: "timeout", CreatedAt: at.Add(time.Minute)},
+		{ID: 3, Stage: "scrape", Status: models.TaskStatusProcessing, CreatedAt: at.Add(2 * time.Minute)},
+		{ID: 4, Stage: "scrape", Status: models.TaskStatusDone, CreatedAt: at.Add(3 * time.Minute)},
+	}
+
+	state := storage.TaskState{}
+	for i, e := range events {
+		state = state.Apply(e)
+		if i == 1 {
+			require.Equal(t, models.TaskStatusFailed, state.Status)
+		}
+	}
+
+	require.Equal(t, models.TaskStatusDone, state.Status)
+	require.Equal(t, int64(4), state.LastEventID)
+	require.Equal(t, storage.StageState{
+		Status:       models.TaskStatusDone,
+		Events:       4,
+		FirstEventID: 1,
+		FirstStatus:  models.TaskStatusProcessing,
+		FirstAt:      at,
+		LastEventID:  4,
+		LastAt:       at.Add(3 * time.Minute),
+	}, state.Stages["scrape"])
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// TaskResult is what a task has produced so far. A section is nil until the
+// stage of the pipeline producing it has run: Article until the article is
+// scraped or parsed, Keywords until keywords are attached to it, and Similar
+// until it is embedded. Similar is empty, not nil, if it is embedded but no
+// shared article is similar to it.
+type TaskResult struct {
+	Task     models.UsersTask                         `json:"task"`
+	Article  *models.UsersArticle                     `json:"article"`
+	Keywords []models.ListKeywordsByUsersArticleIDRow `json:"keywords"`
+	Similar  []SimilarArticle                         `json:"similar"`
+}
+
+// TaskResult returns the task of taskID with its article, the keywords of the
+// article and the DefaultSimilarK shared articles most similar to it under the
+// model it is embedded with, all read from one snapshot, see
+// Storage.WithSnapshot, so that a section is never ahead of the ones it is
+// derived from. A task whose pipeline has not finished yet is not an error,
+// its later sections are left nil, see TaskResult.
+func (s Storage) TaskResult(ctx context.Context, taskID uuid.UUID) (TaskResult, error) {
+	var r TaskResult
+	err := s.WithSnapshot(ctx, func(snap Storage) error {
+		q := snap.Queries
+		task, err := q.GetUserTask(ctx, taskID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ec.ErrNotFound.Clone().
+				WithMessage("task not found").
+				WithDetails(fmt.Sprintf("task ID: %s", taskID))
+		}
+		if err != nil {
+			return handlePgxErr(err)
+		}
+		r.Task = task
+
+		article, err := q.GetUsersArticleByTaskID(ctx, taskID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return handlePgxErr(err)
+		}
+		r.Article = &article
+
+		if r.Keywords, err = q.ListKeywordsByUsersArticleID(ctx, article.ID); err != nil {
+			return handlePgxErr(err)
+		}
+
+		modelID, err := q.GetUsersEmbeddingModelIDByTaskID(ctx, taskID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return handlePgxErr(err)
+		}
+
+		dq, err := snap.withModelDim(ctx, "Storage", "TaskResult", modelID, nil)
+		if err != nil {
+			return err
+		}
+
+		rows, err := dq.GetSimilarArticlesByTaskID(ctx, models.GetSimilarArticlesByTaskIDParams{
+			TaskID:  taskID,
+			ModelID: modelID,
+			K:       DefaultSimilarK,
+			TopM:    similarWithoutExplain,
+		})
+		if err != nil {
+			return handlePgxErr(err)
+		}
+		r.Similar = GroupSimilarArticles(rows, ExplainOptions{})
+		return nil
+	})
+	if err != nil {
+		return TaskResult{}, err
+	}
+	return r, nil
+}
@@ -0,0 +1,103 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/internal/testtools"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskResult(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.TaskResult(ctx, uuid.New())
+	var e *ec.Error
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, ec.ErrNotFound.HttpStatusCode, e.HttpStatusCode)
+
+	rnd := testtools.Random{}
+	taskID, err := s.Task().InsertFromText(ctx, "result "+uuid.NewString(), nil)
+	require.NoError(t, err)
+
+	// the task is not scraped yet
+	r, err := s.TaskResult(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, taskID, r.Task.TaskID)
+	require.Nil(t, r.Article)
+	require.Nil(t, r.Keywords)
+	require.Nil(t, r.Similar)
+
+	ua, err := rnd.UsersArticle(0, taskID)
+	require.NoError(t, err)
+	aID, err := s.UserArticles().Insert(ctx, taskID, ua.Title, ua.Source, ua.Content, ua.Cuts,
+		ua.PublishedAt.Time, time.Time{}, nil)
+	require.NoError(t, err)
+
+	r, err = s.TaskResult(ctx, taskID)
+	require.NoError(t, err)
+	require.NotNil(t, r.Article)
+	require.Equal(t, aID, r.Article.ID)
+	require.Equal(t, ua.Title, r.Article.Title)
+	require.Nil(t, r.Keywords)
+	require.Nil(t, r.Similar)
+
+	_, err = s.Keywords().InsertForUserArticle(ctx, aID, "en", []string{"result-" + uuid.NewString()})
+	require.NoError(t, err)
+
+	r, err = s.TaskResult(ctx, taskID)
+	require.NoError(t, err)
+	require.Len(t, r.Keywords, 1)
+	require.Nil(t, r.Similar)
+
+	modelID, err := s.Models().Insert(ctx, "result-"+uuid.NewString(), 1024)
+	require.NoError(t, err)
+	cID, err := s.UserChunks().Insert(ctx, aID, 0, 0, int32(len(ua.Content)), int32(len(ua.Content)))
+	require.NoError(t, err)
+	_, err = s.UserEmbeddings().Insert(ctx, aID, cID, modelID, axisVector(0, 0))
+	require.NoError(t, err)
+
+	// embedded, but no shared article is embedded under the model yet
+	r, err = s.TaskResult(ctx, taskID)
+	require.NoError(t, err)
+	require.NotNil(t, r.Similar)
+	require.Empty(t, r.Similar)
+
+	a, err := rnd.Article(0)
+	require.NoError(t, err)
+	sharedID, err := storage.Article{Storage: s}.Insert(ctx, a.Url, a.Title, a.Source, a.Md5,
+		a.Content, a.Cuts, a.PublishedAt.Time, time.Time{})
+	require.NoError(t, err)
+	sharedChunk, err := s.Queries.InsertChunk(ctx, models.InsertChunkParams{
+		ArticleID:   sharedID,
+		OffsetRight: int32(len(a.Content)),
+		End:         int32(len(a.Content)),
+	})
+	require.NoError(t, err)
+	_, err = s.Queries.InsertEmbedding(ctx, models.InsertEmbeddingParams{
+		ArticleID: sharedID,
+		ChunkID:   sharedChunk,
+		ModelID:   modelID,
+		Vector:    utils.ToPgVector(axisVector(0, 0.1)),
+	})
+	require.NoError(t, err)
+
+	r, err = s.TaskResult(ctx, taskID)
+	require.NoError(t, err)
+	require.Len(t, r.Similar, 1)
+	require.Equal(t, sharedID, r.Similar[0].ArticleID)
+	require.Equal(t, a.Title, r.Similar[0].Title)
+	require.InDelta(t, 1/1.00499, r.Similar[0].Similarity, 1e-4)
+	require.Empty(t, r.Similar[0].Explanation)
+}
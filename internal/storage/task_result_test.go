@@ -0,0 +1,25 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskResultSnapshot(t *testing.T) {
+	ctx := context.Background()
+	reader := &fakeDB{}
+	db := &snapshotFakeDB{}
+	s := storage.New(db, nil, storage.WithReadDB(reader))
+
+	_, err := s.TaskResult(ctx, uuid.New())
+	require.Error(t, err)
+	// the task is read from the snapshot, and the failure rolls it back
+	require.Len(t, db.tx.stmts, 2)
+	require.Contains(t, db.tx.stmts[1], "GetUserTask")
+	require.False(t, db.tx.committed)
+	require.Zero(t, reader.calls)
+}
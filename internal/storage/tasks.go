@@ -2,11 +2,22 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"time"
 
+	"github.com/ChiaYuChang/weathercock/internal/global"
 	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+// SystemOwnerID is the owner of the tasks created by the workers rather than
+// submitted through the API, e.g. the ones of the discovered news.
+const SystemOwnerID = "system"
+
 type Tasks struct {
 	Storage
 }
@@ -16,6 +27,13 @@ func (s Storage) Task() Tasks {
 }
 
 func (t Tasks) InsertFromURL(ctx context.Context, url string,
+	fn func(ctx context.Context, taskID uuid.UUID) error) (uuid.UUID, error) {
+	return t.InsertFromURLAs(ctx, "", url, fn)
+}
+
+// InsertFromURLAs is InsertFromURL on behalf of ownerID, e.g. SystemOwnerID.
+// The task has no owner if ownerID is empty.
+func (t Tasks) InsertFromURLAs(ctx context.Context, ownerID, url string,
 	fn func(ctx context.Context, taskID uuid.UUID) error) (uuid.UUID, error) {
 	tx, err := t.db.Begin(ctx)
 	if err != nil {
@@ -23,14 +41,20 @@ func (t Tasks) InsertFromURL(ctx context.Context, url string,
 	}
 	defer tx.Rollback(ctx)
 
+	q := t.Queries.WithTx(tx)
 	var uid uuid.UUID
-	if uid, err = t.Queries.WithTx(tx).InsertUserTask(ctx, models.InsertUserTaskParams{
+	if uid, err = q.InsertUserTask(ctx, models.InsertUserTaskParams{
 		Source:        models.SourceTypeUrl,
 		OriginalInput: url,
+		OwnerID:       pgtype.Text{String: ownerID, Valid: ownerID != ""},
 	}); err != nil {
 		return uuid.UUID{}, handlePgxErr(err)
 	}
 
+	if err = IncrementCounter(ctx, q, CounterTasks, 1); err != nil {
+		return uuid.UUID{}, err
+	}
+
 	if fn != nil {
 		if err = fn(ctx, uid); err != nil {
 			return uuid.UUID{}, err
@@ -51,14 +75,19 @@ func (t Tasks) InsertFromText(ctx context.Context, text string,
 	}
 	defer tx.Rollback(ctx)
 
+	q := t.Queries.WithTx(tx)
 	var uid uuid.UUID
-	if uid, err = t.Queries.WithTx(tx).InsertUserTask(ctx, models.InsertUserTaskParams{
+	if uid, err = q.InsertUserTask(ctx, models.InsertUserTaskParams{
 		Source:        models.SourceTypeText,
 		OriginalInput: text,
 	}); err != nil {
 		return uuid.UUID{}, handlePgxErr(err)
 	}
 
+	if err = IncrementCounter(ctx, q, CounterTasks, 1); err != nil {
+		return uuid.UUID{}, err
+	}
+
 	if fn != nil {
 		if err = fn(ctx, uid); err != nil {
 			return uuid.UUID{}, err
@@ -70,3 +99,332 @@ func (t Tasks) InsertFromText(ctx context.Context, text string,
 	}
 	return uid, nil
 }
+
+// Get returns the task of the given ID. A task is usually read right after it
+// is created, so callers should mark ctx with WithFreshReads unless they can
+// tolerate replica lag.
+func (t Tasks) Get(ctx context.Context, taskID uuid.UUID) (models.UsersTask, error) {
+	task, err := t.querier(ctx, "Tasks", "Get").GetUserTask(ctx, taskID)
+	if err != nil {
+		return models.UsersTask{}, handlePgxErr(err)
+	}
+	return task, nil
+}
+
+// KnownURLs returns the URLs among urls which are already stored, either as
+// the URL of an article or as the input of a URL task. It reads the write
+// pool, the tasks created by the previous discovery pass may not have reached
+// the replica yet.
+func (t Tasks) KnownURLs(ctx context.Context, urls []string) ([]string, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	known, err := t.querier(ctx, "Tasks", "KnownURLs").ListKnownURLs(ctx, urls)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return known, nil
+}
+
+// The limits of the number of tasks ListByStatus returns.
+const (
+	DefaultTaskListLimit = 20
+	MaxTaskListLimit     = 100
+)
+
+// taskStatusFrom are the statuses a task can move to each status from. A
+// done task is final, a failed one moves to processing again when its
+// message is retried, and no task goes back to pending.
+var taskStatusFrom = map[models.TaskStatus][]models.TaskStatus{
+	models.TaskStatusProcessing: {
+		models.TaskStatusPending,
+		models.TaskStatusProcessing,
+		models.TaskStatusFailed,
+	},
+	models.TaskStatusDone: {
+		models.TaskStatusPending,
+		models.TaskStatusProcessing,
+	},
+	models.TaskStatusFailed: {
+		models.TaskStatusPending,
+		models.TaskStatusProcessing,
+		models.TaskStatusFailed,
+	},
+}
+
+// CanTransitionTask reports whether a task of status from can move to to.
+func CanTransitionTask(from, to models.TaskStatus) bool {
+	return slices.Contains(taskStatusFrom[to], from)
+}
+
+// TaskStatusInfo is the status of a task, with the error it failed with.
+type TaskStatusInfo struct {
+	Status       models.TaskStatus `json:"status"`
+	ErrorMessage string            `json:"error_message,omitempty"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// transitionTask moves the task to status, recording errMsg as the error it
+// failed with, an empty errMsg clears it. The move is guarded by the status of
+// the task in the database, see CanTransitionTask: an illegal one, e.g. of a
+// done task back to processing, fails with ErrConflict and leaves the task as
+// it is. A reopened task moves from done to processing too, see
+// TaskEvents.Reopen.
+func transitionTask(ctx context.Context, q models.Querier, taskID uuid.UUID, status models.TaskStatus,
+	errMsg string, reopen bool) error {
+	from, ok := taskStatusFrom[status]
+	if !ok {
+		return ec.ErrValidationFailed.Clone().
+			WithMessage("invalid target task status").
+			WithDetails(fmt.Sprintf("status: %s", status))
+	}
+	if reopen && status == models.TaskStatusProcessing {
+		from = append(slices.Clone(from), models.TaskStatusDone)
+	}
+
+	froms := make([]string, len(from))
+	for i, s := range from {
+		froms[i] = string(s)
+	}
+
+	n, err := q.TransitionUserTaskStatus(ctx, models.TransitionUserTaskStatusParams{
+		TaskStatus:   status,
+		ErrorMessage: pgtype.Text{String: errMsg, Valid: errMsg != ""},
+		TaskID:       taskID,
+		FromStatuses: froms,
+	})
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// nothing was updated, either the task does not exist or the move is
+	// illegal
+	row, err := q.GetUserTaskStatus(ctx, taskID)
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	return ec.ErrConflict.Clone().
+		WithMessage("illegal task status transition").
+		WithDetails(fmt.Sprintf("task_id: %s, from: %s, to: %s", taskID, row.Status, status))
+}
+
+// TaskStage is the stage of the events UpdateStatus appends, the task as a
+// whole.
+const TaskStage = "task"
+
+// UpdateStatus moves the task to status, recording errMsg as the error it
+// failed with, by appending an event of TaskStage through TaskEvents.Append:
+// the move is guarded by CanTransitionTask and the status, the snapshot and the
+// timeline of the task agree. The task takes the status derived from all its
+// stages, e.g. a task marked done while one of its stages is processing stays
+// processing.
+func (t Tasks) UpdateStatus(ctx context.Context, taskID uuid.UUID, status models.TaskStatus, errMsg string) error {
+	_, err := t.TaskEvents().Append(ctx, taskID, TaskEvent{
+		Stage:   TaskStage,
+		Status:  status,
+		Message: errMsg,
+	})
+	return err
+}
+
+// GetStatus returns the status of the task. Callers showing the progress of a
+// task right after a worker updated it should mark ctx with WithFreshReads.
+func (t Tasks) GetStatus(ctx context.Context, taskID uuid.UUID) (TaskStatusInfo, error) {
+	row, err := t.querier(ctx, "Tasks", "GetStatus").GetUserTaskStatus(ctx, taskID)
+	if err != nil {
+		return TaskStatusInfo{}, handlePgxErr(err)
+	}
+	return TaskStatusInfo{
+		Status:       row.Status,
+		ErrorMessage: row.ErrorMessage.String,
+		UpdatedAt:    row.UpdatedAt.Time,
+	}, nil
+}
+
+// ListByStatus returns up to limit tasks of status after the first offset,
+// the most recently updated first. A limit of zero or less falls back to
+// DefaultTaskListLimit, one above MaxTaskListLimit is clamped.
+func (t Tasks) ListByStatus(ctx context.Context, status models.TaskStatus, limit, offset int32) ([]models.UsersTask, error) {
+	if !status.Valid() {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("invalid task status").
+			WithDetails(fmt.Sprintf("status: %s", status))
+	}
+
+	if offset < 0 {
+		return nil, ec.ErrValidationFailed.Clone().
+			WithMessage("offset should not be negative").
+			WithDetails(fmt.Sprintf("got: %d", offset))
+	}
+
+	if limit <= 0 {
+		limit = DefaultTaskListLimit
+	}
+	limit = min(limit, MaxTaskListLimit)
+
+	tasks, err := t.querier(ctx, "Tasks", "ListByStatus").ListUserTasksByStatus(ctx, models.ListUserTasksByStatusParams{
+		TaskStatus: status,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return tasks, nil
+}
+
+const (
+	// DefaultTaskRetention is the age of the finished tasks above which they
+	// are purged.
+	DefaultTaskRetention = 180 * 24 * time.Hour
+	// DefaultTaskPurgeInterval is the interval of the purges of RunPurger, a
+	// nightly one.
+	DefaultTaskPurgeInterval = 24 * time.Hour
+	// DefaultTaskPurgeBatchSize is the number of tasks deleted per transaction.
+	DefaultTaskPurgeBatchSize = 100
+)
+
+// TaskPurgeStats are the numbers of tasks and of their articles a purge
+// deleted.
+type TaskPurgeStats struct {
+	Tasks    int64 `json:"tasks"`
+	Articles int64 `json:"articles"`
+}
+
+// Delete deletes the task. Its articles, their chunks, embeddings, keywords and
+// reviews, and the events of the task are deleted with it by the ON DELETE
+// CASCADE of their foreign keys, in the same statement.
+func (t Tasks) Delete(ctx context.Context, taskID uuid.UUID) error {
+	tx, err := t.db.Begin(ctx)
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := t.Queries.WithTx(tx)
+	n, err := q.DeleteUserTask(ctx, taskID)
+	if err != nil {
+		return handlePgxErr(err)
+	}
+
+	if n == 0 {
+		return ec.ErrNotFound.Clone().
+			WithMessage("task not found").
+			WithDetails(fmt.Sprintf("task_id: %s", taskID))
+	}
+
+	if err = IncrementCounter(ctx, q, CounterTasks, -n); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
+
+// PurgeOlderThan deletes the tasks done or failed and last updated more than
+// age ago, with everything cascading from them as Delete does. It deletes
+// batchSize tasks per transaction until none is left, so that a large purge
+// never holds the locks of all its rows at once, and returns the numbers
+// deleted, also when it fails halfway.
+func (t Tasks) PurgeOlderThan(ctx context.Context, age time.Duration, batchSize int) (TaskPurgeStats, error) {
+	if age <= 0 {
+		return TaskPurgeStats{}, ec.ErrValidationFailed.Clone().
+			WithMessage("age should be positive").
+			WithDetails(fmt.Sprintf("got: %s", age))
+	}
+
+	if batchSize <= 0 {
+		batchSize = DefaultTaskPurgeBatchSize
+	}
+
+	cutoff := t.Clock().Now().Add(-age)
+	cutoffTsz, err := utils.TimeTo.PGTimestamptz(cutoff)
+	if err != nil {
+		return TaskPurgeStats{}, ec.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", cutoff.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	var total TaskPurgeStats
+	for {
+		row, err := t.purgeBatch(ctx, models.PurgeUserTasksParams{
+			Cutoff:    cutoffTsz,
+			BatchSize: int32(batchSize),
+		})
+		if err != nil {
+			return total, err
+		}
+
+		total.Tasks += row.Tasks
+		total.Articles += row.Articles
+		if row.Tasks < int64(batchSize) {
+			return total, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// purgeBatch deletes a batch of tasks and takes them off the task counter in
+// a transaction.
+func (t Tasks) purgeBatch(ctx context.Context, arg models.PurgeUserTasksParams) (models.PurgeUserTasksRow, error) {
+	tx, err := t.db.Begin(ctx)
+	if err != nil {
+		return models.PurgeUserTasksRow{}, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := t.Queries.WithTx(tx)
+	row, err := q.PurgeUserTasks(ctx, arg)
+	if err != nil {
+		return models.PurgeUserTasksRow{}, handlePgxErr(err)
+	}
+
+	if row.Tasks > 0 {
+		if err = IncrementCounter(ctx, q, CounterTasks, -row.Tasks); err != nil {
+			return models.PurgeUserTasksRow{}, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return models.PurgeUserTasksRow{}, handlePgxErr(err)
+	}
+	return row, nil
+}
+
+// RunPurger purges the tasks finished more than retention ago every interval,
+// e.g. DefaultTaskPurgeInterval for a nightly purge, until ctx is cancelled.
+func (t Tasks) RunPurger(ctx context.Context, interval, retention time.Duration, batchSize int) {
+	ticker := t.Clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := t.PurgeOlderThan(ctx, retention, batchSize)
+		if err != nil {
+			global.Logger.Error().Err(err).
+				Int64("tasks", n.Tasks).
+				Int64("articles", n.Articles).
+				Msg("Failed to purge tasks")
+		} else if n.Tasks > 0 {
+			global.Logger.Info().
+				Int64("tasks", n.Tasks).
+				Int64("articles", n.Articles).
+				Msg("Purged tasks")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
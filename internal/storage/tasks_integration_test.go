@@ -0,0 +1,238 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// appendStage appends stage moving to status to the timeline of the task.
+func appendStage(ctx context.Context, s storage.Storage, taskID uuid.UUID, stage string,
+	status models.TaskStatus, msg string) error {
+	_, err := s.TaskEvents().Append(ctx, taskID, storage.TaskEvent{Stage: stage, Status: status, Message: msg})
+	return err
+}
+
+func TestTasksStatusLifecycle(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	taskID, err := s.Task().InsertFromText(ctx, "lifecycle "+uuid.NewString(), nil)
+	require.NoError(t, err)
+
+	status, err := s.Task().GetStatus(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusPending, status.Status)
+
+	require.NoError(t, appendStage(ctx, s, taskID, "scrape", models.TaskStatusProcessing, ""))
+	require.NoError(t, appendStage(ctx, s, taskID, "scrape", models.TaskStatusFailed, "fetch timed out"))
+	status, err = s.Task().GetStatus(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusFailed, status.Status)
+	require.Equal(t, "fetch timed out", status.ErrorMessage)
+
+	failed, err := s.Task().ListByStatus(ctx, models.TaskStatusFailed, 100, 0)
+	require.NoError(t, err)
+	require.Contains(t, taskIDs(failed), taskID)
+
+	// a retry clears the error, and the task is processing until its last
+	// stage is done
+	require.NoError(t, appendStage(ctx, s, taskID, "scrape", models.TaskStatusProcessing, ""))
+	require.NoError(t, appendStage(ctx, s, taskID, "scrape", models.TaskStatusDone, ""))
+	require.NoError(t, appendStage(ctx, s, taskID, "extract_keywords", models.TaskStatusPending, ""))
+	status, err = s.Task().GetStatus(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusProcessing, status.Status)
+	require.Empty(t, status.ErrorMessage)
+
+	require.NoError(t, appendStage(ctx, s, taskID, "extract_keywords", models.TaskStatusDone, ""))
+	status, err = s.Task().GetStatus(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusDone, status.Status)
+
+	// a done task is final, the event is not appended
+	requireConflict(t, appendStage(ctx, s, taskID, "scrape", models.TaskStatusProcessing, ""))
+	status, err = s.Task().GetStatus(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusDone, status.Status)
+	timeline, err := s.TaskEvents().Timeline(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusDone, timeline.Status)
+	require.Len(t, timeline.Events, 6)
+
+	err = appendStage(ctx, s, uuid.New(), "scrape", models.TaskStatusProcessing, "")
+	var e *ec.Error
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, ec.ErrNotFound.HttpStatusCode, e.HttpStatusCode)
+
+	done, err := s.Task().ListByStatus(ctx, models.TaskStatusDone, 1, 0)
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+	require.Equal(t, taskID, done[0].TaskID)
+}
+
+func TestTasksUpdateStatus(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	taskID, err := s.Task().InsertFromText(ctx, "update status "+uuid.NewString(), nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Task().UpdateStatus(ctx, taskID, models.TaskStatusProcessing, ""))
+	require.NoError(t, s.Task().UpdateStatus(ctx, taskID, models.TaskStatusFailed, "malformed message"))
+	status, err := s.Task().GetStatus(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusFailed, status.Status)
+	require.Equal(t, "malformed message", status.ErrorMessage)
+
+	// the move is recorded in the timeline and the snapshot of the task
+	timeline, err := s.TaskEvents().Timeline(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusFailed, timeline.Status)
+	require.Len(t, timeline.Events, 2)
+	require.Equal(t, storage.TaskStage, timeline.Events[1].Stage)
+
+	// a failed task is retried before it is done
+	requireConflict(t, s.Task().UpdateStatus(ctx, taskID, models.TaskStatusDone, ""))
+	require.NoError(t, s.Task().UpdateStatus(ctx, taskID, models.TaskStatusProcessing, ""))
+	require.NoError(t, s.Task().UpdateStatus(ctx, taskID, models.TaskStatusDone, ""))
+	state, err := s.TaskEvents().State(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusDone, state.Status)
+
+	// a done task is final
+	requireConflict(t, s.Task().UpdateStatus(ctx, taskID, models.TaskStatusProcessing, ""))
+	status, err = s.Task().GetStatus(ctx, taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusDone, status.Status)
+}
+
+// newTaskWithArticle inserts a task with an article of a chunk and its
+// embedding, and returns the task and the article.
+func newTaskWithArticle(t *testing.T, s storage.Storage, status models.TaskStatus) (uuid.UUID, int32) {
+	t.Helper()
+	ctx := context.Background()
+
+	items := newEmbeddingItems(t, s, 1)
+	_, err := s.UserEmbeddings().BatchInsert(ctx, items)
+	require.NoError(t, err)
+
+	article, err := s.UserArticles().GetByID(ctx, items[0].ArticleID)
+	require.NoError(t, err)
+	if status != models.TaskStatusPending {
+		require.NoError(t, appendStage(ctx, s, article.TaskID, "scrape", status, ""))
+	}
+	return article.TaskID, article.ID
+}
+
+func TestTasksDelete(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	taskID, aID := newTaskWithArticle(t, s, models.TaskStatusDone)
+	_, err := s.TaskEvents().Append(ctx, taskID, storage.TaskEvent{
+		Stage:  "scrape",
+		Status: models.TaskStatusDone,
+	})
+	require.NoError(t, err)
+
+	_, err = s.Counters().Reconcile(ctx, storage.DefaultCounterDriftThreshold)
+	require.NoError(t, err)
+	before := counterValue(t, s, storage.CounterTasks)
+
+	require.NoError(t, s.Task().Delete(ctx, taskID))
+	require.Equal(t, before-1, counterValue(t, s, storage.CounterTasks))
+
+	_, err = s.Task().Get(storage.WithFreshReads(ctx), taskID)
+	var e *ec.Error
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, ec.ErrNotFound.HttpStatusCode, e.HttpStatusCode)
+	for _, table := range []string{"users.articles", "users.chunks", "users.embeddings"} {
+		column := "article_id"
+		if table == "users.articles" {
+			column = "id"
+		}
+		require.Zero(t, countRows(t, pool, table, column, aID), table)
+	}
+
+	var events int
+	require.NoError(t, pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM users.task_events WHERE task_id = $1", taskID).Scan(&events))
+	require.Zero(t, events)
+
+	err = s.Task().Delete(ctx, taskID)
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, ec.ErrNotFound.HttpStatusCode, e.HttpStatusCode)
+}
+
+func TestTasksPurgeOlderThan(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	age := func(taskID uuid.UUID, d time.Duration) {
+		_, err := pool.Exec(ctx, "UPDATE users.tasks SET updated_at = $1 WHERE task_id = $2",
+			time.Now().Add(-d), taskID)
+		require.NoError(t, err)
+	}
+
+	old := 2 * storage.DefaultTaskRetention
+	var purged []int32
+	for _, status := range []models.TaskStatus{models.TaskStatusDone, models.TaskStatusFailed, models.TaskStatusDone} {
+		taskID, aID := newTaskWithArticle(t, s, status)
+		age(taskID, old)
+		purged = append(purged, aID)
+	}
+	pendingID, pendingArticle := newTaskWithArticle(t, s, models.TaskStatusPending)
+	age(pendingID, old)
+	_, recentArticle := newTaskWithArticle(t, s, models.TaskStatusDone)
+
+	_, err := s.Counters().Reconcile(ctx, storage.DefaultCounterDriftThreshold)
+	require.NoError(t, err)
+	before := counterValue(t, s, storage.CounterTasks)
+
+	_, err = s.Task().PurgeOlderThan(ctx, 0, 2)
+	require.Error(t, err)
+
+	// a small batch size makes the purge run several transactions
+	n, err := s.Task().PurgeOlderThan(ctx, storage.DefaultTaskRetention, 2)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, n.Tasks, int64(3))
+	require.GreaterOrEqual(t, n.Articles, int64(3))
+	require.Equal(t, before-n.Tasks, counterValue(t, s, storage.CounterTasks))
+
+	for _, aID := range purged {
+		require.Zero(t, countRows(t, pool, "users.articles", "id", aID))
+		require.Zero(t, countRows(t, pool, "users.embeddings", "article_id", aID))
+	}
+	// the unfinished and the recent tasks are kept
+	require.Equal(t, 1, countRows(t, pool, "users.articles", "id", pendingArticle))
+	require.Equal(t, 1, countRows(t, pool, "users.articles", "id", recentArticle))
+
+	n, err = s.Task().PurgeOlderThan(ctx, storage.DefaultTaskRetention, 2)
+	require.NoError(t, err)
+	require.Zero(t, n.Tasks)
+}
+
+func taskIDs(tasks []models.UsersTask) []uuid.UUID {
+	ids := make([]uuid.UUID, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.TaskID
+	}
+	return ids
+}
@@ -0,0 +1,52 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanTransitionTask(t *testing.T) {
+	tcs := []struct {
+		from, to models.TaskStatus
+		want     bool
+	}{
+		{models.TaskStatusPending, models.TaskStatusProcessing, true},
+		{models.TaskStatusProcessing, models.TaskStatusProcessing, true},
+		{models.TaskStatusProcessing, models.TaskStatusDone, true},
+		{models.TaskStatusProcessing, models.TaskStatusFailed, true},
+		{models.TaskStatusFailed, models.TaskStatusProcessing, true},
+		{models.TaskStatusDone, models.TaskStatusProcessing, false},
+		{models.TaskStatusDone, models.TaskStatusFailed, false},
+		{models.TaskStatusDone, models.TaskStatusDone, false},
+		{models.TaskStatusFailed, models.TaskStatusDone, false},
+		{models.TaskStatusProcessing, models.TaskStatusPending, false},
+	}
+
+	for _, tc := range tcs {
+		require.Equalf(t, tc.want, storage.CanTransitionTask(tc.from, tc.to), "%s to %s", tc.from, tc.to)
+	}
+}
+
+func TestTasksStatusValidation(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{}
+	tasks := storage.New(db, nil).Task()
+
+	_, err := storage.New(db, nil).TaskEvents().Append(ctx, uuid.New(), storage.TaskEvent{
+		Stage:  "scrape",
+		Status: "unknown",
+	})
+	require.Error(t, err)
+	_, err = tasks.ListByStatus(ctx, "unknown", 10, 0)
+	require.Error(t, err)
+	_, err = tasks.ListByStatus(ctx, models.TaskStatusDone, 10, -1)
+	require.Error(t, err)
+	_, err = tasks.PurgeOlderThan(ctx, 0, 10)
+	require.Error(t, err)
+	require.Zero(t, db.calls)
+}
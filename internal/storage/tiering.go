@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// DefaultHotRetention is the age of the articles whose embeddings are kept
+	// in the hot tier.
+	DefaultHotRetention = 90 * 24 * time.Hour
+	// DefaultArchiveBatchSize is the number of embeddings moved per transaction.
+	DefaultArchiveBatchSize = 500
+	// DefaultArchiveSearchWindow is the publishing date range searched in the
+	// archive if the search options do not specify one.
+	DefaultArchiveSearchWindow = 365 * 24 * time.Hour
+	// DefaultArchiveMaxCandidates is the number of archived vectors re-scored
+	// per search, at most MaxArchiveMaxCandidates.
+	DefaultArchiveMaxCandidates = 5_000
+	MaxArchiveMaxCandidates     = 50_000
+
+	// hotOverfetch is the number of chunks fetched from the hot tier per
+	// article requested, since an article may match with several chunks.
+	hotOverfetch = 4
+)
+
+func (s Storage) Tiering() Tiering {
+	return Tiering{Storage: s}
+}
+
+// Tiering moves the embeddings between the hot tier (the embeddings table,
+// covered by the HNSW index) and the cold tier (the embeddings_archive table,
+// with float16 quantized vectors).
+type Tiering struct {
+	Storage
+	// afterCopy, if set, is called inside the archive transaction after a
+	// batch has been copied to the archive and before it is deleted from the
+	// hot tier. It is used in tests to simulate a crash mid-batch.
+	afterCopy func(batch int) error
+}
+
+// ArchiveOlderThan moves the embeddings of the articles published before
+// cutoff to the archive, batchSize rows per transaction, and returns the
+// number of rows moved. Each batch is copied and deleted in the same
+// transaction, so a failure leaves the batch in the hot tier.
+func (t Tiering) ArchiveOlderThan(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultArchiveBatchSize
+	}
+
+	tsz, err := utils.TimeTo.PGTimestamptz(cutoff)
+	if err != nil {
+		return 0, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", cutoff.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	var total int64
+	for batch := 0; ; batch++ {
+		n, err := t.archiveBatch(ctx, tsz, int32(batchSize), batch)
+		total += n
+		if err != nil {
+			return total, err
+		}
+
+		if n < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+func (t Tiering) archiveBatch(ctx context.Context, cutoff pgtype.Timestamptz, batchSize int32, batch int) (int64, error) {
+	tx, err := t.db.Begin(ctx)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := t.Queries.WithTx(tx)
+	rows, err := q.ListEmbeddingsToArchive(ctx, models.ListEmbeddingsToArchiveParams{
+		Cutoff:    cutoff,
+		BatchSize: batchSize,
+	})
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	params := models.InsertEmbeddingsArchiveParams{
+		Ids:        make([]int32, len(rows)),
+		ArticleIds: make([]int32, len(rows)),
+		ChunkIds:   make([]int32, len(rows)),
+		ModelIds:   make([]int32, len(rows)),
+		Dims:       make([]int32, len(rows)),
+		Vectors:    make([][]byte, len(rows)),
+		CreatedAts: make([]pgtype.Timestamptz, len(rows)),
+		WasSplits:  make([]bool, len(rows)),
+		SubCounts:  make([]int32, len(rows)),
+	}
+	for i, row := range rows {
+		vec := row.Vector.Slice()
+		params.Ids[i] = row.ID
+		params.ArticleIds[i] = row.ArticleID
+		params.ChunkIds[i] = row.ChunkID
+		params.ModelIds[i] = row.ModelID
+		params.Dims[i] = int32(len(vec))
+		params.Vectors[i] = utils.PackFloat16(vec)
+		params.CreatedAts[i] = row.CreatedAt
+		params.WasSplits[i] = row.WasSplit
+		params.SubCounts[i] = row.SubCount
+	}
+
+	inserted, err := q.InsertEmbeddingsArchive(ctx, params)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	if inserted != int64(len(rows)) {
+		return 0, errors.ErrDBError.Clone().
+			WithMessage("failed to copy embeddings to the archive").
+			WithDetails(fmt.Sprintf("expected: %d, inserted: %d", len(rows), inserted))
+	}
+
+	if t.afterCopy != nil {
+		if err := t.afterCopy(batch); err != nil {
+			return 0, err
+		}
+	}
+
+	deleted, err := q.DeleteEmbeddingsByIDs(ctx, params.Ids)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	if deleted != int64(len(rows)) {
+		return 0, errors.ErrDBError.Clone().
+			WithMessage("failed to delete archived embeddings from the hot tier").
+			WithDetails(fmt.Sprintf("expected: %d, deleted: %d", len(rows), deleted))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, handlePgxErr(err)
+	}
+	return deleted, nil
+}
+
+// RestoreToHot moves the archived embeddings of the given articles back to the
+// hot tier and returns the number of rows moved. The restored vectors carry
+// the float16 quantization error.
+func (t Tiering) RestoreToHot(ctx context.Context, articleIDs []int32) (int64, error) {
+	if len(articleIDs) == 0 {
+		return 0, nil
+	}
+
+	tx, err := t.db.Begin(ctx)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := t.Queries.WithTx(tx)
+	rows, err := q.ListArchivedEmbeddingsByArticleIDs(ctx, articleIDs)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	params := models.RestoreEmbeddingsParams{
+		Ids:        make([]int32, len(rows)),
+		ArticleIds: make([]int32, len(rows)),
+		ChunkIds:   make([]int32, len(rows)),
+		ModelIds:   make([]int32, len(rows)),
+		Vectors:    make([]string, len(rows)),
+		CreatedAts: make([]pgtype.Timestamptz, len(rows)),
+		WasSplits:  make([]bool, len(rows)),
+		SubCounts:  make([]int32, len(rows)),
+	}
+	for i, row := range rows {
+		vec, err := utils.UnpackFloat16(row.Vector)
+		if err != nil {
+			return 0, errors.ErrDBTypeConversionError.Clone().
+				WithMessage("failed to unpack archived embedding").
+				WithDetails(fmt.Sprintf("embedding ID: %d", row.ID)).
+				Warp(err)
+		}
+
+		params.Ids[i] = row.ID
+		params.ArticleIds[i] = row.ArticleID
+		params.ChunkIds[i] = row.ChunkID
+		params.ModelIds[i] = row.ModelID
+		params.Vectors[i] = utils.ToPgVector(vec).String()
+		params.CreatedAts[i] = row.CreatedAt
+		params.WasSplits[i] = row.WasSplit
+		params.SubCounts[i] = row.SubCount
+	}
+
+	restored, err := q.RestoreEmbeddings(ctx, params)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	deleted, err := q.DeleteArchivedEmbeddingsByIDs(ctx, params.Ids)
+	if err != nil {
+		return 0, handlePgxErr(err)
+	}
+
+	if restored != int64(len(rows)) || deleted != int64(len(rows)) {
+		return 0, errors.ErrDBError.Clone().
+			WithMessage("failed to restore archived embeddings").
+			WithDetails(fmt.Sprintf("expected: %d, restored: %d, deleted: %d",
+				len(rows), restored, deleted))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, handlePgxErr(err)
+	}
+	return restored, nil
+}
+
+// TieredSearchOptions controls whether and how the archive is searched. The
+// archive pass re-scores the candidates published within [Start, End] and,
+// if Party is set, by the given party; zero values fall back to the defaults.
+type TieredSearchOptions struct {
+	IncludeArchive bool
+	Start          time.Time
+	End            time.Time
+	Party          models.Party
+	MaxCandidates  int
+}
+
+// ScoredArticle is an article matched by a tiered search, scored by the cosine
+// similarity of its best matching chunk.
+type ScoredArticle struct {
+	ArticleID  int32   `json:"article_id"`
+	ChunkID    int32   `json:"chunk_id,omitempty"`
+	Similarity float64 `json:"similarity"`
+	Archived   bool    `json:"archived"`
+}
+
+// Search returns the k articles nearest to query under the given model, the
+// length of query must be the dimension of the model. The hot tier is searched
+// through the vector index of the model; if opts.IncludeArchive is set, the
+// archived vectors of a bounded candidate set are decompressed and scored
+// exactly, and both result sets are merged.
+func (t Tiering) Search(ctx context.Context, query []float32, modelID int32, k int,
+	opts TieredSearchOptions) ([]ScoredArticle, error) {
+	if k <= 0 {
+		k = DefaultSimilarK
+	}
+	k = min(k, MaxSimilarK)
+
+	q, err := t.withModelDim(ctx, "Tiering", "Search", modelID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	hot, err := q.GetKNNEmbeddingsByCosineSimilarity(ctx,
+		models.GetKNNEmbeddingsByCosineSimilarityParams{
+			Query:   utils.ToPgVector(query),
+			ModelID: modelID,
+			K:       int32(k * hotOverfetch),
+		})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	best := map[int32]ScoredArticle{}
+	for _, row := range hot {
+		// the query returns the cosine distance
+		mergeScore(best, ScoredArticle{
+			ArticleID:  row.ArticleID,
+			Similarity: 1 - row.Similarity,
+		})
+	}
+
+	if opts.IncludeArchive {
+		archived, err := t.searchArchive(ctx, query, modelID, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, a := range archived {
+			mergeScore(best, a)
+		}
+	}
+
+	articles := make([]ScoredArticle, 0, len(best))
+	for _, a := range best {
+		articles = append(articles, a)
+	}
+	slices.SortFunc(articles, func(a, b ScoredArticle) int {
+		if c := cmp.Compare(b.Similarity, a.Similarity); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ArticleID, b.ArticleID)
+	})
+	return articles[:min(k, len(articles))], nil
+}
+
+func (t Tiering) searchArchive(ctx context.Context, query []float32, modelID int32,
+	opts TieredSearchOptions) ([]ScoredArticle, error) {
+	if opts.End.IsZero() {
+		opts.End = t.Clock().Now()
+	}
+
+	if opts.Start.IsZero() {
+		opts.Start = opts.End.Add(-DefaultArchiveSearchWindow)
+	}
+
+	if opts.MaxCandidates <= 0 {
+		opts.MaxCandidates = DefaultArchiveMaxCandidates
+	}
+	opts.MaxCandidates = min(opts.MaxCandidates, MaxArchiveMaxCandidates)
+
+	start, err := utils.TimeTo.PGTimestamptz(opts.Start)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert start time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("start time: %v", opts.Start.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	end, err := utils.TimeTo.PGTimestamptz(opts.End)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert end time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("end time: %v", opts.End.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	rows, err := t.querier(ctx, "Tiering", "Search").ListArchivedEmbeddingCandidates(ctx,
+		models.ListArchivedEmbeddingCandidatesParams{
+			ModelID:       modelID,
+			Start:         start,
+			End:           end,
+			Party:         models.NullParty{Party: opts.Party, Valid: opts.Party != ""},
+			MaxCandidates: int32(opts.MaxCandidates),
+		})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	scored := make([]ScoredArticle, 0, len(rows))
+	for _, row := range rows {
+		vec, err := utils.UnpackFloat16(row.Vector)
+		if err != nil {
+			global.Logger.Warn().
+				Err(err).
+				Int32("embedding_id", row.ID).
+				Msg("Skipping malformed archived embedding")
+			continue
+		}
+
+		scored = append(scored, ScoredArticle{
+			ArticleID:  row.ArticleID,
+			ChunkID:    row.ChunkID,
+			Similarity: utils.CosineSimilarity(query, vec),
+			Archived:   true,
+		})
+	}
+	return scored, nil
+}
+
+func mergeScore(best map[int32]ScoredArticle, a ScoredArticle) {
+	if b, ok := best[a.ArticleID]; !ok || a.Similarity > b.Similarity {
+		best[a.ArticleID] = a
+	}
+}
+
+// RunArchiver moves the embeddings of the articles older than retention to the
+// archive every interval, until ctx is cancelled.
+func (t Tiering) RunArchiver(ctx context.Context, interval, retention time.Duration, batchSize int) {
+	ticker := t.Clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := t.ArchiveOlderThan(ctx, t.Clock().Now().Add(-retention), batchSize)
+		if err != nil {
+			global.Logger.Error().Err(err).Int64("moved", n).Msg("Failed to archive embeddings")
+		} else if n > 0 {
+			global.Logger.Info().Int64("moved", n).Msg("Archived embeddings")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
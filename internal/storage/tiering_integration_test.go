@@ -0,0 +1,188 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+// tieringFixture inserts n articles published at publishedAt, each with a
+// single chunk embedded under modelID, and returns the article IDs and their
+// vectors.
+func tieringFixture(t *testing.T, s storage.Storage, modelID int32, n int,
+	publishedAt time.Time) ([]int32, [][]float32) {
+	t.Helper()
+	ctx := context.Background()
+
+	ids := make([]int32, n)
+	vecs := make([][]float32, n)
+	for i := range n {
+		title := fmt.Sprintf("tiering %s", uuid.NewString())
+		aID, err := storage.Article{Storage: s}.Insert(ctx, "https://example.com/"+title,
+			title, "test", uuid.NewString(), "content", nil, publishedAt, time.Time{})
+		require.NoError(t, err)
+
+		cID, err := s.Queries.InsertChunk(ctx, models.InsertChunkParams{
+			ArticleID:   aID,
+			Start:       0,
+			OffsetLeft:  0,
+			OffsetRight: 7,
+			End:         7,
+		})
+		require.NoError(t, err)
+
+		vec, err := utils.RandomPGVector(1024, 1.0, -1.0)
+		require.NoError(t, err)
+		_, err = s.Queries.InsertEmbedding(ctx, models.InsertEmbeddingParams{
+			ArticleID: aID,
+			ChunkID:   cID,
+			ModelID:   modelID,
+			Vector:    vec,
+		})
+		require.NoError(t, err)
+		ids[i], vecs[i] = aID, vec.Slice()
+	}
+	return ids, vecs
+}
+
+func countEmbeddings(t *testing.T, pool *pgxpool.Pool, modelID int32) (hot, archived int64) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM embeddings WHERE model_id = $1`, modelID).Scan(&hot))
+	require.NoError(t, pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM embeddings_archive WHERE model_id = $1`, modelID).Scan(&archived))
+
+	var both int64
+	require.NoError(t, pool.QueryRow(ctx, `
+SELECT COUNT(*) FROM embeddings AS e
+JOIN embeddings_archive AS ea ON ea.id = e.id
+WHERE e.model_id = $1`, modelID).Scan(&both))
+	require.Zero(t, both, "embeddings should not be in both tiers")
+	return hot, archived
+}
+
+func TestTieringArchiveCrashMidBatch(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	modelID, err := s.Models().Insert(ctx, "tiering-"+uuid.NewString(), 1024)
+	require.NoError(t, err)
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, _ = tieringFixture(t, s, modelID, 10, old)
+
+	crash := errors.New("simulated crash")
+	tiering := s.Tiering().WithAfterCopy(func(batch int) error {
+		if batch == 1 {
+			return crash
+		}
+		return nil
+	})
+
+	moved, err := tiering.ArchiveOlderThan(ctx, old.AddDate(0, 0, 1), 4)
+	require.ErrorIs(t, err, crash)
+	require.Equal(t, int64(4), moved)
+
+	hot, archived := countEmbeddings(t, pool, modelID)
+	require.Equal(t, int64(6), hot)
+	require.Equal(t, int64(4), archived)
+
+	// resuming moves the rest
+	moved, err = s.Tiering().ArchiveOlderThan(ctx, old.AddDate(0, 0, 1), 4)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, moved, int64(6))
+
+	hot, archived = countEmbeddings(t, pool, modelID)
+	require.Equal(t, int64(0), hot)
+	require.Equal(t, int64(10), archived)
+}
+
+func TestTieringSearch(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	modelID, err := s.Models().Insert(ctx, "tiering-"+uuid.NewString(), 1024)
+	require.NoError(t, err)
+
+	now := time.Now()
+	old := now.AddDate(0, -6, 0)
+	recentIDs, recentVecs := tieringFixture(t, s, modelID, 5, now.AddDate(0, 0, -1))
+	oldIDs, oldVecs := tieringFixture(t, s, modelID, 5, old)
+	isOld := map[int32]bool{}
+	for _, id := range oldIDs {
+		isOld[id] = true
+	}
+
+	// the embedding of a split chunk keeps its split through the round trip
+	_, err = pool.Exec(ctx, `UPDATE embeddings SET was_split = TRUE, sub_count = 3
+WHERE article_id = $1`, oldIDs[2])
+	require.NoError(t, err)
+	requireSplit := func(table string) {
+		t.Helper()
+		var wasSplit bool
+		var subCount int32
+		require.NoError(t, pool.QueryRow(ctx, `SELECT was_split, sub_count FROM `+table+`
+WHERE article_id = $1`, oldIDs[2]).Scan(&wasSplit, &subCount))
+		require.True(t, wasSplit, table)
+		require.Equal(t, int32(3), subCount, table)
+	}
+
+	query := recentVecs[0]
+	before, err := s.Tiering().Search(ctx, query, modelID, 10, storage.TieredSearchOptions{})
+	require.NoError(t, err)
+	require.Equal(t, recentIDs[0], before[0].ArticleID)
+
+	_, err = s.Tiering().ArchiveOlderThan(ctx, now.Add(-storage.DefaultHotRetention), 3)
+	require.NoError(t, err)
+
+	// hot-only results are the recent articles of the previous results, in
+	// the same order and with the same scores
+	after, err := s.Tiering().Search(ctx, query, modelID, 10, storage.TieredSearchOptions{})
+	require.NoError(t, err)
+	want := []storage.ScoredArticle{}
+	for _, a := range before {
+		if !isOld[a.ArticleID] {
+			want = append(want, a)
+		}
+	}
+	require.Equal(t, want, after)
+
+	// the archive pass finds the old article matching the query exactly, up
+	// to the quantization error
+	tiered, err := s.Tiering().Search(ctx, oldVecs[2], modelID, 3, storage.TieredSearchOptions{
+		IncludeArchive: true,
+		Start:          old.AddDate(0, 0, -1),
+		End:            now,
+	})
+	require.NoError(t, err)
+	require.Equal(t, oldIDs[2], tiered[0].ArticleID)
+	require.True(t, tiered[0].Archived)
+	require.InDelta(t, 1.0, tiered[0].Similarity, 1e-3)
+	requireSplit("embeddings_archive")
+
+	// restored articles are searched in the hot tier again
+	restored, err := s.Tiering().RestoreToHot(ctx, oldIDs[2:3])
+	require.NoError(t, err)
+	require.Equal(t, int64(1), restored)
+	requireSplit("embeddings")
+
+	hot, err := s.Tiering().Search(ctx, oldVecs[2], modelID, 1, storage.TieredSearchOptions{})
+	require.NoError(t, err)
+	require.Equal(t, oldIDs[2], hot[0].ArticleID)
+	require.False(t, hot[0].Archived)
+}
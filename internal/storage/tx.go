@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// WithTx calls fn with a copy of the storage bound to a transaction, so that
+// the inserts of the accessor methods fn calls, e.g. of an article, its chunks
+// and their embeddings, are committed together or not at all. The transaction
+// is committed if fn returns nil and rolled back otherwise.
+//
+// The methods opening a transaction of their own open a savepoint of it
+// instead: a failing one rolls back its own statements, the transaction goes
+// on. The reads run on the transaction too, not on the read pool, so that they
+// see the writes of fn.
+func (s Storage) WithTx(ctx context.Context, fn func(tx Storage) error) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	db := txDB{Tx: tx}
+	txs := s
+	txs.Queries = models.New(db)
+	txs.db = db
+	txs.reader = nil
+	if err := fn(txs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
+
+// txDB runs the queries of WithTx on its transaction, the nested transactions
+// are savepoints of it.
+type txDB struct {
+	pgx.Tx
+}
+
+// BeginTx opens a savepoint, the options of the transaction cannot be changed
+// once it has started and are those of the outer one.
+func (db txDB) BeginTx(ctx context.Context, _ pgx.TxOptions) (pgx.Tx, error) {
+	return db.Tx.Begin(ctx)
+}
@@ -0,0 +1,86 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTxArticleChunksEmbeddings(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	modelID, err := s.Models().Insert(ctx, "tx-"+uuid.NewString(), 1024)
+	require.NoError(t, err)
+	paragraphs := []string{strings.Repeat("新北三峽發生重大車禍。", 40), strings.Repeat("交通部宣布下修高齡換照年齡。", 40)}
+	content := strings.Join(paragraphs, "")
+
+	// insert runs the inserts of an article, its chunks and their embeddings
+	// in a transaction, the embedding of the chunk failAt has the wrong length.
+	insert := func(failAt int) (int32, error) {
+		taskID, err := s.Task().InsertFromText(ctx, "tx "+uuid.NewString(), nil)
+		require.NoError(t, err)
+
+		var aID int32
+		err = s.WithTx(ctx, func(tx storage.Storage) error {
+			id, err := tx.UserArticles().Insert(ctx, taskID, "tx "+uuid.NewString(), "test",
+				content, nil, time.Now(), time.Time{}, nil)
+			if err != nil {
+				return err
+			}
+			aID = id
+
+			offsets, err := tx.UserChunks().BatchInsert(ctx, aID, paragraphs, 128, 16)
+			if err != nil {
+				return err
+			}
+			require.Greater(t, len(offsets), failAt)
+
+			for i, o := range offsets {
+				vec := axisVector(i, 0)
+				if i == failAt {
+					vec = vec[:3]
+				}
+				if _, err := tx.UserEmbeddings().Insert(ctx, aID, o.ID, modelID, vec); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return aID, err
+	}
+
+	aID, err := insert(-1)
+	require.NoError(t, err)
+	require.Equal(t, 1, countRows(t, pool, "users.articles", "id", aID))
+	require.Positive(t, countRows(t, pool, "users.chunks", "article_id", aID))
+	require.Equal(t, countRows(t, pool, "users.chunks", "article_id", aID),
+		countRows(t, pool, "users.embeddings", "article_id", aID))
+
+	// a failure in the middle of the embeddings leaves no orphaned article
+	aID, err = insert(2)
+	require.Error(t, err)
+	require.NotZero(t, aID)
+	require.Zero(t, countRows(t, pool, "users.articles", "id", aID))
+	require.Zero(t, countRows(t, pool, "users.chunks", "article_id", aID))
+	require.Zero(t, countRows(t, pool, "users.embeddings", "article_id", aID))
+}
+
+// countRows returns the number of rows of table whose column is id.
+func countRows(t testing.TB, pool *pgxpool.Pool, table, column string, id int32) int {
+	t.Helper()
+	var n int
+	require.NoError(t, pool.QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM "+table+" WHERE "+column+" = $1", id).Scan(&n))
+	return n
+}
@@ -0,0 +1,92 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// txFakeDB begins savepointTx transactions, the other queries go to fakeDB.
+type txFakeDB struct {
+	fakeDB
+	tx *savepointTx
+}
+
+func (db *txFakeDB) Begin(context.Context) (pgx.Tx, error) {
+	db.tx = &savepointTx{}
+	return db.tx, nil
+}
+
+// savepointTx is a fakeTx recording the savepoints opened on it and whether
+// it was rolled back.
+type savepointTx struct {
+	fakeTx
+	savepoints []*savepointTx
+	rolledBack bool
+}
+
+func (tx *savepointTx) Begin(context.Context) (pgx.Tx, error) {
+	sp := &savepointTx{}
+	tx.savepoints = append(tx.savepoints, sp)
+	return sp, nil
+}
+
+func (tx *savepointTx) Rollback(context.Context) error {
+	tx.rolledBack = !tx.committed
+	return nil
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("commit", func(t *testing.T) {
+		reader := &fakeDB{}
+		db := &txFakeDB{}
+		s := storage.New(db, nil, storage.WithReadDB(reader))
+
+		err := s.WithTx(ctx, func(tx storage.Storage) error {
+			_, _ = tx.Models().GetByID(ctx, 1)
+			return nil
+		})
+		require.NoError(t, err)
+		require.True(t, db.tx.committed)
+		// the reads run on the transaction, not on the read pool
+		require.Len(t, db.tx.stmts, 1)
+		require.Zero(t, reader.calls)
+		require.Zero(t, db.calls)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		db := &txFakeDB{}
+		s := storage.New(db, nil)
+
+		err := s.WithTx(ctx, func(tx storage.Storage) error {
+			_, err := tx.Models().Insert(ctx, "model", 1024)
+			return err
+		})
+		require.Error(t, err)
+		require.False(t, db.tx.committed)
+		require.True(t, db.tx.rolledBack)
+	})
+
+	t.Run("savepoint", func(t *testing.T) {
+		db := &txFakeDB{}
+		s := storage.New(db, nil)
+
+		// a method opening a transaction opens a savepoint, its failure does
+		// not roll back the outer transaction
+		err := s.WithTx(ctx, func(tx storage.Storage) error {
+			_, err := tx.Task().InsertFromURL(ctx, "https://example.com", nil)
+			require.Error(t, err)
+			return nil
+		})
+		require.NoError(t, err)
+		require.True(t, db.tx.committed)
+		require.Len(t, db.tx.savepoints, 1)
+		require.True(t, db.tx.savepoints[0].rolledBack)
+		require.Zero(t, db.calls)
+	})
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// RevisionFieldURL is the field name of the revisions made to article URLs.
+const RevisionFieldURL = "url"
+
+func (s Storage) URLStatus() URLStatus {
+	return URLStatus{s}
+}
+
+// URLStatus provides methods to record the liveness of the article URLs.
+type URLStatus struct {
+	Storage
+}
+
+// DueForCheck returns up to limit articles whose URLs have never been checked
+// or have been checked least recently.
+func (u URLStatus) DueForCheck(ctx context.Context, limit int32) ([]models.ListArticlesDueForCheckRow, error) {
+	rows, err := u.querier(ctx, "URLStatus", "DueForCheck").ListArticlesDueForCheck(ctx, limit)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
+
+// RecordCheck records the outcome of a check. A zero statusCode means no
+// response has been received.
+func (u URLStatus) RecordCheck(ctx context.Context, articleID int32, statusCode int,
+	consecutiveFailures int32, dead bool) error {
+	if err := u.Queries.UpsertURLStatus(ctx, models.UpsertURLStatusParams{
+		ArticleID: articleID,
+		LastStatusCode: pgtype.Int4{
+			Int32: int32(statusCode),
+			Valid: statusCode != 0,
+		},
+		ConsecutiveFailures: consecutiveFailures,
+		Dead:                dead,
+	}); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
+
+// MoveURL replaces the URL of an article and records a revision note in the
+// same transaction.
+func (u URLStatus) MoveURL(ctx context.Context, articleID int32, oldURL, newURL, note string) error {
+	tx, err := u.db.Begin(ctx)
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	q := u.Queries.WithTx(tx)
+	if err := q.UpdateArticleURL(ctx, models.UpdateArticleURLParams{
+		ID:  articleID,
+		Url: newURL,
+	}); err != nil {
+		return handlePgxErr(err)
+	}
+
+	if err := q.InsertArticleRevision(ctx, models.InsertArticleRevisionParams{
+		ArticleID: articleID,
+		Field:     RevisionFieldURL,
+		OldValue:  oldURL,
+		NewValue:  newURL,
+		Note:      note,
+	}); err != nil {
+		return handlePgxErr(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
+
+// DeadBySource returns the number of dead URLs per article source.
+func (u URLStatus) DeadBySource(ctx context.Context) (map[string]int64, error) {
+	rows, err := u.querier(ctx, "URLStatus", "DeadBySource").CountDeadArticlesBySource(ctx)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Source] = row.Count
+	}
+	return counts, nil
+}
+
+// List returns the articles along with their URL status, newest first. If dead
+// is nil, articles are returned regardless of their URL status. If terms is not
+// empty, only the articles with one of the terms are returned, see SearchTerms.
+func (u URLStatus) List(ctx context.Context, dead *bool, terms []string, limit, offset int32) ([]models.ListArticlesWithURLStatusRow, error) {
+	params := models.ListArticlesWithURLStatusParams{
+		Terms:  nonNilTerms(terms),
+		Limit:  limit,
+		Offset: offset,
+	}
+	if dead != nil {
+		params.Dead = pgtype.Bool{Bool: *dead, Valid: true}
+	}
+
+	rows, err := u.querier(ctx, "URLStatus", "List").ListArticlesWithURLStatus(ctx, params)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
+
+const (
+	// MaxTopArticlesDepth caps offset plus limit of the top articles. A page
+	// of the top ordering is cut by offset over the scores computed for every
+	// matching article, which gets slower the deeper the page.
+	MaxTopArticlesDepth = 1000
+	// MaxSearchTerms is the number of terms of a query kept by SearchTerms.
+	MaxSearchTerms = 8
+)
+
+// ArticleScoring weighs the score of the articles in the top ordering:
+//
+//	score = Recency·exp(-age_hours/TauHours) + Source·source_weight + Relevance·relevance
+//
+// The source weight of a source without one is DefaultSourceWeight. The
+// relevance is the share of the search terms found in the title and the
+// content of the article, zero without terms. Each term lies in [0, 1].
+type ArticleScoring struct {
+	TauHours            float64
+	Recency             float64
+	Source              float64
+	Relevance           float64
+	DefaultSourceWeight float64
+}
+
+// Top returns the articles along with their URL status, by descending score as
+// of now. The articles are filtered as by List. offset plus limit may not
+// exceed MaxTopArticlesDepth.
+func (u URLStatus) Top(ctx context.Context, dead *bool, terms []string, scoring ArticleScoring,
+	now time.Time, limit, offset int32) ([]models.ListTopArticlesRow, error) {
+	if offset < 0 || limit <= 0 || int(offset)+int(limit) > MaxTopArticlesDepth {
+		return nil, errors.ErrValidationFailed.Clone().
+			WithMessage("top articles page out of range").
+			WithDetails(fmt.Sprintf("offset: %d, limit: %d, max depth: %d",
+				offset, limit, MaxTopArticlesDepth))
+	}
+
+	if scoring.TauHours <= 0 {
+		return nil, errors.ErrValidationFailed.Clone().
+			WithMessage("tau hours should be positive").
+			WithDetails(fmt.Sprintf("tau_hours: %v", scoring.TauHours))
+	}
+
+	nowTsz, err := utils.TimeTo.PGTimestamptz(now)
+	if err != nil {
+		return nil, errors.ErrDBTypeConversionError.Clone().
+			WithMessage("failed to convert time to pgtype.Timestamptz").
+			WithDetails(fmt.Sprintf("time: %v", now.Format(time.DateTime))).
+			Warp(err)
+	}
+
+	params := models.ListTopArticlesParams{
+		RecencyWeight:       scoring.Recency,
+		Now:                 nowTsz,
+		TauHours:            scoring.TauHours,
+		SourceWeight:        scoring.Source,
+		DefaultSourceWeight: scoring.DefaultSourceWeight,
+		RelevanceWeight:     scoring.Relevance,
+		Terms:               nonNilTerms(terms),
+		Limit:               limit,
+		Offset:              offset,
+	}
+	if dead != nil {
+		params.Dead = pgtype.Bool{Bool: *dead, Valid: true}
+	}
+
+	rows, err := u.querier(ctx, "URLStatus", "Top").ListTopArticles(ctx, params)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	return rows, nil
+}
+
+// SearchTerms splits query into the distinct terms matched against the title
+// and the content of the articles, the first MaxSearchTerms of them. Chinese
+// text is not segmented, a term is matched as a substring.
+func SearchTerms(query string) []string {
+	var terms []string
+	for _, t := range strings.Fields(query) {
+		if slices.Contains(terms, t) {
+			continue
+		}
+
+		terms = append(terms, t)
+		if len(terms) == MaxSearchTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// nonNilTerms returns terms, or an empty slice if terms is nil, a nil slice
+// would be sent as NULL and match no article.
+func nonNilTerms(terms []string) []string {
+	if terms == nil {
+		return []string{}
+	}
+	return terms
+}
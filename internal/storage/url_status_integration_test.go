@@ -0,0 +1,96 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopArticles(t *testing.T) {
+	pool := newTestPool(t)
+	s := newTestStorage(t, pool)
+	ctx := context.Background()
+
+	// the token keeps the articles of the other tests out
+	token := "tok" + uuid.NewString()[:8]
+	srcA := "src-a-" + uuid.NewString()[:8]
+	srcB := "src-b-" + uuid.NewString()[:8]
+	now := time.Now().Truncate(time.Second)
+
+	insert := func(title, source string, age time.Duration) int32 {
+		var id int32
+		require.NoError(t, pool.QueryRow(ctx, `
+INSERT INTO articles (title, url, source, md5, content, published_at)
+VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+			title, "https://example.com/"+uuid.NewString(), source, uuid.NewString(),
+			"content "+token, now.Add(-age)).Scan(&id))
+		return id
+	}
+	fresh := insert("fresh", srcA, time.Hour)
+	relevant := insert("relevant "+token, srcA, 24*time.Hour)
+	authoritative := insert("authoritative", srcB, 48*time.Hour)
+
+	_, err := s.SourceWeights().Set(ctx, srcA, 0.2, "editor")
+	require.NoError(t, err)
+	_, err = s.SourceWeights().Set(ctx, srcB, 0.9, "editor")
+	require.NoError(t, err)
+
+	top := func(scoring storage.ArticleScoring) []int32 {
+		t.Helper()
+		scoring.TauHours = 24
+		rows, err := s.URLStatus().Top(ctx, nil, []string{token}, scoring, now, 10, 0)
+		require.NoError(t, err)
+		ids := make([]int32, len(rows))
+		for i, row := range rows {
+			ids[i] = row.ID
+			if i > 0 {
+				require.GreaterOrEqual(t, rows[i-1].Score, row.Score)
+			}
+		}
+		return ids
+	}
+
+	tcs := []struct {
+		name    string
+		scoring storage.ArticleScoring
+		want    []int32
+	}{
+		{"recency", storage.ArticleScoring{Recency: 1}, []int32{fresh, relevant, authoritative}},
+		// ties are broken by id, the latest first
+		{"source", storage.ArticleScoring{Source: 1}, []int32{authoritative, relevant, fresh}},
+		{"relevance", storage.ArticleScoring{Relevance: 1}, []int32{relevant, authoritative, fresh}},
+		{"blend", storage.ArticleScoring{Recency: 0.3, Source: 0.2, Relevance: 0.5}, []int32{relevant, fresh, authoritative}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, top(tc.scoring))
+		})
+	}
+
+	// a new weight applies to the next query
+	_, err = s.SourceWeights().Set(ctx, srcB, 0, "editor")
+	require.NoError(t, err)
+	require.Equal(t, []int32{relevant, fresh, authoritative}, top(storage.ArticleScoring{Source: 1}))
+
+	// pages of the top ordering
+	rows, err := s.URLStatus().Top(ctx, nil, []string{token},
+		storage.ArticleScoring{TauHours: 24, Recency: 1}, now, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, relevant, rows[0].ID)
+
+	// the recent ordering is filtered by the terms as well
+	recent, err := s.URLStatus().List(ctx, nil, []string{token}, 10, 0)
+	require.NoError(t, err)
+	ids := make([]int32, len(recent))
+	for i, row := range recent {
+		ids[i] = row.ID
+	}
+	require.Equal(t, []int32{fresh, relevant, authoritative}, ids)
+}
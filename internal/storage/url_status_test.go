@@ -0,0 +1,41 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchTerms(t *testing.T) {
+	require.Empty(t, storage.SearchTerms(""))
+	require.Empty(t, storage.SearchTerms("  \t "))
+	require.Equal(t, []string{"罷免", "立法院"}, storage.SearchTerms(" 罷免  立法院 罷免 "))
+	require.Len(t, storage.SearchTerms("a b c d e f g h i j"), storage.MaxSearchTerms)
+}
+
+func TestTopOutOfRange(t *testing.T) {
+	ctx := context.Background()
+	s := storage.Storage{}
+	scoring := storage.ArticleScoring{TauHours: 24, Recency: 1}
+
+	tcs := []struct {
+		name          string
+		scoring       storage.ArticleScoring
+		limit, offset int32
+	}{
+		{"too deep", scoring, 20, storage.MaxTopArticlesDepth - 10},
+		{"negative offset", scoring, 20, -1},
+		{"zero limit", scoring, 0, 0},
+		{"zero tau", storage.ArticleScoring{Recency: 1}, 20, 0},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.URLStatus().Top(ctx, nil, nil, tc.scoring, time.Now(), tc.limit, tc.offset)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// vectorDimCast marks the casts of the queries typed with the dimension of the
+// model searched, e.g. e.vector::vector/*dim*/. Run as is, the cast is to an
+// untyped vector and the embeddings of the model are scanned exactly.
+const vectorDimCast = "::vector/*dim*/"
+
+// withModelDim checks the length of embedding, unless nil, against the
+// dimension recorded for the model of mID, and returns the queries of the
+// method of the accessor, on the connection chosen by dbFor, with the casts
+// marked by /*dim*/ typed with the dimension, e.g. e.vector::vector/*dim*/ run
+// as e.vector::vector(1536), so that the searches under the model match the
+// expression of its HNSW index, a partial index over vector::vector(dim) of
+// the embeddings of the model, see Models.GetOrCreate.
+func (s Storage) withModelDim(ctx context.Context, accessor, method string,
+	mID int32, embedding []float32) (*models.Queries, error) {
+	model, err := s.querier(ctx, accessor, method).GetModelByID(ctx, mID)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	if embedding != nil {
+		if err := checkModelDim(model, embedding); err != nil {
+			return nil, err
+		}
+	}
+
+	return models.New(vectorDimDB{
+		DBTX: s.dbFor(ctx, accessor, method),
+		cast: fmt.Sprintf("::vector(%d)", model.Dim),
+	}), nil
+}
+
+// vectorDimDB replaces the casts marked by /*dim*/ of the queries run on DBTX
+// with cast.
+type vectorDimDB struct {
+	models.DBTX
+	cast string
+}
+
+func (db vectorDimDB) rewrite(sql string) string {
+	return strings.ReplaceAll(sql, vectorDimCast, db.cast)
+}
+
+func (db vectorDimDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return db.DBTX.Exec(ctx, db.rewrite(sql), args...)
+}
+
+func (db vectorDimDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return db.DBTX.Query(ctx, db.rewrite(sql), args...)
+}
+
+func (db vectorDimDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return db.DBTX.QueryRow(ctx, db.rewrite(sql), args...)
+}
+
+func (db vectorDimDB) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	for _, q := range b.QueuedQueries {
+		q.SQL = db.rewrite(q.SQL)
+	}
+	return db.DBTX.SendBatch(ctx, b)
+}
@@ -0,0 +1,25 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVectorDimDB(t *testing.T) {
+	ctx := context.Background()
+	tx := &fakeTx{}
+	db := storage.NewVectorDimDB(tx, 1536)
+
+	_ = db.QueryRow(ctx, "SELECT e.vector::vector/*dim*/ <=> $1::vector/*dim*/ FROM embeddings e")
+	_, _ = db.Query(ctx, "SELECT $1::vector, $2::vector/*dim*/")
+	_, _ = db.Exec(ctx, "SELECT 1")
+	require.Equal(t, []string{
+		"SELECT e.vector::vector(1536) <=> $1::vector(1536) FROM embeddings e",
+		// the casts left unmarked stay untyped
+		"SELECT $1::vector, $2::vector(1536)",
+		"SELECT 1",
+	}, tx.stmts)
+}
@@ -0,0 +1,789 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The index methods of pgvector.
+const (
+	VectorIndexHNSW    = "hnsw"
+	VectorIndexIVFFlat = "ivfflat"
+)
+
+// The steps of a rebuild reported to its progress callback.
+const (
+	ReindexStepBuild    = "build"
+	ReindexStepValidate = "validate"
+	ReindexStepSwap     = "swap"
+	ReindexStepDrop     = "drop"
+	ReindexStepMeasure  = "measure"
+)
+
+const (
+	// rebuildSuffix names the index built in place of the rebuilt one, and
+	// retiredSuffix the rebuilt one once swapped out.
+	rebuildSuffix = "_rebuild"
+	retiredSuffix = "_retired"
+
+	// maxIdentifierLength is the length of the identifiers of Postgres, in
+	// bytes.
+	maxIdentifierLength = 63
+
+	// sampleOverdraw is the number of rows drawn by the table sample per query
+	// vector requested, since the sample is random in size.
+	sampleOverdraw = 10
+)
+
+// modelIndexParams are the build options of the HNSW index of a model, the
+// ones of migrations/022_model_dims.up.sql.
+var modelIndexParams = ReindexParams{M: 16, EfConstruction: 64}
+
+// modelIndexTables are the tables of the embeddings indexed by model.
+var modelIndexTables = []pgx.Identifier{{"public", "embeddings"}, {"users", "embeddings"}}
+
+// distanceOperators maps the suffix of an operator class of pgvector to the
+// distance operator the index orders by.
+var distanceOperators = map[string]string{
+	"_l2_ops":      "<->",
+	"_ip_ops":      "<#>",
+	"_cosine_ops":  "<=>",
+	"_l1_ops":      "<+>",
+	"_hamming_ops": "<~>",
+	"_jaccard_ops": "<%>",
+}
+
+func (s Storage) VectorIndexMaintenance() VectorIndexMaintenance {
+	return VectorIndexMaintenance{Storage: s, cfg: global.VectorIndexConfig{}.Default()}
+}
+
+// VectorIndexMaintenance inspects the pgvector indexes and rebuilds them
+// without blocking the searches. The statements run on the write pool, which
+// must be a pool rather than a single connection, since the progress of a
+// build is polled while it runs.
+type VectorIndexMaintenance struct {
+	Storage
+	cfg global.VectorIndexConfig
+}
+
+// WithConfig returns a copy of m sampling the recall and guarding the rebuilds
+// as cfg says.
+func (m VectorIndexMaintenance) WithConfig(cfg global.VectorIndexConfig) VectorIndexMaintenance {
+	m.cfg = cfg
+	return m
+}
+
+// ReindexParams are the build options of an index: M and EfConstruction of an
+// HNSW index, Lists of an IVFFlat one. A zero option of a rebuild keeps the
+// one of the rebuilt index, the pgvector default if it has none.
+type ReindexParams struct {
+	M              int `json:"m,omitempty"`
+	EfConstruction int `json:"ef_construction,omitempty"`
+	Lists          int `json:"lists,omitempty"`
+}
+
+// with returns the WITH clause of an index built with p, empty if p sets no
+// option.
+func (p ReindexParams) with() string {
+	var opts []string
+	for _, opt := range []struct {
+		name  string
+		value int
+	}{{"m", p.M}, {"ef_construction", p.EfConstruction}, {"lists", p.Lists}} {
+		if opt.value > 0 {
+			opts = append(opts, fmt.Sprintf("%s = %d", opt.name, opt.value))
+		}
+	}
+
+	if len(opts) == 0 {
+		return ""
+	}
+	return " WITH (" + strings.Join(opts, ", ") + ")"
+}
+
+// parseReindexParams parses the storage options of an index, as formatted by
+// pg_class.reloptions, ignoring the ones not in ReindexParams.
+func parseReindexParams(options []string) ReindexParams {
+	var p ReindexParams
+	for _, opt := range options {
+		name, value, _ := strings.Cut(opt, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+
+		switch name {
+		case "m":
+			p.M = n
+		case "ef_construction":
+			p.EfConstruction = n
+		case "lists":
+			p.Lists = n
+		}
+	}
+	return p
+}
+
+// mergeReindexParams returns the options of the rebuild of an index of method
+// built with current, overridden by the non-zero options of requested. It
+// fails with a bad request if an option does not fit the method.
+func mergeReindexParams(method string, current, requested ReindexParams) (ReindexParams, error) {
+	p := current
+	if requested.M > 0 {
+		p.M = requested.M
+	}
+	if requested.EfConstruction > 0 {
+		p.EfConstruction = requested.EfConstruction
+	}
+	if requested.Lists > 0 {
+		p.Lists = requested.Lists
+	}
+
+	var details []string
+	switch method {
+	case VectorIndexHNSW:
+		if p.Lists != 0 {
+			details = append(details, "lists is an option of the ivfflat indexes")
+		}
+		if p.M != 0 && (p.M < 2 || p.M > 100) {
+			details = append(details, fmt.Sprintf("m should be between 2 and 100, got %d", p.M))
+		}
+		if p.EfConstruction != 0 && (p.EfConstruction < 4 || p.EfConstruction > 1000) {
+			details = append(details, fmt.Sprintf("ef_construction should be between 4 and 1000, got %d", p.EfConstruction))
+		}
+		// pgvector defaults m to 16 and ef_construction to 64
+		m, ef := cmp.Or(p.M, 16), cmp.Or(p.EfConstruction, 64)
+		if ef < 2*m {
+			details = append(details, fmt.Sprintf("ef_construction should be at least twice m, got %d and %d", ef, m))
+		}
+	case VectorIndexIVFFlat:
+		if p.M != 0 || p.EfConstruction != 0 {
+			details = append(details, "m and ef_construction are options of the hnsw indexes")
+		}
+		if p.Lists != 0 && (p.Lists < 1 || p.Lists > 32768) {
+			details = append(details, fmt.Sprintf("lists should be between 1 and 32768, got %d", p.Lists))
+		}
+	default:
+		details = append(details, fmt.Sprintf("unknown index method %q", method))
+	}
+
+	if len(details) > 0 {
+		return ReindexParams{}, ec.ErrBadRequest.Clone().
+			WithMessage("invalid reindex params").
+			WithDetails(details...)
+	}
+	return p, nil
+}
+
+// RecallEstimate is the recall of an index estimated on Samples query vectors
+// drawn from its table: the share of the exact K nearest neighbours of each the
+// index finds. StdErr is its binomial standard error.
+type RecallEstimate struct {
+	Recall  float64 `json:"recall"`
+	StdErr  float64 `json:"std_err"`
+	Samples int     `json:"samples"`
+	K       int     `json:"k"`
+}
+
+// VectorIndexStats describes a vector index. Name and Table are qualified by
+// their schema, Column is the column or the expression indexed and Predicate
+// the condition of the rows a partial index covers, empty for another one.
+// Rows is the number of rows the index covers estimated by the planner. The
+// recall of an invalid index is not estimated.
+type VectorIndexStats struct {
+	Name          string         `json:"name"`
+	Table         string         `json:"table"`
+	Column        string         `json:"column"`
+	Predicate     string         `json:"predicate,omitempty"`
+	Method        string         `json:"method"`
+	OpClass       string         `json:"opclass"`
+	Params        ReindexParams  `json:"params"`
+	Valid         bool           `json:"valid"`
+	SizeBytes     int64          `json:"size_bytes"`
+	Rows          int64          `json:"rows"`
+	Recall        RecallEstimate `json:"recall"`
+	Degraded      bool           `json:"degraded"`
+	LastReindexAt *time.Time     `json:"last_reindex_at,omitempty"`
+}
+
+// VectorIndexRecord is the record of a rebuild of a vector index. Error is the
+// reason of a failed rebuild, FinishedAt is nil while it runs.
+type VectorIndexRecord struct {
+	ID           int32         `json:"id"`
+	Index        string        `json:"index"`
+	Table        string        `json:"table"`
+	Method       string        `json:"method"`
+	Params       ReindexParams `json:"params"`
+	RecallBefore *float64      `json:"recall_before,omitempty"`
+	RecallAfter  *float64      `json:"recall_after,omitempty"`
+	SizeBefore   *int64        `json:"size_before,omitempty"`
+	SizeAfter    *int64        `json:"size_after,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	StartedAt    time.Time     `json:"started_at"`
+	FinishedAt   *time.Time    `json:"finished_at,omitempty"`
+}
+
+func newVectorIndexRecord(r models.VectorIndexMaintenance) (VectorIndexRecord, error) {
+	record := VectorIndexRecord{
+		ID:        r.ID,
+		Index:     r.IndexName,
+		Table:     r.TableName,
+		Method:    r.Method,
+		Error:     r.Error.String,
+		StartedAt: r.StartedAt.Time,
+	}
+	if r.RecallBefore.Valid {
+		record.RecallBefore = &r.RecallBefore.Float64
+	}
+	if r.RecallAfter.Valid {
+		record.RecallAfter = &r.RecallAfter.Float64
+	}
+	if r.SizeBefore.Valid {
+		record.SizeBefore = &r.SizeBefore.Int64
+	}
+	if r.SizeAfter.Valid {
+		record.SizeAfter = &r.SizeAfter.Int64
+	}
+	if r.FinishedAt.Valid {
+		record.FinishedAt = &r.FinishedAt.Time
+	}
+
+	if len(r.Params) > 0 {
+		if err := json.Unmarshal(r.Params, &record.Params); err != nil {
+			return VectorIndexRecord{}, ec.ErrDBTypeConversionError.Clone().
+				WithMessage("failed to unmarshal reindex params").
+				Warp(err)
+		}
+	}
+	return record, nil
+}
+
+// ReindexProgress is the progress of a rebuild. While the index is built,
+// Phase and the counts are the ones reported by pg_stat_progress_create_index.
+type ReindexProgress struct {
+	Step        string `json:"step"`
+	Phase       string `json:"phase,omitempty"`
+	BlocksTotal int64  `json:"blocks_total,omitempty"`
+	BlocksDone  int64  `json:"blocks_done,omitempty"`
+	TuplesTotal int64  `json:"tuples_total,omitempty"`
+	TuplesDone  int64  `json:"tuples_done,omitempty"`
+}
+
+// InspectIndexes returns the vector indexes of the database, by qualified
+// name, with their estimated recall and the time of their last rebuild. An
+// index sharing its column with another one is measured on the one the planner
+// picks.
+func (m VectorIndexMaintenance) InspectIndexes(ctx context.Context) ([]VectorIndexStats, error) {
+	rows, err := m.Queries.ListVectorIndexes(ctx)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	reindexes, err := m.Queries.ListLastVectorIndexReindexes(ctx)
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	last := make(map[string]time.Time, len(reindexes))
+	for _, r := range reindexes {
+		last[r.IndexName] = r.FinishedAt.Time
+	}
+
+	stats := make([]VectorIndexStats, 0, len(rows))
+	for _, row := range rows {
+		s := VectorIndexStats{
+			Name:      row.SchemaName + "." + row.IndexName,
+			Table:     row.SchemaName + "." + row.TableName,
+			Column:    row.KeyExpr,
+			Predicate: row.Predicate,
+			Method:    row.Method,
+			OpClass:   row.Opclass,
+			Params:    parseReindexParams(row.Options),
+			Valid:     row.IsValid,
+			SizeBytes: row.SizeBytes,
+			Rows:      row.EstimatedRows,
+		}
+		if t, ok := last[s.Name]; ok {
+			s.LastReindexAt = &t
+		}
+
+		if row.IsValid {
+			if s.Recall, err = m.estimateRecall(ctx, row); err != nil {
+				return nil, err
+			}
+			s.Degraded = s.Recall.Samples > 0 && s.Recall.Recall < m.cfg.MinRecall
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// ReindexConcurrently rebuilds the index, by qualified name or in the public
+// schema, with the options of params. A new index is built concurrently next to
+// the old one, which keeps serving the searches, and takes its name once valid
+// in a transaction holding its locks for at most the lock timeout; the old
+// index is dropped concurrently. progress, if not nil, is called with the steps
+// of the rebuild and the progress of the build, from one goroutine at a time.
+//
+// The rebuild is refused with a conflict while a replica lags or a transaction,
+// which a concurrent build has to wait for, has been open for too long, or
+// while another rebuild of the index runs. Its record is kept with the recall
+// and the size of the index before and after it, or the error of a failed one.
+func (m VectorIndexMaintenance) ReindexConcurrently(ctx context.Context, indexName string,
+	params ReindexParams, progress func(ReindexProgress)) (VectorIndexRecord, error) {
+	if progress == nil {
+		progress = func(ReindexProgress) {}
+	}
+
+	idx, params, err := m.plan(ctx, indexName, params)
+	if err != nil {
+		return VectorIndexRecord{}, err
+	}
+
+	create := models.CreateVectorIndexMaintenanceParams{
+		IndexName:  idx.SchemaName + "." + idx.IndexName,
+		TableName:  idx.SchemaName + "." + idx.TableName,
+		Method:     idx.Method,
+		SizeBefore: pgtype.Int8{Int64: idx.SizeBytes, Valid: true},
+	}
+	if create.Params, err = json.Marshal(params); err != nil {
+		return VectorIndexRecord{}, ec.ErrInternalServerError.Clone().
+			WithMessage("failed to marshal reindex params").
+			Warp(err)
+	}
+
+	if idx.IsValid {
+		recall, err := m.estimateRecall(ctx, idx)
+		if err != nil {
+			return VectorIndexRecord{}, err
+		}
+		create.RecallBefore = pgtype.Float8{Float64: recall.Recall, Valid: recall.Samples > 0}
+	}
+
+	row, err := m.Queries.CreateVectorIndexMaintenance(ctx, create)
+	if err != nil {
+		return VectorIndexRecord{}, handlePgxErr(err).
+			WithDetails(fmt.Sprintf("a rebuild of %s may be running", create.IndexName))
+	}
+
+	finish := models.FinishVectorIndexMaintenanceParams{ID: row.ID}
+	recall, size, rerr := m.rebuild(ctx, idx, params, progress)
+	if rerr != nil {
+		finish.Error = pgtype.Text{String: rerr.Error(), Valid: true}
+	} else {
+		finish.RecallAfter = pgtype.Float8{Float64: recall.Recall, Valid: recall.Samples > 0}
+		finish.SizeAfter = pgtype.Int8{Int64: size, Valid: true}
+	}
+
+	// a cancelled rebuild is recorded too
+	row, err = m.Queries.FinishVectorIndexMaintenance(context.WithoutCancel(ctx), finish)
+	if err != nil {
+		return VectorIndexRecord{}, errors.Join(rerr, handlePgxErr(err))
+	}
+
+	record, err := newVectorIndexRecord(row)
+	if err != nil {
+		return VectorIndexRecord{}, errors.Join(rerr, err)
+	}
+	return record, rerr
+}
+
+// PlanReindex checks a rebuild of the index with params as ReindexConcurrently
+// does before it starts, and returns the options the index would be built with.
+func (m VectorIndexMaintenance) PlanReindex(ctx context.Context, indexName string, params ReindexParams) (ReindexParams, error) {
+	_, params, err := m.plan(ctx, indexName, params)
+	return params, err
+}
+
+func (m VectorIndexMaintenance) plan(ctx context.Context, indexName string,
+	params ReindexParams) (models.ListVectorIndexesRow, ReindexParams, error) {
+	idx, err := m.index(ctx, indexName)
+	if err != nil {
+		return idx, ReindexParams{}, err
+	}
+
+	params, err = mergeReindexParams(idx.Method, parseReindexParams(idx.Options), params)
+	if err != nil {
+		return idx, ReindexParams{}, err
+	}
+
+	if err := m.preflight(ctx); err != nil {
+		return idx, ReindexParams{}, err
+	}
+	return idx, params, nil
+}
+
+// index returns the vector index by qualified name, in the public schema if
+// the name is not qualified.
+func (m VectorIndexMaintenance) index(ctx context.Context, name string) (models.ListVectorIndexesRow, error) {
+	if !strings.Contains(name, ".") {
+		name = "public." + name
+	}
+
+	rows, err := m.Queries.ListVectorIndexes(ctx)
+	if err != nil {
+		return models.ListVectorIndexesRow{}, handlePgxErr(err)
+	}
+	for _, row := range rows {
+		if row.SchemaName+"."+row.IndexName == name {
+			return row, nil
+		}
+	}
+	return models.ListVectorIndexesRow{}, ec.ErrNotFound.Clone().
+		WithDetails(fmt.Sprintf("vector index %s not found", name))
+}
+
+// preflight refuses a rebuild while a replica lags by more than the maximum
+// replication lag, as the build would add to its lag, or a transaction has
+// been open for more than the maximum transaction age, as the build would wait
+// for it.
+func (m VectorIndexMaintenance) preflight(ctx context.Context) error {
+	if m.cfg.MaxReplicationLag > 0 {
+		lag, err := m.Queries.GetMaxReplicationLag(ctx)
+		if err != nil {
+			return handlePgxErr(err)
+		}
+
+		if d := time.Duration(lag * float64(time.Second)); d > m.cfg.MaxReplicationLag {
+			return ec.ErrConflict.Clone().
+				WithMessage("replication lag over the limit, try again later").
+				WithDetails(fmt.Sprintf("lag: %v, max: %v", d.Round(time.Millisecond), m.cfg.MaxReplicationLag))
+		}
+	}
+
+	if m.cfg.MaxTransactionAge > 0 {
+		age, err := m.Queries.GetLongestTransactionAge(ctx)
+		if err != nil {
+			return handlePgxErr(err)
+		}
+
+		if d := time.Duration(age * float64(time.Second)); d > m.cfg.MaxTransactionAge {
+			return ec.ErrConflict.Clone().
+				WithMessage("long running transaction open, try again later").
+				WithDetails(fmt.Sprintf("age: %v, max: %v", d.Round(time.Millisecond), m.cfg.MaxTransactionAge))
+		}
+	}
+	return nil
+}
+
+// rebuild builds the index idx anew with params, swaps it in and drops the old
+// one. It returns the recall and the size of the new index.
+func (m VectorIndexMaintenance) rebuild(ctx context.Context, idx models.ListVectorIndexesRow,
+	params ReindexParams, progress func(ReindexProgress)) (RecallEstimate, int64, error) {
+	newName := derivedIdentifier(idx.IndexName, rebuildSuffix)
+	retiredName := derivedIdentifier(idx.IndexName, retiredSuffix)
+	qualified := pgx.Identifier{idx.SchemaName, idx.IndexName}.Sanitize()
+	qualifiedNew := pgx.Identifier{idx.SchemaName, newName}.Sanitize()
+	qualifiedRetired := pgx.Identifier{idx.SchemaName, retiredName}.Sanitize()
+	table := pgx.Identifier{idx.SchemaName, idx.TableName}.Sanitize()
+
+	// an interrupted rebuild leaves its indexes behind
+	for _, name := range []string{qualifiedNew, qualifiedRetired} {
+		if _, err := m.db.Exec(ctx, "DROP INDEX CONCURRENTLY IF EXISTS "+name); err != nil {
+			return RecallEstimate{}, 0, handlePgxErr(err)
+		}
+	}
+
+	// the method is one of the methods of pgvector, the key, the opclass and
+	// the predicate are read from the catalog; a column in parentheses is
+	// indexed as the column itself
+	ddl := fmt.Sprintf("CREATE INDEX CONCURRENTLY %s ON %s USING %s ((%s) %s)%s%s",
+		pgx.Identifier{newName}.Sanitize(), table, idx.Method,
+		idx.KeyExpr, pgx.Identifier{idx.Opclass}.Sanitize(),
+		params.with(), predicateClause(idx))
+	if err := m.build(ctx, ddl, table, progress); err != nil {
+		m.dropIndex(ctx, qualifiedNew)
+		return RecallEstimate{}, 0, err
+	}
+
+	// a concurrent build which failed midway leaves an invalid index
+	progress(ReindexProgress{Step: ReindexStepValidate})
+	state, err := m.Queries.GetVectorIndexState(ctx, qualifiedNew)
+	if err != nil {
+		m.dropIndex(ctx, qualifiedNew)
+		return RecallEstimate{}, 0, handlePgxErr(err)
+	}
+	if !state.IsValid {
+		m.dropIndex(ctx, qualifiedNew)
+		return RecallEstimate{}, 0, ec.ErrDBError.Clone().
+			WithMessage("rebuilt index is invalid").
+			WithDetails(qualifiedNew)
+	}
+
+	progress(ReindexProgress{Step: ReindexStepSwap})
+	if err := m.swap(ctx, qualified, qualifiedNew, idx.IndexName, retiredName); err != nil {
+		m.dropIndex(ctx, qualifiedNew)
+		return RecallEstimate{}, 0, err
+	}
+
+	progress(ReindexProgress{Step: ReindexStepDrop})
+	if _, err := m.db.Exec(ctx, "DROP INDEX CONCURRENTLY "+qualifiedRetired); err != nil {
+		return RecallEstimate{}, 0, handlePgxErr(err)
+	}
+
+	progress(ReindexProgress{Step: ReindexStepMeasure})
+	recall, err := m.estimateRecall(ctx, idx)
+	if err != nil {
+		return RecallEstimate{}, 0, err
+	}
+	return recall, state.SizeBytes, nil
+}
+
+// build runs ddl, the concurrent build of an index on table, and reports the
+// progress of the build every progress interval until it returns.
+func (m VectorIndexMaintenance) build(ctx context.Context, ddl, table string, progress func(ReindexProgress)) error {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := m.Clock().NewTicker(m.cfg.ProgressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C():
+			}
+
+			row, err := m.Queries.GetCreateIndexProgress(ctx, table)
+			if err != nil {
+				if !errors.Is(err, pgx.ErrNoRows) {
+					global.Logger.Debug().Err(err).Str("table", table).Msg("Failed to poll index build progress")
+				}
+				continue
+			}
+			progress(ReindexProgress{
+				Step:        ReindexStepBuild,
+				Phase:       row.Phase,
+				BlocksTotal: row.BlocksTotal,
+				BlocksDone:  row.BlocksDone,
+				TuplesTotal: row.TuplesTotal,
+				TuplesDone:  row.TuplesDone,
+			})
+		}
+	}()
+
+	progress(ReindexProgress{Step: ReindexStepBuild})
+	_, err := m.db.Exec(ctx, ddl)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
+
+// swap renames the index to retired and the rebuilt one to name in a
+// transaction waiting at most the lock timeout for the locks.
+func (m VectorIndexMaintenance) swap(ctx context.Context, index, rebuilt, name, retired string) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	timeout := fmt.Sprintf("%dms", m.cfg.LockTimeout.Milliseconds())
+	if _, err := tx.Exec(ctx, "SELECT set_config('lock_timeout', $1, true)", timeout); err != nil {
+		return handlePgxErr(err)
+	}
+
+	for _, stmt := range []string{
+		fmt.Sprintf("ALTER INDEX %s RENAME TO %s", index, pgx.Identifier{retired}.Sanitize()),
+		fmt.Sprintf("ALTER INDEX %s RENAME TO %s", rebuilt, pgx.Identifier{name}.Sanitize()),
+	} {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return handlePgxErr(err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return handlePgxErr(err)
+	}
+	return nil
+}
+
+// createModelIndexes builds the HNSW index of the model of mID, of dim
+// dimensions, on each table of the embeddings: a partial index over
+// vector::vector(dim) of the embeddings of the model, named as the ones
+// migrations/022_model_dims.up.sql builds for the models recorded before it.
+// An index of the name already there is kept. The builds are concurrent and
+// run out of a transaction; the index left invalid by a failed one is dropped.
+func (m VectorIndexMaintenance) createModelIndexes(ctx context.Context, mID, dim int32) error {
+	name := fmt.Sprintf("embeddings_vector_hnsw_model_%d_idx", mID)
+	for _, table := range modelIndexTables {
+		ddl := fmt.Sprintf("CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s USING %s ((vector::vector(%d)) vector_cosine_ops)%s WHERE model_id = %d",
+			pgx.Identifier{name}.Sanitize(), table.Sanitize(), VectorIndexHNSW,
+			dim, modelIndexParams.with(), mID)
+		if _, err := m.db.Exec(ctx, ddl); err != nil {
+			m.dropIndex(ctx, pgx.Identifier{table[0], name}.Sanitize())
+			return handlePgxErr(err)
+		}
+	}
+	return nil
+}
+
+// dropIndex drops the index left by a failed rebuild, even if ctx is done.
+func (m VectorIndexMaintenance) dropIndex(ctx context.Context, index string) {
+	if _, err := m.db.Exec(context.WithoutCancel(ctx), "DROP INDEX CONCURRENTLY IF EXISTS "+index); err != nil {
+		global.Logger.Warn().Err(err).Str("index", index).Msg("Failed to drop index left by a failed rebuild")
+	}
+}
+
+// estimateRecall estimates the recall of the index idx on the sample size
+// vectors drawn from its table. The exact K nearest neighbours of each are
+// found by a sequential scan and the approximate ones by an index scan; an
+// approximate neighbour counts if it is within the tolerance of the distance of
+// the K-th exact one.
+func (m VectorIndexMaintenance) estimateRecall(ctx context.Context, idx models.ListVectorIndexesRow) (RecallEstimate, error) {
+	estimate := RecallEstimate{K: m.cfg.K}
+	op, ok := distanceOperator(idx.Opclass)
+	if !ok {
+		return estimate, ec.ErrBadRequest.Clone().
+			WithMessage("unknown operator class").
+			WithDetails(idx.Opclass)
+	}
+
+	samples, err := m.sample(ctx, idx)
+	if err != nil || len(samples) == 0 {
+		return estimate, err
+	}
+
+	// the key, its type and the predicate are read from the catalog; the
+	// query repeats the key and the predicate for the planner to match it
+	// with the index
+	knn := fmt.Sprintf("SELECT ((%[1]s) %[2]s $1::%[3]s)::float8 FROM %[4]s%[5]s ORDER BY (%[1]s) %[2]s $1::%[3]s LIMIT $2",
+		idx.KeyExpr, op, idx.KeyType, pgx.Identifier{idx.SchemaName, idx.TableName}.Sanitize(), predicateClause(idx))
+
+	exact, err := m.neighbours(ctx, knn, "enable_indexscan", samples)
+	if err != nil {
+		return estimate, err
+	}
+	approx, err := m.neighbours(ctx, knn, "enable_seqscan", samples)
+	if err != nil {
+		return estimate, err
+	}
+
+	var hits, total int
+	for i := range samples {
+		if len(exact[i]) == 0 {
+			continue
+		}
+
+		threshold := exact[i][len(exact[i])-1] + m.cfg.Tolerance
+		found := 0
+		for _, d := range approx[i] {
+			if d <= threshold {
+				found++
+			}
+		}
+		hits += min(found, len(exact[i]))
+		total += len(exact[i])
+	}
+
+	estimate.Samples = len(samples)
+	if total > 0 {
+		estimate.Recall = float64(hits) / float64(total)
+		estimate.StdErr = math.Sqrt(estimate.Recall * (1 - estimate.Recall) / float64(total))
+	}
+	return estimate, nil
+}
+
+// sample draws up to the sample size vectors, in their text form, from the
+// rows idx covers.
+func (m VectorIndexMaintenance) sample(ctx context.Context, idx models.ListVectorIndexesRow) ([]string, error) {
+	n := m.cfg.SampleSize
+	percent := 100.0
+	if idx.EstimatedRows > 0 {
+		percent = min(100, 100*float64(sampleOverdraw*n)/float64(idx.EstimatedRows))
+	}
+
+	query := fmt.Sprintf("SELECT (%s)::text FROM %s TABLESAMPLE BERNOULLI ($1)%s ORDER BY random() LIMIT $2",
+		idx.KeyExpr, pgx.Identifier{idx.SchemaName, idx.TableName}.Sanitize(), predicateClause(idx))
+	for {
+		rows, err := m.db.Query(ctx, query, percent, n)
+		if err != nil {
+			return nil, handlePgxErr(err)
+		}
+
+		samples, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return nil, handlePgxErr(err)
+		}
+
+		// the estimated number of rows may be stale
+		if len(samples) > 0 || percent >= 100 {
+			return samples, nil
+		}
+		percent = 100
+	}
+}
+
+// neighbours runs the knn query for each sample in a read-only transaction with
+// the setting off, and returns the distances of the neighbours found for each.
+func (m VectorIndexMaintenance) neighbours(ctx context.Context, knn, setting string, samples []string) ([][]float64, error) {
+	tx, err := m.db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, handlePgxErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT set_config($1, 'off', true)", setting); err != nil {
+		return nil, handlePgxErr(err)
+	}
+
+	distances := make([][]float64, len(samples))
+	for i, v := range samples {
+		rows, err := tx.Query(ctx, knn, v, m.cfg.K)
+		if err != nil {
+			return nil, handlePgxErr(err)
+		}
+
+		if distances[i], err = pgx.CollectRows(rows, pgx.RowTo[float64]); err != nil {
+			return nil, handlePgxErr(err)
+		}
+	}
+	return distances, nil
+}
+
+// distanceOperator returns the distance operator of the operator class.
+func distanceOperator(opclass string) (string, bool) {
+	for suffix, op := range distanceOperators {
+		if strings.HasSuffix(opclass, suffix) {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// predicateClause returns the WHERE clause of the predicate of a partial
+// index, empty for another one.
+func predicateClause(idx models.ListVectorIndexesRow) string {
+	if idx.Predicate == "" {
+		return ""
+	}
+	return " WHERE " + idx.Predicate
+}
+
+// derivedIdentifier returns name with suffix, truncating name so that the
+// result fits in an identifier.
+func derivedIdentifier(name, suffix string) string {
+	if len(name)+len(suffix) > maxIdentifierLength {
+		name = name[:maxIdentifierLength-len(suffix)]
+	}
+	return name + suffix
+}
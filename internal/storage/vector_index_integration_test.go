@@ -0,0 +1,225 @@
+//go:build integration
+
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+// vectorIndexFixture creates a table of n random vectors of dim dimensions
+// with an HNSW index built with m, and returns the names of the table and of
+// the index.
+func vectorIndexFixture(t *testing.T, pool *pgxpool.Pool, n, dim, m int) (string, string) {
+	t.Helper()
+	ctx := context.Background()
+	table := "vector_index_" + uuid.NewString()[:8]
+	index := table + "_idx"
+
+	for _, stmt := range []string{
+		fmt.Sprintf("CREATE TABLE %s (id SERIAL PRIMARY KEY, vector vector(%d) NOT NULL)", table, dim),
+		fmt.Sprintf(`INSERT INTO %s (vector)
+			SELECT (SELECT array_agg(random()) FROM generate_series(1, %d) d WHERE g > 0)::vector
+			FROM generate_series(1, %d) g`, table, dim, n),
+		fmt.Sprintf("CREATE INDEX %s ON %s USING hnsw (vector vector_l2_ops) WITH (m = %d, ef_construction = %d)",
+			index, table, m, 4*m),
+		"ANALYZE " + table,
+	} {
+		_, err := pool.Exec(ctx, stmt)
+		require.NoError(t, err)
+	}
+
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS "+table)
+		_, _ = pool.Exec(context.Background(),
+			"DELETE FROM vector_index_maintenance WHERE index_name = $1", "public."+index)
+	})
+	return table, index
+}
+
+func findVectorIndex(t *testing.T, stats []storage.VectorIndexStats, name string) storage.VectorIndexStats {
+	t.Helper()
+	i := slices.IndexFunc(stats, func(s storage.VectorIndexStats) bool { return s.Name == name })
+	require.GreaterOrEqual(t, i, 0, "%s not inspected", name)
+	return stats[i]
+}
+
+func TestVectorIndexMaintenance(t *testing.T) {
+	pool := newTestPool(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	table, index := vectorIndexFixture(t, pool, 3000, 16, 4)
+	cfg := global.VectorIndexConfig{}.Default()
+	cfg.SampleSize, cfg.K = 50, 10
+	cfg.ProgressInterval = 10 * time.Millisecond
+	vim := storage.New(pool, nil).VectorIndexMaintenance().WithConfig(cfg)
+
+	stats, err := vim.InspectIndexes(ctx)
+	require.NoError(t, err)
+	before := findVectorIndex(t, stats, "public."+index)
+	require.Equal(t, "public."+table, before.Table)
+	require.Equal(t, storage.VectorIndexHNSW, before.Method)
+	require.Equal(t, storage.ReindexParams{M: 4, EfConstruction: 16}, before.Params)
+	require.True(t, before.Valid)
+	require.Positive(t, before.SizeBytes)
+	require.Nil(t, before.LastReindexAt)
+	require.Equal(t, 50, before.Recall.Samples)
+	require.Equal(t, 10, before.Recall.K)
+	require.Greater(t, before.Recall.Recall, 0.3)
+	require.LessOrEqual(t, before.Recall.Recall, 1.0)
+
+	// the options have to fit the method
+	_, err = vim.ReindexConcurrently(ctx, index, storage.ReindexParams{Lists: 100}, nil)
+	require.Error(t, err)
+	_, err = vim.ReindexConcurrently(ctx, "public.missing_idx", storage.ReindexParams{}, nil)
+	require.Error(t, err)
+
+	// the searches keep being served during the rebuild
+	var searches, failures atomic.Int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		query := fmt.Sprintf("SELECT id FROM %[1]s ORDER BY vector <-> (SELECT vector FROM %[1]s WHERE id = 1) LIMIT 10", table)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			rows, err := pool.Query(ctx, query)
+			if err == nil {
+				for rows.Next() {
+				}
+				err = rows.Err()
+			}
+			if err != nil {
+				failures.Add(1)
+				t.Log(err)
+			}
+			searches.Add(1)
+		}
+	}()
+
+	var steps []string
+	record, err := vim.ReindexConcurrently(ctx, index, storage.ReindexParams{M: 16, EfConstruction: 64},
+		func(p storage.ReindexProgress) {
+			if len(steps) == 0 || steps[len(steps)-1] != p.Step {
+				steps = append(steps, p.Step)
+			}
+		})
+	close(stop)
+	wg.Wait()
+	require.NoError(t, err)
+	require.Positive(t, searches.Load())
+	require.Zero(t, failures.Load())
+	require.Equal(t, []string{storage.ReindexStepBuild, storage.ReindexStepValidate,
+		storage.ReindexStepSwap, storage.ReindexStepDrop, storage.ReindexStepMeasure}, steps)
+
+	// the rebuild is recorded
+	require.Equal(t, "public."+index, record.Index)
+	require.Equal(t, storage.ReindexParams{M: 16, EfConstruction: 64}, record.Params)
+	require.Empty(t, record.Error)
+	require.NotNil(t, record.FinishedAt)
+	require.NotNil(t, record.RecallBefore)
+	require.NotNil(t, record.RecallAfter)
+	require.NotNil(t, record.SizeBefore)
+	require.Equal(t, before.SizeBytes, *record.SizeBefore)
+	require.NotNil(t, record.SizeAfter)
+	require.GreaterOrEqual(t, *record.RecallAfter, *record.RecallBefore-0.1)
+
+	stats, err = vim.InspectIndexes(ctx)
+	require.NoError(t, err)
+	after := findVectorIndex(t, stats, "public."+index)
+	require.Equal(t, storage.ReindexParams{M: 16, EfConstruction: 64}, after.Params)
+	require.True(t, after.Valid)
+	require.NotNil(t, after.LastReindexAt)
+	require.True(t, after.LastReindexAt.Equal(*record.FinishedAt))
+	for _, s := range stats {
+		if s.Table == after.Table {
+			require.Equal(t, after.Name, s.Name, "the old index is dropped")
+		}
+	}
+
+	// no rebuild runs while a transaction has been open for too long
+	tx, err := pool.Begin(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, "SELECT 1")
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	cfg.MaxTransactionAge = 10 * time.Millisecond
+	_, err = vim.WithConfig(cfg).ReindexConcurrently(ctx, index, storage.ReindexParams{}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "transaction")
+}
+
+func TestVectorIndexMaintenancePartialIndex(t *testing.T) {
+	pool := newTestPool(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// the embeddings of two models of 16 and 8 dimensions share the table, the
+	// index covers the ones of the first, as the indexes of the models do
+	table := "vector_index_" + uuid.NewString()[:8]
+	index := table + "_idx"
+	for _, stmt := range []string{
+		fmt.Sprintf("CREATE TABLE %s (id SERIAL PRIMARY KEY, model_id INTEGER NOT NULL, vector vector NOT NULL)", table),
+		fmt.Sprintf(`INSERT INTO %s (model_id, vector)
+			SELECT g %% 2, (SELECT array_agg(random()) FROM generate_series(1, 16 - 8 * (g %% 2)) d WHERE g > 0)::vector
+			FROM generate_series(1, 2000) g`, table),
+		fmt.Sprintf(`CREATE INDEX %s ON %s USING hnsw ((vector::vector(16)) vector_l2_ops)
+			WITH (m = 4, ef_construction = 16) WHERE model_id = 0`, index, table),
+		"ANALYZE " + table,
+	} {
+		_, err := pool.Exec(ctx, stmt)
+		require.NoError(t, err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), "DROP TABLE IF EXISTS "+table)
+		_, _ = pool.Exec(context.Background(),
+			"DELETE FROM vector_index_maintenance WHERE index_name = $1", "public."+index)
+	})
+
+	cfg := global.VectorIndexConfig{}.Default()
+	cfg.SampleSize, cfg.K = 50, 10
+	vim := storage.New(pool, nil).VectorIndexMaintenance().WithConfig(cfg)
+
+	stats, err := vim.InspectIndexes(ctx)
+	require.NoError(t, err)
+	before := findVectorIndex(t, stats, "public."+index)
+	require.Contains(t, before.Column, "vector(16)")
+	require.Equal(t, "model_id = 0", before.Predicate)
+	require.InDelta(t, 1000, before.Rows, 200)
+	require.Equal(t, 50, before.Recall.Samples)
+
+	// the rebuilt index keeps the expression and the predicate, the embeddings
+	// of 8 dimensions would fail the cast otherwise
+	record, err := vim.ReindexConcurrently(ctx, index, storage.ReindexParams{M: 8}, nil)
+	require.NoError(t, err)
+	require.Empty(t, record.Error)
+	require.NotNil(t, record.RecallAfter)
+
+	stats, err = vim.InspectIndexes(ctx)
+	require.NoError(t, err)
+	after := findVectorIndex(t, stats, "public."+index)
+	require.Equal(t, before.Column, after.Column)
+	require.Equal(t, before.Predicate, after.Predicate)
+	require.Equal(t, storage.ReindexParams{M: 8, EfConstruction: 16}, after.Params)
+	require.True(t, after.Valid)
+}
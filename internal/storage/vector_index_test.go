@@ -0,0 +1,95 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReindexParams(t *testing.T) {
+	require.Equal(t, storage.ReindexParams{M: 16, EfConstruction: 64},
+		storage.ParseReindexParams([]string{"m=16", "ef_construction=64", "fillfactor=90"}))
+	require.Equal(t, storage.ReindexParams{Lists: 100}, storage.ParseReindexParams([]string{"lists=100"}))
+	require.Zero(t, storage.ParseReindexParams(nil))
+}
+
+func TestMergeReindexParams(t *testing.T) {
+	tcs := []struct {
+		Name      string
+		Method    string
+		Current   storage.ReindexParams
+		Requested storage.ReindexParams
+		Expected  storage.ReindexParams
+		Err       bool
+	}{
+		{
+			Name:      "keep",
+			Method:    storage.VectorIndexHNSW,
+			Current:   storage.ReindexParams{M: 16, EfConstruction: 64},
+			Requested: storage.ReindexParams{},
+			Expected:  storage.ReindexParams{M: 16, EfConstruction: 64},
+		},
+		{
+			Name:      "override",
+			Method:    storage.VectorIndexHNSW,
+			Current:   storage.ReindexParams{M: 16, EfConstruction: 64},
+			Requested: storage.ReindexParams{EfConstruction: 128},
+			Expected:  storage.ReindexParams{M: 16, EfConstruction: 128},
+		},
+		{
+			Name:      "pgvector defaults",
+			Method:    storage.VectorIndexHNSW,
+			Requested: storage.ReindexParams{M: 24},
+			Expected:  storage.ReindexParams{M: 24},
+		},
+		{
+			Name:      "ef_construction below twice m",
+			Method:    storage.VectorIndexHNSW,
+			Requested: storage.ReindexParams{M: 48},
+			Err:       true,
+		},
+		{
+			Name:      "m out of range",
+			Method:    storage.VectorIndexHNSW,
+			Requested: storage.ReindexParams{M: 1},
+			Err:       true,
+		},
+		{
+			Name:      "lists of hnsw",
+			Method:    storage.VectorIndexHNSW,
+			Requested: storage.ReindexParams{Lists: 100},
+			Err:       true,
+		},
+		{
+			Name:      "ivfflat",
+			Method:    storage.VectorIndexIVFFlat,
+			Current:   storage.ReindexParams{Lists: 100},
+			Requested: storage.ReindexParams{Lists: 400},
+			Expected:  storage.ReindexParams{Lists: 400},
+		},
+		{
+			Name:      "m of ivfflat",
+			Method:    storage.VectorIndexIVFFlat,
+			Requested: storage.ReindexParams{M: 16},
+			Err:       true,
+		},
+		{
+			Name:   "unknown method",
+			Method: "btree",
+			Err:    true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			p, err := storage.MergeReindexParams(tc.Method, tc.Current, tc.Requested)
+			if tc.Err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.Expected, p)
+		})
+	}
+}
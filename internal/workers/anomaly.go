@@ -0,0 +1,376 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// DefaultAnomalyInterval is the interval between two detection passes. A
+	// pass only looks at complete days, the anomalies of a day are detected by
+	// the first pass after midnight.
+	DefaultAnomalyInterval = time.Hour
+	// DefaultAnomalyWindow is the number of days of the baseline of a day.
+	DefaultAnomalyWindow = 28
+	// DefaultAnomalyThreshold is the number of standard deviations above the
+	// baseline mean a count has to exceed to be flagged.
+	DefaultAnomalyThreshold = 3.0
+	// DefaultAnomalyMinCount is the count a day has to reach to be flagged.
+	DefaultAnomalyMinCount = 5
+	// DefaultAnomalyMinBaselineCount is the number of mentions a keyword needs
+	// over the baseline window to be analysed at all.
+	DefaultAnomalyMinBaselineCount = 5
+	// DefaultAnomalyLookbackDays is the number of complete days a pass
+	// evaluates, so a day missed by a failed pass is caught up by the next.
+	DefaultAnomalyLookbackDays = 3
+)
+
+// KeywordAnomalyEvent is the webhook event of a keyword anomaly.
+const KeywordAnomalyEvent = "keyword.anomaly"
+
+var keywordAnomaliesTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "keyword_anomalies_total",
+	Help: "Number of keyword anomalies flagged.",
+})
+
+// AnomalyStore reads the daily mention counts of the keywords and records the
+// anomalies. It is implemented by storage.KeywordAnomalies.
+type AnomalyStore interface {
+	Trend(ctx context.Context, loc *time.Location, from, to time.Time) ([]models.KeywordTrendRow, error)
+	Record(ctx context.Context, arg models.InsertKeywordAnomalyParams) (models.KeywordAnomaly, bool, error)
+}
+
+// WebhookDispatcher delivers a signed payload to a webhook. It is implemented
+// by publishers.WebhookDispatcher.
+type WebhookDispatcher interface {
+	Dispatch(ctx context.Context, url, event string, payload any) error
+}
+
+// AnomalyOptions configures an AnomalyDetector. The days are calendar days in
+// Location.
+type AnomalyOptions struct {
+	Window           int
+	Threshold        float64
+	MinCount         int
+	MinBaselineCount int
+	LookbackDays     int
+	Location         *time.Location
+	WebhookURL       string
+}
+
+// DefaultAnomalyOptions returns the default AnomalyOptions.
+func DefaultAnomalyOptions() AnomalyOptions {
+	return AnomalyOptions{
+		Window:           DefaultAnomalyWindow,
+		Threshold:        DefaultAnomalyThreshold,
+		MinCount:         DefaultAnomalyMinCount,
+		MinBaselineCount: DefaultAnomalyMinBaselineCount,
+		LookbackDays:     DefaultAnomalyLookbackDays,
+		Location:         scrapers.DefaultTimeZone,
+	}
+}
+
+// NewAnomalyOptions returns the AnomalyOptions of cfg, the days are the ones
+// of scrapers.DefaultTimeZone.
+func NewAnomalyOptions(cfg global.AnomalyConfig) AnomalyOptions {
+	return AnomalyOptions{
+		Window:           cfg.Window,
+		Threshold:        cfg.Threshold,
+		MinCount:         cfg.MinCount,
+		MinBaselineCount: cfg.MinBaselineCount,
+		LookbackDays:     cfg.LookbackDays,
+		Location:         scrapers.DefaultTimeZone,
+		WebhookURL:       cfg.WebhookURL,
+	}
+}
+
+// KeywordAnomaly is a day a keyword was mentioned well above its baseline, it
+// is published as the payload of the KeywordAnomalyDetected event.
+type KeywordAnomaly struct {
+	KeywordID      int32     `json:"keyword_id"`
+	Term           string    `json:"term"`
+	Date           time.Time `json:"date"`
+	Count          int       `json:"count"`
+	BaselineMean   float64   `json:"baseline_mean"`
+	BaselineStddev float64   `json:"baseline_stddev"`
+	ZScore         float64   `json:"zscore"`
+}
+
+// DailySeries is the number of mentions of a keyword per day, Counts[i] being
+// the count of the day From plus i days.
+type DailySeries struct {
+	KeywordID int32
+	Term      string
+	From      time.Time
+	Counts    []int
+}
+
+// Day returns the date of Counts[i].
+func (s DailySeries) Day(i int) time.Time {
+	return s.From.AddDate(0, 0, i)
+}
+
+// NewDailySeries builds the series of every keyword in rows over the days
+// days from the day from. The days without a row are counted as zero, the
+// rows outside of the days are ignored.
+func NewDailySeries(rows []models.KeywordTrendRow, from time.Time, days int) []DailySeries {
+	var series []DailySeries
+	index := map[int32]int{}
+	for _, row := range rows {
+		if !row.Day.Valid {
+			continue
+		}
+
+		day := time.Date(row.Day.Time.Year(), row.Day.Time.Month(), row.Day.Time.Day(),
+			0, 0, 0, 0, from.Location())
+		i := int(math.Round(day.Sub(from).Hours() / 24))
+		if i < 0 || i >= days {
+			continue
+		}
+
+		j, ok := index[row.KeywordID]
+		if !ok {
+			j = len(series)
+			index[row.KeywordID] = j
+			series = append(series, DailySeries{
+				KeywordID: row.KeywordID,
+				Term:      row.Term,
+				From:      from,
+				Counts:    make([]int, days),
+			})
+		}
+		series[j].Counts[i] += int(row.Count)
+	}
+	return series
+}
+
+// Baseline returns the mean and the population standard deviation of counts.
+func Baseline(counts []int) (mean, stddev float64) {
+	if len(counts) == 0 {
+		return 0, 0
+	}
+
+	for _, c := range counts {
+		mean += float64(c)
+	}
+	mean /= float64(len(counts))
+
+	for _, c := range counts {
+		d := float64(c) - mean
+		stddev += d * d
+	}
+	return mean, math.Sqrt(stddev / float64(len(counts)))
+}
+
+// DetectSpikes evaluates the last LookbackDays days of s, each against the
+// Window days before it. A day is flagged if its count reaches MinCount and
+// exceeds the baseline mean by more than Threshold standard deviations. The
+// days whose baseline has fewer than MinBaselineCount mentions are skipped.
+//
+// A nearly constant series has a deviation close to zero, which would flag
+// the slightest rise of a popular keyword. The deviation is therefore at least
+// the square root of the mean, the deviation of a Poisson count of that mean.
+func DetectSpikes(s DailySeries, opts AnomalyOptions) []KeywordAnomaly {
+	var anomalies []KeywordAnomaly
+	for i := max(len(s.Counts)-opts.LookbackDays, opts.Window); i < len(s.Counts); i++ {
+		baseline := s.Counts[i-opts.Window : i]
+
+		total := 0
+		for _, c := range baseline {
+			total += c
+		}
+		if total < opts.MinBaselineCount {
+			continue
+		}
+
+		count := s.Counts[i]
+		if count < opts.MinCount {
+			continue
+		}
+
+		mean, stddev := Baseline(baseline)
+		stddev = max(stddev, math.Sqrt(mean))
+		zscore := (float64(count) - mean) / stddev
+		if zscore <= opts.Threshold {
+			continue
+		}
+
+		anomalies = append(anomalies, KeywordAnomaly{
+			KeywordID:      s.KeywordID,
+			Term:           s.Term,
+			Date:           s.Day(i),
+			Count:          count,
+			BaselineMean:   mean,
+			BaselineStddev: stddev,
+			ZScore:         zscore,
+		})
+	}
+	return anomalies
+}
+
+// AnomalyDetector periodically flags the keywords whose daily mention count
+// spikes above their baseline, and alerts on every new anomaly.
+type AnomalyDetector struct {
+	store   AnomalyStore
+	pub     EventPublisher
+	webhook WebhookDispatcher
+	opts    AnomalyOptions
+	clock   clockid.Clock
+}
+
+// NewAnomalyDetector creates an AnomalyDetector. pub and webhook may be nil,
+// in which case no event is published or no webhook is called. The webhook is
+// only called if the options have a WebhookURL.
+func NewAnomalyDetector(store AnomalyStore, pub EventPublisher, webhook WebhookDispatcher,
+	opts AnomalyOptions) (*AnomalyDetector, error) {
+	if store == nil {
+		return nil, fmt.Errorf("anomaly store should not be nil")
+	}
+
+	if opts.Window < 2 {
+		return nil, fmt.Errorf("anomaly window should be at least 2 days: %d", opts.Window)
+	}
+
+	if opts.Threshold <= 0 {
+		return nil, fmt.Errorf("anomaly threshold should be positive: %v", opts.Threshold)
+	}
+
+	if opts.LookbackDays <= 0 {
+		opts.LookbackDays = DefaultAnomalyLookbackDays
+	}
+
+	if opts.Location == nil {
+		opts.Location = DefaultAnomalyOptions().Location
+	}
+
+	return &AnomalyDetector{
+		store:   store,
+		pub:     pub,
+		webhook: webhook,
+		opts:    opts,
+		clock:   clockid.Real,
+	}, nil
+}
+
+// WithClock makes the detector take the time and tick on c.
+func (d *AnomalyDetector) WithClock(c clockid.Clock) *AnomalyDetector {
+	d.clock = c
+	return d
+}
+
+// RunOnce evaluates the last LookbackDays complete days before now and
+// returns the anomalies not flagged before. The day of now is still in
+// progress and is left out.
+func (d *AnomalyDetector) RunOnce(ctx context.Context, now time.Time) ([]KeywordAnomaly, error) {
+	now = now.In(d.opts.Location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, d.opts.Location)
+	days := d.opts.Window + d.opts.LookbackDays
+	from := today.AddDate(0, 0, -days)
+
+	rows, err := d.store.Trend(ctx, d.opts.Location, from, today)
+	if err != nil {
+		return nil, err
+	}
+
+	var flagged []KeywordAnomaly
+	for _, s := range NewDailySeries(rows, from, days) {
+		for _, a := range DetectSpikes(s, d.opts) {
+			ok, err := d.record(ctx, a)
+			if err != nil {
+				return flagged, err
+			}
+
+			if ok {
+				flagged = append(flagged, a)
+				d.alert(ctx, a)
+			}
+		}
+	}
+	return flagged, ctx.Err()
+}
+
+// record stores a. ok is false if the keyword has already been flagged on
+// that day.
+func (d *AnomalyDetector) record(ctx context.Context, a KeywordAnomaly) (ok bool, err error) {
+	date, err := utils.TimeTo.PGDate(a.Date)
+	if err != nil {
+		return false, fmt.Errorf("failed to convert date %s: %w", a.Date.Format(time.DateOnly), err)
+	}
+
+	_, ok, err = d.store.Record(ctx, models.InsertKeywordAnomalyParams{
+		KeywordID:      a.KeywordID,
+		Date:           date,
+		Count:          int32(a.Count),
+		BaselineMean:   a.BaselineMean,
+		BaselineStddev: a.BaselineStddev,
+		Zscore:         a.ZScore,
+	})
+	return ok, err
+}
+
+// alert publishes a and delivers it to the webhook. The anomaly is recorded
+// already, so the failures are logged and not retried.
+func (d *AnomalyDetector) alert(ctx context.Context, a KeywordAnomaly) {
+	keywordAnomaliesTotal.Inc()
+	global.Logger.Info().
+		Int32("keyword_id", a.KeywordID).
+		Str("term", a.Term).
+		Str("date", a.Date.Format(time.DateOnly)).
+		Int("count", a.Count).
+		Float64("zscore", a.ZScore).
+		Msg("Keyword anomaly flagged")
+
+	if d.pub != nil {
+		if err := d.pub.PublishNATSMessage(ctx, KeywordAnomalyDetected, a,
+			attribute.Int("keyword_id", int(a.KeywordID)),
+			attribute.String("date", a.Date.Format(time.DateOnly))); err != nil {
+			global.Logger.Error().
+				Err(err).
+				Int32("keyword_id", a.KeywordID).
+				Msg("Failed to publish keyword anomaly")
+		}
+	}
+
+	if d.webhook != nil && d.opts.WebhookURL != "" {
+		if err := d.webhook.Dispatch(ctx, d.opts.WebhookURL, KeywordAnomalyEvent, a); err != nil {
+			global.Logger.Warn().
+				Err(err).
+				Int32("keyword_id", a.KeywordID).
+				Msg("Failed to deliver keyword anomaly")
+		}
+	}
+}
+
+// Run runs a detection pass every interval until ctx is cancelled.
+func (d *AnomalyDetector) Run(ctx context.Context, interval time.Duration) {
+	ticker := d.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		flagged, err := d.RunOnce(ctx, d.clock.Now())
+		if err != nil {
+			global.Logger.Error().Err(err).Msg("Keyword anomaly detection failed")
+		} else {
+			global.Logger.Info().
+				Int("flagged", len(flagged)).
+				Msg("Keyword anomaly detection finished")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package workers_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAnomalyStore returns its rows whatever the range asked for, so the tests
+// see what the detector does with the days outside of it.
+type fakeAnomalyStore struct {
+	mu       sync.Mutex
+	rows     []models.KeywordTrendRow
+	recorded map[int32]map[string]models.InsertKeywordAnomalyParams
+	from, to time.Time
+	// passes, if set, receives the end of the range of every Trend call.
+	passes chan time.Time
+}
+
+func (s *fakeAnomalyStore) Trend(ctx context.Context, loc *time.Location, from, to time.Time) ([]models.KeywordTrendRow, error) {
+	s.mu.Lock()
+	s.from, s.to = from, to
+	rows := append([]models.KeywordTrendRow{}, s.rows...)
+	s.mu.Unlock()
+
+	if s.passes != nil {
+		s.passes <- to
+	}
+	return rows, nil
+}
+
+func (s *fakeAnomalyStore) Record(ctx context.Context, arg models.InsertKeywordAnomalyParams) (models.KeywordAnomaly, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recorded == nil {
+		s.recorded = map[int32]map[string]models.InsertKeywordAnomalyParams{}
+	}
+
+	if s.recorded[arg.KeywordID] == nil {
+		s.recorded[arg.KeywordID] = map[string]models.InsertKeywordAnomalyParams{}
+	}
+
+	day := arg.Date.Time.Format(time.DateOnly)
+	if _, ok := s.recorded[arg.KeywordID][day]; ok {
+		return models.KeywordAnomaly{}, false, nil
+	}
+	s.recorded[arg.KeywordID][day] = arg
+	return models.KeywordAnomaly{KeywordID: arg.KeywordID, Date: arg.Date, Count: arg.Count}, true, nil
+}
+
+// add appends the counts of a keyword, counts[i] being the count of the day
+// from plus i days. The zero counts are left out, as the query does.
+func (s *fakeAnomalyStore) add(id int32, term string, from time.Time, counts []int) {
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+
+		day := from.AddDate(0, 0, i)
+		s.rows = append(s.rows, models.KeywordTrendRow{
+			KeywordID: id,
+			Term:      term,
+			Day: pgtype.Date{
+				Time:  time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC),
+				Valid: true,
+			},
+			Count: int32(c),
+		})
+	}
+}
+
+type webhookCall struct {
+	URL   string
+	Event string
+}
+
+type fakeWebhook struct {
+	calls []webhookCall
+}
+
+func (w *fakeWebhook) Dispatch(ctx context.Context, url, event string, payload any) error {
+	w.calls = append(w.calls, webhookCall{url, event})
+	return nil
+}
+
+// noisy returns n counts cycling through pattern.
+func noisy(n int, pattern ...int) []int {
+	counts := make([]int, n)
+	for i := range counts {
+		counts[i] = pattern[i%len(pattern)]
+	}
+	return counts
+}
+
+func series(counts []int) workers.DailySeries {
+	return workers.DailySeries{
+		KeywordID: 1,
+		Term:      "罷免",
+		From:      time.Date(2025, 6, 1, 0, 0, 0, 0, scrapers.DefaultTimeZone),
+		Counts:    counts,
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	mean, stddev := workers.Baseline([]int{2, 4, 4, 4, 5, 5, 7, 9})
+	require.InDelta(t, 5.0, mean, 1e-9)
+	require.InDelta(t, 2.0, stddev, 1e-9)
+
+	mean, stddev = workers.Baseline(nil)
+	require.Zero(t, mean)
+	require.Zero(t, stddev)
+}
+
+func TestNewDailySeries(t *testing.T) {
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, scrapers.DefaultTimeZone)
+	store := &fakeAnomalyStore{}
+	store.add(1, "罷免", from, []int{0, 3, 0, 0, 2})
+	store.add(2, "颱風", from, []int{1})
+	// outside of the days
+	store.add(1, "罷免", from.AddDate(0, 0, -1), []int{9})
+	store.add(1, "罷免", from.AddDate(0, 0, 5), []int{9})
+
+	got := workers.NewDailySeries(store.rows, from, 5)
+	require.Len(t, got, 2)
+	require.Equal(t, int32(1), got[0].KeywordID)
+	require.Equal(t, []int{0, 3, 0, 0, 2}, got[0].Counts)
+	require.Equal(t, int32(2), got[1].KeywordID)
+	require.Equal(t, []int{1, 0, 0, 0, 0}, got[1].Counts)
+	require.Equal(t, from.AddDate(0, 0, 4), got[0].Day(4))
+}
+
+func TestDetectSpikes(t *testing.T) {
+	opts := workers.DefaultAnomalyOptions()
+	opts.LookbackDays = 1
+	n := opts.Window + 1
+
+	tcs := []struct {
+		name    string
+		counts  []int
+		flagged bool
+	}{
+		{
+			name:    "planted spike",
+			counts:  append(noisy(opts.Window, 1, 3, 2, 0, 2), 30),
+			flagged: true,
+		},
+		{
+			name:   "uniformly popular",
+			counts: noisy(n, 40, 43, 37, 41, 39),
+		},
+		{
+			name:   "constant series with a rise",
+			counts: append(noisy(opts.Window, 40), 50),
+		},
+		{
+			name:   "spike below the min count",
+			counts: append(noisy(opts.Window, 0, 0, 0, 1), opts.MinCount-1),
+		},
+		{
+			name:   "no baseline",
+			counts: append(make([]int, opts.Window), 30),
+		},
+		{
+			name:   "spike before the lookback days",
+			counts: append(append(noisy(opts.Window-1, 1, 3, 2, 0, 2), 30), 2),
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Len(t, tc.counts, n)
+			got := workers.DetectSpikes(series(tc.counts), opts)
+			if !tc.flagged {
+				require.Empty(t, got)
+				return
+			}
+
+			require.Len(t, got, 1)
+			a := got[0]
+			require.Equal(t, 30, a.Count)
+			require.Equal(t, series(tc.counts).Day(n-1), a.Date)
+			require.Greater(t, a.ZScore, opts.Threshold)
+			require.InDelta(t, (float64(a.Count)-a.BaselineMean)/a.BaselineStddev, a.ZScore, 1e-9)
+		})
+	}
+}
+
+func TestAnomalyDetectorRunOnce(t *testing.T) {
+	opts := workers.DefaultAnomalyOptions()
+	opts.WebhookURL = "https://hooks.example.com/anomalies"
+
+	now := time.Date(2025, 6, 30, 15, 4, 5, 0, scrapers.DefaultTimeZone)
+	today := time.Date(2025, 6, 30, 0, 0, 0, 0, scrapers.DefaultTimeZone)
+	from := today.AddDate(0, 0, -(opts.Window + opts.LookbackDays))
+
+	store := &fakeAnomalyStore{}
+	days := opts.Window + opts.LookbackDays
+	// spike yesterday
+	store.add(1, "罷免", from, append(noisy(days-1, 1, 3, 2, 0, 2), 30))
+	// spike today, the day is not over yet
+	store.add(2, "颱風", from, append(noisy(days, 1, 3, 2, 0, 2), 30))
+	store.add(3, "立法院", from, noisy(days+1, 40, 43, 37, 41, 39))
+
+	pub := &fakePublisher{}
+	hook := &fakeWebhook{}
+	d, err := workers.NewAnomalyDetector(store, pub, hook, opts)
+	require.NoError(t, err)
+
+	flagged, err := d.RunOnce(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, from, store.from)
+	require.Equal(t, today, store.to)
+	require.Len(t, flagged, 1)
+	require.Equal(t, int32(1), flagged[0].KeywordID)
+	require.Equal(t, today.AddDate(0, 0, -1), flagged[0].Date)
+	require.Equal(t, "2025-06-29", store.recorded[1]["2025-06-29"].Date.Time.Format(time.DateOnly))
+	require.Equal(t, []string{workers.KeywordAnomalyDetected}, pub.subjects)
+	require.Equal(t, []webhookCall{{opts.WebhookURL, workers.KeywordAnomalyEvent}}, hook.calls)
+
+	// the anomaly has been flagged already
+	flagged, err = d.RunOnce(context.Background(), now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, flagged)
+	require.Len(t, pub.subjects, 1)
+	require.Len(t, hook.calls, 1)
+}
+
+func TestNewAnomalyDetector(t *testing.T) {
+	_, err := workers.NewAnomalyDetector(nil, nil, nil, workers.DefaultAnomalyOptions())
+	require.Error(t, err)
+
+	opts := workers.DefaultAnomalyOptions()
+	opts.Window = 1
+	_, err = workers.NewAnomalyDetector(&fakeAnomalyStore{}, nil, nil, opts)
+	require.Error(t, err)
+
+	opts = workers.DefaultAnomalyOptions()
+	opts.Threshold = 0
+	_, err = workers.NewAnomalyDetector(&fakeAnomalyStore{}, nil, nil, opts)
+	require.Error(t, err)
+
+	// no publisher nor webhook
+	d, err := workers.NewAnomalyDetector(&fakeAnomalyStore{}, nil, nil, workers.DefaultAnomalyOptions())
+	require.NoError(t, err)
+	_, err = d.RunOnce(context.Background(), time.Now())
+	require.NoError(t, err)
+}
+
+func TestAnomalyDetectorRun(t *testing.T) {
+	now := time.Date(2025, 6, 30, 23, 30, 0, 0, scrapers.DefaultTimeZone)
+	today := time.Date(2025, 6, 30, 0, 0, 0, 0, scrapers.DefaultTimeZone)
+	clock := clockid.NewFake(now)
+	store := &fakeAnomalyStore{passes: make(chan time.Time)}
+
+	d, err := workers.NewAnomalyDetector(store, nil, nil, workers.DefaultAnomalyOptions())
+	require.NoError(t, err)
+	d.WithClock(clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx, workers.DefaultAnomalyInterval)
+	}()
+
+	// the first pass runs right away, the next one an interval later, past
+	// midnight
+	require.Equal(t, today, <-store.passes)
+	clock.BlockUntil(1)
+	clock.Advance(workers.DefaultAnomalyInterval)
+	require.Equal(t, today.AddDate(0, 0, 1), <-store.passes)
+
+	cancel()
+	<-done
+	require.Zero(t, clock.Waiters())
+}
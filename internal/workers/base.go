@@ -5,17 +5,21 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel/trace"
 )
 
 // BaseWorker provides a convenient struct with common dependencies that can be
-// embedded into concrete worker implementations to reduce boilerplate.
+// embedded into concrete worker implementations to reduce boilerplate. The
+// workers take the time from Clock and the ids from IDs.
 type BaseWorker struct {
 	JS     nats.JetStreamContext
 	Logger zerolog.Logger
 	Tracer trace.Tracer
+	Clock  clockid.Clock
+	IDs    clockid.IDGen
 }
 
 // Log creates a new zerolog.Event with a set of common, standardized fields
@@ -26,7 +30,7 @@ func (w BaseWorker) Log(cmd BaseMessage, lvl zerolog.Level, start time.Time, att
 	event.Str("task_id", cmd.TaskID.String()).
 		Str("cache_key", cmd.CacheKey).
 		Int32("user_id", cmd.UserID).
-		Int64("elapsed_ms", time.Since(start).Microseconds())
+		Int64("elapsed_ms", w.Clock.Since(start).Microseconds())
 
 	for k, v := range attrs {
 		switch v := v.(type) {
@@ -41,7 +45,8 @@ func (w BaseWorker) Log(cmd BaseMessage, lvl zerolog.Level, start time.Time, att
 	return event
 }
 
-// NewBaseWorker creates a new instance of BaseWorker.
+// NewBaseWorker creates a new instance of BaseWorker, on the real clock and
+// random ids.
 func NewBaseWorker(nc *nats.Conn, logger zerolog.Logger, tracer trace.Tracer) (*BaseWorker, error) {
 	js, err := nc.JetStream()
 	if err != nil {
@@ -52,5 +57,7 @@ func NewBaseWorker(nc *nats.Conn, logger zerolog.Logger, tracer trace.Tracer) (*
 		JS:     js,
 		Logger: logger,
 		Tracer: tracer,
+		Clock:  clockid.Real,
+		IDs:    clockid.Random,
 	}, nil
 }
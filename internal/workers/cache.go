@@ -0,0 +1,29 @@
+package workers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ArtifactCacheTTL is how long the pipeline keeps the artifacts of a task in
+// Valkey for the next stages.
+const ArtifactCacheTTL = 3 * time.Hour
+
+// ContentCacheKey is the Valkey key of the scraped content of the article of
+// a task.
+func ContentCacheKey(taskID uuid.UUID) string {
+	return fmt.Sprintf("%s.article.content", taskID)
+}
+
+// KeywordsCacheKey is the Valkey key of the keyword terms of the article of a
+// task, a JSON array.
+func KeywordsCacheKey(taskID uuid.UUID) string {
+	return fmt.Sprintf("%s.article.keywords", taskID)
+}
+
+// TitleCacheKey is the Valkey key of the title of a task.
+func TitleCacheKey(taskID uuid.UUID) string {
+	return fmt.Sprintf("task.%s.title", taskID)
+}
@@ -0,0 +1,512 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// DefaultDiscoveryInterval is the interval between two discovery passes.
+	DefaultDiscoveryInterval = 5 * time.Minute
+	// DefaultDiscoveryMaxPerRun is the number of tasks created per pass.
+	DefaultDiscoveryMaxPerRun = 50
+	// DefaultDiscoveryMaxPerHour is the number of tasks created per hour.
+	DefaultDiscoveryMaxPerHour = 300
+	// DefaultDiscoveryMaxAge is the age past which a listed article is
+	// skipped.
+	DefaultDiscoveryMaxAge = 48 * time.Hour
+	// DefaultDiscoveryFreshWindow is the age under which a listed article is
+	// scraped with PriorityHigh.
+	DefaultDiscoveryFreshWindow = 2 * time.Hour
+	// DefaultDiscoverySeenTTL is how long a URL is remembered as seen.
+	DefaultDiscoverySeenTTL = 7 * 24 * time.Hour
+)
+
+// YahooNewsHost is the host of the articles the discovery creates tasks for,
+// the only one the API accepts too.
+const YahooNewsHost = "tw.news.yahoo.com"
+
+// DiscoverySeenKeyPrefix is the prefix of the Valkey keys of the seen URLs.
+const DiscoverySeenKeyPrefix = "discovery.seen."
+
+var discoveredArticlesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "discovered_articles_total",
+	Help: "Number of articles listed by the news feeds, by outcome of the discovery.",
+}, []string{"outcome"})
+
+// DiscoveryStore looks the discovered URLs up and creates their tasks. It is
+// implemented by storage.Tasks.
+type DiscoveryStore interface {
+	KnownURLs(ctx context.Context, urls []string) ([]string, error)
+	InsertFromURLAs(ctx context.Context, ownerID, url string,
+		fn func(ctx context.Context, taskID uuid.UUID) error) (uuid.UUID, error)
+}
+
+// SeenSet remembers the URLs the discovery has dealt with, so that they are
+// not looked up in the database on every pass. It is implemented by
+// ValkeySeenSet.
+type SeenSet interface {
+	Seen(ctx context.Context, url string) (bool, error)
+	MarkSeen(ctx context.Context, url string) error
+}
+
+// ValkeySeenSet is a SeenSet keeping a key per URL in Valkey, expiring after
+// TTL.
+type ValkeySeenSet struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewValkeySeenSet creates a ValkeySeenSet, the URLs are remembered for
+// DefaultDiscoverySeenTTL if ttl is not positive.
+func NewValkeySeenSet(client *redis.Client, ttl time.Duration) *ValkeySeenSet {
+	if ttl <= 0 {
+		ttl = DefaultDiscoverySeenTTL
+	}
+	return &ValkeySeenSet{client: client, ttl: ttl}
+}
+
+func (s *ValkeySeenSet) Seen(ctx context.Context, url string) (bool, error) {
+	n, err := s.client.Exists(ctx, DiscoverySeenKeyPrefix+url).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to look up seen url: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (s *ValkeySeenSet) MarkSeen(ctx context.Context, url string) error {
+	if err := s.client.Set(ctx, DiscoverySeenKeyPrefix+url, 1, s.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to mark url as seen: %w", err)
+	}
+	return nil
+}
+
+// DiscoveryOptions configures a YahooDiscovery.
+type DiscoveryOptions struct {
+	// Feeds are the URLs of the news sitemaps or RSS feeds.
+	Feeds []string
+	// Allowlist are the terms an article should mention in its title or its
+	// keywords, case insensitively. All the articles are kept if it is empty.
+	Allowlist   []string
+	MaxPerRun   int
+	MaxPerHour  int // 0 for no hourly budget
+	MaxAge      time.Duration
+	FreshWindow time.Duration
+}
+
+// DefaultDiscoveryOptions returns the default DiscoveryOptions of feeds.
+func DefaultDiscoveryOptions(feeds ...string) DiscoveryOptions {
+	return DiscoveryOptions{
+		Feeds:       feeds,
+		MaxPerRun:   DefaultDiscoveryMaxPerRun,
+		MaxPerHour:  DefaultDiscoveryMaxPerHour,
+		MaxAge:      DefaultDiscoveryMaxAge,
+		FreshWindow: DefaultDiscoveryFreshWindow,
+	}
+}
+
+// NewDiscoveryOptions returns the DiscoveryOptions of cfg.
+func NewDiscoveryOptions(cfg global.DiscoveryConfig) DiscoveryOptions {
+	return DiscoveryOptions{
+		Feeds:       cfg.Feeds,
+		Allowlist:   cfg.Allowlist,
+		MaxPerRun:   cfg.MaxPerRun,
+		MaxPerHour:  cfg.MaxPerHour,
+		MaxAge:      cfg.MaxAge,
+		FreshWindow: cfg.FreshWindow,
+	}
+}
+
+// DiscoverySummary is the summary of a discovery pass, it is published as the
+// payload of the ArticlesDiscovered event. Each listed article is counted
+// once under Filtered, Stale, Duplicates or New, and each new one under
+// Capped or Enqueued unless its task could not be created.
+type DiscoverySummary struct {
+	Feeds       int       `json:"feeds"`
+	NotModified int       `json:"not_modified"`
+	Discovered  int       `json:"discovered"`
+	Filtered    int       `json:"filtered"`
+	Stale       int       `json:"stale"`
+	Duplicates  int       `json:"duplicates"`
+	New         int       `json:"new"`
+	Capped      int       `json:"capped"`
+	Enqueued    int       `json:"enqueued"`
+	Prioritized int       `json:"prioritized"`
+	Errors      int       `json:"errors"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// YahooDiscovery periodically reads the Yahoo news feeds and creates a task,
+// owned by storage.SystemOwnerID, for each new article, so that the news are
+// ingested soon after they are published rather than when a user submits
+// them. The freshest articles are scraped with PriorityHigh.
+type YahooDiscovery struct {
+	store   DiscoveryStore
+	seen    SeenSet
+	fetcher *scrapers.FeedFetcher
+	pub     EventPublisher
+	opts    DiscoveryOptions
+	clock   clockid.Clock
+
+	mu sync.Mutex
+	// enqueued are the times the tasks of the last hour were created at.
+	enqueued []time.Time
+}
+
+// NewYahooDiscovery creates a YahooDiscovery. seen may be nil, in which case
+// every listed URL is looked up in store. pub publishes both the scrape
+// commands and the summary event.
+func NewYahooDiscovery(store DiscoveryStore, seen SeenSet, fetcher *scrapers.FeedFetcher,
+	pub EventPublisher, opts DiscoveryOptions) (*YahooDiscovery, error) {
+	if store == nil {
+		return nil, fmt.Errorf("discovery store should not be nil")
+	}
+
+	if fetcher == nil {
+		return nil, fmt.Errorf("feed fetcher should not be nil")
+	}
+
+	if pub == nil {
+		return nil, fmt.Errorf("event publisher should not be nil")
+	}
+
+	if len(opts.Feeds) == 0 {
+		return nil, fmt.Errorf("at least one feed should be given")
+	}
+
+	if opts.MaxPerRun <= 0 {
+		return nil, fmt.Errorf("max tasks per run should be positive: %d", opts.MaxPerRun)
+	}
+
+	if opts.MaxPerHour < 0 {
+		return nil, fmt.Errorf("max tasks per hour should not be negative: %d", opts.MaxPerHour)
+	}
+
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = DefaultDiscoveryMaxAge
+	}
+
+	allowlist := make([]string, 0, len(opts.Allowlist))
+	for _, term := range opts.Allowlist {
+		if term = strings.ToLower(strings.TrimSpace(term)); term != "" {
+			allowlist = append(allowlist, term)
+		}
+	}
+	opts.Allowlist = allowlist
+
+	return &YahooDiscovery{
+		store:   store,
+		seen:    seen,
+		fetcher: fetcher,
+		pub:     pub,
+		opts:    opts,
+		clock:   clockid.Real,
+	}, nil
+}
+
+// WithClock makes the worker take the time and tick on c.
+func (w *YahooDiscovery) WithClock(c clockid.Clock) *YahooDiscovery {
+	w.clock = c
+	return w
+}
+
+// RunOnce reads the feeds and creates the tasks of the new articles, newest
+// first, within the per run and the hourly budgets. The articles left over
+// are not marked as seen and are picked up by a later pass, unless they are
+// stale by then.
+func (w *YahooDiscovery) RunOnce(ctx context.Context) (DiscoverySummary, error) {
+	now := w.clock.Now()
+	summary := DiscoverySummary{StartedAt: now}
+
+	var candidates []scrapers.FeedEntry
+	listed := map[string]bool{}
+	for _, feed := range w.opts.Feeds {
+		entries, modified, err := w.fetcher.Fetch(ctx, feed)
+		if err != nil {
+			if ctx.Err() != nil {
+				return summary, ctx.Err()
+			}
+			global.Logger.Error().
+				Err(err).
+				Str("feed", feed).
+				Msg("Failed to fetch news feed")
+			summary.Errors++
+			continue
+		}
+
+		summary.Feeds++
+		if !modified {
+			summary.NotModified++
+			continue
+		}
+
+		summary.Discovered += len(entries)
+		for _, entry := range entries {
+			u, err := scrapers.CanonicalURL(entry.URL)
+			if err != nil || !isYahooNewsURL(u) {
+				summary.Filtered++
+				continue
+			}
+			entry.URL = u
+
+			switch {
+			case listed[u]:
+				summary.Duplicates++
+			case !w.allowed(entry):
+				summary.Filtered++
+			case !entry.Published.IsZero() && now.Sub(entry.Published) > w.opts.MaxAge:
+				summary.Stale++
+			default:
+				listed[u] = true
+				candidates = append(candidates, entry)
+			}
+		}
+	}
+
+	candidates, err := w.unseen(ctx, candidates, &summary)
+	if err != nil {
+		return summary, err
+	}
+	summary.New = len(candidates)
+
+	// newest first, the articles without a publication time last
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Published.After(candidates[j].Published)
+	})
+
+	if budget := w.budget(now); len(candidates) > budget {
+		summary.Capped = len(candidates) - budget
+		candidates = candidates[:budget]
+	}
+
+	for _, entry := range candidates {
+		if ctx.Err() != nil {
+			break
+		}
+
+		priority := PriorityNormal
+		if w.opts.FreshWindow > 0 && !entry.Published.IsZero() &&
+			now.Sub(entry.Published) <= w.opts.FreshWindow {
+			priority = PriorityHigh
+		}
+
+		if err := w.enqueue(ctx, entry.URL, priority, now); err != nil {
+			global.Logger.Error().
+				Err(err).
+				Str("url", entry.URL).
+				Msg("Failed to create task for discovered article")
+			summary.Errors++
+			continue
+		}
+
+		summary.Enqueued++
+		if priority == PriorityHigh {
+			summary.Prioritized++
+		}
+	}
+	summary.FinishedAt = w.clock.Now()
+
+	for outcome, n := range map[string]int{
+		"filtered":  summary.Filtered,
+		"stale":     summary.Stale,
+		"duplicate": summary.Duplicates,
+		"capped":    summary.Capped,
+		"enqueued":  summary.Enqueued,
+	} {
+		discoveredArticlesTotal.WithLabelValues(outcome).Add(float64(n))
+	}
+
+	if err := w.pub.PublishNATSMessage(ctx, ArticlesDiscovered, summary,
+		attribute.Int("discovered", summary.Discovered),
+		attribute.Int("enqueued", summary.Enqueued)); err != nil {
+		return summary, err
+	}
+	return summary, ctx.Err()
+}
+
+func isYahooNewsURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Hostname() == YahooNewsHost
+}
+
+// allowed reports whether entry mentions one of the allowlist terms.
+func (w *YahooDiscovery) allowed(entry scrapers.FeedEntry) bool {
+	if len(w.opts.Allowlist) == 0 {
+		return true
+	}
+
+	title := strings.ToLower(entry.Title)
+	for _, term := range w.opts.Allowlist {
+		if strings.Contains(title, term) {
+			return true
+		}
+
+		for _, keyword := range entry.Keywords {
+			if strings.Contains(strings.ToLower(keyword), term) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unseen returns the entries neither in the seen set nor in the store. The
+// ones found in the store are added to the seen set.
+func (w *YahooDiscovery) unseen(ctx context.Context, entries []scrapers.FeedEntry,
+	summary *DiscoverySummary) ([]scrapers.FeedEntry, error) {
+	if w.seen != nil {
+		kept := entries[:0]
+		for _, entry := range entries {
+			seen, err := w.seen.Seen(ctx, entry.URL)
+			if err != nil {
+				// the store tells the known URLs apart anyway
+				global.Logger.Warn().
+					Err(err).
+					Str("url", entry.URL).
+					Msg("Failed to look up seen URL")
+			}
+
+			if seen {
+				summary.Duplicates++
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		entries = kept
+	}
+
+	if len(entries) == 0 {
+		return entries, nil
+	}
+
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = entry.URL
+	}
+
+	known, err := w.store.KnownURLs(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+
+	isKnown := make(map[string]bool, len(known))
+	for _, u := range known {
+		isKnown[u] = true
+		w.markSeen(ctx, u)
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if isKnown[entry.URL] {
+			summary.Duplicates++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept, nil
+}
+
+func (w *YahooDiscovery) markSeen(ctx context.Context, u string) {
+	if w.seen == nil {
+		return
+	}
+
+	if err := w.seen.MarkSeen(ctx, u); err != nil {
+		global.Logger.Warn().
+			Err(err).
+			Str("url", u).
+			Msg("Failed to mark URL as seen")
+	}
+}
+
+// budget returns the number of tasks the pass starting at now may create.
+func (w *YahooDiscovery) budget(now time.Time) int {
+	if w.opts.MaxPerHour <= 0 {
+		return w.opts.MaxPerRun
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	recent := w.enqueued[:0]
+	for _, t := range w.enqueued {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	w.enqueued = recent
+	return max(0, min(w.opts.MaxPerRun, w.opts.MaxPerHour-len(recent)))
+}
+
+// enqueue creates the task of u and publishes its scrape command within the
+// same transaction.
+func (w *YahooDiscovery) enqueue(ctx context.Context, u string, priority Priority, now time.Time) error {
+	_, err := w.store.InsertFromURLAs(ctx, storage.SystemOwnerID, u,
+		func(ctx context.Context, taskID uuid.UUID) error {
+			err := w.pub.PublishNATSMessage(ctx, ScrapeSubject(priority), CmdScrapeArticle{
+				BaseMessage: BaseMessage{
+					Version: MessageVersion,
+					TaskID:  taskID,
+					EventAt: now.Unix(),
+				},
+				URL:      u,
+				Priority: priority,
+			}, attribute.String("priority", string(priority)))
+			if err != nil {
+				return fmt.Errorf("failed to publish scrape task: %w", err)
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.enqueued = append(w.enqueued, now)
+	w.mu.Unlock()
+	w.markSeen(ctx, u)
+	return nil
+}
+
+// Run runs a discovery pass every interval until ctx is cancelled.
+func (w *YahooDiscovery) Run(ctx context.Context, interval time.Duration) {
+	ticker := w.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		summary, err := w.RunOnce(ctx)
+		if err != nil {
+			global.Logger.Error().Err(err).Msg("News discovery failed")
+		} else {
+			global.Logger.Info().
+				Int("discovered", summary.Discovered).
+				Int("new", summary.New).
+				Int("capped", summary.Capped).
+				Int("enqueued", summary.Enqueued).
+				Int("prioritized", summary.Prioritized).
+				Msg("News discovery finished")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package workers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDiscoveryStore keeps the known URLs and the created tasks in memory.
+type fakeDiscoveryStore struct {
+	mu    sync.Mutex
+	known map[string]bool
+	// owners are the owners of the created tasks, by URL.
+	owners map[string]string
+}
+
+func newFakeDiscoveryStore(known ...string) *fakeDiscoveryStore {
+	s := &fakeDiscoveryStore{known: map[string]bool{}, owners: map[string]string{}}
+	for _, u := range known {
+		s.known[u] = true
+	}
+	return s
+}
+
+func (s *fakeDiscoveryStore) KnownURLs(ctx context.Context, urls []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var known []string
+	for _, u := range urls {
+		if s.known[u] {
+			known = append(known, u)
+		}
+	}
+	return known, nil
+}
+
+func (s *fakeDiscoveryStore) InsertFromURLAs(ctx context.Context, ownerID, url string,
+	fn func(ctx context.Context, taskID uuid.UUID) error) (uuid.UUID, error) {
+	taskID := uuid.New()
+	if err := fn(ctx, taskID); err != nil {
+		return uuid.Nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.known[url] = true
+	s.owners[url] = ownerID
+	return taskID, nil
+}
+
+type fakeSeenSet struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newFakeSeenSet(seen ...string) *fakeSeenSet {
+	s := &fakeSeenSet{seen: map[string]bool{}}
+	for _, u := range seen {
+		s.seen[u] = true
+	}
+	return s
+}
+
+func (s *fakeSeenSet) Seen(ctx context.Context, url string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen[url], nil
+}
+
+func (s *fakeSeenSet) MarkSeen(ctx context.Context, url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen[url] = true
+	return nil
+}
+
+// discoveryNow is 10:00 in Taipei.
+var discoveryNow = time.Date(2025, 6, 1, 2, 0, 0, 0, time.UTC)
+
+const discoverySitemap = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"
+        xmlns:news="http://www.google.com/schemas/sitemap-news/0.9">
+  <url>
+    <loc>https://tw.news.yahoo.com/fresh-093000001.html</loc>
+    <news:news>
+      <news:publication_date>2025-06-01T09:30:00+08:00</news:publication_date>
+      <news:title>立法院三讀通過預算案</news:title>
+      <news:keywords>國會, 預算</news:keywords>
+    </news:news>
+  </url>
+  <url>
+    <loc>https://tw.news.yahoo.com/baseball-090000002.html</loc>
+    <news:news>
+      <news:publication_date>2025-06-01T09:00:00+08:00</news:publication_date>
+      <news:title>中職開幕戰</news:title>
+      <news:keywords>運動</news:keywords>
+    </news:news>
+  </url>
+  <url>
+    <loc>https://tw.news.yahoo.com/old-090000003.html</loc>
+    <news:news>
+      <news:publication_date>2025-05-29T09:00:00+08:00</news:publication_date>
+      <news:title>總統出訪</news:title>
+    </news:news>
+  </url>
+  <url>
+    <loc>https://www.example.com/elsewhere-090000004.html</loc>
+    <news:news>
+      <news:publication_date>2025-06-01T09:00:00+08:00</news:publication_date>
+      <news:title>立法院外的新聞</news:title>
+    </news:news>
+  </url>
+  <url>
+    <loc>https://tw.news.yahoo.com/known-060000005.html</loc>
+    <news:news>
+      <news:publication_date>2025-06-01T06:00:00+08:00</news:publication_date>
+      <news:title>立法院質詢</news:title>
+    </news:news>
+  </url>
+</urlset>`
+
+const discoveryRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <item>
+      <title>立法院三讀通過預算案</title>
+      <link>https://tw.news.yahoo.com/fresh-093000001.html?utm_source=rss</link>
+      <pubDate>Sun, 01 Jun 2025 01:30:00 GMT</pubDate>
+    </item>
+    <item>
+      <title>行程異動</title>
+      <link>https://tw.news.yahoo.com/president-050000006.html</link>
+      <pubDate>Sat, 31 May 2025 21:00:00 GMT</pubDate>
+      <category>總統府</category>
+    </item>
+    <item>
+      <title>立法院休會</title>
+      <link>https://tw.news.yahoo.com/seen-070000007.html</link>
+      <pubDate>Sat, 31 May 2025 23:00:00 GMT</pubDate>
+    </item>
+  </channel>
+</rss>`
+
+// feedServer serves the feeds by path, answering a 304 to the requests
+// carrying the ETag of a feed if etag is set.
+func feedServer(t *testing.T, etag bool, feeds map[string]string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		feed, ok := feeds[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if etag {
+			tag := fmt.Sprintf("%q", r.URL.Path)
+			if r.Header.Get("If-None-Match") == tag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", tag)
+		}
+		_, _ = w.Write([]byte(feed))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newYahooDiscovery(t *testing.T, store workers.DiscoveryStore, seen workers.SeenSet,
+	pub workers.EventPublisher, clock clockid.Clock, opts workers.DiscoveryOptions) *workers.YahooDiscovery {
+	t.Helper()
+	fetcher := scrapers.NewFeedFetcher(scrapers.NewLinkChecker(nil, 1, scrapers.Delay{}, map[string]string{}))
+	w, err := workers.NewYahooDiscovery(store, seen, fetcher, pub, opts)
+	require.NoError(t, err)
+	return w.WithClock(clock)
+}
+
+// scrapes returns the scrape commands published by pub, by URL.
+func scrapes(t *testing.T, pub *fakePublisher) map[string]workers.CmdScrapeArticle {
+	t.Helper()
+
+	cmds := map[string]workers.CmdScrapeArticle{}
+	for i, subject := range pub.subjects {
+		cmd, ok := pub.payloads[i].(workers.CmdScrapeArticle)
+		if !ok {
+			continue
+		}
+		require.Equal(t, workers.ScrapeSubject(cmd.Priority), subject)
+		cmds[cmd.URL] = cmd
+	}
+	return cmds
+}
+
+func TestYahooDiscoveryFilteringAndDedup(t *testing.T) {
+	srv := feedServer(t, true, map[string]string{
+		"/sitemap.xml":  discoverySitemap,
+		"/rss/politics": discoveryRSS,
+	})
+
+	store := newFakeDiscoveryStore("https://tw.news.yahoo.com/known-060000005.html")
+	seen := newFakeSeenSet("https://tw.news.yahoo.com/seen-070000007.html")
+	pub := &fakePublisher{}
+	opts := workers.DefaultDiscoveryOptions(srv.URL+"/sitemap.xml", srv.URL+"/rss/politics")
+	opts.Allowlist = []string{"立法院", " 總統 "}
+	w := newYahooDiscovery(t, store, seen, pub, clockid.NewFake(discoveryNow), opts)
+
+	summary, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.Feeds)
+	require.Equal(t, 8, summary.Discovered)
+	// the baseball game is not about the allowlist, the example.com article
+	// is not a Yahoo news
+	require.Equal(t, 2, summary.Filtered)
+	require.Equal(t, 1, summary.Stale)
+	// the fresh article listed twice, the known and the seen ones
+	require.Equal(t, 3, summary.Duplicates)
+	require.Equal(t, 2, summary.New)
+	require.Equal(t, 2, summary.Enqueued)
+	require.Equal(t, 1, summary.Prioritized)
+	require.Zero(t, summary.Capped)
+	require.Zero(t, summary.Errors)
+
+	cmds := scrapes(t, pub)
+	require.Len(t, cmds, 2)
+	require.Equal(t, workers.PriorityHigh, cmds["https://tw.news.yahoo.com/fresh-093000001.html"].Priority)
+	require.Equal(t, workers.PriorityNormal, cmds["https://tw.news.yahoo.com/president-050000006.html"].Priority)
+	require.Equal(t, []string{
+		workers.SubjectCmd(workers.StageScrapePriority),
+		workers.SubjectCmd(workers.StageScrape),
+		workers.ArticlesDiscovered,
+	}, pub.subjects)
+
+	for _, owner := range store.owners {
+		require.Equal(t, storage.SystemOwnerID, owner)
+	}
+
+	// the known URL is remembered so the store is not asked again
+	require.True(t, seen.seen["https://tw.news.yahoo.com/known-060000005.html"])
+	require.True(t, seen.seen["https://tw.news.yahoo.com/fresh-093000001.html"])
+
+	// neither feed has changed
+	summary, err = w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.NotModified)
+	require.Zero(t, summary.Discovered)
+	require.Zero(t, summary.Enqueued)
+}
+
+// rssOf returns an RSS feed of n articles published a minute apart, the first
+// one at published.
+func rssOf(n int, published time.Time) string {
+	var b strings.Builder
+	b.WriteString(`<rss version="2.0"><channel>`)
+	for i := range n {
+		fmt.Fprintf(&b, `<item><title>新聞 %d</title><link>https://tw.news.yahoo.com/n-%03d.html</link><pubDate>%s</pubDate></item>`,
+			i, i, published.Add(-time.Duration(i)*time.Minute).Format(time.RFC1123Z))
+	}
+	b.WriteString(`</channel></rss>`)
+	return b.String()
+}
+
+func TestYahooDiscoveryCaps(t *testing.T) {
+	srv := feedServer(t, false, map[string]string{
+		"/rss": rssOf(5, discoveryNow.Add(-3*time.Hour)),
+	})
+
+	store := newFakeDiscoveryStore()
+	pub := &fakePublisher{}
+	clock := clockid.NewFake(discoveryNow)
+	opts := workers.DefaultDiscoveryOptions(srv.URL + "/rss")
+	opts.MaxPerRun = 2
+	opts.MaxPerHour = 3
+	w := newYahooDiscovery(t, store, newFakeSeenSet(), pub, clock, opts)
+
+	tcs := []struct {
+		name     string
+		advance  time.Duration
+		new      int
+		capped   int
+		enqueued []string
+	}{
+		{
+			name:     "per run cap, newest first",
+			new:      5,
+			capped:   3,
+			enqueued: []string{"n-000", "n-001"},
+		},
+		{
+			name:     "hourly budget",
+			advance:  10 * time.Minute,
+			new:      3,
+			capped:   2,
+			enqueued: []string{"n-002"},
+		},
+		{
+			name:     "budget recovered after an hour",
+			advance:  time.Hour,
+			new:      2,
+			enqueued: []string{"n-003", "n-004"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			clock.Advance(tc.advance)
+			pub.subjects, pub.payloads = nil, nil
+
+			summary, err := w.RunOnce(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, tc.new, summary.New)
+			require.Equal(t, tc.capped, summary.Capped)
+			require.Equal(t, len(tc.enqueued), summary.Enqueued)
+
+			cmds := scrapes(t, pub)
+			require.Len(t, cmds, len(tc.enqueued))
+			for _, name := range tc.enqueued {
+				cmd, ok := cmds["https://tw.news.yahoo.com/"+name+".html"]
+				require.True(t, ok, name)
+				// none of them is fresh
+				require.Equal(t, workers.PriorityNormal, cmd.Priority)
+			}
+		})
+	}
+}
+
+func TestScrapeSubject(t *testing.T) {
+	require.Equal(t, "task.cmd.scrape_priority", workers.ScrapeSubject(workers.PriorityHigh))
+	require.Equal(t, "task.cmd.scrape", workers.ScrapeSubject(workers.PriorityNormal))
+	// the commands published before the priorities are scraped as usual
+	require.Equal(t, "task.cmd.scrape", workers.ScrapeSubject(""))
+}
+
+func TestNewYahooDiscovery(t *testing.T) {
+	fetcher := scrapers.NewFeedFetcher(scrapers.NewLinkChecker(nil, 1, scrapers.Delay{}, nil))
+	store := newFakeDiscoveryStore()
+
+	_, err := workers.NewYahooDiscovery(store, nil, fetcher, &fakePublisher{}, workers.DefaultDiscoveryOptions())
+	require.ErrorContains(t, err, "feed")
+
+	opts := workers.DefaultDiscoveryOptions("https://tw.news.yahoo.com/rss/politics")
+	opts.MaxPerRun = 0
+	_, err = workers.NewYahooDiscovery(store, nil, fetcher, &fakePublisher{}, opts)
+	require.ErrorContains(t, err, "per run")
+
+	opts.MaxPerRun = 1
+	_, err = workers.NewYahooDiscovery(store, nil, fetcher, nil, opts)
+	require.ErrorContains(t, err, "publisher")
+
+	_, err = workers.NewYahooDiscovery(store, nil, fetcher, &fakePublisher{}, opts)
+	require.NoError(t, err)
+}
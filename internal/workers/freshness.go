@@ -0,0 +1,428 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// DefaultFreshnessInterval is the interval between two freshness passes.
+	DefaultFreshnessInterval = 30 * time.Minute
+	// DefaultFreshnessWindow is the age under which an article is re-checked.
+	DefaultFreshnessWindow = 48 * time.Hour
+	// DefaultFreshnessRecheckAfter is the time between two checks of an
+	// article.
+	DefaultFreshnessRecheckAfter = 2 * time.Hour
+	// DefaultFreshnessChecksPerRun is the number of articles checked per pass.
+	DefaultFreshnessChecksPerRun = 500
+	// DefaultFreshnessParallelism is the number of articles checked
+	// concurrently, the per-host limit of the LinkChecker applies on top of it.
+	DefaultFreshnessParallelism = 4
+	// DefaultMaxReprocessPerDay is the number of times an article is
+	// re-processed a day at most.
+	DefaultMaxReprocessPerDay = 3
+)
+
+const (
+	// DefaultChunkSize is the size in runes of the chunks of the articles.
+	DefaultChunkSize = 512
+	// DefaultChunkOverlap is the overlap in runes of two chunks.
+	DefaultChunkOverlap = 64
+)
+
+var articleFreshnessChecksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "article_freshness_checks_total",
+	Help: "Number of articles re-checked for the updates of their publisher, by outcome.",
+}, []string{"outcome"})
+
+// The outcomes of the check of an article.
+const (
+	freshnessNotModified = "not_modified"
+	freshnessUnchanged   = "unchanged"
+	freshnessUpdated     = "updated"
+	freshnessThrottled   = "throttled"
+	freshnessError       = "error"
+)
+
+// FreshnessStore persists the freshness state of the scraped articles and
+// their updates. It is implemented by storage.Freshness.
+type FreshnessStore interface {
+	DueForRecheck(ctx context.Context, since, checkedBefore time.Time, limit int32) ([]models.ListUsersArticlesDueForRecheckRow, error)
+	RecordCheck(ctx context.Context, articleID int32, checkedAt, modifiedAt time.Time) error
+	Reprocesses(ctx context.Context, articleID int32, since time.Time) (int64, error)
+	Update(ctx context.Context, u storage.ArticleUpdate, size, overlap int,
+		fn func(ctx context.Context, aID int32, chunks int) error) (int, error)
+}
+
+// FreshnessOptions configures a FreshnessWorker.
+type FreshnessOptions struct {
+	Window       time.Duration
+	RecheckAfter time.Duration
+	ChecksPerRun int32
+	Parallelism  int
+	// MaxReprocessPerDay is the number of updates of an article re-processed
+	// within 24 hours, the later ones wait.
+	MaxReprocessPerDay int
+	ChunkSize          int
+	ChunkOverlap       int
+}
+
+// DefaultFreshnessOptions returns the default FreshnessOptions.
+func DefaultFreshnessOptions() FreshnessOptions {
+	return FreshnessOptions{
+		Window:             DefaultFreshnessWindow,
+		RecheckAfter:       DefaultFreshnessRecheckAfter,
+		ChecksPerRun:       DefaultFreshnessChecksPerRun,
+		Parallelism:        DefaultFreshnessParallelism,
+		MaxReprocessPerDay: DefaultMaxReprocessPerDay,
+		ChunkSize:          DefaultChunkSize,
+		ChunkOverlap:       DefaultChunkOverlap,
+	}
+}
+
+// NewFreshnessOptions returns the FreshnessOptions of cfg.
+func NewFreshnessOptions(cfg global.FreshnessConfig) FreshnessOptions {
+	opts := DefaultFreshnessOptions()
+	opts.Window = cfg.Window
+	opts.RecheckAfter = cfg.RecheckAfter
+	opts.ChecksPerRun = int32(cfg.ChecksPerRun)
+	opts.Parallelism = cfg.Parallelism
+	opts.MaxReprocessPerDay = cfg.MaxReprocessPerDay
+	return opts
+}
+
+// FreshnessSummary is the summary of a freshness pass, it is published as the
+// payload of the ArticlesFreshnessChecked event. Each checked article is
+// counted once under NotModified, Unchanged, Updated, Throttled or Errors.
+type FreshnessSummary struct {
+	Checked     int       `json:"checked"`
+	NotModified int       `json:"not_modified"`
+	Unchanged   int       `json:"unchanged"`
+	Updated     int       `json:"updated"`
+	Throttled   int       `json:"throttled"`
+	Errors      int       `json:"errors"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// FreshnessWorker periodically fetches the recently scraped articles again, as
+// their publishers keep updating the developing stories. An article declaring
+// a later modification time, or with a new content if it declares none, is
+// updated: its content is replaced, the old one kept as a revision, it is
+// re-chunked and its keywords and embeddings created again.
+type FreshnessWorker struct {
+	store   FreshnessStore
+	checker *scrapers.LinkChecker
+	pub     EventPublisher
+	cache   ArtifactCache
+	opts    FreshnessOptions
+	clock   clockid.Clock
+}
+
+// NewFreshnessWorker creates a FreshnessWorker. cache may be nil, otherwise
+// the cached content of an updated article is replaced.
+func NewFreshnessWorker(store FreshnessStore, checker *scrapers.LinkChecker,
+	pub EventPublisher, cache ArtifactCache, opts FreshnessOptions) (*FreshnessWorker, error) {
+	if store == nil {
+		return nil, fmt.Errorf("freshness store should not be nil")
+	}
+
+	if checker == nil {
+		return nil, fmt.Errorf("link checker should not be nil")
+	}
+
+	if pub == nil {
+		return nil, fmt.Errorf("event publisher should not be nil")
+	}
+
+	if opts.Window <= 0 {
+		return nil, fmt.Errorf("window should be positive: %s", opts.Window)
+	}
+
+	if opts.RecheckAfter <= 0 {
+		return nil, fmt.Errorf("recheck after should be positive: %s", opts.RecheckAfter)
+	}
+
+	if opts.ChecksPerRun <= 0 {
+		return nil, fmt.Errorf("checks per run should be positive: %d", opts.ChecksPerRun)
+	}
+
+	if opts.MaxReprocessPerDay <= 0 {
+		return nil, fmt.Errorf("max reprocess per day should be positive: %d", opts.MaxReprocessPerDay)
+	}
+
+	if opts.Parallelism <= 0 {
+		opts.Parallelism = DefaultFreshnessParallelism
+	}
+
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize, opts.ChunkOverlap = DefaultChunkSize, DefaultChunkOverlap
+	}
+
+	return &FreshnessWorker{
+		store:   store,
+		checker: checker,
+		pub:     pub,
+		cache:   cache,
+		opts:    opts,
+		clock:   clockid.Real,
+	}, nil
+}
+
+// WithClock makes the worker take the time and tick on c.
+func (w *FreshnessWorker) WithClock(c clockid.Clock) *FreshnessWorker {
+	w.clock = c
+	return w
+}
+
+// RunOnce checks up to ChecksPerRun of the articles published within the
+// window and not checked for RecheckAfter, least recently checked first.
+func (w *FreshnessWorker) RunOnce(ctx context.Context) (FreshnessSummary, error) {
+	now := w.clock.Now()
+	summary := FreshnessSummary{StartedAt: now}
+
+	rows, err := w.store.DueForRecheck(ctx, now.Add(-w.opts.Window),
+		now.Add(-w.opts.RecheckAfter), w.opts.ChecksPerRun)
+	if err != nil {
+		return summary, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan models.ListUsersArticlesDueForRecheckRow)
+	for range w.opts.Parallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range jobs {
+				outcome := w.check(ctx, row)
+				articleFreshnessChecksTotal.WithLabelValues(outcome).Inc()
+				mu.Lock()
+				summary.add(outcome)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, row := range rows {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- row
+	}
+	close(jobs)
+	wg.Wait()
+	summary.FinishedAt = w.clock.Now()
+
+	if err := w.pub.PublishNATSMessage(ctx, ArticlesFreshnessChecked, summary,
+		attribute.Int("checked", summary.Checked),
+		attribute.Int("updated", summary.Updated)); err != nil {
+		return summary, err
+	}
+	return summary, ctx.Err()
+}
+
+// check checks an article and returns the outcome.
+func (w *FreshnessWorker) check(ctx context.Context, row models.ListUsersArticlesDueForRecheckRow) string {
+	logger := global.Logger.With().
+		Int32("article_id", row.ID).
+		Str("url", row.Url).
+		Logger()
+
+	var stored time.Time
+	if row.ModifiedAt.Valid {
+		stored = row.ModifiedAt.Time
+	}
+
+	// the check is recorded whatever its outcome, so that a failing article
+	// waits for its turn like the other ones
+	record := func(outcome string, modifiedAt time.Time) string {
+		if err := w.store.RecordCheck(ctx, row.ID, w.clock.Now(), modifiedAt); err != nil {
+			logger.Error().Err(err).Msg("Failed to record freshness check")
+			return freshnessError
+		}
+		return outcome
+	}
+
+	article, modified, err := scrapers.RefetchYahooNews(ctx, w.checker, row.Url, stored)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to fetch article again")
+		return record(freshnessError, time.Time{})
+	}
+
+	if !modified {
+		return record(freshnessNotModified, time.Time{})
+	}
+
+	content := strings.Join(article.Content, "")
+	declared, ok := article.DeclaredModified()
+	if !contentChanged(row, declared, ok, content) {
+		return record(freshnessUnchanged, declared)
+	}
+
+	// an article updated over and over is re-processed a few times a day at
+	// most, the latest update is picked up once the day is over
+	n, err := w.store.Reprocesses(ctx, row.ID, w.clock.Now().Add(-24*time.Hour))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to count article reprocesses")
+		return record(freshnessError, time.Time{})
+	}
+
+	if n >= int64(w.opts.MaxReprocessPerDay) {
+		logger.Warn().
+			Int64("reprocesses", n).
+			Msg("Article updated too often, reprocessing deferred")
+		return record(freshnessThrottled, time.Time{})
+	}
+
+	cuts := make([]int32, len(article.Content))
+	cLen := int32(0)
+	for i, c := range article.Content {
+		cLen += int32(len(c))
+		cuts[i] = cLen
+	}
+
+	note := "content changed during freshness check"
+	if ok {
+		note = fmt.Sprintf("modified at %s during freshness check", declared.UTC().Format(time.RFC3339))
+	}
+
+	now := w.clock.Now()
+	update := storage.ArticleUpdate{
+		ArticleID:  row.ID,
+		Title:      article.Title,
+		Content:    content,
+		Cuts:       cuts,
+		ModifiedAt: declared,
+		CheckedAt:  now,
+		Note:       note,
+	}
+	if update.Title == "" {
+		update.Title = row.Title
+	}
+
+	chunks, err := w.store.Update(ctx, update, w.opts.ChunkSize, w.opts.ChunkOverlap,
+		func(ctx context.Context, aID int32, chunks int) error {
+			return w.reprocess(ctx, row, declared, chunks, now)
+		})
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to update article")
+		return record(freshnessError, time.Time{})
+	}
+
+	// the stale content is replaced for the reconciliation, the reprocess
+	// commands carry no cache key and read the article from the database
+	if w.cache != nil {
+		if err := w.cache.Set(ctx, ContentCacheKey(row.TaskID), content, ArtifactCacheTTL); err != nil {
+			logger.Warn().Err(err).Msg("Failed to cache updated article content")
+		}
+	}
+
+	logger.Info().
+		Time("modified_at", declared).
+		Int("chunks", chunks).
+		Msg("Article updated by its publisher")
+	return freshnessUpdated
+}
+
+// reprocess publishes the commands re-creating the keywords and the embeddings
+// of the updated article, then the MsgArticleUpdated event.
+func (w *FreshnessWorker) reprocess(ctx context.Context, row models.ListUsersArticlesDueForRecheckRow,
+	modifiedAt time.Time, chunks int, now time.Time) error {
+	base := BaseMessage{
+		Version: MessageVersion,
+		TaskID:  row.TaskID,
+		EventAt: now.Unix(),
+	}
+
+	if err := w.pub.PublishNATSMessage(ctx, SubjectCmd(StageExtractKeywords), CmdExtractKeywords{
+		BaseMessage: base,
+		ArticleID:   row.ID,
+	}); err != nil {
+		return err
+	}
+
+	if err := w.pub.PublishNATSMessage(ctx, SubjectCmd(StageCreateEmbedding), CmdCreateEmbedding{
+		BaseMessage: base,
+		ArticleID:   row.ID,
+		EmbedType:   EmbedTypePassage,
+	}); err != nil {
+		return err
+	}
+
+	msg := MsgArticleUpdated{
+		BaseMessage: base,
+		ArticleID:   row.ID,
+		Chunks:      chunks,
+	}
+	if !modifiedAt.IsZero() {
+		msg.ModifiedAt = modifiedAt.Unix()
+	}
+	return w.pub.PublishNATSMessage(ctx, SubjectEvt(StageScrape, OutcomeUpdated), msg,
+		attribute.Int("article_id", int(row.ID)))
+}
+
+// contentChanged reports whether the article has been updated since it was
+// stored. The modification times are compared if both are declared, and the
+// content hashes otherwise. A later modification time with the same content
+// is no update either.
+func contentChanged(row models.ListUsersArticlesDueForRecheckRow, declared time.Time,
+	ok bool, content string) bool {
+	if ok && row.ModifiedAt.Valid && !declared.After(row.ModifiedAt.Time) {
+		return false
+	}
+	return storage.ContentHash(content) != row.ContentHash
+}
+
+func (s *FreshnessSummary) add(outcome string) {
+	s.Checked++
+	switch outcome {
+	case freshnessNotModified:
+		s.NotModified++
+	case freshnessUnchanged:
+		s.Unchanged++
+	case freshnessUpdated:
+		s.Updated++
+	case freshnessThrottled:
+		s.Throttled++
+	default:
+		s.Errors++
+	}
+}
+
+// Run runs a freshness pass every interval until ctx is cancelled.
+func (w *FreshnessWorker) Run(ctx context.Context, interval time.Duration) {
+	ticker := w.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		summary, err := w.RunOnce(ctx)
+		if err != nil {
+			global.Logger.Error().Err(err).Msg("Freshness check failed")
+		} else {
+			global.Logger.Info().
+				Int("checked", summary.Checked).
+				Int("updated", summary.Updated).
+				Int("throttled", summary.Throttled).
+				Msg("Freshness check finished")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package workers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+var freshnessNow = time.Date(2025, 5, 21, 12, 0, 0, 0, time.UTC)
+
+type articleRevision struct {
+	ArticleID int32
+	Field     string
+	OldValue  string
+	NewValue  string
+	CreatedAt time.Time
+}
+
+type fakeFreshnessStore struct {
+	mu        sync.Mutex
+	rows      map[int32]*models.ListUsersArticlesDueForRecheckRow
+	content   map[int32]string
+	revisions []articleRevision
+	checks    []int32
+}
+
+func newFakeFreshnessStore() *fakeFreshnessStore {
+	return &fakeFreshnessStore{
+		rows:    map[int32]*models.ListUsersArticlesDueForRecheckRow{},
+		content: map[int32]string{},
+	}
+}
+
+func (s *fakeFreshnessStore) add(id int32, url, content string, modifiedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row := &models.ListUsersArticlesDueForRecheckRow{
+		ID:          id,
+		TaskID:      uuid.New(),
+		Url:         url,
+		Title:       "標題",
+		ContentHash: storage.ContentHash(content),
+	}
+	if !modifiedAt.IsZero() {
+		row.ModifiedAt = pgtype.Timestamptz{Time: modifiedAt, Valid: true}
+	}
+	s.rows[id] = row
+	s.content[id] = content
+}
+
+func (s *fakeFreshnessStore) DueForRecheck(ctx context.Context, since, checkedBefore time.Time,
+	limit int32) ([]models.ListUsersArticlesDueForRecheckRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rows []models.ListUsersArticlesDueForRecheckRow
+	for _, row := range s.rows {
+		rows = append(rows, *row)
+	}
+	return rows, nil
+}
+
+func (s *fakeFreshnessStore) RecordCheck(ctx context.Context, articleID int32, checkedAt, modifiedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, ok := s.rows[articleID]
+	if !ok {
+		return fmt.Errorf("article %d not found", articleID)
+	}
+	row.LastCheckedAt = pgtype.Timestamptz{Time: checkedAt, Valid: true}
+	if !modifiedAt.IsZero() {
+		row.ModifiedAt = pgtype.Timestamptz{Time: modifiedAt, Valid: true}
+	}
+	s.checks = append(s.checks, articleID)
+	return nil
+}
+
+func (s *fakeFreshnessStore) Reprocesses(ctx context.Context, articleID int32, since time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, r := range s.revisions {
+		if r.ArticleID == articleID && r.Field == storage.RevisionFieldContent && !r.CreatedAt.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *fakeFreshnessStore) Update(ctx context.Context, u storage.ArticleUpdate, size, overlap int,
+	fn func(ctx context.Context, aID int32, chunks int) error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, ok := s.rows[u.ArticleID]
+	if !ok {
+		return 0, fmt.Errorf("article %d not found", u.ArticleID)
+	}
+
+	chunks := len(u.Cuts)
+	if fn != nil {
+		if err := fn(ctx, u.ArticleID, chunks); err != nil {
+			return 0, err
+		}
+	}
+
+	s.revisions = append(s.revisions, articleRevision{
+		ArticleID: u.ArticleID,
+		Field:     storage.RevisionFieldContent,
+		OldValue:  s.content[u.ArticleID],
+		NewValue:  u.Content,
+		CreatedAt: u.CheckedAt,
+	})
+	row.Title = u.Title
+	row.ContentHash = storage.ContentHash(u.Content)
+	row.LastCheckedAt = pgtype.Timestamptz{Time: u.CheckedAt, Valid: true}
+	if !u.ModifiedAt.IsZero() {
+		row.ModifiedAt = pgtype.Timestamptz{Time: u.ModifiedAt, Valid: true}
+	}
+	s.content[u.ArticleID] = u.Content
+	return chunks, nil
+}
+
+// yahooPage is a Yahoo News article page, served with a Last-Modified header
+// and honouring If-Modified-Since if it declares a modification time.
+type yahooPage struct {
+	mu         sync.Mutex
+	paragraphs []string
+	modifiedAt time.Time
+}
+
+func (p *yahooPage) set(modifiedAt time.Time, paragraphs ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.modifiedAt, p.paragraphs = modifiedAt, paragraphs
+}
+
+func (p *yahooPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	modified := ""
+	if !p.modifiedAt.IsZero() {
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil &&
+			!p.modifiedAt.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", p.modifiedAt.UTC().Format(http.TimeFormat))
+		modified = fmt.Sprintf(`, "dateModified": %q`, p.modifiedAt.UTC().Format(time.RFC3339))
+	}
+
+	var body strings.Builder
+	for _, paragraph := range p.paragraphs {
+		fmt.Fprintf(&body, "<p>%s</p>", paragraph)
+	}
+	fmt.Fprintf(w, `<html><body><div class="caas-container">
+<script type="application/ld+json">{"datePublished": "2025-05-21T08:01:50Z", "keywords": "車禍"%s}</script>
+<header><h1 id="caas-lead-header-undefined">高齡換照年齡下修</h1></header>
+<div class="caas-body">%s</div>
+</div></body></html>`, modified, body.String())
+}
+
+func newFreshnessWorker(t *testing.T, store workers.FreshnessStore, pub workers.EventPublisher,
+	clock clockid.Clock, opts workers.FreshnessOptions) *workers.FreshnessWorker {
+	t.Helper()
+	checker := scrapers.NewLinkChecker(nil, 2, scrapers.Delay{}, map[string]string{})
+	w, err := workers.NewFreshnessWorker(store, checker, pub, nil, opts)
+	require.NoError(t, err)
+	return w.WithClock(clock)
+}
+
+func TestFreshnessWorkerChangeDetection(t *testing.T) {
+	published := time.Date(2025, 5, 21, 8, 1, 50, 0, time.UTC)
+	declared, undeclared := &yahooPage{}, &yahooPage{}
+	declared.set(published.Add(time.Hour), "新北三峽發生重大車禍。", "交通部宣布下修高齡換照年齡。")
+	undeclared.set(time.Time{}, "立委反彈。")
+
+	mux := http.NewServeMux()
+	mux.Handle("/declared", declared)
+	mux.Handle("/undeclared", undeclared)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	store := newFakeFreshnessStore()
+	store.add(1, srv.URL+"/declared", "新北三峽發生重大車禍。交通部宣布下修高齡換照年齡。", published.Add(time.Hour))
+	store.add(2, srv.URL+"/undeclared", "立委反彈。", time.Time{})
+
+	pub := &fakePublisher{}
+	w := newFreshnessWorker(t, store, pub, clockid.NewFake(freshnessNow), workers.DefaultFreshnessOptions())
+
+	// nothing changed: the declared page is not modified since, the other page
+	// has the same content
+	summary, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.Checked)
+	require.Equal(t, 1, summary.NotModified)
+	require.Equal(t, 1, summary.Unchanged)
+	require.Empty(t, store.revisions)
+	require.Equal(t, []string{workers.ArticlesFreshnessChecked}, pub.subjects)
+
+	// a later modification time with the same content is no update
+	declared.set(published.Add(2*time.Hour), "新北三峽發生重大車禍。", "交通部宣布下修高齡換照年齡。")
+	summary, err = w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.Unchanged)
+	require.Empty(t, store.revisions)
+	require.Equal(t, published.Add(2*time.Hour), store.rows[1].ModifiedAt.Time)
+
+	// both pages are updated
+	declared.set(published.Add(3*time.Hour), "新北三峽發生重大車禍。", "交通部宣布下修高齡換照年齡至70歲。")
+	undeclared.set(time.Time{}, "部分「資深」立委反彈。")
+	pub.subjects, pub.payloads = nil, nil
+	summary, err = w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.Updated)
+	require.Zero(t, summary.Errors)
+
+	require.Len(t, store.revisions, 2)
+	for _, r := range store.revisions {
+		switch r.ArticleID {
+		case 1:
+			require.Equal(t, "新北三峽發生重大車禍。交通部宣布下修高齡換照年齡。", r.OldValue)
+			require.Equal(t, "新北三峽發生重大車禍。交通部宣布下修高齡換照年齡至70歲。", r.NewValue)
+		case 2:
+			require.Equal(t, "立委反彈。", r.OldValue)
+			require.Equal(t, "部分「資深」立委反彈。", r.NewValue)
+		}
+	}
+	require.Equal(t, published.Add(3*time.Hour), store.rows[1].ModifiedAt.Time)
+	require.False(t, store.rows[2].ModifiedAt.Valid)
+	require.Equal(t, "高齡換照年齡下修", store.rows[1].Title)
+
+	// the keywords and the embeddings are created again
+	require.Len(t, pub.subjects, 7)
+	updated := map[int32]workers.MsgArticleUpdated{}
+	for i, subject := range pub.subjects {
+		switch subject {
+		case workers.SubjectCmd(workers.StageExtractKeywords):
+			cmd := pub.payloads[i].(workers.CmdExtractKeywords)
+			require.Empty(t, cmd.CacheKey)
+			require.Equal(t, store.rows[cmd.ArticleID].TaskID, cmd.TaskID)
+		case workers.SubjectCmd(workers.StageCreateEmbedding):
+			cmd := pub.payloads[i].(workers.CmdCreateEmbedding)
+			require.Equal(t, workers.EmbedTypePassage, cmd.EmbedType)
+		case workers.SubjectEvt(workers.StageScrape, workers.OutcomeUpdated):
+			msg := pub.payloads[i].(workers.MsgArticleUpdated)
+			updated[msg.ArticleID] = msg
+		default:
+			require.Equal(t, workers.ArticlesFreshnessChecked, subject)
+		}
+	}
+	require.Len(t, updated, 2)
+	require.Equal(t, published.Add(3*time.Hour).Unix(), updated[1].ModifiedAt)
+	require.Equal(t, 2, updated[1].Chunks)
+	require.Zero(t, updated[2].ModifiedAt)
+}
+
+func TestFreshnessWorkerThrashGuard(t *testing.T) {
+	published := time.Date(2025, 5, 21, 8, 1, 50, 0, time.UTC)
+	page := &yahooPage{}
+	srv := httptest.NewServer(page)
+	defer srv.Close()
+
+	store := newFakeFreshnessStore()
+	store.add(1, srv.URL, "第0版。", published)
+
+	opts := workers.DefaultFreshnessOptions()
+	opts.MaxReprocessPerDay = 2
+	clock := clockid.NewFake(freshnessNow)
+	w := newFreshnessWorker(t, store, &fakePublisher{}, clock, opts)
+
+	for i, outcome := range []string{"updated", "updated", "throttled", "throttled"} {
+		page.set(published.Add(time.Duration(i+1)*time.Hour), fmt.Sprintf("第%d版。", i+1))
+		summary, err := w.RunOnce(context.Background())
+		require.NoError(t, err)
+		if outcome == "updated" {
+			require.Equal(t, 1, summary.Updated, "pass %d", i)
+		} else {
+			require.Equal(t, 1, summary.Throttled, "pass %d", i)
+		}
+		clock.Advance(2 * time.Hour)
+	}
+	require.Len(t, store.revisions, 2)
+	require.Equal(t, "第2版。", store.content[1])
+
+	// the latest update is picked up once the day is over
+	clock.Advance(24 * time.Hour)
+	summary, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, summary.Updated)
+	require.Equal(t, "第4版。", store.content[1])
+	require.Len(t, store.revisions, 3)
+}
+
+func TestNewFreshnessWorkerValidation(t *testing.T) {
+	store := newFakeFreshnessStore()
+	checker := scrapers.NewLinkChecker(nil, 1, scrapers.Delay{}, map[string]string{})
+
+	_, err := workers.NewFreshnessWorker(store, checker, nil, nil, workers.DefaultFreshnessOptions())
+	require.Error(t, err)
+
+	opts := workers.DefaultFreshnessOptions()
+	opts.MaxReprocessPerDay = 0
+	_, err = workers.NewFreshnessWorker(store, checker, &fakePublisher{}, nil, opts)
+	require.Error(t, err)
+
+	_, err = workers.NewFreshnessWorker(store, checker, &fakePublisher{}, nil, workers.DefaultFreshnessOptions())
+	require.NoError(t, err)
+}
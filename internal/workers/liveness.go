@@ -0,0 +1,296 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// DefaultLivenessInterval is the interval between two liveness passes.
+	DefaultLivenessInterval = 7 * 24 * time.Hour
+	// DefaultLivenessChecksPerRun is the number of URLs checked per pass.
+	DefaultLivenessChecksPerRun = 5000
+	// DefaultLivenessParallelism is the number of URLs checked concurrently,
+	// the per-host limit of the LinkChecker applies on top of it.
+	DefaultLivenessParallelism = 8
+)
+
+var deadLinksTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "dead_links_total",
+	Help: "Number of article URLs flagged as dead, by source.",
+}, []string{"source"})
+
+// LinkStore persists the liveness state of the article URLs. It is
+// implemented by storage.URLStatus.
+type LinkStore interface {
+	DueForCheck(ctx context.Context, limit int32) ([]models.ListArticlesDueForCheckRow, error)
+	RecordCheck(ctx context.Context, articleID int32, statusCode int, consecutiveFailures int32, dead bool) error
+	MoveURL(ctx context.Context, articleID int32, oldURL, newURL, note string) error
+	DeadBySource(ctx context.Context) (map[string]int64, error)
+}
+
+// EventPublisher publishes events to NATS. It is implemented by
+// publishers.Publisher.
+type EventPublisher interface {
+	PublishNATSMessage(ctx context.Context, subject string, payload any, attrs ...attribute.KeyValue) error
+}
+
+// LivenessOptions configures a LivenessWorker.
+type LivenessOptions struct {
+	ChecksPerRun int32
+	Parallelism  int
+	MaxFailures  int32
+}
+
+// DefaultLivenessOptions returns the default LivenessOptions.
+func DefaultLivenessOptions() LivenessOptions {
+	return LivenessOptions{
+		ChecksPerRun: DefaultLivenessChecksPerRun,
+		Parallelism:  DefaultLivenessParallelism,
+		MaxFailures:  scrapers.DefaultMaxConsecutiveFailures,
+	}
+}
+
+// LivenessSummary is the summary of a liveness pass, it is published as the
+// payload of the ArticleLinksChecked event.
+type LivenessSummary struct {
+	Checked      int              `json:"checked"`
+	Alive        int              `json:"alive"`
+	SoftFailures int              `json:"soft_failures"`
+	HardFailures int              `json:"hard_failures"`
+	NewlyDead    int              `json:"newly_dead"`
+	Revived      int              `json:"revived"`
+	Moved        int              `json:"moved"`
+	Errors       int              `json:"errors"`
+	DeadBySource map[string]int64 `json:"dead_by_source"`
+	StartedAt    time.Time        `json:"started_at"`
+	FinishedAt   time.Time        `json:"finished_at"`
+}
+
+// LivenessWorker periodically verifies that the stored article URLs still
+// resolve, flags the dead links, and follows the ones moved elsewhere.
+type LivenessWorker struct {
+	store   LinkStore
+	checker *scrapers.LinkChecker
+	pub     EventPublisher
+	opts    LivenessOptions
+	clock   clockid.Clock
+}
+
+// NewLivenessWorker creates a LivenessWorker. pub may be nil, in which case
+// no summary event is published.
+func NewLivenessWorker(store LinkStore, checker *scrapers.LinkChecker,
+	pub EventPublisher, opts LivenessOptions) (*LivenessWorker, error) {
+	if store == nil {
+		return nil, fmt.Errorf("link store should not be nil")
+	}
+
+	if checker == nil {
+		return nil, fmt.Errorf("link checker should not be nil")
+	}
+
+	if opts.ChecksPerRun <= 0 {
+		return nil, fmt.Errorf("checks per run should be positive: %d", opts.ChecksPerRun)
+	}
+
+	if opts.Parallelism <= 0 {
+		opts.Parallelism = DefaultLivenessParallelism
+	}
+
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = scrapers.DefaultMaxConsecutiveFailures
+	}
+
+	return &LivenessWorker{
+		store:   store,
+		checker: checker,
+		pub:     pub,
+		opts:    opts,
+		clock:   clockid.Real,
+	}, nil
+}
+
+// WithClock makes the worker take the time and tick on c.
+func (w *LivenessWorker) WithClock(c clockid.Clock) *LivenessWorker {
+	w.clock = c
+	return w
+}
+
+// RunOnce checks the URLs of up to ChecksPerRun articles, least recently
+// checked first, and records their state.
+func (w *LivenessWorker) RunOnce(ctx context.Context) (LivenessSummary, error) {
+	summary := LivenessSummary{StartedAt: w.clock.Now()}
+
+	rows, err := w.store.DueForCheck(ctx, w.opts.ChecksPerRun)
+	if err != nil {
+		return summary, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan models.ListArticlesDueForCheckRow)
+	for range w.opts.Parallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range jobs {
+				outcome := w.check(ctx, row)
+				mu.Lock()
+				summary.add(outcome)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, row := range rows {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- row
+	}
+	close(jobs)
+	wg.Wait()
+
+	if summary.DeadBySource, err = w.store.DeadBySource(ctx); err != nil {
+		return summary, err
+	}
+
+	deadLinksTotal.Reset()
+	for source, n := range summary.DeadBySource {
+		deadLinksTotal.WithLabelValues(source).Set(float64(n))
+	}
+	summary.FinishedAt = w.clock.Now()
+
+	if w.pub != nil {
+		if err := w.pub.PublishNATSMessage(ctx, ArticleLinksChecked, summary,
+			attribute.Int("checked", summary.Checked),
+			attribute.Int("newly_dead", summary.NewlyDead)); err != nil {
+			return summary, err
+		}
+	}
+	return summary, ctx.Err()
+}
+
+type linkOutcome struct {
+	health  scrapers.LinkHealth
+	prev    scrapers.URLState
+	next    scrapers.URLState
+	moved   bool
+	failure error
+}
+
+func (w *LivenessWorker) check(ctx context.Context, row models.ListArticlesDueForCheckRow) linkOutcome {
+	prev := scrapers.URLState{
+		StatusCode:          int(row.LastStatusCode.Int32),
+		ConsecutiveFailures: row.ConsecutiveFailures,
+		Dead:                row.Dead,
+	}
+
+	check := w.checker.Check(ctx, row.Url)
+	outcome := linkOutcome{
+		health: check.Health(),
+		prev:   prev,
+		next:   prev.Next(check, w.opts.MaxFailures),
+	}
+
+	if newURL, ok := check.Moved(); ok {
+		note := fmt.Sprintf("redirected with status %d during liveness check", check.StatusCode)
+		if err := w.store.MoveURL(ctx, row.ID, row.Url, newURL, note); err != nil {
+			global.Logger.Error().
+				Err(err).
+				Int32("article_id", row.ID).
+				Str("url", row.Url).
+				Str("new_url", newURL).
+				Msg("Failed to update moved article URL")
+		} else {
+			outcome.moved = true
+		}
+	}
+
+	if err := w.store.RecordCheck(ctx, row.ID, outcome.next.StatusCode,
+		outcome.next.ConsecutiveFailures, outcome.next.Dead); err != nil {
+		global.Logger.Error().
+			Err(err).
+			Int32("article_id", row.ID).
+			Str("url", row.Url).
+			Msg("Failed to record URL status")
+		outcome.failure = err
+	}
+
+	if outcome.next.Dead && !prev.Dead {
+		global.Logger.Info().
+			Int32("article_id", row.ID).
+			Str("url", row.Url).
+			Int("status_code", check.StatusCode).
+			Msg("Article URL flagged as dead")
+	}
+	return outcome
+}
+
+func (s *LivenessSummary) add(o linkOutcome) {
+	s.Checked++
+	if o.failure != nil {
+		s.Errors++
+		return
+	}
+
+	switch o.health {
+	case scrapers.LinkAlive:
+		s.Alive++
+	case scrapers.LinkSoftFailure:
+		s.SoftFailures++
+	case scrapers.LinkHardFailure:
+		s.HardFailures++
+	}
+
+	if o.next.Dead && !o.prev.Dead {
+		s.NewlyDead++
+	}
+
+	if !o.next.Dead && o.prev.Dead {
+		s.Revived++
+	}
+
+	if o.moved {
+		s.Moved++
+	}
+}
+
+// Run runs a liveness pass every interval until ctx is cancelled. With the
+// default options a weekly pass covers up to DefaultLivenessChecksPerRun URLs,
+// the least recently checked ones, so the corpus is covered over several
+// passes without hammering any single host.
+func (w *LivenessWorker) Run(ctx context.Context, interval time.Duration) {
+	ticker := w.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		summary, err := w.RunOnce(ctx)
+		if err != nil {
+			global.Logger.Error().Err(err).Msg("Liveness check failed")
+		} else {
+			global.Logger.Info().
+				Int("checked", summary.Checked).
+				Int("newly_dead", summary.NewlyDead).
+				Int("revived", summary.Revived).
+				Int("moved", summary.Moved).
+				Msg("Liveness check finished")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package workers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/scrapers"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type revision struct {
+	ArticleID int32
+	OldURL    string
+	NewURL    string
+	Note      string
+}
+
+// fakeLinkStore keeps the URL status of the articles in memory.
+type fakeLinkStore struct {
+	mu        sync.Mutex
+	rows      []models.ListArticlesDueForCheckRow
+	revisions []revision
+}
+
+func (s *fakeLinkStore) DueForCheck(ctx context.Context, limit int32) ([]models.ListArticlesDueForCheckRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.ListArticlesDueForCheckRow{}, s.rows[:min(int(limit), len(s.rows))]...), nil
+}
+
+func (s *fakeLinkStore) RecordCheck(ctx context.Context, articleID int32, statusCode int,
+	consecutiveFailures int32, dead bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.rows {
+		if s.rows[i].ID == articleID {
+			s.rows[i].LastStatusCode = pgtype.Int4{Int32: int32(statusCode), Valid: statusCode != 0}
+			s.rows[i].ConsecutiveFailures = consecutiveFailures
+			s.rows[i].Dead = dead
+			return nil
+		}
+	}
+	return fmt.Errorf("article not found: %d", articleID)
+}
+
+func (s *fakeLinkStore) MoveURL(ctx context.Context, articleID int32, oldURL, newURL, note string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.rows {
+		if s.rows[i].ID == articleID {
+			s.rows[i].Url = newURL
+			s.revisions = append(s.revisions, revision{articleID, oldURL, newURL, note})
+			return nil
+		}
+	}
+	return fmt.Errorf("article not found: %d", articleID)
+}
+
+func (s *fakeLinkStore) DeadBySource(ctx context.Context) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[string]int64{}
+	for _, row := range s.rows {
+		if row.Dead {
+			counts[row.Source]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *fakeLinkStore) row(id int32) models.ListArticlesDueForCheckRow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range s.rows {
+		if row.ID == id {
+			return row
+		}
+	}
+	return models.ListArticlesDueForCheckRow{}
+}
+
+type fakePublisher struct {
+	mu       sync.Mutex
+	subjects []string
+	payloads []any
+}
+
+func (p *fakePublisher) PublishNATSMessage(ctx context.Context, subject string, payload any, attrs ...attribute.KeyValue) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subjects = append(p.subjects, subject)
+	p.payloads = append(p.payloads, payload)
+	return nil
+}
+
+const timeout = -1
+
+// scriptedServer answers the n-th request to a path with the n-th status code
+// of its script, the last one is repeated once the script runs out.
+func scriptedServer(t *testing.T, scripts map[string][]int) *httptest.Server {
+	t.Helper()
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/new-location" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		mu.Lock()
+		script, ok := scripts[r.URL.Path]
+		n := calls[r.URL.Path]
+		calls[r.URL.Path]++
+		mu.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch code := script[min(n, len(script)-1)]; code {
+		case timeout:
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		case http.StatusMovedPermanently:
+			http.Redirect(w, r, "/new-location?utm_source=feed", code)
+		default:
+			w.WriteHeader(code)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newLivenessWorker(t *testing.T, store workers.LinkStore, pub workers.EventPublisher) *workers.LivenessWorker {
+	t.Helper()
+	checker := scrapers.NewLinkChecker(&http.Client{Timeout: 100 * time.Millisecond},
+		2, scrapers.Delay{}, map[string]string{})
+	w, err := workers.NewLivenessWorker(store, checker, pub, workers.LivenessOptions{
+		ChecksPerRun: 100,
+		Parallelism:  4,
+		MaxFailures:  3,
+	})
+	require.NoError(t, err)
+	return w
+}
+
+func TestLivenessWorkerDeadFlagging(t *testing.T) {
+	srv := scriptedServer(t, map[string][]int{
+		"/gone":    {404, 404, 404, 404, 200},
+		"/flaky":   {404, timeout, 404, 503, 404},
+		"/healthy": {200},
+	})
+
+	store := &fakeLinkStore{rows: []models.ListArticlesDueForCheckRow{
+		{ID: 1, Url: srv.URL + "/gone", Source: "kmt"},
+		{ID: 2, Url: srv.URL + "/flaky", Source: "dpp"},
+		{ID: 3, Url: srv.URL + "/healthy", Source: "tpp"},
+	}}
+	pub := &fakePublisher{}
+	w := newLivenessWorker(t, store, pub)
+
+	tcs := []struct {
+		name      string
+		gone      scrapers.URLState
+		flaky     scrapers.URLState
+		newlyDead int
+		revived   int
+	}{
+		{
+			name:  "first 404",
+			gone:  scrapers.URLState{StatusCode: 404, ConsecutiveFailures: 1},
+			flaky: scrapers.URLState{StatusCode: 404, ConsecutiveFailures: 1},
+		},
+		{
+			name:  "timeout does not count",
+			gone:  scrapers.URLState{StatusCode: 404, ConsecutiveFailures: 2},
+			flaky: scrapers.URLState{StatusCode: 0, ConsecutiveFailures: 1},
+		},
+		{
+			name:      "dead after three hard failures",
+			gone:      scrapers.URLState{StatusCode: 404, ConsecutiveFailures: 3, Dead: true},
+			flaky:     scrapers.URLState{StatusCode: 404, ConsecutiveFailures: 2},
+			newlyDead: 1,
+		},
+		{
+			name:  "server error does not count",
+			gone:  scrapers.URLState{StatusCode: 404, ConsecutiveFailures: 4, Dead: true},
+			flaky: scrapers.URLState{StatusCode: 503, ConsecutiveFailures: 2},
+		},
+		{
+			name:      "revived and dead",
+			gone:      scrapers.URLState{StatusCode: 200},
+			flaky:     scrapers.URLState{StatusCode: 404, ConsecutiveFailures: 3, Dead: true},
+			newlyDead: 1,
+			revived:   1,
+		},
+	}
+
+	state := func(id int32) scrapers.URLState {
+		row := store.row(id)
+		return scrapers.URLState{
+			StatusCode:          int(row.LastStatusCode.Int32),
+			ConsecutiveFailures: row.ConsecutiveFailures,
+			Dead:                row.Dead,
+		}
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			summary, err := w.RunOnce(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, 3, summary.Checked)
+			require.Equal(t, tc.newlyDead, summary.NewlyDead)
+			require.Equal(t, tc.revived, summary.Revived)
+			require.Equal(t, tc.gone, state(1))
+			require.Equal(t, tc.flaky, state(2))
+			require.Equal(t, scrapers.URLState{StatusCode: 200}, state(3))
+		})
+	}
+
+	// only the flaky link is dead after the last pass
+	summary, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"dpp": 1}, summary.DeadBySource)
+	require.Len(t, pub.subjects, len(tcs)+1)
+	require.Equal(t, workers.ArticleLinksChecked, pub.subjects[0])
+}
+
+func TestLivenessWorkerRedirect(t *testing.T) {
+	srv := scriptedServer(t, map[string][]int{
+		"/moved": {301},
+	})
+
+	store := &fakeLinkStore{rows: []models.ListArticlesDueForCheckRow{
+		{ID: 1, Url: srv.URL + "/moved", Source: "yahoo"},
+	}}
+	w := newLivenessWorker(t, store, nil)
+
+	summary, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, summary.Moved)
+	require.Equal(t, 1, summary.Alive)
+
+	want, err := scrapers.CanonicalURL(srv.URL + "/new-location")
+	require.NoError(t, err)
+	require.Equal(t, want, store.row(1).Url)
+	require.Equal(t, scrapers.URLState{StatusCode: 200}, scrapers.URLState{
+		StatusCode:          int(store.row(1).LastStatusCode.Int32),
+		ConsecutiveFailures: store.row(1).ConsecutiveFailures,
+		Dead:                store.row(1).Dead,
+	})
+	require.Len(t, store.revisions, 1)
+	require.Equal(t, srv.URL+"/moved", store.revisions[0].OldURL)
+	require.Equal(t, want, store.revisions[0].NewURL)
+	require.NotEmpty(t, store.revisions[0].Note)
+
+	// the new URL no longer redirects
+	summary, err = w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, summary.Moved)
+	require.Len(t, store.revisions, 1)
+}
@@ -10,7 +10,9 @@ import (
 	"github.com/google/uuid"
 )
 
-// Publish while event has occurred
+// Publish while event has occurred. These flat subjects are the legacy aliases
+// of the task events of the hierarchy, see SubjectEvt, but for the events of
+// the periodic jobs.
 const (
 	// task has been created
 	TaskCreated = "task.created"
@@ -20,14 +22,25 @@ const (
 	KeywordsExtracted = "article.keywords.extracted"
 	// embedding for the article has been created
 	EmbeddingCreated = "article.embedding.created"
+	// a liveness check pass over the article URLs has finished
+	ArticleLinksChecked = "article.links.checked"
+	// a keyword has been mentioned well above its baseline on a day
+	KeywordAnomalyDetected = "keyword.anomaly.detected"
+	// a discovery pass over the news feeds has finished
+	ArticlesDiscovered = "article.discovered"
+	// a freshness check pass over the recent articles has finished
+	ArticlesFreshnessChecked = "article.freshness.checked"
 
 	TaskFailed = "task.failed"
 )
 
-// Publish while event needs to be performed
+// Publish while event needs to be performed. These flat subjects are the
+// legacy aliases of the task commands of the hierarchy, see SubjectCmd.
 const (
 	// scrape a news article
 	TaskScrape = "task.scrape"
+	// scrape a fresh news article, ahead of the ones on TaskScrape
+	TaskScrapePriority = "task.scrape.priority"
 	// generate a title for the article
 	TaskGenerateTitle = "task.generate_title"
 	// extract keywords from the article
@@ -225,6 +238,17 @@ type MsgArticleScraped struct {
 	ArticleID int32 `json:"article_id"`
 }
 
+// MsgArticleUpdated is published once the article of a task has been updated
+// by its publisher, and its keywords and embeddings are being created again.
+// ModifiedAt is the modification time declared by the publisher in Unix
+// format, 0 if it declares none.
+type MsgArticleUpdated struct {
+	BaseMessage
+	ArticleID  int32 `json:"article_id"`
+	ModifiedAt int64 `json:"modified_at,omitempty"`
+	Chunks     int   `json:"chunks"`
+}
+
 type MsgKeywordsExtracted struct {
 	BaseMessageWithElapsed
 	ArticleID      int32 `json:"article_id"`
@@ -245,7 +269,32 @@ type MsgTaskFailed struct {
 
 type CmdScrapeArticle struct {
 	BaseMessage
-	URL string `json:"url,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Priority Priority `json:"priority,omitempty"`
+	// Replace is the ID of the article of the task the scrape replaces, e.g.
+	// one whose extraction an editor dismissed, zero for a new scrape. A
+	// replacing scrape runs even if the task is done.
+	Replace int32 `json:"replace,omitempty"`
+}
+
+// Priority is the priority of a scrape. The priorities are published on
+// subjects of their own, see ScrapeSubject, each consumed by a scraper worker
+// of its own, so that a backlog of normal scrapes does not hold back the fresh
+// news.
+type Priority string
+
+const (
+	PriorityNormal Priority = "normal"
+	PriorityHigh   Priority = "high"
+)
+
+// ScrapeSubject returns the subject the scrapes of priority p are published
+// on, the one of StageScrape for any priority but PriorityHigh.
+func ScrapeSubject(p Priority) string {
+	if p == PriorityHigh {
+		return SubjectCmd(StageScrapePriority)
+	}
+	return SubjectCmd(StageScrape)
 }
 
 type CmdGenerateTitle struct {
@@ -256,6 +305,9 @@ type CmdGenerateTitle struct {
 type CmdExtractKeywords struct {
 	BaseMessage
 	ArticleID int32 `json:"article_id"`
+	// Lang is the language tag of the article, e.g. zh-Hant or en. If empty,
+	// the language is detected from the content.
+	Lang string `json:"lang,omitempty"`
 }
 
 type CmdCreateEmbedding struct {
@@ -3,6 +3,8 @@ package workers
 import (
 	"fmt"
 	"time"
+
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
 )
 
 // Options holds configurable parameters for the Runner.
@@ -11,6 +13,11 @@ type Options struct {
 	HealthCheckPort  int
 	HealthCheckHost  string
 	ShutdownWaitTime time.Duration
+	Clock            clockid.Clock
+	IDs              clockid.IDGen
+	DualSubscribe    bool
+	WarmupTimeout    time.Duration
+	Events           TaskEventAppender
 }
 
 // Option is a function type that modifies the Options struct.
@@ -55,3 +62,61 @@ func WithShutdownWaitTime(d time.Duration) Option {
 		return nil
 	}
 }
+
+// WithClock sets the clock the Runner waits and stamps the events on.
+func WithClock(c clockid.Clock) Option {
+	return func(o *Options) error {
+		if c == nil {
+			return fmt.Errorf("clock should not be nil")
+		}
+		o.Clock = c
+		return nil
+	}
+}
+
+// WithIDGen sets the generator of the ids of the events the Runner publishes.
+func WithIDGen(ids clockid.IDGen) Option {
+	return func(o *Options) error {
+		if ids == nil {
+			return fmt.Errorf("id generator should not be nil")
+		}
+		o.IDs = ids
+		return nil
+	}
+}
+
+// WithDualSubscribe sets whether the Runner consumes the legacy alias of the
+// subject of the worker too, see LegacySubject, so that the messages of the
+// publishers not migrated to the subject hierarchy yet are not missed. The
+// copies a dual publisher makes on the alias are skipped.
+func WithDualSubscribe(dual bool) Option {
+	return func(o *Options) error {
+		o.DualSubscribe = dual
+		return nil
+	}
+}
+
+// WithWarmupTimeout sets how long the Runner waits for the Warmup of a worker
+// implementing Warmer before it subscribes anyway.
+func WithWarmupTimeout(d time.Duration) Option {
+	return func(o *Options) error {
+		if d < 0 {
+			return fmt.Errorf("warmup timeout should be positive: %v", d)
+		}
+		o.WarmupTimeout = d
+		return nil
+	}
+}
+
+// WithTaskEvents makes the Runner record the stage of the worker failed in the
+// timeline of the task of a malformed message through events, if the task ID
+// of the message can be read.
+func WithTaskEvents(events TaskEventAppender) Option {
+	return func(o *Options) error {
+		if events == nil {
+			return fmt.Errorf("task event appender should not be nil")
+		}
+		o.Events = events
+		return nil
+	}
+}
@@ -0,0 +1,8 @@
+package publishers
+
+// AllowLoopback makes d connect to the loopback addresses, for the tests to
+// post to an httptest server.
+func (d *WebhookDispatcher) AllowLoopback() *WebhookDispatcher {
+	d.allowLoopback = true
+	return d
+}
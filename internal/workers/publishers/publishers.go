@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/ChiaYuChang/weathercock/internal/workers"
 	"github.com/ChiaYuChang/weathercock/pkgs/errors"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
@@ -25,8 +26,10 @@ type Publisher struct {
 	js     nats.JetStreamContext
 	logger zerolog.Logger
 	tracer trace.Tracer
+	dual   bool
 }
 
+// NewPublisher creates a Publisher. It dual publishes, see WithDualPublish.
 func NewPublisher(name string, js nats.JetStreamContext,
 	logger zerolog.Logger, tracer trace.Tracer) *Publisher {
 	return &Publisher{
@@ -34,16 +37,30 @@ func NewPublisher(name string, js nats.JetStreamContext,
 		js:     js,
 		logger: logger,
 		tracer: tracer,
+		dual:   true,
 	}
 }
 
+// WithDualPublish sets whether the messages are published on the legacy alias
+// of their subject too, for the consumers not migrated to the subject
+// hierarchy yet, see workers.LegacySubject. The copies carry the
+// workers.AliasOfHeader header.
+func (p *Publisher) WithDualPublish(dual bool) *Publisher {
+	p.dual = dual
+	return p
+}
+
+// PublishNATSMessage publishes payload on subject. A legacy subject is
+// published as the subject of the hierarchy it is an alias of, see
+// workers.CurrentSubject.
 func (p Publisher) PublishNATSMessage(ctx context.Context, subject string,
 	payload any, attrs ...attribute.KeyValue) error {
+	subject = workers.CurrentSubject(subject)
 	attrs = append(attrs, attribute.String("subject", subject))
 	sCtx, span := p.tracer.Start(ctx, p.Name, trace.WithAttributes(attrs...))
 	defer span.End()
 
-	payload, err := json.Marshal(payload)
+	data, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
@@ -52,28 +69,44 @@ func (p Publisher) PublishNATSMessage(ctx context.Context, subject string,
 	otel.GetTextMapPropagator().
 		Inject(sCtx, propagation.HeaderCarrier(headers))
 
-	data, err := json.Marshal(payload)
-	if err != nil {
+	msgs := []*nats.Msg{{Subject: subject, Data: data, Header: headers}}
+	if legacy, ok := workers.LegacySubject(subject); ok && p.dual {
+		aliasHeaders := nats.Header{}
+		for k, v := range headers {
+			aliasHeaders[k] = v
+		}
+		aliasHeaders.Set(workers.AliasOfHeader, subject)
+		msgs = append(msgs, &nats.Msg{Subject: legacy, Data: data, Header: aliasHeaders})
+	}
+
+	for _, msg := range msgs {
+		if err := p.publish(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publish publishes msg, retrying MaxRetryTimes times.
+func (p Publisher) publish(msg *nats.Msg) error {
+	publish := func() error {
+		_, err := p.js.PublishMsg(msg)
 		return err
 	}
 
 	retry := 0
-	_, err = p.js.PublishMsg(&nats.Msg{
-		Subject: subject,
-		Data:    data,
-		Header:  headers,
-	})
-
+	err := publish()
 	for err != nil && retry < MaxRetryTimes {
 		sleep := min(10*time.Second, MinRetryInterval*1<<time.Duration(retry))
 		p.logger.Warn().
 			Int("retry", retry).
-			Str("subject", subject).
-			Int("n", len(data)).
+			Str("subject", msg.Subject).
+			Int("n", len(msg.Data)).
 			Dur("sleep", sleep).
 			Err(err).Msg("falied to publish message")
 		time.Sleep(sleep)
 		retry++
+		err = publish()
 	}
 
 	if err != nil {
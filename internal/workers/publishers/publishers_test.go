@@ -0,0 +1,65 @@
+package publishers_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/ChiaYuChang/weathercock/internal/workers/publishers"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type fakeJetStream struct {
+	nats.JetStreamContext
+	mu   sync.Mutex
+	msgs []*nats.Msg
+}
+
+func (js *fakeJetStream) PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.msgs = append(js.msgs, m)
+	return &nats.PubAck{Sequence: uint64(len(js.msgs))}, nil
+}
+
+func newPublisher(js nats.JetStreamContext) *publishers.Publisher {
+	return publishers.NewPublisher("test", js, zerolog.Nop(), noop.NewTracerProvider().Tracer("test"))
+}
+
+func TestDualPublish(t *testing.T) {
+	scrape := workers.SubjectCmd(workers.StageScrape)
+	cmd := workers.CmdScrapeArticle{URL: "https://tw.news.yahoo.com/a-1.html"}
+
+	js := &fakeJetStream{}
+	require.NoError(t, newPublisher(js).PublishNATSMessage(context.Background(), scrape, cmd))
+	require.Len(t, js.msgs, 2)
+	require.Equal(t, scrape, js.msgs[0].Subject)
+	require.Empty(t, js.msgs[0].Header.Get(workers.AliasOfHeader))
+	require.Equal(t, workers.TaskScrape, js.msgs[1].Subject)
+	require.Equal(t, scrape, js.msgs[1].Header.Get(workers.AliasOfHeader))
+	require.Equal(t, js.msgs[0].Data, js.msgs[1].Data)
+
+	// a legacy subject is published as the one of the hierarchy
+	js = &fakeJetStream{}
+	require.NoError(t, newPublisher(js).PublishNATSMessage(context.Background(), workers.TaskScrape, cmd))
+	require.Len(t, js.msgs, 2)
+	require.Equal(t, scrape, js.msgs[0].Subject)
+	require.Equal(t, workers.TaskScrape, js.msgs[1].Subject)
+
+	// once the migration is over
+	js = &fakeJetStream{}
+	pub := newPublisher(js).WithDualPublish(false)
+	require.NoError(t, pub.PublishNATSMessage(context.Background(), workers.TaskScrape, cmd))
+	require.Len(t, js.msgs, 1)
+	require.Equal(t, scrape, js.msgs[0].Subject)
+
+	// the subjects without an alias are published once
+	js = &fakeJetStream{}
+	require.NoError(t, newPublisher(js).PublishNATSMessage(context.Background(), workers.ArticlesDiscovered, cmd))
+	require.Len(t, js.msgs, 1)
+	require.Equal(t, workers.ArticlesDiscovered, js.msgs[0].Subject)
+}
@@ -0,0 +1,175 @@
+package publishers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ErrUnsafeWebhook is returned by WebhookDispatcher.Dispatch for a webhook
+// not served over https or resolving to a loopback, private or link-local
+// address.
+var ErrUnsafeWebhook = errors.New("unsafe webhook")
+
+// Headers of the webhook requests. The signature is the hex encoded
+// HMAC-SHA256 of the timestamp, a dot and the body, prefixed by "sha256=".
+const (
+	WebhookEventHeader     = "X-Weathercock-Event"
+	WebhookTimestampHeader = "X-Weathercock-Timestamp"
+	WebhookSignatureHeader = "X-Weathercock-Signature"
+)
+
+// WebhookDispatcher posts JSON payloads to webhooks, signed with a shared
+// secret so that receivers can verify where they come from. The webhooks are
+// given by the users, so only https ones are posted to, and the connections to
+// the loopback, private and link-local addresses are refused when dialed, after
+// the name of the host is resolved.
+type WebhookDispatcher struct {
+	client        *http.Client
+	secret        []byte
+	now           func() time.Time
+	allowLoopback bool
+}
+
+// NewWebhookDispatcher creates a WebhookDispatcher signing with secret. A nil
+// client falls back to a client with a 10 seconds timeout. The client is
+// copied with a clone of its transport dialing through the address check, a
+// transport other than an *http.Transport is replaced by a clone of the
+// default one. The proxies of the environment are not used, the check would
+// see the address of the proxy instead of the one of the webhook.
+func NewWebhookDispatcher(client *http.Client, secret string) *WebhookDispatcher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	d := &WebhookDispatcher{
+		secret: []byte(secret),
+		now:    time.Now,
+	}
+
+	base, ok := client.Transport.(*http.Transport)
+	if !ok {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	transport := base.Clone()
+	transport.Proxy = nil
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   d.control,
+	}
+	transport.DialContext = dialer.DialContext
+
+	guarded := *client
+	guarded.Transport = transport
+	guarded.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("%w: redirected to %s", ErrUnsafeWebhook, req.URL.Redacted())
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	d.client = &guarded
+	return d
+}
+
+// control refuses the connections to the loopback, private, link-local and
+// unspecified addresses. It is called with the resolved address, so a host
+// name resolving to one of them is refused too.
+func (d *WebhookDispatcher) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnsafeWebhook, err)
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnsafeWebhook, err)
+	}
+
+	addr = addr.Unmap()
+	if addr.IsLoopback() && d.allowLoopback {
+		return nil
+	}
+
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsUnspecified() {
+		return fmt.Errorf("%w: %s is not a public address", ErrUnsafeWebhook, addr)
+	}
+	return nil
+}
+
+// SignWebhook returns the signature of body sent at timestamp.
+func SignWebhook(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether signature is the signature of body sent at
+// timestamp, in constant time.
+func VerifyWebhook(secret []byte, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
+
+// Dispatch posts payload as JSON to rawURL. Any non-2xx response is an error,
+// a webhook not served over https or resolving to an address which is not
+// public is an ErrUnsafeWebhook.
+func (d *WebhookDispatcher) Dispatch(ctx context.Context, rawURL, event string, payload any) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse webhook url: %w", err)
+	}
+
+	if u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q, only https is allowed", ErrUnsafeWebhook, u.Scheme)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+
+	ts := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(d.secret, ts, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
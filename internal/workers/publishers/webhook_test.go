@@ -0,0 +1,77 @@
+package publishers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/workers/publishers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookDispatcher(t *testing.T) {
+	secret := "s3cret"
+	var got map[string]any
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		ts, err := strconv.ParseInt(r.Header.Get(publishers.WebhookTimestampHeader), 10, 64)
+		require.NoError(t, err)
+		if !publishers.VerifyWebhook([]byte(secret), ts, body, r.Header.Get(publishers.WebhookSignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		require.Equal(t, "digest", r.Header.Get(publishers.WebhookEventHeader))
+		require.NoError(t, json.Unmarshal(body, &got))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	d := publishers.NewWebhookDispatcher(srv.Client(), secret).AllowLoopback()
+	require.NoError(t, d.Dispatch(ctx, srv.URL, "digest", map[string]any{"match_count": 3}))
+	require.Equal(t, float64(3), got["match_count"])
+
+	// a receiver with another secret rejects the request
+	d = publishers.NewWebhookDispatcher(srv.Client(), "other").AllowLoopback()
+	err := d.Dispatch(ctx, srv.URL, "digest", map[string]any{"match_count": 3})
+	require.Error(t, err)
+	require.NotErrorIs(t, err, publishers.ErrUnsafeWebhook)
+}
+
+func TestWebhookDispatcherRefusesUnsafeWebhooks(t *testing.T) {
+	var posted bool
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	d := publishers.NewWebhookDispatcher(srv.Client(), "s3cret")
+	for _, url := range []string{
+		"http://example.com/hook",
+		"ftp://example.com/hook",
+		srv.URL,
+		strings.Replace(srv.URL, "127.0.0.1", "localhost", 1),
+		"https://10.0.0.1/hook",
+		"https://192.168.1.1/hook",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/hook",
+		"https://[fe80::1]/hook",
+		"https://0.0.0.0/hook",
+	} {
+		t.Run(url, func(t *testing.T) {
+			err := d.Dispatch(ctx, url, "digest", map[string]any{})
+			require.ErrorIs(t, err, publishers.ErrUnsafeWebhook)
+		})
+	}
+	require.False(t, posted)
+}
@@ -0,0 +1,550 @@
+package workers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultReconcileInterval is the interval between two reconciliation
+	// passes.
+	DefaultReconcileInterval = time.Hour
+	// DefaultReconcileWindow is how far back a pass looks for done tasks.
+	DefaultReconcileWindow = 3 * 24 * time.Hour
+	// DefaultReconcileSampleRate is the share of the done tasks checked.
+	DefaultReconcileSampleRate = 0.1
+	// DefaultReconcileBatchSize is the number of tasks read at once.
+	DefaultReconcileBatchSize = 100
+	// DefaultReconcileMaxPerRun is the number of tasks checked per run.
+	DefaultReconcileMaxPerRun = 1000
+)
+
+// The artifacts of a task the reconciliation compares.
+const (
+	ArtifactTitle      = "title"
+	ArtifactContent    = "content"
+	ArtifactKeywords   = "keywords"
+	ArtifactEmbeddings = "embeddings"
+)
+
+// The classes of the divergences between the cache and the database. Only the
+// missing cache entries are repaired, the other ones are flagged for review.
+const (
+	DivergenceCacheMissing  = "cache_missing"
+	DivergenceDBMissing     = "db_missing"
+	DivergenceHashMismatch  = "hash_mismatch"
+	DivergenceCountMismatch = "count_mismatch"
+)
+
+var (
+	reconciledTasksTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reconciled_tasks_total",
+		Help: "Number of done tasks whose artifacts were reconciled with the cache.",
+	})
+	reconciliationDivergencesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reconciliation_divergences_total",
+		Help: "Number of divergences between the cache and the database, by artifact and class.",
+	}, []string{"artifact", "class"})
+)
+
+// ReconcileStore pages through the done tasks, loads their artifacts and keeps
+// the reports of the passes. It is implemented by storage.Reconciliation.
+type ReconcileStore interface {
+	DoneTasksSince(ctx context.Context, since time.Time, afterID, limit int32) ([]models.ListDoneTasksSinceRow, error)
+	Artifacts(ctx context.Context, taskID uuid.UUID) ([]models.ListTaskArtifactsRow, error)
+	Running(ctx context.Context) (storage.ReconciliationReport, bool, error)
+	Start(ctx context.Context, since time.Time) (storage.ReconciliationReport, error)
+	Save(ctx context.Context, report storage.ReconciliationReport, finished bool) (storage.ReconciliationReport, error)
+}
+
+// ReviewEnqueuer queues the divergences which cannot be repaired for an
+// editor. It is implemented by storage.ReviewQueue.
+type ReviewEnqueuer interface {
+	Enqueue(ctx context.Context, item storage.ReviewItem) (models.UsersReviewQueue, error)
+}
+
+// ArtifactCache is the cache the pipeline hands the artifacts of a task over
+// in. It is implemented by ValkeyArtifactCache.
+type ArtifactCache interface {
+	// Get returns the value of key, and whether it is set.
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+}
+
+// ValkeyArtifactCache is an ArtifactCache in Valkey.
+type ValkeyArtifactCache struct {
+	client *redis.Client
+}
+
+// NewValkeyArtifactCache creates a ValkeyArtifactCache.
+func NewValkeyArtifactCache(client *redis.Client) *ValkeyArtifactCache {
+	return &ValkeyArtifactCache{client: client}
+}
+
+func (c *ValkeyArtifactCache) Get(ctx context.Context, key string) (string, bool, error) {
+	val, err := c.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read cached artifact: %w", err)
+	}
+	return val, true, nil
+}
+
+func (c *ValkeyArtifactCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := c.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to cache artifact: %w", err)
+	}
+	return nil
+}
+
+// ReconcileOptions configures a Reconciler.
+type ReconcileOptions struct {
+	Window time.Duration
+	// SampleRate is the share of the done tasks checked, in (0, 1].
+	SampleRate float64
+	BatchSize  int
+	MaxPerRun  int
+	// ValkeyRate and PostgresRate are the requests per second made to each
+	// of them, 0 for no limit.
+	ValkeyRate   float64
+	PostgresRate float64
+	// CacheTTL is the TTL of the rehydrated cache entries.
+	CacheTTL time.Duration
+}
+
+// DefaultReconcileOptions returns the default ReconcileOptions.
+func DefaultReconcileOptions() ReconcileOptions {
+	return ReconcileOptions{
+		Window:     DefaultReconcileWindow,
+		SampleRate: DefaultReconcileSampleRate,
+		BatchSize:  DefaultReconcileBatchSize,
+		MaxPerRun:  DefaultReconcileMaxPerRun,
+		CacheTTL:   ArtifactCacheTTL,
+	}
+}
+
+// NewReconcileOptions returns the ReconcileOptions of cfg.
+func NewReconcileOptions(cfg global.ReconcileConfig) ReconcileOptions {
+	return ReconcileOptions{
+		Window:       cfg.Window,
+		SampleRate:   cfg.SampleRate,
+		BatchSize:    cfg.BatchSize,
+		MaxPerRun:    cfg.MaxPerRun,
+		ValkeyRate:   cfg.ValkeyRate,
+		PostgresRate: cfg.PostgresRate,
+		CacheTTL:     cfg.CacheTTL,
+	}
+}
+
+// Reconciler periodically compares the artifacts of a sample of the recently
+// done tasks in the cache with the ones in the database. A missing cache entry
+// is rehydrated from the database, the other divergences are queued for
+// review. The passes are recorded as reconciliation reports, and a pass which
+// did not get through its tasks is resumed by the next run.
+type Reconciler struct {
+	store  ReconcileStore
+	review ReviewEnqueuer
+	cache  ArtifactCache
+	opts   ReconcileOptions
+	clock  clockid.Clock
+
+	valkey   *pacer
+	postgres *pacer
+}
+
+// NewReconciler creates a Reconciler.
+func NewReconciler(store ReconcileStore, review ReviewEnqueuer, cache ArtifactCache,
+	opts ReconcileOptions) (*Reconciler, error) {
+	if store == nil {
+		return nil, fmt.Errorf("reconcile store should not be nil")
+	}
+
+	if review == nil {
+		return nil, fmt.Errorf("review enqueuer should not be nil")
+	}
+
+	if cache == nil {
+		return nil, fmt.Errorf("artifact cache should not be nil")
+	}
+
+	if opts.Window <= 0 {
+		return nil, fmt.Errorf("window should be positive: %s", opts.Window)
+	}
+
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		return nil, fmt.Errorf("sample rate should be in (0, 1]: %g", opts.SampleRate)
+	}
+
+	if opts.BatchSize <= 0 {
+		return nil, fmt.Errorf("batch size should be positive: %d", opts.BatchSize)
+	}
+
+	if opts.MaxPerRun <= 0 {
+		return nil, fmt.Errorf("max tasks per run should be positive: %d", opts.MaxPerRun)
+	}
+
+	if opts.ValkeyRate < 0 || opts.PostgresRate < 0 {
+		return nil, fmt.Errorf("rates should not be negative: %g, %g", opts.ValkeyRate, opts.PostgresRate)
+	}
+
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = ArtifactCacheTTL
+	}
+
+	w := &Reconciler{
+		store:  store,
+		review: review,
+		cache:  cache,
+		opts:   opts,
+	}
+	return w.WithClock(clockid.Real), nil
+}
+
+// WithClock makes the worker take the time and pace its requests on c.
+func (w *Reconciler) WithClock(c clockid.Clock) *Reconciler {
+	w.clock = c
+	w.valkey = newPacer(c, w.opts.ValkeyRate)
+	w.postgres = newPacer(c, w.opts.PostgresRate)
+	return w
+}
+
+// RunOnce resumes the running pass, or starts one over the tasks done within
+// the window, and reconciles the sampled tasks, at most MaxPerRun of them. The
+// progress is saved after each batch, and the pass is finished once it runs
+// out of tasks. It returns the report of the pass.
+func (w *Reconciler) RunOnce(ctx context.Context) (storage.ReconciliationReport, error) {
+	if err := w.postgres.wait(ctx); err != nil {
+		return storage.ReconciliationReport{}, err
+	}
+	report, ok, err := w.store.Running(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	if !ok {
+		if err := w.postgres.wait(ctx); err != nil {
+			return report, err
+		}
+		report, err = w.store.Start(ctx, w.clock.Now().Add(-w.opts.Window))
+		if err != nil {
+			return report, err
+		}
+	}
+	if report.Counts == nil {
+		report.Counts = map[string]int32{}
+	}
+
+	sampled := 0
+	for sampled < w.opts.MaxPerRun {
+		if err := w.postgres.wait(ctx); err != nil {
+			return w.save(ctx, report, false, err)
+		}
+		tasks, err := w.store.DoneTasksSince(ctx, report.Since, report.LastTaskID, int32(w.opts.BatchSize))
+		if err != nil {
+			return w.save(ctx, report, false, err)
+		}
+
+		if len(tasks) == 0 {
+			return w.save(ctx, report, true, nil)
+		}
+
+		for _, task := range tasks {
+			if sampled >= w.opts.MaxPerRun {
+				break
+			}
+
+			if w.sampled(task.TaskID) {
+				if err := w.reconcile(ctx, task.TaskID, &report); err != nil {
+					// the task is checked again when the pass is resumed
+					return w.save(ctx, report, false, err)
+				}
+				sampled++
+				report.Sampled++
+				reconciledTasksTotal.Inc()
+			}
+			report.Checked++
+			report.LastTaskID = task.ID
+		}
+
+		if report, err = w.save(ctx, report, false, nil); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// save records the progress of report and returns err, if any, or the error
+// of saving it. The progress is saved even if ctx is done.
+func (w *Reconciler) save(ctx context.Context, report storage.ReconciliationReport,
+	finished bool, err error) (storage.ReconciliationReport, error) {
+	saved, sErr := w.store.Save(context.WithoutCancel(ctx), report, finished)
+	if sErr != nil {
+		global.Logger.Error().
+			Err(sErr).
+			Int32("report_id", report.ID).
+			Msg("Failed to save reconciliation report")
+		saved = report
+	}
+
+	if err != nil {
+		return saved, err
+	}
+	return saved, sErr
+}
+
+// sampled reports whether the task is in the sample. The sample only depends
+// on the task id, so that a resumed pass samples the same tasks.
+func (w *Reconciler) sampled(taskID uuid.UUID) bool {
+	if w.opts.SampleRate >= 1 {
+		return true
+	}
+
+	sum := sha256.Sum256(taskID[:])
+	return float64(binary.BigEndian.Uint64(sum[:8]))/math.MaxUint64 < w.opts.SampleRate
+}
+
+// reconcile compares the artifacts of the task in the cache with the ones in
+// the database and counts the divergences in report. The errors of the cache
+// and of the review queue are logged, only the ones reading the artifacts are
+// returned.
+func (w *Reconciler) reconcile(ctx context.Context, taskID uuid.UUID, report *storage.ReconciliationReport) error {
+	if err := w.postgres.wait(ctx); err != nil {
+		return err
+	}
+	articles, err := w.store.Artifacts(ctx, taskID)
+	if err != nil {
+		return err
+	}
+
+	// the cache holds the artifacts of the article of the task
+	var article models.ListTaskArtifactsRow
+	if len(articles) > 0 {
+		article = articles[0]
+	}
+
+	cached := []struct {
+		artifact string
+		key      string
+		// db is the value in the database, "" if there is none
+		db   string
+		hash func(string) (string, bool)
+	}{
+		{ArtifactTitle, TitleCacheKey(taskID), strings.TrimSpace(article.Title), hashTitle},
+		{ArtifactContent, ContentCacheKey(taskID), article.Content, hashContent},
+		{ArtifactKeywords, KeywordsCacheKey(taskID), keywordsValue(article.Keywords), hashKeywords},
+	}
+
+	for _, c := range cached {
+		if err := w.valkey.wait(ctx); err != nil {
+			return err
+		}
+		val, ok, err := w.cache.Get(ctx, c.key)
+		if err != nil {
+			global.Logger.Warn().
+				Err(err).
+				Str("task_id", taskID.String()).
+				Str("artifact", c.artifact).
+				Msg("Failed to read cached artifact")
+			continue
+		}
+
+		switch {
+		case !ok && c.db == "":
+		case !ok:
+			w.count(report, c.artifact, DivergenceCacheMissing)
+			w.rehydrate(ctx, taskID, c.artifact, c.key, c.db, report)
+		case c.db == "":
+			w.count(report, c.artifact, DivergenceDBMissing)
+			w.flag(ctx, taskID, article.ID, c.artifact, DivergenceDBMissing, report)
+		default:
+			cacheHash, valid := c.hash(val)
+			dbHash, _ := c.hash(c.db)
+			if !valid || cacheHash != dbHash {
+				w.count(report, c.artifact, DivergenceHashMismatch)
+				w.flag(ctx, taskID, article.ID, c.artifact, DivergenceHashMismatch, report)
+			}
+		}
+	}
+
+	// the embeddings are not cached, their count is checked against the chunks
+	if article.EmbeddedChunks < article.Chunks {
+		w.count(report, ArtifactEmbeddings, DivergenceCountMismatch)
+		w.flag(ctx, taskID, article.ID, ArtifactEmbeddings, DivergenceCountMismatch, report)
+	}
+	return nil
+}
+
+func (w *Reconciler) count(report *storage.ReconciliationReport, artifact, class string) {
+	report.Counts[class]++
+	reconciliationDivergencesTotal.WithLabelValues(artifact, class).Inc()
+}
+
+// rehydrate caches the artifact of the task from the database.
+func (w *Reconciler) rehydrate(ctx context.Context, taskID uuid.UUID, artifact, key, val string,
+	report *storage.ReconciliationReport) {
+	if err := w.valkey.wait(ctx); err != nil {
+		return
+	}
+
+	if err := w.cache.Set(ctx, key, val, w.opts.CacheTTL); err != nil {
+		global.Logger.Warn().
+			Err(err).
+			Str("task_id", taskID.String()).
+			Str("artifact", artifact).
+			Msg("Failed to rehydrate cached artifact")
+		return
+	}
+	report.Repaired++
+}
+
+// flag queues the divergence of the artifact of the article for review. The
+// divergences of a task without an article are only counted.
+func (w *Reconciler) flag(ctx context.Context, taskID uuid.UUID, articleID int32, artifact, class string,
+	report *storage.ReconciliationReport) {
+	if articleID == 0 {
+		global.Logger.Warn().
+			Str("task_id", taskID.String()).
+			Str("artifact", artifact).
+			Str("class", class).
+			Msg("Cached artifact of a task without article")
+		return
+	}
+
+	if err := w.postgres.wait(ctx); err != nil {
+		return
+	}
+
+	_, err := w.review.Enqueue(ctx, storage.ReviewItem{
+		Type:      models.ReviewItemTypeReconciliation,
+		Ref:       map[string]any{"article_id": articleID, "artifact": artifact},
+		ArticleID: articleID,
+		Reason:    fmt.Sprintf("%s %s between the cache and the database", artifact, strings.ReplaceAll(class, "_", " ")),
+	})
+	if err != nil {
+		global.Logger.Error().
+			Err(err).
+			Str("task_id", taskID.String()).
+			Int32("article_id", articleID).
+			Str("artifact", artifact).
+			Str("class", class).
+			Msg("Failed to flag divergent artifact")
+		return
+	}
+	report.Flagged++
+}
+
+func hashContent(s string) (string, bool) {
+	return hashString(s), true
+}
+
+func hashTitle(s string) (string, bool) {
+	return hashString(strings.TrimSpace(s)), true
+}
+
+// hashKeywords hashes the JSON array of keyword terms s regardless of the order
+// and the duplicates of the terms. It reports false if s is not one.
+func hashKeywords(s string) (string, bool) {
+	var terms []string
+	if err := json.Unmarshal([]byte(s), &terms); err != nil {
+		return "", false
+	}
+	slices.Sort(terms)
+	return hashString(strings.Join(slices.Compact(terms), "\n")), true
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// keywordsValue returns the cached value of the keyword terms, "" if there are
+// none.
+func keywordsValue(terms []string) string {
+	if len(terms) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(terms)
+	return string(data)
+}
+
+// Run runs a reconciliation pass every interval until ctx is cancelled.
+func (w *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := w.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := w.RunOnce(ctx)
+		if err != nil {
+			global.Logger.Error().Err(err).Msg("Cache reconciliation failed")
+		} else {
+			global.Logger.Info().
+				Int32("report_id", report.ID).
+				Int32("checked", report.Checked).
+				Int32("sampled", report.Sampled).
+				Int32("repaired", report.Repaired).
+				Int32("flagged", report.Flagged).
+				Bool("finished", report.FinishedAt != nil).
+				Msg("Cache reconciliation finished")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// pacer spaces the requests to a backend out to a rate per second.
+type pacer struct {
+	clock    clockid.Clock
+	interval time.Duration
+	next     time.Time
+}
+
+// newPacer creates a pacer of rate requests per second, a pacer which never
+// waits if rate is not positive.
+func newPacer(c clockid.Clock, rate float64) *pacer {
+	p := &pacer{clock: c}
+	if rate > 0 {
+		p.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return p
+}
+
+// wait waits for the turn of the next request, or for ctx to be done.
+func (p *pacer) wait(ctx context.Context) error {
+	if p.interval <= 0 {
+		return ctx.Err()
+	}
+
+	now := p.clock.Now()
+	if p.next.After(now) {
+		if err := clockid.Sleep(ctx, p.clock, p.next.Sub(now)); err != nil {
+			return err
+		}
+		now = p.next
+	}
+	p.next = now.Add(p.interval)
+	return nil
+}
@@ -0,0 +1,375 @@
+package workers_test
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReconcileStore keeps the done tasks, their artifacts and the reports in
+// memory.
+type fakeReconcileStore struct {
+	mu        sync.Mutex
+	tasks     []models.ListDoneTasksSinceRow
+	artifacts map[uuid.UUID][]models.ListTaskArtifactsRow
+	reports   []storage.ReconciliationReport
+	now       func() time.Time
+}
+
+func newFakeReconcileStore(now func() time.Time) *fakeReconcileStore {
+	return &fakeReconcileStore{artifacts: map[uuid.UUID][]models.ListTaskArtifactsRow{}, now: now}
+}
+
+// add adds a done task with the artifacts of its article.
+func (s *fakeReconcileStore) add(taskID uuid.UUID, articles ...models.ListTaskArtifactsRow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks = append(s.tasks, models.ListDoneTasksSinceRow{ID: int32(len(s.tasks) + 1), TaskID: taskID})
+	s.artifacts[taskID] = articles
+}
+
+func (s *fakeReconcileStore) DoneTasksSince(ctx context.Context, since time.Time,
+	afterID, limit int32) ([]models.ListDoneTasksSinceRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tasks []models.ListDoneTasksSinceRow
+	for _, t := range s.tasks {
+		if t.ID > afterID && len(tasks) < int(limit) {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks, nil
+}
+
+func (s *fakeReconcileStore) Artifacts(ctx context.Context, taskID uuid.UUID) ([]models.ListTaskArtifactsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.artifacts[taskID], nil
+}
+
+func (s *fakeReconcileStore) Running(ctx context.Context) (storage.ReconciliationReport, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.reports {
+		if r.FinishedAt == nil {
+			r.Counts = maps.Clone(r.Counts)
+			return r, true, nil
+		}
+	}
+	return storage.ReconciliationReport{}, false, nil
+}
+
+func (s *fakeReconcileStore) Start(ctx context.Context, since time.Time) (storage.ReconciliationReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := storage.ReconciliationReport{
+		ID:        int32(len(s.reports) + 1),
+		Since:     since,
+		Counts:    map[string]int32{},
+		StartedAt: s.now(),
+		UpdatedAt: s.now(),
+	}
+	s.reports = append(s.reports, r)
+	return r, nil
+}
+
+func (s *fakeReconcileStore) Save(ctx context.Context, report storage.ReconciliationReport,
+	finished bool) (storage.ReconciliationReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report.Counts = maps.Clone(report.Counts)
+	report.UpdatedAt = s.now()
+	if finished {
+		at := s.now()
+		report.FinishedAt = &at
+	}
+	s.reports[report.ID-1] = report
+	return report, nil
+}
+
+type fakeReviewQueue struct {
+	mu    sync.Mutex
+	items []storage.ReviewItem
+}
+
+func (q *fakeReviewQueue) Enqueue(ctx context.Context, item storage.ReviewItem) (models.UsersReviewQueue, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(q.items, item)
+	return models.UsersReviewQueue{ID: int32(len(q.items)), ItemType: item.Type, ArticleID: item.ArticleID}, nil
+}
+
+type cachedArtifact struct {
+	value string
+	ttl   time.Duration
+}
+
+type fakeArtifactCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedArtifact
+}
+
+func newFakeArtifactCache() *fakeArtifactCache {
+	return &fakeArtifactCache{entries: map[string]cachedArtifact{}}
+}
+
+func (c *fakeArtifactCache) Get(ctx context.Context, key string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	return e.value, ok, nil
+}
+
+func (c *fakeArtifactCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cachedArtifact{value: value, ttl: ttl}
+	return nil
+}
+
+var reconcileNow = time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+type reconcileFixture struct {
+	clock  *clockid.Fake
+	store  *fakeReconcileStore
+	review *fakeReviewQueue
+	cache  *fakeArtifactCache
+	ids    *clockid.Sequence
+}
+
+func newReconcileFixture() *reconcileFixture {
+	clock := clockid.NewFake(reconcileNow)
+	return &reconcileFixture{
+		clock:  clock,
+		store:  newFakeReconcileStore(clock.Now),
+		review: &fakeReviewQueue{},
+		cache:  newFakeArtifactCache(),
+		ids:    &clockid.Sequence{},
+	}
+}
+
+func (f *reconcileFixture) reconciler(t *testing.T, opts workers.ReconcileOptions) *workers.Reconciler {
+	t.Helper()
+
+	w, err := workers.NewReconciler(f.store, f.review, f.cache, opts)
+	require.NoError(t, err)
+	return w.WithClock(f.clock)
+}
+
+// consistent adds a done task whose artifacts are cached as they are stored.
+func (f *reconcileFixture) consistent(articleID int32) uuid.UUID {
+	taskID := f.ids.NewUUID()
+	f.store.add(taskID, models.ListTaskArtifactsRow{
+		ID:             articleID,
+		Title:          "立法院三讀通過預算案",
+		Content:        "立法院今日三讀通過總預算案。",
+		Keywords:       []string{"立法院", "預算"},
+		Chunks:         2,
+		EmbeddedChunks: 2,
+	})
+	_ = f.cache.Set(context.Background(), workers.TitleCacheKey(taskID), "立法院三讀通過預算案", time.Hour)
+	_ = f.cache.Set(context.Background(), workers.ContentCacheKey(taskID), "立法院今日三讀通過總預算案。", time.Hour)
+	_ = f.cache.Set(context.Background(), workers.KeywordsCacheKey(taskID), `["預算","立法院","預算"]`, time.Hour)
+	return taskID
+}
+
+func TestNewReconciler(t *testing.T) {
+	f := newReconcileFixture()
+
+	opts := workers.DefaultReconcileOptions()
+	opts.SampleRate = 0
+	_, err := workers.NewReconciler(f.store, f.review, f.cache, opts)
+	require.ErrorContains(t, err, "sample rate should be in (0, 1]")
+
+	opts = workers.DefaultReconcileOptions()
+	opts.PostgresRate = -1
+	_, err = workers.NewReconciler(f.store, f.review, f.cache, opts)
+	require.ErrorContains(t, err, "rates should not be negative")
+
+	_, err = workers.NewReconciler(f.store, nil, f.cache, workers.DefaultReconcileOptions())
+	require.ErrorContains(t, err, "review enqueuer should not be nil")
+}
+
+func TestReconcilerClassification(t *testing.T) {
+	f := newReconcileFixture()
+	ctx := context.Background()
+
+	// the title expired from the cache
+	expired := f.consistent(1)
+	f.cache.mu.Lock()
+	delete(f.cache.entries, workers.TitleCacheKey(expired))
+	f.cache.mu.Unlock()
+
+	// the keywords were cached but never stored
+	unstored := f.ids.NewUUID()
+	f.store.add(unstored, models.ListTaskArtifactsRow{ID: 2, Content: "內容", Chunks: 1, EmbeddedChunks: 1})
+	_ = f.cache.Set(ctx, workers.ContentCacheKey(unstored), "內容", time.Hour)
+	_ = f.cache.Set(ctx, workers.KeywordsCacheKey(unstored), `["罷免"]`, time.Hour)
+
+	// the content was rescraped without the cache being updated
+	stale := f.consistent(3)
+	_ = f.cache.Set(ctx, workers.ContentCacheKey(stale), "舊的內容", time.Hour)
+
+	// the embedding of a chunk failed
+	unembedded := f.ids.NewUUID()
+	f.store.add(unembedded, models.ListTaskArtifactsRow{ID: 4, Content: "內容", Chunks: 3, EmbeddedChunks: 1})
+	_ = f.cache.Set(ctx, workers.ContentCacheKey(unembedded), "內容", time.Hour)
+
+	f.consistent(5)
+
+	opts := workers.DefaultReconcileOptions()
+	opts.SampleRate = 1
+	opts.CacheTTL = 30 * time.Minute
+	report, err := f.reconciler(t, opts).RunOnce(ctx)
+	require.NoError(t, err)
+
+	require.NotNil(t, report.FinishedAt)
+	require.Equal(t, reconcileNow.Add(-opts.Window), report.Since)
+	require.Equal(t, int32(5), report.Checked)
+	require.Equal(t, int32(5), report.Sampled)
+	require.Equal(t, int32(5), report.LastTaskID)
+	require.Equal(t, map[string]int32{
+		workers.DivergenceCacheMissing:  1,
+		workers.DivergenceDBMissing:     1,
+		workers.DivergenceHashMismatch:  1,
+		workers.DivergenceCountMismatch: 1,
+	}, report.Counts)
+	require.Equal(t, int32(1), report.Repaired)
+	require.Equal(t, int32(3), report.Flagged)
+
+	// the missing cache entry is rehydrated from the database
+	title, ok, err := f.cache.Get(ctx, workers.TitleCacheKey(expired))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "立法院三讀通過預算案", title)
+	require.Equal(t, 30*time.Minute, f.cache.entries[workers.TitleCacheKey(expired)].ttl)
+
+	// the stale cache entry is left for the editor
+	content, _, _ := f.cache.Get(ctx, workers.ContentCacheKey(stale))
+	require.Equal(t, "舊的內容", content)
+
+	require.Len(t, f.review.items, 3)
+	for i, want := range []struct {
+		articleID int32
+		artifact  string
+	}{
+		{2, workers.ArtifactKeywords},
+		{3, workers.ArtifactContent},
+		{4, workers.ArtifactEmbeddings},
+	} {
+		item := f.review.items[i]
+		require.Equal(t, models.ReviewItemTypeReconciliation, item.Type)
+		require.Equal(t, want.articleID, item.ArticleID)
+		require.Equal(t, map[string]any{"article_id": want.articleID, "artifact": want.artifact}, item.Ref)
+		require.NotEmpty(t, item.Reason)
+	}
+}
+
+func TestReconcilerResume(t *testing.T) {
+	f := newReconcileFixture()
+	for i := range 5 {
+		f.consistent(int32(i + 1))
+	}
+
+	opts := workers.DefaultReconcileOptions()
+	opts.SampleRate = 1
+	opts.BatchSize = 2
+	opts.MaxPerRun = 3
+	w := f.reconciler(t, opts)
+
+	report, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, report.FinishedAt)
+	require.Equal(t, int32(3), report.Sampled)
+	require.Equal(t, int32(3), report.LastTaskID)
+
+	// the next run picks the pass up where it stopped
+	f.clock.Advance(time.Hour)
+	resumed, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, report.ID, resumed.ID)
+	require.Equal(t, report.Since, resumed.Since)
+	require.NotNil(t, resumed.FinishedAt)
+	require.Equal(t, int32(5), resumed.Sampled)
+	require.Equal(t, int32(5), resumed.Checked)
+	require.Empty(t, resumed.Counts)
+
+	// and the one after starts a new pass
+	next, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.NotEqual(t, report.ID, next.ID)
+	require.Equal(t, reconcileNow.Add(time.Hour-opts.Window), next.Since)
+}
+
+func TestReconcilerSampling(t *testing.T) {
+	f := newReconcileFixture()
+	for i := range 200 {
+		f.consistent(int32(i + 1))
+	}
+
+	opts := workers.DefaultReconcileOptions()
+	opts.SampleRate = 0.5
+	w := f.reconciler(t, opts)
+
+	first, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(200), first.Checked)
+	require.InDelta(t, 100, first.Sampled, 30)
+
+	// the sample is the same on every pass
+	second, err := w.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, first.Sampled, second.Sampled)
+}
+
+func TestReconcilerRateLimit(t *testing.T) {
+	f := newReconcileFixture()
+	f.consistent(1)
+
+	opts := workers.DefaultReconcileOptions()
+	opts.SampleRate = 1
+	opts.PostgresRate = 1
+	opts.ValkeyRate = 2
+	w := f.reconciler(t, opts)
+
+	done := make(chan storage.ReconciliationReport)
+	go func() {
+		report, err := w.RunOnce(context.Background())
+		require.NoError(t, err)
+		done <- report
+	}()
+
+	// finding, starting and paging the pass, loading the artifacts and
+	// paging again are five Postgres requests a second apart, the three cache
+	// reads half a second apart are done before the last one
+	for range 4 {
+		f.clock.BlockUntil(1)
+		select {
+		case <-done:
+			t.Fatal("the requests should be paced")
+		default:
+		}
+		f.clock.Advance(time.Second)
+	}
+
+	select {
+	case report := <-done:
+		require.NotNil(t, report.FinishedAt)
+		require.Equal(t, reconcileNow.Add(4*time.Second), *report.FinishedAt)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pass did not finish")
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
 	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
@@ -28,6 +31,7 @@ const (
 	HealthCheckPort           = 8080
 	HealthCheckHost           = "localhost"
 	ShutdownWaitTime          = 5 * time.Second
+	WarmupTimeout             = 2 * time.Minute
 )
 
 // Runner manages the lifecycle of a worker, handling subscriptions, message fetching,
@@ -59,6 +63,10 @@ func NewRunner(nc *nats.Conn, logger zerolog.Logger, tracer trace.Tracer, w Hand
 			HealthCheckPort:  HealthCheckPort,
 			HealthCheckHost:  HealthCheckHost,
 			ShutdownWaitTime: ShutdownWaitTime,
+			Clock:            clockid.Real,
+			IDs:              clockid.Random,
+			DualSubscribe:    true,
+			WarmupTimeout:    WarmupTimeout,
 		},
 	}
 
@@ -71,14 +79,19 @@ func NewRunner(nc *nats.Conn, logger zerolog.Logger, tracer trace.Tracer, w Hand
 // Run starts the worker and blocks until the context is canceled.
 func (r *Runner) Run(ctx context.Context) error {
 	go r.startHealthCheckServer()
+	r.warmup(ctx)
 
+	subject := CurrentSubject(r.worker.Subject())
 	opts := []nats.SubOpt{
 		nats.BindStream(r.worker.StreamName()),
 	}
+	if legacy, ok := LegacySubject(subject); ok && r.options.DualSubscribe {
+		// a consumer filters both subjects, the subject is then left empty
+		opts = append(opts, nats.ConsumerFilterSubjects(subject, legacy))
+		subject = ""
+	}
 	opts = append(opts, r.worker.ConsumerOptions()...)
-	sub, err := r.js.PullSubscribe(
-		r.worker.Subject(),
-		r.worker.DurableName(), opts...)
+	sub, err := r.js.PullSubscribe(subject, r.worker.DurableName(), opts...)
 
 	if err != nil {
 		e := ec.ErrNATSServerError.Clone().
@@ -90,9 +103,10 @@ func (r *Runner) Run(ctx context.Context) error {
 	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	start := time.Now()
+	start := r.options.Clock.Now()
 	r.logger.Info().
-		Str("subject", r.worker.Subject()).
+		Str("subject", CurrentSubject(r.worker.Subject())).
+		Bool("dual_subscribe", r.options.DualSubscribe).
 		Str("durable_name", r.worker.DurableName()).
 		Str("stream_name", r.worker.StreamName()).
 		Msg("runner started, waiting for messages...")
@@ -101,23 +115,21 @@ func (r *Runner) Run(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
-			if err := sub.Unsubscribe(); err != nil {
-				r.logger.Error().
-					Err(err).
-					Str("subject", sub.Subject).
-					Msg("failed to unsubscribed subject")
-			}
+			// the subscription is left to the connection: unsubscribing deletes
+			// the durable consumer created by PullSubscribe, together with the
+			// messages handled but not acknowledged yet, which are then never
+			// redelivered to the next runner
 
 			sCtx, sCancel := context.WithTimeout(context.Background(), r.options.ShutdownWaitTime)
 			defer sCancel()
 			if r.healthCheckServer != nil {
 				if err := r.healthCheckServer.Shutdown(sCtx); err != nil {
 					r.logger.Error().Err(err).
-						Dur("uptime", time.Since(start)).
+						Dur("uptime", r.options.Clock.Since(start)).
 						Msg("health check server forced to shutdown")
 				} else {
 					r.logger.Info().
-						Dur("uptime", time.Since(start)).
+						Dur("uptime", r.options.Clock.Since(start)).
 						Msg("health check server exit gracefully")
 				}
 			}
@@ -134,7 +146,7 @@ func (r *Runner) Run(ctx context.Context) error {
 					Int("retry", retry).
 					Dur("wait", wait).
 					Msg("failed to fetch messages")
-				time.Sleep(wait)
+				_ = clockid.Sleep(ctx, r.options.Clock, wait)
 				retry++
 				continue
 			}
@@ -146,6 +158,29 @@ func (r *Runner) Run(ctx context.Context) error {
 	}
 }
 
+// warmup calls the Warmup of the worker if it implements Warmer, for up to
+// WarmupTimeout.
+func (r *Runner) warmup(ctx context.Context) {
+	w, ok := r.worker.(Warmer)
+	if !ok {
+		return
+	}
+
+	wCtx, cancel := context.WithTimeout(ctx, r.options.WarmupTimeout)
+	defer cancel()
+	start := r.options.Clock.Now()
+	if err := w.Warmup(wCtx); err != nil {
+		r.logger.Warn().
+			Err(err).
+			Dur("elapsed", r.options.Clock.Since(start)).
+			Msg("failed to warm up the worker, subscribing anyway")
+		return
+	}
+	r.logger.Info().
+		Dur("elapsed", r.options.Clock.Since(start)).
+		Msg("worker warmed up")
+}
+
 // processMessage handles the full lifecycle of a single message, including tracing and ack/nak.
 func (r *Runner) processMessage(ctx context.Context, msg *nats.Msg) {
 	pCtx := otel.GetTextMapPropagator().
@@ -157,6 +192,15 @@ func (r *Runner) processMessage(ctx context.Context, msg *nats.Msg) {
 		))
 	defer sSpan.End()
 
+	// the original of the copy is consumed on the subject of the hierarchy
+	if r.options.DualSubscribe && msg.Header.Get(AliasOfHeader) == CurrentSubject(r.worker.Subject()) {
+		sSpan.SetAttributes(attribute.Bool("alias", true))
+		if ackErr := msg.Ack(); ackErr != nil {
+			r.logger.Error().Err(ackErr).Msg("failed to send ACK")
+		}
+		return
+	}
+
 	tCtx, tCancel := context.WithTimeout(sCtx, r.options.Timeout)
 	defer tCancel()
 
@@ -164,8 +208,8 @@ func (r *Runner) processMessage(ctx context.Context, msg *nats.Msg) {
 		if errors.Is(err, ErrMalformedMessage) {
 			failedMsg := MsgTaskFailed{
 				BaseMessage: BaseMessage{
-					TaskID:   uuid.New(),
-					EventAt:  time.Now().Unix(),
+					TaskID:   r.options.IDs.NewUUID(),
+					EventAt:  r.options.Clock.Now().Unix(),
 					Version:  MessageVersion,
 					CacheKey: "",
 				},
@@ -174,11 +218,8 @@ func (r *Runner) processMessage(ctx context.Context, msg *nats.Msg) {
 			}
 
 			failedData, _ := json.Marshal(failedMsg)
-			_, _ = r.js.PublishMsg(&nats.Msg{
-				Subject: TaskFailed,
-				Header:  msg.Header,
-				Data:    failedData,
-			})
+			r.publishFailed(msg.Header, failedData)
+			r.markFailed(sCtx, msg.Data, err)
 
 			sSpan.RecordError(err)
 			sSpan.SetAttributes(attribute.Bool("success", false))
@@ -209,6 +250,61 @@ func (r *Runner) processMessage(ctx context.Context, msg *nats.Msg) {
 	r.logger.Info().Msg("message processed and ACKed successfully")
 }
 
+// markFailed records the stage of the worker failed with err in the timeline
+// of the task of the malformed message data, if the Runner has a
+// TaskEventAppender and the task ID of data can be read.
+func (r *Runner) markFailed(ctx context.Context, data []byte, err error) {
+	if r.options.Events == nil {
+		return
+	}
+
+	var base BaseMessage
+	if json.Unmarshal(data, &base) != nil || base.TaskID == uuid.Nil {
+		return
+	}
+
+	stage, ok := ParseCmd(CurrentSubject(r.worker.Subject()))
+	if !ok {
+		stage = StageTask
+	}
+	if _, uErr := AppendTaskEvent(ctx, r.options.Events, base.TaskID, stage, models.TaskStatusFailed, err); uErr != nil {
+		r.logger.Error().Err(uErr).
+			Str("task_id", base.TaskID.String()).
+			Msg("failed to mark the task of a malformed message failed")
+	}
+}
+
+// publishFailed publishes the failure of the stage of the worker, and its
+// TaskFailed alias if the Runner dual subscribes.
+func (r *Runner) publishFailed(header nats.Header, data []byte) {
+	stage, ok := ParseCmd(CurrentSubject(r.worker.Subject()))
+	if !ok {
+		stage = StageTask
+	}
+
+	subject := SubjectEvt(stage, OutcomeFailed)
+	_, _ = r.js.PublishMsg(&nats.Msg{
+		Subject: subject,
+		Header:  header,
+		Data:    data,
+	})
+
+	if !r.options.DualSubscribe {
+		return
+	}
+
+	aliasHeader := nats.Header{}
+	for k, v := range header {
+		aliasHeader[k] = v
+	}
+	aliasHeader.Set(AliasOfHeader, subject)
+	_, _ = r.js.PublishMsg(&nats.Msg{
+		Subject: TaskFailed,
+		Header:  aliasHeader,
+		Data:    data,
+	})
+}
+
 // startHealthCheckServer starts the HTTP server for health and metric endpoints.
 // It intelligently uses custom handlers if the worker provides them, otherwise uses defaults.
 func (r *Runner) startHealthCheckServer() {
@@ -261,6 +357,17 @@ func (r *Runner) defaultReadyCheck(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// only set in warn mode, strict mode refuses to start instead
+	if c, ok := global.SchemaMismatch(); ok {
+		e := ec.ErrSchemaMismatch.Clone().WithDetails(fmt.Sprintf(
+			"database at version %d (dirty: %t), binary expects %d", c.Current, c.Dirty, c.Expected))
+		r.logger.Warn().Str("remote_addr", req.RemoteAddr).Err(e).Msg("database schema is not compatible")
+		w.Header().Add("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(e.HttpStatusCode)
+		_ = e.MarshalAndWriteTo(w)
+		return
+	}
+
 	w.WriteHeader(ec.Success.HttpStatusCode)
 	_ = ec.Success.MarshalAndWriteTo(w)
 }
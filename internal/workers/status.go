@@ -0,0 +1,58 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/google/uuid"
+)
+
+// TaskStatusUpdateTimeout is how long an append to the timeline of a task may
+// take, whether the context of the message is done or not.
+const TaskStatusUpdateTimeout = 5 * time.Second
+
+// TaskEventAppender records the progress of the stages of the tasks in their
+// timelines, from which their statuses are derived: a worker appends its
+// stage processing when it starts on a task, and done or failed when it is
+// through with it. It is implemented by storage.TaskEvents.
+type TaskEventAppender interface {
+	Append(ctx context.Context, taskID uuid.UUID, e storage.TaskEvent) (storage.TaskState, error)
+}
+
+// AppendTaskEvent appends stage moving to status to the timeline of the task
+// of taskID through events, with the message of err, if not nil, as the error
+// it failed with. The append outlives ctx, so that a stage whose message timed
+// out is still recorded failed.
+func AppendTaskEvent(ctx context.Context, events TaskEventAppender, taskID uuid.UUID,
+	stage Stage, status models.TaskStatus, err error) (storage.TaskState, error) {
+	e := storage.TaskEvent{Stage: stage.String(), Status: status}
+	if err != nil {
+		e.Message = err.Error()
+	}
+
+	uCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), TaskStatusUpdateTimeout)
+	defer cancel()
+	return events.Append(uCtx, taskID, e)
+}
+
+// TaskEventStore is a TaskEventAppender reading back the state derived from the
+// timelines. It is implemented by storage.TaskEvents.
+type TaskEventStore interface {
+	TaskEventAppender
+	State(ctx context.Context, taskID uuid.UUID) (storage.TaskState, error)
+}
+
+// StageDone reports whether stage of the task of taskID is done already, e.g.
+// when its command is redelivered after its ACK was lost. A worker acknowledges
+// such a command without running the stage again, which would publish its
+// event a second time and move the next stages back. The state is read from
+// the write pool, as the stage has just been recorded there.
+func StageDone(ctx context.Context, events TaskEventStore, taskID uuid.UUID, stage Stage) (bool, error) {
+	state, err := events.State(storage.WithFreshReads(ctx), taskID)
+	if err != nil {
+		return false, err
+	}
+	return state.Stages[stage.String()].Status == models.TaskStatusDone, nil
+}
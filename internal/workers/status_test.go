@@ -0,0 +1,81 @@
+package workers_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTaskEventAppender records the events, failing those of a done context.
+type fakeTaskEventAppender struct {
+	events []storage.TaskEvent
+	state  storage.TaskState
+}
+
+func (a *fakeTaskEventAppender) Append(ctx context.Context, taskID uuid.UUID,
+	e storage.TaskEvent) (storage.TaskState, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.TaskState{}, err
+	}
+	a.events = append(a.events, e)
+	a.state = a.state.Apply(e)
+	return a.state, nil
+}
+
+func (a *fakeTaskEventAppender) State(ctx context.Context, taskID uuid.UUID) (storage.TaskState, error) {
+	return a.state, nil
+}
+
+func TestAppendTaskEvent(t *testing.T) {
+	a := &fakeTaskEventAppender{}
+	_, err := workers.AppendTaskEvent(context.Background(), a, uuid.New(),
+		workers.StageScrape, models.TaskStatusProcessing, nil)
+	require.NoError(t, err)
+
+	// a stage whose message timed out is still recorded failed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = workers.AppendTaskEvent(ctx, a, uuid.New(),
+		workers.StageScrape, models.TaskStatusFailed, errors.New("fetch timed out"))
+	require.NoError(t, err)
+
+	require.Equal(t, []storage.TaskEvent{
+		{Stage: "scrape", Status: models.TaskStatusProcessing},
+		{Stage: "scrape", Status: models.TaskStatusFailed, Message: "fetch timed out"},
+	}, a.events)
+}
+
+func TestStageDone(t *testing.T) {
+	ctx := context.Background()
+	a := &fakeTaskEventAppender{}
+	taskID := uuid.New()
+
+	done, err := workers.StageDone(ctx, a, taskID, workers.StageScrape)
+	require.NoError(t, err)
+	require.False(t, done)
+
+	for _, status := range []models.TaskStatus{models.TaskStatusProcessing, models.TaskStatusFailed} {
+		_, err = workers.AppendTaskEvent(ctx, a, taskID, workers.StageScrape, status, nil)
+		require.NoError(t, err)
+		done, err = workers.StageDone(ctx, a, taskID, workers.StageScrape)
+		require.NoError(t, err)
+		require.False(t, done, "scrape %s", status)
+	}
+
+	_, err = workers.AppendTaskEvent(ctx, a, taskID, workers.StageScrape, models.TaskStatusDone, nil)
+	require.NoError(t, err)
+	done, err = workers.StageDone(ctx, a, taskID, workers.StageScrape)
+	require.NoError(t, err)
+	require.True(t, done)
+
+	// the next stage is not done with the scrape
+	done, err = workers.StageDone(ctx, a, taskID, workers.StageExtractKeywords)
+	require.NoError(t, err)
+	require.False(t, done)
+}
@@ -0,0 +1,214 @@
+package workers
+
+import "strings"
+
+// The subjects of the task pipeline are laid out as a hierarchy, so that a
+// consumer can follow a whole part of it with a wildcard:
+//
+//	task.cmd.<stage>            a command to run the stage of a task
+//	task.evt.<stage>.<outcome>  the outcome of the stage of a task
+//	admin.cmd.<op>              an administrative command
+//
+// The subjects are built from the typed Stage, Outcome and AdminOp, which
+// cannot be made up out of a string, so that a subject with a typo does not
+// compile. The flat subjects published before, TaskScrape, ArticleScraped and
+// so on, are kept as aliases during the migration to the hierarchy, see
+// CurrentSubject and LegacySubject.
+const (
+	// SubjectAllTaskCmds matches all the task commands.
+	SubjectAllTaskCmds = "task.cmd.>"
+	// SubjectAllTaskEvents matches all the task events.
+	SubjectAllTaskEvents = "task.evt.>"
+	// SubjectAllAdminCmds matches all the admin commands.
+	SubjectAllAdminCmds = "admin.cmd.>"
+)
+
+// AliasOfHeader is the header of the copy of a message published on the alias
+// of its subject, holding the subject of the original. A consumer of both
+// subjects skips the copies.
+const AliasOfHeader = "Weathercock-Alias-Of"
+
+// Stage is a stage of the task pipeline. Its zero value is no stage.
+type Stage struct{ name string }
+
+var (
+	// StageTask is the task as a whole.
+	StageTask = Stage{"task"}
+	// StageScrape scrapes the article of a task.
+	StageScrape = Stage{"scrape"}
+	// StageScrapePriority scrapes the article of a task ahead of StageScrape.
+	StageScrapePriority = Stage{"scrape_priority"}
+	// StageGenerateTitle generates a title for the article.
+	StageGenerateTitle = Stage{"generate_title"}
+	// StageExtractKeywords extracts the keywords of the article.
+	StageExtractKeywords = Stage{"extract_keywords"}
+	// StageCreateEmbedding creates the embeddings of the article.
+	StageCreateEmbedding = Stage{"create_embedding"}
+	// StageUpdateStatus updates the status of the task.
+	StageUpdateStatus = Stage{"update_status"}
+	// StageLog logs the task.
+	StageLog = Stage{"log"}
+)
+
+// Stages are all the stages.
+var Stages = []Stage{
+	StageTask,
+	StageScrape,
+	StageScrapePriority,
+	StageGenerateTitle,
+	StageExtractKeywords,
+	StageCreateEmbedding,
+	StageUpdateStatus,
+	StageLog,
+}
+
+func (s Stage) String() string {
+	return s.name
+}
+
+// Outcome is the outcome of a stage. Its zero value is no outcome.
+type Outcome struct{ name string }
+
+var (
+	// OutcomeCreated is the creation of a task.
+	OutcomeCreated = Outcome{"created"}
+	// OutcomeDone is a stage run successfully.
+	OutcomeDone = Outcome{"done"}
+	// OutcomeFailed is a stage which failed.
+	OutcomeFailed = Outcome{"failed"}
+	// OutcomeUpdated is the output of a stage done before being updated, e.g.
+	// the article of a task updated by its publisher.
+	OutcomeUpdated = Outcome{"updated"}
+)
+
+// Outcomes are all the outcomes.
+var Outcomes = []Outcome{OutcomeCreated, OutcomeDone, OutcomeFailed, OutcomeUpdated}
+
+func (o Outcome) String() string {
+	return o.name
+}
+
+// AdminOp is an administrative operation. Its zero value is no operation.
+type AdminOp struct{ name string }
+
+// The admin commands run the periodic jobs ahead of their schedule.
+var (
+	AdminOpDiscover       = AdminOp{"discover"}
+	AdminOpCheckLinks     = AdminOp{"check_links"}
+	AdminOpDetectAnomaly  = AdminOp{"detect_anomaly"}
+	AdminOpReconcileCache = AdminOp{"reconcile_cache"}
+	AdminOpCheckFreshness = AdminOp{"check_freshness"}
+)
+
+func (o AdminOp) String() string {
+	return o.name
+}
+
+// SubjectCmd returns the subject of the commands of stage.
+func SubjectCmd(stage Stage) string {
+	return "task.cmd." + stage.name
+}
+
+// SubjectEvt returns the subject of the outcome of stage.
+func SubjectEvt(stage Stage, outcome Outcome) string {
+	return "task.evt." + stage.name + "." + outcome.name
+}
+
+// SubjectAdmin returns the subject of the admin command op.
+func SubjectAdmin(op AdminOp) string {
+	return "admin.cmd." + op.name
+}
+
+// ParseCmd returns the stage of the task command subject, and whether it is
+// one.
+func ParseCmd(subject string) (Stage, bool) {
+	name, ok := strings.CutPrefix(subject, "task.cmd.")
+	if !ok {
+		return Stage{}, false
+	}
+	return lookupStage(name)
+}
+
+// ParseEvt returns the stage and the outcome of the task event subject, and
+// whether it is one.
+func ParseEvt(subject string) (Stage, Outcome, bool) {
+	rest, ok := strings.CutPrefix(subject, "task.evt.")
+	if !ok {
+		return Stage{}, Outcome{}, false
+	}
+
+	name, outcome, ok := strings.Cut(rest, ".")
+	if !ok {
+		return Stage{}, Outcome{}, false
+	}
+
+	stage, ok := lookupStage(name)
+	if !ok {
+		return Stage{}, Outcome{}, false
+	}
+
+	for _, o := range Outcomes {
+		if o.name == outcome {
+			return stage, o, true
+		}
+	}
+	return Stage{}, Outcome{}, false
+}
+
+func lookupStage(name string) (Stage, bool) {
+	for _, s := range Stages {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return Stage{}, false
+}
+
+// legacySubjects are the subjects of the hierarchy of the flat subjects.
+var legacySubjects = map[string]string{
+	TaskCreated:       SubjectEvt(StageTask, OutcomeCreated),
+	ArticleScraped:    SubjectEvt(StageScrape, OutcomeDone),
+	KeywordsExtracted: SubjectEvt(StageExtractKeywords, OutcomeDone),
+	EmbeddingCreated:  SubjectEvt(StageCreateEmbedding, OutcomeDone),
+	TaskFailed:        SubjectEvt(StageTask, OutcomeFailed),
+
+	TaskScrape:          SubjectCmd(StageScrape),
+	TaskScrapePriority:  SubjectCmd(StageScrapePriority),
+	TaskGenerateTitle:   SubjectCmd(StageGenerateTitle),
+	TaskExtractKeywords: SubjectCmd(StageExtractKeywords),
+	TaskCreateEmbedding: SubjectCmd(StageCreateEmbedding),
+	TaskUpdateStatus:    SubjectCmd(StageUpdateStatus),
+	TaskLog:             SubjectCmd(StageLog),
+}
+
+// currentSubjects are the flat subjects of the subjects of the hierarchy.
+var currentSubjects = func() map[string]string {
+	m := make(map[string]string, len(legacySubjects))
+	for legacy, current := range legacySubjects {
+		m[current] = legacy
+	}
+	return m
+}()
+
+// CurrentSubject returns the subject of the hierarchy of the flat subject, or
+// the subject itself if it has no alias.
+func CurrentSubject(subject string) string {
+	if current, ok := legacySubjects[subject]; ok {
+		return current
+	}
+	return subject
+}
+
+// LegacySubject returns the flat alias of the subject of the hierarchy, and
+// whether it has one. The failures of all the stages are aliased by
+// TaskFailed.
+func LegacySubject(subject string) (string, bool) {
+	if legacy, ok := currentSubjects[subject]; ok {
+		return legacy, true
+	}
+
+	if _, outcome, ok := ParseEvt(subject); ok && outcome == OutcomeFailed {
+		return TaskFailed, true
+	}
+	return "", false
+}
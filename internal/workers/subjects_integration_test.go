@@ -0,0 +1,188 @@
+//go:build integration
+
+package workers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/ChiaYuChang/weathercock/internal/workers/publishers"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// connectJetStream connects to TEST_NATS_URL, with JetStream enabled, and
+// creates the stream name of subjects afresh.
+func connectJetStream(t *testing.T, name string, subjects ...string) (*nats.Conn, nats.JetStreamContext) {
+	t.Helper()
+
+	natsURL := os.Getenv("TEST_NATS_URL")
+	if natsURL == "" {
+		t.Skip("TEST_NATS_URL is not set")
+	}
+
+	nc, err := nats.Connect(natsURL)
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	if err := js.DeleteStream(name); err != nil && !errors.Is(err, nats.ErrStreamNotFound) {
+		require.NoError(t, err)
+	}
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     name,
+		Subjects: subjects,
+		Storage:  nats.MemoryStorage,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = js.DeleteStream(name) })
+	return nc, js
+}
+
+// recordingHandler is a scrape worker recording the URLs it handles.
+type recordingHandler struct {
+	stream string
+
+	mu   sync.Mutex
+	urls []string
+}
+
+func (h *recordingHandler) Subject() string                { return workers.SubjectCmd(workers.StageScrape) }
+func (h *recordingHandler) StreamName() string             { return h.stream }
+func (h *recordingHandler) DurableName() string            { return "recording-handler" }
+func (h *recordingHandler) ConsumerOptions() []nats.SubOpt { return nil }
+
+func (h *recordingHandler) Handle(ctx context.Context, msg *nats.Msg) error {
+	var cmd workers.CmdScrapeArticle
+	if err := json.Unmarshal(msg.Data, &cmd); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.urls = append(h.urls, cmd.URL)
+	return nil
+}
+
+func (h *recordingHandler) handled() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.urls...)
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestDualPublishSubscribe(t *testing.T) {
+	const stream = "SUBJECTS_DUAL"
+	nc, js := connectJetStream(t, stream, workers.SubjectAllTaskCmds, workers.TaskScrape)
+
+	h := &recordingHandler{stream: stream}
+	r, err := workers.NewRunner(nc, zerolog.Nop(), noop.NewTracerProvider().Tracer("test"), h,
+		workers.WithHealthCheckPort(freePort(t)),
+		workers.WithShutdownWaitTime(time.Second))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = r.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// a worker not migrated yet consumes the legacy subject only
+	legacy, err := js.PullSubscribe(workers.TaskScrape, "legacy-worker", nats.BindStream(stream))
+	require.NoError(t, err)
+
+	tracer := noop.NewTracerProvider().Tracer("test")
+	dual := publishers.NewPublisher("dual", js, zerolog.Nop(), tracer)
+	migrated := publishers.NewPublisher("migrated", js, zerolog.Nop(), tracer).WithDualPublish(false)
+	publish := func(pub *publishers.Publisher, url string) {
+		require.NoError(t, pub.PublishNATSMessage(context.Background(), workers.SubjectCmd(workers.StageScrape),
+			workers.CmdScrapeArticle{BaseMessage: workers.BaseMessage{TaskID: uuid.New()}, URL: url}))
+	}
+
+	publish(dual, "dual")
+	publish(migrated, "migrated")
+	// a publisher not migrated yet publishes the legacy subject only
+	data, err := json.Marshal(workers.CmdScrapeArticle{URL: "legacy"})
+	require.NoError(t, err)
+	_, err = js.Publish(workers.TaskScrape, data)
+	require.NoError(t, err)
+
+	// the dual subscriber handles each command once, skipping the alias copy
+	require.Eventually(t, func() bool { return len(h.handled()) == 3 }, 10*time.Second, 50*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	require.ElementsMatch(t, []string{"dual", "migrated", "legacy"}, h.handled())
+
+	// and the legacy one gets the copy of the dual publisher
+	msgs, err := legacy.Fetch(10, nats.MaxWait(time.Second))
+	require.NoError(t, err)
+	var urls []string
+	for _, msg := range msgs {
+		var cmd workers.CmdScrapeArticle
+		require.NoError(t, json.Unmarshal(msg.Data, &cmd))
+		urls = append(urls, cmd.URL)
+		_ = msg.Ack()
+	}
+	require.ElementsMatch(t, []string{"dual", "legacy"}, urls)
+}
+
+func TestEventTap(t *testing.T) {
+	_, js := connectJetStream(t, "SUBJECTS_EVENTS", workers.SubjectAllTaskEvents)
+
+	tap, err := workers.NewEventTap(js, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tap.Close() })
+
+	pub := publishers.NewPublisher("test", js, zerolog.Nop(), noop.NewTracerProvider().Tracer("test")).
+		WithDualPublish(false)
+	taskID := uuid.New()
+	want := map[string]bool{}
+	for _, stage := range workers.Stages {
+		for _, outcome := range workers.Outcomes {
+			subject := workers.SubjectEvt(stage, outcome)
+			want[subject] = true
+			require.NoError(t, pub.PublishNATSMessage(context.Background(), subject,
+				workers.BaseMessage{Version: workers.MessageVersion, TaskID: taskID}))
+		}
+	}
+
+	got := map[string]bool{}
+	timeout := time.After(10 * time.Second)
+	for len(got) < len(want) {
+		select {
+		case e := <-tap.Events():
+			require.Equal(t, taskID, e.TaskID)
+			require.Equal(t, workers.SubjectEvt(e.Stage, e.Outcome), e.Subject)
+			got[e.Subject] = true
+		case <-timeout:
+			t.Fatalf("tapped %d of the %d events", len(got), len(want))
+		}
+	}
+	require.Equal(t, want, got)
+
+	require.NoError(t, tap.Close())
+	_, ok := <-tap.Events()
+	require.False(t, ok)
+}
@@ -0,0 +1,104 @@
+package workers_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubjectBuilders(t *testing.T) {
+	require.Equal(t, "task.cmd.scrape", workers.SubjectCmd(workers.StageScrape))
+	require.Equal(t, "task.cmd.extract_keywords", workers.SubjectCmd(workers.StageExtractKeywords))
+	require.Equal(t, "task.evt.scrape.done", workers.SubjectEvt(workers.StageScrape, workers.OutcomeDone))
+	require.Equal(t, "task.evt.task.created", workers.SubjectEvt(workers.StageTask, workers.OutcomeCreated))
+	require.Equal(t, "admin.cmd.reconcile_cache", workers.SubjectAdmin(workers.AdminOpReconcileCache))
+
+	// a stage, an outcome or an op can only be one of the declared ones, a
+	// string does not convert to them
+	str := reflect.TypeOf("")
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf(workers.Stage{}),
+		reflect.TypeOf(workers.Outcome{}),
+		reflect.TypeOf(workers.AdminOp{}),
+	} {
+		require.False(t, str.ConvertibleTo(typ), "%s should not be convertible from a string", typ)
+	}
+}
+
+func TestParseSubject(t *testing.T) {
+	for _, stage := range workers.Stages {
+		got, ok := workers.ParseCmd(workers.SubjectCmd(stage))
+		require.True(t, ok, stage.String())
+		require.Equal(t, stage, got)
+
+		for _, outcome := range workers.Outcomes {
+			gotStage, gotOutcome, ok := workers.ParseEvt(workers.SubjectEvt(stage, outcome))
+			require.True(t, ok)
+			require.Equal(t, stage, gotStage)
+			require.Equal(t, outcome, gotOutcome)
+		}
+	}
+
+	for _, subject := range []string{
+		"task.cmd.scrpe",
+		"task.cmd.scrape.priority",
+		"task.scrape",
+		"admin.cmd.discover",
+	} {
+		_, ok := workers.ParseCmd(subject)
+		require.False(t, ok, subject)
+	}
+
+	for _, subject := range []string{
+		"task.evt.scrape",
+		"task.evt.scrape.finished",
+		"task.evt.scrpe.done",
+		"article.scraped",
+	} {
+		_, _, ok := workers.ParseEvt(subject)
+		require.False(t, ok, subject)
+	}
+}
+
+func TestLegacySubjects(t *testing.T) {
+	tcs := []struct {
+		legacy  string
+		current string
+	}{
+		{workers.TaskCreated, workers.SubjectEvt(workers.StageTask, workers.OutcomeCreated)},
+		{workers.ArticleScraped, workers.SubjectEvt(workers.StageScrape, workers.OutcomeDone)},
+		{workers.KeywordsExtracted, workers.SubjectEvt(workers.StageExtractKeywords, workers.OutcomeDone)},
+		{workers.EmbeddingCreated, workers.SubjectEvt(workers.StageCreateEmbedding, workers.OutcomeDone)},
+		{workers.TaskFailed, workers.SubjectEvt(workers.StageTask, workers.OutcomeFailed)},
+		{workers.TaskScrape, workers.SubjectCmd(workers.StageScrape)},
+		{workers.TaskScrapePriority, workers.SubjectCmd(workers.StageScrapePriority)},
+		{workers.TaskGenerateTitle, workers.SubjectCmd(workers.StageGenerateTitle)},
+		{workers.TaskExtractKeywords, workers.SubjectCmd(workers.StageExtractKeywords)},
+		{workers.TaskCreateEmbedding, workers.SubjectCmd(workers.StageCreateEmbedding)},
+		{workers.TaskUpdateStatus, workers.SubjectCmd(workers.StageUpdateStatus)},
+		{workers.TaskLog, workers.SubjectCmd(workers.StageLog)},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.legacy, func(t *testing.T) {
+			require.Equal(t, tc.current, workers.CurrentSubject(tc.legacy))
+			require.Equal(t, tc.current, workers.CurrentSubject(tc.current))
+
+			legacy, ok := workers.LegacySubject(tc.current)
+			require.True(t, ok)
+			require.Equal(t, tc.legacy, legacy)
+		})
+	}
+
+	// the failures of all the stages are aliased by the one legacy subject
+	legacy, ok := workers.LegacySubject(workers.SubjectEvt(workers.StageScrape, workers.OutcomeFailed))
+	require.True(t, ok)
+	require.Equal(t, workers.TaskFailed, legacy)
+
+	// the events of the periodic jobs are not part of the hierarchy
+	require.Equal(t, workers.ArticlesDiscovered, workers.CurrentSubject(workers.ArticlesDiscovered))
+	_, ok = workers.LegacySubject(workers.SubjectAdmin(workers.AdminOpDiscover))
+	require.False(t, ok)
+}
@@ -0,0 +1,25 @@
+package subscribers
+
+import (
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+)
+
+// NewKeywordExtractorWorkerWith creates a KeywordExtractorWorker on the given
+// dependencies instead of the connections to NATS, Postgres and Valkey.
+func NewKeywordExtractorWorkerWith(base workers.BaseWorker, store KeywordExtractorStore,
+	cache workers.ArtifactCache, pub workers.EventPublisher, cli *LLMCli,
+	prompts *llm.PromptStore) (*KeywordExtractorWorker, error) {
+	extractor, err := NewKeywordExtractor(cli, prompts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeywordExtractorWorker{
+		BaseWorker: base,
+		store:      store,
+		cache:      cache,
+		extractor:  extractor,
+		publisher:  pub,
+	}, nil
+}
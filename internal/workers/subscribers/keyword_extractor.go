@@ -8,10 +8,11 @@ import (
 	"time"
 
 	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/models"
 	"github.com/ChiaYuChang/weathercock/internal/storage"
 	"github.com/ChiaYuChang/weathercock/internal/workers"
 	"github.com/ChiaYuChang/weathercock/internal/workers/publishers"
-	"github.com/invopop/jsonschema"
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
@@ -22,24 +23,25 @@ import (
 const (
 	KeywordExtractorWorkerStreamName  = "TASK"
 	KeywordExtractorWorkerDurableName = "keyword-extractor-worker"
-	KeywordExtractorWorkerSubject     = workers.TaskExtractKeywords
 	KeywordExtractorWorkerSource      = "keyword-extractor-worker"
 )
 
+var KeywordExtractorWorkerSubject = workers.SubjectCmd(workers.StageExtractKeywords)
+
 // Constants for OpenTelemetry span names, used for tracing.
 const (
 	KeywordExtractorSpanReadDataFromCache = "keyword-extractor.read-article-from-cache"
 	KeywordExtractorSpanReadDataFromDB    = "keyword-extractor.read-article-from-db"
 	KeywordExtractorSpanGenerateKeywords  = "keyword-extractor.generate-keywords"
 	KeywordExtractorSpanInsertKeywords    = "keyword-extractor.insert-keywords-to-cache"
+	KeywordExtractorSpanStoreKeywords     = "keyword-extractor.insert-keywords-to-db"
+	KeywordExtractorSpanLinkKeywords      = "keyword-extractor.link-keywords"
 )
 
-// Constants for retry logic when interacting with external services (e.g., LLM API).
-const (
-	MaxRetryTimes    = 3
-	MinRetryInterval = 500 * time.Millisecond
-	MaxRetryInterval = 10 * time.Second
-)
+// MaxKeywordRepairs is the number of times the model is asked to correct a
+// keyword output which is not valid JSON or does not match the schema. The
+// transient failures of the LLM API are retried by the client.
+const MaxKeywordRepairs = 2
 
 // LLMCli is a helper struct to bundle an LLM client with its specific
 // configuration (model, prompt) for this worker.
@@ -100,21 +102,83 @@ func (k KeywordExtractorOutput) Flatten() []string {
 	return keywords
 }
 
+// KeywordExtractorStore reads the articles and persists the keywords
+// extracted from them. It is implemented over storage.Storage by
+// NewKeywordExtractorStore.
+type KeywordExtractorStore interface {
+	KeywordLinkStore
+	workers.ReviewEnqueuer
+	workers.TaskEventStore
+	GetArticle(ctx context.Context, aID int32) (*models.UsersArticle, error)
+	// InsertKeywords inserts the keywords of lang and attaches them to the
+	// article with their category, it returns the keywords attached.
+	InsertKeywords(ctx context.Context, aID int32, lang string, keywords []storage.ArticleKeyword) ([]models.Keyword, error)
+	SetNeedsReview(ctx context.Context, aID int32, needsReview bool) error
+}
+
+// keywordExtractorStore is the KeywordExtractorStore of a storage.Storage.
+type keywordExtractorStore struct {
+	storage.Keywords
+	storage.ReviewQueue
+	articles storage.UserArticles
+	events   storage.TaskEvents
+}
+
+// NewKeywordExtractorStore returns the KeywordExtractorStore of store.
+func NewKeywordExtractorStore(store *storage.Storage) KeywordExtractorStore {
+	return keywordExtractorStore{
+		Keywords:    store.Keywords(),
+		ReviewQueue: store.ReviewQueue(),
+		articles:    store.UserArticles(),
+		events:      store.TaskEvents(),
+	}
+}
+
+func (s keywordExtractorStore) GetArticle(ctx context.Context, aID int32) (*models.UsersArticle, error) {
+	return s.articles.GetByID(ctx, aID)
+}
+
+func (s keywordExtractorStore) InsertKeywords(ctx context.Context, aID int32, lang string,
+	keywords []storage.ArticleKeyword) ([]models.Keyword, error) {
+	return s.Keywords.BatchInsert(ctx, aID, lang, keywords)
+}
+
+func (s keywordExtractorStore) SetNeedsReview(ctx context.Context, aID int32, needsReview bool) error {
+	return s.articles.SetNeedsReview(ctx, aID, needsReview)
+}
+
+func (s keywordExtractorStore) Append(ctx context.Context, taskID uuid.UUID,
+	e storage.TaskEvent) (storage.TaskState, error) {
+	return s.events.Append(ctx, taskID, e)
+}
+
+func (s keywordExtractorStore) State(ctx context.Context, taskID uuid.UUID) (storage.TaskState, error) {
+	return s.events.State(ctx, taskID)
+}
+
 // KeywordExtractorWorker is the main worker struct, holding all necessary dependencies
-// like database connections, cache clients, and the LLM client.
+// like the store, the artifact cache, and the LLM client.
 type KeywordExtractorWorker struct {
 	workers.BaseWorker
-	storage   *storage.Storage
-	valkey    *redis.Client
-	llm       *LLMCli
-	prompt    string
-	publisher *publishers.Publisher
+	store     KeywordExtractorStore
+	cache     workers.ArtifactCache
+	extractor *KeywordExtractor
+	linker    *KeywordLinker
+	publisher workers.EventPublisher
 }
 
 // NewKeywordExtractorWorker creates a new instance of the worker, initializing
 // its base components and a dedicated publisher for sending completion events.
+// The keyword prompt variants are looked up in prompts by the article language,
+// if prompts is nil the prompt of cli is used for every language.
 func NewKeywordExtractorWorker(nc *nats.Conn, logger zerolog.Logger, tracer trace.Tracer,
-	store *storage.Storage, valkey *redis.Client, llm *LLMCli) (*KeywordExtractorWorker, error) {
+	store *storage.Storage, valkey *redis.Client, cli *LLMCli,
+	prompts *llm.PromptStore) (*KeywordExtractorWorker, error) {
+	extractor, err := NewKeywordExtractor(cli, prompts)
+	if err != nil {
+		return nil, err
+	}
+
 	baseWorker, err := workers.NewBaseWorker(nc, logger, tracer)
 	if err != nil {
 		return nil, err
@@ -125,13 +189,21 @@ func NewKeywordExtractorWorker(nc *nats.Conn, logger zerolog.Logger, tracer trac
 		baseWorker.JS, baseWorker.Logger, tracer)
 	return &KeywordExtractorWorker{
 		BaseWorker: *baseWorker,
-		storage:    store,
-		valkey:     valkey,
-		llm:        llm,
+		store:      NewKeywordExtractorStore(store),
+		cache:      workers.NewValkeyArtifactCache(valkey),
+		extractor:  extractor,
 		publisher:  pub,
 	}, nil
 }
 
+// WithKeywordLinker makes the worker link the extracted keywords to the
+// keywords of the other language with linker. Linking is best effort, its
+// failures are logged but do not fail the message.
+func (w *KeywordExtractorWorker) WithKeywordLinker(linker *KeywordLinker) *KeywordExtractorWorker {
+	w.linker = linker
+	return w
+}
+
 func (w *KeywordExtractorWorker) Subject() string {
 	return KeywordExtractorWorkerSubject
 }
@@ -154,6 +226,12 @@ func (w *KeywordExtractorWorker) ConsumerOptions() []nats.SubOpt {
 	}
 }
 
+// Warmup loads the keyword model, if its client loads models on demand, so
+// that the first article does not wait for it.
+func (w *KeywordExtractorWorker) Warmup(ctx context.Context) error {
+	return llm.Warmup(ctx, w.extractor.llm.client, w.extractor.llm.model)
+}
+
 // log is a standardized logging helper to ensure consistent log formats for errors.
 func (w KeywordExtractorWorker) log(cmd workers.CmdExtractKeywords,
 	lvl zerolog.Level, msg string, start time.Time, err error, attrs map[string]any) {
@@ -163,14 +241,49 @@ func (w KeywordExtractorWorker) log(cmd workers.CmdExtractKeywords,
 	event.Msg(msg)
 }
 
+// flagKeywordOutput holds the keyword output of the article back and queues
+// it for an editor. Flagging is best effort, its failures are logged but do
+// not fail the message.
+func (w *KeywordExtractorWorker) flagKeywordOutput(ctx context.Context,
+	cmd workers.CmdExtractKeywords, start time.Time, reason string) {
+	if err := w.store.SetNeedsReview(ctx, cmd.ArticleID, true); err != nil {
+		w.log(cmd, zerolog.WarnLevel, "failed to flag keyword output", start, err, nil)
+		return
+	}
+
+	_, err := w.store.Enqueue(ctx, storage.ReviewItem{
+		Type:      models.ReviewItemTypeKeywordOutput,
+		ArticleID: cmd.ArticleID,
+		Reason:    reason,
+	})
+	if err != nil {
+		w.log(cmd, zerolog.WarnLevel, "failed to queue keyword output for review", start, err, nil)
+	}
+}
+
+// setStatus records the keyword extraction of the task of cmd moving to status
+// in its timeline, with err as the error it failed with. It is best effort, a
+// failure is logged but does not fail the message.
+func (w *KeywordExtractorWorker) setStatus(ctx context.Context, cmd workers.CmdExtractKeywords,
+	status models.TaskStatus, start time.Time, err error) {
+	if _, uErr := workers.AppendTaskEvent(ctx, w.store, cmd.TaskID, workers.StageExtractKeywords, status, err); uErr != nil {
+		w.log(cmd, zerolog.WarnLevel, "failed to update task status", start, uErr,
+			map[string]any{"status": status})
+	}
+}
+
 // Handle is the core logic for the worker. It processes a message from the NATS stream.
-func (w *KeywordExtractorWorker) Handle(ctx context.Context, msg *nats.Msg) error {
-	now := time.Now()
+// The extraction is recorded processing in the timeline of the task once the
+// message is parsed, and done or failed with its outcome. The keywords being
+// the last stage of the pipeline, the task is done with them. A command of a
+// task whose keywords are extracted already is acknowledged without a call to
+// the LLM.
+func (w *KeywordExtractorWorker) Handle(ctx context.Context, msg *nats.Msg) (err error) {
+	now := w.Clock.Now()
 	w.Logger.Info().Msg("KeywordExtractorWorker received message")
 
 	// 1. Parse and validate the incoming message.
 	var cmd workers.CmdExtractKeywords
-	var err error
 	if err = json.Unmarshal(msg.Data, &cmd); err != nil {
 		// If parsing fails, this is a permanent "poison pill" error.
 		// We wrap it in ErrMalformedMessage to signal the runner to discard it.
@@ -180,6 +293,24 @@ func (w *KeywordExtractorWorker) Handle(ctx context.Context, msg *nats.Msg) erro
 		return fmt.Errorf("%w: %s", workers.ErrMalformedMessage, err)
 	}
 
+	// a command redelivered once its keywords are extracted is acknowledged
+	// as it is
+	if done, err := workers.StageDone(ctx, w.store, cmd.TaskID, workers.StageExtractKeywords); err != nil {
+		w.log(cmd, zerolog.WarnLevel, "failed to read task state", now, err, nil)
+	} else if done {
+		w.log(cmd, zerolog.InfoLevel, "keywords extracted already", now, nil, nil)
+		return nil
+	}
+
+	w.setStatus(ctx, cmd, models.TaskStatusProcessing, now, nil)
+	defer func() {
+		if err != nil {
+			w.setStatus(ctx, cmd, models.TaskStatusFailed, now, err)
+		} else {
+			w.setStatus(ctx, cmd, models.TaskStatusDone, now, nil)
+		}
+	}()
+
 	// 2. Get the article content, using a cache-then-database fallback strategy.
 	// This ensures that if the cache is unavailable or stale, the worker can still
 	// retrieve the necessary data from the primary database.
@@ -189,24 +320,22 @@ func (w *KeywordExtractorWorker) Handle(ctx context.Context, msg *nats.Msg) erro
 		defer rSpan.End()
 
 		// First, attempt to get the article content from the cache.
+		var ok bool
 		var cErr error
-		content, cErr = w.valkey.Get(rCtx, cmd.CacheKey).Result()
-		if cErr != nil {
+		content, ok, cErr = w.cache.Get(rCtx, cmd.CacheKey)
+		switch {
+		case cErr != nil:
 			// Fall back to the DB if error
-			eMsg := "failed to read article from cache"
-			if cErr == redis.Nil {
-				eMsg = "cache missing"
-			}
-			w.log(cmd, zerolog.WarnLevel, eMsg, now, cErr, nil)
-		}
-
-		if cErr == nil && content == "" {
-			w.log(cmd, zerolog.ErrorLevel, "empty content from cache", now, cErr, nil)
+			w.log(cmd, zerolog.WarnLevel, "failed to read article from cache", now, cErr, nil)
+		case !ok:
+			w.log(cmd, zerolog.WarnLevel, "cache missing", now, nil, nil)
+		case content == "":
+			w.log(cmd, zerolog.ErrorLevel, "empty content from cache", now, nil, nil)
 		}
 
 		// If content is still empty (due to cache miss, error, or empty value), fetch from the database.
 		if content == "" {
-			article, dbErr := w.storage.UserArticles().GetByID(rCtx, cmd.ArticleID)
+			article, dbErr := w.store.GetArticle(rCtx, cmd.ArticleID)
 			if dbErr != nil {
 				rSpan.RecordError(dbErr)
 				w.log(cmd, zerolog.ErrorLevel, "failed to read article from db", now, dbErr, nil)
@@ -222,71 +351,80 @@ func (w *KeywordExtractorWorker) Handle(ctx context.Context, msg *nats.Msg) erro
 		return fmt.Errorf("failed to read article: %w", err)
 	}
 
-	// 3. Generate keywords using the LLM client.
-	// This step includes a robust retry mechanism with exponential backoff
-	// to handle transient network issues or API rate limits when calling the LLM.
-	var keywords KeywordExtractorOutput
-	err = func(lCtx context.Context) error {
-		schema := jsonschema.Reflect(KeywordExtractorOutput{})
-		lCtx, lSpan := w.Tracer.Start(lCtx, KeywordExtractorSpanGenerateKeywords)
-		defer lSpan.End()
-
-		var resp *llm.GenerateResponse
-		retry := 0
-		// Retry loop with exponential backoff to handle transient LLM API failures.
-		for err = nil; retry < MaxRetryTimes; retry++ {
-			resp, err = w.llm.client.Generate(lCtx, &llm.GenerateRequest{
-				Messages: []llm.Message{
-					{
-						Role:    llm.RoleSystem,
-						Content: []string{w.prompt},
-					},
-					{
-						Role:    llm.RoleUser,
-						Content: []string{content},
-					},
-				},
-				ModelName: w.llm.model,
-				Schema: &llm.ResponseSchema{
-					Name:        "keywords",
-					Description: "keywords-extraction-results",
-					S:           schema,
-					Strict:      true,
-				},
-				Config: w.llm.config,
-			})
-
-			if err == nil {
-				break // Success
-			}
-			time.Sleep(min(MaxRetryInterval, MinRetryInterval<<retry))
-		}
-		if err != nil {
-			lSpan.RecordError(err)
-			return fmt.Errorf("failed to generate keywords (3 retries): %w", err)
-		}
-
-		if err = json.Unmarshal([]byte(resp.Outputs[0]), &keywords); err != nil {
-			lSpan.RecordError(err)
-			return fmt.Errorf("failed to unmarshal keywords: %w", err)
-		}
-		return nil
-	}(ctx)
+	// 3. Generate keywords using the LLM client, with the prompt and schema
+	// variants of the article language. The extractor retries with exponential
+	// backoff to handle transient network issues or API rate limits.
+	lCtx, lSpan := w.Tracer.Start(ctx, KeywordExtractorSpanGenerateKeywords)
+	result, err := w.extractor.Extract(lCtx, content, cmd.Lang)
+	if err != nil {
+		lSpan.RecordError(err)
+	}
+	lSpan.End()
 	if err != nil {
 		w.log(cmd, zerolog.ErrorLevel, "failed to generate keywords", now, err,
 			map[string]any{
-				"model":  w.llm.model,
-				"prompt": w.prompt,
-				"config": w.llm.config,
+				"model":  w.extractor.llm.model,
+				"prompt": result.Prompt,
+				"config": w.extractor.llm.config,
 			})
 		return err
 	}
+	w.log(cmd, zerolog.InfoLevel, "keywords generated", now, nil, map[string]any{
+		"model":             w.extractor.llm.model,
+		"prompt":            result.Prompt,
+		"attempts":          result.Attempts,
+		"prompt_tokens":     result.Usage.PromptTokens,
+		"completion_tokens": result.Usage.CompletionTokens,
+		"total_tokens":      result.Usage.TotalTokens,
+	})
+	if len(result.Repairs) > 0 {
+		w.log(cmd, zerolog.WarnLevel, "keyword output repaired", now, nil, map[string]any{
+			"repairs": result.Repairs,
+			"prompt":  result.Prompt,
+		})
+	}
+	keywords := result.Output
+
+	sCtx, sSpan := w.Tracer.Start(ctx, KeywordExtractorSpanStoreKeywords)
+	stored, err := w.store.InsertKeywords(sCtx, cmd.ArticleID, result.Lang, keywords.Categorized())
+	if err != nil {
+		sSpan.RecordError(err)
+		sSpan.End()
+		w.log(cmd, zerolog.ErrorLevel, "failed to insert keywords to db", now, err, map[string]any{
+			"lang": result.Lang,
+		})
+		return fmt.Errorf("failed to insert keywords to db: %w", err)
+	}
+	sSpan.End()
+
+	if len(stored) == 0 {
+		w.flagKeywordOutput(ctx, cmd, now, "no keywords extracted")
+	}
+
+	if w.linker != nil {
+		kCtx, kSpan := w.Tracer.Start(ctx, KeywordExtractorSpanLinkKeywords)
+		n, err := w.linker.Link(kCtx, w.store, result.Lang, stored)
+		if err != nil {
+			kSpan.RecordError(err)
+			w.log(cmd, zerolog.WarnLevel, "failed to link keywords", now, err, nil)
+		} else {
+			w.log(cmd, zerolog.DebugLevel, "keywords linked", now, nil, map[string]any{
+				"links": n,
+			})
+		}
+		kSpan.End()
+	}
 
 	// 4. Cache the results and publish a completion event.
-	cachekey := fmt.Sprintf("%s.article.keywords", cmd.TaskID.String())
+	// the terms are cached as stored, so that the cache can be reconciled
+	// with the database
+	cachekey := workers.KeywordsCacheKey(cmd.TaskID)
 	vCtx, vSpan := w.Tracer.Start(ctx, KeywordExtractorSpanInsertKeywords)
 	defer vSpan.End()
-	err = w.valkey.Set(vCtx, cachekey, keywords, time.Hour*3).Err()
+	terms, err := json.Marshal(keywords.Terms())
+	if err == nil {
+		err = w.cache.Set(vCtx, cachekey, string(terms), workers.ArtifactCacheTTL)
+	}
 	if err != nil {
 		vSpan.RecordError(err)
 		w.log(cmd, zerolog.ErrorLevel, "failed to insert keywords to cache", now, err, nil)
@@ -294,7 +432,7 @@ func (w *KeywordExtractorWorker) Handle(ctx context.Context, msg *nats.Msg) erro
 	}
 
 	// 5. Publish an event to notify other services that keywords have been extracted.
-	err = w.publisher.PublishNATSMessage(ctx, workers.KeywordsExtracted, workers.MsgKeywordsExtracted{
+	err = w.publisher.PublishNATSMessage(ctx, workers.SubjectEvt(workers.StageExtractKeywords, workers.OutcomeDone), workers.MsgKeywordsExtracted{
 		BaseMessageWithElapsed: workers.BaseMessageWithElapsed{
 			BaseMessage: workers.BaseMessage{
 				TaskID:   cmd.TaskID,
@@ -302,7 +440,7 @@ func (w *KeywordExtractorWorker) Handle(ctx context.Context, msg *nats.Msg) erro
 				Version:  workers.MessageVersion,
 				CacheKey: cachekey,
 			},
-			ElapsedMs: time.Since(now).Milliseconds(),
+			ElapsedMs: w.Clock.Since(now).Milliseconds(),
 		},
 		ArticleID:      cmd.ArticleID,
 		KeywordsCount:  len(keywords.Flatten()),
@@ -318,6 +456,8 @@ func (w *KeywordExtractorWorker) Handle(ctx context.Context, msg *nats.Msg) erro
 	w.log(cmd, zerolog.InfoLevel, "keywords extracted and published", now, nil, map[string]any{
 		"cache_key": cachekey,
 		"keywords":  keywords,
+		"lang":      result.Lang,
+		"prompt":    result.Prompt,
 	})
 	return nil
 }
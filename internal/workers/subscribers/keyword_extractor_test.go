@@ -0,0 +1,332 @@
+package subscribers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/llm/llmtest"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/ChiaYuChang/weathercock/internal/workers/subscribers"
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// fakeKeywordStore keeps the articles, their keywords, the review queue and
+// the events of the tasks in memory.
+type fakeKeywordStore struct {
+	fakeLinkStore
+	mu       sync.Mutex
+	articles map[int32]string
+	attached map[int32][]string
+	flagged  map[int32]bool
+	reviews  []storage.ReviewItem
+	stages   []string
+	statuses []models.TaskStatus
+	errMsgs  []string
+	state    storage.TaskState
+}
+
+func newFakeKeywordStore() *fakeKeywordStore {
+	return &fakeKeywordStore{
+		fakeLinkStore: fakeLinkStore{links: map[[2]int32]float32{}},
+		articles:      map[int32]string{},
+		attached:      map[int32][]string{},
+		flagged:       map[int32]bool{},
+	}
+}
+
+func (s *fakeKeywordStore) GetArticle(ctx context.Context, aID int32) (*models.UsersArticle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.articles[aID]
+	if !ok {
+		return nil, errors.New("article not found")
+	}
+	return &models.UsersArticle{ID: aID, Content: content}, nil
+}
+
+func (s *fakeKeywordStore) InsertKeywords(ctx context.Context, aID int32, lang string,
+	categorized []storage.ArticleKeyword) ([]models.Keyword, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keywords := make([]models.Keyword, len(categorized))
+	terms := make([]string, len(categorized))
+	for i, kw := range categorized {
+		keywords[i] = models.Keyword{ID: int32(i + 1), Term: kw.Term, Lang: lang}
+		terms[i] = kw.Term
+	}
+	s.attached[aID] = terms
+	return keywords, nil
+}
+
+func (s *fakeKeywordStore) SetNeedsReview(ctx context.Context, aID int32, needsReview bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flagged[aID] = needsReview
+	return nil
+}
+
+func (s *fakeKeywordStore) Enqueue(ctx context.Context, item storage.ReviewItem) (models.UsersReviewQueue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reviews = append(s.reviews, item)
+	return models.UsersReviewQueue{}, nil
+}
+
+func (s *fakeKeywordStore) Append(ctx context.Context, taskID uuid.UUID,
+	e storage.TaskEvent) (storage.TaskState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stages = append(s.stages, e.Stage)
+	s.statuses = append(s.statuses, e.Status)
+	s.errMsgs = append(s.errMsgs, e.Message)
+	s.state = s.state.Apply(e)
+	return s.state, nil
+}
+
+func (s *fakeKeywordStore) State(ctx context.Context, taskID uuid.UUID) (storage.TaskState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, nil
+}
+
+// fakeArtifactCache is an ArtifactCache in memory.
+type fakeArtifactCache struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (c *fakeArtifactCache) Get(ctx context.Context, key string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	return v, ok, nil
+}
+
+func (c *fakeArtifactCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = value
+	return nil
+}
+
+// fakeEventPublisher records the published events.
+type fakeEventPublisher struct {
+	mu       sync.Mutex
+	subjects []string
+	payloads []any
+}
+
+func (p *fakeEventPublisher) PublishNATSMessage(ctx context.Context, subject string, payload any,
+	attrs ...attribute.KeyValue) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subjects = append(p.subjects, subject)
+	p.payloads = append(p.payloads, payload)
+	return nil
+}
+
+func TestKeywordExtractorWorkerHandle(t *testing.T) {
+	fixture := keywordFixtures[0]
+	prompts := llm.NewPromptStore(map[string]string{
+		"keyword":    zhPrompt,
+		"keyword.en": enPrompt,
+	})
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	type env struct {
+		worker *subscribers.KeywordExtractorWorker
+		cli    *llmtest.MockLLM
+		store  *fakeKeywordStore
+		cache  *fakeArtifactCache
+		pub    *fakeEventPublisher
+	}
+	setup := func(t *testing.T) env {
+		e := env{
+			cli:   llmtest.NewMockLLM(),
+			store: newFakeKeywordStore(),
+			cache: &fakeArtifactCache{values: map[string]string{}},
+			pub:   &fakeEventPublisher{},
+		}
+		e.cli.Expect(
+			llmtest.Model("gpt-5-nano"),
+			llmtest.HasSchema("keywords"),
+		)
+
+		base := workers.BaseWorker{
+			Logger: zerolog.Nop(),
+			Tracer: noop.NewTracerProvider().Tracer("test"),
+			Clock:  clockid.NewFake(start),
+			IDs:    clockid.Random,
+		}
+		w, err := subscribers.NewKeywordExtractorWorkerWith(base, e.store, e.cache, e.pub,
+			subscribers.NewLLM(e.cli, "gpt-5-nano", "", nil), prompts)
+		require.NoError(t, err)
+		e.worker = w
+		return e
+	}
+	message := func(t *testing.T, cmd workers.CmdExtractKeywords) *nats.Msg {
+		data, err := json.Marshal(cmd)
+		require.NoError(t, err)
+		return &nats.Msg{Subject: subscribers.KeywordExtractorWorkerSubject, Data: data}
+	}
+	cmd := workers.CmdExtractKeywords{
+		BaseMessage: workers.BaseMessage{
+			Version:  workers.MessageVersion,
+			TaskID:   uuid.New(),
+			CacheKey: "task.article.content",
+		},
+		ArticleID: 7,
+	}
+
+	t.Run("cached article", func(t *testing.T) {
+		e := setup(t)
+		e.cache.values[cmd.CacheKey] = fixture.content
+		e.cli.QueueOutput(fixture.output, llmtest.Roles(llm.RoleSystem, llm.RoleUser),
+			llmtest.Func(func(req *llm.GenerateRequest) error {
+				if req.Messages[1].Content[0] != fixture.content {
+					return errors.New("the article should be the user message")
+				}
+				return nil
+			}))
+
+		require.NoError(t, e.worker.Handle(context.Background(), message(t, cmd)))
+		require.Zero(t, e.cli.Pending())
+		require.Equal(t, fixture.wantTerms, e.store.attached[cmd.ArticleID])
+		require.Empty(t, e.store.reviews)
+
+		var cached []string
+		require.NoError(t, json.Unmarshal([]byte(e.cache.values[workers.KeywordsCacheKey(cmd.TaskID)]), &cached))
+		require.Equal(t, fixture.wantTerms, cached)
+
+		require.Equal(t, []string{workers.SubjectEvt(workers.StageExtractKeywords, workers.OutcomeDone)}, e.pub.subjects)
+		evt, ok := e.pub.payloads[0].(workers.MsgKeywordsExtracted)
+		require.True(t, ok)
+		require.Equal(t, cmd.TaskID, evt.TaskID)
+		require.Equal(t, cmd.ArticleID, evt.ArticleID)
+		require.Equal(t, start.Unix(), evt.EventAt)
+		require.Equal(t, len(fixture.wantTerms), evt.KeywordsCount)
+		require.Equal(t, 1, evt.RelationsCount)
+		require.Equal(t, []models.TaskStatus{models.TaskStatusProcessing, models.TaskStatusDone}, e.store.statuses)
+		stage := workers.StageExtractKeywords.String()
+		require.Equal(t, []string{stage, stage}, e.store.stages)
+	})
+
+	t.Run("cache miss", func(t *testing.T) {
+		e := setup(t)
+		e.store.articles[cmd.ArticleID] = fixture.content
+		e.cli.QueueOutput(fixture.output)
+
+		require.NoError(t, e.worker.Handle(context.Background(), message(t, cmd)))
+		require.Equal(t, fixture.wantTerms, e.store.attached[cmd.ArticleID])
+		require.Len(t, e.pub.subjects, 1)
+	})
+
+	t.Run("corrected output", func(t *testing.T) {
+		e := setup(t)
+		e.cache.values[cmd.CacheKey] = fixture.content
+		e.cli.QueueOutput(`{"keywords":{"themes":["AI"],"events":[]}`).
+			QueueOutput(fixture.output, llmtest.Func(func(req *llm.GenerateRequest) error {
+				if len(req.Messages) != 4 || req.Messages[2].Role != llm.RoleAssistant {
+					return errors.New("the malformed output should be sent back")
+				}
+				return nil
+			}))
+
+		require.NoError(t, e.worker.Handle(context.Background(), message(t, cmd)))
+		require.Zero(t, e.cli.Pending())
+		require.Equal(t, fixture.wantTerms, e.store.attached[cmd.ArticleID])
+	})
+
+	t.Run("no keywords", func(t *testing.T) {
+		e := setup(t)
+		e.cache.values[cmd.CacheKey] = fixture.content
+		e.cli.QueueOutput(`{"keywords":{"themes":[],"events":[],"entities":[],"actions":[]},"relations":[]}`)
+
+		require.NoError(t, e.worker.Handle(context.Background(), message(t, cmd)))
+		require.True(t, e.store.flagged[cmd.ArticleID])
+		require.Len(t, e.store.reviews, 1)
+		require.Equal(t, models.ReviewItemTypeKeywordOutput, e.store.reviews[0].Type)
+		require.Len(t, e.pub.subjects, 1)
+	})
+
+	t.Run("no output", func(t *testing.T) {
+		e := setup(t)
+		e.cache.values[cmd.CacheKey] = fixture.content
+		e.cli.QueueGenerate(&llm.GenerateResponse{}, nil)
+
+		require.ErrorContains(t, e.worker.Handle(context.Background(), message(t, cmd)), "no output")
+		require.Empty(t, e.store.attached)
+		require.Empty(t, e.pub.subjects)
+		require.Equal(t, []models.TaskStatus{models.TaskStatusProcessing, models.TaskStatusFailed}, e.store.statuses)
+		require.Contains(t, e.store.errMsgs[1], "no output")
+	})
+
+	t.Run("article not found", func(t *testing.T) {
+		e := setup(t)
+		require.Error(t, e.worker.Handle(context.Background(), message(t, cmd)))
+		require.Empty(t, e.cli.Calls())
+	})
+
+	t.Run("redelivered", func(t *testing.T) {
+		e := setup(t)
+		e.cache.values[cmd.CacheKey] = fixture.content
+		e.cli.QueueOutput(fixture.output)
+
+		require.NoError(t, e.worker.Handle(context.Background(), message(t, cmd)))
+		require.NoError(t, e.worker.Handle(context.Background(), message(t, cmd)))
+		require.Len(t, e.cli.Calls(), 1)
+		require.Len(t, e.pub.subjects, 1)
+		require.Equal(t, []models.TaskStatus{models.TaskStatusProcessing, models.TaskStatusDone}, e.store.statuses)
+	})
+
+	t.Run("malformed message", func(t *testing.T) {
+		e := setup(t)
+		err := e.worker.Handle(context.Background(), &nats.Msg{Data: []byte("{")})
+		require.ErrorIs(t, err, workers.ErrMalformedMessage)
+		require.Empty(t, e.cli.Calls())
+		require.Empty(t, e.store.statuses)
+	})
+}
+
+// warmerLLM is a MockLLM loading its models on demand.
+type warmerLLM struct {
+	*llmtest.MockLLM
+	warmed []string
+}
+
+func (c *warmerLLM) Warmup(ctx context.Context, modelName string) error {
+	c.warmed = append(c.warmed, modelName)
+	return nil
+}
+
+func TestKeywordExtractorWorkerWarmup(t *testing.T) {
+	base := workers.BaseWorker{Logger: zerolog.Nop(), Tracer: noop.NewTracerProvider().Tracer("test")}
+	newWorker := func(cli llm.LLM) *subscribers.KeywordExtractorWorker {
+		w, err := subscribers.NewKeywordExtractorWorkerWith(base, newFakeKeywordStore(),
+			&fakeArtifactCache{}, &fakeEventPublisher{}, subscribers.NewLLM(cli, "gemma3:12b", "", nil), nil)
+		require.NoError(t, err)
+		return w
+	}
+
+	var _ workers.Warmer = (*subscribers.KeywordExtractorWorker)(nil)
+	cli := &warmerLLM{MockLLM: llmtest.NewMockLLM()}
+	require.NoError(t, newWorker(cli).Warmup(context.Background()))
+	require.Equal(t, []string{"gemma3:12b"}, cli.warmed)
+
+	mock := llmtest.NewMockLLM()
+	require.NoError(t, newWorker(mock).Warmup(context.Background()))
+	require.Empty(t, mock.Calls())
+}
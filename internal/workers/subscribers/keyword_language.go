@@ -0,0 +1,417 @@
+package subscribers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/pkgs/utils"
+	"github.com/invopop/jsonschema"
+	"golang.org/x/text/width"
+)
+
+// Language tags of the keyword prompt variants.
+const (
+	LangTraditionalChinese = "zh-Hant"
+	LangEnglish            = "en"
+)
+
+const (
+	// KeywordPromptName is the name of the keyword prompt in the prompt store,
+	// the prompt without a language tag is written for LangTraditionalChinese.
+	KeywordPromptName = "keyword"
+	// DefaultKeywordLang is the language of the keyword prompt without a language tag.
+	DefaultKeywordLang = LangTraditionalChinese
+	// MaxKeywordRunes is the length limit of a keyword term in the database.
+	MaxKeywordRunes = 32
+	// DefaultKeywordLinkThreshold is the cosine similarity of two keyword
+	// embeddings above which the keywords are linked.
+	DefaultKeywordLinkThreshold = 0.85
+	// DefaultKeywordLinkCandidates is the number of most recent keywords of the
+	// other language compared against.
+	DefaultKeywordLinkCandidates = 500
+)
+
+// DetectLanguage is a coarse language detector for the articles without a
+// stored language: Han characters making up at least a fifth of the letters
+// means LangTraditionalChinese, anything else LangEnglish.
+func DetectLanguage(text string) string {
+	letters, han := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Han, r) {
+			han++
+		}
+	}
+
+	if letters > 0 && han*5 >= letters {
+		return LangTraditionalChinese
+	}
+	return LangEnglish
+}
+
+// baseLanguage returns the primary language subtag of lang.
+func baseLanguage(lang string) string {
+	base, _, _ := strings.Cut(lang, "-")
+	return strings.ToLower(base)
+}
+
+// NormalizeKeyword normalizes a keyword term of lang. Spaces are collapsed in
+// every language. Chinese terms are width folded, so ＡＩ becomes AI, and keep
+// their case. English terms are not width folded, and are lower cased except
+// for the words written in upper case, i.e. acronyms such as TSMC.
+func NormalizeKeyword(term, lang string) string {
+	term = strings.Join(strings.Fields(term), " ")
+
+	switch baseLanguage(lang) {
+	case "zh":
+		term = width.Fold.String(term)
+	case "en":
+		words := strings.Split(term, " ")
+		for i, w := range words {
+			if w != strings.ToUpper(w) || utf8.RuneCountInString(w) < 2 {
+				words[i] = strings.ToLower(w)
+			}
+		}
+		term = strings.Join(words, " ")
+	}
+	return term
+}
+
+// normalizeKeywords normalizes terms, dropping the empty ones and the
+// duplicates.
+func normalizeKeywords(terms []string, lang string) []string {
+	out := make([]string, 0, len(terms))
+	for _, term := range terms {
+		term = NormalizeKeyword(term, lang)
+		if term != "" && !slices.Contains(out, term) {
+			out = append(out, term)
+		}
+	}
+	return out
+}
+
+// Normalize normalizes the keywords and the relations of lang.
+func (k KeywordExtractorOutput) Normalize(lang string) KeywordExtractorOutput {
+	k.Keywords.Themes = normalizeKeywords(k.Keywords.Themes, lang)
+	k.Keywords.Events = normalizeKeywords(k.Keywords.Events, lang)
+	k.Keywords.Entities = normalizeKeywords(k.Keywords.Entities, lang)
+	k.Keywords.Actions = normalizeKeywords(k.Keywords.Actions, lang)
+
+	relations := k.Relations[:0:0]
+	for _, r := range k.Relations {
+		r.Entity1 = NormalizeKeyword(r.Entity1, lang)
+		r.Entity2 = NormalizeKeyword(r.Entity2, lang)
+		r.Relation = NormalizeKeyword(r.Relation, lang)
+		if r.Entity1 != "" && r.Entity2 != "" && r.Relation != "" {
+			relations = append(relations, r)
+		}
+	}
+	k.Relations = relations
+	return k
+}
+
+// Terms returns the distinct keywords regardless of their category, the ones
+// longer than MaxKeywordRunes are left out.
+func (k KeywordExtractorOutput) Terms() []string {
+	terms := []string{}
+	for _, group := range [][]string{k.Keywords.Themes, k.Keywords.Events,
+		k.Keywords.Entities, k.Keywords.Actions} {
+		for _, term := range group {
+			if utf8.RuneCountInString(term) <= MaxKeywordRunes && !slices.Contains(terms, term) {
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+// Categorized returns the keywords of Terms with their category, a term
+// found in several categories keeps the first one of themes, events, entities
+// and actions.
+func (k KeywordExtractorOutput) Categorized() []storage.ArticleKeyword {
+	keywords := []storage.ArticleKeyword{}
+	seen := map[string]bool{}
+	for _, group := range []struct {
+		category models.KeywordCategory
+		terms    []string
+	}{
+		{models.KeywordCategoryTheme, k.Keywords.Themes},
+		{models.KeywordCategoryEvent, k.Keywords.Events},
+		{models.KeywordCategoryEntity, k.Keywords.Entities},
+		{models.KeywordCategoryAction, k.Keywords.Actions},
+	} {
+		for _, term := range group.terms {
+			if utf8.RuneCountInString(term) <= MaxKeywordRunes && !seen[term] {
+				seen[term] = true
+				keywords = append(keywords, storage.ArticleKeyword{Category: group.category, Term: term})
+			}
+		}
+	}
+	return keywords
+}
+
+// keywordSchemaDescriptions are the descriptions of the fields of the keyword
+// schema by base language, the structure of the schema is the same for every
+// language.
+var keywordSchemaDescriptions = map[string]map[string]string{
+	"zh": {
+		"themes":   "廣泛、高層次的背景或反覆出現的議題",
+		"events":   "具體、有新聞價值的事件，通常與時間或地點相關",
+		"entities": "專有名詞，如人物、組織、地點、法律或品牌",
+		"actions":  "持續進行的政策、策略或重大行動",
+		"entity1":  "關係中的第一個實體",
+		"relation": "連接兩個實體的精簡動詞或動作片語",
+		"entity2":  "關係中的第二個實體",
+	},
+	"en": {
+		"themes":   "Broad, high-level context or recurring topics",
+		"events":   "Specific, newsworthy occurrences, usually tied to time or place",
+		"entities": "Proper nouns such as people, organizations, places, laws, or brands",
+		"actions":  "Ongoing policies, strategies, or significant actions",
+		"entity1":  "The first entity of the relation",
+		"relation": "A concise verb or action phrase connecting the two entities",
+		"entity2":  "The second entity of the relation",
+	},
+}
+
+// KeywordSchema returns the JSON schema of KeywordExtractorOutput with the
+// field descriptions of lang, falling back to the ones of DefaultKeywordLang.
+func KeywordSchema(lang string) *jsonschema.Schema {
+	descs, ok := keywordSchemaDescriptions[baseLanguage(lang)]
+	if !ok {
+		descs = keywordSchemaDescriptions[baseLanguage(DefaultKeywordLang)]
+	}
+
+	r := &jsonschema.Reflector{ExpandedStruct: true, DoNotReference: true}
+	schema := r.Reflect(KeywordExtractorOutput{})
+
+	var describe func(s *jsonschema.Schema)
+	describe = func(s *jsonschema.Schema) {
+		if s == nil {
+			return
+		}
+
+		if s.Properties != nil {
+			for pair := s.Properties.Oldest(); pair != nil; pair = pair.Next() {
+				if desc, ok := descs[pair.Key]; ok {
+					pair.Value.Description = desc
+				}
+				describe(pair.Value)
+			}
+		}
+		describe(s.Items)
+	}
+	describe(schema)
+	return schema
+}
+
+// KeywordExtraction is the outcome of a KeywordExtractor.
+type KeywordExtraction struct {
+	Output KeywordExtractorOutput
+	// Lang is the language of the prompt used, and of the keywords.
+	Lang string
+	// Prompt is the name of the prompt used.
+	Prompt string
+	// Repairs are the fixes applied to the output of the model to unmarshal it.
+	Repairs []llm.RepairAction
+	// Attempts is the number of generations, more than 1 if the model was
+	// asked to correct its output.
+	Attempts int
+	// Usage is the number of tokens used by all the generations.
+	Usage llm.Usage
+}
+
+// KeywordExtractor extracts the keywords of an article with the prompt and
+// the schema variants of the article language.
+type KeywordExtractor struct {
+	llm     *LLMCli
+	prompts *llm.PromptStore
+}
+
+// NewKeywordExtractor creates a KeywordExtractor. If prompts is nil, the
+// prompt of cli is used for every language.
+func NewKeywordExtractor(cli *LLMCli, prompts *llm.PromptStore) (*KeywordExtractor, error) {
+	if cli == nil {
+		return nil, fmt.Errorf("llm client should not be nil")
+	}
+
+	if prompts == nil {
+		prompts = llm.NewPromptStore(map[string]string{KeywordPromptName: cli.prompt})
+	}
+
+	if _, _, ok := prompts.Lookup(KeywordPromptName, ""); !ok {
+		return nil, fmt.Errorf("prompt store should have a %q prompt without language tag", KeywordPromptName)
+	}
+
+	return &KeywordExtractor{
+		llm:     cli,
+		prompts: prompts,
+	}, nil
+}
+
+// Prompt returns the keyword prompt for lang, the name of the prompt, and the
+// language it is written for.
+func (e *KeywordExtractor) Prompt(lang string) (prompt, key, promptLang string) {
+	prompt, key, _ = e.prompts.Lookup(KeywordPromptName, lang)
+	promptLang = strings.TrimPrefix(key, KeywordPromptName+".")
+	if key == KeywordPromptName {
+		promptLang = DefaultKeywordLang
+	}
+	return prompt, key, promptLang
+}
+
+// Extract extracts the keywords of content written in lang, or in the detected
+// language if lang is empty. The keywords are normalized for the language of
+// the prompt used, which differs from lang if the prompt store has no variant
+// for it.
+func (e *KeywordExtractor) Extract(ctx context.Context, content, lang string) (KeywordExtraction, error) {
+	if lang == "" {
+		lang = DetectLanguage(content)
+	}
+
+	prompt, key, promptLang := e.Prompt(lang)
+	result := KeywordExtraction{Lang: promptLang, Prompt: key}
+
+	structured, err := llm.GenerateStructured(ctx, e.llm.client, &llm.GenerateRequest{
+		Messages: []llm.Message{
+			{
+				Role:    llm.RoleSystem,
+				Content: []string{prompt},
+			},
+			{
+				Role:    llm.RoleUser,
+				Content: []string{content},
+			},
+		},
+		ModelName: e.llm.model,
+		Schema: &llm.ResponseSchema{
+			Name:        "keywords",
+			Description: "keywords-extraction-results",
+			S:           KeywordSchema(promptLang),
+			Strict:      true,
+		},
+		Config: e.llm.config,
+	}, &result.Output, MaxKeywordRepairs)
+	if structured != nil {
+		result.Usage = structured.Usage
+		result.Attempts = structured.Attempts
+		result.Repairs = structured.Repairs
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to generate keywords: %w", err)
+	}
+	result.Output = result.Output.Normalize(promptLang)
+	return result, nil
+}
+
+// KeywordLinkStore persists the keywords links. It is implemented by
+// storage.Keywords.
+type KeywordLinkStore interface {
+	ListByLang(ctx context.Context, lang string, limit int32) ([]models.Keyword, error)
+	Link(ctx context.Context, keywordID, linkedKeywordID int32, similarity float32) error
+}
+
+// KeywordLinker links the keywords of different languages whose embeddings
+// are similar enough to be considered the same concept.
+type KeywordLinker struct {
+	client     llm.LLM
+	model      string
+	threshold  float64
+	candidates int32
+}
+
+// NewKeywordLinker creates a KeywordLinker embedding the keywords with model.
+func NewKeywordLinker(client llm.LLM, model string, threshold float64) (*KeywordLinker, error) {
+	if client == nil {
+		return nil, fmt.Errorf("llm client should not be nil")
+	}
+
+	if threshold <= 0 || threshold > 1 {
+		return nil, fmt.Errorf("threshold should be in (0, 1]: %v", threshold)
+	}
+
+	return &KeywordLinker{
+		client:     client,
+		model:      model,
+		threshold:  threshold,
+		candidates: DefaultKeywordLinkCandidates,
+	}, nil
+}
+
+// otherLanguage returns the language the keywords of lang are linked to.
+func otherLanguage(lang string) string {
+	if baseLanguage(lang) == "zh" {
+		return LangEnglish
+	}
+	return LangTraditionalChinese
+}
+
+// Link links keywords, all of the same language, to the most recent keywords
+// of the other language, and returns the number of links made.
+func (l *KeywordLinker) Link(ctx context.Context, store KeywordLinkStore, lang string,
+	keywords []models.Keyword) (int, error) {
+	if len(keywords) == 0 {
+		return 0, nil
+	}
+
+	others, err := store.ListByLang(ctx, otherLanguage(lang), l.candidates)
+	if err != nil || len(others) == 0 {
+		return 0, err
+	}
+
+	vecs, err := l.embed(ctx, append(slices.Clone(keywords), others...))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for i, k := range keywords {
+		for j, o := range others {
+			sim := utils.CosineSimilarity(vecs[i], vecs[len(keywords)+j])
+			if sim < l.threshold {
+				continue
+			}
+
+			if err := store.Link(ctx, k.ID, o.ID, float32(sim)); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (l *KeywordLinker) embed(ctx context.Context, keywords []models.Keyword) ([][]float32, error) {
+	inputs := make([]llm.EmbedInput, len(keywords))
+	for i, k := range keywords {
+		inputs[i] = llm.NewSimpleTextInput(k.Term)
+	}
+
+	resp, err := l.client.Embed(ctx, &llm.EmbedRequest{
+		Inputs:    inputs,
+		ModelName: l.model,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed keywords: %w", err)
+	}
+
+	if len(resp.Embeddings) != len(keywords) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(keywords), len(resp.Embeddings))
+	}
+
+	vecs := make([][]float32, len(resp.Embeddings))
+	for i, embed := range resp.Embeddings {
+		vecs[i] = embed.Values
+	}
+	return vecs, nil
+}
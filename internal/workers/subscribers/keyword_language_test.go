@@ -0,0 +1,297 @@
+package subscribers_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/ChiaYuChang/weathercock/internal/llm"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/internal/workers/subscribers"
+	"github.com/invopop/jsonschema"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLLM answers every Generate with output and records the requests.
+type fakeLLM struct {
+	*llm.BaseClient
+	mu     sync.Mutex
+	output string
+	usage  llm.Usage
+	reqs   []*llm.GenerateRequest
+	embeds map[string][]float32
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, req *llm.GenerateRequest) (*llm.GenerateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reqs = append(f.reqs, req)
+	return &llm.GenerateResponse{Outputs: []string{f.output}, Usage: f.usage}, nil
+}
+
+func (f *fakeLLM) BatchCreate(ctx context.Context, reqs *llm.BatchRequest) (*llm.BatchResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeLLM) BatchRetrieve(ctx context.Context, req *llm.BatchRetrieveRequest) (*llm.BatchResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeLLM) BatchCancel(ctx context.Context, req *llm.BatchCancelRequest) error {
+	return nil
+}
+
+func (f *fakeLLM) Embed(ctx context.Context, req *llm.EmbedRequest) (*llm.EmbedResponse, error) {
+	resp := &llm.EmbedResponse{Embeddings: make([]llm.Embedding, len(req.Inputs))}
+	for i, input := range req.Inputs {
+		resp.Embeddings[i].Values = f.embeds[input.String()]
+	}
+	return resp, nil
+}
+
+const (
+	zhPrompt = "請擷取關鍵字"
+	enPrompt = "Extract the keywords"
+)
+
+var keywordFixtures = []struct {
+	name       string
+	content    string
+	lang       string
+	output     string
+	wantLang   string
+	wantPrompt string
+	wantSystem string
+	wantDesc   string
+	wantTerms  []string
+}{
+	{
+		name:    "zh article",
+		content: "行政院今日宣布啟動ＡＩ產業領航旗艦計畫，台積電與聯發科均表示將積極參與。",
+		output: `{"keywords":{"themes":["ＡＩ產業發展","ＡＩ產業發展"],"events":["行政院啟動  AI旗艦計畫"],` +
+			`"entities":["行政院","台積電"],"actions":["培育ＡＩ人才"]},` +
+			`"relations":[{"entity1":"行政院","relation":"啟動","entity2":"ＡＩ旗艦計畫"}]}`,
+		wantLang:   subscribers.LangTraditionalChinese,
+		wantPrompt: "keyword",
+		wantSystem: zhPrompt,
+		wantDesc:   "廣泛、高層次的背景或反覆出現的議題",
+		wantTerms:  []string{"AI產業發展", "行政院啟動 AI旗艦計畫", "行政院", "台積電", "培育AI人才"},
+	},
+	{
+		name: "en article",
+		content: "The Executive Yuan today launched the AI Industry Flagship Program. " +
+			"TSMC and MediaTek said they would take part.",
+		output: `{"keywords":{"themes":["AI Industry Development"],"events":["Executive Yuan Launches Program"],` +
+			`"entities":["TSMC","MediaTek","ＡＩ"],"actions":["Cultivate AI Talent"]},` +
+			`"relations":[{"entity1":"TSMC","relation":"Take Part In","entity2":"AI Program"}]}`,
+		wantLang:   subscribers.LangEnglish,
+		wantPrompt: "keyword.en",
+		wantSystem: enPrompt,
+		wantDesc:   "Broad, high-level context or recurring topics",
+		wantTerms: []string{"AI industry development", "executive yuan launches program",
+			"TSMC", "mediatek", "ＡＩ", "cultivate AI talent"},
+	},
+	{
+		name:       "explicit language tag falls back to the base variant",
+		content:    "Short English text",
+		lang:       "zh-Hant-TW",
+		output:     `{"keywords":{"themes":["ＡＩ"],"events":[],"entities":[],"actions":[]},"relations":[]}`,
+		wantLang:   subscribers.LangTraditionalChinese,
+		wantPrompt: "keyword",
+		wantSystem: zhPrompt,
+		wantDesc:   "廣泛、高層次的背景或反覆出現的議題",
+		wantTerms:  []string{"AI"},
+	},
+}
+
+func TestKeywordExtractorLanguages(t *testing.T) {
+	prompts := llm.NewPromptStore(map[string]string{
+		"keyword":    zhPrompt,
+		"keyword.en": enPrompt,
+	})
+
+	for _, tc := range keywordFixtures {
+		t.Run(tc.name, func(t *testing.T) {
+			usage := llm.Usage{PromptTokens: 120, CompletionTokens: 30, TotalTokens: 150}
+			cli := &fakeLLM{BaseClient: llm.NewClient(), output: tc.output, usage: usage}
+			extractor, err := subscribers.NewKeywordExtractor(
+				subscribers.NewLLM(cli, "model", "", nil), prompts)
+			require.NoError(t, err)
+
+			result, err := extractor.Extract(context.Background(), tc.content, tc.lang)
+			require.NoError(t, err)
+			require.Equal(t, usage, result.Usage)
+			require.Equal(t, tc.wantLang, result.Lang)
+			require.Equal(t, tc.wantPrompt, result.Prompt)
+			require.Equal(t, tc.wantTerms, result.Output.Terms())
+
+			require.Len(t, cli.reqs, 1)
+			req := cli.reqs[0]
+			require.Equal(t, llm.RoleSystem, req.Messages[0].Role)
+			require.Equal(t, []string{tc.wantSystem}, req.Messages[0].Content)
+			require.Equal(t, []string{tc.content}, req.Messages[1].Content)
+
+			schema, ok := req.Schema.S.(*jsonschema.Schema)
+			require.True(t, ok)
+			kw, ok := schema.Properties.Get("keywords")
+			require.True(t, ok)
+			themes, ok := kw.Properties.Get("themes")
+			require.True(t, ok)
+			require.Equal(t, tc.wantDesc, themes.Description)
+		})
+	}
+}
+
+func TestKeywordExtractorOutputCategorized(t *testing.T) {
+	var output subscribers.KeywordExtractorOutput
+	require.NoError(t, json.Unmarshal([]byte(`{"keywords":{
+		"themes":["energy policy"],
+		"events":["election"],
+		"entities":["TSMC","energy policy"],
+		"actions":["a keyword far too long to be stored as a term"]}}`), &output))
+
+	// a term keeps its first category, the ones too long are left out
+	require.Equal(t, []storage.ArticleKeyword{
+		{Category: models.KeywordCategoryTheme, Term: "energy policy"},
+		{Category: models.KeywordCategoryEvent, Term: "election"},
+		{Category: models.KeywordCategoryEntity, Term: "TSMC"},
+	}, output.Categorized())
+	require.Equal(t, []string{"energy policy", "election", "TSMC"}, output.Terms())
+}
+
+func TestKeywordExtractorRepair(t *testing.T) {
+	prompts := llm.NewPromptStore(map[string]string{
+		"keyword":    zhPrompt,
+		"keyword.en": enPrompt,
+	})
+	extract := func(output string) (subscribers.KeywordExtraction, error) {
+		cli := &fakeLLM{BaseClient: llm.NewClient(), output: output}
+		extractor, err := subscribers.NewKeywordExtractor(
+			subscribers.NewLLM(cli, "model", "", nil), prompts)
+		require.NoError(t, err)
+		return extractor.Extract(context.Background(), "Short English text", subscribers.LangEnglish)
+	}
+
+	result, err := extract("```json\n{'keywords':{'themes':['Energy Policy'],'events':[],'entities':['TSMC',]," +
+		"'actions':[]},'relations':[")
+	require.NoError(t, err)
+	require.Equal(t, []string{"energy policy", "TSMC"}, result.Output.Terms())
+	require.Equal(t, []llm.RepairAction{llm.RepairStripFences, llm.RepairSingleQuotes,
+		llm.RepairTrailingCommas, llm.RepairCloseBrackets}, result.Repairs)
+
+	// a valid output is not repaired
+	result, err = extract(`{"keywords":{"themes":["Energy Policy"],"events":[],"entities":[],"actions":[]},"relations":[]}`)
+	require.NoError(t, err)
+	require.Empty(t, result.Repairs)
+
+	_, err = extract(`{"keywords":{"themes":["Energy Policy"],"eve`)
+	require.Error(t, err)
+}
+
+func TestKeywordSchemaStructure(t *testing.T) {
+	strip := func(s *jsonschema.Schema) string {
+		data, err := json.Marshal(s)
+		require.NoError(t, err)
+		var v any
+		require.NoError(t, json.Unmarshal(data, &v))
+
+		var walk func(v any)
+		walk = func(v any) {
+			switch v := v.(type) {
+			case map[string]any:
+				delete(v, "description")
+				for _, c := range v {
+					walk(c)
+				}
+			case []any:
+				for _, c := range v {
+					walk(c)
+				}
+			}
+		}
+		walk(v)
+		data, err = json.Marshal(v)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	require.Equal(t,
+		strip(subscribers.KeywordSchema(subscribers.LangTraditionalChinese)),
+		strip(subscribers.KeywordSchema(subscribers.LangEnglish)))
+}
+
+func TestNormalizeKeyword(t *testing.T) {
+	tcs := []struct {
+		term string
+		lang string
+		want string
+	}{
+		{term: "  能源　政策 ", lang: "zh-Hant", want: "能源 政策"},
+		{term: "ＡＩ晶片", lang: "zh-Hant", want: "AI晶片"},
+		{term: "AI晶片", lang: "zh", want: "AI晶片"},
+		{term: "Energy Policy", lang: "en", want: "energy policy"},
+		{term: "TSMC Earnings", lang: "en", want: "TSMC earnings"},
+		{term: "ＡＩ Chips", lang: "en", want: "ＡＩ chips"},
+		{term: "A Plan", lang: "en", want: "a plan"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.term, func(t *testing.T) {
+			require.Equal(t, tc.want, subscribers.NormalizeKeyword(tc.term, tc.lang))
+		})
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	require.Equal(t, subscribers.LangTraditionalChinese, subscribers.DetectLanguage("台積電公布AI晶片營收"))
+	require.Equal(t, subscribers.LangEnglish, subscribers.DetectLanguage("TSMC reports AI chip revenue"))
+	require.Equal(t, subscribers.LangEnglish, subscribers.DetectLanguage("2025"))
+}
+
+type fakeLinkStore struct {
+	keywords []models.Keyword
+	links    map[[2]int32]float32
+}
+
+func (s *fakeLinkStore) ListByLang(ctx context.Context, lang string, limit int32) ([]models.Keyword, error) {
+	out := []models.Keyword{}
+	for _, k := range s.keywords {
+		if k.Lang == lang {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func (s *fakeLinkStore) Link(ctx context.Context, keywordID, linkedKeywordID int32, similarity float32) error {
+	s.links[[2]int32{keywordID, linkedKeywordID}] = similarity
+	return nil
+}
+
+func TestKeywordLinker(t *testing.T) {
+	cli := &fakeLLM{BaseClient: llm.NewClient(), embeds: map[string][]float32{
+		"能源政策":          {1, 0, 0},
+		"台積電":           {0, 1, 0},
+		"energy policy": {0.99, 0.1, 0},
+		"election":      {0, 0, 1},
+	}}
+	store := &fakeLinkStore{
+		keywords: []models.Keyword{
+			{ID: 10, Term: "energy policy", Lang: "en"},
+			{ID: 11, Term: "election", Lang: "en"},
+		},
+		links: map[[2]int32]float32{},
+	}
+
+	linker, err := subscribers.NewKeywordLinker(cli, "embed", subscribers.DefaultKeywordLinkThreshold)
+	require.NoError(t, err)
+	n, err := linker.Link(context.Background(), store, subscribers.LangTraditionalChinese, []models.Keyword{
+		{ID: 1, Term: "能源政策", Lang: "zh-Hant"},
+		{ID: 2, Term: "台積電", Lang: "zh-Hant"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Contains(t, store.links, [2]int32{1, 10})
+}
@@ -14,10 +14,11 @@ import (
 const (
 	LoggerWorkerStreamName  = "TASK"
 	LoggerWorkerDurableName = "logger-worker"
-	LoggerWorkerSubject     = workers.TaskLog
 	LoggerWorkerSource      = "logger-worker"
 )
 
+var LoggerWorkerSubject = workers.SubjectCmd(workers.StageLog)
+
 type LoggerWorker struct {
 	workers.BaseWorker
 	LogFile *os.File
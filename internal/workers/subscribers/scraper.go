@@ -4,15 +4,19 @@ package subscribers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/ChiaYuChang/weathercock/internal/models"
 	"github.com/ChiaYuChang/weathercock/internal/scrapers"
 	"github.com/ChiaYuChang/weathercock/internal/storage"
 	"github.com/ChiaYuChang/weathercock/internal/workers"
 	"github.com/ChiaYuChang/weathercock/internal/workers/publishers"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
@@ -25,8 +29,16 @@ import (
 const (
 	ScraperWorkerStreamName  = "TASK"
 	ScraperWorkerDurableName = "scraper-worker"
-	ScraperWorkerSubject     = workers.TaskScrape
 	ScraperWorkerSource      = "scraper-worker"
+
+	// The priority scraper worker consumes the fresh news on a durable
+	// consumer of its own, see WithPriority.
+	ScraperWorkerPriorityDurableName = "scraper-worker-priority"
+)
+
+var (
+	ScraperWorkerSubject         = workers.SubjectCmd(workers.StageScrape)
+	ScraperWorkerPrioritySubject = workers.SubjectCmd(workers.StageScrapePriority)
 )
 
 const (
@@ -36,14 +48,41 @@ const (
 	ScraperWorkerSpanInsertCache = "scrape.article.insert-cache"
 )
 
+// MinConfidentExtractionRunes is the content length below which an extraction
+// is flagged for review, a shorter body usually means the parser missed most
+// of the article.
+const MinConfidentExtractionRunes = 200
+
+// ExtractionDoubts returns the reasons to doubt the extraction of article, none
+// if it looks complete.
+func ExtractionDoubts(article scrapers.ParsedArticle) []string {
+	var doubts []string
+	if strings.TrimSpace(article.Title) == "" {
+		doubts = append(doubts, "missing title")
+	}
+
+	if article.Published.IsZero() {
+		doubts = append(doubts, "missing publish time")
+	}
+
+	if n := utf8.RuneCountInString(strings.Join(article.Paragraphs, "")); n < MinConfidentExtractionRunes {
+		doubts = append(doubts, fmt.Sprintf("content of %d runes, expected at least %d",
+			n, MinConfidentExtractionRunes))
+	}
+	return doubts
+}
+
 // ScraperWorker implements the Handler interface for scraping articles from the web.
 type ScraperWorker struct {
 	workers.BaseWorker
 	storage   *storage.Storage
+	events    workers.TaskEventStore
 	valkey    *redis.Client
 	publisher *publishers.Publisher
 	httpCli   *http.Client
 	headers   map[string]string
+	parsers   *scrapers.Registry
+	priority  workers.Priority
 }
 
 // NewScraperWorker creates a new instance of ScraperWorker.
@@ -62,6 +101,7 @@ func NewScraperWorker(nc *nats.Conn, logger zerolog.Logger, tracer trace.Tracer,
 	return &ScraperWorker{
 		BaseWorker: *baseWorker,
 		storage:    db,
+		events:     db.TaskEvents(),
 		valkey:     valkey,
 		publisher:  pub,
 		httpCli:    &http.Client{Timeout: 30 * time.Second}, // Default HTTP client with timeout.
@@ -72,11 +112,35 @@ func NewScraperWorker(nc *nats.Conn, logger zerolog.Logger, tracer trace.Tracer,
 			"Accept-Language": "zh-TW,zh;q=0.9,en-US;q=0.8,en;q=0.7",
 			"Connection":      "keep-alive",
 		},
+		parsers: scrapers.DefaultRegistry,
 	}, nil
 }
 
+// WithTransport makes the worker fetch the articles through rt, e.g. the
+// Transport of a fixtures.Server when the fixtures of the scraper worker
+// config are enabled.
+func (w *ScraperWorker) WithTransport(rt http.RoundTripper) *ScraperWorker {
+	w.httpCli.Transport = rt
+	return w
+}
+
+// WithRegistry makes the worker parse the articles with the parsers of r
+// instead of scrapers.DefaultRegistry.
+func (w *ScraperWorker) WithRegistry(r *scrapers.Registry) *ScraperWorker {
+	w.parsers = r
+	return w
+}
+
+// WithPriority makes the worker consume the scrapes of priority p. A process
+// runs one worker per priority so that the fresh news never wait behind the
+// backlog of normal scrapes.
+func (w *ScraperWorker) WithPriority(p workers.Priority) *ScraperWorker {
+	w.priority = p
+	return w
+}
+
 func (w *ScraperWorker) Subject() string {
-	return ScraperWorkerSubject
+	return workers.ScrapeSubject(w.priority)
 }
 
 func (w *ScraperWorker) StreamName() string {
@@ -84,6 +148,9 @@ func (w *ScraperWorker) StreamName() string {
 }
 
 func (w *ScraperWorker) DurableName() string {
+	if w.priority == workers.PriorityHigh {
+		return ScraperWorkerPriorityDurableName
+	}
 	return ScraperWorkerDurableName
 }
 
@@ -103,15 +170,37 @@ func (w ScraperWorker) log(cmd workers.CmdScrapeArticle,
 	lvl zerolog.Level, msg string, start time.Time, err error, attrs map[string]any) {
 	event := w.BaseWorker.Log(cmd.BaseMessage, lvl, start, attrs)
 	event.Err(err).
-		Str("url", cmd.URL)
+		Str("url", cmd.URL).
+		Str("priority", string(cmd.Priority))
 	event.Msg(msg)
 }
 
+// setStatus records the scrape of the task of cmd moving to status in its
+// timeline, with err as the error it failed with. It is best effort, a
+// failure is logged but does not fail the message.
+func (w *ScraperWorker) setStatus(ctx context.Context, cmd workers.CmdScrapeArticle,
+	status models.TaskStatus, start time.Time, err error) {
+	var events workers.TaskEventAppender = w.events
+	if cmd.Replace != 0 && status == models.TaskStatusProcessing {
+		events = reopening{w.storage.TaskEvents()}
+	}
+	if _, uErr := workers.AppendTaskEvent(ctx, events, cmd.TaskID, workers.StageScrape, status, err); uErr != nil {
+		w.log(cmd, zerolog.WarnLevel, "failed to update task status", start, uErr,
+			map[string]any{"status": status})
+	}
+}
+
 // Handle processes a single NATS message to scrape an article.
 // It orchestrates fetching, parsing, and storing the article,
-// and publishes a message upon completion.
-func (w *ScraperWorker) Handle(ctx context.Context, msg *nats.Msg) error {
-	now := time.Now()
+// and publishes a message upon completion. The scrape is recorded processing
+// in the timeline of the task once the message is parsed, and failed if it
+// fails. Scraping is not the last stage of the task: a scraped article is
+// recorded with the scrape done and the keyword extraction pending, so that
+// the task stays processing. A command of a task whose scrape is done already
+// is acknowledged without scraping again, unless it replaces the article of
+// the task: the article is then updated in place and the task reopened.
+func (w *ScraperWorker) Handle(ctx context.Context, msg *nats.Msg) (err error) {
+	now := w.Clock.Now()
 	w.Logger.Info().Msg("ScraperWorker received message")
 
 	// 1. Parse and validate the incoming message.
@@ -124,9 +213,34 @@ func (w *ScraperWorker) Handle(ctx context.Context, msg *nats.Msg) error {
 		return fmt.Errorf("%w: %s", workers.ErrMalformedMessage, err)
 	}
 
-	// 2. Fetch Article via HTTP Request.
+	// a command redelivered once its scrape is done is acknowledged as it is
+	if cmd.Replace == 0 {
+		if done, err := workers.StageDone(ctx, w.events, cmd.TaskID, workers.StageScrape); err != nil {
+			w.log(cmd, zerolog.WarnLevel, "failed to read task state", now, err, nil)
+		} else if done {
+			w.log(cmd, zerolog.InfoLevel, "article scraped already", now, nil, nil)
+			return nil
+		}
+	}
+
+	w.setStatus(ctx, cmd, models.TaskStatusProcessing, now, nil)
+	defer func() {
+		if err != nil {
+			w.setStatus(ctx, cmd, models.TaskStatusFailed, now, err)
+		}
+	}()
+
+	// 2. Look up the parser of the site. An unsupported site is permanent, the
+	// message is discarded rather than retried.
+	parser, err := w.parsers.Lookup(cmd.URL)
+	if err != nil {
+		w.log(cmd, zerolog.ErrorLevel, "unsupported site", now, err, nil)
+		return fmt.Errorf("%w: %w", workers.ErrMalformedMessage, err)
+	}
+
+	// 3. Fetch Article via HTTP Request.
 	var resp *http.Response
-	err := func(ctx context.Context) error {
+	err = func(ctx context.Context) error {
 		sCtx, sSpan := w.Tracer.Start(ctx, ScraperWorkerSpanFetch)
 		defer sSpan.End()
 
@@ -166,8 +280,8 @@ func (w *ScraperWorker) Handle(ctx context.Context, msg *nats.Msg) error {
 		return err // Propagate the error up to be NAK'd by the runner.
 	}
 
-	// 3. Parse the HTTP response body.
-	var newsArticle *scrapers.YahooNewsArticle
+	// 4. Parse the HTTP response body.
+	var newsArticle *scrapers.ParsedArticle
 	err = func(ctx context.Context) error {
 		pCtx, pSpan := w.Tracer.Start(ctx, ScraperWorkerSpanParse)
 		defer pSpan.End()
@@ -177,12 +291,12 @@ func (w *ScraperWorker) Handle(ctx context.Context, msg *nats.Msg) error {
 			pSpan.RecordError(pCtx.Err())
 			return pCtx.Err()
 		default:
-			parseResult := scrapers.ParseYahooNewsResp(resp)
-			if parseResult.Error != nil {
-				pSpan.RecordError(parseResult.Error)
-				return parseResult.Error
+			article, err := parser.Parse(resp)
+			if err != nil {
+				pSpan.RecordError(err)
+				return err
 			}
-			newsArticle = &parseResult.Article
+			newsArticle = article
 			return nil
 		}
 	}(ctx)
@@ -191,45 +305,110 @@ func (w *ScraperWorker) Handle(ctx context.Context, msg *nats.Msg) error {
 		return fmt.Errorf("failed to parse article response: %w", err)
 	}
 
-	// 4. Insert the parsed article into the database.
+	// 5. Insert the parsed article into the database.
 	var aID int32
 	var content string
-	cachekey := fmt.Sprintf("%s.article.content", cmd.TaskID.String())
+	var duplicate bool
+	cachekey := workers.ContentCacheKey(cmd.TaskID)
 	err = func(ctx context.Context) error {
 		iCtx, iSpan := w.Tracer.Start(ctx, ScraperWorkerSpanInsertDB)
 		defer iSpan.End()
 
 		// Pre-calculate cumulative lengths of content parts for storage.
-		cuts := make([]int32, len(newsArticle.Content))
+		cuts := make([]int32, len(newsArticle.Paragraphs))
 		cLen := int32(0)
-		for i, c := range newsArticle.Content {
+		for i, c := range newsArticle.Paragraphs {
 			cLen += int32(len(c))
 			cuts[i] = cLen
 		}
-		content = strings.Join(newsArticle.Content, "")
-
-		// Insert into DB. The publisher is passed in to ensure the completion event
-		// is sent within the same database transaction for consistency. This guarantees
-		// that the NATS message is only published if the article is successfully
-		// committed to the database.
-		aID, err = w.storage.UserArticles().Insert(iCtx, cmd.TaskID, newsArticle.Title,
-			newsArticle.Publisher, content, cuts, newsArticle.Published,
-			func(ctx context.Context, tID uuid.UUID, aID int32) error {
-				return w.publisher.PublishNATSMessage(ctx, workers.ArticleScraped,
-					workers.MsgArticleScraped{
-						BaseMessageWithElapsed: workers.BaseMessageWithElapsed{
-							BaseMessage: workers.BaseMessage{
-								TaskID:   cmd.TaskID,
-								EventAt:  now.Unix(),
-								Version:  workers.MessageVersion,
-								CacheKey: cachekey,
-							},
-							ElapsedMs: time.Since(now).Milliseconds(),
-						},
+		content = strings.Join(newsArticle.Paragraphs, "")
+		// the modification time is kept to re-check the article for updates
+		modified, _ := newsArticle.DeclaredModified()
+
+		// The article and its review item are inserted in a transaction, and the
+		// completion event is sent within it for consistency. This guarantees
+		// that the NATS message is only published if the article is about to be
+		// committed to the database, and that a doubtful article is never left
+		// without its review item by a crash.
+		//
+		// An article scraped before, e.g. by a redelivery of the command, is not
+		// inserted again: the scrape is done with the stored article. A
+		// replacing scrape updates the article it replaces instead, and its
+		// events reopen the task.
+		err := w.storage.WithTx(iCtx, func(tx storage.Storage) error {
+			appendEvent := tx.TaskEvents().Append
+			inserted := true
+			if cmd.Replace != 0 {
+				appendEvent = tx.TaskEvents().Reopen
+				aID = cmd.Replace
+				err := tx.UserArticles().Replace(iCtx, cmd.TaskID, cmd.Replace, newsArticle.Title,
+					newsArticle.Publisher, content, cuts, newsArticle.Published, modified)
+				if err != nil {
+					return err
+				}
+			} else {
+				id, ok, err := tx.UserArticles().UpsertByMD5(iCtx, cmd.TaskID, newsArticle.Title,
+					newsArticle.Publisher, content, cuts, newsArticle.Published, modified)
+				if err != nil {
+					return err
+				}
+				aID, inserted = id, ok
+			}
+			if !inserted {
+				w.log(cmd, zerolog.InfoLevel, "article stored already", now, nil,
+					map[string]any{"article_id": aID})
+			}
+
+			// Queue a doubtful extraction for an editor, the pipeline goes on with
+			// it in the meantime. Flagging is best effort, it runs in a savepoint
+			// so that a failure does not abort the transaction.
+			if doubts := ExtractionDoubts(*newsArticle); inserted && len(doubts) > 0 {
+				err := tx.WithTx(iCtx, func(tx storage.Storage) error {
+					_, err := tx.ReviewQueue().Enqueue(iCtx, storage.ReviewItem{
+						Type:      models.ReviewItemTypeExtraction,
 						ArticleID: aID,
+						Reason:    strings.Join(doubts, "; "),
 					})
-			},
-		)
+					return err
+				})
+				if err != nil {
+					w.log(cmd, zerolog.WarnLevel, "failed to queue extraction for review", now, err,
+						map[string]any{"article_id": aID})
+				}
+			}
+
+			// the timeline records the scrape with its article, and hands the
+			// task over to the keyword extraction
+			for _, e := range []storage.TaskEvent{
+				{Stage: workers.StageScrape.String(), Status: models.TaskStatusDone},
+				{Stage: workers.StageExtractKeywords.String(), Status: models.TaskStatusPending},
+			} {
+				if _, err := appendEvent(iCtx, cmd.TaskID, e); err != nil {
+					return err
+				}
+			}
+
+			return w.publisher.PublishNATSMessage(iCtx, workers.SubjectEvt(workers.StageScrape, workers.OutcomeDone),
+				workers.MsgArticleScraped{
+					BaseMessageWithElapsed: workers.BaseMessageWithElapsed{
+						BaseMessage: workers.BaseMessage{
+							TaskID:   cmd.TaskID,
+							EventAt:  now.Unix(),
+							Version:  workers.MessageVersion,
+							CacheKey: cachekey,
+						},
+						ElapsedMs: w.Clock.Since(now).Milliseconds(),
+					},
+					ArticleID: aID,
+				})
+		})
+		// UpsertByMD5 resolves the conflicts on the md5 of the task, any other
+		// duplicate is stored by a concurrent delivery of the command, which
+		// publishes the event. Retrying would fail again.
+		if errors.Is(err, ec.ErrDBUniqueViolation) {
+			duplicate = true
+			return nil
+		}
 		if err != nil {
 			iSpan.RecordError(err)
 			return fmt.Errorf("failed to insert article into database: %w", err)
@@ -240,12 +419,16 @@ func (w *ScraperWorker) Handle(ctx context.Context, msg *nats.Msg) error {
 		w.log(cmd, zerolog.ErrorLevel, "failed to insert article into database", now, err, nil)
 		return fmt.Errorf("failed to insert article into database: %w", err)
 	}
+	if duplicate {
+		w.log(cmd, zerolog.InfoLevel, "article stored by another delivery", now, nil, nil)
+		return nil
+	}
 
-	// 5. Insert the article content into the cache for quick access by the next worker.
+	// 6. Insert the article content into the cache for quick access by the next worker.
 	cCtx, cSpan := w.Tracer.Start(ctx, ScraperWorkerSpanInsertCache)
 	defer cSpan.End()
 
-	err = w.valkey.Set(cCtx, cachekey, content, time.Hour*3).Err()
+	err = w.valkey.Set(cCtx, cachekey, content, workers.ArtifactCacheTTL).Err()
 	if err != nil {
 		cSpan.RecordError(err)
 		// A cache failure is not ideal, but the task has succeeded since the data is in the DB.
@@ -261,3 +444,13 @@ func (w *ScraperWorker) Handle(ctx context.Context, msg *nats.Msg) error {
 		now, nil, map[string]any{"article_id": aID})
 	return nil
 }
+
+// reopening appends the events of a replacing scrape with Reopen, so that a
+// done task moves back to processing.
+type reopening struct {
+	storage.TaskEvents
+}
+
+func (r reopening) Append(ctx context.Context, taskID uuid.UUID, e storage.TaskEvent) (storage.TaskState, error) {
+	return r.Reopen(ctx, taskID, e)
+}
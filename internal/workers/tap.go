@@ -0,0 +1,124 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DefaultEventTapBuffer is the number of events an EventTap holds for a slow
+// reader.
+const DefaultEventTapBuffer = 256
+
+var eventTapDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "event_tap_dropped_total",
+	Help: "Number of task events dropped by the event taps as their reader fell behind.",
+})
+
+// TaskEvent is an event of the task pipeline, as received by an EventTap.
+type TaskEvent struct {
+	BaseMessage
+	Subject string
+	Stage   Stage
+	Outcome Outcome
+	// Data is the payload of the event, to be decoded into the message of its
+	// subject.
+	Data []byte
+}
+
+// EventTap follows all the task events, see SubjectAllTaskEvents, on a
+// consumer of its own which is not durable: it only gets the events published
+// after it is created, and does not hold the other consumers back. It is meant
+// for the observers of the pipeline, which follow the progress of the tasks
+// rather than run their stages.
+type EventTap struct {
+	sub *nats.Subscription
+
+	mu     sync.Mutex
+	events chan TaskEvent
+	closed bool
+}
+
+// NewEventTap creates an EventTap on js holding up to buffer events,
+// DefaultEventTapBuffer if buffer is not positive. The events are dropped
+// while the buffer is full.
+func NewEventTap(js nats.JetStreamContext, buffer int) (*EventTap, error) {
+	if js == nil {
+		return nil, fmt.Errorf("jetstream context should not be nil")
+	}
+
+	if buffer <= 0 {
+		buffer = DefaultEventTapBuffer
+	}
+
+	t := &EventTap{events: make(chan TaskEvent, buffer)}
+	sub, err := js.Subscribe(SubjectAllTaskEvents, t.receive, nats.DeliverNew(), nats.AckNone())
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to task events: %w", err)
+	}
+	t.sub = sub
+	return t, nil
+}
+
+// Events returns the events received, closed once the tap is.
+func (t *EventTap) Events() <-chan TaskEvent {
+	return t.events
+}
+
+func (t *EventTap) receive(msg *nats.Msg) {
+	stage, outcome, ok := ParseEvt(msg.Subject)
+	if !ok {
+		global.Logger.Warn().
+			Str("subject", msg.Subject).
+			Msg("Tapped event of an unknown subject")
+		return
+	}
+
+	e := TaskEvent{
+		Subject: msg.Subject,
+		Stage:   stage,
+		Outcome: outcome,
+		Data:    msg.Data,
+	}
+	if err := json.Unmarshal(msg.Data, &e.BaseMessage); err != nil {
+		global.Logger.Warn().
+			Err(err).
+			Str("subject", msg.Subject).
+			Msg("Tapped malformed event")
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+
+	select {
+	case t.events <- e:
+	default:
+		eventTapDroppedTotal.Inc()
+	}
+}
+
+// Close unsubscribes the tap and closes its events.
+func (t *EventTap) Close() error {
+	err := t.sub.Unsubscribe()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.events)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to unsubscribe from task events: %w", err)
+	}
+	return nil
+}
@@ -40,3 +40,10 @@ type Healther interface {
 type Metricker interface {
 	Metric(w http.ResponseWriter, r *http.Request)
 }
+
+// Warmer is an optional interface for workers that need to prepare their
+// dependencies, e.g. load a model, before their first message. The Runner
+// calls Warmup before subscribing, a failure is logged but does not stop it.
+type Warmer interface {
+	Warmup(ctx context.Context) error
+}
@@ -0,0 +1,14 @@
+-- Drop the views depending on annotations
+DROP VIEW IF EXISTS effective_articles_keywords;
+DROP VIEW IF EXISTS latest_annotations;
+
+-- Reset the sequence for annotation table
+ALTER SEQUENCE annotations_id_seq RESTART WITH 1;
+
+-- Drop the annotation table
+DROP INDEX IF EXISTS annotations_article_id_idx;
+DROP TABLE IF EXISTS annotations;
+
+-- Drop the annotation types
+DROP TYPE IF EXISTS annotation_action;
+DROP TYPE IF EXISTS annotation_target;
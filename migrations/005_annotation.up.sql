@@ -0,0 +1,54 @@
+CREATE TYPE annotation_target AS ENUM (
+    'keyword',  -- keyword extracted from an article
+    'stance',   -- stance of an article toward a party
+    'summary'   -- summary of an article
+);
+
+CREATE TYPE annotation_action AS ENUM (
+    'confirm',  -- the model output is correct
+    'reject',   -- the model output is wrong and should be excluded
+    'replace'   -- the model output should be substituted by the replacement
+);
+
+-- Annotations are manual labels and corrections attached by editors to LLM
+-- outputs. target_id refers to a stable text key (e.g. the keyword term) rather
+-- than a row ID so that annotations survive re-processing of the article.
+CREATE TABLE annotations (
+    id           SERIAL            PRIMARY KEY,
+    target_type  annotation_target NOT NULL,
+    target_id    TEXT              NOT NULL,
+    article_id   INTEGER           NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    "action"     annotation_action NOT NULL,
+    replacement  JSONB,
+    author       TEXT              NOT NULL,
+    created_at   TIMESTAMPTZ       DEFAULT CURRENT_TIMESTAMP,
+    CHECK ("action" <> 'replace' OR replacement IS NOT NULL)
+);
+
+CREATE INDEX annotations_article_id_idx ON annotations (article_id, target_type, target_id);
+
+-- latest_annotations keeps only the most recent annotation of each target, which
+-- is the one that takes effect.
+CREATE VIEW latest_annotations AS
+SELECT DISTINCT ON (article_id, target_type, target_id)
+    id, target_type, target_id, article_id, "action", replacement, author, created_at
+FROM annotations
+ORDER BY article_id, target_type, target_id, created_at DESC, id DESC;
+
+-- effective_articles_keywords applies the keyword annotations to the model
+-- output: rejected keywords are excluded, replaced keywords are substituted by
+-- replacement->>'term', and keywords confirmed or added by editors are included.
+CREATE VIEW effective_articles_keywords AS
+SELECT ak.article_id, COALESCE(la.replacement->>'term', k.term)::TEXT AS term
+FROM articles_keywords AS ak
+JOIN keywords AS k ON k.id = ak.keyword_id
+LEFT JOIN latest_annotations AS la
+    ON la.article_id = ak.article_id
+    AND la.target_type = 'keyword'
+    AND la.target_id = k.term
+WHERE la.id IS NULL OR la."action" <> 'reject'
+UNION
+SELECT la.article_id, COALESCE(la.replacement->>'term', la.target_id)::TEXT AS term
+FROM latest_annotations AS la
+WHERE la.target_type = 'keyword'
+    AND la."action" IN ('confirm', 'replace');
@@ -0,0 +1,2 @@
+-- Drop the counters table
+DROP TABLE IF EXISTS counters;
@@ -0,0 +1,23 @@
+-- Counters are pre-aggregated headline numbers for the dashboard. Running
+-- totals are bumped in the same transaction as the insert they count, rollups
+-- are recomputed by a scheduled job which also reconciles the running totals
+-- against the real counts.
+CREATE TABLE counters (
+    "name"      TEXT        PRIMARY KEY,
+    "value"     BIGINT      NOT NULL DEFAULT 0,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Seed the counters from the existing rows
+INSERT INTO counters ("name", "value")
+SELECT 'articles', COUNT(*) FROM articles
+UNION ALL
+SELECT 'tasks', COUNT(*) FROM users.tasks
+UNION ALL
+SELECT 'keywords', COUNT(*) FROM articles_keywords
+UNION ALL
+SELECT 'articles_this_week', COUNT(*) FROM articles
+WHERE published_at >= date_trunc('week', CURRENT_TIMESTAMP)
+UNION ALL
+SELECT 'tasks_processed_today', COUNT(*) FROM users.tasks
+WHERE status = 'done' AND updated_at >= date_trunc('day', CURRENT_TIMESTAMP);
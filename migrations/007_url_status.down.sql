@@ -0,0 +1,3 @@
+-- Drop the liveness check tables
+DROP TABLE IF EXISTS article_revisions;
+DROP TABLE IF EXISTS url_status;
@@ -0,0 +1,26 @@
+-- url_status records the outcome of the periodic liveness check of the
+-- article URLs. An article without a row has never been checked.
+CREATE TABLE url_status (
+    article_id           INTEGER     PRIMARY KEY REFERENCES articles(id) ON DELETE CASCADE,
+    last_checked_at      TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_status_code     INTEGER,
+    consecutive_failures INTEGER     NOT NULL DEFAULT 0,
+    dead                 BOOLEAN     NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX idx_url_status_last_checked_at ON url_status(last_checked_at);
+CREATE INDEX idx_url_status_dead ON url_status(dead) WHERE dead;
+
+-- article_revisions keeps a note of every change made to an article after it
+-- has been inserted, e.g. the URL being moved to a new canonical URL.
+CREATE TABLE article_revisions (
+    id         SERIAL      PRIMARY KEY,
+    article_id INTEGER     NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    field      TEXT        NOT NULL,
+    old_value  TEXT        NOT NULL,
+    new_value  TEXT        NOT NULL,
+    note       TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_article_revisions_article_id ON article_revisions(article_id);
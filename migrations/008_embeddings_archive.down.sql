@@ -0,0 +1,3 @@
+-- Drop the embeddings archive. The archived vectors are lost, restore them
+-- to the hot tier with RestoreToHot before rolling back.
+DROP TABLE IF EXISTS embeddings_archive;
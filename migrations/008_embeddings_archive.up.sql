@@ -0,0 +1,18 @@
+-- embeddings_archive is the cold tier of the embeddings. Vectors of articles
+-- older than the retention window of the hot tier are moved here, quantized to
+-- float16 (little endian), so that the HNSW index of the embeddings table only
+-- covers recent articles. Rows keep the id they had in the embeddings table.
+CREATE TABLE embeddings_archive (
+    id          INTEGER     PRIMARY KEY,
+    article_id  INTEGER     NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    chunk_id    INTEGER     NOT NULL REFERENCES chunks(id) ON DELETE CASCADE,
+    model_id    INTEGER     NOT NULL REFERENCES models(id) ON DELETE CASCADE,
+    dims        INTEGER     NOT NULL,
+    vector      BYTEA       NOT NULL,
+    created_at  TIMESTAMPTZ,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(article_id, chunk_id, model_id),
+    CHECK (octet_length(vector) = 2 * dims)
+);
+
+CREATE INDEX idx_embeddings_archive_model_id ON embeddings_archive(model_id, article_id);
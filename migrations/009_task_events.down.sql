@@ -0,0 +1,3 @@
+-- Drop the task event tables
+DROP TABLE IF EXISTS users.task_state;
+DROP TABLE IF EXISTS users.task_events;
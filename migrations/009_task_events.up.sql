@@ -0,0 +1,28 @@
+-- task_events is the audit log of the stages a task goes through, one row per
+-- status change of a stage, retries included.
+CREATE TABLE users.task_events (
+    id         BIGSERIAL   PRIMARY KEY,
+    task_id    UUID        NOT NULL REFERENCES users.tasks(task_id) ON DELETE CASCADE,
+    stage      TEXT        NOT NULL,
+    status     task_status NOT NULL,
+    message    TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_task_events_task_id_stage ON users.task_events(task_id, stage, id);
+CREATE INDEX idx_task_events_created_at ON users.task_events(created_at);
+
+-- task_state is the snapshot of the state derived from the events of a task,
+-- it is upserted in the same transaction as the event it is derived from.
+-- compacted is set once part of the events of the task has been deleted.
+CREATE TABLE users.task_state (
+    task_id        UUID        PRIMARY KEY REFERENCES users.tasks(task_id) ON DELETE CASCADE,
+    current_status task_status NOT NULL,
+    stage_statuses JSONB       NOT NULL DEFAULT '{}',
+    last_event_id  BIGINT      NOT NULL,
+    compacted      BOOLEAN     NOT NULL DEFAULT FALSE,
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_task_state_terminal ON users.task_state(updated_at)
+    WHERE current_status IN ('done', 'failed');
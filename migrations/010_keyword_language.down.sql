@@ -0,0 +1,24 @@
+-- Drop the keyword language tag. Keywords existing in several languages are
+-- merged into the one with the smallest id.
+DROP TABLE IF EXISTS keyword_links;
+
+INSERT INTO articles_keywords (article_id, keyword_id)
+SELECT ak.article_id, MIN(k.id) OVER (PARTITION BY k.term)
+FROM articles_keywords AS ak
+    JOIN keywords AS k ON k.id = ak.keyword_id
+ON CONFLICT DO NOTHING;
+
+INSERT INTO users.articles_keywords (article_id, keyword_id)
+SELECT ak.article_id, MIN(k.id) OVER (PARTITION BY k.term)
+FROM users.articles_keywords AS ak
+    JOIN keywords AS k ON k.id = ak.keyword_id
+ON CONFLICT DO NOTHING;
+
+DELETE FROM keywords AS k
+USING keywords AS d
+WHERE k.term = d.term
+    AND k.id > d.id;
+
+ALTER TABLE keywords DROP CONSTRAINT keywords_term_lang_key;
+ALTER TABLE keywords ADD CONSTRAINT keywords_term_key UNIQUE (term);
+ALTER TABLE keywords DROP COLUMN lang;
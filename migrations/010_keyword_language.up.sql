@@ -0,0 +1,20 @@
+-- Keywords are tagged with the language they are extracted in, the same term
+-- may exist once per language. Existing keywords were all extracted from
+-- Traditional Chinese articles.
+ALTER TABLE keywords ADD COLUMN lang TEXT NOT NULL DEFAULT 'zh-Hant';
+ALTER TABLE keywords DROP CONSTRAINT keywords_term_key;
+ALTER TABLE keywords ADD CONSTRAINT keywords_term_lang_key UNIQUE (term, lang);
+
+-- keyword_links links keywords of different languages whose embeddings are
+-- similar enough to be considered the same concept, e.g. 「能源政策」 and
+-- "energy policy". A pair is stored once, with the smaller id first.
+CREATE TABLE keyword_links (
+    keyword_id        INTEGER     NOT NULL REFERENCES keywords(id) ON DELETE CASCADE,
+    linked_keyword_id INTEGER     NOT NULL REFERENCES keywords(id) ON DELETE CASCADE,
+    similarity        REAL        NOT NULL,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (keyword_id, linked_keyword_id),
+    CHECK (keyword_id < linked_keyword_id)
+);
+
+CREATE INDEX idx_keyword_links_linked_keyword_id ON keyword_links(linked_keyword_id);
@@ -0,0 +1,5 @@
+-- Drop the saved search tables and types
+DROP TABLE IF EXISTS users.saved_search_hits;
+DROP TABLE IF EXISTS users.saved_searches;
+DROP TYPE IF EXISTS saved_search_delivery;
+DROP TYPE IF EXISTS saved_search_schedule;
@@ -0,0 +1,31 @@
+CREATE TYPE saved_search_schedule AS ENUM ('daily', 'weekly');
+CREATE TYPE saved_search_delivery AS ENUM ('none', 'webhook');
+
+-- saved_searches are the article filters users subscribe to. filter is a
+-- versioned storage.ArticleFilter, it is evaluated on schedule against the
+-- articles published since last_run_at.
+CREATE TABLE users.saved_searches (
+    id          SERIAL                PRIMARY KEY,
+    owner_id    TEXT                  NOT NULL,
+    name        TEXT                  NOT NULL,
+    filter      JSONB                 NOT NULL,
+    schedule    saved_search_schedule NOT NULL DEFAULT 'daily',
+    delivery    saved_search_delivery NOT NULL DEFAULT 'none',
+    webhook_url TEXT                  NOT NULL DEFAULT '',
+    last_run_at TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ           NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMPTZ           NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (owner_id, name)
+);
+
+CREATE INDEX idx_saved_searches_schedule ON users.saved_searches(schedule, last_run_at);
+
+-- saved_search_hits are the articles matched by the runs of a saved search.
+CREATE TABLE users.saved_search_hits (
+    saved_search_id INTEGER     NOT NULL REFERENCES users.saved_searches(id) ON DELETE CASCADE,
+    article_id      INTEGER     NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    run_at          TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (saved_search_id, article_id)
+);
+
+CREATE INDEX idx_saved_search_hits_run_at ON users.saved_search_hits(saved_search_id, run_at DESC, article_id DESC);
@@ -0,0 +1,8 @@
+-- Drop the split markers of the embeddings
+ALTER TABLE users.embeddings
+    DROP COLUMN IF EXISTS sub_count,
+    DROP COLUMN IF EXISTS was_split;
+
+ALTER TABLE embeddings
+    DROP COLUMN IF EXISTS sub_count,
+    DROP COLUMN IF EXISTS was_split;
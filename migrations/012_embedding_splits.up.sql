@@ -0,0 +1,10 @@
+-- An embedding of a chunk over the per-input token limit of the model is
+-- pooled from the embeddings of its pieces. was_split marks such embeddings
+-- and sub_count is the number of pieces, 1 for an unsplit chunk.
+ALTER TABLE embeddings
+    ADD COLUMN was_split BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN sub_count INTEGER NOT NULL DEFAULT 1 CHECK (sub_count >= 1);
+
+ALTER TABLE users.embeddings
+    ADD COLUMN was_split BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN sub_count INTEGER NOT NULL DEFAULT 1 CHECK (sub_count >= 1);
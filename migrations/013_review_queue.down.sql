@@ -0,0 +1,5 @@
+-- Drop the review queue and the review flag of the articles
+DROP TABLE IF EXISTS users.review_queue;
+ALTER TABLE users.articles DROP COLUMN IF EXISTS needs_review;
+DROP TYPE IF EXISTS review_status;
+DROP TYPE IF EXISTS review_item_type;
@@ -0,0 +1,34 @@
+CREATE TYPE review_item_type AS ENUM (
+    'keyword_output',      -- keyword output of an article flagged by the extractor
+    'extraction',          -- low-confidence extraction of an article by the scraper
+    'keyword_attribution', -- keywords not attributed to any party
+    'stance'               -- stance below the confidence threshold
+);
+
+CREATE TYPE review_status AS ENUM ('open', 'resolved', 'dismissed');
+
+-- needs_review marks the articles whose keyword output is held back until an
+-- editor approves it.
+ALTER TABLE users.articles ADD COLUMN needs_review BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- review_queue collects the pipeline outputs flagged for an editor. item_ref
+-- holds the ids needed to load the item, an item is queued once while it is
+-- open however many times it is flagged. An editor claims an item for a lease,
+-- after which another editor may claim it.
+CREATE TABLE users.review_queue (
+    id               SERIAL           PRIMARY KEY,
+    item_type        review_item_type NOT NULL,
+    item_ref         JSONB            NOT NULL,
+    article_id       INTEGER          NOT NULL REFERENCES users.articles(id) ON DELETE CASCADE,
+    reason           TEXT             NOT NULL,
+    priority         INTEGER          NOT NULL DEFAULT 0,
+    status           review_status    NOT NULL DEFAULT 'open',
+    assigned_to      TEXT,
+    lease_expires_at TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at      TIMESTAMPTZ,
+    CHECK ((status = 'open') = (resolved_at IS NULL))
+);
+
+CREATE UNIQUE INDEX idx_review_queue_open_item ON users.review_queue(item_type, item_ref) WHERE status = 'open';
+CREATE INDEX idx_review_queue_status ON users.review_queue(status, item_type, created_at, id);
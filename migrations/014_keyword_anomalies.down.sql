@@ -0,0 +1,3 @@
+-- Drop the keyword anomalies and the low information flag of the keywords
+DROP TABLE IF EXISTS keyword_anomalies;
+ALTER TABLE keywords DROP COLUMN IF EXISTS low_information;
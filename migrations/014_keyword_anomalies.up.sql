@@ -0,0 +1,19 @@
+-- low_information marks the keywords too generic to tell the articles apart,
+-- e.g. 「新聞」 or 「台灣」. They are left out of the trend analysis.
+ALTER TABLE keywords ADD COLUMN low_information BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- keyword_anomalies records the days a keyword was mentioned well above its
+-- baseline. A keyword is flagged once per day, however many times the
+-- detector runs over that day.
+CREATE TABLE keyword_anomalies (
+    keyword_id      INTEGER          NOT NULL REFERENCES keywords(id) ON DELETE CASCADE,
+    "date"          DATE             NOT NULL,
+    "count"         INTEGER          NOT NULL,
+    baseline_mean   DOUBLE PRECISION NOT NULL,
+    baseline_stddev DOUBLE PRECISION NOT NULL,
+    zscore          DOUBLE PRECISION NOT NULL,
+    detected_at     TIMESTAMPTZ      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (keyword_id, "date")
+);
+
+CREATE INDEX idx_keyword_anomalies_date ON keyword_anomalies("date");
@@ -0,0 +1,2 @@
+-- Drop the source weights
+DROP TABLE IF EXISTS source_weights;
@@ -0,0 +1,9 @@
+-- source_weights holds the authority of the sources in [0, 1], blended into
+-- the score of the top articles. The sources without a weight get the default
+-- weight of the scoring.
+CREATE TABLE source_weights (
+    source     TEXT             PRIMARY KEY,
+    weight     DOUBLE PRECISION NOT NULL CHECK (weight >= 0 AND weight <= 1),
+    updated_by TEXT             NOT NULL,
+    updated_at TIMESTAMPTZ      NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
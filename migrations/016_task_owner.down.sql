@@ -0,0 +1,4 @@
+-- Drop the task owners
+DROP INDEX IF EXISTS idx_articles_url;
+DROP INDEX IF EXISTS users.idx_tasks_url_original_input;
+ALTER TABLE users.tasks DROP COLUMN IF EXISTS owner_id;
@@ -0,0 +1,8 @@
+-- owner_id is who created a task, NULL for the tasks submitted through the API
+-- and 'system' for the ones created by the discovery of the fresh news.
+ALTER TABLE users.tasks ADD COLUMN owner_id TEXT;
+
+-- The discovered URLs are looked up in the URL tasks and the articles before
+-- a task is created for them.
+CREATE INDEX idx_tasks_url_original_input ON users.tasks(original_input) WHERE source = 'url';
+CREATE INDEX idx_articles_url ON articles("url");
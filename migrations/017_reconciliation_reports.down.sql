@@ -0,0 +1,5 @@
+-- Drop the reconciliation reports. An enum value cannot be dropped, the
+-- reconciliation review items are deleted and the value is left unused.
+DROP INDEX IF EXISTS users.idx_tasks_status_updated_at;
+DROP TABLE IF EXISTS reconciliation_reports;
+DELETE FROM users.review_queue WHERE item_type = 'reconciliation';
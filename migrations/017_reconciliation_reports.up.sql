@@ -0,0 +1,28 @@
+-- A divergence between the cache and the database the reconciliation cannot
+-- repair on its own is queued for an editor.
+ALTER TYPE review_item_type ADD VALUE IF NOT EXISTS 'reconciliation';
+
+-- reconciliation_reports holds a report per reconciliation pass over the
+-- tasks done since since. A pass interrupted before it finished is resumed
+-- after last_task_id, the row id of the last task it checked. counts holds the
+-- number of divergences by class.
+CREATE TABLE reconciliation_reports (
+    id           SERIAL      PRIMARY KEY,
+    since        TIMESTAMPTZ NOT NULL,
+    last_task_id INTEGER     NOT NULL DEFAULT 0,
+    checked      INTEGER     NOT NULL DEFAULT 0,
+    sampled      INTEGER     NOT NULL DEFAULT 0,
+    counts       JSONB       NOT NULL DEFAULT '{}',
+    repaired     INTEGER     NOT NULL DEFAULT 0,
+    flagged      INTEGER     NOT NULL DEFAULT 0,
+    started_at   TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at  TIMESTAMPTZ
+);
+
+-- at most one pass runs at a time
+CREATE UNIQUE INDEX idx_reconciliation_reports_running ON reconciliation_reports ((finished_at IS NULL))
+    WHERE finished_at IS NULL;
+
+-- the done tasks are paged through by row id
+CREATE INDEX idx_tasks_status_updated_at ON users.tasks(status, updated_at);
@@ -0,0 +1,9 @@
+-- Drop the freshness check columns and the user article revisions
+DROP TABLE IF EXISTS users.article_revisions;
+DROP INDEX IF EXISTS users.idx_users_articles_published_at;
+ALTER TABLE users.articles
+    DROP COLUMN IF EXISTS last_checked_at,
+    DROP COLUMN IF EXISTS content_hash,
+    DROP COLUMN IF EXISTS modified_at;
+ALTER TABLE articles
+    DROP COLUMN IF EXISTS modified_at;
@@ -0,0 +1,31 @@
+-- modified_at is the modification time declared by the publisher, NULL if it
+-- declares none. The scraped user articles are re-checked for updates while
+-- they are fresh: last_checked_at is the time of the last check, NULL if they
+-- have never been checked, and content_hash the MD5 of the content, compared
+-- when the publisher declares no modification time.
+ALTER TABLE articles
+    ADD COLUMN modified_at TIMESTAMPTZ;
+
+ALTER TABLE users.articles
+    ADD COLUMN modified_at     TIMESTAMPTZ,
+    ADD COLUMN content_hash    TEXT        NOT NULL DEFAULT '',
+    ADD COLUMN last_checked_at TIMESTAMPTZ;
+
+UPDATE users.articles SET content_hash = md5(content);
+
+-- the fresh articles are looked up by publication time
+CREATE INDEX idx_users_articles_published_at ON users.articles(published_at);
+
+-- users.article_revisions keeps a note of every change made to a user article
+-- after it has been inserted, e.g. its content updated by the publisher.
+CREATE TABLE users.article_revisions (
+    id         SERIAL      PRIMARY KEY,
+    article_id INTEGER     NOT NULL REFERENCES users.articles(id) ON DELETE CASCADE,
+    field      TEXT        NOT NULL,
+    old_value  TEXT        NOT NULL,
+    new_value  TEXT        NOT NULL,
+    note       TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_users_article_revisions_article_id ON users.article_revisions(article_id, created_at);
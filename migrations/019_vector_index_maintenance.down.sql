@@ -0,0 +1,2 @@
+-- Drop the vector index maintenance records
+DROP TABLE IF EXISTS vector_index_maintenance;
@@ -0,0 +1,24 @@
+-- vector_index_maintenance holds a record per rebuild of a vector index, with
+-- the recall estimated and the size before and after it. params holds the
+-- build options of the new index, error the reason of a failed rebuild.
+-- finished_at is NULL while the rebuild runs.
+CREATE TABLE vector_index_maintenance (
+    id            SERIAL           PRIMARY KEY,
+    index_name    TEXT             NOT NULL,
+    table_name    TEXT             NOT NULL,
+    method        TEXT             NOT NULL,
+    params        JSONB            NOT NULL DEFAULT '{}',
+    recall_before DOUBLE PRECISION,
+    recall_after  DOUBLE PRECISION,
+    size_before   BIGINT,
+    size_after    BIGINT,
+    error         TEXT,
+    started_at    TIMESTAMPTZ      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at   TIMESTAMPTZ
+);
+
+-- at most one rebuild of an index runs at a time
+CREATE UNIQUE INDEX idx_vector_index_maintenance_running ON vector_index_maintenance (index_name)
+    WHERE finished_at IS NULL;
+
+CREATE INDEX idx_vector_index_maintenance_index_name ON vector_index_maintenance (index_name, finished_at);
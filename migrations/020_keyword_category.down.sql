@@ -0,0 +1,4 @@
+-- Drop the categories of the keywords of the user articles
+ALTER TABLE users.articles_keywords DROP COLUMN IF EXISTS category;
+
+DROP TYPE IF EXISTS keyword_category;
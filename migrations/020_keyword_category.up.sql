@@ -0,0 +1,13 @@
+-- category is the kind of a keyword the extractor found in a user article,
+-- NULL for the keywords attached before the categories were stored. The
+-- categories are kept per article, the same term is an entity of one article
+-- and a theme of another.
+CREATE TYPE keyword_category AS ENUM (
+    'theme',
+    'event',
+    'entity',
+    'action'
+);
+
+ALTER TABLE users.articles_keywords
+    ADD COLUMN category keyword_category;
@@ -0,0 +1,8 @@
+-- Rebuild the HNSW indexes of the embeddings with the default parameters
+DROP INDEX IF EXISTS embeddings_vector_hnsw_idx;
+CREATE INDEX embeddings_vector_hnsw_idx ON embeddings
+    USING hnsw (vector vector_cosine_ops);
+
+DROP INDEX IF EXISTS users.embeddings_vector_hnsw_idx;
+CREATE INDEX embeddings_vector_hnsw_idx ON users.embeddings
+    USING hnsw (vector vector_cosine_ops);
@@ -0,0 +1,22 @@
+-- The HNSW indexes of the embeddings are rebuilt with their build parameters
+-- spelled out, instead of relying on the defaults of pgvector:
+--
+-- m               the number of neighbours of a node per layer of the graph,
+--                 16. A larger m raises the recall of the high-dimensional
+--                 vectors at the cost of the size and the build time.
+-- ef_construction the size of the candidate list while building the graph,
+--                 64, at least twice m. A larger one builds a better graph,
+--                 slower.
+--
+-- The searches order by the cosine distance, <=>, so the indexes are built
+-- with vector_cosine_ops. At query time hnsw.ef_search, 40 by default, bounds
+-- the number of rows an index scan returns, see storage.MaxSearchLimit.
+-- VectorIndexMaintenance rebuilds the indexes with other parameters once the
+-- recall drops.
+DROP INDEX IF EXISTS embeddings_vector_hnsw_idx;
+CREATE INDEX embeddings_vector_hnsw_idx ON embeddings
+    USING hnsw (vector vector_cosine_ops) WITH (m = 16, ef_construction = 64);
+
+DROP INDEX IF EXISTS users.embeddings_vector_hnsw_idx;
+CREATE INDEX embeddings_vector_hnsw_idx ON users.embeddings
+    USING hnsw (vector vector_cosine_ops) WITH (m = 16, ef_construction = 64);
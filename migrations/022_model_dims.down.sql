@@ -0,0 +1,29 @@
+-- Restore the embeddings of 1024 dimensions, the embeddings of other
+-- dimensions are dropped
+DO $$
+DECLARE idx RECORD;
+BEGIN
+    FOR idx IN SELECT schemaname, indexname FROM pg_indexes
+        WHERE schemaname IN ('public', 'users')
+            AND tablename = 'embeddings'
+            AND indexname LIKE 'embeddings\_vector\_hnsw\_model\_%\_idx'
+    LOOP
+        EXECUTE format('DROP INDEX %I.%I', idx.schemaname, idx.indexname);
+    END LOOP;
+END $$;
+
+DROP INDEX IF EXISTS idx_users_embeddings_model_id;
+DROP INDEX IF EXISTS idx_embeddings_model_id;
+
+DELETE FROM embeddings WHERE vector_dims(vector) <> 1024;
+DELETE FROM users.embeddings WHERE vector_dims(vector) <> 1024;
+
+ALTER TABLE embeddings ALTER COLUMN vector TYPE vector(1024);
+ALTER TABLE users.embeddings ALTER COLUMN vector TYPE vector(1024);
+
+CREATE INDEX embeddings_vector_hnsw_idx ON embeddings
+    USING hnsw (vector vector_cosine_ops) WITH (m = 16, ef_construction = 64);
+CREATE INDEX embeddings_vector_hnsw_idx ON users.embeddings
+    USING hnsw (vector vector_cosine_ops) WITH (m = 16, ef_construction = 64);
+
+ALTER TABLE models DROP COLUMN IF EXISTS dim;
@@ -0,0 +1,44 @@
+-- dim is the number of dimensions of the embeddings of a model, the length of
+-- the embeddings is checked against it at insert time. The models recorded so
+-- far all embed in 1024 dimensions.
+ALTER TABLE models
+    ADD COLUMN dim INTEGER NOT NULL DEFAULT 1024 CHECK (dim > 0 AND dim <= 16000);
+
+ALTER TABLE models ALTER COLUMN dim DROP DEFAULT;
+
+-- The embeddings of models of different dimensions share the tables, so the
+-- vectors are no longer typed with a dimension, and the distances are only
+-- computed between the embeddings of a model. An HNSW index needs a
+-- dimension: the indexes over the whole column are dropped, and an index of a
+-- model is a partial expression index over the vectors of the model, e.g.
+--
+--   CREATE INDEX ON embeddings USING hnsw ((vector::vector(1536)) vector_cosine_ops)
+--       WITH (m = 16, ef_construction = 64) WHERE model_id = 3;
+--
+-- searched by ordering by vector::vector(1536) <=> query::vector(1536). Without
+-- one the embeddings of a model are scanned exactly, through the index on
+-- model_id. The indexes over the whole column are replaced by one for each of
+-- the models recorded so far, all of 1024 dimensions.
+DROP INDEX IF EXISTS embeddings_vector_hnsw_idx;
+DROP INDEX IF EXISTS users.embeddings_vector_hnsw_idx;
+
+ALTER TABLE embeddings ALTER COLUMN vector TYPE vector;
+ALTER TABLE users.embeddings ALTER COLUMN vector TYPE vector;
+
+CREATE INDEX idx_embeddings_model_id ON embeddings (model_id);
+CREATE INDEX idx_users_embeddings_model_id ON users.embeddings (model_id);
+
+DO $$
+DECLARE m RECORD;
+BEGIN
+    FOR m IN SELECT id, dim FROM models ORDER BY id LOOP
+        EXECUTE format(
+            'CREATE INDEX %I ON embeddings USING hnsw ((vector::vector(%s)) vector_cosine_ops) WITH (m = 16, ef_construction = 64) WHERE model_id = %s',
+            'embeddings_vector_hnsw_model_' || m.id || '_idx', m.dim, m.id
+        );
+        EXECUTE format(
+            'CREATE INDEX %I ON users.embeddings USING hnsw ((vector::vector(%s)) vector_cosine_ops) WITH (m = 16, ef_construction = 64) WHERE model_id = %s',
+            'embeddings_vector_hnsw_model_' || m.id || '_idx', m.dim, m.id
+        );
+    END LOOP;
+END $$;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users.idx_users_articles_task_id_published_at;
+DROP INDEX IF EXISTS idx_articles_published_at_id;
@@ -0,0 +1,6 @@
+-- The articles are listed newest first, paged by a cursor on published_at and
+-- id, the id breaking the ties of the articles published at the same time.
+CREATE INDEX idx_articles_published_at_id ON articles (published_at, id);
+
+-- The user articles of the tasks of a status are listed the same way.
+CREATE INDEX idx_users_articles_task_id_published_at ON users.articles (task_id, published_at, id);
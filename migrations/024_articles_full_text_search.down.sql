@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_articles_content_trgm;
+DROP INDEX IF EXISTS idx_articles_title_trgm;
+DROP INDEX IF EXISTS idx_articles_search_vector;
+DROP FUNCTION IF EXISTS articles_search_vector(TEXT, TEXT);
+DROP TEXT SEARCH CONFIGURATION IF EXISTS articles_fts;
@@ -0,0 +1,35 @@
+-- The articles are searched by the text search configuration articles_fts. It
+-- segments the Chinese words with zhparser where the extension is installed,
+-- and falls back to a copy of simple otherwise, which splits on spaces and
+-- punctuation only and so turns a Chinese sentence into a single token.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'zhparser') THEN
+        CREATE EXTENSION IF NOT EXISTS zhparser;
+        CREATE TEXT SEARCH CONFIGURATION articles_fts (PARSER = zhparser);
+        -- nouns, verbs, adjectives, idioms, exclamations and habitual words
+        ALTER TEXT SEARCH CONFIGURATION articles_fts ADD MAPPING FOR n, v, a, i, e, l WITH simple;
+    ELSE
+        CREATE TEXT SEARCH CONFIGURATION articles_fts (COPY = simple);
+    END IF;
+END
+$$;
+
+-- articles_search_vector is the document of an article, its title weighted
+-- above its content. The index and the queries both call it, so that the
+-- planner matches them.
+CREATE FUNCTION articles_search_vector(title TEXT, content TEXT) RETURNS tsvector
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+SELECT setweight(to_tsvector('articles_fts', title), 'A')
+    || setweight(to_tsvector('articles_fts', content), 'B');
+$$;
+
+CREATE INDEX idx_articles_search_vector ON articles
+    USING gin (articles_search_vector(title, content));
+
+-- The trigram indexes serve the substring matches, which find the Chinese
+-- words the fallback configuration cannot segment.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_articles_title_trgm ON articles USING gin (title gin_trgm_ops);
+CREATE INDEX idx_articles_content_trgm ON articles USING gin (content gin_trgm_ops);
@@ -0,0 +1,3 @@
+-- active tells whether new embeddings are inserted under a model. A retired
+-- model is deactivated rather than deleted, its embeddings stay searchable.
+ALTER TABLE models ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE;
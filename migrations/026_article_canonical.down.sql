@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_articles_canonical_id;
+
+ALTER TABLE articles DROP COLUMN IF EXISTS canonical_id;
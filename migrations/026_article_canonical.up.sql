@@ -0,0 +1,10 @@
+-- canonical_id links an article to the one it republishes, e.g. a press
+-- release of a party site republished by a news portal under another URL, so
+-- that the duplicates are kept but not embedded again. The canonical article
+-- of a duplicate is never a duplicate itself.
+ALTER TABLE articles
+    ADD COLUMN canonical_id INTEGER REFERENCES articles (id) ON DELETE SET NULL,
+    ADD CONSTRAINT articles_canonical_id_check CHECK (canonical_id <> id);
+
+CREATE INDEX idx_articles_canonical_id ON articles (canonical_id)
+    WHERE canonical_id IS NOT NULL;
@@ -0,0 +1,4 @@
+-- fails if an article has been stored by several tasks since
+ALTER TABLE users.articles
+    DROP CONSTRAINT IF EXISTS articles_task_id_md5_key,
+    ADD CONSTRAINT articles_md5_key UNIQUE (md5);
@@ -0,0 +1,6 @@
+-- The md5 of a user article is unique within its task only: two users
+-- submitting the same article each get their own copy, so that the keywords,
+-- the review and the deletion of one task do not touch the other.
+ALTER TABLE users.articles
+    DROP CONSTRAINT IF EXISTS articles_md5_key,
+    ADD CONSTRAINT articles_task_id_md5_key UNIQUE (task_id, md5);
@@ -0,0 +1,3 @@
+ALTER TABLE embeddings_archive
+    DROP COLUMN IF EXISTS sub_count,
+    DROP COLUMN IF EXISTS was_split;
@@ -0,0 +1,6 @@
+-- The archived embeddings keep whether they were pooled from the pieces of a
+-- split chunk, see 012, so that a restored embedding is not taken for the one
+-- of an unsplit chunk.
+ALTER TABLE embeddings_archive
+    ADD COLUMN was_split BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN sub_count INTEGER NOT NULL DEFAULT 1 CHECK (sub_count >= 1);
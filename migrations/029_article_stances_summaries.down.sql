@@ -0,0 +1,4 @@
+DROP VIEW IF EXISTS effective_article_summaries;
+DROP VIEW IF EXISTS effective_article_stances;
+DROP TABLE IF EXISTS article_summaries;
+DROP TABLE IF EXISTS article_stances;
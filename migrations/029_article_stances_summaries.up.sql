@@ -0,0 +1,50 @@
+-- article_stances holds the stance of an article toward a party produced by
+-- the model, one per party. A stance annotation targets the stance of its
+-- article by the party, e.g. target_id 'KMT' and replacement
+-- {"stance": "oppose"}.
+CREATE TABLE article_stances (
+    article_id INTEGER     NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    party      party       NOT NULL,
+    stance     TEXT        NOT NULL CHECK (stance IN ('support', 'oppose', 'neutral')),
+    confidence REAL        NOT NULL CHECK (confidence >= 0 AND confidence <= 1),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (article_id, party)
+);
+
+-- article_summaries holds the summary of an article produced by the model. An
+-- article has a single summary, the latest summary annotation of the article
+-- takes effect on it whatever its target_id, e.g. replacement
+-- {"summary": "..."}.
+CREATE TABLE article_summaries (
+    article_id INTEGER     PRIMARY KEY REFERENCES articles(id) ON DELETE CASCADE,
+    summary    TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- effective_article_stances applies the stance annotations to the model
+-- output, as effective_articles_keywords does for the keywords: rejected
+-- stances are excluded and replaced stances take replacement->>'stance'.
+CREATE VIEW effective_article_stances AS
+SELECT s.article_id, s.party, COALESCE(la.replacement->>'stance', s.stance)::TEXT AS stance, s.confidence
+FROM article_stances AS s
+LEFT JOIN latest_annotations AS la
+    ON la.article_id = s.article_id
+    AND la.target_type = 'stance'
+    AND la.target_id = s.party::TEXT
+WHERE la.id IS NULL OR la."action" <> 'reject';
+
+-- effective_article_summaries applies the summary annotations to the model
+-- output: a rejected summary is excluded and a replaced one takes
+-- replacement->>'summary'.
+CREATE VIEW effective_article_summaries AS
+SELECT s.article_id, COALESCE(la.replacement->>'summary', s.summary)::TEXT AS summary
+FROM article_summaries AS s
+LEFT JOIN LATERAL (
+    SELECT l.id, l."action", l.replacement
+    FROM latest_annotations AS l
+    WHERE l.article_id = s.article_id
+        AND l.target_type = 'summary'
+    ORDER BY l.created_at DESC, l.id DESC
+    LIMIT 1
+) AS la ON TRUE
+WHERE la.id IS NULL OR la."action" <> 'reject';
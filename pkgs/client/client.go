@@ -0,0 +1,392 @@
+// Package client is the Go client of the HTTP API. It shares the paths and
+// the payloads of the API with the server through internal/apischema.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/retry"
+	"github.com/google/uuid"
+)
+
+// MaxErrorBodySize is the largest error body decoded, the rest is dropped.
+const MaxErrorBodySize = 1 << 20
+
+// Client calls the HTTP API. It is safe for concurrent use.
+type Client struct {
+	baseURL     *url.URL
+	httpClient  *http.Client
+	editorToken string
+	retry       retry.Policy
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient makes the client send its requests with c.
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		if c != nil {
+			cl.httpClient = c
+		}
+	}
+}
+
+// WithEditorToken sets the editor token of the editor endpoints, e.g. the
+// source weights.
+func WithEditorToken(token string) Option {
+	return func(c *Client) {
+		c.editorToken = token
+	}
+}
+
+// WithRetry sets the retry policy of the GET requests, retry.DefaultPolicy by
+// default. The other requests are never retried.
+func WithRetry(p retry.Policy) Option {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+// New creates a Client of the API served at baseURL, e.g.
+// http://localhost:8080.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL %q: %w", baseURL, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: expected an http or https URL", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		retry:      retry.DefaultPolicy(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// CreateTaskFromURL creates a task from a Yahoo news URL and returns its id.
+func (c *Client) CreateTaskFromURL(ctx context.Context, rawURL string) (uuid.UUID, error) {
+	return c.createTask(ctx, apischema.PathTaskFromURL, apischema.FormQueryURL, rawURL)
+}
+
+// CreateTaskFromText creates a task from a text and returns its id. A first
+// line starting with # is taken as the title.
+func (c *Client) CreateTaskFromText(ctx context.Context, text string) (uuid.UUID, error) {
+	return c.createTask(ctx, apischema.PathTaskFromText, apischema.FormQueryText, text)
+}
+
+func (c *Client) createTask(ctx context.Context, path, field, value string) (uuid.UUID, error) {
+	form := url.Values{field: {value}}
+	var created apischema.TaskCreated
+	err := c.do(ctx, http.MethodPost, path, nil,
+		[]byte(form.Encode()), "application/x-www-form-urlencoded", &created)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return created.TaskID, nil
+}
+
+// RecoverTask re-submits the journaled submission of intakeID, which failed on
+// the server side, and returns the id of the created task. The intake id of a
+// failed submission is taken from its error with IntakeID.
+func (c *Client) RecoverTask(ctx context.Context, intakeID uuid.UUID) (uuid.UUID, error) {
+	var created apischema.TaskCreated
+	path := apischema.Path(apischema.PathTaskRecover, "intake_id", intakeID.String())
+	if err := c.do(ctx, http.MethodPost, path, nil, nil, "", &created); err != nil {
+		return uuid.Nil, err
+	}
+	return created.TaskID, nil
+}
+
+// IntakeID returns the intake id in the details of the error of a task
+// submission, and whether there is one.
+func IntakeID(err error) (uuid.UUID, bool) {
+	e, ok := err.(*ec.Error)
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	for _, d := range e.Details {
+		if v, ok := strings.CutPrefix(d, "intake_id: "); ok {
+			id, err := uuid.Parse(v)
+			return id, err == nil
+		}
+	}
+	return uuid.Nil, false
+}
+
+// GetTask returns a task.
+func (c *Client) GetTask(ctx context.Context, taskID uuid.UUID) (*models.UsersTask, error) {
+	var task models.UsersTask
+	path := apischema.Path(apischema.PathTask, "task_id", taskID.String())
+	if err := c.get(ctx, path, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// TaskTimeline returns the timeline of a task.
+func (c *Client) TaskTimeline(ctx context.Context, taskID uuid.UUID) (*storage.TaskTimeline, error) {
+	var timeline storage.TaskTimeline
+	path := apischema.Path(apischema.PathTaskTimeline, "task_id", taskID.String())
+	if err := c.get(ctx, path, nil, &timeline); err != nil {
+		return nil, err
+	}
+	return &timeline, nil
+}
+
+// ListArticles returns a page of the public articles.
+func (c *Client) ListArticles(ctx context.Context, filter apischema.ArticleFilter) ([]apischema.ArticleListItem, error) {
+	var resp apischema.ArticlesResponse
+	if err := c.get(ctx, apischema.PathArticles, filter.Values(), &resp); err != nil {
+		return nil, err
+	}
+	return resp.Articles, nil
+}
+
+// ListArticlesAll calls fn with the pages of the articles of filter, from
+// filter.Offset on, until the last one. The pages are of filter.Limit
+// articles, apischema.MaxArticlesPageSize if not set. In the top ordering it
+// stops at storage.MaxTopArticlesDepth articles. It returns the first error of
+// fn, if any.
+func (c *Client) ListArticlesAll(ctx context.Context, filter apischema.ArticleFilter,
+	fn func(page []apischema.ArticleListItem) error) error {
+	if filter.Limit <= 0 {
+		filter.Limit = apischema.MaxArticlesPageSize
+	}
+
+	for {
+		if filter.Sort == apischema.ArticlesSortTop {
+			if filter.Offset >= storage.MaxTopArticlesDepth {
+				return nil
+			}
+			filter.Limit = min(filter.Limit, storage.MaxTopArticlesDepth-filter.Offset)
+		}
+
+		page, err := c.ListArticles(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		if len(page) == 0 {
+			return nil
+		}
+
+		if err := fn(page); err != nil {
+			return err
+		}
+
+		if len(page) < filter.Limit {
+			return nil
+		}
+		filter.Offset += len(page)
+	}
+}
+
+// KeywordAnomalies returns the keywords flagged as spiking since the given
+// day. A zero since or limit is left to the server defaults.
+func (c *Client) KeywordAnomalies(ctx context.Context, since time.Time, limit int) ([]models.ListKeywordAnomaliesSinceRow, error) {
+	query := url.Values{}
+	if !since.IsZero() {
+		query.Set(apischema.ParamSince, since.Format(time.DateOnly))
+	}
+
+	if limit > 0 {
+		query.Set(apischema.ParamLimit, fmt.Sprint(limit))
+	}
+
+	var resp apischema.KeywordAnomaliesResponse
+	if err := c.get(ctx, apischema.PathKeywordAnomalies, query, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Anomalies, nil
+}
+
+// SourceWeights returns the authority weights of the sources. It requires an
+// editor token.
+func (c *Client) SourceWeights(ctx context.Context) ([]models.SourceWeight, error) {
+	var resp apischema.SourceWeightsResponse
+	if err := c.get(ctx, apischema.PathSourceWeights, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.SourceWeights, nil
+}
+
+// ReconciliationReports returns the latest reports of the reconciliation of
+// the cache with the database, newest first. A zero limit is left to the
+// server default. It requires an editor token.
+func (c *Client) ReconciliationReports(ctx context.Context, limit int) ([]storage.ReconciliationReport, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set(apischema.ParamLimit, fmt.Sprint(limit))
+	}
+
+	var resp apischema.ReconciliationResponse
+	if err := c.get(ctx, apischema.PathReconciliation, query, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Reports, nil
+}
+
+// SetSourceWeight sets the authority weight of a source on behalf of editor.
+// It requires an editor token.
+func (c *Client) SetSourceWeight(ctx context.Context, source string, weight float64, editor string) (*models.SourceWeight, error) {
+	body, err := json.Marshal(apischema.SourceWeightRequest{Weight: &weight, Editor: editor})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal source weight request: %w", err)
+	}
+
+	var sw models.SourceWeight
+	path := apischema.Path(apischema.PathSourceWeight, "source", source)
+	if err := c.do(ctx, http.MethodPut, path, nil, body, "application/json", &sw); err != nil {
+		return nil, err
+	}
+	return &sw, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, out any) error {
+	return c.do(ctx, http.MethodGet, path, query, nil, "", out)
+}
+
+// do sends a request and decodes its JSON response into out. The GET requests
+// are retried on the network errors and the 429 and 5xx responses.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values,
+	body []byte, contentType string, out any) error {
+	// path is escaped already, see apischema.Path
+	u := *c.baseURL
+	u.RawPath = u.EscapedPath() + path
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	u.RawQuery = query.Encode()
+
+	policy := retry.Never
+	if method == http.MethodGet {
+		policy = c.retry
+	}
+
+	return policy.Do(ctx, func(ctx context.Context) error {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+		if err != nil {
+			return retry.Permanent(fmt.Errorf("failed to create request: %w", err))
+		}
+		req.Header.Set("Accept", "application/json")
+
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		if c.editorToken != "" {
+			req.Header.Set(apischema.EditorTokenHeader, c.editorToken)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return retry.Permanent(err)
+			}
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			e := decodeError(resp)
+			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+				return e
+			}
+			return retry.Permanent(e)
+		}
+
+		// the server answers an empty query text with a 204
+		if resp.StatusCode == http.StatusNoContent {
+			if out == nil {
+				return nil
+			}
+			return retry.Permanent(ec.ErrNoContent.Clone())
+		}
+
+		if out == nil {
+			return nil
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return retry.Permanent(fmt.Errorf("failed to decode response of %s %s: %w", method, path, err))
+		}
+		return nil
+	})
+}
+
+// knownErrors are the errors of pkgs/errors the internal status code of a
+// decoded error is recovered from.
+var knownErrors = []*ec.Error{
+	ec.ErrInternalServerError,
+	ec.ErrBadRequest,
+	ec.ErrUnauthorized,
+	ec.ErrForbidden,
+	ec.ErrConflict,
+	ec.ErrPayloadTooLarge,
+	ec.ErrContentContainsMaliciousPrompt,
+	ec.ErrValidationFailed,
+	ec.ErrDBError,
+	ec.ErrNotFound,
+	ec.ErrDBIntegrityConstrainViolation,
+	ec.ErrDBTransactionRollback,
+	ec.ErrDBTypeConversionError,
+	ec.ErrSchemaMismatch,
+	ec.ErrDBUniqueViolation,
+	ec.ErrDBForeignKeyViolation,
+	ec.ErrDBNotNullViolation,
+	ec.ErrDBValueTooLong,
+	ec.ErrDBSerializationFailure,
+	ec.ErrNATSServerError,
+	ec.ErrNATSConnectionFailed,
+	ec.ErrNATSMsgPublishFailed,
+}
+
+// decodeError decodes the error envelope of resp. The internal status code is
+// only recovered if the server kept the message of the error, the body is
+// taken as the message if it is not an envelope.
+func decodeError(resp *http.Response) *ec.Error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, MaxErrorBodySize))
+
+	var e ec.Error
+	if err := json.Unmarshal(data, &e); err != nil || e.Message == "" {
+		msg := strings.TrimSpace(string(data))
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return ec.NewWithHTTPStatus(resp.StatusCode, 0, msg)
+	}
+	e.HttpStatusCode = resp.StatusCode
+
+	for _, known := range knownErrors {
+		if known.HttpStatusCode == e.HttpStatusCode && known.Message == e.Message {
+			e.InternalStatusCode = known.InternalStatusCode
+			break
+		}
+	}
+	return ec.NewWithHTTPStatus(e.HttpStatusCode, e.InternalStatusCode, e.Message, e.Details...)
+}
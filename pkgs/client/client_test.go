@@ -0,0 +1,497 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/internal/apischema"
+	"github.com/ChiaYuChang/weathercock/internal/global"
+	"github.com/ChiaYuChang/weathercock/internal/models"
+	"github.com/ChiaYuChang/weathercock/internal/router"
+	"github.com/ChiaYuChang/weathercock/internal/router/intake"
+	"github.com/ChiaYuChang/weathercock/internal/storage"
+	"github.com/ChiaYuChang/weathercock/internal/workers"
+	"github.com/ChiaYuChang/weathercock/internal/workers/publishers"
+	"github.com/ChiaYuChang/weathercock/pkgs/client"
+	ec "github.com/ChiaYuChang/weathercock/pkgs/errors"
+	"github.com/ChiaYuChang/weathercock/pkgs/retry"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const editorToken = "s3cret"
+
+var (
+	taskID  = uuid.MustParse("6f1c2b9e-4d2a-4c3b-9a7e-0b1d2c3e4f50")
+	created = pgtype.Timestamptz{Time: time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC), Valid: true}
+)
+
+type fixture struct {
+	db  *fakeDB
+	js  *fakeJetStream
+	c   *client.Client
+	url string
+}
+
+// newFixture serves the real router on a fake database and returns a client
+// of it, retrying three times without delay.
+func newFixture(t *testing.T, opts ...client.Option) fixture {
+	t.Helper()
+	return newJournaledFixture(t, nil, opts...)
+}
+
+// newJournaledFixture is a newFixture journaling the task submissions to
+// journal.
+func newJournaledFixture(t *testing.T, journal *intake.Journal, opts ...client.Option) fixture {
+	t.Helper()
+	global.InitValidator()
+
+	db := newFakeDB()
+	js := &fakeJetStream{}
+	pub := publishers.NewPublisher("test", js, zerolog.Nop(), noop.NewTracerProvider().Tracer("test"))
+	mux := router.NewRouter(storage.New(db, nil), pub, nil, editorToken, global.ScoringConfig{}.Default(),
+		global.VectorIndexConfig{}.Default(), journal)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	opts = append([]client.Option{
+		client.WithHTTPClient(srv.Client()),
+		client.WithEditorToken(editorToken),
+		client.WithRetry(retry.Policy{MaxAttempts: 3}),
+	}, opts...)
+	c, err := client.New(srv.URL, opts...)
+	require.NoError(t, err)
+	return fixture{db: db, js: js, c: c, url: srv.URL}
+}
+
+// requireError requires err to be the decoded want.
+func requireError(t *testing.T, want *ec.Error, err error) *ec.Error {
+	t.Helper()
+	require.Error(t, err)
+
+	e, ok := err.(*ec.Error)
+	require.True(t, ok, "expected an *errors.Error, got %T: %v", err, err)
+	require.Equal(t, want.HttpStatusCode, e.HttpStatusCode)
+	require.Equal(t, want.InternalStatusCode, e.InternalStatusCode)
+	require.Equal(t, want.Message, e.Message)
+	return e
+}
+
+func TestNew(t *testing.T) {
+	_, err := client.New("localhost:8080")
+	require.Error(t, err)
+
+	_, err = client.New("://")
+	require.Error(t, err)
+
+	_, err = client.New("https://weathercock.example.com/")
+	require.NoError(t, err)
+}
+
+func TestCreateTaskFromURL(t *testing.T) {
+	f := newFixture(t)
+	f.db.on("InsertUserTask", func(args []any) ([]any, error) {
+		require.Equal(t, models.SourceTypeUrl, args[0])
+		return []any{taskID}, nil
+	})
+
+	id, err := f.c.CreateTaskFromURL(context.Background(), "https://tw.news.yahoo.com/news-071647696.html")
+	require.NoError(t, err)
+	require.Equal(t, taskID, id)
+	// dual published on the legacy subject during the migration
+	require.Equal(t, []string{workers.SubjectCmd(workers.StageScrape), workers.TaskScrape}, f.js.subjects)
+	require.Equal(t, 1, f.db.called("IncrementCounter"))
+
+	_, err = f.c.CreateTaskFromURL(context.Background(), "https://example.com/news.html")
+	e := requireError(t, ec.ErrBadRequest, err)
+	require.Contains(t, e.Details, "only support Yahoo news URL (tw.news.yahoo.com)")
+	require.Equal(t, 1, f.db.called("InsertUserTask"))
+}
+
+func TestCreateTaskFromText(t *testing.T) {
+	f := newFixture(t)
+
+	_, err := f.c.CreateTaskFromText(context.Background(), "DROP TABLE articles;")
+	requireError(t, ec.ErrContentContainsMaliciousPrompt, err)
+
+	_, err = f.c.CreateTaskFromText(context.Background(), "  \n ")
+	requireError(t, ec.ErrNoContent, err)
+	require.Zero(t, f.db.called("InsertUserTask"))
+}
+
+func TestCreateTaskIsNotRetried(t *testing.T) {
+	f := newFixture(t)
+	f.db.fail("InsertUserTask", 1)
+
+	_, err := f.c.CreateTaskFromURL(context.Background(), "https://tw.news.yahoo.com/news-071647696.html")
+	requireError(t, ec.ErrDBError, err)
+	require.Equal(t, 1, f.db.called("InsertUserTask"))
+	require.Empty(t, f.js.subjects)
+}
+
+func TestRecoverTask(t *testing.T) {
+	opts := intake.DefaultOptions(t.TempDir())
+	opts.MaxBodySize = 256
+	opts.Fsync = intake.FsyncNever
+	journal, err := intake.Open(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = journal.Close() })
+
+	f := newJournaledFixture(t, journal)
+	f.db.fail("InsertUserTask", 1)
+	f.db.on("InsertUserTask", func(args []any) ([]any, error) {
+		return []any{taskID}, nil
+	})
+
+	rawURL := "https://tw.news.yahoo.com/news-071647696.html"
+	_, err = f.c.CreateTaskFromURL(context.Background(), rawURL)
+	requireError(t, ec.ErrDBError, err)
+	intakeID, ok := client.IntakeID(err)
+	require.True(t, ok)
+
+	pending := journal.Pending(0)
+	require.Len(t, pending, 1)
+	require.Equal(t, intakeID, pending[0].ID)
+	require.Equal(t, apischema.PathTaskFromURL, pending[0].Endpoint)
+
+	id, err := f.c.RecoverTask(context.Background(), intakeID)
+	require.NoError(t, err)
+	require.Equal(t, taskID, id)
+	require.Equal(t, []string{workers.SubjectCmd(workers.StageScrape), workers.TaskScrape}, f.js.subjects)
+	require.Equal(t, 2, f.db.called("InsertUserTask"))
+	require.Empty(t, journal.Pending(0))
+
+	_, err = f.c.RecoverTask(context.Background(), intakeID)
+	requireError(t, ec.ErrNotFound, err)
+
+	// a rejected submission would be rejected again, it is not kept
+	_, err = f.c.CreateTaskFromURL(context.Background(), "https://example.com/news.html")
+	requireError(t, ec.ErrBadRequest, err)
+	_, ok = client.IntakeID(err)
+	require.False(t, ok)
+	require.Empty(t, journal.Pending(0))
+
+	_, err = f.c.CreateTaskFromText(context.Background(), strings.Repeat("長", 256))
+	requireError(t, ec.ErrPayloadTooLarge, err)
+	require.Empty(t, journal.Pending(0))
+	require.Equal(t, 2, f.db.called("InsertUserTask"))
+}
+
+func TestGetTask(t *testing.T) {
+	f := newFixture(t)
+	task := models.UsersTask{
+		ID:            7,
+		TaskID:        taskID,
+		Source:        models.SourceTypeUrl,
+		OriginalInput: "https://tw.news.yahoo.com/news-071647696.html",
+		Status:        models.TaskStatusPending,
+		CreatedAt:     created,
+		UpdatedAt:     created,
+	}
+	f.db.on("GetUserTask", func(args []any) ([]any, error) {
+		require.Equal(t, taskID, args[0])
+		return []any{task}, nil
+	})
+
+	got, err := f.c.GetTask(context.Background(), taskID)
+	require.NoError(t, err)
+	require.Equal(t, task.ID, got.ID)
+	require.Equal(t, task.TaskID, got.TaskID)
+	require.Equal(t, task.Source, got.Source)
+	require.Equal(t, task.OriginalInput, got.OriginalInput)
+	require.Equal(t, task.Status, got.Status)
+	require.True(t, task.CreatedAt.Time.Equal(got.CreatedAt.Time))
+}
+
+// getJSON gets the JSON object at path in the given locale, bypassing the
+// client which does not decode the labels.
+func getJSON(t *testing.T, f fixture, path, acceptLanguage string) map[string]any {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, f.url+path, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Language", acceptLanguage)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return body
+}
+
+func TestLocalizedLabels(t *testing.T) {
+	f := newFixture(t)
+	f.db.on("GetUserTask", func(args []any) ([]any, error) {
+		return []any{models.UsersTask{TaskID: taskID, Source: models.SourceTypeUrl, Status: models.TaskStatusProcessing}}, nil
+	})
+	f.db.on("ListArticlesWithURLStatus", func(args []any) ([]any, error) {
+		return []any{models.ListArticlesWithURLStatusRow{ID: 1, Party: models.PartyTPP, PublishedAt: created}}, nil
+	})
+
+	tcs := []struct {
+		acceptLanguage string
+		status         string
+		party          string
+	}{
+		{acceptLanguage: "zh-TW,zh;q=0.9", status: "處理中", party: "台灣民眾黨"},
+		{acceptLanguage: "en-US", status: "Processing", party: "Taiwan People's Party"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.acceptLanguage, func(t *testing.T) {
+			task := getJSON(t, f, apischema.Path(apischema.PathTask, "task_id", taskID.String()), tc.acceptLanguage)
+			require.Equal(t, "processing", task["status"])
+			require.Equal(t, tc.status, task["status_label"])
+
+			articles := getJSON(t, f, apischema.PathArticles, tc.acceptLanguage)["articles"].([]any)
+			require.Len(t, articles, 1)
+			require.Equal(t, "TPP", articles[0].(map[string]any)["party"])
+			require.Equal(t, tc.party, articles[0].(map[string]any)["party_label"])
+		})
+	}
+
+	// the client ignores the labels
+	got, err := f.c.GetTask(context.Background(), taskID)
+	require.NoError(t, err)
+	require.Equal(t, models.TaskStatusProcessing, got.Status)
+}
+
+func TestTaskTimeline(t *testing.T) {
+	f := newFixture(t)
+	f.db.on("GetUserTask", func(args []any) ([]any, error) {
+		return []any{models.UsersTask{TaskID: taskID, Status: models.TaskStatusPending}}, nil
+	})
+	f.db.on("ListTaskEvents", func(args []any) ([]any, error) {
+		return []any{
+			models.UsersTaskEvent{ID: 1, TaskID: taskID, Stage: "scrape", Status: models.TaskStatusPending, CreatedAt: created},
+			models.UsersTaskEvent{ID: 2, TaskID: taskID, Stage: "scrape", Status: models.TaskStatusDone, CreatedAt: created},
+		}, nil
+	})
+
+	got, err := f.c.TaskTimeline(context.Background(), taskID)
+	require.NoError(t, err)
+	require.Equal(t, taskID, got.TaskID)
+	require.Equal(t, models.TaskStatusPending, got.Status)
+	require.False(t, got.Summarized)
+	require.Len(t, got.Events, 2)
+	require.Equal(t, "scrape", got.Events[1].Stage)
+	require.Equal(t, models.TaskStatusDone, got.Events[1].Status)
+}
+
+// articles serves n articles, the newest first.
+func articles(n int) result {
+	return func(args []any) ([]any, error) {
+		limit, offset := int(args[2].(int32)), int(args[3].(int32))
+		var rows []any
+		for i := offset; i < min(offset+limit, n); i++ {
+			rows = append(rows, models.ListArticlesWithURLStatusRow{
+				ID:          int32(n - i),
+				Title:       fmt.Sprintf("article %d", n-i),
+				Url:         fmt.Sprintf("https://tw.news.yahoo.com/%d.html", n-i),
+				Source:      "台視新聞網",
+				Party:       models.PartyKMT,
+				PublishedAt: created,
+			})
+		}
+		return rows, nil
+	}
+}
+
+func TestListArticles(t *testing.T) {
+	f := newFixture(t)
+	f.db.on("ListArticlesWithURLStatus", func(args []any) ([]any, error) {
+		require.Equal(t, pgtype.Bool{Bool: false, Valid: true}, args[0])
+		require.Equal(t, []string{"罷免", "颱風"}, args[1])
+		return articles(45)(args)
+	})
+
+	dead := false
+	got, err := f.c.ListArticles(context.Background(), apischema.ArticleFilter{
+		Dead:   &dead,
+		Query:  "罷免 颱風",
+		Limit:  10,
+		Offset: 5,
+	})
+	require.NoError(t, err)
+	require.Len(t, got, 10)
+	require.Equal(t, int32(40), got[0].ID)
+	require.Equal(t, "article 40", got[0].Title)
+	require.Equal(t, models.PartyKMT, got[0].Party)
+	require.Nil(t, got[0].Score)
+
+	_, err = f.c.ListArticles(context.Background(), apischema.ArticleFilter{Sort: "oldest"})
+	requireError(t, ec.ErrBadRequest, err)
+}
+
+func TestListArticlesAll(t *testing.T) {
+	f := newFixture(t)
+	f.db.on("ListArticlesWithURLStatus", articles(45))
+
+	var sizes []int
+	var ids []int32
+	err := f.c.ListArticlesAll(context.Background(), apischema.ArticleFilter{Limit: 20},
+		func(page []apischema.ArticleListItem) error {
+			sizes = append(sizes, len(page))
+			for _, a := range page {
+				ids = append(ids, a.ID)
+			}
+			return nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, []int{20, 20, 5}, sizes)
+	require.Len(t, ids, 45)
+	require.Equal(t, int32(45), ids[0])
+	require.Equal(t, int32(1), ids[44])
+
+	// the error of the callback stops the paging
+	calls := f.db.called("ListArticlesWithURLStatus")
+	errStop := fmt.Errorf("stop")
+	err = f.c.ListArticlesAll(context.Background(), apischema.ArticleFilter{Limit: 20},
+		func(page []apischema.ArticleListItem) error {
+			return errStop
+		})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, calls+1, f.db.called("ListArticlesWithURLStatus"))
+}
+
+func TestKeywordAnomalies(t *testing.T) {
+	f := newFixture(t)
+	f.db.on("ListKeywordAnomaliesSince", func(args []any) ([]any, error) {
+		since := args[0].(pgtype.Date)
+		require.Equal(t, "2025-06-01", since.Time.Format(time.DateOnly))
+		require.Equal(t, int32(5), args[1])
+		return []any{models.ListKeywordAnomaliesSinceRow{
+			KeywordID: 1,
+			Term:      "罷免",
+			Lang:      "zh",
+			Date:      pgtype.Date{Time: created.Time, Valid: true},
+			Count:     30,
+			Zscore:    4.2,
+		}}, nil
+	})
+
+	got, err := f.c.KeywordAnomalies(context.Background(), time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), 5)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t, "罷免", got[0].Term)
+	require.Equal(t, int32(30), got[0].Count)
+	require.InDelta(t, 4.2, got[0].Zscore, 1e-9)
+}
+
+func TestSourceWeights(t *testing.T) {
+	f := newFixture(t)
+	f.db.on("ListSourceWeights", func(args []any) ([]any, error) {
+		return []any{models.SourceWeight{Source: "台視新聞網", Weight: 0.8, UpdatedBy: "editor", UpdatedAt: created}}, nil
+	})
+	f.db.on("UpsertSourceWeight", func(args []any) ([]any, error) {
+		return []any{models.SourceWeight{
+			Source:    args[0].(string),
+			Weight:    args[1].(float64),
+			UpdatedBy: args[2].(string),
+			UpdatedAt: created,
+		}}, nil
+	})
+
+	weights, err := f.c.SourceWeights(context.Background())
+	require.NoError(t, err)
+	require.Len(t, weights, 1)
+	require.Equal(t, "台視新聞網", weights[0].Source)
+	require.InDelta(t, 0.8, weights[0].Weight, 1e-9)
+
+	sw, err := f.c.SetSourceWeight(context.Background(), "中央社", 0.9, "editor")
+	require.NoError(t, err)
+	require.Equal(t, "中央社", sw.Source)
+	require.InDelta(t, 0.9, sw.Weight, 1e-9)
+	require.Equal(t, "editor", sw.UpdatedBy)
+
+	_, err = f.c.SetSourceWeight(context.Background(), "中央社", 1.5, "editor")
+	requireError(t, ec.ErrValidationFailed, err)
+}
+
+func TestReconciliationReports(t *testing.T) {
+	f := newFixture(t)
+	f.db.on("ListReconciliationReports", func(args []any) ([]any, error) {
+		require.Equal(t, int32(5), args[0])
+		return []any{models.ReconciliationReport{
+			ID:         2,
+			Since:      created,
+			LastTaskID: 42,
+			Checked:    40,
+			Sampled:    4,
+			Counts:     []byte(`{"cache_missing":2,"hash_mismatch":1}`),
+			Repaired:   2,
+			Flagged:    1,
+			StartedAt:  created,
+			UpdatedAt:  created,
+			FinishedAt: created,
+		}}, nil
+	})
+
+	reports, err := f.c.ReconciliationReports(context.Background(), 5)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	require.Equal(t, map[string]int32{"cache_missing": 2, "hash_mismatch": 1}, reports[0].Counts)
+	require.Equal(t, int32(2), reports[0].Repaired)
+	require.NotNil(t, reports[0].FinishedAt)
+	require.True(t, created.Time.Equal(*reports[0].FinishedAt))
+
+	_, err = f.c.ReconciliationReports(context.Background(), 500)
+	requireError(t, ec.ErrBadRequest, err)
+}
+
+func TestEditorToken(t *testing.T) {
+	f := newFixture(t, client.WithEditorToken(""))
+	_, err := f.c.SourceWeights(context.Background())
+	e := requireError(t, ec.ErrUnauthorized, err)
+	require.Equal(t, []string{"missing editor token"}, e.Details)
+
+	f = newFixture(t, client.WithEditorToken("guess"))
+	_, err = f.c.SetSourceWeight(context.Background(), "中央社", 0.9, "editor")
+	requireError(t, ec.ErrForbidden, err)
+	require.Zero(t, f.db.called("UpsertSourceWeight"))
+}
+
+func TestRetry(t *testing.T) {
+	f := newFixture(t)
+	f.db.on("ListSourceWeights", func(args []any) ([]any, error) {
+		return []any{models.SourceWeight{Source: "台視新聞網", Weight: 0.8}}, nil
+	})
+
+	f.db.fail("ListSourceWeights", 2)
+	weights, err := f.c.SourceWeights(context.Background())
+	require.NoError(t, err)
+	require.Len(t, weights, 1)
+	require.Equal(t, 3, f.db.called("ListSourceWeights"))
+
+	f.db.fail("ListSourceWeights", 3)
+	_, err = f.c.SourceWeights(context.Background())
+	requireError(t, ec.ErrDBError, err)
+	require.Equal(t, 6, f.db.called("ListSourceWeights"))
+
+	// a client error is not retried
+	_, err = f.c.ListArticles(context.Background(), apischema.ArticleFilter{Sort: "oldest"})
+	requireError(t, ec.ErrBadRequest, err)
+}
+
+func TestContextCanceled(t *testing.T) {
+	f := newFixture(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := f.c.SourceWeights(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, f.db.called("ListSourceWeights"))
+}
@@ -0,0 +1,222 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nats-io/nats.go"
+)
+
+// result returns the rows of a query given its arguments. The rows are the
+// structs the query scans into, or the value for a single column.
+type result func(args []any) ([]any, error)
+
+// fakeDB is a storage.DB answering the sqlc queries by their name.
+type fakeDB struct {
+	mu      sync.Mutex
+	results map[string]result
+	calls   map[string]int
+	// failures is the number of calls of a query failing before it succeeds.
+	failures map[string]int
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		results:  map[string]result{},
+		calls:    map[string]int{},
+		failures: map[string]int{},
+	}
+}
+
+// on sets the result of the query named name.
+func (db *fakeDB) on(name string, fn result) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.results[name] = fn
+}
+
+// fail makes the next n calls of the query named name fail.
+func (db *fakeDB) fail(name string, n int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.failures[name] = n
+}
+
+func (db *fakeDB) called(name string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.calls[name]
+}
+
+// queryName returns the name of a sqlc query, from its "-- name: X :kind"
+// first line.
+func queryName(sql string) string {
+	line, _, _ := strings.Cut(sql, "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[1] != "name:" {
+		return ""
+	}
+	return fields[2]
+}
+
+func (db *fakeDB) run(sql string, args []any) ([]any, error) {
+	name := queryName(sql)
+	db.mu.Lock()
+	db.calls[name]++
+	if db.failures[name] > 0 {
+		db.failures[name]--
+		db.mu.Unlock()
+		return nil, errors.New("connection reset by peer")
+	}
+	fn, ok := db.results[name]
+	db.mu.Unlock()
+
+	if !ok {
+		return nil, nil
+	}
+	return fn(args)
+}
+
+func (db *fakeDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	_, err := db.run(sql, args)
+	return pgconn.NewCommandTag("UPDATE 1"), err
+}
+
+func (db *fakeDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	rows, err := db.run(sql, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows, i: -1}, nil
+}
+
+func (db *fakeDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	rows, err := db.run(sql, args)
+	if err == nil && len(rows) == 0 {
+		err = pgx.ErrNoRows
+	}
+
+	if err != nil {
+		return fakeRow{err: err}
+	}
+	return fakeRow{v: rows[0]}
+}
+
+func (db *fakeDB) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	panic("fakeDB: SendBatch is not supported")
+}
+
+func (db *fakeDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return fakeTx{db: db}, nil
+}
+
+func (db *fakeDB) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	return fakeTx{db: db}, nil
+}
+
+// fakeTx runs its queries on db, it implements the methods the storage uses.
+type fakeTx struct {
+	pgx.Tx
+	db *fakeDB
+}
+
+func (tx fakeTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return tx.db.Exec(ctx, sql, args...)
+}
+
+func (tx fakeTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return tx.db.Query(ctx, sql, args...)
+}
+
+func (tx fakeTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return tx.db.QueryRow(ctx, sql, args...)
+}
+
+func (tx fakeTx) Commit(ctx context.Context) error   { return nil }
+func (tx fakeTx) Rollback(ctx context.Context) error { return nil }
+
+// scan copies v into dest: v itself for a single column, its fields in order
+// otherwise, as sqlc scans them.
+func scan(v any, dest []any) error {
+	if len(dest) == 1 {
+		return assign(dest[0], reflect.ValueOf(v))
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Struct || rv.NumField() != len(dest) {
+		return fmt.Errorf("fakeDB: cannot scan %T into %d columns", v, len(dest))
+	}
+
+	for i := range dest {
+		if err := assign(dest[i], rv.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func assign(dest any, v reflect.Value) error {
+	d := reflect.ValueOf(dest).Elem()
+	if !v.Type().AssignableTo(d.Type()) {
+		return fmt.Errorf("fakeDB: cannot scan %s into %s", v.Type(), d.Type())
+	}
+	d.Set(v)
+	return nil
+}
+
+type fakeRow struct {
+	v   any
+	err error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	return scan(r.v, dest)
+}
+
+type fakeRows struct {
+	rows []any
+	i    int
+}
+
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.NewCommandTag("SELECT") }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+
+func (r *fakeRows) Next() bool {
+	r.i++
+	return r.i < len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	return scan(r.rows[r.i], dest)
+}
+
+func (r *fakeRows) Values() ([]any, error) {
+	return nil, errors.New("fakeRows: Values is not supported")
+}
+
+// fakeJetStream records the subjects of the published messages.
+type fakeJetStream struct {
+	nats.JetStreamContext
+	mu       sync.Mutex
+	subjects []string
+}
+
+func (js *fakeJetStream) PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.subjects = append(js.subjects, m.Subject)
+	return &nats.PubAck{Stream: "TASKS", Sequence: uint64(len(js.subjects))}, nil
+}
@@ -0,0 +1,133 @@
+package clockid_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch = time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+// fired returns the time received from c, if any.
+func fired(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeNow(t *testing.T) {
+	c := clockid.NewFake(epoch)
+	require.Equal(t, epoch, c.Now())
+
+	c.Advance(90 * time.Second)
+	require.Equal(t, epoch.Add(90*time.Second), c.Now())
+	require.Equal(t, 90*time.Second, c.Since(epoch))
+
+	c.Set(epoch.Add(time.Hour))
+	require.Equal(t, epoch.Add(time.Hour), c.Now())
+}
+
+func TestFakeTimer(t *testing.T) {
+	c := clockid.NewFake(epoch)
+	timer := c.NewTimer(10 * time.Second)
+	require.Equal(t, 1, c.Waiters())
+
+	c.Advance(9 * time.Second)
+	_, ok := fired(timer.C())
+	require.False(t, ok)
+
+	c.Advance(5 * time.Second)
+	at, ok := fired(timer.C())
+	require.True(t, ok)
+	require.Equal(t, epoch.Add(10*time.Second), at)
+	require.Zero(t, c.Waiters())
+	require.False(t, timer.Stop())
+
+	// a stopped timer does not fire
+	require.False(t, timer.Reset(time.Second))
+	require.True(t, timer.Stop())
+	c.Advance(time.Minute)
+	_, ok = fired(timer.C())
+	require.False(t, ok)
+}
+
+func TestFakeTicker(t *testing.T) {
+	c := clockid.NewFake(epoch)
+	ticker := c.NewTicker(time.Minute)
+
+	for i := 1; i <= 3; i++ {
+		c.Advance(time.Minute)
+		at, ok := fired(ticker.C())
+		require.True(t, ok)
+		require.Equal(t, epoch.Add(time.Duration(i)*time.Minute), at)
+	}
+
+	// the ticks nobody receives are dropped
+	c.Advance(5 * time.Minute)
+	at, ok := fired(ticker.C())
+	require.True(t, ok)
+	require.Equal(t, epoch.Add(4*time.Minute), at)
+	_, ok = fired(ticker.C())
+	require.False(t, ok)
+
+	ticker.Reset(time.Hour)
+	c.Advance(time.Minute)
+	_, ok = fired(ticker.C())
+	require.False(t, ok)
+	c.Advance(time.Hour)
+	_, ok = fired(ticker.C())
+	require.True(t, ok)
+
+	ticker.Stop()
+	require.Zero(t, c.Waiters())
+}
+
+func TestFakeOrder(t *testing.T) {
+	c := clockid.NewFake(epoch)
+	late := c.NewTimer(2 * time.Second)
+	early := c.NewTimer(time.Second)
+
+	c.Advance(3 * time.Second)
+	lateAt, ok := fired(late.C())
+	require.True(t, ok)
+	earlyAt, ok := fired(early.C())
+	require.True(t, ok)
+	require.True(t, earlyAt.Before(lateAt))
+	require.Equal(t, epoch.Add(3*time.Second), c.Now())
+}
+
+func TestSleep(t *testing.T) {
+	c := clockid.NewFake(epoch)
+	done := make(chan error)
+	go func() {
+		done <- clockid.Sleep(context.Background(), c, time.Hour)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Hour)
+	require.NoError(t, <-done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- clockid.Sleep(ctx, c, time.Hour)
+	}()
+	c.BlockUntil(1)
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestSequence(t *testing.T) {
+	var s clockid.Sequence
+	first := s.NewUUID()
+	require.Equal(t, "00000000-0000-4000-8000-000000000001", first.String())
+	require.Equal(t, uuid.Version(4), first.Version())
+	require.Equal(t, uuid.RFC4122, first.Variant())
+	require.Equal(t, "00000000-0000-4000-8000-000000000002", s.NewUUID().String())
+}
@@ -0,0 +1,44 @@
+package clockid
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// IDGen generates the UUIDs of the tasks and the events.
+type IDGen interface {
+	NewUUID() uuid.UUID
+}
+
+// IDGenFunc adapts a function to an IDGen.
+type IDGenFunc func() uuid.UUID
+
+func (f IDGenFunc) NewUUID() uuid.UUID {
+	return f()
+}
+
+// Random is the IDGen of the random (version 4) UUIDs.
+var Random IDGen = IDGenFunc(uuid.New)
+
+// Sequence is an IDGen of the UUIDs counting from 1, laid out as version 4
+// UUIDs so that they pass the same validation, e.g. the n-th one is
+// 00000000-0000-4000-8000-00000000000n. The zero value is ready to use.
+type Sequence struct {
+	mu sync.Mutex
+	n  uint64
+}
+
+func (s *Sequence) NewUUID() uuid.UUID {
+	s.mu.Lock()
+	s.n++
+	n := s.n
+	s.mu.Unlock()
+
+	var id uuid.UUID
+	binary.BigEndian.PutUint64(id[8:], n)
+	id[6] = 0x40
+	id[8] = 0x80 | id[8]&0x3f
+	return id
+}
@@ -25,8 +25,11 @@ const (
 const (
 	ECBadRequest      = http.StatusBadRequest
 	ECUnauthorized    = http.StatusUnauthorized
+	ECForbidden       = http.StatusForbidden
+	ECConflict        = http.StatusConflict
 	ECNoContent       = http.StatusNoContent
 	ECTooManyRequests = http.StatusTooManyRequests
+	ECPayloadTooLarge = http.StatusRequestEntityTooLarge
 )
 
 // HTTP 500 - 599: Server errors
@@ -48,6 +51,16 @@ const (
 	ECIntegrityConstrainViolation
 	ECTransactionRollback
 	ECDatabaseTypeConversionError
+	ECSchemaMismatch
+)
+
+// the SQLSTATEs a caller branches on, see storage's handlePgxErr
+const (
+	ECUniqueViolation = iota + 570
+	ECForeignKeyViolation
+	ECNotNullViolation
+	ECValueTooLong
+	ECSerializationFailure
 )
 
 const (
@@ -74,6 +87,10 @@ var (
 	ErrInternalServerError            = NewWithHTTPStatus(http.StatusInternalServerError, ECInternalServerError, "internal server error")
 	ErrInvalidConfig                  = NewWithHTTPStatus(http.StatusInternalServerError, ECValidationError, "invalid configuration")
 	ErrBadRequest                     = NewWithHTTPStatus(http.StatusBadRequest, ECBadRequest, "bad request")
+	ErrUnauthorized                   = NewWithHTTPStatus(http.StatusUnauthorized, ECUnauthorized, "unauthorized")
+	ErrForbidden                      = NewWithHTTPStatus(http.StatusForbidden, ECForbidden, "forbidden")
+	ErrConflict                       = NewWithHTTPStatus(http.StatusConflict, ECConflict, "conflict")
+	ErrPayloadTooLarge                = NewWithHTTPStatus(http.StatusRequestEntityTooLarge, ECPayloadTooLarge, "payload too large")
 	ErrContentContainsMaliciousPrompt = NewWithHTTPStatus(http.StatusBadRequest, ECLLMMaliciousPrompt, "content contains malicious prompt")
 	ErrNoContent                      = NewWithHTTPStatus(http.StatusNoContent, ECNoContent, "no content available")
 	ErrValidationFailed               = NewWithHTTPStatus(http.StatusBadRequest, ECValidationError, "validation failed")
@@ -82,6 +99,12 @@ var (
 	ErrDBIntegrityConstrainViolation  = NewWithHTTPStatus(http.StatusConflict, ECIntegrityConstrainViolation, "integrity constraint violation")
 	ErrDBTransactionRollback          = NewWithHTTPStatus(http.StatusInternalServerError, ECTransactionRollback, "transaction rollback error")
 	ErrDBTypeConversionError          = NewWithHTTPStatus(http.StatusInternalServerError, ECDatabaseTypeConversionError, "database type conversion error")
+	ErrSchemaMismatch                 = NewWithHTTPStatus(http.StatusServiceUnavailable, ECSchemaMismatch, "schema_mismatch")
+	ErrDBUniqueViolation              = NewWithHTTPStatus(http.StatusConflict, ECUniqueViolation, "unique violation")
+	ErrDBForeignKeyViolation          = NewWithHTTPStatus(http.StatusConflict, ECForeignKeyViolation, "foreign key violation")
+	ErrDBNotNullViolation             = NewWithHTTPStatus(http.StatusBadRequest, ECNotNullViolation, "not null violation")
+	ErrDBValueTooLong                 = NewWithHTTPStatus(http.StatusBadRequest, ECValueTooLong, "value too long")
+	ErrDBSerializationFailure         = NewWithHTTPStatus(http.StatusServiceUnavailable, ECSerializationFailure, "serialization failure")
 	ErrNATSServerError                = NewWithHTTPStatus(http.StatusInternalServerError, ECNATSServerError, "NATS server error")
 	ErrNATSConnectionFailed           = NewWithHTTPStatus(http.StatusServiceUnavailable, ECNATSConnectionFailed, "NATS is not connected")
 	ErrNATSMsgPublishFailed           = NewWithHTTPStatus(http.StatusInternalServerError, ECNATSJsPublishFailed, "falied to publish message")
@@ -187,6 +210,17 @@ func (e *Error) Unwrap() error {
 	return e.internal
 }
 
+// Is reports whether target is an *Error of the same codes, so that a clone
+// of an error, e.g. the ErrDBUniqueViolation returned by the storage, matches
+// it with errors.Is.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || e == nil || t == nil {
+		return false
+	}
+	return e.InternalStatusCode == t.InternalStatusCode && e.HttpStatusCode == t.HttpStatusCode
+}
+
 func (e Error) ToHTTPError() *HTTPError {
 	return &HTTPError{
 		StatusCode: e.InternalStatusCode,
@@ -8,10 +8,12 @@ import (
 )
 
 type PGErr struct {
-	Code     string `json:"code"`
-	Message  string `json:"message"`
-	Severity string `json:"severity"`
-	Details  string `json:"details"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Severity   string `json:"severity"`
+	Details    string `json:"details"`
+	Constraint string `json:"constraint,omitempty"`
+	Column     string `json:"column,omitempty"`
 }
 
 func (p PGErr) String() string {
@@ -33,10 +35,12 @@ func NewPGErr(err error) (*PGErr, bool) {
 	}
 
 	return &PGErr{
-		Code:     pgErr.Code,
-		Message:  pgErr.Message,
-		Severity: pgErr.Severity,
-		Details:  pgErr.Detail,
+		Code:       pgErr.Code,
+		Message:    pgErr.Message,
+		Severity:   pgErr.Severity,
+		Details:    pgErr.Detail,
+		Constraint: pgErr.ConstraintName,
+		Column:     pgErr.ColumnName,
 	}, true
 }
 
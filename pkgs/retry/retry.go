@@ -0,0 +1,144 @@
+// Package retry retries an operation with an exponential backoff.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+)
+
+const (
+	DefaultMaxAttempts = 3
+	DefaultMinDelay    = 200 * time.Millisecond
+	DefaultMaxDelay    = 5 * time.Second
+)
+
+// Policy is how an operation is retried. The delay before the n-th retry is
+// MinDelay doubled n-1 times, capped at MaxDelay, with up to Jitter of it
+// taken off at random.
+type Policy struct {
+	// MaxAttempts is the number of attempts including the first one, 1 never
+	// retries.
+	MaxAttempts int
+	MinDelay    time.Duration
+	MaxDelay    time.Duration
+	// Jitter is the fraction of the delay taken off at random, in [0, 1].
+	Jitter float64
+	// Clock is the clock the delays are waited on, the real one if nil.
+	Clock clockid.Clock
+}
+
+// DefaultPolicy returns the policy of three attempts 200ms then 400ms apart,
+// with a jitter of a half.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts: DefaultMaxAttempts,
+		MinDelay:    DefaultMinDelay,
+		MaxDelay:    DefaultMaxDelay,
+		Jitter:      0.5,
+	}
+}
+
+// Never is the policy of a single attempt.
+var Never = Policy{MaxAttempts: 1}
+
+// Delay returns the delay before the n-th retry, n starting at 1.
+func (p Policy) Delay(n int) time.Duration {
+	d := p.MinDelay
+	for i := 1; i < n && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if p.Jitter > 0 && d > 0 {
+		d -= time.Duration(rand.Float64() * min(p.Jitter, 1) * float64(d))
+	}
+	return d
+}
+
+// Do calls fn until it succeeds, it returns a permanent error, see Permanent,
+// or MaxAttempts attempts have been made. It returns the last error of fn, or
+// the error of ctx if ctx is done while waiting. An error of After delays the
+// next attempt by at least its delay.
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	clock := p.Clock
+	if clock == nil {
+		clock = clockid.Real
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(ctx); err == nil {
+			return nil
+		}
+
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+
+		delay := p.Delay(attempt)
+		if after, ok := err.(*afterError); ok {
+			err, delay = after.err, max(delay, after.delay)
+		}
+
+		if attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		if serr := clockid.Sleep(ctx, clock, delay); serr != nil {
+			return err
+		}
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps err so that Do returns it without retrying. Do returns err
+// itself, not the wrapper.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+type afterError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *afterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *afterError) Unwrap() error {
+	return e.err
+}
+
+// After wraps err so that Do waits at least delay before the next attempt,
+// e.g. the delay asked for by the Retry-After header of a response. Do
+// returns err itself, not the wrapper. It returns err if delay is not
+// positive.
+func After(err error, delay time.Duration) error {
+	if err == nil || delay <= 0 {
+		return err
+	}
+	return &afterError{err: err, delay: delay}
+}
@@ -0,0 +1,133 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ChiaYuChang/weathercock/pkgs/clockid"
+	"github.com/ChiaYuChang/weathercock/pkgs/retry"
+	"github.com/stretchr/testify/require"
+)
+
+var errFlaky = errors.New("flaky")
+
+func TestDelay(t *testing.T) {
+	p := retry.Policy{MinDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	require.Equal(t, 100*time.Millisecond, p.Delay(1))
+	require.Equal(t, 200*time.Millisecond, p.Delay(2))
+	require.Equal(t, 800*time.Millisecond, p.Delay(4))
+	require.Equal(t, time.Second, p.Delay(5))
+	require.Equal(t, time.Second, p.Delay(100))
+
+	p.Jitter = 0.5
+	for range 100 {
+		d := p.Delay(2)
+		require.GreaterOrEqual(t, d, 100*time.Millisecond)
+		require.LessOrEqual(t, d, 200*time.Millisecond)
+	}
+}
+
+func TestDo(t *testing.T) {
+	clock := clockid.NewFake(time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC))
+	p := retry.Policy{MaxAttempts: 3, MinDelay: time.Second, MaxDelay: time.Minute, Clock: clock}
+
+	go func() {
+		// the delays before the second and the third attempt
+		for range 2 {
+			clock.BlockUntil(1)
+			clock.Advance(time.Minute)
+		}
+	}()
+
+	calls := 0
+	err := p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errFlaky
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+}
+
+func TestDoGivesUp(t *testing.T) {
+	p := retry.Policy{MaxAttempts: 2}
+
+	calls := 0
+	err := p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return errFlaky
+	})
+	require.ErrorIs(t, err, errFlaky)
+	require.Equal(t, 2, calls)
+
+	calls = 0
+	err = retry.Never.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return errFlaky
+	})
+	require.ErrorIs(t, err, errFlaky)
+	require.Equal(t, 1, calls)
+}
+
+func TestDoPermanent(t *testing.T) {
+	calls := 0
+	err := retry.DefaultPolicy().Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return retry.Permanent(errFlaky)
+	})
+	require.Equal(t, errFlaky, err)
+	require.Equal(t, 1, calls)
+	require.NoError(t, retry.Permanent(nil))
+}
+
+func TestDoCanceled(t *testing.T) {
+	clock := clockid.NewFake(time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC))
+	p := retry.Policy{MaxAttempts: 5, MinDelay: time.Hour, Clock: clock}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		clock.BlockUntil(1)
+		cancel()
+	}()
+
+	calls := 0
+	err := p.Do(ctx, func(ctx context.Context) error {
+		calls++
+		return errFlaky
+	})
+	require.ErrorIs(t, err, errFlaky)
+	require.Equal(t, 1, calls)
+}
+
+func TestDoAfter(t *testing.T) {
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	clock := clockid.NewFake(start)
+	p := retry.Policy{MaxAttempts: 3, MinDelay: time.Second, MaxDelay: time.Second, Clock: clock}
+
+	go func() {
+		// the second attempt waits the delay of After, the third the one of p
+		clock.BlockUntil(1)
+		clock.Advance(time.Minute)
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+	}()
+
+	var at []time.Duration
+	err := p.Do(context.Background(), func(ctx context.Context) error {
+		at = append(at, clock.Since(start))
+		if len(at) == 1 {
+			return retry.After(errFlaky, time.Minute)
+		}
+		return retry.After(errFlaky, time.Millisecond)
+	})
+	require.Equal(t, errFlaky, err)
+	require.Equal(t, []time.Duration{0, time.Minute, time.Minute + time.Second}, at)
+
+	require.Equal(t, errFlaky, retry.After(errFlaky, 0))
+	require.NoError(t, retry.After(nil, time.Second))
+	require.ErrorIs(t, retry.After(errFlaky, time.Second), errFlaky)
+}
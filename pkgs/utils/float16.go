@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Float16 quantization is used to store archived embeddings at half the size
+// of packed float32. Half precision keeps 11 significant bits, so a component
+// in the normal range [6.1e-5, 65504] has a relative rounding error of at most
+// 2^-11 (about 4.9e-4), and smaller components an absolute error of at most
+// 2^-25. For unit-normalized embeddings the cosine similarity of a quantized
+// vector is typically off by less than 1e-3, which only reorders results whose
+// scores are within that margin; recall@k of an exact re-scoring is otherwise
+// unaffected.
+
+// Float32ToFloat16 converts f to IEEE 754 half precision, rounding to the
+// nearest even value. Values out of range become infinities.
+func Float32ToFloat16(f float32) uint16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int32(b>>23) & 0xff
+	mant := b & 0x7fffff
+
+	if exp == 0xff {
+		if mant != 0 {
+			return sign | 0x7e00 // NaN
+		}
+		return sign | 0x7c00 // Inf
+	}
+
+	e := exp - 127 + 15
+	if e >= 0x1f {
+		return sign | 0x7c00
+	}
+
+	if e <= 0 {
+		// subnormal, or too small and rounded to zero
+		if e < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint32(14 - e)
+		m := mant >> shift
+		rem, half := mant&(1<<shift-1), uint32(1)<<(shift-1)
+		if rem > half || (rem == half && m&1 == 1) {
+			m++
+		}
+		return sign | uint16(m)
+	}
+
+	m := mant >> 13
+	rem := mant & 0x1fff
+	h := uint32(e)<<10 | m
+	if rem > 0x1000 || (rem == 0x1000 && m&1 == 1) {
+		h++ // may carry into the exponent, which is still correct
+	}
+	return sign | uint16(h)
+}
+
+// Float16ToFloat32 converts an IEEE 754 half precision value to float32.
+func Float16ToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | mant<<13)
+}
+
+// PackFloat16 quantizes v to half precision and packs it in little endian.
+func PackFloat16(v []float32) []byte {
+	b := make([]byte, 2*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint16(b[2*i:], Float32ToFloat16(f))
+	}
+	return b
+}
+
+// UnpackFloat16 reverses PackFloat16.
+func UnpackFloat16(b []byte) ([]float32, error) {
+	if len(b)%2 != 0 {
+		return nil, fmt.Errorf("packed float16 should have an even length, got: %d", len(b))
+	}
+
+	v := make([]float32, len(b)/2)
+	for i := range v {
+		v[i] = Float16ToFloat32(binary.LittleEndian.Uint16(b[2*i:]))
+	}
+	return v, nil
+}
+
+// CosineSimilarity returns the cosine similarity of a and b, or 0 if their
+// lengths differ or either of them is a zero vector.
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -27,3 +27,14 @@ func (t2 timeTo) PGTimestamp(t time.Time) (pgtype.Timestamp, error) {
 	ts.Valid = true
 	return ts, nil
 }
+
+// PGDate converts the calendar date of t, in the location of t, to a
+// pgtype.Date.
+func (t2 timeTo) PGDate(t time.Time) (pgtype.Date, error) {
+	var d pgtype.Date
+	if err := d.Scan(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)); err != nil {
+		return pgtype.Date{}, err
+	}
+	d.Valid = true
+	return d, nil
+}
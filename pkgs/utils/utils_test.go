@@ -2,6 +2,7 @@ package utils_test
 
 import (
 	"fmt"
+	"math"
 	"net/url"
 	"slices"
 	"strings"
@@ -326,3 +327,58 @@ func TestPtr(t *testing.T) {
 		})
 	}
 }
+
+func TestFloat16RoundTrip(t *testing.T) {
+	tcs := []struct {
+		Name string
+		In   float32
+		Out  float32
+	}{
+		{Name: "Zero", In: 0, Out: 0},
+		{Name: "One", In: 1, Out: 1},
+		{Name: "Negative", In: -2.5, Out: -2.5},
+		{Name: "Max", In: 65504, Out: 65504},
+		{Name: "Overflow", In: 1e6, Out: float32(math.Inf(1))},
+		{Name: "Smallest Subnormal", In: 1.0 / (1 << 24), Out: 1.0 / (1 << 24)},
+		{Name: "Underflow", In: 1e-9, Out: 0},
+		{Name: "Round To Nearest Even", In: 1 + 1.0/(1<<11), Out: 1},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Out, utils.Float16ToFloat32(utils.Float32ToFloat16(tc.In)))
+		})
+	}
+
+	t.Run("NaN", func(t *testing.T) {
+		nan := utils.Float16ToFloat32(utils.Float32ToFloat16(float32(math.NaN())))
+		require.True(t, math.IsNaN(float64(nan)))
+	})
+
+	t.Run("Error Bounds", func(t *testing.T) {
+		vec, err := utils.RandomPGVector(1024, 1.0, -1.0)
+		require.NoError(t, err)
+
+		packed := utils.PackFloat16(vec.Slice())
+		require.Len(t, packed, 2*1024)
+
+		unpacked, err := utils.UnpackFloat16(packed)
+		require.NoError(t, err)
+		require.Len(t, unpacked, 1024)
+
+		for i, v := range vec.Slice() {
+			diff := math.Abs(float64(v - unpacked[i]))
+			if math.Abs(float64(v)) >= 6.1e-5 {
+				require.LessOrEqual(t, diff, math.Abs(float64(v))/(1<<11), "component %d: %v", i, v)
+			} else {
+				require.LessOrEqual(t, diff, 1.0/(1<<25), "component %d: %v", i, v)
+			}
+		}
+		require.InDelta(t, 1.0, utils.CosineSimilarity(vec.Slice(), unpacked), 1e-3)
+	})
+
+	t.Run("Odd Length", func(t *testing.T) {
+		_, err := utils.UnpackFloat16([]byte{0x00})
+		require.Error(t, err)
+	})
+}
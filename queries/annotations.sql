@@ -0,0 +1,57 @@
+-- name: InsertAnnotation :one
+INSERT INTO annotations (
+    target_type,
+    target_id,
+    article_id,
+    "action",
+    replacement,
+    author
+) VALUES (
+    @target_type::annotation_target,
+    @target_id::text,
+    @article_id::integer,
+    @action::annotation_action,
+    sqlc.narg('replacement')::jsonb,
+    @author::text
+)
+RETURNING *;
+
+-- name: ListAnnotationsByArticleID :many
+SELECT * FROM annotations
+WHERE article_id = @article_id::integer
+ORDER BY created_at ASC, id ASC;
+
+-- name: ListLatestAnnotationsByArticleID :many
+SELECT * FROM latest_annotations
+WHERE article_id = @article_id::integer
+  AND target_type = @target_type::annotation_target
+ORDER BY target_id ASC;
+
+-- name: DeleteAnnotation :execrows
+DELETE FROM annotations
+WHERE id = @id::integer
+  AND article_id = @article_id::integer;
+
+-- name: GetEffectiveKeywordsByArticleID :many
+SELECT term FROM effective_articles_keywords
+WHERE article_id = @article_id::integer
+ORDER BY term ASC;
+
+-- name: GetTopKeywords :many
+SELECT e.term, COUNT(DISTINCT e.article_id)::integer AS article_count
+FROM effective_articles_keywords AS e
+JOIN articles AS a ON a.id = e.article_id
+WHERE a.published_at BETWEEN @start::timestamptz AND @end::timestamptz
+GROUP BY e.term
+ORDER BY article_count DESC, e.term ASC
+LIMIT sqlc.arg('limit')::integer;
+
+-- name: GetStanceCounts :many
+-- Counts the articles published within [start, end] by party and stance,
+-- with the stance annotations of the editors applied.
+SELECT es.party, es.stance, COUNT(*)::integer AS article_count
+FROM effective_article_stances AS es
+JOIN articles AS a ON a.id = es.article_id
+WHERE a.published_at BETWEEN @start::timestamptz AND @end::timestamptz
+GROUP BY es.party, es.stance
+ORDER BY es.party ASC, es.stance ASC;
@@ -0,0 +1,126 @@
+-- name: ListEmbeddingsToArchive :many
+-- The rows are locked until the end of the transaction, so concurrent
+-- archivers skip them instead of moving them twice.
+SELECT e.id,
+    e.article_id,
+    e.chunk_id,
+    e.model_id,
+    e.vector::vector AS vector,
+    e.created_at,
+    e.was_split,
+    e.sub_count
+FROM embeddings AS e
+    JOIN articles AS a ON a.id = e.article_id
+WHERE a.published_at < @cutoff::timestamptz
+ORDER BY e.id
+LIMIT @batch_size::integer FOR UPDATE OF e SKIP LOCKED;
+-- name: InsertEmbeddingsArchive :execrows
+INSERT INTO embeddings_archive (
+        id,
+        article_id,
+        chunk_id,
+        model_id,
+        dims,
+        vector,
+        created_at,
+        was_split,
+        sub_count
+    )
+SELECT u.id,
+    u.article_id,
+    u.chunk_id,
+    u.model_id,
+    u.dims,
+    u.vector,
+    u.created_at,
+    u.was_split,
+    u.sub_count
+FROM unnest(
+        @ids::integer [],
+        @article_ids::integer [],
+        @chunk_ids::integer [],
+        @model_ids::integer [],
+        @dims::integer [],
+        @vectors::bytea [],
+        @created_ats::timestamptz [],
+        @was_splits::boolean [],
+        @sub_counts::integer []
+    ) AS u(
+        id,
+        article_id,
+        chunk_id,
+        model_id,
+        dims,
+        vector,
+        created_at,
+        was_split,
+        sub_count
+    );
+-- name: DeleteEmbeddingsByIDs :execrows
+DELETE FROM embeddings
+WHERE id = ANY(@ids::integer []);
+-- name: ListArchivedEmbeddingsByArticleIDs :many
+SELECT *
+FROM embeddings_archive
+WHERE article_id = ANY(@article_ids::integer [])
+ORDER BY id FOR UPDATE;
+-- name: RestoreEmbeddings :execrows
+INSERT INTO embeddings (
+        id,
+        article_id,
+        chunk_id,
+        model_id,
+        vector,
+        created_at,
+        was_split,
+        sub_count
+    )
+SELECT u.id,
+    u.article_id,
+    u.chunk_id,
+    u.model_id,
+    u.vector::vector,
+    u.created_at,
+    u.was_split,
+    u.sub_count
+FROM unnest(
+        @ids::integer [],
+        @article_ids::integer [],
+        @chunk_ids::integer [],
+        @model_ids::integer [],
+        @vectors::text [],
+        @created_ats::timestamptz [],
+        @was_splits::boolean [],
+        @sub_counts::integer []
+    ) AS u(
+        id,
+        article_id,
+        chunk_id,
+        model_id,
+        vector,
+        created_at,
+        was_split,
+        sub_count
+    );
+-- name: DeleteArchivedEmbeddingsByIDs :execrows
+DELETE FROM embeddings_archive
+WHERE id = ANY(@ids::integer []);
+-- name: ListArchivedEmbeddingCandidates :many
+-- The candidate set is bounded by the model, the publishing date, and
+-- optionally the party, the vectors are re-scored by the caller.
+SELECT ea.id,
+    ea.article_id,
+    ea.chunk_id,
+    ea.vector,
+    a.published_at
+FROM embeddings_archive AS ea
+    JOIN articles AS a ON a.id = ea.article_id
+WHERE ea.model_id = @model_id::integer
+    AND a.published_at BETWEEN @start::timestamptz AND @end::timestamptz
+    AND (
+        sqlc.narg('party')::party IS NULL
+        OR a.party = sqlc.narg('party')::party
+    )
+ORDER BY a.published_at DESC,
+    ea.id
+LIMIT @max_candidates::integer;
@@ -31,7 +31,9 @@ INSERT INTO users.articles (
         md5,
         content,
         cuts,
-        published_at
+        published_at,
+        modified_at,
+        content_hash
     )
 VALUES (
         $1,
@@ -41,7 +43,9 @@ VALUES (
         $5,
         $6,
         $7,
-        $8
+        $8,
+        $9,
+        $10
     )
 RETURNING id;
 -- name: InsertUsersChunk :one
@@ -75,6 +79,58 @@ FROM users.articles AS a
     JOIN users.chunks AS c ON a.id = c.article_id
 WHERE a.id = $1
 ORDER BY c."start";
+-- name: GetUsersChunksByArticleID :many
+SELECT id,
+    article_id,
+    "start",
+    offset_left,
+    offset_right,
+    "end",
+    created_at
+FROM users.chunks
+WHERE article_id = $1
+ORDER BY "start",
+    id;
+-- name: GetUsersChunksWithContent :many
+-- The chunks of the article with their text, the substring of the content
+-- from start to end.
+SELECT c.id,
+    c.article_id,
+    c."start",
+    c.offset_left,
+    c.offset_right,
+    c."end",
+    c.created_at,
+    substring(
+        a.content
+        FROM c."start" + 1 FOR (c."end" - c."start")
+    )::text AS content
+FROM users.chunks AS c
+    JOIN users.articles AS a ON a.id = c.article_id
+WHERE c.article_id = $1
+ORDER BY c."start",
+    c.id;
+-- name: ListUsersArticlesByTaskStatus :many
+-- Lists the user articles of the tasks of task_status, the newest first.
+SELECT a.id,
+    a.task_id,
+    a.title,
+    a."url",
+    a.source,
+    a.published_at,
+    a.modified_at,
+    a.needs_review
+FROM users.articles AS a
+    JOIN users.tasks AS t ON t.task_id = a.task_id
+WHERE t.status = sqlc.arg('task_status')::task_status
+ORDER BY a.published_at DESC,
+    a.id DESC
+LIMIT sqlc.arg('limit')::integer OFFSET sqlc.arg('offset')::integer;
+-- name: CountUsersArticlesByTaskStatus :one
+SELECT COUNT(*)::bigint AS total
+FROM users.articles AS a
+    JOIN users.tasks AS t ON t.task_id = a.task_id
+WHERE t.status = sqlc.arg('task_status')::task_status;
 -- name: GetUsersArticleByID :one
 SELECT *
 FROM users.articles
@@ -86,7 +142,64 @@ WHERE task_id = $1;
 -- name: GetUsersArticleByMD5 :one
 SELECT *
 FROM users.articles
-WHERE md5 = $1;
+WHERE task_id = $1
+    AND md5 = $2;
+-- name: ExistsUsersArticleByMD5 :one
+SELECT EXISTS (
+        SELECT 1
+        FROM users.articles
+        WHERE task_id = $1
+            AND md5 = $2
+    ) AS found;
+-- name: UpsertUsersArticleByMD5 :one
+-- Inserts the article, or leaves the one of the same md5 in the task as it is,
+-- and returns its id either way. inserted is false for an existing article.
+INSERT INTO users.articles (
+        task_id,
+        title,
+        "url",
+        source,
+        md5,
+        content,
+        cuts,
+        published_at,
+        modified_at,
+        content_hash
+    )
+VALUES (
+        $1,
+        $2,
+        $3,
+        $4,
+        $5,
+        $6,
+        $7,
+        $8,
+        $9,
+        $10
+    ) ON CONFLICT (task_id, md5) DO
+UPDATE
+SET md5 = EXCLUDED.md5
+RETURNING id,
+    (xmax = 0)::boolean AS inserted;
+-- name: ReplaceUsersArticle :execrows
+-- Replaces the scraped fields of the article of the task, and clears its
+-- review flag.
+UPDATE users.articles
+SET title = @title::text,
+    source = @source::text,
+    md5 = @md5::text,
+    content = @content::text,
+    cuts = @cuts::integer [],
+    published_at = @published_at::timestamptz,
+    modified_at = sqlc.narg('modified_at')::timestamptz,
+    content_hash = @content_hash::text,
+    needs_review = FALSE
+WHERE id = @id::integer
+    AND task_id = @task_id::uuid;
+-- name: DeleteUsersArticleKeywords :exec
+DELETE FROM users.articles_keywords
+WHERE article_id = $1;
 -- name: InsertArticle :one
 INSERT INTO articles (
         title,
@@ -96,7 +209,8 @@ INSERT INTO articles (
         party,
         content,
         cuts,
-        published_at
+        published_at,
+        modified_at
     )
 VALUES (
         $1,
@@ -106,7 +220,8 @@ VALUES (
         $5,
         $6,
         $7,
-        $8
+        $8,
+        $9
     )
 RETURNING id;
 -- name: GetArticleByID :one
@@ -121,6 +236,41 @@ WHERE id = ANY(sqlc.arg('ids')::integer[]);
 SELECT *
 FROM articles
 WHERE md5 = $1;
+-- name: ExistsArticleByMD5 :one
+SELECT EXISTS (
+        SELECT 1
+        FROM articles
+        WHERE md5 = $1
+    ) AS found;
+-- name: UpsertArticleByMD5 :one
+-- Inserts the article, or leaves the one of the same md5 as it is, and returns
+-- its id either way. inserted is false for an existing article.
+INSERT INTO articles (
+        title,
+        "url",
+        source,
+        md5,
+        party,
+        content,
+        cuts,
+        published_at,
+        modified_at
+    )
+VALUES (
+        $1,
+        $2,
+        $3,
+        $4,
+        $5,
+        $6,
+        $7,
+        $8,
+        $9
+    ) ON CONFLICT (md5) DO
+UPDATE
+SET md5 = EXCLUDED.md5
+RETURNING id,
+    (xmax = 0)::boolean AS inserted;
 -- name: GetArticleByURL :one
 SELECT *
 FROM articles
@@ -139,6 +289,159 @@ FROM articles
 WHERE published_at >= NOW() - INTERVAL '1 day' * sqlc.arg(k)::integer
 ORDER BY published_at DESC
 LIMIT sqlc.arg('limit')::integer;
+-- name: ListArticles :many
+-- Lists the articles published in [since, until) which match the filter, the
+-- newest first or, if ascending, the oldest first, after the cursor
+-- (after_published_at, after_id) if one is given. An empty party, source or
+-- query and a NULL bound match every article, the query matches the titles
+-- case-insensitively.
+SELECT a.id,
+    a.title,
+    a."url",
+    a.source,
+    a.party,
+    a.published_at,
+    a.modified_at
+FROM articles AS a
+WHERE (
+        @party::text = ''
+        OR a.party::text = @party::text
+    )
+    AND (
+        @source::text = ''
+        OR a.source = @source::text
+    )
+    AND (
+        sqlc.narg('since')::timestamptz IS NULL
+        OR a.published_at >= sqlc.narg('since')::timestamptz
+    )
+    AND (
+        sqlc.narg('until')::timestamptz IS NULL
+        OR a.published_at < sqlc.narg('until')::timestamptz
+    )
+    AND (
+        @query::text = ''
+        OR a.title ILIKE '%' || @query::text || '%'
+    )
+    AND (
+        sqlc.narg('after_id')::integer IS NULL
+        OR (
+            @ascending::boolean
+            AND (a.published_at, a.id) > (
+                sqlc.narg('after_published_at')::timestamptz,
+                sqlc.narg('after_id')::integer
+            )
+        )
+        OR (
+            NOT @ascending::boolean
+            AND (a.published_at, a.id) < (
+                sqlc.narg('after_published_at')::timestamptz,
+                sqlc.narg('after_id')::integer
+            )
+        )
+    )
+ORDER BY CASE
+        WHEN @ascending::boolean THEN a.published_at
+    END,
+    CASE
+        WHEN @ascending::boolean THEN a.id
+    END,
+    a.published_at DESC,
+    a.id DESC
+LIMIT sqlc.arg('limit')::integer OFFSET sqlc.arg('offset')::integer;
+-- name: CountListedArticles :one
+-- Counts the articles ListArticles lists under the same filter, regardless of
+-- the cursor.
+SELECT COUNT(*)::bigint AS total
+FROM articles AS a
+WHERE (
+        @party::text = ''
+        OR a.party::text = @party::text
+    )
+    AND (
+        @source::text = ''
+        OR a.source = @source::text
+    )
+    AND (
+        sqlc.narg('since')::timestamptz IS NULL
+        OR a.published_at >= sqlc.narg('since')::timestamptz
+    )
+    AND (
+        sqlc.narg('until')::timestamptz IS NULL
+        OR a.published_at < sqlc.narg('until')::timestamptz
+    )
+    AND (
+        @query::text = ''
+        OR a.title ILIKE '%' || @query::text || '%'
+    );
+-- name: SearchArticlesByText :many
+-- Ranks the articles matching query, by the full-text search or, for the words
+-- the text search configuration cannot segment, as a substring of the title or
+-- the content, the best match first or, if by_recency, the newest first. The
+-- headline is the fragments of the content around the matches.
+WITH q AS (
+    SELECT websearch_to_tsquery('articles_fts', @query::text) AS tsq
+),
+matched AS (
+    SELECT a.id,
+        a.title,
+        a."url",
+        a.source,
+        a.party,
+        a.published_at,
+        a.content,
+        articles_search_vector(a.title, a.content) @@ q.tsq AS fts,
+        (
+            ts_rank_cd(articles_search_vector(a.title, a.content), q.tsq)
+            + word_similarity(@query::text, a.title)
+        )::real AS rank
+    FROM articles AS a,
+        q
+    WHERE articles_search_vector(a.title, a.content) @@ q.tsq
+        OR a.title ILIKE '%' || @pattern::text || '%'
+        OR a.content ILIKE '%' || @pattern::text || '%'
+    ORDER BY CASE
+            WHEN @by_recency::boolean THEN a.published_at
+        END DESC,
+        rank DESC,
+        a.published_at DESC,
+        a.id DESC
+    LIMIT sqlc.arg('limit')::integer OFFSET sqlc.arg('offset')::integer
+)
+SELECT m.id,
+    m.title,
+    m."url",
+    m.source,
+    m.party,
+    m.published_at,
+    m.rank,
+    (
+        CASE
+            WHEN m.fts THEN ts_headline(
+                'articles_fts',
+                m.content,
+                q.tsq,
+                'MaxFragments=2, MinWords=5, MaxWords=20'
+            )
+            ELSE replace(
+                substr(
+                    m.content,
+                    greatest(strpos(lower(m.content), lower(@query::text)) - 20, 1),
+                    80
+                ),
+                @query::text,
+                '<b>' || @query::text || '</b>'
+            )
+        END
+    )::text AS headline
+FROM matched AS m,
+    q
+ORDER BY CASE
+        WHEN @by_recency::boolean THEN m.published_at
+    END DESC,
+    m.rank DESC,
+    m.published_at DESC,
+    m.id DESC;
 -- name: InsertChunk :one
 INSERT INTO chunks (
         article_id,
@@ -0,0 +1,76 @@
+-- name: IncrementCounter :exec
+-- Atomically add delta to a counter, creating it if it does not exist.
+INSERT INTO counters ("name", "value", updated_at)
+VALUES (@name::text, @delta::bigint, CURRENT_TIMESTAMP)
+ON CONFLICT ("name") DO UPDATE
+SET "value" = counters."value" + EXCLUDED."value",
+    updated_at = EXCLUDED.updated_at;
+
+-- name: SetCounter :exec
+INSERT INTO counters ("name", "value", updated_at)
+VALUES (@name::text, @value::bigint, CURRENT_TIMESTAMP)
+ON CONFLICT ("name") DO UPDATE
+SET "value" = EXCLUDED."value",
+    updated_at = EXCLUDED.updated_at;
+
+-- name: ListCounters :many
+SELECT * FROM counters
+ORDER BY "name" ASC;
+
+-- name: CountArticles :one
+SELECT COUNT(*)::bigint AS count FROM articles;
+
+-- name: CountArticlesPublishedSince :one
+SELECT COUNT(*)::bigint AS count FROM articles
+WHERE published_at >= @since::timestamptz;
+
+-- name: CountUsersTasks :one
+SELECT COUNT(*)::bigint AS count FROM users.tasks;
+
+-- name: CountUsersTasksDoneSince :one
+SELECT COUNT(*)::bigint AS count FROM users.tasks
+WHERE status = 'done' AND updated_at >= @since::timestamptz;
+
+-- name: CountArticlesKeywords :one
+SELECT COUNT(*)::bigint AS count FROM articles_keywords;
+
+-- name: CountArticlesByParty :many
+SELECT party,
+    COUNT(*)::bigint AS count
+FROM articles
+GROUP BY party
+ORDER BY party;
+
+-- name: CountArticlesPerDay :many
+-- The articles published per day and party since since.
+SELECT date_trunc('day', published_at)::timestamptz AS day,
+    party,
+    COUNT(*)::bigint AS count
+FROM articles
+WHERE published_at >= @since::timestamptz
+GROUP BY day, party
+ORDER BY day, party;
+
+-- name: CountArticlesPerBucketByParty :many
+-- The articles published per bucket, a field of date_trunc, and party within
+-- [since, until).
+SELECT date_trunc(@bucket::text, published_at)::timestamptz AS bucket_start,
+    party,
+    COUNT(*)::bigint AS count
+FROM articles
+WHERE published_at >= @since::timestamptz
+    AND published_at < @until::timestamptz
+GROUP BY bucket_start, party
+ORDER BY bucket_start, party;
+
+-- name: CountArticlesPerBucketBySource :many
+-- The articles published per bucket, a field of date_trunc, and source within
+-- [since, until).
+SELECT date_trunc(@bucket::text, published_at)::timestamptz AS bucket_start,
+    source,
+    COUNT(*)::bigint AS count
+FROM articles
+WHERE published_at >= @since::timestamptz
+    AND published_at < @until::timestamptz
+GROUP BY bucket_start, source
+ORDER BY bucket_start, source;
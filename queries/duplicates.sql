@@ -0,0 +1,29 @@
+-- name: FindNearDuplicateArticles :many
+-- FindNearDuplicateArticles returns the articles published within window_days
+-- of the article of id whose content is at least pg_trgm.similarity_threshold
+-- similar to its own by trigrams, the most similar first. The content is
+-- matched by the % operator for the trigram index to serve the search, the
+-- threshold has to be set on the transaction.
+SELECT d.id,
+    d.title,
+    d.url,
+    d.source,
+    d.published_at,
+    d.canonical_id,
+    similarity(d.content, a.content)::real AS similarity
+FROM articles AS a
+    JOIN articles AS d ON d.id <> a.id
+    AND d.published_at BETWEEN a.published_at - make_interval(days => @window_days::integer)
+    AND a.published_at + make_interval(days => @window_days::integer)
+WHERE a.id = @id::integer
+    AND d.content % a.content
+ORDER BY similarity DESC,
+    d.id
+LIMIT @max_results::integer;
+-- name: LinkArticleDuplicates :execrows
+-- LinkArticleDuplicates links the article of id, and the duplicates linked to
+-- it, to the article of canonical_id.
+UPDATE articles
+SET canonical_id = @canonical_id::integer
+WHERE id = @id::integer
+    OR canonical_id = @id::integer;
@@ -37,12 +37,12 @@ ORDER BY vector <->@query
 LIMIT @k::integer;
 -- name: GetKNNEmbeddingsByCosineSimilarity :many
 SELECT article_id,
-    (vector <=> @query::vector)::float8 AS similarity -- <=> is the cosine distance operator in pgvector
+    (vector::vector/*dim*/ <=> @query::vector/*dim*/)::float8 AS similarity -- <=> is the cosine distance operator in pgvector
 FROM embeddings
 WHERE model_id = @model_id::integer
     AND vector IS NOT NULL
     AND vector <> '[]'::vector
-ORDER BY vector <=> @query
+ORDER BY vector::vector/*dim*/ <=> @query::vector/*dim*/
 LIMIT @k::integer;
 -- name: GetKNNEmbeddingsByInnerProduct :many
 SELECT article_id,
@@ -58,9 +58,11 @@ INSERT INTO users.embeddings (
         article_id,
         chunk_id,
         model_id,
-        vector
+        vector,
+        was_split,
+        sub_count
     )
-VALUES ($1, $2, $3, @vector::vector)
+VALUES ($1, $2, $3, @vector::vector, @was_split, @sub_count)
 RETURNING id;
 -- name: InsertUsersEmbedding :one
 INSERT INTO users.embeddings (
@@ -76,10 +78,32 @@ INSERT INTO users.embeddings (
         article_id,
         chunk_id,
         model_id,
-        vector
+        vector,
+        was_split,
+        sub_count
     )
-VALUES ($1, $2, $3, @vector::vector) ON CONFLICT DO NOTHING
+VALUES ($1, $2, $3, @vector::vector, @was_split, @sub_count) ON CONFLICT DO NOTHING
+RETURNING id;
+-- name: InsertUsersEmbeddingIfAbsent :one
+-- Inserts the embedding unless the chunk is embedded under the model already,
+-- no row is returned then.
+INSERT INTO users.embeddings (
+        article_id,
+        chunk_id,
+        model_id,
+        vector,
+        was_split,
+        sub_count
+    )
+VALUES ($1, $2, $3, @vector::vector, @was_split, @sub_count) ON CONFLICT DO NOTHING
 RETURNING id;
+-- name: ListUsersEmbeddedChunkIDs :many
+-- The chunks among chunk_ids embedded under the model.
+SELECT DISTINCT chunk_id
+FROM users.embeddings
+WHERE model_id = @model_id::integer
+    AND chunk_id = ANY(@chunk_ids::integer [])
+ORDER BY chunk_id;
 -- name: GetAverageUsersEmbeddingByArticleIDs :one
 SELECT e.article_id,
     e.model_id,
@@ -116,4 +140,181 @@ WHERE model_id = @model_id::integer
     AND vector IS NOT NULL
     AND vector <> '[]'::vector
 ORDER BY vector <#>@query
-LIMIT @k::integer;
\ No newline at end of file
+LIMIT @k::integer;
+-- name: GetUsersEmbeddingModelIDByTaskID :one
+-- The model of the latest embedding of the articles of a task.
+SELECT e.model_id
+FROM users.embeddings AS e
+    JOIN users.articles AS ua ON ua.id = e.article_id
+WHERE ua.task_id = @task_id::uuid
+ORDER BY e.id DESC
+LIMIT 1;
+-- name: GetSimilarArticlesByTaskID :many
+-- The articles nearest to the average embedding of the articles of a task,
+-- each joined with its top_m closest chunks. A match yields one row per chunk.
+WITH query AS (
+    SELECT AVG(e.vector)::vector AS vector
+    FROM users.embeddings AS e
+        JOIN users.articles AS ua ON ua.id = e.article_id
+    WHERE ua.task_id = @task_id::uuid
+        AND e.model_id = @model_id::integer
+),
+matched AS (
+    SELECT e.article_id,
+        MIN(e.vector::vector/*dim*/ <=> q.vector::vector/*dim*/)::float8 AS distance
+    FROM embeddings AS e
+        CROSS JOIN query AS q
+    WHERE e.model_id = @model_id::integer
+        AND q.vector IS NOT NULL
+        AND e.article_id NOT IN (
+            SELECT a.id
+            FROM articles AS a
+                JOIN users.articles AS ua ON ua.md5 = a.md5
+            WHERE ua.task_id = @task_id::uuid
+        )
+    GROUP BY e.article_id
+    ORDER BY distance ASC
+    LIMIT @k::integer
+)
+SELECT a.id AS article_id,
+    a.title,
+    a."url",
+    a.source,
+    a.published_at,
+    (1 - m.distance)::float8 AS similarity,
+    c.chunk_id,
+    c.chunk_similarity,
+    c.unique_start,
+    c.unique_end,
+    c.snippet
+FROM matched AS m
+    JOIN articles AS a ON a.id = m.article_id
+    CROSS JOIN query AS q
+    JOIN LATERAL (
+        SELECT ch.id AS chunk_id,
+            (1 - (e.vector::vector/*dim*/ <=> q.vector::vector/*dim*/))::float8 AS chunk_similarity,
+            (ch."start" + ch.offset_left)::integer AS unique_start,
+            (ch."start" + ch.offset_right)::integer AS unique_end,
+            substring(
+                a.content
+                FROM ch."start" + ch.offset_left + 1 FOR (ch.offset_right - ch.offset_left)
+            )::text AS snippet
+        FROM embeddings AS e
+            JOIN chunks AS ch ON ch.id = e.chunk_id
+        WHERE e.article_id = a.id
+            AND e.model_id = @model_id::integer
+        ORDER BY e.vector::vector/*dim*/ <=> q.vector::vector/*dim*/ ASC,
+            ch.id ASC
+        LIMIT @top_m::integer
+    ) AS c ON TRUE
+ORDER BY similarity DESC,
+    a.id ASC,
+    c.chunk_similarity DESC,
+    c.chunk_id ASC;
+-- name: SearchSimilarEmbeddings :many
+-- The chunks of the articles nearest to query under the cosine distance, no
+-- farther than max_distance, with their offsets in the article content.
+SELECT e.chunk_id,
+    e.article_id,
+    c."start",
+    c.offset_left,
+    c.offset_right,
+    c."end",
+    (e.vector::vector/*dim*/ <=> @query::vector/*dim*/)::float8 AS distance
+FROM embeddings AS e
+    JOIN chunks AS c ON c.id = e.chunk_id
+WHERE e.model_id = @model_id::integer
+    AND (e.vector::vector/*dim*/ <=> @query::vector/*dim*/) <= @max_distance::float8
+ORDER BY e.vector::vector/*dim*/ <=> @query::vector/*dim*/
+LIMIT sqlc.arg('limit')::integer;
+-- name: SearchSimilarUsersEmbeddings :many
+-- The chunks of the user articles nearest to query under the cosine distance,
+-- no farther than max_distance, with their offsets in the article content.
+SELECT e.chunk_id,
+    e.article_id,
+    c."start",
+    c.offset_left,
+    c.offset_right,
+    c."end",
+    (e.vector::vector/*dim*/ <=> @query::vector/*dim*/)::float8 AS distance
+FROM users.embeddings AS e
+    JOIN users.chunks AS c ON c.id = e.chunk_id
+WHERE e.model_id = @model_id::integer
+    AND (e.vector::vector/*dim*/ <=> @query::vector/*dim*/) <= @max_distance::float8
+ORDER BY e.vector::vector/*dim*/ <=> @query::vector/*dim*/
+LIMIT sqlc.arg('limit')::integer;
+-- name: HybridSearchArticles :many
+-- Fuses the articles of the chunks nearest to embedding under the cosine
+-- distance with the articles matching query, as Article.Search matches them,
+-- by their weighted reciprocal ranks: an article scores
+-- vector_weight / (rrf_k + its rank by distance) plus
+-- keyword_weight / (rrf_k + its rank by keyword score), a side it is not
+-- among the candidates of adding nothing. An article is ranked by distance
+-- by its nearest chunk.
+WITH nearest AS (
+    SELECT e.chunk_id,
+        e.article_id,
+        (e.vector::vector/*dim*/ <=> @embedding::vector/*dim*/)::float8 AS distance
+    FROM embeddings AS e
+    WHERE e.model_id = @model_id::integer
+    ORDER BY e.vector::vector/*dim*/ <=> @embedding::vector/*dim*/
+    LIMIT sqlc.arg('candidates')::integer
+),
+vector_hits AS (
+    SELECT DISTINCT ON (article_id) article_id,
+        chunk_id,
+        distance
+    FROM nearest
+    ORDER BY article_id,
+        distance,
+        chunk_id
+),
+vector_ranked AS (
+    SELECT article_id,
+        chunk_id,
+        distance,
+        row_number() OVER (
+            ORDER BY distance,
+                chunk_id
+        ) AS "rank"
+    FROM vector_hits
+),
+keyword_hits AS (
+    SELECT a.id AS article_id,
+        (
+            ts_rank_cd(articles_search_vector(a.title, a.content), q.tsq)
+            + word_similarity(@query::text, a.title)
+        )::float8 AS score
+    FROM articles AS a,
+        websearch_to_tsquery('articles_fts', @query::text) AS q(tsq)
+    WHERE articles_search_vector(a.title, a.content) @@ q.tsq
+        OR a.title ILIKE '%' || @pattern::text || '%'
+        OR a.content ILIKE '%' || @pattern::text || '%'
+    ORDER BY score DESC,
+        a.id
+    LIMIT sqlc.arg('candidates')::integer
+),
+keyword_ranked AS (
+    SELECT article_id,
+        score,
+        row_number() OVER (
+            ORDER BY score DESC,
+                article_id
+        ) AS "rank"
+    FROM keyword_hits
+)
+SELECT COALESCE(v.article_id, k.article_id)::integer AS article_id,
+    v.chunk_id,
+    v.distance AS vector_distance,
+    v."rank" AS vector_rank,
+    k.score AS keyword_score,
+    k."rank" AS keyword_rank,
+    (
+        COALESCE(@vector_weight::float8 / (@rrf_k::float8 + v."rank"), 0)
+        + COALESCE(@keyword_weight::float8 / (@rrf_k::float8 + k."rank"), 0)
+    )::float8 AS score
+FROM vector_ranked AS v
+    FULL JOIN keyword_ranked AS k ON k.article_id = v.article_id
+ORDER BY score DESC,
+    article_id
+LIMIT sqlc.arg('limit')::integer;
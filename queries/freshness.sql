@@ -0,0 +1,67 @@
+-- name: CountUsersArticleRevisionsSince :one
+SELECT COUNT(*)::bigint AS count
+FROM users.article_revisions
+WHERE article_id = @article_id::integer
+    AND field = @field::text
+    AND created_at >= @since::timestamptz;
+-- name: DeleteUsersChunksByArticleID :exec
+DELETE FROM users.chunks
+WHERE article_id = $1;
+-- name: InsertUsersArticleRevision :exec
+INSERT INTO users.article_revisions (
+        article_id,
+        field,
+        old_value,
+        new_value,
+        note
+    )
+VALUES ($1, $2, $3, $4, $5);
+-- name: ListUsersArticlesDueForRecheck :many
+-- The user articles scraped from a URL and published since since which have
+-- never been checked, or not since checked_before, least recently checked
+-- first.
+SELECT a.id,
+    a.task_id,
+    t.original_input AS "url",
+    a.title,
+    a.modified_at,
+    a.content_hash,
+    a.last_checked_at
+FROM users.articles AS a
+    JOIN users.tasks AS t ON t.task_id = a.task_id
+WHERE t.source = 'url'
+    AND a.published_at >= @since::timestamptz
+    AND (
+        a.last_checked_at IS NULL
+        OR a.last_checked_at < @checked_before::timestamptz
+    )
+ORDER BY a.last_checked_at ASC NULLS FIRST,
+    a.id
+LIMIT sqlc.arg('limit')::integer;
+-- name: TouchUsersArticleChecked :execrows
+-- A NULL modified_at keeps the stored modification time.
+UPDATE users.articles
+SET last_checked_at = @checked_at::timestamptz,
+    modified_at = COALESCE(sqlc.narg('modified_at')::timestamptz, modified_at)
+WHERE id = @id::integer;
+-- name: UpdateUsersArticleContent :one
+-- The title and the content before the update are returned, to be kept as
+-- revisions.
+WITH old AS (
+    SELECT id,
+        title,
+        content
+    FROM users.articles
+    WHERE id = @id::integer FOR UPDATE
+)
+UPDATE users.articles AS a
+SET title = @title::text,
+    content = @content::text,
+    cuts = @cuts::integer [],
+    content_hash = @content_hash::text,
+    modified_at = COALESCE(sqlc.narg('modified_at')::timestamptz, a.modified_at),
+    last_checked_at = @checked_at::timestamptz
+FROM old
+WHERE a.id = old.id
+RETURNING old.title AS old_title,
+    old.content AS old_content;
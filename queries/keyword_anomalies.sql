@@ -0,0 +1,60 @@
+-- name: InsertKeywordAnomaly :one
+-- A keyword already flagged on the day is not flagged again, no row is
+-- returned then.
+INSERT INTO keyword_anomalies (
+        keyword_id,
+        "date",
+        "count",
+        baseline_mean,
+        baseline_stddev,
+        zscore
+    )
+VALUES (
+        @keyword_id::integer,
+        @date::date,
+        @count::integer,
+        @baseline_mean::double precision,
+        @baseline_stddev::double precision,
+        @zscore::double precision
+    ) ON CONFLICT (keyword_id, "date") DO NOTHING
+RETURNING *;
+-- name: KeywordTrend :many
+-- Counts the public articles mentioning each keyword per day, in the time
+-- zone tz, over the articles published in [published_from, published_to). The
+-- days without articles are not returned and the low information keywords are
+-- left out.
+SELECT k.id AS keyword_id,
+    k.term,
+    (a.published_at AT TIME ZONE @tz::text)::date AS day,
+    COUNT(*)::integer AS count
+FROM articles_keywords ak
+    JOIN articles a ON a.id = ak.article_id
+    JOIN keywords k ON k.id = ak.keyword_id
+WHERE a.published_at >= @published_from::timestamptz
+    AND a.published_at < @published_to::timestamptz
+    AND NOT k.low_information
+GROUP BY k.id,
+    k.term,
+    day
+ORDER BY k.id,
+    day;
+-- name: ListKeywordAnomaliesSince :many
+SELECT ka.keyword_id,
+    k.term,
+    k.lang,
+    ka."date",
+    ka."count",
+    ka.baseline_mean,
+    ka.baseline_stddev,
+    ka.zscore,
+    ka.detected_at
+FROM keyword_anomalies ka
+    JOIN keywords k ON k.id = ka.keyword_id
+WHERE ka."date" >= @since::date
+ORDER BY ka."date" DESC,
+    ka.zscore DESC
+LIMIT sqlc.arg('limit')::integer;
+-- name: SetKeywordLowInformation :exec
+UPDATE keywords
+SET low_information = @low_information::boolean
+WHERE id = @id::integer;
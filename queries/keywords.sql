@@ -0,0 +1,69 @@
+-- name: InsertKeywordLink :exec
+INSERT INTO keyword_links (
+        keyword_id,
+        linked_keyword_id,
+        similarity
+    )
+VALUES (
+        LEAST(@keyword_id::integer, @linked_keyword_id::integer),
+        GREATEST(@keyword_id::integer, @linked_keyword_id::integer),
+        @similarity::real
+    ) ON CONFLICT (keyword_id, linked_keyword_id) DO
+UPDATE
+SET similarity = EXCLUDED.similarity;
+-- name: InsertUsersArticleKeywords :exec
+INSERT INTO users.articles_keywords (article_id, keyword_id)
+SELECT @article_id::integer,
+    UNNEST(@keyword_ids::integer []) ON CONFLICT DO NOTHING;
+-- name: ListKeywordsByLang :many
+SELECT *
+FROM keywords
+WHERE lang = @lang::text
+ORDER BY id DESC
+LIMIT sqlc.arg('limit')::integer;
+-- name: ListKeywordsByUsersArticleID :many
+-- Lists the keywords of a user article with their category, the keywords
+-- attached without a category last.
+SELECT k.id,
+    k.term,
+    k.lang,
+    k.low_information,
+    ak.category
+FROM users.articles_keywords AS ak
+    JOIN keywords AS k ON k.id = ak.keyword_id
+WHERE ak.article_id = @article_id::integer
+ORDER BY ak.category NULLS LAST,
+    k.term ASC;
+-- name: ListTopUsersKeywords :many
+-- Counts the user articles published since since by keyword, leaving out the
+-- low information keywords.
+SELECT k.id,
+    k.term,
+    k.lang,
+    COUNT(*)::integer AS article_count
+FROM users.articles_keywords AS ak
+    JOIN keywords AS k ON k.id = ak.keyword_id
+    JOIN users.articles AS a ON a.id = ak.article_id
+WHERE a.published_at >= @since::timestamptz
+    AND NOT k.low_information
+GROUP BY k.id
+ORDER BY article_count DESC,
+    k.id ASC
+LIMIT sqlc.arg('limit')::integer;
+-- name: UpsertKeywords :many
+-- Inserts the terms which do not exist yet in lang, and returns all of them.
+INSERT INTO keywords (term, lang)
+SELECT UNNEST(@terms::text []),
+    @lang::text ON CONFLICT (term, lang) DO
+UPDATE
+SET term = EXCLUDED.term
+RETURNING *;
+-- name: UpsertUsersArticleKeywords :exec
+-- Attaches the keywords to a user article with their category, the category of
+-- a keyword attached already is updated.
+INSERT INTO users.articles_keywords (article_id, keyword_id, category)
+SELECT @article_id::integer,
+    UNNEST(@keyword_ids::integer []),
+    UNNEST(@categories::text [])::keyword_category ON CONFLICT (keyword_id, article_id) DO
+UPDATE
+SET category = EXCLUDED.category;
@@ -1,23 +1,34 @@
 -- name: InsertModel :one
-INSERT INTO models (name)
-VALUES (@name::text)
+INSERT INTO models (name, dim)
+VALUES (@name::text, @dim::integer)
 RETURNING id;
+-- name: GetOrCreateModel :one
+-- GetOrCreateModel inserts the model unless one of the name exists, and returns
+-- the model of the name either way. The no-op update makes RETURNING return
+-- the existing model too. inserted is false for an existing model.
+INSERT INTO models (name, dim)
+VALUES (@name::text, @dim::integer)
+ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+RETURNING id, dim, active, (xmax = 0)::boolean AS inserted;
 -- name: GetModelByName :one
-SELECT id, name
+SELECT id, name, dim, active
 FROM models
 WHERE name = @name::text
 LIMIT 1;
 -- name: GetModelByID :one
-SELECT id, name
+SELECT id, name, dim, active
 FROM models
 WHERE id = @id::integer
 LIMIT 1;
+-- name: DeactivateModel :execrows
+UPDATE models
+SET active = FALSE
+WHERE id = @id::integer;
 -- name: DeleteModelByID :exec
 DELETE FROM models
 WHERE id = @id::integer
 RETURNING id;
 -- name: ListModels :many
-SELECT id, name
+SELECT *
 FROM models
-ORDER BY created_at DESC
-LIMIT $1 OFFSET $2;
\ No newline at end of file
+ORDER BY created_at DESC, id DESC;
@@ -0,0 +1,72 @@
+-- name: ListDoneTasksSince :many
+-- ListDoneTasksSince pages through the tasks done since since, by row id.
+SELECT id, task_id
+FROM users.tasks
+WHERE status = 'done'
+    AND updated_at >= @since::timestamptz
+    AND id > @after_id::integer
+ORDER BY id
+LIMIT sqlc.arg('limit')::integer;
+-- name: ListTaskArtifacts :many
+-- ListTaskArtifacts returns the pipeline artifacts of the user articles of a
+-- task: the title, the content, the keyword terms, sorted, and the number of
+-- chunks and of chunks with an embedding.
+SELECT a.id,
+    a.title,
+    a.content,
+    COALESCE(
+        (
+            SELECT array_agg(k.term::text ORDER BY k.term)
+            FROM users.articles_keywords ak
+                JOIN keywords k ON k.id = ak.keyword_id
+            WHERE ak.article_id = a.id
+        ),
+        '{}'
+    )::text [] AS keywords,
+    (
+        SELECT count(*)
+        FROM users.chunks c
+        WHERE c.article_id = a.id
+    ) AS chunks,
+    (
+        SELECT count(DISTINCT e.chunk_id)
+        FROM users.embeddings e
+        WHERE e.article_id = a.id
+    ) AS embedded_chunks
+FROM users.articles a
+WHERE a.task_id = @task_id::uuid
+ORDER BY a.id;
+-- name: CreateReconciliationReport :one
+-- CreateReconciliationReport starts the report of a pass over the tasks done
+-- since since.
+INSERT INTO reconciliation_reports (since)
+VALUES (@since::timestamptz)
+RETURNING *;
+-- name: GetRunningReconciliationReport :one
+-- GetRunningReconciliationReport returns the report of the pass not finished
+-- yet, if any.
+SELECT *
+FROM reconciliation_reports
+WHERE finished_at IS NULL;
+-- name: UpdateReconciliationReport :one
+-- UpdateReconciliationReport records the progress of a pass, and finishes it
+-- if finished is set.
+UPDATE reconciliation_reports
+SET last_task_id = @last_task_id::integer,
+    checked = @checked::integer,
+    sampled = @sampled::integer,
+    counts = @counts::jsonb,
+    repaired = @repaired::integer,
+    flagged = @flagged::integer,
+    updated_at = CURRENT_TIMESTAMP,
+    finished_at = CASE
+        WHEN @finished::boolean THEN CURRENT_TIMESTAMP
+    END
+WHERE id = @id::integer
+RETURNING *;
+-- name: ListReconciliationReports :many
+-- ListReconciliationReports returns the latest reports, newest first.
+SELECT *
+FROM reconciliation_reports
+ORDER BY id DESC
+LIMIT sqlc.arg('limit')::integer;
@@ -0,0 +1,86 @@
+-- name: ClaimReviewItem :one
+-- An open item is claimed if it is unassigned, already assigned to the
+-- editor, or the lease of its assignee has expired.
+UPDATE users.review_queue
+SET assigned_to = @assigned_to::text,
+    lease_expires_at = @lease_expires_at::timestamptz
+WHERE id = @id::integer
+    AND status = 'open'
+    AND (
+        assigned_to IS NULL
+        OR assigned_to = @assigned_to::text
+        OR lease_expires_at <= @now::timestamptz
+    )
+RETURNING *;
+-- name: CloseReviewItem :one
+-- Only the editor holding an unexpired lease on the item closes it.
+UPDATE users.review_queue
+SET status = @status::review_status,
+    resolved_at = @now::timestamptz
+WHERE id = @id::integer
+    AND status = 'open'
+    AND assigned_to = @assigned_to::text
+    AND lease_expires_at > @now::timestamptz
+RETURNING *;
+-- name: EnqueueReviewItem :one
+-- An item flagged again while it is open is not queued twice, its priority is
+-- raised to the highest of the flags instead.
+INSERT INTO users.review_queue (
+        item_type,
+        item_ref,
+        article_id,
+        reason,
+        priority
+    )
+VALUES (
+        @item_type::review_item_type,
+        @item_ref::jsonb,
+        @article_id::integer,
+        @reason::text,
+        @priority::integer
+    ) ON CONFLICT (item_type, item_ref)
+WHERE status = 'open' DO
+UPDATE
+SET priority = GREATEST(users.review_queue.priority, EXCLUDED.priority)
+RETURNING *;
+-- name: GetReviewItem :one
+SELECT *
+FROM users.review_queue
+WHERE id = @id::integer;
+-- name: ListReviewItems :many
+-- Oldest first, an empty item type lists the items of every type.
+SELECT *
+FROM users.review_queue
+WHERE status = @status::review_status
+    AND (
+        @item_type::text = ''
+        OR item_type::text = @item_type::text
+    )
+ORDER BY created_at,
+    id
+LIMIT sqlc.arg('limit')::integer OFFSET sqlc.arg('offset')::integer;
+-- name: ReviewQueueDepth :many
+SELECT item_type,
+    COUNT(*) AS depth
+FROM users.review_queue
+WHERE status = 'open'
+GROUP BY item_type
+ORDER BY item_type;
+-- name: ReviewQueueMedianResolution :many
+SELECT item_type,
+    (
+        percentile_cont(0.5) WITHIN GROUP (
+            ORDER BY EXTRACT(
+                    EPOCH
+                    FROM resolved_at - created_at
+                )
+        )
+    )::float8 AS median_seconds
+FROM users.review_queue
+WHERE resolved_at >= @since::timestamptz
+GROUP BY item_type
+ORDER BY item_type;
+-- name: SetUsersArticleNeedsReview :execrows
+UPDATE users.articles
+SET needs_review = @needs_review::boolean
+WHERE id = @id::integer;
@@ -0,0 +1,129 @@
+-- name: CountSavedSearchesByOwner :one
+SELECT COUNT(*)
+FROM users.saved_searches
+WHERE owner_id = @owner_id::text;
+-- name: DeleteSavedSearch :execrows
+DELETE FROM users.saved_searches
+WHERE id = @id::integer
+    AND owner_id = @owner_id::text;
+-- name: GetSavedSearch :one
+SELECT *
+FROM users.saved_searches
+WHERE id = @id::integer
+    AND owner_id = @owner_id::text;
+-- name: InsertSavedSearch :one
+INSERT INTO users.saved_searches (
+        owner_id,
+        name,
+        filter,
+        schedule,
+        delivery,
+        webhook_url
+    )
+VALUES (
+        @owner_id::text,
+        @name::text,
+        @filter::jsonb,
+        @schedule::saved_search_schedule,
+        @delivery::saved_search_delivery,
+        @webhook_url::text
+    )
+RETURNING *;
+-- name: InsertSavedSearchHits :execrows
+INSERT INTO users.saved_search_hits (saved_search_id, article_id, run_at)
+SELECT @saved_search_id::integer,
+    UNNEST(@article_ids::integer []),
+    @run_at::timestamptz ON CONFLICT DO NOTHING;
+-- name: ListDueSavedSearches :many
+-- Saved searches which have never run come first, then the ones whose last
+-- run is the oldest.
+SELECT *
+FROM users.saved_searches
+WHERE last_run_at IS NULL
+    OR last_run_at + CASE
+        schedule
+        WHEN 'daily' THEN INTERVAL '1 day'
+        ELSE INTERVAL '7 days'
+    END <= @now::timestamptz
+ORDER BY last_run_at ASC NULLS FIRST,
+    id
+LIMIT sqlc.arg('limit')::integer;
+-- name: ListSavedSearchHits :many
+SELECT h.run_at,
+    a.id,
+    a.title,
+    a."url",
+    a.source,
+    a.party,
+    a.published_at
+FROM users.saved_search_hits AS h
+    JOIN articles AS a ON a.id = h.article_id
+WHERE h.saved_search_id = @saved_search_id::integer
+ORDER BY h.run_at DESC,
+    h.article_id DESC
+LIMIT sqlc.arg('limit')::integer OFFSET sqlc.arg('offset')::integer;
+-- name: ListSavedSearchesByOwner :many
+SELECT *
+FROM users.saved_searches
+WHERE owner_id = @owner_id::text
+ORDER BY id;
+-- name: LockSavedSearchOwner :exec
+-- Serializes the inserts of the saved searches of an owner until the end of
+-- the transaction, so that the per-owner cap holds under concurrent inserts.
+SELECT pg_advisory_xact_lock(hashtext(@owner_id::text));
+-- name: SearchArticles :many
+-- Lists the articles published in (published_after, published_before] which
+-- match the filter, newest first. An empty party or source and an empty list
+-- of keywords match every article. The keywords are matched against the
+-- effective keywords, with the keyword annotations of the editors applied,
+-- and each article carries its effective summary, empty if it has none or the
+-- editors rejected it.
+SELECT a.id,
+    a.title,
+    a."url",
+    a.source,
+    a.party,
+    a.published_at,
+    COALESCE(es.summary, '')::text AS summary
+FROM articles AS a
+    LEFT JOIN effective_article_summaries AS es ON es.article_id = a.id
+WHERE a.published_at > @published_after::timestamptz
+    AND a.published_at <= @published_before::timestamptz
+    AND (
+        @party::text = ''
+        OR a.party::text = @party::text
+    )
+    AND (
+        @source::text = ''
+        OR a.source = @source::text
+    )
+    AND (
+        cardinality(@keywords::text []) = 0
+        OR EXISTS (
+            SELECT 1
+            FROM effective_articles_keywords AS ek
+            WHERE ek.article_id = a.id
+                AND ek.term = ANY(@keywords::text [])
+        )
+    )
+ORDER BY a.published_at DESC,
+    a.id DESC
+LIMIT sqlc.arg('limit')::integer;
+-- name: UpdateSavedSearch :one
+UPDATE users.saved_searches
+SET name = @name::text,
+    filter = @filter::jsonb,
+    schedule = @schedule::saved_search_schedule,
+    delivery = @delivery::saved_search_delivery,
+    webhook_url = @webhook_url::text,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = @id::integer
+    AND owner_id = @owner_id::text
+RETURNING *;
+-- name: UpdateSavedSearchLastRun :execrows
+-- Moves last_run_at of a saved search forward, unless another run has moved it
+-- since prev_run_at was read.
+UPDATE users.saved_searches
+SET last_run_at = @run_at::timestamptz
+WHERE id = @id::integer
+    AND last_run_at IS NOT DISTINCT FROM sqlc.narg('prev_run_at')::timestamptz;
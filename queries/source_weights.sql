@@ -0,0 +1,16 @@
+-- name: ListSourceWeights :many
+SELECT *
+FROM source_weights
+ORDER BY source;
+-- name: UpsertSourceWeight :one
+INSERT INTO source_weights (source, weight, updated_by)
+VALUES (
+        @source::text,
+        @weight::double precision,
+        @updated_by::text
+    ) ON CONFLICT (source) DO
+UPDATE
+SET weight = EXCLUDED.weight,
+    updated_by = EXCLUDED.updated_by,
+    updated_at = CURRENT_TIMESTAMP
+RETURNING *;
@@ -0,0 +1,80 @@
+-- name: CompactTaskEvents :one
+-- Deletes up to batch_size events older than cutoff of the tasks in a terminal
+-- state, keeping the first and the last event of every stage, and flags the
+-- snapshots of these tasks as compacted.
+WITH doomed AS (
+    SELECT e.id
+    FROM users.task_events AS e
+        JOIN users.task_state AS s ON s.task_id = e.task_id
+    WHERE s.current_status IN ('done', 'failed')
+        AND e.created_at < sqlc.arg('cutoff')::timestamptz
+        AND e.id <> (
+            SELECT MIN(k.id)
+            FROM users.task_events AS k
+            WHERE k.task_id = e.task_id
+                AND k.stage = e.stage
+        )
+        AND e.id <> (
+            SELECT MAX(k.id)
+            FROM users.task_events AS k
+            WHERE k.task_id = e.task_id
+                AND k.stage = e.stage
+        )
+    ORDER BY e.id
+    LIMIT sqlc.arg('batch_size')::integer
+), deleted AS (
+    DELETE FROM users.task_events
+    WHERE id IN (
+            SELECT id
+            FROM doomed
+        )
+    RETURNING task_id
+), flagged AS (
+    UPDATE users.task_state
+    SET compacted = TRUE
+    WHERE task_id IN (
+            SELECT task_id
+            FROM deleted
+        )
+    RETURNING task_id
+)
+SELECT COUNT(*)::bigint AS deleted
+FROM deleted;
+-- name: GetTaskState :one
+SELECT *
+FROM users.task_state
+WHERE task_id = $1;
+-- name: InsertTaskEvent :one
+INSERT INTO users.task_events (
+        task_id,
+        stage,
+        status,
+        message,
+        created_at
+    )
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+-- name: ListTaskEvents :many
+SELECT *
+FROM users.task_events
+WHERE task_id = $1
+ORDER BY id;
+-- name: LockUserTask :one
+-- Serializes the appends to the event stream of a task.
+SELECT id
+FROM users.tasks
+WHERE task_id = $1 FOR UPDATE;
+-- name: UpsertTaskState :exec
+INSERT INTO users.task_state (
+        task_id,
+        current_status,
+        stage_statuses,
+        last_event_id,
+        updated_at
+    )
+VALUES ($1, $2, $3, $4, $5) ON CONFLICT (task_id) DO
+UPDATE
+SET current_status = EXCLUDED.current_status,
+    stage_statuses = EXCLUDED.stage_statuses,
+    last_event_id = EXCLUDED.last_event_id,
+    updated_at = EXCLUDED.updated_at;
@@ -1,10 +1,12 @@
 -- name: InsertUserTask :one
 INSERT INTO users.tasks (
     source,
-    original_input
+    original_input,
+    owner_id
 ) VALUES (
     $1,
-    $2
+    $2,
+    sqlc.narg('owner_id')
 )
 RETURNING task_id;
 
@@ -12,11 +14,59 @@ RETURNING task_id;
 SELECT * FROM users.tasks
 WHERE task_id = $1;
 
--- name: UpdateUserTaskStatus :exec
-UPDATE users.tasks
-SET status = sqlc.arg('task_status')::task_status, updated_at = NOW()
+-- name: GetUserTaskStatus :one
+SELECT status, error_message, updated_at FROM users.tasks
+WHERE task_id = $1;
+
+-- name: DeleteUserTask :execrows
+-- DeleteUserTask deletes the task, its articles, their chunks, embeddings,
+-- keywords and reviews, and its events go with it through the cascades.
+DELETE FROM users.tasks
 WHERE task_id = $1;
 
+-- name: PurgeUserTasks :one
+-- PurgeUserTasks deletes up to batch_size tasks in a terminal state last
+-- updated before cutoff, with everything cascading from them, and returns the
+-- number of tasks and of their articles deleted.
+WITH doomed AS (
+    SELECT task_id
+    FROM users.tasks
+    WHERE status IN ('done', 'failed')
+        AND updated_at < sqlc.arg('cutoff')::timestamptz
+    ORDER BY updated_at, id
+    LIMIT sqlc.arg('batch_size')::integer
+), deleted AS (
+    DELETE FROM users.tasks
+    WHERE task_id IN (
+            SELECT task_id
+            FROM doomed
+        )
+    RETURNING task_id
+)
+SELECT (
+        SELECT COUNT(*)
+        FROM deleted
+    )::bigint AS tasks,
+    (
+        SELECT COUNT(*)
+        FROM users.articles
+        WHERE task_id IN (
+                SELECT task_id
+                FROM doomed
+            )
+    )::bigint AS articles;
+
+-- name: TransitionUserTaskStatus :execrows
+-- TransitionUserTaskStatus moves the task to task_status only if its status is
+-- one of from_statuses, so that a concurrent update is never overwritten by an
+-- illegal transition.
+UPDATE users.tasks
+SET status = sqlc.arg('task_status')::task_status,
+    error_message = sqlc.narg('error_message'),
+    updated_at = NOW()
+WHERE task_id = sqlc.arg('task_id')
+    AND status::text = ANY(sqlc.arg('from_statuses')::text[]);
+
 -- name: UpdateUserTaskErrMsg :exec
 UPDATE users.tasks
 SET error_message = $1, status = 'failed', updated_at = NOW()
@@ -28,3 +78,19 @@ WHERE id > $1
 ORDER BY id DESC
 LIMIT sqlc.arg('limit')::integer;
 
+-- name: ListUserTasksByStatus :many
+SELECT * FROM users.tasks
+WHERE status = sqlc.arg('task_status')::task_status
+ORDER BY updated_at DESC, id DESC
+LIMIT sqlc.arg('limit')::integer
+OFFSET sqlc.arg('offset')::integer;
+
+
+-- name: ListKnownURLs :many
+-- ListKnownURLs returns the URLs among urls already scraped into an article or
+-- submitted as a URL task.
+SELECT "url" FROM articles
+WHERE "url" = ANY(@urls::text[])
+UNION
+SELECT original_input AS "url" FROM users.tasks
+WHERE source = 'url' AND original_input = ANY(@urls::text[]);
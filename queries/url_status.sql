@@ -0,0 +1,156 @@
+-- name: CountDeadArticlesBySource :many
+SELECT a.source,
+    COUNT(*)::bigint AS count
+FROM url_status AS s
+    JOIN articles AS a ON a.id = s.article_id
+WHERE s.dead
+GROUP BY a.source
+ORDER BY a.source;
+-- name: InsertArticleRevision :exec
+INSERT INTO article_revisions (
+        article_id,
+        field,
+        old_value,
+        new_value,
+        note
+    )
+VALUES ($1, $2, $3, $4, $5);
+-- name: ListArticlesDueForCheck :many
+-- Articles which have never been checked come first, then the ones checked
+-- least recently.
+SELECT a.id,
+    a."url",
+    a.source,
+    s.last_checked_at,
+    s.last_status_code,
+    COALESCE(s.consecutive_failures, 0)::integer AS consecutive_failures,
+    COALESCE(s.dead, FALSE)::boolean AS dead
+FROM articles AS a
+    LEFT JOIN url_status AS s ON s.article_id = a.id
+ORDER BY s.last_checked_at ASC NULLS FIRST,
+    a.id
+LIMIT sqlc.arg('limit')::integer;
+-- name: ListArticlesWithURLStatus :many
+-- If dead is NULL, articles are returned regardless of their URL status. If
+-- terms is not empty, only the articles with one of the terms in the title or
+-- the content are returned.
+SELECT a.id,
+    a.title,
+    a."url",
+    a.source,
+    a.party,
+    a.published_at,
+    s.last_checked_at,
+    s.last_status_code,
+    COALESCE(s.dead, FALSE)::boolean AS dead
+FROM articles AS a
+    LEFT JOIN url_status AS s ON s.article_id = a.id
+WHERE (
+        sqlc.narg('dead')::boolean IS NULL
+        OR COALESCE(s.dead, FALSE) = sqlc.narg('dead')::boolean
+    )
+    AND (
+        CARDINALITY(@terms::text []) = 0
+        OR EXISTS (
+            SELECT 1
+            FROM UNNEST(@terms::text []) AS t(term)
+            WHERE STRPOS(a.title, t.term) > 0
+                OR STRPOS(a.content, t.term) > 0
+        )
+    )
+ORDER BY a.published_at DESC,
+    a.id DESC
+LIMIT sqlc.arg('limit')::integer OFFSET sqlc.arg('offset')::integer;
+-- name: ListTopArticles :many
+-- Ranks the articles by a score blending the recency decay
+-- exp(-age_hours / tau_hours), the weight of the source, default_source_weight
+-- if it has none, and the relevance, the share of the terms found in the
+-- title (half) and the content (half). The articles are filtered as by
+-- ListArticlesWithURLStatus. The score is computed in a subquery so the pages
+-- are cut on it, by offset.
+SELECT scored.id,
+    scored.title,
+    scored."url",
+    scored.source,
+    scored.party,
+    scored.published_at,
+    scored.last_checked_at,
+    scored.last_status_code,
+    scored.dead,
+    scored.score
+FROM (
+        SELECT a.id,
+            a.title,
+            a."url",
+            a.source,
+            a.party,
+            a.published_at,
+            s.last_checked_at,
+            s.last_status_code,
+            COALESCE(s.dead, FALSE)::boolean AS dead,
+            (
+                @recency_weight::double precision * EXP(
+                    - LEAST(
+                        GREATEST(
+                            EXTRACT(
+                                EPOCH
+                                FROM @now::timestamptz - a.published_at
+                            )::double precision / 3600,
+                            0
+                        ) / @tau_hours::double precision,
+                        700
+                    )
+                ) + @source_weight::double precision * COALESCE(w.weight, @default_source_weight::double precision) + @relevance_weight::double precision * COALESCE(
+                    (
+                        SELECT AVG(
+                                CASE
+                                    WHEN STRPOS(a.title, t.term) > 0 THEN 0.5
+                                    ELSE 0
+                                END + CASE
+                                    WHEN STRPOS(a.content, t.term) > 0 THEN 0.5
+                                    ELSE 0
+                                END
+                            )::double precision
+                        FROM UNNEST(@terms::text []) AS t(term)
+                    ),
+                    0
+                )
+            )::double precision AS score
+        FROM articles AS a
+            LEFT JOIN url_status AS s ON s.article_id = a.id
+            LEFT JOIN source_weights AS w ON w.source = a.source
+        WHERE (
+                sqlc.narg('dead')::boolean IS NULL
+                OR COALESCE(s.dead, FALSE) = sqlc.narg('dead')::boolean
+            )
+            AND (
+                CARDINALITY(@terms::text []) = 0
+                OR EXISTS (
+                    SELECT 1
+                    FROM UNNEST(@terms::text []) AS t(term)
+                    WHERE STRPOS(a.title, t.term) > 0
+                        OR STRPOS(a.content, t.term) > 0
+                )
+            )
+    ) AS scored
+ORDER BY scored.score DESC,
+    scored.id DESC
+LIMIT sqlc.arg('limit')::integer OFFSET sqlc.arg('offset')::integer;
+-- name: UpdateArticleURL :exec
+UPDATE articles
+SET "url" = $2
+WHERE id = $1;
+-- name: UpsertURLStatus :exec
+INSERT INTO url_status (
+        article_id,
+        last_checked_at,
+        last_status_code,
+        consecutive_failures,
+        dead
+    )
+VALUES ($1, CURRENT_TIMESTAMP, $2, $3, $4) ON CONFLICT (article_id) DO
+UPDATE
+SET last_checked_at = EXCLUDED.last_checked_at,
+    last_status_code = EXCLUDED.last_status_code,
+    consecutive_failures = EXCLUDED.consecutive_failures,
+    dead = EXCLUDED.dead;
@@ -0,0 +1,115 @@
+-- name: ListVectorIndexes :many
+-- ListVectorIndexes returns the pgvector indexes of the database with the
+-- column or the expression they cover and its type, the predicate of a partial
+-- index, empty for the others, the operator class, their build options, their
+-- size and the estimated number of rows they cover.
+SELECT n.nspname::text AS schema_name,
+    c.relname::text AS index_name,
+    t.relname::text AS table_name,
+    am.amname::text AS method,
+    pg_get_indexdef(i.indexrelid, 1, true)::text AS key_expr,
+    format_type(a.atttypid, a.atttypmod)::text AS key_type,
+    COALESCE(pg_get_expr(i.indpred, i.indrelid, true), '')::text AS predicate,
+    opc.opcname::text AS opclass,
+    COALESCE(c.reloptions, '{}')::text [] AS options,
+    i.indisvalid AS is_valid,
+    pg_relation_size(c.oid)::bigint AS size_bytes,
+    GREATEST(
+        CASE
+            WHEN i.indpred IS NULL THEN t.reltuples
+            ELSE c.reltuples
+        END,
+        0
+    )::bigint AS estimated_rows
+FROM pg_index i
+    JOIN pg_class c ON c.oid = i.indexrelid
+    JOIN pg_namespace n ON n.oid = c.relnamespace
+    JOIN pg_class t ON t.oid = i.indrelid
+    JOIN pg_am am ON am.oid = c.relam
+    JOIN pg_attribute a ON a.attrelid = i.indexrelid
+    AND a.attnum = 1
+    JOIN pg_opclass opc ON opc.oid = i.indclass [0]
+WHERE am.amname IN ('hnsw', 'ivfflat')
+ORDER BY n.nspname,
+    c.relname;
+-- name: GetVectorIndexState :one
+-- GetVectorIndexState returns whether the index, by qualified name, is valid
+-- and its size.
+SELECT i.indisvalid AS is_valid,
+    pg_relation_size(i.indexrelid)::bigint AS size_bytes
+FROM pg_index i
+WHERE i.indexrelid = to_regclass(@index_name::text);
+-- name: GetCreateIndexProgress :one
+-- GetCreateIndexProgress returns the progress of the index build running on
+-- the table, by qualified name.
+SELECT p.phase::text AS phase,
+    p.blocks_total,
+    p.blocks_done,
+    p.tuples_total,
+    p.tuples_done
+FROM pg_stat_progress_create_index p
+WHERE p.relid = to_regclass(@table_name::text)
+LIMIT 1;
+-- name: GetMaxReplicationLag :one
+-- GetMaxReplicationLag returns the largest lag of the replicas streaming from
+-- the server, in seconds.
+SELECT COALESCE(
+        EXTRACT(
+            EPOCH
+            FROM max(GREATEST(write_lag, flush_lag, replay_lag))
+        ),
+        0
+    )::float8 AS lag_seconds
+FROM pg_stat_replication;
+-- name: GetLongestTransactionAge :one
+-- GetLongestTransactionAge returns the age of the oldest transaction open by
+-- another client, in seconds.
+SELECT COALESCE(
+        EXTRACT(
+            EPOCH
+            FROM max(now() - xact_start)
+        ),
+        0
+    )::float8 AS age_seconds
+FROM pg_stat_activity
+WHERE xact_start IS NOT NULL
+    AND pid <> pg_backend_pid()
+    AND backend_type = 'client backend';
+-- name: CreateVectorIndexMaintenance :one
+-- CreateVectorIndexMaintenance starts the record of a rebuild of an index.
+INSERT INTO vector_index_maintenance (
+        index_name,
+        table_name,
+        method,
+        params,
+        recall_before,
+        size_before
+    )
+VALUES (
+        @index_name::text,
+        @table_name::text,
+        @method::text,
+        @params::jsonb,
+        sqlc.narg('recall_before')::float8,
+        sqlc.narg('size_before')::bigint
+    )
+RETURNING *;
+-- name: FinishVectorIndexMaintenance :one
+-- FinishVectorIndexMaintenance finishes the record of a rebuild, with the
+-- error of a failed one.
+UPDATE vector_index_maintenance
+SET recall_after = sqlc.narg('recall_after')::float8,
+    size_after = sqlc.narg('size_after')::bigint,
+    error = sqlc.narg('error')::text,
+    finished_at = CURRENT_TIMESTAMP
+WHERE id = @id::integer
+RETURNING *;
+-- name: ListLastVectorIndexReindexes :many
+-- ListLastVectorIndexReindexes returns the time of the last successful
+-- rebuild of each index.
+SELECT index_name,
+    max(finished_at)::timestamptz AS finished_at
+FROM vector_index_maintenance
+WHERE finished_at IS NOT NULL
+    AND error IS NULL
+GROUP BY index_name;
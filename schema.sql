@@ -40,6 +40,34 @@ CREATE EXTENSION IF NOT EXISTS vector WITH SCHEMA public;
 COMMENT ON EXTENSION vector IS 'vector data type and ivfflat and hnsw access methods';
 
 
+--
+-- Name: pg_trgm; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public;
+
+
+--
+-- Name: EXTENSION pg_trgm; Type: COMMENT; Schema: -; Owner: 
+--
+
+COMMENT ON EXTENSION pg_trgm IS 'text similarity measurement and index searching based on trigrams';
+
+
+--
+-- Name: keyword_category; Type: TYPE; Schema: public; Owner: postgres
+--
+
+CREATE TYPE public.keyword_category AS ENUM (
+    'theme',
+    'event',
+    'entity',
+    'action'
+);
+
+
+ALTER TYPE public.keyword_category OWNER TO postgres;
+
 --
 -- Name: party; Type: TYPE; Schema: public; Owner: postgres
 --
@@ -124,6 +152,35 @@ $$;
 
 ALTER FUNCTION users.avg_embedding(aid integer, mid integer) OWNER TO postgres;
 
+--
+-- Name: articles_fts; Type: TEXT SEARCH CONFIGURATION; Schema: public; Owner: postgres
+--
+
+CREATE TEXT SEARCH CONFIGURATION public.articles_fts (
+    PARSER = pg_catalog."default" );
+
+ALTER TEXT SEARCH CONFIGURATION public.articles_fts
+    ADD MAPPING FOR asciiword, word, numword, asciihword, hword, numhword,
+        hword_asciipart, hword_part, hword_numpart, email, url, host, sfloat,
+        version, file, float, int, uint, url_path WITH simple;
+
+
+ALTER TEXT SEARCH CONFIGURATION public.articles_fts OWNER TO postgres;
+
+--
+-- Name: articles_search_vector(text, text); Type: FUNCTION; Schema: public; Owner: postgres
+--
+
+CREATE FUNCTION public.articles_search_vector(title text, content text) RETURNS tsvector
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE
+    AS $$
+SELECT setweight(to_tsvector('articles_fts', title), 'A')
+    || setweight(to_tsvector('articles_fts', content), 'B');
+$$;
+
+
+ALTER FUNCTION public.articles_search_vector(title text, content text) OWNER TO postgres;
+
 SET default_tablespace = '';
 
 SET default_table_access_method = heap;
@@ -142,7 +199,10 @@ CREATE TABLE public.articles (
     content text NOT NULL,
     cuts integer[] DEFAULT '{}'::integer[] NOT NULL,
     published_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
-    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    modified_at timestamp with time zone,
+    canonical_id integer,
+    CONSTRAINT articles_canonical_id_check CHECK ((canonical_id <> id))
 );
 
 
@@ -230,8 +290,11 @@ CREATE TABLE public.embeddings (
     article_id integer NOT NULL,
     chunk_id integer NOT NULL,
     model_id integer NOT NULL,
-    vector public.vector(1024) NOT NULL,
-    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+    vector public.vector NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    was_split boolean DEFAULT false NOT NULL,
+    sub_count integer DEFAULT 1 NOT NULL,
+    CONSTRAINT embeddings_sub_count_check CHECK ((sub_count >= 1))
 );
 
 
@@ -265,7 +328,9 @@ ALTER SEQUENCE public.embeddings_id_seq OWNED BY public.embeddings.id;
 
 CREATE TABLE public.keywords (
     id integer NOT NULL,
-    term character varying(32) NOT NULL
+    term character varying(32) NOT NULL,
+    lang text DEFAULT 'zh-Hant'::text NOT NULL,
+    low_information boolean DEFAULT false NOT NULL
 );
 
 
@@ -300,7 +365,10 @@ ALTER SEQUENCE public.keywords_id_seq OWNED BY public.keywords.id;
 CREATE TABLE public.models (
     id integer NOT NULL,
     name text NOT NULL,
-    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    dim integer NOT NULL,
+    active boolean DEFAULT true NOT NULL,
+    CONSTRAINT models_dim_check CHECK (((dim > 0) AND (dim <= 16000)))
 );
 
 
@@ -354,7 +422,11 @@ CREATE TABLE users.articles (
     content text DEFAULT ''::text NOT NULL,
     cuts integer[] DEFAULT '{}'::integer[] NOT NULL,
     published_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
-    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    needs_review boolean DEFAULT false NOT NULL,
+    modified_at timestamp with time zone,
+    content_hash text DEFAULT ''::text NOT NULL,
+    last_checked_at timestamp with time zone
 );
 
 
@@ -388,7 +460,8 @@ ALTER SEQUENCE users.articles_id_seq OWNED BY users.articles.id;
 
 CREATE TABLE users.articles_keywords (
     article_id integer NOT NULL,
-    keyword_id integer NOT NULL
+    keyword_id integer NOT NULL,
+    category public.keyword_category
 );
 
 
@@ -442,8 +515,11 @@ CREATE TABLE users.embeddings (
     article_id integer NOT NULL,
     chunk_id integer NOT NULL,
     model_id integer NOT NULL,
-    vector public.vector(1024) NOT NULL,
-    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+    vector public.vector NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    was_split boolean DEFAULT false NOT NULL,
+    sub_count integer DEFAULT 1 NOT NULL,
+    CONSTRAINT embeddings_sub_count_check CHECK ((sub_count >= 1))
 );
 
 
@@ -483,7 +559,8 @@ CREATE TABLE users.tasks (
     status public.task_status DEFAULT 'pending'::public.task_status NOT NULL,
     error_message text,
     created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
-    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    owner_id text
 );
 
 
@@ -639,11 +716,11 @@ ALTER TABLE ONLY public.keywords
 
 
 --
--- Name: keywords keywords_term_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: keywords keywords_term_lang_key; Type: CONSTRAINT; Schema: public; Owner: postgres
 --
 
 ALTER TABLE ONLY public.keywords
-    ADD CONSTRAINT keywords_term_key UNIQUE (term);
+    ADD CONSTRAINT keywords_term_lang_key UNIQUE (term, lang);
 
 
 --
@@ -678,20 +755,22 @@ ALTER TABLE ONLY users.articles_keywords
     ADD CONSTRAINT articles_keywords_pkey PRIMARY KEY (keyword_id, article_id);
 
 
+
+
 --
--- Name: articles articles_md5_key; Type: CONSTRAINT; Schema: users; Owner: postgres
+-- Name: articles articles_pkey; Type: CONSTRAINT; Schema: users; Owner: postgres
 --
 
 ALTER TABLE ONLY users.articles
-    ADD CONSTRAINT articles_md5_key UNIQUE (md5);
+    ADD CONSTRAINT articles_pkey PRIMARY KEY (id);
 
 
 --
--- Name: articles articles_pkey; Type: CONSTRAINT; Schema: users; Owner: postgres
+-- Name: articles articles_task_id_md5_key; Type: CONSTRAINT; Schema: users; Owner: postgres
 --
 
 ALTER TABLE ONLY users.articles
-    ADD CONSTRAINT articles_pkey PRIMARY KEY (id);
+    ADD CONSTRAINT articles_task_id_md5_key UNIQUE (task_id, md5);
 
 
 --
@@ -742,6 +821,14 @@ ALTER TABLE ONLY users.tasks
     ADD CONSTRAINT tasks_task_id_key UNIQUE (task_id);
 
 
+--
+-- Name: articles articles_canonical_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.articles
+    ADD CONSTRAINT articles_canonical_id_fkey FOREIGN KEY (canonical_id) REFERENCES public.articles(id) ON DELETE SET NULL;
+
+
 --
 -- Name: articles_keywords articles_keywords_article_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -846,6 +933,853 @@ ALTER TABLE ONLY users.embeddings
     ADD CONSTRAINT embeddings_model_id_fkey FOREIGN KEY (model_id) REFERENCES public.models(id) ON DELETE CASCADE;
 
 
+--
+-- Name: annotation_action; Type: TYPE; Schema: public; Owner: postgres
+--
+
+CREATE TYPE public.annotation_action AS ENUM (
+    'confirm',
+    'reject',
+    'replace'
+);
+
+
+ALTER TYPE public.annotation_action OWNER TO postgres;
+
+--
+-- Name: annotation_target; Type: TYPE; Schema: public; Owner: postgres
+--
+
+CREATE TYPE public.annotation_target AS ENUM (
+    'keyword',
+    'stance',
+    'summary'
+);
+
+
+ALTER TYPE public.annotation_target OWNER TO postgres;
+
+--
+-- Name: annotations; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.annotations (
+    id integer NOT NULL,
+    target_type public.annotation_target NOT NULL,
+    target_id text NOT NULL,
+    article_id integer NOT NULL,
+    action public.annotation_action NOT NULL,
+    replacement jsonb,
+    author text NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT annotations_check CHECK (((action <> 'replace'::public.annotation_action) OR (replacement IS NOT NULL)))
+);
+
+
+ALTER TABLE public.annotations OWNER TO postgres;
+
+--
+-- Name: annotations_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.annotations_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.annotations_id_seq OWNER TO postgres;
+ALTER SEQUENCE public.annotations_id_seq OWNED BY public.annotations.id;
+ALTER TABLE ONLY public.annotations ALTER COLUMN id SET DEFAULT nextval('public.annotations_id_seq'::regclass);
+
+ALTER TABLE ONLY public.annotations
+    ADD CONSTRAINT annotations_pkey PRIMARY KEY (id);
+
+CREATE INDEX annotations_article_id_idx ON public.annotations USING btree (article_id, target_type, target_id);
+
+ALTER TABLE ONLY public.annotations
+    ADD CONSTRAINT annotations_article_id_fkey FOREIGN KEY (article_id) REFERENCES public.articles(id) ON DELETE CASCADE;
+
+
+--
+-- Name: latest_annotations; Type: VIEW; Schema: public; Owner: postgres
+--
+
+CREATE VIEW public.latest_annotations AS
+ SELECT DISTINCT ON (article_id, target_type, target_id) id,
+    target_type,
+    target_id,
+    article_id,
+    action,
+    replacement,
+    author,
+    created_at
+   FROM public.annotations
+  ORDER BY article_id, target_type, target_id, created_at DESC, id DESC;
+
+
+ALTER VIEW public.latest_annotations OWNER TO postgres;
+
+--
+-- Name: effective_articles_keywords; Type: VIEW; Schema: public; Owner: postgres
+--
+
+CREATE VIEW public.effective_articles_keywords AS
+ SELECT ak.article_id,
+    (COALESCE((la.replacement ->> 'term'::text), (k.term)::text))::text AS term
+   FROM ((public.articles_keywords ak
+     JOIN public.keywords k ON ((k.id = ak.keyword_id)))
+     LEFT JOIN public.latest_annotations la ON (((la.article_id = ak.article_id) AND (la.target_type = 'keyword'::public.annotation_target) AND (la.target_id = (k.term)::text))))
+  WHERE ((la.id IS NULL) OR (la.action <> 'reject'::public.annotation_action))
+UNION
+ SELECT la.article_id,
+    COALESCE((la.replacement ->> 'term'::text), la.target_id) AS term
+   FROM public.latest_annotations la
+  WHERE ((la.target_type = 'keyword'::public.annotation_target) AND (la.action = ANY (ARRAY['confirm'::public.annotation_action, 'replace'::public.annotation_action])));
+
+
+ALTER VIEW public.effective_articles_keywords OWNER TO postgres;
+
+
+--
+-- Name: counters; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.counters (
+    name text NOT NULL,
+    value bigint DEFAULT 0 NOT NULL,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+
+ALTER TABLE public.counters OWNER TO postgres;
+
+--
+-- Name: counters counters_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.counters
+    ADD CONSTRAINT counters_pkey PRIMARY KEY (name);
+
+
+--
+-- Name: url_status; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.url_status (
+    article_id integer NOT NULL,
+    last_checked_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    last_status_code integer,
+    consecutive_failures integer DEFAULT 0 NOT NULL,
+    dead boolean DEFAULT false NOT NULL
+);
+
+
+ALTER TABLE public.url_status OWNER TO postgres;
+
+ALTER TABLE ONLY public.url_status
+    ADD CONSTRAINT url_status_pkey PRIMARY KEY (article_id);
+
+CREATE INDEX idx_url_status_last_checked_at ON public.url_status USING btree (last_checked_at);
+
+CREATE INDEX idx_url_status_dead ON public.url_status USING btree (dead) WHERE dead;
+
+ALTER TABLE ONLY public.url_status
+    ADD CONSTRAINT url_status_article_id_fkey FOREIGN KEY (article_id) REFERENCES public.articles(id) ON DELETE CASCADE;
+
+
+--
+-- Name: article_revisions; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.article_revisions (
+    id integer NOT NULL,
+    article_id integer NOT NULL,
+    field text NOT NULL,
+    old_value text NOT NULL,
+    new_value text NOT NULL,
+    note text DEFAULT ''::text NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+
+ALTER TABLE public.article_revisions OWNER TO postgres;
+
+--
+-- Name: article_revisions_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.article_revisions_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.article_revisions_id_seq OWNER TO postgres;
+ALTER SEQUENCE public.article_revisions_id_seq OWNED BY public.article_revisions.id;
+ALTER TABLE ONLY public.article_revisions ALTER COLUMN id SET DEFAULT nextval('public.article_revisions_id_seq'::regclass);
+
+ALTER TABLE ONLY public.article_revisions
+    ADD CONSTRAINT article_revisions_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_article_revisions_article_id ON public.article_revisions USING btree (article_id);
+
+ALTER TABLE ONLY public.article_revisions
+    ADD CONSTRAINT article_revisions_article_id_fkey FOREIGN KEY (article_id) REFERENCES public.articles(id) ON DELETE CASCADE;
+
+
+--
+-- Name: embeddings_archive; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.embeddings_archive (
+    id integer NOT NULL,
+    article_id integer NOT NULL,
+    chunk_id integer NOT NULL,
+    model_id integer NOT NULL,
+    dims integer NOT NULL,
+    vector bytea NOT NULL,
+    created_at timestamp with time zone,
+    archived_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    was_split boolean DEFAULT false NOT NULL,
+    sub_count integer DEFAULT 1 NOT NULL,
+    CONSTRAINT embeddings_archive_check CHECK ((octet_length(vector) = (2 * dims))),
+    CONSTRAINT embeddings_archive_sub_count_check CHECK ((sub_count >= 1))
+);
+
+
+ALTER TABLE public.embeddings_archive OWNER TO postgres;
+
+ALTER TABLE ONLY public.embeddings_archive
+    ADD CONSTRAINT embeddings_archive_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.embeddings_archive
+    ADD CONSTRAINT embeddings_archive_article_id_chunk_id_model_id_key UNIQUE (article_id, chunk_id, model_id);
+
+CREATE INDEX idx_embeddings_archive_model_id ON public.embeddings_archive USING btree (model_id, article_id);
+
+ALTER TABLE ONLY public.embeddings_archive
+    ADD CONSTRAINT embeddings_archive_article_id_fkey FOREIGN KEY (article_id) REFERENCES public.articles(id) ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.embeddings_archive
+    ADD CONSTRAINT embeddings_archive_chunk_id_fkey FOREIGN KEY (chunk_id) REFERENCES public.chunks(id) ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.embeddings_archive
+    ADD CONSTRAINT embeddings_archive_model_id_fkey FOREIGN KEY (model_id) REFERENCES public.models(id) ON DELETE CASCADE;
+
+
+--
+-- Name: task_events; Type: TABLE; Schema: users; Owner: postgres
+--
+
+CREATE TABLE users.task_events (
+    id bigint NOT NULL,
+    task_id uuid NOT NULL,
+    stage text NOT NULL,
+    status public.task_status NOT NULL,
+    message text DEFAULT ''::text NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+
+ALTER TABLE users.task_events OWNER TO postgres;
+
+--
+-- Name: task_events_id_seq; Type: SEQUENCE; Schema: users; Owner: postgres
+--
+
+CREATE SEQUENCE users.task_events_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE users.task_events_id_seq OWNER TO postgres;
+ALTER SEQUENCE users.task_events_id_seq OWNED BY users.task_events.id;
+ALTER TABLE ONLY users.task_events ALTER COLUMN id SET DEFAULT nextval('users.task_events_id_seq'::regclass);
+
+ALTER TABLE ONLY users.task_events
+    ADD CONSTRAINT task_events_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_task_events_task_id_stage ON users.task_events USING btree (task_id, stage, id);
+
+CREATE INDEX idx_task_events_created_at ON users.task_events USING btree (created_at);
+
+ALTER TABLE ONLY users.task_events
+    ADD CONSTRAINT task_events_task_id_fkey FOREIGN KEY (task_id) REFERENCES users.tasks(task_id) ON DELETE CASCADE;
+
+
+--
+-- Name: task_state; Type: TABLE; Schema: users; Owner: postgres
+--
+
+CREATE TABLE users.task_state (
+    task_id uuid NOT NULL,
+    current_status public.task_status NOT NULL,
+    stage_statuses jsonb DEFAULT '{}'::jsonb NOT NULL,
+    last_event_id bigint NOT NULL,
+    compacted boolean DEFAULT false NOT NULL,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+
+ALTER TABLE users.task_state OWNER TO postgres;
+
+ALTER TABLE ONLY users.task_state
+    ADD CONSTRAINT task_state_pkey PRIMARY KEY (task_id);
+
+CREATE INDEX idx_task_state_terminal ON users.task_state USING btree (updated_at) WHERE (current_status = ANY (ARRAY['done'::public.task_status, 'failed'::public.task_status]));
+
+ALTER TABLE ONLY users.task_state
+    ADD CONSTRAINT task_state_task_id_fkey FOREIGN KEY (task_id) REFERENCES users.tasks(task_id) ON DELETE CASCADE;
+
+
+--
+-- Name: keyword_links; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.keyword_links (
+    keyword_id integer NOT NULL,
+    linked_keyword_id integer NOT NULL,
+    similarity real NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT keyword_links_check CHECK ((keyword_id < linked_keyword_id))
+);
+
+
+ALTER TABLE public.keyword_links OWNER TO postgres;
+
+ALTER TABLE ONLY public.keyword_links
+    ADD CONSTRAINT keyword_links_pkey PRIMARY KEY (keyword_id, linked_keyword_id);
+
+CREATE INDEX idx_keyword_links_linked_keyword_id ON public.keyword_links USING btree (linked_keyword_id);
+
+ALTER TABLE ONLY public.keyword_links
+    ADD CONSTRAINT keyword_links_keyword_id_fkey FOREIGN KEY (keyword_id) REFERENCES public.keywords(id) ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.keyword_links
+    ADD CONSTRAINT keyword_links_linked_keyword_id_fkey FOREIGN KEY (linked_keyword_id) REFERENCES public.keywords(id) ON DELETE CASCADE;
+
+
+--
+-- Name: saved_search_delivery; Type: TYPE; Schema: public; Owner: postgres
+--
+
+CREATE TYPE public.saved_search_delivery AS ENUM (
+    'none',
+    'webhook'
+);
+
+
+ALTER TYPE public.saved_search_delivery OWNER TO postgres;
+
+--
+-- Name: saved_search_schedule; Type: TYPE; Schema: public; Owner: postgres
+--
+
+CREATE TYPE public.saved_search_schedule AS ENUM (
+    'daily',
+    'weekly'
+);
+
+
+ALTER TYPE public.saved_search_schedule OWNER TO postgres;
+
+--
+-- Name: saved_searches; Type: TABLE; Schema: users; Owner: postgres
+--
+
+CREATE TABLE users.saved_searches (
+    id integer NOT NULL,
+    owner_id text NOT NULL,
+    name text NOT NULL,
+    filter jsonb NOT NULL,
+    schedule public.saved_search_schedule DEFAULT 'daily'::public.saved_search_schedule NOT NULL,
+    delivery public.saved_search_delivery DEFAULT 'none'::public.saved_search_delivery NOT NULL,
+    webhook_url text DEFAULT ''::text NOT NULL,
+    last_run_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+
+ALTER TABLE users.saved_searches OWNER TO postgres;
+
+--
+-- Name: saved_searches_id_seq; Type: SEQUENCE; Schema: users; Owner: postgres
+--
+
+CREATE SEQUENCE users.saved_searches_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE users.saved_searches_id_seq OWNER TO postgres;
+ALTER SEQUENCE users.saved_searches_id_seq OWNED BY users.saved_searches.id;
+ALTER TABLE ONLY users.saved_searches ALTER COLUMN id SET DEFAULT nextval('users.saved_searches_id_seq'::regclass);
+
+ALTER TABLE ONLY users.saved_searches
+    ADD CONSTRAINT saved_searches_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY users.saved_searches
+    ADD CONSTRAINT saved_searches_owner_id_name_key UNIQUE (owner_id, name);
+
+CREATE INDEX idx_saved_searches_schedule ON users.saved_searches USING btree (schedule, last_run_at);
+
+
+--
+-- Name: saved_search_hits; Type: TABLE; Schema: users; Owner: postgres
+--
+
+CREATE TABLE users.saved_search_hits (
+    saved_search_id integer NOT NULL,
+    article_id integer NOT NULL,
+    run_at timestamp with time zone NOT NULL
+);
+
+
+ALTER TABLE users.saved_search_hits OWNER TO postgres;
+
+ALTER TABLE ONLY users.saved_search_hits
+    ADD CONSTRAINT saved_search_hits_pkey PRIMARY KEY (saved_search_id, article_id);
+
+CREATE INDEX idx_saved_search_hits_run_at ON users.saved_search_hits USING btree (saved_search_id, run_at DESC, article_id DESC);
+
+ALTER TABLE ONLY users.saved_search_hits
+    ADD CONSTRAINT saved_search_hits_saved_search_id_fkey FOREIGN KEY (saved_search_id) REFERENCES users.saved_searches(id) ON DELETE CASCADE;
+
+ALTER TABLE ONLY users.saved_search_hits
+    ADD CONSTRAINT saved_search_hits_article_id_fkey FOREIGN KEY (article_id) REFERENCES public.articles(id) ON DELETE CASCADE;
+
+
+--
+-- Name: review_item_type; Type: TYPE; Schema: public; Owner: postgres
+--
+
+CREATE TYPE public.review_item_type AS ENUM (
+    'keyword_output',
+    'extraction',
+    'keyword_attribution',
+    'stance',
+    'reconciliation'
+);
+
+
+ALTER TYPE public.review_item_type OWNER TO postgres;
+
+--
+-- Name: review_status; Type: TYPE; Schema: public; Owner: postgres
+--
+
+CREATE TYPE public.review_status AS ENUM (
+    'open',
+    'resolved',
+    'dismissed'
+);
+
+
+ALTER TYPE public.review_status OWNER TO postgres;
+
+--
+-- Name: review_queue; Type: TABLE; Schema: users; Owner: postgres
+--
+
+CREATE TABLE users.review_queue (
+    id integer NOT NULL,
+    item_type public.review_item_type NOT NULL,
+    item_ref jsonb NOT NULL,
+    article_id integer NOT NULL,
+    reason text NOT NULL,
+    priority integer DEFAULT 0 NOT NULL,
+    status public.review_status DEFAULT 'open'::public.review_status NOT NULL,
+    assigned_to text,
+    lease_expires_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    resolved_at timestamp with time zone,
+    CONSTRAINT review_queue_check CHECK (((status = 'open'::public.review_status) = (resolved_at IS NULL)))
+);
+
+
+ALTER TABLE users.review_queue OWNER TO postgres;
+
+--
+-- Name: review_queue_id_seq; Type: SEQUENCE; Schema: users; Owner: postgres
+--
+
+CREATE SEQUENCE users.review_queue_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE users.review_queue_id_seq OWNER TO postgres;
+ALTER SEQUENCE users.review_queue_id_seq OWNED BY users.review_queue.id;
+ALTER TABLE ONLY users.review_queue ALTER COLUMN id SET DEFAULT nextval('users.review_queue_id_seq'::regclass);
+
+ALTER TABLE ONLY users.review_queue
+    ADD CONSTRAINT review_queue_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_review_queue_open_item ON users.review_queue USING btree (item_type, item_ref) WHERE (status = 'open'::public.review_status);
+
+CREATE INDEX idx_review_queue_status ON users.review_queue USING btree (status, item_type, created_at, id);
+
+ALTER TABLE ONLY users.review_queue
+    ADD CONSTRAINT review_queue_article_id_fkey FOREIGN KEY (article_id) REFERENCES users.articles(id) ON DELETE CASCADE;
+
+
+--
+-- Name: keyword_anomalies; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.keyword_anomalies (
+    keyword_id integer NOT NULL,
+    date date NOT NULL,
+    count integer NOT NULL,
+    baseline_mean double precision NOT NULL,
+    baseline_stddev double precision NOT NULL,
+    zscore double precision NOT NULL,
+    detected_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+
+ALTER TABLE public.keyword_anomalies OWNER TO postgres;
+
+ALTER TABLE ONLY public.keyword_anomalies
+    ADD CONSTRAINT keyword_anomalies_pkey PRIMARY KEY (keyword_id, date);
+
+CREATE INDEX idx_keyword_anomalies_date ON public.keyword_anomalies USING btree (date);
+
+ALTER TABLE ONLY public.keyword_anomalies
+    ADD CONSTRAINT keyword_anomalies_keyword_id_fkey FOREIGN KEY (keyword_id) REFERENCES public.keywords(id) ON DELETE CASCADE;
+
+
+--
+-- Name: source_weights; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.source_weights (
+    source text NOT NULL,
+    weight double precision NOT NULL,
+    updated_by text NOT NULL,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT source_weights_weight_check CHECK (((weight >= (0)::double precision) AND (weight <= (1)::double precision)))
+);
+
+
+ALTER TABLE public.source_weights OWNER TO postgres;
+
+ALTER TABLE ONLY public.source_weights
+    ADD CONSTRAINT source_weights_pkey PRIMARY KEY (source);
+
+
+--
+-- Name: idx_tasks_url_original_input; Type: INDEX; Schema: users; Owner: postgres
+--
+
+CREATE INDEX idx_tasks_url_original_input ON users.tasks USING btree (original_input) WHERE (source = 'url'::public.source_type);
+
+
+--
+-- Name: idx_articles_url; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_articles_url ON public.articles USING btree (url);
+
+
+--
+-- Name: reconciliation_reports; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.reconciliation_reports (
+    id integer NOT NULL,
+    since timestamp with time zone NOT NULL,
+    last_task_id integer DEFAULT 0 NOT NULL,
+    checked integer DEFAULT 0 NOT NULL,
+    sampled integer DEFAULT 0 NOT NULL,
+    counts jsonb DEFAULT '{}'::jsonb NOT NULL,
+    repaired integer DEFAULT 0 NOT NULL,
+    flagged integer DEFAULT 0 NOT NULL,
+    started_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    finished_at timestamp with time zone
+);
+
+
+ALTER TABLE public.reconciliation_reports OWNER TO postgres;
+
+CREATE SEQUENCE public.reconciliation_reports_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.reconciliation_reports_id_seq OWNER TO postgres;
+ALTER SEQUENCE public.reconciliation_reports_id_seq OWNED BY public.reconciliation_reports.id;
+ALTER TABLE ONLY public.reconciliation_reports ALTER COLUMN id SET DEFAULT nextval('public.reconciliation_reports_id_seq'::regclass);
+
+ALTER TABLE ONLY public.reconciliation_reports
+    ADD CONSTRAINT reconciliation_reports_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_reconciliation_reports_running ON public.reconciliation_reports USING btree (((finished_at IS NULL))) WHERE (finished_at IS NULL);
+
+
+--
+-- Name: idx_tasks_status_updated_at; Type: INDEX; Schema: users; Owner: postgres
+--
+
+CREATE INDEX idx_tasks_status_updated_at ON users.tasks USING btree (status, updated_at);
+
+
+--
+-- Name: idx_users_articles_published_at; Type: INDEX; Schema: users; Owner: postgres
+--
+
+CREATE INDEX idx_users_articles_published_at ON users.articles USING btree (published_at);
+
+
+--
+-- Name: article_revisions; Type: TABLE; Schema: users; Owner: postgres
+--
+
+CREATE TABLE users.article_revisions (
+    id integer NOT NULL,
+    article_id integer NOT NULL,
+    field text NOT NULL,
+    old_value text NOT NULL,
+    new_value text NOT NULL,
+    note text DEFAULT ''::text NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+
+ALTER TABLE users.article_revisions OWNER TO postgres;
+
+--
+-- Name: article_revisions_id_seq; Type: SEQUENCE; Schema: users; Owner: postgres
+--
+
+CREATE SEQUENCE users.article_revisions_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE users.article_revisions_id_seq OWNER TO postgres;
+ALTER SEQUENCE users.article_revisions_id_seq OWNED BY users.article_revisions.id;
+ALTER TABLE ONLY users.article_revisions ALTER COLUMN id SET DEFAULT nextval('users.article_revisions_id_seq'::regclass);
+
+ALTER TABLE ONLY users.article_revisions
+    ADD CONSTRAINT article_revisions_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_users_article_revisions_article_id ON users.article_revisions USING btree (article_id, created_at);
+
+ALTER TABLE ONLY users.article_revisions
+    ADD CONSTRAINT article_revisions_article_id_fkey FOREIGN KEY (article_id) REFERENCES users.articles(id) ON DELETE CASCADE;
+
+
+--
+-- Name: vector_index_maintenance; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.vector_index_maintenance (
+    id integer NOT NULL,
+    index_name text NOT NULL,
+    table_name text NOT NULL,
+    method text NOT NULL,
+    params jsonb DEFAULT '{}'::jsonb NOT NULL,
+    recall_before double precision,
+    recall_after double precision,
+    size_before bigint,
+    size_after bigint,
+    error text,
+    started_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    finished_at timestamp with time zone
+);
+
+
+ALTER TABLE public.vector_index_maintenance OWNER TO postgres;
+
+CREATE SEQUENCE public.vector_index_maintenance_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.vector_index_maintenance_id_seq OWNER TO postgres;
+ALTER SEQUENCE public.vector_index_maintenance_id_seq OWNED BY public.vector_index_maintenance.id;
+ALTER TABLE ONLY public.vector_index_maintenance ALTER COLUMN id SET DEFAULT nextval('public.vector_index_maintenance_id_seq'::regclass);
+
+ALTER TABLE ONLY public.vector_index_maintenance
+    ADD CONSTRAINT vector_index_maintenance_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_vector_index_maintenance_running ON public.vector_index_maintenance USING btree (index_name) WHERE (finished_at IS NULL);
+
+CREATE INDEX idx_vector_index_maintenance_index_name ON public.vector_index_maintenance USING btree (index_name, finished_at);
+
+
+--
+-- Name: idx_embeddings_model_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_embeddings_model_id ON public.embeddings USING btree (model_id);
+
+
+--
+-- Name: idx_users_embeddings_model_id; Type: INDEX; Schema: users; Owner: postgres
+--
+
+CREATE INDEX idx_users_embeddings_model_id ON users.embeddings USING btree (model_id);
+
+
+
+--
+-- Name: idx_articles_published_at_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_articles_published_at_id ON public.articles USING btree (published_at, id);
+
+
+--
+-- Name: idx_users_articles_task_id_published_at; Type: INDEX; Schema: users; Owner: postgres
+--
+
+CREATE INDEX idx_users_articles_task_id_published_at ON users.articles USING btree (task_id, published_at, id);
+
+
+
+--
+-- Name: idx_articles_search_vector; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_articles_search_vector ON public.articles USING gin (public.articles_search_vector(title, content));
+
+
+--
+-- Name: idx_articles_title_trgm; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_articles_title_trgm ON public.articles USING gin (title public.gin_trgm_ops);
+
+
+--
+-- Name: idx_articles_content_trgm; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_articles_content_trgm ON public.articles USING gin (content public.gin_trgm_ops);
+
+
+--
+-- Name: idx_articles_canonical_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_articles_canonical_id ON public.articles USING btree (canonical_id) WHERE (canonical_id IS NOT NULL);
+
+
+--
+-- Name: article_stances; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.article_stances (
+    article_id integer NOT NULL,
+    party public.party NOT NULL,
+    stance text NOT NULL,
+    confidence real NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT article_stances_confidence_check CHECK (((confidence >= (0)::double precision) AND (confidence <= (1)::double precision))),
+    CONSTRAINT article_stances_stance_check CHECK ((stance = ANY (ARRAY['support'::text, 'oppose'::text, 'neutral'::text])))
+);
+
+
+ALTER TABLE public.article_stances OWNER TO postgres;
+
+ALTER TABLE ONLY public.article_stances
+    ADD CONSTRAINT article_stances_pkey PRIMARY KEY (article_id, party);
+
+ALTER TABLE ONLY public.article_stances
+    ADD CONSTRAINT article_stances_article_id_fkey FOREIGN KEY (article_id) REFERENCES public.articles(id) ON DELETE CASCADE;
+
+
+--
+-- Name: article_summaries; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.article_summaries (
+    article_id integer NOT NULL,
+    summary text NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+
+ALTER TABLE public.article_summaries OWNER TO postgres;
+
+ALTER TABLE ONLY public.article_summaries
+    ADD CONSTRAINT article_summaries_pkey PRIMARY KEY (article_id);
+
+ALTER TABLE ONLY public.article_summaries
+    ADD CONSTRAINT article_summaries_article_id_fkey FOREIGN KEY (article_id) REFERENCES public.articles(id) ON DELETE CASCADE;
+
+
+--
+-- Name: effective_article_stances; Type: VIEW; Schema: public; Owner: postgres
+--
+
+CREATE VIEW public.effective_article_stances AS
+ SELECT s.article_id,
+    s.party,
+    COALESCE((la.replacement ->> 'stance'::text), s.stance) AS stance,
+    s.confidence
+   FROM (public.article_stances s
+     LEFT JOIN public.latest_annotations la ON (((la.article_id = s.article_id) AND (la.target_type = 'stance'::public.annotation_target) AND (la.target_id = (s.party)::text))))
+  WHERE ((la.id IS NULL) OR (la.action <> 'reject'::public.annotation_action));
+
+
+ALTER VIEW public.effective_article_stances OWNER TO postgres;
+
+--
+-- Name: effective_article_summaries; Type: VIEW; Schema: public; Owner: postgres
+--
+
+CREATE VIEW public.effective_article_summaries AS
+ SELECT s.article_id,
+    COALESCE((la.replacement ->> 'summary'::text), s.summary) AS summary
+   FROM (public.article_summaries s
+     LEFT JOIN LATERAL ( SELECT l.id,
+            l.action,
+            l.replacement
+           FROM public.latest_annotations l
+          WHERE ((l.article_id = s.article_id) AND (l.target_type = 'summary'::public.annotation_target))
+          ORDER BY l.created_at DESC, l.id DESC
+         LIMIT 1) la ON (true))
+  WHERE ((la.id IS NULL) OR (la.action <> 'reject'::public.annotation_action));
+
+
+ALTER VIEW public.effective_article_summaries OWNER TO postgres;
+
+
 --
 -- PostgreSQL database dump complete
 --